@@ -4,14 +4,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"syscall"
 	"time"
 
 	"github.com/knadh/listmonk/internal/captcha"
+	"github.com/knadh/listmonk/internal/sendlimit"
 	"github.com/labstack/echo/v4"
 	null "gopkg.in/volatiletech/null.v6"
 )
 
+// pendingChange is a settings change that's pending an app restart.
+type pendingChange struct {
+	Keys      []string  `json:"keys"`
+	User      string    `json:"user"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
 type serverConfig struct {
 	RootURL            string `json:"root_url"`
 	FromEmail          string `json:"from_email"`
@@ -22,15 +31,17 @@ type serverConfig struct {
 		CaptchaKey       null.String `json:"captcha_key"`
 		AltchaComplexity int         `json:"altcha_complexity"`
 	} `json:"public_subscription"`
-	MediaProvider string          `json:"media_provider"`
-	Messengers    []string        `json:"messengers"`
-	Langs         []i18nLang      `json:"langs"`
-	Lang          string          `json:"lang"`
-	Permissions   json.RawMessage `json:"permissions"`
-	Update        *AppUpdate      `json:"update"`
-	NeedsRestart  bool            `json:"needs_restart"`
-	HasLegacyUser bool            `json:"has_legacy_user"`
-	Version       string          `json:"version"`
+	MediaProvider  string                     `json:"media_provider"`
+	Messengers     []string                   `json:"messengers"`
+	SendLimits     map[string]sendlimit.Limit `json:"send_limits"`
+	Langs          []i18nLang                 `json:"langs"`
+	Lang           string                     `json:"lang"`
+	Permissions    json.RawMessage            `json:"permissions"`
+	Update         *AppUpdate                 `json:"update"`
+	NeedsRestart   bool                       `json:"needs_restart"`
+	PendingChanges []pendingChange            `json:"pending_changes"`
+	HasLegacyUser  bool                       `json:"has_legacy_user"`
+	Version        string                     `json:"version"`
 }
 
 // GetServerConfig returns general server config.
@@ -65,6 +76,8 @@ func (a *App) GetServerConfig(c echo.Context) error {
 	}
 	out.Langs = langList
 
+	out.SendLimits = sendlimit.Providers
+
 	out.Messengers = make([]string, 0, len(a.messengers))
 	for _, m := range a.messengers {
 		out.Messengers = append(out.Messengers, m.Name())
@@ -72,6 +85,7 @@ func (a *App) GetServerConfig(c echo.Context) error {
 
 	a.Lock()
 	out.NeedsRestart = a.needsRestart
+	out.PendingChanges = append([]pendingChange{}, a.pendingRestart...)
 	out.Update = a.update
 	a.Unlock()
 	out.Version = versionString
@@ -82,7 +96,7 @@ func (a *App) GetServerConfig(c echo.Context) error {
 // GetDashboardCharts returns chart data points to render ont he dashboard.
 func (a *App) GetDashboardCharts(c echo.Context) error {
 	// Get the chart data from the DB.
-	out, err := a.core.GetDashboardCharts()
+	out, err := a.reqCore(c).GetDashboardCharts()
 	if err != nil {
 		return err
 	}
@@ -93,7 +107,28 @@ func (a *App) GetDashboardCharts(c echo.Context) error {
 // GetDashboardCounts returns stats counts to show on the dashboard.
 func (a *App) GetDashboardCounts(c echo.Context) error {
 	// Get the chart data from the DB.
-	out, err := a.core.GetDashboardCounts()
+	out, err := a.reqCore(c).GetDashboardCounts()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetDashboardUnsubscribeReasons returns the unsubscribe reasons of all campaigns
+// over the last ?days=30 days to show on the dashboard.
+func (a *App) GetDashboardUnsubscribeReasons(c echo.Context) error {
+	days := unsubReasonsDays
+	if v := c.QueryParam("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 3650 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "days"))
+		}
+		days = n
+	}
+
+	// Free text comments aren't shown on the dashboard.
+	out, err := a.reqCore(c).GetUnsubscribeReasons(0, time.Now().AddDate(0, 0, -days), 0)
 	if err != nil {
 		return err
 	}
@@ -101,6 +136,23 @@ func (a *App) GetDashboardCounts(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// ReloadPublicTemplates reloads the public page templates with the overrides
+// in app.public_templates_dir without a restart. If the overrides are invalid,
+// the current templates are retained.
+func (a *App) ReloadPublicTemplates(c echo.Context) error {
+	t, ok := c.Echo().Renderer.(*tplRenderer)
+	if !ok {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	if err := t.reload(ko.String("app.public_templates_dir")); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("admin.errorLoadingTemplates", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{t.getOverridden()})
+}
+
 // ReloadApp sends a reload signal to the app, causing a full restart.
 func (a *App) ReloadApp(c echo.Context) error {
 	go func() {
@@ -3,9 +3,15 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"html/template"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/feeds"
 	"github.com/knadh/listmonk/internal/manager"
@@ -14,6 +20,23 @@ import (
 	null "gopkg.in/volatiletech/null.v6"
 )
 
+const (
+	// Duration for which a correctly entered archive password is remembered.
+	archivePasswordCookieAge = time.Hour * 24
+
+	// Duration for which the generated archive sitemap is cached. The cache is
+	// also invalidated when archived campaigns are changed from the admin.
+	archiveSitemapTTL = time.Hour
+
+	// Maximum number of URLs in a sitemap as per the sitemaps protocol.
+	maxSitemapURLs = 50000
+)
+
+var (
+	reHTMLHead  = regexp.MustCompile(`(?i)<head[^>]*>`)
+	reHTMLTitle = regexp.MustCompile(`(?i)<title[\s>]`)
+)
+
 type campArchive struct {
 	UUID      string    `json:"uuid"`
 	Subject   string    `json:"subject"`
@@ -23,6 +46,51 @@ type campArchive struct {
 	URL       string    `json:"url"`
 }
 
+// sitemapURLSet represents the <urlset> of a sitemap.
+type sitemapURLSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+// archiveSitemap caches the generated archive sitemap.
+type archiveSitemap struct {
+	mut     sync.RWMutex
+	body    []byte
+	expires time.Time
+}
+
+// get returns the cached sitemap if it hasn't expired.
+func (s *archiveSitemap) get() ([]byte, bool) {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+
+	if s.body == nil || time.Now().After(s.expires) {
+		return nil, false
+	}
+
+	return s.body, true
+}
+
+func (s *archiveSitemap) set(b []byte) {
+	s.mut.Lock()
+	s.body = b
+	s.expires = time.Now().Add(archiveSitemapTTL)
+	s.mut.Unlock()
+}
+
+// invalidate clears the cached sitemap so that it's regenerated on the next request.
+func (s *archiveSitemap) invalidate() {
+	s.mut.Lock()
+	s.body = nil
+	s.mut.Unlock()
+}
+
 // GetCampaignArchives renders the public campaign archives page.
 func (a *App) GetCampaignArchives(c echo.Context) error {
 	// Get archives from the DB.
@@ -95,6 +163,42 @@ func (a *App) GetCampaignArchivesFeed(c echo.Context) error {
 	return nil
 }
 
+// GetCampaignArchiveSitemap renders the sitemap of the public campaign archives.
+// Unlisted and password protected campaigns are never listed.
+func (a *App) GetCampaignArchiveSitemap(c echo.Context) error {
+	if b, ok := a.archiveSitemap.get(); ok {
+		return c.Blob(http.StatusOK, "application/xml; charset=utf-8", b)
+	}
+
+	camps, err := a.core.GetArchiveSitemap(maxSitemapURLs)
+	if err != nil {
+		return err
+	}
+
+	out := sitemapURLSet{
+		XMLNS: "http://www.sitemaps.org/schemas/sitemap/0.9",
+		URLs:  make([]sitemapURL, 0, len(camps)),
+	}
+	for _, camp := range camps {
+		u := sitemapURL{Loc: a.archiveCampURL(camp)}
+		if camp.UpdatedAt.Valid {
+			u.LastMod = camp.UpdatedAt.Time.UTC().Format(time.RFC3339)
+		}
+		out.URLs = append(out.URLs, u)
+	}
+
+	b, err := xml.Marshal(out)
+	if err != nil {
+		a.log.Printf("error generating archive sitemap: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("public.errorProcessingRequest"))
+	}
+	b = append([]byte(xml.Header), b...)
+
+	a.archiveSitemap.set(b)
+
+	return c.Blob(http.StatusOK, "application/xml; charset=utf-8", b)
+}
+
 // CampaignArchivesPage renders the public campaign archives page.
 func (a *App) CampaignArchivesPage(c echo.Context) error {
 	// Get archives from the DB.
@@ -129,7 +233,7 @@ func (a *App) CampaignArchivePage(c echo.Context) error {
 	}
 
 	// Get the campaign from the DB.
-	pubCamp, err := a.core.GetArchivedCampaign(0, uuid, slug)
+	pubCamp, err := a.reqCore(c).GetArchivedCampaign(0, uuid, slug)
 	if err != nil || pubCamp.Type != models.CampaignTypeRegular {
 		notFound := false
 
@@ -154,6 +258,21 @@ func (a *App) CampaignArchivePage(c echo.Context) error {
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
 	}
 
+	// Non-public campaigns are accessible only with a signed URL or a password,
+	// and are never indexed.
+	switch pubCamp.ArchiveAccess {
+	case models.CampaignArchiveAccessUnlisted:
+		if !a.verifySignedURL(c, archiveSignMsg(pubCamp.UUID)) {
+			return c.Render(http.StatusNotFound, tplMessage,
+				makeMsgTpl(a.i18n.T("public.notFoundTitle"), "", a.i18n.T("public.campaignNotFound")))
+		}
+
+	case models.CampaignArchiveAccessPassword:
+		if ok, err := a.checkArchivePassword(c, pubCamp); !ok {
+			return err
+		}
+	}
+
 	// "Compile" the campaign template with appropriate data.
 	out, err := a.compileArchiveCampaigns([]models.Campaign{pubCamp})
 	if err != nil {
@@ -170,7 +289,128 @@ func (a *App) CampaignArchivePage(c echo.Context) error {
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
 	}
 
-	return c.HTML(http.StatusOK, string(msg.Body()))
+	// Inject the canonical URL and the title and description meta tags.
+	// Non-public campaigns get a noindex tag instead of the canonical URL.
+	canonicalURL := ""
+	if pubCamp.ArchiveAccess == models.CampaignArchiveAccessPublic {
+		canonicalURL = a.archiveCampURL(pubCamp)
+	} else {
+		c.Response().Header().Set("X-Robots-Tag", "noindex")
+	}
+	body := injectArchiveMeta(msg.Body(), msg.Subject(), msg.PreviewText(), canonicalURL)
+
+	return c.HTML(http.StatusOK, string(body))
+}
+
+// checkArchivePassword checks whether the request has access to a password
+// protected archive campaign, either with a cookie set on an earlier successful
+// attempt or a posted password. If it doesn't, the password form is rendered and
+// false is returned along with the render result.
+func (a *App) checkArchivePassword(c echo.Context, camp models.Campaign) (bool, error) {
+	var (
+		cookieName = "archive_" + camp.UUID
+
+		// Changing the password invalidates earlier cookies.
+		msg = "archive-password:" + camp.UUID + ":" + camp.ArchivePassword.String
+	)
+
+	// Valid cookie from an earlier attempt.
+	if ck, err := c.Cookie(cookieName); err == nil {
+		if expStr, sig, ok := strings.Cut(ck.Value, "."); ok {
+			exp, _ := strconv.ParseInt(expStr, 10, 64)
+			if exp > 0 && a.verifySign(msg, exp, sig) {
+				return true, nil
+			}
+		}
+	}
+
+	tpl := struct {
+		publicTpl
+		Error string
+	}{publicTpl: publicTpl{Title: a.i18n.T("public.archivePasswordTitle")}}
+
+	if c.Request().Method != http.MethodPost {
+		return false, c.Render(http.StatusOK, "archive-password", tpl)
+	}
+
+	ok, err := a.core.CheckCampaignArchivePassword(camp.ID, c.FormValue("password"))
+	if err != nil {
+		return false, c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
+	}
+	if !ok {
+		tpl.Error = a.i18n.T("public.archivePasswordInvalid")
+		return false, c.Render(http.StatusUnauthorized, "archive-password", tpl)
+	}
+
+	exp := time.Now().Add(archivePasswordCookieAge).Unix()
+	c.SetCookie(&http.Cookie{
+		Name:     cookieName,
+		Value:    strconv.FormatInt(exp, 10) + "." + a.sign(msg, exp),
+		Path:     "/archive",
+		Expires:  time.Unix(exp, 0),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.urlCfg.RootURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return true, nil
+}
+
+// archiveSignMsg returns the message that's signed in unlisted archive URLs.
+func archiveSignMsg(campUUID string) string {
+	return "archive:" + campUUID
+}
+
+// archiveCampURL returns the public archive URL of a campaign, which uses the
+// campaign's custom slug if there's one.
+func (a *App) archiveCampURL(camp models.Campaign) string {
+	id := camp.UUID
+	if camp.ArchiveSlug.Valid {
+		id = camp.ArchiveSlug.String
+	}
+
+	u, _ := url.JoinPath(a.urlCfg.ArchiveURL, id)
+	return u
+}
+
+// injectArchiveMeta adds the title, description, and Open Graph meta tags to the
+// <head> of a HTML body. If canonicalURL is empty, a robots noindex tag is added
+// in place of the canonical link. A <title> is only added if the body doesn't
+// have one.
+func injectArchiveMeta(body []byte, title, description, canonicalURL string) []byte {
+	var (
+		b   strings.Builder
+		esc = template.HTMLEscapeString
+	)
+
+	if !reHTMLTitle.Match(body) {
+		b.WriteString(`<title>` + esc(title) + `</title>`)
+	}
+
+	if canonicalURL != "" {
+		b.WriteString(`<link rel="canonical" href="` + esc(canonicalURL) + `" />`)
+		b.WriteString(`<meta property="og:url" content="` + esc(canonicalURL) + `" />`)
+	} else {
+		b.WriteString(`<meta name="robots" content="noindex" />`)
+	}
+
+	b.WriteString(`<meta property="og:title" content="` + esc(title) + `" />`)
+	if description != "" {
+		b.WriteString(`<meta name="description" content="` + esc(description) + `" />`)
+		b.WriteString(`<meta property="og:description" content="` + esc(description) + `" />`)
+	}
+	meta := b.String()
+
+	loc := reHTMLHead.FindIndex(body)
+	if loc == nil {
+		return append([]byte(meta), body...)
+	}
+
+	out := make([]byte, 0, len(body)+len(meta))
+	out = append(out, body[:loc[1]]...)
+	out = append(out, meta...)
+	return append(out, body[loc[1]:]...)
 }
 
 // CampaignArchivePageLatest renders the latest public campaign.
@@ -214,11 +454,7 @@ func (a *App) getCampaignArchives(offset, limit int, renderBody bool) ([]campArc
 		}
 
 		// The campaign may have a custom slug.
-		if camp.ArchiveSlug.Valid {
-			archive.URL, _ = url.JoinPath(a.urlCfg.ArchiveURL, camp.ArchiveSlug.String)
-		} else {
-			archive.URL, _ = url.JoinPath(a.urlCfg.ArchiveURL, camp.UUID)
-		}
+		archive.URL = a.archiveCampURL(*camp)
 
 		// Render the full template body if requested.
 		if renderBody {
@@ -244,6 +480,11 @@ func (a *App) compileArchiveCampaigns(camps []models.Campaign) ([]manager.Campai
 	)
 	for _, c := range camps {
 		camp := c
+
+		// Assets are resolved from the archive template that's rendered.
+		if camp.ArchiveTemplateID.Valid {
+			camp.TemplateID = camp.ArchiveTemplateID
+		}
 		if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
 			a.log.Printf("error compiling template: %v", err)
 			return nil, echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("public.errorFetchingCampaign"))
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+func TestArchivePasswordRateLimit(t *testing.T) {
+	a := newTestApp(t)
+	a.cfg.EnablePublicArchive = true
+	a.cfg.Security.PublicRateLimit = 2
+
+	e := newTestEcho()
+	e.Use(middleware.Recover())
+	initHTTPHandlers(e, a)
+
+	// There's no DB, so the requests that get through fail, but the ones over
+	// the limit are rejected before reaching the handler.
+	form := url.Values{"password": {"guess"}}
+	for i := range 2 {
+		if rec := doForm(e, http.MethodPost, "/archive/test", form); rec.Code == http.StatusTooManyRequests {
+			t.Fatalf("request %d: unexpected rate limit", i)
+		}
+	}
+	if rec := doForm(e, http.MethodPost, "/archive/test", form); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+}
+
+func TestArchiveSignedURL(t *testing.T) {
+	a := newTestApp(t)
+	a.cfg.Security.SigningKey = "secret"
+
+	var (
+		campUUID = "2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11"
+		msg      = archiveSignMsg(campUUID)
+		exp      = time.Now().Add(time.Hour).Unix()
+		signed   = a.signURL("https://example.com/archive/"+campUUID, msg, exp)
+	)
+
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+
+	tamper := func(fn func(url.Values)) string {
+		v := url.Values{}
+		for k, vals := range q {
+			v[k] = append([]string{}, vals...)
+		}
+		fn(v)
+		return "/?" + v.Encode()
+	}
+
+	cases := []struct {
+		name   string
+		target string
+		msg    string
+		ok     bool
+	}{
+		{"valid", "/?" + q.Encode(), msg, true},
+		{"no signature", "/", msg, false},
+		{"tampered signature", tamper(func(v url.Values) {
+			s, c := v.Get("sig"), "0"
+			if s[len(s)-1] == '0' {
+				c = "1"
+			}
+			v.Set("sig", s[:len(s)-1]+c)
+		}), msg, false},
+		{"extended expiry", tamper(func(v url.Values) { v.Set("exp", strconv.FormatInt(exp+3600, 10)) }), msg, false},
+		{"removed expiry", tamper(func(v url.Values) { v.Del("exp") }), msg, false},
+		{"other campaign", "/?" + q.Encode(), archiveSignMsg("7c1e0b7e-3f38-4b47-9f6a-0b1e2d3c4f5a"), false},
+	}
+
+	e := echo.New()
+	for _, c := range cases {
+		ctx := e.NewContext(httptest.NewRequest(http.MethodGet, c.target, nil), httptest.NewRecorder())
+		if ok := a.verifySignedURL(ctx, c.msg); ok != c.ok {
+			t.Errorf("%s: expected %v, got %v", c.name, c.ok, ok)
+		}
+	}
+
+	// Expired signatures are rejected.
+	expired := a.signURL("https://example.com", msg, time.Now().Add(-time.Minute).Unix())
+	u, _ = url.Parse(expired)
+	ctx := e.NewContext(httptest.NewRequest(http.MethodGet, "/?"+u.RawQuery, nil), httptest.NewRecorder())
+	if a.verifySignedURL(ctx, msg) {
+		t.Error("expected an expired signature to be rejected")
+	}
+
+	// Signatures made with another key are rejected.
+	b := newTestApp(t)
+	b.cfg.Security.SigningKey = "other"
+	ctx = e.NewContext(httptest.NewRequest(http.MethodGet, "/?"+q.Encode(), nil), httptest.NewRecorder())
+	if b.verifySignedURL(ctx, msg) {
+		t.Error("expected a signature made with another key to be rejected")
+	}
+}
+
+func TestArchivePassword(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.cfg.Security.SigningKey = "secret"
+
+	var camp models.Campaign
+	if err := db.Get(&camp, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, archive)
+		VALUES (gen_random_uuid(), 'test', 'test', 'from@example.com', 'body', 'email', true) RETURNING id, uuid`); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.core.UpdateCampaignArchive(camp.ID, true, 0, models.JSON{}, "", models.CampaignArchiveAccessPassword, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+	camp, err := a.core.GetArchivedCampaign(camp.ID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEcho()
+	check := func(method string, form url.Values, cookies ...*http.Cookie) (bool, *httptest.ResponseRecorder) {
+		req := httptest.NewRequest(method, "/archive/"+camp.UUID, strings.NewReader(form.Encode()))
+		if form != nil {
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		}
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+
+		ok, err := a.checkArchivePassword(e.NewContext(req, rec), camp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ok, rec
+	}
+
+	// No password shows the form.
+	if ok, rec := check(http.MethodGet, nil); ok || rec.Body.String() != "tpl:archive-password" {
+		t.Fatalf("expected the password form, got %v %q", ok, rec.Body.String())
+	}
+
+	// Wrong passwords are rejected.
+	for _, p := range []string{"", "wrong", "correct horse ", "CORRECT HORSE"} {
+		ok, rec := check(http.MethodPost, url.Values{"password": {p}})
+		if ok || rec.Code != http.StatusUnauthorized {
+			t.Fatalf("password %q: expected %d, got %v %d", p, http.StatusUnauthorized, ok, rec.Code)
+		}
+		if len(rec.Result().Cookies()) > 0 {
+			t.Fatalf("password %q: unexpected cookie", p)
+		}
+	}
+
+	// The right password sets an access cookie.
+	ok, rec := check(http.MethodPost, url.Values{"password": {"correct horse"}})
+	if !ok {
+		t.Fatal("expected the right password to be accepted")
+	}
+	cks := rec.Result().Cookies()
+	if len(cks) != 1 || cks[0].Name != "archive_"+camp.UUID || !cks[0].HttpOnly || cks[0].Secure {
+		t.Fatalf("unexpected cookies %v", cks)
+	}
+	ck := cks[0]
+
+	// The cookie is only sent over HTTPS when the root URL is HTTPS.
+	a.urlCfg.RootURL = "https://example.com"
+	_, rec = check(http.MethodPost, url.Values{"password": {"correct horse"}})
+	if cks := rec.Result().Cookies(); len(cks) != 1 || !cks[0].Secure {
+		t.Fatalf("expected a secure cookie, got %v", cks)
+	}
+	a.urlCfg.RootURL = ""
+
+	// The cookie grants access without the password.
+	if ok, _ := check(http.MethodGet, nil, ck); !ok {
+		t.Fatal("expected the cookie to be accepted")
+	}
+
+	// Tampered cookies are rejected.
+	exp, sig, _ := strings.Cut(ck.Value, ".")
+	n, _ := strconv.ParseInt(exp, 10, 64)
+	for name, val := range map[string]string{
+		"extended expiry": strconv.FormatInt(n+3600, 10) + "." + sig,
+		"no expiry":       "0." + sig,
+		"no signature":    exp + ".",
+		"bad signature":   exp + "." + strings.Repeat("0", len(sig)),
+		"garbage":         "garbage",
+	} {
+		if ok, _ := check(http.MethodGet, nil, &http.Cookie{Name: ck.Name, Value: val}); ok {
+			t.Errorf("%s: expected the tampered cookie to be rejected", name)
+		}
+	}
+
+	// Changing the password invalidates the cookie.
+	if err := a.core.UpdateCampaignArchive(camp.ID, true, 0, models.JSON{}, "", models.CampaignArchiveAccessPassword, "new password"); err != nil {
+		t.Fatal(err)
+	}
+	if camp, err = a.core.GetArchivedCampaign(camp.ID, "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := check(http.MethodGet, nil, ck); ok {
+		t.Fatal("expected the cookie to be rejected after the password changed")
+	}
+}
+
+func TestArchiveSitemapCache(t *testing.T) {
+	var s archiveSitemap
+	if _, ok := s.get(); ok {
+		t.Fatal("expected an empty cache")
+	}
+
+	s.set([]byte("sitemap"))
+	if b, ok := s.get(); !ok || string(b) != "sitemap" {
+		t.Errorf("expected the cached sitemap, got %s", b)
+	}
+
+	// Invalidated and expired sitemaps aren't returned.
+	s.invalidate()
+	if _, ok := s.get(); ok {
+		t.Error("expected the invalidated sitemap to not be returned")
+	}
+	s.set([]byte("sitemap"))
+	s.expires = time.Now().Add(-time.Second)
+	if _, ok := s.get(); ok {
+		t.Error("expected the expired sitemap to not be returned")
+	}
+}
+
+func TestInjectArchiveMeta(t *testing.T) {
+	cases := []struct {
+		name      string
+		body      string
+		title     string
+		desc      string
+		canonical string
+		exp       string
+	}{
+		{
+			"public", `<html><head><meta charset="utf-8"></head><body>Hi</body></html>`, `News & "more"`, "Inside", "https://example.com/archive/news",
+			`<html><head><title>News &amp; &#34;more&#34;</title><link rel="canonical" href="https://example.com/archive/news" />` +
+				`<meta property="og:url" content="https://example.com/archive/news" /><meta property="og:title" content="News &amp; &#34;more&#34;" />` +
+				`<meta name="description" content="Inside" /><meta property="og:description" content="Inside" /><meta charset="utf-8"></head><body>Hi</body></html>`,
+		},
+		{
+			"noindex with a title", `<HTML><HEAD lang="en"><title>Own</title></HEAD></HTML>`, "News", "", "",
+			`<HTML><HEAD lang="en"><meta name="robots" content="noindex" /><meta property="og:title" content="News" /><title>Own</title></HEAD></HTML>`,
+		},
+		{
+			"no head", `<p>Hi</p>`, "News", "", "",
+			`<title>News</title><meta name="robots" content="noindex" /><meta property="og:title" content="News" /><p>Hi</p>`,
+		},
+	}
+	for _, c := range cases {
+		if got := string(injectArchiveMeta([]byte(c.body), c.title, c.desc, c.canonical)); got != c.exp {
+			t.Errorf("%s:\nexpected %s\ngot      %s", c.name, c.exp, got)
+		}
+	}
+}
+
+// TestArchiveSitemap checks that only public archived campaigns are listed in
+// the sitemap and that it's cached until it's invalidated.
+func TestArchiveSitemap(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.urlCfg.ArchiveURL = "https://example.com/archive"
+	a.archiveSitemap = &archiveSitemap{}
+
+	e := newTestEcho()
+	e.GET("/archive/sitemap.xml", a.GetCampaignArchiveSitemap)
+
+	newCamp := func(name, status string, archive bool, access string, slug any) string {
+		var uuid string
+		if err := db.Get(&uuid, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, archive, archive_access, archive_slug, updated_at)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', 'Hello', 'email', $2, $3, $4, $5, '2026-01-02T03:04:05Z') RETURNING uuid`,
+			name, status, archive, access, slug); err != nil {
+			t.Fatal(err)
+		}
+		return uuid
+	}
+	public := newCamp("public", models.CampaignStatusFinished, true, models.CampaignArchiveAccessPublic, nil)
+	newCamp("slugged", models.CampaignStatusRunning, true, models.CampaignArchiveAccessPublic, "slugged")
+	unlisted := newCamp("unlisted", models.CampaignStatusFinished, true, models.CampaignArchiveAccessUnlisted, nil)
+	password := newCamp("password", models.CampaignStatusFinished, true, models.CampaignArchiveAccessPassword, nil)
+	unarchived := newCamp("unarchived", models.CampaignStatusFinished, false, models.CampaignArchiveAccessPublic, nil)
+	draft := newCamp("draft", models.CampaignStatusDraft, true, models.CampaignArchiveAccessPublic, nil)
+
+	type urlSet struct {
+		XMLName xml.Name `xml:"urlset"`
+		XMLNS   string   `xml:"xmlns,attr"`
+		URLs    []struct {
+			Loc     string `xml:"loc"`
+			LastMod string `xml:"lastmod"`
+		} `xml:"url"`
+	}
+	get := func() (urlSet, string) {
+		t.Helper()
+
+		rec := doForm(e, http.MethodGet, "/archive/sitemap.xml", nil)
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get(echo.HeaderContentType), "application/xml") {
+			t.Fatalf("expected an XML sitemap, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if !strings.HasPrefix(rec.Body.String(), xml.Header) {
+			t.Errorf("expected the XML header, got %s", rec.Body.String())
+		}
+
+		var s urlSet
+		if err := xml.Unmarshal(rec.Body.Bytes(), &s); err != nil {
+			t.Fatalf("invalid XML: %v: %s", err, rec.Body.String())
+		}
+		return s, rec.Body.String()
+	}
+
+	s, body := get()
+	if s.XMLNS != "http://www.sitemaps.org/schemas/sitemap/0.9" || len(s.URLs) != 2 {
+		t.Fatalf("expected 2 URLs in the sitemap, got %s", body)
+	}
+	locs := map[string]string{}
+	for _, u := range s.URLs {
+		locs[u.Loc] = u.LastMod
+	}
+	for _, loc := range []string{"https://example.com/archive/" + public, "https://example.com/archive/slugged"} {
+		if lastMod, ok := locs[loc]; !ok || lastMod != "2026-01-02T03:04:05Z" {
+			t.Errorf("expected %s with its lastmod in the sitemap, got %s", loc, body)
+		}
+	}
+	for _, uuid := range []string{unlisted, password, unarchived, draft} {
+		if strings.Contains(body, uuid) {
+			t.Errorf("expected %s to not be in the sitemap", uuid)
+		}
+	}
+
+	// The sitemap is cached until it's invalidated.
+	if _, err := db.Exec(`UPDATE campaigns SET archive_access = 'unlisted' WHERE uuid = $1`, public); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := get(); len(s.URLs) != 2 {
+		t.Errorf("expected the cached sitemap, got %d URLs", len(s.URLs))
+	}
+	a.archiveSitemap.invalidate()
+	if s, body := get(); len(s.URLs) != 1 || strings.Contains(body, public) {
+		t.Errorf("expected the regenerated sitemap without the unlisted campaign, got %s", body)
+	}
+}
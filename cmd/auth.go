@@ -242,7 +242,7 @@ func (a *App) OIDCFinish(c echo.Context) error {
 	claims.Email = email
 
 	// Get the user by e-mail received from OIDC.
-	user, userErr := a.core.GetUser(0, "", email)
+	user, userErr := a.reqCore(c).GetUser(0, "", email)
 	if userErr != nil {
 		// If the user doesn't exist, and auto-creation is enabled, create a new user.
 		if httpErr, ok := userErr.(*echo.HTTPError); ok && httpErr.Code == http.StatusNotFound && a.cfg.Security.OIDC.AutoCreateUsers {
@@ -258,7 +258,7 @@ func (a *App) OIDCFinish(c echo.Context) error {
 	}
 
 	// Update the user login state (avatar, logged in date) in the DB.
-	if err := a.core.UpdateUserLogin(user.ID, claims.Picture); err != nil {
+	if err := a.reqCore(c).UpdateUserLogin(user.ID, claims.Picture); err != nil {
 		return a.renderLoginPage(c, err)
 	}
 
@@ -302,7 +302,7 @@ func (a *App) ResetPage(c echo.Context) error {
 	}
 
 	// Validate that the user exists.
-	_, err = a.core.GetUser(0, "", email)
+	_, err = a.reqCore(c).GetUser(0, "", email)
 	if err != nil {
 		return c.Render(http.StatusBadRequest, tplMessage, makeMsgTpl(a.i18n.T("users.resetPassword"), "", a.i18n.T("users.invalidResetLink")))
 	}
@@ -469,10 +469,18 @@ func (a *App) doLogin(c echo.Context) error {
 	}
 
 	// Log the user in by fetching and verifying credentials from the DB.
-	user, err := a.core.LoginUser(username, password)
+	user, err := a.reqCore(c).LoginUser(username, password)
+
+	// The client IP is found via the trusted proxies only (see initHTTPServer), so
+	// that the login events can't be attributed to spoofed IPs.
 	if err != nil {
+		// Record invalid credentials for security alerts. Errors are logged by core.
+		if e, ok := err.(*echo.HTTPError); ok && e.Code == http.StatusForbidden {
+			_ = a.core.RecordLoginEvent(username, c.RealIP(), false)
+		}
 		return err
 	}
+	_ = a.core.RecordLoginEvent(username, c.RealIP(), true)
 
 	// If TOTP is enabled for the user, create a temp token and redirect to the 2FA page.
 	if user.TwofaType == models.TwofaTypeTOTP {
@@ -521,7 +529,7 @@ func (a *App) doFirstTimeSetup(c echo.Context) error {
 	}
 
 	// Create the default "Super Admin" with all permissions if it doesn't exist.
-	if _, err := a.core.GetRole(auth.SuperAdminRoleID); err != nil {
+	if _, err := a.reqCore(c).GetRole(auth.SuperAdminRoleID); err != nil {
 		r := auth.Role{
 			Type: auth.RoleTypeUser,
 			Name: null.NewString("Super Admin", true),
@@ -531,7 +539,7 @@ func (a *App) doFirstTimeSetup(c echo.Context) error {
 		}
 
 		// Create the role in the DB.
-		if _, err := a.core.CreateRole(r); err != nil {
+		if _, err := a.reqCore(c).CreateRole(r); err != nil {
 			return err
 		}
 	}
@@ -548,12 +556,12 @@ func (a *App) doFirstTimeSetup(c echo.Context) error {
 		UserRoleID:    auth.SuperAdminRoleID,
 		Status:        auth.UserStatusEnabled,
 	}
-	if _, err := a.core.CreateUser(u); err != nil {
+	if _, err := a.reqCore(c).CreateUser(u); err != nil {
 		return err
 	}
 
 	// Log the user in directly.
-	user, err := a.core.LoginUser(username, password)
+	user, err := a.reqCore(c).LoginUser(username, password)
 	if err != nil {
 		return err
 	}
@@ -589,7 +597,7 @@ func (a *App) doForgotPassword(c echo.Context) error {
 	}
 
 	// Get the user by email.
-	user, err := a.core.GetUser(0, "", email)
+	user, err := a.reqCore(c).GetUser(0, "", email)
 	if err != nil {
 		return c.Render(http.StatusOK, tplMessage, makeMsgTpl(a.i18n.T("users.resetPassword"), "", a.i18n.T("users.resetLinkSent")))
 	}
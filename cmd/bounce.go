@@ -2,11 +2,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
-	"time"
 
+	"github.com/knadh/listmonk/internal/bounce"
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
@@ -15,11 +18,15 @@ import (
 func (a *App) GetBounce(c echo.Context) error {
 	// Fetch one bounce from the DB.
 	id := getID(c)
-	out, err := a.core.GetBounce(id)
+	out, err := a.reqCore(c).GetBounce(id)
 	if err != nil {
 		return err
 	}
 
+	if isPIIRedacted(c) {
+		out = redactBounce(out)
+	}
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -34,36 +41,63 @@ func (a *App) GetBounces(c echo.Context) error {
 		pg = a.pg.NewFromURL(c.Request().URL.Query())
 	)
 
-	// Query and fetch bounces from the DB.
-	res, total, err := a.core.QueryBounces(campID, 0, source, orderBy, order, pg.Offset, pg.Limit)
+	// Stream the bounces from the DB cursor to the response as they're read instead of
+	// loading all of them into memory, as ?per_page=all can be a very large result set.
+	var (
+		w      = c.Response()
+		enc    = json.NewEncoder(w)
+		wrote  = false
+		redact = isPIIRedacted(c)
+	)
+	total, err := a.reqCore(c).StreamBounces(c.Request().Context(), campID, source, orderBy, order, pg.Offset, pg.Limit, skipTotal(c), func(b models.Bounce) error {
+		if !wrote {
+			w.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+			w.WriteHeader(http.StatusOK)
+			if _, err := io.WriteString(w, `{"data":{"results":[`); err != nil {
+				return err
+			}
+			wrote = true
+		} else if _, err := io.WriteString(w, ","); err != nil {
+			return err
+		}
+
+		if redact {
+			b = redactBounce(b)
+		}
+		return enc.Encode(b)
+	})
+
+	// The response has already begun and the error can't be sent.
+	if wrote {
+		if err != nil {
+			a.log.Printf("error streaming bounces: %v", err)
+			return nil
+		}
+
+		_, err := fmt.Fprintf(w, `],"search":"","query":"","total":%d,"per_page":%d,"page":%d}}`, total, pg.PerPage, pg.Page)
+		return err
+	}
 	if err != nil {
 		return err
 	}
 
 	// No results.
-	if len(res) == 0 {
-		return c.JSON(http.StatusOK, okResp{models.PageResults{Results: []models.Bounce{}}})
-	}
-
-	out := models.PageResults{
-		Results: res,
-		Total:   total,
-		Page:    pg.Page,
-		PerPage: pg.PerPage,
-	}
-
-	return c.JSON(http.StatusOK, okResp{out})
+	return c.JSON(http.StatusOK, okResp{makePageResults([]models.Bounce{}, total, pg)})
 }
 
 // GetSubscriberBounces retrieves a subscriber's bounce records.
 func (a *App) GetSubscriberBounces(c echo.Context) error {
 	// Query and fetch bounces from the DB.
 	subID := getID(c)
-	out, _, err := a.core.QueryBounces(0, subID, "", "", "", 0, 1000)
+	out, _, err := a.reqCore(c).QueryBounces(0, subID, "", "", "", 0, 1000)
 	if err != nil {
 		return err
 	}
 
+	if isPIIRedacted(c) {
+		out = redactBounces(out)
+	}
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -86,7 +120,7 @@ func (a *App) DeleteBounces(c echo.Context) error {
 	}
 
 	// Delete bounces from the DB.
-	if err := a.core.DeleteBounces(ids, all); err != nil {
+	if err := a.reqCore(c).DeleteBounces(ids, all); err != nil {
 		return err
 	}
 
@@ -97,7 +131,7 @@ func (a *App) DeleteBounces(c echo.Context) error {
 func (a *App) DeleteBounce(c echo.Context) error {
 	// Delete bounces from the DB.
 	id := getID(c)
-	if err := a.core.DeleteBounces([]int{id}, false); err != nil {
+	if err := a.reqCore(c).DeleteBounces([]int{id}, false); err != nil {
 		return err
 	}
 
@@ -106,7 +140,7 @@ func (a *App) DeleteBounce(c echo.Context) error {
 
 // BlocklistBouncedSubscribers handles blocklisting of all bounced subscribers.
 func (a *App) BlocklistBouncedSubscribers(c echo.Context) error {
-	if err := a.core.BlocklistBouncedSubscribers(); err != nil {
+	if err := a.reqCore(c).BlocklistBouncedSubscribers(); err != nil {
 		return err
 	}
 
@@ -134,21 +168,6 @@ func (a *App) BounceWebhook(c echo.Context) error {
 		if err := json.Unmarshal(rawReq, &b); err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+":"+err.Error())
 		}
-
-		if bv, err := a.validateBounceFields(b); err != nil {
-			return err
-		} else {
-			b = bv
-		}
-
-		if len(b.Meta) == 0 {
-			b.Meta = json.RawMessage("{}")
-		}
-
-		if b.CreatedAt.Year() == 0 {
-			b.CreatedAt = time.Now()
-		}
-
 		bounces = append(bounces, b)
 
 	// Amazon SES.
@@ -158,6 +177,9 @@ func (a *App) BounceWebhook(c echo.Context) error {
 		// start getting bounce notifications.
 		case "SubscriptionConfirmation", "UnsubscribeConfirmation":
 			if err := a.bounce.SES.ProcessSubscription(rawReq); err != nil {
+				if errors.Is(err, webhooks.ErrUnverified) {
+					return echo.NewHTTPError(http.StatusUnauthorized, a.i18n.T("globals.messages.invalidData"))
+				}
 				a.log.Printf("error processing SNS (SES) subscription: %v", err)
 				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
 			}
@@ -166,6 +188,9 @@ func (a *App) BounceWebhook(c echo.Context) error {
 		case "Notification":
 			b, err := a.bounce.SES.ProcessBounce(rawReq)
 			if err != nil {
+				if errors.Is(err, webhooks.ErrUnverified) {
+					return echo.NewHTTPError(http.StatusUnauthorized, a.i18n.T("globals.messages.invalidData"))
+				}
 				a.log.Printf("error processing SES notification: %v", err)
 				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
 			}
@@ -176,7 +201,7 @@ func (a *App) BounceWebhook(c echo.Context) error {
 		}
 
 	// SendGrid.
-	case service == "sendgrid" && a.cfg.BounceSendgridEnabled:
+	case service == "sendgrid" && a.cfg.BounceSendgridEnabled && a.bounce.Sendgrid != nil:
 		var (
 			sig = c.Request().Header.Get("X-Twilio-Email-Event-Webhook-Signature")
 			ts  = c.Request().Header.Get("X-Twilio-Email-Event-Webhook-Timestamp")
@@ -185,19 +210,22 @@ func (a *App) BounceWebhook(c echo.Context) error {
 		// Sendgrid sends multiple bounces.
 		bs, err := a.bounce.Sendgrid.ProcessBounce(sig, ts, rawReq)
 		if err != nil {
+			if errors.Is(err, webhooks.ErrUnverified) {
+				return echo.NewHTTPError(http.StatusUnauthorized, a.i18n.T("globals.messages.invalidData"))
+			}
 			a.log.Printf("error processing sendgrid notification: %v", err)
 			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
 		}
 		bounces = append(bounces, bs...)
 
 	// Postmark.
-	case service == "postmark" && a.cfg.BouncePostmarkEnabled:
+	case service == "postmark" && a.cfg.BouncePostmarkEnabled && a.bounce.Postmark != nil:
 		bs, err := a.bounce.Postmark.ProcessBounce(rawReq, c)
 		if err != nil {
-			a.log.Printf("error processing postmark notification: %v", err)
-			if _, ok := err.(*echo.HTTPError); ok {
-				return err
+			if errors.Is(err, webhooks.ErrUnverified) {
+				return echo.NewHTTPError(http.StatusUnauthorized, a.i18n.T("globals.messages.invalidData"))
 			}
+			a.log.Printf("error processing postmark notification: %v", err)
 
 			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
 		}
@@ -211,10 +239,10 @@ func (a *App) BounceWebhook(c echo.Context) error {
 
 		bs, err := a.bounce.Forwardemail.ProcessBounce(sig, rawReq)
 		if err != nil {
-			a.log.Printf("error processing forwardemail notification: %v", err)
-			if _, ok := err.(*echo.HTTPError); ok {
-				return err
+			if errors.Is(err, webhooks.ErrUnverified) {
+				return echo.NewHTTPError(http.StatusUnauthorized, a.i18n.T("globals.messages.invalidData"))
 			}
+			a.log.Printf("error processing forwardemail notification: %v", err)
 
 			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
 		}
@@ -224,36 +252,21 @@ func (a *App) BounceWebhook(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("bounces.unknownService"))
 	}
 
-	// Insert bounces into the DB.
+	// Validate and record the bounces.
 	for _, b := range bounces {
-		if err := a.bounce.Record(b); err != nil {
+		if err := a.bounce.ProcessBounce(b); err != nil {
+			// Invalid data posted to the native webhook is reported back.
+			var vErr *bounce.ValidationError
+			if service == "" && errors.As(err, &vErr) {
+				if vErr.Err != nil {
+					return echo.NewHTTPError(http.StatusBadRequest, vErr.Err.Error())
+				}
+				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", vErr.Field))
+			}
+
 			a.log.Printf("error recording bounce: %v", err)
 		}
 	}
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
-
-func (a *App) validateBounceFields(b models.Bounce) (models.Bounce, error) {
-	if b.Email == "" && b.SubscriberUUID == "" {
-		return b, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "email / subscriber_uuid"))
-	}
-
-	if b.SubscriberUUID != "" && !reUUID.MatchString(b.SubscriberUUID) {
-		return b, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "subscriber_uuid"))
-	}
-
-	if b.Email != "" {
-		em, err := a.importer.SanitizeEmail(b.Email)
-		if err != nil {
-			return b, echo.NewHTTPError(http.StatusBadRequest, err.Error())
-		}
-		b.Email = em
-	}
-
-	if b.Type != models.BounceTypeHard && b.Type != models.BounceTypeSoft && b.Type != models.BounceTypeComplaint {
-		return b, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "type"))
-	}
-
-	return b, nil
-}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/knadh/paginator"
+	"github.com/labstack/echo/v4"
+)
+
+// TestGetBounces checks that the streamed bounces are the same paginated
+// response that was serialized in memory before.
+func TestGetBounces(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.pg = paginator.New(paginator.Opt{
+		DefaultPerPage: 20,
+		MaxPerPage:     50,
+		NumPageNums:    10,
+		PageParam:      "page",
+		PerPageParam:   "per_page",
+		AllowAll:       true,
+	})
+
+	var user auth.User
+	e := newTestEcho()
+	e.GET("/api/bounces", a.GetBounces, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, user)
+			return next(c)
+		}
+	})
+
+	var subID, campID int
+	if err := db.Get(&subID, `INSERT INTO subscribers (uuid, email, name, status) VALUES (gen_random_uuid(), 'user@example.com', 'User', 'enabled') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&campID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com', '<p>Hello</p>', 'email', 'finished') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO bounces (subscriber_id, campaign_id, type, source, meta) VALUES
+		($1, $2, 'hard', 'api', '{"n": 1}'), ($1, $2, 'soft', 'api', '{"n": 2}'), ($1, NULL, 'hard', 'ses', '{"n": 3}')`, subID, campID); err != nil {
+		t.Fatal(err)
+	}
+
+	type page struct {
+		Data struct {
+			Results []json.RawMessage `json:"results"`
+			Total   int               `json:"total"`
+			PerPage int               `json:"per_page"`
+			Page    int               `json:"page"`
+		} `json:"data"`
+	}
+	get := func(target string) (page, []models.Bounce) {
+		t.Helper()
+
+		rec := doForm(e, http.MethodGet, target, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: expected 200, got %d: %s", target, rec.Code, rec.Body.String())
+		}
+		var p page
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatalf("%s: invalid JSON: %v: %s", target, err, rec.Body.String())
+		}
+		if p.Data.Results == nil {
+			t.Fatalf("%s: expected a results array: %s", target, rec.Body.String())
+		}
+
+		var out []models.Bounce
+		for _, r := range p.Data.Results {
+			var b models.Bounce
+			if err := json.Unmarshal(r, &b); err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, b)
+		}
+		return p, out
+	}
+
+	user = auth.User{UserRoleID: auth.SuperAdminRoleID}
+
+	// All the bounces, sorted.
+	p, res := get("/api/bounces?per_page=all&order_by=id&order=asc")
+	if p.Data.Total != 3 || len(res) != 3 {
+		t.Fatalf("expected 3 bounces, got %+v", p.Data)
+	}
+	if res[0].Email != "user@example.com" || res[0].Type != models.BounceTypeHard || string(res[0].Meta) != `{"n": 1}` ||
+		res[0].Campaign == nil || res[2].Campaign != nil || res[2].Source != "ses" {
+		t.Errorf("unexpected bounces %+v", res)
+	}
+
+	// A page has the total of all the matches.
+	p, res = get("/api/bounces?per_page=2&page=2&order_by=id&order=asc")
+	if p.Data.Total != 3 || p.Data.PerPage != 2 || p.Data.Page != 2 || len(res) != 1 || string(res[0].Meta) != `{"n": 3}` {
+		t.Errorf("unexpected page %+v", p.Data)
+	}
+
+	// Filters.
+	if p, _ := get("/api/bounces?campaign_id=" + strconv.Itoa(campID)); p.Data.Total != 2 || len(p.Data.Results) != 2 {
+		t.Errorf("expected the campaign's bounces, got %+v", p.Data)
+	}
+
+	// No results are an empty array.
+	if p, _ := get("/api/bounces?source=nope"); p.Data.Total != 0 || len(p.Data.Results) != 0 {
+		t.Errorf("expected no bounces, got %+v", p.Data)
+	}
+
+	// Streamed e-mails are redacted for users whose PII view is redacted.
+	user = auth.User{PermissionsMap: map[string]struct{}{auth.PermBouncesGet: {}, auth.PermPIIRedacted: {}}}
+	if _, res := get("/api/bounces?per_page=all"); len(res) != 3 || res[0].Email == "user@example.com" {
+		t.Errorf("expected redacted e-mails, got %+v", res)
+	}
+}
+
+func TestStreamBouncesAbort(t *testing.T) {
+	a, db := newTestAppDB(t)
+
+	if _, err := db.Exec(`WITH s AS (INSERT INTO subscribers (uuid, email, name, status) VALUES (gen_random_uuid(), 'user@example.com', 'User', 'enabled') RETURNING id)
+		INSERT INTO bounces (subscriber_id, source) SELECT s.id, 'api' FROM s, generate_series(1, 10000)`); err != nil {
+		t.Fatal(err)
+	}
+
+	// An error from the callback stops reading the rows.
+	errStop := errors.New("stop")
+	n := 0
+	_, err := a.core.StreamBounces(context.Background(), 0, "", "", "", 0, 0, false, func(models.Bounce) error {
+		n++
+		if n == 10 {
+			return errStop
+		}
+		return nil
+	})
+	if !errors.Is(err, errStop) || n != 10 {
+		t.Errorf("expected the stream to stop at 10, got %d: %v", n, err)
+	}
+
+	// A cancelled context, eg: an aborted download, stops the query.
+	ctx, cancel := context.WithCancel(context.Background())
+	n = 0
+	_, err = a.core.StreamBounces(ctx, 0, "", "", "", 0, 0, false, func(models.Bounce) error {
+		n++
+		if n == 1 {
+			cancel()
+		}
+		return nil
+	})
+	if err == nil || n == 10000 {
+		t.Errorf("expected the cancelled stream to stop, got %d: %v", n, err)
+	}
+}
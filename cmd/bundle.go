@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+var bundleTypes = []string{models.BundleTypeLists, models.BundleTypeTemplates, models.BundleTypeCampaigns, models.BundleTypeSettings}
+
+// ExportBundle exports lists, templates, campaigns, and settings (without secrets)
+// as a portable JSON bundle that can be imported into another instance.
+// ?types=lists,templates optionally picks the entity types to export.
+func (a *App) ExportBundle(c echo.Context) error {
+	types := bundleTypes
+	if v := strings.TrimSpace(c.QueryParam("types")); v != "" {
+		types = strings.Split(v, ",")
+		for _, t := range types {
+			if !slices.Contains(bundleTypes, t) {
+				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "types"))
+			}
+		}
+	}
+
+	out, err := a.reqCore(c).ExportBundle(types)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	// Set headers to force the browser to prompt for download.
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().Header().Set("Content-Disposition", `attachment; filename="listmonk-bundle.json"`)
+	return c.Blob(http.StatusOK, "application/json", b)
+}
+
+// ImportBundle imports a bundle produced by ExportBundle, creating entities or
+// updating the ones with matching UUIDs, and responds with per-entity results.
+func (a *App) ImportBundle(c echo.Context) error {
+	var b models.Bundle
+	if err := c.Bind(&b); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "bundle"))
+	}
+
+	if err := a.validateBundle(&b); err != nil {
+		return err
+	}
+
+	out, err := a.reqCore(c).ImportBundle(b)
+	if err != nil {
+		return err
+	}
+
+	// Imported settings only take effect on a restart.
+	var keys []string
+	for _, r := range out {
+		if r.Type == models.BundleTypeSettings && r.Action == models.BundleActionUpdated {
+			keys = append(keys, r.Name)
+		}
+	}
+	if len(keys) > 0 {
+		a.setNeedsRestart(keys, auth.GetUser(c).Username)
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// validateBundle validates and sanitizes the entities in a bundle before import
+// and returns an HTTP error on invalid entities.
+func (a *App) validateBundle(b *models.Bundle) error {
+	invalid := func(name string) error {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", name))
+	}
+	isUUID := func(s string) bool {
+		_, err := uuid.FromString(s)
+		return err == nil
+	}
+
+	if b.Version != models.BundleVersion {
+		return invalid("version")
+	}
+
+	for i, l := range b.Lists {
+		field := fmt.Sprintf("lists[%d]", i)
+		if !isUUID(l.UUID) || !strHasLen(l.Name, 1, stdInputMaxLen) {
+			return invalid(field)
+		}
+		if l.Type != models.ListTypePrivate && l.Type != models.ListTypePublic {
+			b.Lists[i].Type = models.ListTypePrivate
+		}
+		if l.Optin != models.ListOptinSingle && l.Optin != models.ListOptinDouble {
+			b.Lists[i].Optin = models.ListOptinSingle
+		}
+		if l.Status != models.ListStatusActive && l.Status != models.ListStatusArchived {
+			b.Lists[i].Status = models.ListStatusActive
+		}
+		if !validateTags(l.Tags) || l.PurgeUnconfirmedAfterDays < 0 || (l.SunsetInactiveDays.Valid && l.SunsetInactiveDays.Int < 0) {
+			return invalid(field)
+		}
+		if !strHasLen(l.PublicDescription, 0, stdInputMaxLen) || !strHasLen(l.Frequency, 0, listFrequencyMaxLen) {
+			return invalid(field)
+		}
+	}
+
+	for i, t := range b.Templates {
+		field := fmt.Sprintf("templates[%d]", i)
+		if !isUUID(t.UUID) {
+			return invalid(field)
+		}
+		if t.Type != models.TemplateTypeCampaign && t.Type != models.TemplateTypeCampaignVisual && t.Type != models.TemplateTypeTx {
+			return invalid(field + ".type")
+		}
+
+		if err := a.validateTemplate(models.Template{Name: t.Name, Type: t.Type, Subject: t.Subject, Body: t.Body, Tags: t.Tags, BlockStyles: t.BlockStyles}); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, field+": "+httpErrMsg(err))
+		}
+	}
+
+	for i, cm := range b.Campaigns {
+		field := fmt.Sprintf("campaigns[%d]", i)
+		if !isUUID(cm.UUID) || !strHasLen(cm.Name, 1, stdInputMaxLen) || !strHasLen(cm.Subject, 1, 5000) || !validateTags(cm.Tags) {
+			return invalid(field)
+		}
+		if cm.TemplateUUID.Valid && !isUUID(cm.TemplateUUID.String) {
+			return invalid(field + ".template_uuid")
+		}
+		if cm.ArchiveTemplateUUID.Valid && !isUUID(cm.ArchiveTemplateUUID.String) {
+			return invalid(field + ".archive_template_uuid")
+		}
+		for _, u := range cm.ListUUIDs {
+			if !isUUID(u) {
+				return invalid(field + ".list_uuids")
+			}
+		}
+
+		switch cm.Type {
+		case models.CampaignTypeRegular, models.CampaignTypeOptin, models.CampaignTypeAdhoc:
+		default:
+			b.Campaigns[i].Type = models.CampaignTypeRegular
+		}
+
+		switch cm.ContentType {
+		case models.CampaignContentTypeRichtext, models.CampaignContentTypeHTML, models.CampaignContentTypePlain,
+			models.CampaignContentTypeVisual, models.CampaignContentTypeMarkdown, models.CampaignContentTypeBlocks:
+		default:
+			b.Campaigns[i].ContentType = models.CampaignContentTypeRichtext
+		}
+
+		switch cm.TrackingMode {
+		case models.CampaignTrackingModeFull, models.CampaignTrackingModeClicksOnly, models.CampaignTrackingModeNone:
+		default:
+			b.Campaigns[i].TrackingMode = a.cfg.Privacy.TrackingMode
+		}
+
+		// The messenger may not exist on this instance.
+		if !a.manager.HasMessenger(cm.Messenger) {
+			b.Campaigns[i].Messenger = emailMsgr
+		}
+		if cm.FromEmail == "" {
+			b.Campaigns[i].FromEmail = a.cfg.FromEmail
+		}
+		if cm.Headers == nil {
+			b.Campaigns[i].Headers = models.Headers{}
+		}
+		if len(cm.ArchiveMeta) == 0 {
+			b.Campaigns[i].ArchiveMeta = json.RawMessage("{}")
+		}
+	}
+
+	// Check that the imported settings, merged with the current ones, are
+	// of the right types.
+	if len(b.Settings) > 0 {
+		cur, err := a.core.GetSettings()
+		if err != nil {
+			return err
+		}
+
+		curB, err := json.Marshal(cur)
+		if err != nil {
+			return invalid("settings")
+		}
+		merged := map[string]json.RawMessage{}
+		if err := json.Unmarshal(curB, &merged); err != nil {
+			return invalid("settings")
+		}
+		for k, v := range b.Settings {
+			if !core.IsBundleSecretSetting(k) {
+				merged[k] = v
+			}
+		}
+
+		mergedB, err := json.Marshal(merged)
+		if err != nil {
+			return invalid("settings")
+		}
+		var set models.Settings
+		if err := json.Unmarshal(mergedB, &set); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "settings")+": "+err.Error())
+		}
+	}
+
+	return nil
+}
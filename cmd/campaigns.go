@@ -2,18 +2,27 @@ package main
 
 import (
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
+	"maps"
+	"math"
 	"net/http"
+	"net/textproto"
 	"net/url"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/blocks"
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/internal/messenger/email"
 	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
@@ -32,12 +41,24 @@ type campReq struct {
 	// to the outside world.
 	ListIDs []int `json:"lists"`
 
+	// IDs of the lists whose subscribers are excluded. This overrides
+	// Campaign.ExcludeLists like ListIDs. On updates, nil (not in the
+	// request) retains the existing excluded lists.
+	ExcludeListIDs []int `json:"exclude_lists"`
+
 	MediaIDs []int `json:"media"`
 
 	// This is only relevant to campaign test requests.
 	SubscriberEmails pq.StringArray `json:"subscribers"`
 }
 
+// campResendReq represents the params for resending a campaign to non-openers.
+type campResendReq struct {
+	Name    string    `json:"name"`
+	Subject string    `json:"subject"`
+	SendAt  null.Time `json:"send_at"`
+}
+
 // campContentReq wraps params coming from API requests for converting
 // campaign content formats.
 type campContentReq struct {
@@ -46,9 +67,112 @@ type campContentReq struct {
 	To   string `json:"to"`
 }
 
+const (
+	dryRunPass = "pass"
+	dryRunWarn = "warn"
+	dryRunFail = "fail"
+)
+
+const (
+	// overlapWindow is the default window around a campaign's send time in which
+	// other campaigns are checked for overlapping subscribers.
+	overlapWindow = time.Hour * 24
+
+	// overlapSampleSize is the maximum number of a campaign's subscriptions checked
+	// for overlaps. Beyond this, the overlap is estimated.
+	overlapSampleSize = 100000
+
+	// maxDynamicAttachments is the maximum number of dynamic attachments on a campaign.
+	maxDynamicAttachments = 10
+
+	// unsubCommentsLimit is the number of the latest unsubscribe comments
+	// returned with a campaign's unsubscribe reasons.
+	unsubCommentsLimit = 50
+
+	// unsubReasonsDays is the default number of days over which unsubscribe
+	// reasons are aggregated on the dashboard.
+	unsubReasonsDays = 30
+
+	// msgSizeWarnRatio is the fraction of a messenger's maximum message size beyond
+	// which a campaign's messages are warned about in a dry-run.
+	msgSizeWarnRatio = 0.8
+)
+
+// recipientsBatchSize is the number of ad-hoc campaign recipients
+// inserted at a time while importing a CSV.
+const recipientsBatchSize = 1000
+
+// campTagTransactional is the campaign tag that exempts a campaign from the
+// unsubscribe link check before it's started.
+const campTagTransactional = "transactional"
+
+const (
+	// domainStatsLimit is the default number of top domains in the domain report.
+	// The rest are grouped as 'other'.
+	domainStatsLimit    = 20
+	domainStatsMaxLimit = 100
+)
+
+// campOverlapWarning is a non-blocking warning about other campaigns targeting
+// the same subscribers around the time a campaign is scheduled or started.
+type campOverlapWarning struct {
+	Message   string                   `json:"message"`
+	Campaigns []models.CampaignOverlap `json:"campaigns"`
+}
+
+// dryRunCheck is the result of a single pre-flight check in a campaign dry-run.
+type dryRunCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	Data    any    `json:"data,omitempty"`
+}
+
+// dryRunReport is the result of all pre-flight checks of a campaign dry-run.
+// Status is the worst status among all the checks.
+type dryRunReport struct {
+	Status string        `json:"status"`
+	Checks []dryRunCheck `json:"checks"`
+}
+
+// add adds a check to the report and updates the report's overall status.
+func (r *dryRunReport) add(name, status, msg string, data any) {
+	r.Checks = append(r.Checks, dryRunCheck{Name: name, Status: status, Message: msg, Data: data})
+
+	switch {
+	case status == dryRunFail:
+		r.Status = dryRunFail
+	case status == dryRunWarn && r.Status != dryRunFail:
+		r.Status = dryRunWarn
+	case r.Status == "":
+		r.Status = dryRunPass
+	}
+}
+
+// calendarMaxWindow is the maximum time span that can be queried on the campaign calendar.
+const calendarMaxWindow = 366 * 24 * time.Hour
+
 var (
 	reFromAddress = regexp.MustCompile(`((.+?)\s)?<(.+?)@(.+?)>`)
 	reSlug        = regexp.MustCompile(`[^\p{L}\p{M}\p{N}]`)
+	reHeaderName  = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+\\-.^_`|~]+$")
+
+	// Core headers set by listmonk and the messengers that cannot be
+	// overridden by custom campaign headers.
+	reservedCampaignHeaders = map[string]bool{
+		"From":                           true,
+		"To":                             true,
+		"Subject":                        true,
+		"Date":                           true,
+		"Message-Id":                     true,
+		"Mime-Version":                   true,
+		"Content-Type":                   true,
+		"Content-Transfer-Encoding":      true,
+		"List-Unsubscribe":               true,
+		"List-Unsubscribe-Post":          true,
+		models.EmailHeaderCampaignUUID:   true,
+		models.EmailHeaderSubscriberUUID: true,
+	}
 )
 
 // GetCampaigns handles retrieval of campaigns.
@@ -70,16 +194,17 @@ func (a *App) GetCampaigns(c echo.Context) error {
 	var (
 		pg = a.pg.NewFromURL(c.Request().URL.Query())
 
-		status    = c.QueryParams()["status"]
-		tags      = c.QueryParams()["tag"]
-		query     = strings.TrimSpace(c.FormValue("query"))
-		orderBy   = c.FormValue("order_by")
-		order     = c.FormValue("order")
-		noBody, _ = strconv.ParseBool(c.QueryParam("no_body"))
+		status      = c.QueryParams()["status"]
+		tags        = getQueryTags(c.QueryParams())
+		tagMatchAny = c.QueryParam("tag_match") == "any"
+		query       = strings.TrimSpace(c.FormValue("query"))
+		orderBy     = c.FormValue("order_by")
+		order       = c.FormValue("order")
+		noBody, _   = strconv.ParseBool(c.QueryParam("no_body"))
 	)
 
 	// Query and retrieve campaigns from the DB.
-	res, total, err := a.core.QueryCampaigns(query, status, tags, orderBy, order, hasAllPerm, permittedLists, pg.Offset, pg.Limit)
+	res, total, err := a.reqCore(c).QueryCampaigns(query, status, tags, tagMatchAny, orderBy, order, hasAllPerm, permittedLists, pg.Offset, pg.Limit, skipTotal(c))
 	if err != nil {
 		return err
 	}
@@ -94,16 +219,10 @@ func (a *App) GetCampaigns(c echo.Context) error {
 
 	// Paginate the response.
 	if len(res) == 0 {
-		return c.JSON(http.StatusOK, okResp{models.PageResults{Results: []models.Campaign{}}})
-	}
-
-	out := models.PageResults{
-		Query:   query,
-		Results: res,
-		Total:   total,
-		Page:    pg.Page,
-		PerPage: pg.PerPage,
+		res = models.Campaigns{}
 	}
+	out := makePageResults(res, total, pg)
+	out.Query = query
 
 	return c.JSON(http.StatusOK, okResp{out})
 }
@@ -119,7 +238,7 @@ func (a *App) GetCampaign(c echo.Context) error {
 	}
 
 	// Get the campaign from the DB.
-	out, err := a.core.GetCampaign(id, "", "")
+	out, err := a.reqCore(c).GetCampaign(id, "", "")
 	if err != nil {
 		return err
 	}
@@ -154,7 +273,7 @@ func (a *App) PreviewCampaign(c echo.Context) error {
 	}
 
 	// Get the campaign from the DB for previewing with the `template_body` field.
-	camp, err := a.core.GetCampaignForPreview(id, tplID)
+	camp, err := a.reqCore(c).GetCampaignForPreview(id, tplID)
 	if err != nil {
 		return err
 	}
@@ -170,17 +289,28 @@ func (a *App) PreviewCampaign(c echo.Context) error {
 		}
 	}
 
+	// Preview for a real subscriber?
+	sub, isReal, err := a.getPreviewSubscriber(c)
+	if err != nil {
+		return err
+	}
+
 	// Use a dummy campaign ID to prevent views and clicks from {{ TrackView }}
-	// and {{ TrackLink }} being registered on preview.
-	camp.UUID = dummySubscriber.UUID
-	if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
+	// and {{ TrackLink }} being registered on preview. With a real subscriber,
+	// the links are disarmed instead.
+	funcs := a.manager.PreviewTemplateFuncs(&camp)
+	if !isReal {
+		camp.UUID = dummySubscriber.UUID
+		funcs = a.manager.TemplateFuncs(&camp)
+	}
+	if err := camp.CompileTemplate(funcs); err != nil {
 		a.log.Printf("error compiling template: %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest,
 			a.i18n.Ts("templates.errorCompiling", "error", err.Error()))
 	}
 
 	// Render the message body.
-	msg, err := a.manager.NewCampaignMessage(&camp, dummySubscriber)
+	msg, err := a.manager.NewCampaignMessage(&camp, sub)
 	if err != nil {
 		a.log.Printf("error rendering message: %v", err)
 		return echo.NewHTTPError(http.StatusBadRequest,
@@ -195,6 +325,36 @@ func (a *App) PreviewCampaign(c echo.Context) error {
 	return c.HTML(http.StatusOK, string(msg.Body()))
 }
 
+// getPreviewSubscriber returns the subscriber in the optional :subscriber_id
+// param for previewing messages with real data, checking that the user has
+// access to them. If there's no param, the dummy subscriber is returned with
+// isReal = false.
+func (a *App) getPreviewSubscriber(c echo.Context) (models.Subscriber, bool, error) {
+	if c.Param("subscriber_id") == "" {
+		return dummySubscriber, false, nil
+	}
+
+	id, _ := strconv.Atoi(c.Param("subscriber_id"))
+	if id < 1 {
+		return models.Subscriber{}, false, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidID"))
+	}
+
+	if err := a.hasSubPerm(auth.GetUser(c), []int{id}); err != nil {
+		return models.Subscriber{}, false, err
+	}
+
+	sub, err := a.core.GetSubscriber(id, "", "")
+	if err != nil {
+		return models.Subscriber{}, false, err
+	}
+
+	if isPIIRedacted(c) {
+		sub = redactSubscriber(sub)
+	}
+
+	return sub, true, nil
+}
+
 // PreviewCampaignArchive renders the public campaign archives page.
 func (a *App) PreviewCampaignArchive(c echo.Context) error {
 	// Get the campaign ID.
@@ -207,7 +367,7 @@ func (a *App) PreviewCampaignArchive(c echo.Context) error {
 
 	// Fetch the campaign body from the DB.
 	tplID, _ := strconv.Atoi(c.FormValue("template_id"))
-	camp, err := a.core.GetCampaignForPreview(id, tplID)
+	camp, err := a.reqCore(c).GetCampaignForPreview(id, tplID)
 	if err != nil {
 		return err
 	}
@@ -260,6 +420,7 @@ func (a *App) CreateCampaign(c echo.Context) error {
 	// Filter lists against the current user's permitted lists.
 	user := auth.GetUser(c)
 	o.ListIDs = user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, o.ListIDs)
+	o.ExcludeListIDs = user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, o.ExcludeListIDs)
 
 	// If the campaign's 'opt-in', prepare a default message.
 	switch o.Type {
@@ -283,12 +444,143 @@ func (a *App) CreateCampaign(c echo.Context) error {
 	} else {
 		o = c
 	}
+	if err := a.checkCampaignSender(o.FromEmail, user); err != nil {
+		return err
+	}
 
 	if o.ArchiveTemplateID.Valid && o.ArchiveTemplateID.Int != 0 {
 		o.ArchiveTemplateID = o.TemplateID
 	}
 
-	out, err := a.core.CreateCampaign(o.Campaign, o.ListIDs, o.MediaIDs)
+	// Resends are only linked via ResendCampaignToNonOpeners.
+	o.ParentID = null.Int{}
+
+	out, err := a.reqCore(c).CreateCampaign(o.Campaign, o.ListIDs, o.ExcludeListIDs, o.MediaIDs)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// ResendCampaignToNonOpeners creates a draft copy of a finished campaign with a new
+// subject that's linked to it. When the copy is run, it's only sent to the parent
+// campaign's recipients who haven't opened or clicked it, or bounced since.
+func (a *App) ResendCampaignToNonOpeners(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	var req campResendReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	parent, err := a.reqCore(c).GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	if parent.Status != models.CampaignStatusFinished || parent.Type != models.CampaignTypeRegular {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.resendOnlyFinished"))
+	}
+	if parent.TrackingMode == models.CampaignTrackingModeNone {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.resendNoTracking"))
+	}
+
+	req.Subject = strings.TrimSpace(req.Subject)
+	if req.Subject == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "subject"))
+	}
+	if req.Name == "" {
+		req.Name = a.i18n.Ts("campaigns.resendName", "name", parent.Name)
+	}
+
+	// Copy the parent's lists and attachments.
+	var (
+		lists []struct {
+			ID int `json:"id"`
+		}
+		media []struct {
+			ID int `json:"id"`
+		}
+	)
+	if err := parent.Lists.Unmarshal(&lists); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	if err := parent.Media.Unmarshal(&media); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	var excludeLists []struct {
+		ID int `json:"id"`
+	}
+	if len(parent.ExcludeLists) > 0 {
+		if err := parent.ExcludeLists.Unmarshal(&excludeLists); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	o := campReq{
+		Campaign: models.Campaign{
+			Type:               models.CampaignTypeRegular,
+			Name:               req.Name,
+			Subject:            req.Subject,
+			PreviewText:        parent.PreviewText,
+			DynamicAttachments: parent.DynamicAttachments,
+			ShortLinks:         parent.ShortLinks,
+			MaxRuntime:         parent.MaxRuntime,
+			FromEmail:          parent.FromEmail,
+			ReplyTo:            parent.ReplyTo,
+			Body:               parent.Body,
+			BodySource:         parent.BodySource,
+			AltBody:            parent.AltBody,
+			SendAt:             req.SendAt,
+			ContentType:        parent.ContentType,
+			Tags:               parent.Tags,
+			Headers:            parent.Headers,
+			TemplateID:         parent.TemplateID,
+			Messenger:          parent.Messenger,
+			TrackingMode:       parent.TrackingMode,
+			UTM:                parent.UTM,
+			ArchiveMeta:        json.RawMessage("{}"),
+			ProgressMilestones: parent.ProgressMilestones,
+			ParentID:           null.IntFrom(parent.ID),
+		},
+	}
+	for _, l := range lists {
+		// Deleted lists have the ID 0.
+		if l.ID > 0 {
+			o.ListIDs = append(o.ListIDs, l.ID)
+		}
+	}
+	for _, m := range media {
+		if m.ID > 0 {
+			o.MediaIDs = append(o.MediaIDs, m.ID)
+		}
+	}
+	for _, l := range excludeLists {
+		o.ExcludeListIDs = append(o.ExcludeListIDs, l.ID)
+	}
+
+	// Filter lists against the current user's permitted lists.
+	user := auth.GetUser(c)
+	o.ListIDs = user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, o.ListIDs)
+	o.ExcludeListIDs = user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, o.ExcludeListIDs)
+
+	if v, err := a.validateCampaignFields(o); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else {
+		o = v
+	}
+	if err := a.checkCampaignSender(o.FromEmail, user); err != nil {
+		return err
+	}
+
+	out, err := a.reqCore(c).CreateCampaign(o.Campaign, o.ListIDs, o.ExcludeListIDs, o.MediaIDs)
 	if err != nil {
 		return err
 	}
@@ -308,7 +600,7 @@ func (a *App) UpdateCampaign(c echo.Context) error {
 	}
 
 	// Retrieve the campaign from the DB.
-	cm, err := a.core.GetCampaign(id, "", "")
+	cm, err := a.reqCore(c).GetCampaign(id, "", "")
 	if err != nil {
 		return err
 	}
@@ -330,15 +622,52 @@ func (a *App) UpdateCampaign(c echo.Context) error {
 	} else {
 		o = c
 	}
+	if err := a.checkCampaignSender(o.FromEmail, auth.GetUser(c)); err != nil {
+		return err
+	}
 
-	out, err := a.core.UpdateCampaign(id, o.Campaign, o.ListIDs, o.MediaIDs)
+	out, err := a.reqCore(c).UpdateCampaign(id, o.Campaign, o.ListIDs, o.ExcludeListIDs, o.MediaIDs)
 	if err != nil {
 		return err
 	}
+	a.archiveSitemap.invalidate()
+
+	if ok, err := a.reviewEditedCampaign(c, cm, o.Campaign); err != nil {
+		return err
+	} else if ok {
+		if out, err = a.reqCore(c).GetCampaign(id, "", ""); err != nil {
+			return err
+		}
+	}
 
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// reviewEditedCampaign revokes the approval of an approved campaign whose content
+// has been edited, unless the user can approve campaigns, in which case, the new
+// content is approved. It returns true if the approval was updated.
+func (a *App) reviewEditedCampaign(c echo.Context, old, edited models.Campaign) (bool, error) {
+	if !a.cfg.RequireCampaignApproval || !old.ApprovedAt.Valid || !hasCampaignContentChanged(old, edited) {
+		return false, nil
+	}
+
+	user := auth.GetUser(c)
+
+	approverID := 0
+	if user.HasPerm(auth.PermCampaignsApprove) {
+		approverID = user.ID
+	}
+	if err := a.reqCore(c).SetCampaignApproval(old.ID, approverID); err != nil {
+		return false, err
+	}
+
+	if approverID == 0 {
+		go a.notifyCampaignApprovers(edited, user)
+	}
+
+	return true, nil
+}
+
 // UpdateCampaignStatus handles campaign status modification.
 func (a *App) UpdateCampaignStatus(c echo.Context) error {
 	// Get the campaign ID.
@@ -351,162 +680,851 @@ func (a *App) UpdateCampaignStatus(c echo.Context) error {
 
 	req := struct {
 		Status string `json:"status"`
+
+		// Skips the unsubscribe link check. Only allowed for super admins.
+		OverrideUnsubCheck bool `json:"override_unsub_check"`
+
+		// Sends the campaign through the entire pipeline without delivering messages.
+		Simulate bool `json:"simulate"`
+
+		// Adds a warning about other campaigns targeting the same subscribers
+		// around the same time to the response.
+		CheckOverlap bool `json:"check_overlap"`
 	}{}
 	if err := c.Bind(&req); err != nil {
 		return err
 	}
 
+	// If approvals are required, campaigns should be approved before they're scheduled
+	// or started, unless the user can approve campaigns.
+	user := auth.GetUser(c)
+	switch req.Status {
+	case models.CampaignStatusPendingApproval:
+		if !a.cfg.RequireCampaignApproval {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.approvalNotRequired"))
+		}
+	case models.CampaignStatusScheduled, models.CampaignStatusRunning:
+		if err := a.checkCampaignApproval(id, user); err != nil {
+			return err
+		}
+	}
+
+	// Campaigns should have an unsubscribe link before they're scheduled or started.
+	if req.Status == models.CampaignStatusScheduled || req.Status == models.CampaignStatusRunning {
+		if req.OverrideUnsubCheck {
+			if user.UserRoleID != auth.SuperAdminRoleID {
+				return echo.NewHTTPError(http.StatusForbidden,
+					a.i18n.Ts("globals.messages.permissionDenied", "name", "override_unsub_check"))
+			}
+		} else if err := a.checkCampaignUnsubLink(id); err != nil {
+			return err
+		}
+	}
+
+	// If a seed send is required, the campaign should have been sent to the seed list
+	// before it's scheduled or started for the first time.
+	if a.cfg.RequireSeedSend && (req.Status == models.CampaignStatusScheduled || req.Status == models.CampaignStatusRunning) {
+		if err := a.checkCampaignSeedSend(id); err != nil {
+			return err
+		}
+	}
+
+	// Set the simulation mode before the campaign is scheduled or started. It has no
+	// effect on paused campaigns which resume in the mode they were started in.
+	if req.Status == models.CampaignStatusScheduled || req.Status == models.CampaignStatusRunning {
+		if err := a.reqCore(c).SetCampaignSimulation(id, req.Simulate); err != nil {
+			return err
+		}
+	}
+
 	// Update the campaign status in the DB.
-	out, err := a.core.UpdateCampaignStatus(id, req.Status)
+	out, err := a.reqCore(c).UpdateCampaignStatus(id, req.Status)
 	if err != nil {
 		return err
 	}
+	a.archiveSitemap.invalidate()
+
+	if a.cfg.RequireCampaignApproval {
+		switch req.Status {
+		case models.CampaignStatusPendingApproval:
+			go a.notifyCampaignApprovers(out, user)
+
+		case models.CampaignStatusScheduled, models.CampaignStatusRunning:
+			// Approvers implicitly approve the campaigns they schedule or start so
+			// that the scheduler picks them up.
+			if !out.ApprovedAt.Valid {
+				if err := a.reqCore(c).SetCampaignApproval(id, user.ID); err != nil {
+					return err
+				}
+			}
+		}
+	}
 
 	// If the campaign is being stopped, send the signal to the manager to stop it in flight.
 	if req.Status == models.CampaignStatusPaused || req.Status == models.CampaignStatusCancelled {
 		a.manager.StopCampaign(id)
 	}
 
-	return c.JSON(http.StatusOK, okResp{out})
+	if !req.CheckOverlap {
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	// If the campaign is being scheduled or started, warn about other campaigns
+	// targeting the same subscribers around the same time. This doesn't block the change.
+	var warn *campOverlapWarning
+	if req.Status == models.CampaignStatusScheduled || req.Status == models.CampaignStatusRunning {
+		warn = a.getCampaignOverlapWarning(id)
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		models.Campaign
+		Warning *campOverlapWarning `json:"warning,omitempty"`
+	}{out, warn}})
 }
 
-// UpdateCampaignArchive handles campaign status modification.
-func (a *App) UpdateCampaignArchive(c echo.Context) error {
+// ApproveCampaign approves a campaign that's pending approval so that it can be
+// scheduled or started.
+func (a *App) ApproveCampaign(c echo.Context) error {
+	return a.reviewCampaign(c, true)
+}
+
+// RejectCampaign rejects a campaign that's pending approval with a comment and
+// moves it back to draft.
+func (a *App) RejectCampaign(c echo.Context) error {
+	return a.reviewCampaign(c, false)
+}
+
+// reviewCampaign approves or rejects a campaign that's pending approval.
+func (a *App) reviewCampaign(c echo.Context, approve bool) error {
+	// Get the campaign ID.
 	id := getID(c)
 
 	// Check if the user has access to the campaign.
-	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
 		return err
 	}
 
-	req := struct {
-		Archive     bool        `json:"archive"`
-		TemplateID  int         `json:"archive_template_id"`
-		Meta        models.JSON `json:"archive_meta"`
-		ArchiveSlug string      `json:"archive_slug"`
-	}{}
+	var req struct {
+		Comment string `json:"comment"`
+	}
 	if err := c.Bind(&req); err != nil {
 		return err
 	}
 
-	if req.ArchiveSlug != "" {
-		// Format the slug to be alpha-numeric-dash.
-		s := strings.ToLower(req.ArchiveSlug)
-		s = strings.TrimSpace(reSlug.ReplaceAllString(s, " "))
-		s = regexpSpaces.ReplaceAllString(s, "-")
-		req.ArchiveSlug = s
+	// A comment is required for rejections.
+	req.Comment = strings.TrimSpace(req.Comment)
+	if len(req.Comment) > stdInputMaxLen || (!approve && req.Comment == "") {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "comment"))
+	}
+
+	if approve {
+		if err := a.core.ApproveCampaign(id, auth.GetUser(c).ID, req.Comment); err != nil {
+			return err
+		}
+	} else {
+		if err := a.core.RejectCampaign(id, req.Comment); err != nil {
+			return err
+		}
 	}
 
-	if err := a.core.UpdateCampaignArchive(id, req.Archive, req.TemplateID, req.Meta, req.ArchiveSlug); err != nil {
+	out, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
 		return err
 	}
 
-	return c.JSON(http.StatusOK, okResp{req})
+	return c.JSON(http.StatusOK, okResp{out})
 }
 
-// DeleteCampaign handles campaign deletion.
-// Only scheduled campaigns that have not started yet can be deleted.
-func (a *App) DeleteCampaign(c echo.Context) error {
-	// Get the campaign ID.
-	id := getID(c)
+// checkCampaignApproval returns an error if approvals are required and the campaign
+// isn't approved, unless the user can approve campaigns.
+func (a *App) checkCampaignApproval(id int, user auth.User) error {
+	if !a.cfg.RequireCampaignApproval || user.HasPerm(auth.PermCampaignsApprove) {
+		return nil
+	}
 
-	// Check if the user has access to the campaign.
-	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
 		return err
 	}
 
-	// Delete the campaign from the DB.
-	if err := a.core.DeleteCampaign(id); err != nil {
-		return err
+	if !camp.ApprovedAt.Valid {
+		return echo.NewHTTPError(http.StatusForbidden, a.i18n.T("campaigns.needsApproval"))
 	}
 
-	return c.JSON(http.StatusOK, okResp{true})
+	return nil
 }
 
-// DeleteCampaigns deletes multiple campaigns by IDs or by query.
-func (a *App) DeleteCampaigns(c echo.Context) error {
-	// Get the authenticated user.
-	user := auth.GetUser(c)
+// notifyCampaignApprovers e-mails the users who can approve campaigns about
+// a campaign that's pending approval.
+func (a *App) notifyCampaignApprovers(camp models.Campaign, user auth.User) {
+	emails, err := a.core.GetCampaignApprovers()
+	if err != nil || len(emails) == 0 {
+		return
+	}
 
-	var (
-		hasAllPerm     = user.HasPerm(auth.PermCampaignsManageAll)
-		permittedLists []int
-	)
+	data := struct {
+		ID          int
+		Name        string
+		Subject     string
+		RequestedBy string
+	}{camp.ID, camp.Name, camp.Subject, user.Name}
 
-	if !hasAllPerm {
-		// Either the user has campaigns:manage_all permissions and can manage all campaigns,
-		// or the campaigns are filtered by the lists the user has get|manage access to.
-		hasAllPerm, permittedLists = user.GetPermittedLists(auth.PermTypeGet | auth.PermTypeManage)
+	if err := notifs.Notify(emails, a.i18n.Ts("email.approval.title")+": "+camp.Name, notifs.TplCampaignApproval, data, nil); err != nil {
+		a.log.Printf("error sending campaign approval notification: %v", err)
 	}
+}
 
-	var (
-		ids   []int
-		query string
-	)
+// hasCampaignContentChanged checks whether the content of a campaign that
+// goes out to subscribers has changed between two versions.
+func hasCampaignContentChanged(a, b models.Campaign) bool {
+	return a.Subject != b.Subject ||
+		a.PreviewText != b.PreviewText ||
+		!slices.Equal(a.DynamicAttachments, b.DynamicAttachments) ||
+		a.FromEmail != b.FromEmail ||
+		a.ReplyTo != b.ReplyTo ||
+		a.Body != b.Body ||
+		a.AltBody != b.AltBody ||
+		a.ContentType != b.ContentType ||
+		a.TemplateID != b.TemplateID ||
+		!slices.EqualFunc(a.Headers, b.Headers, maps.Equal)
+}
 
-	// Check for IDs in query params.
-	if len(c.Request().URL.Query()["id"]) > 0 {
-		var err error
-		ids, err = parseStringIDs(c.Request().URL.Query()["id"])
-		if err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest,
-				a.i18n.Ts("globals.messages.errorInvalidIDs", "error", err.Error()))
-		}
-	} else {
-		// Check for query param.
-		query = strings.TrimSpace(c.FormValue("query"))
+// checkCampaignUnsubLink renders a campaign for the dummy subscriber and returns
+// an error if the message doesn't contain the unsubscribe link. Campaigns tagged
+// as transactional are exempt.
+func (a *App) checkCampaignUnsubLink(id int) error {
+	camp, err := a.core.GetCampaignForPreview(id, 0)
+	if err != nil {
+		return err
 	}
 
-	// Validate that either IDs or query is provided.
-	if len(ids) == 0 && query == "" {
-		return echo.NewHTTPError(http.StatusBadRequest,
-			a.i18n.Ts("globals.messages.errorInvalidIDs", "error", "id or query required"))
+	if slices.Contains(camp.Tags, campTagTransactional) {
+		return nil
 	}
 
-	// Delete the campaigns from the DB.
-	if err := a.core.DeleteCampaigns(ids, query, hasAllPerm, permittedLists); err != nil {
-		return err
+	// Use a dummy campaign UUID to prevent views and clicks from being registered.
+	camp.UUID = dummySubscriber.UUID
+	if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("templates.errorCompiling", "error", err.Error()))
 	}
 
-	return c.JSON(http.StatusOK, okResp{true})
-}
-
-// GetRunningCampaignStats returns stats of a given set of campaign IDs.
-func (a *App) GetRunningCampaignStats(c echo.Context) error {
-	// Get the running campaign stats from the DB.
-	out, err := a.core.GetRunningCampaignStats()
+	msg, err := a.manager.NewCampaignMessage(&camp, dummySubscriber)
 	if err != nil {
-		return err
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("templates.errorRendering", "error", err.Error()))
 	}
 
-	if len(out) == 0 {
-		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	if !hasUnsubLink(msg) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.noUnsubLink"))
 	}
 
-	// Compute rate.
-	for i, c := range out {
+	return nil
+}
+
+// hasUnsubLink checks whether a rendered campaign message's body or alt body
+// contains its unsubscribe URL. This works regardless of how the link is
+// generated, for instance, in a template partial.
+func hasUnsubLink(msg manager.CampaignMessage) bool {
+	u := []byte(msg.UnsubscribeURL())
+	return bytes.Contains(msg.Body(), u) || bytes.Contains(msg.AltBody(), u)
+}
+
+// checkCampaignSeedSend returns an error if a draft or scheduled campaign hasn't been
+// successfully sent to the seed list.
+func (a *App) checkCampaignSeedSend(id int) error {
+	camp, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	if camp.Status != models.CampaignStatusDraft && camp.Status != models.CampaignStatusScheduled {
+		return nil
+	}
+
+	var seed models.CampaignSeedSend
+	if len(camp.SeedSend) > 0 {
+		if err := json.Unmarshal(camp.SeedSend, &seed); err != nil {
+			a.log.Printf("error unmarshalling campaign seed send: %v", err)
+		}
+	}
+	if !seed.HasSent() {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.seedSendRequired"))
+	}
+
+	return nil
+}
+
+// getCampaignOverlapWarning returns a warning if other campaigns scheduled or running
+// within the default window of the campaign target any of its subscribers. Errors are
+// only logged as the warning is advisory.
+func (a *App) getCampaignOverlapWarning(id int) *campOverlapWarning {
+	overlaps, err := a.core.GetCampaignOverlap(id, overlapWindow, overlapSampleSize)
+	if err != nil {
+		a.log.Printf("error checking campaign overlap: %v", err)
+		return nil
+	}
+
+	out := make([]models.CampaignOverlap, 0, len(overlaps))
+	for _, o := range overlaps {
+		if o.Overlap > 0 {
+			out = append(out, o)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+
+	return &campOverlapWarning{
+		Message: a.i18n.Ts("campaigns.overlapWarning",
+			"num", strconv.Itoa(len(out)), "window", overlapWindow.String()),
+		Campaigns: out,
+	}
+}
+
+// UpdateCampaignArchive handles campaign status modification.
+func (a *App) UpdateCampaignArchive(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	req := struct {
+		Archive         bool        `json:"archive"`
+		TemplateID      int         `json:"archive_template_id"`
+		Meta            models.JSON `json:"archive_meta"`
+		ArchiveSlug     string      `json:"archive_slug"`
+		ArchiveAccess   string      `json:"archive_access"`
+		ArchivePassword string      `json:"archive_password,omitempty"`
+	}{}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	switch req.ArchiveAccess {
+	case "":
+		req.ArchiveAccess = models.CampaignArchiveAccessPublic
+	case models.CampaignArchiveAccessPublic, models.CampaignArchiveAccessUnlisted:
+	case models.CampaignArchiveAccessPassword:
+		if req.ArchivePassword != "" {
+			if !strHasLen(req.ArchivePassword, 8, stdInputMaxLen) {
+				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "archive_password"))
+			}
+		} else {
+			// Without a new password, there should be an existing one.
+			camp, err := a.reqCore(c).GetCampaign(id, "", "")
+			if err != nil {
+				return err
+			}
+			if !camp.ArchivePassword.Valid {
+				return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.missingFields", "name", "archive_password"))
+			}
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "archive_access"))
+	}
+
+	if req.ArchiveSlug != "" {
+		// Format the slug to be alpha-numeric-dash.
+		s := strings.ToLower(req.ArchiveSlug)
+		s = strings.TrimSpace(reSlug.ReplaceAllString(s, " "))
+		s = regexpSpaces.ReplaceAllString(s, "-")
+		req.ArchiveSlug = s
+	}
+
+	if err := a.reqCore(c).UpdateCampaignArchive(id, req.Archive, req.TemplateID, req.Meta, req.ArchiveSlug, req.ArchiveAccess, req.ArchivePassword); err != nil {
+		return err
+	}
+	a.archiveSitemap.invalidate()
+
+	// Don't echo the password back.
+	req.ArchivePassword = ""
+
+	return c.JSON(http.StatusOK, okResp{req})
+}
+
+// GetCampaignArchiveLink returns the public archive URL of a campaign. For
+// unlisted campaigns, the URL is signed and optionally expires in ?days=.
+func (a *App) GetCampaignArchiveLink(c echo.Context) error {
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	days, _ := strconv.Atoi(c.QueryParam("days"))
+	if days < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "days"))
+	}
+
+	camp, err := a.reqCore(c).GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if !camp.Archive {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "archive"))
+	}
+
+	out := struct {
+		URL       string    `json:"url"`
+		ExpiresAt null.Time `json:"expires_at"`
+	}{}
+
+	out.URL = a.archiveCampURL(camp)
+
+	if camp.ArchiveAccess == models.CampaignArchiveAccessUnlisted {
+		var exp int64
+		if days > 0 {
+			t := time.Now().AddDate(0, 0, days)
+			exp = t.Unix()
+			out.ExpiresAt = null.TimeFrom(t)
+		}
+		out.URL = a.signURL(out.URL, archiveSignMsg(camp.UUID), exp)
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteCampaign handles campaign deletion.
+// Only scheduled campaigns that have not started yet can be deleted.
+func (a *App) DeleteCampaign(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	// Delete the campaign from the DB.
+	if err := a.reqCore(c).DeleteCampaign(id); err != nil {
+		return err
+	}
+	a.archiveSitemap.invalidate()
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// DeleteCampaigns deletes multiple campaigns by IDs or by query.
+func (a *App) DeleteCampaigns(c echo.Context) error {
+	// Get the authenticated user.
+	user := auth.GetUser(c)
+
+	var (
+		hasAllPerm     = user.HasPerm(auth.PermCampaignsManageAll)
+		permittedLists []int
+	)
+
+	if !hasAllPerm {
+		// Either the user has campaigns:manage_all permissions and can manage all campaigns,
+		// or the campaigns are filtered by the lists the user has get|manage access to.
+		hasAllPerm, permittedLists = user.GetPermittedLists(auth.PermTypeGet | auth.PermTypeManage)
+	}
+
+	var (
+		ids   []int
+		query string
+	)
+
+	// Check for IDs in query params.
+	if len(c.Request().URL.Query()["id"]) > 0 {
+		var err error
+		ids, err = parseStringIDs(c.Request().URL.Query()["id"])
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.errorInvalidIDs", "error", err.Error()))
+		}
+	} else {
+		// Check for query param.
+		query = strings.TrimSpace(c.FormValue("query"))
+	}
+
+	// Validate that either IDs or query is provided.
+	if len(ids) == 0 && query == "" {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.errorInvalidIDs", "error", "id or query required"))
+	}
+
+	// Delete the campaigns from the DB.
+	if err := a.reqCore(c).DeleteCampaigns(ids, query, hasAllPerm, permittedLists); err != nil {
+		return err
+	}
+	a.archiveSitemap.invalidate()
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// GetRunningCampaignStats returns stats of a given set of campaign IDs.
+func (a *App) GetRunningCampaignStats(c echo.Context) error {
+	// Get the running campaign stats from the DB.
+	out, err := a.reqCore(c).GetRunningCampaignStats()
+	if err != nil {
+		return err
+	}
+
+	if len(out) == 0 {
+		return c.JSON(http.StatusOK, okResp{[]struct{}{}})
+	}
+
+	// Compute rate.
+	for i, c := range out {
+		stats := a.manager.GetCampaignStats(c.ID)
+		out[i].Routes = stats.Routes
+		out[i].AttachmentErrors = stats.AttachmentErrors
+		if stats.MaxRuntime > 0 {
+			out[i].RuntimeRemaining = null.IntFrom(int(stats.RuntimeRemaining.Seconds()))
+		}
+
+		// Live bounce counts from the bounce processor that may be ahead of the DB.
+		if a.bounce != nil {
+			if b, ok := a.bounce.LiveCounts(c.UUID); ok {
+				out[i].LiveBounces = &b
+				out[i].Bounces = max(out[i].Bounces, b.Total)
+			}
+		}
+		out[i].Delivered, out[i].OpenRate, out[i].ClickRate = models.CalcRates(c.Sent, out[i].Bounces, c.UniqueViews, c.UniqueClicks)
+		if c.Sent > 0 {
+			out[i].BounceRate = math.Round(float64(out[i].Bounces)/float64(c.Sent)*10000) / 100
+		}
+
 		if c.Started.Valid && c.UpdatedAt.Valid {
 			diff := max(int(c.UpdatedAt.Time.Sub(c.Started.Time).Minutes()), 1)
 
-			rate := c.Sent / diff
-			if rate > c.Sent || rate > c.ToSend {
-				rate = c.Sent
-			}
+			rate := c.Sent / diff
+			if rate > c.Sent || rate > c.ToSend {
+				rate = c.Sent
+			}
+
+			// Rate since the starting of the campaign.
+			out[i].NetRate = rate
+
+			// Realtime running rate over the last minute.
+			out[i].Rate = stats.SendRate
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// TestCampaign handles the sending of a campaign message to
+// arbitrary subscribers for testing.
+func (a *App) TestCampaign(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	// Get and validate fields.
+	var req campReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	// Validate.
+	if c, err := a.validateCampaignFields(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else {
+		req = c
+	}
+	if len(req.SubscriberEmails) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.noSubsToTest"))
+	}
+
+	// Sanitize subscriber e-mails.
+	for i := range req.SubscriberEmails {
+		req.SubscriberEmails[i] = strings.ToLower(strings.TrimSpace(req.SubscriberEmails[i]))
+	}
+
+	// Get the subscribers from the DB by their e-mails.
+	subs, err := a.reqCore(c).GetSubscribersByEmail(req.SubscriberEmails)
+	if err != nil {
+		return err
+	}
+
+	// Get the campaign from the DB for previewing.
+	tplID, _ := strconv.Atoi(c.FormValue("template_id"))
+	camp, err := a.reqCore(c).GetCampaignForPreview(id, tplID)
+	if err != nil {
+		return err
+	}
+
+	// Override certain values from the DB with incoming values.
+	camp.Name = req.Name
+	camp.Subject = req.Subject
+	camp.PreviewText = req.PreviewText
+	camp.DynamicAttachments = req.DynamicAttachments
+	camp.ShortLinks = req.ShortLinks
+	camp.FromEmail = req.FromEmail
+	camp.ReplyTo = req.ReplyTo
+	camp.Body = req.Body
+	camp.AltBody = req.AltBody
+	camp.Messenger = req.Messenger
+	camp.TrackingMode = req.TrackingMode
+	camp.UTM = req.UTM
+	camp.ContentType = req.ContentType
+	camp.Headers = req.Headers
+	camp.TemplateID = req.TemplateID
+	for _, id := range req.MediaIDs {
+		if id > 0 {
+			camp.MediaIDs = append(camp.MediaIDs, int64(id))
+		}
+	}
+
+	// Send the test messages.
+	for _, s := range subs {
+		sub := s
+
+		if err := a.sendTestMessage(sub, &camp); err != nil {
+			a.log.Printf("error sending test message: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				a.i18n.Ts("campaigns.errorSendTest", "error", err.Error()))
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// SeedSendCampaign sends a campaign to the addresses on the seed list for checking
+// inbox placement before the real send. Seed messages carry the seed header, aren't
+// counted in the campaign's stats, and the per-address results are saved on the campaign.
+func (a *App) SeedSendCampaign(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	if len(a.cfg.SeedEmails) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.noSeedEmails"))
+	}
+
+	camp, err := a.reqCore(c).GetCampaignForPreview(id, 0)
+	if err != nil {
+		return err
+	}
+
+	if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
+		a.log.Printf("error compiling template: %v", err)
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("templates.errorCompiling", "error", err.Error()))
+	}
+
+	out := models.CampaignSeedSend{
+		SentAt:  time.Now(),
+		Results: make([]models.SeedSendResult, 0, len(a.cfg.SeedEmails)),
+	}
+	for _, email := range a.cfg.SeedEmails {
+		// Seeds are ephemeral subscribers with the nil UUID that's excluded from tracking.
+		sub := models.Subscriber{
+			UUID:    models.SeedSubscriberUUID,
+			Email:   email,
+			Name:    email,
+			Status:  models.SubscriberStatusEnabled,
+			Attribs: models.JSON{},
+		}
+
+		res := models.SeedSendResult{Email: email, Status: models.SeedStatusSent}
+
+		msg, err := a.manager.NewCampaignMessage(&camp, sub)
+		if err == nil {
+			err = a.manager.SendSeedMessage(msg)
+		}
+		if err != nil {
+			a.log.Printf("error sending seed message (%s) to %s: %v", camp.Name, email, err)
+			res.Status = models.SeedStatusFailed
+			res.Error = err.Error()
+		}
+
+		out.Results = append(out.Results, res)
+	}
+
+	if err := a.reqCore(c).UpdateCampaignSeedSend(id, out); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UploadCampaignRecipients imports the recipients of an ad-hoc campaign from an
+// uploaded CSV file without creating subscribers. The CSV should have a header row
+// with an `email` column and optional `name` and `attributes` (JSON) columns. The
+// file is streamed and inserted in batches. Invalid and domain-blocklisted e-mails
+// are skipped. Blocklisted subscribers are skipped at the time of sending.
+func (a *App) UploadCampaignRecipients(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	camp, err := a.reqCore(c).GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if camp.Type != models.CampaignTypeAdhoc {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.notAdhoc"))
+	}
+	if !canEditCampaign(camp.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdate"))
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "file"))
+	}
+
+	f, err := file.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "file"))
+	}
+	defer f.Close()
+
+	rd := csv.NewReader(f)
+	rd.FieldsPerRecord = -1
+	rd.ReuseRecord = true
+
+	// Map the header columns.
+	hdr, err := rd.Read()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.invalidRecipientsCSV"))
+	}
+	cols := map[string]int{}
+	for n, h := range hdr {
+		cols[strings.ToLower(strings.TrimSpace(h))] = n
+	}
+	if _, ok := cols["email"]; !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.invalidRecipientsCSV"))
+	}
+
+	var (
+		out = struct {
+			Imported int `json:"imported"`
+			Skipped  int `json:"skipped"`
+			Total    int `json:"total"`
+		}{}
+
+		emails  = make([]string, 0, recipientsBatchSize)
+		names   = make([]string, 0, recipientsBatchSize)
+		attribs = make([]string, 0, recipientsBatchSize)
+	)
+
+	// Insert the batch and reset the buffers.
+	flush := func() error {
+		if len(emails) == 0 {
+			return nil
+		}
+
+		n, err := a.reqCore(c).InsertCampaignRecipients(id, emails, names, attribs)
+		if err != nil {
+			return err
+		}
+		out.Imported += n
+		out.Skipped += len(emails) - n
+
+		emails, names, attribs = emails[:0], names[:0], attribs[:0]
+		return nil
+	}
+
+	col := func(row []string, name string) string {
+		if n, ok := cols[name]; ok && n < len(row) {
+			return strings.TrimSpace(row[n])
+		}
+		return ""
+	}
+
+	for {
+		row, err := rd.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.T("campaigns.invalidRecipientsCSV")+": "+err.Error())
+		}
+
+		em, err := a.importer.SanitizeEmail(col(row, "email"))
+		if err != nil {
+			out.Skipped++
+			continue
+		}
+
+		attr := "{}"
+		if v := col(row, "attributes"); v != "" {
+			var m map[string]any
+			if err := json.Unmarshal([]byte(v), &m); err != nil {
+				out.Skipped++
+				continue
+			}
+			attr = v
+		}
+
+		// If there's no name, use the name part of the e-mail.
+		name := col(row, "name")
+		if name == "" {
+			name = strings.Split(em, "@")[0]
+		}
+
+		emails = append(emails, em)
+		names = append(names, name)
+		attribs = append(attribs, attr)
+
+		if len(emails) >= recipientsBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	total, err := a.reqCore(c).CountCampaignRecipients(id)
+	if err != nil {
+		return err
+	}
+	out.Total = total
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteCampaignRecipients deletes all the uploaded recipients of an ad-hoc campaign.
+func (a *App) DeleteCampaignRecipients(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
 
-			// Rate since the starting of the campaign.
-			out[i].NetRate = rate
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
 
-			// Realtime running rate over the last minute.
-			out[i].Rate = a.manager.GetCampaignStats(c.ID).SendRate
-		}
+	camp, err := a.reqCore(c).GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+	if !canEditCampaign(camp.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdate"))
 	}
 
-	return c.JSON(http.StatusOK, okResp{out})
+	if err := a.reqCore(c).DeleteCampaignRecipients(id); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
 }
 
-// TestCampaign handles the sending of a campaign message to
-// arbitrary subscribers for testing.
-func (a *App) TestCampaign(c echo.Context) error {
+// DryRunCampaign runs the pre-flight checks for starting a campaign and returns a report
+// of what would be sent without creating a pipe or touching the campaign's status.
+func (a *App) DryRunCampaign(c echo.Context) error {
 	// Get the campaign ID.
 	id := getID(c)
 
@@ -515,68 +1533,240 @@ func (a *App) TestCampaign(c echo.Context) error {
 		return err
 	}
 
-	// Get and validate fields.
-	var req campReq
-	if err := c.Bind(&req); err != nil {
+	camp, err := a.reqCore(c).GetCampaignForPreview(id, 0)
+	if err != nil {
 		return err
 	}
 
-	// Validate.
-	if c, err := a.validateCampaignFields(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	out := dryRunReport{Checks: make([]dryRunCheck, 0, 5)}
+
+	// Status.
+	switch camp.Status {
+	case models.CampaignStatusDraft, models.CampaignStatusPaused:
+		out.add("status", dryRunPass, "", nil)
+	case models.CampaignStatusScheduled:
+		if camp.SendAt.Valid && camp.SendAt.Time.Before(time.Now()) {
+			out.add("status", dryRunWarn, a.i18n.T("campaigns.dryRunSendAtPast"), nil)
+		} else {
+			out.add("status", dryRunPass, "", nil)
+		}
+	default:
+		out.add("status", dryRunFail, a.i18n.T("campaigns.onlyPausedDraft"), nil)
+	}
+
+	// Eligible recipients and exclusions per list. Ad-hoc campaigns
+	// are sent to their uploaded recipients.
+	counts, total, err := a.reqCore(c).GetCampaignListCounts(id)
+	if err != nil {
+		return err
+	}
+	if camp.Type == models.CampaignTypeAdhoc {
+		if total, err = a.reqCore(c).CountCampaignRecipients(id); err != nil {
+			return err
+		}
+	}
+	if total == 0 {
+		out.add("recipients", dryRunFail, a.i18n.T("campaigns.noSubsToTest"), counts)
 	} else {
-		req = c
+		out.add("recipients", dryRunPass, "", counts)
 	}
-	if len(req.SubscriberEmails) == 0 {
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.noSubsToTest"))
+
+	excluded := 0
+	for _, l := range counts {
+		excluded += l.Unsubscribed + l.Blocklisted
+	}
+	if excluded > 0 {
+		out.add("exclusions", dryRunWarn, a.i18n.Ts("campaigns.dryRunExcluded", "num", strconv.Itoa(excluded)), excluded)
+	} else {
+		out.add("exclusions", dryRunPass, "", excluded)
 	}
 
-	// Sanitize subscriber e-mails.
-	for i := range req.SubscriberEmails {
-		req.SubscriberEmails[i] = strings.ToLower(strings.TrimSpace(req.SubscriberEmails[i]))
+	// Messenger.
+	a.checkMessengerHealth(&out, camp.Messenger)
+
+	// Template. Compile and render the message for a dummy subscriber.
+	// Use a dummy campaign UUID to prevent views and clicks from being registered.
+	camp.UUID = dummySubscriber.UUID
+	if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
+		out.add("template", dryRunFail, a.i18n.Ts("templates.errorCompiling", "error", err.Error()), nil)
+	} else if msg, err := a.manager.NewCampaignMessage(&camp, dummySubscriber); err != nil {
+		out.add("template", dryRunFail, a.i18n.Ts("templates.errorRendering", "error", err.Error()), nil)
+	} else {
+		out.add("template", dryRunPass, "", nil)
+
+		// Unsubscribe link and header.
+		switch {
+		case slices.Contains(camp.Tags, campTagTransactional):
+			out.add("unsubscribe", dryRunPass, "", nil)
+		case !hasUnsubLink(msg):
+			out.add("unsubscribe", dryRunFail, a.i18n.T("campaigns.noUnsubLink"), nil)
+		case msg.Headers().Get("List-Unsubscribe") == "":
+			out.add("unsubscribe", dryRunWarn, a.i18n.T("campaigns.noUnsubHeader"), nil)
+		default:
+			out.add("unsubscribe", dryRunPass, "", nil)
+		}
+
+		// Message size with attachments against the smallest size limit of the messenger.
+		if sz, ok, err := a.manager.EstimateMessageSize(msg); err != nil {
+			out.add("size", dryRunWarn, a.i18n.Ts("campaigns.dryRunErrorSize", "error", err.Error()), nil)
+		} else if ok {
+			var (
+				size  = formatMB(sz.Size)
+				limit = formatMB(sz.Limit)
+			)
+			switch {
+			case sz.Size > sz.Limit:
+				out.add("size", dryRunFail, a.i18n.Ts("campaigns.dryRunMessageTooLarge", "size", size, "limit", limit), sz)
+			case float64(sz.Size+sz.MaxDynamicAttachments) >= float64(sz.Limit)*msgSizeWarnRatio:
+				out.add("size", dryRunWarn, a.i18n.Ts("campaigns.dryRunMessageNearLimit", "size", size, "limit", limit), sz)
+			default:
+				out.add("size", dryRunPass, "", sz)
+			}
+		}
 	}
 
-	// Get the subscribers from the DB by their e-mails.
-	subs, err := a.core.GetSubscribersByEmail(req.SubscriberEmails)
+	// Projected time to send the campaign at the configured rates.
+	out.add("eta", dryRunPass, "", map[string]any{
+		"recipients": total,
+		"seconds":    int(a.manager.EstimateDuration(total).Seconds()),
+	})
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// checkMessengerHealth adds the dry-run check of a campaign's messenger. E-mail messengers
+// are checked against the health of their SMTP servers. Other messengers don't report their
+// health, which is unknown and warned about.
+func (a *App) checkMessengerHealth(out *dryRunReport, name string) {
+	if !a.manager.HasMessenger(name) {
+		out.add("messenger", dryRunFail, a.i18n.Ts("campaigns.fieldInvalidMessenger", "name", name), name)
+		return
+	}
+
+	var em *email.Emailer
+	for _, m := range a.messengers {
+		if e, ok := m.(*email.Emailer); ok && e.Name() == name {
+			em = e
+			break
+		}
+	}
+	if em == nil {
+		out.add("messenger", dryRunWarn, a.i18n.Ts("campaigns.dryRunMessengerUnknown", "name", name), name)
+		return
+	}
+
+	var (
+		servers = em.Health()
+		down    []string
+	)
+	for _, s := range servers {
+		if !s.Healthy {
+			down = append(down, s.Name)
+		}
+	}
+
+	data := map[string]any{"name": name, "servers": servers}
+	switch {
+	case len(down) == len(servers):
+		out.add("messenger", dryRunFail, a.i18n.Ts("campaigns.dryRunMessengerDown", "name", name), data)
+	case len(down) > 0:
+		out.add("messenger", dryRunWarn, a.i18n.Ts("campaigns.dryRunServersDown", "servers", strings.Join(down, ", ")), data)
+	default:
+		out.add("messenger", dryRunPass, "", data)
+	}
+}
+
+// GetCampaignOverlap returns the other campaigns scheduled or running within a window
+// (?window=24h) of a campaign's send time and the number of subscribers they share.
+func (a *App) GetCampaignOverlap(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	window := overlapWindow
+	if v := c.QueryParam("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "window"))
+		}
+		window = d
+	}
+
+	if _, err := a.reqCore(c).GetCampaign(id, "", ""); err != nil {
+		return err
+	}
+
+	out, err := a.reqCore(c).GetCampaignOverlap(id, window, overlapSampleSize)
 	if err != nil {
 		return err
 	}
 
-	// Get the campaign from the DB for previewing.
-	tplID, _ := strconv.Atoi(c.FormValue("template_id"))
-	camp, err := a.core.GetCampaignForPreview(id, tplID)
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetCampaignErrors returns samples of the distinct errors that occurred while
+// sending a campaign. For a campaign that's being processed, the live samples
+// of the current run are returned, and for others, the ones saved from the last run.
+func (a *App) GetCampaignErrors(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	if out, ok := a.manager.GetCampaignErrors(id); ok {
+		return c.JSON(http.StatusOK, okResp{out})
+	}
+
+	out, err := a.reqCore(c).GetCampaignSendErrors(id)
 	if err != nil {
 		return err
 	}
 
-	// Override certain values from the DB with incoming values.
-	camp.Name = req.Name
-	camp.Subject = req.Subject
-	camp.FromEmail = req.FromEmail
-	camp.Body = req.Body
-	camp.AltBody = req.AltBody
-	camp.Messenger = req.Messenger
-	camp.ContentType = req.ContentType
-	camp.Headers = req.Headers
-	camp.TemplateID = req.TemplateID
-	for _, id := range req.MediaIDs {
-		if id > 0 {
-			camp.MediaIDs = append(camp.MediaIDs, int64(id))
-		}
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetCampaignHygiene returns the bounce hygiene summary of a campaign.
+func (a *App) GetCampaignHygiene(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
 	}
 
-	// Send the test messages.
-	for _, s := range subs {
-		sub := s
+	out, err := a.reqCore(c).GetCampaignHygiene(id)
+	if err != nil {
+		return err
+	}
 
-		if err := a.sendTestMessage(sub, &camp); err != nil {
-			a.log.Printf("error sending test message: %v", err)
-			return echo.NewHTTPError(http.StatusInternalServerError,
-				a.i18n.Ts("campaigns.errorSendTest", "error", err.Error()))
-		}
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetCampaignUnsubscribeReasons returns the aggregated unsubscribe reasons of
+// a campaign with the latest free text comments.
+func (a *App) GetCampaignUnsubscribeReasons(c echo.Context) error {
+	// Get the campaign ID.
+	id := getID(c)
+
+	// Check if the user has access to the campaign.
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
 	}
 
-	return c.JSON(http.StatusOK, okResp{true})
+	out, err := a.reqCore(c).GetUnsubscribeReasons(id, time.Time{}, unsubCommentsLimit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
 }
 
 // GetCampaignViewAnalytics retrieves view counts for a campaign.
@@ -596,6 +1786,9 @@ func (a *App) GetCampaignViewAnalytics(c echo.Context) error {
 		typ  = c.Param("type")
 		from = c.QueryParams().Get("from")
 		to   = c.QueryParams().Get("to")
+
+		// Events flagged as bots are excluded unless explicitly requested.
+		includeBots, _ = strconv.ParseBool(c.QueryParam("include_bots"))
 	)
 	if !strHasLen(from, 10, 30) || !strHasLen(to, 10, 30) {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("analytics.invalidDates"))
@@ -603,7 +1796,7 @@ func (a *App) GetCampaignViewAnalytics(c echo.Context) error {
 
 	// Campaign link stats.
 	if typ == "links" {
-		out, err := a.core.GetCampaignAnalyticsLinks(ids, typ, from, to)
+		out, err := a.reqCore(c).GetCampaignAnalyticsLinks(ids, typ, from, to, includeBots)
 		if err != nil {
 			return err
 		}
@@ -612,7 +1805,109 @@ func (a *App) GetCampaignViewAnalytics(c echo.Context) error {
 	}
 
 	// Get the analytics numbers from the DB for the campaigns.
-	out, err := a.core.GetCampaignAnalyticsCounts(ids, typ, from, to)
+	out, err := a.reqCore(c).GetCampaignAnalyticsCounts(ids, typ, from, to, includeBots)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetDomainAnalytics returns sent, view, click and bounce counts grouped by the
+// recipients' e-mail domain for an optional ?campaign_id and ?from / ?to dates.
+func (a *App) GetDomainAnalytics(c echo.Context) error {
+	var (
+		campID, _ = strconv.Atoi(c.QueryParam("campaign_id"))
+		from      = c.QueryParam("from")
+		to        = c.QueryParam("to")
+	)
+
+	// Accept timestamps and use the date part.
+	if len(from) > 10 {
+		from = from[:10]
+	}
+	if len(to) > 10 {
+		to = to[:10]
+	}
+
+	// Querying across all campaigns requires a date range.
+	if (from == "" || to == "") && campID < 1 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("analytics.invalidDates"))
+	}
+	for _, d := range []string{from, to} {
+		if _, err := time.Parse(time.DateOnly, d); d != "" && err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("analytics.invalidDates"))
+		}
+	}
+
+	if campID > 0 {
+		if err := a.checkCampaignPerm(auth.PermTypeGet, campID, c); err != nil {
+			return err
+		}
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > domainStatsMaxLimit {
+		limit = domainStatsLimit
+	}
+
+	out, err := a.reqCore(c).GetDomainStats(campID, from, to, limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// RollupCampaignStats saves the stats rollups of all finished campaigns that
+// don't have one yet, and the final rollups of campaigns whose stats have settled.
+// It's meant for backfilling rollups on large existing installations right away.
+func (a *App) RollupCampaignStats(c echo.Context) error {
+	var (
+		co     = a.reqCore(c)
+		settle = ko.Duration("app.campaign_stats_rollup_after")
+		batch  = max(ko.Int("app.batch_size"), 1)
+	)
+
+	total := 0
+	for {
+		n, err := co.RollupCampaignStats(nil, settle, batch)
+		if err != nil {
+			return err
+		}
+		total += n
+
+		if n < batch {
+			break
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Count int `json:"count"`
+	}{total}})
+}
+
+// GetCampaignCalendar returns campaigns that are scheduled or were running
+// within the ?from= and ?to= window for rendering on a calendar.
+func (a *App) GetCampaignCalendar(c echo.Context) error {
+	from, okFrom := parseCalendarDate(c.QueryParam("from"), false)
+	to, okTo := parseCalendarDate(c.QueryParam("to"), true)
+	if !okFrom || !okTo || to.Before(from) || to.Sub(from) > calendarMaxWindow {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("analytics.invalidDates"))
+	}
+
+	// Either the user has campaigns:get_all permissions and can view all campaigns,
+	// or the campaigns are filtered by the lists the user has get|manage access to.
+	var (
+		user           = auth.GetUser(c)
+		hasAllPerm     = user.HasPerm(auth.PermCampaignsGetAll)
+		permittedLists []int
+	)
+	if !hasAllPerm {
+		hasAllPerm, permittedLists = user.GetPermittedLists(auth.PermTypeGet | auth.PermTypeManage)
+	}
+
+	out, err := a.reqCore(c).GetCampaignCalendar(from, to, hasAllPerm, permittedLists)
 	if err != nil {
 		return err
 	}
@@ -638,6 +1933,25 @@ func (a *App) sendTestMessage(sub models.Subscriber, camp *models.Campaign) erro
 	return a.manager.PushCampaignMessage(msg)
 }
 
+// parseCalendarDate parses a calendar window boundary that's either an RFC3339
+// timestamp or a YYYY-MM-DD date. If endOfDay is true, a date is extended to
+// the last instant of the day so that the window is inclusive of it.
+func parseCalendarDate(s string, endOfDay bool) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return t, false
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	return t, true
+}
+
 // validateCampaignFields validates incoming campaign field values.
 func (a *App) validateCampaignFields(c campReq) (campReq, error) {
 	if c.FromEmail == "" {
@@ -648,6 +1962,13 @@ func (a *App) validateCampaignFields(c campReq) (campReq, error) {
 		}
 	}
 
+	c.ReplyTo = strings.TrimSpace(c.ReplyTo)
+	if c.ReplyTo != "" && !reFromAddress.Match([]byte(c.ReplyTo)) {
+		if _, err := a.importer.SanitizeEmail(c.ReplyTo); err != nil {
+			return c, errors.New(a.i18n.T("campaigns.fieldInvalidReplyTo"))
+		}
+	}
+
 	if !strHasLen(c.Name, 1, stdInputMaxLen) {
 		return c, errors.New(a.i18n.T("campaigns.fieldInvalidName"))
 	}
@@ -656,16 +1977,27 @@ func (a *App) validateCampaignFields(c campReq) (campReq, error) {
 	if !strHasLen(c.Subject, 1, 5000) {
 		return c, errors.New(a.i18n.T("campaigns.fieldInvalidSubject"))
 	}
+	if len(c.PreviewText) > 5000 {
+		return c, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "preview_text"))
+	}
 
 	// If no content-type is specified, default to richtext.
 	if c.ContentType != models.CampaignContentTypeRichtext &&
 		c.ContentType != models.CampaignContentTypeHTML &&
 		c.ContentType != models.CampaignContentTypePlain &&
 		c.ContentType != models.CampaignContentTypeVisual &&
-		c.ContentType != models.CampaignContentTypeMarkdown {
+		c.ContentType != models.CampaignContentTypeMarkdown &&
+		c.ContentType != models.CampaignContentTypeBlocks {
 		c.ContentType = models.CampaignContentTypeRichtext
 	}
 
+	// Structured content should be valid blocks. The errors list the index of every invalid block.
+	if c.ContentType == models.CampaignContentTypeBlocks {
+		if err := blocks.Validate(c.Body); err != nil {
+			return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidBlocks", "error", err.Error()))
+		}
+	}
+
 	if c.ContentType != models.CampaignContentTypeVisual {
 		c.BodySource.Valid = false
 	}
@@ -677,14 +2009,80 @@ func (a *App) validateCampaignFields(c campReq) (campReq, error) {
 		}
 	}
 
-	if len(c.ListIDs) == 0 {
+	// Ad-hoc campaigns are sent to uploaded recipients and not lists.
+	if c.Type == models.CampaignTypeAdhoc {
+		c.ListIDs = nil
+		c.ExcludeListIDs = []int{}
+	} else if len(c.ListIDs) == 0 {
 		return c, errors.New(a.i18n.T("campaigns.fieldInvalidListIDs"))
 	}
 
+	// A list can't be both included and excluded.
+	for _, id := range c.ExcludeListIDs {
+		if slices.Contains(c.ListIDs, id) {
+			return c, errors.New(a.i18n.T("campaigns.fieldInvalidExcludeLists"))
+		}
+	}
+
+	if !validateTags(c.Tags) {
+		return c, errors.New(a.i18n.Ts("globals.messages.invalidTags", "max", strconv.Itoa(tagsMaxNum), "len", strconv.Itoa(tagMaxLen)))
+	}
+
 	if !a.manager.HasMessenger(c.Messenger) {
 		return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidMessenger", "name", c.Messenger))
 	}
 
+	// Progress milestones are percentages. Null uses the global milestones.
+	if c.ProgressMilestones != nil {
+		for _, v := range c.ProgressMilestones {
+			if v < 1 || v > 100 {
+				return c, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "progress_milestones"))
+			}
+		}
+		slices.Sort(c.ProgressMilestones)
+		c.ProgressMilestones = slices.Compact(c.ProgressMilestones)
+	}
+
+	// Empty uses the global max runtime and 0 disables the limit.
+	if c.MaxRuntime != "" {
+		if d, err := time.ParseDuration(c.MaxRuntime); err != nil || d < 0 {
+			return c, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "max_runtime"))
+		}
+	}
+
+	// If no UTM config is specified, use the global default.
+	if c.UTM == (models.CampaignUTM{}) {
+		c.UTM = a.cfg.UTM
+	}
+
+	// If no tracking mode is specified, use the global default.
+	switch c.TrackingMode {
+	case models.CampaignTrackingModeFull, models.CampaignTrackingModeClicksOnly, models.CampaignTrackingModeNone:
+	case "":
+		c.TrackingMode = a.cfg.Privacy.TrackingMode
+	default:
+		return c, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "tracking_mode"))
+	}
+
+	if len(c.DynamicAttachments) > maxDynamicAttachments {
+		return c, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "dynamic_attachments"))
+	}
+	if c.DynamicAttachments == nil {
+		c.DynamicAttachments = models.DynamicAttachments{}
+	}
+	for n, d := range c.DynamicAttachments {
+		d.URL = strings.TrimSpace(d.URL)
+		d.Filename = strings.TrimSpace(d.Filename)
+		if d.OnError == "" {
+			d.OnError = models.AttachmentOnErrorSkip
+		}
+		if !strHasLen(d.URL, 1, 2000) || len(d.Filename) > 200 ||
+			(d.OnError != models.AttachmentOnErrorSkip && d.OnError != models.AttachmentOnErrorSend) {
+			return c, errors.New(a.i18n.Ts("globals.messages.invalidFields", "name", "dynamic_attachments"))
+		}
+		c.DynamicAttachments[n] = d
+	}
+
 	camp := models.Campaign{Body: c.Body, TemplateBody: tplTag}
 	if err := c.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
 		return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidBody", "error", err.Error()))
@@ -693,6 +2091,14 @@ func (a *App) validateCampaignFields(c campReq) (campReq, error) {
 	if len(c.Headers) == 0 {
 		c.Headers = make([]map[string]string, 0)
 	}
+	for _, set := range c.Headers {
+		for hdr, val := range set {
+			if !reHeaderName.MatchString(hdr) || strings.ContainsAny(val, "\r\n") ||
+				reservedCampaignHeaders[textproto.CanonicalMIMEHeaderKey(hdr)] {
+				return c, errors.New(a.i18n.Ts("campaigns.fieldInvalidHeader", "name", hdr))
+			}
+		}
+	}
 
 	if len(c.ArchiveMeta) == 0 {
 		c.ArchiveMeta = json.RawMessage("{}")
@@ -796,6 +2202,7 @@ func (a *App) checkCampaignPerm(types auth.PermType, id int, c echo.Context) err
 // its properties is allowed.
 func canEditCampaign(status string) bool {
 	return status == models.CampaignStatusDraft ||
+		status == models.CampaignStatusPendingApproval ||
 		status == models.CampaignStatusPaused ||
 		status == models.CampaignStatusScheduled
 }
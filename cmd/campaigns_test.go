@@ -0,0 +1,1483 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/bounce"
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/models"
+	"github.com/knadh/smtppool/v2"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+func TestUpdateCampaignStatusOverlap(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.archiveSitemap = &archiveSitemap{}
+
+	e := newTestEcho()
+	e.PUT("/api/campaigns/:id/status", hasID(a.UpdateCampaignStatus), func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+			return next(c)
+		}
+	})
+
+	var listID int
+	if err := db.Get(&listID, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'list', 'private') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	var subID int
+	if err := db.Get(&subID, `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), 'sub@example.com', 'sub') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO subscriber_lists (subscriber_id, list_id, status) VALUES ($1, $2, 'confirmed')`, subID, listID); err != nil {
+		t.Fatal(err)
+	}
+
+	newCamp := func(name, status string) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, send_at)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', '', 'email', $2, NOW() + INTERVAL '1 hour') RETURNING id`, name, status); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO campaign_lists (campaign_id, list_id) VALUES ($1, $2)`, id, listID); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	newCamp("other", "scheduled")
+
+	schedule := func(id int, body string) map[string]json.RawMessage {
+		req := httptest.NewRequest(http.MethodPut, "/api/campaigns/"+strconv.Itoa(id)+"/status", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		var out struct {
+			Data map[string]json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		return out.Data
+	}
+
+	// By default, the response is the campaign without a warning.
+	out := schedule(newCamp("default", "draft"), `{"status": "scheduled", "override_unsub_check": true}`)
+	if _, ok := out["warning"]; ok {
+		t.Errorf("expected no warning without check_overlap: %v", out)
+	}
+	if string(out["status"]) != `"scheduled"` || string(out["name"]) != `"default"` {
+		t.Errorf("expected the campaign in the response: %v", out)
+	}
+
+	// With check_overlap, the overlapping campaign is in the warning.
+	out = schedule(newCamp("check", "draft"), `{"status": "scheduled", "override_unsub_check": true, "check_overlap": true}`)
+	var warn campOverlapWarning
+	if err := json.Unmarshal(out["warning"], &warn); err != nil {
+		t.Fatalf("expected a warning with check_overlap: %v", out)
+	}
+	if len(warn.Campaigns) != 2 || warn.Message == "" || string(out["name"]) != `"check"` {
+		t.Errorf("unexpected warning %+v", warn)
+	}
+}
+
+// testMessenger is a messenger that discards messages.
+type testMessenger struct{}
+
+func (testMessenger) Name() string              { return "email" }
+func (testMessenger) Push(models.Message) error { return nil }
+func (testMessenger) Flush() error              { return nil }
+func (testMessenger) Close() error              { return nil }
+
+func TestValidateCampaignHeaders(t *testing.T) {
+	a := newTestApp(t)
+	a.manager = manager.New(manager.Config{}, nil, a.i18n, log.New(io.Discard, "", 0))
+	if err := a.manager.AddMessenger(testMessenger{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		headers models.Headers
+		ok      bool
+	}{
+		{"none", nil, true},
+		{"custom", models.Headers{{"X-Campaign-Type": "promo"}, {"X-MSYS-API": `{"options": {}}`}}, true},
+		{"reply-to", models.Headers{{"Reply-To": "reply@example.com"}}, true},
+		{"from", models.Headers{{"From": "spoof@example.com"}}, false},
+		{"to", models.Headers{{"to": "victim@example.com"}}, false},
+		{"subject", models.Headers{{"SUBJECT": "Hi"}}, false},
+		{"list-unsubscribe", models.Headers{{"X-Campaign-Type": "promo"}, {"List-Unsubscribe": "<https://example.com>"}}, false},
+		{"list-unsubscribe-post", models.Headers{{"list-unsubscribe-post": "List-Unsubscribe=One-Click"}}, false},
+		{"message-id", models.Headers{{"Message-ID": "<id@example.com>"}}, false},
+		{"campaign uuid", models.Headers{{models.EmailHeaderCampaignUUID: "uuid"}}, false},
+		{"invalid name", models.Headers{{"X Bad:Name": "value"}}, false},
+		{"line break", models.Headers{{"X-Tag": "a\r\nBcc: victim@example.com"}}, false},
+	}
+	for _, c := range cases {
+		req := campReq{
+			Campaign: models.Campaign{
+				Name:      "test",
+				Subject:   "Hello",
+				FromEmail: "News <news@example.com>",
+				Body:      "Hello",
+				Messenger: "email",
+				Headers:   c.headers,
+			},
+			ListIDs: []int{1},
+		}
+
+		out, err := a.validateCampaignFields(req)
+		if c.ok {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", c.name, err)
+			} else if out.Headers == nil {
+				t.Errorf("%s: expected non-nil headers", c.name)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), "header") {
+			t.Errorf("%s: expected an invalid header error, got %v", c.name, err)
+		}
+	}
+}
+
+func TestParseCalendarDate(t *testing.T) {
+	cases := []struct {
+		in       string
+		endOfDay bool
+		exp      time.Time
+		ok       bool
+	}{
+		{"2026-03-01", false, time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), true},
+		{"2026-03-01", true, time.Date(2026, 3, 1, 23, 59, 59, 999999999, time.UTC), true},
+		{"2026-02-28", true, time.Date(2026, 2, 28, 23, 59, 59, 999999999, time.UTC), true},
+		{"2026-03-01T10:30:00Z", false, time.Date(2026, 3, 1, 10, 30, 0, 0, time.UTC), true},
+
+		// Timestamps aren't extended to the end of the day.
+		{"2026-03-01T10:30:00+05:30", true, time.Date(2026, 3, 1, 5, 0, 0, 0, time.UTC), true},
+
+		{"", false, time.Time{}, false},
+		{"2026-02-30", false, time.Time{}, false},
+		{"01-03-2026", false, time.Time{}, false},
+		{"2026-03-01 10:30:00", false, time.Time{}, false},
+	}
+	for _, c := range cases {
+		got, ok := parseCalendarDate(c.in, c.endOfDay)
+		if ok != c.ok || (ok && !got.Equal(c.exp)) {
+			t.Errorf("%q %v: expected %v %v, got %v %v", c.in, c.endOfDay, c.exp, c.ok, got, ok)
+		}
+	}
+}
+
+func TestGetCampaignCalendarWindow(t *testing.T) {
+	a := newTestApp(t)
+
+	// Invalid windows are refused before they're queried.
+	cases := []struct {
+		name, from, to string
+	}{
+		{"no dates", "", ""},
+		{"no to", "2026-03-01", ""},
+		{"invalid from", "yesterday", "2026-03-01"},
+		{"to before from", "2026-03-02", "2026-03-01"},
+		{"to before from timestamp", "2026-03-01T10:00:00Z", "2026-03-01T09:59:59Z"},
+		{"too long", "2026-01-01", "2027-01-02"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/campaigns/calendar?from="+c.from+"&to="+c.to, nil)
+		err := a.GetCampaignCalendar(echo.New().NewContext(req, httptest.NewRecorder()))
+
+		var he *echo.HTTPError
+		if !errors.As(err, &he) || he.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected a bad request, got %v", c.name, err)
+		}
+	}
+
+	// The longest window is 366 days, inclusive of the last day.
+	from, _ := parseCalendarDate("2028-01-01", false)
+	to, _ := parseCalendarDate("2028-12-31", true)
+	if to.Sub(from) > calendarMaxWindow {
+		t.Fatalf("expected a leap year to fit in the window, got %v", to.Sub(from))
+	}
+}
+
+func TestGetDomainAnalyticsParams(t *testing.T) {
+	a := newTestApp(t)
+
+	// Invalid params are refused before they're queried.
+	cases := []struct {
+		name, query string
+	}{
+		{"no dates or campaign", ""},
+		{"no to", "from=2026-03-01"},
+		{"no from", "to=2026-03-01"},
+		{"invalid campaign", "campaign_id=abc&to=2026-03-01"},
+		{"invalid from", "from=yesterday&to=2026-03-01"},
+		{"invalid to", "campaign_id=1&to=2026-13-01"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/api/analytics/domains?"+c.query, nil)
+		err := a.GetDomainAnalytics(echo.New().NewContext(req, httptest.NewRecorder()))
+
+		var he *echo.HTTPError
+		if !errors.As(err, &he) || he.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected a bad request, got %v", c.name, err)
+		}
+	}
+}
+
+func TestDryRunMessengerHealth(t *testing.T) {
+	a := newTestApp(t)
+	a.manager = manager.New(manager.Config{}, nil, a.i18n, log.New(io.Discard, "", 0))
+
+	// A server on a port that refuses connections.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	em, err := email.New("email", email.Server{Name: "down", Opt: smtppool.Opt{Host: "127.0.0.1", Port: port, MaxConns: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer em.Close()
+
+	a.messengers = []manager.Messenger{em, webhookMessenger{}}
+	for _, m := range a.messengers {
+		if err := a.manager.AddMessenger(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(name string) dryRunCheck {
+		var r dryRunReport
+		a.checkMessengerHealth(&r, name)
+		if len(r.Checks) != 1 || r.Status != r.Checks[0].Status {
+			t.Fatalf("unexpected report %+v", r)
+		}
+		return r.Checks[0]
+	}
+
+	// Unknown messengers fail and the health of other messengers is unknown.
+	if c := check("nope"); c.Status != dryRunFail {
+		t.Errorf("expected an unknown messenger to fail, got %+v", c)
+	}
+	if c := check("webhook"); c.Status != dryRunWarn || c.Message == "" {
+		t.Errorf("expected the health of a non e-mail messenger to be warned about, got %+v", c)
+	}
+
+	// E-mail messengers pass while their servers are healthy.
+	if c := check("email"); c.Status != dryRunPass {
+		t.Errorf("expected healthy servers to pass, got %+v", c)
+	}
+
+	// A failed send puts the server in its cooldown.
+	if err := em.Push(models.Message{From: "from@example.com", To: []string{"to@example.com"}, Subject: "Hi", Body: []byte("Hi")}); err == nil {
+		t.Fatal("expected the send to fail")
+	}
+	c := check("email")
+	if c.Status != dryRunFail || !strings.Contains(c.Message, "email") {
+		t.Fatalf("expected the messenger to fail with all its servers down, got %+v", c)
+	}
+	if servers := c.Data.(map[string]any)["servers"].([]email.ServerHealth); len(servers) != 1 || servers[0].Healthy || servers[0].Error == "" {
+		t.Errorf("unexpected server health %+v", servers)
+	}
+}
+
+// webhookMessenger is a messenger that isn't an e-mail messenger.
+type webhookMessenger struct{ testMessenger }
+
+func (webhookMessenger) Name() string { return "webhook" }
+
+// seedMessenger is an e-mail messenger that records the seed addresses it sends
+// to and fails addresses prefixed with "fail".
+type seedMessenger struct {
+	testMessenger
+
+	sent []string
+}
+
+func (s *seedMessenger) Push(m models.Message) error {
+	if strings.HasPrefix(m.To[0], "fail") {
+		return errors.New("mailbox unavailable")
+	}
+	if m.Headers.Get(models.SeedHeader) == "true" {
+		s.sent = append(s.sent, m.To[0])
+	}
+	return nil
+}
+
+func TestSeedSendCampaign(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.archiveSitemap = &archiveSitemap{}
+	a.manager = manager.New(manager.Config{}, nil, a.i18n, log.New(io.Discard, "", 0))
+
+	msgr := &seedMessenger{}
+	if err := a.manager.AddMessenger(msgr); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEcho()
+	g := e.Group("", func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+			return next(c)
+		}
+	})
+	g.POST("/api/campaigns/:id/seed-send", hasID(a.SeedSendCampaign))
+	g.PUT("/api/campaigns/:id/status", hasID(a.UpdateCampaignStatus))
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var tplID int
+	if err := db.Get(&tplID, `INSERT INTO templates (name, type, subject, body) VALUES ('tpl', 'campaign', '', '<p>{{ template "content" . }}</p>') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	newCamp := func(name string) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, template_id, send_at)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', 'Hello', 'email', 'draft', $2, NOW() + INTERVAL '1 hour') RETURNING id`, name, tplID); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	seeded, unseeded := newCamp("seeded"), newCamp("unseeded")
+	seedURL := "/api/campaigns/" + strconv.Itoa(seeded) + "/seed-send"
+
+	// Without a seed list, there's nothing to send.
+	if rec := do(http.MethodPost, seedURL, ""); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected a bad request without seed addresses, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Each seed address is sent to and its result is recorded.
+	a.cfg.SeedEmails = []string{"seed@example.com", "fail@example.com"}
+	rec := do(http.MethodPost, seedURL, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Data models.CampaignSeedSend `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if r := resp.Data.Results; len(r) != 2 ||
+		r[0] != (models.SeedSendResult{Email: "seed@example.com", Status: models.SeedStatusSent}) ||
+		r[1].Status != models.SeedStatusFailed || r[1].Error != "mailbox unavailable" {
+		t.Errorf("unexpected results %+v", resp.Data)
+	}
+	if len(msgr.sent) != 1 || msgr.sent[0] != "seed@example.com" {
+		t.Errorf("expected the seed message with the seed header, got %v", msgr.sent)
+	}
+
+	// The results are saved on the campaign and the stats are untouched.
+	var c struct {
+		SeedSend json.RawMessage `db:"seed_send"`
+		Sent     int             `db:"sent"`
+	}
+	if err := db.Get(&c, `SELECT seed_send, sent FROM campaigns WHERE id = $1`, seeded); err != nil {
+		t.Fatal(err)
+	}
+	var saved models.CampaignSeedSend
+	if err := json.Unmarshal(c.SeedSend, &saved); err != nil || len(saved.Results) != 2 || !saved.HasSent() || c.Sent != 0 {
+		t.Errorf("unexpected saved seed send %s, sent %d: %v", c.SeedSend, c.Sent, err)
+	}
+
+	// With the seed send required, only the campaign that was sent to the seed list can be scheduled.
+	a.cfg.RequireSeedSend = true
+	schedule := func(id int) *httptest.ResponseRecorder {
+		return do(http.MethodPut, "/api/campaigns/"+strconv.Itoa(id)+"/status", `{"status": "scheduled", "override_unsub_check": true}`)
+	}
+	if rec := schedule(unseeded); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), a.i18n.T("campaigns.seedSendRequired")) {
+		t.Errorf("expected the unseeded campaign to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := schedule(seeded); rec.Code != http.StatusOK {
+		t.Errorf("expected the seeded campaign to be scheduled, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// A seed send where every address failed doesn't count.
+	if err := a.core.UpdateCampaignSeedSend(unseeded, models.CampaignSeedSend{
+		Results: []models.SeedSendResult{{Email: "fail@example.com", Status: models.SeedStatusFailed}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if rec := schedule(unseeded); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a failed seed send to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Without the requirement, campaigns can be scheduled as usual.
+	a.cfg.RequireSeedSend = false
+	if rec := schedule(unseeded); rec.Code != http.StatusOK {
+		t.Errorf("expected the campaign to be scheduled, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestHasUnsubLink(t *testing.T) {
+	a := newTestApp(t)
+	a.manager = manager.New(manager.Config{UnsubURL: "https://example.com/subscription/%s/%s"}, nil, a.i18n, log.New(io.Discard, "", 0))
+
+	cases := []struct {
+		name    string
+		tpl     string
+		body    string
+		altBody string
+		exp     bool
+	}{
+		{"no link", "", "<p>Hello</p>", "", false},
+		{"in the body", "", `<a href="{{ UnsubscribeURL }}">Unsubscribe</a>`, "", true},
+		{"in the template", `{{ template "content" . }}<a href="{{ UnsubscribeURL }}">Unsubscribe</a>`, "<p>Hello</p>", "", true},
+		{"in a template partial",
+			`{{ define "footer" }}<a href="{{ UnsubscribeURL }}">Unsubscribe</a>{{ end }}{{ template "content" . }}{{ template "footer" . }}`,
+			"<p>Hello</p>", "", true},
+		{"partial that isn't used", `{{ define "footer" }}<a href="{{ UnsubscribeURL }}">Unsubscribe</a>{{ end }}{{ template "content" . }}`,
+			"<p>Hello</p>", "", false},
+		{"in the alt body", "", "<p>Hello</p>", "Unsubscribe: {{ UnsubscribeURL }}", true},
+		{"manage link", "", `<a href="{{ ManageURL }}">Preferences</a>`, "", true},
+		{"hardcoded link", "", `<a href="https://example.com/subscription/x/y">Unsubscribe</a>`, "", false},
+	}
+	for _, c := range cases {
+		camp := models.Campaign{
+			UUID:         dummySubscriber.UUID,
+			Name:         c.name,
+			Subject:      "Hello",
+			ContentType:  models.CampaignContentTypeRichtext,
+			TemplateBody: c.tpl,
+			Body:         c.body,
+		}
+		if c.altBody != "" {
+			camp.AltBody.String, camp.AltBody.Valid = c.altBody, true
+		}
+		if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		msg, err := a.manager.NewCampaignMessage(&camp, dummySubscriber)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+
+		if got := hasUnsubLink(msg); got != c.exp {
+			t.Errorf("%s: expected %v, got %v: %s", c.name, c.exp, got, msg.Body())
+		}
+	}
+}
+
+func TestUpdateCampaignStatusUnsubLink(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.archiveSitemap = &archiveSitemap{}
+	a.manager = manager.New(manager.Config{UnsubURL: "https://example.com/subscription/%s/%s"}, nil, a.i18n, log.New(io.Discard, "", 0))
+
+	var user auth.User
+	e := newTestEcho()
+	e.PUT("/api/campaigns/:id/status", hasID(a.UpdateCampaignStatus), func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, user)
+			return next(c)
+		}
+	})
+
+	var tplID int
+	if err := db.Get(&tplID, `INSERT INTO templates (name, type, subject, body) VALUES ('footer', 'campaign', '',
+		'{{ define "footer" }}<a href="{{ UnsubscribeURL }}">Unsubscribe</a>{{ end }}{{ template "content" . }}{{ template "footer" . }}') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	newCamp := func(name string, tplID any, tags string) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, template_id, tags, send_at)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', '<p>Hello</p>', 'email', 'draft', $2, $3, NOW() + INTERVAL '1 hour') RETURNING id`,
+			name, tplID, tags); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	schedule := func(id int, override bool) *httptest.ResponseRecorder {
+		body := `{"status": "scheduled", "override_unsub_check": ` + strconv.FormatBool(override) + `}`
+		req := httptest.NewRequest(http.MethodPut, "/api/campaigns/"+strconv.Itoa(id)+"/status", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	user = auth.User{UserRoleID: auth.SuperAdminRoleID}
+
+	// A campaign without the link can't be scheduled.
+	noLink := newCamp("no link", nil, "{}")
+	if rec := schedule(noLink, false); rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), a.i18n.T("campaigns.noUnsubLink")) {
+		t.Errorf("expected the campaign without a link to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The link in the template's partial is found.
+	if rec := schedule(newCamp("partial", tplID, "{}"), false); rec.Code != http.StatusOK {
+		t.Errorf("expected the campaign with the link in a partial to be scheduled, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Transactional campaigns are exempt.
+	if rec := schedule(newCamp("transactional", nil, "{transactional}"), false); rec.Code != http.StatusOK {
+		t.Errorf("expected the transactional campaign to be scheduled, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Only super admins can override the check.
+	user = auth.User{PermissionsMap: map[string]struct{}{auth.PermCampaignsManageAll: {}}}
+	if rec := schedule(noLink, true); rec.Code != http.StatusForbidden {
+		t.Errorf("expected the override to be forbidden, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	user = auth.User{UserRoleID: auth.SuperAdminRoleID}
+	if rec := schedule(noLink, true); rec.Code != http.StatusOK {
+		t.Errorf("expected the override to schedule the campaign, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAdhocCampaignRecipients(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.importer = subimporter.New(subimporter.Options{DomainBlocklist: []string{"blocked.com"}}, nil, a.i18n, a.log)
+
+	e := newTestEcho()
+	e.POST("/api/campaigns/:id/recipients", hasID(a.UploadCampaignRecipients), func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+			return next(c)
+		}
+	})
+
+	upload := func(id int, csv string) *httptest.ResponseRecorder {
+		t.Helper()
+
+		var b bytes.Buffer
+		w := multipart.NewWriter(&b)
+		f, err := w.CreateFormFile("file", "recipients.csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(f, csv); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/campaigns/"+strconv.Itoa(id)+"/recipients", &b)
+		req.Header.Set(echo.HeaderContentType, w.FormDataContentType())
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	newCamp := func(name, typ string) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, type, status)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', '', 'email', $2, 'draft') RETURNING id`, name, typ); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	campID := newCamp("adhoc", models.CampaignTypeAdhoc)
+
+	// An existing subscriber that's blocklisted.
+	if _, err := db.Exec(`INSERT INTO subscribers (uuid, email, name, status) VALUES (gen_random_uuid(), 'blocked@example.com', 'blocked', 'blocklisted')`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only ad-hoc campaigns take recipients.
+	if rec := upload(newCamp("regular", models.CampaignTypeRegular), "email\na@example.com\n"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a regular campaign to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := upload(campID, "name\nA\n"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a CSV without an email column to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Invalid, domain blocklisted, and duplicate e-mails and bad attributes are skipped.
+	rec := upload(campID, "Email,Name,Attributes\n"+
+		`a@example.com,A,"{""plan"": ""pro""}"`+"\n"+
+		"B@Example.com,,\n"+
+		"blocked@example.com,Blocked,\n"+
+		"x@blocked.com,X,\n"+
+		"not-an-email,,\n"+
+		"a@example.com,Dup,\n"+
+		"c@example.com,C,{bad\n")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Data struct {
+			Imported int `json:"imported"`
+			Skipped  int `json:"skipped"`
+			Total    int `json:"total"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if d := resp.Data; d.Imported != 3 || d.Skipped != 4 || d.Total != 3 {
+		t.Errorf("unexpected import result %+v", d)
+	}
+
+	// No subscribers are created.
+	var n int
+	if err := db.Get(&n, `SELECT COUNT(*) FROM subscribers`); err != nil || n != 1 {
+		t.Errorf("expected no new subscribers, got %d: %v", n, err)
+	}
+
+	// The running campaign is sent to the recipients, skipping the blocklisted subscriber.
+	if _, err := db.Exec(`UPDATE campaigns SET status = 'running' WHERE id = $1`, campID); err != nil {
+		t.Fatal(err)
+	}
+	st := newManagerStore(db.DB, db.Q, a.core, nil, false, 0)
+	camps, err := st.NextCampaigns(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(camps) != 1 || camps[0].ID != campID || camps[0].ToSend != 2 {
+		t.Fatalf("expected the ad-hoc campaign with 2 recipients, got %+v", camps)
+	}
+
+	subs, err := st.NextSubscribers(campID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 2 || subs[0].Email != "a@example.com" || subs[0].Name != "A" || subs[0].Attribs["plan"] != "pro" ||
+		subs[1].Email != "b@example.com" || subs[1].Name != "b" || subs[1].UUID == "" || subs[1].Status != models.SubscriberStatusEnabled {
+		t.Fatalf("unexpected recipients %+v", subs)
+	}
+	if subs, err := st.NextSubscribers(campID, 10); err != nil || len(subs) != 0 {
+		t.Errorf("expected no more recipients, got %+v: %v", subs, err)
+	}
+
+	// The recipients are deleted once the campaign has ended for longer than the retention period.
+	if _, err := db.Exec(`UPDATE campaigns SET status = 'finished', updated_at = NOW() - INTERVAL '2 hours' WHERE id = $1`, campID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := st.DeleteStaleCampaignRecipients(3 * time.Hour); err != nil || n != 0 {
+		t.Errorf("expected the recipients to be retained, got %d deleted: %v", n, err)
+	}
+	if n, err := st.DeleteStaleCampaignRecipients(time.Hour); err != nil || n != 3 {
+		t.Errorf("expected 3 recipients to be deleted, got %d: %v", n, err)
+	}
+}
+
+func TestHasCampaignContentChanged(t *testing.T) {
+	base := models.Campaign{
+		Subject:   "Hello",
+		FromEmail: "from@example.com",
+		Body:      "<p>Hello</p>",
+		Headers:   []map[string]string{{"X-Tag": "a"}},
+	}
+	base.TemplateID = null.IntFrom(1)
+
+	cases := []struct {
+		name string
+		fn   func(c *models.Campaign)
+		exp  bool
+	}{
+		{"unchanged", func(c *models.Campaign) {}, false},
+		{"name", func(c *models.Campaign) { c.Name = "renamed" }, false},
+		{"tags", func(c *models.Campaign) { c.Tags = []string{"tag"} }, false},
+		{"subject", func(c *models.Campaign) { c.Subject = "Hi" }, true},
+		{"preview text", func(c *models.Campaign) { c.PreviewText = "Hi" }, true},
+		{"from", func(c *models.Campaign) { c.FromEmail = "other@example.com" }, true},
+		{"body", func(c *models.Campaign) { c.Body = "<p>Hi</p>" }, true},
+		{"alt body", func(c *models.Campaign) { c.AltBody = null.StringFrom("Hi") }, true},
+		{"template", func(c *models.Campaign) { c.TemplateID = null.IntFrom(2) }, true},
+		{"headers", func(c *models.Campaign) { c.Headers = []map[string]string{{"X-Tag": "b"}} }, true},
+	}
+	for _, c := range cases {
+		camp := base
+		camp.Headers = []map[string]string{{"X-Tag": "a"}}
+		c.fn(&camp)
+		if got := hasCampaignContentChanged(base, camp); got != c.exp {
+			t.Errorf("%s: expected %v, got %v", c.name, c.exp, got)
+		}
+	}
+}
+
+// TestCampaignApproval checks that unapproved campaigns can't be scheduled or
+// started by users who can't approve them, through the API or the scheduler.
+func TestCampaignApproval(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.cfg.RequireCampaignApproval = true
+	a.archiveSitemap = &archiveSitemap{}
+	a.manager = manager.New(manager.Config{}, nil, a.i18n, log.New(io.Discard, "", 0))
+
+	var user auth.User
+	e := newTestEcho()
+	setUser := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, user)
+			return next(c)
+		}
+	}
+	e.PUT("/api/campaigns/:id/status", hasID(a.UpdateCampaignStatus), setUser)
+	e.POST("/api/campaigns/:id/approve", hasID(a.ApproveCampaign), setUser)
+	e.POST("/api/campaigns/:id/reject", hasID(a.RejectCampaign), setUser)
+
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	setStatus := func(id int, status string) *httptest.ResponseRecorder {
+		return do(http.MethodPut, "/api/campaigns/"+strconv.Itoa(id)+"/status", `{"status": "`+status+`"}`)
+	}
+
+	type approval struct {
+		Status     string        `db:"status"`
+		ApprovedBy sql.NullInt64 `db:"approved_by"`
+		Comment    string        `db:"approval_comment"`
+	}
+	getApproval := func(id int) approval {
+		t.Helper()
+		var out approval
+		if err := db.Get(&out, `SELECT status, approved_by, approval_comment FROM campaigns WHERE id = $1`, id); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	var id int
+	if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, tags, send_at)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com', '<p>Hello</p>', 'email', 'draft', '{transactional}', NOW() + INTERVAL '1 hour') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		junior   = auth.User{Base: auth.Base{ID: 2}, PermissionsMap: map[string]struct{}{auth.PermCampaignsManageAll: {}}}
+		approver = auth.User{Base: auth.Base{ID: 3}, PermissionsMap: map[string]struct{}{auth.PermCampaignsGetAll: {}, auth.PermCampaignsManageAll: {}, auth.PermCampaignsApprove: {}}}
+	)
+
+	// Users who can't approve campaigns can't schedule or start unapproved ones.
+	user = junior
+	for _, s := range []string{models.CampaignStatusScheduled, models.CampaignStatusRunning} {
+		if rec := setStatus(id, s); rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), a.i18n.T("campaigns.needsApproval")) {
+			t.Errorf("%s: expected the unapproved campaign to be refused, got %d: %s", s, rec.Code, rec.Body.String())
+		}
+	}
+	if s := getApproval(id).Status; s != models.CampaignStatusDraft {
+		t.Fatalf("expected the campaign to be a draft, got %s", s)
+	}
+
+	// Campaigns that aren't pending approval can't be reviewed.
+	user = approver
+	if rec := do(http.MethodPost, "/api/campaigns/"+strconv.Itoa(id)+"/approve", `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected the draft to not be approved, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The campaign is submitted for approval. This is set directly as the
+	// handler notifies the approvers in the background.
+	if _, err := db.Exec(`UPDATE campaigns SET status = 'pending_approval' WHERE id = $1`, id); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rejections require a comment and move the campaign back to draft.
+	if rec := do(http.MethodPost, "/api/campaigns/"+strconv.Itoa(id)+"/reject", `{"comment": " "}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected the rejection without a comment to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/api/campaigns/"+strconv.Itoa(id)+"/reject", `{"comment": "fix the subject"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected the campaign to be rejected, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ap := getApproval(id); ap.Status != models.CampaignStatusDraft || ap.ApprovedBy.Valid || ap.Comment != "fix the subject" {
+		t.Errorf("unexpected rejected campaign %+v", ap)
+	}
+	user = junior
+	if rec := setStatus(id, models.CampaignStatusScheduled); rec.Code != http.StatusForbidden {
+		t.Errorf("expected the rejected campaign to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Approved campaigns can be scheduled.
+	if _, err := db.Exec(`UPDATE campaigns SET status = 'pending_approval' WHERE id = $1`, id); err != nil {
+		t.Fatal(err)
+	}
+	user = approver
+	if rec := do(http.MethodPost, "/api/campaigns/"+strconv.Itoa(id)+"/approve", `{"comment": "ok"}`); rec.Code != http.StatusOK {
+		t.Fatalf("expected the campaign to be approved, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ap := getApproval(id); ap.Status != models.CampaignStatusDraft || ap.ApprovedBy.Int64 != int64(approver.ID) || ap.Comment != "ok" {
+		t.Errorf("unexpected approved campaign %+v", ap)
+	}
+	user = junior
+	if rec := setStatus(id, models.CampaignStatusScheduled); rec.Code != http.StatusOK {
+		t.Fatalf("expected the approved campaign to be scheduled, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Revoking the approval, as a content change does, moves the scheduled campaign
+	// back to pending approval.
+	if err := a.core.SetCampaignApproval(id, 0); err != nil {
+		t.Fatal(err)
+	}
+	if ap := getApproval(id); ap.Status != models.CampaignStatusPendingApproval || ap.ApprovedBy.Valid {
+		t.Errorf("expected the approval to be revoked, got %+v", ap)
+	}
+
+	// Approvers implicitly approve the campaigns they schedule.
+	if _, err := db.Exec(`UPDATE campaigns SET status = 'draft' WHERE id = $1`, id); err != nil {
+		t.Fatal(err)
+	}
+	user = approver
+	if rec := setStatus(id, models.CampaignStatusScheduled); rec.Code != http.StatusOK {
+		t.Fatalf("expected the approver to schedule the campaign, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if ap := getApproval(id); ap.Status != models.CampaignStatusScheduled || ap.ApprovedBy.Int64 != int64(approver.ID) {
+		t.Errorf("expected the scheduled campaign to be approved, got %+v", ap)
+	}
+
+	// The scheduler doesn't start unapproved campaigns that are due.
+	if _, err := db.Exec(`UPDATE campaigns SET send_at = NOW() - INTERVAL '1 minute', approved_by = NULL, approved_at = NULL WHERE id = $1`, id); err != nil {
+		t.Fatal(err)
+	}
+	camps, err := newManagerStore(db.DB, db.Q, a.core, nil, true, 0).NextCampaigns(nil, nil)
+	if err != nil || len(camps) != 0 {
+		t.Errorf("expected the unapproved campaign to not be started, got %+v: %v", camps, err)
+	}
+	if s := getApproval(id).Status; s != models.CampaignStatusScheduled {
+		t.Errorf("expected the campaign to stay scheduled, got %s", s)
+	}
+
+	// ... unless approvals aren't required.
+	camps, err = newManagerStore(db.DB, db.Q, a.core, nil, false, 0).NextCampaigns(nil, nil)
+	if err != nil || len(camps) != 1 || camps[0].ID != id {
+		t.Errorf("expected the campaign to be started, got %+v: %v", camps, err)
+	}
+}
+
+// TestPreviewSubscriber previews a campaign and a template with a real
+// subscriber's data and checks that nothing is recorded for them.
+func TestPreviewSubscriber(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.manager = manager.New(manager.Config{
+		LinkTrackURL:   "https://example.com/link/%s/%s/%s",
+		ViewTrackURL:   "https://example.com/campaign/%s/%s/px.png",
+		UnsubURL:       "https://example.com/subscription/%s/%s",
+		PreviewLinkURL: "https://example.com/link/preview?url=%s",
+	}, nil, a.i18n, log.New(io.Discard, "", 0))
+
+	var user auth.User
+	e := newTestEcho()
+	setUser := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, user)
+			return next(c)
+		}
+	}
+	e.GET("/api/campaigns/:id/preview", hasID(a.PreviewCampaign), setUser)
+	e.GET("/api/campaigns/:id/preview/:subscriber_id", hasID(a.PreviewCampaign), setUser)
+	e.GET("/api/templates/:id/preview/:subscriber_id", hasID(a.PreviewTemplate), setUser)
+	e.GET("/link/preview", a.PreviewLinkPage)
+
+	var subID, campID, tplID int
+	if err := db.Get(&subID, `INSERT INTO subscribers (uuid, email, name, status, attribs)
+		VALUES (gen_random_uuid(), 'user@example.com', 'Real User', 'enabled', '{"plan": "pro"}') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&tplID, `INSERT INTO templates (name, type, subject, body) VALUES ('tpl', 'campaign', '',
+		'<p>{{ .Subscriber.Name }}</p>{{ template "content" . }}<a href="{{ UnsubscribeURL }}">Unsubscribe</a>{{ TrackView }}') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&campID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, template_id)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com',
+		'{{ if eq .Subscriber.Attribs.plan "pro" }}<p>Pro plan</p>{{ end }}<a href="https://listmonk.app@TrackLink">Link</a>', 'email', 'draft', $1) RETURNING id`,
+		tplID); err != nil {
+		t.Fatal(err)
+	}
+
+	preview := func(target string) *httptest.ResponseRecorder {
+		t.Helper()
+		return doForm(e, http.MethodGet, target, nil)
+	}
+	campURL := "/api/campaigns/" + strconv.Itoa(campID) + "/preview"
+
+	user = auth.User{UserRoleID: auth.SuperAdminRoleID}
+
+	// The dummy subscriber doesn't match the condition.
+	if rec := preview(campURL); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Pro plan") {
+		t.Errorf("unexpected dummy preview %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The real subscriber's data is used with the links disarmed.
+	for _, target := range []string{campURL + "/" + strconv.Itoa(subID), "/api/templates/" + strconv.Itoa(tplID) + "/preview/" + strconv.Itoa(subID)} {
+		rec := preview(target)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || !strings.Contains(body, "Real User") {
+			t.Fatalf("%s: unexpected preview %d: %s", target, rec.Code, body)
+		}
+		if !strings.Contains(body, "https://example.com/link/preview?url="+url.QueryEscape("https://example.com/subscription/")) ||
+			strings.Contains(body, `"https://example.com/subscription/`) || strings.Contains(body, "px.png") {
+			t.Errorf("%s: expected the links to be disarmed, got %s", target, body)
+		}
+	}
+	if rec := preview(campURL + "/" + strconv.Itoa(subID)); !strings.Contains(rec.Body.String(), "Pro plan") ||
+		!strings.Contains(rec.Body.String(), "https://example.com/link/preview?url="+url.QueryEscape("https://listmonk.app")) {
+		t.Errorf("expected the subscriber's attributes and a disarmed link, got %s", rec.Body.String())
+	}
+
+	// The disarmed links lead to the no-op page.
+	if rec := preview("/link/preview?url=" + url.QueryEscape("https://listmonk.app")); rec.Code != http.StatusOK || rec.Body.String() != "tpl:"+tplMessage {
+		t.Errorf("unexpected link preview page %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Nothing's recorded for the campaign or the subscriber.
+	for _, tbl := range []string{"campaign_views", "link_clicks", "links"} {
+		var n int
+		if err := db.Get(&n, `SELECT COUNT(*) FROM `+tbl); err != nil || n != 0 {
+			t.Errorf("expected no %s, got %d: %v", tbl, n, err)
+		}
+	}
+	var status string
+	if err := db.Get(&status, `SELECT status FROM subscribers WHERE id = $1`, subID); err != nil || status != models.SubscriberStatusEnabled {
+		t.Errorf("expected the subscriber to be unchanged, got %s: %v", status, err)
+	}
+
+	// Invalid and unknown subscribers.
+	if rec := preview(campURL + "/abc"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an invalid ID to be refused, got %d", rec.Code)
+	}
+	if rec := preview(campURL + "/" + strconv.Itoa(subID+1)); rec.Code == http.StatusOK {
+		t.Errorf("expected an unknown subscriber to fail, got %d", rec.Code)
+	}
+
+	// Users can only preview with subscribers on the lists they can access.
+	user = auth.User{PermissionsMap: map[string]struct{}{auth.PermCampaignsGetAll: {}}}
+	if rec := preview(campURL + "/" + strconv.Itoa(subID)); rec.Code != http.StatusForbidden {
+		t.Errorf("expected the subscriber to be forbidden, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestValidateCampaignReplyTo(t *testing.T) {
+	a := newTestApp(t)
+	a.importer = subimporter.New(subimporter.Options{}, nil, a.i18n, a.log)
+	a.manager = manager.New(manager.Config{}, nil, a.i18n, log.New(io.Discard, "", 0))
+	if err := a.manager.AddMessenger(testMessenger{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		replyTo string
+		exp     string
+		ok      bool
+	}{
+		{"", "", true},
+		{" reply@example.com ", "reply@example.com", true},
+		{"Replies <reply@example.com>", "Replies <reply@example.com>", true},
+		{"reply", "", false},
+		{"Replies <reply>", "", false},
+	}
+	for _, c := range cases {
+		out, err := a.validateCampaignFields(campReq{
+			Campaign: models.Campaign{
+				Name:      "test",
+				Subject:   "Hello",
+				FromEmail: "News <news@example.com>",
+				ReplyTo:   c.replyTo,
+				Body:      "Hello",
+				Messenger: "email",
+			},
+			ListIDs: []int{1},
+		})
+		if !c.ok {
+			if err == nil || err.Error() != a.i18n.T("campaigns.fieldInvalidReplyTo") {
+				t.Errorf("%q: expected an invalid Reply-To error, got %v", c.replyTo, err)
+			}
+			continue
+		}
+		if err != nil || out.ReplyTo != c.exp {
+			t.Errorf("%q: expected %q, got %q: %v", c.replyTo, c.exp, out.ReplyTo, err)
+		}
+	}
+}
+
+// TestRunningCampaignLiveBounces checks that the running stats have the live
+// bounce counts of the bounce processor when they're ahead of the DB, and the
+// DB counts once the campaign is no longer tracked.
+func TestRunningCampaignLiveBounces(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.manager = manager.New(manager.Config{}, nil, a.i18n, log.New(io.Discard, "", 0))
+
+	var err error
+	a.bounce, err = bounce.New(bounce.Opt{
+		// The processed bounces haven't reached the DB yet.
+		RecordBounceCB: func(models.Bounce) error { return nil },
+	}, nil, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEcho()
+	e.GET("/api/campaigns/running/stats", a.GetRunningCampaignStats)
+
+	var (
+		id   int
+		uuid string
+	)
+	if err := db.QueryRow(`INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, to_send, sent, started_at)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com', '', 'email', 'running', 200, 100, NOW()) RETURNING id, uuid`).Scan(&id, &uuid); err != nil {
+		t.Fatal(err)
+	}
+	addBounces := func(typ string, n int) {
+		t.Helper()
+		if _, err := db.Exec(`WITH s AS (INSERT INTO subscribers (uuid, email, name) SELECT gen_random_uuid(), gen_random_uuid() || '@example.com', 'Sub' FROM generate_series(1, $3) RETURNING id)
+			INSERT INTO bounces (subscriber_id, campaign_id, type) SELECT s.id, $1, $2::bounce_type FROM s`, id, typ, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	getStats := func() models.CampaignStats {
+		t.Helper()
+
+		rec := doForm(e, http.MethodGet, "/api/campaigns/running/stats", nil)
+		var res struct {
+			Data []models.CampaignStats `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || len(res.Data) != 1 {
+			t.Fatalf("unexpected response %d: %v: %s", rec.Code, err, rec.Body.String())
+		}
+		return res.Data[0]
+	}
+
+	// Two bounces in the DB before the campaign (resumes and) is tracked.
+	addBounces("hard", 2)
+	if s := getStats(); s.Bounces != 2 || s.LiveBounces != nil || s.BounceRate != 2 {
+		t.Fatalf("expected the DB counts without tracking, got %+v", s)
+	}
+
+	counts, err := a.core.GetCampaignBounceTypes(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts != (models.BounceCounts{Hard: 2, Total: 2}) {
+		t.Fatalf("unexpected DB counts %+v", counts)
+	}
+	a.bounce.TrackCampaign(uuid, counts)
+
+	// Processed bounces show up before they're in the DB.
+	for i, typ := range []string{models.BounceTypeSoft, models.BounceTypeSoft, models.BounceTypeComplaint} {
+		if err := a.bounce.ProcessBounce(models.Bounce{Email: "b" + strconv.Itoa(i) + "@example.com", CampaignUUID: uuid, Type: typ}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := getStats()
+	if exp := (models.BounceCounts{Hard: 2, Soft: 2, Complaint: 1, Total: 5}); s.LiveBounces == nil || *s.LiveBounces != exp {
+		t.Fatalf("expected the live counts %+v, got %+v", exp, s.LiveBounces)
+	}
+	if s.Bounces != 5 || s.BounceRate != 5 || s.Delivered != 95 {
+		t.Errorf("expected the stats with the live counts, got %+v", s)
+	}
+
+	// When the DB is ahead, eg: bounces recorded by another instance, it's used.
+	addBounces("soft", 5)
+	if s := getStats(); s.Bounces != 7 || s.LiveBounces.Total != 5 || s.BounceRate != 7 {
+		t.Errorf("expected the DB counts, got %+v", s)
+	}
+
+	// Once the campaign stops, the DB counts are reconciled with the live counts.
+	a.bounce.UntrackCampaign(uuid)
+	if s := getStats(); s.Bounces != 7 || s.LiveBounces != nil {
+		t.Errorf("expected the DB counts, got %+v", s)
+	}
+	if counts, err := a.core.GetCampaignBounceTypes(id); err != nil || counts != (models.BounceCounts{Hard: 2, Soft: 5, Total: 7}) {
+		t.Errorf("unexpected DB counts %+v: %v", counts, err)
+	}
+}
+
+// TestResendToNonOpeners resends a finished campaign and checks that the copy
+// is only sent to the parent's recipients who haven't engaged with it since.
+func TestResendToNonOpeners(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.importer = subimporter.New(subimporter.Options{}, nil, a.i18n, a.log)
+	a.manager = manager.New(manager.Config{}, nil, a.i18n, log.New(io.Discard, "", 0))
+	if err := a.manager.AddMessenger(testMessenger{}); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEcho()
+	e.POST("/api/campaigns/:id/resend-to-non-openers", hasID(a.ResendCampaignToNonOpeners), func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+			return next(c)
+		}
+	})
+	resend := func(id int, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/campaigns/"+strconv.Itoa(id)+"/resend-to-non-openers", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	var listID int
+	if err := db.Get(&listID, `INSERT INTO lists (uuid, name, type, optin) VALUES (gen_random_uuid(), 'list', 'public', 'single') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	newCamp := func(name, status, tracking string) int {
+		var id int
+		if err := db.Get(&id, `WITH c AS (INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, tracking_mode, started_at)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', 'Hello', 'email', $2, $3, NOW() - INTERVAL '1 day') RETURNING id)
+			INSERT INTO campaign_lists (campaign_id, list_id, list_name) SELECT id, $4, 'list' FROM c RETURNING campaign_id`, name, status, tracking, listID); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	parentID := newCamp("parent", models.CampaignStatusFinished, "full")
+
+	subs := map[string]int{}
+	newSub := func(name, subStatus string, sent bool) {
+		var id int
+		if err := db.Get(&id, `WITH s AS (INSERT INTO subscribers (uuid, email, name, status) VALUES (gen_random_uuid(), $1 || '@example.com', $1, 'enabled') RETURNING id)
+			INSERT INTO subscriber_lists (subscriber_id, list_id, status) SELECT id, $2, $3 FROM s RETURNING subscriber_id`, name, listID, subStatus); err != nil {
+			t.Fatal(err)
+		}
+		if sent {
+			if _, err := db.Exec(`INSERT INTO campaign_sends (campaign_id, subscriber_id, messenger) VALUES ($1, $2, 'email')`, parentID, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		subs[name] = id
+	}
+	for _, s := range []string{"fresh", "viewer", "botviewer", "clicker", "bounced", "oldbounce"} {
+		newSub(s, models.SubscriptionStatusConfirmed, true)
+	}
+	newSub("unsub", models.SubscriptionStatusUnsubscribed, true)
+	newSub("unsent", models.SubscriptionStatusConfirmed, false)
+	if _, err := db.Exec(`UPDATE campaigns SET max_subscriber_id = (SELECT MAX(id) FROM subscribers) WHERE id = $1`, parentID); err != nil {
+		t.Fatal(err)
+	}
+	newSub("late", models.SubscriptionStatusConfirmed, false)
+
+	var linkID int
+	if err := db.Get(&linkID, `INSERT INTO links (uuid, url) VALUES (gen_random_uuid(), 'https://listmonk.app') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range []struct {
+		query string
+		sub   string
+	}{
+		{`INSERT INTO campaign_views (campaign_id, subscriber_id) VALUES ($1, $2)`, "viewer"},
+		{`INSERT INTO campaign_views (campaign_id, subscriber_id, is_bot) VALUES ($1, $2, true)`, "botviewer"},
+		{`INSERT INTO link_clicks (campaign_id, subscriber_id, link_id) VALUES ($1, $2, ` + strconv.Itoa(linkID) + `)`, "clicker"},
+		{`INSERT INTO bounces (campaign_id, subscriber_id, type) VALUES ($1, $2, 'soft')`, "bounced"},
+		{`INSERT INTO bounces (campaign_id, subscriber_id, type, created_at) VALUES ($1, $2, 'soft', NOW() - INTERVAL '2 days')`, "oldbounce"},
+	} {
+		if _, err := db.Exec(q.query, parentID, subs[q.sub]); err != nil {
+			t.Fatalf("%s: %v", q.sub, err)
+		}
+	}
+
+	// Only finished campaigns with tracking can be resent, with a new subject.
+	if rec := resend(newCamp("running", models.CampaignStatusRunning, "full"), `{"subject": "Again"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an unfinished campaign to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := resend(newCamp("untracked", models.CampaignStatusFinished, "none"), `{"subject": "Again"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an untracked campaign to be refused, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := resend(parentID, `{"subject": "  "}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a subject to be required, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := resend(parentID, `{"subject": "Did you miss this?"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var res struct {
+		Data models.Campaign `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	child := res.Data
+	if child.Status != models.CampaignStatusDraft || child.Subject != "Did you miss this?" || child.Body != "Hello" ||
+		child.ParentID.Int != parentID || child.Name == "" {
+		t.Fatalf("unexpected resend campaign %+v", child)
+	}
+
+	// The audience is computed when the campaign runs.
+	if _, err := db.Exec(`UPDATE campaigns SET status = 'running' WHERE id = $1`, child.ID); err != nil {
+		t.Fatal(err)
+	}
+	st := newManagerStore(db.DB, db.Q, a.core, nil, false, 0)
+	camps, err := st.NextCampaigns(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var toSend int
+	for _, c := range camps {
+		if c.ID == child.ID {
+			toSend = c.ToSend
+		}
+	}
+
+	out, err := st.NextSubscribers(child.ID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, s := range out {
+		names = append(names, s.Name)
+	}
+
+	// Recipients who opened (not bots) or clicked, bounced since it was sent,
+	// unsubscribed, weren't sent to, or subscribed later are excluded.
+	exp := []string{"fresh", "botviewer", "oldbounce"}
+	if !slices.Equal(names, exp) {
+		t.Errorf("expected %v, got %v", exp, names)
+	}
+	if toSend != len(exp) {
+		t.Errorf("expected to_send %d, got %d", len(exp), toSend)
+	}
+}
+
+func TestGetUnsubscribeReasons(t *testing.T) {
+	a, db := newTestAppDB(t)
+
+	var subID, campID int
+	if err := db.Get(&subID, `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), 'sub@example.com', 'sub') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&campID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com', '', 'email') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO unsubscribe_events (subscriber_id, campaign_id, reason, comment, created_at) VALUES
+		($1, $2, 'Too frequent', 'Too many', NOW()), ($1, $2, 'Not relevant', '', NOW() - INTERVAL '60 days'), ($1, NULL, 'Too frequent', 'Old', NOW() - INTERVAL '10 days')`, subID, campID); err != nil {
+		t.Fatal(err)
+	}
+
+	setUser := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+			return next(c)
+		}
+	}
+	e := newTestEcho()
+	e.GET("/api/campaigns/:id/unsubscribe-reasons", hasID(a.GetCampaignUnsubscribeReasons), setUser)
+	e.GET("/api/dashboard/unsubscribe-reasons", a.GetDashboardUnsubscribeReasons, setUser)
+
+	get := func(target string) (int, models.UnsubscribeReasonStats) {
+		t.Helper()
+
+		rec := doForm(e, http.MethodGet, target, nil)
+		var out struct {
+			Data models.UnsubscribeReasonStats `json:"data"`
+		}
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec.Code, out.Data
+	}
+
+	// All of a campaign's reasons with its comments.
+	code, out := get("/api/campaigns/" + strconv.Itoa(campID) + "/unsubscribe-reasons")
+	if code != http.StatusOK || out.Total != 2 || !strings.Contains(string(out.Comments), "Too many") {
+		t.Errorf("unexpected campaign reasons %d %+v", code, out)
+	}
+
+	// The dashboard has all the campaigns' reasons for the last 30 days by default
+	// without the comments.
+	code, out = get("/api/dashboard/unsubscribe-reasons")
+	if code != http.StatusOK || out.Total != 2 || string(out.Comments) != "[]" {
+		t.Errorf("unexpected dashboard reasons %d %+v", code, out)
+	}
+	if code, out = get("/api/dashboard/unsubscribe-reasons?days=7"); code != http.StatusOK || out.Total != 1 {
+		t.Errorf("expected the last 7 days' reasons, got %d %+v", code, out)
+	}
+	if code, out = get("/api/dashboard/unsubscribe-reasons?days=90"); code != http.StatusOK || out.Total != 3 {
+		t.Errorf("expected the last 90 days' reasons, got %d %+v", code, out)
+	}
+	for _, d := range []string{"0", "-1", "3651", "week"} {
+		if code, _ := get("/api/dashboard/unsubscribe-reasons?days=" + d); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", d, code)
+		}
+	}
+}
+
+func TestValidateCampaignExcludeLists(t *testing.T) {
+	a := newTestApp(t)
+	a.importer = subimporter.New(subimporter.Options{}, nil, a.i18n, a.log)
+	a.manager = manager.New(manager.Config{}, nil, a.i18n, log.New(io.Discard, "", 0))
+	if err := a.manager.AddMessenger(testMessenger{}); err != nil {
+		t.Fatal(err)
+	}
+
+	validate := func(typ string, lists, exclude []int) (campReq, error) {
+		return a.validateCampaignFields(campReq{
+			Campaign: models.Campaign{
+				Type:      typ,
+				Name:      "test",
+				Subject:   "Hello",
+				FromEmail: "News <news@example.com>",
+				Body:      "Hello",
+				Messenger: "email",
+			},
+			ListIDs:        lists,
+			ExcludeListIDs: exclude,
+		})
+	}
+
+	if out, err := validate(models.CampaignTypeRegular, []int{1, 2}, []int{3}); err != nil || !slices.Equal(out.ExcludeListIDs, []int{3}) {
+		t.Errorf("expected the excluded lists to be valid, got %v: %v", out.ExcludeListIDs, err)
+	}
+
+	// A list can't be both included and excluded.
+	if _, err := validate(models.CampaignTypeRegular, []int{1, 2}, []int{3, 2}); err == nil || err.Error() != a.i18n.T("campaigns.fieldInvalidExcludeLists") {
+		t.Errorf("expected an invalid excluded lists error, got %v", err)
+	}
+
+	// Ad-hoc campaigns have no lists to exclude from.
+	if out, err := validate(models.CampaignTypeAdhoc, nil, []int{3}); err != nil || out.ExcludeListIDs == nil || len(out.ExcludeListIDs) != 0 {
+		t.Errorf("expected the excluded lists to be cleared, got %v: %v", out.ExcludeListIDs, err)
+	}
+}
+
+// TestCampaignExcludeLists checks that subscribers on an excluded list are never
+// fetched for a campaign, even if they're also on one of its lists, and that they're
+// counted as excluded.
+func TestCampaignExcludeLists(t *testing.T) {
+	a, db := newTestAppDB(t)
+
+	newList := func(name string) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO lists (uuid, name, type, optin) VALUES (gen_random_uuid(), $1, 'public', 'single') RETURNING id`, name); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	var (
+		newsletter = newList("Newsletter")
+		customers  = newList("Customers")
+		other      = newList("Other")
+	)
+
+	subs := map[string]int{}
+	newSub := func(name string, lists map[int]string) {
+		var id int
+		if err := db.Get(&id, `INSERT INTO subscribers (uuid, email, name, status) VALUES (gen_random_uuid(), $1 || '@example.com', $1, 'enabled') RETURNING id`, name); err != nil {
+			t.Fatal(err)
+		}
+		for l, status := range lists {
+			if _, err := db.Exec(`INSERT INTO subscriber_lists (subscriber_id, list_id, status) VALUES ($1, $2, $3)`, id, l, status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		subs[name] = id
+	}
+	newSub("reader", map[int]string{newsletter: models.SubscriptionStatusConfirmed})
+	newSub("customer", map[int]string{newsletter: models.SubscriptionStatusConfirmed, customers: models.SubscriptionStatusConfirmed})
+	newSub("excustomer", map[int]string{newsletter: models.SubscriptionStatusConfirmed, customers: models.SubscriptionStatusUnsubscribed})
+	newSub("onlycustomer", map[int]string{customers: models.SubscriptionStatusConfirmed})
+	newSub("both", map[int]string{newsletter: models.SubscriptionStatusConfirmed, other: models.SubscriptionStatusConfirmed})
+
+	var campID int
+	if err := db.Get(&campID, `WITH c AS (INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com', 'Hello', 'email') RETURNING id)
+		INSERT INTO campaign_lists (campaign_id, list_id, list_name) SELECT id, $1, 'Newsletter' FROM c RETURNING campaign_id`, newsletter); err != nil {
+		t.Fatal(err)
+	}
+
+	excluded := func() []int {
+		t.Helper()
+
+		c, err := a.core.GetCampaign(campID, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lists []struct {
+			ID int `json:"id"`
+		}
+		if err := c.ExcludeLists.Unmarshal(&lists); err != nil {
+			t.Fatal(err)
+		}
+		out := []int{}
+		for _, l := range lists {
+			out = append(out, l.ID)
+		}
+		slices.Sort(out)
+		return out
+	}
+	update := func(exclude []int) {
+		t.Helper()
+
+		c, err := a.core.GetCampaign(campID, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := a.core.UpdateCampaign(campID, c, []int{newsletter}, exclude, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The excluded lists are set on updates and retained if they're not given.
+	update([]int{customers, other})
+	if ex := excluded(); !slices.Equal(ex, []int{customers, other}) {
+		t.Fatalf("unexpected excluded lists %v", ex)
+	}
+	update([]int{customers})
+	update(nil)
+	if ex := excluded(); !slices.Equal(ex, []int{customers}) {
+		t.Fatalf("expected the excluded lists to be retained, got %v", ex)
+	}
+
+	// Subscribers on the excluded list, whatever their subscription status on it,
+	// aren't counted or fetched.
+	if _, err := db.Exec(`UPDATE campaigns SET status = 'running' WHERE id = $1`, campID); err != nil {
+		t.Fatal(err)
+	}
+	st := newManagerStore(db.DB, db.Q, a.core, nil, false, 0)
+	camps, err := st.NextCampaigns(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(camps) != 1 || camps[0].ToSend != 2 || camps[0].Excluded != 2 {
+		t.Fatalf("expected 2 to send and 2 excluded, got %+v", camps)
+	}
+
+	out, err := st.NextSubscribers(campID, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []int{}
+	for _, s := range out {
+		got = append(got, int(s.ID))
+	}
+	exp := []int{subs["reader"], subs["both"]}
+	slices.Sort(got)
+	slices.Sort(exp)
+	if !slices.Equal(got, exp) {
+		t.Errorf("expected subscribers %v, got %v", exp, got)
+	}
+
+	// Removing the exclusions includes everyone on the lists.
+	update([]int{})
+	if ex := excluded(); len(ex) != 0 {
+		t.Fatalf("expected no excluded lists, got %v", ex)
+	}
+}
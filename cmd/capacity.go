@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	"github.com/knadh/listmonk/internal/capacity"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/notifs"
+)
+
+// Interval at which the storage usage is checked.
+const capacityInterval = time.Minute * 15
+
+// storageAlertTpl is the data of the storage usage alert notification template.
+type storageAlertTpl struct {
+	capacity.Usage
+
+	MediaExceeded bool
+	DBExceeded    bool
+	MediaFreeMB   uint64
+	DBSizeMB      int64
+}
+
+// initCapacityChecker initializes the checker that periodically checks the space
+// used on the media filesystem and the size of the database, and sends alerts to
+// the admin notification e-mails when they cross their thresholds.
+func initCapacityChecker(co *core.Core, i *i18n.I18n) *capacity.Checker {
+	cooldown, _ := time.ParseDuration(ko.String("maintenance.storage.cooldown"))
+
+	opt := capacity.Opt{
+		Interval:      capacityInterval,
+		Cooldown:      cooldown,
+		DiskThreshold: ko.Int("maintenance.storage.disk_threshold"),
+		DBThresholdMB: ko.Int("maintenance.storage.db_threshold_mb"),
+	}
+	if ko.String("upload.provider") == "filesystem" {
+		opt.MediaPath = ko.String("upload.filesystem.upload_path")
+	}
+
+	notify := func(u capacity.Usage) error {
+		data := storageAlertTpl{
+			Usage:         u,
+			MediaExceeded: slices.Contains(u.Exceeded, capacity.ResourceMedia),
+			DBExceeded:    slices.Contains(u.Exceeded, capacity.ResourceDB),
+			DBSizeMB:      u.DBSize / 1024 / 1024,
+		}
+		if u.Media != nil {
+			data.MediaFreeMB = u.Media.Free / 1024 / 1024
+		}
+
+		return notifs.NotifySystem(i.T("email.storageAlert.title"), notifs.TplStorageAlert, data, nil)
+	}
+
+	return capacity.New(opt, co, notify, lo)
+}
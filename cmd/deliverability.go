@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// Version of the deliverability config document. It's incremented on
+// incompatible changes to the keys or their values.
+const deliverabilitySchema = 1
+
+// deliverabilityKeys are the settings keys in the deliverability config: bounce
+// classification and processing, bounce webhook providers, and messenger routing.
+// Bounce mailboxes are specific to an instance and aren't included.
+var deliverabilityKeys = []string{
+	"bounce.enabled",
+	"bounce.webhooks_enabled",
+	"bounce.webhooks_log_only",
+	"bounce.actions",
+	"bounce.ses_enabled",
+	"bounce.sendgrid_enabled",
+	"bounce.sendgrid_key",
+	"bounce.postmark",
+	"bounce.forwardemail",
+	"bounce.reply_unsubscribe",
+	"bounce.verp",
+	"app.messenger_routes",
+}
+
+// Secret values in the config are references to environment variables, eg: ${BOUNCE_VERP_SECRET}.
+var reEnvPlaceholder = regexp.MustCompile(`^\$\{([A-Za-z_][A-Za-z0-9_]*)\}$`)
+
+// deliverabilitySecrets returns the secrets in the deliverability config mapped
+// to the environment variables that they're exported as references to.
+func deliverabilitySecrets(s *models.Settings) map[string]*string {
+	return map[string]*string{
+		"BOUNCE_SENDGRID_KEY":      &s.SendgridKey,
+		"BOUNCE_POSTMARK_PASSWORD": &s.BouncePostmark.Password,
+		"BOUNCE_FORWARDEMAIL_KEY":  &s.BounceForwardEmail.Key,
+		"BOUNCE_VERP_SECRET":       &s.BounceVERP.Secret,
+	}
+}
+
+// GetDeliverabilityConfig returns the deliverability config document. Secrets are
+// never exported and are replaced with references to environment variables.
+func (a *App) GetDeliverabilityConfig(c echo.Context) error {
+	s, err := a.reqCore(c).GetSettings()
+	if err != nil {
+		return err
+	}
+
+	out, err := makeDeliverabilityConfig(s)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateDeliverabilityConfig applies a deliverability config document over the
+// current settings. The document is validated and saved as a whole like in UpdateSettings.
+func (a *App) UpdateDeliverabilityConfig(c echo.Context) error {
+	var doc map[string]json.RawMessage
+	if err := c.Bind(&doc); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
+	}
+
+	cur, err := a.reqCore(c).GetSettings()
+	if err != nil {
+		return err
+	}
+
+	set, err := a.applyDeliverabilityConfig(cur, doc)
+	if err != nil {
+		return err
+	}
+
+	return a.saveSettings(c, cur, set)
+}
+
+// applyDeliverabilityConfigFile validates and saves the deliverability config in
+// the given file. It's used to bootstrap new instances with --apply-deliverability-config.
+func (a *App) applyDeliverabilityConfigFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+
+	cur, err := a.core.GetSettings()
+	if err != nil {
+		return err
+	}
+
+	set, err := a.applyDeliverabilityConfig(cur, doc)
+	if err != nil {
+		return err
+	}
+
+	_, err = a.core.UpdateSettings(set)
+	return err
+}
+
+// applyDeliverabilityConfig returns the current settings with the given config
+// document applied and validated. Secrets that reference environment variables
+// are resolved, and secrets that are empty or whose variables aren't set are
+// retained from the current settings.
+func (a *App) applyDeliverabilityConfig(cur models.Settings, doc map[string]json.RawMessage) (models.Settings, error) {
+	var schema int
+	if err := json.Unmarshal(doc["schema"], &schema); err != nil || schema != deliverabilitySchema {
+		return cur, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "schema"))
+	}
+	delete(doc, "schema")
+
+	for k := range doc {
+		if !slices.Contains(deliverabilityKeys, k) {
+			return cur, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", k))
+		}
+	}
+
+	// Decode the document over a copy of the current settings made via JSON so
+	// that the decoding doesn't overwrite the maps and slices of cur.
+	base, err := json.Marshal(cur)
+	if err != nil {
+		return cur, echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(base, &m); err != nil {
+		return cur, echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+	for k, v := range doc {
+		m[k] = v
+	}
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return cur, echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+	var set models.Settings
+	if err := json.Unmarshal(b, &set); err != nil {
+		return cur, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "deliverability: "+err.Error()))
+	}
+
+	for _, v := range deliverabilitySecrets(&set) {
+		if p := reEnvPlaceholder.FindStringSubmatch(*v); p != nil {
+			*v = os.Getenv(p[1])
+		}
+	}
+	// The document is applied over cur, so the save is checked against cur's version.
+	set.Version = cur.Version
+	unmaskSettings(&set)
+
+	return a.validateSettings(cur, set)
+}
+
+// makeDeliverabilityConfig returns the deliverability config document of the given
+// settings with the secrets replaced with references to environment variables.
+func makeDeliverabilityConfig(s models.Settings) (map[string]json.RawMessage, error) {
+	for env, v := range deliverabilitySecrets(&s) {
+		if *v != "" {
+			*v = "${" + env + "}"
+		}
+	}
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+
+	out := map[string]json.RawMessage{
+		"schema": json.RawMessage(strconv.Itoa(deliverabilitySchema)),
+	}
+	for _, k := range deliverabilityKeys {
+		out[k] = m[k]
+	}
+
+	return out, nil
+}
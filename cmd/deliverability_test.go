@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+func TestMakeDeliverabilityConfig(t *testing.T) {
+	var s models.Settings
+	s.AppSiteName = "site"
+	s.BounceEnabled = true
+	s.SendgridKey = "sendgrid-key"
+	s.BounceVERP.Secret = "verp-secret"
+	s.AppMessengerRoutes = []models.MessengerRoute{{Domain: "example.com", Messenger: "email"}}
+
+	doc, err := makeDeliverabilityConfig(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the deliverability keys are exported.
+	if len(doc) != len(deliverabilityKeys)+1 {
+		t.Errorf("expected the deliverability keys, got %d keys", len(doc))
+	}
+	for k := range doc {
+		if k != "schema" && !slices.Contains(deliverabilityKeys, k) {
+			t.Errorf("unexpected key %s", k)
+		}
+	}
+	if string(doc["schema"]) != "1" || string(doc["bounce.enabled"]) != "true" ||
+		string(doc["app.messenger_routes"]) != `[{"domain":"example.com","messenger":"email"}]` {
+		t.Errorf("unexpected config %s %s %s", doc["schema"], doc["bounce.enabled"], doc["app.messenger_routes"])
+	}
+
+	// Secrets are exported as references to environment variables, and empty ones as is.
+	b, _ := json.Marshal(doc)
+	for _, v := range []string{"sendgrid-key", "verp-secret"} {
+		if strings.Contains(string(b), v) {
+			t.Errorf("secret %s is in the config", v)
+		}
+	}
+	if string(doc["bounce.sendgrid_key"]) != `"${BOUNCE_SENDGRID_KEY}"` ||
+		!strings.Contains(string(doc["bounce.verp"]), `"secret":"${BOUNCE_VERP_SECRET}"`) ||
+		!strings.Contains(string(doc["bounce.forwardemail"]), `"key":""`) {
+		t.Errorf("unexpected secrets %s %s %s", doc["bounce.sendgrid_key"], doc["bounce.verp"], doc["bounce.forwardemail"])
+	}
+
+	// The settings aren't changed.
+	if s.SendgridKey != "sendgrid-key" || s.BounceVERP.Secret != "verp-secret" {
+		t.Errorf("expected the settings to be unchanged, got %+v", s)
+	}
+}
+
+func TestDeliverabilityConfig(t *testing.T) {
+	a, _ := newTestAppDB(t)
+
+	// Save the settings once so that they have a version.
+	cur, err := a.core.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur.SendgridKey = "sendgrid-key"
+	cur.BounceForwardEmail.Key = "forwardemail-key"
+	if _, err := a.core.UpdateSettings(cur); err != nil {
+		t.Fatal(err)
+	}
+	if cur, err = a.core.GetSettings(); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEcho()
+	e.GET("/api/config/deliverability", a.GetDeliverabilityConfig)
+
+	rec := doForm(e, http.MethodGet, "/api/config/deliverability", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := resp.Data["smtp"]; ok || strings.Contains(rec.Body.String(), "sendgrid-key") {
+		t.Errorf("unexpected config %s", rec.Body.String())
+	}
+
+	apply := func(doc map[string]json.RawMessage) (models.Settings, error) {
+		t.Helper()
+
+		// Apply a copy as the keys are removed from the document.
+		d := make(map[string]json.RawMessage, len(doc))
+		for k, v := range doc {
+			d[k] = v
+		}
+		return a.applyDeliverabilityConfig(cur, d)
+	}
+
+	// The exported config applies as is. Secrets whose variables aren't set are retained.
+	set, err := apply(resp.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.SendgridKey != "sendgrid-key" || set.BounceForwardEmail.Key != "forwardemail-key" {
+		t.Errorf("expected the secrets to be retained, got %q %q", set.SendgridKey, set.BounceForwardEmail.Key)
+	}
+	if set.Version != cur.Version {
+		t.Errorf("expected the current version %d, got %d", cur.Version, set.Version)
+	}
+	for _, k := range changedSettingsKeys(cur, set) {
+		if slices.Contains(deliverabilityKeys, k) {
+			t.Errorf("expected %s to be unchanged", k)
+		}
+	}
+
+	// Secrets are resolved from the environment, and the other settings are retained.
+	t.Setenv("BOUNCE_SENDGRID_KEY", "env-key")
+	doc := resp.Data
+	doc["bounce.enabled"] = json.RawMessage(`true`)
+	doc["app.messenger_routes"] = json.RawMessage(`[{"domain": " *.Example.com ", "messenger": "email"}]`)
+	set, err = apply(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if set.SendgridKey != "env-key" || !set.BounceEnabled || set.AppSiteName != cur.AppSiteName || len(set.SMTP) != len(cur.SMTP) ||
+		!slices.Equal(set.AppMessengerRoutes, []models.MessengerRoute{{Domain: "*.example.com", Messenger: "email"}}) {
+		t.Errorf("unexpected settings %+v", set)
+	}
+
+	// Invalid documents are rejected.
+	for name, kv := range map[string][2]string{
+		"no schema":         {"schema", ``},
+		"unknown schema":    {"schema", `2`},
+		"unknown key":       {"smtp", `[]`},
+		"invalid value":     {"bounce.enabled", `"yes"`},
+		"unknown messenger": {"app.messenger_routes", `[{"domain": "example.com", "messenger": "nope"}]`},
+	} {
+		d := map[string]json.RawMessage{"schema": json.RawMessage(`1`)}
+		if kv[1] == "" {
+			delete(d, kv[0])
+		} else {
+			d[kv[0]] = json.RawMessage(kv[1])
+		}
+
+		_, err := apply(d)
+		if er, ok := err.(*echo.HTTPError); !ok || er.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %d, got %v", name, http.StatusBadRequest, err)
+		}
+	}
+
+	// A config file is applied and saved over settings that have been saved before.
+	b, _ := json.Marshal(map[string]any{"schema": 1, "bounce.enabled": true, "bounce.sendgrid_key": "${BOUNCE_SENDGRID_KEY}"})
+	path := filepath.Join(t.TempDir(), "deliverability.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.applyDeliverabilityConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := a.core.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.BounceEnabled || saved.SendgridKey != "env-key" || saved.BounceForwardEmail.Key != "forwardemail-key" || saved.Version == cur.Version {
+		t.Errorf("expected the config to be saved, got %+v", saved)
+	}
+
+	// Invalid files aren't.
+	if err := os.WriteFile(path, []byte(`{"schema": 1, "bounce.enabled": `), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.applyDeliverabilityConfigFile(path); err == nil {
+		t.Error("expected an invalid file to fail")
+	}
+	if err := a.applyDeliverabilityConfigFile(filepath.Join(t.TempDir(), "nope.json")); err == nil {
+		t.Error("expected a missing file to fail")
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gdgvda/cron"
+	"github.com/knadh/listmonk/internal/digest"
+	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Number of the latest digest runs returned by the status endpoint.
+const digestRunsLimit = 10
+
+// digestStatus represents the schedule and the latest runs of the digest report.
+type digestStatus struct {
+	Enabled  bool               `json:"enabled"`
+	Schedule string             `json:"schedule"`
+	NextRun  null.Time          `json:"next_run"`
+	Runs     []models.DigestRun `json:"runs"`
+}
+
+// initDigest initializes the periodic activity digest report that's scheduled
+// by the cron in initCron().
+func initDigest(st digest.Store, notify digest.Notifier) *digest.Digest {
+	var opt digest.Opt
+	if err := ko.Unmarshal("app.digest", &opt.DigestSettings); err != nil {
+		lo.Fatalf("error loading app.digest config: %v", err)
+	}
+	opt.DefaultRecipients = ko.Strings("app.notify_emails")
+
+	return digest.New(opt, st, notify, lo)
+}
+
+// notifyDigest returns the notifier that e-mails the digest report.
+func notifyDigest(i *i18n.I18n) digest.Notifier {
+	return func(toEmails []string, r digest.Report) error {
+		return notifs.Notify(toEmails, i.T("email.digest.title"), notifs.TplDigest, r, nil)
+	}
+}
+
+// digestNextRun returns the time of the next run of the digest report after now.
+func digestNextRun(schedule string, now time.Time) (time.Time, error) {
+	s, err := cron.ParseStandard(schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return s.Next(now), nil
+}
+
+// GetDigest returns the schedule and the latest runs of the digest report.
+func (a *App) GetDigest(c echo.Context) error {
+	runs, err := a.reqCore(c).GetDigestRuns(digestRunsLimit)
+	if err != nil {
+		return err
+	}
+
+	out := digestStatus{
+		Enabled:  ko.Bool("app.digest.enabled"),
+		Schedule: ko.String("app.digest.schedule"),
+		Runs:     runs,
+	}
+	if out.Enabled {
+		if t, err := digestNextRun(out.Schedule, time.Now()); err == nil {
+			out.NextRun = null.TimeFrom(t)
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// SendDigest builds and sends the digest report for the period ending today
+// right away, irrespective of the schedule.
+func (a *App) SendDigest(c echo.Context) error {
+	run, err := a.digest.Run(time.Now())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("email.digest.errorSending", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{run})
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/knadh/koanf/providers/confmap"
+	"github.com/knadh/listmonk/internal/digest"
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/models"
+)
+
+// digestStore is a digest.Store that records the runs.
+type digestStore struct {
+	runs []models.DigestRun
+}
+
+func (s *digestStore) GetDigestStats(from, to time.Time, topCampaigns int) (models.DigestStats, error) {
+	return models.DigestStats{}, nil
+}
+
+func (s *digestStore) RecordDigestRun(r models.DigestRun) error {
+	s.runs = append(s.runs, r)
+	return nil
+}
+
+func TestInitDigest(t *testing.T) {
+	if err := ko.Load(confmap.Provider(map[string]any{
+		"app.digest.enabled":     true,
+		"app.digest.schedule":    "0 8 * * 1",
+		"app.digest.period_days": 7,
+		"app.digest.recipients":  []string{},
+		"app.digest.sections":    []string{models.DigestSectionCampaigns},
+		"app.notify_emails":      []string{"admin@example.com"},
+	}, "."), nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		ko.Delete("app.digest")
+		ko.Delete("app.notify_emails")
+	})
+
+	var (
+		st   = &digestStore{}
+		sent []string
+		rep  digest.Report
+	)
+	dg := initDigest(st, func(to []string, r digest.Report) error {
+		sent, rep = to, r
+		return nil
+	})
+
+	// The scheduled Monday morning run covers the previous week.
+	now, err := digestNextRun(ko.String("app.digest.schedule"), time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC); !now.Equal(exp) {
+		t.Fatalf("expected the next run at %v, got %v", exp, now)
+	}
+	run, err := dg.Run(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		from = time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+		to   = time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+	)
+	if !run.PeriodStart.Equal(from) || !run.PeriodEnd.Equal(to) || run.Status != models.DigestRunSent {
+		t.Errorf("unexpected run %+v", run)
+	}
+	if !slices.Equal(sent, []string{"admin@example.com"}) {
+		t.Errorf("expected the notify e-mails as the recipients, got %v", sent)
+	}
+	if !rep.Sections[models.DigestSectionCampaigns] || len(rep.Sections) != 1 {
+		t.Errorf("unexpected sections %v", rep.Sections)
+	}
+	if len(st.runs) != 1 {
+		t.Errorf("expected the run to be recorded, got %+v", st.runs)
+	}
+
+	// A failed notification is recorded as a failed run.
+	dg = initDigest(st, func([]string, digest.Report) error { return errors.New("smtp error") })
+	if run, err := dg.Run(now); err == nil || run.Status != models.DigestRunFailed || len(st.runs) != 2 {
+		t.Errorf("expected a failed run, got %+v: %v", run, err)
+	}
+}
+
+func TestDigestNextRun(t *testing.T) {
+	cases := []struct {
+		schedule string
+		now      time.Time
+		exp      time.Time
+	}{
+		// Monday, 8 AM.
+		{"0 8 * * 1", time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC), time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * 1", time.Date(2026, 3, 9, 7, 59, 59, 0, time.UTC), time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC)},
+		{"0 8 * * 1", time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC), time.Date(2026, 3, 16, 8, 0, 0, 0, time.UTC)},
+
+		// Daily and monthly.
+		{"30 6 * * *", time.Date(2026, 12, 31, 7, 0, 0, 0, time.UTC), time.Date(2027, 1, 1, 6, 30, 0, 0, time.UTC)},
+		{"0 9 1 * *", time.Date(2026, 2, 15, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC), time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		got, err := digestNextRun(c.schedule, c.now)
+		if err != nil || !got.Equal(c.exp) {
+			t.Errorf("%s at %v: expected %v, got %v: %v", c.schedule, c.now, c.exp, got, err)
+		}
+	}
+
+	for _, s := range []string{"", "every monday", "0 8 * *", "61 8 * * 1", "0 8 * * 8"} {
+		if _, err := digestNextRun(s, time.Now()); err == nil {
+			t.Errorf("%q: expected an invalid schedule", s)
+		}
+	}
+}
+
+func TestValidateDigestSettings(t *testing.T) {
+	a := newTestApp(t)
+	a.importer = subimporter.New(subimporter.Options{}, nil, a.i18n, a.log)
+
+	valid := models.DigestSettings{Enabled: true, Schedule: "0 8 * * 1", PeriodDays: 7}
+	cases := []struct {
+		name string
+		fn   func(d *models.DigestSettings)
+		ok   bool
+	}{
+		{"valid", func(d *models.DigestSettings) {}, true},
+		{"invalid schedule", func(d *models.DigestSettings) { d.Schedule = "every monday" }, false},
+		{"empty schedule", func(d *models.DigestSettings) { d.Schedule = "" }, false},
+		{"disabled with an invalid schedule", func(d *models.DigestSettings) { d.Enabled, d.Schedule = false, "every monday" }, true},
+		{"no period", func(d *models.DigestSettings) { d.PeriodDays = 0 }, false},
+		{"long period", func(d *models.DigestSettings) { d.PeriodDays = 367 }, false},
+		{"year", func(d *models.DigestSettings) { d.PeriodDays = 366 }, true},
+		{"invalid recipient", func(d *models.DigestSettings) { d.Recipients = []string{"admin"} }, false},
+		{"invalid section", func(d *models.DigestSettings) { d.Sections = []string{"nope"} }, false},
+	}
+	for _, c := range cases {
+		d := valid
+		c.fn(&d)
+		if _, err := a.validateDigestSettings(d); (err == nil) != c.ok {
+			t.Errorf("%s: expected ok %v, got %v", c.name, c.ok, err)
+		}
+	}
+
+	// Empty recipients are dropped and the sections are deduplicated.
+	d := valid
+	d.Recipients = []string{" admin@example.com ", ""}
+	d.Sections = []string{models.DigestSectionCampaigns, models.DigestSectionCampaigns, models.DigestSectionEngagement}
+	out, err := a.validateDigestSettings(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(out.Recipients, []string{"admin@example.com"}) ||
+		!slices.Equal(out.Sections, []string{models.DigestSectionCampaigns, models.DigestSectionEngagement}) {
+		t.Errorf("unexpected settings %+v", out)
+	}
+}
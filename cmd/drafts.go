@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Minimum interval between autosaves of a campaign.
+	draftMinInterval = time.Second * 2
+
+	// Maximum number of campaigns whose last autosave times are tracked.
+	// Beyond this, expired entries are evicted.
+	maxDraftLimits = 10000
+)
+
+// draftLimiter limits the rate of autosaves per campaign.
+type draftLimiter struct {
+	mut  sync.Mutex
+	last map[int]time.Time
+}
+
+func newDraftLimiter() *draftLimiter {
+	return &draftLimiter{last: make(map[int]time.Time)}
+}
+
+// allow returns true if a campaign can be autosaved now and records the time.
+func (d *draftLimiter) allow(id int) bool {
+	now := time.Now()
+
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	if t, ok := d.last[id]; ok && now.Sub(t) < draftMinInterval {
+		return false
+	}
+
+	if len(d.last) >= maxDraftLimits {
+		for k, t := range d.last {
+			if now.Sub(t) >= draftMinInterval {
+				delete(d.last, k)
+			}
+		}
+	}
+
+	d.last[id] = now
+	return true
+}
+
+// SaveCampaignDraft autosaves the unsaved subject and body of a campaign from the
+// editor. The draft is never sent and is discarded when the campaign is saved.
+func (a *App) SaveCampaignDraft(c echo.Context) error {
+	id := getID(c)
+
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	if !a.draftLimiter.allow(id) {
+		return echo.NewHTTPError(http.StatusTooManyRequests, a.i18n.T("campaigns.autosaveTooFrequent"))
+	}
+
+	var req models.CampaignDraft
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	// Larger char limit for subject as it can contain {{ go templating }} logic.
+	if len(req.Subject) > 5000 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.fieldInvalidSubject"))
+	}
+
+	ok, err := a.reqCore(c).SaveCampaignDraft(id, req)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdate"))
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// GetCampaignDraft returns the autosaved draft of a campaign.
+func (a *App) GetCampaignDraft(c echo.Context) error {
+	id := getID(c)
+
+	if err := a.checkCampaignPerm(auth.PermTypeGet, id, c); err != nil {
+		return err
+	}
+
+	out, err := a.reqCore(c).GetCampaignDraft(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// RestoreCampaignDraft promotes the autosaved draft of a campaign to its content.
+func (a *App) RestoreCampaignDraft(c echo.Context) error {
+	id := getID(c)
+
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	cm, err := a.reqCore(c).GetCampaign(id, "", "")
+	if err != nil {
+		return err
+	}
+
+	if !canEditCampaign(cm.Status) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.cantUpdate"))
+	}
+
+	out, err := a.reqCore(c).RestoreCampaignDraft(id)
+	if err != nil {
+		return err
+	}
+
+	if ok, err := a.reviewEditedCampaign(c, cm, out); err != nil {
+		return err
+	} else if ok {
+		if out, err = a.reqCore(c).GetCampaign(id, "", ""); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteCampaignDraft discards the autosaved draft of a campaign.
+func (a *App) DeleteCampaignDraft(c echo.Context) error {
+	id := getID(c)
+
+	if err := a.checkCampaignPerm(auth.PermTypeManage, id, c); err != nil {
+		return err
+	}
+
+	if err := a.reqCore(c).DeleteCampaignDraft(id); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+func TestDraftLimiter(t *testing.T) {
+	d := newDraftLimiter()
+
+	// Autosaves of a campaign are limited, independently of other campaigns.
+	if !d.allow(1) || d.allow(1) || !d.allow(2) {
+		t.Fatal("expected one autosave per campaign in the interval")
+	}
+
+	// The campaign can be autosaved again after the interval.
+	d.last[1] = time.Now().Add(-draftMinInterval)
+	if !d.allow(1) {
+		t.Error("expected the autosave to be allowed after the interval")
+	}
+
+	// Expired entries are evicted when the limiter is full.
+	d = newDraftLimiter()
+	for i := range maxDraftLimits {
+		d.last[i+1] = time.Now().Add(-draftMinInterval)
+	}
+	d.last[maxDraftLimits+1] = time.Now()
+	if !d.allow(maxDraftLimits + 2) {
+		t.Fatal("expected the autosave to be allowed")
+	}
+	if len(d.last) != 2 || d.allow(maxDraftLimits+1) {
+		t.Errorf("expected the expired entries to be evicted, got %d entries", len(d.last))
+	}
+}
+
+// TestCampaignDrafts autosaves a campaign and checks that the draft is kept apart
+// from the live content until it's restored, discarded, or the campaign is saved.
+func TestCampaignDrafts(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.draftLimiter = newDraftLimiter()
+
+	e := newTestEcho()
+	mw := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+			return next(c)
+		}
+	}
+	e.GET("/api/campaigns/:id/draft", hasID(a.GetCampaignDraft), mw)
+	e.PUT("/api/campaigns/:id/draft", hasID(a.SaveCampaignDraft), mw)
+	e.POST("/api/campaigns/:id/draft/restore", hasID(a.RestoreCampaignDraft), mw)
+	e.DELETE("/api/campaigns/:id/draft", hasID(a.DeleteCampaignDraft), mw)
+
+	do := func(method string, id int, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/campaigns/"+strconv.Itoa(id)+"/draft"+path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	save := func(id int, subject, body string) *httptest.ResponseRecorder {
+		// Reset the rate limit.
+		a.draftLimiter = newDraftLimiter()
+		b, _ := json.Marshal(models.CampaignDraft{Subject: subject, Body: body})
+		return do(http.MethodPut, id, "", string(b))
+	}
+	getDraft := func(id int) (models.CampaignDraft, int) {
+		t.Helper()
+
+		rec := do(http.MethodGet, id, "", "")
+		var res struct {
+			Data models.CampaignDraft `json:"data"`
+		}
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return res.Data, rec.Code
+	}
+	type content struct {
+		Subject   string    `db:"subject"`
+		Body      string    `db:"body"`
+		UpdatedAt time.Time `db:"updated_at"`
+	}
+	getContent := func(id int) content {
+		t.Helper()
+
+		var out content
+		if err := db.Get(&out, `SELECT subject, body, updated_at FROM campaigns WHERE id = $1`, id); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	newCamp := func(status string) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, updated_at)
+			VALUES (gen_random_uuid(), 'camp', 'Live', 'from@example.com', '<p>Live</p>', 'email', $1, NOW() - INTERVAL '1 day') RETURNING id`, status); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	id := newCamp(models.CampaignStatusDraft)
+	before := getContent(id)
+
+	// There's no draft to begin with.
+	if _, code := getDraft(id); code != http.StatusNotFound {
+		t.Fatalf("expected no draft, got %d", code)
+	}
+	if rec := do(http.MethodPost, id, "/restore", ""); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected no draft to restore, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The autosave doesn't touch the live content.
+	if rec := save(id, "Draft", "<p>Draft</p>"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the draft to be saved, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if d, code := getDraft(id); code != http.StatusOK || d.Subject != "Draft" || d.Body != "<p>Draft</p>" || d.UpdatedAt.IsZero() {
+		t.Fatalf("unexpected draft %d: %+v", code, d)
+	}
+	if c := getContent(id); c != before {
+		t.Fatalf("expected the live content to be unchanged, got %+v", c)
+	}
+
+	// Autosaves are rate limited per campaign.
+	if rec := do(http.MethodPut, id, "", `{"subject": "Again", "body": ""}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected the autosave to be rate limited, got %d", rec.Code)
+	}
+	if d, _ := getDraft(id); d.Subject != "Draft" {
+		t.Errorf("expected the rate limited autosave to be dropped, got %+v", d)
+	}
+
+	// Discarding.
+	if rec := do(http.MethodDelete, id, "", ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected the draft to be discarded, got %d", rec.Code)
+	}
+	if _, code := getDraft(id); code != http.StatusNotFound {
+		t.Errorf("expected the draft to be discarded, got %d", code)
+	}
+	if c := getContent(id); c != before {
+		t.Errorf("expected the live content to be unchanged, got %+v", c)
+	}
+
+	// Restoring promotes the draft and discards it.
+	save(id, "Restored", "<p>Restored</p>")
+	rec := do(http.MethodPost, id, "/restore", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the draft to be restored, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var res struct {
+		Data models.Campaign `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if c := getContent(id); res.Data.Subject != "Restored" || c.Subject != "Restored" || c.Body != "<p>Restored</p>" || !c.UpdatedAt.After(before.UpdatedAt) {
+		t.Errorf("expected the draft to be the content, got %+v: %+v", c, res.Data)
+	}
+	if _, code := getDraft(id); code != http.StatusNotFound {
+		t.Errorf("expected the restored draft to be discarded, got %d", code)
+	}
+
+	// An empty draft subject keeps the campaign's subject.
+	save(id, "", "<p>No subject</p>")
+	do(http.MethodPost, id, "/restore", "")
+	if c := getContent(id); c.Subject != "Restored" || c.Body != "<p>No subject</p>" {
+		t.Errorf("expected the subject to be kept, got %+v", c)
+	}
+
+	// Saving the campaign discards the draft.
+	save(id, "Unsaved", "<p>Unsaved</p>")
+	cm, err := a.core.GetCampaign(id, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.core.UpdateCampaign(id, cm, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, code := getDraft(id); code != http.StatusNotFound {
+		t.Errorf("expected the save to discard the draft, got %d", code)
+	}
+	if c := getContent(id); c.Subject != "Restored" {
+		t.Errorf("expected the draft not to be saved, got %+v", c)
+	}
+
+	// Campaigns that can't be edited can't be autosaved or restored.
+	for _, s := range []string{models.CampaignStatusRunning, models.CampaignStatusFinished, models.CampaignStatusCancelled} {
+		id := newCamp(s)
+		if rec := save(id, "Draft", "<p>Draft</p>"); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected the autosave to be refused, got %d", s, rec.Code)
+		}
+		if _, err := db.Exec(`UPDATE campaigns SET draft = '{"subject": "Draft", "body": "<p>Draft</p>"}' WHERE id = $1`, id); err != nil {
+			t.Fatal(err)
+		}
+		if rec := do(http.MethodPost, id, "/restore", ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected the restore to be refused, got %d", s, rec.Code)
+		}
+		if c := getContent(id); c.Subject != "Live" {
+			t.Errorf("%s: expected the content to be unchanged, got %+v", s, c)
+		}
+	}
+
+	// Overlong subjects.
+	if rec := save(id, strings.Repeat("a", 5001), ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected an overlong subject to be refused, got %d", rec.Code)
+	}
+}
@@ -7,16 +7,32 @@ import (
 	"path"
 	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/models"
+	"github.com/knadh/paginator"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"golang.org/x/time/rate"
 )
 
 const (
 	// stdInputMaxLen is the maximum allowed length for a standard input field.
 	stdInputMaxLen = 2000
 
+	// tagMaxLen is the maximum allowed length of a single tag and
+	// tagsMaxNum is the maximum number of tags on a campaign or template.
+	tagMaxLen  = 100
+	tagsMaxNum = 20
+
+	// listFrequencyMaxLen is the maximum allowed length of a list's frequency hint.
+	listFrequencyMaxLen = 100
+
+	// sequenceMaxSteps is the maximum number of steps in a sequence.
+	sequenceMaxSteps = 50
+
 	// URIs.
 	uriAdmin = "/admin"
 )
@@ -37,7 +53,7 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		if _, ok := err.(*echo.HTTPError); !ok {
 			a.log.Println(err.Error())
 		}
-		e.DefaultHTTPErrorHandler(err, c)
+		e.DefaultHTTPErrorHandler(redactError(c, err), c)
 	}
 
 	// Configure CORS middleware if domains are configured.
@@ -104,12 +120,24 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.GET("/api/lang/:lang", a.GetI18nLang)
 		g.GET("/api/dashboard/charts", a.GetDashboardCharts)
 		g.GET("/api/dashboard/counts", a.GetDashboardCounts)
+		g.GET("/api/dashboard/unsubscribe-reasons", a.GetDashboardUnsubscribeReasons)
 
 		g.GET("/api/settings", pm(a.GetSettings, "settings:get"))
 		g.PUT("/api/settings", pm(a.UpdateSettings, "settings:manage"))
+		g.GET("/api/settings/export", pm(a.ExportSettings, "settings:manage"))
+		g.POST("/api/settings/import", pm(a.ImportSettings, "settings:manage"))
+		g.GET("/api/config/deliverability", pm(a.GetDeliverabilityConfig, "settings:get"))
+		g.PUT("/api/config/deliverability", pm(a.UpdateDeliverabilityConfig, "settings:manage"))
+		g.GET("/api/settings/:key", pm(a.GetSettingsByKey, "settings:get"))
 		g.PUT("/api/settings/:key", pm(a.UpdateSettingsByKey, "settings:manage"))
 		g.POST("/api/settings/smtp/test", pm(a.TestSMTPSettings, "settings:manage"))
+		g.POST("/api/settings/media/test", pm(a.TestMediaSettings, "settings:manage"))
+
+		g.GET("/api/bundle/export", pm(a.ExportBundle, "settings:get"))
+		g.POST("/api/bundle/import", pm(a.ImportBundle, "settings:manage"))
+
 		g.POST("/api/admin/reload", pm(a.ReloadApp, "settings:manage"))
+		g.POST("/api/admin/reload-templates", pm(a.ReloadPublicTemplates, "settings:manage"))
 		g.GET("/api/logs", pm(a.GetLogs, "settings:get"))
 		g.GET("/api/events", pm(a.EventStream, "settings:get"))
 		g.GET("/api/about", a.GetAboutInfo)
@@ -117,6 +145,7 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.GET("/api/subscribers", pm(a.QuerySubscribers, "subscribers:get_all", "subscribers:get"))
 		g.GET("/api/subscribers/:id", pm(hasID(a.GetSubscriber), "subscribers:get_all", "subscribers:get"))
 		g.GET("/api/subscribers/:id/activity", pm(hasID(a.GetSubscriberActivity), "subscribers:get_all", "subscribers:get"))
+		g.GET("/api/subscribers/:id/sends", pm(hasID(a.GetSubscriberSends), "subscribers:get_all", "subscribers:get"))
 		g.GET("/api/subscribers/:id/export", pm(hasID(a.ExportSubscriberData), "subscribers:get_all", "subscribers:get"))
 		g.GET("/api/subscribers/:id/bounces", pm(hasID(a.GetSubscriberBounces), "bounces:get"))
 		g.DELETE("/api/subscribers/:id/bounces", pm(hasID(a.DeleteSubscriberBounces), "bounces:manage"))
@@ -127,6 +156,7 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.PUT("/api/subscribers/:id/blocklist", pm(hasID(a.BlocklistSubscriber), "subscribers:manage"))
 		g.PUT("/api/subscribers/lists/:id", pm(a.ManageSubscriberLists, "subscribers:manage"))
 		g.PUT("/api/subscribers/lists", pm(a.ManageSubscriberLists, "subscribers:manage"))
+		g.PUT("/api/subscribers/lists/items", pm(a.ManageSubscriptionItems, "subscribers:manage"))
 		g.DELETE("/api/subscribers/:id", pm(hasID(a.DeleteSubscriber), "subscribers:manage"))
 		g.DELETE("/api/subscribers", pm(a.DeleteSubscribers, "subscribers:manage"))
 
@@ -148,9 +178,15 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.GET("/api/import/subscribers/logs", pm(a.GetImportSubscriberStats, "subscribers:import"))
 		g.POST("/api/import/subscribers", pm(a.ImportSubscribers, "subscribers:import"))
 		g.DELETE("/api/import/subscribers", pm(a.StopImportSubscribers, "subscribers:import"))
+		g.GET("/api/import/jobs", pm(a.GetImportJobs, "subscribers:import"))
+		g.GET("/api/import/jobs/:id", pm(hasID(a.GetImportJob), "subscribers:import"))
+		g.GET("/api/import/jobs/:id/errors", pm(hasID(a.ExportImportJobErrors), "subscribers:import"))
+		g.DELETE("/api/import/jobs/:id", pm(hasID(a.StopImportJob), "subscribers:import"))
 
 		// Individual list permissions are applied directly within handleGetLists.
 		g.GET("/api/lists", a.GetLists)
+		g.GET("/api/lists/export", pm(a.ExportLists, "lists:get_all"))
+		g.POST("/api/lists/import", pm(a.ImportLists, "lists:manage_all"))
 		g.GET("/api/lists/:id", hasID(a.GetList))
 		g.POST("/api/lists", pm(a.CreateList, "lists:manage_all"))
 		g.PUT("/api/lists/:id", hasID(a.UpdateList))
@@ -158,22 +194,48 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.DELETE("/api/lists/:id", hasID(a.DeleteList))
 
 		g.GET("/api/campaigns", pm(a.GetCampaigns, "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/calendar", pm(a.GetCampaignCalendar, "campaigns:get_all", "campaigns:get"))
 		g.GET("/api/campaigns/running/stats", pm(a.GetRunningCampaignStats, "campaigns:get_all", "campaigns:get"))
+		g.POST("/api/campaigns/stats/rollup", pm(a.RollupCampaignStats, "campaigns:manage_all"))
 		g.GET("/api/campaigns/:id", pm(hasID(a.GetCampaign), "campaigns:get_all", "campaigns:get"))
 		g.GET("/api/campaigns/analytics/:type", pm(a.GetCampaignViewAnalytics, "campaigns:get_analytics"))
+		g.GET("/api/analytics/domains", pm(a.GetDomainAnalytics, "campaigns:get_analytics"))
+		g.GET("/api/campaigns/:id/overlap", pm(hasID(a.GetCampaignOverlap), "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/:id/errors", pm(hasID(a.GetCampaignErrors), "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/:id/hygiene", pm(hasID(a.GetCampaignHygiene), "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/:id/unsubscribe-reasons", pm(hasID(a.GetCampaignUnsubscribeReasons), "campaigns:get_all", "campaigns:get"))
 		g.GET("/api/campaigns/:id/preview", pm(hasID(a.PreviewCampaign), "campaigns:get_all", "campaigns:get"))
+		g.GET("/api/campaigns/:id/preview/:subscriber_id", pm(hasID(a.PreviewCampaign), "campaigns:get_all", "campaigns:get"))
 		g.POST("/api/campaigns/:id/preview/archive", pm(hasID(a.PreviewCampaignArchive), "campaigns:get_all", "campaigns:get"))
 		g.POST("/api/campaigns/:id/preview", pm(hasID(a.PreviewCampaign), "campaigns:get_all", "campaigns:get"))
 		g.POST("/api/campaigns/:id/content", pm(hasID(a.CampaignContent), "campaigns:manage_all", "campaigns:manage"))
 		g.POST("/api/campaigns/:id/text", pm(hasID(a.PreviewCampaign), "campaigns:get"))
+		g.POST("/api/campaigns/:id/dry-run", pm(hasID(a.DryRunCampaign), "campaigns:manage_all", "campaigns:manage"))
 		g.POST("/api/campaigns/:id/test", pm(hasID(a.TestCampaign), "campaigns:manage_all", "campaigns:manage"))
+		g.POST("/api/campaigns/:id/seed-send", pm(hasID(a.SeedSendCampaign), "campaigns:manage_all", "campaigns:manage"))
+		g.POST("/api/campaigns/:id/resend-to-non-openers", pm(hasID(a.ResendCampaignToNonOpeners), "campaigns:manage_all", "campaigns:manage"))
+		g.POST("/api/campaigns/:id/approve", pm(hasID(a.ApproveCampaign), "campaigns:approve"))
+		g.POST("/api/campaigns/:id/reject", pm(hasID(a.RejectCampaign), "campaigns:approve"))
+		g.POST("/api/campaigns/:id/recipients", pm(hasID(a.UploadCampaignRecipients), "campaigns:manage_all", "campaigns:manage"))
+		g.DELETE("/api/campaigns/:id/recipients", pm(hasID(a.DeleteCampaignRecipients), "campaigns:manage_all", "campaigns:manage"))
 		g.POST("/api/campaigns", pm(a.CreateCampaign, "campaigns:manage_all", "campaigns:manage"))
 		g.PUT("/api/campaigns/:id", pm(hasID(a.UpdateCampaign), "campaigns:manage_all", "campaigns:manage"))
 		g.PUT("/api/campaigns/:id/status", pm(hasID(a.UpdateCampaignStatus), "campaigns:manage_all", "campaigns:manage"))
 		g.PUT("/api/campaigns/:id/archive", pm(hasID(a.UpdateCampaignArchive), "campaigns:manage_all", "campaigns:manage"))
+		g.GET("/api/campaigns/:id/draft", pm(hasID(a.GetCampaignDraft), "campaigns:get_all", "campaigns:get"))
+		g.PUT("/api/campaigns/:id/draft", pm(hasID(a.SaveCampaignDraft), "campaigns:manage_all", "campaigns:manage"))
+		g.POST("/api/campaigns/:id/draft/restore", pm(hasID(a.RestoreCampaignDraft), "campaigns:manage_all", "campaigns:manage"))
+		g.DELETE("/api/campaigns/:id/draft", pm(hasID(a.DeleteCampaignDraft), "campaigns:manage_all", "campaigns:manage"))
+		g.GET("/api/campaigns/:id/archive/link", pm(hasID(a.GetCampaignArchiveLink), "campaigns:get_all", "campaigns:get"))
 		g.DELETE("/api/campaigns", pm(a.DeleteCampaigns, "campaigns:manage", "campaigns:manage_all"))
 		g.DELETE("/api/campaigns/:id", pm(hasID(a.DeleteCampaign), "campaigns:manage_all", "campaigns:manage"))
 
+		g.GET("/api/sequences", pm(a.GetSequences, "campaigns:get_all"))
+		g.GET("/api/sequences/:id", pm(hasID(a.GetSequence), "campaigns:get_all"))
+		g.POST("/api/sequences", pm(a.CreateSequence, "campaigns:manage_all"))
+		g.PUT("/api/sequences/:id", pm(hasID(a.UpdateSequence), "campaigns:manage_all"))
+		g.DELETE("/api/sequences/:id", pm(hasID(a.DeleteSequence), "campaigns:manage_all"))
+
 		g.GET("/api/media", pm(a.GetAllMedia, "media:get"))
 		g.GET("/api/media/:id", pm(hasID(a.GetMedia), "media:get"))
 		g.POST("/api/media", pm(a.UploadMedia, "media:manage"))
@@ -182,17 +244,38 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.GET("/api/templates", pm(a.GetTemplates, "templates:get"))
 		g.GET("/api/templates/:id", pm(hasID(a.GetTemplate), "templates:get"))
 		g.GET("/api/templates/:id/preview", pm(hasID(a.PreviewTemplate), "templates:get"))
+		g.GET("/api/templates/:id/preview/:subscriber_id", pm(hasID(a.PreviewTemplate), "templates:get"))
 		g.POST("/api/templates/preview", pm(a.PreviewTemplateBody, "templates:get"))
 		g.POST("/api/templates", pm(a.CreateTemplate, "templates:manage"))
 		g.PUT("/api/templates/:id", pm(hasID(a.UpdateTemplate), "templates:manage"))
 		g.PUT("/api/templates/:id/default", pm(hasID(a.TemplateSetDefault), "templates:manage"))
 		g.DELETE("/api/templates/:id", pm(hasID(a.DeleteTemplate), "templates:manage"))
 
+		g.GET("/api/security/alerts", pm(a.GetSecurityAlerts, "settings:get"))
+		g.GET("/api/digest", pm(a.GetDigest, "settings:get"))
+		g.POST("/api/digest", pm(a.SendDigest, "settings:manage"))
+		g.GET("/api/system-messages/failed", pm(a.GetFailedSystemMessages, "settings:get"))
+		g.POST("/api/system-messages/:id/retry", pm(hasID(a.RetrySystemMessage), "settings:manage"))
+
+		g.GET("/api/sender-identities", pm(a.GetSenderIdentities, "settings:get"))
+		g.POST("/api/sender-identities", pm(a.CreateSenderIdentity, "settings:manage"))
+		g.POST("/api/sender-identities/:id/verify", pm(hasID(a.ResendSenderIdentityVerification), "settings:manage"))
+		g.DELETE("/api/sender-identities/:id", pm(hasID(a.DeleteSenderIdentity), "settings:manage"))
+
+		g.GET("/api/tags", pm(a.GetTags, "campaigns:get_all", "campaigns:get", "templates:get"))
+
 		g.DELETE("/api/maintenance/subscribers/:type", pm(a.GCSubscribers, "settings:maintain"))
 		g.DELETE("/api/maintenance/analytics/:type", pm(a.GCCampaignAnalytics, "settings:maintain"))
 		g.DELETE("/api/maintenance/subscriptions/unconfirmed", pm(a.GCSubscriptions, "settings:maintain"))
+		g.DELETE("/api/maintenance/simulations", pm(a.DeleteSimulationData, "settings:maintain"))
+		g.POST("/api/maintenance/sunset", pm(a.RunSunset, "settings:maintain"))
+		g.POST("/api/maintenance/subscribers/normalize-emails", pm(a.NormalizeSubscriberEmails, "settings:maintain"))
+		g.GET("/api/maintenance/verify-subscribers", pm(a.GetSubscriberVerification, "settings:maintain"))
+		g.POST("/api/maintenance/verify-subscribers", pm(a.StartSubscriberVerification, "settings:maintain"))
+		g.DELETE("/api/maintenance/verify-subscribers", pm(a.CancelSubscriberVerification, "settings:maintain"))
 
 		g.POST("/api/tx", pm(a.SendTxMessage, "tx:send"))
+		g.GET("/api/tx/:id", pm(a.GetTxMessageStatus, "tx:send"))
 
 		g.GET("/api/profile", a.GetUserProfile)
 		g.PUT("/api/profile", a.UpdateUserProfile)
@@ -229,9 +312,12 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		// Public unauthenticated endpoints.
 		g := e.Group("")
 
+		// Rate limiter for the public subscription APIs.
+		rl := a.publicRateLimiter()
+
 		if a.cfg.BounceWebhooksEnabled {
 			// Public bounce endpoints for webservices like SES.
-			g.POST("/webhooks/service/:service", a.BounceWebhook)
+			g.POST("/webhooks/service/:service", a.BounceWebhook, a.bounceSourceAllowlist())
 		}
 
 		// Landing page.
@@ -256,7 +342,9 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 
 		// Public APIs.
 		g.GET("/api/public/lists", a.GetPublicLists)
-		g.POST("/api/public/subscription", a.PublicSubscription)
+		g.POST("/api/public/subscription", a.PublicSubscription, rl)
+		g.GET("/api/public/subscription/confirm/:token", a.PublicConfirmSubscription, rl)
+		g.POST("/api/public/subscription/unsubscribe", a.PublicUnsubscribe, rl)
 		g.GET("/api/public/captcha/altcha", a.AltchaChallenge)
 		if a.cfg.EnablePublicArchive {
 			g.GET("/api/public/archive", a.GetCampaignArchives)
@@ -272,22 +360,31 @@ func initHTTPHandlers(e *echo.Echo, a *App) {
 		g.POST("/subscription/optin/:subUUID", a.hasUUID(a.hasSub(a.OptinPage), "subUUID"))
 		g.POST("/subscription/export/:subUUID", a.hasUUID(a.hasSub(a.SelfExportSubscriberData), "subUUID"))
 		g.POST("/subscription/wipe/:subUUID", a.hasUUID(a.hasSub(a.WipeSubscriberData), "subUUID"))
+		g.GET("/subscription/cancel-deletion/:subUUID", noIndex(a.hasUUID(a.hasSub(a.CancelSubscriberDeletion), "subUUID")))
+		g.POST("/subscription/cancel-deletion/:subUUID", a.hasUUID(a.hasSub(a.CancelSubscriberDeletion), "subUUID"))
+		g.GET("/link/preview", noIndex(a.PreviewLinkPage))
 		g.GET("/link/:linkUUID/:campUUID/:subUUID", noIndex(a.hasUUID(a.LinkRedirect, "linkUUID", "campUUID", "subUUID")))
+		g.GET("/l/:slug/:ref", noIndex(a.ShortLinkRedirect))
 		g.GET("/campaign/:campUUID/:subUUID", noIndex(a.hasUUID(a.ViewCampaignMessage, "campUUID", "subUUID")))
+		g.GET("/sender-identities/verify/:token", noIndex(a.SenderIdentityVerifyPage))
+		g.POST("/sender-identities/verify/:token", a.SenderIdentityVerifyPage)
 		g.GET("/campaign/:campUUID/:subUUID/px.png", noIndex(a.hasUUID(a.RegisterCampaignView, "campUUID", "subUUID")))
 
 		if a.cfg.EnablePublicArchive {
 			g.GET("/archive", a.CampaignArchivesPage)
 			g.GET("/archive.xml", a.GetCampaignArchivesFeed)
+			g.GET("/archive/sitemap.xml", a.GetCampaignArchiveSitemap)
 			g.GET("/archive/:id", a.CampaignArchivePage)
+			g.POST("/archive/:id", a.CampaignArchivePage, rl)
 			g.GET("/archive/latest", a.CampaignArchivePageLatest)
 		}
 
 		g.GET("/public/custom.css", serveCustomAppearance("public.custom_css"))
 		g.GET("/public/custom.js", serveCustomAppearance("public.custom_js"))
 
-		// Public health API endpoint.
+		// Public health (liveness) and readiness API endpoints.
 		g.GET("/health", a.HealthCheck)
+		g.GET("/ready", a.ReadyCheck)
 
 		// 404 pages.
 		g.RouteNotFound("/*", func(c echo.Context) error {
@@ -408,7 +505,78 @@ func noIndex(next echo.HandlerFunc) echo.HandlerFunc {
 	}
 }
 
+// reqCore returns the core with the HTTP request's context attached so that
+// its DB queries are cancelled when the request is cancelled.
+func (a *App) reqCore(c echo.Context) *core.Core {
+	return a.core.WithContext(c.Request().Context())
+}
+
 // getID returns the :id param from the URL parsed and stored as an int by the hasID middleware.
 func getID(c echo.Context) int {
 	return c.Get("id").(int)
 }
+
+// skipTotal returns true if ?skip_total is set on a request to a paginated endpoint
+// to skip counting all the results, which is expensive on large tables.
+func skipTotal(c echo.Context) bool {
+	ok, _ := strconv.ParseBool(c.QueryParam("skip_total"))
+	return ok
+}
+
+// paginated returns true if ?paginated is set on a request to an endpoint whose
+// response predates the standard paginated envelope, eg: /api/logs, to get the
+// results in the envelope instead.
+func paginated(c echo.Context) bool {
+	ok, _ := strconv.ParseBool(c.QueryParam("paginated"))
+	return ok
+}
+
+// makePageResults returns the standard envelope of paginated results. total is
+// models.TotalSkipped if counting was skipped.
+func makePageResults(res any, total int, pg paginator.Set) models.PageResults {
+	return models.PageResults{
+		Results: res,
+		Total:   total,
+		Page:    pg.Page,
+		PerPage: pg.PerPage,
+	}
+}
+
+// publicRateLimiter returns a middleware that limits the number of requests per minute
+// per IP to the public subscription APIs. It's a no-op if the limit is 0.
+func (a *App) publicRateLimiter() echo.MiddlewareFunc {
+	n := a.cfg.Security.PublicRateLimit
+	if n <= 0 {
+		return func(next echo.HandlerFunc) echo.HandlerFunc {
+			return next
+		}
+	}
+
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      rate.Limit(float64(n) / 60),
+			Burst:     n,
+			ExpiresIn: 3 * time.Minute,
+		}),
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			return c.RealIP(), nil
+		},
+		DenyHandler: func(c echo.Context, _ string, _ error) error {
+			return a.publicErr(http.StatusTooManyRequests, pubErrRateLimited, http.StatusText(http.StatusTooManyRequests))
+		},
+	})
+}
+
+// bounceSourceAllowlist returns a middleware that rejects requests to the bounce
+// webhooks of providers from source IPs that aren't in their allowlists.
+func (a *App) bounceSourceAllowlist() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if a.bounce != nil && !a.bounce.AllowWebhookSource(c.Param("service"), c.Request()) {
+				return echo.NewHTTPError(http.StatusForbidden, http.StatusText(http.StatusForbidden))
+			}
+
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Maximum time all readiness checks together can take.
+	readyTimeout = 2 * time.Second
+
+	healthOK   = "ok"
+	healthFail = "fail"
+)
+
+// readyCheck is a named readiness check that returns an error if the
+// subsystem isn't ready to serve requests.
+type readyCheck struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// readyChecks is the registry of readiness checks run by the /ready endpoint.
+type readyChecks struct {
+	mut    sync.RWMutex
+	checks []readyCheck
+
+	// Maximum time all the checks together can take. Defaults to readyTimeout.
+	timeout time.Duration
+}
+
+type readyResp struct {
+	Status string                    `json:"status"`
+	Checks map[string]readyCheckResp `json:"checks"`
+}
+
+type readyCheckResp struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+var errReadyTimeout = errors.New("check timed out")
+
+// Register adds a named readiness check. Subsystems (eg: the bounce manager)
+// can register their own checks at init.
+func (r *readyChecks) Register(name string, fn func(ctx context.Context) error) {
+	r.mut.Lock()
+	r.checks = append(r.checks, readyCheck{name: name, fn: fn})
+	r.mut.Unlock()
+}
+
+// run runs all the checks and returns their results. The bool is false
+// if any of the checks fail or don't finish within the timeout.
+func (r *readyChecks) run(ctx context.Context) (readyResp, bool) {
+	r.mut.RLock()
+	checks, timeout := r.checks, r.timeout
+	r.mut.RUnlock()
+
+	if timeout <= 0 {
+		timeout = readyTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Run the checks concurrently so that a check that doesn't return on the
+	// context's cancellation doesn't hold up the response.
+	errs := make([]chan error, len(checks))
+	for n, c := range checks {
+		errs[n] = make(chan error, 1)
+		go func() { errs[n] <- c.fn(ctx) }()
+	}
+
+	out := readyResp{Status: healthOK, Checks: make(map[string]readyCheckResp, len(checks))}
+	ok := true
+	for n, c := range checks {
+		var err error
+		select {
+		case err = <-errs[n]:
+		case <-ctx.Done():
+			// The check may have finished just as the timeout expired.
+			select {
+			case err = <-errs[n]:
+			default:
+				err = errReadyTimeout
+			}
+		}
+
+		if err != nil {
+			out.Checks[c.name] = readyCheckResp{Status: healthFail, Error: err.Error()}
+			ok = false
+			continue
+		}
+		out.Checks[c.name] = readyCheckResp{Status: healthOK}
+	}
+
+	if !ok {
+		out.Status = healthFail
+	}
+
+	return out, ok
+}
+
+// initReadyChecks registers the default readiness checks of the app.
+func initReadyChecks(a *App) *readyChecks {
+	r := &readyChecks{}
+
+	// The DB should respond to a ping.
+	r.Register("db", func(ctx context.Context) error {
+		return a.db.PingContext(ctx)
+	})
+
+	// At least one messenger should be available to send messages.
+	r.Register("messengers", func(ctx context.Context) error {
+		if len(a.messengers) == 0 {
+			return errors.New("no messengers registered")
+		}
+		return nil
+	})
+
+	// The campaign manager shouldn't be paused on a lost database connection.
+	r.Register("campaigns", func(ctx context.Context) error {
+		return a.manager.DBStatus()
+	})
+
+	// The app shouldn't be in the middle of a restart.
+	r.Register("reload", func(ctx context.Context) error {
+		if a.reloading.Load() {
+			return errors.New("app is restarting")
+		}
+		return nil
+	})
+
+	return r
+}
+
+// ReadyCheck is a readiness endpoint for load balancers and orchestrators that
+// returns 200 if all the readiness checks pass and 503 otherwise.
+func (a *App) ReadyCheck(c echo.Context) error {
+	out, ok := a.readyChecks.run(c.Request().Context())
+	if !ok {
+		return c.JSON(http.StatusServiceUnavailable, okResp{out})
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/labstack/echo/v4"
+	_ "github.com/lib/pq"
+)
+
+// getReady makes a /ready request and returns the response status and body.
+func getReady(t *testing.T, a *App) (int, readyResp) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/api/health/ready", nil), rec)
+	if err := a.ReadyCheck(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var out struct {
+		Data readyResp `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	return rec.Code, out.Data
+}
+
+func TestReadyCheck(t *testing.T) {
+	a := newTestApp(t)
+	a.manager = manager.New(manager.Config{}, nil, a.i18n, log.New(io.Discard, "", 0))
+	a.messengers = []manager.Messenger{testMessenger{}}
+
+	// A DB whose connections are closed.
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	a.db = sqlx.NewDb(db, "postgres")
+
+	a.readyChecks = initReadyChecks(a)
+	code, out := getReady(t, a)
+	if code != http.StatusServiceUnavailable || out.Status != healthFail {
+		t.Fatalf("expected the check to fail, got %d %+v", code, out)
+	}
+	if c := out.Checks["db"]; c.Status != healthFail || !strings.Contains(c.Error, "closed") {
+		t.Errorf("expected the db check to fail, got %+v", c)
+	}
+	for _, name := range []string{"messengers", "campaigns", "reload"} {
+		if c := out.Checks[name]; c.Status != healthOK {
+			t.Errorf("expected the %s check to pass, got %+v", name, c)
+		}
+	}
+
+	// The other checks fail with their own errors.
+	a.messengers = nil
+	a.reloading.Store(true)
+	_, out = getReady(t, a)
+	if out.Checks["messengers"].Status != healthFail || out.Checks["reload"].Error != "app is restarting" {
+		t.Errorf("unexpected checks %+v", out.Checks)
+	}
+
+	// All the checks pass.
+	r := &readyChecks{}
+	r.Register("db", func(context.Context) error { return nil })
+	r.Register("messengers", func(context.Context) error { return nil })
+	a.readyChecks = r
+	if code, out := getReady(t, a); code != http.StatusOK || out.Status != healthOK || len(out.Checks) != 2 {
+		t.Fatalf("expected the checks to pass, got %d %+v", code, out)
+	}
+}
+
+func TestReadyChecksTimeout(t *testing.T) {
+	stuck := make(chan struct{})
+	t.Cleanup(func() { close(stuck) })
+
+	r := &readyChecks{timeout: 50 * time.Millisecond}
+	r.Register("fast", func(context.Context) error { return nil })
+	r.Register("failing", func(context.Context) error { return errors.New("broken") })
+
+	// A check that returns on the context's cancellation.
+	r.Register("slow", func(ctx context.Context) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Minute):
+			return nil
+		}
+	})
+
+	// A check that ignores the context.
+	r.Register("stuck", func(context.Context) error {
+		<-stuck
+		return nil
+	})
+
+	start := time.Now()
+	out, ok := r.run(context.Background())
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("expected the checks to time out, took %v", d)
+	}
+	if ok || out.Status != healthFail {
+		t.Fatalf("expected the checks to fail, got %+v", out)
+	}
+
+	exp := map[string]readyCheckResp{
+		"fast":    {Status: healthOK},
+		"failing": {Status: healthFail, Error: "broken"},
+		"stuck":   {Status: healthFail, Error: errReadyTimeout.Error()},
+	}
+	for name, e := range exp {
+		if got := out.Checks[name]; got != e {
+			t.Errorf("%s: expected %+v, got %+v", name, e, got)
+		}
+	}
+
+	// The slow check fails with its own error or the timeout, whichever is first.
+	if got := out.Checks["slow"]; got.Status != healthFail ||
+		(got.Error != context.DeadlineExceeded.Error() && got.Error != errReadyTimeout.Error()) {
+		t.Errorf("slow: expected a timeout, got %+v", got)
+	}
+
+	// A cancelled request fails the checks that are still running.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if out, ok := r.run(ctx); ok || out.Checks["stuck"].Status != healthFail {
+		t.Fatalf("expected the checks to fail on a cancelled request, got %+v", out)
+	}
+}
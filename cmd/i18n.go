@@ -39,6 +39,16 @@ func (a *App) GetI18nLang(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{json.RawMessage(i.JSON())})
 }
 
+// isValidLang checks whether a language pack exists for the given language code.
+func isValidLang(lang string, fs stuffbin.FileSystem) bool {
+	if lang == "" || len(lang) > 6 || reLangCode.MatchString(lang) {
+		return false
+	}
+
+	_, err := fs.Read(fmt.Sprintf("/i18n/%s.json", lang))
+	return err == nil
+}
+
 // getI18nLangList returns the list of available i18n languages.
 func getI18nLangList(fs stuffbin.FileSystem) ([]i18nLang, error) {
 	list, err := fs.Glob("/i18n/*.json")
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/knadh/stuffbin"
+)
+
+func TestIsValidLang(t *testing.T) {
+	fs, err := stuffbin.NewLocalFS("/", "../i18n:/i18n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for lang, exp := range map[string]bool{
+		"en":       true,
+		"fr":       true,
+		"pt-BR":    true,
+		"":         false,
+		"xx":       false,
+		"../en":    false,
+		"toolong1": false,
+	} {
+		if got := isValidLang(lang, fs); got != exp {
+			t.Errorf("%q: expected %v, got %v", lang, exp, got)
+		}
+	}
+}
@@ -1,25 +1,24 @@
 package main
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 
+	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
 
-// ImportSubscribers handles the uploading and bulk importing of
-// a ZIP file of one or more CSV files.
+// ImportSubscribers handles the uploading of a CSV file or a ZIP file of CSV
+// files and queues an import job for it. Jobs are run one at a time.
 func (a *App) ImportSubscribers(c echo.Context) error {
-	// Is an import already running?
-	if a.importer.GetStats().Status == subimporter.StatusImporting {
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.alreadyRunning"))
-	}
-
 	// Unmarshal the JSON params.
 	var opt subimporter.SessionOpt
 	if err := json.Unmarshal([]byte(c.FormValue("params")), &opt); err != nil {
@@ -71,6 +70,7 @@ func (a *App) ImportSubscribers(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			a.i18n.Ts("import.errorCopyingFile", "error", err.Error()))
 	}
+	defer os.Remove(out.Name())
 	defer out.Close()
 
 	if _, err = io.Copy(out, src); err != nil {
@@ -78,37 +78,22 @@ func (a *App) ImportSubscribers(c echo.Context) error {
 			a.i18n.Ts("import.errorCopyingFile", "error", err.Error()))
 	}
 
-	// Start the importer session.
+	// Queue the import. The file is copied to the import directory.
 	opt.Filename = file.Filename
-	sess, err := a.importer.NewSession(opt)
+	res, err := a.importer.Queue(opt, out.Name())
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError,
-			a.i18n.Ts("import.errorStarting", "error", err.Error()))
-	}
-	go sess.Start()
-
-	if strings.HasSuffix(strings.ToLower(file.Filename), ".csv") {
-		go sess.LoadCSV(out.Name(), rune(opt.Delim[0]))
-	} else {
-		// Only 1 CSV from the ZIP is considered. If multiple files have
-		// to be processed, counting the net number of lines (to track progress),
-		// keeping the global import state (failed / successful) etc. across
-		// multiple files becomes complex. Instead, it's just easier for the
-		// end user to concat multiple CSVs (if there are multiple in the first)
-		// place and upload as one in the first place.
-		dir, files, err := sess.ExtractZIP(out.Name(), 1)
-		if err != nil {
+		if !strings.HasSuffix(strings.ToLower(file.Filename), ".csv") {
 			return echo.NewHTTPError(http.StatusInternalServerError,
 				a.i18n.Ts("import.errorProcessingZIP", "error", err.Error()))
 		}
-
-		go sess.LoadCSV(dir+"/"+files[0], rune(opt.Delim[0]))
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("import.errorStarting", "error", err.Error()))
 	}
 
-	return c.JSON(http.StatusOK, okResp{a.importer.GetStats()})
+	return c.JSON(http.StatusOK, okResp{res})
 }
 
-// GetImportSubscribers returns import statistics.
+// GetImportSubscribers returns the statistics of the running or the last import.
 func (a *App) GetImportSubscribers(c echo.Context) error {
 	s := a.importer.GetStats()
 	return c.JSON(http.StatusOK, okResp{s})
@@ -116,6 +101,12 @@ func (a *App) GetImportSubscribers(c echo.Context) error {
 
 // GetImportSubscriberStats returns import statistics.
 func (a *App) GetImportSubscriberStats(c echo.Context) error {
+	// The logs have the e-mails of skipped rows.
+	if isPIIRedacted(c) {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", auth.PermPIIRedacted))
+	}
+
 	return c.JSON(http.StatusOK, okResp{string(a.importer.GetLogs())})
 }
 
@@ -126,3 +117,87 @@ func (a *App) StopImportSubscribers(c echo.Context) error {
 	a.importer.Stop()
 	return c.JSON(http.StatusOK, okResp{a.importer.GetStats()})
 }
+
+// GetImportJobs returns the history of import jobs.
+func (a *App) GetImportJobs(c echo.Context) error {
+	var (
+		status = c.QueryParam("status")
+		pg     = a.pg.NewFromURL(c.Request().URL.Query())
+	)
+
+	res, total, err := a.reqCore(c).QueryImportJobs(status, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{makePageResults(res, total, pg)})
+}
+
+// GetImportJob returns an import job.
+func (a *App) GetImportJob(c echo.Context) error {
+	out, err := a.reqCore(c).GetImportJob(getID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// ExportImportJobErrors streams the rows of an import job that were skipped
+// as a CSV file along with the reasons.
+func (a *App) ExportImportJobErrors(c echo.Context) error {
+	// The raw rows can't be anonymized.
+	if isPIIRedacted(c) {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", auth.PermPIIRedacted))
+	}
+
+	id := getID(c)
+	if _, err := a.reqCore(c).GetImportJob(id); err != nil {
+		return err
+	}
+
+	res, err := a.reqCore(c).GetImportJobErrors(id)
+	if err != nil {
+		return err
+	}
+
+	var (
+		hdr = c.Response().Header()
+		wr  = csv.NewWriter(c.Response())
+	)
+
+	hdr.Set(echo.HeaderContentType, "text/csv")
+	hdr.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=import-%d-errors.csv", id))
+	hdr.Set("Cache-Control", "no-cache")
+	wr.Write([]string{"line", "error", "row"})
+
+	for _, r := range res {
+		if err := wr.Write([]string{strconv.Itoa(r.Line), r.Error, r.Data}); err != nil {
+			a.log.Printf("error streaming import errors: %v", err)
+			break
+		}
+	}
+	wr.Flush()
+
+	return nil
+}
+
+// StopImportJob stops a running import job or cancels a queued one.
+func (a *App) StopImportJob(c echo.Context) error {
+	id := getID(c)
+	if err := a.importer.StopJob(id); err != nil {
+		if err == subimporter.ErrNotStoppable {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("import.notStoppable"))
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.importJob}", "error", err.Error()))
+	}
+
+	out, err := a.reqCore(c).GetImportJob(id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
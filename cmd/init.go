@@ -34,19 +34,30 @@ import (
 	"github.com/knadh/koanf/providers/posflag"
 	"github.com/knadh/koanf/v2"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/botfilter"
 	"github.com/knadh/listmonk/internal/bounce"
 	"github.com/knadh/listmonk/internal/bounce/mailbox"
+	bwebhooks "github.com/knadh/listmonk/internal/bounce/webhooks"
 	"github.com/knadh/listmonk/internal/captcha"
 	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/digest"
+	"github.com/knadh/listmonk/internal/emailnorm"
+	"github.com/knadh/listmonk/internal/emailverify"
+	"github.com/knadh/listmonk/internal/fetcher"
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/internal/media/providers/filesystem"
 	"github.com/knadh/listmonk/internal/media/providers/s3"
+	"github.com/knadh/listmonk/internal/media/providers/sftp"
+	"github.com/knadh/listmonk/internal/media/providers/webdav"
 	"github.com/knadh/listmonk/internal/messenger/email"
 	"github.com/knadh/listmonk/internal/messenger/postback"
 	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/sendlimit"
 	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/internal/verp"
+	"github.com/knadh/listmonk/internal/webhooks"
 	"github.com/knadh/listmonk/models"
 	"github.com/knadh/stuffbin"
 	"github.com/labstack/echo/v4"
@@ -60,6 +71,9 @@ const (
 	queryFilePath = "/queries"
 
 	emailMsgr = "email"
+
+	// Interval at which the SMTP servers' sent counts are persisted.
+	sendCountsFlushInterval = time.Second * 10
 )
 
 // UrlConfig contains various URL constants used in the app.
@@ -74,6 +88,12 @@ type UrlConfig struct {
 	OptinURL     string
 	MessageURL   string
 	ArchiveURL   string
+
+	// Short tracking link URL with the link slug and the ref.
+	ShortLinkURL string
+
+	// No-op page that disarmed links in message previews point to.
+	PreviewLinkURL string
 }
 
 // Config contains static, constant config values required by arbitrary handlers and functions.
@@ -86,17 +106,26 @@ type Config struct {
 	EnablePublicArchiveRSSContent bool     `koanf:"enable_public_archive_rss_content"`
 	Lang                          string   `koanf:"lang"`
 	DBBatchSize                   int      `koanf:"batch_size"`
+	SeedEmails                    []string `koanf:"seed_emails"`
+	RequireSeedSend               bool     `koanf:"require_seed_send"`
+	RequireCampaignApproval       bool     `koanf:"require_campaign_approval"`
 	Privacy                       struct {
 		IndividualTracking bool            `koanf:"individual_tracking"`
 		AllowPreferences   bool            `koanf:"allow_preferences"`
 		AllowBlocklist     bool            `koanf:"allow_blocklist"`
 		AllowExport        bool            `koanf:"allow_export"`
 		AllowWipe          bool            `koanf:"allow_wipe"`
+		DeletionGraceDays  int             `koanf:"deletion_grace_days"`
 		RecordOptinIP      bool            `koanf:"record_optin_ip"`
 		UnsubHeader        bool            `koanf:"unsubscribe_header"`
+		TrackingMode       string          `koanf:"tracking_mode"`
 		Exportable         map[string]bool `koanf:"-"`
 		DomainBlocklist    []string        `koanf:"-"`
 		DomainAllowlist    []string        `koanf:"-"`
+		LinkAttribs        []string        `koanf:"-"`
+		EmailVerifyOnAPI   bool            `koanf:"-"`
+
+		UnsubscribeReasons models.UnsubscribeReasons `koanf:"-"`
 	} `koanf:"privacy"`
 	Security struct {
 		OIDC struct {
@@ -123,6 +152,21 @@ type Config struct {
 		} `koanf:"captcha"`
 
 		CorsOrigins []string `koanf:"cors_origins"`
+
+		// Maximum number of requests per minute per IP to the public subscription APIs.
+		PublicRateLimit int `koanf:"public_rate_limit"`
+
+		// Reverse proxies (IPs or CIDRs) whose X-Forwarded-For headers are trusted
+		// for finding the client IPs of requests.
+		TrustedProxies []string `koanf:"trusted_proxies"`
+
+		SenderIdentities struct {
+			Enabled             bool `koanf:"enabled"`
+			RequireVerification bool `koanf:"require_verification"`
+		} `koanf:"sender_identities"`
+
+		// Key for signing public URLs such as unlisted archive links.
+		SigningKey string `koanf:"signing_key"`
 	} `koanf:"security"`
 
 	Appearance struct {
@@ -132,6 +176,10 @@ type Config struct {
 		PublicJS  []byte `koanf:"public.custom_js"`
 	}
 
+	UTM models.CampaignUTM
+
+	Sunset models.SunsetPolicy
+
 	HasLegacyUser bool
 	AssetVersion  string
 
@@ -171,6 +219,7 @@ func initFlags(ko *koanf.Koanf) {
 	f.String("i18n-dir", "", "(optional) path to directory with i18n language files")
 	f.Bool("yes", false, "assume 'yes' to prompts during --install/upgrade")
 	f.Bool("passive", false, "run in passive mode where campaigns are not processed")
+	f.String("apply-deliverability-config", "", "apply the deliverability config (bounce and messenger routing settings) in the given JSON file and exit")
 	if err := f.Parse(os.Args[1:]); err != nil {
 		lo.Fatalf("error loading flags: %v", err)
 	}
@@ -318,14 +367,23 @@ func initDB() *sqlx.DB {
 		MaxOpen     int           `koanf:"max_open"`
 		MaxIdle     int           `koanf:"max_idle"`
 		MaxLifetime time.Duration `koanf:"max_lifetime"`
+
+		StatementTimeout time.Duration `koanf:"statement_timeout"`
 	}
 	if err := ko.Unmarshal("db", &c); err != nil {
 		lo.Fatalf("error loading db config: %v", err)
 	}
 
+	// The statement timeout is set on every connection in the pool. Long running
+	// background queries override it with db.background_statement_timeout.
+	params := c.Params
+	if c.StatementTimeout > 0 {
+		params = fmt.Sprintf("%s options='-c statement_timeout=%d'", params, c.StatementTimeout.Milliseconds())
+	}
+
 	lo.Printf("connecting to db: %s:%d/%s", c.Host, c.Port, c.DBName)
 	db, err := sqlx.Connect("postgres",
-		fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s %s", c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode, c.Params))
+		fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s %s", c.Host, c.Port, c.User, c.Password, c.DBName, c.SSLMode, params))
 	if err != nil {
 		lo.Fatalf("error connecting to DB: %v", err)
 	}
@@ -427,6 +485,11 @@ func initSettings(query string, db *sqlx.DB, ko *koanf.Koanf) {
 func initUrlConfig(ko *koanf.Koanf) *UrlConfig {
 	root := strings.TrimSuffix(ko.String("app.root_url"), "/")
 
+	short := strings.TrimSuffix(ko.String("app.short_link_url"), "/")
+	if short == "" {
+		short = root + "/l"
+	}
+
 	return &UrlConfig{
 		RootURL:    root,
 		LogoURL:    ko.String("app.logo_url"),
@@ -443,6 +506,9 @@ func initUrlConfig(ko *koanf.Koanf) *UrlConfig {
 		// url.com/link/{campaign_uuid}/{subscriber_uuid}/{link_uuid}
 		LinkTrackURL: fmt.Sprintf("%s/link/%%s/%%s/%%s", root),
 
+		// short.url/{slug}/{ref} or url.com/l/{slug}/{ref}
+		ShortLinkURL: fmt.Sprintf("%s/%%s/%%s", short),
+
 		// url.com/link/{campaign_uuid}/{subscriber_uuid}
 		MessageURL: fmt.Sprintf("%s/campaign/%%s/%%s", root),
 
@@ -451,6 +517,9 @@ func initUrlConfig(ko *koanf.Koanf) *UrlConfig {
 
 		// url.com/campaign/{campaign_uuid}/{subscriber_uuid}/px.png
 		ViewTrackURL: fmt.Sprintf("%s/campaign/%%s/%%s/px.png", root),
+
+		// url.com/link/preview?url={url}
+		PreviewLinkURL: fmt.Sprintf("%s/link/preview?url=%%s", root),
 	}
 }
 
@@ -473,11 +542,30 @@ func initConstConfig(ko *koanf.Koanf) *Config {
 	}
 
 	c.Lang = ko.String("app.lang")
+	c.UTM = models.CampaignUTM{
+		Enabled:  ko.Bool("app.utm.enabled"),
+		Source:   ko.String("app.utm.source"),
+		Medium:   ko.String("app.utm.medium"),
+		Campaign: ko.String("app.utm.campaign"),
+		Term:     ko.String("app.utm.term"),
+		Content:  ko.String("app.utm.content"),
+	}
+	if err := ko.UnmarshalWithConf("maintenance.sunset", &c.Sunset, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error loading maintenance.sunset config: %v", err)
+	}
 	c.Privacy.Exportable = koanfmaps.StringSliceToLookupMap(ko.Strings("privacy.exportable"))
 	c.MediaUpload.Provider = ko.String("upload.provider")
 	c.MediaUpload.Extensions = ko.Strings("upload.extensions")
 	c.Privacy.DomainBlocklist = ko.Strings("privacy.domain_blocklist")
 	c.Privacy.DomainAllowlist = ko.Strings("privacy.domain_allowlist")
+	c.Privacy.LinkAttribs = ko.Strings("privacy.link_attribs")
+	c.Privacy.EmailVerifyOnAPI = ko.Bool("privacy.email_verification.on_api")
+	if err := ko.UnmarshalWithConf("privacy.unsubscribe_reasons", &c.Privacy.UnsubscribeReasons, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error loading privacy.unsubscribe_reasons config: %v", err)
+	}
+	if c.Privacy.TrackingMode == "" {
+		c.Privacy.TrackingMode = models.CampaignTrackingModeFull
+	}
 
 	c.BounceWebhooksEnabled = ko.Bool("bounce.webhooks_enabled")
 	c.BounceSESEnabled = ko.Bool("bounce.ses_enabled")
@@ -486,6 +574,16 @@ func initConstConfig(ko *koanf.Koanf) *Config {
 	c.BounceForwardemailEnabled = ko.Bool("bounce.forwardemail.enabled")
 	c.HasLegacyUser = ko.Exists("app.admin_username") || ko.Exists("app.admin_password")
 
+	// Without a persistent signing key, signed URLs are only valid until a restart.
+	if c.Security.SigningKey == "" {
+		lo.Println("WARNING: security.signing_key is not set. Signed URLs will be invalidated on restart")
+		k, err := generateRandomString(32)
+		if err != nil {
+			lo.Fatalf("error generating signing key: %v", err)
+		}
+		c.Security.SigningKey = k
+	}
+
 	b := md5.Sum([]byte(time.Now().String()))
 	c.AssetVersion = fmt.Sprintf("%x", b)[0:10]
 
@@ -536,6 +634,8 @@ func initCore(fnNotify func(sub models.Subscriber, listIDs []int) (int, error),
 		Constants: core.Constants{
 			SendOptinConfirmation: ko.Bool("app.send_optin_confirmation"),
 			CacheSlowQueries:      ko.Bool("app.cache_slow_queries"),
+			EmailNormalization:    emailNormPolicy(ko),
+			TrackedAttribs:        ko.Strings("app.tracked_attribs"),
 		},
 		Queries: queries,
 		DB:      db,
@@ -548,6 +648,21 @@ func initCore(fnNotify func(sub models.Subscriber, listIDs []int) (int, error),
 		lo.Fatalf("error unmarshalling bounce config: %v", err)
 	}
 
+	// Complaints have their own threshold. If it's missing in an old config,
+	// blocklist on the first complaint.
+	if opt.Constants.BounceActions == nil {
+		opt.Constants.BounceActions = map[string]models.BounceAction{}
+	}
+	if _, ok := opt.Constants.BounceActions[models.BounceTypeComplaint]; !ok {
+		opt.Constants.BounceActions[models.BounceTypeComplaint] = models.BounceAction{Count: 1, Action: models.BounceActionBlocklist}
+	}
+
+	// Limits on ad-hoc subscriber queries.
+	if err := ko.UnmarshalWithConf("app.query_guard", &opt.Constants.QueryGuard, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error loading app.query_guard config: %v", err)
+	}
+	opt.Constants.QueryTimeout = ko.Duration("app.query_guard.timeout")
+
 	// Initialize the CRUD core.
 	return core.New(opt, &core.Hooks{
 		SendOptinConfirmation: fnNotify,
@@ -560,27 +675,73 @@ func initCampaignManager(msgrs []manager.Messenger, q *models.Queries, u *UrlCon
 		lo.Println("running in passive mode. won't process campaigns.")
 	}
 
+	var sunset models.SunsetPolicy
+	if err := ko.UnmarshalWithConf("maintenance.sunset", &sunset, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error loading maintenance.sunset config: %v", err)
+	}
+
+	var routes []models.MessengerRoute
+	if err := ko.UnmarshalWithConf("app.messenger_routes", &routes, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error loading app.messenger_routes config: %v", err)
+	}
+
+	var af models.AttachmentFetch
+	if err := ko.UnmarshalWithConf("app.attachment_fetch", &af, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error loading app.attachment_fetch config: %v", err)
+	}
+	afTimeout, _ := time.ParseDuration(af.Timeout)
+	afCacheTTL, _ := time.ParseDuration(af.CacheTTL)
+
 	mgr := manager.New(manager.Config{
 		BatchSize:             ko.Int("app.batch_size"),
 		Concurrency:           ko.Int("app.concurrency"),
 		MessageRate:           ko.Int("app.message_rate"),
+		TxConcurrency:         ko.Int("app.tx_concurrency"),
+		TxQueueSize:           ko.Int("app.tx_queue_size"),
 		MaxSendErrors:         ko.Int("app.max_send_errors"),
+		MaxRuntime:            ko.Duration("app.max_campaign_runtime"),
 		FromEmail:             ko.String("app.from_email"),
+		ReplyTo:               ko.String("app.reply_to"),
+		MessengerRoutes:       routes,
+		SimulationLatency:     ko.Duration("app.simulation_latency"),
 		IndividualTracking:    ko.Bool("privacy.individual_tracking"),
 		UnsubURL:              u.UnsubURL,
 		OptinURL:              u.OptinURL,
 		LinkTrackURL:          u.LinkTrackURL,
+		ShortLinkURL:          u.ShortLinkURL,
 		ViewTrackURL:          u.ViewTrackURL,
 		MessageURL:            u.MessageURL,
+		PreviewLinkURL:        u.PreviewLinkURL,
 		ArchiveURL:            u.ArchiveURL,
 		RootURL:               u.RootURL,
 		UnsubHeader:           ko.Bool("privacy.unsubscribe_header"),
+		BotFilter:             ko.Bool("privacy.bot_filter.enabled"),
 		SlidingWindow:         ko.Bool("app.message_sliding_window"),
 		SlidingWindowDuration: ko.Duration("app.message_sliding_window_duration"),
 		SlidingWindowRate:     ko.Int("app.message_sliding_window_rate"),
 		ScanInterval:          time.Second * 5,
+		SequenceInterval:      time.Minute,
+		SystemMessageInterval: time.Second * 30,
+		PurgeInterval:         time.Hour,
+		AnonymizeUnconfirmed:  ko.String("privacy.purge_unconfirmed_action") == models.PurgeActionAnonymize,
+		Sunset:                sunset,
+		SunsetInterval:        time.Hour,
 		ScanCampaigns:         !ko.Bool("passive"),
-	}, newManagerStore(q, co, md), i, lo)
+
+		AdhocRecipientsRetention: ko.Duration("app.adhoc_recipients_retention"),
+		StatsRollupAfter:         ko.Duration("app.campaign_stats_rollup_after"),
+		AttribHistoryRetention:   ko.Duration("app.attrib_history_retention"),
+		DeletionGraceDays:        ko.Int("privacy.deletion_grace_days"),
+		ProgressMilestones:       ko.Ints("app.progress_milestones"),
+		AttachmentFetch: fetcher.Opt{
+			Timeout:      afTimeout,
+			MaxSize:      int64(af.MaxSizeMB) * 1024 * 1024,
+			ContentTypes: af.ContentTypes,
+			Concurrency:  af.Concurrency,
+			CacheTTL:     afCacheTTL,
+			CacheSize:    int64(af.CacheSizeMB) * 1024 * 1024,
+		},
+	}, newManagerStore(db, q, co, md, ko.Bool("app.require_campaign_approval"), ko.Duration("db.background_statement_timeout")), i, lo)
 
 	// Attach all messengers to the campaign manager.
 	for _, m := range msgrs {
@@ -592,7 +753,7 @@ func initCampaignManager(msgrs []manager.Messenger, q *models.Queries, u *UrlCon
 
 // initTxTemplates initializes and compiles the transactional templates and caches them in-memory.
 func initTxTemplates(m *manager.Manager, co *core.Core) {
-	tpls, err := co.GetTemplates(models.TemplateTypeTx, false)
+	tpls, err := co.GetTemplates(models.TemplateTypeTx, nil, false, false)
 	if err != nil {
 		lo.Fatalf("error loading transactional templates: %v", err)
 	}
@@ -608,7 +769,13 @@ func initTxTemplates(m *manager.Manager, co *core.Core) {
 }
 
 // initImporter initializes the bulk subscriber importer.
-func initImporter(q *models.Queries, db *sqlx.DB, core *core.Core, i *i18n.I18n, ko *koanf.Koanf) *subimporter.Importer {
+func initImporter(q *models.Queries, db *sqlx.DB, core *core.Core, v *emailverify.Verifier, i *i18n.I18n, ko *koanf.Koanf) *subimporter.Importer {
+	// Directory in which the files of queued imports are kept.
+	dir := ko.String("app.import_dir")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "listmonk-imports")
+	}
+
 	return subimporter.New(
 		subimporter.Options{
 			DomainBlocklist:    ko.Strings("privacy.domain_blocklist"),
@@ -617,6 +784,19 @@ func initImporter(q *models.Queries, db *sqlx.DB, core *core.Core, i *i18n.I18n,
 			BlocklistStmt:      q.UpsertBlocklistSubscriber.Stmt,
 			UpdateListDateStmt: q.UpdateListsDate.Stmt,
 			CreateListStmt:     q.CreateList.Stmt,
+			Verifier:           v,
+			EmailNormalization: emailNormPolicy(ko),
+			TrackedAttribs:     ko.Strings("app.tracked_attribs"),
+			Dir:                dir,
+
+			CreateJobStmt:         q.CreateImportJob,
+			NextJobStmt:           q.NextImportJob,
+			GetJobStmt:            q.GetImportJob,
+			CountQueuedJobsStmt:   q.CountQueuedImportJobs,
+			UpdateJobProgressStmt: q.UpdateImportJobProgress,
+			UpdateJobStatusStmt:   q.UpdateImportJobStatus,
+			StopQueuedJobStmt:     q.StopQueuedImportJob,
+			InsertJobErrorStmt:    q.InsertImportJobError,
 
 			// Hook for triggering admin notifications and refreshing stats materialized
 			// views after a successful import.
@@ -628,15 +808,42 @@ func initImporter(q *models.Queries, db *sqlx.DB, core *core.Core, i *i18n.I18n,
 				notifs.NotifySystem(subject, notifs.TplImport, data, nil)
 				return nil
 			},
-		}, db.DB, i)
+		}, db.DB, i, lo)
+}
+
+// emailNormPolicy returns the e-mail normalization policy from the settings.
+func emailNormPolicy(ko *koanf.Koanf) emailnorm.Policy {
+	return emailnorm.Policy{
+		StripDots: ko.Bool("privacy.email_normalization.strip_dots"),
+		StripPlus: ko.Bool("privacy.email_normalization.strip_plus"),
+	}
+}
+
+// initSendLimiter initializes the limiter that enforces the sending limits of SMTP servers.
+func initSendLimiter(q *models.Queries) *sendlimit.Limiter {
+	l := sendlimit.New(&sendCountStore{q: q}, lo)
+
+	// Persist the sent counts periodically.
+	go l.Run(sendCountsFlushInterval)
+
+	return l
 }
 
 // initSMTPMessenger initializes the combined and individual SMTP messengers.
-func initSMTPMessengers() []manager.Messenger {
+func initSMTPMessengers(limiter *sendlimit.Limiter) []manager.Messenger {
 	var (
 		servers = []email.Server{}
 		out     = []manager.Messenger{}
+
+		// VERP envelope senders for campaign messages, if enabled.
+		v = initVERP(ko)
+
+		// Global headers attached to all e-mails.
+		hdrs models.Headers
 	)
+	if err := ko.UnmarshalWithConf("app.email_headers", &hdrs, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error loading app.email_headers config: %v", err)
+	}
 
 	// Load the config for multiple SMTP servers.
 	for _, item := range ko.Slices("smtp") {
@@ -650,6 +857,11 @@ func initSMTPMessengers() []manager.Messenger {
 			lo.Fatalf("error reading SMTP config: %v", err)
 		}
 
+		// Servers without a size limit of their own use the global one.
+		if s.MaxMessageSizeMB == 0 {
+			s.MaxMessageSizeMB = ko.Int("app.max_message_size_mb")
+		}
+
 		servers = append(servers, s)
 		lo.Printf("initialized email (SMTP) messenger: %s@%s", item.String("username"), item.String("host"))
 
@@ -660,6 +872,11 @@ func initSMTPMessengers() []manager.Messenger {
 			if err != nil {
 				lo.Fatalf("error initializing e-mail messenger: %v", err)
 			}
+			msgr.SetVERP(v)
+			msgr.SetHeaders(hdrs)
+			if err := msgr.SetLimiter(limiter); err != nil {
+				lo.Fatalf("error initializing e-mail messenger sending limits: %v", err)
+			}
 			out = append(out, msgr)
 		}
 	}
@@ -669,6 +886,11 @@ func initSMTPMessengers() []manager.Messenger {
 	if err != nil {
 		lo.Fatalf("error initializing e-mail messenger: %v", err)
 	}
+	msgr.SetVERP(v)
+	msgr.SetHeaders(hdrs)
+	if err := msgr.SetLimiter(limiter); err != nil {
+		lo.Fatalf("error initializing e-mail messenger sending limits: %v", err)
+	}
 
 	// If it's just one server, return the default "email" messenger.
 	if len(servers) == 1 {
@@ -717,19 +939,53 @@ func initPostbackMessengers(ko *koanf.Koanf) []manager.Messenger {
 	return out
 }
 
+// initWebhooks initializes the outbound webhooks that receive app events.
+func initWebhooks(ko *koanf.Koanf) *webhooks.Webhooks {
+	var hooks []webhooks.Hook
+	for _, item := range ko.Slices("webhooks") {
+		if !item.Bool("enabled") {
+			continue
+		}
+
+		var h webhooks.Hook
+		if err := item.UnmarshalWithConf("", &h, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+			lo.Fatalf("error reading webhook config: %v", err)
+		}
+		hooks = append(hooks, h)
+
+		lo.Printf("loaded webhook: %s", h.Name)
+	}
+
+	return webhooks.New(hooks, webhooks.Opt{
+		Timeout:       time.Second * 5,
+		MaxRetries:    3,
+		RetryInterval: time.Second * 10,
+		Concurrency:   2,
+		QueueSize:     1000,
+	}, lo)
+}
+
 // initMediaStore initializes Upload manager with a custom backend.
 func initMediaStore(ko *koanf.Koanf) media.Store {
-	switch provider := ko.String("upload.provider"); provider {
+	provider := ko.String("upload.provider")
+	up, err := newMediaStore(provider, ko)
+	if err != nil {
+		lo.Fatalf("error initializing %s upload provider %s", provider, err)
+	}
+	lo.Printf("media upload provider: %s", provider)
+
+	return up
+}
+
+// newMediaStore returns a media store for the given provider configured
+// from the upload.* config in ko.
+func newMediaStore(provider string, ko *koanf.Koanf) (media.Store, error) {
+	switch provider {
 	case "s3":
 		var o s3.Opt
 		ko.Unmarshal("upload.s3", &o)
 
-		up, err := s3.NewS3Store(o)
-		if err != nil {
-			lo.Fatalf("error initializing s3 upload provider %s", err)
-		}
-		lo.Println("media upload provider: s3")
-		return up
+		return s3.NewS3Store(o)
 
 	case "filesystem":
 		var o filesystem.Opts
@@ -738,21 +994,27 @@ func initMediaStore(ko *koanf.Koanf) media.Store {
 		o.RootURL = ko.String("app.root_url")
 		o.UploadPath = filepath.Clean(o.UploadPath)
 		o.UploadURI = filepath.Clean(o.UploadURI)
-		up, err := filesystem.New(o)
-		if err != nil {
-			lo.Fatalf("error initializing filesystem upload provider %s", err)
-		}
-		lo.Println("media upload provider: filesystem")
-		return up
 
-	default:
-		lo.Fatalf("unknown provider. select filesystem or s3")
+		return filesystem.New(o)
+
+	case "sftp":
+		var o sftp.Opt
+		ko.Unmarshal("upload.sftp", &o)
+
+		return sftp.New(o)
+
+	case "webdav":
+		var o webdav.Opt
+		ko.Unmarshal("upload.webdav", &o)
+
+		return webdav.New(o)
 	}
-	return nil
+
+	return nil, errors.New("unknown provider. select filesystem, s3, sftp, or webdav")
 }
 
 // initNotifs initializes the notifier with the system e-mail templates.
-func initNotifs(fs stuffbin.FileSystem, i *i18n.I18n, em *email.Emailer, u *UrlConfig, ko *koanf.Koanf) {
+func initNotifs(fs stuffbin.FileSystem, i *i18n.I18n, em *email.Emailer, u *UrlConfig, co *core.Core, ko *koanf.Koanf) {
 	tpls, err := stuffbin.ParseTemplatesGlob(initTplFuncs(i, u), fs, "/static/email-templates/*.html")
 	if err != nil {
 		lo.Fatalf("error parsing e-mail notif templates: %v", err)
@@ -780,12 +1042,26 @@ func initNotifs(fs stuffbin.FileSystem, i *i18n.I18n, em *email.Emailer, u *UrlC
 		FromEmail:    ko.String("app.from_email"),
 		SystemEmails: ko.Strings("app.notify_emails"),
 		ContentType:  contentType,
+		Lang:         ko.String("app.lang"),
+		FnUserLangs:  co.GetUserLangs,
+		FnQueue:      co.QueueSystemMessage,
+
+		// Notification templates for users who prefer a different language
+		// are parsed with that language's i18n catalog.
+		FnLoadTpls: func(lang string) (*template.Template, error) {
+			li, _, err := getI18nLang(lang, fs)
+			if err != nil {
+				return nil, err
+			}
+
+			return stuffbin.ParseTemplatesGlob(initTplFuncs(li, u), fs, "/static/email-templates/*.html")
+		},
 	}, tpls, em, lo)
 }
 
 // initBounceManager initializes the bounce manager that scans mailboxes and listens to webhooks
 // for incoming bounce events.
-func initBounceManager(cb func(models.Bounce) error, stmt *sqlx.Stmt, lo *log.Logger, ko *koanf.Koanf) *bounce.Manager {
+func initBounceManager(cb func(models.Bounce) error, sanitizeEmail func(string) (string, error), unsubReply func(mailbox.Reply) error, subEmail func(string) (string, error), stmt *sqlx.Stmt, lo *log.Logger, ko *koanf.Koanf) *bounce.Manager {
 	opt := bounce.Opt{
 		WebhooksEnabled: ko.Bool("bounce.webhooks_enabled"),
 		SESEnabled:      ko.Bool("bounce.ses_enabled"),
@@ -807,8 +1083,28 @@ func initBounceManager(cb func(models.Bounce) error, stmt *sqlx.Stmt, lo *log.Lo
 			ko.Bool("bounce.forwardemail.enabled"),
 			ko.String("bounce.forwardemail.key"),
 		},
-		RecordBounceCB: cb,
+		WebhooksLogOnly:    ko.Bool("bounce.webhooks_log_only"),
+		VERP:               initVERP(ko),
+		RecordBounceCB:     cb,
+		SanitizeEmailCB:    sanitizeEmail,
+		UnsubscribeReplyCB: unsubReply,
+		SubscriberEmailCB:  subEmail,
+	}
+
+	if err := ko.UnmarshalWithConf("bounce.reply_unsubscribe", &opt.Replies, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error reading bounce reply config: %v", err)
+	}
+
+	// Source IP allowlists of the webhooks.
+	var al bwebhooks.AllowlistOpt
+	if err := ko.UnmarshalWithConf("bounce.webhook_allowlist", &al, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error reading bounce webhook allowlist config: %v", err)
+	}
+	wl, err := bwebhooks.NewAllowlist(al)
+	if err != nil {
+		lo.Fatalf("error initializing bounce webhook allowlist: %v", err)
 	}
+	opt.WebhookAllowlist = wl
 
 	// For now, only one mailbox is supported.
 	for _, b := range ko.Slices("bounce.mailboxes") {
@@ -836,6 +1132,26 @@ func initBounceManager(cb func(models.Bounce) error, stmt *sqlx.Stmt, lo *log.Lo
 	return b
 }
 
+// initVERP initializes the VERP envelope sender encoder. It returns nil if VERP is disabled.
+func initVERP(ko *koanf.Koanf) *verp.VERP {
+	if !ko.Bool("bounce.verp.enabled") {
+		return nil
+	}
+
+	var o verp.Opt
+	if err := ko.UnmarshalWithConf("bounce.verp", &o, koanf.UnmarshalConf{Tag: "json"}); err != nil {
+		lo.Fatalf("error reading VERP config: %v", err)
+	}
+
+	v, err := verp.New(o)
+	if err != nil {
+		lo.Printf("error initializing VERP. Envelope senders won't be encoded: %v", err)
+		return nil
+	}
+
+	return v
+}
+
 // initAbout initializes the app's /about API endpoint with the app and system info.
 func initAbout(q *models.Queries, db *sqlx.DB) about {
 	var (
@@ -887,12 +1203,7 @@ func initHTTPServer(cfg *Config, urlCfg *UrlConfig, i *i18n.I18n, fs stuffbin.Fi
 		}
 	})
 
-	tpl, err := stuffbin.ParseTemplatesGlob(initTplFuncs(i, urlCfg), fs, "/public/templates/*.html")
-	if err != nil {
-		lo.Fatalf("error parsing public templates: %v", err)
-	}
-	srv.Renderer = &tplRenderer{
-		templates:           tpl,
+	tpls := &tplRenderer{
 		SiteName:            cfg.SiteName,
 		RootURL:             urlCfg.RootURL,
 		LogoURL:             urlCfg.LogoURL,
@@ -901,7 +1212,35 @@ func initHTTPServer(cfg *Config, urlCfg *UrlConfig, i *i18n.I18n, fs stuffbin.Fi
 		EnablePublicSubPage: cfg.EnablePublicSubPage,
 		EnablePublicArchive: cfg.EnablePublicArchive,
 		IndividualTracking:  cfg.Privacy.IndividualTracking,
+
+		fs:    fs,
+		funcs: initTplFuncs(i, urlCfg),
+	}
+
+	// Load the public templates with the custom overrides, if any. If the overrides
+	// are invalid, fall back to the default templates.
+	if dir := ko.String("app.public_templates_dir"); dir != "" {
+		if err := tpls.reload(dir); err != nil {
+			lo.Printf("error loading public template overrides from %s: %v. Using the default templates", dir, err)
+		} else {
+			lo.Printf("loaded public template overrides from %s: %v", dir, tpls.getOverridden())
+		}
+	}
+	if tpls.getTemplates() == nil {
+		if err := tpls.reload(""); err != nil {
+			lo.Fatalf("error parsing public templates: %v", err)
+		}
+	}
+	srv.Renderer = tpls
+
+	// Find the client IPs of requests (for rate limits, login events etc.) by
+	// trusting X-Forwarded-For only from the configured reverse proxies.
+	ipExtractor, err := bwebhooks.NewIPExtractor(cfg.Security.TrustedProxies)
+	if err != nil {
+		lo.Printf("error loading trusted proxies: %v. Using the direct client IPs", err)
+		ipExtractor = echo.ExtractIPDirect()
 	}
+	srv.IPExtractor = ipExtractor
 
 	// Initialize the static file server.
 	fSrv := fs.FileServer()
@@ -934,6 +1273,37 @@ func initHTTPServer(cfg *Config, urlCfg *UrlConfig, i *i18n.I18n, fs stuffbin.Fi
 	return srv
 }
 
+// initBotFilter initializes the bot filter that flags tracking events generated
+// by bots and security scanners. It returns nil if the filter is disabled.
+func initBotFilter(ko *koanf.Koanf) *botfilter.Filter {
+	if !ko.Bool("privacy.bot_filter.enabled") {
+		return nil
+	}
+
+	return botfilter.New(botfilter.Opt{
+		UserAgents:  ko.Strings("privacy.bot_filter.user_agents"),
+		GraceWindow: ko.Duration("privacy.bot_filter.grace_window"),
+		BurstWindow: ko.Duration("privacy.bot_filter.burst_window"),
+		BurstCount:  ko.Int("privacy.bot_filter.burst_count"),
+	})
+}
+
+// initEmailVerifier initializes the e-mail verifier. It returns nil if none of
+// the verification checks are turned on.
+func initEmailVerifier(ko *koanf.Koanf) *emailverify.Verifier {
+	v := emailverify.New(emailverify.Opt{
+		Syntax:     ko.Bool("privacy.email_verification.syntax"),
+		MX:         ko.Bool("privacy.email_verification.mx"),
+		Typos:      ko.Bool("privacy.email_verification.typos"),
+		DNSTimeout: ko.Duration("privacy.email_verification.dns_timeout"),
+	})
+	if !v.Enabled() {
+		return nil
+	}
+
+	return v
+}
+
 // initCaptcha initializes the captcha service.
 func initCaptcha() *captcha.Captcha {
 	var opt captcha.Opt
@@ -944,8 +1314,9 @@ func initCaptcha() *captcha.Captcha {
 	return captcha.New(opt)
 }
 
-// initCron initializes cron jobs for slow query cache refresh and database vacuum.
-func initCron(co *core.Core, db *sqlx.DB) {
+// initCron initializes cron jobs for slow query cache refresh, database vacuum,
+// and the activity digest report.
+func initCron(co *core.Core, db *sqlx.DB, dg *digest.Digest) {
 	c := cron.New(cron.WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
 
 	// Slow query cache cron job.
@@ -984,6 +1355,20 @@ func initCron(co *core.Core, db *sqlx.DB) {
 		}
 	}
 
+	// Activity digest report cron job.
+	if ko.Bool("app.digest.enabled") {
+		intval := ko.String("app.digest.schedule")
+		_, err := c.Add(intval, func() {
+			// Errors are logged and recorded by the digest.
+			_, _ = dg.Run(time.Now())
+		})
+		if err != nil {
+			lo.Printf("error initializing digest report cron: %v", err)
+		} else {
+			lo.Printf("digest report cron enabled at interval: %s", intval)
+		}
+	}
+
 	if len(c.Entries()) > 0 {
 		c.Start()
 	}
@@ -1091,6 +1476,9 @@ func initAuth(co *core.Core, db *sql.DB, ko *koanf.Koanf) (bool, *auth.Auth) {
 		GetUser: func(id int) (auth.User, error) {
 			return co.GetUser(id, "", "")
 		},
+
+		// Record the networks API tokens are used from for security alerts.
+		OnAPIAccess: (&apiNetworkTracker{co: co}).track,
 	}
 
 	// Initiaize the auth module.
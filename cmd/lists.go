@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"net/http"
 	"strconv"
 	"strings"
@@ -22,11 +23,11 @@ func (a *App) GetLists(c echo.Context) error {
 	minimal, _ := strconv.ParseBool(c.FormValue("minimal"))
 	if minimal {
 		status := c.FormValue("status")
-		res, err := a.core.GetLists("", status, hasAllPerm, permittedIDs)
+		res, err := a.reqCore(c).GetLists("", status, hasAllPerm, permittedIDs)
 		if err != nil {
 			return err
 		}
-		if len(res) == 0 {
+		if len(res) == 0 && !paginated(c) {
 			return c.JSON(http.StatusOK, okResp{[]struct{}{}})
 		}
 
@@ -54,19 +55,21 @@ func (a *App) GetLists(c echo.Context) error {
 
 		pg = a.pg.NewFromURL(c.Request().URL.Query())
 	)
-	res, total, err := a.core.QueryLists(query, typ, optin, status, tags, orderBy, order, hasAllPerm, permittedIDs, pg.Offset, pg.Limit)
+	res, total, err := a.reqCore(c).QueryLists(query, typ, optin, status, tags, orderBy, order, hasAllPerm, permittedIDs, pg.Offset, pg.Limit)
 	if err != nil {
 		return err
 	}
 
-	out := models.PageResults{
-		Query:   query,
-		Results: res,
-		Total:   total,
-		Page:    pg.Page,
-		PerPage: pg.PerPage,
+	// Optional per-status breakdown and growth.
+	if hasExpand(c.QueryParams(), "stats") {
+		if err := a.reqCore(c).AttachListStats(res); err != nil {
+			return err
+		}
 	}
 
+	out := makePageResults(res, total, pg)
+	out.Query = query
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -83,11 +86,20 @@ func (a *App) GetList(c echo.Context) error {
 	}
 
 	// Get the list from the DB.
-	out, err := a.core.GetList(id, "")
+	out, err := a.reqCore(c).GetList(id, "")
 	if err != nil {
 		return err
 	}
 
+	// Optional per-status breakdown and growth.
+	if hasExpand(c.QueryParams(), "stats") {
+		res := []models.List{out}
+		if err := a.reqCore(c).AttachListStats(res); err != nil {
+			return err
+		}
+		out = res[0]
+	}
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -102,8 +114,21 @@ func (a *App) CreateList(c echo.Context) error {
 	if !strHasLen(l.Name, 1, stdInputMaxLen) {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("lists.invalidName"))
 	}
+	if l.PurgeUnconfirmedAfterDays < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "purge_unconfirmed_after_days"))
+	}
+	if l.SunsetInactiveDays.Valid && l.SunsetInactiveDays.Int < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "sunset_inactive_days"))
+	}
+	if !strHasLen(l.PublicDescription, 0, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "public_description"))
+	}
+	l.Frequency = strings.TrimSpace(l.Frequency)
+	if !strHasLen(l.Frequency, 0, listFrequencyMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "frequency"))
+	}
 
-	out, err := a.core.CreateList(l)
+	out, err := a.reqCore(c).CreateList(l)
 	if err != nil {
 		return err
 	}
@@ -133,9 +158,22 @@ func (a *App) UpdateList(c echo.Context) error {
 	if !strHasLen(l.Name, 1, stdInputMaxLen) {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("lists.invalidName"))
 	}
+	if l.PurgeUnconfirmedAfterDays < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "purge_unconfirmed_after_days"))
+	}
+	if l.SunsetInactiveDays.Valid && l.SunsetInactiveDays.Int < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "sunset_inactive_days"))
+	}
+	if !strHasLen(l.PublicDescription, 0, stdInputMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "public_description"))
+	}
+	l.Frequency = strings.TrimSpace(l.Frequency)
+	if !strHasLen(l.Frequency, 0, listFrequencyMaxLen) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "frequency"))
+	}
 
 	// Update the list in the DB.
-	out, err := a.core.UpdateList(id, l)
+	out, err := a.reqCore(c).UpdateList(id, l)
 	if err != nil {
 		return err
 	}
@@ -155,7 +193,7 @@ func (a *App) DeleteList(c echo.Context) error {
 
 	// Delete the list from the DB.
 	// Pass getAll=true since we've already verified permissions above.
-	if err := a.core.DeleteLists([]int{id}, "", true, nil); err != nil {
+	if err := a.reqCore(c).DeleteLists([]int{id}, "", true, nil); err != nil {
 		return err
 	}
 
@@ -198,7 +236,7 @@ func (a *App) DeleteLists(c echo.Context) error {
 
 		// Delete the lists from the DB.
 		// Pass getAll=true since we've already verified permissions above.
-		if err := a.core.DeleteLists(ids, "", true, nil); err != nil {
+		if err := a.reqCore(c).DeleteLists(ids, "", true, nil); err != nil {
 			return err
 		}
 	} else {
@@ -206,10 +244,51 @@ func (a *App) DeleteLists(c echo.Context) error {
 		hasAllPerm, permittedIDs := user.GetPermittedLists(auth.PermTypeManage)
 
 		// Delete the lists from the DB with permission filtering.
-		if err := a.core.DeleteLists(nil, query, hasAllPerm, permittedIDs); err != nil {
+		if err := a.reqCore(c).DeleteLists(nil, query, hasAllPerm, permittedIDs); err != nil {
 			return err
 		}
 	}
 
 	return c.JSON(http.StatusOK, okResp{true})
 }
+
+// ExportLists exports the list definitions (without subscribers) as a JSON
+// bundle that can be imported into another instance with ImportLists.
+func (a *App) ExportLists(c echo.Context) error {
+	out, err := a.reqCore(c).ExportBundle([]string{models.BundleTypeLists})
+	if err != nil {
+		return err
+	}
+
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	// Set headers to force the browser to prompt for download.
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().Header().Set("Content-Disposition", `attachment; filename="listmonk-lists.json"`)
+	return c.Blob(http.StatusOK, "application/json", b)
+}
+
+// ImportLists imports list definitions exported by ExportLists, creating lists
+// or updating the ones with matching UUIDs, and responds with per-list results.
+func (a *App) ImportLists(c echo.Context) error {
+	var b models.Bundle
+	if err := c.Bind(&b); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "lists"))
+	}
+
+	// Only lists are imported.
+	b = models.Bundle{Version: b.Version, Lists: b.Lists}
+	if err := a.validateBundle(&b); err != nil {
+		return err
+	}
+
+	out, err := a.reqCore(c).ImportLists(b.Lists)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
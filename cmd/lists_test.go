@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/knadh/paginator"
+	"github.com/labstack/echo/v4"
+)
+
+func TestGetListsMinimalEmpty(t *testing.T) {
+	a, _ := newTestAppDB(t)
+
+	e := newTestEcho()
+	e.GET("/api/lists", a.GetLists, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+			return next(c)
+		}
+	})
+
+	// Without lists, the minimal response is an empty array as it's always been.
+	rec := doForm(e, http.MethodGet, "/api/lists?minimal=true", nil)
+	if body := strings.TrimSpace(rec.Body.String()); body != `{"data":[]}` {
+		t.Errorf("unexpected response %s", body)
+	}
+
+	// With ?paginated, it's an empty page.
+	rec = doForm(e, http.MethodGet, "/api/lists?minimal=true&paginated=true", nil)
+	if body := strings.TrimSpace(rec.Body.String()); !strings.Contains(body, `"results":[]`) || !strings.Contains(body, `"total":0`) {
+		t.Errorf("unexpected paginated response %s", body)
+	}
+}
+
+func TestHasExpand(t *testing.T) {
+	cases := []struct {
+		query string
+		exp   bool
+	}{
+		{"", false},
+		{"expand=stats", true},
+		{"expand=tags,stats", true},
+		{"expand=tags, stats", true},
+		{"expand=tags&expand=stats", true},
+		{"expand=statistics", false},
+		{"stats=true", false},
+	}
+	for _, c := range cases {
+		qp, _ := url.ParseQuery(c.query)
+		if got := hasExpand(qp, "stats"); got != c.exp {
+			t.Errorf("%s: expected %v, got %v", c.query, c.exp, got)
+		}
+	}
+}
+
+// TestGetListsStats checks that the per-status breakdown of lists adds up to
+// their totals and that it's only fetched when it's requested.
+func TestGetListsStats(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.pg = paginator.New(paginator.Opt{DefaultPerPage: 20, MaxPerPage: 50, NumPageNums: 10, PageParam: "page", PerPageParam: "per_page"})
+
+	mw := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+			return next(c)
+		}
+	}
+	e := newTestEcho()
+	e.GET("/api/lists", a.GetLists, mw)
+	e.GET("/api/lists/:id", hasID(a.GetList), mw)
+
+	var full, empty int
+	if err := db.Get(&full, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'full', 'public') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&empty, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'empty', 'public') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Three confirmed (one of them blocklisted and one subscribed 60 days ago),
+	// one unconfirmed, and one unsubscribed subscription.
+	for n, s := range []struct {
+		status, subStatus string
+		days              int
+	}{
+		{"enabled", "confirmed", 0},
+		{"blocklisted", "confirmed", 0},
+		{"enabled", "confirmed", 60},
+		{"enabled", "unconfirmed", 1},
+		{"enabled", "unsubscribed", 2},
+	} {
+		if _, err := db.Exec(`WITH s AS (INSERT INTO subscribers (uuid, email, name, status) VALUES (gen_random_uuid(), $1, 'Sub', $2) RETURNING id)
+			INSERT INTO subscriber_lists (subscriber_id, list_id, status, created_at) SELECT s.id, $3, $4, NOW() - MAKE_INTERVAL(days => $5) FROM s`,
+			"sub"+strconv.Itoa(n)+"@example.com", s.status, full, s.subStatus, s.days); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type list struct {
+		ID    int               `json:"id"`
+		Stats *models.ListStats `json:"stats"`
+	}
+	getLists := func(target string) map[int]*models.ListStats {
+		t.Helper()
+
+		rec := doForm(e, http.MethodGet, target, nil)
+		var res struct {
+			Data struct {
+				Results []list `json:"results"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected response %d: %v: %s", target, rec.Code, err, rec.Body.String())
+		}
+		out := map[int]*models.ListStats{}
+		for _, l := range res.Data.Results {
+			out[l.ID] = l.Stats
+		}
+		return out
+	}
+
+	// The stats aren't fetched by default.
+	res := getLists("/api/lists")
+	if len(res) != 2 || res[full] != nil || res[empty] != nil {
+		t.Errorf("expected no stats by default, got %+v", res)
+	}
+
+	// The breakdown adds up to the total.
+	res = getLists("/api/lists?expand=stats")
+	s := res[full]
+	if s == nil {
+		t.Fatalf("expected stats, got %+v", res)
+	}
+	if exp := (models.ListStats{Confirmed: 3, Unconfirmed: 1, Unsubscribed: 1, Blocklisted: 1, Total: 5, NewLast30d: 4}); *s != exp {
+		t.Errorf("expected %+v, got %+v", exp, *s)
+	}
+	if s.Confirmed+s.Unconfirmed+s.Unsubscribed != s.Total {
+		t.Errorf("expected the breakdown to add up to the total, got %+v", s)
+	}
+
+	// Lists without subscribers have zero stats.
+	if s := res[empty]; s == nil || *s != (models.ListStats{}) {
+		t.Errorf("expected zero stats for the empty list, got %+v", s)
+	}
+
+	// A single list.
+	rec := doForm(e, http.MethodGet, "/api/lists/"+strconv.Itoa(full)+"?expand=stats", nil)
+	var one struct {
+		Data list `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &one); err != nil || one.Data.Stats == nil || one.Data.Stats.Total != 5 {
+		t.Errorf("unexpected list %d: %v: %s", rec.Code, err, rec.Body.String())
+	}
+}
+
+// TestExportImportLists exports the list definitions from one instance and
+// imports them into another.
+func TestExportImportLists(t *testing.T) {
+	mw := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+			return next(c)
+		}
+	}
+
+	src, srcDB := newTestAppDB(t)
+	srcE := newTestEcho()
+	srcE.GET("/api/lists/export", src.ExportLists, mw)
+
+	dst, dstDB := newTestAppDB(t)
+	dstE := newTestEcho()
+	dstE.GET("/api/lists/export", dst.ExportLists, mw)
+	dstE.POST("/api/lists/import", dst.ImportLists, mw)
+
+	if _, err := srcDB.Exec(`INSERT INTO lists (uuid, name, type, optin, status, tags, description, sunset_inactive_days, public_description, frequency) VALUES
+		(gen_random_uuid(), 'News', 'public', 'double', 'active', '{news,weekly}', 'The newsletter', 90, 'Weekly news', 'weekly'),
+		(gen_random_uuid(), 'Internal', 'private', 'single', 'archived', '{}', '', NULL, '', ''),
+		(gen_random_uuid(), 'Taken', 'private', 'single', 'active', '{}', '', NULL, '', '')`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srcDB.Exec(`WITH s AS (INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), 'user@example.com', 'User') RETURNING id)
+		INSERT INTO subscriber_lists (subscriber_id, list_id) SELECT s.id, l.id FROM s, lists l`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another list on the destination has one of the names.
+	if _, err := dstDB.Exec(`INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'TAKEN', 'private')`); err != nil {
+		t.Fatal(err)
+	}
+
+	export := func(e *echo.Echo) (models.Bundle, []byte) {
+		t.Helper()
+
+		rec := doForm(e, http.MethodGet, "/api/lists/export", nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if !strings.Contains(rec.Header().Get("Content-Disposition"), "listmonk-lists.json") {
+			t.Errorf("expected a download, got %v", rec.Header())
+		}
+
+		var b models.Bundle
+		if err := json.Unmarshal(rec.Body.Bytes(), &b); err != nil {
+			t.Fatal(err)
+		}
+		return b, rec.Body.Bytes()
+	}
+	importLists := func(body []byte) (int, map[string]models.BundleResult) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodPost, "/api/lists/import", bytes.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		dstE.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			return rec.Code, nil
+		}
+
+		var res struct {
+			Data []models.BundleResult `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		out := map[string]models.BundleResult{}
+		for _, r := range res.Data {
+			out[r.Name] = r
+		}
+		return rec.Code, out
+	}
+	countLists := func() int {
+		t.Helper()
+
+		var n int
+		if err := dstDB.Get(&n, `SELECT COUNT(*) FROM lists`); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	// The export has the definitions and no subscriber data.
+	b, body := export(srcE)
+	if b.Version != models.BundleVersion || len(b.Lists) != 3 || len(b.Templates) != 0 || len(b.Campaigns) != 0 {
+		t.Fatalf("unexpected export %+v", b)
+	}
+	if strings.Contains(string(body), "user@example.com") || strings.Contains(string(body), "subscriber") {
+		t.Errorf("expected no subscriber data in the export: %s", body)
+	}
+	l := b.Lists[0]
+	if l.Name != "News" || l.Type != models.ListTypePublic || l.Optin != models.ListOptinDouble || len(l.Tags) != 2 ||
+		l.Description != "The newsletter" || l.SunsetInactiveDays.Int != 90 || l.PublicDescription != "Weekly news" || l.Frequency != "weekly" {
+		t.Errorf("unexpected list %+v", l)
+	}
+
+	// New lists are created and the one whose name is taken by a different list is skipped.
+	code, res := importLists(body)
+	if code != http.StatusOK || len(res) != 3 {
+		t.Fatalf("unexpected import %d: %+v", code, res)
+	}
+	if res["News"].Action != models.BundleActionCreated || res["Internal"].Action != models.BundleActionCreated {
+		t.Errorf("expected the lists to be created, got %+v", res)
+	}
+	if r := res["Taken"]; r.Action != models.BundleActionSkipped || r.Reason != "another list with the name exists" {
+		t.Errorf("expected the conflicting list to be skipped, got %+v", r)
+	}
+	if n := countLists(); n != 3 {
+		t.Errorf("expected 3 lists, got %d", n)
+	}
+
+	// The imported lists are identical to the exported ones, UUIDs included.
+	out, _ := export(dstE)
+	got := map[string]models.BundleList{}
+	for _, l := range out.Lists {
+		got[l.UUID] = l
+	}
+	for _, l := range b.Lists[:2] {
+		g, ok := got[l.UUID]
+		if !ok || g.Name != l.Name || g.Type != l.Type || g.Optin != l.Optin || g.Status != l.Status ||
+			strings.Join(g.Tags, ",") != strings.Join(l.Tags, ",") || g.Description != l.Description ||
+			g.SunsetInactiveDays != l.SunsetInactiveDays || g.PublicDescription != l.PublicDescription || g.Frequency != l.Frequency {
+			t.Errorf("expected %+v, got %+v", l, g)
+		}
+	}
+	var subs int
+	if err := dstDB.Get(&subs, `SELECT COUNT(*) FROM subscriber_lists`); err != nil || subs != 0 {
+		t.Errorf("expected no subscriptions to be imported, got %d: %v", subs, err)
+	}
+
+	// Importing again changes nothing.
+	_, res = importLists(body)
+	if res["News"].Action != models.BundleActionSkipped || res["News"].Reason != "unchanged" || res["Internal"].Reason != "unchanged" {
+		t.Errorf("expected the lists to be unchanged, got %+v", res)
+	}
+	if n := countLists(); n != 3 {
+		t.Errorf("expected 3 lists, got %d", n)
+	}
+
+	// Lists with matching UUIDs are updated.
+	b.Lists[0].Description = "Updated"
+	b.Lists = b.Lists[:1]
+	body, _ = json.Marshal(b)
+	if _, res = importLists(body); res["News"].Action != models.BundleActionUpdated {
+		t.Errorf("expected the list to be updated, got %+v", res)
+	}
+	var desc string
+	if err := dstDB.Get(&desc, `SELECT description FROM lists WHERE uuid = $1`, b.Lists[0].UUID); err != nil || desc != "Updated" {
+		t.Errorf("expected the description to be updated, got %q: %v", desc, err)
+	}
+
+	// Invalid bundles are rejected.
+	for _, body := range []string{`{"version": 99, "lists": []}`, `{"version": 1, "lists": [{"uuid": "nope", "name": "x"}]}`, `{"version": 1, "lists": [{"uuid": "` + b.Lists[0].UUID + `", "name": ""}]}`} {
+		if code, _ := importLists([]byte(body)); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, code)
+		}
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,19 +18,26 @@ import (
 	"github.com/knadh/koanf/providers/env"
 	"github.com/knadh/koanf/v2"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/botfilter"
 	"github.com/knadh/listmonk/internal/bounce"
+	"github.com/knadh/listmonk/internal/bounce/mailbox"
 	"github.com/knadh/listmonk/internal/buflog"
+	"github.com/knadh/listmonk/internal/capacity"
 	"github.com/knadh/listmonk/internal/captcha"
 	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/digest"
+	"github.com/knadh/listmonk/internal/emailverify"
 	"github.com/knadh/listmonk/internal/events"
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
 	"github.com/knadh/listmonk/internal/messenger/email"
 	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/internal/webhooks"
 	"github.com/knadh/listmonk/models"
 	"github.com/knadh/paginator"
 	"github.com/knadh/stuffbin"
+	"github.com/labstack/echo/v4"
 )
 
 // App contains the "global" shared components, controllers and fields.
@@ -43,10 +52,15 @@ type App struct {
 	messengers []manager.Messenger
 	emailMsgr  manager.Messenger
 	importer   *subimporter.Importer
+	digest     *digest.Digest
+	capacity   *capacity.Checker
 	auth       *auth.Auth
 	media      media.Store
 	bounce     *bounce.Manager
 	captcha    *captcha.Captcha
+	botFilter  *botfilter.Filter
+	verifier   *emailverify.Verifier
+	webhooks   *webhooks.Webhooks
 	i18n       *i18n.I18n
 	pg         *paginator.Paginator
 	events     *events.Events
@@ -63,6 +77,24 @@ type App struct {
 	// after a settings update.
 	needsRestart bool
 
+	// Settings changes made since needsRestart was set that will be applied on restart.
+	pendingRestart []pendingChange
+
+	// Set when the app is shutting down to restart on a reload signal.
+	reloading atomic.Bool
+
+	// Readiness checks for the /ready endpoint.
+	readyChecks *readyChecks
+
+	// Rate limiter for campaign autosaves.
+	draftLimiter *draftLimiter
+
+	// Cached sitemap of the public campaign archives.
+	archiveSitemap *archiveSitemap
+
+	// Background bulk e-mail verification of subscribers.
+	subVerify *subVerifyJob
+
 	// First time installation with no user records in the DB. Needs user setup.
 	needsUserSetup bool
 
@@ -93,7 +125,10 @@ var (
 	frontendDir string = "frontend/dist"
 )
 
-func init() {
+// initApp loads the config, connects to the database, and prepares the queries.
+// It runs at the start of main() rather than in init() so that the package's
+// tests don't need a config and a database.
+func initApp() {
 	// Initialize commandline flags.
 	initFlags(ko)
 
@@ -177,6 +212,8 @@ func init() {
 }
 
 func main() {
+	initApp()
+
 	var (
 		// Initialize static global config.
 		cfg = initConstConfig(ko)
@@ -195,14 +232,23 @@ func main() {
 		// Crud core.
 		core = initCore(fbOptinNotify, queries, db, i18n, ko)
 
+		// Sending limits of SMTP servers.
+		sendLimiter = initSendLimiter(queries)
+
 		// Initialize all messengers, SMTP and postback.
-		msgrs = append(initSMTPMessengers(), initPostbackMessengers(ko)...)
+		msgrs = append(initSMTPMessengers(sendLimiter), initPostbackMessengers(ko)...)
+
+		// Outbound webhooks for app events.
+		hooks = initWebhooks(ko)
 
 		// Campaign manager.
 		mgr = initCampaignManager(msgrs, queries, urlCfg, core, media, i18n, ko)
 
+		// E-mail verifier for subscriptions and imports.
+		emailVerifier = initEmailVerifier(ko)
+
 		// Bulk importer.
-		importer = initImporter(queries, db, core, i18n, ko)
+		importer = initImporter(queries, db, core, emailVerifier, i18n, ko)
 
 		// Initialize the auth manager.
 		hasUsers, auth = initAuth(core, db.DB, ko)
@@ -218,7 +264,21 @@ func main() {
 	// Initialize the bounce manager that processes bounces from webhooks and
 	// POP3 mailbox scanning.
 	if ko.Bool("bounce.enabled") {
-		bounce = initBounceManager(core.RecordBounce, queries.RecordBounce, lo, ko)
+		unsubReply := func(r mailbox.Reply) error {
+			return core.UnsubscribeByReply(r.SubscriberUUID, r.CampaignUUID)
+		}
+		subEmail := func(uuid string) (string, error) {
+			sub, err := core.GetSubscriber(0, uuid, "")
+			if err != nil {
+				// The subscriber doesn't exist.
+				if e, ok := err.(*echo.HTTPError); ok && e.Code == http.StatusBadRequest {
+					return "", nil
+				}
+				return "", err
+			}
+			return sub.Email, nil
+		}
+		bounce = initBounceManager(core.RecordBounce, importer.SanitizeEmail, unsubReply, subEmail, queries.RecordBounce, lo, ko)
 	}
 
 	// Assign the default `email` messenger to the app.
@@ -229,23 +289,48 @@ func main() {
 	}
 
 	// Initialize the global admin/sub e-mail notifier.
-	initNotifs(fs, i18n, emailMsgr, urlCfg, ko)
+	initNotifs(fs, i18n, emailMsgr, urlCfg, core, ko)
 
 	// Initialize and cache tx templates in memory.
 	initTxTemplates(mgr, core)
 
+	// Start evaluating the security alert rules over the login audit data.
+	go initSecurityAlerter(core, i18n).Run()
+
+	// Periodic check of the storage usage with alerts to admins.
+	capChecker := initCapacityChecker(core, i18n)
+	go capChecker.Run()
+
+	// Start running the queued subscriber imports, resuming any that were interrupted.
+	go importer.Run()
+
 	// Initialize the bounce manager that processes bounces from webhooks and
 	// POP3 mailbox scanning.
 	if ko.Bool("bounce.enabled") {
+		bounce.SetEventHandler(hooks.Emit)
+
+		// Maintain live bounce counts of running campaigns, starting from
+		// the counts already in the DB, eg: when a paused campaign resumes.
+		mgr.SetCampaignHandlers(func(c *models.Campaign) {
+			// Errors are logged by core and the counts start from zero.
+			counts, _ := core.GetCampaignBounceTypes(c.ID)
+			bounce.TrackCampaign(c.UUID, counts)
+		}, func(c *models.Campaign) {
+			bounce.UntrackCampaign(c.UUID)
+		})
+
 		go bounce.Run()
 	}
 
+	// Periodic activity digest report to admins.
+	dg := initDigest(core, notifyDigest(i18n))
+
 	// Start cronjobs.
-	initCron(core, db)
+	initCron(core, db, dg)
 
-	// Start the campaign manager workers. The campaign batches (fetch from DB, push out
-	// messages) get processed at the specified interval.
-	go mgr.Run()
+	// Start the webhook delivery workers and forward the manager's events to them.
+	go hooks.Run()
+	mgr.SetEventHandler(hooks.Emit)
 
 	// =========================================================================
 	// Initialize the App{} with all the global shared components, controllers and fields.
@@ -260,10 +345,15 @@ func main() {
 		messengers: msgrs,
 		emailMsgr:  emailMsgr,
 		importer:   importer,
+		digest:     dg,
+		capacity:   capChecker,
 		auth:       auth,
 		media:      media,
 		bounce:     bounce,
 		captcha:    initCaptcha(),
+		botFilter:  initBotFilter(ko),
+		verifier:   emailVerifier,
+		webhooks:   hooks,
 		i18n:       i18n,
 		log:        lo,
 		events:     evStream,
@@ -278,14 +368,38 @@ func main() {
 			AllowAll:       true,
 		}),
 
-		fnOptinNotify: fbOptinNotify,
-		about:         initAbout(queries, db),
-		chReload:      chReload,
+		fnOptinNotify:  fbOptinNotify,
+		about:          initAbout(queries, db),
+		chReload:       chReload,
+		draftLimiter:   newDraftLimiter(),
+		archiveSitemap: &archiveSitemap{},
+		subVerify:      &subVerifyJob{},
 
 		// If there are no users, then the app needs to prompt for new user setup.
 		needsUserSetup: !hasUsers,
 	}
 
+	// Apply the deliverability config from a file, eg: to bootstrap a new instance.
+	if path := ko.String("apply-deliverability-config"); path != "" {
+		if err := app.applyDeliverabilityConfigFile(path); err != nil {
+			lo.Fatalf("error applying deliverability config: %v", err)
+		}
+		lo.Printf("applied deliverability config from %s", path)
+		os.Exit(0)
+	}
+
+	// Sign the {{ MessageURL }} links in campaign messages with the app's signing key.
+	mgr.SetMessageURLSigner(func(u, campUUID, subUUID string) string {
+		return app.signURL(u, messageSignMsg(campUUID, subUUID), 0)
+	})
+
+	// Start the campaign manager workers. The campaign batches (fetch from DB, push out
+	// messages) get processed at the specified interval.
+	go mgr.Run()
+
+	// Register the readiness checks.
+	app.readyChecks = initReadyChecks(app)
+
 	// Star the update checker.
 	if ko.Bool("app.check_updates") {
 		go app.checkUpdates(versionString, time.Hour*24)
@@ -302,6 +416,9 @@ func main() {
 
 	closerWait := make(chan bool)
 	<-awaitReload(chReload, closerWait, func() {
+		// Fail readiness checks while shutting down.
+		app.reloading.Store(true)
+
 		// Stop the HTTP server.
 		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Second)
 		defer cancel()
@@ -310,6 +427,9 @@ func main() {
 		// Close the campaign manager.
 		mgr.Close()
 
+		// Persist the SMTP servers' sent counts.
+		sendLimiter.Flush()
+
 		// Close the DB pool.
 		db.Close()
 
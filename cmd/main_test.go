@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/testdb"
+	"github.com/labstack/echo/v4"
+)
+
+// newTestApp returns an App with the English language pack and no database.
+func newTestApp(t *testing.T) *App {
+	t.Helper()
+
+	b, err := os.ReadFile("../i18n/en.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := i18n.New(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &App{
+		cfg:    &Config{},
+		urlCfg: &UrlConfig{},
+		i18n:   i,
+		log:    log.New(io.Discard, "", 0),
+	}
+}
+
+// newTestAppDB returns an App whose core runs on a test database. It skips
+// the test if there's no test database.
+func newTestAppDB(t *testing.T) (*App, *testdb.DB) {
+	t.Helper()
+
+	db := testdb.New(t)
+	a := newTestApp(t)
+	a.db = db.DB
+	a.queries = db.Q
+	a.core = core.New(&core.Opt{
+		I18n:    a.i18n,
+		DB:      db.DB,
+		Queries: db.Q,
+		Log:     a.log,
+	}, &core.Hooks{})
+
+	return a, db
+}
+
+// testRenderer renders the name of the template so that tests can check which
+// page was rendered.
+type testRenderer struct{}
+
+func (testRenderer) Render(w io.Writer, name string, data any, c echo.Context) error {
+	_, err := io.WriteString(w, "tpl:"+name)
+	return err
+}
+
+// newTestEcho returns an Echo instance with the test renderer.
+func newTestEcho() *echo.Echo {
+	e := echo.New()
+	e.Renderer = testRenderer{}
+	return e
+}
+
+// doForm makes a request with an optional form body to an Echo instance.
+func doForm(e *echo.Echo, method, target string, form url.Values, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req := httptest.NewRequest(method, target, body)
+	if form != nil {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	}
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	return rec
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -20,9 +21,9 @@ func (a *App) GCSubscribers(c echo.Context) error {
 
 	switch typ {
 	case "blocklisted":
-		n, err = a.core.DeleteBlocklistedSubscribers()
+		n, err = a.reqCore(c).DeleteBlocklistedSubscribers()
 	case "orphan":
-		n, err = a.core.DeleteOrphanSubscribers()
+		n, err = a.reqCore(c).DeleteOrphanSubscribers()
 	default:
 		err = echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
 	}
@@ -45,7 +46,7 @@ func (a *App) GCSubscriptions(c echo.Context) error {
 	}
 
 	// Delete unconfirmed subscriptions from the DB in bulk.
-	n, err := a.core.DeleteUnconfirmedSubscriptions(t)
+	n, err := a.reqCore(c).DeleteUnconfirmedSubscriptions(t)
 	if err != nil {
 		return err
 	}
@@ -55,6 +56,58 @@ func (a *App) GCSubscriptions(c echo.Context) error {
 	}{n}})
 }
 
+// NormalizeSubscriberEmails reports subscribers whose e-mails are duplicates of each other
+// as per the e-mail normalization policy. With {"merge": true}, each group of duplicates
+// is merged into its oldest subscriber.
+func (a *App) NormalizeSubscriberEmails(c echo.Context) error {
+	var req struct {
+		Merge bool `json:"merge"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	out, err := a.reqCore(c).NormalizeSubscriberEmails(req.Merge)
+	if err != nil {
+		return err
+	}
+
+	if isPIIRedacted(c) {
+		for i, d := range out.Duplicates {
+			emails := make([]string, len(d.Emails))
+			for j, e := range d.Emails {
+				emails[j] = redactEmail(e)
+			}
+			out.Duplicates[i].Email = redactEmail(d.Email)
+			out.Duplicates[i].Emails = emails
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// RunSunset applies the sunset policy to inactive subscribers immediately and returns
+// the affected counts. With ?dry_run=true, only the counts are returned.
+func (a *App) RunSunset(c echo.Context) error {
+	dryRun, _ := strconv.ParseBool(c.QueryParam("dry_run"))
+
+	if !dryRun && !a.cfg.Sunset.Enabled {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("maintenance.sunsetDisabled"))
+	}
+	if !a.cfg.Privacy.IndividualTracking {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("maintenance.sunsetNeedsTracking"))
+	}
+
+	out, err := a.manager.Sunset(dryRun)
+	if err != nil {
+		a.log.Printf("error applying sunset policy: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			a.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", err.Error()))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // GCCampaignAnalytics garbage collects (deletes) campaign analytics.
 func (a *App) GCCampaignAnalytics(c echo.Context) error {
 
@@ -65,14 +118,14 @@ func (a *App) GCCampaignAnalytics(c echo.Context) error {
 
 	switch c.Param("type") {
 	case "all":
-		if err := a.core.DeleteCampaignViews(t); err != nil {
+		if err := a.reqCore(c).DeleteCampaignViews(t); err != nil {
 			return err
 		}
-		err = a.core.DeleteCampaignLinkClicks(t)
+		err = a.reqCore(c).DeleteCampaignLinkClicks(t)
 	case "views":
-		err = a.core.DeleteCampaignViews(t)
+		err = a.reqCore(c).DeleteCampaignViews(t)
 	case "clicks":
-		err = a.core.DeleteCampaignLinkClicks(t)
+		err = a.reqCore(c).DeleteCampaignLinkClicks(t)
 	default:
 		err = echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
 	}
@@ -84,6 +137,16 @@ func (a *App) GCCampaignAnalytics(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// DeleteSimulationData deletes the send and view records of simulated campaign runs.
+func (a *App) DeleteSimulationData(c echo.Context) error {
+	out, err := a.reqCore(c).DeleteSimulationData()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // RunDBVacuum runs a full VACUUM on the PostgreSQL database.
 // VACUUM reclaims storage occupied by dead tuples and updates planner statistics.
 func RunDBVacuum(db *sqlx.DB, lo *log.Logger) {
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"time"
 	"github.com/gofrs/uuid/v5"
+	"github.com/jmoiron/sqlx"
 	"github.com/knadh/listmonk/internal/core"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/media"
+	"github.com/knadh/listmonk/internal/shortlink"
 	"github.com/knadh/listmonk/models"
 	"github.com/lib/pq"
 )
@@ -13,9 +24,17 @@ import (
 // store implements DataSource over the primary
 // database.
 type store struct {
+	db      *sqlx.DB
 	queries *models.Queries
 	core    *core.Core
 	media   media.Store
+
+	// Whether scheduled campaigns need to be approved before they're started.
+	requireApproval bool
+
+	// Timeout for background queries that overrides the connections'
+	// (interactive) statement_timeout. 0 = no timeout.
+	timeout time.Duration
 }
 
 type runningCamp struct {
@@ -26,21 +45,87 @@ type runningCamp struct {
 	ListID           sql.NullInt64 `db:"list_id"`
 }
 
-func newManagerStore(q *models.Queries, c *core.Core, m media.Store) *store {
+func newManagerStore(db *sqlx.DB, q *models.Queries, c *core.Core, m media.Store, requireApproval bool, timeout time.Duration) *store {
 	return &store{
-		queries: q,
-		core:    c,
-		media:   m,
+		db:              db,
+		queries:         q,
+		core:            c,
+		media:           m,
+		requireApproval: requireApproval,
+		timeout:         timeout,
+	}
+}
+
+// ctx returns a background context for store queries that's bounded by the
+// background timeout.
+func (s *store) ctx() (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), s.timeout)
+}
+
+// dbErr wraps errors caused by a lost connection to the database, eg: on a network
+// blip, failover, or restart, with manager.ErrDBUnavailable so that the manager
+// backs off and retries instead of failing the campaigns.
+func dbErr(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var (
+		pqErr  *pq.Error
+		netErr net.Error
+	)
+	switch {
+	// Query timeouts and cancellations aren't connection errors. They're checked
+	// first as context.DeadlineExceeded is also a net.Error.
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled):
+		return err
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &netErr):
+	case errors.As(err, &pqErr):
+		// Class 08 (connection_exception), admin_shutdown, crash_shutdown, cannot_connect_now.
+		if pqErr.Code.Class() != "08" && pqErr.Code != "57P01" && pqErr.Code != "57P02" && pqErr.Code != "57P03" {
+			return err
+		}
+	default:
+		return err
+	}
+
+	return fmt.Errorf("%w: %w", manager.ErrDBUnavailable, err)
+}
+
+// withTimeout runs a long running background query in a transaction that overrides
+// the connection's statement_timeout with the background timeout.
+func (s *store) withTimeout(ctx context.Context, fn func(tx *sqlx.Tx) error) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("SET LOCAL statement_timeout = %d", s.timeout.Milliseconds())); err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // NextCampaigns retrieves active campaigns ready to be processed excluding
 // campaigns that are also being processed. Additionally, it takes a map of campaignID:sentCount
 // of campaigns that are being processed and updates them in the DB.
 func (s *store) NextCampaigns(currentIDs []int64, sentCounts []int64) ([]*models.Campaign, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
 	var out []*models.Campaign
-	err := s.queries.NextCampaigns.Select(&out, pq.Int64Array(currentIDs), pq.Int64Array(sentCounts))
-	return out, err
+	err := s.queries.NextCampaigns.SelectContext(ctx, &out, pq.Int64Array(currentIDs), pq.Int64Array(sentCounts), s.requireApproval)
+	return out, dbErr(err)
 }
 
 // NextSubscribers retrieves a subset of subscribers of a given campaign.
@@ -48,9 +133,26 @@ func (s *store) NextCampaigns(currentIDs []int64, sentCounts []int64) ([]*models
 // and every batch takes the last ID of the last batch and fetches the next
 // batch above that.
 func (s *store) NextSubscribers(campID, limit int) ([]models.Subscriber, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
 	var camps []runningCamp
-	if err := s.queries.GetRunningCampaign.Select(&camps, campID); err != nil {
-		return nil, err
+	if err := s.queries.GetRunningCampaign.SelectContext(ctx, &camps, campID); err != nil {
+		return nil, dbErr(err)
+	}
+
+	if len(camps) == 0 {
+		return nil, nil
+	}
+
+	// Ad-hoc campaigns are sent to their uploaded recipients instead of list subscribers.
+	if camps[0].CampaignType == models.CampaignTypeAdhoc {
+		var out []models.Subscriber
+		err := s.withTimeout(ctx, func(tx *sqlx.Tx) error {
+			return tx.StmtxContext(ctx, s.queries.NextCampaignRecipients).SelectContext(ctx, &out,
+				camps[0].CampaignID, camps[0].LastSubscriberID, camps[0].MaxSubscriberID, limit)
+		})
+		return out, dbErr(err)
 	}
 
 	var listIDs []int
@@ -65,32 +167,129 @@ func (s *store) NextSubscribers(campID, limit int) ([]models.Subscriber, error)
 	}
 
 	var out []models.Subscriber
-	err := s.queries.NextCampaignSubscribers.Select(&out, camps[0].CampaignID, camps[0].CampaignType, camps[0].LastSubscriberID, camps[0].MaxSubscriberID, pq.Array(listIDs), limit)
-	return out, err
+	err := s.withTimeout(ctx, func(tx *sqlx.Tx) error {
+		return tx.StmtxContext(ctx, s.queries.NextCampaignSubscribers).SelectContext(ctx, &out,
+			camps[0].CampaignID, camps[0].CampaignType, camps[0].LastSubscriberID, camps[0].MaxSubscriberID, pq.Array(listIDs), limit)
+	})
+	return out, dbErr(err)
 }
 
 // GetCampaign fetches a campaign from the database.
 func (s *store) GetCampaign(campID int) (*models.Campaign, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
 	var out = &models.Campaign{}
-	err := s.queries.GetCampaign.Get(out, campID, nil, nil, "default")
-	return out, err
+	err := s.queries.GetCampaign.GetContext(ctx, out, campID, nil, nil, "default")
+	return out, dbErr(err)
 }
 
 // UpdateCampaignStatus updates a campaign's status.
 func (s *store) UpdateCampaignStatus(campID int, status string) error {
-	_, err := s.queries.UpdateCampaignStatus.Exec(campID, status)
-	return err
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	_, err := s.queries.UpdateCampaignStatus.ExecContext(ctx, campID, status)
+	return dbErr(err)
 }
 
 // UpdateCampaignCounts updates a campaign's status.
 func (s *store) UpdateCampaignCounts(campID int, toSend int, sent int, lastSubID int) error {
-	_, err := s.queries.UpdateCampaignCounts.Exec(campID, toSend, sent, lastSubID)
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	_, err := s.queries.UpdateCampaignCounts.ExecContext(ctx, campID, toSend, sent, lastSubID)
+	return dbErr(err)
+}
+
+// RecordCampaignSends records the sends of a campaign to a batch of subscribers
+// in their send history.
+func (s *store) RecordCampaignSends(campID int, subIDs []int64, messenger string) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	_, err := s.queries.InsertCampaignSends.ExecContext(ctx, campID, pq.Int64Array(subIDs), messenger)
+	return err
+}
+
+// UpdateCampaignErrors saves the sampled send errors of a campaign.
+func (s *store) UpdateCampaignErrors(campID int, errs []models.CampaignSendError) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	b, err := json.Marshal(errs)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.queries.UpdateCampaignSendErrors.ExecContext(ctx, campID, b)
 	return err
 }
 
+// UpdateCampaignHygiene computes and saves the bounce hygiene summary of a campaign.
+func (s *store) UpdateCampaignHygiene(campID int) (models.CampaignHygiene, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	return s.core.WithContext(ctx).UpdateCampaignHygiene(campID)
+}
+
+// NextSystemMessages claims the queued system e-mails that are due for a retry.
+func (s *store) NextSystemMessages(limit int) ([]models.SystemMessage, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	return s.core.WithContext(ctx).NextSystemMessages(limit)
+}
+
+// RecordSystemMessageAttempt records an attempt at sending a queued system e-mail.
+func (s *store) RecordSystemMessageAttempt(id int, sendErr error) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	return s.core.WithContext(ctx).RecordSystemMessageAttempt(id, sendErr)
+}
+
+// RollupCampaignStats computes and saves the stats rollups of finished campaigns.
+func (s *store) RollupCampaignStats(campIDs []int, settle time.Duration, limit int) (int, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	return s.core.WithContext(ctx).RollupCampaignStats(campIDs, settle, limit)
+}
+
+// DeleteStaleCampaignRecipients deletes the ad-hoc recipients of campaigns that
+// ended longer than the retention period ago.
+func (s *store) DeleteStaleCampaignRecipients(retention time.Duration) (int, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	var n int
+	err := s.withTimeout(ctx, func(tx *sqlx.Tx) error {
+		return tx.StmtxContext(ctx, s.queries.DeleteStaleRecipients).GetContext(ctx, &n, retention.Seconds())
+	})
+	return n, err
+}
+
+// DeleteStaleAttribHistory deletes the changes to tracked subscriber attributes
+// that are older than the retention period.
+func (s *store) DeleteStaleAttribHistory(retention time.Duration) (int, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	var n int
+	err := s.withTimeout(ctx, func(tx *sqlx.Tx) error {
+		return tx.StmtxContext(ctx, s.queries.DeleteStaleAttribHistory).GetContext(ctx, &n, retention.Seconds())
+	})
+	return n, err
+}
+
 // GetAttachment fetches a media attachment blob.
 func (s *store) GetAttachment(mediaID int) (models.Attachment, error) {
-	m, err := s.core.GetMedia(mediaID, "", "", s.media)
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	m, err := s.core.WithContext(ctx).GetMedia(mediaID, "", "", s.media)
 	if err != nil {
 		return models.Attachment{}, err
 	}
@@ -107,18 +306,199 @@ func (s *store) GetAttachment(mediaID int) (models.Attachment, error) {
 	}, nil
 }
 
-// CreateLink registers a URL with a UUID for tracking clicks and returns the UUID.
-func (s *store) CreateLink(url string) (string, error) {
+// CreateLink registers a URL with a UUID and a short link slug for tracking clicks
+// and returns them. Slugs are random and are regenerated on collisions.
+func (s *store) CreateLink(url string) (string, string, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
 	// Create a new UUID for the URL. If the URL already exists in the DB
 	// the UUID in the database is returned.
 	uu, err := uuid.NewV4()
 	if err != nil {
-		return "", err
+		return "", "", err
+	}
+
+	for n := 0; ; n++ {
+		slug, err := shortlink.NewSlug(n)
+		if err != nil {
+			return "", "", err
+		}
+
+		var out struct {
+			UUID string `db:"uuid"`
+			Slug string `db:"slug"`
+		}
+		err = s.queries.CreateLink.GetContext(ctx, &out, uu, url, slug)
+		if err == nil {
+			return out.UUID, out.Slug, nil
+		}
+
+		if pqErr, ok := err.(*pq.Error); !ok || pqErr.Constraint != "links_slug_key" || n+1 >= shortlink.MaxSlugAttempts {
+			return "", "", err
+		}
+	}
+}
+
+// EnrollSequenceSubscribers enrolls new list subscribers into sequences.
+func (s *store) EnrollSequenceSubscribers() error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	return s.withTimeout(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.StmtxContext(ctx, s.queries.EnrollSequenceSubscribers).ExecContext(ctx)
+		return err
+	})
+}
+
+// NextSequenceMessages claims a batch of subscribers whose next sequence step is due.
+func (s *store) NextSequenceMessages(limit int) ([]models.SequenceMessage, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	var out []models.SequenceMessage
+	err := s.withTimeout(ctx, func(tx *sqlx.Tx) error {
+		return tx.StmtxContext(ctx, s.queries.NextSequenceMessages).SelectContext(ctx, &out, limit)
+	})
+	return out, err
+}
+
+// RecordSequenceSends counts claimed sequence messages that were sent towards
+// their campaigns.
+func (s *store) RecordSequenceSends(msgs []models.SequenceMessage) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	var (
+		seqIDs  = make([]int, len(msgs))
+		subIDs  = make([]int, len(msgs))
+		campIDs = make([]int, len(msgs))
+	)
+	for i, m := range msgs {
+		seqIDs[i], subIDs[i], campIDs[i] = m.SequenceID, m.ID, m.CampaignID
+	}
+
+	return s.withTimeout(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.StmtxContext(ctx, s.queries.RecordSequenceSends).ExecContext(ctx,
+			pq.Array(seqIDs), pq.Array(subIDs), pq.Array(campIDs))
+		return err
+	})
+}
+
+// ReleaseSequenceMessages releases the claims of sequence messages that failed
+// to be sent so that their steps are retried after the backoff.
+func (s *store) ReleaseSequenceMessages(msgs []models.SequenceMessage, backoff time.Duration, maxAttempts int) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	var (
+		seqIDs = make([]int, len(msgs))
+		subIDs = make([]int, len(msgs))
+		steps  = make([]int, len(msgs))
+	)
+	for i, m := range msgs {
+		seqIDs[i], subIDs[i], steps[i] = m.SequenceID, m.ID, m.Step
+	}
+
+	return s.withTimeout(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.StmtxContext(ctx, s.queries.ReleaseSequenceMessages).ExecContext(ctx,
+			pq.Array(seqIDs), pq.Array(subIDs), pq.Array(steps), int(backoff.Seconds()), maxAttempts)
+		return err
+	})
+}
+
+// PurgeUnconfirmedSubscribers deletes or anonymizes a batch of subscribers who
+// never confirmed their double opt-in subscriptions and returns the number purged.
+func (s *store) PurgeUnconfirmedSubscribers(limit int, anonymize bool) (int, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	var n int
+	err := s.withTimeout(ctx, func(tx *sqlx.Tx) error {
+		return tx.StmtxContext(ctx, s.queries.PurgeUnconfirmedSubscribers).GetContext(ctx, &n, limit, anonymize)
+	})
+	return n, err
+}
+
+// DeletePendingSubscribers deletes a batch of subscribers whose deletion was requested
+// longer than the grace period ago and returns the number deleted.
+func (s *store) DeletePendingSubscribers(graceDays, limit int) (int, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	var n int
+	err := s.withTimeout(ctx, func(tx *sqlx.Tx) error {
+		return tx.StmtxContext(ctx, s.queries.DeletePendingSubscribers).GetContext(ctx, &n, graceDays, limit)
+	})
+	return n, err
+}
+
+// SunsetSubscribers applies the sunset policy to inactive subscribers.
+func (s *store) SunsetSubscribers(p models.SunsetPolicy, dryRun bool, limit int) (models.SunsetResult, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	var out models.SunsetResult
+	err := s.withTimeout(ctx, func(tx *sqlx.Tx) error {
+		return tx.StmtxContext(ctx, s.queries.SunsetSubscribers).GetContext(ctx, &out, p.InactiveCampaigns, p.InactiveDays,
+			p.FinalCampaignID, p.GraceDays, p.DormantListID, p.Unsubscribe, dryRun, limit)
+	})
+	return out, err
+}
+
+// RecordSunsetNotices marks subscribers who were sent the sunset policy's final
+// campaign as notified.
+func (s *store) RecordSunsetNotices(campID int, subIDs []int64) error {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	return s.withTimeout(ctx, func(tx *sqlx.Tx) error {
+		_, err := tx.StmtxContext(ctx, s.queries.RecordSunsetNotices).ExecContext(ctx, pq.Array(subIDs), campID)
+		return err
+	})
+}
+
+// GetSubscribers fetches subscribers by their IDs.
+func (s *store) GetSubscribers(ids []int64) ([]models.Subscriber, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	var out []models.Subscriber
+	err := s.queries.GetSubscribersByIDs.SelectContext(ctx, &out, pq.Int64Array(ids))
+	return out, err
+}
+
+// GetTemplateAssets returns the assets of a template as a map of asset names to media URLs.
+func (s *store) GetTemplateAssets(tplID int) (map[string]string, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	assets, err := s.core.WithContext(ctx).GetTemplateAssets(tplID)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string, len(assets))
+	for name, fname := range assets {
+		out[name] = s.media.GetURL(fname)
+	}
+
+	return out, nil
+}
+
+// GetListDescriptions returns the sanitized public descriptions of all lists by list ID.
+func (s *store) GetListDescriptions() (map[int]template.HTML, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	lists, err := s.core.WithContext(ctx).GetLists("", "", true, nil)
+	if err != nil {
+		return nil, err
 	}
 
-	var out string
-	if err := s.queries.CreateLink.Get(&out, uu, url); err != nil {
-		return "", err
+	out := make(map[int]template.HTML, len(lists))
+	for _, l := range lists {
+		out[l.ID] = l.PublicDescriptionHTML()
 	}
 
 	return out, nil
@@ -126,12 +506,15 @@ func (s *store) CreateLink(url string) (string, error) {
 
 // RecordBounce records a bounce event and returns the bounce count.
 func (s *store) RecordBounce(b models.Bounce) (int64, int, error) {
+	ctx, cancel := s.ctx()
+	defer cancel()
+
 	var res = struct {
 		SubscriberID int64 `db:"subscriber_id"`
 		Num          int   `db:"num"`
 	}{}
 
-	err := s.queries.UpdateCampaignStatus.Select(&res,
+	err := s.queries.UpdateCampaignStatus.SelectContext(ctx, &res,
 		b.SubscriberUUID,
 		b.Email,
 		b.CampaignUUID,
@@ -144,12 +527,18 @@ func (s *store) RecordBounce(b models.Bounce) (int64, int, error) {
 
 // BlocklistSubscriber blocklists a subscriber permanently.
 func (s *store) BlocklistSubscriber(id int64) error {
-	_, err := s.queries.BlocklistSubscribers.Exec(pq.Int64Array{id})
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	_, err := s.queries.BlocklistSubscribers.ExecContext(ctx, pq.Int64Array{id})
 	return err
 }
 
 // DeleteSubscriber deletes a subscriber from the DB.
 func (s *store) DeleteSubscriber(id int64) error {
-	_, err := s.queries.DeleteSubscribers.Exec(pq.Int64Array{id})
+	ctx, cancel := s.ctx()
+	defer cancel()
+
+	_, err := s.queries.DeleteSubscribers.ExecContext(ctx, pq.Int64Array{id})
 	return err
 }
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/internal/testdb"
+	"github.com/knadh/listmonk/models"
+	"github.com/lib/pq"
+)
+
+func TestDBErr(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	cases := []struct {
+		name string
+		err  error
+		lost bool
+	}{
+		{"nil", nil, false},
+		{"no rows", sql.ErrNoRows, false},
+		{"query error", &pq.Error{Code: "42601"}, false},
+		{"statement timeout", &pq.Error{Code: "57014"}, false},
+		{"context deadline", ctx.Err(), false},
+		{"context canceled", context.Canceled, false},
+		{"wrapped deadline", &net.OpError{Op: "read", Err: context.DeadlineExceeded}, false},
+		{"bad conn", driver.ErrBadConn, true},
+		{"conn done", sql.ErrConnDone, true},
+		{"eof", io.ErrUnexpectedEOF, true},
+		{"net error", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"connection failure", &pq.Error{Code: "08006"}, true},
+		{"admin shutdown", &pq.Error{Code: "57P01"}, true},
+	}
+
+	for _, c := range cases {
+		err := dbErr(c.err)
+		if got := errors.Is(err, manager.ErrDBUnavailable); got != c.lost {
+			t.Errorf("%s: expected lost=%v, got %v (%v)", c.name, c.lost, got, err)
+		}
+		if c.err != nil && !errors.Is(err, c.err) {
+			t.Errorf("%s: the original error isn't wrapped: %v", c.name, err)
+		}
+	}
+}
+
+// TestDBErrDroppedConn runs a query against a server that drops connections,
+// as a proxy in front of a restarting database would, and checks that it's
+// classified as a lost connection.
+func TestDBErrDroppedConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+
+	db, err := sql.Open("postgres", "postgres://listmonk@"+ln.Addr().String()+"/listmonk?sslmode=disable&connect_timeout=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec("SELECT 1")
+	if err == nil {
+		t.Fatal("expected the query to fail")
+	}
+	if !errors.Is(dbErr(err), manager.ErrDBUnavailable) {
+		t.Fatalf("expected a lost connection, got %v", err)
+	}
+}
+
+// TestSequenceClaims checks that claimed sequence steps are counted as sent only
+// when they're recorded, and that released claims are retried and then skipped.
+func TestSequenceClaims(t *testing.T) {
+	db := testdb.New(t)
+	s := &store{db: db.DB, queries: db.Q}
+
+	var listID, seqID int
+	if err := db.Get(&listID, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'list', 'private') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	var campIDs []int
+	for _, name := range []string{"step 1", "step 2"} {
+		var id int
+		if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', 'body', 'email') RETURNING id`, name); err != nil {
+			t.Fatal(err)
+		}
+		campIDs = append(campIDs, id)
+	}
+
+	if err := db.Get(&seqID, `INSERT INTO sequences (uuid, name, list_id) VALUES (gen_random_uuid(), 'seq', $1) RETURNING id`, listID); err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec(`INSERT INTO sequence_steps (sequence_id, campaign_id, position, delay_secs) VALUES ($1, $2, 1, 0), ($1, $3, 2, 3600)`,
+		seqID, campIDs[0], campIDs[1])
+
+	for _, email := range []string{"one@example.com", "two@example.com"} {
+		var subID int
+		if err := db.Get(&subID, `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), $1, 'sub') RETURNING id`, email); err != nil {
+			t.Fatal(err)
+		}
+		db.MustExec(`INSERT INTO subscriber_lists (subscriber_id, list_id, status) VALUES ($1, $2, 'confirmed')`, subID, listID)
+		db.MustExec(`INSERT INTO sequence_state (sequence_id, subscriber_id, step, subscribed_at, next_send_at) VALUES ($1, $2, 1, NOW(), NOW())`,
+			seqID, subID)
+	}
+
+	sent := func() int {
+		var n int
+		if err := db.Get(&n, `SELECT sent FROM campaigns WHERE id = $1`, campIDs[0]); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	msgs, err := s.NextSequenceMessages(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 2 || msgs[0].Step != 1 || msgs[0].CampaignID != campIDs[0] {
+		t.Fatalf("unexpected messages %+v", msgs)
+	}
+	if n := sent(); n != 0 {
+		t.Fatalf("expected claims not to be counted as sent, got %d", n)
+	}
+
+	// The first message is sent and the second fails.
+	if err := s.RecordSequenceSends(msgs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if n := sent(); n != 1 {
+		t.Fatalf("expected 1 sent, got %d", n)
+	}
+	if err := s.ReleaseSequenceMessages(msgs[1:], time.Hour, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	type state struct {
+		Step     int    `db:"step"`
+		Status   string `db:"status"`
+		Attempts int    `db:"attempts"`
+		Later    bool   `db:"later"`
+	}
+	getState := func(subID int) state {
+		var st state
+		if err := db.Get(&st, `SELECT step, status, attempts, next_send_at > NOW() + INTERVAL '10 minutes' AS later
+			FROM sequence_state WHERE subscriber_id = $1`, subID); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	if st := getState(msgs[0].ID); st.Step != 2 || st.Status != "active" || st.Attempts != 0 {
+		t.Fatalf("unexpected state of the sent message %+v", st)
+	}
+	if st := getState(msgs[1].ID); st.Step != 1 || st.Status != "active" || st.Attempts != 1 || !st.Later {
+		t.Fatalf("expected the failed step to be retried after the backoff, got %+v", st)
+	}
+
+	// The step isn't due until the backoff is over.
+	if m, err := s.NextSequenceMessages(10); err != nil || len(m) != 0 {
+		t.Fatalf("expected no due messages, got %v %v", m, err)
+	}
+
+	// The step is skipped once it has failed the max attempts.
+	db.MustExec(`UPDATE sequence_state SET next_send_at = NOW() WHERE subscriber_id = $1`, msgs[1].ID)
+	retry, err := s.NextSequenceMessages(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retry) != 1 || retry[0].ID != msgs[1].ID || retry[0].Step != 1 {
+		t.Fatalf("expected the failed step to be claimed again, got %+v", retry)
+	}
+	if err := s.ReleaseSequenceMessages(retry, time.Hour, 2); err != nil {
+		t.Fatal(err)
+	}
+	if st := getState(msgs[1].ID); st.Step != 2 || st.Status != "active" || st.Attempts != 0 {
+		t.Fatalf("expected the failed step to be skipped, got %+v", st)
+	}
+	if n := sent(); n != 1 {
+		t.Fatalf("expected failed messages not to be counted, got %d sent", n)
+	}
+}
+
+// TestSunsetClaims checks that subscribers claimed for the sunset policy's final
+// campaign are marked notified, and start their grace period, only when the
+// notices are recorded, and that unrecorded claims expire.
+func TestSunsetClaims(t *testing.T) {
+	db := testdb.New(t)
+	s := &store{db: db.DB, queries: db.Q}
+
+	var listID, finalID int
+	if err := db.Get(&listID, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'list', 'private') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	// A campaign sent to the list recently that the subscribers didn't engage with.
+	var campID int
+	if err := db.Get(&campID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, started_at)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com', 'body', 'email', 'finished', NOW() - INTERVAL '1 day') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec(`INSERT INTO campaign_lists (campaign_id, list_id) VALUES ($1, $2)`, campID, listID)
+	if err := db.Get(&finalID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger)
+		VALUES (gen_random_uuid(), 'final', 'final', 'from@example.com', 'body', 'email') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	var subIDs []int64
+	for _, email := range []string{"one@example.com", "two@example.com"} {
+		var id int64
+		if err := db.Get(&id, `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), $1, 'sub') RETURNING id`, email); err != nil {
+			t.Fatal(err)
+		}
+		db.MustExec(`INSERT INTO subscriber_lists (subscriber_id, list_id, status, created_at) VALUES ($1, $2, 'confirmed', NOW() - INTERVAL '30 days')`,
+			id, listID)
+		subIDs = append(subIDs, id)
+	}
+
+	// An inactive subscriber pending data deletion isn't sent the final campaign.
+	var pendingID int64
+	if err := db.Get(&pendingID, `INSERT INTO subscribers (uuid, email, name, deletion_requested_at)
+		VALUES (gen_random_uuid(), 'pending@example.com', 'sub', NOW()) RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	db.MustExec(`INSERT INTO subscriber_lists (subscriber_id, list_id, status, created_at) VALUES ($1, $2, 'confirmed', NOW() - INTERVAL '30 days')`,
+		pendingID, listID)
+
+	p := models.SunsetPolicy{Enabled: true, InactiveDays: 7, FinalCampaignID: finalID, GraceDays: 14}
+	run := func() models.SunsetResult {
+		res, err := s.SunsetSubscribers(p, false, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	sent := func() int {
+		var n int
+		if err := db.Get(&n, `SELECT sent FROM campaigns WHERE id = $1`, finalID); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if res := run(); len(res.NotifyIDs) != 2 || res.InactiveSubscribers != 2 || res.Sunset != 0 {
+		t.Fatalf("expected the two subscribers not pending deletion to be claimed, got %+v", res)
+	}
+	if n := sent(); n != 0 {
+		t.Fatalf("expected claims not to be counted as sent, got %d", n)
+	}
+
+	// Claimed subscribers aren't claimed again.
+	if res := run(); len(res.NotifyIDs) != 0 || res.Sunset != 0 {
+		t.Fatalf("expected no new claims, got %+v", res)
+	}
+
+	// The notice is sent to the first subscriber only.
+	if err := s.RecordSunsetNotices(finalID, subIDs[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if n := sent(); n != 1 {
+		t.Fatalf("expected 1 sent, got %d", n)
+	}
+
+	var notified []int64
+	if err := db.Select(&notified, `SELECT subscriber_id FROM sunset_state WHERE notified_at IS NOT NULL`); err != nil {
+		t.Fatal(err)
+	}
+	if len(notified) != 1 || notified[0] != subIDs[0] {
+		t.Fatalf("expected only the first subscriber to be notified, got %v", notified)
+	}
+
+	// The unrecorded claim expires and is retried.
+	db.MustExec(`UPDATE sunset_state SET claimed_at = NOW() - INTERVAL '2 days' WHERE subscriber_id = $1`, subIDs[1])
+	if res := run(); len(res.NotifyIDs) != 1 || res.NotifyIDs[0] != subIDs[1] {
+		t.Fatalf("expected the expired claim to be retried, got %+v", res)
+	}
+
+	// Only the notified subscriber is sunset after the grace period.
+	db.MustExec(`UPDATE sunset_state SET notified_at = NOW() - INTERVAL '15 days' WHERE subscriber_id = $1`, subIDs[0])
+	db.MustExec(`UPDATE sunset_state SET claimed_at = NOW() - INTERVAL '15 days' WHERE subscriber_id = $1`, subIDs[1])
+	if res := run(); res.Sunset != 1 {
+		t.Fatalf("expected 1 sunset, got %+v", res)
+	}
+}
+
+func TestDeletePendingSubscribers(t *testing.T) {
+	db := testdb.New(t)
+	s := &store{db: db.DB, queries: db.Q}
+
+	for email, requested := range map[string]string{
+		"due@example.com":     "NOW() - INTERVAL '31 days'",
+		"grace@example.com":   "NOW() - INTERVAL '1 day'",
+		"none@example.com":    "NULL",
+		"due-two@example.com": "NOW() - INTERVAL '40 days'",
+	} {
+		db.MustExec(`INSERT INTO subscribers (uuid, email, name, deletion_requested_at)
+			VALUES (gen_random_uuid(), $1, 'sub', `+requested+`)`, email)
+	}
+
+	// Batches are deleted until there are none left.
+	if n, err := s.DeletePendingSubscribers(30, 1); err != nil || n != 1 {
+		t.Fatalf("expected 1 deleted, got %d %v", n, err)
+	}
+	if n, err := s.DeletePendingSubscribers(30, 10); err != nil || n != 1 {
+		t.Fatalf("expected 1 deleted, got %d %v", n, err)
+	}
+	if n, err := s.DeletePendingSubscribers(30, 10); err != nil || n != 0 {
+		t.Fatalf("expected none deleted, got %d %v", n, err)
+	}
+
+	var emails []string
+	if err := db.Select(&emails, `SELECT email FROM subscribers ORDER BY email`); err != nil {
+		t.Fatal(err)
+	}
+	if len(emails) != 2 || emails[0] != "grace@example.com" || emails[1] != "none@example.com" {
+		t.Fatalf("unexpected remaining subscribers %v", emails)
+	}
+}
+
+// TestPurgeUnconfirmedSubscribers checks that only the subscribers whose
+// subscriptions are all old unconfirmed double opt-in ones are purged and that
+// any confirmed subscription on another list excludes a subscriber.
+func TestPurgeUnconfirmedSubscribers(t *testing.T) {
+	db := testdb.New(t)
+	s := &store{db: db.DB, queries: db.Q}
+
+	lists := map[string]int{}
+	for _, l := range []struct {
+		name  string
+		optin string
+		days  int
+	}{
+		{"purge", "double", 7},
+		{"double", "double", 0},
+		{"single", "single", 0},
+		{"slow", "double", 30},
+	} {
+		var id int
+		if err := db.Get(&id, `INSERT INTO lists (uuid, name, type, optin, purge_unconfirmed_after_days)
+			VALUES (gen_random_uuid(), $1, 'private', $2, $3) RETURNING id`, l.name, l.optin, l.days); err != nil {
+			t.Fatal(err)
+		}
+		lists[l.name] = id
+	}
+
+	type sub struct {
+		list   string
+		status string
+		age    string
+	}
+	subs := map[string][]sub{
+		// Purged.
+		"only@example.com": {{"purge", "unconfirmed", "10 days"}},
+		"two@example.com":  {{"purge", "unconfirmed", "10 days"}, {"double", "unconfirmed", "1 day"}},
+
+		// Confirmed, unsubscribed, or single opt-in subscriptions on other lists exclude subscribers.
+		"confirmed@example.com": {{"purge", "unconfirmed", "10 days"}, {"double", "confirmed", "10 days"}},
+		"unsub@example.com":     {{"purge", "unconfirmed", "10 days"}, {"double", "unsubscribed", "10 days"}},
+		"single@example.com":    {{"purge", "unconfirmed", "10 days"}, {"single", "unconfirmed", "10 days"}},
+
+		// Subscriptions within the thresholds.
+		"slow@example.com":   {{"purge", "unconfirmed", "10 days"}, {"slow", "unconfirmed", "10 days"}},
+		"recent@example.com": {{"purge", "unconfirmed", "1 day"}},
+	}
+	for email, sl := range subs {
+		var id int
+		if err := db.Get(&id, `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), $1, 'sub') RETURNING id`, email); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range sl {
+			db.MustExec(`INSERT INTO subscriber_lists (subscriber_id, list_id, status, updated_at)
+				VALUES ($1, $2, $3, NOW() - $4::INTERVAL)`, id, lists[l.list], l.status, l.age)
+		}
+	}
+
+	// Blocklisted subscribers are left alone.
+	db.MustExec(`WITH s AS (INSERT INTO subscribers (uuid, email, name, status)
+		VALUES (gen_random_uuid(), 'blocked@example.com', 'sub', 'blocklisted') RETURNING id)
+		INSERT INTO subscriber_lists (subscriber_id, list_id, status, updated_at)
+		SELECT id, $1, 'unconfirmed', NOW() - INTERVAL '10 days' FROM s`, lists["purge"])
+
+	// Batches are purged until there are none left.
+	if n, err := s.PurgeUnconfirmedSubscribers(1, false); err != nil || n != 1 {
+		t.Fatalf("expected 1 purged, got %d %v", n, err)
+	}
+	if n, err := s.PurgeUnconfirmedSubscribers(10, false); err != nil || n != 1 {
+		t.Fatalf("expected 1 purged, got %d %v", n, err)
+	}
+	if n, err := s.PurgeUnconfirmedSubscribers(10, false); err != nil || n != 0 {
+		t.Fatalf("expected none purged, got %d %v", n, err)
+	}
+
+	var emails []string
+	if err := db.Select(&emails, `SELECT email FROM subscribers ORDER BY email`); err != nil {
+		t.Fatal(err)
+	}
+	exp := []string{"blocked@example.com", "confirmed@example.com", "recent@example.com", "single@example.com", "slow@example.com", "unsub@example.com"}
+	if len(emails) != len(exp) {
+		t.Fatalf("expected %v to remain, got %v", exp, emails)
+	}
+	for i := range exp {
+		if emails[i] != exp[i] {
+			t.Fatalf("expected %v to remain, got %v", exp, emails)
+		}
+	}
+
+	// Anonymized subscribers are kept without their data and subscriptions.
+	db.MustExec(`UPDATE subscriber_lists SET updated_at = NOW() - INTERVAL '10 days'
+		WHERE subscriber_id = (SELECT id FROM subscribers WHERE email = 'recent@example.com')`)
+	if n, err := s.PurgeUnconfirmedSubscribers(10, true); err != nil || n != 1 {
+		t.Fatalf("expected 1 anonymized, got %d %v", n, err)
+	}
+
+	var anon struct {
+		Email string `db:"email"`
+		Name  string `db:"name"`
+		Lists int    `db:"lists"`
+	}
+	if err := db.Get(&anon, `SELECT email, name, (SELECT COUNT(*) FROM subscriber_lists WHERE subscriber_id = s.id) AS lists
+		FROM subscribers s WHERE email LIKE '%@anonymized.invalid'`); err != nil {
+		t.Fatal(err)
+	}
+	if anon.Name != "Anonymous" || anon.Lists != 0 {
+		t.Fatalf("unexpected anonymized subscriber %+v", anon)
+	}
+}
@@ -56,7 +56,7 @@ func (a *App) UploadMedia(c echo.Context) error {
 	fName := makeFilename(file.Filename)
 
 	// If the filename already exists in the DB, make it unique by adding a random suffix.
-	if _, err := a.core.GetMedia(0, "", fName, a.media); err == nil {
+	if _, err := a.reqCore(c).GetMedia(0, "", fName, a.media); err == nil {
 		suffix, err := generateRandomString(6)
 		if err != nil {
 			a.log.Printf("error generating random string: %v", err)
@@ -130,7 +130,7 @@ func (a *App) UploadMedia(c echo.Context) error {
 	}
 
 	// Insert the media into the DB.
-	m, err := a.core.InsertMedia(fName, thumbfName, contentType, meta, a.cfg.MediaUpload.Provider, a.media)
+	m, err := a.reqCore(c).InsertMedia(fName, thumbfName, contentType, meta, a.cfg.MediaUpload.Provider, a.media)
 	if err != nil {
 		cleanUp = true
 		return err
@@ -147,26 +147,19 @@ func (a *App) GetAllMedia(c echo.Context) error {
 		pg = a.pg.NewFromURL(c.Request().URL.Query())
 	)
 	// Fetch the media items from the DB.
-	res, total, err := a.core.QueryMedia(a.cfg.MediaUpload.Provider, a.media, query, pg.Offset, pg.Limit)
+	res, total, err := a.reqCore(c).QueryMedia(a.cfg.MediaUpload.Provider, a.media, query, pg.Offset, pg.Limit, skipTotal(c))
 	if err != nil {
 		return err
 	}
 
-	out := models.PageResults{
-		Results: res,
-		Total:   total,
-		Page:    pg.Page,
-		PerPage: pg.PerPage,
-	}
-
-	return c.JSON(http.StatusOK, okResp{out})
+	return c.JSON(http.StatusOK, okResp{makePageResults(res, total, pg)})
 }
 
 // GetMedia handles retrieval of a media item by ID.
 func (a *App) GetMedia(c echo.Context) error {
 	// Fetch the media item from the DB.
 	id := getID(c)
-	out, err := a.core.GetMedia(id, "", "", a.media)
+	out, err := a.reqCore(c).GetMedia(id, "", "", a.media)
 	if err != nil {
 		return err
 	}
@@ -177,9 +170,19 @@ func (a *App) GetMedia(c echo.Context) error {
 // DeleteMedia handles deletion of uploaded media.
 func (a *App) DeleteMedia(c echo.Context) error {
 
-	// Delete the media from the DB. The query returns the filename.
+	// Media used as template assets can't be deleted.
 	id := getID(c)
-	fname, err := a.core.DeleteMedia(id)
+	tpls, err := a.reqCore(c).GetMediaTemplates(id)
+	if err != nil {
+		return err
+	}
+	if len(tpls) > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("media.inUseByTemplates", "names", strings.Join(tpls, ", ")))
+	}
+
+	// Delete the media from the DB. The query returns the filename.
+	fname, err := a.reqCore(c).DeleteMedia(id)
 	if err != nil {
 		return err
 	}
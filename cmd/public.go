@@ -2,32 +2,44 @@ package main
 
 import (
 	"bytes"
-	"database/sql"
 	"fmt"
 	"html/template"
 	"image"
 	"image/png"
 	"io"
 	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	txttpl "text/template"
+	"time"
 
 	"github.com/knadh/listmonk/internal/captcha"
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/shortlink"
+	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
+	"github.com/knadh/stuffbin"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
 )
 
 const (
 	tplMessage = "message"
+
+	// Limits of the unsubscribe reason choices and the free text comment.
+	maxUnsubReasons    = 20
+	unsubReasonMaxLen  = 200
+	unsubCommentMaxLen = 1000
 )
 
 // tplRenderer wraps a template.tplRenderer for echo.
 type tplRenderer struct {
-	templates           *template.Template
 	SiteName            string
 	RootURL             string
 	LogoURL             string
@@ -36,6 +48,15 @@ type tplRenderer struct {
 	EnablePublicSubPage bool
 	EnablePublicArchive bool
 	IndividualTracking  bool
+
+	// Public templates that can be reloaded at runtime with the overrides
+	// in app.public_templates_dir, and the names of the overridden files.
+	templates  *template.Template
+	overridden []string
+	mut        sync.RWMutex
+
+	fs    stuffbin.FileSystem
+	funcs template.FuncMap
 }
 
 // tplData is the data container that is injected
@@ -68,6 +89,38 @@ type unsubTpl struct {
 	AllowWipe        bool
 	AllowPreferences bool
 	ShowManage       bool
+	UnsubReasons     models.UnsubscribeReasons
+}
+
+// subFormReq is a subscription request from public HTML forms and the public API.
+type subFormReq struct {
+	Name          string   `form:"name" json:"name"`
+	Email         string   `form:"email" json:"email"`
+	FormListUUIDs []string `form:"l" json:"list_uuids"`
+
+	// Captcha response for public API requests.
+	Captcha string `form:"captcha" json:"captcha"`
+}
+
+// Machine-readable error codes returned by the public subscription APIs.
+const (
+	pubErrInvalidRequest    = "invalid_request"
+	pubErrInvalidCaptcha    = "invalid_captcha"
+	pubErrInvalidEmail      = "invalid_email"
+	pubErrDomainBlocked     = "domain_blocked"
+	pubErrAlreadySubscribed = "already_subscribed"
+	pubErrAlreadyConfirmed  = "already_confirmed"
+	pubErrNoLists           = "no_lists"
+	pubErrNotFound          = "not_found"
+	pubErrDisabled          = "feature_disabled"
+	pubErrRateLimited       = "rate_limited"
+	pubErrInternal          = "internal_error"
+)
+
+// publicAPIError is the JSON error response of the public subscription APIs.
+type publicAPIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
 }
 
 type optinReq struct {
@@ -100,11 +153,14 @@ type subFormTpl struct {
 
 var (
 	pixelPNG = drawTransparentImage(3, 14)
+
+	// regexpLinkTplTag matches {{ .Subscriber.UUID }} style placeholders in tracked URLs.
+	regexpLinkTplTag = regexp.MustCompile(`{{[^{}]*}}`)
 )
 
 // Render executes and renders a template for echo.
 func (t *tplRenderer) Render(w io.Writer, name string, data any, c echo.Context) error {
-	return t.templates.ExecuteTemplate(w, name, tplData{
+	return t.getTemplates().ExecuteTemplate(w, name, tplData{
 		SiteName:            t.SiteName,
 		RootURL:             t.RootURL,
 		LogoURL:             t.LogoURL,
@@ -122,33 +178,40 @@ func (t *tplRenderer) Render(w io.Writer, name string, data any, c echo.Context)
 // required to submit a subscription.
 func (a *App) GetPublicLists(c echo.Context) error {
 	// Get all public lists.
-	lists, err := a.core.GetLists(models.ListTypePublic, models.ListStatusActive, true, nil)
+	lists, err := a.reqCore(c).GetLists(models.ListTypePublic, models.ListStatusActive, true, nil)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.errorFetchingLists"))
 	}
 
 	type list struct {
-		UUID string `json:"uuid"`
-		Name string `json:"name"`
+		UUID              string        `json:"uuid"`
+		Name              string        `json:"name"`
+		PublicDescription template.HTML `json:"public_description"`
+		Frequency         string        `json:"frequency"`
 	}
 
 	out := make([]list, 0, len(lists))
 	for _, l := range lists {
 		out = append(out, list{
-			UUID: l.UUID,
-			Name: l.Name,
+			UUID:              l.UUID,
+			Name:              l.Name,
+			PublicDescription: l.PublicDescriptionHTML(),
+			Frequency:         l.Frequency,
 		})
 	}
 
 	return c.JSON(http.StatusOK, out)
 }
 
-// ViewCampaignMessage renders the HTML view of a campaign message.
-// This is the view the {{ MessageURL }} template tag links to in e-mail campaigns.
+// ViewCampaignMessage renders the HTML view of a campaign message with the
+// subscriber's data. This is the view the signed {{ MessageURL }} template tag
+// links to in e-mail campaigns. Links in the view aren't tracked. If the signature
+// is invalid or the subscriber no longer exists, the campaign's archive page is
+// shown instead if the campaign is in the archive.
 func (a *App) ViewCampaignMessage(c echo.Context) error {
 	// Get the campaign.
 	campUUID := c.Param("campUUID")
-	camp, err := a.core.GetCampaign(0, campUUID, "")
+	camp, err := a.reqCore(c).GetCampaign(0, campUUID, "")
 	if err != nil {
 		if er, ok := err.(*echo.HTTPError); ok {
 			if er.Code == http.StatusBadRequest {
@@ -161,21 +224,28 @@ func (a *App) ViewCampaignMessage(c echo.Context) error {
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
 	}
 
-	// Get the subscriber.
+	// The view has the subscriber's personal data and shouldn't be cached.
+	c.Response().Header().Set("Cache-Control", "private, no-store")
+
+	// Get the subscriber if the URL is signed for them.
 	subUUID := c.Param("subUUID")
-	sub, err := a.core.GetSubscriber(0, subUUID, "")
+	if !a.verifySignedURL(c, messageSignMsg(campUUID, subUUID)) {
+		return a.viewCampaignArchive(c, camp)
+	}
+
+	sub, err := a.reqCore(c).GetSubscriber(0, subUUID, "")
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return c.Render(http.StatusNotFound, tplMessage,
-				makeMsgTpl(a.i18n.T("public.notFoundTitle"), "", a.i18n.T("public.errorFetchingEmail")))
+		// Subscriber doesn't exist, eg: deleted.
+		if er, ok := err.(*echo.HTTPError); ok && er.Code == http.StatusBadRequest {
+			return a.viewCampaignArchive(c, camp)
 		}
 
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
 	}
 
-	// Compile the template.
-	if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
+	// Compile the template without link and view tracking.
+	if err := camp.CompileTemplate(a.manager.ViewTemplateFuncs(&camp)); err != nil {
 		a.log.Printf("error compiling template: %v", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingCampaign")))
@@ -192,6 +262,25 @@ func (a *App) ViewCampaignMessage(c echo.Context) error {
 	return c.HTML(http.StatusOK, string(msg.Body()))
 }
 
+// viewCampaignArchive redirects a request for the view of a campaign message that
+// can't be rendered for the subscriber to the campaign's archive page. Only public
+// and password protected archive campaigns are accessible this way as unlisted ones
+// require a signed archive URL.
+func (a *App) viewCampaignArchive(c echo.Context, camp models.Campaign) error {
+	if !a.cfg.EnablePublicArchive || !camp.Archive || camp.Type != models.CampaignTypeRegular ||
+		(camp.ArchiveAccess != models.CampaignArchiveAccessPublic && camp.ArchiveAccess != models.CampaignArchiveAccessPassword) {
+		return c.Render(http.StatusNotFound, tplMessage,
+			makeMsgTpl(a.i18n.T("public.notFoundTitle"), "", a.i18n.T("public.campaignNotFound")))
+	}
+
+	return c.Redirect(http.StatusFound, a.archiveCampURL(camp))
+}
+
+// messageSignMsg returns the message that's signed in {{ MessageURL }} links.
+func messageSignMsg(campUUID, subUUID string) string {
+	return "message:" + campUUID + ":" + subUUID
+}
+
 // SubscriptionPage renders the subscription management page and handles unsubscriptions.
 // This is the view that {{ UnsubscribeURL }} in campaigns link to.
 func (a *App) SubscriptionPage(c echo.Context) error {
@@ -201,7 +290,7 @@ func (a *App) SubscriptionPage(c echo.Context) error {
 	)
 
 	// Get the subscriber from the DB.
-	s, err := a.core.GetSubscriber(0, subUUID, "")
+	s, err := a.reqCore(c).GetSubscriber(0, subUUID, "")
 	if err != nil {
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
@@ -216,6 +305,7 @@ func (a *App) SubscriptionPage(c echo.Context) error {
 		AllowExport:      a.cfg.Privacy.AllowExport,
 		AllowWipe:        a.cfg.Privacy.AllowWipe,
 		AllowPreferences: a.cfg.Privacy.AllowPreferences,
+		UnsubReasons:     a.cfg.Privacy.UnsubscribeReasons,
 	}
 
 	// If the subscriber is blocklisted, throw an error.
@@ -228,7 +318,7 @@ func (a *App) SubscriptionPage(c echo.Context) error {
 		out.ShowManage = showManage
 
 		// Get the subscriber's lists from the DB to render in the template.
-		subs, err := a.core.GetSubscriptions(0, subUUID, false)
+		subs, err := a.reqCore(c).GetSubscriptions(0, subUUID, false)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.errorFetchingLists"))
 		}
@@ -257,6 +347,8 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 		ListUUIDs []string `form:"l" json:"list_uuids"`
 		Blocklist bool     `form:"blocklist" json:"blocklist"`
 		Manage    bool     `form:"manage" json:"manage"`
+		Reason    string   `form:"reason" json:"reason"`
+		Comment   string   `form:"comment" json:"comment"`
 	}
 	if err := c.Bind(&req); err != nil {
 		return c.Render(http.StatusBadRequest, tplMessage,
@@ -270,10 +362,11 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 		blocklist = a.cfg.Privacy.AllowBlocklist && req.Blocklist
 	)
 	if !req.Manage || blocklist {
-		if err := a.core.UnsubscribeByCampaign(subUUID, campUUID, blocklist); err != nil {
+		if err := a.reqCore(c).UnsubscribeByCampaign(subUUID, campUUID, blocklist); err != nil {
 			return c.Render(http.StatusInternalServerError, tplMessage,
 				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.errorProcessingRequest")))
 		}
+		a.recordUnsubReason(c, subUUID, campUUID, req.Reason, req.Comment)
 
 		return c.Render(http.StatusOK, tplMessage,
 			makeMsgTpl(a.i18n.T("public.unsubbedTitle"), "", a.i18n.T("public.unsubbedInfo")))
@@ -293,7 +386,7 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 	}
 
 	// Get the subscriber from the DB.
-	sub, err := a.core.GetSubscriber(0, subUUID, "")
+	sub, err := a.reqCore(c).GetSubscriber(0, subUUID, "")
 	if err != nil {
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("globals.messages.pFound",
@@ -302,7 +395,7 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 	sub.Name = req.Name
 
 	// Update the subscriber properties in the DB.
-	if _, err := a.core.UpdateSubscriber(sub.ID, sub); err != nil {
+	if _, err := a.reqCore(c).UpdateSubscriber(sub.ID, sub); err != nil {
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.errorProcessingRequest")))
 	}
@@ -315,7 +408,7 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 	}
 
 	// Get subscription from teh DB.
-	subs, err := a.core.GetSubscriptions(0, subUUID, false)
+	subs, err := a.reqCore(c).GetSubscriptions(0, subUUID, false)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.errorFetchingLists"))
 	}
@@ -332,7 +425,7 @@ func (a *App) SubscriptionPrefs(c echo.Context) error {
 	}
 
 	// Unsubscribe from lists.
-	if err := a.core.UnsubscribeLists([]int{sub.ID}, nil, unsubUUIDs); err != nil {
+	if err := a.reqCore(c).UnsubscribeLists([]int{sub.ID}, nil, unsubUUIDs); err != nil {
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.errorProcessingRequest")))
 
@@ -366,7 +459,7 @@ func (a *App) OptinPage(c echo.Context) error {
 	}
 
 	// Get the list of subscription lists where the subscriber hasn't confirmed.
-	lists, err := a.core.GetSubscriberLists(0, subUUID, nil, req.ListUUIDs, models.SubscriptionStatusUnconfirmed, "")
+	lists, err := a.reqCore(c).GetSubscriberLists(0, subUUID, nil, req.ListUUIDs, models.SubscriptionStatusUnconfirmed, "")
 	if err != nil {
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingLists")))
@@ -380,17 +473,8 @@ func (a *App) OptinPage(c echo.Context) error {
 
 	// Confirm.
 	if confirm {
-		meta := models.JSON{}
-		if a.cfg.Privacy.RecordOptinIP {
-			if h := c.Request().Header.Get("X-Forwarded-For"); h != "" {
-				meta["optin_ip"] = h
-			} else if h := c.Request().RemoteAddr; h != "" {
-				meta["optin_ip"] = strings.Split(h, ":")[0]
-			}
-		}
-
 		// Confirm subscriptions in the DB.
-		if err := a.core.ConfirmOptionSubscription(subUUID, req.ListUUIDs, meta); err != nil {
+		if err := a.reqCore(c).ConfirmOptionSubscription(subUUID, req.ListUUIDs, a.optinMeta(c)); err != nil {
 			a.log.Printf("error unsubscribing: %v", err)
 			return c.Render(http.StatusInternalServerError, tplMessage,
 				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
@@ -417,7 +501,7 @@ func (a *App) SubscriptionFormPage(c echo.Context) error {
 	}
 
 	// Get all public lists from the DB.
-	lists, err := a.core.GetLists(models.ListTypePublic, models.ListStatusActive, true, nil)
+	lists, err := a.reqCore(c).GetLists(models.ListTypePublic, models.ListStatusActive, true, nil)
 	if err != nil {
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorFetchingLists")))
@@ -470,22 +554,9 @@ func (a *App) SubscriptionForm(c echo.Context) error {
 			val = c.FormValue("h-captcha-response")
 		case captcha.ProviderAltcha:
 			val = c.FormValue("altcha")
-		default:
-			return c.Render(http.StatusBadRequest, tplMessage,
-				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.invalidCaptcha")))
-		}
-
-		if val == "" {
-			return c.Render(http.StatusBadRequest, tplMessage,
-				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.invalidCaptcha")))
 		}
 
-		err, ok := a.captcha.Verify(val)
-		if err != nil {
-			a.log.Printf("captcha request failed: %v", err)
-		}
-
-		if !ok {
+		if !a.verifyCaptcha(val) {
 			return c.Render(http.StatusBadRequest, tplMessage,
 				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.invalidCaptcha")))
 		}
@@ -498,7 +569,10 @@ func (a *App) SubscriptionForm(c echo.Context) error {
 			return err
 		}
 
-		return c.Render(e.Code, tplMessage, makeMsgTpl(a.i18n.T("public.errorTitle"), "", fmt.Sprintf("%s", e.Message)))
+		// Existing subscriptions are not disclosed on the HTML form.
+		if pe, ok := e.Message.(publicAPIError); !ok || pe.Code != pubErrAlreadySubscribed {
+			return c.Render(e.Code, tplMessage, makeMsgTpl(a.i18n.T("public.errorTitle"), "", httpErrMsg(e)))
+		}
 	}
 
 	// If there were double optin lists, show the opt-in pending message instead of
@@ -512,13 +586,23 @@ func (a *App) SubscriptionForm(c echo.Context) error {
 }
 
 // PublicSubscription handles subscription requests coming from public
-// API calls.
+// API calls. If captcha is enabled, the captcha response should be sent in
+// the `captcha` field.
 func (a *App) PublicSubscription(c echo.Context) error {
 	if !a.cfg.EnablePublicSubPage {
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.invalidFeature"))
+		return a.publicErr(http.StatusBadRequest, pubErrDisabled, a.i18n.T("public.invalidFeature"))
 	}
 
-	hasOptin, err := a.processSubForm(c)
+	var req subFormReq
+	if err := c.Bind(&req); err != nil {
+		return a.publicErr(http.StatusBadRequest, pubErrInvalidRequest, a.i18n.T("globals.messages.invalidData"))
+	}
+
+	if a.captcha.IsEnabled() && !a.verifyCaptcha(req.Captcha) {
+		return a.publicErr(http.StatusBadRequest, pubErrInvalidCaptcha, a.i18n.T("public.invalidCaptcha"))
+	}
+
+	hasOptin, err := a.subscribe(req)
 	if err != nil {
 		return err
 	}
@@ -528,10 +612,223 @@ func (a *App) PublicSubscription(c echo.Context) error {
 	}{hasOptin}})
 }
 
+// PublicConfirmSubscription confirms a subscriber's double opt-in subscriptions.
+// It's the JSON equivalent of the opt-in confirmation page for headless frontends.
+// The token is the subscriber UUID in the opt-in confirmation link. Optional
+// list UUIDs (?l=) confirm only those lists.
+func (a *App) PublicConfirmSubscription(c echo.Context) error {
+	var (
+		subUUID   = c.Param("token")
+		listUUIDs = c.QueryParams()["l"]
+	)
+	if !reUUID.MatchString(subUUID) {
+		return a.publicErr(http.StatusBadRequest, pubErrInvalidRequest, a.i18n.T("globals.messages.invalidUUID"))
+	}
+	for _, l := range listUUIDs {
+		if !reUUID.MatchString(l) {
+			return a.publicErr(http.StatusBadRequest, pubErrInvalidRequest, a.i18n.T("globals.messages.invalidUUID"))
+		}
+	}
+
+	if _, err := a.reqCore(c).GetSubscriber(0, subUUID, ""); err != nil {
+		return a.publicSubErr(err)
+	}
+
+	// Get the list of subscription lists where the subscriber hasn't confirmed.
+	lists, err := a.reqCore(c).GetSubscriberLists(0, subUUID, nil, listUUIDs, models.SubscriptionStatusUnconfirmed, "")
+	if err != nil {
+		return a.publicErr(http.StatusInternalServerError, pubErrInternal, a.i18n.T("public.errorFetchingLists"))
+	}
+
+	// There are no lists to confirm.
+	if len(lists) == 0 {
+		return a.publicErr(http.StatusConflict, pubErrAlreadyConfirmed, a.i18n.T("public.noSubInfo"))
+	}
+
+	type list struct {
+		UUID string `json:"uuid"`
+		Name string `json:"name"`
+	}
+	out := make([]list, 0, len(lists))
+	uuids := make([]string, 0, len(lists))
+	for _, l := range lists {
+		out = append(out, list{UUID: l.UUID, Name: l.Name})
+		uuids = append(uuids, l.UUID)
+	}
+
+	// Confirm subscriptions in the DB.
+	if err := a.reqCore(c).ConfirmOptionSubscription(subUUID, uuids, a.optinMeta(c)); err != nil {
+		return a.publicErr(http.StatusInternalServerError, pubErrInternal, a.i18n.T("public.errorProcessingRequest"))
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		Lists []list `json:"lists"`
+	}{out}})
+}
+
+// PublicUnsubscribe unsubscribes a subscriber from the lists of a campaign or the given
+// public lists, and optionally blocklists them if it's allowed in the privacy settings.
+// It's the JSON equivalent of the unsubscription page for headless frontends.
+func (a *App) PublicUnsubscribe(c echo.Context) error {
+	var req struct {
+		SubscriberUUID string   `json:"subscriber_uuid"`
+		CampaignUUID   string   `json:"campaign_uuid"`
+		ListUUIDs      []string `json:"list_uuids"`
+		Blocklist      bool     `json:"blocklist"`
+		Reason         string   `json:"reason"`
+		Comment        string   `json:"comment"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return a.publicErr(http.StatusBadRequest, pubErrInvalidRequest, a.i18n.T("globals.messages.invalidData"))
+	}
+
+	// Validate the UUIDs.
+	blocklist := a.cfg.Privacy.AllowBlocklist && req.Blocklist
+	if !reUUID.MatchString(req.SubscriberUUID) ||
+		(req.CampaignUUID != "" && !reUUID.MatchString(req.CampaignUUID)) ||
+		(req.CampaignUUID == "" && len(req.ListUUIDs) == 0 && !blocklist) {
+		return a.publicErr(http.StatusBadRequest, pubErrInvalidRequest, a.i18n.T("globals.messages.invalidUUID"))
+	}
+	for _, l := range req.ListUUIDs {
+		if !reUUID.MatchString(l) {
+			return a.publicErr(http.StatusBadRequest, pubErrInvalidRequest, a.i18n.T("globals.messages.invalidUUID"))
+		}
+	}
+
+	sub, err := a.reqCore(c).GetSubscriber(0, req.SubscriberUUID, "")
+	if err != nil {
+		return a.publicSubErr(err)
+	}
+
+	// Unsubscribe from the campaign's lists, or all lists if blocklisting.
+	if req.CampaignUUID != "" || blocklist {
+		if err := a.reqCore(c).UnsubscribeByCampaign(sub.UUID, req.CampaignUUID, blocklist); err != nil {
+			return a.publicErr(http.StatusInternalServerError, pubErrInternal, a.i18n.T("public.errorProcessingRequest"))
+		}
+	}
+
+	// Unsubscribe from the given lists, skipping private lists.
+	if len(req.ListUUIDs) > 0 {
+		subs, err := a.reqCore(c).GetSubscriptions(sub.ID, "", false)
+		if err != nil {
+			return a.publicErr(http.StatusInternalServerError, pubErrInternal, a.i18n.T("public.errorFetchingLists"))
+		}
+
+		uuids := make([]string, 0, len(req.ListUUIDs))
+		for _, s := range subs {
+			if s.Type != models.ListTypePrivate && slices.Contains(req.ListUUIDs, s.UUID) {
+				uuids = append(uuids, s.UUID)
+			}
+		}
+
+		if len(uuids) > 0 {
+			if err := a.reqCore(c).UnsubscribeLists([]int{sub.ID}, nil, uuids); err != nil {
+				return a.publicErr(http.StatusInternalServerError, pubErrInternal, a.i18n.T("public.errorProcessingRequest"))
+			}
+		}
+	}
+	a.recordUnsubReason(c, sub.UUID, req.CampaignUUID, req.Reason, req.Comment)
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// recordUnsubReason records an unsubscription with the optional reason and comment
+// if unsubscribe reasons are enabled. Reasons that aren't one of the configured choices
+// and comments that aren't allowed are dropped and errors are only logged so that
+// the unsubscription itself never fails because of them.
+func (a *App) recordUnsubReason(c echo.Context, subUUID, campUUID, reason, comment string) {
+	opt := a.cfg.Privacy.UnsubscribeReasons
+	if !opt.Enabled {
+		return
+	}
+
+	reason = strings.TrimSpace(reason)
+	if !slices.Contains(opt.Choices, reason) {
+		reason = ""
+	}
+
+	comment = strings.TrimSpace(comment)
+	if !opt.AllowText {
+		comment = ""
+	} else if len(comment) > unsubCommentMaxLen {
+		comment = strings.ToValidUTF8(comment[:unsubCommentMaxLen], "")
+	}
+
+	_ = a.reqCore(c).InsertUnsubscribeEvent(subUUID, campUUID, reason, comment, "")
+}
+
+// publicErr returns an HTTP error with a machine-readable code for the public APIs.
+func (a *App) publicErr(status int, code, msg string) error {
+	return echo.NewHTTPError(status, publicAPIError{Code: code, Message: msg})
+}
+
+// publicSubErr translates an error from fetching a subscriber to a public API error.
+func (a *App) publicSubErr(err error) error {
+	if e, ok := err.(*echo.HTTPError); ok && e.Code == http.StatusBadRequest {
+		return a.publicErr(http.StatusNotFound, pubErrNotFound, httpErrMsg(e))
+	}
+
+	return a.publicErr(http.StatusInternalServerError, pubErrInternal, a.i18n.T("public.errorProcessingRequest"))
+}
+
+// verifyCaptcha verifies a captcha response with the configured provider.
+func (a *App) verifyCaptcha(val string) bool {
+	if val == "" {
+		return false
+	}
+
+	err, ok := a.captcha.Verify(val)
+	if err != nil {
+		a.log.Printf("captcha request failed: %v", err)
+	}
+
+	return ok
+}
+
+// optinMeta returns the meta to record against confirmed opt-in subscriptions.
+func (a *App) optinMeta(c echo.Context) models.JSON {
+	meta := models.JSON{}
+	if a.cfg.Privacy.RecordOptinIP {
+		if h := c.Request().Header.Get("X-Forwarded-For"); h != "" {
+			meta["optin_ip"] = h
+		} else if h := c.Request().RemoteAddr; h != "" {
+			meta["optin_ip"] = strings.Split(h, ":")[0]
+		}
+	}
+
+	return meta
+}
+
+// httpErrMsg returns the message of an HTTP error as a string.
+func httpErrMsg(err error) string {
+	e, ok := err.(*echo.HTTPError)
+	if !ok {
+		return err.Error()
+	}
+
+	if pe, ok := e.Message.(publicAPIError); ok {
+		return pe.Message
+	}
+
+	return fmt.Sprintf("%s", e.Message)
+}
+
+// PreviewLinkPage is the no-op page that the disarmed links in previews of messages
+// with real subscriber data point to. It shows the link's destination without
+// following it, so that clicking links in previews doesn't record stats or
+// unsubscribe anyone.
+func (a *App) PreviewLinkPage(c echo.Context) error {
+	return c.Render(http.StatusOK, tplMessage,
+		makeMsgTpl(a.i18n.T("public.previewLinkTitle"), "",
+			a.i18n.Ts("public.previewLink", "url", c.QueryParam("url"))))
+}
+
 // LinkRedirect redirects a link UUID to its original underlying link
 // after recording the link click for a particular subscriber in the particular
 // campaign. These links are generated by {{ TrackLink }} tags in campaigns.
 func (a *App) LinkRedirect(c echo.Context) error {
+	isBot := a.isBotEvent(c)
+
 	// If individual tracking is disabled, do not record the subscriber ID.
 	subUUID := c.Param("subUUID")
 	if !a.cfg.Privacy.IndividualTracking {
@@ -543,20 +840,166 @@ func (a *App) LinkRedirect(c echo.Context) error {
 		linkUUID = c.Param("linkUUID")
 		campUUID = c.Param("campUUID")
 	)
-	url, err := a.core.RegisterCampaignLinkClick(linkUUID, campUUID, subUUID)
+	url, err := a.reqCore(c).RegisterCampaignLinkClick(linkUUID, campUUID, subUUID, isBot)
 	if err != nil {
 		e := err.(*echo.HTTPError)
 		return c.Render(e.Code, tplMessage, makeMsgTpl(a.i18n.T("public.errorTitle"), "", e.Error()))
 	}
 
+	// If the URL has {{ .Subscriber.UUID }} style placeholders, render them.
+	if strings.Contains(url, "{{") {
+		url = a.renderLinkURL(url, campUUID, c.Param("subUUID"))
+	}
+
 	return c.Redirect(http.StatusTemporaryRedirect, url)
 }
 
+// ShortLinkRedirect resolves a short link's slug and ref to the link, campaign,
+// and subscriber and redirects like LinkRedirect. These links are generated by
+// {{ TrackLink }} tags in campaigns with short links enabled.
+func (a *App) ShortLinkRedirect(c echo.Context) error {
+	var (
+		slug = c.Param("slug")
+		co   = a.reqCore(c)
+	)
+
+	ref, err := shortlink.ParseRef(c.Param("ref"))
+	if err != nil {
+		return c.Render(http.StatusBadRequest, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.invalidLink")))
+	}
+
+	l, err := co.GetShortLink(slug, ref.CampaignID, ref.SubscriberID)
+	if err != nil {
+		e := err.(*echo.HTTPError)
+		return c.Render(e.Code, tplMessage, makeMsgTpl(a.i18n.T("public.errorTitle"), "", e.Error()))
+	}
+
+	// A ref that doesn't verify, eg: of a deleted subscriber or a tampered one,
+	// still redirects to the link, but records no click and renders no data.
+	campUUID, subUUID := l.CampaignUUID, l.SubscriberUUID
+	if !ref.Verify(slug, campUUID, subUUID) {
+		campUUID, subUUID = dummyUUID, dummyUUID
+	}
+
+	// The subscriber ID is used for burst detection irrespective of
+	// whether individual tracking is enabled.
+	isBot := a.isBotRequest(c, strconv.Itoa(ref.SubscriberID), time.Unix(ref.SentAt, 0))
+
+	// If individual tracking is disabled, do not record the subscriber ID.
+	recUUID := subUUID
+	if !a.cfg.Privacy.IndividualTracking && campUUID != dummyUUID {
+		recUUID = ""
+	}
+
+	url, err := co.RegisterCampaignLinkClick(l.LinkUUID, campUUID, recUUID, isBot)
+	if err != nil {
+		e := err.(*echo.HTTPError)
+		return c.Render(e.Code, tplMessage, makeMsgTpl(a.i18n.T("public.errorTitle"), "", e.Error()))
+	}
+
+	// If the URL has {{ .Subscriber.UUID }} style placeholders, render them.
+	if strings.Contains(url, "{{") {
+		url = a.renderLinkURL(url, campUUID, subUUID)
+	}
+
+	return c.Redirect(http.StatusTemporaryRedirect, url)
+}
+
+// renderLinkURL renders the placeholders in a tracked link's URL with the clicking
+// subscriber's and the campaign's data. If rendering fails for any reason, the URL
+// is returned with all the placeholders removed.
+func (a *App) renderLinkURL(u, campUUID, subUUID string) string {
+	camp, err := a.core.GetCampaign(0, campUUID, "")
+	if err != nil {
+		return regexpLinkTplTag.ReplaceAllString(u, "")
+	}
+
+	// Subscriber data is only available with individual tracking.
+	var sub *models.Subscriber
+	if a.cfg.Privacy.IndividualTracking && subUUID != "" && subUUID != dummyUUID {
+		s, err := a.core.GetSubscriber(0, subUUID, "")
+		if err != nil {
+			return regexpLinkTplTag.ReplaceAllString(u, "")
+		}
+		sub = &s
+	}
+
+	return renderLinkTpl(u, camp, sub, a.cfg.Privacy.LinkAttribs)
+}
+
+// renderLinkTpl renders the placeholders in a link's URL with the campaign's data
+// and, if sub isn't nil, the subscriber's UUID and allowlisted attributes. Every
+// substituted value is query-escaped. If rendering fails, the URL is returned with
+// all the placeholders removed.
+func renderLinkTpl(u string, camp models.Campaign, sub *models.Subscriber, linkAttribs []string) string {
+	stripped := regexpLinkTplTag.ReplaceAllString(u, "")
+
+	tpl, err := txttpl.New("link").Option("missingkey=error").Parse(u)
+	if err != nil {
+		return stripped
+	}
+
+	data := map[string]any{
+		"Campaign": map[string]string{
+			"UUID":    url.QueryEscape(camp.UUID),
+			"Name":    url.QueryEscape(camp.Name),
+			"Subject": url.QueryEscape(camp.Subject),
+		},
+	}
+
+	if sub != nil {
+		attribs := make(map[string]string, len(linkAttribs))
+		for _, k := range linkAttribs {
+			if v, ok := sub.Attribs[k]; ok {
+				attribs[k] = url.QueryEscape(fmt.Sprintf("%v", v))
+			}
+		}
+
+		data["Subscriber"] = map[string]any{
+			"UUID":    url.QueryEscape(sub.UUID),
+			"Attribs": attribs,
+		}
+	}
+
+	var b bytes.Buffer
+	if err := tpl.Execute(&b, data); err != nil {
+		return stripped
+	}
+
+	return b.String()
+}
+
+// isBotEvent checks whether a tracking request (view or click) is likely generated by a bot.
+// The ?t= param carries the unix timestamp of when the message was sent.
+func (a *App) isBotEvent(c echo.Context) bool {
+	var sentAt time.Time
+	if t, err := strconv.ParseInt(c.QueryParam("t"), 10, 64); err == nil && t > 0 {
+		sentAt = time.Unix(t, 0)
+	}
+
+	// The subscriber UUID from the URL is used for burst detection
+	// irrespective of whether individual tracking is enabled.
+	return a.isBotRequest(c, c.Param("subUUID"), sentAt)
+}
+
+// isBotRequest checks whether a tracking request is likely generated by a bot
+// given the key that identifies the subscriber and the message's send time.
+func (a *App) isBotRequest(c echo.Context, subKey string, sentAt time.Time) bool {
+	if a.botFilter == nil {
+		return false
+	}
+
+	return a.botFilter.IsBot(c.Request().UserAgent(), c.RealIP(), subKey, sentAt)
+}
+
 // RegisterCampaignView registers a campaign view which comes in
 // the form of an pixel image request. Regardless of errors, this handler
 // should always render the pixel image bytes. The pixel URL is generated by
 // the {{ TrackView }} template tag in campaigns.
 func (a *App) RegisterCampaignView(c echo.Context) error {
+	isBot := a.isBotEvent(c)
+
 	// If individual tracking is disabled, do not record the subscriber ID.
 	subUUID := c.Param("subUUID")
 	if !a.cfg.Privacy.IndividualTracking {
@@ -566,7 +1009,7 @@ func (a *App) RegisterCampaignView(c echo.Context) error {
 	// Exclude dummy hits from template previews.
 	campUUID := c.Param("campUUID")
 	if campUUID != dummyUUID && subUUID != dummyUUID {
-		if err := a.core.RegisterCampaignView(campUUID, subUUID); err != nil {
+		if err := a.reqCore(c).RegisterCampaignView(campUUID, subUUID, isBot); err != nil {
 			a.log.Printf("error registering campaign view: %s", err)
 		}
 	}
@@ -634,7 +1077,9 @@ func (a *App) SelfExportSubscriberData(c echo.Context) error {
 
 // WipeSubscriberData allows a subscriber to delete their data. The
 // profile and subscriptions are deleted, while the campaign_views and link
-// clicks remain as orphan data unconnected to any subscriber.
+// clicks remain as orphan data unconnected to any subscriber. If there's a
+// deletion grace period, the subscriber is only marked for deletion and is
+// e-mailed a link to cancel it.
 func (a *App) WipeSubscriberData(c echo.Context) error {
 	// Is wiping allowed?
 	if !a.cfg.Privacy.AllowWipe {
@@ -643,14 +1088,101 @@ func (a *App) WipeSubscriberData(c echo.Context) error {
 	}
 
 	subUUID := c.Param("subUUID")
-	if err := a.core.DeleteSubscribers(nil, []string{subUUID}); err != nil {
-		a.log.Printf("error wiping subscriber data: %s", err)
+	if a.cfg.Privacy.DeletionGraceDays < 1 {
+		if err := a.reqCore(c).DeleteSubscribers(nil, []string{subUUID}); err != nil {
+			a.log.Printf("error wiping subscriber data: %s", err)
+			return c.Render(http.StatusInternalServerError, tplMessage,
+				makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
+		}
+
+		return c.Render(http.StatusOK, tplMessage,
+			makeMsgTpl(a.i18n.T("public.dataRemovedTitle"), "", a.i18n.T("public.dataRemoved")))
+	}
+
+	// Mark the subscriber for deletion. They're excluded from all sends from here on.
+	sub, err := a.reqCore(c).RequestSubscriberDeletion(subUUID)
+	if err != nil {
+		a.log.Printf("error requesting subscriber deletion: %s", err)
 		return c.Render(http.StatusInternalServerError, tplMessage,
 			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
 	}
 
+	deleteAt := sub.DeletionRequestedAt.Time.AddDate(0, 0, a.cfg.Privacy.DeletionGraceDays)
+	if err := a.sendDeletionNotice(sub, deleteAt); err != nil {
+		a.log.Printf("error e-mailing subscriber deletion notice: %s", err)
+	}
+
 	return c.Render(http.StatusOK, tplMessage,
-		makeMsgTpl(a.i18n.T("public.dataRemovedTitle"), "", a.i18n.T("public.dataRemoved")))
+		makeMsgTpl(a.i18n.T("public.deletionRequestedTitle"), "",
+			a.i18n.Ts("public.deletionRequested", "date", deleteAt.Format(time.DateOnly))))
+}
+
+// CancelSubscriberDeletion handles the signed link e-mailed to subscribers who
+// requested the deletion of their data. GET shows a confirmation form so that
+// e-mail link scanners don't cancel deletions and POST cancels it.
+func (a *App) CancelSubscriberDeletion(c echo.Context) error {
+	subUUID := c.Param("subUUID")
+	if !a.verifySignedURL(c, deletionSignMsg(subUUID)) {
+		return c.Render(http.StatusBadRequest, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("public.invalidLink")))
+	}
+
+	if c.Request().Method != http.MethodPost {
+		return c.Render(http.StatusOK, "cancel-deletion", publicTpl{Title: a.i18n.T("public.cancelDeletionTitle")})
+	}
+
+	ok, err := a.reqCore(c).CancelSubscriberDeletion(subUUID)
+	if err != nil {
+		return c.Render(http.StatusInternalServerError, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.Ts("public.errorProcessingRequest")))
+	}
+	if !ok {
+		return c.Render(http.StatusOK, tplMessage,
+			makeMsgTpl(a.i18n.T("public.cancelDeletionTitle"), "", a.i18n.T("public.noDeletion")))
+	}
+
+	return c.Render(http.StatusOK, tplMessage,
+		makeMsgTpl(a.i18n.T("public.deletionCancelledTitle"), "", a.i18n.T("public.deletionCancelled")))
+}
+
+// sendDeletionNotice e-mails a subscriber who requested the deletion of their
+// data a signed link to cancel it that expires at the time of deletion.
+func (a *App) sendDeletionNotice(sub models.Subscriber, deleteAt time.Time) error {
+	var (
+		u    = fmt.Sprintf("%s/subscription/cancel-deletion/%s", a.urlCfg.RootURL, sub.UUID)
+		msg  bytes.Buffer
+		data = struct {
+			Email     string
+			DeleteAt  string
+			CancelURL string
+			SiteName  string
+			L         *i18n.I18n
+		}{
+			Email:     sub.Email,
+			DeleteAt:  deleteAt.Format(time.DateOnly),
+			CancelURL: a.signURL(u, deletionSignMsg(sub.UUID), deleteAt.Unix()),
+			SiteName:  a.cfg.SiteName,
+			L:         a.i18n,
+		}
+	)
+
+	if err := notifs.Tpls.ExecuteTemplate(&msg, notifs.TplSubscriberDelete, data); err != nil {
+		return fmt.Errorf("error compiling notification template '%s': %v", notifs.TplSubscriberDelete, err)
+	}
+
+	subject, body := notifs.GetTplSubject(a.i18n.T("email.deletion.subject"), msg.Bytes())
+	return a.emailMsgr.Push(models.Message{
+		From:    a.cfg.FromEmail,
+		To:      []string{sub.Email},
+		Subject: subject,
+		Body:    body,
+	})
+}
+
+// deletionSignMsg returns the message that's signed in the links to cancel
+// subscriber data deletions.
+func deletionSignMsg(subUUID string) string {
+	return "cancel-deletion:" + subUUID
 }
 
 // AltchaChallenge generates a challenge for Altcha captcha.
@@ -688,37 +1220,47 @@ func drawTransparentImage(h, w int) []byte {
 // The bool indicates whether there was subscription to an optin list so that
 // an appropriate message can be shown.
 func (a *App) processSubForm(c echo.Context) (bool, error) {
-	// Get and validate fields.
-	var req struct {
-		Name          string   `form:"name" json:"name"`
-		Email         string   `form:"email" json:"email"`
-		FormListUUIDs []string `form:"l" json:"list_uuids"`
-	}
+	var req subFormReq
 	if err := c.Bind(&req); err != nil {
-		return false, err
+		return false, a.publicErr(http.StatusBadRequest, pubErrInvalidRequest, a.i18n.T("globals.messages.invalidData"))
 	}
 
+	return a.subscribe(req)
+}
+
+// subscribe validates and inserts a public subscription request or adds the lists to
+// an existing subscriber's subscriptions. The bool indicates whether there was
+// subscription to an optin list. Errors are publicErr()s with machine-readable codes.
+func (a *App) subscribe(req subFormReq) (bool, error) {
 	if len(req.FormListUUIDs) == 0 {
-		return false, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("public.noListsSelected"))
+		return false, a.publicErr(http.StatusBadRequest, pubErrNoLists, a.i18n.T("public.noListsSelected"))
 	}
 
 	// Validate fields.
 	if len(req.Email) > 1000 {
-		return false, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidEmail"))
+		return false, a.publicErr(http.StatusBadRequest, pubErrInvalidEmail, a.i18n.T("subscribers.invalidEmail"))
 	}
 
 	em, err := a.importer.SanitizeEmail(req.Email)
 	if err != nil {
-		return false, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		if subimporter.IsDomainBlocked(err) {
+			return false, a.publicErr(http.StatusBadRequest, pubErrDomainBlocked, err.Error())
+		}
+		return false, a.publicErr(http.StatusBadRequest, pubErrInvalidEmail, err.Error())
 	}
 	req.Email = em
 
+	// Run the e-mail verification checks, if enabled.
+	if err := a.verifyEmail(req.Email); err != nil {
+		return false, a.publicErr(http.StatusBadRequest, pubErrInvalidEmail, httpErrMsg(err))
+	}
+
 	req.Name = strings.TrimSpace(req.Name)
 	if len(req.Name) == 0 {
 		// If there's no name, use the name bit from the e-mail.
 		req.Name = strings.Split(req.Email, "@")[0]
 	} else if len(req.Name) > stdInputMaxLen {
-		return false, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidName"))
+		return false, a.publicErr(http.StatusBadRequest, pubErrInvalidRequest, a.i18n.T("subscribers.invalidName"))
 	}
 
 	listUUIDs := pq.StringArray(req.FormListUUIDs)
@@ -726,12 +1268,12 @@ func (a *App) processSubForm(c echo.Context) (bool, error) {
 	// Fetch the list types and ensure that they are not private.
 	listTypes, err := a.core.GetListTypes(nil, req.FormListUUIDs)
 	if err != nil {
-		return false, echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("%s", err.(*echo.HTTPError).Message))
+		return false, a.publicErr(http.StatusInternalServerError, pubErrInternal, httpErrMsg(err))
 	}
 
 	for _, t := range listTypes {
 		if t == models.ListTypePrivate {
-			return false, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidUUID"))
+			return false, a.publicErr(http.StatusBadRequest, pubErrInvalidRequest, a.i18n.T("globals.messages.invalidUUID"))
 		}
 	}
 
@@ -753,7 +1295,16 @@ func (a *App) processSubForm(c echo.Context) (bool, error) {
 		// Get the subscriber from the DB by their email.
 		sub, err := a.core.GetSubscriber(0, "", req.Email)
 		if err != nil {
-			return false, err
+			return false, a.publicErr(http.StatusInternalServerError, pubErrInternal, httpErrMsg(err))
+		}
+
+		// If the subscriber is already subscribed to all the lists, there's nothing to do.
+		subs, err := a.core.GetSubscriptions(sub.ID, "", false)
+		if err != nil {
+			return false, a.publicErr(http.StatusInternalServerError, pubErrInternal, httpErrMsg(err))
+		}
+		if isSubscribed(subs, req.FormListUUIDs) {
+			return false, a.publicErr(http.StatusConflict, pubErrAlreadySubscribed, a.i18n.T("subscribers.emailExists"))
 		}
 
 		// Update the subscriber's subscriptions in the DB.
@@ -765,8 +1316,22 @@ func (a *App) processSubForm(c echo.Context) (bool, error) {
 	}
 
 	// Something else went wrong.
-	if e, ok := lastErr.(*echo.HTTPError); ok {
-		return false, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("%s", e.Message))
+	if _, ok := lastErr.(*echo.HTTPError); ok {
+		return false, a.publicErr(http.StatusBadRequest, pubErrInvalidRequest, httpErrMsg(lastErr))
+	}
+	return false, a.publicErr(http.StatusInternalServerError, pubErrInternal, a.i18n.T("public.errorProcessingRequest"))
+}
+
+// isSubscribed checks whether the subscriptions include all the given list UUIDs
+// without being unsubscribed from any of them.
+func isSubscribed(subs []models.Subscription, listUUIDs []string) bool {
+	for _, u := range listUUIDs {
+		if !slices.ContainsFunc(subs, func(s models.Subscription) bool {
+			return s.UUID == u && s.SubscriptionStatus.String != models.SubscriptionStatusUnsubscribed
+		}) {
+			return false
+		}
 	}
-	return false, echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("public.errorProcessingRequest"))
+
+	return true
 }
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+
+	"github.com/knadh/stuffbin"
+)
+
+// Directory of the embedded public page templates.
+const publicTplDir = "/public/templates"
+
+// loadPublicTemplates parses the embedded public page templates and overrides
+// them with the same named *.html files in dir, if it's set. An override should
+// parse and define all the templates that the file it replaces defines. The
+// names of the overridden files are returned.
+func loadPublicTemplates(fs stuffbin.FileSystem, funcs template.FuncMap, dir string) (*template.Template, []string, error) {
+	tpl, err := stuffbin.ParseTemplatesGlob(funcs, fs, publicTplDir+"/*.html")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := []string{}
+	if dir == "" {
+		return tpl, out, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.html"))
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(files)
+
+	for _, f := range files {
+		name := filepath.Base(f)
+
+		// Only the existing templates can be overridden.
+		orig, err := fs.Read(path.Join(publicTplDir, name))
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: unknown template", name)
+		}
+
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", name, err)
+		}
+
+		want, err := definedTemplates(funcs, orig)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", name, err)
+		}
+		got, err := definedTemplates(funcs, b)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", name, err)
+		}
+		for _, w := range want {
+			if !slices.Contains(got, w) {
+				return nil, nil, fmt.Errorf("%s: template \"%s\" isn't defined", name, w)
+			}
+		}
+
+		// Redefine the templates in the set. The set itself is named after one of
+		// the files and New() on an existing name resets that template, so that
+		// file is parsed into the set directly.
+		t := tpl
+		if name != tpl.Name() {
+			t = tpl.New(name)
+		}
+		if _, err := t.Parse(string(b)); err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", name, err)
+		}
+		out = append(out, name)
+	}
+
+	return tpl, out, nil
+}
+
+// definedTemplates returns the names of the templates defined in a template file.
+func definedTemplates(funcs template.FuncMap, b []byte) ([]string, error) {
+	t, err := template.New("").Funcs(funcs).Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, d := range t.Templates() {
+		if d.Name() != "" {
+			out = append(out, d.Name())
+		}
+	}
+
+	return out, nil
+}
+
+// reload loads the public templates with the overrides in dir and swaps them in.
+// On error, the current templates are retained.
+func (t *tplRenderer) reload(dir string) error {
+	tpl, overridden, err := loadPublicTemplates(t.fs, t.funcs, dir)
+	if err != nil {
+		return err
+	}
+
+	t.mut.Lock()
+	t.templates = tpl
+	t.overridden = overridden
+	t.mut.Unlock()
+
+	return nil
+}
+
+// getTemplates returns the current public templates.
+func (t *tplRenderer) getTemplates() *template.Template {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+
+	return t.templates
+}
+
+// getOverridden returns the names of the public template files that are
+// currently overridden.
+func (t *tplRenderer) getOverridden() []string {
+	t.mut.RLock()
+	defer t.mut.RUnlock()
+
+	return slices.Clone(t.overridden)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/knadh/stuffbin"
+)
+
+// newTestTplRenderer returns a renderer with the embedded public templates.
+func newTestTplRenderer(t *testing.T) *tplRenderer {
+	t.Helper()
+
+	fs, err := stuffbin.NewLocalFS("/", "../static/public/templates:"+publicTplDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := newTestApp(t)
+	r := &tplRenderer{fs: fs, funcs: initTplFuncs(a.i18n, a.urlCfg)}
+	if err := r.reload(""); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestReloadPublicTemplates(t *testing.T) {
+	r := newTestTplRenderer(t)
+	if r.getTemplates().Lookup("message") == nil || len(r.getOverridden()) != 0 {
+		t.Fatalf("expected the default templates, got %v", r.getOverridden())
+	}
+
+	// A valid override replaces the template.
+	dir := t.TempDir()
+	write := func(name, body string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("message.html", `{{ define "message" }}custom message{{ end }}`)
+	if err := r.reload(dir); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(r.getOverridden(), []string{"message.html"}) {
+		t.Fatalf("expected message.html to be overridden, got %v", r.getOverridden())
+	}
+
+	var b strings.Builder
+	if err := r.getTemplates().ExecuteTemplate(&b, "message", nil); err != nil || b.String() != "custom message" {
+		t.Fatalf("expected the override to render, got %q: %v", b.String(), err)
+	}
+	good := r.getTemplates()
+
+	// Broken overrides are rejected and the previous templates are retained.
+	cases := []struct {
+		name, file, body, err string
+	}{
+		{"parse error", "message.html", `{{ define "message" }}{{ .Foo `, "message.html:"},
+		{"unknown file", "nope.html", `{{ define "nope" }}{{ end }}`, "nope.html: unknown template"},
+		{"missing template", "index.html", `{{ define "header" }}{{ end }}`, `index.html: template "footer" isn't defined`},
+	}
+	for _, c := range cases {
+		write(c.file, c.body)
+
+		err := r.reload(dir)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error %q, got %v", c.name, c.err, err)
+		}
+		if r.getTemplates() != good || !slices.Equal(r.getOverridden(), []string{"message.html"}) {
+			t.Errorf("%s: expected the previous templates to be retained, got %v", c.name, r.getOverridden())
+		}
+
+		// Restore the override dir for the next case.
+		if c.file == "message.html" {
+			write(c.file, `{{ define "message" }}custom message{{ end }}`)
+		} else if err := os.Remove(filepath.Join(dir, c.file)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Reloading without the overrides restores the defaults.
+	if err := r.reload(""); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.getOverridden()) != 0 || r.getTemplates() == good {
+		t.Errorf("expected the default templates, got %v", r.getOverridden())
+	}
+}
+
+// TestOverrideAllTemplates checks that overriding the file that the template set
+// happens to be named after doesn't reset the set.
+func TestOverrideAllTemplates(t *testing.T) {
+	r := newTestTplRenderer(t)
+
+	files, err := filepath.Glob("../static/public/templates/*.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, filepath.Base(f)), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := r.reload(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.getOverridden()) != len(files) {
+		t.Errorf("expected all the templates to be overridden, got %v", r.getOverridden())
+	}
+	for _, n := range []string{"header", "footer", "message", "subscription-form"} {
+		if r.getTemplates().Lookup(n) == nil {
+			t.Errorf("%s: expected the template to be defined", n)
+		}
+	}
+}
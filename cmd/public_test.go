@@ -0,0 +1,417 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// cancelDeletion calls the cancel deletion handler for a subscriber.
+func cancelDeletion(t *testing.T, a *App, method, subUUID, query string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	e := newTestEcho()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(method, "/subscription/cancel-deletion/"+subUUID+query, nil), rec)
+	c.SetParamNames("subUUID")
+	c.SetParamValues(subUUID)
+
+	if err := a.CancelSubscriberDeletion(c); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+// signedQuery returns the query string of a signed cancel deletion link.
+func signedQuery(a *App, subUUID string, exp time.Time) string {
+	u, _ := url.Parse(a.signURL("https://example.com/subscription/cancel-deletion/"+subUUID, deletionSignMsg(subUUID), exp.Unix()))
+	return "?" + u.RawQuery
+}
+
+func TestCancelDeletionLink(t *testing.T) {
+	a := newTestApp(t)
+	a.cfg.Security.SigningKey = "secret"
+
+	var (
+		subUUID = "5b0f3f6e-8a8e-4f4b-a1a4-3c2d1e0f9a8b"
+		valid   = signedQuery(a, subUUID, time.Now().Add(time.Hour))
+		other   = newTestApp(t)
+	)
+	other.cfg.Security.SigningKey = "other"
+
+	// Invalid links are rejected.
+	for name, q := range map[string]string{
+		"unsigned":        "",
+		"other sub":       signedQuery(a, "9c8b7a6f-5e4d-4c3b-8a2f-1e0d9c8b7a6f", time.Now().Add(time.Hour)),
+		"expired":         signedQuery(a, subUUID, time.Now().Add(-time.Minute)),
+		"other signature": signedQuery(other, subUUID, time.Now().Add(time.Hour)),
+	} {
+		for _, m := range []string{http.MethodGet, http.MethodPost} {
+			if rec := cancelDeletion(t, a, m, subUUID, q); rec.Code != http.StatusBadRequest || rec.Body.String() != "tpl:"+tplMessage {
+				t.Errorf("%s %s: expected %d, got %d %q", name, m, http.StatusBadRequest, rec.Code, rec.Body.String())
+			}
+		}
+	}
+
+	// GET shows the confirmation form without cancelling the deletion, which
+	// would fail as there's no DB.
+	if rec := cancelDeletion(t, a, http.MethodGet, subUUID, valid); rec.Code != http.StatusOK || rec.Body.String() != "tpl:cancel-deletion" {
+		t.Fatalf("expected the confirmation form, got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestCancelDeletion(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.cfg.Security.SigningKey = "secret"
+
+	var subUUID string
+	if err := db.Get(&subUUID, `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), 'sub@example.com', 'sub') RETURNING uuid`); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := a.core.RequestSubscriberDeletion(subUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sub.DeletionRequestedAt.Valid {
+		t.Fatal("expected the deletion to be requested")
+	}
+
+	pending := func() bool {
+		var ok bool
+		if err := db.Get(&ok, `SELECT deletion_requested_at IS NOT NULL FROM subscribers WHERE uuid = $1`, subUUID); err != nil {
+			t.Fatal(err)
+		}
+		return ok
+	}
+
+	q := signedQuery(a, subUUID, time.Now().Add(time.Hour))
+
+	// GET doesn't cancel the deletion, eg: when a link scanner follows it.
+	cancelDeletion(t, a, http.MethodGet, subUUID, q)
+	if !pending() {
+		t.Fatal("expected GET not to cancel the deletion")
+	}
+
+	if rec := cancelDeletion(t, a, http.MethodPost, subUUID, q); rec.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+	}
+	if pending() {
+		t.Fatal("expected the deletion to be cancelled")
+	}
+
+	// Cancelling again is a no-op.
+	if rec := cancelDeletion(t, a, http.MethodPost, subUUID, q); rec.Code != http.StatusOK {
+		t.Fatalf("expected %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+// TestPublicRateLimitClientIP checks that the public rate limits are per client
+// IP, and that X-Forwarded-For is only trusted from the trusted proxies.
+func TestPublicRateLimitClientIP(t *testing.T) {
+	a := newTestApp(t)
+	a.cfg.EnablePublicArchive = true
+	a.cfg.Security.PublicRateLimit = 2
+
+	e := newTestEcho()
+	e.Use(middleware.Recover())
+	ext, err := webhooks.NewIPExtractor([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.IPExtractor = ext
+	initHTTPHandlers(e, a)
+
+	// There's no DB, so the requests that get through fail, but the ones over
+	// the limit are rejected before reaching the handler.
+	post := func(peer, xff string) int {
+		req := httptest.NewRequest(http.MethodPost, "/archive/test", strings.NewReader("password=guess"))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.Header.Set(echo.HeaderXForwardedFor, xff)
+		req.Header.Set(echo.HeaderXRealIP, xff)
+		req.RemoteAddr = peer + ":1234"
+
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A spoofed X-Forwarded-For from an untrusted client doesn't reset its limit.
+	for i := range 3 {
+		code := post("203.0.113.1", "198.51.100."+strconv.Itoa(i))
+		if i < 2 && code == http.StatusTooManyRequests {
+			t.Fatalf("request %d: unexpected rate limit", i)
+		}
+		if i == 2 && code != http.StatusTooManyRequests {
+			t.Fatalf("expected the spoofed request to be rate limited, got %d", code)
+		}
+	}
+
+	// Clients behind a trusted proxy are limited separately.
+	for i := range 3 {
+		code := post("10.0.0.1", "198.51.100.1")
+		if i < 2 && code == http.StatusTooManyRequests {
+			t.Fatalf("proxied request %d: unexpected rate limit", i)
+		}
+		if i == 2 && code != http.StatusTooManyRequests {
+			t.Fatalf("expected the proxied request to be rate limited, got %d", code)
+		}
+	}
+	if code := post("10.0.0.1", "198.51.100.2"); code == http.StatusTooManyRequests {
+		t.Fatal("expected another client behind the proxy to not be rate limited")
+	}
+}
+
+func TestRenderLinkTpl(t *testing.T) {
+	var (
+		camp = models.Campaign{UUID: "2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11", Name: "Sales & offers / 2026?", Subject: "Grüße für dich"}
+		sub  = &models.Subscriber{UUID: "5b0f3f6e-8a8e-4f4b-a1a4-3c2d1e0f9a8b", Attribs: models.JSON{
+			"city":   "São Paulo / BR",
+			"ref":    "a&b=c?d",
+			"plan":   7,
+			"secret": "s3cret",
+		}}
+		attribs = []string{"city", "ref", "plan"}
+	)
+
+	cases := []struct {
+		name string
+		url  string
+		sub  *models.Subscriber
+		exp  string
+	}{
+		{"no placeholders", "https://example.com/?a=1", sub, "https://example.com/?a=1"},
+		{"campaign", "https://example.com/?c={{ .Campaign.Name }}&s={{ .Campaign.Subject }}&u={{ .Campaign.UUID }}", nil,
+			"https://example.com/?c=Sales+%26+offers+%2F+2026%3F&s=Gr%C3%BC%C3%9Fe+f%C3%BCr+dich&u=2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11"},
+		{"subscriber", "https://example.com/?u={{ .Subscriber.UUID }}", sub, "https://example.com/?u=5b0f3f6e-8a8e-4f4b-a1a4-3c2d1e0f9a8b"},
+		{"attribs", `https://example.com/{{ index .Subscriber.Attribs "city" }}?r={{ index .Subscriber.Attribs "ref" }}&p={{ index .Subscriber.Attribs "plan" }}`, sub,
+			"https://example.com/S%C3%A3o+Paulo+%2F+BR?r=a%26b%3Dc%3Fd&p=7"},
+		{"non-allowlisted attrib", `https://example.com/?c={{ .Campaign.UUID }}&s={{ index .Subscriber.Attribs "secret" }}`, sub,
+			"https://example.com/?c=2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11&s="},
+		{"non-allowlisted attrib field", "https://example.com/?c={{ .Campaign.UUID }}&s={{ .Subscriber.Attribs.secret }}", sub,
+			"https://example.com/?c=&s="},
+		{"missing attrib", `https://example.com/?x={{ index .Subscriber.Attribs "missing" }}`, sub, "https://example.com/?x="},
+		{"individual tracking off", "https://example.com/?c={{ .Campaign.UUID }}&u={{ .Subscriber.UUID }}", nil,
+			"https://example.com/?c=&u="},
+		{"unknown field", "https://example.com/?e={{ .Subscriber.Email }}", sub, "https://example.com/?e="},
+		{"function call", `https://example.com/?x={{ printf "%s" "y" }}`, sub, "https://example.com/?x=y"},
+		{"parse error", "https://example.com/?c={{ .Campaign.UUID }}&x={{ .Campaign.Name ", sub,
+			"https://example.com/?c=&x={{ .Campaign.Name "},
+		{"unclosed action", "https://example.com/?x={{ if .Campaign.Name }}", sub, "https://example.com/?x="},
+	}
+	for _, c := range cases {
+		if got := renderLinkTpl(c.url, camp, c.sub, attribs); got != c.exp {
+			t.Errorf("%s: expected %s, got %s", c.name, c.exp, got)
+		}
+	}
+}
+
+// TestUnsubscribeReason checks that the optional unsubscribe reasons and comments
+// are recorded as configured without ever being required.
+func TestUnsubscribeReason(t *testing.T) {
+	a, db := newTestAppDB(t)
+
+	var subUUID, campUUID string
+	if err := db.Get(&subUUID, `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), 'sub@example.com', 'sub') RETURNING uuid`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&campUUID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com', '', 'email') RETURNING uuid`); err != nil {
+		t.Fatal(err)
+	}
+
+	e := newTestEcho()
+	e.POST("/subscription/:campUUID/:subUUID", a.hasUUID(a.hasSub(a.SubscriptionPrefs), "campUUID", "subUUID"))
+	e.POST("/api/public/subscription/unsubscribe", a.PublicUnsubscribe)
+
+	type event struct {
+		Reason  string `db:"reason"`
+		Comment string `db:"comment"`
+		Camp    bool   `db:"camp"`
+	}
+	unsub := func(form url.Values) []event {
+		t.Helper()
+
+		if _, err := db.Exec(`DELETE FROM unsubscribe_events`); err != nil {
+			t.Fatal(err)
+		}
+		if rec := doForm(e, http.MethodPost, "/subscription/"+campUUID+"/"+subUUID, form); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+
+		var out []event
+		if err := db.Select(&out, `SELECT reason, comment, campaign_id IS NOT NULL AS camp FROM unsubscribe_events`); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	// Nothing is recorded when the reasons are disabled.
+	form := url.Values{"reason": {"Too frequent"}, "comment": {"Too many"}}
+	if ev := unsub(form); len(ev) != 0 {
+		t.Errorf("expected no events, got %+v", ev)
+	}
+
+	a.cfg.Privacy.UnsubscribeReasons = models.UnsubscribeReasons{Enabled: true, Choices: []string{"Too frequent", "Not relevant"}}
+
+	// The comment is dropped unless free text is allowed.
+	if ev := unsub(form); len(ev) != 1 || ev[0] != (event{"Too frequent", "", true}) {
+		t.Errorf("unexpected events %+v", ev)
+	}
+
+	// Unknown reasons are dropped, and long comments truncated.
+	a.cfg.Privacy.UnsubscribeReasons.AllowText = true
+	form = url.Values{"reason": {"I was hacked"}, "comment": {" " + strings.Repeat("a", unsubCommentMaxLen+10) + " "}}
+	if ev := unsub(form); len(ev) != 1 || ev[0] != (event{"", strings.Repeat("a", unsubCommentMaxLen), true}) {
+		t.Errorf("unexpected events %+v", ev)
+	}
+
+	// A reason is never required, including for one-click List-Unsubscribe.
+	for _, form := range []url.Values{{}, {"List-Unsubscribe": {"One-Click"}}} {
+		if ev := unsub(form); len(ev) != 1 || ev[0] != (event{"", "", true}) {
+			t.Errorf("%v: unexpected events %+v", form, ev)
+		}
+	}
+
+	// The public API.
+	if _, err := db.Exec(`DELETE FROM unsubscribe_events`); err != nil {
+		t.Fatal(err)
+	}
+	body := `{"subscriber_uuid": "` + subUUID + `", "campaign_uuid": "` + campUUID + `", "reason": "Not relevant", "comment": "Meh"}`
+	req := httptest.NewRequest(http.MethodPost, "/api/public/subscription/unsubscribe", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var ev []event
+	if err := db.Select(&ev, `SELECT reason, comment, campaign_id IS NOT NULL AS camp FROM unsubscribe_events`); err != nil {
+		t.Fatal(err)
+	}
+	if len(ev) != 1 || ev[0] != (event{"Not relevant", "Meh", true}) {
+		t.Errorf("unexpected events %+v", ev)
+	}
+}
+
+// TestViewCampaignMessage checks that the browser view of a campaign message is
+// only rendered for the subscriber it's signed for, and that other requests fall
+// back to the campaign's archive page.
+func TestViewCampaignMessage(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.cfg.Security.SigningKey = "secret"
+	a.cfg.EnablePublicArchive = true
+	a.urlCfg.ArchiveURL = "https://example.com/archive"
+	a.manager = manager.New(manager.Config{}, nil, a.i18n, log.New(io.Discard, "", 0))
+
+	var subUUID, otherUUID, campUUID, hiddenUUID string
+	if err := db.Get(&subUUID, `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), 'sub@example.com', 'Sub') RETURNING uuid`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&otherUUID, `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), 'other@example.com', 'Other') RETURNING uuid`); err != nil {
+		t.Fatal(err)
+	}
+
+	var tplID int
+	if err := db.Get(&tplID, `INSERT INTO templates (name, type, subject, body) VALUES ('tpl', 'campaign', '', '<p>{{ template "content" . }}</p>') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	newCamp := func(access string) string {
+		var id string
+		if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, messenger, status, template_id, archive, archive_slug, archive_access, body)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', 'email', 'finished', $2, true, $1, $1,
+			'Hi {{ .Subscriber.Name }} <a href="{{ TrackLink "https://example.com/page" . }}">page</a>{{ TrackView . }}') RETURNING uuid`, access, tplID); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	campUUID, hiddenUUID = newCamp("public"), newCamp("unlisted")
+
+	e := newTestEcho()
+	e.GET("/campaign/:campUUID/:subUUID", a.hasUUID(a.ViewCampaignMessage, "campUUID", "subUUID"))
+
+	// link returns the path of the view of a campaign message signed for the given
+	// campaign and subscriber.
+	link := func(a *App, campUUID, subUUID, forCamp, forSub string, exp int64) string {
+		u, _ := url.Parse(a.signURL("https://example.com/campaign/"+campUUID+"/"+subUUID, messageSignMsg(forCamp, forSub), exp))
+		return u.Path + "?" + u.RawQuery
+	}
+
+	// A valid link renders the subscriber's message without link and view tracking.
+	rec := doForm(e, http.MethodGet, link(a, campUUID, subUUID, campUUID, subUUID, 0), nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if b := rec.Body.String(); !strings.Contains(b, "Hi Sub") || !strings.Contains(b, `href="https://example.com/page"`) ||
+		strings.Contains(b, "/link/") || strings.Contains(b, "<img") {
+		t.Errorf("unexpected message %q", b)
+	}
+	if h := rec.Header().Get("Cache-Control"); h != "private, no-store" {
+		t.Errorf("expected the view not to be cached, got %q", h)
+	}
+
+	// A link that hasn't expired is valid.
+	if rec := doForm(e, http.MethodGet, link(a, campUUID, subUUID, campUUID, subUUID, time.Now().Add(time.Hour).Unix()), nil); rec.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Links that don't verify fall back to the archive page of the campaign.
+	other := newTestApp(t)
+	other.cfg.Security.SigningKey = "other"
+	tampered := link(a, campUUID, subUUID, campUUID, subUUID, 0)
+	tampered = strings.Replace(tampered, "sig=", "sig=x", 1)
+
+	// The signature of an expiring link without the expiry.
+	u, _ := url.Parse(link(a, campUUID, subUUID, campUUID, subUUID, time.Now().Add(-time.Minute).Unix()))
+	q := u.Query()
+	q.Del("exp")
+	noExp := u.Path + "?" + q.Encode()
+	for name, target := range map[string]string{
+		"unsigned":        "/campaign/" + campUUID + "/" + subUUID,
+		"tampered":        tampered,
+		"expired":         link(a, campUUID, subUUID, campUUID, subUUID, time.Now().Add(-time.Minute).Unix()),
+		"other sub":       link(a, campUUID, subUUID, campUUID, otherUUID, 0),
+		"other campaign":  link(a, campUUID, subUUID, hiddenUUID, subUUID, 0),
+		"other signature": link(other, campUUID, subUUID, campUUID, subUUID, 0),
+		"expiry dropped":  noExp,
+	} {
+		rec := doForm(e, http.MethodGet, target, nil)
+		if rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://example.com/archive/public" {
+			t.Errorf("%s: expected a redirect to the archive, got %d %q", name, rec.Code, rec.Header().Get("Location"))
+		}
+		if strings.Contains(rec.Body.String(), "Hi Sub") {
+			t.Errorf("%s: expected the message not to be rendered", name)
+		}
+	}
+
+	// A deleted subscriber's valid link falls back to the archive too.
+	deleted := link(a, campUUID, otherUUID, campUUID, otherUUID, 0)
+	if _, err := db.Exec(`DELETE FROM subscribers WHERE uuid = $1`, otherUUID); err != nil {
+		t.Fatal(err)
+	}
+	if rec := doForm(e, http.MethodGet, deleted, nil); rec.Code != http.StatusFound || rec.Header().Get("Location") != "https://example.com/archive/public" {
+		t.Errorf("deleted sub: expected a redirect to the archive, got %d %q", rec.Code, rec.Header().Get("Location"))
+	}
+
+	// Campaigns whose archive isn't public, or when the public archive is disabled,
+	// have no fallback.
+	if rec := doForm(e, http.MethodGet, "/campaign/"+hiddenUUID+"/"+subUUID, nil); rec.Code != http.StatusNotFound || rec.Body.String() != "tpl:"+tplMessage {
+		t.Errorf("unlisted: expected %d, got %d %q", http.StatusNotFound, rec.Code, rec.Body.String())
+	}
+	a.cfg.EnablePublicArchive = false
+	if rec := doForm(e, http.MethodGet, "/campaign/"+campUUID+"/"+subUUID, nil); rec.Code != http.StatusNotFound || rec.Body.String() != "tpl:"+tplMessage {
+		t.Errorf("archive disabled: expected %d, got %d %q", http.StatusNotFound, rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// Subscriber e-mails and names are redacted in the responses to users whose role
+// has the pii:redacted flag, eg: read-only access to a staging instance. The
+// redaction is done when the responses are serialized and not in the queries
+// so that searches, counts, and stats remain correct.
+
+var reEmail = regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`)
+
+// isPIIRedacted checks whether the subscriber PII in the response to the
+// request is to be redacted.
+func isPIIRedacted(c echo.Context) bool {
+	u := auth.GetUser(c)
+	return u.IsPIIRedacted()
+}
+
+// redactEmail masks an e-mail, eg: hello@domain.com => h***@d***.com.
+func redactEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return redactStr(email)
+	}
+
+	// Retain the TLD.
+	name, tld := domain, ""
+	if i := strings.LastIndex(domain, "."); i > 0 {
+		name, tld = domain[:i], domain[i:]
+	}
+
+	return redactStr(local) + "@" + redactStr(name) + tld
+}
+
+// redactName reduces a name to its initials, eg: John Doe => J. D.
+func redactName(name string) string {
+	var out []string
+	for _, w := range strings.Fields(name) {
+		r, _ := utf8.DecodeRuneInString(w)
+		out = append(out, string(r)+".")
+	}
+
+	return strings.Join(out, " ")
+}
+
+// redactStr masks all but the first character of a string.
+func redactStr(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	r, _ := utf8.DecodeRuneInString(s)
+	return string(r) + "***"
+}
+
+// redactAttribs masks the e-mails in subscriber attributes, including nested
+// ones, eg: the suggestion in e-mail verification results.
+func redactAttribs(a models.JSON) models.JSON {
+	if a == nil {
+		return nil
+	}
+
+	out := make(models.JSON, len(a))
+	for k, v := range a {
+		out[k] = redactValue(v)
+	}
+
+	return out
+}
+
+// redactValue masks e-mails in an arbitrary JSON value.
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case string:
+		if isEmail(v) {
+			return redactEmail(v)
+		}
+	case map[string]any:
+		return map[string]any(redactAttribs(v))
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = redactValue(item)
+		}
+		return out
+	}
+
+	return v
+}
+
+// isEmail checks whether a string is an e-mail address.
+func isEmail(s string) bool {
+	if !strings.Contains(s, "@") {
+		return false
+	}
+
+	em, err := mail.ParseAddress(s)
+	return err == nil && em.Address == s
+}
+
+// redactSubscriber redacts the PII of a subscriber.
+func redactSubscriber(s models.Subscriber) models.Subscriber {
+	s.Email = redactEmail(s.Email)
+	s.Name = redactName(s.Name)
+	s.Attribs = redactAttribs(s.Attribs)
+
+	return s
+}
+
+// redactSubscribers redacts the PII of a list of subscribers.
+func redactSubscribers(subs []models.Subscriber) []models.Subscriber {
+	out := make([]models.Subscriber, len(subs))
+	for i, s := range subs {
+		out[i] = redactSubscriber(s)
+	}
+
+	return out
+}
+
+// redactSubscriberExport redacts the PII of an exported subscriber.
+func redactSubscriberExport(s models.SubscriberExport) models.SubscriberExport {
+	s.Email = redactEmail(s.Email)
+	s.Name = redactName(s.Name)
+
+	var attribs models.JSON
+	if err := json.Unmarshal([]byte(s.Attribs), &attribs); err == nil {
+		b, _ := json.Marshal(redactAttribs(attribs))
+		s.Attribs = string(b)
+	} else {
+		s.Attribs = "{}"
+	}
+
+	return s
+}
+
+// redactActivity masks the e-mails in the old and new values of the changes to
+// tracked attributes in a subscriber's activity.
+func redactActivity(a models.SubscriberActivity) models.SubscriberActivity {
+	var changes []map[string]any
+	if err := json.Unmarshal(a.AttribChanges, &changes); err != nil {
+		a.AttribChanges = json.RawMessage(`[]`)
+		return a
+	}
+
+	for _, c := range changes {
+		for _, k := range []string{"old_value", "new_value"} {
+			if v, ok := c[k]; ok {
+				c[k] = redactValue(v)
+			}
+		}
+	}
+
+	b, _ := json.Marshal(changes)
+	a.AttribChanges = b
+	return a
+}
+
+// redactBounce redacts the e-mail of a bounce. The bounce's meta, which is
+// the raw payload of the bounce, is dropped as it may contain the e-mail.
+func redactBounce(b models.Bounce) models.Bounce {
+	b.Email = redactEmail(b.Email)
+	b.Meta = json.RawMessage(`{}`)
+
+	return b
+}
+
+// redactBounces redacts the e-mails of a list of bounces.
+func redactBounces(bounces []models.Bounce) []models.Bounce {
+	out := make([]models.Bounce, len(bounces))
+	for i, b := range bounces {
+		out[i] = redactBounce(b)
+	}
+
+	return out
+}
+
+// redactError masks the e-mails in the message of an HTTP error, eg: the ones
+// in DB errors, if the response to the request is to be redacted.
+func redactError(c echo.Context, err error) error {
+	u, ok := c.Get(auth.UserHTTPCtxKey).(auth.User)
+	if !ok || !u.IsPIIRedacted() {
+		return err
+	}
+
+	e, ok := err.(*echo.HTTPError)
+	if !ok {
+		return err
+	}
+	msg, ok := e.Message.(string)
+	if !ok {
+		return err
+	}
+
+	out := *e
+	out.Message = reEmail.ReplaceAllStringFunc(msg, redactEmail)
+	return &out
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+func TestRedactActivity(t *testing.T) {
+	a := models.SubscriberActivity{
+		CampaignViews: json.RawMessage(`[]`),
+		LinkClicks:    json.RawMessage(`[]`),
+		AttribChanges: json.RawMessage(`[
+			{"key": "backup_email", "old_value": "old@example.com", "new_value": "new@example.com", "created_at": "2026-01-01T00:00:00Z"},
+			{"key": "contacts", "old_value": null, "new_value": {"work": "work@example.com", "n": 2}, "created_at": "2026-01-01T00:00:00Z"},
+			{"key": "plan", "old_value": "free", "new_value": "pro", "created_at": "2026-01-01T00:00:00Z"}
+		]`),
+	}
+
+	out := redactActivity(a)
+	s := string(out.AttribChanges)
+	for _, e := range []string{"old@example.com", "new@example.com", "work@example.com"} {
+		if strings.Contains(s, e) {
+			t.Errorf("%s isn't redacted: %s", e, s)
+		}
+	}
+
+	var changes []map[string]any
+	if err := json.Unmarshal(out.AttribChanges, &changes); err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 3 {
+		t.Fatalf("expected 3 changes, got %d", len(changes))
+	}
+	if changes[0]["old_value"] != "o***@e***.com" || changes[0]["new_value"] != "n***@e***.com" {
+		t.Errorf("unexpected redacted values %v", changes[0])
+	}
+	if changes[1]["old_value"] != nil || changes[1]["new_value"].(map[string]any)["n"] != float64(2) {
+		t.Errorf("unexpected redacted values %v", changes[1])
+	}
+	if changes[2]["old_value"] != "free" || changes[2]["new_value"] != "pro" || changes[2]["key"] != "plan" {
+		t.Errorf("expected non-e-mail values to be retained, got %v", changes[2])
+	}
+
+	// The rest of the activity is untouched.
+	if string(out.CampaignViews) != "[]" || string(out.LinkClicks) != "[]" {
+		t.Errorf("unexpected activity %+v", out)
+	}
+}
+
+func TestRedactEmailName(t *testing.T) {
+	for in, exp := range map[string]string{
+		"hello@domain.com":       "h***@d***.com",
+		"a.b@mail.example.co.uk": "a***@m***.uk",
+		"ünï@dömain.de":          "ü***@d***.de",
+		"x@localhost":            "x***@l***",
+		"invalid":                "i***",
+		"":                       "",
+	} {
+		if out := redactEmail(in); out != exp {
+			t.Errorf("%q: expected %q, got %q", in, exp, out)
+		}
+	}
+
+	for in, exp := range map[string]string{
+		"John Doe":         "J. D.",
+		"  jane   q  doe ": "j. q. d.",
+		"Émile":            "É.",
+		"":                 "",
+	} {
+		if out := redactName(in); out != exp {
+			t.Errorf("%q: expected %q, got %q", in, exp, out)
+		}
+	}
+}
+
+func TestRedactSubscriber(t *testing.T) {
+	s := models.Subscriber{
+		Email: "john@example.com",
+		Name:  "John Doe",
+		Attribs: models.JSON{
+			"backup":  "backup@example.com",
+			"city":    "Berlin",
+			"mention": "write to john@example.com",
+			"nested":  map[string]any{"work": "work@example.com", "n": 1.0},
+			"list":    []any{"a@example.com", "b", map[string]any{"c": "c@example.com"}},
+		},
+	}
+
+	out := redactSubscriber(s)
+	if out.Email != "j***@e***.com" || out.Name != "J. D." {
+		t.Errorf("unexpected redacted subscriber %s, %s", out.Email, out.Name)
+	}
+
+	b, _ := json.Marshal(out.Attribs)
+	for _, e := range []string{"backup@example.com", "work@example.com", "a@example.com", "c@example.com"} {
+		if strings.Contains(string(b), e) {
+			t.Errorf("%s isn't redacted: %s", e, b)
+		}
+	}
+	if out.Attribs["city"] != "Berlin" || out.Attribs["mention"] != "write to john@example.com" {
+		t.Errorf("expected values that aren't e-mails to be retained: %s", b)
+	}
+	if l := out.Attribs["list"].([]any); l[0] != "a***@e***.com" || l[1] != "b" {
+		t.Errorf("unexpected redacted list %v", l)
+	}
+
+	// The subscriber's attributes are left as they are.
+	if s.Email != "john@example.com" || s.Attribs["backup"] != "backup@example.com" ||
+		s.Attribs["nested"].(map[string]any)["work"] != "work@example.com" {
+		t.Errorf("the original subscriber was modified: %+v", s)
+	}
+
+	if out := redactSubscribers([]models.Subscriber{s, {}}); len(out) != 2 || out[0].Email != "j***@e***.com" || out[1].Attribs != nil {
+		t.Errorf("unexpected redacted subscribers %+v", out)
+	}
+}
+
+func TestRedactSubscriberExport(t *testing.T) {
+	out := redactSubscriberExport(models.SubscriberExport{
+		Email:   "john@example.com",
+		Name:    "John Doe",
+		Attribs: `{"backup": "backup@example.com", "city": "Berlin"}`,
+	})
+	if out.Email != "j***@e***.com" || out.Name != "J. D." {
+		t.Errorf("unexpected redacted export %s, %s", out.Email, out.Name)
+	}
+	if out.Attribs != `{"backup":"b***@e***.com","city":"Berlin"}` {
+		t.Errorf("unexpected redacted attribs %s", out.Attribs)
+	}
+
+	// Attributes that can't be parsed are dropped.
+	if out := redactSubscriberExport(models.SubscriberExport{Attribs: `{"a": john@example.com`}); out.Attribs != "{}" {
+		t.Errorf("expected invalid attribs to be dropped, got %s", out.Attribs)
+	}
+}
+
+func TestRedactBounces(t *testing.T) {
+	b := models.Bounce{Email: "john@example.com", Meta: json.RawMessage(`{"to": "john@example.com"}`)}
+
+	out := redactBounces([]models.Bounce{b})
+	if len(out) != 1 || out[0].Email != "j***@e***.com" || string(out[0].Meta) != "{}" {
+		t.Errorf("unexpected redacted bounce %+v", out)
+	}
+	if b.Email != "john@example.com" {
+		t.Errorf("the original bounce was modified: %+v", b)
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	e := echo.New()
+	errMsg := echo.NewHTTPError(http.StatusBadRequest, "duplicate e-mail john@example.com and jane@example.org")
+
+	ctx := func(u any) echo.Context {
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), httptest.NewRecorder())
+		if u != nil {
+			c.Set(auth.UserHTTPCtxKey, u)
+		}
+		return c
+	}
+	redacted := auth.User{PermissionsMap: map[string]struct{}{auth.PermPIIRedacted: {}}}
+
+	err := redactError(ctx(redacted), errMsg)
+	var he *echo.HTTPError
+	if !errors.As(err, &he) || he.Code != http.StatusBadRequest || he.Message != "duplicate e-mail j***@e***.com and j***@e***.org" {
+		t.Errorf("unexpected redacted error %v", err)
+	}
+	if errMsg.Message != "duplicate e-mail john@example.com and jane@example.org" {
+		t.Errorf("the original error was modified: %v", errMsg)
+	}
+
+	// Errors for other users and errors that aren't HTTP errors are left as they are.
+	superAdmin := redacted
+	superAdmin.UserRoleID = auth.SuperAdminRoleID
+	for _, c := range []struct {
+		ctx echo.Context
+		err error
+	}{
+		{ctx(nil), errMsg},
+		{ctx(auth.User{}), errMsg},
+		{ctx(superAdmin), errMsg},
+		{ctx(redacted), errors.New("john@example.com")},
+	} {
+		if out := redactError(c.ctx, c.err); out != c.err {
+			t.Errorf("expected the error to be left, got %v", out)
+		}
+	}
+}
@@ -12,7 +12,7 @@ import (
 // GetUserRoles retrieves roles.
 func (a *App) GetUserRoles(c echo.Context) error {
 	// Get all roles.
-	out, err := a.core.GetRoles()
+	out, err := a.reqCore(c).GetRoles()
 	if err != nil {
 		return err
 	}
@@ -23,7 +23,7 @@ func (a *App) GetUserRoles(c echo.Context) error {
 // GeListRoles retrieves roles.
 func (a *App) GeListRoles(c echo.Context) error {
 	// Get all roles.
-	out, err := a.core.GetListRoles()
+	out, err := a.reqCore(c).GetListRoles()
 	if err != nil {
 		return err
 	}
@@ -42,7 +42,7 @@ func (a *App) CreateUserRole(c echo.Context) error {
 	}
 
 	// Create the role in the DB.
-	out, err := a.core.CreateRole(r)
+	out, err := a.reqCore(c).CreateRole(r)
 	if err != nil {
 		return err
 	}
@@ -61,7 +61,7 @@ func (a *App) CreateListRole(c echo.Context) error {
 	}
 
 	// Create the role in the DB.
-	out, err := a.core.CreateListRole(r)
+	out, err := a.reqCore(c).CreateListRole(r)
 	if err != nil {
 		return err
 	}
@@ -91,7 +91,7 @@ func (a *App) UpdateUserRole(c echo.Context) error {
 	r.Name.String = strings.TrimSpace(r.Name.String)
 
 	// Update the role in the DB.
-	out, err := a.core.UpdateUserRole(id, r)
+	out, err := a.reqCore(c).UpdateUserRole(id, r)
 	if err != nil {
 		return err
 	}
@@ -128,7 +128,7 @@ func (a *App) UpdateListRole(c echo.Context) error {
 	r.Name.String = strings.TrimSpace(r.Name.String)
 
 	// Update the role in the DB.
-	out, err := a.core.UpdateListRole(id, r)
+	out, err := a.reqCore(c).UpdateListRole(id, r)
 	if err != nil {
 		return err
 	}
@@ -152,7 +152,7 @@ func (a *App) DeleteRole(c echo.Context) error {
 	}
 
 	// Delete the role from the DB.
-	if err := a.core.DeleteRole(int(id)); err != nil {
+	if err := a.reqCore(c).DeleteRole(int(id)); err != nil {
 		return err
 	}
 
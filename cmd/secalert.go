@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/secalert"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// Interval at which the security alert rules are evaluated.
+	secAlertInterval = time.Minute
+
+	// Duration for which login events are retained.
+	secAlertLoginRetention = time.Hour * 24 * 30
+)
+
+// secAlertTpl is the data of the security alert notification template.
+type secAlertTpl struct {
+	Rule string
+
+	// []models.FailedLogins or []models.TokenNetwork.
+	Data any
+
+	Threshold int
+	Window    string
+}
+
+// apiNetworkTracker records the networks that API tokens are used from so that
+// the use from new networks can be alerted. Only the first use from a network
+// after a (re)start hits the DB.
+type apiNetworkTracker struct {
+	co   *core.Core
+	seen sync.Map
+}
+
+// track records the use of an API token from an IP.
+func (t *apiNetworkTracker) track(u auth.User, ip string) {
+	network := secalert.Network(ip)
+	if network == "" {
+		return
+	}
+
+	key := u.Username + "|" + network
+	if _, ok := t.seen.LoadOrStore(key, true); ok {
+		return
+	}
+
+	if err := t.co.RecordAPITokenNetwork(u.Username, network, ip); err != nil {
+		t.seen.Delete(key)
+	}
+}
+
+// initSecurityAlerter initializes the alerter that evaluates the security alert
+// rules and sends the alerts to the admin notification e-mails.
+func initSecurityAlerter(co *core.Core, i *i18n.I18n) *secalert.Alerter {
+	var (
+		window, _     = time.ParseDuration(ko.String("security.alerts.failed_logins.window"))
+		loginsCool, _ = time.ParseDuration(ko.String("security.alerts.failed_logins.cooldown"))
+		netsCool, _   = time.ParseDuration(ko.String("security.alerts.new_token_network.cooldown"))
+		threshold     = ko.Int("security.alerts.failed_logins.threshold")
+	)
+
+	opt := secalert.Opt{
+		Interval:  secAlertInterval,
+		Retention: secAlertLoginRetention,
+		FailedLogins: secalert.FailedLoginsRule{
+			Enabled:   ko.Bool("security.alerts.failed_logins.enabled") && threshold > 0 && window > 0,
+			Threshold: threshold,
+			Window:    window,
+			Cooldown:  loginsCool,
+		},
+		NewTokenNetwork: secalert.NewTokenNetworkRule{
+			Enabled:  ko.Bool("security.alerts.new_token_network.enabled"),
+			Cooldown: netsCool,
+		},
+	}
+
+	notify := func(rule string, data any) error {
+		var title string
+		switch rule {
+		case models.SecurityAlertFailedLogins:
+			title = i.T("email.securityAlert.failedLogins")
+		case models.SecurityAlertNewTokenNetwork:
+			title = i.T("email.securityAlert.newTokenNetwork")
+		}
+
+		return notifs.NotifySystem(i.T("email.securityAlert.title")+": "+title, notifs.TplSecurityAlert, secAlertTpl{
+			Rule:      rule,
+			Data:      data,
+			Threshold: threshold,
+			Window:    window.String(),
+		}, nil)
+	}
+
+	return secalert.New(opt, co, notify, lo)
+}
+
+// GetSecurityAlerts returns the history of fired security alerts.
+func (a *App) GetSecurityAlerts(c echo.Context) error {
+	var (
+		rule = c.QueryParam("rule")
+		pg   = a.pg.NewFromURL(c.Request().URL.Query())
+	)
+
+	res, total, err := a.reqCore(c).QuerySecurityAlerts(rule, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{makePageResults(res, total, pg)})
+}
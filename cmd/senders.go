@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	// senderTokenLen is the length of the verification token e-mailed to sender addresses.
+	senderTokenLen = 48
+
+	// senderVerifyLinkAge is how long the signed verification links e-mailed to
+	// sender addresses are valid for.
+	senderVerifyLinkAge = time.Hour * 48
+)
+
+// GetSenderIdentities retrieves all sender identities.
+func (a *App) GetSenderIdentities(c echo.Context) error {
+	out, err := a.reqCore(c).GetSenderIdentities()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateSenderIdentity handles sender identity creation. Domain identities are
+// trusted as they're defined by admins. If verification is required, address
+// identities remain unverified until the confirmation link e-mailed to them is opened.
+func (a *App) CreateSenderIdentity(c echo.Context) error {
+	var s models.SenderIdentity
+	if err := c.Bind(&s); err != nil {
+		return err
+	}
+
+	s.Value = strings.ToLower(strings.TrimSpace(s.Value))
+	switch s.Type {
+	case models.SenderIdentityTypeAddress:
+		if em, err := mail.ParseAddress(s.Value); err != nil || em.Address != s.Value {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "value"))
+		}
+	case models.SenderIdentityTypeDomain:
+		if !strings.Contains(s.Value, ".") || strings.Contains(s.Value, "@") {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "value"))
+		}
+		if _, err := mail.ParseAddress("postmaster@" + s.Value); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "value"))
+		}
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+	}
+
+	s.Verified = s.Type == models.SenderIdentityTypeDomain || !a.cfg.Security.SenderIdentities.RequireVerification
+	s.VerifyToken = null.String{}
+	if !s.Verified {
+		tk, err := generateRandomString(senderTokenLen)
+		if err != nil {
+			a.log.Printf("error generating sender verification token: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+		}
+		s.VerifyToken = null.StringFrom(tk)
+	}
+
+	out, err := a.reqCore(c).CreateSenderIdentity(s)
+	if err != nil {
+		return err
+	}
+
+	if s.VerifyToken.Valid {
+		if err := a.sendSenderVerification(out.Value, s.VerifyToken.String); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// ResendSenderIdentityVerification sends a new verification e-mail to an
+// unverified sender address.
+func (a *App) ResendSenderIdentityVerification(c echo.Context) error {
+	s, err := a.reqCore(c).GetSenderIdentity(getID(c))
+	if err != nil {
+		return err
+	}
+
+	if s.Verified || s.Type != models.SenderIdentityTypeAddress {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("senders.alreadyVerified"))
+	}
+
+	tk, err := generateRandomString(senderTokenLen)
+	if err != nil {
+		a.log.Printf("error generating sender verification token: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	if err := a.reqCore(c).SetSenderIdentityToken(s.ID, tk); err != nil {
+		return err
+	}
+
+	if err := a.sendSenderVerification(s.Value, tk); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// DeleteSenderIdentity handles sender identity deletion.
+func (a *App) DeleteSenderIdentity(c echo.Context) error {
+	if err := a.reqCore(c).DeleteSenderIdentity(getID(c)); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// SenderIdentityVerifyPage handles the signed confirmation link e-mailed to sender
+// addresses. GET shows a confirmation form so that e-mail link scanners don't
+// verify addresses and POST verifies it.
+func (a *App) SenderIdentityVerifyPage(c echo.Context) error {
+	token := c.Param("token")
+	if !a.verifySignedURL(c, senderVerifySignMsg(token)) {
+		return c.Render(http.StatusBadRequest, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("senders.invalidToken")))
+	}
+
+	if c.Request().Method != http.MethodPost {
+		return c.Render(http.StatusOK, "sender-verify", publicTpl{Title: a.i18n.T("senders.verifyTitle")})
+	}
+
+	s, err := a.reqCore(c).VerifySenderIdentity(token)
+	if err != nil {
+		return c.Render(http.StatusBadRequest, tplMessage,
+			makeMsgTpl(a.i18n.T("public.errorTitle"), "", a.i18n.T("senders.invalidToken")))
+	}
+
+	return c.Render(http.StatusOK, tplMessage,
+		makeMsgTpl(a.i18n.T("senders.verifiedTitle"), "", a.i18n.Ts("senders.verified", "value", s.Value)))
+}
+
+// sendSenderVerification e-mails the verification link to a sender address.
+func (a *App) sendSenderVerification(email, token string) error {
+	var (
+		msg  bytes.Buffer
+		u    = fmt.Sprintf("%s/sender-identities/verify/%s", a.urlCfg.RootURL, token)
+		exp  = time.Now().Add(senderVerifyLinkAge).Unix()
+		data = struct {
+			Email     string
+			VerifyURL string
+			SiteName  string
+			L         *i18n.I18n
+		}{
+			Email:     email,
+			VerifyURL: a.signURL(u, senderVerifySignMsg(token), exp),
+			SiteName:  a.cfg.SiteName,
+			L:         a.i18n,
+		}
+	)
+
+	if err := notifs.Tpls.ExecuteTemplate(&msg, notifs.TplSenderVerify, data); err != nil {
+		a.log.Printf("error compiling notification template '%s': %v", notifs.TplSenderVerify, err)
+		return errors.New(a.i18n.T("globals.messages.internalError"))
+	}
+
+	subject, body := notifs.GetTplSubject(a.i18n.T("email.senderVerify.subject"), msg.Bytes())
+	if err := a.emailMsgr.Push(models.Message{
+		From:    a.cfg.FromEmail,
+		To:      []string{email},
+		Subject: subject,
+		Body:    body,
+	}); err != nil {
+		a.log.Printf("error sending sender verification e-mail: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// senderVerifySignMsg returns the message that's signed in sender verification links.
+func senderVerifySignMsg(token string) string {
+	return "sender-verify:" + token
+}
+
+// checkCampaignSender checks whether the user is allowed to send a campaign from
+// the given from address. If sender identities are enforced, the address should
+// be covered by a verified identity or be the global from address, unless the
+// user has the permission to send from any address.
+func (a *App) checkCampaignSender(fromEmail string, user auth.User) error {
+	if !a.cfg.Security.SenderIdentities.Enabled || user.HasPerm(auth.PermCampaignsAnySender) {
+		return nil
+	}
+
+	em, err := mail.ParseAddress(fromEmail)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("campaigns.fieldInvalidFromEmail"))
+	}
+	addr := strings.ToLower(em.Address)
+
+	// The global from address is always allowed.
+	if g, err := mail.ParseAddress(a.cfg.FromEmail); err == nil && strings.ToLower(g.Address) == addr {
+		return nil
+	}
+
+	ids, err := a.core.GetSenderIdentities()
+	if err != nil {
+		return err
+	}
+	for _, s := range ids {
+		if s.Verified && s.Matches(addr) {
+			return nil
+		}
+	}
+
+	return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("senders.notAllowed", "email", addr))
+}
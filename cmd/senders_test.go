@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// senderVerifyQuery returns the query string of a signed sender verification link.
+func senderVerifyQuery(a *App, token string, exp time.Time) string {
+	u, _ := url.Parse(a.signURL("https://example.com/sender-identities/verify/"+token, senderVerifySignMsg(token), exp.Unix()))
+	return "?" + u.RawQuery
+}
+
+func TestSenderIdentityVerifyLink(t *testing.T) {
+	a := newTestApp(t)
+	a.cfg.Security.SigningKey = "secret"
+
+	var (
+		token = "abc123"
+		valid = senderVerifyQuery(a, token, time.Now().Add(senderVerifyLinkAge))
+		other = newTestApp(t)
+	)
+	other.cfg.Security.SigningKey = "other"
+
+	verify := func(method, query string) *httptest.ResponseRecorder {
+		e := newTestEcho()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(method, "/sender-identities/verify/"+token+query, nil), rec)
+		c.SetParamNames("token")
+		c.SetParamValues(token)
+
+		if err := a.SenderIdentityVerifyPage(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	// Invalid links are rejected.
+	for name, q := range map[string]string{
+		"unsigned":        "",
+		"other token":     senderVerifyQuery(a, "xyz789", time.Now().Add(time.Hour)),
+		"expired":         senderVerifyQuery(a, token, time.Now().Add(-time.Minute)),
+		"other signature": senderVerifyQuery(other, token, time.Now().Add(time.Hour)),
+		"no expiry":       "?sig=" + a.sign(senderVerifySignMsg(token), time.Now().Add(time.Hour).Unix()),
+	} {
+		for _, m := range []string{http.MethodGet, http.MethodPost} {
+			if rec := verify(m, q); rec.Code != http.StatusBadRequest || rec.Body.String() != "tpl:"+tplMessage {
+				t.Errorf("%s %s: expected %d, got %d %q", name, m, http.StatusBadRequest, rec.Code, rec.Body.String())
+			}
+		}
+	}
+
+	// GET shows the confirmation form without verifying the sender, which would
+	// fail as there's no DB.
+	if rec := verify(http.MethodGet, valid); rec.Code != http.StatusOK || rec.Body.String() != "tpl:sender-verify" {
+		t.Fatalf("expected the confirmation form, got %d %q", rec.Code, rec.Body.String())
+	}
+}
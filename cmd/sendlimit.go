@@ -0,0 +1,46 @@
+package main
+
+import (
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/lib/pq"
+)
+
+// sendCountStore implements sendlimit.Store over the DB.
+type sendCountStore struct {
+	q *models.Queries
+}
+
+// GetSendCounts returns the counts of messages sent by each SMTP server on a day.
+func (s *sendCountStore) GetSendCounts(day time.Time) (map[string]int, error) {
+	var res []struct {
+		Server string `db:"server"`
+		Sent   int    `db:"sent"`
+	}
+	if err := s.q.GetSMTPSendCounts.Select(&res, day); err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]int, len(res))
+	for _, r := range res {
+		out[r.Server] = r.Sent
+	}
+
+	return out, nil
+}
+
+// AddSendCounts adds to the counts of messages sent by SMTP servers on a day.
+func (s *sendCountStore) AddSendCounts(day time.Time, counts map[string]int) error {
+	var (
+		servers = make([]string, 0, len(counts))
+		sent    = make([]int64, 0, len(counts))
+	)
+	for k, n := range counts {
+		servers = append(servers, k)
+		sent = append(sent, int64(n))
+	}
+
+	_, err := s.q.AddSMTPSendCounts.Exec(pq.Array(servers), pq.Array(sent), day)
+	return err
+}
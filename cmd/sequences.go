@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// GetSequences retrieves all sequences.
+func (a *App) GetSequences(c echo.Context) error {
+	out, err := a.reqCore(c).GetSequences()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// GetSequence retrieves a single sequence.
+func (a *App) GetSequence(c echo.Context) error {
+	out, err := a.reqCore(c).GetSequence(getID(c))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// CreateSequence handles sequence creation.
+func (a *App) CreateSequence(c echo.Context) error {
+	var s models.Sequence
+	if err := c.Bind(&s); err != nil {
+		return err
+	}
+
+	s, err := a.validateSequence(s)
+	if err != nil {
+		return err
+	}
+
+	out, err := a.reqCore(c).CreateSequence(s)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// UpdateSequence handles sequence modification.
+func (a *App) UpdateSequence(c echo.Context) error {
+	var s models.Sequence
+	if err := c.Bind(&s); err != nil {
+		return err
+	}
+
+	s, err := a.validateSequence(s)
+	if err != nil {
+		return err
+	}
+
+	out, err := a.reqCore(c).UpdateSequence(getID(c), s)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// DeleteSequence handles sequence deletion.
+func (a *App) DeleteSequence(c echo.Context) error {
+	if err := a.reqCore(c).DeleteSequence(getID(c)); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// validateSequence validates sequence fields and parses the step delays.
+// Step delays are relative to the subscription and cannot decrease.
+func (a *App) validateSequence(s models.Sequence) (models.Sequence, error) {
+	s.Name = strings.TrimSpace(s.Name)
+	if !strHasLen(s.Name, 1, stdInputMaxLen) {
+		return s, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "name"))
+	}
+
+	if s.ListID < 1 {
+		return s, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "list_id"))
+	}
+
+	switch s.Status {
+	case "", models.SequenceStatusActive, models.SequenceStatusPaused:
+	default:
+		return s, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "status"))
+	}
+
+	if len(s.Steps) == 0 || len(s.Steps) > sequenceMaxSteps {
+		return s, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "steps"))
+	}
+
+	prev := 0
+	for n, st := range s.Steps {
+		if st.CampaignID < 1 {
+			return s, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", fmt.Sprintf("steps[%d].campaign_id", n)))
+		}
+
+		// If a duration string is given, it takes precedence over the seconds.
+		if st.Delay != "" {
+			d, err := time.ParseDuration(st.Delay)
+			if err != nil {
+				return s, echo.NewHTTPError(http.StatusBadRequest,
+					a.i18n.Ts("globals.messages.invalidFields", "name", fmt.Sprintf("steps[%d].delay", n)))
+			}
+			st.DelaySecs = int(d.Seconds())
+		}
+
+		if st.DelaySecs < prev {
+			return s, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", fmt.Sprintf("steps[%d].delay", n)))
+		}
+		prev = st.DelaySecs
+		s.Steps[n] = st
+	}
+
+	return s, nil
+}
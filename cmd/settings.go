@@ -3,11 +3,18 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/mail"
+	"net/textproto"
 	"net/url"
+	"os"
 	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -17,17 +24,37 @@ import (
 	"github.com/gofrs/uuid/v5"
 	"github.com/jmoiron/sqlx/types"
 	koanfjson "github.com/knadh/koanf/parsers/json"
+	"github.com/knadh/koanf/providers/confmap"
 	"github.com/knadh/koanf/providers/rawbytes"
 	"github.com/knadh/koanf/v2"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/bounce/mailbox"
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
+	"github.com/knadh/listmonk/internal/capacity"
+	"github.com/knadh/listmonk/internal/manager"
 	"github.com/knadh/listmonk/internal/messenger/email"
 	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/sendlimit"
+	"github.com/knadh/listmonk/internal/utils"
+	"github.com/knadh/listmonk/internal/verp"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
 
 const pwdMask = "•"
 
+// managerRuntimeKeys are the settings that the campaign manager can apply at
+// runtime without an app restart.
+var managerRuntimeKeys = map[string]bool{
+	"app.batch_size":                      true,
+	"app.concurrency":                     true,
+	"app.message_rate":                    true,
+	"app.message_sliding_window":          true,
+	"app.message_sliding_window_duration": true,
+	"app.message_sliding_window_rate":     true,
+	"app.max_campaign_runtime":            true,
+}
+
 type aboutHost struct {
 	OS       string `json:"os"`
 	Machine  string `json:"arch"`
@@ -48,19 +75,57 @@ type about struct {
 	Database  types.JSONText `json:"database"`
 	System    aboutSystem    `json:"system"`
 	Host      aboutHost      `json:"host"`
+
+	// Number of bounce webhook requests that failed verification by provider.
+	UnverifiedBounceWebhooks map[string]int64 `json:"unverified_bounce_webhooks"`
+
+	// Number of bounce webhook requests from source IPs that aren't in the allowlist by provider.
+	RejectedBounceWebhooks map[string]int64 `json:"rejected_bounce_webhooks"`
+
+	// Public template files that are overridden by app.public_templates_dir.
+	OverriddenTemplates []string `json:"overridden_public_templates"`
+
+	// Health of the SMTP servers by messenger.
+	SMTPServers map[string][]email.ServerHealth `json:"smtp_servers"`
+
+	// Usage of the media filesystem and the database as of the last check.
+	Storage capacity.Usage `json:"storage"`
 }
 
 var (
 	reAlphaNum = regexp.MustCompile(`[^a-z0-9\-]`)
+
+	// Exact domain or a suffix wildcard, eg: outlook.com, *.outlook.com.
+	reRouteDomain = regexp.MustCompile(`^(\*\.)?[a-z0-9]([a-z0-9\-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9\-]*[a-z0-9])?)+$`)
+
+	// Headers that can't be set globally on all e-mails in addition to the
+	// reserved campaign headers as they change the envelope or the recipients.
+	reservedGlobalHeaders = map[string]bool{
+		"Return-Path": true,
+		"Reply-To":    true,
+		"Cc":          true,
+		"Bcc":         true,
+	}
 )
 
 // GetSettings returns settings from the DB.
 func (a *App) GetSettings(c echo.Context) error {
-	s, err := a.core.GetSettings()
+	s, err := a.reqCore(c).GetSettings()
 	if err != nil {
 		return err
 	}
 
+	return c.JSON(http.StatusOK, okResp{maskSettings(s)})
+}
+
+// maskSettings replaces the passwords and other secrets in the settings
+// with masks of the same length. The slices of s are copied and left as they are.
+func maskSettings(s models.Settings) models.Settings {
+	s.SMTP = slices.Clone(s.SMTP)
+	s.BounceBoxes = slices.Clone(s.BounceBoxes)
+	s.Messengers = slices.Clone(s.Messengers)
+	s.Webhooks = slices.Clone(s.Webhooks)
+
 	// Empty out passwords.
 	for i := range s.SMTP {
 		s.SMTP[i].Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SMTP[i].Password))
@@ -71,15 +136,22 @@ func (a *App) GetSettings(c echo.Context) error {
 	for i := range s.Messengers {
 		s.Messengers[i].Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Messengers[i].Password))
 	}
+	for i := range s.Webhooks {
+		s.Webhooks[i].Secret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.Webhooks[i].Secret))
+	}
 
 	s.UploadS3AwsSecretAccessKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.UploadS3AwsSecretAccessKey))
+	s.UploadSFTPPassword = strings.Repeat(pwdMask, utf8.RuneCountInString(s.UploadSFTPPassword))
+	s.UploadSFTPPrivateKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.UploadSFTPPrivateKey))
+	s.UploadWebDAVPassword = strings.Repeat(pwdMask, utf8.RuneCountInString(s.UploadWebDAVPassword))
 	s.SendgridKey = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SendgridKey))
 	s.BouncePostmark.Password = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BouncePostmark.Password))
 	s.BounceForwardEmail.Key = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BounceForwardEmail.Key))
+	s.BounceVERP.Secret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.BounceVERP.Secret))
 	s.SecurityCaptcha.HCaptcha.Secret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.SecurityCaptcha.HCaptcha.Secret))
 	s.OIDC.ClientSecret = strings.Repeat(pwdMask, utf8.RuneCountInString(s.OIDC.ClientSecret))
 
-	return c.JSON(http.StatusOK, okResp{s})
+	return s
 }
 
 // UpdateSettings returns settings from the DB.
@@ -91,11 +163,135 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	}
 
 	// Get the existing settings.
-	cur, err := a.core.GetSettings()
+	cur, err := a.reqCore(c).GetSettings()
+	if err != nil {
+		return err
+	}
+
+	return a.saveSettings(c, cur, set)
+}
+
+// ExportSettings returns all the settings as a JSON document that can be imported
+// into another instance with ImportSettings. Secrets are masked unless they're
+// explicitly asked for with ?secrets=true. Masked secrets are retained from the
+// current settings on import.
+func (a *App) ExportSettings(c echo.Context) error {
+	s, err := a.reqCore(c).GetSettings()
+	if err != nil {
+		return err
+	}
+
+	if ok, _ := strconv.ParseBool(c.QueryParam("secrets")); !ok {
+		s = maskSettings(s)
+	}
+	s.Version = 0
+
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, a.i18n.T("globals.messages.internalError"))
+	}
+
+	// Set headers to force the browser to prompt for download.
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	c.Response().Header().Set("Content-Disposition", `attachment; filename="listmonk-settings.json"`)
+	return c.Blob(http.StatusOK, "application/json", b)
+}
+
+// ImportSettings imports a settings document produced by ExportSettings. The
+// document is applied over the current settings, so keys that aren't in it are
+// left unchanged. Empty and masked secrets are retained from the current settings.
+// The document is validated and saved as a whole like in UpdateSettings.
+func (a *App) ImportSettings(c echo.Context) error {
+	b, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidData"))
+	}
+
+	cur, err := a.reqCore(c).GetSettings()
+	if err != nil {
+		return err
+	}
+
+	set, err := decodeSettingsImport(cur, b)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "settings: "+err.Error()))
+	}
+
+	return a.saveSettings(c, cur, set)
+}
+
+// decodeSettingsImport decodes a settings document over a copy of the current
+// settings and empties its masked secrets. The copy is made via JSON so that the
+// decoding doesn't overwrite the slices of cur, which the secrets are merged from.
+func decodeSettingsImport(cur models.Settings, b []byte) (models.Settings, error) {
+	base, err := json.Marshal(cur)
+	if err != nil {
+		return models.Settings{}, err
+	}
+
+	var set models.Settings
+	if err := json.Unmarshal(base, &set); err != nil {
+		return models.Settings{}, err
+	}
+	if err := json.Unmarshal(b, &set); err != nil {
+		return models.Settings{}, err
+	}
+
+	// The document may be from another instance, so its version is irrelevant.
+	// It's applied over cur, so the save is checked against cur's version.
+	set.Version = cur.Version
+	unmaskSettings(&set)
+
+	return set, nil
+}
+
+// saveSettings validates and sanitizes the incoming settings, retains the secrets
+// that aren't set in them from the current settings, saves them, and applies them
+// at runtime or restarts the app as needed.
+func (a *App) saveSettings(c echo.Context, cur, set models.Settings) error {
+	set, err := a.validateSettings(cur, set)
 	if err != nil {
 		return err
 	}
 
+	// Update the settings in the DB.
+	if _, err := a.reqCore(c).UpdateSettings(set); err != nil {
+		return err
+	}
+
+	// If only the settings that the campaign manager can apply at runtime have
+	// changed, apply them to it instead of restarting the app and disrupting
+	// running campaigns.
+	if onlyManagerRuntimeChanged(cur, set) {
+		a.manager.Reconfigure(makeManagerRuntimeConfig(set))
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	// If only the bounce mailboxes have changed and there are no running
+	// campaigns, reload the bounce manager's mailbox scanner instead of
+	// restarting the app.
+	if a.bounce != nil && onlyBounceBoxesChanged(cur, set) && !a.manager.HasRunningCampaigns() {
+		if err := a.reloadBounceMailbox(set); err != nil {
+			a.log.Printf("error reloading bounce mailbox: %v", err)
+		} else {
+			return c.JSON(http.StatusOK, okResp{true})
+		}
+	}
+
+	return a.handleSettingsRestart(c, changedSettingsKeys(cur, set))
+}
+
+// validateSettings validates and sanitizes the incoming settings and retains the
+// secrets that aren't set in them from the current settings.
+func (a *App) validateSettings(cur, set models.Settings) (models.Settings, error) {
+	set.AppReplyTo = strings.TrimSpace(set.AppReplyTo)
+	if set.AppReplyTo != "" && !reFromAddress.MatchString(set.AppReplyTo) {
+		if _, err := a.importer.SanitizeEmail(set.AppReplyTo); err != nil {
+			return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "app.reply_to"))
+		}
+	}
+
 	// Validate and sanitize postback Messenger names along with SMTP names
 	// (where each SMTP is also considered as a standalone messenger).
 	// Duplicates are disallowed and "email" is a reserved name.
@@ -116,7 +312,7 @@ func (a *App) UpdateSettings(c echo.Context) error {
 			}
 
 			if _, ok := names[name]; ok {
-				return echo.NewHTTPError(http.StatusBadRequest,
+				return set, echo.NewHTTPError(http.StatusBadRequest,
 					a.i18n.Ts("settings.duplicateMessengerName", "name", name))
 			}
 
@@ -136,6 +332,31 @@ func (a *App) UpdateSettings(c echo.Context) error {
 		// This is a common mistake when copy-pasting SMTP settings.
 		set.SMTP[i].Host = strings.TrimSpace(s.Host)
 
+		// The optional envelope sender should be a bare e-mail address.
+		set.SMTP[i].EnvelopeFrom = strings.TrimSpace(s.EnvelopeFrom)
+		if e := set.SMTP[i].EnvelopeFrom; e != "" {
+			if em, err := mail.ParseAddress(e); err != nil || em.Address != e {
+				return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "envelope_from"))
+			}
+		}
+
+		if s.MaxMessageSizeMB < 0 {
+			return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "max_message_size_mb"))
+		}
+
+		set.SMTP[i].Provider = strings.TrimSpace(s.Provider)
+		if p := set.SMTP[i].Provider; p != "" {
+			if _, ok := sendlimit.Providers[p]; !ok {
+				return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "provider"))
+			}
+		}
+		if s.Weight < 0 {
+			return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "weight"))
+		}
+		if s.SendLimit.PerDay < 0 || s.SendLimit.PerMinute < 0 {
+			return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "send_limit"))
+		}
+
 		// If there's no password coming in from the frontend, copy the existing
 		// password by matching the UUID.
 		if s.Password == "" {
@@ -147,12 +368,22 @@ func (a *App) UpdateSettings(c echo.Context) error {
 		}
 	}
 	if !has {
-		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.errorNoSMTP"))
+		return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.errorNoSMTP"))
 	}
 
 	// Always remove the trailing slash from the app root URL.
 	set.AppRootURL = strings.TrimRight(set.AppRootURL, "/")
 
+	// Short link URL. If empty, the root URL is used.
+	set.AppShortLinkURL = strings.TrimRight(strings.TrimSpace(set.AppShortLinkURL), "/")
+	if set.AppShortLinkURL != "" {
+		u, err := url.Parse(set.AppShortLinkURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "app.short_link_url"))
+		}
+	}
+
 	// Bounce boxes.
 	for i, s := range set.BounceBoxes {
 		// Assign a UUID. The frontend only sends a password when the user explicitly
@@ -168,7 +399,7 @@ func (a *App) UpdateSettings(c echo.Context) error {
 		set.BounceBoxes[i].Host = strings.TrimSpace(s.Host)
 
 		if d, _ := time.ParseDuration(s.ScanInterval); d.Minutes() < 1 {
-			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.bounces.invalidScanInterval"))
+			return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.bounces.invalidScanInterval"))
 		}
 
 		// If there's no password coming in from the frontend, copy the existing
@@ -198,30 +429,169 @@ func (a *App) UpdateSettings(c echo.Context) error {
 
 		name := reAlphaNum.ReplaceAllString(strings.ToLower(m.Name), "")
 		if _, ok := names[name]; ok {
-			return echo.NewHTTPError(http.StatusBadRequest,
+			return set, echo.NewHTTPError(http.StatusBadRequest,
 				a.i18n.Ts("settings.duplicateMessengerName", "name", name))
 		}
 		if len(name) == 0 {
-			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.invalidMessengerName"))
+			return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("settings.invalidMessengerName"))
 		}
 
 		set.Messengers[i].Name = name
 		names[name] = true
 	}
 
+	// Messenger routes should have valid domain patterns and refer to the messengers above.
+	for i, r := range set.AppMessengerRoutes {
+		r.Domain = strings.ToLower(strings.TrimSpace(r.Domain))
+		if !reRouteDomain.MatchString(r.Domain) || !names[r.Messenger] {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", fmt.Sprintf("app.messenger_routes[%d]", i)))
+		}
+		set.AppMessengerRoutes[i] = r
+	}
+
+	// The public templates directory should exist.
+	set.AppPublicTemplatesDir = strings.TrimSpace(set.AppPublicTemplatesDir)
+	if set.AppPublicTemplatesDir != "" {
+		if s, err := os.Stat(set.AppPublicTemplatesDir); err != nil || !s.IsDir() {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "app.public_templates_dir"))
+		}
+	}
+
+	// Global e-mail headers.
+	if set.AppEmailHeaders == nil {
+		set.AppEmailHeaders = models.Headers{}
+	}
+	for _, h := range set.AppEmailHeaders {
+		for k, v := range h {
+			key := textproto.CanonicalMIMEHeaderKey(k)
+			if !reHeaderName.MatchString(k) || strings.ContainsAny(v, "\r\n") ||
+				reservedCampaignHeaders[key] || reservedGlobalHeaders[key] {
+				return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("campaigns.fieldInvalidHeader", "name", k))
+			}
+		}
+	}
+
+	for i, w := range set.Webhooks {
+		// UUID to keep track of secret changes similar to the SMTP logic above.
+		if w.UUID == "" {
+			set.Webhooks[i].UUID = uuid.Must(uuid.NewV4()).String()
+		}
+
+		if w.Secret == "" {
+			for _, c := range cur.Webhooks {
+				if w.UUID == c.UUID {
+					set.Webhooks[i].Secret = c.Secret
+				}
+			}
+		}
+
+		u, err := url.Parse(strings.TrimSpace(w.URL))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "webhooks.url"))
+		}
+		set.Webhooks[i].URL = u.String()
+	}
+
 	// S3 password?
 	if set.UploadS3AwsSecretAccessKey == "" {
 		set.UploadS3AwsSecretAccessKey = cur.UploadS3AwsSecretAccessKey
 	}
+	if set.UploadSFTPPassword == "" {
+		set.UploadSFTPPassword = cur.UploadSFTPPassword
+	}
+	if set.UploadSFTPPrivateKey == "" {
+		set.UploadSFTPPrivateKey = cur.UploadSFTPPrivateKey
+	}
+	if set.UploadWebDAVPassword == "" {
+		set.UploadWebDAVPassword = cur.UploadWebDAVPassword
+	}
+
+	// The remote media providers' settings are validated by initializing them,
+	// which doesn't connect to the server.
+	switch set.UploadProvider {
+	case "filesystem", "s3":
+	case "sftp", "webdav":
+		if _, err := newMediaStore(set.UploadProvider, settingsToKoanf(set)); err != nil {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "upload."+set.UploadProvider+": "+err.Error()))
+		}
+	default:
+		return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "upload.provider"))
+	}
 	if set.SendgridKey == "" {
 		set.SendgridKey = cur.SendgridKey
 	}
 	if set.BouncePostmark.Password == "" {
 		set.BouncePostmark.Password = cur.BouncePostmark.Password
 	}
+
+	// Postmark's webhooks can only be authenticated with basic auth.
+	if set.BouncePostmark.Enabled && (set.BouncePostmark.Username == "" || set.BouncePostmark.Password == "") {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "bounce.postmark"))
+	}
 	if set.BounceForwardEmail.Key == "" {
 		set.BounceForwardEmail.Key = cur.BounceForwardEmail.Key
 	}
+	if set.BounceVERP.Secret == "" {
+		set.BounceVERP.Secret = cur.BounceVERP.Secret
+	}
+
+	// VERP. Generate a signing secret if there isn't one.
+	if set.BounceVERP.Enabled {
+		if set.BounceVERP.Secret == "" {
+			s, err := utils.GenerateRandomString(32)
+			if err != nil {
+				return set, echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+			}
+			set.BounceVERP.Secret = s
+		}
+
+		if _, err := verp.New(verp.Opt{
+			Domain: set.BounceVERP.Domain,
+			Prefix: set.BounceVERP.Prefix,
+			Scheme: set.BounceVERP.Scheme,
+			Secret: set.BounceVERP.Secret,
+		}); err != nil {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "bounce.verp: "+err.Error()))
+		}
+	}
+
+	// Unsubscribe keywords of replies by language. Blank keywords and languages are dropped.
+	kws := make(map[string][]string, len(set.BounceReplyUnsubscribe.Keywords))
+	for lang, words := range set.BounceReplyUnsubscribe.Keywords {
+		lang = strings.ToLower(strings.TrimSpace(lang))
+		for _, w := range words {
+			if w = strings.TrimSpace(w); w == "" {
+				continue
+			}
+			if lang == "" || !strHasLen(w, 1, 200) {
+				return set, echo.NewHTTPError(http.StatusBadRequest,
+					a.i18n.Ts("globals.messages.invalidFields", "name", "bounce.reply_unsubscribe.keywords"))
+			}
+			kws[lang] = append(kws[lang], w)
+		}
+	}
+	set.BounceReplyUnsubscribe.Keywords = kws
+
+	// Source IP allowlists of the bounce webhooks. Blank entries are dropped.
+	wl := &set.BounceWebhookAllowlist
+	wl.TrustedProxies = trimStrings(wl.TrustedProxies)
+	for prov, cidrs := range wl.AllowedCIDRs {
+		wl.AllowedCIDRs[prov] = trimStrings(cidrs)
+	}
+	if _, err := webhooks.NewAllowlist(webhooks.AllowlistOpt{
+		TrustedProxies: wl.TrustedProxies,
+		AllowedCIDRs:   wl.AllowedCIDRs,
+	}); err != nil {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "bounce.webhook_allowlist: "+err.Error()))
+	}
+
 	if set.SecurityCaptcha.HCaptcha.Secret == "" {
 		set.SecurityCaptcha.HCaptcha.Secret = cur.SecurityCaptcha.HCaptcha.Secret
 	}
@@ -232,11 +602,206 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	// OIDC user auto-creation is enabled. Validate.
 	if set.OIDC.AutoCreateUsers {
 		if set.OIDC.DefaultUserRoleID.Int < auth.SuperAdminRoleID {
-			return echo.NewHTTPError(http.StatusBadRequest,
+			return set, echo.NewHTTPError(http.StatusBadRequest,
 				a.i18n.Ts("globals.messages.invalidFields", "name", a.i18n.T("settings.security.OIDCDefaultRole")))
 		}
 	}
 
+	switch set.PrivacyTrackingMode {
+	case models.CampaignTrackingModeFull, models.CampaignTrackingModeClicksOnly, models.CampaignTrackingModeNone:
+	case "":
+		set.PrivacyTrackingMode = models.CampaignTrackingModeFull
+	default:
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "privacy.tracking_mode"))
+	}
+
+	// 0 deletes subscriber data immediately on request.
+	if set.PrivacyDeletionGraceDays < 0 || set.PrivacyDeletionGraceDays > 365 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "privacy.deletion_grace_days"))
+	}
+
+	// Unsubscribe reason choices.
+	if len(set.PrivacyUnsubscribeReasons.Choices) > maxUnsubReasons {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "privacy.unsubscribe_reasons"))
+	}
+	choices := make([]string, 0, len(set.PrivacyUnsubscribeReasons.Choices))
+	for _, v := range set.PrivacyUnsubscribeReasons.Choices {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if len(v) > unsubReasonMaxLen {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "privacy.unsubscribe_reasons"))
+		}
+		if !slices.Contains(choices, v) {
+			choices = append(choices, v)
+		}
+	}
+	set.PrivacyUnsubscribeReasons.Choices = choices
+
+	switch set.PrivacyPurgeUnconfirmedAction {
+	case models.PurgeActionDelete, models.PurgeActionAnonymize:
+	case "":
+		set.PrivacyPurgeUnconfirmedAction = models.PurgeActionDelete
+	default:
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "privacy.purge_unconfirmed_action"))
+	}
+
+	if set.AppTxConcurrency < 1 || set.AppTxQueueSize < 1 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "app.tx_concurrency / app.tx_queue_size"))
+	}
+
+	if d, err := time.ParseDuration(set.AppAdhocRecipientsRetention); err != nil || d < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "app.adhoc_recipients_retention"))
+	}
+
+	// Tracked attribute keys are top level keys.
+	attribs := make([]string, 0, len(set.AppTrackedAttribs))
+	for _, k := range set.AppTrackedAttribs {
+		if k = strings.TrimSpace(k); k != "" && !slices.Contains(attribs, k) {
+			attribs = append(attribs, k)
+		}
+	}
+	set.AppTrackedAttribs = attribs
+
+	if d, err := time.ParseDuration(set.AppAttribHistoryRetention); err != nil || d < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "app.attrib_history_retention"))
+	}
+
+	if d, err := time.ParseDuration(set.AppCampaignStatsRollupAfter); err != nil || d < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "app.campaign_stats_rollup_after"))
+	}
+
+	if d, err := time.ParseDuration(set.AppMaxCampaignRuntime); err != nil || d < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "app.max_campaign_runtime"))
+	}
+
+	for _, v := range set.AppProgressMilestones {
+		if v < 1 || v > 100 {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "app.progress_milestones"))
+		}
+	}
+	if set.AppProgressMilestones == nil {
+		set.AppProgressMilestones = []int{}
+	}
+
+	// Seed list addresses.
+	seeds := make([]string, 0, len(set.AppSeedEmails))
+	for _, e := range set.AppSeedEmails {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+
+		em, err := a.importer.SanitizeEmail(e)
+		if err != nil {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "app.seed_emails: "+e))
+		}
+		if !slices.Contains(seeds, em) {
+			seeds = append(seeds, em)
+		}
+	}
+	set.AppSeedEmails = seeds
+
+	// Sunset policy. Inactivity can only be determined with individual tracking.
+	if sp := set.MaintenanceSunset; sp.Enabled {
+		if !set.PrivacyIndividualTracking || set.PrivacyTrackingMode == models.CampaignTrackingModeNone {
+			return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("maintenance.sunsetNeedsTracking"))
+		}
+		if sp.InactiveDays < 1 || sp.InactiveCampaigns < 0 || sp.GraceDays < 0 || sp.FinalCampaignID < 0 || sp.DormantListID < 0 {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "maintenance.sunset"))
+		}
+	}
+
+	// Bot filter durations.
+	if set.PrivacyBotFilter.Enabled {
+		for _, d := range []string{set.PrivacyBotFilter.GraceWindow, set.PrivacyBotFilter.BurstWindow} {
+			if _, err := time.ParseDuration(d); err != nil {
+				return set, echo.NewHTTPError(http.StatusBadRequest,
+					a.i18n.Ts("globals.messages.invalidFields", "name", "privacy.bot_filter"))
+			}
+		}
+	}
+
+	// Bounce actions. Each bounce type has its own threshold and action.
+	for typ, b := range set.BounceActions {
+		switch typ {
+		case models.BounceTypeSoft, models.BounceTypeHard, models.BounceTypeComplaint:
+		default:
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "bounce.actions"))
+		}
+
+		switch b.Action {
+		case models.BounceActionNone, models.BounceActionUnsubscribe, models.BounceActionBlocklist, models.BounceActionDelete:
+		default:
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "bounce.actions."+typ))
+		}
+		if b.Count < 1 {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "bounce.actions."+typ))
+		}
+	}
+	if set.BounceActions == nil {
+		set.BounceActions = map[string]models.BounceAction{}
+	}
+	if _, ok := set.BounceActions[models.BounceTypeComplaint]; !ok {
+		set.BounceActions[models.BounceTypeComplaint] = models.BounceAction{Count: 1, Action: models.BounceActionBlocklist}
+	}
+
+	// Ad-hoc subscriber query limits.
+	if g := set.AppQueryGuard; g.Enabled {
+		d, err := time.ParseDuration(g.Timeout)
+		if err != nil || d < 0 || g.MaxCost < 0 || g.MaxRows < 0 ||
+			(g.Action != models.QueryGuardActionConfirm && g.Action != models.QueryGuardActionRefuse) {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "app.query_guard"))
+		}
+	}
+
+	// Dynamic attachment fetching.
+	fetchTimeout, err := time.ParseDuration(set.AppAttachmentFetch.Timeout)
+	if err != nil || fetchTimeout <= 0 || set.AppAttachmentFetch.MaxSizeMB < 1 ||
+		set.AppAttachmentFetch.Concurrency < 1 || set.AppAttachmentFetch.CacheSizeMB < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "app.attachment_fetch"))
+	}
+	if d, err := time.ParseDuration(set.AppAttachmentFetch.CacheTTL); err != nil || d < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "app.attachment_fetch"))
+	}
+	contentTypes := make([]string, 0, len(set.AppAttachmentFetch.ContentTypes))
+	for _, v := range set.AppAttachmentFetch.ContentTypes {
+		if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+			contentTypes = append(contentTypes, v)
+		}
+	}
+	set.AppAttachmentFetch.ContentTypes = contentTypes
+
+	if set.AppMaxMessageSizeMB < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "app.max_message_size_mb"))
+	}
+
+	// E-mail verification DNS timeout.
+	if d, err := time.ParseDuration(set.PrivacyEmailVerification.DNSTimeout); err != nil || d < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "privacy.email_verification"))
+	}
+
 	for n, v := range set.UploadExtensions {
 		set.UploadExtensions[n] = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(v), "."))
 	}
@@ -258,6 +823,59 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	}
 	set.DomainAllowlist = doms
 
+	if set.SecurityPublicRateLimit < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "security.public_rate_limit"))
+	}
+
+	// Validate and clean the trusted proxies.
+	proxies := make([]string, 0, len(set.SecurityTrustedProxies))
+	for _, p := range set.SecurityTrustedProxies {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, err := webhooks.ParseCIDR(p); err != nil {
+			return set, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidData")+": invalid trusted proxy: "+p)
+		}
+		proxies = append(proxies, p)
+	}
+	set.SecurityTrustedProxies = proxies
+
+	// Validate the security alert rules.
+	fl := set.SecurityAlerts.FailedLogins
+	if fl.Threshold < 1 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "security.alerts.failed_logins.threshold"))
+	}
+	if d, err := time.ParseDuration(fl.Window); err != nil || d < time.Minute || d > secAlertLoginRetention {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "security.alerts.failed_logins.window"))
+	}
+	if d, err := time.ParseDuration(fl.Cooldown); err != nil || d < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "security.alerts.failed_logins.cooldown"))
+	}
+	if d, err := time.ParseDuration(set.SecurityAlerts.NewTokenNetwork.Cooldown); err != nil || d < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "security.alerts.new_token_network.cooldown"))
+	}
+
+	// Validate the storage usage alert thresholds.
+	st := set.MaintenanceStorage
+	if st.DiskThreshold < 0 || st.DiskThreshold > 100 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "maintenance.storage.disk_threshold"))
+	}
+	if st.DBThresholdMB < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "maintenance.storage.db_threshold_mb"))
+	}
+	if d, err := time.ParseDuration(st.Cooldown); err != nil || d < 0 {
+		return set, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "maintenance.storage.cooldown"))
+	}
+
 	// Validate and clean CORS domains.
 	cors := make([]string, 0, len(set.SecurityCORSOrigins))
 	for _, d := range set.SecurityCORSOrigins {
@@ -270,7 +888,7 @@ func (a *App) UpdateSettings(c echo.Context) error {
 			// Parse and validate the URL.
 			u, err := url.Parse(d)
 			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
-				return echo.NewHTTPError(http.StatusBadRequest,
+				return set, echo.NewHTTPError(http.StatusBadRequest,
 					a.i18n.Ts("globals.messages.invalidData")+": invalid CORS domain: "+d)
 			}
 			// Save clean scheme + host
@@ -282,16 +900,182 @@ func (a *App) UpdateSettings(c echo.Context) error {
 	// Validate slow query caching cron.
 	if set.CacheSlowQueries {
 		if _, err := cron.ParseStandard(set.CacheSlowQueriesInterval); err != nil {
-			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": slow query cron: "+err.Error())
+			return set, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": slow query cron: "+err.Error())
 		}
 	}
 
-	// Update the settings in the DB.
-	if err := a.core.UpdateSettings(set); err != nil {
+	// Validate the digest report.
+	dg, err := a.validateDigestSettings(set.AppDigest)
+	if err != nil {
+		return set, err
+	}
+	set.AppDigest = dg
+
+	return set, nil
+}
+
+// validateDigestSettings validates the digest report settings and returns them
+// with the recipients sanitized and the sections deduplicated.
+func (a *App) validateDigestSettings(d models.DigestSettings) (models.DigestSettings, error) {
+	if d.Enabled {
+		if _, err := cron.ParseStandard(d.Schedule); err != nil {
+			return d, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidData")+": digest cron: "+err.Error())
+		}
+	}
+	if d.PeriodDays < 1 || d.PeriodDays > 366 {
+		return d, echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "app.digest.period_days"))
+	}
+
+	rcpt := make([]string, 0, len(d.Recipients))
+	for _, e := range d.Recipients {
+		if e = strings.TrimSpace(e); e == "" {
+			continue
+		}
+
+		em, err := a.importer.SanitizeEmail(e)
+		if err != nil {
+			return d, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "app.digest.recipients"))
+		}
+		rcpt = append(rcpt, em)
+	}
+	d.Recipients = rcpt
+
+	secs := make([]string, 0, len(d.Sections))
+	for _, s := range d.Sections {
+		if !slices.Contains(models.DigestSections, s) {
+			return d, echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "app.digest.sections"))
+		}
+		if !slices.Contains(secs, s) {
+			secs = append(secs, s)
+		}
+	}
+	d.Sections = secs
+
+	return d, nil
+}
+
+// GetSettingsByKey returns the value of a single setting key from the DB. The key
+// can be a dotted path into the setting's value, eg: bounce.postmark.enabled or
+// smtp.0.host. Secrets are masked as in GetSettings.
+func (a *App) GetSettingsByKey(c echo.Context) error {
+	key := c.Param("key")
+
+	s, err := a.reqCore(c).GetSettings()
+	if err != nil {
 		return err
 	}
 
-	return a.handleSettingsRestart(c)
+	b, err := json.Marshal(maskSettings(s))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	val, ok := lookupSettingsKey(all, key)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, a.i18n.Ts("globals.messages.notFound", "name", key))
+	}
+
+	return c.JSON(http.StatusOK, okResp{val})
+}
+
+// unmaskSettings empties the secrets in the settings that are masked by maskSettings()
+// so that they are retained from the current settings on saving.
+func unmaskSettings(s *models.Settings) {
+	unmask := func(v *string) {
+		if *v != "" && strings.Trim(*v, pwdMask) == "" {
+			*v = ""
+		}
+	}
+
+	for i := range s.SMTP {
+		unmask(&s.SMTP[i].Password)
+	}
+	for i := range s.BounceBoxes {
+		unmask(&s.BounceBoxes[i].Password)
+	}
+	for i := range s.Messengers {
+		unmask(&s.Messengers[i].Password)
+	}
+	for i := range s.Webhooks {
+		unmask(&s.Webhooks[i].Secret)
+	}
+
+	unmask(&s.UploadS3AwsSecretAccessKey)
+	unmask(&s.UploadSFTPPassword)
+	unmask(&s.UploadSFTPPrivateKey)
+	unmask(&s.UploadWebDAVPassword)
+	unmask(&s.SendgridKey)
+	unmask(&s.BouncePostmark.Password)
+	unmask(&s.BounceForwardEmail.Key)
+	unmask(&s.BounceVERP.Secret)
+	unmask(&s.SecurityCaptcha.HCaptcha.Secret)
+	unmask(&s.OIDC.ClientSecret)
+}
+
+// lookupSettingsKey returns the value of a setting key or a dotted path into it
+// from the settings map. Setting keys have dots themselves, eg: app.root_url, so
+// the longest key that the path starts with is picked and the rest of the path
+// is walked through the nested objects and arrays (by index) of its value.
+func lookupSettingsKey(all map[string]json.RawMessage, path string) (any, bool) {
+	var (
+		key  string
+		raw  json.RawMessage
+		rest string
+	)
+	for k, v := range all {
+		if k == path {
+			key, raw, rest = k, v, ""
+			break
+		}
+		if strings.HasPrefix(path, k+".") && len(k) > len(key) {
+			key, raw, rest = k, v, strings.TrimPrefix(path, k+".")
+		}
+	}
+	if key == "" {
+		return nil, false
+	}
+
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+
+	var val any
+	if err := d.Decode(&val); err != nil {
+		return nil, false
+	}
+	if rest == "" {
+		return val, true
+	}
+
+	for _, p := range strings.Split(rest, ".") {
+		switch v := val.(type) {
+		case map[string]any:
+			n, ok := v[p]
+			if !ok {
+				return nil, false
+			}
+			val = n
+
+		case []any:
+			i, err := strconv.Atoi(p)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			val = v[i]
+
+		default:
+			return nil, false
+		}
+	}
+
+	return val, true
 }
 
 // UpdateSettingsByKey updates a single setting key-value in the DB.
@@ -308,22 +1092,31 @@ func (a *App) UpdateSettingsByKey(c echo.Context) error {
 	}
 
 	// Update the value in the DB.
-	if err := a.core.UpdateSettingsByKey(key, b); err != nil {
+	if err := a.reqCore(c).UpdateSettingsByKey(key, b); err != nil {
 		return err
 	}
 
-	return a.handleSettingsRestart(c)
+	if managerRuntimeKeys[key] {
+		set, err := a.reqCore(c).GetSettings()
+		if err != nil {
+			return err
+		}
+
+		a.manager.Reconfigure(makeManagerRuntimeConfig(set))
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	return a.handleSettingsRestart(c, []string{key})
 }
 
 // handleSettingsRestart checks for running campaigns and either triggers an
-// immediate app restart or marks the app as needing a restart.
-func (a *App) handleSettingsRestart(c echo.Context) error {
+// immediate app restart or marks the app as needing a restart, recording
+// the changed setting keys as the reason.
+func (a *App) handleSettingsRestart(c echo.Context, keys []string) error {
 	// If there are any active campaigns, don't do an auto reload and
 	// warn the user on the frontend.
 	if a.manager.HasRunningCampaigns() {
-		a.Lock()
-		a.needsRestart = true
-		a.Unlock()
+		a.setNeedsRestart(keys, auth.GetUser(c).Username)
 
 		return c.JSON(http.StatusOK, okResp{struct {
 			NeedsRestart bool `json:"needs_restart"`
@@ -339,9 +1132,165 @@ func (a *App) handleSettingsRestart(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
-// GetLogs returns the log entries stored in the log buffer.
+// onlyManagerRuntimeChanged checks whether the settings that have changed, if any,
+// are all in managerRuntimeKeys.
+func onlyManagerRuntimeChanged(cur, set models.Settings) bool {
+	// Blank out the runtime settings on both and compare the rest.
+	strip := func(s models.Settings) ([]byte, error) {
+		s.AppBatchSize = 0
+		s.AppConcurrency = 0
+		s.AppMessageRate = 0
+		s.AppMessageSlidingWindow = false
+		s.AppMessageSlidingWindowDuration = ""
+		s.AppMessageSlidingWindowRate = 0
+		s.Version = 0
+
+		return json.Marshal(s)
+	}
+
+	a, err := strip(cur)
+	if err != nil {
+		return false
+	}
+	b, err := strip(set)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(a, b)
+}
+
+// changedSettingsKeys returns the sorted setting keys whose values differ between cur and set.
+func changedSettingsKeys(cur, set models.Settings) []string {
+	toMap := func(s models.Settings) map[string]json.RawMessage {
+		s.Version = 0
+
+		var out map[string]json.RawMessage
+		b, _ := json.Marshal(s)
+		_ = json.Unmarshal(b, &out)
+		return out
+	}
+
+	var (
+		a   = toMap(cur)
+		b   = toMap(set)
+		out = []string{}
+	)
+	for k, v := range b {
+		if !bytes.Equal(a[k], v) {
+			out = append(out, k)
+		}
+	}
+	slices.Sort(out)
+
+	return out
+}
+
+// setNeedsRestart marks the app as needing a restart and records the
+// setting keys whose changes are pending the restart.
+func (a *App) setNeedsRestart(keys []string, user string) {
+	a.Lock()
+	a.needsRestart = true
+	a.pendingRestart = append(a.pendingRestart, pendingChange{Keys: keys, User: user, ChangedAt: time.Now()})
+	a.Unlock()
+}
+
+// onlyBounceBoxesChanged checks whether the bounce mailboxes are the only
+// settings that differ between cur and set.
+func onlyBounceBoxesChanged(cur, set models.Settings) bool {
+	strip := func(s models.Settings) ([]byte, error) {
+		s.BounceBoxes = nil
+		s.Version = 0
+
+		return json.Marshal(s)
+	}
+
+	a, err := strip(cur)
+	if err != nil {
+		return false
+	}
+	b, err := strip(set)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Equal(a, b)
+}
+
+// reloadBounceMailbox replaces the bounce manager's mailbox with the first
+// enabled mailbox in the settings, as initBounceManager() does.
+func (a *App) reloadBounceMailbox(set models.Settings) error {
+	for _, b := range set.BounceBoxes {
+		if !b.Enabled {
+			continue
+		}
+
+		d, _ := time.ParseDuration(b.ScanInterval)
+		return a.bounce.ReloadMailbox(true, b.Type, mailbox.Opt{
+			Host:          b.Host,
+			Port:          b.Port,
+			AuthProtocol:  b.AuthProtocol,
+			Username:      b.Username,
+			Password:      b.Password,
+			TLSEnabled:    b.TLSEnabled,
+			TLSSkipVerify: b.TLSSkipVerify,
+			ScanInterval:  d,
+		})
+	}
+
+	return a.bounce.ReloadMailbox(false, "", mailbox.Opt{})
+}
+
+// makeManagerRuntimeConfig returns the campaign manager config with the
+// runtime settings in managerRuntimeKeys.
+func makeManagerRuntimeConfig(s models.Settings) manager.Config {
+	d, _ := time.ParseDuration(s.AppMessageSlidingWindowDuration)
+	maxRuntime, _ := time.ParseDuration(s.AppMaxCampaignRuntime)
+
+	return manager.Config{
+		BatchSize:             s.AppBatchSize,
+		Concurrency:           s.AppConcurrency,
+		MessageRate:           s.AppMessageRate,
+		SlidingWindow:         s.AppMessageSlidingWindow,
+		SlidingWindowDuration: d,
+		SlidingWindowRate:     s.AppMessageSlidingWindowRate,
+		MaxRuntime:            maxRuntime,
+	}
+}
+
+// GetLogs returns the log entries stored in the log buffer as an array, or as a
+// single page of results in the standard envelope with ?paginated=true.
 func (a *App) GetLogs(c echo.Context) error {
-	return c.JSON(http.StatusOK, okResp{a.bufLog.Lines()})
+	lines := a.bufLog.Lines()
+
+	// Encode and write the lines one by one instead of serializing the
+	// whole buffer into memory again.
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSONCharsetUTF8)
+	w.WriteHeader(http.StatusOK)
+
+	start, end := `{"data":[`, "]}"
+	if paginated(c) {
+		start, end = `{"data":{"results":[`, fmt.Sprintf(`],"total":%d,"per_page":%d,"page":1}}`, len(lines), len(lines))
+	}
+
+	enc := json.NewEncoder(w)
+	if _, err := io.WriteString(w, start); err != nil {
+		return nil
+	}
+	for i, l := range lines {
+		if i > 0 {
+			if _, err := io.WriteString(w, ","); err != nil {
+				return nil
+			}
+		}
+		if err := enc.Encode(l); err != nil {
+			return nil
+		}
+	}
+	_, _ = io.WriteString(w, end)
+
+	return nil
 }
 
 // TestSMTPSettings returns the log entries stored in the log buffer.
@@ -400,6 +1349,90 @@ func (a *App) TestSMTPSettings(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{a.bufLog.Lines()})
 }
 
+// TestMediaSettings tests the media upload settings in the request by uploading,
+// reading back, and deleting a small probe file. Secrets that are empty or masked
+// in the request are taken from the saved settings.
+func (a *App) TestMediaSettings(c echo.Context) error {
+	var set models.Settings
+	if err := c.Bind(&set); err != nil {
+		return err
+	}
+
+	cur, err := a.reqCore(c).GetSettings()
+	if err != nil {
+		return err
+	}
+	unmaskSettings(&set)
+	if set.UploadS3AwsSecretAccessKey == "" {
+		set.UploadS3AwsSecretAccessKey = cur.UploadS3AwsSecretAccessKey
+	}
+	if set.UploadSFTPPassword == "" {
+		set.UploadSFTPPassword = cur.UploadSFTPPassword
+	}
+	if set.UploadSFTPPrivateKey == "" {
+		set.UploadSFTPPrivateKey = cur.UploadSFTPPrivateKey
+	}
+	if set.UploadWebDAVPassword == "" {
+		set.UploadWebDAVPassword = cur.UploadWebDAVPassword
+	}
+	set.AppRootURL = cur.AppRootURL
+
+	store, err := newMediaStore(set.UploadProvider, settingsToKoanf(set))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "upload."+set.UploadProvider+": "+err.Error()))
+	}
+
+	// Upload, read back, and delete a probe file.
+	var (
+		name = fmt.Sprintf("listmonk-test-%d.txt", time.Now().UnixNano())
+		body = []byte("listmonk media upload test")
+	)
+	if _, err := store.Put(name, "text/plain", bytes.NewReader(body)); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "upload: "+err.Error())
+	}
+
+	// The public URL of the file should be a valid URL.
+	u := store.GetURL(name)
+	if p, err := url.Parse(u); err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		_ = store.Delete(name)
+		return echo.NewHTTPError(http.StatusBadRequest, "url: invalid file URL: "+u)
+	}
+
+	b, err := store.GetBlob(u)
+	if err == nil && !bytes.Equal(b, body) {
+		err = errors.New("file contents don't match")
+	}
+	if err != nil {
+		_ = store.Delete(name)
+		return echo.NewHTTPError(http.StatusInternalServerError, "read: "+err.Error())
+	}
+
+	if err := store.Delete(name); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "delete: "+err.Error())
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
+
+// settingsToKoanf returns the settings as a koanf instance where the dot
+// separated keys are unflattened, as they are when they're loaded from the DB.
+func settingsToKoanf(set models.Settings) *koanf.Koanf {
+	ko := koanf.New(".")
+
+	b, err := json.Marshal(set)
+	if err != nil {
+		return ko
+	}
+	var mp map[string]any
+	if err := json.Unmarshal(b, &mp); err != nil {
+		return ko
+	}
+	ko.Load(confmap.Provider(mp, "."), nil)
+
+	return ko
+}
+
 func (a *App) GetAboutInfo(c echo.Context) error {
 	var mem runtime.MemStats
 	runtime.ReadMemStats(&mem)
@@ -408,5 +1441,26 @@ func (a *App) GetAboutInfo(c echo.Context) error {
 	out.System.AllocMB = mem.Alloc / 1024 / 1024
 	out.System.OSMB = mem.Sys / 1024 / 1024
 
+	out.UnverifiedBounceWebhooks = map[string]int64{}
+	out.RejectedBounceWebhooks = map[string]int64{}
+	if a.bounce != nil {
+		out.UnverifiedBounceWebhooks = a.bounce.UnverifiedCounts()
+		out.RejectedBounceWebhooks = a.bounce.RejectedCounts()
+	}
+
+	out.SMTPServers = map[string][]email.ServerHealth{}
+	for _, m := range a.messengers {
+		if e, ok := m.(*email.Emailer); ok {
+			out.SMTPServers[e.Name()] = e.Health()
+		}
+	}
+
+	out.Storage = a.capacity.Usage()
+
+	out.OverriddenTemplates = []string{}
+	if t, ok := c.Echo().Renderer.(*tplRenderer); ok {
+		out.OverriddenTemplates = t.getOverridden()
+	}
+
 	return c.JSON(http.StatusOK, out)
 }
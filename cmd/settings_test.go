@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/buflog"
+	"github.com/knadh/listmonk/models"
+)
+
+func TestGetLogs(t *testing.T) {
+	a := newTestApp(t)
+	a.bufLog = buflog.New(10)
+	a.bufLog.Write([]byte("line 1\n"))
+	a.bufLog.Write([]byte("line \"2\"\n"))
+
+	e := newTestEcho()
+	e.GET("/api/logs", a.GetLogs)
+
+	// By default, the lines are a bare array as they've always been.
+	rec := doForm(e, http.MethodGet, "/api/logs", nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var legacy struct {
+		Data []string `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &legacy); err != nil {
+		t.Fatalf("expected an array of lines: %v: %s", err, rec.Body.String())
+	}
+	if len(legacy.Data) != 2 || legacy.Data[1] != `line "2"` {
+		t.Errorf("unexpected lines %q", legacy.Data)
+	}
+
+	// With ?paginated, they're a single page in the standard envelope.
+	rec = doForm(e, http.MethodGet, "/api/logs?paginated=true", nil)
+	var page struct {
+		Data struct {
+			Results []string `json:"results"`
+			Total   int      `json:"total"`
+			PerPage int      `json:"per_page"`
+			Page    int      `json:"page"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+		t.Fatalf("expected paginated results: %v: %s", err, rec.Body.String())
+	}
+	if len(page.Data.Results) != 2 || page.Data.Total != 2 || page.Data.PerPage != 2 || page.Data.Page != 1 {
+		t.Errorf("unexpected page %+v", page.Data)
+	}
+
+	// An empty buffer is an empty array.
+	a.bufLog = buflog.New(10)
+	rec = doForm(e, http.MethodGet, "/api/logs", nil)
+	if rec.Body.String() != `{"data":[]}` {
+		t.Errorf("unexpected empty logs %s", rec.Body.String())
+	}
+}
+
+// secretSettings are settings with every secret set to a distinct value.
+const secretSettings = `{
+	"smtp": [{"uuid": "s1", "enabled": true, "password": "smtp-pass"}],
+	"bounce.mailboxes": [{"uuid": "b1", "password": "box-pass"}],
+	"messengers": [{"uuid": "m1", "name": "m", "password": "msgr-pass"}],
+	"webhooks": [{"uuid": "w1", "url": "https://example.com", "secret": "hook-secret"}],
+	"upload.s3.aws_secret_access_key": "s3-secret",
+	"upload.sftp.password": "sftp-pass",
+	"upload.sftp.private_key": "sftp-key",
+	"upload.webdav.password": "webdav-pass",
+	"bounce.sendgrid_key": "sendgrid-key",
+	"bounce.postmark": {"password": "postmark-pass"},
+	"bounce.forwardemail": {"key": "fwd-key"},
+	"bounce.verp": {"secret": "verp-secret"},
+	"security.captcha": {"hcaptcha": {"secret": "hcaptcha-secret"}},
+	"security.oidc": {"client_secret": "oidc-secret"}
+}`
+
+var secretValues = []string{"smtp-pass", "box-pass", "msgr-pass", "hook-secret", "s3-secret", "sftp-pass", "sftp-key",
+	"webdav-pass", "sendgrid-key", "postmark-pass", "fwd-key", "verp-secret", "hcaptcha-secret", "oidc-secret"}
+
+func TestMaskSettings(t *testing.T) {
+	var s models.Settings
+	if err := json.Unmarshal([]byte(secretSettings), &s); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := json.Marshal(maskSettings(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range secretValues {
+		if strings.Contains(string(b), v) {
+			t.Errorf("secret %s isn't masked", v)
+		}
+	}
+	if !strings.Contains(string(b), `"password":"`+strings.Repeat(pwdMask, len("smtp-pass"))+`"`) {
+		t.Errorf("expected a mask of the same length: %s", b)
+	}
+
+	// The settings that were masked are left as they are.
+	orig, _ := json.Marshal(s)
+	for _, v := range secretValues {
+		if !strings.Contains(string(orig), v) {
+			t.Errorf("secret %s was masked in the original settings", v)
+		}
+	}
+
+	// Unmasking empties the masked secrets so that they're retained on saving,
+	// but leaves the ones that were changed.
+	var m models.Settings
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	m.BounceVERP.Secret = "new" + pwdMask
+	unmaskSettings(&m)
+
+	b, _ = json.Marshal(m)
+	if strings.Contains(string(b), pwdMask+pwdMask) || m.SMTP[0].Password != "" || m.OIDC.ClientSecret != "" {
+		t.Errorf("expected the masked secrets to be emptied: %s", b)
+	}
+	if m.BounceVERP.Secret != "new"+pwdMask {
+		t.Errorf("expected the changed secret to be left, got %q", m.BounceVERP.Secret)
+	}
+}
+
+func TestLookupSettingsKey(t *testing.T) {
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(`{
+		"app.root_url": "http://localhost:9000",
+		"app.root": "x",
+		"smtp": [{"host": "smtp.example.com", "port": 25}],
+		"bounce.postmark": {"enabled": true, "username": "u"}
+	}`), &all); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path string
+		exp  any
+		ok   bool
+	}{
+		{"app.root_url", "http://localhost:9000", true},
+		{"app.root", "x", true},
+		{"smtp.0.host", "smtp.example.com", true},
+		{"smtp.0.port", json.Number("25"), true},
+		{"bounce.postmark.enabled", true, true},
+		{"smtp.1.host", nil, false},
+		{"smtp.x", nil, false},
+		{"bounce.postmark.password", nil, false},
+		{"app.root_url.x", nil, false},
+		{"app", nil, false},
+		{"nope", nil, false},
+	}
+	for _, c := range cases {
+		v, ok := lookupSettingsKey(all, c.path)
+		if ok != c.ok || (ok && v != c.exp) {
+			t.Errorf("%s: expected %v (%v), got %v (%v)", c.path, c.exp, c.ok, v, ok)
+		}
+	}
+
+	// Whole values are returned as they are.
+	if v, ok := lookupSettingsKey(all, "smtp"); !ok || len(v.([]any)) != 1 {
+		t.Errorf("unexpected smtp %v", v)
+	}
+}
+
+func TestSettingsExportImport(t *testing.T) {
+	a, db := newTestAppDB(t)
+
+	var set map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(secretSettings), &set); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"smtp", "upload.sftp.password", "bounce.sendgrid_key", "security.oidc"} {
+		if _, err := db.Exec(`UPDATE settings SET value = $2 WHERE key = $1`, k, string(set[k])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := newTestEcho()
+	e.GET("/api/settings/export", a.ExportSettings)
+	e.GET("/api/settings/:key", a.GetSettingsByKey)
+
+	// Secrets are masked by default.
+	masked := doForm(e, http.MethodGet, "/api/settings/export", nil)
+	if masked.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", masked.Code, masked.Body.String())
+	}
+	for _, v := range []string{"smtp-pass", "sftp-pass", "sendgrid-key", "oidc-secret"} {
+		if strings.Contains(masked.Body.String(), v) {
+			t.Errorf("secret %s is in the default export", v)
+		}
+	}
+
+	// They're included when they're asked for.
+	full := doForm(e, http.MethodGet, "/api/settings/export?secrets=true", nil)
+	for _, v := range []string{"smtp-pass", "sftp-pass", "sendgrid-key", "oidc-secret"} {
+		if !strings.Contains(full.Body.String(), v) {
+			t.Errorf("secret %s isn't in the export with secrets", v)
+		}
+	}
+
+	// A single key is masked too.
+	rec := doForm(e, http.MethodGet, "/api/settings/smtp.0.password", nil)
+	if exp := `{"data":"` + strings.Repeat(pwdMask, len("smtp-pass")) + `"}`; strings.TrimSpace(rec.Body.String()) != exp {
+		t.Errorf("expected %s, got %s", exp, rec.Body.String())
+	}
+	rec = doForm(e, http.MethodGet, "/api/settings/smtp.0.uuid", nil)
+	if strings.TrimSpace(rec.Body.String()) != `{"data":"s1"}` {
+		t.Errorf("unexpected smtp.0.uuid %s", rec.Body.String())
+	}
+	rec = doForm(e, http.MethodGet, "/api/settings/smtp.5.host", nil)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a missing key, got %d", rec.Code)
+	}
+
+	// Importing a masked export retains the secrets and applies the other changes.
+	cur, err := a.core.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := strings.Replace(masked.Body.String(), `"app.site_name": "`+cur.AppSiteName+`"`, `"app.site_name": "Imported"`, 1)
+	imp, err := decodeSettingsImport(cur, []byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imp, err = a.validateSettings(cur, imp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imp.AppSiteName != "Imported" {
+		t.Errorf("expected the imported site name, got %s", imp.AppSiteName)
+	}
+	if imp.SMTP[0].Password != "smtp-pass" || imp.UploadSFTPPassword != "sftp-pass" ||
+		imp.SendgridKey != "sendgrid-key" || imp.OIDC.ClientSecret != "oidc-secret" {
+		t.Errorf("expected the secrets to be retained: %+v", imp)
+	}
+
+	// Importing an export with secrets into another instance sets them.
+	other := cur
+	other.SMTP = nil
+	other.SendgridKey = "other"
+	imp, err = decodeSettingsImport(other, full.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imp.SMTP[0].Password != "smtp-pass" || imp.SendgridKey != "sendgrid-key" || imp.Version != other.Version {
+		t.Errorf("expected the exported secrets: %+v", imp)
+	}
+}
+
+func TestGetSettingsCancelled(t *testing.T) {
+	a, _ := newTestAppDB(t)
+
+	e := newTestEcho()
+	e.GET("/api/settings", a.GetSettings)
+
+	if rec := doForm(e, http.MethodGet, "/api/settings", nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// The queries of a request that's cancelled, eg: by the client going away, are
+	// cancelled with it.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/api/settings", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500 for a cancelled request, got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestValidateBounceActions(t *testing.T) {
+	a, _ := newTestAppDB(t)
+
+	cur, err := a.core.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := func(actions map[string]models.BounceAction) (models.Settings, bool) {
+		t.Helper()
+
+		set := cur
+		set.BounceActions = actions
+		out, err := a.validateSettings(cur, set)
+		return out, err == nil
+	}
+
+	// Each type has its own threshold and action.
+	out, ok := validate(map[string]models.BounceAction{
+		models.BounceTypeSoft:      {Count: 5, Action: models.BounceActionUnsubscribe},
+		models.BounceTypeHard:      {Count: 2, Action: models.BounceActionDelete},
+		models.BounceTypeComplaint: {Count: 3, Action: models.BounceActionNone},
+	})
+	if !ok || out.BounceActions[models.BounceTypeComplaint] != (models.BounceAction{Count: 3, Action: models.BounceActionNone}) {
+		t.Errorf("expected the actions to be valid, got %+v", out.BounceActions)
+	}
+
+	// Complaints blocklist on the first one if they're missing.
+	out, ok = validate(map[string]models.BounceAction{models.BounceTypeSoft: {Count: 2, Action: models.BounceActionNone}})
+	if !ok || out.BounceActions[models.BounceTypeComplaint] != (models.BounceAction{Count: 1, Action: models.BounceActionBlocklist}) {
+		t.Errorf("expected the default complaint action, got %+v", out.BounceActions)
+	}
+
+	for name, actions := range map[string]map[string]models.BounceAction{
+		"unknown type":   {"spam": {Count: 1, Action: models.BounceActionBlocklist}},
+		"unknown action": {models.BounceTypeComplaint: {Count: 1, Action: "ban"}},
+		"zero count":     {models.BounceTypeComplaint: {Count: 0, Action: models.BounceActionBlocklist}},
+		"negative count": {models.BounceTypeSoft: {Count: -1, Action: models.BounceActionNone}},
+	} {
+		if _, ok := validate(actions); ok {
+			t.Errorf("%s: expected the actions to be invalid", name)
+		}
+	}
+}
+
+func TestValidateUnsubscribeReasons(t *testing.T) {
+	a, _ := newTestAppDB(t)
+
+	cur, err := a.core.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := func(choices []string) ([]string, bool) {
+		t.Helper()
+
+		set := cur
+		set.PrivacyUnsubscribeReasons = models.UnsubscribeReasons{Enabled: true, Choices: choices}
+		out, err := a.validateSettings(cur, set)
+		return out.PrivacyUnsubscribeReasons.Choices, err == nil
+	}
+
+	// Choices are trimmed with empty ones and duplicates removed.
+	if out, ok := validate([]string{" Too frequent ", "", "Not relevant", "Too frequent", "  "}); !ok || !slices.Equal(out, []string{"Too frequent", "Not relevant"}) {
+		t.Errorf("unexpected choices %v", out)
+	}
+	if out, ok := validate(nil); !ok || len(out) != 0 {
+		t.Errorf("expected no choices, got %v", out)
+	}
+
+	// Too many or too long choices are rejected.
+	many := make([]string, maxUnsubReasons+1)
+	for n := range many {
+		many[n] = "Reason " + strconv.Itoa(n)
+	}
+	if _, ok := validate(many); ok {
+		t.Error("expected too many choices to be rejected")
+	}
+	if _, ok := validate(many[:maxUnsubReasons]); !ok {
+		t.Error("expected the maximum number of choices to be valid")
+	}
+	if _, ok := validate([]string{strings.Repeat("a", unsubReasonMaxLen+1)}); ok {
+		t.Error("expected a long choice to be rejected")
+	}
+}
+
+func TestValidateEmailHeaders(t *testing.T) {
+	a, _ := newTestAppDB(t)
+
+	cur, err := a.core.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := func(h models.Headers) (models.Headers, bool) {
+		t.Helper()
+
+		set := cur
+		set.AppEmailHeaders = h
+		out, err := a.validateSettings(cur, set)
+		return out.AppEmailHeaders, err == nil
+	}
+
+	if out, ok := validate(models.Headers{{"X-Entity-Ref-ID": "ref"}, {"X-Compliance": "yes"}}); !ok || len(out) != 2 {
+		t.Errorf("expected the headers to be valid, got %v", out)
+	}
+	if out, ok := validate(nil); !ok || out == nil {
+		t.Errorf("expected no headers to be an empty list, got %v", out)
+	}
+
+	// Invalid and protected headers are rejected.
+	for _, h := range []map[string]string{
+		{"X Bad": "1"},
+		{"X-Injected": "a\r\nBcc: evil@example.com"},
+		{"bcc": "evil@example.com"},
+		{"Reply-To": "other@example.com"},
+		{"Return-Path": "bounce@example.com"},
+		{"list-unsubscribe": "<https://example.com>"},
+	} {
+		if _, ok := validate(models.Headers{h}); ok {
+			t.Errorf("expected %v to be rejected", h)
+		}
+	}
+}
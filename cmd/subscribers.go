@@ -11,16 +11,21 @@ import (
 	"strings"
 
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/emailverify"
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/internal/notifs"
 	"github.com/knadh/listmonk/internal/subimporter"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
 const (
 	dummyUUID = "00000000-0000-0000-0000-000000000000"
+
+	// Maximum number of items in a subscription items request.
+	maxSubscriptionItems = 10000
 )
 
 // subQueryReq is a "catch all" struct for reading various
@@ -35,6 +40,11 @@ type subQueryReq struct {
 	Status             string `json:"status"`
 	SubscriptionStatus string `json:"subscription_status"`
 	All                bool   `json:"all"`
+
+	// Confirms running an ad-hoc query that exceeds the app.query_guard limits.
+	Confirm bool `json:"confirm"`
+
+	models.SubscriberFilter
 }
 
 // subOptin contains the data that's passed to the double opt-in e-mail template.
@@ -66,11 +76,15 @@ func (a *App) GetSubscriber(c echo.Context) error {
 	}
 
 	// Fetch the subscriber from the DB.
-	out, err := a.core.GetSubscriber(id, "", "")
+	out, err := a.reqCore(c).GetSubscriber(id, "", "")
 	if err != nil {
 		return err
 	}
 
+	if isPIIRedacted(c) {
+		out = redactSubscriber(out)
+	}
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -85,14 +99,58 @@ func (a *App) GetSubscriberActivity(c echo.Context) error {
 	}
 
 	// Fetch the subscriber activity from the DB.
-	out, err := a.core.GetSubscriberActivity(id)
+	out, err := a.reqCore(c).GetSubscriberActivity(id)
 	if err != nil {
 		return err
 	}
 
+	if isPIIRedacted(c) {
+		out = redactActivity(out)
+	}
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
+// GetSubscriberSends returns the paginated campaign send history of a subscriber,
+// optionally filtered by ?campaign_id and ?from / ?to dates.
+func (a *App) GetSubscriberSends(c echo.Context) error {
+	user := auth.GetUser(c)
+
+	// Check if the user has access to at least one of the lists on the subscriber.
+	id := getID(c)
+	if err := a.hasSubPerm(user, []int{id}); err != nil {
+		return err
+	}
+
+	var (
+		campID, _ = strconv.Atoi(c.QueryParam("campaign_id"))
+		from, to  null.Time
+
+		pg = a.pg.NewFromURL(c.Request().URL.Query())
+	)
+	if v := c.QueryParam("from"); v != "" {
+		t, ok := parseCalendarDate(v, false)
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("analytics.invalidDates"))
+		}
+		from = null.TimeFrom(t)
+	}
+	if v := c.QueryParam("to"); v != "" {
+		t, ok := parseCalendarDate(v, true)
+		if !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("analytics.invalidDates"))
+		}
+		to = null.TimeFrom(t)
+	}
+
+	res, total, err := a.reqCore(c).QuerySubscriberSends(id, campID, from, to, pg.Offset, pg.Limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{makePageResults(res, total, pg)})
+}
+
 // QuerySubscribers handles querying subscribers based on an arbitrary SQL expression.
 func (a *App) QuerySubscribers(c echo.Context) error {
 	// Get the authenticated user.
@@ -113,6 +171,16 @@ func (a *App) QuerySubscribers(c echo.Context) error {
 		}
 	}
 
+	// Bounce and engagement filters.
+	filter, err := a.getSubscriberFilter(c)
+	if err != nil {
+		return err
+	}
+	cond, err := a.reqCore(c).MakeSubscriberFilterExp(query, filter)
+	if err != nil {
+		return err
+	}
+
 	var (
 		searchStr = strings.TrimSpace(c.FormValue("search"))
 		subStatus = c.FormValue("subscription_status")
@@ -121,21 +189,27 @@ func (a *App) QuerySubscribers(c echo.Context) error {
 		pg        = a.pg.NewFromURL(c.Request().URL.Query())
 	)
 
+	// Check the estimated cost of the ad-hoc query.
+	if query != "" {
+		if err := a.reqCore(c).CheckSubscriberQuery(searchStr, cond, listIDs, subStatus, c.FormValue("confirm") == "true", user.UserRoleID == auth.SuperAdminRoleID); err != nil {
+			return err
+		}
+	}
+
 	// Query subscribers from the DB.
-	res, total, err := a.core.QuerySubscribers(searchStr, query, listIDs, subStatus, order, orderBy, pg.Offset, pg.Limit)
+	res, total, err := a.reqCore(c).QuerySubscribers(searchStr, cond, listIDs, subStatus, order, orderBy, pg.Offset, pg.Limit, skipTotal(c))
 	if err != nil {
 		return err
 	}
 
-	out := models.PageResults{
-		Query:   query,
-		Search:  searchStr,
-		Results: res,
-		Total:   total,
-		Page:    pg.Page,
-		PerPage: pg.PerPage,
+	if isPIIRedacted(c) {
+		res = redactSubscribers(res)
 	}
 
+	out := makePageResults(res, total, pg)
+	out.Query = query
+	out.Search = searchStr
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -171,8 +245,25 @@ func (a *App) ExportSubscribers(c echo.Context) error {
 		}
 	}
 
+	// Bounce and engagement filters.
+	filter, err := a.getSubscriberFilter(c)
+	if err != nil {
+		return err
+	}
+	hasQuery := query != ""
+	if query, err = a.reqCore(c).MakeSubscriberFilterExp(query, filter); err != nil {
+		return err
+	}
+
+	// Check the estimated cost of the ad-hoc query.
+	if hasQuery {
+		if err := a.reqCore(c).CheckSubscriberQuery(searchStr, query, listIDs, subStatus, c.FormValue("confirm") == "true", user.UserRoleID == auth.SuperAdminRoleID); err != nil {
+			return err
+		}
+	}
+
 	// Get the batched export iterator.
-	exp, err := a.core.ExportSubscribers(searchStr, query, subIDs, listIDs, subStatus, a.cfg.DBBatchSize)
+	exp, err := a.reqCore(c).ExportSubscribers(searchStr, query, subIDs, listIDs, subStatus, a.cfg.DBBatchSize)
 	if err != nil {
 		return err
 	}
@@ -180,6 +271,9 @@ func (a *App) ExportSubscribers(c echo.Context) error {
 	var (
 		hdr = c.Response().Header()
 		wr  = csv.NewWriter(c.Response())
+
+		// Exports are anonymized for users whose PII view is redacted.
+		redact = isPIIRedacted(c)
 	)
 
 	hdr.Set(echo.HeaderContentType, echo.MIMEOctetStream)
@@ -192,6 +286,12 @@ func (a *App) ExportSubscribers(c echo.Context) error {
 loop:
 	// Iterate in batches until there are no more subscribers to export.
 	for {
+		// Stop querying the DB if the client has aborted the download.
+		if err := c.Request().Context().Err(); err != nil {
+			a.log.Printf("subscriber export aborted: %v", err)
+			break
+		}
+
 		out, err := exp()
 		if err != nil {
 			return err
@@ -201,6 +301,10 @@ loop:
 		}
 
 		for _, r := range out {
+			if redact {
+				r = redactSubscriberExport(r)
+			}
+
 			if err = wr.Write([]string{r.UUID, r.Email, r.Name, r.Attribs, r.Status,
 				r.CreatedAt.Time.String(), r.UpdatedAt.Time.String()}); err != nil {
 				a.log.Printf("error streaming CSV export: %v", err)
@@ -208,8 +312,14 @@ loop:
 			}
 		}
 
-		// Flush CSV to stream after each batch.
+		// Flush CSV to stream after each batch so that only one batch is
+		// held in memory at a time.
 		wr.Flush()
+		if err := wr.Error(); err != nil {
+			a.log.Printf("error streaming CSV export: %v", err)
+			break
+		}
+		c.Response().Flush()
 	}
 
 	return nil
@@ -232,18 +342,56 @@ func (a *App) CreateSubscriber(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
+	// Run the e-mail verification checks, if they're enabled for the API.
+	if a.cfg.Privacy.EmailVerifyOnAPI {
+		if err := a.verifyEmail(req.Email); err != nil {
+			return err
+		}
+	}
+
 	// Filter lists against the current user's permitted lists.
 	listIDs := user.FilterListsByPerm(auth.PermTypeManage, req.Lists)
 
 	// Insert the subscriber into the DB.
-	sub, _, err := a.core.InsertSubscriber(req.Subscriber, listIDs, nil, req.PreconfirmSubs, false)
+	sub, _, err := a.reqCore(c).InsertSubscriber(req.Subscriber, listIDs, nil, req.PreconfirmSubs, false)
 	if err != nil {
 		return err
 	}
 
+	if isPIIRedacted(c) {
+		sub = redactSubscriber(sub)
+	}
+
 	return c.JSON(http.StatusOK, okResp{sub})
 }
 
+// verifyEmail runs the e-mail verification checks, if any are enabled, on a
+// sanitized e-mail and returns a translated HTTP error if it fails them.
+func (a *App) verifyEmail(email string) error {
+	if a.verifier == nil {
+		return nil
+	}
+
+	err := a.verifier.Verify(email)
+	if err == nil {
+		return nil
+	}
+
+	e, ok := err.(*emailverify.Error)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidEmail"))
+	}
+
+	switch e.Reason {
+	case emailverify.ReasonTypo:
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("subscribers.emailTypo", "email", e.Suggestion))
+	case emailverify.ReasonNoMX:
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.emailNoMX"))
+	}
+
+	return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidEmail"))
+}
+
 // UpdateSubscriber handles modification of a subscriber.
 func (a *App) UpdateSubscriber(c echo.Context) error {
 	// Get the authenticated user.
@@ -275,11 +423,15 @@ func (a *App) UpdateSubscriber(c echo.Context) error {
 
 	// Update the subscriber in the DB.
 	id := getID(c)
-	out, _, err := a.core.UpdateSubscriberWithLists(id, req.Subscriber, listIDs, nil, req.PreconfirmSubs, true, false)
+	out, _, err := a.reqCore(c).UpdateSubscriberWithLists(id, req.Subscriber, listIDs, nil, req.PreconfirmSubs, true, false)
 	if err != nil {
 		return err
 	}
 
+	if isPIIRedacted(c) {
+		out = redactSubscriber(out)
+	}
+
 	return c.JSON(http.StatusOK, okResp{out})
 }
 
@@ -287,7 +439,7 @@ func (a *App) UpdateSubscriber(c echo.Context) error {
 func (a *App) SubscriberSendOptin(c echo.Context) error {
 	// Fetch the subscriber.
 	id := getID(c)
-	out, err := a.core.GetSubscriber(id, "", "")
+	out, err := a.reqCore(c).GetSubscriber(id, "", "")
 	if err != nil {
 		return err
 	}
@@ -304,7 +456,7 @@ func (a *App) SubscriberSendOptin(c echo.Context) error {
 func (a *App) BlocklistSubscriber(c echo.Context) error {
 	// Update the subscribers in the DB.
 	id := getID(c)
-	if err := a.core.BlocklistSubscribers([]int{id}); err != nil {
+	if err := a.reqCore(c).BlocklistSubscribers([]int{id}); err != nil {
 		return err
 	}
 
@@ -324,7 +476,7 @@ func (a *App) BlocklistSubscribers(c echo.Context) error {
 	}
 
 	// Update the subscribers in the DB.
-	if err := a.core.BlocklistSubscribers(req.SubscriberIDs); err != nil {
+	if err := a.reqCore(c).BlocklistSubscribers(req.SubscriberIDs); err != nil {
 		return err
 	}
 
@@ -379,11 +531,11 @@ func (a *App) ManageSubscriberLists(c echo.Context) error {
 	var err error
 	switch req.Action {
 	case "add":
-		err = a.core.AddSubscriptions(subIDs, listIDs, req.Status)
+		err = a.reqCore(c).AddSubscriptions(subIDs, listIDs, req.Status)
 	case "remove":
-		err = a.core.DeleteSubscriptions(subIDs, listIDs)
+		err = a.reqCore(c).DeleteSubscriptions(subIDs, listIDs)
 	case "unsubscribe":
-		err = a.core.UnsubscribeLists(subIDs, listIDs, nil)
+		err = a.reqCore(c).UnsubscribeLists(subIDs, listIDs, nil)
 	default:
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidAction"))
 	}
@@ -395,11 +547,109 @@ func (a *App) ManageSubscriberLists(c echo.Context) error {
 	return c.JSON(http.StatusOK, okResp{true})
 }
 
+// ManageSubscriptionItems applies a batch of individual subscription changes,
+// each with its own subscriber, list, and action, and returns the result of each
+// in order. Items that wouldn't change anything are skipped, so a retried request
+// converges to the same state.
+func (a *App) ManageSubscriptionItems(c echo.Context) error {
+	// Get the authenticated user.
+	user := auth.GetUser(c)
+
+	var req struct {
+		Items []models.SubscriptionItem `json:"items"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+	if len(req.Items) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.errorNoIDs"))
+	}
+	if len(req.Items) > maxSubscriptionItems {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "items"))
+	}
+
+	var (
+		seen    = make(map[[2]int]bool, len(req.Items))
+		listIDs = make([]int, 0, len(req.Items))
+	)
+	for n, it := range req.Items {
+		if it.SubscriberID < 1 || it.ListID < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("globals.messages.invalidID"))
+		}
+
+		switch it.Action {
+		case "add":
+			switch it.Status {
+			case "", models.SubscriptionStatusUnconfirmed, models.SubscriptionStatusConfirmed, models.SubscriptionStatusUnsubscribed:
+			default:
+				return echo.NewHTTPError(http.StatusBadRequest,
+					a.i18n.Ts("globals.messages.invalidFields", "name", fmt.Sprintf("items[%d].status", n)))
+			}
+		case "remove", "unsubscribe":
+			req.Items[n].Status = ""
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidAction"))
+		}
+
+		// The same subscription can't be changed more than once in a request
+		// as the order of the changes can't be guaranteed.
+		key := [2]int{it.SubscriberID, it.ListID}
+		if seen[key] {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", fmt.Sprintf("items[%d]", n)))
+		}
+		seen[key] = true
+		listIDs = append(listIDs, it.ListID)
+	}
+
+	// Items on lists that the user doesn't have permissions for are not applied.
+	permitted := make(map[int]bool)
+	for _, id := range user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, listIDs) {
+		permitted[id] = true
+	}
+
+	items := make([]models.SubscriptionItem, 0, len(req.Items))
+	for _, it := range req.Items {
+		if permitted[it.ListID] {
+			items = append(items, it)
+		}
+	}
+
+	var res []models.SubscriptionItemResult
+	if len(items) > 0 {
+		r, err := a.reqCore(c).ManageSubscriptionItems(items)
+		if err != nil {
+			return err
+		}
+		res = r
+	}
+
+	// Assemble the results in the order of the request.
+	out := make([]models.SubscriptionItemResult, 0, len(req.Items))
+	for _, it := range req.Items {
+		if !permitted[it.ListID] {
+			out = append(out, models.SubscriptionItemResult{
+				SubscriberID: it.SubscriberID,
+				ListID:       it.ListID,
+				Action:       it.Action,
+				Result:       models.SubscriptionResultForbidden,
+			})
+			continue
+		}
+
+		out = append(out, res[0])
+		res = res[1:]
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
 // DeleteSubscriber handles deletion of a single subscriber.
 func (a *App) DeleteSubscriber(c echo.Context) error {
 	// Delete the subscribers from the DB.
 	id := getID(c)
-	if err := a.core.DeleteSubscribers([]int{id}, nil); err != nil {
+	if err := a.reqCore(c).DeleteSubscribers([]int{id}, nil); err != nil {
 		return err
 	}
 
@@ -420,7 +670,7 @@ func (a *App) DeleteSubscribers(c echo.Context) error {
 	}
 
 	// Delete the subscribers from the DB.
-	if err := a.core.DeleteSubscribers(ids, nil); err != nil {
+	if err := a.reqCore(c).DeleteSubscribers(ids, nil); err != nil {
 		return err
 	}
 
@@ -441,10 +691,11 @@ func (a *App) DeleteSubscribersByQuery(c echo.Context) error {
 	req.Search = strings.TrimSpace(req.Search)
 	req.Query = formatSQLExp(req.Query)
 	if req.All {
-		// If the "all" flag is set, ignore any subquery that may be present.
+		// If the "all" flag is set, ignore any subquery or filter that may be present.
 		req.Search = ""
 		req.Query = ""
-	} else if req.Search == "" && req.Query == "" {
+		req.SubscriberFilter = models.SubscriberFilter{}
+	} else if req.Search == "" && req.Query == "" && req.SubscriberFilter.IsEmpty() {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "query"))
 	}
 
@@ -456,8 +707,21 @@ func (a *App) DeleteSubscribersByQuery(c echo.Context) error {
 		}
 	}
 
+	// Bounce and engagement filters.
+	query, err := a.reqCore(c).MakeSubscriberFilterExp(req.Query, req.SubscriberFilter)
+	if err != nil {
+		return err
+	}
+
+	// Check the estimated cost of the ad-hoc query.
+	if req.Query != "" {
+		if err := a.reqCore(c).CheckSubscriberQuery(req.Search, query, req.ListIDs, req.SubscriptionStatus, req.Confirm, user.UserRoleID == auth.SuperAdminRoleID); err != nil {
+			return err
+		}
+	}
+
 	// Delete the subscribers from the DB.
-	if err := a.core.DeleteSubscribersByQuery(req.Search, req.Query, req.ListIDs, req.SubscriptionStatus); err != nil {
+	if err := a.reqCore(c).DeleteSubscribersByQuery(req.Search, query, req.ListIDs, req.SubscriptionStatus); err != nil {
 		return err
 	}
 
@@ -478,10 +742,11 @@ func (a *App) BlocklistSubscribersByQuery(c echo.Context) error {
 	req.Search = strings.TrimSpace(req.Search)
 	req.Query = formatSQLExp(req.Query)
 	if req.All {
-		// If the "all" flag is set, ignore any subquery that may be present.
+		// If the "all" flag is set, ignore any subquery or filter that may be present.
 		req.Search = ""
 		req.Query = ""
-	} else if req.Search == "" && req.Query == "" {
+		req.SubscriberFilter = models.SubscriberFilter{}
+	} else if req.Search == "" && req.Query == "" && req.SubscriberFilter.IsEmpty() {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "query"))
 	}
 	// Does the user have the subscribers:sql_query permission?
@@ -492,8 +757,21 @@ func (a *App) BlocklistSubscribersByQuery(c echo.Context) error {
 		}
 	}
 
+	// Bounce and engagement filters.
+	query, err := a.reqCore(c).MakeSubscriberFilterExp(req.Query, req.SubscriberFilter)
+	if err != nil {
+		return err
+	}
+
+	// Check the estimated cost of the ad-hoc query.
+	if req.Query != "" {
+		if err := a.reqCore(c).CheckSubscriberQuery(req.Search, query, req.ListIDs, req.SubscriptionStatus, req.Confirm, user.UserRoleID == auth.SuperAdminRoleID); err != nil {
+			return err
+		}
+	}
+
 	// Update the subscribers in the DB.
-	if err := a.core.BlocklistSubscribersByQuery(req.Search, req.Query, req.ListIDs, req.SubscriptionStatus); err != nil {
+	if err := a.reqCore(c).BlocklistSubscribersByQuery(req.Search, query, req.ListIDs, req.SubscriptionStatus); err != nil {
 		return err
 	}
 
@@ -526,19 +804,31 @@ func (a *App) ManageSubscriberListsByQuery(c echo.Context) error {
 		}
 	}
 
+	// Bounce and engagement filters.
+	query, err := a.reqCore(c).MakeSubscriberFilterExp(req.Query, req.SubscriberFilter)
+	if err != nil {
+		return err
+	}
+
+	// Check the estimated cost of the ad-hoc query.
+	if req.Query != "" {
+		if err := a.reqCore(c).CheckSubscriberQuery(req.Search, query, req.ListIDs, req.SubscriptionStatus, req.Confirm, user.UserRoleID == auth.SuperAdminRoleID); err != nil {
+			return err
+		}
+	}
+
 	// Filter lists against the current user's permitted lists.
 	sourceListIDs := user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, req.ListIDs)
 	targetListIDs := user.FilterListsByPerm(auth.PermTypeGet|auth.PermTypeManage, req.TargetListIDs)
 
 	// Run the action in the DB.
-	var err error
 	switch req.Action {
 	case "add":
-		err = a.core.AddSubscriptionsByQuery(req.Search, req.Query, sourceListIDs, targetListIDs, req.Status, req.SubscriptionStatus)
+		err = a.reqCore(c).AddSubscriptionsByQuery(req.Search, query, sourceListIDs, targetListIDs, req.Status, req.SubscriptionStatus)
 	case "remove":
-		err = a.core.DeleteSubscriptionsByQuery(req.Search, req.Query, sourceListIDs, targetListIDs, req.SubscriptionStatus)
+		err = a.reqCore(c).DeleteSubscriptionsByQuery(req.Search, query, sourceListIDs, targetListIDs, req.SubscriptionStatus)
 	case "unsubscribe":
-		err = a.core.UnsubscribeListsByQuery(req.Search, req.Query, sourceListIDs, targetListIDs, req.SubscriptionStatus)
+		err = a.reqCore(c).UnsubscribeListsByQuery(req.Search, query, sourceListIDs, targetListIDs, req.SubscriptionStatus)
 	default:
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("subscribers.invalidAction"))
 	}
@@ -554,7 +844,7 @@ func (a *App) ManageSubscriberListsByQuery(c echo.Context) error {
 func (a *App) DeleteSubscriberBounces(c echo.Context) error {
 	// Delete the bounces from the DB.
 	id := getID(c)
-	if err := a.core.DeleteSubscriberBounces(id, ""); err != nil {
+	if err := a.reqCore(c).DeleteSubscriberBounces(id, ""); err != nil {
 		return err
 	}
 
@@ -566,6 +856,12 @@ func (a *App) DeleteSubscriberBounces(c echo.Context) error {
 // a JSON report. This is a privacy feature and depends on the
 // configuration in a.Constants.Privacy.
 func (a *App) ExportSubscriberData(c echo.Context) error {
+	// The export is all PII and can't be anonymized.
+	if isPIIRedacted(c) {
+		return echo.NewHTTPError(http.StatusForbidden,
+			a.i18n.Ts("globals.messages.permissionDenied", "name", auth.PermPIIRedacted))
+	}
+
 	// Get the subscriber's data. A single query that gets the profile,
 	// list subscriptions, campaign views, and link clicks. Names of
 	// private lists are replaced with "Private list".
@@ -672,6 +968,29 @@ func (a *App) filterListQueryByPerm(param string, qp url.Values, user auth.User)
 	return listIDs, nil
 }
 
+// getSubscriberFilter reads the bounce and engagement filters from the query params.
+func (a *App) getSubscriberFilter(c echo.Context) (models.SubscriberFilter, error) {
+	f := models.SubscriberFilter{
+		BounceType:      c.FormValue("bounce_type"),
+		LastOpenBefore:  c.FormValue("last_open_before"),
+		LastClickBefore: c.FormValue("last_click_before"),
+		NeverOpened:     c.FormValue("never_opened") == "true",
+		PendingDeletion: c.FormValue("status") == models.SubscriberStatusPendingDeletion,
+
+		VerificationStatus: c.FormValue("verification_status"),
+	}
+
+	if v := c.FormValue("min_bounces"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return f, echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "min_bounces"))
+		}
+		f.MinBounces = n
+	}
+
+	return f, nil
+}
+
 // formatSQLExp does basic sanitisation on arbitrary
 // SQL query expressions coming from the frontend.
 func formatSQLExp(q string) string {
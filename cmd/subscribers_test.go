@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/core"
+	"github.com/knadh/listmonk/internal/emailverify"
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/models"
+	"github.com/knadh/paginator"
+	"github.com/labstack/echo/v4"
+)
+
+// noMailResolver is a DNS resolver on which no domain exists.
+type noMailResolver struct{}
+
+func (noMailResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (noMailResolver) LookupHost(ctx context.Context, name string) ([]string, error) {
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	a := newTestApp(t)
+
+	// Without a verifier, there are no checks.
+	if err := a.verifyEmail("test@test"); err != nil {
+		t.Fatalf("expected no checks without a verifier, got %v", err)
+	}
+
+	cases := []struct {
+		opt   emailverify.Opt
+		email string
+		msg   string
+	}{
+		{emailverify.Opt{Syntax: true}, "test@test", a.i18n.T("subscribers.invalidEmail")},
+		{emailverify.Opt{Typos: true}, "user@gamil.com", a.i18n.Ts("subscribers.emailTypo", "email", "user@gmail.com")},
+		{emailverify.Opt{MX: true, Resolver: noMailResolver{}}, "user@example.com", a.i18n.T("subscribers.emailNoMX")},
+		{emailverify.Opt{Syntax: true, Typos: true}, "user@gmail.com", ""},
+	}
+	for _, c := range cases {
+		a.verifier = emailverify.New(c.opt)
+
+		err := a.verifyEmail(c.email)
+		if c.msg == "" {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", c.email, err)
+			}
+			continue
+		}
+
+		var he *echo.HTTPError
+		if !errors.As(err, &he) || he.Code != http.StatusBadRequest || he.Message != c.msg {
+			t.Errorf("%s: expected %q, got %v", c.email, c.msg, err)
+		}
+	}
+}
+
+// TestVerifyEmailEntryPoints checks that the public subscription and, when it's
+// turned on, the subscriber API reject e-mails that fail verification.
+func TestVerifyEmailEntryPoints(t *testing.T) {
+	a := newTestApp(t)
+	a.importer = subimporter.New(subimporter.Options{}, nil, a.i18n, a.log)
+	a.verifier = emailverify.New(emailverify.Opt{Typos: true})
+	typoMsg := a.i18n.Ts("subscribers.emailTypo", "email", "user@gmail.com")
+
+	// Public subscriptions suggest the correction.
+	_, err := a.subscribe(subFormReq{Email: "User@Gamil.com", FormListUUIDs: []string{"list"}})
+	var he *echo.HTTPError
+	if !errors.As(err, &he) || he.Code != http.StatusBadRequest {
+		t.Fatalf("expected a bad request, got %v", err)
+	}
+	if pe, ok := he.Message.(publicAPIError); !ok || pe.Code != pubErrInvalidEmail || pe.Message != typoMsg {
+		t.Errorf("unexpected public error %+v", he.Message)
+	}
+
+	// The subscriber API.
+	a.cfg.Privacy.EmailVerifyOnAPI = true
+	req := httptest.NewRequest(http.MethodPost, "/api/subscribers", strings.NewReader(`{"email": "user@gamil.com", "name": "User"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+
+	err = a.CreateSubscriber(c)
+	if !errors.As(err, &he) || he.Code != http.StatusBadRequest || he.Message != typoMsg {
+		t.Errorf("expected the API to reject the e-mail, got %v", err)
+	}
+}
+
+// exportWriter is a response writer that discards the export, counting its
+// rows and sampling the peak heap allocation as it's written.
+type exportWriter struct {
+	hdr    http.Header
+	rows   int
+	writes int
+	peak   uint64
+
+	// If set, it's called once the number of rows reaches abortAt.
+	abort   func()
+	abortAt int
+}
+
+func (w *exportWriter) Header() http.Header { return w.hdr }
+func (w *exportWriter) WriteHeader(int)     {}
+func (w *exportWriter) Flush()              {}
+
+func (w *exportWriter) Write(b []byte) (int, error) {
+	w.rows += bytes.Count(b, []byte("\n"))
+
+	w.writes++
+	if w.writes%100 == 0 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		w.peak = max(w.peak, m.HeapAlloc)
+	}
+
+	if w.abort != nil && w.rows >= w.abortAt {
+		w.abort()
+		w.abort = nil
+	}
+	return len(b), nil
+}
+
+// TestExportSubscribersMemory exports 1M subscribers and checks that the export
+// is streamed in batches. The peak heap allocation during the export stays
+// under 64 MB (typically a few MB) whereas holding all the rows in memory
+// takes several hundred MB.
+func TestExportSubscribersMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping the 1M row export in short mode")
+	}
+
+	const (
+		numSubs = 1000000
+		budget  = 64 << 20
+	)
+
+	a, db := newTestAppDB(t)
+	a.cfg.DBBatchSize = 1000
+
+	if _, err := db.Exec(`INSERT INTO subscribers (uuid, email, name, status, attribs)
+		SELECT gen_random_uuid(), 'user' || i || '@example.com', 'User ' || i, 'enabled', JSONB_BUILD_OBJECT('n', i)
+		FROM generate_series(1, $1) i`, numSubs); err != nil {
+		t.Fatal(err)
+	}
+
+	export := func(ctx context.Context, w *exportWriter) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, "/api/subscribers/export", nil).WithContext(ctx)
+		c := echo.New().NewContext(req, w)
+		c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+		if err := a.ExportSubscribers(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	w := &exportWriter{hdr: http.Header{}}
+	export(context.Background(), w)
+
+	// All the rows and the header row are exported.
+	if w.rows != numSubs+1 {
+		t.Fatalf("expected %d rows, got %d", numSubs+1, w.rows)
+	}
+	if w.peak == 0 {
+		t.Fatal("expected the heap to be sampled")
+	}
+	if used := w.peak - min(w.peak, before.HeapAlloc); used > budget {
+		t.Errorf("expected the export to use under %d MB, used %d MB", budget>>20, used>>20)
+	}
+	t.Logf("peak heap during the export: %d MB", (w.peak-min(w.peak, before.HeapAlloc))>>20)
+
+	// An aborted download stops querying the DB after the current batch.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	w = &exportWriter{hdr: http.Header{}, abort: cancel, abortAt: 5000}
+	export(ctx, w)
+	if w.rows >= 10000 {
+		t.Errorf("expected the aborted export to stop, got %d rows", w.rows)
+	}
+}
+
+func TestGetSubscriberFilter(t *testing.T) {
+	a := newTestApp(t)
+
+	get := func(target string) (models.SubscriberFilter, error) {
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, target, nil), httptest.NewRecorder())
+		return a.getSubscriberFilter(c)
+	}
+
+	f, err := get("/api/subscribers?min_bounces=2&bounce_type=soft&last_open_before=2024-01-02&last_click_before=2024-02-03&never_opened=true&list_id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := (models.SubscriberFilter{MinBounces: 2, BounceType: "soft", LastOpenBefore: "2024-01-02", LastClickBefore: "2024-02-03", NeverOpened: true}); f != exp {
+		t.Errorf("expected %+v, got %+v", exp, f)
+	}
+
+	// No filters.
+	if f, err := get("/api/subscribers?list_id=1&never_opened=false"); err != nil || !f.IsEmpty() {
+		t.Errorf("expected no filters, got %+v: %v", f, err)
+	}
+
+	var he *echo.HTTPError
+	if _, err := get("/api/subscribers?min_bounces=two"); !errors.As(err, &he) || he.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad request, got %v", err)
+	}
+}
+
+// TestSubscriberQueryGuard checks that the ad-hoc query handlers check the
+// estimates of an intentionally awful query against the query guard.
+func TestSubscriberQueryGuard(t *testing.T) {
+	a, db := newTestAppDB(t)
+	a.pg = paginator.New(paginator.Opt{DefaultPerPage: 20, MaxPerPage: 50, NumPageNums: 10, PageParam: "page", PerPageParam: "per_page"})
+	a.core = core.New(&core.Opt{
+		Constants: core.Constants{QueryGuard: models.QueryGuard{Enabled: true, MaxCost: 1000, Action: models.QueryGuardActionRefuse}},
+		I18n:      a.i18n,
+		DB:        db.DB,
+		Queries:   db.Q,
+		Log:       a.log,
+	}, &core.Hooks{})
+
+	if _, err := db.Exec(`INSERT INTO subscribers (uuid, email, name, status, attribs)
+		SELECT gen_random_uuid(), 'user' || i || '@example.com', 'User ' || i, 'enabled', JSONB_BUILD_OBJECT('n', i)
+		FROM generate_series(1, 100000) i`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`ANALYZE subscribers, subscriber_lists`); err != nil {
+		t.Fatal(err)
+	}
+
+	var user auth.User
+	e := newTestEcho()
+	mw := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, user)
+			return next(c)
+		}
+	}
+	e.GET("/api/subscribers", a.QuerySubscribers, mw)
+	e.PUT("/api/subscribers/query/blocklist", a.BlocklistSubscribersByQuery, mw)
+
+	const awful = `subscribers.attribs::TEXT ILIKE '%needle%' AND subscribers.created_at::DATE > '2000-01-01'`
+	query := func(q string, confirm bool) *httptest.ResponseRecorder {
+		return doForm(e, http.MethodGet, "/api/subscribers?"+url.Values{"query": {q}, "confirm": {strconv.FormatBool(confirm)}}.Encode(), nil)
+	}
+
+	// Non-admins are refused and get the estimates.
+	user = auth.User{PermissionsMap: map[string]struct{}{auth.PermSubscribersGetAll: {}, auth.PermSubscribersSqlQuery: {}}}
+	rec := query(awful, true)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), `"estimate"`) {
+		t.Errorf("expected the query to be refused with the estimates, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := query("subscribers.id = 1", false); rec.Code != http.StatusOK {
+		t.Errorf("expected the cheap query to run, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Admins have to confirm.
+	user = auth.User{UserRoleID: auth.SuperAdminRoleID}
+	if rec := query(awful, false); rec.Code != http.StatusPreconditionRequired {
+		t.Errorf("expected a confirmation, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := query(awful, true); rec.Code != http.StatusOK {
+		t.Errorf("expected the confirmed query to run, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// Bulk actions are checked before they run.
+	blocklist := func(confirm bool) int {
+		b, _ := json.Marshal(map[string]any{"query": `subscribers.attribs::TEXT ILIKE '%user1@%' AND subscribers.created_at::DATE > '2000-01-01'`, "confirm": confirm})
+		req := httptest.NewRequest(http.MethodPut, "/api/subscribers/query/blocklist", bytes.NewReader(b))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	blocked := func() int {
+		var n int
+		if err := db.Get(&n, `SELECT COUNT(*) FROM subscribers WHERE status = 'blocklisted'`); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if code := blocklist(false); code != http.StatusPreconditionRequired || blocked() != 0 {
+		t.Errorf("expected the unconfirmed blocklist to not run, got %d", code)
+	}
+	if code := blocklist(true); code != http.StatusOK || blocked() == 0 {
+		t.Errorf("expected the confirmed blocklist to run, got %d", code)
+	}
+}
+
+// putItems makes a subscription items request and returns the response.
+func putItems(e *echo.Echo, items []models.SubscriptionItem) *httptest.ResponseRecorder {
+	b, _ := json.Marshal(map[string]any{"items": items})
+	req := httptest.NewRequest(http.MethodPut, "/api/subscribers/lists/items", bytes.NewReader(b))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestManageSubscriptionItemsValidation(t *testing.T) {
+	a := newTestApp(t)
+	e := newTestEcho()
+	e.PUT("/api/subscribers/lists/items", a.ManageSubscriptionItems, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+			return next(c)
+		}
+	})
+
+	for name, items := range map[string][]models.SubscriptionItem{
+		"no items":        nil,
+		"too many items":  make([]models.SubscriptionItem, maxSubscriptionItems+1),
+		"invalid sub":     {{SubscriberID: 0, ListID: 1, Action: "add"}},
+		"invalid list":    {{SubscriberID: 1, ListID: -1, Action: "add"}},
+		"invalid action":  {{SubscriberID: 1, ListID: 1, Action: "block"}},
+		"invalid status":  {{SubscriberID: 1, ListID: 1, Action: "add", Status: "blocklisted"}},
+		"duplicate items": {{SubscriberID: 1, ListID: 1, Action: "add"}, {SubscriberID: 1, ListID: 1, Action: "remove"}},
+	} {
+		if rec := putItems(e, items); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d: %s", name, rec.Code, rec.Body.String())
+		}
+	}
+}
+
+func TestManageSubscriptionItems(t *testing.T) {
+	a, db := newTestAppDB(t)
+
+	var subID, blockedID, list1, list2, list3 int
+	for _, q := range []struct {
+		id *int
+		q  string
+	}{
+		{&subID, `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), 'sub@example.com', 'sub') RETURNING id`},
+		{&blockedID, `INSERT INTO subscribers (uuid, email, name, status) VALUES (gen_random_uuid(), 'blocked@example.com', 'blocked', 'blocklisted') RETURNING id`},
+		{&list1, `INSERT INTO lists (uuid, name, type, optin) VALUES (gen_random_uuid(), 'list1', 'private', 'single') RETURNING id`},
+		{&list2, `INSERT INTO lists (uuid, name, type, optin) VALUES (gen_random_uuid(), 'list2', 'private', 'single') RETURNING id`},
+		{&list3, `INSERT INTO lists (uuid, name, type, optin) VALUES (gen_random_uuid(), 'list3', 'private', 'single') RETURNING id`},
+	} {
+		if err := db.Get(q.id, q.q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO subscriber_lists (subscriber_id, list_id, status) VALUES ($1, $2, 'confirmed')`, subID, list2); err != nil {
+		t.Fatal(err)
+	}
+
+	// The user can manage list1, list2, and a list that doesn't exist, but not list3.
+	const noList = 999999
+	perms := map[string]struct{}{auth.PermListGet: {}, auth.PermListManage: {}}
+	user := auth.User{ListPermissionsMap: map[int]map[string]struct{}{list1: perms, list2: perms, noList: perms}}
+
+	e := newTestEcho()
+	e.PUT("/api/subscribers/lists/items", a.ManageSubscriptionItems, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, user)
+			return next(c)
+		}
+	})
+
+	put := func(items []models.SubscriptionItem) []string {
+		t.Helper()
+
+		rec := putItems(e, items)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var out struct {
+			Data []models.SubscriptionItemResult `json:"data"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+
+		// The results are in the order of the items.
+		res := make([]string, 0, len(out.Data))
+		for n, r := range out.Data {
+			if r.SubscriberID != items[n].SubscriberID || r.ListID != items[n].ListID || r.Action != items[n].Action {
+				t.Errorf("%d: result %+v doesn't match the item %+v", n, r, items[n])
+			}
+			res = append(res, r.Result)
+		}
+		return res
+	}
+	status := func(subID, listID int) string {
+		t.Helper()
+
+		var s string
+		if err := db.Get(&s, `SELECT COALESCE((SELECT status::TEXT FROM subscriber_lists WHERE subscriber_id = $1 AND list_id = $2), '')`, subID, listID); err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	check := func(name string, got, exp []string) {
+		t.Helper()
+		if !slices.Equal(got, exp) {
+			t.Errorf("%s: expected %v, got %v", name, exp, got)
+		}
+	}
+
+	add := []models.SubscriptionItem{
+		{SubscriberID: subID, ListID: list1, Action: "add"},
+		{SubscriberID: subID, ListID: list2, Action: "add"},
+		{SubscriberID: blockedID, ListID: list1, Action: "add"},
+		{SubscriberID: noList, ListID: list1, Action: "add"},
+		{SubscriberID: subID, ListID: noList, Action: "add"},
+		{SubscriberID: subID, ListID: list3, Action: "add"},
+		{SubscriberID: blockedID, ListID: list2, Action: "remove"},
+	}
+	check("add", put(add), []string{
+		models.SubscriptionResultOK,
+		models.SubscriptionResultAlreadyMember,
+		models.SubscriptionResultBlocked,
+		models.SubscriptionResultNotFound,
+		models.SubscriptionResultNotFound,
+		models.SubscriptionResultForbidden,
+		models.SubscriptionResultNotMember,
+	})
+	if status(subID, list1) != models.SubscriptionStatusUnconfirmed || status(subID, list2) != models.SubscriptionStatusConfirmed ||
+		status(blockedID, list1) != "" || status(subID, list3) != "" {
+		t.Fatal("unexpected subscriptions after adding")
+	}
+
+	// A retry converges without changing anything.
+	check("add retry", put(add)[:2], []string{models.SubscriptionResultAlreadyMember, models.SubscriptionResultAlreadyMember})
+	if status(subID, list1) != models.SubscriptionStatusUnconfirmed {
+		t.Fatal("expected the retry not to change the subscription")
+	}
+
+	// Mixed actions.
+	mixed := []models.SubscriptionItem{
+		{SubscriberID: subID, ListID: list1, Action: "unsubscribe"},
+		{SubscriberID: subID, ListID: list2, Action: "remove"},
+	}
+	check("mixed", put(mixed), []string{models.SubscriptionResultOK, models.SubscriptionResultOK})
+	if status(subID, list1) != models.SubscriptionStatusUnsubscribed || status(subID, list2) != "" {
+		t.Fatal("unexpected subscriptions after the mixed actions")
+	}
+	check("mixed retry", put(mixed), []string{models.SubscriptionResultAlreadyUnsubscribed, models.SubscriptionResultNotMember})
+
+	// Adding with a status changes an existing subscription's status.
+	resub := []models.SubscriptionItem{{SubscriberID: subID, ListID: list1, Action: "add", Status: models.SubscriptionStatusConfirmed}}
+	check("resubscribe", put(resub), []string{models.SubscriptionResultOK})
+	if status(subID, list1) != models.SubscriptionStatusConfirmed {
+		t.Fatal("expected the subscription to be confirmed")
+	}
+	check("resubscribe retry", put(resub), []string{models.SubscriptionResultAlreadyMember})
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/internal/emailverify"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	subVerifyStatusNone      = "none"
+	subVerifyStatusRunning   = "running"
+	subVerifyStatusFinished  = "finished"
+	subVerifyStatusCancelled = "cancelled"
+	subVerifyStatusFailed    = "failed"
+
+	// Number of subscribers fetched and verified in a single batch.
+	subVerifyBatchSize = 500
+
+	// Number of e-mails verified concurrently within a batch.
+	subVerifyConcurrency = 10
+
+	// Maximum number of domains looked up per second so as to not flood
+	// the DNS resolver. Lookups are cached per domain.
+	subVerifyLookupRate = 20
+)
+
+// subVerifyJob is the background job that verifies the e-mails of existing
+// subscribers in bulk (list cleaning). Only one job runs at a time.
+type subVerifyJob struct {
+	mut    sync.Mutex
+	status subVerifyStatus
+	cancel context.CancelFunc
+}
+
+// subVerifyStatus represents the progress of a bulk verification job.
+type subVerifyStatus struct {
+	Status     string         `json:"status"`
+	Total      int            `json:"total"`
+	Processed  int            `json:"processed"`
+	Counts     map[string]int `json:"counts"`
+	Error      string         `json:"error,omitempty"`
+	StartedAt  null.Time      `json:"started_at"`
+	FinishedAt null.Time      `json:"finished_at"`
+}
+
+// subVerifyStore is the source of the subscribers to verify and the sink of
+// their results.
+type subVerifyStore interface {
+	GetSubscribersToVerify(afterID, limit int, listIDs []int, before null.Time) ([]models.Subscriber, error)
+	UpdateSubscriberVerifications(ids []int, statuses []string) error
+}
+
+// subVerifier classifies an e-mail as one of the emailverify.Status* values.
+type subVerifier interface {
+	Classify(email string) string
+}
+
+type subVerifyReq struct {
+	// Only verify the subscribers of these lists.
+	ListIDs []int `json:"list_ids"`
+
+	// Skip the subscribers verified in the last N days.
+	SkipVerifiedDays int `json:"skip_verified_days"`
+}
+
+// get returns a copy of the job's status.
+func (j *subVerifyJob) get() subVerifyStatus {
+	j.mut.Lock()
+	defer j.mut.Unlock()
+
+	out := j.status
+	if out.Status == "" {
+		out.Status = subVerifyStatusNone
+	}
+	out.Counts = make(map[string]int, len(j.status.Counts))
+	for k, v := range j.status.Counts {
+		out.Counts[k] = v
+	}
+
+	return out
+}
+
+// StartSubscriberVerification starts a background job that verifies the e-mails
+// of enabled subscribers and records the results on them. Nothing is blocklisted
+// automatically.
+func (a *App) StartSubscriberVerification(c echo.Context) error {
+	var req subVerifyReq
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if req.SkipVerifiedDays < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "skip_verified_days"))
+	}
+	if req.ListIDs == nil {
+		req.ListIDs = []int{}
+	}
+
+	var before null.Time
+	if req.SkipVerifiedDays > 0 {
+		before = null.TimeFrom(time.Now().AddDate(0, 0, -req.SkipVerifiedDays))
+	}
+
+	j := a.subVerify
+	j.mut.Lock()
+	if j.status.Status == subVerifyStatusRunning {
+		j.mut.Unlock()
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.T("maintenance.verifyRunning"))
+	}
+
+	total, err := a.core.CountSubscribersToVerify(req.ListIDs, before)
+	if err != nil {
+		j.mut.Unlock()
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j.cancel = cancel
+	j.status = subVerifyStatus{
+		Status:    subVerifyStatusRunning,
+		Total:     total,
+		Counts:    make(map[string]int),
+		StartedAt: null.TimeFrom(time.Now()),
+	}
+	j.mut.Unlock()
+
+	// A fresh verifier so that the MX lookups are cached for the duration of the job.
+	v := emailverify.New(emailverify.Opt{LookupRate: subVerifyLookupRate})
+	go a.runSubscriberVerification(ctx, a.core, v, req.ListIDs, before)
+
+	return c.JSON(http.StatusOK, okResp{j.get()})
+}
+
+// GetSubscriberVerification returns the progress of the bulk verification job.
+func (a *App) GetSubscriberVerification(c echo.Context) error {
+	return c.JSON(http.StatusOK, okResp{a.subVerify.get()})
+}
+
+// CancelSubscriberVerification cancels the running bulk verification job. The
+// results of the batches verified so far are retained.
+func (a *App) CancelSubscriberVerification(c echo.Context) error {
+	j := a.subVerify
+	j.mut.Lock()
+	if j.status.Status == subVerifyStatusRunning && j.cancel != nil {
+		j.cancel()
+	}
+	j.mut.Unlock()
+
+	return c.JSON(http.StatusOK, okResp{j.get()})
+}
+
+// runSubscriberVerification verifies the subscribers from st with v batch by
+// batch until they're exhausted or the job is cancelled.
+func (a *App) runSubscriberVerification(ctx context.Context, st subVerifyStore, v subVerifier, listIDs []int, before null.Time) {
+	var (
+		j = a.subVerify
+
+		status = subVerifyStatusFinished
+		errMsg string
+		lastID int
+	)
+
+	a.log.Printf("started bulk e-mail verification of %d subscribers", j.get().Total)
+
+	for {
+		if ctx.Err() != nil {
+			status = subVerifyStatusCancelled
+			break
+		}
+
+		subs, err := st.GetSubscribersToVerify(lastID, subVerifyBatchSize, listIDs, before)
+		if err != nil {
+			status, errMsg = subVerifyStatusFailed, err.Error()
+			break
+		}
+		if len(subs) == 0 {
+			break
+		}
+
+		var (
+			ids      = make([]int, len(subs))
+			statuses = make([]string, len(subs))
+			sem      = make(chan struct{}, subVerifyConcurrency)
+			wg       sync.WaitGroup
+		)
+		for n, s := range subs {
+			ids[n] = s.ID
+
+			sem <- struct{}{}
+			wg.Add(1)
+			go func(n int, email string) {
+				defer func() { <-sem; wg.Done() }()
+				statuses[n] = v.Classify(email)
+			}(n, s.Email)
+		}
+		wg.Wait()
+
+		if err := st.UpdateSubscriberVerifications(ids, statuses); err != nil {
+			status, errMsg = subVerifyStatusFailed, err.Error()
+			break
+		}
+
+		j.mut.Lock()
+		j.status.Processed += len(subs)
+		for _, s := range statuses {
+			j.status.Counts[s]++
+		}
+		j.mut.Unlock()
+
+		lastID = subs[len(subs)-1].ID
+	}
+
+	j.mut.Lock()
+	j.status.Status = status
+	j.status.Error = errMsg
+	j.status.FinishedAt = null.TimeFrom(time.Now())
+	j.cancel = nil
+	j.mut.Unlock()
+
+	a.log.Printf("bulk e-mail verification %s: %d subscribers verified", status, j.get().Processed)
+}
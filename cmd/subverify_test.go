@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/emailverify"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// verifyStore is an in-memory subVerifyStore.
+type verifyStore struct {
+	mut      sync.Mutex
+	subs     []models.Subscriber
+	results  map[int]string
+	batches  int
+	err      error
+	onUpdate func(batch int)
+}
+
+func newVerifyStore(n int) *verifyStore {
+	s := &verifyStore{results: map[int]string{}}
+	for i := range n {
+		var sub models.Subscriber
+		sub.ID = i + 1
+
+		// Every 5th subscriber is invalid and every 7th is risky.
+		switch {
+		case sub.ID%5 == 0:
+			sub.Email = "invalid@example.com"
+		case sub.ID%7 == 0:
+			sub.Email = "risky@example.com"
+		default:
+			sub.Email = "valid@example.com"
+		}
+		s.subs = append(s.subs, sub)
+	}
+	return s
+}
+
+func (s *verifyStore) GetSubscribersToVerify(afterID, limit int, listIDs []int, before null.Time) ([]models.Subscriber, error) {
+	var out []models.Subscriber
+	for _, sub := range s.subs {
+		if sub.ID > afterID && len(out) < limit {
+			out = append(out, sub)
+		}
+	}
+	return out, nil
+}
+
+func (s *verifyStore) UpdateSubscriberVerifications(ids []int, statuses []string) error {
+	s.mut.Lock()
+	if s.err != nil {
+		s.mut.Unlock()
+		return s.err
+	}
+	for n, id := range ids {
+		s.results[id] = statuses[n]
+	}
+	s.batches++
+	batch := s.batches
+	s.mut.Unlock()
+
+	if s.onUpdate != nil {
+		s.onUpdate(batch)
+	}
+	return nil
+}
+
+// fakeVerifier classifies e-mails by their local part. If block is set,
+// it signals entered and blocks until block is closed.
+type fakeVerifier struct {
+	entered chan struct{}
+	block   chan struct{}
+}
+
+func (f fakeVerifier) Classify(email string) string {
+	if f.block != nil {
+		f.entered <- struct{}{}
+		<-f.block
+	}
+	local, _, _ := strings.Cut(email, "@")
+	return local
+}
+
+// startVerifyJob sets the job running as StartSubscriberVerification does.
+func startVerifyJob(a *App, total int) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	a.subVerify = &subVerifyJob{
+		cancel: cancel,
+		status: subVerifyStatus{Status: subVerifyStatusRunning, Total: total, Counts: map[string]int{}},
+	}
+	return ctx
+}
+
+func TestRunSubscriberVerification(t *testing.T) {
+	const total = subVerifyBatchSize*2 + 100
+
+	a := newTestApp(t)
+	st := newVerifyStore(total)
+
+	// Progress is updated after every batch.
+	var progress []int
+	st.onUpdate = func(int) { progress = append(progress, a.subVerify.get().Processed) }
+
+	ctx := startVerifyJob(a, total)
+	a.runSubscriberVerification(ctx, st, fakeVerifier{}, nil, null.Time{})
+
+	if exp := []int{0, subVerifyBatchSize, subVerifyBatchSize * 2}; !slices.Equal(progress, exp) {
+		t.Errorf("expected progress %v, got %v", exp, progress)
+	}
+
+	s := a.subVerify.get()
+	if s.Status != subVerifyStatusFinished || s.Processed != total || s.Error != "" || !s.FinishedAt.Valid {
+		t.Fatalf("unexpected status %+v", s)
+	}
+	if a.subVerify.cancel != nil {
+		t.Error("expected the job's cancel func to be cleared")
+	}
+
+	// Every subscriber's result is recorded and counted.
+	exp := map[string]int{}
+	for _, sub := range st.subs {
+		local, _, _ := strings.Cut(sub.Email, "@")
+		exp[local]++
+		if st.results[sub.ID] != local {
+			t.Fatalf("expected %d to be %s, got %s", sub.ID, local, st.results[sub.ID])
+		}
+	}
+	for _, k := range []string{emailverify.StatusValid, emailverify.StatusRisky, emailverify.StatusInvalid} {
+		if s.Counts[k] != exp[k] || exp[k] == 0 {
+			t.Errorf("expected %d %s, got %d", exp[k], k, s.Counts[k])
+		}
+	}
+}
+
+func TestRunSubscriberVerificationCancel(t *testing.T) {
+	const total = subVerifyBatchSize * 3
+
+	a := newTestApp(t)
+	st := newVerifyStore(total)
+
+	// The job is cancelled after the first batch. Its results are retained.
+	ctx := startVerifyJob(a, total)
+	st.onUpdate = func(batch int) {
+		if batch == 1 {
+			a.subVerify.cancel()
+		}
+	}
+	a.runSubscriberVerification(ctx, st, fakeVerifier{}, nil, null.Time{})
+
+	s := a.subVerify.get()
+	if s.Status != subVerifyStatusCancelled || s.Processed != subVerifyBatchSize || len(st.results) != subVerifyBatchSize {
+		t.Fatalf("expected the job to be cancelled after a batch, got %+v", s)
+	}
+
+	// A failing store fails the job.
+	st = newVerifyStore(total)
+	st.err = errors.New("db error")
+	ctx = startVerifyJob(a, total)
+	a.runSubscriberVerification(ctx, st, fakeVerifier{}, nil, null.Time{})
+	if s := a.subVerify.get(); s.Status != subVerifyStatusFailed || s.Error != "db error" || s.Processed != 0 {
+		t.Fatalf("expected the job to fail, got %+v", s)
+	}
+}
+
+func TestCancelSubscriberVerification(t *testing.T) {
+	a := newTestApp(t)
+	st := newVerifyStore(10)
+
+	// A verifier that's stuck until the test unblocks it.
+	v := fakeVerifier{entered: make(chan struct{}, 10), block: make(chan struct{})}
+	ctx := startVerifyJob(a, 10)
+	done := make(chan struct{})
+	go func() {
+		a.runSubscriberVerification(ctx, st, v, nil, null.Time{})
+		close(done)
+	}()
+
+	call := func(h echo.HandlerFunc, method, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/api/maintenance/verify-subscribers", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := h(echo.New().NewContext(req, rec)); err != nil {
+			var hErr *echo.HTTPError
+			if !errors.As(err, &hErr) {
+				t.Fatal(err)
+			}
+			rec.Code = hErr.Code
+		}
+		return rec
+	}
+
+	select {
+	case <-v.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the job to start")
+	}
+
+	// Another job can't be started while one is running.
+	if rec := call(a.StartSubscriberVerification, http.MethodPost, `{}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected a running job to be rejected, got %d", rec.Code)
+	}
+	if rec := call(a.StartSubscriberVerification, http.MethodPost, `{"skip_verified_days": -1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected invalid days to be rejected, got %d", rec.Code)
+	}
+
+	if rec := call(a.CancelSubscriberVerification, http.MethodDelete, ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected the job to be cancelled, got %d", rec.Code)
+	}
+	close(v.block)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the job to stop")
+	}
+
+	// The batch in progress is saved and the job stops.
+	if s := a.subVerify.get(); s.Status != subVerifyStatusCancelled || s.Processed != 10 {
+		t.Fatalf("expected the job to be cancelled, got %+v", s)
+	}
+
+	// Cancelling a stopped job is a no-op.
+	if rec := call(a.CancelSubscriberVerification, http.MethodDelete, ""); rec.Code != http.StatusOK || a.subVerify.get().Status != subVerifyStatusCancelled {
+		t.Errorf("unexpected cancellation of a stopped job: %d", rec.Code)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Number of the latest failed system e-mails returned by the dead-letter endpoint.
+const failedSystemMessagesLimit = 100
+
+// GetFailedSystemMessages returns the latest system e-mails, eg: opt-in
+// confirmations, that failed to send on all their attempts.
+func (a *App) GetFailedSystemMessages(c echo.Context) error {
+	out, err := a.reqCore(c).GetFailedSystemMessages(failedSystemMessagesLimit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
+
+// RetrySystemMessage queues a failed system e-mail for retries again.
+func (a *App) RetrySystemMessage(c echo.Context) error {
+	if err := a.reqCore(c).RetrySystemMessage(getID(c)); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{true})
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetTags handles retrieval of all distinct campaign and template tags with their usage counts.
+func (a *App) GetTags(c echo.Context) error {
+	// Optionally filter by the tag source, campaign or template.
+	typ := c.QueryParam("type")
+	if typ != "" && typ != "campaign" && typ != "template" {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "type"))
+	}
+
+	out, err := a.reqCore(c).GetTags(typ)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
+}
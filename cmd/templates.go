@@ -8,8 +8,10 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/knadh/listmonk/internal/blocks"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
 const (
@@ -38,7 +40,7 @@ func (a *App) GetTemplate(c echo.Context) error {
 
 	// Get the template from the DB.
 	id := getID(c)
-	out, err := a.core.GetTemplate(id, noBody)
+	out, err := a.reqCore(c).GetTemplate(id, noBody)
 	if err != nil {
 		return err
 	}
@@ -48,11 +50,15 @@ func (a *App) GetTemplate(c echo.Context) error {
 
 // GetTemplates handles retrieval of templates.
 func (a *App) GetTemplates(c echo.Context) error {
-	// If no_body is true, blank out the body of the template from the response.
-	noBody, _ := strconv.ParseBool(c.QueryParam("no_body"))
+	var (
+		// If no_body is true, blank out the body of the template from the response.
+		noBody, _   = strconv.ParseBool(c.QueryParam("no_body"))
+		tags        = getQueryTags(c.QueryParams())
+		tagMatchAny = c.QueryParam("tag_match") == "any"
+	)
 
 	// Fetch templates from the DB.
-	out, err := a.core.GetTemplates("", noBody)
+	out, err := a.reqCore(c).GetTemplates("", tags, tagMatchAny, noBody)
 	if err != nil {
 		return err
 	}
@@ -64,13 +70,19 @@ func (a *App) GetTemplates(c echo.Context) error {
 func (a *App) PreviewTemplate(c echo.Context) error {
 	// Fetch one template from the DB.
 	id := getID(c)
-	tpl, err := a.core.GetTemplate(id, false)
+	tpl, err := a.reqCore(c).GetTemplate(id, false)
+	if err != nil {
+		return err
+	}
+
+	// Preview for a real subscriber?
+	sub, isReal, err := a.getPreviewSubscriber(c)
 	if err != nil {
 		return err
 	}
 
 	// Render the template.
-	out, err := a.previewTemplate(tpl)
+	out, err := a.previewTemplateFor(tpl, sub, isReal)
 	if err != nil {
 		return err
 	}
@@ -130,7 +142,7 @@ func (a *App) CreateTemplate(c echo.Context) error {
 	}
 
 	// Create the template the in the DB.
-	out, err := a.core.CreateTemplate(o.Name, o.Type, o.Subject, []byte(o.Body), o.BodySource)
+	out, err := a.reqCore(c).CreateTemplate(o.Name, o.Type, o.Subject, []byte(o.Body), o.BodySource, o.Tags, o.Assets, o.BlockStyles)
 	if err != nil {
 		return err
 	}
@@ -171,7 +183,7 @@ func (a *App) UpdateTemplate(c echo.Context) error {
 
 	// Update the template in the DB.
 	id := getID(c)
-	out, err := a.core.UpdateTemplate(id, o.Name, o.Subject, []byte(o.Body), o.BodySource)
+	out, err := a.reqCore(c).UpdateTemplate(id, o.Name, o.Subject, []byte(o.Body), o.BodySource, o.Tags, o.Assets, o.BlockStyles)
 	if err != nil {
 		return err
 	}
@@ -189,7 +201,7 @@ func (a *App) UpdateTemplate(c echo.Context) error {
 func (a *App) TemplateSetDefault(c echo.Context) error {
 	// Update the template in the DB.
 	id := getID(c)
-	if err := a.core.SetDefaultTemplate(id); err != nil {
+	if err := a.reqCore(c).SetDefaultTemplate(id); err != nil {
 		return err
 	}
 
@@ -200,7 +212,7 @@ func (a *App) TemplateSetDefault(c echo.Context) error {
 func (a *App) DeleteTemplate(c echo.Context) error {
 	// Delete the template from the DB.
 	id := getID(c)
-	if err := a.core.DeleteTemplate(id); err != nil {
+	if err := a.reqCore(c).DeleteTemplate(id); err != nil {
 		return err
 	}
 
@@ -226,15 +238,44 @@ func (a *App) validateTemplate(o models.Template) error {
 			a.i18n.Ts("globals.messages.missingFields", "name", "subject"))
 	}
 
+	if !validateTags(o.Tags) {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidTags", "max", strconv.Itoa(tagsMaxNum), "len", strconv.Itoa(tagMaxLen)))
+	}
+
+	// Asset names should be unique as they're referenced by name in the template.
+	names := make(map[string]struct{}, len(o.Assets))
+	for _, as := range o.Assets {
+		if _, ok := names[as.Name]; ok || !strHasLen(as.Name, 1, stdInputMaxLen) || as.MediaID < 1 {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				a.i18n.Ts("globals.messages.invalidFields", "name", "assets"))
+		}
+		names[as.Name] = struct{}{}
+	}
+
+	// Block styles should be of known block elements.
+	if err := blocks.ValidateStyles(blocks.Styles(o.BlockStyles)); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			a.i18n.Ts("globals.messages.invalidFields", "name", "block_styles: "+err.Error()))
+	}
+
 	return nil
 }
 
 // previewTemplate renders the HTML preview of a template.
 func (a *App) previewTemplate(tpl models.Template) ([]byte, error) {
+	return a.previewTemplateFor(tpl, dummySubscriber, false)
+}
+
+// previewTemplateFor renders the HTML preview of a template for a subscriber.
+// If disarm is true, tracking and subscription links in campaign templates are
+// disarmed so that a real subscriber's data can be used.
+func (a *App) previewTemplateFor(tpl models.Template, sub models.Subscriber, disarm bool) ([]byte, error) {
 	var out []byte
 	if tpl.Type == models.TemplateTypeCampaign || tpl.Type == models.TemplateTypeCampaignVisual {
 		camp := models.Campaign{
 			UUID:         dummyUUID,
+			TemplateID:   null.NewInt(tpl.ID, tpl.ID > 0),
 			Name:         a.i18n.T("templates.dummyName"),
 			Subject:      a.i18n.T("templates.dummySubject"),
 			FromEmail:    "dummy-campaign@listmonk.app",
@@ -242,13 +283,17 @@ func (a *App) previewTemplate(tpl models.Template) ([]byte, error) {
 			Body:         dummyTpl,
 		}
 
-		if err := camp.CompileTemplate(a.manager.TemplateFuncs(&camp)); err != nil {
+		funcs := a.manager.TemplateFuncs(&camp)
+		if disarm {
+			funcs = a.manager.PreviewTemplateFuncs(&camp)
+		}
+		if err := camp.CompileTemplate(funcs); err != nil {
 			return nil, echo.NewHTTPError(http.StatusBadRequest,
 				a.i18n.Ts("templates.errorCompiling", "error", err.Error()))
 		}
 
 		// Render the message body.
-		msg, err := a.manager.NewCampaignMessage(&camp, dummySubscriber)
+		msg, err := a.manager.NewCampaignMessage(&camp, sub)
 		if err != nil {
 			return nil, echo.NewHTTPError(http.StatusBadRequest,
 				a.i18n.Ts("templates.errorRendering", "error", err.Error()))
@@ -265,7 +310,7 @@ func (a *App) previewTemplate(tpl models.Template) ([]byte, error) {
 		}
 
 		// Render the message.
-		if err := m.Render(dummySubscriber, &tpl); err != nil {
+		if err := m.Render(sub, &tpl); err != nil {
 			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
 		out = m.Body
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// testMediaStore is a media store that records deleted files.
+type testMediaStore struct {
+	deleted []string
+}
+
+func (s *testMediaStore) Put(string, string, io.ReadSeeker) (string, error) { return "", nil }
+func (s *testMediaStore) GetURL(name string) string                         { return "https://example.com/uploads/" + name }
+func (s *testMediaStore) GetBlob(string) ([]byte, error)                    { return nil, nil }
+
+func (s *testMediaStore) Delete(name string) error {
+	s.deleted = append(s.deleted, name)
+	return nil
+}
+
+func TestValidateTemplateAssets(t *testing.T) {
+	a := newTestApp(t)
+
+	cases := []struct {
+		name   string
+		assets models.TemplateAssets
+		ok     bool
+	}{
+		{"no assets", nil, true},
+		{"assets", models.TemplateAssets{{Name: "logo", MediaID: 1}, {Name: "banner", MediaID: 1}}, true},
+		{"duplicate names", models.TemplateAssets{{Name: "logo", MediaID: 1}, {Name: "logo", MediaID: 2}}, false},
+		{"empty name", models.TemplateAssets{{Name: "", MediaID: 1}}, false},
+		{"long name", models.TemplateAssets{{Name: strings.Repeat("a", stdInputMaxLen+1), MediaID: 1}}, false},
+		{"no media", models.TemplateAssets{{Name: "logo"}}, false},
+	}
+	for _, c := range cases {
+		err := a.validateTemplate(models.Template{
+			Name:   "tpl",
+			Type:   models.TemplateTypeCampaign,
+			Body:   `{{ template "content" . }}`,
+			Assets: c.assets,
+		})
+		if c.ok {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", c.name, err)
+			}
+			continue
+		}
+
+		var he *echo.HTTPError
+		if !errors.As(err, &he) || he.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected a bad request, got %v", c.name, err)
+		}
+	}
+}
+
+// TestDeleteMediaInUse checks that media used as a template asset can't be
+// deleted until it's detached from the templates.
+func TestDeleteMediaInUse(t *testing.T) {
+	a, db := newTestAppDB(t)
+	ms := &testMediaStore{}
+	a.media = ms
+
+	e := newTestEcho()
+	e.DELETE("/api/media/:id", hasID(a.DeleteMedia), func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(auth.UserHTTPCtxKey, auth.User{UserRoleID: auth.SuperAdminRoleID})
+			return next(c)
+		}
+	})
+
+	var mediaID int
+	if err := db.Get(&mediaID, `INSERT INTO media (uuid, provider, filename, thumb) VALUES (gen_random_uuid(), 'filesystem', 'logo.png', 'thumb_logo.png') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := a.core.CreateTemplate("branded", models.TemplateTypeCampaign, "", []byte(`{{ Asset "logo" }} {{ template "content" . }}`),
+		null.String{}, nil, models.TemplateAssets{{Name: "logo", MediaID: mediaID}}, models.BlockStyles{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The assets resolve to the media's URLs.
+	if assets, err := (&store{core: a.core, media: ms}).GetTemplateAssets(tpl.ID); err != nil || assets["logo"] != "https://example.com/uploads/logo.png" {
+		t.Errorf("unexpected assets %v: %v", assets, err)
+	}
+
+	target := "/api/media/" + strconv.Itoa(mediaID)
+	rec := doForm(e, http.MethodDelete, target, nil)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "branded") {
+		t.Errorf("expected media in use to not be deleted, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(ms.deleted) != 0 {
+		t.Errorf("expected no files to be deleted, got %v", ms.deleted)
+	}
+
+	// Once detached, the media and its files are deleted.
+	if _, err := a.core.UpdateTemplate(tpl.ID, "branded", "", []byte(`{{ template "content" . }}`), null.String{}, nil, models.TemplateAssets{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if rec := doForm(e, http.MethodDelete, target, nil); rec.Code != http.StatusOK {
+		t.Fatalf("expected the media to be deleted, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if !slices.Equal(ms.deleted, []string{"logo.png", thumbPrefix + "logo.png"}) {
+		t.Errorf("expected the files to be deleted, got %v", ms.deleted)
+	}
+}
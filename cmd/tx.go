@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -85,7 +86,10 @@ func (a *App) SendTxMessage(c echo.Context) error {
 		isEmails = false
 	}
 
-	notFound := []string{}
+	var (
+		notFound = []string{}
+		msgs     = make([]models.Message, 0, num)
+	)
 	for n := range num {
 		var sub models.Subscriber
 
@@ -109,7 +113,7 @@ func (a *App) SendTxMessage(c echo.Context) error {
 			}
 
 			var err error
-			sub, err = a.core.GetSubscriber(subID, "", subEmail)
+			sub, err = a.reqCore(c).GetSubscriber(subID, "", subEmail)
 			if err != nil {
 				if er, ok := err.(*echo.HTTPError); ok && er.Code == http.StatusBadRequest {
 					// `fallback`: Create an ephemeral "subscriber" if the subscriber wasn't found.
@@ -126,6 +130,12 @@ func (a *App) SendTxMessage(c echo.Context) error {
 					return err
 				}
 			}
+
+			// Subscribers pending data deletion don't receive messages.
+			if sub.DeletionRequestedAt.Valid {
+				notFound = append(notFound, a.i18n.Ts("globals.messages.notFound", "name", sub.Email))
+				continue
+			}
 		}
 
 		// Render the message.
@@ -161,17 +171,46 @@ func (a *App) SendTxMessage(c echo.Context) error {
 			}
 		}
 
-		if err := a.manager.PushMessage(msg); err != nil {
-			a.log.Printf("error sending message (%s): %v", msg.Subject, err)
-			return err
+		msgs = append(msgs, msg)
+	}
+
+	// Queue the messages to all the recipients at once so that a full queue
+	// doesn't leave the request partially sent.
+	ids, err := a.manager.PushTxMessages(msgs)
+	if err != nil {
+		if errors.Is(err, manager.ErrTxQueueFull) {
+			return echo.NewHTTPError(http.StatusTooManyRequests, a.i18n.T("tx.queueFull"))
 		}
+
+		a.log.Printf("error sending message (%s): %v", m.Subject, err)
+		return err
 	}
 
 	if len(notFound) > 0 {
 		return echo.NewHTTPError(http.StatusBadRequest, strings.Join(notFound, "; "))
 	}
 
-	return c.JSON(http.StatusOK, okResp{true})
+	// The IDs of the messages for querying their status are only returned
+	// if they're asked for so that the response doesn't change for existing clients.
+	if !m.ReturnIDs {
+		return c.JSON(http.StatusOK, okResp{true})
+	}
+
+	return c.JSON(http.StatusOK, okResp{struct {
+		MessageIDs []string `json:"message_ids"`
+	}{ids}})
+}
+
+// GetTxMessageStatus returns the delivery status of a tx message
+// by the ID returned when it was sent.
+func (a *App) GetTxMessageStatus(c echo.Context) error {
+	out, ok := a.manager.GetTxStatus(c.Param("id"))
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound,
+			a.i18n.Ts("globals.messages.notFound", "name", "message"))
+	}
+
+	return c.JSON(http.StatusOK, okResp{out})
 }
 
 // validateTxMessage validates the tx message fields.
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/manager"
+	"github.com/knadh/listmonk/internal/subimporter"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// newTestTxApp returns an App that queues tx messages to a manager that isn't
+// run, so that the queue (of the given size) isn't drained.
+func newTestTxApp(t *testing.T, queueSize int) *App {
+	t.Helper()
+
+	a := newTestApp(t)
+	a.importer = subimporter.New(subimporter.Options{}, nil, a.i18n, a.log)
+	a.manager = manager.New(manager.Config{TxQueueSize: queueSize}, nil, a.i18n, log.New(io.Discard, "", 0))
+
+	tpl := &models.Template{Type: models.TemplateTypeTx, Subject: "Hello", Body: "Hello {{ .Subscriber.Email }}"}
+	if err := tpl.Compile(nil); err != nil {
+		t.Fatal(err)
+	}
+	a.manager.CacheTpl(1, tpl)
+
+	return a
+}
+
+// sendTx makes a tx request with a JSON body.
+func sendTx(t *testing.T, a *App, body string) (*httptest.ResponseRecorder, error) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodPost, "/api/tx", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+
+	return rec, a.SendTxMessage(echo.New().NewContext(req, rec))
+}
+
+func TestSendTxResponse(t *testing.T) {
+	a := newTestTxApp(t, 10)
+
+	// The response is unchanged by default.
+	rec, err := sendTx(t, a, `{"subscriber_mode": "external", "subscriber_emails": ["a@example.com"], "template_id": 1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := strings.TrimSpace(rec.Body.String()); b != `{"data":true}` {
+		t.Fatalf("expected the legacy response, got %s", b)
+	}
+
+	// The message IDs are returned if they're asked for.
+	rec, err = sendTx(t, a, `{"subscriber_mode": "external", "subscriber_emails": ["a@example.com", "b@example.com"],
+		"template_id": 1, "return_ids": true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out struct {
+		Data struct {
+			MessageIDs []string `json:"message_ids"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Data.MessageIDs) != 2 {
+		t.Fatalf("expected 2 message IDs, got %s", rec.Body.String())
+	}
+	for _, id := range out.Data.MessageIDs {
+		if _, ok := a.manager.GetTxStatus(id); !ok {
+			t.Fatalf("unknown message ID %s", id)
+		}
+	}
+}
+
+// TestSendTxQueueFull checks that a request whose messages don't fit in the
+// queue queues none of them.
+func TestSendTxQueueFull(t *testing.T) {
+	a := newTestTxApp(t, 2)
+
+	_, err := sendTx(t, a, `{"subscriber_mode": "external", "subscriber_emails": ["a@example.com", "b@example.com", "c@example.com"],
+		"template_id": 1, "return_ids": true}`)
+	if e, ok := err.(*echo.HTTPError); !ok || e.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected %d, got %v", http.StatusTooManyRequests, err)
+	}
+
+	// The queue still has room for both messages of a request to two recipients.
+	rec, err := sendTx(t, a, `{"subscriber_mode": "external", "subscriber_emails": ["a@example.com", "b@example.com"],
+		"template_id": 1, "return_ids": true}`)
+	if err != nil {
+		t.Fatalf("expected the messages to be queued, got %v", err)
+	}
+	if !strings.Contains(rec.Body.String(), "message_ids") {
+		t.Fatalf("unexpected response %s", rec.Body.String())
+	}
+}
@@ -22,7 +22,7 @@ var (
 func (a *App) GetUser(c echo.Context) error {
 	// Get the user from the DB.
 	id := getID(c)
-	out, err := a.core.GetUser(id, "", "")
+	out, err := a.reqCore(c).GetUser(id, "", "")
 	if err != nil {
 		return err
 	}
@@ -36,7 +36,7 @@ func (a *App) GetUser(c echo.Context) error {
 // GetUsers retrieves all users.
 func (a *App) GetUsers(c echo.Context) error {
 	// Get all users from the DB.
-	out, err := a.core.GetUsers()
+	out, err := a.reqCore(c).GetUsers()
 	if err != nil {
 		return err
 	}
@@ -67,6 +67,10 @@ func (a *App) CreateUser(c echo.Context) error {
 	if !reUsername.MatchString(u.Username) {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "username"))
 	}
+	u.Language = strings.TrimSpace(u.Language)
+	if u.Language != "" && !isValidLang(u.Language, a.fs) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "language"))
+	}
 	if u.Type != auth.UserTypeAPI {
 		if !utils.ValidateEmail(email) {
 			return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "email"))
@@ -85,7 +89,7 @@ func (a *App) CreateUser(c echo.Context) error {
 	}
 
 	// Create the user in the DB.
-	user, err := a.core.CreateUser(u)
+	user, err := a.reqCore(c).CreateUser(u)
 	if err != nil {
 		return err
 	}
@@ -122,6 +126,10 @@ func (a *App) UpdateUser(c echo.Context) error {
 	if !reUsername.MatchString(u.Username) {
 		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "username"))
 	}
+	u.Language = strings.TrimSpace(u.Language)
+	if u.Language != "" && !isValidLang(u.Language, a.fs) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "language"))
+	}
 
 	// Get the user ID.
 	id := getID(c)
@@ -143,7 +151,7 @@ func (a *App) UpdateUser(c echo.Context) error {
 				}
 			} else {
 				// Get the user from the DB.
-				user, err := a.core.GetUser(id, "", "")
+				user, err := a.reqCore(c).GetUser(id, "", "")
 				if err != nil {
 					return err
 				}
@@ -165,7 +173,7 @@ func (a *App) UpdateUser(c echo.Context) error {
 	}
 
 	// Update the user in the DB.
-	user, err := a.core.UpdateUser(id, u)
+	user, err := a.reqCore(c).UpdateUser(id, u)
 	if err != nil {
 		return err
 	}
@@ -185,7 +193,7 @@ func (a *App) UpdateUser(c echo.Context) error {
 func (a *App) DeleteUser(c echo.Context) error {
 	// Delete the user(s) from the DB.
 	id := getID(c)
-	if err := a.core.DeleteUsers([]int{id}); err != nil {
+	if err := a.reqCore(c).DeleteUsers([]int{id}); err != nil {
 		return err
 	}
 
@@ -205,7 +213,7 @@ func (a *App) DeleteUsers(c echo.Context) error {
 	}
 
 	// Delete the user(s) from the DB.
-	if err := a.core.DeleteUsers(ids); err != nil {
+	if err := a.reqCore(c).DeleteUsers(ids); err != nil {
 		return err
 	}
 
@@ -257,8 +265,13 @@ func (a *App) UpdateUserProfile(c echo.Context) error {
 		}
 	}
 
+	u.Language = strings.TrimSpace(u.Language)
+	if u.Language != "" && !isValidLang(u.Language, a.fs) {
+		return echo.NewHTTPError(http.StatusBadRequest, a.i18n.Ts("globals.messages.invalidFields", "name", "language"))
+	}
+
 	// Update the user in the DB.
-	out, err := a.core.UpdateUserProfile(user.ID, u)
+	out, err := a.reqCore(c).UpdateUserProfile(user.ID, u)
 	if err != nil {
 		return err
 	}
@@ -298,7 +311,7 @@ func (a *App) EnableTOTP(c echo.Context) error {
 	}
 
 	// Enable TOTP in the DB.
-	if err := a.core.SetTwoFA(u.ID, models.TwofaTypeTOTP, secret); err != nil {
+	if err := a.reqCore(c).SetTwoFA(u.ID, models.TwofaTypeTOTP, secret); err != nil {
 		return err
 	}
 
@@ -323,12 +336,12 @@ func (a *App) DisableTOTP(c echo.Context) error {
 	}
 
 	// Verify the password.
-	if _, err := a.core.LoginUser(u.Username, password); err != nil {
+	if _, err := a.reqCore(c).LoginUser(u.Username, password); err != nil {
 		return echo.NewHTTPError(http.StatusForbidden, a.i18n.T("users.invalidPassword"))
 	}
 
 	// Disable TOTP in the DB.
-	if err := a.core.SetTwoFA(u.ID, models.TwofaTypeNone, ""); err != nil {
+	if err := a.reqCore(c).SetTwoFA(u.ID, models.TwofaTypeNone, ""); err != nil {
 		return err
 	}
 
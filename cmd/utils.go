@@ -93,6 +93,19 @@ func strHasLen(str string, min, max int) bool {
 	return len(str) >= min && len(str) <= max
 }
 
+// hasExpand checks if the given field is requested in the comma separated
+// (or repeated) ?expand= query param.
+func hasExpand(qp url.Values, field string) bool {
+	for _, v := range qp["expand"] {
+		for _, f := range strings.Split(v, ",") {
+			if strings.TrimSpace(f) == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // getQueryInts parses the list of given query param values into ints.
 func getQueryInts(param string, qp url.Values) ([]int, error) {
 	var out []int
@@ -112,3 +125,50 @@ func getQueryInts(param string, qp url.Values) ([]int, error) {
 
 	return out, nil
 }
+
+// getQueryTags returns the tags in the given query params from both
+// repeated ?tag= params and comma separated ?tags= params.
+func getQueryTags(qp url.Values) []string {
+	out := append([]string{}, qp["tag"]...)
+	for _, v := range qp["tags"] {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				out = append(out, t)
+			}
+		}
+	}
+
+	return out
+}
+
+// validateTags checks if the number of tags and the length of each tag are within limits.
+func validateTags(tags []string) bool {
+	if len(tags) > tagsMaxNum {
+		return false
+	}
+
+	for _, t := range tags {
+		if !strHasLen(strings.TrimSpace(t), 1, tagMaxLen) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// formatMB formats a size in bytes as megabytes.
+func formatMB(n int64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+}
+
+// trimStrings returns the given strings with whitespace trimmed and blanks dropped.
+func trimStrings(in []string) []string {
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+
+	return out
+}
@@ -56,6 +56,11 @@ type Callbacks struct {
 	SetCookie func(cookie *http.Cookie, w any) error
 	GetCookie func(name string, r any) (*http.Cookie, error)
 	GetUser   func(id int) (User, error)
+
+	// OnAPIAccess, if set, is called on every successful API token authentication
+	// with the user and the client IP of the request, as found by the echo
+	// server's IPExtractor.
+	OnAPIAccess func(u User, ip string)
 }
 
 type Auth struct {
@@ -313,6 +318,10 @@ func (o *Auth) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
 				return next(c)
 			}
 
+			if o.cb.OnAPIAccess != nil {
+				o.cb.OnAPIAccess(user, c.RealIP())
+			}
+
 			// Set the user details on the handler context.
 			c.Set(UserHTTPCtxKey, user)
 			return next(c)
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
+	"github.com/labstack/echo/v4"
+	"gopkg.in/volatiletech/null.v6"
+)
+
+func TestOnAPIAccessIP(t *testing.T) {
+	var ip string
+	o := &Auth{
+		apiUsers: map[string]User{},
+		cb:       &Callbacks{OnAPIAccess: func(u User, i string) { ip = i }},
+	}
+	o.CacheAPIUser(User{Username: "api", Password: null.StringFrom("token")})
+
+	e := echo.New()
+	ext, err := webhooks.NewIPExtractor([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.IPExtractor = ext
+	e.GET("/", o.Middleware(func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}))
+
+	cases := []struct {
+		name string
+		peer string
+		ip   string
+	}{
+		{"spoofed", "203.0.113.1", "203.0.113.1"},
+		{"trusted proxy", "10.0.0.1", "198.51.100.1"},
+	}
+	for _, c := range cases {
+		ip = ""
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "token api:token")
+		req.Header.Set(echo.HeaderXForwardedFor, "198.51.100.1")
+		req.Header.Set(echo.HeaderXRealIP, "198.51.100.1")
+		req.RemoteAddr = c.peer + ":1234"
+		e.ServeHTTP(httptest.NewRecorder(), req)
+
+		if ip != c.ip {
+			t.Errorf("%s: expected the API access IP %s, got %q", c.name, c.ip, ip)
+		}
+	}
+}
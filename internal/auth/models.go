@@ -57,6 +57,8 @@ const (
 	PermCampaignsGetAnalytics = "campaigns:get_analytics"
 	PermCampaignsManage       = "campaigns:manage"
 	PermCampaignsManageAll    = "campaigns:manage_all"
+	PermCampaignsAnySender    = "campaigns:any_sender"
+	PermCampaignsApprove      = "campaigns:approve"
 	PermBouncesGet            = "bounces:get"
 	PermBouncesManage         = "bounces:manage"
 	PermWebhooksPostBounce    = "webhooks:post_bounce"
@@ -71,6 +73,10 @@ const (
 	PermSettingsGet           = "settings:get"
 	PermSettingsManage        = "settings:manage"
 	PermSettingsMaintain      = "settings:maintain"
+
+	// PermPIIRedacted isn't a permission, but a flag that redacts the e-mails and
+	// names of subscribers shown to the users of the role.
+	PermPIIRedacted = "pii:redacted"
 )
 
 // Base holds common fields shared across models.
@@ -97,6 +103,7 @@ type User struct {
 	Avatar        null.String      `db:"avatar" json:"avatar"`
 	TwofaType     string           `db:"twofa_type" json:"twofa_type"`
 	TwofaKey      null.String      `db:"twofa_key" json:"-"`
+	Language      string           `db:"language" json:"language"`
 	LoggedInAt    null.Time        `db:"loggedin_at" json:"loggedin_at"`
 	UserRoleID    int              `db:"user_role_id" json:"user_role_id,omitempty"`
 	UserRoleName  string           `db:"user_role_name" json:"-"`
@@ -167,6 +174,17 @@ func (u *User) HasPerm(perm string) bool {
 	return ok
 }
 
+// IsPIIRedacted checks whether the subscriber e-mails and names shown to the user
+// are to be redacted. Unlike permissions, it doesn't apply to the super admin.
+func (u *User) IsPIIRedacted() bool {
+	if u.UserRoleID == SuperAdminRoleID {
+		return false
+	}
+
+	_, ok := u.PermissionsMap[PermPIIRedacted]
+	return ok
+}
+
 // HasListPerm checks if the user has get or manage access to the given list.
 // perm is either PermGet or PermManage.
 func (u *User) HasListPerm(types PermType, listIDs ...int) error {
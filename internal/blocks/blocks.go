@@ -0,0 +1,342 @@
+// Package blocks validates and renders structured campaign content. The content
+// is a JSON array of typed blocks (text, image, button, divider, columns) that's
+// rendered to e-mail safe table markup. The elements of the blocks are styled
+// inline with the block styles of the campaign's template, and every block is
+// wrapped in an element with the block-<type> class.
+package blocks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark"
+)
+
+const (
+	TypeText    = "text"
+	TypeImage   = "image"
+	TypeButton  = "button"
+	TypeDivider = "divider"
+	TypeColumns = "columns"
+
+	// Limits on the columns in a columns block.
+	minColumns = 2
+	maxColumns = 4
+
+	// Keys of the styles of the elements of blocks.
+	StyleBlock   = "block"
+	StyleText    = "text"
+	StyleImage   = "image"
+	StyleButton  = "button"
+	StyleDivider = "divider"
+	StyleColumn  = "column"
+
+	maxStyleLen = 1000
+)
+
+// Styles are the inline CSS styles of the elements of blocks by their keys,
+// eg: {"button": "background: #0055d4; color: #ffffff;"}. The cell of every
+// block is styled with "block", and text blocks also with "text". Elements
+// without styles get DefaultStyles.
+type Styles map[string]string
+
+// DefaultStyles are the styles of the elements that a template has no styles for.
+var DefaultStyles = Styles{
+	StyleBlock:   "padding: 10px 0;",
+	StyleText:    "",
+	StyleImage:   "max-width: 100%; height: auto;",
+	StyleButton:  "display: inline-block; text-decoration: none;",
+	StyleDivider: "border-top: 1px solid #dddddd; margin: 0;",
+	StyleColumn:  "padding: 0 5px;",
+}
+
+// get returns the style of an element with a trailing semicolon, if any.
+func (s Styles) get(key string) string {
+	v, ok := s[key]
+	if !ok {
+		v = DefaultStyles[key]
+	}
+
+	v = strings.TrimSpace(v)
+	if v != "" && !strings.HasSuffix(v, ";") {
+		v += ";"
+	}
+
+	return html.EscapeString(v)
+}
+
+// ValidateStyles checks that the styles are of known elements.
+func ValidateStyles(s Styles) error {
+	var errs Errors
+	for k, v := range s {
+		if _, ok := DefaultStyles[k]; !ok {
+			errs = append(errs, Error{Path: k, Msg: "unknown block element"})
+		} else if len(v) > maxStyleLen {
+			errs = append(errs, Error{Path: k, Msg: fmt.Sprintf("should be at most %d characters", maxStyleLen)})
+		}
+	}
+
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Block represents a content block.
+type Block struct {
+	Type string `json:"type"`
+
+	// Markdown of text blocks.
+	Text string `json:"text,omitempty"`
+
+	// Source and alt text of image blocks.
+	Src string `json:"src,omitempty"`
+	Alt string `json:"alt,omitempty"`
+
+	// Link of image and button blocks and the label of button blocks.
+	URL   string `json:"url,omitempty"`
+	Label string `json:"label,omitempty"`
+
+	// Horizontal alignment of the block: left, center, or right.
+	Align string `json:"align,omitempty"`
+
+	// Blocks in each column of columns blocks. Columns can't be nested.
+	Columns [][]Block `json:"columns,omitempty"`
+}
+
+// Error represents a validation error of a block, eg: blocks[2].columns[0][1].url.
+type Error struct {
+	Path string `json:"path"`
+	Msg  string `json:"message"`
+}
+
+func (e Error) Error() string {
+	return e.Path + ": " + e.Msg
+}
+
+// Errors is the list of validation errors of a body.
+type Errors []Error
+
+func (e Errors) Error() string {
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+
+	return strings.Join(s, "; ")
+}
+
+// Links and images can be absolute http(s) URLs or begin with template
+// expressions, eg: {{ RootURL }}/uploads/logo.png or {{ UnsubscribeURL }}.
+var (
+	reLink  = regexp.MustCompile(`^(?i)(https?://|mailto:|tel:|{{)`)
+	reImage = regexp.MustCompile(`^(?i)(https?://|{{)`)
+
+	reTplExpr = regexp.MustCompile(`{{.*?}}`)
+)
+
+// Parse decodes and validates a body. An empty body has no blocks. Validation
+// errors are returned as Errors with the index of every invalid block.
+func Parse(body string) ([]Block, error) {
+	if strings.TrimSpace(body) == "" {
+		return nil, nil
+	}
+
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	var out []Block
+	if err := dec.Decode(&out); err != nil {
+		return nil, fmt.Errorf("invalid blocks JSON: %v", err)
+	}
+
+	if errs := validate(out, "blocks", true); len(errs) > 0 {
+		return nil, errs
+	}
+
+	return out, nil
+}
+
+// Validate validates a body.
+func Validate(body string) error {
+	_, err := Parse(body)
+	return err
+}
+
+// Render validates a body and renders it to HTML with the given styles. The
+// Markdown of text blocks is converted with md.
+func Render(body string, styles Styles, md goldmark.Markdown) (string, error) {
+	bl, err := Parse(body)
+	if err != nil {
+		return "", err
+	}
+
+	var b bytes.Buffer
+	if err := renderBlocks(&b, bl, styles, md); err != nil {
+		return "", err
+	}
+
+	return b.String(), nil
+}
+
+// validate validates a list of blocks whose paths are prefixed with path.
+func validate(bl []Block, path string, allowColumns bool) Errors {
+	var errs Errors
+	for i, b := range bl {
+		p := fmt.Sprintf("%s[%d]", path, i)
+		fail := func(field, msg string) {
+			errs = append(errs, Error{Path: p + field, Msg: msg})
+		}
+
+		switch b.Align {
+		case "", "left", "center", "right":
+		default:
+			fail(".align", "should be left, center, or right")
+		}
+
+		switch b.Type {
+		case TypeText:
+			if strings.TrimSpace(b.Text) == "" {
+				fail(".text", "is required")
+			}
+
+		case TypeImage:
+			if !reImage.MatchString(b.Src) {
+				fail(".src", "should be an http(s) URL")
+			}
+			if b.URL != "" && !reLink.MatchString(b.URL) {
+				fail(".url", "should be an http(s), mailto, or tel URL")
+			}
+
+		case TypeButton:
+			if strings.TrimSpace(b.Label) == "" {
+				fail(".label", "is required")
+			}
+			if !reLink.MatchString(b.URL) {
+				fail(".url", "should be an http(s), mailto, or tel URL")
+			}
+
+		case TypeDivider:
+
+		case TypeColumns:
+			if !allowColumns {
+				fail(".type", "columns can't be nested")
+				continue
+			}
+			if len(b.Columns) < minColumns || len(b.Columns) > maxColumns {
+				fail(".columns", fmt.Sprintf("should have %d to %d columns", minColumns, maxColumns))
+			}
+			for n, col := range b.Columns {
+				errs = append(errs, validate(col, fmt.Sprintf("%s.columns[%d]", p, n), false)...)
+			}
+
+		default:
+			fail(".type", fmt.Sprintf("unknown type '%s'", b.Type))
+		}
+
+		if b.Type != TypeColumns && len(b.Columns) > 0 {
+			fail(".columns", "is only valid for columns blocks")
+		}
+	}
+
+	return errs
+}
+
+// renderBlocks renders a list of blocks as the rows of a table.
+func renderBlocks(b *bytes.Buffer, bl []Block, st Styles, md goldmark.Markdown) error {
+	b.WriteString(`<table role="presentation" class="blocks" width="100%" cellpadding="0" cellspacing="0" border="0" style="width: 100%; border: 0;">` + "\n")
+
+	for _, bk := range bl {
+		align := bk.Align
+		if align == "" {
+			align = "left"
+			if bk.Type == TypeImage || bk.Type == TypeButton {
+				align = "center"
+			}
+		}
+
+		style := st.get(StyleBlock)
+		if bk.Type == TypeText {
+			style = css(style, st.get(StyleText))
+		}
+		fmt.Fprintf(b, `<tr><td class="block block-%s" align="%s" style="%s">`, bk.Type, align, css(style, "border: 0;", "text-align: "+align+";"))
+
+		switch bk.Type {
+		case TypeText:
+			if err := md.Convert([]byte(bk.Text), b); err != nil {
+				return err
+			}
+
+		case TypeImage:
+			img := fmt.Sprintf(`<img src="%s" alt="%s" style="%s" />`,
+				escape(bk.Src), escape(bk.Alt), css("display: inline-block;", st.get(StyleImage), "border: 0;"))
+			if bk.URL != "" {
+				img = fmt.Sprintf(`<a href="%s">%s</a>`, escape(bk.URL), img)
+			}
+			b.WriteString(img)
+
+		case TypeButton:
+			fmt.Fprintf(b, `<table role="presentation" cellpadding="0" cellspacing="0" border="0" align="%s" style="border: 0;">`+
+				`<tr><td style="border: 0; padding: 0;">`+
+				`<a href="%s" class="button" style="%s">%s</a>`+
+				`</td></tr></table>`, align, escape(bk.URL), st.get(StyleButton), escape(bk.Label))
+
+		case TypeDivider:
+			fmt.Fprintf(b, `<hr style="%s" />`, css("border: 0;", st.get(StyleDivider)))
+
+		case TypeColumns:
+			b.WriteString(`<table role="presentation" width="100%" cellpadding="0" cellspacing="0" border="0" style="width: 100%; border: 0;"><tr>`)
+			w := 100 / len(bk.Columns)
+			for _, col := range bk.Columns {
+				fmt.Fprintf(b, `<td class="block-column" width="%d%%" valign="top" style="%s">`, w, css(fmt.Sprintf("width: %d%%;", w), st.get(StyleColumn), "border: 0;"))
+				if err := renderBlocks(b, col, st, md); err != nil {
+					return err
+				}
+				b.WriteString(`</td>`)
+			}
+			b.WriteString(`</tr></table>`)
+		}
+
+		b.WriteString("</td></tr>\n")
+	}
+
+	b.WriteString("</table>\n")
+
+	return nil
+}
+
+// css joins the non-empty styles of an element.
+func css(styles ...string) string {
+	out := make([]string, 0, len(styles))
+	for _, s := range styles {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+
+	return strings.Join(out, " ")
+}
+
+// escape escapes HTML in a value while leaving its template expressions,
+// eg: {{ .Subscriber.Name }} or {{ TrackLink "..." }}, as they are.
+func escape(s string) string {
+	var (
+		b    strings.Builder
+		last = 0
+	)
+	for _, m := range reTplExpr.FindAllStringIndex(s, -1) {
+		b.WriteString(html.EscapeString(s[last:m[0]]))
+		b.WriteString(s[m[0]:m[1]])
+		last = m[1]
+	}
+	b.WriteString(html.EscapeString(s[last:]))
+
+	return b.String()
+}
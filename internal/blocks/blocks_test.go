@@ -0,0 +1,153 @@
+package blocks
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yuin/goldmark"
+)
+
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// TestRenderGolden renders the bodies in testdata/<name>.json with their styles
+// and compares the HTML with testdata/<name>.golden.
+func TestRenderGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no test files in testdata")
+	}
+
+	for _, f := range files {
+		name := strings.TrimSuffix(filepath.Base(f), ".json")
+		t.Run(name, func(t *testing.T) {
+			b, err := os.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var in struct {
+				Styles Styles          `json:"styles"`
+				Body   json.RawMessage `json:"body"`
+			}
+			if err := json.Unmarshal(b, &in); err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := Render(string(in.Body), in.Styles, goldmark.New())
+			if err != nil {
+				t.Fatalf("error rendering: %v", err)
+			}
+
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, []byte(out), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			exp, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("error reading golden file (run with -update to create it): %v", err)
+			}
+			if out != string(exp) {
+				t.Errorf("output doesn't match %s:\n%s", golden, out)
+			}
+		})
+	}
+}
+
+func TestRenderStyles(t *testing.T) {
+	body := `[{"type": "button", "label": "Go", "url": "https://example.com"}]`
+
+	// No styles render the defaults.
+	out, err := Render(body, nil, goldmark.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `class="button" style="display: inline-block; text-decoration: none;"`) {
+		t.Errorf("expected the default button style: %s", out)
+	}
+
+	// Styles can't break out of the attribute.
+	out, err = Render(body, Styles{StyleButton: `color: red" onclick="alert(1)`}, goldmark.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out, `onclick="`) || !strings.Contains(out, `style="color: red&#34; onclick=&#34;alert(1);"`) {
+		t.Errorf("expected the style to be escaped: %s", out)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name string
+		body string
+		exp  []string
+	}{
+		{"empty", ``, nil},
+		{"valid", `[{"type": "text", "text": "Hi"}, {"type": "button", "label": "Go", "url": "{{ UnsubscribeURL }}"}]`, nil},
+		{"invalid by index", `[{"type": "text", "text": "Hi"}, {"type": "image", "src": "ftp://x"}, {"type": "button", "url": "x"}]`,
+			[]string{"blocks[1].src", "blocks[2].label", "blocks[2].url"}},
+		{"columns", `[{"type": "columns", "columns": [[{"type": "text"}], [{"type": "columns"}]]}]`,
+			[]string{"blocks[0].columns[0][0].text", "blocks[0].columns[1][0].type"}},
+		{"column count", `[{"type": "columns", "columns": [[{"type": "divider"}]]}]`, []string{"blocks[0].columns"}},
+		{"unknown type and align", `[{"type": "video", "align": "top"}]`, []string{"blocks[0].align", "blocks[0].type"}},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := Validate(c.body)
+			if len(c.exp) == 0 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+
+			var errs Errors
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected Errors, got %v", err)
+			}
+			paths := make([]string, len(errs))
+			for i, e := range errs {
+				paths[i] = e.Path
+			}
+			if strings.Join(paths, ",") != strings.Join(c.exp, ",") {
+				t.Errorf("expected errors at %v, got %v", c.exp, paths)
+			}
+		})
+	}
+
+	if err := Validate(`{"type": "text"}`); err == nil {
+		t.Error("expected an error for a body that's not a list")
+	}
+	if err := Validate(`[{"type": "text", "text": "Hi", "color": "red"}]`); err == nil {
+		t.Error("expected an error for unknown fields")
+	}
+}
+
+func TestValidateStyles(t *testing.T) {
+	if err := ValidateStyles(nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := ValidateStyles(Styles{StyleButton: "color: #fff;", StyleBlock: ""}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	err := ValidateStyles(Styles{"link": "color: red;", "table": "", StyleText: strings.Repeat("a", maxStyleLen+1)})
+	var errs Errors
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("expected 3 errors, got %v", err)
+	}
+	if errs[0].Path != "link" || errs[1].Path != "table" || errs[2].Path != StyleText {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
@@ -0,0 +1,144 @@
+// Package botfilter flags tracking events (campaign views and link clicks)
+// that are likely generated by security scanners and bots, such as Outlook SafeLinks
+// and Mimecast, that fetch every pixel and link in a message soon after delivery.
+package botfilter
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Opt represents the bot filter options.
+type Opt struct {
+	// UserAgents is the list of case-insensitive substrings that
+	// flag an event if present in the event's user agent.
+	UserAgents []string
+
+	// GraceWindow flags events that occur within this duration of the message being sent.
+	GraceWindow time.Duration
+
+	// BurstCount flags events from an IP that has fetched the pixels or links of at least
+	// BurstCount distinct subscribers within BurstWindow.
+	BurstWindow time.Duration
+	BurstCount  int
+}
+
+// Filter detects bot events.
+type Filter struct {
+	userAgents  []string
+	graceWindow time.Duration
+	burstWindow time.Duration
+	burstCount  int
+
+	// Recent events by IP for burst detection.
+	hits    map[string][]hit
+	lastGC  time.Time
+	hitsMut sync.Mutex
+
+	// now returns the current time. It's replaced in tests.
+	now func() time.Time
+}
+
+type hit struct {
+	subUUID string
+	t       time.Time
+}
+
+// New returns a new instance of the bot filter.
+func New(o Opt) *Filter {
+	f := &Filter{
+		userAgents:  make([]string, 0, len(o.UserAgents)),
+		graceWindow: o.GraceWindow,
+		burstWindow: o.BurstWindow,
+		burstCount:  o.BurstCount,
+		hits:        make(map[string][]hit),
+		lastGC:      time.Now(),
+		now:         time.Now,
+	}
+
+	for _, u := range o.UserAgents {
+		if u = strings.ToLower(strings.TrimSpace(u)); u != "" {
+			f.userAgents = append(f.userAgents, u)
+		}
+	}
+
+	return f
+}
+
+// IsBot checks whether a tracking event is likely generated by a bot based on the
+// user agent, the time elapsed since the message was sent (if sentAt is non-zero),
+// and bursts of events for distinct subscribers from the same IP.
+func (f *Filter) IsBot(userAgent, ip, subUUID string, sentAt time.Time) bool {
+	now := f.now()
+
+	// Record the hit first so that bursts are detected even if the event
+	// is flagged by the other checks.
+	isBurst := f.recordHit(ip, subUUID, now)
+
+	if f.matchUserAgent(userAgent) {
+		return true
+	}
+
+	if f.graceWindow > 0 && !sentAt.IsZero() && now.Sub(sentAt) < f.graceWindow {
+		return true
+	}
+
+	return isBurst
+}
+
+// matchUserAgent checks whether the user agent matches any of the bot user agents.
+// An empty user agent is considered a bot as real mail clients always send one.
+func (f *Filter) matchUserAgent(ua string) bool {
+	ua = strings.ToLower(strings.TrimSpace(ua))
+	if ua == "" {
+		return true
+	}
+
+	for _, u := range f.userAgents {
+		if strings.Contains(ua, u) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// recordHit records an event for the IP and returns true if the number of distinct
+// subscribers for the IP within the burst window has reached the burst count.
+func (f *Filter) recordHit(ip, subUUID string, now time.Time) bool {
+	if f.burstWindow <= 0 || f.burstCount < 2 || ip == "" {
+		return false
+	}
+
+	f.hitsMut.Lock()
+	defer f.hitsMut.Unlock()
+
+	// Periodically clean up stale IPs.
+	if now.Sub(f.lastGC) > f.burstWindow {
+		for k, hits := range f.hits {
+			if len(hits) == 0 || now.Sub(hits[len(hits)-1].t) > f.burstWindow {
+				delete(f.hits, k)
+			}
+		}
+		f.lastGC = now
+	}
+
+	// Drop the IP's hits that are outside the window and add the new one.
+	var (
+		hits = f.hits[ip]
+		out  = make([]hit, 0, len(hits)+1)
+		subs = make(map[string]struct{}, len(hits)+1)
+	)
+	for _, h := range hits {
+		if now.Sub(h.t) <= f.burstWindow {
+			out = append(out, h)
+			subs[h.subUUID] = struct{}{}
+		}
+	}
+	out = append(out, hit{subUUID: subUUID, t: now})
+	subs[subUUID] = struct{}{}
+	f.hits[ip] = out
+
+	return len(subs) >= f.burstCount
+}
@@ -0,0 +1,180 @@
+package botfilter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+const ua = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Thunderbird/115.0"
+
+func TestUserAgents(t *testing.T) {
+	f := New(Opt{UserAgents: []string{" SafeLinks ", "curl/", ""}})
+
+	for u, exp := range map[string]bool{
+		ua:                               false,
+		"":                               true,
+		"Microsoft Office SafeLinks/1.0": true,
+		"curl/8.0.1":                     true,
+		"curly":                          false,
+	} {
+		if got := f.IsBot(u, "10.0.0.1", "sub", time.Time{}); got != exp {
+			t.Errorf("%q: expected %v, got %v", u, exp, got)
+		}
+	}
+}
+
+func TestGraceWindow(t *testing.T) {
+	f := New(Opt{GraceWindow: time.Minute})
+
+	if !f.IsBot(ua, "10.0.0.1", "sub", time.Now().Add(-time.Second)) {
+		t.Error("expected an event right after the send to be flagged")
+	}
+	if f.IsBot(ua, "10.0.0.1", "sub", time.Now().Add(-time.Hour)) {
+		t.Error("expected an event after the grace window not to be flagged")
+	}
+
+	// Without the send time, eg: URLs of messages sent with the filter
+	// disabled, the grace window doesn't apply.
+	if f.IsBot(ua, "10.0.0.1", "sub", time.Time{}) {
+		t.Error("expected an event without a send time not to be flagged")
+	}
+}
+
+func TestBurst(t *testing.T) {
+	f := New(Opt{BurstWindow: time.Minute, BurstCount: 3})
+
+	// Repeated events of the same subscriber aren't a burst.
+	for range 5 {
+		if f.IsBot(ua, "10.0.0.1", "sub", time.Time{}) {
+			t.Fatal("expected the events of one subscriber not to be flagged")
+		}
+	}
+
+	if f.IsBot(ua, "10.0.0.1", "sub2", time.Time{}) {
+		t.Fatal("expected the second subscriber not to be flagged")
+	}
+	if !f.IsBot(ua, "10.0.0.1", "sub3", time.Time{}) {
+		t.Fatal("expected the third distinct subscriber from the IP to be flagged")
+	}
+
+	// Other IPs are counted separately.
+	for i := range 2 {
+		if f.IsBot(ua, "10.0.0.2", fmt.Sprintf("sub%d", i), time.Time{}) {
+			t.Fatal("expected the events of another IP not to be flagged")
+		}
+	}
+}
+
+// defaultOpt mirrors the default privacy.bot_filter settings.
+var defaultOpt = Opt{
+	UserAgents: []string{"bot", "crawler", "spider", "safelinks", "mimecast", "barracuda", "proofpoint", "symantec",
+		"trendmicro", "fortinet", "python-requests", "curl/", "wget/", "go-http-client", "headlesschrome"},
+	GraceWindow: 10 * time.Second,
+	BurstWindow: 10 * time.Second,
+	BurstCount:  10,
+}
+
+// Browser user agents that the link scanners present instead of identifying themselves.
+const (
+	uaEdge     = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.0.0"
+	uaChrome   = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36"
+	uaIE11     = "Mozilla/5.0 (Windows NT 6.1; WOW64; Trident/7.0; rv:11.0) like Gecko"
+	uaOutlook  = "Microsoft Office/16.0 (Windows NT 10.0; Microsoft Outlook 16.0.17328; Pro)"
+	uaMacOS    = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+	uaGoogleIP = "Mozilla/5.0 (Windows NT 5.1; rv:11.0) Gecko Firefox/11.0 (via ggpht.com GoogleImageProxy)"
+)
+
+// trackEvent is a view or click at an offset from the message's send time.
+type trackEvent struct {
+	at  time.Duration
+	ua  string
+	ip  string
+	sub string
+	bot bool
+}
+
+// TestScannerSequences replays the tracking events of messages delivered to mailboxes
+// behind link scanners, modelled on the user agents, source networks, and timings
+// reported for them, followed by the subscribers' own opens and clicks.
+func TestScannerSequences(t *testing.T) {
+	// burst returns the events of a scanner fetching the links of n subscribers
+	// from one IP, starting at an offset from the send.
+	burst := func(from time.Duration, n int, ua, ip string) []trackEvent {
+		out := make([]trackEvent, n)
+		for i := range out {
+			out[i] = trackEvent{from + time.Duration(i)*300*time.Millisecond, ua, ip, fmt.Sprintf("sub%d", i), i >= defaultOpt.BurstCount-1}
+		}
+		return out
+	}
+
+	cases := []struct {
+		name   string
+		events []trackEvent
+	}{
+		{
+			// SafeLinks fetches every pixel and link with a browser user agent from
+			// Microsoft's network within seconds of delivery, before the message is read.
+			name: "safelinks",
+			events: []trackEvent{
+				{1200 * time.Millisecond, uaEdge, "40.94.29.10", "sub1", true},
+				{1500 * time.Millisecond, uaEdge, "40.94.29.10", "sub1", true},
+				{2100 * time.Millisecond, uaEdge, "40.94.29.45", "sub2", true},
+				{6800 * time.Millisecond, uaEdge, "40.94.31.7", "sub3", true},
+
+				// The subscribers open and click later from their own networks.
+				{47 * time.Minute, uaOutlook, "203.0.113.7", "sub1", false},
+				{48 * time.Minute, uaEdge, "203.0.113.7", "sub1", false},
+				{3 * time.Hour, uaOutlook, "198.51.100.20", "sub3", false},
+			},
+		},
+		{
+			// Mimecast scans held messages in batches after the grace window. The first
+			// subscribers of a batch pass until the IP reaches the burst count. Its
+			// link checks without a user agent are flagged regardless.
+			name: "mimecast",
+			events: append(burst(45*time.Second, 14, uaChrome, "205.139.110.24"),
+				trackEvent{46 * time.Second, "", "205.139.110.61", "sub20", true},
+				trackEvent{25 * time.Minute, uaMacOS, "192.0.2.33", "sub3", false},
+			),
+		},
+		{
+			// Proofpoint's URL Defense sandbox follows the rewritten links with an old
+			// IE user agent on delivery and with scripted clients later.
+			name: "proofpoint",
+			events: []trackEvent{
+				{3 * time.Second, uaIE11, "148.163.150.12", "sub1", true},
+				{4 * time.Second, uaIE11, "148.163.150.12", "sub2", true},
+				{90 * time.Second, "python-requests/2.31.0", "148.163.158.5", "sub1", true},
+				{20 * time.Minute, uaMacOS, "192.0.2.90", "sub1", false},
+			},
+		},
+		{
+			// Gmail's image proxy fetches the pixel when the message is opened. The
+			// opens of different subscribers are spread out and aren't flagged.
+			name: "google image proxy",
+			events: []trackEvent{
+				{2 * time.Minute, uaGoogleIP, "66.249.84.20", "sub1", false},
+				{9 * time.Minute, uaGoogleIP, "66.249.84.20", "sub2", false},
+				{9*time.Minute + 40*time.Second, uaGoogleIP, "66.249.84.20", "sub3", false},
+				{31 * time.Minute, uaGoogleIP, "66.249.84.22", "sub4", false},
+			},
+		},
+	}
+
+	sent := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	for _, c := range cases {
+		var (
+			f   = New(defaultOpt)
+			now time.Time
+		)
+		f.now = func() time.Time { return now }
+
+		for i, e := range c.events {
+			now = sent.Add(e.at)
+			if got := f.IsBot(e.ua, e.ip, e.sub, sent); got != e.bot {
+				t.Errorf("%s: event %d (%s from %s at %v): expected %v, got %v", c.name, i, e.sub, e.ip, e.at, e.bot, got)
+			}
+		}
+	}
+}
@@ -1,16 +1,53 @@
 package bounce
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/knadh/listmonk/internal/bounce/mailbox"
 	"github.com/knadh/listmonk/internal/bounce/webhooks"
+	"github.com/knadh/listmonk/internal/verp"
 	"github.com/knadh/listmonk/models"
 )
 
+const (
+	// Bounces of the same type for the same subscriber and campaign within
+	// this window are considered duplicates, eg: a provider retrying a webhook
+	// or the same bounce arriving via a webhook and the mailbox.
+	dedupWindow = time.Minute * 10
+
+	// Maximum number of recent bounces tracked for deduplication.
+	maxRecent = 10000
+
+	// SourceAPI is the source recorded for bounces posted to the native
+	// webhook without one.
+	SourceAPI = "api"
+)
+
+var reUUID = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
+// ValidationError is returned by ProcessBounce when a bounce is invalid.
+type ValidationError struct {
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Err != nil {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("invalid bounce field: %s", e.Field)
+}
+
 // Mailbox represents a POP/IMAP mailbox client that can scan messages and pass
 // them to a given channel.
 type Mailbox interface {
@@ -36,7 +73,30 @@ type Opt struct {
 		Key     string
 	}
 
-	RecordBounceCB func(models.Bounce) error
+	// WebhooksLogOnly logs webhook requests that fail verification
+	// instead of rejecting them.
+	WebhooksLogOnly bool
+
+	// WebhookAllowlist, if set, restricts the source IPs of the webhook
+	// requests of providers.
+	WebhookAllowlist *webhooks.Allowlist
+
+	// VERP, if set, decodes the campaign and subscriber UUIDs from the
+	// recipients (envelope senders of the original messages) of bounces.
+	VERP *verp.VERP
+
+	// Processing of unsubscribe requests sent as replies to campaigns
+	// that land in the mailbox.
+	Replies mailbox.ReplyOpt
+
+	RecordBounceCB     func(models.Bounce) error
+	SanitizeEmailCB    func(string) (string, error)
+	UnsubscribeReplyCB func(mailbox.Reply) error
+
+	// SubscriberEmailCB returns the e-mail of a subscriber by UUID, or an empty
+	// string if the subscriber doesn't exist, to verify that unsubscribe replies
+	// are sent by the subscriber.
+	SubscriberEmailCB func(subUUID string) (string, error)
 }
 
 // Manager handles e-mail bounces.
@@ -50,6 +110,31 @@ type Manager struct {
 	queries      *Queries
 	opt          Opt
 	log          *log.Logger
+
+	// Recently recorded bounces for deduplication.
+	recent    map[string]time.Time
+	recentMut sync.Mutex
+
+	fnEvent func(event string, data any)
+
+	// Number of webhook requests that failed verification by provider.
+	unverified    map[string]int64
+	unverifiedMut sync.Mutex
+
+	// Number of webhook requests from source IPs that aren't allowed by provider.
+	rejected    map[string]int64
+	rejectedMut sync.Mutex
+
+	// Live bounce counts of running campaigns by campaign UUID.
+	live    map[string]*models.BounceCounts
+	liveMut sync.RWMutex
+
+	// The running mailbox scanner is stopped by closing scanStop, and it
+	// closes scanDone when it exits.
+	scanStop chan struct{}
+	scanDone chan struct{}
+	scanMut  sync.Mutex
+	running  bool
 }
 
 // Queries contains the queries.
@@ -65,25 +150,35 @@ func New(opt Opt, q *Queries, lo *log.Logger) (*Manager, error) {
 		queries: q,
 		queue:   make(chan models.Bounce, 1000),
 		log:     lo,
+		recent:  make(map[string]time.Time),
+		fnEvent: func(event string, data any) {},
+
+		unverified: make(map[string]int64),
+		rejected:   make(map[string]int64),
+		live:       make(map[string]*models.BounceCounts),
 	}
 
 	// Is there a mailbox?
 	if opt.MailboxEnabled {
-		switch opt.MailboxType {
-		case "pop":
-			m.mailbox = mailbox.NewPOP(opt.Mailbox)
-		default:
-			return nil, errors.New("unknown bounce mailbox type")
+		mb, err := m.newMailbox(opt.MailboxType, opt.Mailbox)
+		if err != nil {
+			return nil, err
 		}
+		m.mailbox = mb
 	}
 
 	if opt.WebhooksEnabled {
+		wo := webhooks.Opt{
+			LogOnly:      opt.WebhooksLogOnly,
+			OnUnverified: m.recordUnverified,
+		}
+
 		if opt.SESEnabled {
-			m.SES = webhooks.NewSES()
+			m.SES = webhooks.NewSES(wo)
 		}
 
 		if opt.SendgridEnabled {
-			sg, err := webhooks.NewSendgrid(opt.SendgridKey)
+			sg, err := webhooks.NewSendgrid(opt.SendgridKey, wo)
 			if err != nil {
 				lo.Printf("error initializing sendgrid webhooks: %v", err)
 			} else {
@@ -92,11 +187,16 @@ func New(opt Opt, q *Queries, lo *log.Logger) (*Manager, error) {
 		}
 
 		if opt.Postmark.Enabled {
-			m.Postmark = webhooks.NewPostmark(opt.Postmark.Username, opt.Postmark.Password)
+			pm, err := webhooks.NewPostmark(opt.Postmark.Username, opt.Postmark.Password, wo)
+			if err != nil {
+				lo.Printf("error initializing postmark webhooks: %v", err)
+			} else {
+				m.Postmark = pm
+			}
 		}
 
 		if opt.ForwardEmail.Enabled {
-			fe := webhooks.NewForwardemail([]byte(opt.ForwardEmail.Key))
+			fe := webhooks.NewForwardemail([]byte(opt.ForwardEmail.Key), wo)
 			m.Forwardemail = fe
 		}
 	}
@@ -104,38 +204,412 @@ func New(opt Opt, q *Queries, lo *log.Logger) (*Manager, error) {
 	return m, nil
 }
 
-// Run is a blocking function that listens for bounce events from webhooks and or mailboxes
-// and executes them on the DB.
+// SetEventHandler sets the callback that receives the events emitted
+// for recorded bounces.
+func (m *Manager) SetEventHandler(fn func(event string, data any)) {
+	m.fnEvent = fn
+}
+
+// UnverifiedCounts returns the number of webhook requests that have failed
+// verification, by provider, since the manager started.
+func (m *Manager) UnverifiedCounts() map[string]int64 {
+	m.unverifiedMut.Lock()
+	defer m.unverifiedMut.Unlock()
+
+	out := make(map[string]int64, len(m.unverified))
+	for k, v := range m.unverified {
+		out[k] = v
+	}
+
+	return out
+}
+
+// AllowWebhookSource checks the source IP of a request to a provider's webhook
+// against the allowlist. Requests from sources that aren't allowed are counted
+// and logged, and in the audit mode, allowed anyway.
+func (m *Manager) AllowWebhookSource(provider string, r *http.Request) bool {
+	al := m.opt.WebhookAllowlist
+	if al == nil {
+		return true
+	}
+
+	ip, ok := al.Allowed(provider, r)
+	if ok {
+		return true
+	}
+
+	m.rejectedMut.Lock()
+	m.rejected[provider]++
+	m.rejectedMut.Unlock()
+
+	if al.AuditOnly {
+		m.log.Printf("%s bounce webhook request from %s isn't in the allowlist (audit mode, processing anyway)", provider, ip)
+		return true
+	}
+	m.log.Printf("%s bounce webhook request from %s isn't in the allowlist, rejecting", provider, ip)
+
+	return false
+}
+
+// RejectedCounts returns the number of webhook requests from source IPs that
+// aren't in the allowlist, by provider, since the manager started. Requests
+// allowed in the audit mode are included.
+func (m *Manager) RejectedCounts() map[string]int64 {
+	m.rejectedMut.Lock()
+	defer m.rejectedMut.Unlock()
+
+	out := make(map[string]int64, len(m.rejected))
+	for k, v := range m.rejected {
+		out[k] = v
+	}
+
+	return out
+}
+
+// TrackCampaign starts maintaining live bounce counts for a running campaign,
+// starting with the given counts that have already been recorded in the DB.
+func (m *Manager) TrackCampaign(uuid string, base models.BounceCounts) {
+	m.liveMut.Lock()
+	m.live[uuid] = &base
+	m.liveMut.Unlock()
+}
+
+// UntrackCampaign stops maintaining the live bounce counts of a campaign once it's
+// no longer running, after which, the counts in the DB are the source of truth.
+func (m *Manager) UntrackCampaign(uuid string) {
+	m.liveMut.Lock()
+	delete(m.live, uuid)
+	m.liveMut.Unlock()
+}
+
+// LiveCounts returns the live bounce counts of a running campaign. The bool
+// is false if the campaign isn't being tracked.
+func (m *Manager) LiveCounts(uuid string) (models.BounceCounts, bool) {
+	m.liveMut.RLock()
+	defer m.liveMut.RUnlock()
+
+	c, ok := m.live[uuid]
+	if !ok {
+		return models.BounceCounts{}, false
+	}
+
+	return *c, true
+}
+
+// countLive increments the live counts of a tracked campaign with a recorded bounce.
+func (m *Manager) countLive(b models.Bounce) {
+	if b.CampaignUUID == "" {
+		return
+	}
+
+	m.liveMut.Lock()
+	defer m.liveMut.Unlock()
+
+	c, ok := m.live[b.CampaignUUID]
+	if !ok {
+		return
+	}
+
+	switch b.Type {
+	case models.BounceTypeHard:
+		c.Hard++
+	case models.BounceTypeSoft:
+		c.Soft++
+	case models.BounceTypeComplaint:
+		c.Complaint++
+	}
+	c.Total++
+}
+
+// recordUnverified counts and logs a webhook request that failed verification.
+func (m *Manager) recordUnverified(provider string, err error) {
+	m.unverifiedMut.Lock()
+	m.unverified[provider]++
+	m.unverifiedMut.Unlock()
+
+	if m.opt.WebhooksLogOnly {
+		m.log.Printf("%s bounce webhook failed verification (log-only mode, processing anyway): %v", provider, err)
+		return
+	}
+	m.log.Printf("%s bounce webhook failed verification: %v", provider, err)
+}
+
+// Run is a blocking function that listens for bounce events from mailboxes
+// and processes them.
 func (m *Manager) Run() {
-	if m.opt.MailboxEnabled {
-		go m.runMailboxScanner()
+	m.scanMut.Lock()
+	m.running = true
+	if m.mailbox != nil {
+		m.startScanner(m.mailbox, m.opt.Mailbox, nil)
 	}
+	m.scanMut.Unlock()
 
 	for b := range m.queue {
-		if b.CreatedAt.IsZero() {
-			b.CreatedAt = time.Now()
+		if err := m.ProcessBounce(b); err != nil {
+			m.log.Printf("error processing bounce from %s: %v", b.Source, err)
 		}
+	}
+}
 
-		if err := m.opt.RecordBounceCB(b); err != nil {
-			continue
+// ReloadMailbox replaces the mailbox that's scanned for bounces, eg: when its
+// credentials change, without restarting the manager. If enabled is false, the
+// scanning stops. A scan that's in progress is allowed to finish so that the
+// downloaded messages are deleted from the server and its connection is closed
+// cleanly. The new mailbox is scanned after that.
+func (m *Manager) ReloadMailbox(enabled bool, typ string, opt mailbox.Opt) error {
+	var mb Mailbox
+	if enabled {
+		var err error
+		if mb, err = m.newMailbox(typ, opt); err != nil {
+			return err
 		}
 	}
+
+	m.scanMut.Lock()
+	defer m.scanMut.Unlock()
+
+	prev := m.scanDone
+	if m.scanStop != nil {
+		close(m.scanStop)
+		m.scanStop, m.scanDone = nil, nil
+	}
+
+	m.mailbox = mb
+	m.opt.MailboxEnabled = enabled
+	m.opt.MailboxType = typ
+	m.opt.Mailbox = opt
+
+	if mb != nil && m.running {
+		m.startScanner(mb, opt, prev)
+	}
+
+	return nil
+}
+
+// startScanner starts a mailbox scanner after the previous scanner, if
+// any, exits. scanMut should be held by the caller.
+func (m *Manager) startScanner(mb Mailbox, opt mailbox.Opt, prev chan struct{}) {
+	stop, done := make(chan struct{}), make(chan struct{})
+	m.scanStop, m.scanDone = stop, done
+
+	go m.runMailboxScanner(mb, opt, stop, done, prev)
 }
 
-// runMailboxScanner runs a blocking loop that scans the mailbox at given intervals.
-func (m *Manager) runMailboxScanner() {
+// runMailboxScanner runs a blocking loop that scans the mailbox at given intervals
+// until stop is closed.
+func (m *Manager) runMailboxScanner(mb Mailbox, opt mailbox.Opt, stop, done, prev chan struct{}) {
+	defer close(done)
+
+	// Wait for the previous scanner to finish its scan so that the
+	// mailbox isn't scanned concurrently.
+	if prev != nil {
+		<-prev
+	}
+
 	for {
-		m.log.Printf("scanning bounce mailbox %s", m.opt.Mailbox.Host)
-		if err := m.mailbox.Scan(1000, m.queue); err != nil {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		m.log.Printf("scanning bounce mailbox %s", opt.Host)
+		if err := mb.Scan(1000, m.queue); err != nil {
 			m.log.Printf("error scanning bounce mailbox: %v", err)
 		}
 
-		time.Sleep(m.opt.Mailbox.ScanInterval)
+		select {
+		case <-stop:
+			return
+		case <-time.After(opt.ScanInterval):
+		}
+	}
+}
+
+// newMailbox returns a mailbox client of the given type.
+func (m *Manager) newMailbox(typ string, opt mailbox.Opt) (Mailbox, error) {
+	switch typ {
+	case "pop":
+		return mailbox.NewPOP(opt, m.opt.Replies, m.processReply), nil
+	default:
+		return nil, errors.New("unknown bounce mailbox type")
 	}
 }
 
-// Record records a new bounce event given the subscriber's email or UUID.
-func (m *Manager) Record(b models.Bounce) error {
-	m.queue <- b
+// ProcessBounce validates, deduplicates, and records a bounce from any source
+// (webhooks, mailboxes) and emits an event for it. It returns a *ValidationError
+// if the bounce is invalid. Duplicate bounces are silently ignored.
+func (m *Manager) ProcessBounce(b models.Bounce) error {
+	b, err := m.validate(b)
+	if err != nil {
+		return err
+	}
+
+	if m.isDuplicate(b) {
+		return nil
+	}
+
+	if err := m.opt.RecordBounceCB(b); err != nil {
+		// Forget the bounce so that a retry isn't dropped as a duplicate.
+		m.forget(b)
+		return err
+	}
+	m.countLive(b)
+
+	m.fnEvent(models.EventBounceRecorded, b)
 	return nil
 }
+
+// processReply processes an unsubscribe request sent as a reply to a campaign. Replies
+// that can't be attributed to a campaign and a subscriber, or that aren't sent from the
+// subscriber's e-mail address, eg: forwarded campaigns, are logged and dropped.
+func (m *Manager) processReply(r mailbox.Reply) error {
+	if !reUUID.MatchString(r.CampaignUUID) || !reUUID.MatchString(r.SubscriberUUID) {
+		m.log.Printf("ignoring unsubscribe reply %s from %s: campaign or subscriber not found", r.MessageID, r.From)
+		return nil
+	}
+
+	if m.opt.UnsubscribeReplyCB == nil {
+		return nil
+	}
+
+	if m.opt.SubscriberEmailCB != nil {
+		email, err := m.opt.SubscriberEmailCB(r.SubscriberUUID)
+		if err != nil {
+			m.log.Printf("error fetching the subscriber of unsubscribe reply %s: %v", r.MessageID, err)
+			return err
+		}
+		if email == "" {
+			m.log.Printf("ignoring unsubscribe reply %s from %s: subscriber %s not found", r.MessageID, r.From, r.SubscriberUUID)
+			return nil
+		}
+
+		from, err := mail.ParseAddress(r.From)
+		if err != nil || !strings.EqualFold(from.Address, email) {
+			m.log.Printf("ignoring unsubscribe reply %s from %s: sender doesn't match the e-mail of subscriber %s",
+				r.MessageID, r.From, r.SubscriberUUID)
+			return nil
+		}
+	}
+
+	if err := m.opt.UnsubscribeReplyCB(r); err != nil {
+		m.log.Printf("error processing unsubscribe reply %s from %s: %v", r.MessageID, r.From, err)
+		return err
+	}
+
+	m.log.Printf("unsubscribed subscriber %s from the lists of campaign %s by e-mail reply (%s: %s)",
+		r.SubscriberUUID, r.CampaignUUID, r.Lang, r.Keyword)
+	return nil
+}
+
+// validate validates a bounce and fills in the defaults of optional fields.
+func (m *Manager) validate(b models.Bounce) (models.Bounce, error) {
+	// A signed VERP recipient is the most reliable attribution as it survives
+	// the original headers being stripped, and overrides the rest.
+	if m.opt.VERP != nil {
+		for _, r := range b.Recipients {
+			if c, s, ok := m.opt.VERP.Decode(r); ok {
+				b.CampaignUUID = c
+				b.SubscriberUUID = s
+				break
+			}
+		}
+	}
+
+	b.Email = strings.TrimSpace(b.Email)
+	b.SubscriberUUID = strings.TrimSpace(b.SubscriberUUID)
+	b.CampaignUUID = strings.TrimSpace(b.CampaignUUID)
+
+	if b.Email == "" && b.SubscriberUUID == "" {
+		return b, &ValidationError{Field: "email / subscriber_uuid"}
+	}
+
+	if b.SubscriberUUID != "" && !reUUID.MatchString(b.SubscriberUUID) {
+		return b, &ValidationError{Field: "subscriber_uuid"}
+	}
+
+	// An invalid campaign UUID doesn't invalidate the bounce. It's just
+	// not associated with a campaign.
+	if b.CampaignUUID != "" && !reUUID.MatchString(b.CampaignUUID) {
+		b.CampaignUUID = ""
+	}
+
+	if b.Email != "" {
+		if m.opt.SanitizeEmailCB != nil {
+			em, err := m.opt.SanitizeEmailCB(b.Email)
+			if err != nil {
+				return b, &ValidationError{Field: "email", Err: err}
+			}
+			b.Email = em
+		}
+		b.Email = strings.ToLower(b.Email)
+	}
+
+	if b.Type != models.BounceTypeHard && b.Type != models.BounceTypeSoft && b.Type != models.BounceTypeComplaint {
+		return b, &ValidationError{Field: "type"}
+	}
+
+	b.Source = strings.TrimSpace(b.Source)
+	if b.Source == "" {
+		b.Source = SourceAPI
+	}
+
+	if len(b.Meta) == 0 {
+		b.Meta = json.RawMessage("{}")
+	}
+
+	if b.CreatedAt.IsZero() {
+		b.CreatedAt = time.Now()
+	}
+
+	return b, nil
+}
+
+// isDuplicate checks whether an identical bounce was recorded within the dedup
+// window, and if not, remembers the bounce.
+func (m *Manager) isDuplicate(b models.Bounce) bool {
+	var (
+		key = dedupKey(b)
+		now = time.Now()
+	)
+
+	m.recentMut.Lock()
+	defer m.recentMut.Unlock()
+
+	if t, ok := m.recent[key]; ok && now.Sub(t) < dedupWindow {
+		return true
+	}
+
+	// Evict expired entries when full, and if that doesn't free up
+	// space, start afresh.
+	if len(m.recent) >= maxRecent {
+		for k, t := range m.recent {
+			if now.Sub(t) >= dedupWindow {
+				delete(m.recent, k)
+			}
+		}
+		if len(m.recent) >= maxRecent {
+			m.recent = make(map[string]time.Time)
+		}
+	}
+
+	m.recent[key] = now
+	return false
+}
+
+// forget removes a bounce remembered by isDuplicate.
+func (m *Manager) forget(b models.Bounce) {
+	m.recentMut.Lock()
+	delete(m.recent, dedupKey(b))
+	m.recentMut.Unlock()
+}
+
+// dedupKey returns the key of a bounce's type, campaign, and subscriber
+// for deduplication.
+func dedupKey(b models.Bounce) string {
+	sub := b.SubscriberUUID
+	if sub == "" {
+		sub = b.Email
+	}
+	return b.Type + "|" + b.CampaignUUID + "|" + sub
+}
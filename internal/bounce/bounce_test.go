@@ -0,0 +1,372 @@
+package bounce
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/bounce/mailbox"
+	"github.com/knadh/listmonk/internal/bounce/webhooks"
+	"github.com/knadh/listmonk/internal/verp"
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	testCampUUID = "2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11"
+	testSubUUID  = "7c1e0b7e-3f38-4b47-9f6a-0b1e2d3c4f5a"
+)
+
+func TestProcessReply(t *testing.T) {
+	var (
+		logs  bytes.Buffer
+		unsub []string
+		subs  = map[string]string{testSubUUID: "john@example.com"}
+	)
+	m, err := New(Opt{
+		UnsubscribeReplyCB: func(r mailbox.Reply) error {
+			unsub = append(unsub, r.From)
+			return nil
+		},
+		SubscriberEmailCB: func(uuid string) (string, error) {
+			if uuid == "00000000-0000-0000-0000-000000000000" {
+				return "", errors.New("db error")
+			}
+			return subs[uuid], nil
+		},
+	}, nil, log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name    string
+		from    string
+		subUUID string
+		unsub   bool
+		err     bool
+		log     string
+	}{
+		{"sender is the subscriber", "John Doe <John@Example.com>", testSubUUID, true, false, "unsubscribed"},
+		{"bare address", "john@example.com", testSubUUID, true, false, "unsubscribed"},
+		{"other sender", "Jane Doe <jane@example.com>", testSubUUID, false, false, "doesn't match"},
+		{"lookalike sender", "john@example.com <jane@example.com>", testSubUUID, false, false, "doesn't match"},
+		{"invalid sender", "john", testSubUUID, false, false, "doesn't match"},
+		{"unknown subscriber", "john@example.com", "4f9f6a44-0b1b-4a4e-8f4f-0d7a7c6f5e11", false, false, "not found"},
+		{"lookup error", "john@example.com", "00000000-0000-0000-0000-000000000000", false, true, "error fetching"},
+		{"no attribution", "john@example.com", "", false, false, "not found"},
+	}
+
+	for _, c := range cases {
+		logs.Reset()
+		unsub = nil
+
+		err := m.processReply(mailbox.Reply{CampaignUUID: testCampUUID, SubscriberUUID: c.subUUID, From: c.from, MessageID: "<r@example.com>"})
+		if (err != nil) != c.err {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+		if (len(unsub) == 1) != c.unsub {
+			t.Errorf("%s: expected unsubscription %v, got %v", c.name, c.unsub, unsub)
+		}
+		if !strings.Contains(logs.String(), c.log) {
+			t.Errorf("%s: expected the log to contain %q, got %q", c.name, c.log, logs.String())
+		}
+	}
+}
+
+func TestAllowWebhookSource(t *testing.T) {
+	al, err := webhooks.NewAllowlist(webhooks.AllowlistOpt{AllowedCIDRs: map[string][]string{"ses": {"54.240.0.0/18"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	m, err := New(Opt{WebhookAllowlist: al}, nil, log.New(&logs, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := func(remote string) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/webhooks/service/ses", nil)
+		r.RemoteAddr = remote
+		return r
+	}
+
+	if !m.AllowWebhookSource("ses", req("54.240.1.1:1234")) || !m.AllowWebhookSource("sendgrid", req("1.2.3.4:1234")) {
+		t.Fatal("expected the allowed sources to be allowed")
+	}
+	if m.AllowWebhookSource("ses", req("1.2.3.4:1234")) {
+		t.Fatal("expected the other source to be rejected")
+	}
+	if !strings.Contains(logs.String(), "rejecting") {
+		t.Errorf("expected the rejection to be logged, got %q", logs.String())
+	}
+
+	// In the audit mode, other sources are counted and logged, but allowed.
+	al.AuditOnly = true
+	logs.Reset()
+	if !m.AllowWebhookSource("ses", req("1.2.3.4:1234")) {
+		t.Fatal("expected the other source to be allowed in the audit mode")
+	}
+	if !strings.Contains(logs.String(), "audit mode") {
+		t.Errorf("expected the audit to be logged, got %q", logs.String())
+	}
+
+	if c := m.RejectedCounts(); len(c) != 1 || c["ses"] != 2 {
+		t.Errorf("unexpected rejected counts %v", c)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	v, err := verp.New(verp.Opt{Domain: "bounces.example.com", Prefix: "bounce", Scheme: verp.SchemeCompact, Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verpAddr, err := v.Encode(testCampUUID, testSubUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := verp.New(verp.Opt{Domain: "bounces.example.com", Prefix: "bounce", Scheme: verp.SchemeCompact, Secret: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := other.Encode(testCampUUID, testSubUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := New(Opt{
+		VERP: v,
+		SanitizeEmailCB: func(e string) (string, error) {
+			if !strings.Contains(e, "@") {
+				return "", errors.New("invalid e-mail")
+			}
+			return e, nil
+		},
+	}, nil, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const otherUUID = "4f9f6a44-0b1b-4a4e-8f4f-0d7a7c6f5e11"
+	cases := []struct {
+		name  string
+		in    models.Bounce
+		field string
+		exp   models.Bounce
+	}{
+		{"no email or subscriber", models.Bounce{Email: "  ", Type: models.BounceTypeHard}, "email / subscriber_uuid", models.Bounce{}},
+		{"invalid subscriber", models.Bounce{SubscriberUUID: "123", Type: models.BounceTypeHard}, "subscriber_uuid", models.Bounce{}},
+		{"invalid email", models.Bounce{Email: "john", Type: models.BounceTypeHard}, "email", models.Bounce{}},
+		{"no type", models.Bounce{Email: "john@example.com"}, "type", models.Bounce{}},
+		{"invalid type", models.Bounce{Email: "john@example.com", Type: "bounced"}, "type", models.Bounce{}},
+		{
+			"defaults",
+			models.Bounce{Email: " John@Example.com ", Type: models.BounceTypeSoft},
+			"",
+			models.Bounce{Email: "john@example.com", Type: models.BounceTypeSoft, Source: SourceAPI, Meta: json.RawMessage("{}")},
+		},
+		{
+			"invalid campaign is dropped",
+			models.Bounce{SubscriberUUID: testSubUUID, CampaignUUID: "camp", Type: models.BounceTypeComplaint, Source: "ses"},
+			"",
+			models.Bounce{SubscriberUUID: testSubUUID, Type: models.BounceTypeComplaint, Source: "ses", Meta: json.RawMessage("{}")},
+		},
+		{
+			"verp overrides the headers",
+			models.Bounce{Email: "john@example.com", CampaignUUID: otherUUID, SubscriberUUID: otherUUID, Recipients: []string{"x@example.com", verpAddr},
+				Type: models.BounceTypeHard, Meta: json.RawMessage(`{"a": 1}`)},
+			"",
+			models.Bounce{Email: "john@example.com", CampaignUUID: testCampUUID, SubscriberUUID: testSubUUID, Type: models.BounceTypeHard,
+				Source: SourceAPI, Meta: json.RawMessage(`{"a": 1}`)},
+		},
+		{
+			"forged verp is ignored",
+			models.Bounce{Email: "john@example.com", CampaignUUID: otherUUID, Recipients: []string{forged}, Type: models.BounceTypeHard},
+			"",
+			models.Bounce{Email: "john@example.com", CampaignUUID: otherUUID, Type: models.BounceTypeHard, Source: SourceAPI, Meta: json.RawMessage("{}")},
+		},
+	}
+	for _, c := range cases {
+		got, err := m.validate(c.in)
+		if c.field != "" {
+			var vErr *ValidationError
+			if !errors.As(err, &vErr) || vErr.Field != c.field {
+				t.Errorf("%s: expected a validation error on %s, got %v", c.name, c.field, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.name, err)
+			continue
+		}
+
+		if got.CreatedAt.IsZero() || time.Since(got.CreatedAt) > time.Minute {
+			t.Errorf("%s: expected the creation time to default to now, got %v", c.name, got.CreatedAt)
+		}
+		if got.Email != c.exp.Email || got.SubscriberUUID != c.exp.SubscriberUUID || got.CampaignUUID != c.exp.CampaignUUID ||
+			got.Type != c.exp.Type || got.Source != c.exp.Source || string(got.Meta) != string(c.exp.Meta) {
+			t.Errorf("%s: expected %+v, got %+v", c.name, c.exp, got)
+		}
+	}
+}
+
+func TestProcessBounceDedup(t *testing.T) {
+	var (
+		recorded []models.Bounce
+		events   int
+		fail     bool
+	)
+	m, err := New(Opt{
+		RecordBounceCB: func(b models.Bounce) error {
+			if fail {
+				return errors.New("db error")
+			}
+			recorded = append(recorded, b)
+			return nil
+		},
+	}, nil, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetEventHandler(func(event string, data any) {
+		if event == models.EventBounceRecorded {
+			events++
+		}
+	})
+
+	hard := models.Bounce{Email: "john@example.com", CampaignUUID: testCampUUID, Type: models.BounceTypeHard}
+	cases := []struct {
+		name   string
+		b      models.Bounce
+		record bool
+	}{
+		{"first", hard, true},
+		{"retried webhook", hard, false},
+		{"same address in another case", models.Bounce{Email: "JOHN@example.com", CampaignUUID: testCampUUID, Type: models.BounceTypeHard}, false},
+		{"other type", models.Bounce{Email: "john@example.com", CampaignUUID: testCampUUID, Type: models.BounceTypeSoft}, true},
+		{"other campaign", models.Bounce{Email: "john@example.com", Type: models.BounceTypeHard}, true},
+		{"other subscriber", models.Bounce{Email: "jane@example.com", CampaignUUID: testCampUUID, Type: models.BounceTypeHard}, true},
+		{"by subscriber", models.Bounce{SubscriberUUID: testSubUUID, CampaignUUID: testCampUUID, Type: models.BounceTypeHard}, true},
+		{"by subscriber and email", models.Bounce{Email: "x@example.com", SubscriberUUID: testSubUUID, CampaignUUID: testCampUUID, Type: models.BounceTypeHard}, false},
+	}
+	for _, c := range cases {
+		n := len(recorded)
+		if err := m.ProcessBounce(c.b); err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.name, err)
+		}
+		if (len(recorded) > n) != c.record {
+			t.Errorf("%s: expected recorded %v, got %d bounces", c.name, c.record, len(recorded))
+		}
+	}
+	if events != len(recorded) {
+		t.Errorf("expected %d events, got %d", len(recorded), events)
+	}
+
+	// Invalid bounces aren't recorded or remembered.
+	var vErr *ValidationError
+	if err := m.ProcessBounce(models.Bounce{Email: "new@example.com", Type: "bounced"}); !errors.As(err, &vErr) {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+
+	// A bounce that fails to be recorded isn't suppressed on a retry.
+	n := len(recorded)
+	retry := models.Bounce{Email: "retry@example.com", Type: models.BounceTypeHard}
+	fail = true
+	if err := m.ProcessBounce(retry); err == nil {
+		t.Fatal("expected the record error")
+	}
+	fail = false
+	if err := m.ProcessBounce(retry); err != nil || len(recorded) != n+1 {
+		t.Fatalf("expected the retry to be recorded, got %d bounces: %v", len(recorded)-n, err)
+	}
+
+	// Bounces are recorded again after the dedup window.
+	m.recentMut.Lock()
+	for k := range m.recent {
+		m.recent[k] = time.Now().Add(-dedupWindow)
+	}
+	m.recentMut.Unlock()
+	if err := m.ProcessBounce(hard); err != nil || len(recorded) != n+2 {
+		t.Fatalf("expected the bounce to be recorded after the window, got %v", err)
+	}
+}
+
+// TestLiveCounts checks that the bounces of tracked campaigns are counted as
+// they're recorded, on top of the counts that were already in the DB.
+func TestLiveCounts(t *testing.T) {
+	var fail bool
+	m, err := New(Opt{
+		RecordBounceCB: func(b models.Bounce) error {
+			if fail {
+				return errors.New("db error")
+			}
+			return nil
+		},
+	}, nil, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const otherUUID = "4f9f6a44-0b1b-4a4e-8f4f-0d7a7c6f5e11"
+	if _, ok := m.LiveCounts(testCampUUID); ok {
+		t.Fatal("expected the campaign not to be tracked")
+	}
+	m.TrackCampaign(testCampUUID, models.BounceCounts{Hard: 1, Soft: 2, Total: 3})
+
+	for i, b := range []models.Bounce{
+		{Email: "a@example.com", CampaignUUID: testCampUUID, Type: models.BounceTypeHard},
+		{Email: "b@example.com", CampaignUUID: testCampUUID, Type: models.BounceTypeSoft},
+		{Email: "c@example.com", CampaignUUID: testCampUUID, Type: models.BounceTypeComplaint},
+		{Email: "d@example.com", CampaignUUID: testCampUUID, Type: models.BounceTypeHard},
+
+		// Other campaigns and bounces without campaigns aren't counted.
+		{Email: "e@example.com", CampaignUUID: otherUUID, Type: models.BounceTypeHard},
+		{Email: "f@example.com", Type: models.BounceTypeHard},
+
+		// Duplicates and invalid bounces aren't counted.
+		{Email: "a@example.com", CampaignUUID: testCampUUID, Type: models.BounceTypeHard},
+		{Email: "g@example.com", CampaignUUID: testCampUUID, Type: "bounced"},
+	} {
+		_ = m.ProcessBounce(b)
+
+		// Bounces are counted as soon as they're recorded.
+		if i == 0 {
+			if c, _ := m.LiveCounts(testCampUUID); c.Hard != 2 || c.Total != 4 {
+				t.Fatalf("expected the bounce to be counted, got %+v", c)
+			}
+		}
+	}
+
+	// Bounces that fail to be recorded aren't counted.
+	fail = true
+	_ = m.ProcessBounce(models.Bounce{Email: "h@example.com", CampaignUUID: testCampUUID, Type: models.BounceTypeSoft})
+
+	c, ok := m.LiveCounts(testCampUUID)
+	if exp := (models.BounceCounts{Hard: 3, Soft: 3, Complaint: 1, Total: 7}); !ok || c != exp {
+		t.Errorf("expected %+v, got %+v", exp, c)
+	}
+	if _, ok := m.LiveCounts(otherUUID); ok {
+		t.Error("expected the other campaign not to be tracked")
+	}
+
+	// Once the campaign stops, the DB counts are the source of truth.
+	m.UntrackCampaign(testCampUUID)
+	if _, ok := m.LiveCounts(testCampUUID); ok {
+		t.Error("expected the campaign to be untracked")
+	}
+
+	// Tracking again, eg: on resuming, starts over from the DB counts.
+	m.TrackCampaign(testCampUUID, models.BounceCounts{Hard: 3, Soft: 3, Complaint: 1, Total: 7})
+	fail = false
+	_ = m.ProcessBounce(models.Bounce{Email: "h@example.com", CampaignUUID: testCampUUID, Type: models.BounceTypeSoft})
+	if c, _ := m.LiveCounts(testCampUUID); c.Soft != 4 || c.Total != 8 {
+		t.Errorf("expected the counts to continue from the DB counts, got %+v", c)
+	}
+}
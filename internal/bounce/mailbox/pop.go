@@ -1,6 +1,7 @@
 package mailbox
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -18,6 +19,14 @@ import (
 type POP struct {
 	opt    Opt
 	client *pop3.Client
+
+	// Detects unsubscribe requests in human replies. nil if disabled.
+	replies *replyMatcher
+	onReply func(Reply) error
+
+	// UIDs of the human replies without unsubscribe requests that are left on
+	// the server so that they aren't downloaded again on every scan.
+	skipped map[string]struct{}
 }
 
 type bounceHeaders struct {
@@ -46,6 +55,10 @@ var (
 		{models.EmailHeaderDeliveredTo, regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderDeliveredTo + `:\s+?)(.*)`)},
 	}
 
+	// Headers that may contain the recipient address of a bounce in the order of
+	// preference. With VERP, this is the envelope sender of the original message.
+	recipientHeaders = []string{"X-Original-To", "Delivered-To", "Envelope-To", "X-Envelope-To", "To"}
+
 	reHdrReceived = regexp.MustCompile(`(?m)(?:^` + models.EmailHeaderReceived + `:\s+?)(.*)`)
 
 	// SMTP status code (5.x.x or 4.x.x) to classify hard/soft bounces.
@@ -57,8 +70,10 @@ var (
 		`bad.*address|unknown.*user|account.*disabled|address.*disabled)`)
 )
 
-// NewPOP returns a new instance of the POP mailbox client.
-func NewPOP(opt Opt) *POP {
+// NewPOP returns a new instance of the POP mailbox client. If replies are
+// enabled, onReply is called with the human replies to campaigns that request
+// an unsubscription.
+func NewPOP(opt Opt, ropt ReplyOpt, onReply func(Reply) error) *POP {
 	return &POP{
 		opt: opt,
 		client: pop3.New(pop3.Opt{
@@ -67,6 +82,9 @@ func NewPOP(opt Opt) *POP {
 			TLSEnabled:    opt.TLSEnabled,
 			TLSSkipVerify: opt.TLSSkipVerify,
 		}),
+		replies: newReplyMatcher(ropt),
+		onReply: onReply,
+		skipped: make(map[string]struct{}),
 	}
 }
 
@@ -105,6 +123,12 @@ func classifyBounce(b []byte) (string, string) {
 // Scan scans the mailbox and pushes the downloaded messages into the given channel.
 // The messages that are downloaded are deleted from the server. If limit > 0,
 // all messages on the server are downloaded and deleted.
+//
+// If replies are enabled, human replies to campaigns are not bounces. Replies
+// that request an unsubscription are passed to onReply and deleted, and the
+// other replies are left on the server untouched and don't count towards the limit.
+// If the server supports UIDL, the replies that are left on it are remembered
+// and not downloaded again on subsequent scans.
 func (p *POP) Scan(limit int, ch chan models.Bounce) error {
 	c, err := p.client.NewConn()
 	if err != nil {
@@ -130,27 +154,38 @@ func (p *POP) Scan(limit int, ch chan models.Bounce) error {
 		return nil
 	}
 
-	if limit > 0 && count > limit {
-		count = limit
-	}
+	// UIDs of the messages to skip the replies that were left on the server
+	// in the previous scans.
+	uids := p.getUIDs(c)
 
 	// Download messages.
-	for id := 1; id <= count; id++ {
+	var (
+		dele = make([]int, 0, count)
+		n    = 0
+	)
+	for id := 1; id <= count && (limit <= 0 || n < limit); id++ {
+		uid := uids[id]
+		if _, ok := p.skipped[uid]; ok && uid != "" {
+			continue
+		}
+
 		// Retrieve the raw bytes of the message.
-		b, err := c.RetrRaw(id)
+		buf, err := c.RetrRaw(id)
 		if err != nil {
 			return err
 		}
+		b := buf.Bytes()
 
 		// Parse the message.
-		m, err := message.Read(b)
+		m, err := message.Read(bytes.NewReader(b))
 		if err != nil {
 			return err
 		}
 
 		h := m
 
-		// If this is a multipart message, find the last part.
+		// If this is a multipart message, find the last part. Reading the parts
+		// consumes the body of m, which isn't used after this.
 		if mr := m.MultipartReader(); mr != nil {
 			for {
 				part, err := mr.NextPart()
@@ -163,9 +198,29 @@ func (p *POP) Scan(limit int, ch chan models.Bounce) error {
 			}
 		}
 
-		// Reset the "unread portion" pointer of the message buffer.
-		// If you don't do this, you can't read the entire body because the pointer will not point to the beginning.
-		b, _ = c.RetrRaw(id)
+		// Human replies to campaigns.
+		if p.replies != nil {
+			if r, ok := p.replies.parse(b); ok {
+				if r.Keyword == "" {
+					if uid != "" {
+						p.skipped[uid] = struct{}{}
+					}
+					continue
+				}
+
+				r.Source = p.opt.Host
+				if err := p.onReply(r); err != nil {
+					// Retry on the next scan.
+					continue
+				}
+
+				dele = append(dele, id)
+				n++
+				continue
+			}
+		}
+		dele = append(dele, id)
+		n++
 
 		// Lookup headers in the e-mail. If a header isn't found, fall back to regexp lookups.
 		hdr := make(map[string]string, 7)
@@ -174,7 +229,7 @@ func (p *POP) Scan(limit int, ch chan models.Bounce) error {
 
 			// Not in the header. Try regexp.
 			if v == "" {
-				if m := l.Regexp.FindAllSubmatch(b.Bytes(), -1); m != nil {
+				if m := l.Regexp.FindAllSubmatch(b, -1); m != nil {
 					v = string(m[len(m)-1][1])
 				}
 			}
@@ -185,20 +240,32 @@ func (p *POP) Scan(limit int, ch chan models.Bounce) error {
 		// Received is a []string header.
 		msgReceived := h.Header.Map()[models.EmailHeaderReceived]
 		if len(msgReceived) == 0 {
-			if u := reHdrReceived.FindAllSubmatch(b.Bytes(), -1); u != nil {
+			if u := reHdrReceived.FindAllSubmatch(b, -1); u != nil {
 				for i := 0; i < len(u); i++ {
 					msgReceived = append(msgReceived, string(u[i][1]))
 				}
 			}
 		}
 
+		// Recipients of the bounce message itself (top level headers).
+		var rcpts []string
+		for _, k := range recipientHeaders {
+			for _, v := range m.Header.Map()[k] {
+				for _, a := range strings.Split(v, ",") {
+					if a = strings.TrimSpace(a); a != "" {
+						rcpts = append(rcpts, a)
+					}
+				}
+			}
+		}
+
 		date, _ := time.Parse("Mon, 02 Jan 2006 15:04:05 -0700", hdr[models.EmailHeaderDate])
 		if date.IsZero() {
 			date = time.Now()
 		}
 
 		// Classify the bounce type based on message content.
-		bounceType, bounceReason := classifyBounce(b.Bytes())
+		bounceType, bounceReason := classifyBounce(b)
 
 		// Additional bounce e-mail metadata.
 		fmt.Println(bounceReason)
@@ -216,6 +283,7 @@ func (p *POP) Scan(limit int, ch chan models.Bounce) error {
 			Type:           bounceType,
 			CampaignUUID:   hdr[models.EmailHeaderCampaignUUID],
 			SubscriberUUID: hdr[models.EmailHeaderSubscriberUUID],
+			Recipients:     rcpts,
 			Source:         p.opt.Host,
 			CreatedAt:      date,
 			Meta:           meta,
@@ -225,7 +293,7 @@ func (p *POP) Scan(limit int, ch chan models.Bounce) error {
 	}
 
 	// Delete the downloaded messages.
-	for id := 1; id <= count; id++ {
+	for _, id := range dele {
 		if err := c.Dele(id); err != nil {
 			return err
 		}
@@ -233,3 +301,30 @@ func (p *POP) Scan(limit int, ch chan models.Bounce) error {
 
 	return nil
 }
+
+// getUIDs returns the UIDs of the messages on the server by their IDs and forgets
+// the skipped replies that are no longer on the server. It returns nil if the
+// server doesn't support UIDL.
+func (p *POP) getUIDs(c *pop3.Conn) map[int]string {
+	ids, err := c.Uidl(0)
+	if err != nil {
+		return nil
+	}
+
+	var (
+		out  = make(map[int]string, len(ids))
+		seen = make(map[string]struct{}, len(ids))
+	)
+	for _, m := range ids {
+		out[m.ID] = m.UID
+		seen[m.UID] = struct{}{}
+	}
+
+	for uid := range p.skipped {
+		if _, ok := seen[uid]; !ok {
+			delete(p.skipped, uid)
+		}
+	}
+
+	return out
+}
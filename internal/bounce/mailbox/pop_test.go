@@ -0,0 +1,254 @@
+package mailbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/verp"
+	"github.com/knadh/listmonk/models"
+)
+
+type popMessage struct {
+	uid string
+	raw []byte
+}
+
+// popServer is a minimal in-process POP3 server that counts the
+// downloads of each message.
+type popServer struct {
+	ln net.Listener
+
+	mut   sync.Mutex
+	msgs  []popMessage
+	retrs map[string]int
+}
+
+func newPOPServer(t *testing.T, files ...string) *popServer {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &popServer{ln: ln, retrs: make(map[string]int)}
+	for _, f := range files {
+		s.msgs = append(s.msgs, popMessage{uid: f, raw: readFixture(t, f)})
+	}
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(c)
+		}
+	}()
+
+	return s
+}
+
+func (s *popServer) serve(c net.Conn) {
+	defer c.Close()
+
+	var (
+		r    = bufio.NewReader(c)
+		dele = map[int]bool{}
+		ok   = func(f string, a ...any) { fmt.Fprintf(c, "+OK "+f+"\r\n", a...) }
+	)
+	ok("ready")
+
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		f := strings.Fields(l)
+		if len(f) == 0 {
+			continue
+		}
+		id := 0
+		if len(f) > 1 {
+			id, _ = strconv.Atoi(f[1])
+		}
+
+		s.mut.Lock()
+		switch strings.ToUpper(f[0]) {
+		case "USER", "PASS", "NOOP":
+			ok("")
+		case "STAT":
+			ok("%d 0", len(s.msgs))
+		case "UIDL":
+			ok("")
+			for i, m := range s.msgs {
+				fmt.Fprintf(c, "%d %s\r\n", i+1, m.uid)
+			}
+			fmt.Fprint(c, ".\r\n")
+		case "RETR":
+			m := s.msgs[id-1]
+			s.retrs[m.uid]++
+			ok("")
+			fmt.Fprintf(c, "%s\r\n.\r\n", strings.ReplaceAll(strings.TrimRight(string(m.raw), "\n"), "\n", "\r\n"))
+		case "DELE":
+			dele[id] = true
+			ok("")
+		case "QUIT":
+			var keep []popMessage
+			for i, m := range s.msgs {
+				if !dele[i+1] {
+					keep = append(keep, m)
+				}
+			}
+			s.msgs = keep
+			ok("")
+			s.mut.Unlock()
+			return
+		default:
+			fmt.Fprint(c, "-ERR unknown command\r\n")
+		}
+		s.mut.Unlock()
+	}
+}
+
+func (s *popServer) uids() []string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	out := make([]string, len(s.msgs))
+	for i, m := range s.msgs {
+		out[i] = m.uid
+	}
+	return out
+}
+
+func TestPOPScanReplies(t *testing.T) {
+	srv := newPOPServer(t, "reply-en.eml", "reply-en-quoted.eml", "bounce.eml", "reply-de.eml", "reply-de-auto.eml")
+	port := srv.ln.Addr().(*net.TCPAddr).Port
+
+	var (
+		replies []Reply
+		failed  bool
+	)
+	p := NewPOP(Opt{Host: "127.0.0.1", Port: port, AuthProtocol: "none"}, testReplyOpt, func(r Reply) error {
+		// Fail the German request once to have it retried on the next scan.
+		if r.Lang == "de" && !failed {
+			failed = true
+			return errors.New("retry")
+		}
+		replies = append(replies, r)
+		return nil
+	})
+
+	ch := make(chan models.Bounce, 10)
+	if err := p.Scan(0, ch); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ch) != 1 {
+		t.Fatalf("expected 1 bounce, got %d", len(ch))
+	}
+	if b := <-ch; b.Type != models.BounceTypeHard || b.CampaignUUID != testCampUUID {
+		t.Errorf("unexpected bounce %+v", b)
+	}
+
+	// The bounce and the processed request are deleted, and the failed request and
+	// the replies without requests are left on the server.
+	if got, exp := fmt.Sprint(srv.uids()), "[reply-en-quoted.eml reply-de.eml reply-de-auto.eml]"; got != exp {
+		t.Fatalf("expected %s on the server, got %s", exp, got)
+	}
+
+	// The replies without requests aren't downloaded again and the failed one is retried.
+	if err := p.Scan(0, ch); err != nil {
+		t.Fatal(err)
+	}
+	if got, exp := fmt.Sprint(srv.uids()), "[reply-en-quoted.eml reply-de-auto.eml]"; got != exp {
+		t.Fatalf("expected %s on the server, got %s", exp, got)
+	}
+	for uid, n := range map[string]int{"reply-en.eml": 1, "reply-en-quoted.eml": 1, "bounce.eml": 1, "reply-de.eml": 2, "reply-de-auto.eml": 1} {
+		if srv.retrs[uid] != n {
+			t.Errorf("%s: expected %d downloads, got %d", uid, n, srv.retrs[uid])
+		}
+	}
+
+	if len(replies) != 2 || replies[0].Keyword != "remove me" || replies[1].Keyword != "abmelden" {
+		t.Fatalf("unexpected replies %+v", replies)
+	}
+	if replies[1].Source != "127.0.0.1" || replies[1].From != "Max Mustermann <max@example.de>" {
+		t.Errorf("unexpected reply %+v", replies[1])
+	}
+}
+
+// TestPOPScanVERP scans a DSN whose original headers are stripped and that's
+// addressed to the VERP envelope sender of the original message.
+func TestPOPScanVERP(t *testing.T) {
+	v, err := verp.New(verp.Opt{Domain: "bounces.example.com", Prefix: "bounce", Scheme: verp.SchemeCompact, Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := v.Encode(testCampUUID, testSubUUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := newPOPServer(t)
+	srv.msgs = append(srv.msgs, popMessage{uid: "dsn", raw: []byte(`From: Mail Delivery System <MAILER-DAEMON@mx.example.com>
+X-Original-To: ` + strings.ToUpper(addr) + `
+Delivered-To: inbox@bounces.example.com
+To: "Bounces" <` + addr + `>, other@example.com
+Subject: Undelivered Mail Returned to Sender
+Date: Mon, 12 Oct 2026 10:15:00 +0000
+MIME-Version: 1.0
+Content-Type: multipart/report; report-type=delivery-status; boundary="b1"
+
+--b1
+Content-Type: text/plain; charset=utf-8
+
+Your message could not be delivered.
+
+--b1
+Content-Type: message/delivery-status
+
+Reporting-MTA: dns; mx.example.com
+Final-Recipient: rfc822; john@example.com
+Action: failed
+Status: 5.1.1
+
+--b1--
+`)})
+	port := srv.ln.Addr().(*net.TCPAddr).Port
+
+	p := NewPOP(Opt{Host: "127.0.0.1", Port: port, AuthProtocol: "none"}, ReplyOpt{}, nil)
+	ch := make(chan models.Bounce, 10)
+	if err := p.Scan(0, ch); err != nil {
+		t.Fatal(err)
+	}
+	if len(ch) != 1 {
+		t.Fatalf("expected 1 bounce, got %d", len(ch))
+	}
+
+	b := <-ch
+	if b.Type != models.BounceTypeHard || b.CampaignUUID != "" || b.SubscriberUUID != "" {
+		t.Errorf("unexpected bounce %+v", b)
+	}
+
+	// The recipients are in the order of preference of the headers.
+	exp := []string{strings.ToUpper(addr), "inbox@bounces.example.com", `"Bounces" <` + addr + `>`, "other@example.com"}
+	if !slices.Equal(b.Recipients, exp) {
+		t.Fatalf("expected the recipients %v, got %v", exp, b.Recipients)
+	}
+
+	// The UUIDs are recovered from the recipient.
+	if c, s, ok := v.Decode(b.Recipients[0]); !ok || c != testCampUUID || s != testSubUUID {
+		t.Errorf("expected the UUIDs from the recipient, got %s %s %v", c, s, ok)
+	}
+}
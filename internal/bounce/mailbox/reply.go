@@ -0,0 +1,276 @@
+package mailbox
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/emersion/go-message"
+	"github.com/knadh/listmonk/models"
+)
+
+// Maximum number of characters of a reply's own text (without the quoted
+// original message) that are checked for commands.
+const maxReplyText = 1000
+
+// ReplyOpt represents the options for processing human replies to campaigns
+// that land in the mailbox.
+type ReplyOpt struct {
+	Enabled bool `json:"enabled"`
+
+	// Keywords and phrases that express the intent to unsubscribe by language,
+	// eg: {"en": ["unsubscribe"], "de": ["abmelden"]}.
+	Keywords map[string][]string `json:"keywords"`
+}
+
+// Reply represents a human reply to a campaign with an unsubscribe request.
+type Reply struct {
+	CampaignUUID   string
+	SubscriberUUID string
+	From           string
+	MessageID      string
+
+	// The language and the keyword that matched.
+	Lang    string
+	Keyword string
+
+	// The host of the mailbox.
+	Source string
+}
+
+type replyKeyword struct {
+	lang    string
+	keyword string
+	re      *regexp.Regexp
+}
+
+// replyMatcher detects unsubscribe requests in human replies.
+type replyMatcher struct {
+	keywords []replyKeyword
+}
+
+var (
+	// Report content types of DSNs (bounces) and ARFs (complaints).
+	reportTypes = []string{"message/delivery-status", "message/global-delivery-status", "message/feedback-report"}
+
+	// Reply and forward prefixes in subjects, eg: "Re: ", "AW: ", "Fwd: ".
+	reSubjectPrefix = regexp.MustCompile(`(?i)^\s*((re|aw|sv|wg|fw|fwd)\s*(\[\d+\])?\s*:\s*)+`)
+
+	// Lines that mark the start of the quoted original message in replies.
+	reQuoteHeader = regexp.MustCompile(`(?i)^\s*(` +
+		`(on|am) .+ (wrote|schrieb)( .+)?:\s*$|` +
+		`-+\s*(original message|ursprüngliche nachricht|forwarded message|weitergeleitete nachricht)\s*-+|` +
+		`(from|von):\s+.+@.+)`)
+
+	reHTMLTag  = regexp.MustCompile(`(?s)<(style|script)[^>]*>.*?</(style|script)>|<[^>]+>`)
+	reSpaces   = regexp.MustCompile(`\s+`)
+	reUUIDText = regexp.MustCompile(`^[0-9a-fA-F\-]{36}$`)
+)
+
+// newReplyMatcher returns a matcher for the given options. It returns nil
+// if reply processing is disabled or there are no keywords.
+func newReplyMatcher(o ReplyOpt) *replyMatcher {
+	if !o.Enabled {
+		return nil
+	}
+
+	langs := make([]string, 0, len(o.Keywords))
+	for l := range o.Keywords {
+		langs = append(langs, l)
+	}
+	sort.Strings(langs)
+
+	r := &replyMatcher{}
+	for _, l := range langs {
+		for _, k := range o.Keywords[l] {
+			k = reSpaces.ReplaceAllString(strings.TrimSpace(k), " ")
+			if k == "" {
+				continue
+			}
+
+			// Match whole words and phrases, case insensitively, with any whitespace between words.
+			p := strings.ReplaceAll(regexp.QuoteMeta(k), " ", `\s+`)
+			r.keywords = append(r.keywords, replyKeyword{
+				lang:    l,
+				keyword: k,
+				re:      regexp.MustCompile(`(?i)(^|[^\p{L}\p{N}])` + p + `($|[^\p{L}\p{N}])`),
+			})
+		}
+	}
+
+	if len(r.keywords) == 0 {
+		return nil
+	}
+
+	return r
+}
+
+// parse checks whether a raw message is a human reply to a campaign, and if it
+// is, returns the reply with the matched keyword, if any. DSNs, ARFs, and other
+// messages that aren't replies are left to bounce processing.
+func (r *replyMatcher) parse(raw []byte) (Reply, bool) {
+	// The parts of a message can only be read once, so the report check
+	// and the text lookup use their own instances.
+	m, err := message.Read(bytes.NewReader(raw))
+	if err != nil || isReport(m) {
+		return Reply{}, false
+	}
+	if m, err = message.Read(bytes.NewReader(raw)); err != nil {
+		return Reply{}, false
+	}
+
+	var (
+		inReplyTo = m.Header.Get("In-Reply-To")
+		refs      = m.Header.Get("References")
+	)
+	if inReplyTo == "" && refs == "" {
+		return Reply{}, false
+	}
+
+	out := Reply{
+		From:      m.Header.Get(models.EmailHeaderFrom),
+		MessageID: strings.TrimSpace(m.Header.Get(models.EmailHeaderMessageId)),
+	}
+
+	// Resolve the campaign and subscriber from the Message-Id of the original
+	// message, or the listmonk headers embedded in the quoted or attached original.
+	if c, s, ok := models.ParseMessageID(inReplyTo); ok {
+		out.CampaignUUID, out.SubscriberUUID = c, s
+	} else if c, s, ok := models.ParseMessageID(refs); ok {
+		out.CampaignUUID, out.SubscriberUUID = c, s
+	} else {
+		out.CampaignUUID = lookupEmbeddedHeader(raw, models.EmailHeaderCampaignUUID)
+		out.SubscriberUUID = lookupEmbeddedHeader(raw, models.EmailHeaderSubscriberUUID)
+	}
+
+	// Automatic replies, eg: out-of-office messages, are never commands.
+	if a := strings.ToLower(strings.TrimSpace(m.Header.Get("Auto-Submitted"))); a != "" && a != "no" {
+		return out, true
+	}
+
+	subject, _ := m.Header.Text(models.EmailHeaderSubject)
+	subject = reSubjectPrefix.ReplaceAllString(subject, "")
+	text := subject + "\n" + replyText(m)
+	for _, k := range r.keywords {
+		if k.re.MatchString(text) {
+			out.Lang, out.Keyword = k.lang, k.keyword
+			break
+		}
+	}
+
+	return out, true
+}
+
+// isReport checks whether a message is a DSN or an ARF report.
+func isReport(m *message.Entity) bool {
+	typ, _, _ := m.Header.ContentType()
+	if typ == "multipart/report" || slices.Contains(reportTypes, typ) {
+		return true
+	}
+
+	mr := m.MultipartReader()
+	if mr == nil {
+		return false
+	}
+
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			return false
+		}
+
+		if t, _, _ := p.Header.ContentType(); slices.Contains(reportTypes, t) {
+			return true
+		}
+	}
+}
+
+// replyText returns the reply's own text from its first text/plain part, or
+// text/html part with the tags removed, without the quoted original message.
+func replyText(m *message.Entity) string {
+	var plain, html string
+	_ = m.Walk(func(path []int, p *message.Entity, err error) error {
+		if err != nil {
+			return err
+		}
+
+		typ, _, _ := p.Header.ContentType()
+		if disp, _, _ := p.Header.ContentDisposition(); disp == "attachment" {
+			return nil
+		}
+
+		switch {
+		case typ == "text/plain" && plain == "":
+			b, _ := io.ReadAll(io.LimitReader(p.Body, 1<<20))
+			plain = string(b)
+		case typ == "text/html" && html == "":
+			b, _ := io.ReadAll(io.LimitReader(p.Body, 1<<20))
+			html = string(b)
+		}
+		return nil
+	})
+
+	text := plain
+	if text == "" && html != "" {
+		h := strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n", "</p>", "\n", "</div>", "\n").Replace(html)
+
+		// Cut off the quoted original message of common clients.
+		if i := strings.Index(strings.ToLower(h), "<blockquote"); i >= 0 {
+			h = h[:i]
+		}
+		text = reHTMLTag.ReplaceAllString(h, " ")
+	}
+
+	return stripQuoted(text)
+}
+
+// stripQuoted returns the text before the quoted original message in a reply
+// without quoted lines, truncated to maxReplyText characters.
+func stripQuoted(s string) string {
+	var b strings.Builder
+	for _, l := range strings.Split(s, "\n") {
+		if reQuoteHeader.MatchString(l) {
+			break
+		}
+		if strings.HasPrefix(strings.TrimSpace(l), ">") {
+			continue
+		}
+
+		b.WriteString(l)
+		b.WriteString("\n")
+	}
+
+	out := []rune(b.String())
+	if len(out) > maxReplyText {
+		out = out[:maxReplyText]
+	}
+
+	return string(out)
+}
+
+// lookupEmbeddedHeader returns the UUID value of the given listmonk header in the
+// body of a message, eg: in an attached or quoted original message.
+func lookupEmbeddedHeader(raw []byte, hdr string) string {
+	for _, l := range headerLookups {
+		if l.Header != hdr {
+			continue
+		}
+
+		m := l.Regexp.FindAllSubmatch(raw, -1)
+		if m == nil {
+			return ""
+		}
+
+		v := string(bytes.TrimSpace(m[len(m)-1][1]))
+		if !reUUIDText.MatchString(v) {
+			return ""
+		}
+
+		return strings.ToLower(v)
+	}
+
+	return ""
+}
@@ -0,0 +1,91 @@
+package mailbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testCampUUID = "2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11"
+	testSubUUID  = "7c1e0b7e-3f38-4b47-9f6a-0b1e2d3c4f5a"
+)
+
+// testReplyOpt are the default reply options in the settings.
+var testReplyOpt = ReplyOpt{
+	Enabled: true,
+	Keywords: map[string][]string{
+		"en": {"unsubscribe", "remove me", "opt out"},
+		"de": {"abmelden", "abbestellen", "austragen"},
+	},
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestParseReply(t *testing.T) {
+	r := newReplyMatcher(testReplyOpt)
+
+	cases := []struct {
+		file    string
+		isReply bool
+		lang    string
+		keyword string
+		from    string
+	}{
+		// Unsubscribe requests attributed by In-Reply-To.
+		{"reply-en.eml", true, "en", "remove me", "John Doe <John@Example.com>"},
+
+		// HTML replies attributed by References with the quoted message cut off.
+		{"reply-de.eml", true, "de", "abmelden", "Max Mustermann <max@example.de>"},
+
+		// Replies attributed by the headers of the quoted original message.
+		{"reply-de-embedded.eml", true, "de", "austragen", "Max Mustermann <max@example.de>"},
+
+		// Keywords in the quoted original message aren't requests.
+		{"reply-en-quoted.eml", true, "", "", "John Doe <john@example.com>"},
+
+		// Automatic replies are never requests.
+		{"reply-de-auto.eml", true, "", "", "Max Mustermann <max@example.de>"},
+
+		// Bounces aren't replies.
+		{"bounce.eml", false, "", "", ""},
+	}
+
+	for _, c := range cases {
+		out, ok := r.parse(readFixture(t, c.file))
+		if ok != c.isReply {
+			t.Errorf("%s: expected reply %v, got %v", c.file, c.isReply, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		if out.Lang != c.lang || out.Keyword != c.keyword {
+			t.Errorf("%s: expected %s/%q, got %s/%q", c.file, c.lang, c.keyword, out.Lang, out.Keyword)
+		}
+		if out.CampaignUUID != testCampUUID || out.SubscriberUUID != testSubUUID {
+			t.Errorf("%s: unexpected attribution %s %s", c.file, out.CampaignUUID, out.SubscriberUUID)
+		}
+		if out.From != c.from {
+			t.Errorf("%s: expected from %q, got %q", c.file, c.from, out.From)
+		}
+	}
+}
+
+func TestReplyMatcherDisabled(t *testing.T) {
+	if newReplyMatcher(ReplyOpt{Keywords: testReplyOpt.Keywords}) != nil {
+		t.Error("expected no matcher when replies are disabled")
+	}
+	if newReplyMatcher(ReplyOpt{Enabled: true, Keywords: map[string][]string{"en": {" ", ""}}}) != nil {
+		t.Error("expected no matcher without keywords")
+	}
+}
@@ -0,0 +1,125 @@
+package webhooks
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Providers whose webhook requests can be restricted by source IP.
+var AllowlistProviders = []string{"ses", "sendgrid", "postmark", "forwardemail"}
+
+// AllowlistOpt represents the source IP allowlist options of the webhooks.
+type AllowlistOpt struct {
+	// AuditOnly logs requests from sources that aren't allowed but processes
+	// them anyway. This is meant to be used while rolling out the allowlist.
+	AuditOnly bool `json:"audit_only"`
+
+	// Reverse proxies (IPs or CIDRs) whose X-Forwarded-For headers are trusted
+	// for finding the client IP. If it's empty, X-Forwarded-For is ignored.
+	TrustedProxies []string `json:"trusted_proxies"`
+
+	// CIDRs (or IPs) allowed to post to the webhook of each provider. Providers
+	// without CIDRs aren't restricted.
+	AllowedCIDRs map[string][]string `json:"allowed_cidrs"`
+}
+
+// Allowlist restricts the source IPs of webhook requests by provider.
+type Allowlist struct {
+	AuditOnly bool
+
+	nets      map[string][]*net.IPNet
+	extractIP echo.IPExtractor
+}
+
+// NewAllowlist returns a new Allowlist. It returns an error if a provider is
+// unknown or a CIDR is invalid.
+func NewAllowlist(o AllowlistOpt) (*Allowlist, error) {
+	extractIP, err := NewIPExtractor(o.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Allowlist{
+		AuditOnly: o.AuditOnly,
+		nets:      make(map[string][]*net.IPNet, len(o.AllowedCIDRs)),
+		extractIP: extractIP,
+	}
+	for prov, cidrs := range o.AllowedCIDRs {
+		if !slices.Contains(AllowlistProviders, prov) {
+			return nil, fmt.Errorf("unknown provider '%s'", prov)
+		}
+
+		for _, c := range cidrs {
+			n, err := ParseCIDR(c)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR '%s' for %s", c, prov)
+			}
+			a.nets[prov] = append(a.nets[prov], n)
+		}
+	}
+
+	return a, nil
+}
+
+// NewIPExtractor returns an echo.IPExtractor that finds the client IP of a request
+// via the given trusted reverse proxies (IPs or CIDRs). Only the trusted proxies
+// are skipped when walking X-Forwarded-For. Without them, the client IP is always
+// the direct peer and the header can't be spoofed.
+func NewIPExtractor(trustedProxies []string) (echo.IPExtractor, error) {
+	trust := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, p := range trustedProxies {
+		n, err := ParseCIDR(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy '%s'", p)
+		}
+		trust = append(trust, echo.TrustIPRange(n))
+	}
+
+	return echo.ExtractIPFromXFFHeader(trust...), nil
+}
+
+// ParseCIDR parses a CIDR, eg: 10.0.0.0/8, or a single IP as a /32 (or /128) network.
+func ParseCIDR(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		ip := net.ParseIP(s)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP '%s'", s)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+
+	_, n, err := net.ParseCIDR(s)
+	return n, err
+}
+
+// Allowed checks whether a request to a provider's webhook is from an allowed
+// source IP. It returns the client IP that was checked.
+func (a *Allowlist) Allowed(provider string, r *http.Request) (string, bool) {
+	ip := a.extractIP(r)
+
+	nets, ok := a.nets[provider]
+	if !ok || len(nets) == 0 {
+		return ip, true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return ip, false
+	}
+	for _, n := range nets {
+		if n.Contains(addr) {
+			return ip, true
+		}
+	}
+
+	return ip, false
+}
@@ -0,0 +1,95 @@
+package webhooks
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseCIDR(t *testing.T) {
+	cases := []struct {
+		in  string
+		exp string
+	}{
+		{"10.0.0.0/8", "10.0.0.0/8"},
+		{"10.1.2.3/8", "10.0.0.0/8"},
+		{" 1.2.3.4 ", "1.2.3.4/32"},
+		{"2001:db8::1", "2001:db8::1/128"},
+		{"2001:db8::/32", "2001:db8::/32"},
+	}
+	for _, c := range cases {
+		n, err := ParseCIDR(c.in)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", c.in, err)
+			continue
+		}
+		if n.String() != c.exp {
+			t.Errorf("%s: expected %s, got %s", c.in, c.exp, n)
+		}
+	}
+
+	for _, in := range []string{"", "1.2.3", "10.0.0.0/33", "example.com"} {
+		if _, err := ParseCIDR(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
+
+func TestNewAllowlist(t *testing.T) {
+	cases := []struct {
+		name string
+		opt  AllowlistOpt
+	}{
+		{"unknown provider", AllowlistOpt{AllowedCIDRs: map[string][]string{"mailgun": {"1.2.3.4"}}}},
+		{"invalid CIDR", AllowlistOpt{AllowedCIDRs: map[string][]string{"ses": {"1.2.3.4/40"}}}},
+		{"invalid proxy", AllowlistOpt{TrustedProxies: []string{"proxy"}}},
+	}
+	for _, c := range cases {
+		if _, err := NewAllowlist(c.opt); err == nil {
+			t.Errorf("%s: expected an error", c.name)
+		}
+	}
+}
+
+func TestAllowlistAllowed(t *testing.T) {
+	a, err := NewAllowlist(AllowlistOpt{
+		TrustedProxies: []string{"10.0.0.1"},
+		AllowedCIDRs: map[string][]string{
+			"ses":      {"54.240.0.0/18", "2001:db8::/32"},
+			"postmark": {},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		provider string
+		remote   string
+		xff      string
+		ip       string
+		ok       bool
+	}{
+		{"allowed peer", "ses", "54.240.1.1:1234", "", "54.240.1.1", true},
+		{"allowed IPv6 peer", "ses", "[2001:db8::5]:1234", "", "2001:db8::5", true},
+		{"other peer", "ses", "1.2.3.4:1234", "", "1.2.3.4", false},
+		{"spoofed header from an untrusted peer", "ses", "1.2.3.4:1234", "54.240.1.1", "1.2.3.4", false},
+		{"allowed client via the trusted proxy", "ses", "10.0.0.1:1234", "54.240.1.1", "54.240.1.1", true},
+		{"other client via the trusted proxy", "ses", "10.0.0.1:1234", "1.2.3.4", "1.2.3.4", false},
+		{"spoofed header via the trusted proxy", "ses", "10.0.0.1:1234", "54.240.1.1, 1.2.3.4", "1.2.3.4", false},
+		{"provider without CIDRs", "postmark", "1.2.3.4:1234", "", "1.2.3.4", true},
+		{"provider without a list", "sendgrid", "1.2.3.4:1234", "", "1.2.3.4", true},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "/webhooks/service/"+c.provider, nil)
+		r.RemoteAddr = c.remote
+		if c.xff != "" {
+			r.Header.Set("X-Forwarded-For", c.xff)
+		}
+
+		ip, ok := a.Allowed(c.provider, r)
+		if ip != c.ip || ok != c.ok {
+			t.Errorf("%s: expected %s (%v), got %s (%v)", c.name, c.ip, c.ok, ip, ok)
+		}
+	}
+}
@@ -40,27 +40,16 @@ type forwardemailNotif struct {
 // Forwardemail handles webhook notifications (mainly bounce notifications).
 type Forwardemail struct {
 	hmacKey []byte
+	opt     Opt
 }
 
-func NewForwardemail(key []byte) *Forwardemail {
-	return &Forwardemail{hmacKey: key}
+func NewForwardemail(key []byte, o Opt) *Forwardemail {
+	return &Forwardemail{hmacKey: key, opt: o}
 }
 
 func (p *Forwardemail) ProcessBounce(sigHex string, body []byte) ([]models.Bounce, error) {
-	// Decode the hex-encoded signature from the webhook
-	sig, err := hex.DecodeString(sigHex)
-	if err != nil {
-		return nil, fmt.Errorf("invalid signature encoding: %v", err)
-	}
-
-	// Generate HMAC using the request body and secret key
-	mac := hmac.New(sha256.New, p.hmacKey)
-	mac.Write(body)
-	expectedSignature := mac.Sum(nil)
-
-	// Compare the generated signature with the provided signature
-	if !hmac.Equal(expectedSignature, sig) {
-		return nil, errors.New("invalid signature")
+	if err := p.opt.check("forwardemail", p.verify(sigHex, body)); err != nil {
+		return nil, err
 	}
 
 	// Parse the JSON payload
@@ -93,3 +82,24 @@ func (p *Forwardemail) ProcessBounce(sigHex string, body []byte) ([]models.Bounc
 		CreatedAt:    n.BouncedAt,
 	}}, nil
 }
+
+// verify verifies the HMAC signature of a notification payload.
+func (p *Forwardemail) verify(sigHex string, body []byte) error {
+	// Decode the hex-encoded signature from the webhook
+	sig, err := hex.DecodeString(sigHex)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding: %v", err)
+	}
+
+	// Generate HMAC using the request body and secret key
+	mac := hmac.New(sha256.New, p.hmacKey)
+	mac.Write(body)
+	expectedSignature := mac.Sum(nil)
+
+	// Compare the generated signature with the provided signature
+	if !hmac.Equal(expectedSignature, sig) {
+		return errors.New("invalid signature")
+	}
+
+	return nil
+}
@@ -3,13 +3,13 @@ package webhooks
 import (
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
 )
 
 type postmarkNotif struct {
@@ -37,21 +37,29 @@ type postmarkNotif struct {
 
 // Postmark handles webhook notifications (mainly bounce notifications).
 type Postmark struct {
-	authHandler echo.HandlerFunc
+	username []byte
+	password []byte
+	opt      Opt
 }
 
-func NewPostmark(username, password string) *Postmark {
-	return &Postmark{
-		authHandler: middleware.BasicAuth(makePostmarkAuthHandler(username, password))(func(c echo.Context) error {
-			return nil
-		}),
+// NewPostmark returns a new Postmark instance. Postmark doesn't sign its webhooks,
+// so the basic auth credentials are required to authenticate requests.
+func NewPostmark(username, password string, o Opt) (*Postmark, error) {
+	if username == "" || password == "" {
+		return nil, errors.New("postmark basic auth username and password are required")
 	}
+
+	return &Postmark{
+		username: []byte(username),
+		password: []byte(password),
+		opt:      o,
+	}, nil
 }
 
 // ProcessBounce processes Postmark bounce notifications and returns one object.
 func (p *Postmark) ProcessBounce(b []byte, c echo.Context) ([]models.Bounce, error) {
 	// Do basicauth.
-	if err := p.authHandler(c); err != nil {
+	if err := p.opt.check("postmark", p.verify(c)); err != nil {
 		return nil, err
 	}
 
@@ -98,21 +106,16 @@ func (p *Postmark) ProcessBounce(b []byte, c echo.Context) ([]models.Bounce, err
 	}}, nil
 }
 
-func makePostmarkAuthHandler(cfgUser, cfgPassword string) func(username, password string, c echo.Context) (bool, error) {
-	var (
-		u = []byte(cfgUser)
-		p = []byte(cfgPassword)
-	)
-
-	return func(username, password string, c echo.Context) (bool, error) {
-		if len(u) == 0 || len(p) == 0 {
-			return true, nil
-		}
-
-		if subtle.ConstantTimeCompare([]byte(username), u) == 1 && subtle.ConstantTimeCompare([]byte(password), p) == 1 {
-			return true, nil
-		}
+// verify checks the request's basic auth credentials.
+func (p *Postmark) verify(c echo.Context) error {
+	user, pass, ok := c.Request().BasicAuth()
+	if !ok {
+		return errors.New("missing basic auth credentials")
+	}
 
-		return false, nil
+	if subtle.ConstantTimeCompare([]byte(user), p.username) != 1 || subtle.ConstantTimeCompare([]byte(pass), p.password) != 1 {
+		return errors.New("invalid basic auth credentials")
 	}
+
+	return nil
 }
@@ -0,0 +1,97 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+const postmarkTestBody = `{"RecordType":"Bounce","Type":"HardBounce","Email":"User@Example.com",` +
+	`"BouncedAt":"2026-01-01T12:00:00Z","Metadata":{"X-Listmonk-Campaign":"2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11"}}`
+
+func postmarkContext(user, pass string) echo.Context {
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/service/postmark", strings.NewReader(postmarkTestBody))
+	if user != "" || pass != "" {
+		req.SetBasicAuth(user, pass)
+	}
+	return echo.New().NewContext(req, httptest.NewRecorder())
+}
+
+func TestNewPostmark(t *testing.T) {
+	for _, c := range [][2]string{{"", ""}, {"user", ""}, {"", "pass"}} {
+		if _, err := NewPostmark(c[0], c[1], Opt{}); err == nil {
+			t.Errorf("%q: expected empty credentials to be refused", c)
+		}
+	}
+}
+
+func TestPostmarkProcessBounce(t *testing.T) {
+	var failed int
+	p, err := NewPostmark("user", "pass", Opt{OnUnverified: func(string, error) { failed++ }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := p.ProcessBounce([]byte(postmarkTestBody), postmarkContext("user", "pass"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bs) != 1 || bs[0].Email != "user@example.com" || bs[0].Type != models.BounceTypeHard ||
+		bs[0].CampaignUUID != "2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11" {
+		t.Fatalf("unexpected bounces %+v", bs)
+	}
+
+	cases := []struct {
+		name, user, pass string
+	}{
+		{"no credentials", "", ""},
+		{"wrong user", "admin", "pass"},
+		{"wrong password", "user", "password"},
+		{"empty password", "user", ""},
+	}
+	for _, c := range cases {
+		if _, err := p.ProcessBounce([]byte(postmarkTestBody), postmarkContext(c.user, c.pass)); !errors.Is(err, ErrUnverified) {
+			t.Errorf("%s: expected ErrUnverified, got %v", c.name, err)
+		}
+	}
+	if failed != len(cases) {
+		t.Errorf("expected %d failures to be reported, got %d", len(cases), failed)
+	}
+}
+
+func TestPostmarkLogOnly(t *testing.T) {
+	var failed int
+	p, err := NewPostmark("user", "pass", Opt{LogOnly: true, OnUnverified: func(string, error) { failed++ }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bs, err := p.ProcessBounce([]byte(postmarkTestBody), postmarkContext("user", "wrong"))
+	if err != nil || len(bs) != 1 {
+		t.Fatalf("expected the notification to be processed, got %+v %v", bs, err)
+	}
+	if failed != 1 {
+		t.Fatalf("expected the failure to be reported, got %d", failed)
+	}
+}
+
+func TestPostmarkComplaint(t *testing.T) {
+	p, err := NewPostmark("user", "pass", Opt{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `{"RecordType":"SpamComplaint","Type":"SpamComplaint","Email":"Spam@Example.com","BouncedAt":"2026-01-01T12:00:00Z"}`
+	bs, err := p.ProcessBounce([]byte(body), postmarkContext("user", "pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bs) != 1 || bs[0].Email != "spam@example.com" || bs[0].Type != models.BounceTypeComplaint {
+		t.Errorf("expected a complaint, got %+v", bs)
+	}
+}
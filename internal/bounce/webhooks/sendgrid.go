@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"strconv"
 	"strings"
 	"time"
 
@@ -31,10 +32,11 @@ type sendgridNotif struct {
 // requests and bounce notifications.
 type Sendgrid struct {
 	pubKey *ecdsa.PublicKey
+	opt    Opt
 }
 
 // NewSendgrid returns a new Sendgrid instance.
-func NewSendgrid(key string) (*Sendgrid, error) {
+func NewSendgrid(key string, o Opt) (*Sendgrid, error) {
 	// Get the certificate from the key.
 	sigB, err := base64.StdEncoding.DecodeString(key)
 	if err != nil {
@@ -46,12 +48,17 @@ func NewSendgrid(key string) (*Sendgrid, error) {
 		return nil, err
 	}
 
-	return &Sendgrid{pubKey: pubKey.(*ecdsa.PublicKey)}, nil
+	pk, ok := pubKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, errors.New("sendgrid key is not an ECDSA public key")
+	}
+
+	return &Sendgrid{pubKey: pk, opt: o}, nil
 }
 
 // ProcessBounce processes Sendgrid bounce notifications and returns one or more Bounce objects.
 func (s *Sendgrid) ProcessBounce(sig, timestamp string, b []byte) ([]models.Bounce, error) {
-	if err := s.verifyNotif(sig, timestamp, b); err != nil {
+	if err := s.opt.check("sendgrid", s.verifyNotif(sig, timestamp, b)); err != nil {
 		return nil, err
 	}
 
@@ -62,15 +69,19 @@ func (s *Sendgrid) ProcessBounce(sig, timestamp string, b []byte) ([]models.Boun
 
 	out := make([]models.Bounce, 0, len(notifs))
 	for _, n := range notifs {
-		if n.Event != "bounce" {
+		var typ string
+		switch n.Event {
+		case "bounce":
+			typ = models.BounceTypeHard
+			if n.BounceClassification == "technical" || n.BounceClassification == "content" {
+				typ = models.BounceTypeSoft
+			}
+		case "spamreport":
+			typ = models.BounceTypeComplaint
+		default:
 			continue
 		}
 
-		typ := models.BounceTypeHard
-		if n.BounceClassification == "technical" || n.BounceClassification == "content" {
-			typ = models.BounceTypeSoft
-		}
-
 		tstamp := time.Unix(n.Timestamp, 0)
 		bn := models.Bounce{
 			CampaignUUID: n.CampaignUUID,
@@ -86,7 +97,8 @@ func (s *Sendgrid) ProcessBounce(sig, timestamp string, b []byte) ([]models.Boun
 	return out, nil
 }
 
-// verifyNotif verifies the signature on a notification payload.
+// verifyNotif verifies the signature on a notification payload and rejects
+// notifications that are too old to prevent replays.
 func (s *Sendgrid) verifyNotif(sig, timestamp string, b []byte) error {
 	sigB, err := base64.StdEncoding.DecodeString(sig)
 	if err != nil {
@@ -111,5 +123,11 @@ func (s *Sendgrid) verifyNotif(sig, timestamp string, b []byte) error {
 		return errors.New("invalid signature")
 	}
 
-	return nil
+	// The timestamp is a part of the signed payload.
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp: %v", err)
+	}
+
+	return checkTimestamp(time.Unix(ts, 0))
 }
@@ -0,0 +1,129 @@
+package webhooks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const sendgridTestBody = `[{"email":"User@Example.com","timestamp":1767268800,"event":"bounce","bounce_classification":"invalid","XListmonkCampaign":"2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11"},` +
+	`{"email":"other@example.com","timestamp":1767268800,"event":"delivered"},` +
+	`{"email":"spam@example.com","timestamp":1767268800,"event":"spamreport"}]`
+
+// newTestSendgrid returns a Sendgrid instance with a new verification key and a
+// func that signs payloads with it.
+func newTestSendgrid(t *testing.T, o Opt) (*Sendgrid, func(ts string, b []byte) string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSendgrid(base64.StdEncoding.EncodeToString(der), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sign := func(ts string, b []byte) string {
+		h := sha256.Sum256(append([]byte(ts), b...))
+		sig, err := ecdsa.SignASN1(rand.Reader, key, h[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.StdEncoding.EncodeToString(sig)
+	}
+
+	return s, sign
+}
+
+func TestNewSendgrid(t *testing.T) {
+	for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("not a key"))} {
+		if _, err := NewSendgrid(key, Opt{}); err == nil {
+			t.Errorf("%q: expected an error", key)
+		}
+	}
+}
+
+func TestSendgridProcessBounce(t *testing.T) {
+	s, sign := newTestSendgrid(t, Opt{})
+
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	bs, err := s.ProcessBounce(sign(ts, []byte(sendgridTestBody)), ts, []byte(sendgridTestBody))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(bs) != 2 {
+		t.Fatalf("expected 2 bounces, got %d", len(bs))
+	}
+	if b := bs[0]; b.Email != "user@example.com" || b.Type != models.BounceTypeHard || b.CampaignUUID != "2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11" {
+		t.Errorf("unexpected bounce %+v", b)
+	}
+	if b := bs[1]; b.Email != "spam@example.com" || b.Type != models.BounceTypeComplaint {
+		t.Errorf("unexpected bounce %+v", b)
+	}
+}
+
+func TestSendgridVerify(t *testing.T) {
+	var failed int
+	s, sign := newTestSendgrid(t, Opt{OnUnverified: func(string, error) { failed++ }})
+
+	var (
+		body = []byte(sendgridTestBody)
+		now  = strconv.FormatInt(time.Now().Unix(), 10)
+		old  = strconv.FormatInt(time.Now().Add(-maxNotifAge-time.Minute).Unix(), 10)
+		fut  = strconv.FormatInt(time.Now().Add(maxClockSkew+time.Minute).Unix(), 10)
+	)
+
+	_, other := newTestSendgrid(t, Opt{})
+	cases := []struct {
+		name string
+		sig  string
+		ts   string
+		body []byte
+	}{
+		{"expired", sign(old, body), old, body},
+		{"future", sign(fut, body), fut, body},
+		{"tampered body", sign(now, body), now, []byte(`[{"email":"victim@example.com","event":"bounce"}]`)},
+		{"tampered timestamp", sign(old, body), now, body},
+		{"other key", other(now, body), now, body},
+		{"no signature", "", now, body},
+		{"invalid signature", "bm90IGEgc2lnbmF0dXJl", now, body},
+		{"invalid timestamp", sign("yesterday", body), "yesterday", body},
+	}
+	for _, c := range cases {
+		if _, err := s.ProcessBounce(c.sig, c.ts, c.body); !errors.Is(err, ErrUnverified) {
+			t.Errorf("%s: expected ErrUnverified, got %v", c.name, err)
+		}
+	}
+	if failed != len(cases) {
+		t.Errorf("expected %d failures to be reported, got %d", len(cases), failed)
+	}
+}
+
+func TestSendgridLogOnly(t *testing.T) {
+	var failed int
+	s, sign := newTestSendgrid(t, Opt{LogOnly: true, OnUnverified: func(string, error) { failed++ }})
+
+	old := strconv.FormatInt(time.Now().Add(-2*maxNotifAge).Unix(), 10)
+	bs, err := s.ProcessBounce(sign(old, []byte(sendgridTestBody)), old, []byte(sendgridTestBody))
+	if err != nil || len(bs) != 2 {
+		t.Fatalf("expected the notification to be processed, got %d bounces, %v", len(bs), err)
+	}
+	if failed != 1 {
+		t.Fatalf("expected the failure to be reported, got %d", failed)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/knadh/listmonk/models"
@@ -56,6 +57,7 @@ type sesMail struct {
 	} `json:"bounce"`
 	Mail struct {
 		Timestamp        sesTimestamp        `json:"timestamp"`
+		Source           string              `json:"source"`
 		HeadersTruncated bool                `json:"headersTruncated"`
 		Destination      []string            `json:"destination"`
 		Headers          []map[string]string `json:"headers"`
@@ -66,12 +68,21 @@ type sesMail struct {
 // requests and bounce notifications.
 type SES struct {
 	certs map[string]*x509.Certificate
+	opt   Opt
+
+	// IDs of the recently verified notifications and their timestamps to
+	// reject replays within maxNotifAge.
+	seen      map[string]time.Time
+	seenMut   sync.Mutex
+	lastPrune time.Time
 }
 
 // NewSES returns a new SES instance.
-func NewSES() *SES {
+func NewSES(o Opt) *SES {
 	return &SES{
 		certs: make(map[string]*x509.Certificate),
+		opt:   o,
+		seen:  make(map[string]time.Time),
 	}
 }
 
@@ -82,7 +93,9 @@ func (s *SES) ProcessSubscription(b []byte) error {
 	if err := json.Unmarshal(b, &n); err != nil {
 		return fmt.Errorf("error unmarshalling SNS notification: %v", err)
 	}
-	if err := s.verifyNotif(n); err != nil {
+
+	// Subscriptions are always verified as confirming one makes an outbound request.
+	if err := s.opt.enforce("ses", s.verifyNotif(n)); err != nil {
 		return err
 	}
 
@@ -113,7 +126,7 @@ func (s *SES) ProcessBounce(b []byte) (models.Bounce, error) {
 	if err := json.Unmarshal(b, &n); err != nil {
 		return bounce, fmt.Errorf("error unmarshalling SES notification: %v", err)
 	}
-	if err := s.verifyNotif(n); err != nil {
+	if err := s.opt.check("ses", s.verifyNotif(n)); err != nil {
 		return bounce, err
 	}
 
@@ -122,7 +135,7 @@ func (s *SES) ProcessBounce(b []byte) (models.Bounce, error) {
 		return bounce, fmt.Errorf("error unmarshalling SES notification: %v", err)
 	}
 
-	if (m.EventType != "" && m.EventType != "Bounce") ||
+	if (m.EventType != "" && m.EventType != "Bounce" && m.EventType != "Complaint") ||
 		(m.NotifType != "" && (m.NotifType != "Bounce" && m.NotifType != "Complaint")) {
 		return bounce, errors.New("notification type is not bounce")
 	}
@@ -141,7 +154,7 @@ func (s *SES) ProcessBounce(b []byte) (models.Bounce, error) {
 			typ = models.BounceTypeHard
 		}
 	}
-	if m.NotifType == "Complaint" {
+	if m.NotifType == "Complaint" || m.EventType == "Complaint" {
 		typ = models.BounceTypeComplaint
 	}
 
@@ -165,6 +178,7 @@ func (s *SES) ProcessBounce(b []byte) (models.Bounce, error) {
 	return models.Bounce{
 		Email:        strings.ToLower(m.Mail.Destination[0]),
 		CampaignUUID: campUUID,
+		Recipients:   []string{m.Mail.Source},
 		Type:         typ,
 		Source:       "ses",
 		Meta:         json.RawMessage(n.Message),
@@ -195,8 +209,19 @@ func (s *SES) buildSignature(n sesNotif) []byte {
 	return b.Bytes()
 }
 
-// verifyNotif verifies the signature on a notification payload.
+// verifyNotif verifies the signature on a notification payload and rejects
+// notifications that are too old or have already been seen to prevent replays.
 func (s *SES) verifyNotif(n sesNotif) error {
+	// SignatureVersion 1 is SHA1 and 2 is SHA256.
+	algo := x509.SHA1WithRSA
+	switch n.SignatureVersion {
+	case "1":
+	case "2":
+		algo = x509.SHA256WithRSA
+	default:
+		return fmt.Errorf("unsupported SNS signature version: %s", n.SignatureVersion)
+	}
+
 	// Get the message signing certificate.
 	cert, err := s.getCert(n.SigningCertURL)
 	if err != nil {
@@ -208,7 +233,49 @@ func (s *SES) verifyNotif(n sesNotif) error {
 		return err
 	}
 
-	return cert.CheckSignature(x509.SHA1WithRSA, s.buildSignature(n), sign)
+	if err := cert.CheckSignature(algo, s.buildSignature(n), sign); err != nil {
+		return err
+	}
+
+	// The timestamp is a part of the signed payload.
+	ts, err := time.Parse(time.RFC3339, n.Timestamp)
+	if err != nil {
+		return fmt.Errorf("invalid SNS timestamp: %v", err)
+	}
+	if err := checkTimestamp(ts); err != nil {
+		return err
+	}
+
+	return s.checkReplay(n.MessageId, ts)
+}
+
+// checkReplay rejects a notification whose (signed) MessageId has already been
+// seen. IDs are only remembered until their timestamps are older than
+// maxNotifAge, after which checkTimestamp rejects them anyway.
+func (s *SES) checkReplay(id string, ts time.Time) error {
+	if id == "" {
+		return errors.New("missing SNS MessageId")
+	}
+
+	s.seenMut.Lock()
+	defer s.seenMut.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastPrune) > time.Minute {
+		for k, t := range s.seen {
+			if now.Sub(t) > maxNotifAge {
+				delete(s.seen, k)
+			}
+		}
+		s.lastPrune = now
+	}
+
+	if _, ok := s.seen[id]; ok {
+		return fmt.Errorf("duplicate SNS notification %s", id)
+	}
+	s.seen[id] = ts
+
+	return nil
 }
 
 // getCert takes the SNS certificate URL and fetches it and caches it for the first time,
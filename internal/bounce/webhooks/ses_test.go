@@ -0,0 +1,244 @@
+package webhooks
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	sesTestCertPath = "/SimpleNotificationService-0123456789abcdef.pem"
+	sesTestCertURL  = "https://sns.us-east-1.amazonaws.com" + sesTestCertPath
+
+	sesTestMessage = `{"notificationType":"Bounce","bounce":{"bounceType":"Permanent","bouncedRecipients":[{"status":"5.1.1"}]},` +
+		`"mail":{"timestamp":"2026-01-01T12:00:00.000Z","source":"news@example.com","destination":["User@Example.com"],` +
+		`"headers":[{"name":"X-Listmonk-Campaign","value":"2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11"}]}}`
+)
+
+// newTestSES returns an SES instance with a cached signing certificate and a
+// func that returns signed notifications with it.
+func newTestSES(t *testing.T, o Opt) (*SES, func(n sesNotif) []byte) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewSES(o)
+	s.certs[sesTestCertPath] = cert
+
+	sign := func(n sesNotif) []byte {
+		if n.SigningCertURL == "" {
+			n.SigningCertURL = sesTestCertURL
+		}
+
+		var (
+			b    = s.buildSignature(n)
+			hash = crypto.SHA256
+			sum  []byte
+		)
+		if n.SignatureVersion == "1" {
+			hash = crypto.SHA1
+			h := sha1.Sum(b)
+			sum = h[:]
+		} else {
+			h := sha256.Sum256(b)
+			sum = h[:]
+		}
+
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, hash, sum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n.Signature = base64.StdEncoding.EncodeToString(sig)
+
+		out, err := json.Marshal(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	return s, sign
+}
+
+func sesTestNotif(id string, ts time.Time) sesNotif {
+	return sesNotif{
+		Type:             "Notification",
+		MessageId:        id,
+		TopicArn:         "arn:aws:sns:us-east-1:123456789012:bounces",
+		Message:          sesTestMessage,
+		Timestamp:        ts.UTC().Format(time.RFC3339),
+		SignatureVersion: "2",
+	}
+}
+
+func TestSESProcessBounce(t *testing.T) {
+	s, sign := newTestSES(t, Opt{})
+
+	for _, v := range []string{"1", "2"} {
+		n := sesTestNotif("msg-v"+v, time.Now())
+		n.SignatureVersion = v
+
+		b, err := s.ProcessBounce(sign(n))
+		if err != nil {
+			t.Fatalf("v%s: unexpected error: %v", v, err)
+		}
+		if b.Email != "user@example.com" || b.Type != models.BounceTypeHard || b.Source != "ses" ||
+			b.CampaignUUID != "2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11" {
+			t.Fatalf("v%s: unexpected bounce %+v", v, b)
+		}
+	}
+}
+
+func TestSESVerify(t *testing.T) {
+	var failed int
+	s, sign := newTestSES(t, Opt{OnUnverified: func(string, error) { failed++ }})
+
+	cases := []struct {
+		name string
+		body func() []byte
+	}{
+		{"expired", func() []byte { return sign(sesTestNotif("msg-expired", time.Now().Add(-maxNotifAge-time.Minute))) }},
+		{"future", func() []byte { return sign(sesTestNotif("msg-future", time.Now().Add(maxClockSkew+time.Minute))) }},
+		{"tampered", func() []byte {
+			var n sesNotif
+			json.Unmarshal(sign(sesTestNotif("msg-tampered", time.Now())), &n)
+			n.Message = `{"notificationType":"Bounce","mail":{"destination":["victim@example.com"]}}`
+			b, _ := json.Marshal(n)
+			return b
+		}},
+		{"unsigned", func() []byte {
+			b, _ := json.Marshal(sesTestNotif("msg-unsigned", time.Now()))
+			return b
+		}},
+		{"unsupported version", func() []byte {
+			n := sesTestNotif("msg-version", time.Now())
+			n.SignatureVersion = "3"
+			return sign(n)
+		}},
+		{"foreign cert URL", func() []byte {
+			n := sesTestNotif("msg-cert", time.Now())
+			n.SigningCertURL = "https://example.com" + sesTestCertPath
+			return sign(n)
+		}},
+		{"no message ID", func() []byte { return sign(sesTestNotif("", time.Now())) }},
+	}
+	for _, c := range cases {
+		if _, err := s.ProcessBounce(c.body()); !errors.Is(err, ErrUnverified) {
+			t.Errorf("%s: expected ErrUnverified, got %v", c.name, err)
+		}
+	}
+	if failed != len(cases) {
+		t.Errorf("expected %d failures to be reported, got %d", len(cases), failed)
+	}
+}
+
+func TestSESReplay(t *testing.T) {
+	s, sign := newTestSES(t, Opt{})
+
+	body := sign(sesTestNotif("msg-1", time.Now()))
+	if _, err := s.ProcessBounce(body); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The same notification replayed within maxNotifAge is rejected.
+	if _, err := s.ProcessBounce(body); !errors.Is(err, ErrUnverified) {
+		t.Fatalf("expected the replay to be rejected, got %v", err)
+	}
+
+	// Other notifications are accepted.
+	if _, err := s.ProcessBounce(sign(sesTestNotif("msg-2", time.Now()))); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Seen IDs are forgotten once they're too old to pass checkTimestamp.
+	s.seen["msg-old"] = time.Now().Add(-maxNotifAge - time.Minute)
+	s.lastPrune = time.Time{}
+	if err := s.checkReplay("msg-3", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.seen["msg-old"]; ok {
+		t.Error("expected the old ID to be pruned")
+	}
+}
+
+func TestSESLogOnly(t *testing.T) {
+	var failed int
+	s, sign := newTestSES(t, Opt{LogOnly: true, OnUnverified: func(string, error) { failed++ }})
+
+	// Expired notifications are processed in the log-only mode, but reported.
+	b, err := s.ProcessBounce(sign(sesTestNotif("msg-expired", time.Now().Add(-2*maxNotifAge))))
+	if err != nil || b.Email != "user@example.com" {
+		t.Fatalf("expected the notification to be processed, got %+v %v", b, err)
+	}
+	if failed != 1 {
+		t.Fatalf("expected the failure to be reported, got %d", failed)
+	}
+
+	// Subscription confirmations are always verified.
+	n := sesTestNotif("msg-sub", time.Now().Add(-2*maxNotifAge))
+	n.Type = "SubscriptionConfirmation"
+	n.SubscribeURL = "http://127.0.0.1:1/confirm"
+	if err := s.ProcessSubscription(sign(n)); !errors.Is(err, ErrUnverified) {
+		t.Fatalf("expected the subscription confirmation to be rejected, got %v", err)
+	}
+}
+
+func TestSESComplaint(t *testing.T) {
+	s, sign := newTestSES(t, Opt{})
+
+	// SNS notifications and event publishing report complaints differently.
+	for n, msg := range []string{
+		`{"notificationType":"Complaint","complaint":{"complainedRecipients":[{"emailAddress":"user@example.com"}]},` +
+			`"mail":{"timestamp":"2026-01-01T12:00:00.000Z","destination":["User@Example.com"]}}`,
+		`{"eventType":"Complaint","complaint":{"complainedRecipients":[{"emailAddress":"user@example.com"}]},` +
+			`"mail":{"timestamp":"2026-01-01T12:00:00.000Z","destination":["User@Example.com"]}}`,
+	} {
+		notif := sesTestNotif("complaint-"+strconv.Itoa(n), time.Now())
+		notif.Message = msg
+
+		b, err := s.ProcessBounce(sign(notif))
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", n, err)
+		}
+		if b.Email != "user@example.com" || b.Type != models.BounceTypeComplaint {
+			t.Errorf("%d: expected a complaint, got %+v", n, b)
+		}
+	}
+
+	// Other events are ignored.
+	notif := sesTestNotif("delivery", time.Now())
+	notif.Message = `{"eventType":"Delivery","mail":{"timestamp":"2026-01-01T12:00:00.000Z","destination":["user@example.com"]}}`
+	if _, err := s.ProcessBounce(sign(notif)); err == nil {
+		t.Error("expected a delivery event to be ignored")
+	}
+}
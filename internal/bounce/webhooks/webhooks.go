@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// Maximum age of a signed notification's timestamp beyond which it's
+	// considered to be replayed.
+	maxNotifAge = time.Hour
+
+	// Maximum clock skew allowed for timestamps in the future.
+	maxClockSkew = time.Minute * 5
+)
+
+// ErrUnverified is returned (wrapped) when a webhook request fails the
+// provider's authentication or signature verification.
+var ErrUnverified = errors.New("webhook verification failed")
+
+// Opt represents the options common to all webhook providers.
+type Opt struct {
+	// LogOnly reports verification failures to OnUnverified but processes
+	// the requests anyway. This is meant to be used while migrating to
+	// verified webhooks.
+	LogOnly bool
+
+	// OnUnverified is called with the provider name and the error on every
+	// verification failure.
+	OnUnverified func(provider string, err error)
+}
+
+// check takes the result of a provider's verification and returns a wrapped
+// ErrUnverified if it failed, or nil in the log-only mode.
+func (o Opt) check(provider string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if o.OnUnverified != nil {
+		o.OnUnverified(provider, err)
+	}
+	if o.LogOnly {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %v", ErrUnverified, err)
+}
+
+// enforce is like check but rejects failed requests even in the log-only mode.
+// It's used for requests that trigger side effects, eg: confirming a subscription.
+func (o Opt) enforce(provider string, err error) error {
+	o.LogOnly = false
+	return o.check(provider, err)
+}
+
+// checkTimestamp checks that a notification's timestamp is recent enough and
+// isn't a replay of an old notification.
+func checkTimestamp(t time.Time) error {
+	now := time.Now()
+	if now.Sub(t) > maxNotifAge {
+		return fmt.Errorf("notification timestamp %s is too old", t.Format(time.RFC3339))
+	}
+	if t.Sub(now) > maxClockSkew {
+		return fmt.Errorf("notification timestamp %s is in the future", t.Format(time.RFC3339))
+	}
+
+	return nil
+}
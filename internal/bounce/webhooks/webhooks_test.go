@@ -0,0 +1,57 @@
+package webhooks
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckTimestamp(t *testing.T) {
+	now := time.Now()
+	cases := []struct {
+		name string
+		ts   time.Time
+		ok   bool
+	}{
+		{"now", now, true},
+		{"recent", now.Add(-maxNotifAge + time.Minute), true},
+		{"expired", now.Add(-maxNotifAge - time.Minute), false},
+		{"clock skew", now.Add(maxClockSkew - time.Minute), true},
+		{"future", now.Add(maxClockSkew + time.Minute), false},
+	}
+	for _, c := range cases {
+		if err := checkTimestamp(c.ts); (err == nil) != c.ok {
+			t.Errorf("%s: expected ok=%v, got %v", c.name, c.ok, err)
+		}
+	}
+}
+
+func TestOptCheck(t *testing.T) {
+	var failed []string
+	o := Opt{OnUnverified: func(provider string, err error) { failed = append(failed, provider) }}
+
+	if err := o.check("ses", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(failed) != 0 {
+		t.Fatalf("unexpected failures %v", failed)
+	}
+
+	if err := o.check("ses", errors.New("bad signature")); !errors.Is(err, ErrUnverified) {
+		t.Fatalf("expected ErrUnverified, got %v", err)
+	}
+
+	// The log-only mode reports failures but lets the requests through, except
+	// for the ones that are enforced.
+	o.LogOnly = true
+	if err := o.check("sendgrid", errors.New("bad signature")); err != nil {
+		t.Fatalf("expected the log-only mode to process the request, got %v", err)
+	}
+	if err := o.enforce("ses", errors.New("bad signature")); !errors.Is(err, ErrUnverified) {
+		t.Fatalf("expected an enforced check to fail in the log-only mode, got %v", err)
+	}
+
+	if len(failed) != 3 || failed[0] != "ses" || failed[1] != "sendgrid" || failed[2] != "ses" {
+		t.Fatalf("unexpected failures %v", failed)
+	}
+}
@@ -0,0 +1,180 @@
+// Package capacity periodically checks the space used on the filesystem of the
+// media uploads and the size of the database against thresholds, and alerts
+// when they're crossed. An alert isn't sent again until its cooldown has elapsed.
+package capacity
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	ResourceMedia = "media"
+	ResourceDB    = "db"
+)
+
+var errUnsupported = errors.New("disk usage isn't supported on this platform")
+
+// Opt represents the checker's options.
+type Opt struct {
+	// Interval at which the usage is checked.
+	Interval time.Duration
+
+	// Minimum duration between alerts.
+	Cooldown time.Duration
+
+	// Directory of the media uploads. Empty if media isn't stored on the filesystem.
+	MediaPath string
+
+	// Percentage of the space used on the media filesystem at or above which an
+	// alert is sent. 0 disables the alert.
+	DiskThreshold int
+
+	// Size of the database in MB at or above which an alert is sent. 0 disables the alert.
+	DBThresholdMB int
+}
+
+// Store is the source of the database size.
+type Store interface {
+	GetDBSize() (int64, error)
+}
+
+// Notifier sends out an alert with the usage whose thresholds are crossed.
+type Notifier func(u Usage) error
+
+// DiskUsage represents the space on a filesystem in bytes.
+type DiskUsage struct {
+	Path        string  `json:"path"`
+	Total       uint64  `json:"total"`
+	Free        uint64  `json:"free"`
+	UsedPercent float64 `json:"used_percent"`
+}
+
+// Usage represents the result of a check.
+type Usage struct {
+	// Usage of the media filesystem. nil if media isn't stored on the
+	// filesystem or its usage can't be read.
+	Media *DiskUsage `json:"media"`
+
+	// Size of the database in bytes.
+	DBSize int64 `json:"db_size"`
+
+	// Resources whose thresholds are crossed (Resource*).
+	Exceeded []string `json:"exceeded"`
+
+	CheckedAt time.Time `json:"checked_at"`
+
+	// Thresholds at the time of the check for the alert.
+	DiskThreshold int `json:"-"`
+	DBThresholdMB int `json:"-"`
+}
+
+// Checker checks the usage.
+type Checker struct {
+	opt    Opt
+	store  Store
+	notify Notifier
+	log    *log.Logger
+
+	// Returns the usage of the filesystem of a path. It can be
+	// replaced to check against fake values.
+	fnDisk func(path string) (DiskUsage, error)
+
+	mut       sync.RWMutex
+	usage     Usage
+	lastAlert time.Time
+}
+
+// New returns a new Checker.
+func New(opt Opt, st Store, notify Notifier, lo *log.Logger) *Checker {
+	return &Checker{
+		opt:    opt,
+		store:  st,
+		notify: notify,
+		log:    lo,
+		fnDisk: diskUsage,
+	}
+}
+
+// Run checks the usage right away and at the configured interval. It blocks forever.
+func (c *Checker) Run() {
+	c.Check(time.Now())
+
+	t := time.NewTicker(c.opt.Interval)
+	defer t.Stop()
+
+	for now := range t.C {
+		c.Check(now)
+	}
+}
+
+// Check checks the usage once and sends an alert if any of the thresholds are
+// crossed and the alert isn't cooling down.
+func (c *Checker) Check(now time.Time) Usage {
+	u := Usage{
+		Exceeded:      []string{},
+		CheckedAt:     now,
+		DiskThreshold: c.opt.DiskThreshold,
+		DBThresholdMB: c.opt.DBThresholdMB,
+	}
+
+	if c.opt.MediaPath != "" {
+		d, err := c.fnDisk(c.opt.MediaPath)
+		if err != nil {
+			if !errors.Is(err, errUnsupported) {
+				c.log.Printf("error checking media disk usage: %v", err)
+			}
+		} else {
+			u.Media = &d
+			if c.opt.DiskThreshold > 0 && d.UsedPercent >= float64(c.opt.DiskThreshold) {
+				u.Exceeded = append(u.Exceeded, ResourceMedia)
+			}
+		}
+	}
+
+	// Errors are logged by the store.
+	if n, err := c.store.GetDBSize(); err == nil {
+		u.DBSize = n
+		if c.opt.DBThresholdMB > 0 && n >= int64(c.opt.DBThresholdMB)*1024*1024 {
+			u.Exceeded = append(u.Exceeded, ResourceDB)
+		}
+	}
+
+	c.mut.Lock()
+	c.usage = u
+	alert := len(u.Exceeded) > 0 && (c.lastAlert.IsZero() || now.Sub(c.lastAlert) >= c.opt.Cooldown)
+	if alert {
+		c.lastAlert = now
+	}
+	c.mut.Unlock()
+
+	if alert {
+		c.log.Printf("storage usage alert: %v", u.Exceeded)
+		if err := c.notify(u); err != nil {
+			c.log.Printf("error sending storage usage alert: %v", err)
+		}
+	}
+
+	return u
+}
+
+// Usage returns the result of the last check.
+func (c *Checker) Usage() Usage {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	return c.usage
+}
+
+// makeDiskUsage returns the usage of a filesystem from its size and the space
+// available to unprivileged users.
+func makeDiskUsage(path string, total, free uint64) DiskUsage {
+	out := DiskUsage{Path: path, Total: total, Free: free}
+	if total > 0 {
+		out.UsedPercent = float64(total-free) / float64(total) * 100
+	}
+
+	return out
+}
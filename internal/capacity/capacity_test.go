@@ -0,0 +1,146 @@
+package capacity
+
+import (
+	"errors"
+	"io"
+	"log"
+	"slices"
+	"testing"
+	"time"
+)
+
+const mb = 1024 * 1024
+
+// testStore returns a fake database size.
+type testStore struct {
+	size int64
+	err  error
+}
+
+func (s *testStore) GetDBSize() (int64, error) {
+	return s.size, s.err
+}
+
+// newTestChecker returns a checker with fake usage values that records the alerts.
+func newTestChecker(opt Opt, st *testStore, used *float64, alerts *[]Usage) *Checker {
+	c := New(opt, st, func(u Usage) error {
+		*alerts = append(*alerts, u)
+		return nil
+	}, log.New(io.Discard, "", 0))
+
+	c.fnDisk = func(path string) (DiskUsage, error) {
+		return makeDiskUsage(path, 1000, uint64(1000-*used*10)), nil
+	}
+	return c
+}
+
+func TestCheckThresholds(t *testing.T) {
+	cases := []struct {
+		name     string
+		opt      Opt
+		used     float64
+		dbSize   int64
+		exceeded []string
+	}{
+		{"below", Opt{MediaPath: "/media", DiskThreshold: 90, DBThresholdMB: 100}, 50, 10 * mb, []string{}},
+		{"at the disk threshold", Opt{MediaPath: "/media", DiskThreshold: 90}, 90, 0, []string{ResourceMedia}},
+		{"at the db threshold", Opt{DBThresholdMB: 100}, 0, 100 * mb, []string{ResourceDB}},
+		{"both", Opt{MediaPath: "/media", DiskThreshold: 90, DBThresholdMB: 100}, 95, 200 * mb, []string{ResourceMedia, ResourceDB}},
+		{"disabled thresholds", Opt{MediaPath: "/media"}, 100, 200 * mb, []string{}},
+		{"no media path", Opt{DiskThreshold: 90}, 100, 0, []string{}},
+	}
+	for _, c := range cases {
+		var alerts []Usage
+		ch := newTestChecker(c.opt, &testStore{size: c.dbSize}, &c.used, &alerts)
+
+		u := ch.Check(time.Now())
+		if !slices.Equal(u.Exceeded, c.exceeded) {
+			t.Errorf("%s: expected %v exceeded, got %v", c.name, c.exceeded, u.Exceeded)
+		}
+		if (len(alerts) == 1) != (len(c.exceeded) > 0) {
+			t.Errorf("%s: unexpected alerts %+v", c.name, alerts)
+		}
+		if u.DBSize != c.dbSize || (c.opt.MediaPath != "") != (u.Media != nil) {
+			t.Errorf("%s: unexpected usage %+v", c.name, u)
+		}
+	}
+}
+
+func TestCheckCooldown(t *testing.T) {
+	var (
+		alerts []Usage
+		used   = 50.0
+		st     = &testStore{size: 10 * mb}
+		now    = time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC)
+	)
+	c := newTestChecker(Opt{MediaPath: "/media", DiskThreshold: 90, DBThresholdMB: 100, Cooldown: time.Hour}, st, &used, &alerts)
+
+	check := func(d time.Duration, expAlerts int) {
+		t.Helper()
+		u := c.Check(now.Add(d))
+		if len(alerts) != expAlerts {
+			t.Fatalf("%v: expected %d alerts, got %d", d, expAlerts, len(alerts))
+		}
+		if c.Usage().CheckedAt != u.CheckedAt {
+			t.Fatalf("%v: expected the last usage to be stored", d)
+		}
+	}
+
+	// Below the thresholds.
+	check(0, 0)
+
+	// The threshold is crossed and the alert is sent once.
+	used = 95
+	check(time.Minute, 1)
+	check(2*time.Minute, 1)
+	check(30*time.Minute, 1)
+
+	// Another resource crossing within the cooldown doesn't alert either.
+	st.size = 200 * mb
+	check(time.Hour, 1)
+
+	// After the cooldown, the alert is sent again.
+	check(time.Hour+time.Minute, 2)
+	if !slices.Equal(alerts[1].Exceeded, []string{ResourceMedia, ResourceDB}) || alerts[1].DiskThreshold != 90 {
+		t.Errorf("unexpected alert %+v", alerts[1])
+	}
+
+	// Dropping below the thresholds stops the alerts.
+	used, st.size = 50, 10*mb
+	check(3*time.Hour, 2)
+	if len(c.Usage().Exceeded) != 0 {
+		t.Errorf("expected no thresholds exceeded, got %v", c.Usage().Exceeded)
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	var alerts []Usage
+	c := New(Opt{MediaPath: "/media", DiskThreshold: 90, DBThresholdMB: 100}, &testStore{err: errors.New("db error")},
+		func(u Usage) error {
+			alerts = append(alerts, u)
+			return errors.New("smtp error")
+		}, log.New(io.Discard, "", 0))
+
+	// Usage that can't be read is left out and doesn't alert.
+	for _, err := range []error{errUnsupported, errors.New("stat error")} {
+		c.fnDisk = func(string) (DiskUsage, error) { return DiskUsage{}, err }
+		if u := c.Check(time.Now()); u.Media != nil || u.DBSize != 0 || len(u.Exceeded) != 0 || len(alerts) != 0 {
+			t.Errorf("%v: unexpected usage %+v", err, u)
+		}
+	}
+
+	// A failed alert doesn't fail the check.
+	c.store = &testStore{size: 200 * mb}
+	if u := c.Check(time.Now()); !slices.Equal(u.Exceeded, []string{ResourceDB}) || len(alerts) != 1 {
+		t.Errorf("expected an alert, got %+v", u)
+	}
+}
+
+func TestMakeDiskUsage(t *testing.T) {
+	if d := makeDiskUsage("/", 200, 50); d.UsedPercent != 75 {
+		t.Errorf("expected 75%%, got %v", d.UsedPercent)
+	}
+	if d := makeDiskUsage("/", 0, 0); d.UsedPercent != 0 {
+		t.Errorf("expected 0%%, got %v", d.UsedPercent)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !(linux || darwin || freebsd || windows)
+
+package capacity
+
+// diskUsage isn't supported on the rest of the platforms.
+func diskUsage(path string) (DiskUsage, error) {
+	return DiskUsage{}, errUnsupported
+}
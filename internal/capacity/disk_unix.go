@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd
+
+package capacity
+
+import "syscall"
+
+// diskUsage returns the usage of the filesystem of a path.
+func diskUsage(path string) (DiskUsage, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return DiskUsage{}, err
+	}
+
+	bs := uint64(st.Bsize)
+	return makeDiskUsage(path, uint64(st.Blocks)*bs, uint64(st.Bavail)*bs), nil
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
@@ -22,8 +23,8 @@ func (c *Core) QueryBounces(campID, subID int, source, orderBy, order string, of
 	}
 
 	out := []models.Bounce{}
-	stmt := strings.ReplaceAll(c.q.QueryBounces, "%order%", orderBy+" "+order)
-	if err := c.db.Select(&out, stmt, 0, campID, subID, source, offset, limit); err != nil {
+	stmt := strings.ReplaceAll(makeTotalQuery(c.q.QueryBounces, false), "%order%", orderBy+" "+order)
+	if err := c.db.SelectContext(c.ctx, &out, stmt, 0, campID, subID, source, offset, limit); err != nil {
 		c.log.Printf("error fetching bounces: %v", err)
 		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.bounce}", "error", pqErrMsg(err)))
@@ -37,11 +38,52 @@ func (c *Core) QueryBounces(campID, subID int, source, orderBy, order string, of
 	return out, total, nil
 }
 
+// StreamBounces queries bounces like QueryBounces, but instead of loading the
+// results into memory, calls fn for every row as it's read from the DB cursor.
+// The query is aborted if ctx is cancelled (eg: the client disconnects) or fn
+// returns an error. It returns the total number of bounces matching the query,
+// or models.TotalSkipped if skipTotal is set.
+func (c *Core) StreamBounces(ctx context.Context, campID int, source, orderBy, order string, offset, limit int, skipTotal bool, fn func(models.Bounce) error) (int, error) {
+	if !strSliceContains(orderBy, bounceQuerySortFields) {
+		orderBy = "created_at"
+	}
+	if order != SortAsc && order != SortDesc {
+		order = SortDesc
+	}
+
+	stmt := strings.ReplaceAll(makeTotalQuery(c.q.QueryBounces, skipTotal), "%order%", orderBy+" "+order)
+	rows, err := c.db.QueryxContext(ctx, stmt, 0, campID, 0, source, offset, limit)
+	if err != nil {
+		c.log.Printf("error fetching bounces: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.bounce}", "error", pqErrMsg(err)))
+	}
+	defer rows.Close()
+
+	total := 0
+	if skipTotal {
+		total = models.TotalSkipped
+	}
+	for rows.Next() {
+		var b models.Bounce
+		if err := rows.StructScan(&b); err != nil {
+			return total, err
+		}
+		total = b.Total
+
+		if err := fn(b); err != nil {
+			return total, err
+		}
+	}
+
+	return total, rows.Err()
+}
+
 // GetBounce retrieves bounce entries based on the given params.
 func (c *Core) GetBounce(id int) (models.Bounce, error) {
 	var out []models.Bounce
-	stmt := strings.ReplaceAll(c.q.QueryBounces, "%order%", "id "+SortAsc)
-	if err := c.db.Select(&out, stmt, id, 0, 0, "", 0, 1); err != nil {
+	stmt := strings.ReplaceAll(makeTotalQuery(c.q.QueryBounces, false), "%order%", "id "+SortAsc)
+	if err := c.db.SelectContext(c.ctx, &out, stmt, id, 0, 0, "", 0, 1); err != nil {
 		c.log.Printf("error fetching bounces: %v", err)
 		return models.Bounce{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.bounce}", "error", pqErrMsg(err)))
@@ -63,7 +105,7 @@ func (c *Core) RecordBounce(b models.Bounce) error {
 		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("globals.messages.invalidData")+": "+b.Type)
 	}
 
-	_, err := c.q.RecordBounce.Exec(b.SubscriberUUID,
+	_, err := c.q.RecordBounce.ExecContext(c.ctx, b.SubscriberUUID,
 		b.Email,
 		b.CampaignUUID,
 		b.Type,
@@ -76,6 +118,15 @@ func (c *Core) RecordBounce(b models.Bounce) error {
 	if err != nil {
 		// Ignore the error if it complained of no subscriber.
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Column == "subscriber_id" {
+			// The bounce may be on an ad-hoc campaign recipient who isn't a subscriber.
+			if b.CampaignUUID != "" {
+				if res, err := c.q.RecordRecipientBounce.ExecContext(c.ctx, b.CampaignUUID, b.SubscriberUUID, b.Email, b.Type); err == nil {
+					if n, _ := res.RowsAffected(); n > 0 {
+						return nil
+					}
+				}
+			}
+
 			c.log.Printf("bounced subscriber (%s / %s) not found", b.SubscriberUUID, b.Email)
 			return nil
 		}
@@ -88,7 +139,7 @@ func (c *Core) RecordBounce(b models.Bounce) error {
 
 // BlocklistBouncedSubscribers blocklists all bounced subscribers.
 func (c *Core) BlocklistBouncedSubscribers() error {
-	if _, err := c.q.BlocklistBouncedSubscribers.Exec(); err != nil {
+	if _, err := c.q.BlocklistBouncedSubscribers.ExecContext(c.ctx); err != nil {
 		c.log.Printf("error blocklisting bounced subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("subscribers.errorBlocklisting", "error", err.Error()))
 	}
@@ -103,7 +154,7 @@ func (c *Core) DeleteBounce(id int) error {
 
 // DeleteBounces deletes multiple lists.
 func (c *Core) DeleteBounces(ids []int, all bool) error {
-	if _, err := c.q.DeleteBounces.Exec(pq.Array(ids), all); err != nil {
+	if _, err := c.q.DeleteBounces.ExecContext(c.ctx, pq.Array(ids), all); err != nil {
 		c.log.Printf("error deleting lists: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// TestRecordBounceActions checks that the threshold and action of each bounce
+// type apply independently of the other types.
+func TestRecordBounceActions(t *testing.T) {
+	c, db := newTestCore(t, Constants{BounceActions: map[string]models.BounceAction{
+		models.BounceTypeSoft:      {Count: 3, Action: models.BounceActionUnsubscribe},
+		models.BounceTypeHard:      {Count: 2, Action: models.BounceActionBlocklist},
+		models.BounceTypeComplaint: {Count: 1, Action: models.BounceActionBlocklist},
+	}})
+
+	var listID int
+	if err := db.Get(&listID, `INSERT INTO lists (uuid, name, type, optin) VALUES (gen_random_uuid(), 'list', 'public', 'single') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []string{"soft@example.com", "hard@example.com", "complaint@example.com", "mixed@example.com"} {
+		if _, err := db.Exec(`WITH s AS (INSERT INTO subscribers (uuid, email, name, status) VALUES (gen_random_uuid(), $1, $1, 'enabled') RETURNING id)
+			INSERT INTO subscriber_lists (subscriber_id, list_id, status) SELECT id, $2, 'confirmed' FROM s`, e, listID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	bounce := func(email, typ string, n int) {
+		t.Helper()
+		for range n {
+			if err := c.RecordBounce(models.Bounce{Email: email, Type: typ, Source: "api", Meta: []byte(`{}`), CreatedAt: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	state := func(email string) (string, string) {
+		t.Helper()
+
+		var s struct {
+			Status    string `db:"status"`
+			SubStatus string `db:"sub_status"`
+		}
+		if err := db.Get(&s, `SELECT s.status, sl.status AS sub_status FROM subscribers s
+			JOIN subscriber_lists sl ON sl.subscriber_id = s.id WHERE s.email = $1`, email); err != nil {
+			t.Fatal(err)
+		}
+		return s.Status, s.SubStatus
+	}
+	check := func(email, status, subStatus string) {
+		t.Helper()
+		if s, ss := state(email); s != status || ss != subStatus {
+			t.Errorf("%s: expected %s/%s, got %s/%s", email, status, subStatus, s, ss)
+		}
+	}
+
+	// Soft bounces are tolerated up to their threshold.
+	bounce("soft@example.com", models.BounceTypeSoft, 2)
+	check("soft@example.com", "enabled", "confirmed")
+	bounce("soft@example.com", models.BounceTypeSoft, 1)
+	check("soft@example.com", "enabled", "unsubscribed")
+
+	// Hard bounces have their own threshold.
+	bounce("hard@example.com", models.BounceTypeHard, 1)
+	check("hard@example.com", "enabled", "confirmed")
+	bounce("hard@example.com", models.BounceTypeHard, 1)
+	check("hard@example.com", "blocklisted", "confirmed")
+
+	// The first complaint blocklists.
+	bounce("complaint@example.com", models.BounceTypeComplaint, 1)
+	check("complaint@example.com", "blocklisted", "confirmed")
+
+	// Bounces of different types don't add up.
+	bounce("mixed@example.com", models.BounceTypeSoft, 2)
+	bounce("mixed@example.com", models.BounceTypeHard, 1)
+	check("mixed@example.com", "enabled", "confirmed")
+	bounce("mixed@example.com", models.BounceTypeComplaint, 1)
+	check("mixed@example.com", "blocklisted", "confirmed")
+
+	// Types without a config are rejected.
+	if err := c.RecordBounce(models.Bounce{Email: "soft@example.com", Type: "unknown", Source: "api"}); err == nil {
+		t.Error("expected an unknown bounce type to be rejected")
+	}
+}
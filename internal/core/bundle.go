@@ -0,0 +1,201 @@
+package core
+
+import (
+	"database/sql"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/jmoiron/sqlx/types"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// bundleSecretSettings are settings that have credentials or secrets in them
+// and are never exported or imported in bundles.
+var bundleSecretSettings = []string{
+	"smtp",
+	"messengers",
+	"webhooks",
+	"bounce.mailboxes",
+	"bounce.sendgrid_key",
+	"bounce.postmark",
+	"bounce.forwardemail",
+	"security.captcha",
+	"security.signing_key",
+	"security.oidc",
+	"upload.s3.aws_access_key_id",
+	"upload.s3.aws_secret_access_key",
+}
+
+// IsBundleSecretSetting returns true if a setting key is excluded from bundles.
+func IsBundleSecretSetting(key string) bool {
+	return slices.Contains(bundleSecretSettings, key)
+}
+
+// ExportBundle exports the given entity types (models.BundleType*) as a bundle.
+func (c *Core) ExportBundle(entities []string) (models.Bundle, error) {
+	out := models.Bundle{
+		Version:    models.BundleVersion,
+		ExportedAt: time.Now(),
+	}
+
+	if slices.Contains(entities, models.BundleTypeLists) {
+		out.Lists = []models.BundleList{}
+		if err := c.q.ExportLists.SelectContext(c.ctx, &out.Lists); err != nil {
+			c.log.Printf("error exporting lists: %v", err)
+			return out, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
+		}
+	}
+
+	if slices.Contains(entities, models.BundleTypeTemplates) {
+		out.Templates = []models.BundleTemplate{}
+		if err := c.q.ExportTemplates.SelectContext(c.ctx, &out.Templates); err != nil {
+			c.log.Printf("error exporting templates: %v", err)
+			return out, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.templates}", "error", pqErrMsg(err)))
+		}
+	}
+
+	if slices.Contains(entities, models.BundleTypeCampaigns) {
+		out.Campaigns = []models.BundleCampaign{}
+		if err := c.q.ExportCampaigns.SelectContext(c.ctx, &out.Campaigns); err != nil {
+			c.log.Printf("error exporting campaigns: %v", err)
+			return out, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+		}
+	}
+
+	if slices.Contains(entities, models.BundleTypeSettings) {
+		var b types.JSONText
+		if err := c.q.GetSettings.GetContext(c.ctx, &b); err != nil {
+			return out, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+		}
+
+		if err := json.Unmarshal(b, &out.Settings); err != nil {
+			return out, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("settings.errorEncoding", "error", err.Error()))
+		}
+		for _, k := range bundleSecretSettings {
+			delete(out.Settings, k)
+		}
+	}
+
+	return out, nil
+}
+
+// ImportBundle creates or updates (matched by UUID) the entities in a bundle in
+// a single transaction, resolving the campaigns' references to templates and lists
+// by their UUIDs. Lists and templates are imported before the campaigns that may
+// refer to them. Campaigns that exist and aren't drafts, and unknown or secret
+// settings are skipped. Any DB error rolls back the whole import.
+func (c *Core) ImportBundle(b models.Bundle) ([]models.BundleResult, error) {
+	tx, err := c.db.BeginTxx(c.ctx, nil)
+	if err != nil {
+		c.log.Printf("error starting bundle import: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.T("globals.messages.internalError"))
+	}
+	defer tx.Rollback()
+
+	out := []models.BundleResult{}
+	action := func(created bool) string {
+		if created {
+			return models.BundleActionCreated
+		}
+		return models.BundleActionUpdated
+	}
+
+	for _, l := range b.Lists {
+		var created bool
+		if err := tx.Stmtx(c.q.UpsertList).GetContext(c.ctx, &created, l.UUID, l.Name, l.Type, l.Optin, l.Status,
+			pq.StringArray(normalizeTags(l.Tags)), l.Description, l.PurgeUnconfirmedAfterDays, l.SunsetInactiveDays,
+			l.PublicDescription, l.Frequency); err != nil {
+			c.log.Printf("error importing list (%s): %v", l.UUID, err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorCreating", "name", l.Name, "error", pqErrMsg(err)))
+		}
+
+		out = append(out, models.BundleResult{Type: models.BundleTypeLists, UUID: l.UUID, Name: l.Name, Action: action(created)})
+	}
+
+	for _, t := range b.Templates {
+		var created bool
+		if err := tx.Stmtx(c.q.UpsertTemplate).GetContext(c.ctx, &created, t.UUID, t.Name, t.Type, t.Subject, t.Body,
+			t.BodySource, pq.StringArray(normalizeTags(t.Tags)), t.BlockStyles); err != nil {
+			c.log.Printf("error importing template (%s): %v", t.UUID, err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorCreating", "name", t.Name, "error", pqErrMsg(err)))
+		}
+
+		out = append(out, models.BundleResult{Type: models.BundleTypeTemplates, UUID: t.UUID, Name: t.Name, Action: action(created)})
+	}
+
+	for _, cm := range b.Campaigns {
+		var created bool
+		err := tx.Stmtx(c.q.UpsertCampaign).GetContext(c.ctx, &created, cm.UUID, cm.Type, cm.Name, cm.Subject, cm.FromEmail,
+			cm.Body, cm.BodySource, cm.AltBody, cm.ContentType, pq.StringArray(normalizeTags(cm.Tags)), cm.Headers,
+			cm.Messenger, cm.TrackingMode, cm.UTM, cm.Archive, cm.ArchiveSlug, cm.ArchiveMeta,
+			cm.TemplateUUID, cm.ArchiveTemplateUUID, cm.ListUUIDs, cm.PreviewText)
+		if err == sql.ErrNoRows {
+			out = append(out, models.BundleResult{Type: models.BundleTypeCampaigns, UUID: cm.UUID, Name: cm.Name,
+				Action: models.BundleActionSkipped, Reason: "campaign exists and is not a draft"})
+			continue
+		}
+		if err != nil {
+			c.log.Printf("error importing campaign (%s): %v", cm.UUID, err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorCreating", "name", cm.Name, "error", pqErrMsg(err)))
+		}
+
+		out = append(out, models.BundleResult{Type: models.BundleTypeCampaigns, UUID: cm.UUID, Name: cm.Name, Action: action(created)})
+	}
+
+	updatedSettings := false
+	for _, k := range slices.Sorted(maps.Keys(b.Settings)) {
+		v := b.Settings[k]
+		if IsBundleSecretSetting(k) {
+			out = append(out, models.BundleResult{Type: models.BundleTypeSettings, Name: k,
+				Action: models.BundleActionSkipped, Reason: "secret setting"})
+			continue
+		}
+
+		res, err := tx.Stmtx(c.q.UpdateSettingsByKey).ExecContext(c.ctx, k, v)
+		if err != nil {
+			c.log.Printf("error importing setting (%s): %v", k, err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+		}
+
+		if n, _ := res.RowsAffected(); n == 0 {
+			out = append(out, models.BundleResult{Type: models.BundleTypeSettings, Name: k,
+				Action: models.BundleActionSkipped, Reason: "unknown setting"})
+			continue
+		}
+
+		out = append(out, models.BundleResult{Type: models.BundleTypeSettings, Name: k, Action: models.BundleActionUpdated})
+		updatedSettings = true
+	}
+
+	// Invalidate the settings version held by clients that fetched the settings earlier.
+	if updatedSettings {
+		if _, err := tx.Stmtx(c.q.BumpSettingsVersion).ExecContext(c.ctx); err != nil {
+			c.log.Printf("error updating settings version: %v", err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.log.Printf("error committing bundle import: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.T("globals.messages.internalError"))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,222 @@
+package core
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/lib/pq"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	src, srcDB := newTestCore(t, Constants{})
+	dst, dstDB := newTestCore(t, Constants{})
+
+	// Rows in the target that offset its IDs from the source's so that
+	// references have to be remapped by UUIDs.
+	for _, q := range []string{
+		`INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'other 1', 'private'), (gen_random_uuid(), 'other 2', 'private')`,
+		`INSERT INTO templates (name, subject, body) VALUES ('other', '', '{{ template "content" . }}')`,
+	} {
+		if _, err := dstDB.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Source content.
+	var lists, tpls []int
+	for _, name := range []string{"news", "offers"} {
+		var id int
+		if err := srcDB.Get(&id, `INSERT INTO lists (uuid, name, type, optin, tags, frequency) VALUES (gen_random_uuid(), $1, 'public', 'double', '{a,b}', 'weekly') RETURNING id`,
+			name); err != nil {
+			t.Fatal(err)
+		}
+		lists = append(lists, id)
+	}
+	for _, name := range []string{"layout", "archive"} {
+		var id int
+		if err := srcDB.Get(&id, `INSERT INTO templates (name, subject, body, tags) VALUES ($1, '', '<p>{{ template "content" . }}</p>', '{tpl}') RETURNING id`,
+			name); err != nil {
+			t.Fatal(err)
+		}
+		tpls = append(tpls, id)
+	}
+
+	var campID int
+	if err := srcDB.Get(&campID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, template_id, archive_template_id, archive, archive_slug, tags)
+		VALUES (gen_random_uuid(), 'draft', 'Hello', 'from@example.com', '<p>hi</p>', 'email', 'draft', $1, $2, true, 'hello', '{promo}') RETURNING id`,
+		tpls[0], tpls[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srcDB.Exec(`INSERT INTO campaign_lists (campaign_id, list_id, list_name) VALUES ($1, $2, 'news'), ($1, $3, 'offers')`,
+		campID, lists[0], lists[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Export and serialize the bundle as it's downloaded.
+	exp, err := src.ExportBundle([]string{models.BundleTypeLists, models.BundleTypeTemplates, models.BundleTypeCampaigns, models.BundleTypeSettings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exp.Lists) != 2 || len(exp.Templates) != 2 || len(exp.Campaigns) != 1 {
+		t.Fatalf("unexpected bundle %+v", exp)
+	}
+	if _, ok := exp.Settings["smtp"]; ok {
+		t.Error("expected the secret settings to be left out")
+	}
+	if _, ok := exp.Settings["app.site_name"]; !ok {
+		t.Error("expected the settings to be exported")
+	}
+
+	cm := exp.Campaigns[0]
+	if cm.TemplateUUID.String != exp.Templates[0].UUID || cm.ArchiveTemplateUUID.String != exp.Templates[1].UUID ||
+		!slices.Equal(cm.ListUUIDs, pq.StringArray{exp.Lists[0].UUID, exp.Lists[1].UUID}) {
+		t.Fatalf("expected the references as UUIDs, got %+v", cm)
+	}
+
+	b, err := json.Marshal(exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle models.Bundle
+	if err := json.Unmarshal(b, &bundle); err != nil {
+		t.Fatal(err)
+	}
+	bundle.Settings = map[string]json.RawMessage{
+		"app.site_name": json.RawMessage(`"Imported"`),
+		"smtp":          json.RawMessage(`[]`),
+		"nope":          json.RawMessage(`1`),
+	}
+
+	// importBundle imports the bundle and returns the actions by entity UUID or setting name.
+	importBundle := func() map[string]string {
+		t.Helper()
+
+		res, err := dst.ImportBundle(bundle)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := map[string]string{}
+		for _, r := range res {
+			key := r.UUID
+			if r.Type == models.BundleTypeSettings {
+				key = r.Name
+			}
+			out[key] = r.Action
+		}
+		return out
+	}
+
+	// checkCampaign checks that the imported campaign refers to the target's
+	// templates and lists with the bundle's UUIDs.
+	checkCampaign := func() {
+		t.Helper()
+
+		var c struct {
+			Status    string `db:"status"`
+			TplID     int    `db:"template_id"`
+			ArchTplID int    `db:"archive_template_id"`
+			Slug      string `db:"archive_slug"`
+		}
+		if err := dstDB.Get(&c, `SELECT status, template_id, archive_template_id, archive_slug FROM campaigns WHERE uuid = $1`, cm.UUID); err != nil {
+			t.Fatal(err)
+		}
+
+		var tplIDs []int
+		if err := dstDB.Select(&tplIDs, `SELECT id FROM templates WHERE uuid = ANY($1::UUID[]) ORDER BY array_position($1::UUID[], uuid)`,
+			pq.StringArray{exp.Templates[0].UUID, exp.Templates[1].UUID}); err != nil {
+			t.Fatal(err)
+		}
+		if len(tplIDs) != 2 || c.TplID != tplIDs[0] || c.ArchTplID != tplIDs[1] || c.Slug != "hello" {
+			t.Errorf("expected the templates %v, got %+v", tplIDs, c)
+		}
+		if tplIDs[0] == tpls[0] {
+			t.Fatal("expected the target's template IDs to differ from the source's")
+		}
+
+		var got []string
+		if err := dstDB.Select(&got, `SELECT l.uuid::TEXT FROM campaign_lists cl JOIN lists l ON l.id = cl.list_id
+			JOIN campaigns c ON c.id = cl.campaign_id WHERE c.uuid = $1 ORDER BY l.uuid`, cm.UUID); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{exp.Lists[0].UUID, exp.Lists[1].UUID}
+		slices.Sort(want)
+		if !slices.Equal(got, want) {
+			t.Errorf("expected the lists %v, got %v", want, got)
+		}
+	}
+
+	counts := func() [3]int {
+		t.Helper()
+
+		var out [3]int
+		for n, tbl := range []string{"lists", "templates", "campaigns"} {
+			if err := dstDB.Get(&out[n], `SELECT COUNT(*) FROM `+tbl); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return out
+	}
+
+	// The first import creates everything.
+	res := importBundle()
+	for _, u := range []string{exp.Lists[0].UUID, exp.Lists[1].UUID, exp.Templates[0].UUID, exp.Templates[1].UUID, cm.UUID} {
+		if res[u] != models.BundleActionCreated {
+			t.Errorf("%s: expected created, got %s", u, res[u])
+		}
+	}
+	if res["app.site_name"] != models.BundleActionUpdated || res["smtp"] != models.BundleActionSkipped || res["nope"] != models.BundleActionSkipped {
+		t.Errorf("unexpected settings results %v", res)
+	}
+	checkCampaign()
+
+	var l models.BundleList
+	if err := dstDB.Get(&l, `SELECT uuid, name, type, optin, status, tags, description, purge_unconfirmed_after_days,
+		sunset_inactive_days, public_description, frequency FROM lists WHERE uuid = $1`, exp.Lists[0].UUID); err != nil {
+		t.Fatal(err)
+	}
+	if l.Name != "news" || l.Type != "public" || l.Optin != "double" || l.Frequency != "weekly" || !slices.Equal(l.Tags, pq.StringArray{"a", "b"}) {
+		t.Errorf("unexpected imported list %+v", l)
+	}
+
+	var siteName string
+	if err := dstDB.Get(&siteName, `SELECT value#>>'{}' FROM settings WHERE key = 'app.site_name'`); err != nil || siteName != "Imported" {
+		t.Errorf("expected the setting to be imported, got %q: %v", siteName, err)
+	}
+
+	// Importing again updates the same entities by their UUIDs.
+	before := counts()
+	bundle.Lists[0].Name = "renamed"
+	bundle.Campaigns[0].ListUUIDs = bundle.Campaigns[0].ListUUIDs[:1]
+	res = importBundle()
+	for _, u := range []string{exp.Lists[0].UUID, exp.Templates[0].UUID, cm.UUID} {
+		if res[u] != models.BundleActionUpdated {
+			t.Errorf("%s: expected updated, got %s", u, res[u])
+		}
+	}
+	if after := counts(); after != before {
+		t.Errorf("expected no new rows on re-import, got %v, was %v", after, before)
+	}
+
+	var name string
+	if err := dstDB.Get(&name, `SELECT name FROM lists WHERE uuid = $1`, exp.Lists[0].UUID); err != nil || name != "renamed" {
+		t.Errorf("expected the list to be renamed, got %q: %v", name, err)
+	}
+	var n int
+	if err := dstDB.Get(&n, `SELECT COUNT(*) FROM campaign_lists cl JOIN campaigns c ON c.id = cl.campaign_id WHERE c.uuid = $1`, cm.UUID); err != nil || n != 1 {
+		t.Errorf("expected the campaign's lists to be replaced, got %d: %v", n, err)
+	}
+
+	// Campaigns that aren't drafts anymore are left untouched.
+	if _, err := dstDB.Exec(`UPDATE campaigns SET status = 'running' WHERE uuid = $1`, cm.UUID); err != nil {
+		t.Fatal(err)
+	}
+	bundle.Campaigns[0].Name = "changed"
+	if res := importBundle(); res[cm.UUID] != models.BundleActionSkipped {
+		t.Errorf("expected the running campaign to be skipped, got %s", res[cm.UUID])
+	}
+	if err := dstDB.Get(&name, `SELECT name FROM campaigns WHERE uuid = $1`, cm.UUID); err != nil || name != "draft" {
+		t.Errorf("expected the campaign to be unchanged, got %q: %v", name, err)
+	}
+}
@@ -0,0 +1,66 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// campCountTTL is the duration for which the eligible recipient counts
+	// of campaigns are cached.
+	campCountTTL = time.Minute
+
+	// campCountCacheSize is the maximum number of cached counts. The cache is
+	// reset when full.
+	campCountCacheSize = 10000
+)
+
+// countCache is a small in-memory cache of counts by ID that expire after a TTL.
+type countCache struct {
+	ttl  time.Duration
+	size int
+
+	mut   sync.Mutex
+	items map[int]countItem
+
+	// now returns the current time. It's replaced in tests.
+	now func() time.Time
+}
+
+type countItem struct {
+	count int
+	exp   time.Time
+}
+
+func newCountCache(ttl time.Duration, size int) *countCache {
+	return &countCache{
+		ttl:   ttl,
+		size:  size,
+		items: make(map[int]countItem),
+		now:   time.Now,
+	}
+}
+
+// get returns the cached count of an ID if it hasn't expired.
+func (c *countCache) get(id int) (int, bool) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	it, ok := c.items[id]
+	if !ok || c.now().After(it.exp) {
+		return 0, false
+	}
+
+	return it.count, true
+}
+
+// set caches the count of an ID.
+func (c *countCache) set(id, count int) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if _, ok := c.items[id]; !ok && len(c.items) >= c.size {
+		c.items = make(map[int]countItem)
+	}
+	c.items[id] = countItem{count: count, exp: c.now().Add(c.ttl)}
+}
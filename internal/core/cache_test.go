@@ -0,0 +1,48 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCountCache(t *testing.T) {
+	var (
+		now = time.Now()
+		c   = newCountCache(time.Minute, 2)
+	)
+	c.now = func() time.Time { return now }
+
+	if _, ok := c.get(1); ok {
+		t.Fatal("expected a miss on an empty cache")
+	}
+
+	c.set(1, 10)
+	c.set(2, 20)
+	if n, ok := c.get(1); !ok || n != 10 {
+		t.Fatalf("expected 10, got %d %v", n, ok)
+	}
+
+	// Updating an existing ID doesn't reset a full cache.
+	c.set(2, 21)
+	if n, ok := c.get(2); !ok || n != 21 {
+		t.Fatalf("expected 21, got %d %v", n, ok)
+	}
+	if _, ok := c.get(1); !ok {
+		t.Fatal("expected 1 to still be cached")
+	}
+
+	// The counts expire after the TTL.
+	now = now.Add(time.Minute + time.Second)
+	if _, ok := c.get(1); ok {
+		t.Fatal("expected the count to expire")
+	}
+
+	// A new ID resets a full cache.
+	c.set(3, 30)
+	if _, ok := c.get(2); ok {
+		t.Fatal("expected the cache to be reset when full")
+	}
+	if n, ok := c.get(3); !ok || n != 30 {
+		t.Fatalf("expected 30, got %d %v", n, ok)
+	}
+}
@@ -2,14 +2,19 @@ package core
 
 import (
 	"database/sql"
+	"encoding/json"
+	"math"
 	"net/http"
 	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
 const (
@@ -23,20 +28,19 @@ const (
 
 // QueryCampaigns retrieves paginated campaigns optionally filtering them by the given arbitrary
 // query expression. It also returns the total number of records in the DB.
-func (c *Core) QueryCampaigns(searchStr string, statuses, tags []string, orderBy, order string, getAll bool, permittedLists []int, offset, limit int) (models.Campaigns, int, error) {
-	queryStr, stmt := makeSearchQuery(searchStr, orderBy, order, c.q.QueryCampaigns, campQuerySortFields)
+// If tagMatchAny is true, campaigns having any of the given tags are matched instead of all of them.
+func (c *Core) QueryCampaigns(searchStr string, statuses, tags []string, tagMatchAny bool, orderBy, order string, getAll bool, permittedLists []int, offset, limit int, skipTotal bool) (models.Campaigns, int, error) {
+	queryStr, stmt := makeSearchQuery(searchStr, orderBy, order, makeTotalQuery(c.q.QueryCampaigns, skipTotal), campQuerySortFields)
 
 	if statuses == nil {
 		statuses = []string{}
 	}
 
-	if tags == nil {
-		tags = []string{}
-	}
+	tags = normalizeTags(tags)
 
 	// Unsafe to ignore scanning fields not present in models.Campaigns.
 	var out models.Campaigns
-	if err := c.db.Select(&out, stmt, 0, pq.StringArray(statuses), pq.StringArray(tags), queryStr, getAll, pq.Array(permittedLists), offset, limit); err != nil {
+	if err := c.db.SelectContext(c.ctx, &out, stmt, 0, pq.StringArray(statuses), pq.StringArray(tags), queryStr, getAll, pq.Array(permittedLists), offset, limit, tagMatchAny); err != nil {
 		c.log.Printf("error fetching campaigns: %v", err)
 		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
@@ -57,7 +61,9 @@ func (c *Core) QueryCampaigns(searchStr string, statuses, tags []string, orderBy
 	}
 
 	total := 0
-	if len(out) > 0 {
+	if skipTotal {
+		total = models.TotalSkipped
+	} else if len(out) > 0 {
 		total = out[0].Total
 	}
 
@@ -95,8 +101,8 @@ func (c *Core) getCampaign(id int, uuid, archiveSlug string, tplType string) (mo
 	}
 
 	var out models.Campaigns
-	if err := c.q.GetCampaign.Select(&out, id, uu, archiveSlug, tplType); err != nil {
-		// if err := c.db.Select(&out, stmt, 0, pq.Array([]string{}), queryStr, 0, 1); err != nil {
+	if err := c.q.GetCampaign.SelectContext(c.ctx, &out, id, uu, archiveSlug, tplType); err != nil {
+		// if err := c.db.SelectContext(c.ctx, &out, stmt, 0, pq.Array([]string{}), queryStr, 0, 1); err != nil {
 		c.log.Printf("error fetching campaign: %v", err)
 		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
@@ -128,7 +134,7 @@ func (c *Core) getCampaign(id int, uuid, archiveSlug string, tplType string) (mo
 // that particular template is used, otherwise, the template saved on the campaign is.
 func (c *Core) GetCampaignForPreview(id, tplID int) (models.Campaign, error) {
 	var out models.Campaign
-	if err := c.q.GetCampaignForPreview.Get(&out, id, tplID); err != nil {
+	if err := c.q.GetCampaignForPreview.GetContext(c.ctx, &out, id, tplID); err != nil {
 		if err == sql.ErrNoRows {
 			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest,
 				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}"))
@@ -145,7 +151,7 @@ func (c *Core) GetCampaignForPreview(id, tplID int) (models.Campaign, error) {
 // GetArchivedCampaigns retrieves campaigns with a template body.
 func (c *Core) GetArchivedCampaigns(offset, limit int) (models.Campaigns, int, error) {
 	var out models.Campaigns
-	if err := c.q.GetArchivedCampaigns.Select(&out, offset, limit, campaignTplArchive); err != nil {
+	if err := c.q.GetArchivedCampaigns.SelectContext(c.ctx, &out, offset, limit, campaignTplArchive); err != nil {
 		c.log.Printf("error fetching public campaigns: %v", err)
 		return models.Campaigns{}, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
@@ -159,8 +165,21 @@ func (c *Core) GetArchivedCampaigns(offset, limit int) (models.Campaigns, int, e
 	return out, total, nil
 }
 
+// GetArchiveSitemap retrieves the UUIDs, slugs, and update times of public
+// archived campaigns for the archive sitemap.
+func (c *Core) GetArchiveSitemap(limit int) (models.Campaigns, error) {
+	var out models.Campaigns
+	if err := c.q.GetArchiveSitemap.SelectContext(c.ctx, &out, limit); err != nil {
+		c.log.Printf("error fetching archive sitemap: %v", err)
+		return models.Campaigns{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // CreateCampaign creates a new campaign.
-func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int) (models.Campaign, error) {
+func (c *Core) CreateCampaign(o models.Campaign, listIDs, excludeListIDs []int, mediaIDs []int) (models.Campaign, error) {
 	uu, err := uuid.NewV4()
 	if err != nil {
 		c.log.Printf("error generating UUID: %v", err)
@@ -170,7 +189,7 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 
 	// Insert and read ID.
 	var newID int
-	if err := c.q.CreateCampaign.Get(&newID,
+	if err := c.q.CreateCampaign.GetContext(c.ctx, &newID,
 		uu,
 		o.Type,
 		o.Name,
@@ -191,6 +210,16 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 		o.ArchiveMeta,
 		pq.Array(mediaIDs),
 		o.BodySource,
+		o.TrackingMode,
+		o.UTM,
+		o.ProgressMilestones,
+		o.ReplyTo,
+		o.ParentID,
+		o.PreviewText,
+		o.DynamicAttachments,
+		o.ShortLinks,
+		o.MaxRuntime,
+		pq.Array(excludeListIDs),
 	); err != nil {
 		if err == sql.ErrNoRows {
 			return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.noSubs"))
@@ -210,8 +239,8 @@ func (c *Core) CreateCampaign(o models.Campaign, listIDs []int, mediaIDs []int)
 }
 
 // UpdateCampaign updates a campaign.
-func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs []int, mediaIDs []int) (models.Campaign, error) {
-	_, err := c.q.UpdateCampaign.Exec(id,
+func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs, excludeListIDs []int, mediaIDs []int) (models.Campaign, error) {
+	_, err := c.q.UpdateCampaign.ExecContext(c.ctx, id,
 		o.Name,
 		o.Subject,
 		o.FromEmail,
@@ -229,7 +258,16 @@ func (c *Core) UpdateCampaign(id int, o models.Campaign, listIDs []int, mediaIDs
 		o.ArchiveTemplateID,
 		o.ArchiveMeta,
 		pq.Array(mediaIDs),
-		o.BodySource)
+		o.BodySource,
+		o.TrackingMode,
+		o.UTM,
+		o.ProgressMilestones,
+		o.ReplyTo,
+		o.PreviewText,
+		o.DynamicAttachments,
+		o.ShortLinks,
+		o.MaxRuntime,
+		pq.Array(excludeListIDs))
 	if err != nil {
 		c.log.Printf("error updating campaign: %v", err)
 		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
@@ -254,9 +292,13 @@ func (c *Core) UpdateCampaignStatus(id int, status string) (models.Campaign, err
 	errMsg := ""
 	switch status {
 	case models.CampaignStatusDraft:
-		if cm.Status != models.CampaignStatusScheduled {
+		if cm.Status != models.CampaignStatusScheduled && cm.Status != models.CampaignStatusPendingApproval {
 			errMsg = c.i18n.T("campaigns.onlyScheduledAsDraft")
 		}
+	case models.CampaignStatusPendingApproval:
+		if cm.Status != models.CampaignStatusDraft {
+			errMsg = c.i18n.T("campaigns.onlyDraftForApproval")
+		}
 	case models.CampaignStatusScheduled:
 		if cm.Status != models.CampaignStatusDraft && cm.Status != models.CampaignStatusPaused {
 			errMsg = c.i18n.T("campaigns.onlyDraftAsScheduled")
@@ -283,7 +325,7 @@ func (c *Core) UpdateCampaignStatus(id int, status string) (models.Campaign, err
 		return models.Campaign{}, echo.NewHTTPError(http.StatusBadRequest, errMsg)
 	}
 
-	res, err := c.q.UpdateCampaignStatus.Exec(cm.ID, status)
+	res, err := c.q.UpdateCampaignStatus.ExecContext(c.ctx, cm.ID, status)
 	if err != nil {
 		c.log.Printf("error updating campaign status: %v", err)
 
@@ -300,9 +342,140 @@ func (c *Core) UpdateCampaignStatus(id int, status string) (models.Campaign, err
 	return cm, nil
 }
 
+// ApproveCampaign approves a campaign that's pending approval and moves it back to draft.
+func (c *Core) ApproveCampaign(id, userID int, comment string) error {
+	res, err := c.q.ApproveCampaign.ExecContext(c.ctx, id, userID, comment)
+	if err != nil {
+		c.log.Printf("error approving campaign: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.notPendingApproval"))
+	}
+
+	return nil
+}
+
+// RejectCampaign rejects a campaign that's pending approval with a comment
+// and moves it back to draft.
+func (c *Core) RejectCampaign(id int, comment string) error {
+	res, err := c.q.RejectCampaign.ExecContext(c.ctx, id, comment)
+	if err != nil {
+		c.log.Printf("error rejecting campaign: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("campaigns.notPendingApproval"))
+	}
+
+	return nil
+}
+
+// SetCampaignSimulation sets whether a campaign that hasn't started yet is sent
+// in the simulation mode, where messages go through the entire pipeline but
+// aren't delivered.
+func (c *Core) SetCampaignSimulation(id int, simulate bool) error {
+	if _, err := c.q.UpdateCampaignSimulation.ExecContext(c.ctx, id, simulate); err != nil {
+		c.log.Printf("error updating campaign simulation: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// SaveCampaignDraft autosaves the unsaved subject and body of a campaign without
+// altering its live content. It returns false if the campaign can't be edited.
+func (c *Core) SaveCampaignDraft(id int, d models.CampaignDraft) (bool, error) {
+	res, err := c.q.UpdateCampaignDraft.ExecContext(c.ctx, id, d.Subject, d.Body, d.BodySource.String)
+	if err != nil {
+		c.log.Printf("error saving campaign draft: %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// GetCampaignDraft retrieves the autosaved draft of a campaign.
+func (c *Core) GetCampaignDraft(id int) (models.CampaignDraft, error) {
+	var out models.CampaignDraft
+	if err := c.q.GetCampaignDraft.GetContext(c.ctx, &out, id); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusNotFound,
+				c.i18n.T("campaigns.noDraft"))
+		}
+
+		c.log.Printf("error fetching campaign draft: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// RestoreCampaignDraft promotes the autosaved draft of a campaign to its content
+// and discards the draft.
+func (c *Core) RestoreCampaignDraft(id int) (models.Campaign, error) {
+	res, err := c.q.RestoreCampaignDraft.ExecContext(c.ctx, id)
+	if err != nil {
+		c.log.Printf("error restoring campaign draft: %v", err)
+		return models.Campaign{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return models.Campaign{}, echo.NewHTTPError(http.StatusNotFound,
+			c.i18n.T("campaigns.noDraft"))
+	}
+
+	return c.GetCampaign(id, "", "")
+}
+
+// DeleteCampaignDraft discards the autosaved draft of a campaign.
+func (c *Core) DeleteCampaignDraft(id int) error {
+	if _, err := c.q.DeleteCampaignDraft.ExecContext(c.ctx, id); err != nil {
+		c.log.Printf("error deleting campaign draft: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// SetCampaignApproval records the approval of a campaign's current content by the
+// given user. If userID is 0, the approval is revoked and draft and scheduled
+// campaigns go back to pending approval.
+func (c *Core) SetCampaignApproval(id, userID int) error {
+	if _, err := c.q.SetCampaignApproval.ExecContext(c.ctx, id, userID); err != nil {
+		c.log.Printf("error updating campaign approval: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetCampaignApprovers returns the e-mails of the users who can approve campaigns.
+func (c *Core) GetCampaignApprovers() ([]string, error) {
+	var out []string
+	if err := c.q.GetCampaignApprovers.SelectContext(c.ctx, &out, auth.SuperAdminRoleID); err != nil {
+		c.log.Printf("error fetching campaign approvers: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.users}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // UpdateCampaignArchive updates a campaign's archive properties.
-func (c *Core) UpdateCampaignArchive(id int, enabled bool, tplID int, meta models.JSON, archiveSlug string) error {
-	if _, err := c.q.UpdateCampaignArchive.Exec(id, enabled, archiveSlug, tplID, meta); err != nil {
+func (c *Core) UpdateCampaignArchive(id int, enabled bool, tplID int, meta models.JSON, archiveSlug, access, password string) error {
+	if _, err := c.q.UpdateCampaignArchive.ExecContext(c.ctx, id, enabled, archiveSlug, tplID, meta, access, password); err != nil {
 		c.log.Printf("error updating campaign: %v", err)
 
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -312,9 +485,39 @@ func (c *Core) UpdateCampaignArchive(id int, enabled bool, tplID int, meta model
 	return nil
 }
 
+// CheckCampaignArchivePassword checks the given password against the archive
+// password of a password protected campaign.
+func (c *Core) CheckCampaignArchivePassword(id int, password string) (bool, error) {
+	var ok bool
+	if err := c.q.CheckArchivePassword.GetContext(c.ctx, &ok, id, password); err != nil {
+		c.log.Printf("error checking campaign archive password: %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return ok, nil
+}
+
+// UpdateCampaignSeedSend saves the results of a campaign's seed list send.
+func (c *Core) UpdateCampaignSeedSend(id int, s models.CampaignSeedSend) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	if _, err := c.q.UpdateCampaignSeedSend.ExecContext(c.ctx, id, b); err != nil {
+		c.log.Printf("error updating campaign seed send: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
 // DeleteCampaign deletes a campaign.
 func (c *Core) DeleteCampaign(id int) error {
-	res, err := c.q.DeleteCampaign.Exec(id)
+	res, err := c.q.DeleteCampaign.ExecContext(c.ctx, id)
 	if err != nil {
 		c.log.Printf("error deleting campaign: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -342,7 +545,7 @@ func (c *Core) DeleteCampaigns(ids []int, query string, hasAllPerm bool, permitt
 		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("globals.messages.invalidData"))
 	}
 
-	if _, err := c.q.DeleteCampaigns.Exec(pq.Array(ids), queryStr, hasAllPerm, pq.Array(permittedLists)); err != nil {
+	if _, err := c.q.DeleteCampaigns.ExecContext(c.ctx, pq.Array(ids), queryStr, hasAllPerm, pq.Array(permittedLists)); err != nil {
 		c.log.Printf("error deleting campaigns: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
@@ -354,7 +557,7 @@ func (c *Core) DeleteCampaigns(ids []int, query string, hasAllPerm bool, permitt
 // CampaignHasLists checks if a campaign has any of the given list IDs.
 func (c *Core) CampaignHasLists(id int, listIDs []int) (bool, error) {
 	has := false
-	if err := c.q.CampaignHasLists.Get(&has, id, pq.Array(listIDs)); err != nil {
+	if err := c.q.CampaignHasLists.GetContext(c.ctx, &has, id, pq.Array(listIDs)); err != nil {
 		c.log.Printf("error checking campaign lists: %v", err)
 		return false, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
@@ -366,7 +569,7 @@ func (c *Core) CampaignHasLists(id int, listIDs []int) (bool, error) {
 // GetRunningCampaignStats returns the progress stats of running campaigns.
 func (c *Core) GetRunningCampaignStats() ([]models.CampaignStats, error) {
 	out := []models.CampaignStats{}
-	if err := c.q.GetCampaignStatus.Select(&out, models.CampaignStatusRunning); err != nil {
+	if err := c.q.GetCampaignStatus.SelectContext(c.ctx, &out, models.CampaignStatusRunning); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -378,10 +581,26 @@ func (c *Core) GetRunningCampaignStats() ([]models.CampaignStats, error) {
 		return nil, nil
 	}
 
+	for n, s := range out {
+		out[n].Delivered, out[n].OpenRate, out[n].ClickRate = models.CalcRates(s.Sent, s.Bounces, s.UniqueViews, s.UniqueClicks)
+	}
+
+	return out, nil
+}
+
+// GetCampaignBounceTypes returns the number of bounces of a campaign by type.
+func (c *Core) GetCampaignBounceTypes(id int) (models.BounceCounts, error) {
+	var out models.BounceCounts
+	if err := c.q.GetCampaignBounceTypes.GetContext(c.ctx, &out, id); err != nil {
+		c.log.Printf("error fetching campaign bounce counts: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.bounces}", "error", pqErrMsg(err)))
+	}
+
 	return out, nil
 }
 
-func (c *Core) GetCampaignAnalyticsCounts(campIDs []int, typ, fromDate, toDate string) ([]models.CampaignAnalyticsCount, error) {
+func (c *Core) GetCampaignAnalyticsCounts(campIDs []int, typ, fromDate, toDate string, includeBots bool) ([]models.CampaignAnalyticsCount, error) {
 	// Pick campaign view counts or click counts.
 	var stmt *sqlx.Stmt
 	switch typ {
@@ -399,8 +618,14 @@ func (c *Core) GetCampaignAnalyticsCounts(campIDs []int, typ, fromDate, toDate s
 		return nil, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("analytics.invalidDates"))
 	}
 
+	// Bounces aren't tracking events and don't have bot flags.
+	args := []any{pq.Array(campIDs), fromDate, toDate}
+	if typ != "bounces" {
+		args = append(args, includeBots)
+	}
+
 	out := []models.CampaignAnalyticsCount{}
-	if err := stmt.Select(&out, pq.Array(campIDs), fromDate, toDate); err != nil {
+	if err := stmt.SelectContext(c.ctx, &out, args...); err != nil {
 		c.log.Printf("error fetching campaign %s: %v", typ, err)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
@@ -409,10 +634,261 @@ func (c *Core) GetCampaignAnalyticsCounts(campIDs []int, typ, fromDate, toDate s
 	return out, nil
 }
 
+// GetCampaignCalendar returns campaigns that are scheduled or were running within the given window.
+// The expected recipient count of campaigns that are yet to start is their number of eligible
+// subscribers (cached briefly), and their to_send count for the rest.
+func (c *Core) GetCampaignCalendar(from, to time.Time, getAll bool, permittedLists []int) ([]models.CampaignCalendarItem, error) {
+	out := []models.CampaignCalendarItem{}
+	if err := c.q.GetCampaignCalendar.SelectContext(c.ctx, &out, from, to, getAll, pq.Array(permittedLists)); err != nil {
+		c.log.Printf("error fetching campaign calendar: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+	}
+
+	for n, camp := range out {
+		if camp.StartedAt.Valid {
+			out[n].ExpectedCount = camp.ToSend
+			continue
+		}
+
+		count, ok := c.counts.get(camp.ID)
+		if !ok {
+			_, total, err := c.GetCampaignListCounts(camp.ID)
+			if err != nil {
+				return nil, err
+			}
+			count = total
+			c.counts.set(camp.ID, count)
+		}
+		out[n].ExpectedCount = count
+	}
+
+	return out, nil
+}
+
+// GetCampaignListCounts returns the eligible and excluded subscriber counts on each list
+// of a campaign along with the total number of unique eligible subscribers.
+func (c *Core) GetCampaignListCounts(id int) ([]models.CampaignListCount, int, error) {
+	out := []models.CampaignListCount{}
+	if err := c.q.GetCampaignListCounts.SelectContext(c.ctx, &out, id); err != nil {
+		c.log.Printf("error fetching campaign list counts: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
+// GetCampaignOverlap returns the other campaigns scheduled or running within the given
+// window of a campaign's send time and the number of its subscribers they also target.
+// Only up to sampleSize subscriptions are checked and the rest are estimated.
+func (c *Core) GetCampaignOverlap(id int, window time.Duration, sampleSize int) ([]models.CampaignOverlap, error) {
+	out := []models.CampaignOverlap{}
+	if err := c.q.GetCampaignOverlap.SelectContext(c.ctx, &out, id, window.Seconds(), sampleSize); err != nil {
+		c.log.Printf("error fetching campaign overlap: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaigns}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetCampaignSendErrors returns the send errors saved from a campaign's last run.
+func (c *Core) GetCampaignSendErrors(id int) ([]models.CampaignSendError, error) {
+	var b types.JSONText
+	if err := c.q.GetCampaignSendErrors.GetContext(c.ctx, &b, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}"))
+		}
+
+		c.log.Printf("error fetching campaign errors: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	out := []models.CampaignSendError{}
+	if err := b.Unmarshal(&out); err != nil {
+		c.log.Printf("error unmarshalling campaign errors: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", err.Error()))
+	}
+
+	return out, nil
+}
+
+// GetCampaignHygiene returns the bounce hygiene summary saved when a campaign finished.
+// If there isn't one, the summary is computed from the bounces recorded so far.
+func (c *Core) GetCampaignHygiene(id int) (models.CampaignHygiene, error) {
+	var b types.JSONText
+	if err := c.q.GetCampaignStoredHygiene.GetContext(c.ctx, &b, id); err != nil {
+		if err == sql.ErrNoRows {
+			return models.CampaignHygiene{}, echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}"))
+		}
+
+		c.log.Printf("error fetching campaign hygiene: %v", err)
+		return models.CampaignHygiene{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	if len(b) > 0 && string(b) != "null" {
+		var out models.CampaignHygiene
+		if err := b.Unmarshal(&out); err == nil {
+			return out, nil
+		}
+	}
+
+	return c.computeCampaignHygiene(id)
+}
+
+// UpdateCampaignHygiene computes the bounce hygiene summary of a campaign and saves it.
+func (c *Core) UpdateCampaignHygiene(id int) (models.CampaignHygiene, error) {
+	out, err := c.computeCampaignHygiene(id)
+	if err != nil {
+		return out, err
+	}
+
+	b, err := json.Marshal(out)
+	if err != nil {
+		return out, err
+	}
+
+	if _, err := c.q.UpdateCampaignHygiene.ExecContext(c.ctx, id, b); err != nil {
+		c.log.Printf("error updating campaign hygiene: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// RollupCampaignStats computes and saves the stats rollups of the given finished
+// campaigns, or if there are no IDs, of up to limit finished campaigns whose rollups
+// are missing or aren't final yet. Rollups computed settle or more after a campaign
+// finished are final and its stats are read from them from then on. It returns
+// the number of rollups saved.
+func (c *Core) RollupCampaignStats(ids []int, settle time.Duration, limit int) (int, error) {
+	var campIDs any
+	if len(ids) > 0 {
+		campIDs = pq.Array(ids)
+		limit = len(ids)
+	}
+
+	var n int
+	if err := c.q.RollupCampaignStats.GetContext(c.ctx, &n, campIDs, settle.Seconds(), limit); err != nil {
+		c.log.Printf("error saving campaign stats rollups: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return n, nil
+}
+
+// computeCampaignHygiene computes the bounce hygiene summary of a campaign
+// from the bounces recorded against it.
+func (c *Core) computeCampaignHygiene(id int) (models.CampaignHygiene, error) {
+	// Bounce counts at which the configured actions kick in.
+	thresholds := map[string]int{}
+	for typ, a := range c.consts.BounceActions {
+		if a.Action != "" && a.Action != "none" && a.Count > 0 {
+			thresholds[typ] = a.Count
+		}
+	}
+	th, _ := json.Marshal(thresholds)
+
+	var out models.CampaignHygiene
+	if err := c.q.GetCampaignHygiene.GetContext(c.ctx, &out, id, th); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.campaign}"))
+		}
+
+		c.log.Printf("error computing campaign hygiene: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.campaign}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// InsertCampaignRecipients adds a batch of ad-hoc recipients to a campaign and
+// returns the number of new recipients. attribs are JSON encoded attribute maps.
+func (c *Core) InsertCampaignRecipients(campID int, emails, names, attribs []string) (int, error) {
+	var n int
+	if err := c.q.InsertCampaignRecipients.GetContext(c.ctx, &n, campID, pq.Array(emails), pq.Array(names), pq.Array(attribs)); err != nil {
+		c.log.Printf("error inserting campaign recipients: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return n, nil
+}
+
+// CountCampaignRecipients returns the number of ad-hoc recipients of a campaign.
+func (c *Core) CountCampaignRecipients(campID int) (int, error) {
+	var n int
+	if err := c.q.CountCampaignRecipients.GetContext(c.ctx, &n, campID); err != nil {
+		c.log.Printf("error counting campaign recipients: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return n, nil
+}
+
+// DeleteCampaignRecipients deletes all the ad-hoc recipients of a campaign.
+func (c *Core) DeleteCampaignRecipients(campID int) error {
+	if _, err := c.q.DeleteCampaignRecipients.ExecContext(c.ctx, campID); err != nil {
+		c.log.Printf("error deleting campaign recipients: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetDomainStats returns the engagement and bounce counts grouped by the recipients'
+// e-mail domain for an optional campaign (0 for all) and date range (YYYY-MM-DD,
+// empty for open-ended). The top limit domains are returned and the rest are
+// aggregated into an 'other' row.
+func (c *Core) GetDomainStats(campID int, from, to string, limit int) ([]models.DomainStats, error) {
+	_ = c.refreshCache(matDomainStats, false)
+
+	out := []models.DomainStats{}
+	if err := c.q.GetDomainStats.SelectContext(c.ctx, &out, campID,
+		null.NewString(from, from != ""), null.NewString(to, to != ""), limit); err != nil {
+		c.log.Printf("error fetching domain stats: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
+	}
+
+	for n, d := range out {
+		if d.Sent == 0 {
+			continue
+		}
+		out[n].ViewRate = percent(d.Views, d.Sent)
+		out[n].ClickRate = percent(d.Clicks, d.Sent)
+		out[n].BounceRate = percent(d.BouncesSoft+d.BouncesHard+d.BouncesComplaint, d.Sent)
+	}
+
+	return out, nil
+}
+
+// percent returns n as a percentage of total rounded to two decimals.
+func percent(n, total int) float64 {
+	return math.Round(float64(n)/float64(total)*10000) / 100
+}
+
 // GetCampaignAnalyticsLinks returns link click analytics for the given campaign IDs.
-func (c *Core) GetCampaignAnalyticsLinks(campIDs []int, typ, fromDate, toDate string) ([]models.CampaignAnalyticsLink, error) {
+func (c *Core) GetCampaignAnalyticsLinks(campIDs []int, typ, fromDate, toDate string, includeBots bool) ([]models.CampaignAnalyticsLink, error) {
 	out := []models.CampaignAnalyticsLink{}
-	if err := c.q.GetCampaignLinkCounts.Select(&out, pq.Array(campIDs), fromDate, toDate); err != nil {
+	if err := c.q.GetCampaignLinkCounts.SelectContext(c.ctx, &out, pq.Array(campIDs), fromDate, toDate, includeBots); err != nil {
 		c.log.Printf("error fetching campaign %s: %v", typ, err)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.analytics}", "error", pqErrMsg(err)))
@@ -422,8 +898,9 @@ func (c *Core) GetCampaignAnalyticsLinks(campIDs []int, typ, fromDate, toDate st
 }
 
 // RegisterCampaignView registers a subscriber's view on a campaign.
-func (c *Core) RegisterCampaignView(campUUID, subUUID string) error {
-	if _, err := c.q.RegisterCampaignView.Exec(campUUID, subUUID); err != nil {
+// isBot flags the view as likely generated by a bot.
+func (c *Core) RegisterCampaignView(campUUID, subUUID string, isBot bool) error {
+	if _, err := c.q.RegisterCampaignView.ExecContext(c.ctx, campUUID, subUUID, isBot); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Column == "campaign_id" {
 			return nil
 		}
@@ -436,10 +913,11 @@ func (c *Core) RegisterCampaignView(campUUID, subUUID string) error {
 }
 
 // RegisterCampaignLinkClick registers a subscriber's link click on a campaign.
-func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string) (string, error) {
+// isBot flags the click as likely generated by a bot.
+func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string, isBot bool) (string, error) {
 	var url string
-	if err := c.q.RegisterLinkClick.Get(&url, linkUUID, campUUID, subUUID); err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Column == "link_id" {
+	if err := c.q.RegisterLinkClick.GetContext(c.ctx, &url, linkUUID, campUUID, subUUID, isBot); err != nil {
+		if err == sql.ErrNoRows {
 			return "", echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("public.invalidLink"))
 		}
 
@@ -450,9 +928,25 @@ func (c *Core) RegisterCampaignLinkClick(linkUUID, campUUID, subUUID string) (st
 	return url, nil
 }
 
+// GetShortLink returns the link, campaign, and subscriber UUIDs that a short link's
+// slug and the IDs in its ref resolve to.
+func (c *Core) GetShortLink(slug string, campID, subID int) (models.ShortLink, error) {
+	var out models.ShortLink
+	if err := c.q.GetShortLink.GetContext(c.ctx, &out, slug, campID, subID); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("public.invalidLink"))
+		}
+
+		c.log.Printf("error fetching short link: %s", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("public.errorProcessingRequest"))
+	}
+
+	return out, nil
+}
+
 // DeleteCampaignViews deletes campaign views older than a given date.
 func (c *Core) DeleteCampaignViews(before time.Time) error {
-	if _, err := c.q.DeleteCampaignViews.Exec(before); err != nil {
+	if _, err := c.q.DeleteCampaignViews.ExecContext(c.ctx, before); err != nil {
 		c.log.Printf("error deleting campaign views: %s", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("public.errorProcessingRequest"))
 	}
@@ -460,9 +954,21 @@ func (c *Core) DeleteCampaignViews(before time.Time) error {
 	return nil
 }
 
+// DeleteSimulationData deletes the send and view records of simulated campaign
+// runs and returns their counts.
+func (c *Core) DeleteSimulationData() (models.SimulationPurge, error) {
+	var out models.SimulationPurge
+	if err := c.q.DeleteSimulationData.GetContext(c.ctx, &out); err != nil {
+		c.log.Printf("error deleting simulation data: %s", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("public.errorProcessingRequest"))
+	}
+
+	return out, nil
+}
+
 // DeleteCampaignLinkClicks deletes campaign views older than a given date.
 func (c *Core) DeleteCampaignLinkClicks(before time.Time) error {
-	if _, err := c.q.DeleteCampaignLinkClicks.Exec(before); err != nil {
+	if _, err := c.q.DeleteCampaignLinkClicks.ExecContext(c.ctx, before); err != nil {
 		c.log.Printf("error deleting campaign link clicks: %s", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, c.i18n.Ts("public.errorProcessingRequest"))
 	}
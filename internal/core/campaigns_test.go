@@ -0,0 +1,857 @@
+package core
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+func TestCampaignOverlap(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	newList := func(name, optin string) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO lists (uuid, name, type, optin) VALUES (gen_random_uuid(), $1, 'private', $2) RETURNING id`,
+			name, optin); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	newSub := func(email, status string, subs map[int]string) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO subscribers (uuid, email, name, status) VALUES (gen_random_uuid(), $1, $1, $2) RETURNING id`,
+			email, status); err != nil {
+			t.Fatal(err)
+		}
+		for listID, st := range subs {
+			if _, err := db.Exec(`INSERT INTO subscriber_lists (subscriber_id, list_id, status) VALUES ($1, $2, $3)`, id, listID, st); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return id
+	}
+	newCamp := func(name, status, typ string, sendAt *time.Time, lists ...int) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, type, send_at)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', '', 'email', $2, $3, $4) RETURNING id`,
+			name, status, typ, sendAt); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range lists {
+			if _, err := db.Exec(`INSERT INTO campaign_lists (campaign_id, list_id) VALUES ($1, $2)`, id, l); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return id
+	}
+	at := func(d time.Duration) *time.Time {
+		v := time.Now().Add(d)
+		return &v
+	}
+
+	var (
+		single  = newList("single", "single")
+		double  = newList("double", "double")
+		exclude = newList("exclude", "single")
+	)
+
+	// The campaign targets s1, s2, and s5.
+	newSub("s1@example.com", "enabled", map[int]string{single: "unconfirmed", double: "confirmed"})
+	newSub("s2@example.com", "enabled", map[int]string{single: "confirmed"})
+	newSub("s3@example.com", "enabled", map[int]string{double: "unconfirmed"})
+	newSub("s4@example.com", "blocklisted", map[int]string{single: "confirmed"})
+	newSub("s5@example.com", "enabled", map[int]string{single: "confirmed", exclude: "confirmed"})
+	newSub("s6@example.com", "enabled", map[int]string{single: "unsubscribed"})
+
+	campID := newCamp("camp", "draft", "regular", at(time.Hour), single, double)
+
+	var (
+		// Double opt-in lists only count confirmed subscriptions: s1.
+		dbl = newCamp("double", "scheduled", "regular", at(2*time.Hour), double)
+
+		// s5 is on the excluded list: s1 and s2.
+		excl = newCamp("excluded", "scheduled", "regular", at(3*time.Hour), single)
+
+		// Opt-in campaigns only go to unconfirmed double opt-in subscriptions: s3.
+		optin = newCamp("optin", "scheduled", "optin", at(4*time.Hour), double)
+
+		// Ad-hoc campaigns target the subscribers among their recipients: s2.
+		adhoc = newCamp("adhoc", "scheduled", "adhoc", at(5*time.Hour))
+
+		// Running campaigns are sending now: s1, s2, and s5.
+		running = newCamp("running", "running", "regular", nil, single)
+	)
+	newCamp("later", "scheduled", "regular", at(72*time.Hour), single)
+
+	if _, err := db.Exec(`INSERT INTO campaign_exclude_lists (campaign_id, list_id) VALUES ($1, $2)`, excl, exclude); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO campaign_recipients (campaign_id, email) VALUES ($1, 's2@example.com'), ($1, 'nobody@example.com')`, adhoc); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := c.GetCampaignOverlap(campID, 24*time.Hour, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []struct {
+		id, overlap int
+	}{{running, 3}, {dbl, 1}, {excl, 2}, {optin, 0}, {adhoc, 1}}
+	if len(out) != len(exp) {
+		t.Fatalf("expected %d campaigns, got %+v", len(exp), out)
+	}
+	for i, e := range exp {
+		if out[i].CampaignID != e.id || out[i].Overlap != e.overlap || out[i].Estimated {
+			t.Errorf("campaign %d: expected id %d with overlap %d, got %+v", i, e.id, e.overlap, out[i])
+		}
+	}
+
+	// A sample of the subscribers with the lowest IDs (s1 and s2) is estimated, and
+	// the estimate is the same every time.
+	for n := 0; n < 3; n++ {
+		out, err := c.GetCampaignOverlap(campID, 24*time.Hour, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(out) == 0 || out[0].CampaignID != running || out[0].Overlap != 2 || !out[0].Estimated {
+			t.Fatalf("unexpected sampled overlap %+v", out)
+		}
+	}
+
+	// Campaigns outside the window aren't checked.
+	out, err = c.GetCampaignOverlap(campID, 90*time.Minute, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].CampaignID != running || out[1].CampaignID != dbl {
+		t.Fatalf("unexpected overlap in a short window %+v", out)
+	}
+}
+
+func TestCampaignStatsRollup(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	var campID, linkID int
+	if err := db.Get(&campID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, sent)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com', '', 'email', 'finished', 4) RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&linkID, `INSERT INTO links (uuid, url) VALUES (gen_random_uuid(), 'https://example.com') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	subs := make([]int, 3)
+	for i := range subs {
+		if err := db.Get(&subs[i], `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), $1, 'sub') RETURNING id`,
+			"sub"+strconv.Itoa(i)+"@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Views and clicks with repeats and bots, bounces of every type, and an unsubscription.
+	for _, q := range []string{
+		`INSERT INTO campaign_views (campaign_id, subscriber_id, is_bot) VALUES ($1, $2, false), ($1, $2, false), ($1, $3, false), ($1, $4, true)`,
+		`INSERT INTO link_clicks (campaign_id, link_id, subscriber_id, is_bot) VALUES ($1, $5, $2, false), ($1, $5, $3, false), ($1, $5, $3, false), ($1, $5, NULL, true)`,
+		`INSERT INTO bounces (subscriber_id, campaign_id, type) VALUES ($4, $1, 'hard'), ($4, $1, 'soft'), ($3, $1, 'complaint')`,
+		`INSERT INTO unsubscribe_events (subscriber_id, campaign_id) VALUES ($3, $1)`,
+	} {
+		if _, err := db.Exec(q, campID, subs[0], subs[1], subs[2], linkID); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+
+	type stats struct{ views, uniqViews, clicks, uniqClicks, bounces int }
+	get := func() stats {
+		camp, err := c.GetCampaign(campID, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stats{camp.Views, camp.UniqueViews, camp.Clicks, camp.UniqueClicks, camp.Bounces}
+	}
+
+	live := get()
+	if exp := (stats{3, 2, 3, 2, 3}); live != exp {
+		t.Fatalf("expected the live stats %+v, got %+v", exp, live)
+	}
+
+	// A rollup that isn't final yet matches the live stats, which are still computed.
+	if n, err := c.RollupCampaignStats([]int{campID}, time.Hour, 10); err != nil || n != 1 {
+		t.Fatalf("expected 1 rollup, got %d: %v", n, err)
+	}
+	var r struct {
+		stats
+		Sent, Delivered, Hard, Soft, Complaints, Unsubs int
+		Final                                           bool
+	}
+	row := func() {
+		if err := db.QueryRow(`SELECT views, unique_views, clicks, unique_clicks, bounces, sent, delivered,
+			hard_bounces, soft_bounces, complaints, unsubscribes, final FROM campaign_stats WHERE campaign_id = $1`, campID).Scan(
+			&r.views, &r.uniqViews, &r.clicks, &r.uniqClicks, &r.bounces, &r.Sent, &r.Delivered,
+			&r.Hard, &r.Soft, &r.Complaints, &r.Unsubs, &r.Final); err != nil {
+			t.Fatal(err)
+		}
+	}
+	row()
+	if r.stats != live || r.Final {
+		t.Fatalf("expected a rollup matching the live stats %+v, got %+v", live, r)
+	}
+	if r.Sent != 4 || r.Delivered != 1 || r.Hard != 1 || r.Soft != 1 || r.Complaints != 1 || r.Unsubs != 1 {
+		t.Fatalf("unexpected rollup counts %+v", r)
+	}
+
+	// A new view is counted live until the rollup is final.
+	if _, err := db.Exec(`INSERT INTO campaign_views (campaign_id, subscriber_id) VALUES ($1, $2)`, campID, subs[2]); err != nil {
+		t.Fatal(err)
+	}
+	live = get()
+	if live.views != 4 || live.uniqViews != 3 {
+		t.Fatalf("expected the new view in the live stats, got %+v", live)
+	}
+
+	// The final rollup matches the live stats and they're read from it from then on.
+	if _, err := c.RollupCampaignStats([]int{campID}, 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	row()
+	if r.stats != live || !r.Final {
+		t.Fatalf("expected a final rollup matching the live stats %+v, got %+v", live, r)
+	}
+	if _, err := db.Exec(`INSERT INTO campaign_views (campaign_id, subscriber_id) VALUES ($1, $2)`, campID, subs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if s := get(); s != live {
+		t.Errorf("expected the stats of the final rollup %+v, got %+v", live, s)
+	}
+
+	// Final rollups aren't picked up again without IDs.
+	if n, err := c.RollupCampaignStats(nil, 0, 10); err != nil || n != 0 {
+		t.Errorf("expected no rollups, got %d: %v", n, err)
+	}
+}
+
+func TestCampaignCalendar(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	var listID int
+	if err := db.Get(&listID, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'list', 'private') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	addSub := func(email string) {
+		if _, err := db.Exec(`WITH s AS (INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), $1, $1) RETURNING id)
+			INSERT INTO subscriber_lists (subscriber_id, list_id, status) SELECT id, $2, 'confirmed' FROM s`, email, listID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addSub("s1@example.com")
+	addSub("s2@example.com")
+
+	var (
+		from = time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+		to   = time.Date(2026, 3, 31, 23, 59, 59, 0, time.UTC)
+	)
+	newCamp := func(name, status string, sendAt, startedAt, updatedAt *time.Time, toSend int) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, send_at, started_at, updated_at, to_send)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', '', 'email', $2, $3, $4, COALESCE($5, NOW()), $6) RETURNING id`,
+			name, status, sendAt, startedAt, updatedAt, toSend); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO campaign_lists (campaign_id, list_id, list_name) VALUES ($1, $2, 'list')`, id, listID); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	at := func(tm time.Time) *time.Time { return &tm }
+
+	var (
+		// Scheduled exactly on the boundaries of the window.
+		first = newCamp("first", "scheduled", at(from), nil, nil, 0)
+		last  = newCamp("last", "scheduled", at(to), nil, nil, 0)
+
+		// Started before the window and finished within it.
+		finished = newCamp("finished", "finished", nil, at(from.Add(-48*time.Hour)), at(from.Add(time.Hour)), 10)
+
+		// Started before the window and still running.
+		running = newCamp("running", "running", nil, at(from.Add(-time.Hour)), nil, 20)
+	)
+
+	// Outside the window.
+	newCamp("before", "scheduled", at(from.Add(-time.Second)), nil, nil, 0)
+	newCamp("after", "scheduled", at(to.Add(time.Second)), nil, nil, 0)
+	newCamp("ended", "finished", nil, at(from.Add(-48*time.Hour)), at(from.Add(-time.Hour)), 10)
+
+	out, err := c.GetCampaignCalendar(from, to, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []struct {
+		id, count int
+	}{{finished, 10}, {running, 20}, {first, 2}, {last, 2}}
+	if len(out) != len(exp) {
+		t.Fatalf("expected %d campaigns, got %+v", len(exp), out)
+	}
+	for i, e := range exp {
+		if out[i].ID != e.id || out[i].ExpectedCount != e.count {
+			t.Errorf("campaign %d: expected id %d with count %d, got %+v", i, e.id, e.count, out[i])
+		}
+	}
+	if !out[0].FinishedAt.Valid || out[1].FinishedAt.Valid {
+		t.Errorf("unexpected finish times %+v", out[:2])
+	}
+
+	// Campaigns on lists the user can't access aren't returned.
+	if out, err := c.GetCampaignCalendar(from, to, false, []int{listID + 1}); err != nil || len(out) != 0 {
+		t.Fatalf("expected no campaigns, got %+v: %v", out, err)
+	}
+
+	// The eligible counts are cached until they expire.
+	addSub("s3@example.com")
+	count := func() int {
+		out, err := c.GetCampaignCalendar(from, from, true, nil)
+		if err != nil || len(out) == 0 || out[len(out)-1].ID != first {
+			t.Fatalf("unexpected campaigns %+v: %v", out, err)
+		}
+		return out[len(out)-1].ExpectedCount
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("expected the cached count 2, got %d", n)
+	}
+	c.counts.now = func() time.Time { return time.Now().Add(campCountTTL + time.Second) }
+	if n := count(); n != 3 {
+		t.Fatalf("expected the fresh count 3, got %d", n)
+	}
+}
+
+func TestQueryCampaignsTags(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	for _, camp := range []struct {
+		name string
+		tags string
+	}{
+		{"oct-promo-x", "{promo,october,brand-x}"},
+		{"oct-promo-y", "{promo,october,brand-y}"},
+		{"nov-promo-x", "{promo,november,brand-x}"},
+		{"newsletter", "{newsletter}"},
+		{"untagged", "{}"},
+	} {
+		if _, err := db.Exec(`INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, tags)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', '', 'email', $2)`, camp.name, camp.tags); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name  string
+		tags  []string
+		any   bool
+		names []string
+	}{
+		{"no filter", nil, false, []string{"newsletter", "nov-promo-x", "oct-promo-x", "oct-promo-y", "untagged"}},
+		{"all of the tags", []string{"promo", "october", "brand-x"}, false, []string{"oct-promo-x"}},
+		{"all of two tags", []string{"promo", "brand-x"}, false, []string{"nov-promo-x", "oct-promo-x"}},
+		{"any of the tags", []string{"brand-x", "newsletter"}, true, []string{"newsletter", "nov-promo-x", "oct-promo-x"}},
+		{"normalized filter", []string{" PROMO ", "Brand X"}, false, []string{"nov-promo-x", "oct-promo-x"}},
+		{"no match", []string{"promo", "newsletter"}, false, nil},
+		{"unknown tag", []string{"nope"}, true, nil},
+	}
+	for _, tc := range cases {
+		out, total, err := c.QueryCampaigns("", nil, tc.tags, tc.any, "name", SortAsc, true, nil, 0, 0, false)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		var names []string
+		for _, camp := range out {
+			names = append(names, camp.Name)
+		}
+		if !slices.Equal(names, tc.names) || total != len(tc.names) {
+			t.Errorf("%s: expected %v, got %v (%d)", tc.name, tc.names, names, total)
+		}
+	}
+}
+
+func TestGetDomainStats(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	var listID int
+	if err := db.Get(&listID, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'list', 'private') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscribers on several domains. Domains are case-insensitive.
+	subs := map[string]int{}
+	for _, email := range []string{
+		"a@gmail.com", "b@gmail.com", "c@gmail.com", "d@gmail.com", "e@gmail.com", "F@GMail.com",
+		"a@outlook.com", "b@outlook.com", "c@outlook.com",
+		"a@t-online.de", "b@t-online.de",
+		"a@yahoo.com",
+	} {
+		var id int
+		if err := db.Get(&id, `WITH s AS (INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), $1, $1) RETURNING id)
+			INSERT INTO subscriber_lists (subscriber_id, list_id, status) SELECT id, $2, 'confirmed' FROM s RETURNING subscriber_id`,
+			email, listID); err != nil {
+			t.Fatal(err)
+		}
+		subs[email] = id
+	}
+
+	// Unsubscribed recipients aren't counted as sent.
+	if _, err := db.Exec(`WITH s AS (INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), 'unsub@gmail.com', 'unsub') RETURNING id)
+		INSERT INTO subscriber_lists (subscriber_id, list_id, status) SELECT id, $1, 'unsubscribed' FROM s`, listID); err != nil {
+		t.Fatal(err)
+	}
+
+	newCamp := func(name, status string) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, started_at)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', '', 'email', $2, '2026-03-02 10:00:00+00') RETURNING id`,
+			name, status); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO campaign_lists (campaign_id, list_id, list_name) VALUES ($1, $2, 'list')`, id, listID); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	var (
+		camp  = newCamp("camp", "finished")
+		other = newCamp("other", "finished")
+		_     = newCamp("draft", "draft")
+	)
+
+	// Engagement on the day after the campaign started.
+	const day = "2026-03-03 10:00:00+00"
+	for _, q := range []struct {
+		query string
+		args  []any
+	}{
+		{`INSERT INTO campaign_views (campaign_id, subscriber_id, is_bot, created_at) VALUES ($1, $2, false, $5), ($1, $3, false, $5), ($1, $4, false, $5), ($1, $2, true, $5)`,
+			[]any{camp, subs["a@gmail.com"], subs["F@GMail.com"], subs["a@outlook.com"], day}},
+		{`WITH l AS (INSERT INTO links (uuid, url) VALUES (gen_random_uuid(), 'https://example.com') RETURNING id)
+			INSERT INTO link_clicks (campaign_id, link_id, subscriber_id, is_bot, created_at) SELECT $1, id, $2, false, $3 FROM l`,
+			[]any{camp, subs["a@gmail.com"], day}},
+		{`INSERT INTO bounces (subscriber_id, campaign_id, type, created_at) VALUES ($1, $4, 'hard', $5), ($2, $4, 'soft', $5), ($3, $4, 'complaint', $5)`,
+			[]any{subs["a@t-online.de"], subs["b@t-online.de"], subs["a@outlook.com"], camp, day}},
+
+		// Another campaign's engagement.
+		{`INSERT INTO campaign_views (campaign_id, subscriber_id, created_at) VALUES ($1, $2, $3)`, []any{other, subs["a@yahoo.com"], day}},
+	} {
+		if _, err := db.Exec(q.query, q.args...); err != nil {
+			t.Fatalf("%s: %v", q.query, err)
+		}
+	}
+
+	get := func(campID int, from, to string, limit int) map[string]models.DomainStats {
+		t.Helper()
+
+		out, err := c.GetDomainStats(campID, from, to, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := map[string]models.DomainStats{}
+		for _, d := range out {
+			m[d.Domain] = d
+		}
+		if len(m) != len(out) {
+			t.Fatalf("duplicate domains in %+v", out)
+		}
+		return m
+	}
+
+	// The top domains by sent count and the rest as 'other'.
+	got := get(camp, "", "", 2)
+	exp := map[string]models.DomainStats{
+		"gmail.com":   {Domain: "gmail.com", Sent: 6, Views: 2, Clicks: 1, ViewRate: 33.33, ClickRate: 16.67},
+		"outlook.com": {Domain: "outlook.com", Sent: 3, Views: 1, BouncesComplaint: 1, ViewRate: 33.33, BounceRate: 33.33},
+		"other":       {Domain: "other", Sent: 3, BouncesSoft: 1, BouncesHard: 1, BounceRate: 66.67},
+	}
+	if len(got) != len(exp) {
+		t.Fatalf("expected %d domains, got %+v", len(exp), got)
+	}
+	for k, e := range exp {
+		if got[k] != e {
+			t.Errorf("%s: expected %+v, got %+v", k, e, got[k])
+		}
+	}
+
+	// Without a limit on the domains.
+	got = get(camp, "", "", 10)
+	if len(got) != 4 || got["t-online.de"].BouncesHard != 1 || got["yahoo.com"].Sent != 1 || got["yahoo.com"].Views != 0 {
+		t.Errorf("unexpected domains %+v", got)
+	}
+
+	// All campaigns.
+	got = get(0, "2026-03-01", "2026-03-31", 10)
+	if got["gmail.com"].Sent != 12 || got["yahoo.com"].Views != 1 {
+		t.Errorf("unexpected domains across campaigns %+v", got)
+	}
+
+	// Only the events on the dates in the range are counted.
+	got = get(camp, "2026-03-03", "2026-03-03", 10)
+	if d := got["gmail.com"]; d.Sent != 0 || d.Views != 2 || d.ViewRate != 0 {
+		t.Errorf("unexpected stats for the day %+v", d)
+	}
+	if got = get(camp, "2026-03-04", "", 10); len(got) != 0 {
+		t.Errorf("expected no stats after the events, got %+v", got)
+	}
+}
+
+func TestCampaignHygiene(t *testing.T) {
+	c, db := newTestCore(t, Constants{BounceActions: map[string]models.BounceAction{
+		models.BounceTypeHard:      {Count: 1, Action: "blocklist"},
+		models.BounceTypeSoft:      {Count: 2, Action: "delete"},
+		models.BounceTypeComplaint: {Count: 1, Action: "none"},
+	}})
+
+	var listID int
+	if err := db.Get(&listID, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'list', 'private') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	newCamp := func(name string, started string, hygiene any) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, sent, started_at, hygiene)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', '', 'email', 'finished', 10, NOW() - $2::INTERVAL, $3) RETURNING id`,
+			name, started, hygiene); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO campaign_lists (campaign_id, list_id) VALUES ($1, $2)`, id, listID); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	prevID := newCamp("prev", "2 days", `{"quality_score": 90}`)
+	campID := newCamp("camp", "1 hour", nil)
+
+	subs := map[string]int{}
+	for _, email := range []string{"a@gmail.com", "b@Gmail.com", "c@yahoo.com", "d@outlook.com"} {
+		var id int
+		if err := db.Get(&id, `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), $1, 'sub') RETURNING id`, email); err != nil {
+			t.Fatal(err)
+		}
+		subs[email] = id
+	}
+
+	// Earlier bounces on the previous campaign. b had already crossed the hard
+	// bounce threshold and d is one soft bounce short of it.
+	bounce := func(email string, campID int, typ, ago string) {
+		t.Helper()
+		if _, err := db.Exec(`INSERT INTO bounces (subscriber_id, campaign_id, type, created_at) VALUES ($1, $2, $3, NOW() - $4::INTERVAL)`,
+			subs[email], campID, typ, ago); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bounce("b@Gmail.com", prevID, "hard", "2 days")
+	bounce("d@outlook.com", prevID, "soft", "2 days")
+
+	bounce("a@gmail.com", campID, "hard", "30 minutes")
+	bounce("b@Gmail.com", campID, "hard", "30 minutes")
+	bounce("c@yahoo.com", campID, "hard", "30 minutes")
+	bounce("d@outlook.com", campID, "soft", "30 minutes")
+	bounce("d@outlook.com", campID, "complaint", "20 minutes")
+
+	check := func(h models.CampaignHygiene) {
+		t.Helper()
+
+		if h.CampaignID != campID || h.Sent != 10 || h.BouncesHard != 3 || h.BouncesSoft != 1 || h.BouncesComplaint != 1 {
+			t.Errorf("unexpected counts %+v", h)
+		}
+
+		// a and c crossed the hard bounce threshold and d the soft one. Complaints have no action.
+		if h.ThresholdCrossed != 3 {
+			t.Errorf("expected 3 subscribers to cross the thresholds, got %d", h.ThresholdCrossed)
+		}
+
+		// (3 hard + 1 complaint) of 10 sent.
+		if h.QualityScore != 60 || !h.PrevQualityScore.Valid || h.PrevQualityScore.Float64 != 90 {
+			t.Errorf("expected the score 60 after 90, got %v after %v", h.QualityScore, h.PrevQualityScore)
+		}
+
+		var doms []struct {
+			Domain string `json:"domain"`
+			Count  int    `json:"count"`
+		}
+		if err := h.Domains.Unmarshal(&doms); err != nil {
+			t.Fatal(err)
+		}
+		if len(doms) != 2 || doms[0].Domain != "gmail.com" || doms[0].Count != 2 || doms[1].Domain != "yahoo.com" || doms[1].Count != 1 {
+			t.Errorf("unexpected domains %s", h.Domains)
+		}
+	}
+
+	// Before the campaign's summary is saved, it's computed on the fly.
+	h, err := c.GetCampaignHygiene(campID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(h)
+
+	var n int
+	if err := db.Get(&n, `SELECT COUNT(*) FROM campaigns WHERE id = $1 AND hygiene IS NULL`, campID); err != nil || n != 1 {
+		t.Fatalf("expected the summary not to be saved on a get: %v", err)
+	}
+
+	// The saved summary is returned as is, even as more bounces come in.
+	h, err = c.UpdateCampaignHygiene(campID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(h)
+
+	bounce("c@yahoo.com", campID, "hard", "10 minutes")
+	if h, err = c.GetCampaignHygiene(campID); err != nil {
+		t.Fatal(err)
+	}
+	check(h)
+
+	if _, err := c.GetCampaignHygiene(campID + 100); err == nil {
+		t.Error("expected an error for an unknown campaign")
+	}
+}
+
+func TestCampaignRecipients(t *testing.T) {
+	c, db := newTestCore(t, Constants{BounceActions: map[string]models.BounceAction{
+		models.BounceTypeHard: {Count: 1, Action: "blocklist"},
+	}})
+
+	var camp struct {
+		ID   int    `db:"id"`
+		UUID string `db:"uuid"`
+	}
+	if err := db.Get(&camp, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, type)
+		VALUES (gen_random_uuid(), 'adhoc', 'adhoc', 'from@example.com', '', 'email', 'adhoc') RETURNING id, uuid`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Duplicates across batches are ignored.
+	for _, b := range [][]string{{"a@example.com", "b@example.com"}, {"b@example.com", "c@example.com"}} {
+		if _, err := c.InsertCampaignRecipients(camp.ID, b, []string{"x", "y"}, []string{"{}", `{"plan": "pro"}`}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n, err := c.CountCampaignRecipients(camp.ID); err != nil || n != 3 {
+		t.Fatalf("expected 3 recipients, got %d: %v", n, err)
+	}
+
+	// Bounces on recipients are recorded on the recipient and not as subscriber bounces.
+	var uuid string
+	if err := db.Get(&uuid, `SELECT uuid FROM campaign_recipients WHERE email = 'a@example.com'`); err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []models.Bounce{
+		{SubscriberUUID: uuid, CampaignUUID: camp.UUID, Type: models.BounceTypeHard, Source: "api", Meta: json.RawMessage(`{}`)},
+		{Email: "B@example.com", CampaignUUID: camp.UUID, Type: models.BounceTypeHard, Source: "api", Meta: json.RawMessage(`{}`)},
+	} {
+		if err := c.RecordBounce(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var bounced []string
+	if err := db.Select(&bounced, `SELECT email FROM campaign_recipients WHERE bounce_type = 'hard' ORDER BY email`); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(bounced, []string{"a@example.com", "b@example.com"}) {
+		t.Errorf("expected the recipients to be bounced, got %v", bounced)
+	}
+	var n int
+	if err := db.Get(&n, `SELECT COUNT(*) FROM bounces`); err != nil || n != 0 {
+		t.Errorf("expected no subscriber bounces, got %d: %v", n, err)
+	}
+
+	if err := c.DeleteCampaignRecipients(camp.ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := c.CountCampaignRecipients(camp.ID); err != nil || n != 0 {
+		t.Errorf("expected the recipients to be deleted, got %d: %v", n, err)
+	}
+}
+
+// TestCampaignEngagementStats checks the total and unique views and clicks and
+// the delivered-based rates of a campaign, its running stats, and the dashboard.
+func TestCampaignEngagementStats(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	var campID, linkID int
+	if err := db.Get(&campID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, to_send, sent)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com', '', 'email', 'running', 10, 10) RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&linkID, `INSERT INTO links (uuid, url) VALUES (gen_random_uuid(), 'https://example.com') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	subs := make([]int, 3)
+	for i := range subs {
+		if err := db.Get(&subs[i], `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), $1, 'sub') RETURNING id`,
+			"sub"+strconv.Itoa(i)+"@example.com"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// One enthusiastic subscriber with repeated views and clicks, another who viewed
+	// once, an anonymous view, bot events, and two bounces.
+	for _, q := range []string{
+		`INSERT INTO campaign_views (campaign_id, subscriber_id, is_bot) VALUES ($1, $2, false), ($1, $2, false), ($1, $2, false), ($1, $3, false), ($1, NULL, false), ($1, $4, true)`,
+		`INSERT INTO link_clicks (campaign_id, link_id, subscriber_id, is_bot) VALUES ($1, $5, $2, false), ($1, $5, $2, false), ($1, $5, $2, false), ($1, $5, $2, false), ($1, $5, $4, true)`,
+		`INSERT INTO bounces (subscriber_id, campaign_id, type) VALUES ($4, $1, 'hard'), ($4, $1, 'soft')`,
+	} {
+		if _, err := db.Exec(q, campID, subs[0], subs[1], subs[2], linkID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The campaign.
+	camp, err := c.GetCampaign(campID, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if camp.Views != 5 || camp.UniqueViews != 2 || camp.Clicks != 4 || camp.UniqueClicks != 1 || camp.Bounces != 2 {
+		t.Errorf("unexpected counts views=%d/%d clicks=%d/%d bounces=%d", camp.Views, camp.UniqueViews, camp.Clicks, camp.UniqueClicks, camp.Bounces)
+	}
+	if camp.Delivered != 8 || camp.OpenRate != 25 || camp.ClickRate != 12.5 {
+		t.Errorf("unexpected rates delivered=%d open=%v click=%v", camp.Delivered, camp.OpenRate, camp.ClickRate)
+	}
+
+	// The running stats match.
+	stats, err := c.GetRunningCampaignStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 1 {
+		t.Fatalf("expected the running campaign's stats, got %+v", stats)
+	}
+	s := stats[0]
+	if s.Views != camp.Views || s.UniqueViews != camp.UniqueViews || s.Clicks != camp.Clicks || s.UniqueClicks != camp.UniqueClicks ||
+		s.Bounces != camp.Bounces || s.Delivered != camp.Delivered || s.OpenRate != camp.OpenRate || s.ClickRate != camp.ClickRate {
+		t.Errorf("expected the running stats to match the campaign's, got %+v", s)
+	}
+
+	// So do the dashboard's.
+	b, err := c.GetDashboardCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dash struct {
+		Engagement struct {
+			Views        int     `json:"views"`
+			UniqueViews  int     `json:"unique_views"`
+			Clicks       int     `json:"clicks"`
+			UniqueClicks int     `json:"unique_clicks"`
+			Delivered    int     `json:"delivered"`
+			OpenRate     float64 `json:"open_rate"`
+			ClickRate    float64 `json:"click_rate"`
+		} `json:"engagement"`
+	}
+	if err := json.Unmarshal(b, &dash); err != nil {
+		t.Fatal(err)
+	}
+	if e := dash.Engagement; e.Views != 5 || e.UniqueViews != 2 || e.Clicks != 4 || e.UniqueClicks != 1 ||
+		e.Delivered != 8 || e.OpenRate != 25 || e.ClickRate != 12.5 {
+		t.Errorf("unexpected dashboard engagement %+v", e)
+	}
+}
+
+func TestCampaignSimulation(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	var subID int
+	var subUUID string
+	if err := db.QueryRow(`INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), 'user@example.com', 'User') RETURNING id, uuid`).Scan(&subID, &subUUID); err != nil {
+		t.Fatal(err)
+	}
+	newCamp := func(name, status string) (int, string) {
+		var (
+			id   int
+			uuid string
+		)
+		if err := db.QueryRow(`INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', '', 'email', $2) RETURNING id, uuid`, name, status).Scan(&id, &uuid); err != nil {
+			t.Fatal(err)
+		}
+		return id, uuid
+	}
+	simulation := func(id int) bool {
+		var v bool
+		if err := db.Get(&v, `SELECT simulation FROM campaigns WHERE id = $1`, id); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	var (
+		simID, simUUID   = newCamp("simulated", models.CampaignStatusDraft)
+		realID, realUUID = newCamp("real", models.CampaignStatusDraft)
+		runID, _         = newCamp("running", models.CampaignStatusRunning)
+	)
+
+	// The mode is set on campaigns that haven't started yet.
+	if err := c.SetCampaignSimulation(simID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.SetCampaignSimulation(runID, true); err != nil {
+		t.Fatal(err)
+	}
+	if !simulation(simID) || simulation(realID) || simulation(runID) {
+		t.Fatal("expected only the draft to be set to the simulation mode")
+	}
+
+	// Sends and views of the simulated campaign are flagged.
+	for _, id := range []int{simID, realID} {
+		if _, err := db.Q.InsertCampaignSends.Exec(id, pq.Int64Array{int64(subID)}, "email"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, uuid := range []string{simUUID, realUUID} {
+		if err := c.RegisterCampaignView(uuid, subUUID, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	count := func(q string) int {
+		t.Helper()
+
+		var n int
+		if err := db.Get(&n, q, simID); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if n := count(`SELECT COUNT(*) FROM campaign_sends WHERE simulated = (campaign_id = $1)`); n != 2 {
+		t.Errorf("expected the simulated send to be flagged, got %d matching sends", n)
+	}
+	if n := count(`SELECT COUNT(*) FROM campaign_views WHERE simulated = (campaign_id = $1)`); n != 2 {
+		t.Errorf("expected the simulated view to be flagged, got %d matching views", n)
+	}
+
+	// The subscriber's send history excludes simulations.
+	hist, total, err := c.QuerySubscriberSends(subID, 0, null.Time{}, null.Time{}, 0, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hist) != 1 || total != 1 || hist[0].CampaignID != realID {
+		t.Errorf("expected the real send in the history, got %+v", hist)
+	}
+
+	// The cleanup only deletes the simulation's records.
+	out, err := c.DeleteSimulationData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Sends != 1 || out.Views != 1 {
+		t.Errorf("expected 1 send and 1 view to be deleted, got %+v", out)
+	}
+	if n := count(`SELECT (SELECT COUNT(*) FROM campaign_sends WHERE campaign_id != $1) + (SELECT COUNT(*) FROM campaign_views WHERE campaign_id != $1)`); n != 2 {
+		t.Errorf("expected the real campaign's records to be left, got %d", n)
+	}
+	if out, err := c.DeleteSimulationData(); err != nil || out.Sends != 0 || out.Views != 0 {
+		t.Errorf("expected nothing to delete, got %+v: %v", out, err)
+	}
+}
@@ -6,13 +6,17 @@ package core
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/emailnorm"
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
@@ -26,6 +30,7 @@ const (
 	matDashboardCharts = "mat_dashboard_charts"
 	matDashboardCounts = "mat_dashboard_counts"
 	matListSubStats    = "mat_list_subscriber_stats"
+	matDomainStats     = "mat_domain_stats"
 )
 
 // Core represents the listmonk core with all shared, global functions.
@@ -37,16 +42,31 @@ type Core struct {
 	db     *sqlx.DB
 	q      *models.Queries
 	log    *log.Logger
+
+	// ctx is passed to all DB queries. It's context.Background() unless
+	// a request context has been attached with WithContext().
+	ctx context.Context
+
+	// Cached eligible recipient counts of campaigns that are yet to start.
+	// It's shared by the copies made by WithContext().
+	counts *countCache
 }
 
 // Constants represents constant config.
 type Constants struct {
 	SendOptinConfirmation bool
-	BounceActions         map[string]struct {
-		Count  int
-		Action string
-	}
-	CacheSlowQueries bool
+	BounceActions         map[string]models.BounceAction
+	CacheSlowQueries      bool
+
+	// Normalization policy of subscriber e-mails.
+	EmailNormalization emailnorm.Policy
+
+	// Subscriber attribute keys whose value changes are recorded in the history.
+	TrackedAttribs []string
+
+	// Limits on ad-hoc subscriber queries and their parsed statement timeout.
+	QueryGuard   models.QueryGuard
+	QueryTimeout time.Duration
 }
 
 // Hooks contains external function hooks that are required by the core package.
@@ -84,12 +104,23 @@ func New(o *Opt, h *Hooks) *Core {
 		db:     o.DB,
 		q:      o.Queries,
 		log:    o.Log,
+		ctx:    context.Background(),
+		counts: newCountCache(campCountTTL, campCountCacheSize),
 	}
 }
 
+// WithContext returns a shallow copy of the core whose DB queries run with
+// the given context, eg: an HTTP request's context, so that queries are
+// cancelled when the request is cancelled or the client goes away.
+func (c *Core) WithContext(ctx context.Context) *Core {
+	cp := *c
+	cp.ctx = ctx
+	return &cp
+}
+
 // RefreshMatViews refreshes all materialized views.
 func (c *Core) RefreshMatViews(concurrent bool) error {
-	for _, v := range []string{matDashboardCharts, matDashboardCounts, matListSubStats} {
+	for _, v := range []string{matDashboardCharts, matDashboardCounts, matListSubStats, matDomainStats} {
 		_ = c.RefreshMatView(v, true)
 	}
 	return nil
@@ -104,7 +135,7 @@ func (c *Core) RefreshMatView(name string, concurrent bool) error {
 		q = fmt.Sprintf(q, "", name)
 	}
 
-	if _, err := c.db.Exec(q); err != nil {
+	if _, err := c.db.ExecContext(c.ctx, q); err != nil {
 		c.log.Printf("error refreshing materialized view: %s: %v", name, err)
 		return err
 	}
@@ -151,6 +182,18 @@ func makeSearchQuery(searchStr, orderBy, order, query string, querySortFields []
 	return searchStr, query
 }
 
+// makeTotalQuery replaces the %total% placeholder in a query with the windowed
+// COUNT() of all the matching rows, or with models.TotalSkipped to skip the
+// expensive count on large tables.
+func makeTotalQuery(query string, skipTotal bool) string {
+	expr := "COUNT(*) OVER ()"
+	if skipTotal {
+		expr = strconv.Itoa(models.TotalSkipped)
+	}
+
+	return strings.ReplaceAll(query, "%total%", expr)
+}
+
 // makeSearchString prepares a search string for use in both tsquery and ILIKE queries.
 func makeSearchString(searchStr string) string {
 	if searchStr == "" {
@@ -171,19 +214,26 @@ func strSliceContains(str string, sl []string) bool {
 }
 
 // normalizeTags takes a list of string tags and normalizes them by
-// lower casing and removing all special characters except for dashes.
+// trimming, lower casing, replacing spaces with dashes, and removing duplicates.
 func normalizeTags(tags []string) []string {
 	var (
-		out  []string
+		out  = make([]string, 0, len(tags))
+		seen = make(map[string]struct{}, len(tags))
 		dash = []byte("-")
 	)
 
 	for _, t := range tags {
-		rep := regexpSpaces.ReplaceAll(bytes.TrimSpace([]byte(t)), dash)
+		rep := string(bytes.ToLower(regexpSpaces.ReplaceAll(bytes.TrimSpace([]byte(t)), dash)))
+		if len(rep) == 0 {
+			continue
+		}
 
-		if len(rep) > 0 {
-			out = append(out, string(rep))
+		if _, ok := seen[rep]; ok {
+			continue
 		}
+		seen[rep] = struct{}{}
+
+		out = append(out, rep)
 	}
 	return out
 }
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestWithContext(t *testing.T) {
+	c, _ := newTestCore(t, Constants{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rc := c.WithContext(ctx)
+	if rc == c || rc.ctx != ctx || c.ctx == ctx {
+		t.Fatal("expected a copy of the core with the context")
+	}
+
+	s, err := rc.GetSettings()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The queries of the copy are cancelled with its context, and the core's
+	// are left unaffected.
+	cancel()
+	if _, err := rc.GetSettings(); err == nil {
+		t.Fatal("expected an error with a cancelled context")
+	}
+	if _, err := rc.UpdateSettings(s); err == nil {
+		t.Fatal("expected an error in a transaction with a cancelled context")
+	}
+	if _, err := c.UpdateSettings(s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestNormalizeTags(t *testing.T) {
+	cases := []struct {
+		in, exp []string
+	}{
+		{nil, []string{}},
+		{[]string{"Promo", "PROMO", " promo "}, []string{"promo"}},
+		{[]string{"Brand X", "brand  x", "brand\tx"}, []string{"brand-x"}},
+		{[]string{"", "  ", "oct"}, []string{"oct"}},
+		{[]string{"b", "a", "B"}, []string{"b", "a"}},
+	}
+	for _, c := range cases {
+		if got := normalizeTags(c.in); !slices.Equal(got, c.exp) {
+			t.Errorf("%q: expected %q, got %q", c.in, c.exp, got)
+		}
+	}
+}
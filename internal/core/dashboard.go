@@ -2,8 +2,10 @@ package core
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/jmoiron/sqlx/types"
+	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 )
 
@@ -12,7 +14,7 @@ func (c *Core) GetDashboardCharts() (types.JSONText, error) {
 	_ = c.refreshCache(matDashboardCharts, false)
 
 	var out types.JSONText
-	if err := c.q.GetDashboardCharts.Get(&out); err != nil {
+	if err := c.q.GetDashboardCharts.GetContext(c.ctx, &out); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "dashboard charts", "error", pqErrMsg(err)))
 	}
@@ -25,10 +27,61 @@ func (c *Core) GetDashboardCounts() (types.JSONText, error) {
 	_ = c.refreshCache(matDashboardCounts, false)
 
 	var out types.JSONText
-	if err := c.q.GetDashboardCounts.Get(&out); err != nil {
+	if err := c.q.GetDashboardCounts.GetContext(c.ctx, &out); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "dashboard stats", "error", pqErrMsg(err)))
 	}
 
 	return out, nil
 }
+
+// GetDigestStats returns the activity in the period [from, to) for the digest
+// report along with the given number of top campaigns.
+func (c *Core) GetDigestStats(from, to time.Time, topCampaigns int) (models.DigestStats, error) {
+	var b types.JSONText
+	if err := c.q.GetDigestStats.GetContext(c.ctx, &b, from, to, topCampaigns); err != nil {
+		c.log.Printf("error fetching digest stats: %v", err)
+		return models.DigestStats{}, err
+	}
+
+	var out models.DigestStats
+	if err := b.Unmarshal(&out); err != nil {
+		c.log.Printf("error unmarshalling digest stats: %v", err)
+		return models.DigestStats{}, err
+	}
+
+	return out, nil
+}
+
+// RecordDigestRun records a run of the digest report.
+func (c *Core) RecordDigestRun(r models.DigestRun) error {
+	if _, err := c.q.InsertDigestRun.ExecContext(c.ctx, r.PeriodStart, r.PeriodEnd, r.Status, r.Recipients, r.Error); err != nil {
+		c.log.Printf("error recording digest run: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetDigestRuns returns the latest runs of the digest report.
+func (c *Core) GetDigestRuns(limit int) ([]models.DigestRun, error) {
+	out := []models.DigestRun{}
+	if err := c.q.GetDigestRuns.SelectContext(c.ctx, &out, limit); err != nil {
+		c.log.Printf("error fetching digest runs: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.digest}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetDBSize returns the size of the database in bytes.
+func (c *Core) GetDBSize() (int64, error) {
+	var out int64
+	if err := c.q.GetDBSize.GetContext(c.ctx, &out); err != nil {
+		c.log.Printf("error fetching database size: %v", err)
+		return 0, err
+	}
+
+	return out, nil
+}
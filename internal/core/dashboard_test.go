@@ -0,0 +1,104 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGetDigestStats(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	var (
+		from = time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+		to   = time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+	)
+
+	var listID int
+	if err := db.Get(&listID, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'list', 'private') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscribers created at and around the boundaries of the period.
+	newSub := func(email string, created time.Time, status string, updated time.Time) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO subscribers (uuid, email, name, created_at) VALUES (gen_random_uuid(), $1, $1, $2) RETURNING id`,
+			email, created); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO subscriber_lists (subscriber_id, list_id, status, created_at, updated_at) VALUES ($1, $2, $3, $4, $5)`,
+			id, listID, status, created, updated); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	var (
+		first = newSub("first@example.com", from, "confirmed", from)
+		_     = newSub("before@example.com", from.Add(-time.Second), "confirmed", from.Add(-time.Second))
+		_     = newSub("last@example.com", to.Add(-time.Second), "confirmed", to.Add(-time.Second))
+		_     = newSub("after@example.com", to, "confirmed", to)
+
+		// Subscribed before the period and unsubscribed within it.
+		_ = newSub("unsub@example.com", from.AddDate(0, 0, -30), "unsubscribed", from.Add(time.Hour))
+
+		// Unsubscribed after the period.
+		_ = newSub("unsub-after@example.com", from.AddDate(0, 0, -30), "unsubscribed", to)
+	)
+
+	// Campaigns that finished at and around the boundaries of the period.
+	newCamp := func(name string, finished time.Time, simulation bool, sent int) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger, status, sent, simulation, updated_at)
+			VALUES (gen_random_uuid(), $1, $1, 'from@example.com', '', 'email', 'finished', $2, $3, $4) RETURNING id`,
+			name, sent, simulation, finished); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	var (
+		atStart = newCamp("start", from, false, 10)
+		atEnd   = newCamp("end", to.Add(-time.Second), false, 20)
+	)
+	newCamp("before", from.Add(-time.Second), false, 100)
+	newCamp("after", to, false, 100)
+	sim := newCamp("simulation", from.Add(time.Hour), true, 100)
+
+	for _, q := range []string{
+		// Repeated and bot views, and views of a simulation, aren't counted.
+		`INSERT INTO campaign_views (campaign_id, subscriber_id, is_bot, simulated) VALUES ($1, $3, false, false), ($1, $3, false, false), ($1, NULL, true, false)`,
+		`INSERT INTO campaign_views (campaign_id, subscriber_id, is_bot, simulated) VALUES ($2, $3, false, true)`,
+		`INSERT INTO bounces (subscriber_id, campaign_id, type) VALUES ($3, $1, 'hard'), ($3, $1, 'soft')`,
+	} {
+		if _, err := db.Exec(q, atEnd, sim, first); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+
+	s, err := c.GetDigestStats(from, to, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.Campaigns != 2 || s.Messages != 30 || s.Views != 1 || s.Clicks != 0 || s.Bounces != 2 {
+		t.Errorf("unexpected campaign stats %+v", s)
+	}
+	if s.NewSubscribers != 2 {
+		t.Errorf("expected 2 new subscribers, got %d", s.NewSubscribers)
+	}
+	if len(s.Lists) != 1 || s.Lists[0].ID != listID || s.Lists[0].Subscribed != 2 || s.Lists[0].Unsubscribed != 1 {
+		t.Errorf("unexpected list stats %+v", s.Lists)
+	}
+
+	// The top campaigns are by unique views.
+	if len(s.TopCampaigns) != 1 || s.TopCampaigns[0].ID != atEnd || s.TopCampaigns[0].Views != 1 {
+		t.Errorf("unexpected top campaigns %+v", s.TopCampaigns)
+	}
+
+	// The next period starts where this one ends.
+	s, err = c.GetDigestStats(to, to.AddDate(0, 0, 7), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Campaigns != 1 || s.Messages != 100 || s.NewSubscribers != 1 || len(s.TopCampaigns) != 1 || s.TopCampaigns[0].ID == atStart {
+		t.Errorf("unexpected stats of the next period %+v", s)
+	}
+}
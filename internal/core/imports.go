@@ -0,0 +1,56 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+// QueryImportJobs returns the subscriber import jobs, latest first, optionally
+// filtered by status.
+func (c *Core) QueryImportJobs(status string, offset, limit int) ([]models.ImportJob, int, error) {
+	out := []models.ImportJob{}
+	if err := c.q.QueryImportJobs.SelectContext(c.ctx, &out, status, offset, limit); err != nil {
+		c.log.Printf("error fetching import jobs: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.importJobs}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].TotalJobs
+	}
+
+	return out, total, nil
+}
+
+// GetImportJob returns a subscriber import job.
+func (c *Core) GetImportJob(id int) (models.ImportJob, error) {
+	var out models.ImportJob
+	if err := c.q.GetImportJob.GetContext(c.ctx, &out, id); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusNotFound,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.importJob}"))
+		}
+
+		c.log.Printf("error fetching import job: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.importJob}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetImportJobErrors returns the rows of a subscriber import job that were skipped.
+func (c *Core) GetImportJobErrors(id int) ([]models.ImportJobError, error) {
+	out := []models.ImportJobError{}
+	if err := c.q.GetImportJobErrors.SelectContext(c.ctx, &out, id); err != nil {
+		c.log.Printf("error fetching import job errors: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.importJob}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
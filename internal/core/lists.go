@@ -1,6 +1,7 @@
 package core
 
 import (
+	"database/sql"
 	"net/http"
 
 	"github.com/gofrs/uuid/v5"
@@ -19,7 +20,7 @@ type listType struct {
 func (c *Core) GetLists(typ, status string, getAll bool, permittedIDs []int) ([]models.List, error) {
 	out := []models.List{}
 
-	if err := c.q.GetLists.Select(&out, typ, status, "id", getAll, pq.Array(permittedIDs)); err != nil {
+	if err := c.q.GetLists.SelectContext(c.ctx, &out, typ, status, "id", getAll, pq.Array(permittedIDs)); err != nil {
 		c.log.Printf("error fetching lists: %v", err)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
@@ -53,7 +54,7 @@ func (c *Core) QueryLists(searchStr, typ, optin, status string, tags []string, o
 		out            = []models.List{}
 		queryStr, stmt = makeSearchQuery(searchStr, orderBy, order, c.q.QueryLists, listQuerySortFields)
 	)
-	if err := c.db.Select(&out, stmt, 0, "", queryStr, typ, optin, status, pq.StringArray(tags), getAll, pq.Array(permittedIDs), offset, limit); err != nil {
+	if err := c.db.SelectContext(c.ctx, &out, stmt, 0, "", queryStr, typ, optin, status, pq.StringArray(tags), getAll, pq.Array(permittedIDs), offset, limit); err != nil {
 		c.log.Printf("error fetching lists: %v", err)
 		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
@@ -74,6 +75,39 @@ func (c *Core) QueryLists(searchStr, typ, optin, status string, tags []string, o
 	return out, total, nil
 }
 
+// AttachListStats fetches the per-status subscriber breakdown and growth
+// of the given lists and sets them on the lists.
+func (c *Core) AttachListStats(lists []models.List) error {
+	if len(lists) == 0 {
+		return nil
+	}
+
+	_ = c.refreshCache(matListSubStats, false)
+
+	ids := make([]int, len(lists))
+	for n, l := range lists {
+		ids[n] = l.ID
+	}
+
+	var res []models.ListStats
+	if err := c.q.GetListStats.SelectContext(c.ctx, &res, pq.Array(ids)); err != nil {
+		c.log.Printf("error fetching list stats: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
+	}
+
+	stats := make(map[int]models.ListStats, len(res))
+	for _, s := range res {
+		stats[s.ListID] = s
+	}
+	for n, l := range lists {
+		s := stats[l.ID]
+		lists[n].Stats = &s
+	}
+
+	return nil
+}
+
 // GetList gets a list by its ID or UUID.
 func (c *Core) GetList(id int, uuid string) (models.List, error) {
 	var uu any
@@ -83,7 +117,7 @@ func (c *Core) GetList(id int, uuid string) (models.List, error) {
 
 	var res []models.List
 	queryStr, stmt := makeSearchQuery("", "", "", c.q.QueryLists, nil)
-	if err := c.db.Select(&res, stmt, id, uu, queryStr, "", "", "", pq.StringArray{}, true, nil, 0, 1); err != nil {
+	if err := c.db.SelectContext(c.ctx, &res, stmt, id, uu, queryStr, "", "", "", pq.StringArray{}, true, nil, 0, 1); err != nil {
 		c.log.Printf("error fetching lists: %v", err)
 		return models.List{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
@@ -109,7 +143,7 @@ func (c *Core) GetList(id int, uuid string) (models.List, error) {
 // GetListsByOptin returns lists by optin type.
 func (c *Core) GetListsByOptin(ids []int, optinType string) ([]models.List, error) {
 	out := []models.List{}
-	if err := c.q.GetListsByOptin.Select(&out, optinType, pq.Array(ids), nil); err != nil {
+	if err := c.q.GetListsByOptin.SelectContext(c.ctx, &out, optinType, pq.Array(ids), nil); err != nil {
 		c.log.Printf("error fetching lists for opt-in: %s", pqErrMsg(err))
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
@@ -127,7 +161,7 @@ func (c *Core) GetListTypes(ids []int, uuids []string) (map[any]string, error) {
 	res := []listType{}
 
 	out := map[any]string{}
-	if err := c.q.GetListTypes.Select(&res, pq.Array(ids), pq.StringArray(uuids)); err != nil {
+	if err := c.q.GetListTypes.SelectContext(c.ctx, &res, pq.Array(ids), pq.StringArray(uuids)); err != nil {
 		c.log.Printf("error fetching list types: %v", err)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
@@ -167,7 +201,8 @@ func (c *Core) CreateList(l models.List) (models.List, error) {
 	// Insert and read ID.
 	var newID int
 	l.UUID = uu.String()
-	if err := c.q.CreateList.Get(&newID, l.UUID, l.Name, l.Type, l.Optin, l.Status, pq.StringArray(normalizeTags(l.Tags)), l.Description); err != nil {
+	if err := c.q.CreateList.GetContext(c.ctx, &newID, l.UUID, l.Name, l.Type, l.Optin, l.Status, pq.StringArray(normalizeTags(l.Tags)), l.Description, l.PurgeUnconfirmedAfterDays, l.SunsetInactiveDays,
+		l.PublicDescription, l.Frequency); err != nil {
 		c.log.Printf("error creating list: %v", err)
 		return models.List{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.list}", "error", pqErrMsg(err)))
@@ -178,7 +213,8 @@ func (c *Core) CreateList(l models.List) (models.List, error) {
 
 // UpdateList updates a given list.
 func (c *Core) UpdateList(id int, l models.List) (models.List, error) {
-	res, err := c.q.UpdateList.Exec(id, l.Name, l.Type, l.Optin, l.Status, pq.StringArray(normalizeTags(l.Tags)), l.Description)
+	res, err := c.q.UpdateList.ExecContext(c.ctx, id, l.Name, l.Type, l.Optin, l.Status, pq.StringArray(normalizeTags(l.Tags)), l.Description, l.PurgeUnconfirmedAfterDays, l.SunsetInactiveDays,
+		l.PublicDescription, l.Frequency)
 	if err != nil {
 		c.log.Printf("error updating list: %v", err)
 		return models.List{}, echo.NewHTTPError(http.StatusInternalServerError,
@@ -210,10 +246,67 @@ func (c *Core) DeleteLists(ids []int, query string, getAll bool, permittedIDs []
 		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("globals.messages.invalidData"))
 	}
 
-	if _, err := c.q.DeleteLists.Exec(pq.Array(ids), queryStr, getAll, pq.Array(permittedIDs)); err != nil {
+	if _, err := c.q.DeleteLists.ExecContext(c.ctx, pq.Array(ids), queryStr, getAll, pq.Array(permittedIDs)); err != nil {
 		c.log.Printf("error deleting lists: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.lists}", "error", pqErrMsg(err)))
 	}
 	return nil
 }
+
+// ImportLists creates or updates (matched by UUID) list definitions in a single
+// transaction. Lists whose name is taken by another list with a different UUID,
+// and lists that are identical to the existing ones are skipped.
+func (c *Core) ImportLists(lists []models.BundleList) ([]models.BundleResult, error) {
+	tx, err := c.db.BeginTxx(c.ctx, nil)
+	if err != nil {
+		c.log.Printf("error starting list import: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, c.i18n.T("globals.messages.internalError"))
+	}
+	defer tx.Rollback()
+
+	out := make([]models.BundleResult, 0, len(lists))
+	for _, l := range lists {
+		res := models.BundleResult{Type: models.BundleTypeLists, UUID: l.UUID, Name: l.Name}
+
+		var conflict bool
+		if err := tx.StmtxContext(c.ctx, c.q.HasListConflict).GetContext(c.ctx, &conflict, l.UUID, l.Name); err != nil {
+			c.log.Printf("error checking list (%s): %v", l.UUID, err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorCreating", "name", l.Name, "error", pqErrMsg(err)))
+		}
+		if conflict {
+			res.Action = models.BundleActionSkipped
+			res.Reason = "another list with the name exists"
+			out = append(out, res)
+			continue
+		}
+
+		var created bool
+		err := tx.StmtxContext(c.ctx, c.q.ImportList).GetContext(c.ctx, &created, l.UUID, l.Name, l.Type, l.Optin, l.Status,
+			pq.StringArray(normalizeTags(l.Tags)), l.Description, l.PurgeUnconfirmedAfterDays, l.SunsetInactiveDays,
+			l.PublicDescription, l.Frequency)
+		switch {
+		case err == sql.ErrNoRows:
+			res.Action = models.BundleActionSkipped
+			res.Reason = "unchanged"
+		case err != nil:
+			c.log.Printf("error importing list (%s): %v", l.UUID, err)
+			return nil, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorCreating", "name", l.Name, "error", pqErrMsg(err)))
+		case created:
+			res.Action = models.BundleActionCreated
+		default:
+			res.Action = models.BundleActionUpdated
+		}
+
+		out = append(out, res)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.log.Printf("error committing list import: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, c.i18n.T("globals.messages.internalError"))
+	}
+
+	return out, nil
+}
@@ -14,20 +14,24 @@ import (
 )
 
 // QueryMedia returns media entries optionally filtered by a query string.
-func (c *Core) QueryMedia(provider string, s media.Store, query string, offset, limit int) ([]media.Media, int, error) {
+func (c *Core) QueryMedia(provider string, s media.Store, query string, offset, limit int, skipTotal bool) ([]media.Media, int, error) {
 	out := []media.Media{}
 
 	if query != "" {
 		query = strings.ToLower(query)
 	}
 
-	if err := c.q.QueryMedia.Select(&out, fmt.Sprintf("%%%s%%", query), provider, offset, limit); err != nil {
+	stmt := makeTotalQuery(c.q.QueryMedia, skipTotal)
+	if err := c.db.SelectContext(c.ctx, &out, stmt, fmt.Sprintf("%%%s%%", query), provider, offset, limit); err != nil {
 		return out, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching",
 				"name", "{globals.terms.media}", "error", pqErrMsg(err)))
 	}
 
 	total := 0
+	if skipTotal {
+		total = models.TotalSkipped
+	}
 	if len(out) > 0 {
 		total = out[0].Total
 
@@ -51,7 +55,7 @@ func (c *Core) GetMedia(id int, uuid, fileName string, s media.Store) (media.Med
 	}
 
 	var out media.Media
-	if err := c.q.GetMedia.Get(&out, id, uu, fileName); err != nil {
+	if err := c.q.GetMedia.GetContext(c.ctx, &out, id, uu, fileName); err != nil {
 		// If it's ` sql: no rows in result set`, return a 404.
 		if err == sql.ErrNoRows {
 			return out, ErrNotFound
@@ -80,7 +84,7 @@ func (c *Core) InsertMedia(fileName, thumbName, contentType string, meta models.
 
 	// Write to the DB.
 	var newID int
-	if err := c.q.InsertMedia.Get(&newID, uu, fileName, thumbName, contentType, provider, meta); err != nil {
+	if err := c.q.InsertMedia.GetContext(c.ctx, &newID, uu, fileName, thumbName, contentType, provider, meta); err != nil {
 		c.log.Printf("error inserting uploaded file to db: %v", err)
 		return media.Media{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
@@ -92,7 +96,7 @@ func (c *Core) InsertMedia(fileName, thumbName, contentType string, meta models.
 // DeleteMedia deletes a given media item and returns the filename of the deleted item.
 func (c *Core) DeleteMedia(id int) (string, error) {
 	var fname string
-	if err := c.q.DeleteMedia.Get(&fname, id); err != nil {
+	if err := c.q.DeleteMedia.GetContext(c.ctx, &fname, id); err != nil {
 		c.log.Printf("error inserting uploaded file to db: %v", err)
 		return "", echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.media}", "error", pqErrMsg(err)))
@@ -12,7 +12,7 @@ import (
 // GetRoles retrieves all roles.
 func (c *Core) GetRoles() ([]auth.Role, error) {
 	out := []auth.Role{}
-	if err := c.q.GetUserRoles.Select(&out, nil); err != nil {
+	if err := c.q.GetUserRoles.SelectContext(c.ctx, &out, nil); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "role", "error", pqErrMsg(err)))
 	}
@@ -23,7 +23,7 @@ func (c *Core) GetRoles() ([]auth.Role, error) {
 // GetRole retrieves a role.
 func (c *Core) GetRole(id int) (auth.Role, error) {
 	out := []auth.Role{}
-	if err := c.q.GetUserRoles.Select(&out, id); err != nil {
+	if err := c.q.GetUserRoles.SelectContext(c.ctx, &out, id); err != nil {
 		return auth.Role{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "role", "error", pqErrMsg(err)))
 	}
@@ -40,7 +40,7 @@ func (c *Core) GetRole(id int) (auth.Role, error) {
 // GetListRoles retrieves all list roles.
 func (c *Core) GetListRoles() ([]auth.ListRole, error) {
 	out := []auth.ListRole{}
-	if err := c.q.GetListRoles.Select(&out); err != nil {
+	if err := c.q.GetListRoles.SelectContext(c.ctx, &out); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "role", "error", pqErrMsg(err)))
 	}
@@ -63,7 +63,7 @@ func (c *Core) GetListRoles() ([]auth.ListRole, error) {
 func (c *Core) CreateRole(r auth.Role) (auth.Role, error) {
 	var out auth.Role
 
-	if err := c.q.CreateRole.Get(&out, r.Name, auth.RoleTypeUser, pq.Array(r.Permissions)); err != nil {
+	if err := c.q.CreateRole.GetContext(c.ctx, &out, r.Name, auth.RoleTypeUser, pq.Array(r.Permissions)); err != nil {
 		return out, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{users.role}", "error", pqErrMsg(err)))
 	}
@@ -75,7 +75,7 @@ func (c *Core) CreateRole(r auth.Role) (auth.Role, error) {
 func (c *Core) CreateListRole(r auth.ListRole) (auth.ListRole, error) {
 	var out auth.ListRole
 
-	if err := c.q.CreateRole.Get(&out, r.Name, auth.RoleTypeList, pq.Array([]string{})); err != nil {
+	if err := c.q.CreateRole.GetContext(c.ctx, &out, r.Name, auth.RoleTypeList, pq.Array([]string{})); err != nil {
 		return out, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{users.role}", "error", pqErrMsg(err)))
 	}
@@ -108,7 +108,7 @@ func (c *Core) UpsertListPermissions(roleID int, lp []auth.ListPermission) error
 		listPerms = append(listPerms, perms)
 	}
 
-	if _, err := c.q.UpsertListPermissions.Exec(roleID, pq.Array(listIDs), pq.Array(listPerms)); err != nil {
+	if _, err := c.q.UpsertListPermissions.ExecContext(c.ctx, roleID, pq.Array(listIDs), pq.Array(listPerms)); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{users.role}", "error", pqErrMsg(err)))
 	}
@@ -118,7 +118,7 @@ func (c *Core) UpsertListPermissions(roleID int, lp []auth.ListPermission) error
 
 // DeleteListPermission deletes a list permission entry from a role.
 func (c *Core) DeleteListPermission(roleID, listID int) error {
-	if _, err := c.q.DeleteListPermission.Exec(roleID, listID); err != nil {
+	if _, err := c.q.DeleteListPermission.ExecContext(c.ctx, roleID, listID); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "users_role_id_fkey" {
 			return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("users.cantDeleteRole"))
 		}
@@ -133,7 +133,7 @@ func (c *Core) DeleteListPermission(roleID, listID int) error {
 func (c *Core) UpdateUserRole(id int, r auth.Role) (auth.Role, error) {
 	var out auth.Role
 
-	if err := c.q.UpdateRole.Get(&out, id, r.Name, pq.Array(r.Permissions)); err != nil {
+	if err := c.q.UpdateRole.GetContext(c.ctx, &out, id, r.Name, pq.Array(r.Permissions)); err != nil {
 		return out, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{users.userRole}", "error", pqErrMsg(err)))
 	}
@@ -149,7 +149,7 @@ func (c *Core) UpdateUserRole(id int, r auth.Role) (auth.Role, error) {
 func (c *Core) UpdateListRole(id int, r auth.ListRole) (auth.ListRole, error) {
 	var out auth.ListRole
 
-	if err := c.q.UpdateRole.Get(&out, id, r.Name, pq.Array([]string{})); err != nil {
+	if err := c.q.UpdateRole.GetContext(c.ctx, &out, id, r.Name, pq.Array([]string{})); err != nil {
 		return out, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{users.listRole}", "error", pqErrMsg(err)))
 	}
@@ -168,7 +168,7 @@ func (c *Core) UpdateListRole(id int, r auth.ListRole) (auth.ListRole, error) {
 
 // DeleteRole deletes a given role.
 func (c *Core) DeleteRole(id int) error {
-	if _, err := c.q.DeleteRole.Exec(id); err != nil {
+	if _, err := c.q.DeleteRole.ExecContext(c.ctx, id); err != nil {
 		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "users_role_id_fkey" {
 			return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("users.cantDeleteRole"))
 		}
@@ -0,0 +1,136 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// RecordLoginEvent records a login attempt.
+func (c *Core) RecordLoginEvent(username, ip string, success bool) error {
+	if _, err := c.q.InsertLoginEvent.ExecContext(c.ctx, username, ip, success); err != nil {
+		c.log.Printf("error recording login event: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, c.i18n.T("globals.messages.internalError"))
+	}
+
+	return nil
+}
+
+// RecordAPITokenNetwork records the network that an API token is used from.
+func (c *Core) RecordAPITokenNetwork(username, network, ip string) error {
+	if _, err := c.q.UpsertAPITokenNetwork.ExecContext(c.ctx, username, network, ip); err != nil {
+		c.log.Printf("error recording API token network: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, c.i18n.T("globals.messages.internalError"))
+	}
+
+	return nil
+}
+
+// GetFailedLogins returns the usernames and IPs with at least threshold
+// failed logins since the given time.
+func (c *Core) GetFailedLogins(since time.Time, threshold int) ([]models.FailedLogins, error) {
+	out := []models.FailedLogins{}
+	if err := c.q.GetFailedLoginCounts.SelectContext(c.ctx, &out, since, threshold); err != nil {
+		c.log.Printf("error fetching failed logins: %v", err)
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// GetNewTokenNetworks returns the networks API tokens have been used from
+// for the first time that are yet to be alerted.
+func (c *Core) GetNewTokenNetworks() ([]models.TokenNetwork, error) {
+	out := []models.TokenNetwork{}
+	if err := c.q.GetNewAPITokenNetworks.SelectContext(c.ctx, &out); err != nil {
+		c.log.Printf("error fetching new API token networks: %v", err)
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// MarkTokenNetworksAlerted marks the given API token networks as alerted.
+func (c *Core) MarkTokenNetworksAlerted(nets []models.TokenNetwork) error {
+	var (
+		users = make([]string, len(nets))
+		names = make([]string, len(nets))
+	)
+	for n, t := range nets {
+		users[n] = t.Username
+		names[n] = t.Network
+	}
+
+	if _, err := c.q.MarkAPITokenNetworksAlerted.ExecContext(c.ctx, pq.Array(users), pq.Array(names)); err != nil {
+		c.log.Printf("error updating API token networks: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// DeleteLoginEvents deletes the login events recorded before the given time.
+func (c *Core) DeleteLoginEvents(before time.Time) error {
+	if _, err := c.q.DeleteLoginEvents.ExecContext(c.ctx, before); err != nil {
+		c.log.Printf("error deleting login events: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetLastSecurityAlerts returns the time each security alert rule was last fired at.
+func (c *Core) GetLastSecurityAlerts() (map[string]time.Time, error) {
+	var res []struct {
+		Rule      string    `db:"rule"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+	if err := c.q.GetLastSecurityAlerts.SelectContext(c.ctx, &res); err != nil {
+		c.log.Printf("error fetching security alerts: %v", err)
+		return nil, err
+	}
+
+	out := make(map[string]time.Time, len(res))
+	for _, r := range res {
+		out[r.Rule] = r.CreatedAt
+	}
+
+	return out, nil
+}
+
+// RecordSecurityAlert records a fired security alert along with the data that triggered it.
+func (c *Core) RecordSecurityAlert(rule string, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.q.InsertSecurityAlert.ExecContext(c.ctx, rule, b); err != nil {
+		c.log.Printf("error recording security alert: %v", err)
+		return err
+	}
+
+	return nil
+}
+
+// QuerySecurityAlerts returns the fired security alerts, latest first, optionally
+// filtered by rule.
+func (c *Core) QuerySecurityAlerts(rule string, offset, limit int) ([]models.SecurityAlert, int, error) {
+	out := []models.SecurityAlert{}
+	if err := c.q.QuerySecurityAlerts.SelectContext(c.ctx, &out, rule, offset, limit); err != nil {
+		c.log.Printf("error fetching security alerts: %v", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.securityAlerts}", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
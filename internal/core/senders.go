@@ -0,0 +1,99 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetSenderIdentities retrieves all sender identities.
+func (c *Core) GetSenderIdentities() ([]models.SenderIdentity, error) {
+	out := []models.SenderIdentity{}
+	if err := c.q.GetSenderIdentities.SelectContext(c.ctx, &out, 0); err != nil {
+		c.log.Printf("error fetching sender identities: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.senderIdentities}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetSenderIdentity retrieves a sender identity.
+func (c *Core) GetSenderIdentity(id int) (models.SenderIdentity, error) {
+	var out []models.SenderIdentity
+	if err := c.q.GetSenderIdentities.SelectContext(c.ctx, &out, id); err != nil {
+		c.log.Printf("error fetching sender identity: %v", err)
+		return models.SenderIdentity{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.senderIdentity}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.SenderIdentity{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.senderIdentity}"))
+	}
+
+	return out[0], nil
+}
+
+// CreateSenderIdentity creates a new sender identity. An unverified identity
+// should have a verification token that's e-mailed to the address.
+func (c *Core) CreateSenderIdentity(s models.SenderIdentity) (models.SenderIdentity, error) {
+	var newID int
+	if err := c.q.CreateSenderIdentity.GetContext(c.ctx, &newID, s.Type, s.Value, s.Verified, s.VerifyToken); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return models.SenderIdentity{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("senders.exists"))
+		}
+
+		c.log.Printf("error creating sender identity: %v", err)
+		return models.SenderIdentity{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.senderIdentity}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetSenderIdentity(newID)
+}
+
+// SetSenderIdentityToken sets a new verification token on an unverified sender identity.
+func (c *Core) SetSenderIdentityToken(id int, token string) error {
+	res, err := c.q.SetSenderIdentityToken.ExecContext(c.ctx, id, token)
+	if err != nil {
+		c.log.Printf("error updating sender identity: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.senderIdentity}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("senders.alreadyVerified"))
+	}
+
+	return nil
+}
+
+// VerifySenderIdentity marks the sender identity with the given verification token as verified.
+func (c *Core) VerifySenderIdentity(token string) (models.SenderIdentity, error) {
+	var out models.SenderIdentity
+	if err := c.q.VerifySenderIdentity.GetContext(c.ctx, &out, token); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusBadRequest, c.i18n.T("senders.invalidToken"))
+		}
+
+		c.log.Printf("error verifying sender identity: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.senderIdentity}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// DeleteSenderIdentity deletes a sender identity.
+func (c *Core) DeleteSenderIdentity(id int) error {
+	if _, err := c.q.DeleteSenderIdentity.ExecContext(c.ctx, id); err != nil {
+		c.log.Printf("error deleting sender identity: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.senderIdentity}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package core
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// GetSequences retrieves all sequences.
+func (c *Core) GetSequences() ([]models.Sequence, error) {
+	out := []models.Sequence{}
+	if err := c.q.GetSequences.SelectContext(c.ctx, &out, 0); err != nil {
+		c.log.Printf("error fetching sequences: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.sequences}", "error", pqErrMsg(err)))
+	}
+
+	for n := range out {
+		formatStepDelays(out[n].Steps)
+	}
+
+	return out, nil
+}
+
+// GetSequence retrieves a sequence.
+func (c *Core) GetSequence(id int) (models.Sequence, error) {
+	var out []models.Sequence
+	if err := c.q.GetSequences.SelectContext(c.ctx, &out, id); err != nil {
+		c.log.Printf("error fetching sequence: %v", err)
+		return models.Sequence{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.sequence}", "error", pqErrMsg(err)))
+	}
+
+	if len(out) == 0 {
+		return models.Sequence{}, echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.sequence}"))
+	}
+	formatStepDelays(out[0].Steps)
+
+	return out[0], nil
+}
+
+// CreateSequence creates a new sequence. Only subscribers who subscribe to
+// the list after the sequence is created are enrolled into it.
+func (c *Core) CreateSequence(s models.Sequence) (models.Sequence, error) {
+	uu, err := uuid.NewV4()
+	if err != nil {
+		c.log.Printf("error generating UUID: %v", err)
+		return models.Sequence{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUUID", "error", err.Error()))
+	}
+
+	if s.Status == "" {
+		s.Status = models.SequenceStatusActive
+	}
+
+	campIDs, delays := splitSequenceSteps(s.Steps)
+
+	var newID int
+	if err := c.q.CreateSequence.GetContext(c.ctx, &newID, uu.String(), s.Name, s.ListID, s.Status, campIDs, delays); err != nil {
+		c.log.Printf("error creating sequence: %v", err)
+		return models.Sequence{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.sequence}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetSequence(newID)
+}
+
+// UpdateSequence updates a sequence and replaces its steps. Subscribers already in
+// the sequence continue from their current step position.
+func (c *Core) UpdateSequence(id int, s models.Sequence) (models.Sequence, error) {
+	if s.Status == "" {
+		s.Status = models.SequenceStatusActive
+	}
+
+	campIDs, delays := splitSequenceSteps(s.Steps)
+
+	var seqID int
+	if err := c.q.UpdateSequence.GetContext(c.ctx, &seqID, id, s.Name, s.ListID, s.Status, campIDs, delays); err != nil {
+		if err == sql.ErrNoRows {
+			return models.Sequence{}, echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.sequence}"))
+		}
+
+		c.log.Printf("error updating sequence: %v", err)
+		return models.Sequence{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.sequence}", "error", pqErrMsg(err)))
+	}
+
+	return c.GetSequence(id)
+}
+
+// DeleteSequence deletes a sequence along with the state of its subscribers.
+func (c *Core) DeleteSequence(id int) error {
+	if _, err := c.q.DeleteSequence.ExecContext(c.ctx, id); err != nil {
+		c.log.Printf("error deleting sequence: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.sequence}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// splitSequenceSteps returns the campaign IDs and delays of sequence steps
+// as arrays for the step insertion queries.
+func splitSequenceSteps(steps models.SequenceSteps) (pq.Int64Array, pq.Int64Array) {
+	var (
+		campIDs = make(pq.Int64Array, 0, len(steps))
+		delays  = make(pq.Int64Array, 0, len(steps))
+	)
+	for _, s := range steps {
+		campIDs = append(campIDs, int64(s.CampaignID))
+		delays = append(delays, int64(s.DelaySecs))
+	}
+
+	return campIDs, delays
+}
+
+// formatStepDelays sets the human readable duration string on sequence steps.
+func formatStepDelays(steps models.SequenceSteps) {
+	for n, s := range steps {
+		steps[n].Delay = (time.Duration(s.DelaySecs) * time.Second).String()
+	}
+}
@@ -16,7 +16,7 @@ func (c *Core) GetSettings() (models.Settings, error) {
 		out models.Settings
 	)
 
-	if err := c.q.GetSettings.Get(&b); err != nil {
+	if err := c.q.GetSettings.GetContext(c.ctx, &b); err != nil {
 		return out, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching",
 				"name", "{globals.terms.settings}", "error", pqErrMsg(err)))
@@ -28,30 +28,83 @@ func (c *Core) GetSettings() (models.Settings, error) {
 			c.i18n.Ts("settings.errorEncoding", "error", err.Error()))
 	}
 
+	if err := c.q.GetSettingsVersion.GetContext(c.ctx, &out.Version); err != nil {
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching",
+				"name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+	}
+
 	return out, nil
 }
 
-// UpdateSettings updates settings.
-func (c *Core) UpdateSettings(s models.Settings) error {
+// UpdateSettings updates settings and returns the new settings version. If s.Version
+// doesn't match the current version, that is, the settings have been updated by
+// someone else since they were fetched or the version is missing, a 409 is returned.
+func (c *Core) UpdateSettings(s models.Settings) (int, error) {
 	// Marshal settings.
 	b, err := json.Marshal(s)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError,
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("settings.errorEncoding", "error", err.Error()))
 	}
 
+	tx, err := c.db.BeginTxx(c.ctx, nil)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	// Lock the settings so that concurrent updates are serialized and check the version.
+	var ver int
+	if err := tx.StmtxContext(c.ctx, c.q.LockSettings).GetContext(c.ctx, &ver); err != nil {
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+	}
+	if s.Version != ver {
+		return 0, echo.NewHTTPError(http.StatusConflict, c.i18n.T("settings.versionConflict"))
+	}
+
 	// Update the settings in the DB.
-	if _, err := c.q.UpdateSettings.Exec(b); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError,
+	if _, err := tx.StmtxContext(c.ctx, c.q.UpdateSettings).ExecContext(c.ctx, b); err != nil {
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
 	}
 
-	return nil
+	if err := tx.StmtxContext(c.ctx, c.q.BumpSettingsVersion).GetContext(c.ctx, &ver); err != nil {
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+	}
+
+	return ver, nil
 }
 
 // UpdateSettingsByKey updates a single setting by key.
 func (c *Core) UpdateSettingsByKey(key string, value json.RawMessage) error {
-	if _, err := c.q.UpdateSettingsByKey.Exec(key, value); err != nil {
+	tx, err := c.db.BeginTxx(c.ctx, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.StmtxContext(c.ctx, c.q.UpdateSettingsByKey).ExecContext(c.ctx, key, value); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+	}
+
+	// Invalidate the version held by clients that fetched the settings earlier.
+	if _, err := tx.StmtxContext(c.ctx, c.q.BumpSettingsVersion).ExecContext(c.ctx); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
+	}
+
+	if err := tx.Commit(); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.settings}", "error", pqErrMsg(err)))
 	}
@@ -0,0 +1,85 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestUpdateSettingsVersion(t *testing.T) {
+	c, _ := newTestCore(t, Constants{})
+
+	isConflict := func(err error) bool {
+		var e *echo.HTTPError
+		return errors.As(err, &e) && e.Code == http.StatusConflict
+	}
+
+	cur, err := c.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cur.Version < 1 {
+		t.Fatalf("expected a settings version, got %d", cur.Version)
+	}
+
+	// Saves without the version are rejected.
+	s := cur
+	s.Version = 0
+	if _, err := c.UpdateSettings(s); !isConflict(err) {
+		t.Fatalf("expected a conflict without the version, got %v", err)
+	}
+
+	// Of the concurrent saves of the same version, only one succeeds and the
+	// others are rejected as stale.
+	const n = 5
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, n)
+		vers = make([]int, n)
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s := cur
+			s.AppSiteName = "site " + string(rune('a'+i))
+			vers[i], errs[i] = c.UpdateSettings(s)
+		}(i)
+	}
+	wg.Wait()
+
+	var (
+		ok     int
+		newVer int
+	)
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			ok++
+			newVer = vers[i]
+		case !isConflict(err):
+			t.Fatalf("expected a conflict, got %v", err)
+		}
+	}
+	if ok != 1 || newVer != cur.Version+1 {
+		t.Fatalf("expected one save with version %d, got %d saves with version %d", cur.Version+1, ok, newVer)
+	}
+
+	// The saved settings have the new version, which the next save needs.
+	saved, err := c.GetSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Version != newVer {
+		t.Fatalf("expected version %d, got %d", newVer, saved.Version)
+	}
+	if _, err := c.UpdateSettings(cur); !isConflict(err) {
+		t.Fatalf("expected a conflict with the old version, got %v", err)
+	}
+	if _, err := c.UpdateSettings(saved); err != nil {
+		t.Fatalf("unexpected error saving the current version: %v", err)
+	}
+}
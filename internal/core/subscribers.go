@@ -4,32 +4,46 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/jmoiron/sqlx"
 	"github.com/knadh/listmonk/internal/auth"
+	"github.com/knadh/listmonk/internal/emailnorm"
+	"github.com/knadh/listmonk/internal/emailverify"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
 	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
 var (
 	allowedSubQueryTables = map[string]struct{}{
-		"subscribers":       {},
-		"lists":             {},
-		"subscribers_lists": {},
-		"campaigns":         {},
-		"campaign_lists":    {},
-		"campaign_views":    {},
-		"links":             {},
-		"link_clicks":       {},
-		"bounces":           {},
+		"subscribers":               {},
+		"lists":                     {},
+		"subscribers_lists":         {},
+		"campaigns":                 {},
+		"campaign_lists":            {},
+		"campaign_views":            {},
+		"links":                     {},
+		"link_clicks":               {},
+		"bounces":                   {},
+		"subscriber_attrib_history": {},
 	}
 )
 
+// queryCostErr is the error of ad-hoc queries that exceed the query guard limits.
+// It carries the estimates for the client to show.
+type queryCostErr struct {
+	Message  string               `json:"message"`
+	Estimate models.QueryEstimate `json:"estimate"`
+}
+
 // GetSubscriber fetches a subscriber by one of the given params.
 func (c *Core) GetSubscriber(id int, uuid, email string) (models.Subscriber, error) {
 	var uu any
@@ -38,7 +52,7 @@ func (c *Core) GetSubscriber(id int, uuid, email string) (models.Subscriber, err
 	}
 
 	var out models.Subscribers
-	if err := c.q.GetSubscriber.Select(&out, id, uu, email); err != nil {
+	if err := c.q.GetSubscriber.SelectContext(c.ctx, &out, id, uu, email, c.normalizeEmail(email)); err != nil {
 		c.log.Printf("error fetching subscriber: %v", err)
 		return models.Subscriber{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching",
@@ -66,7 +80,7 @@ func (c *Core) HasSubscriberLists(subIDs []int, listIDs []int) (map[int]bool, er
 		Has   bool `db:"has"`
 	}{}
 
-	if err := c.q.HasSubscriberLists.Select(&res, pq.Array(subIDs), pq.Array(listIDs)); err != nil {
+	if err := c.q.HasSubscriberLists.SelectContext(c.ctx, &res, pq.Array(subIDs), pq.Array(listIDs)); err != nil {
 		c.log.Printf("error fetching subscriber: %v", err)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
@@ -84,7 +98,7 @@ func (c *Core) HasSubscriberLists(subIDs []int, listIDs []int) (map[int]bool, er
 func (c *Core) GetSubscribersByEmail(emails []string) (models.Subscribers, error) {
 	var out models.Subscribers
 
-	if err := c.q.GetSubscribersByEmails.Select(&out, pq.Array(emails)); err != nil {
+	if err := c.q.GetSubscribersByEmails.SelectContext(c.ctx, &out, pq.Array(emails)); err != nil {
 		c.log.Printf("error fetching subscriber: %v", err)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
@@ -103,7 +117,7 @@ func (c *Core) GetSubscribersByEmail(emails []string) (models.Subscribers, error
 }
 
 // QuerySubscribers queries and returns paginated subscrribers based on the given params including the total count.
-func (c *Core) QuerySubscribers(searchStr, queryExp string, listIDs []int, subStatus string, order, orderBy string, offset, limit int) (models.Subscribers, int, error) {
+func (c *Core) QuerySubscribers(searchStr, queryExp string, listIDs []int, subStatus string, order, orderBy string, offset, limit int, skipTotal bool) (models.Subscribers, int, error) {
 	// Sort params.
 	if !strSliceContains(orderBy, subQuerySortFields) {
 		orderBy = "subscribers.id"
@@ -135,31 +149,45 @@ func (c *Core) QuerySubscribers(searchStr, queryExp string, listIDs []int, subSt
 	}
 
 	// Create a readonly transaction that just does COUNT() to obtain the count of results
-	// and to ensure that the arbitrary query is indeed readonly.
-	total, err := c.getSubscriberCount(searchStr, cond, subStatus, listIDs)
-	if err != nil {
-		c.log.Printf("error getting subscriber count: %v", err)
-		return nil, 0, err
-	}
+	// and to ensure that the arbitrary query is indeed readonly. The query itself also
+	// runs in a readonly transaction, so the count can be skipped.
+	total := models.TotalSkipped
+	if !skipTotal {
+		n, err := c.getSubscriberCount(searchStr, cond, subStatus, listIDs)
+		if err != nil {
+			c.log.Printf("error getting subscriber count: %v", err)
+			return nil, 0, err
+		}
+		total = n
 
-	// No results.
-	if total == 0 {
-		return models.Subscribers{}, 0, nil
+		// No results.
+		if total == 0 {
+			return models.Subscribers{}, 0, nil
+		}
 	}
 
-	tx, err := c.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	tx, err := c.db.BeginTxx(c.ctx, &sql.TxOptions{ReadOnly: true})
 	if err != nil {
 		c.log.Printf("error preparing subscriber query: %v", err)
 		return nil, 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
 	}
 	defer tx.Rollback()
 
+	if err := models.SetStatementTimeout(tx, c.queryTimeout(queryExp)); err != nil {
+		return nil, 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+
 	var out models.Subscribers
-	if err := tx.Select(&out, stmt, pq.Array(listIDs), subStatus, searchStr, offset, limit); err != nil {
+	if err := tx.SelectContext(c.ctx, &out, stmt, pq.Array(listIDs), subStatus, searchStr, offset, limit); err != nil {
 		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
 
+	// No results (when the count was skipped).
+	if len(out) == 0 {
+		return models.Subscribers{}, total, nil
+	}
+
 	// Lazy load lists for each subscriber.
 	if err := out.LoadLists(c.q.GetSubscriberListsLazy); err != nil {
 		c.log.Printf("error fetching subscriber lists: %v", err)
@@ -187,7 +215,7 @@ func (c *Core) GetSubscriberLists(subID int, uuid string, listIDs []int, listUUI
 	// Fetch double opt-in lists from the given list IDs.
 	// Get the list of subscription lists where the subscriber hasn't confirmed.
 	out := []models.List{}
-	if err := c.q.GetSubscriberLists.Select(&out, subID, uu, pq.Array(listIDs), pq.Array(listUUIDs), subStatus, listType); err != nil {
+	if err := c.q.GetSubscriberLists.SelectContext(c.ctx, &out, subID, uu, pq.Array(listIDs), pq.Array(listUUIDs), subStatus, listType); err != nil {
 		c.log.Printf("error fetching lists for opt-in: %s", pqErrMsg(err))
 		return nil, err
 	}
@@ -205,7 +233,7 @@ func (c *Core) GetSubscriberProfileForExport(id int, uuid string) (models.Subscr
 	}
 
 	var out models.SubscriberExportProfile
-	if err := c.q.ExportSubscriberData.Get(&out, id, uu); err != nil {
+	if err := c.q.ExportSubscriberData.GetContext(c.ctx, &out, id, uu); err != nil {
 		c.log.Printf("error fetching subscriber export data: %v", err)
 
 		return models.SubscriberExportProfile{}, echo.NewHTTPError(http.StatusInternalServerError,
@@ -218,7 +246,7 @@ func (c *Core) GetSubscriberProfileForExport(id int, uuid string) (models.Subscr
 // GetSubscriberActivity returns the subscriber's campaign views and link clicks for the Activity tab.
 func (c *Core) GetSubscriberActivity(id int) (models.SubscriberActivity, error) {
 	var out models.SubscriberActivity
-	if err := c.q.GetSubscriberActivity.Get(&out, id); err != nil {
+	if err := c.q.GetSubscriberActivity.GetContext(c.ctx, &out, id); err != nil {
 		c.log.Printf("error fetching subscriber activity: %v", err)
 
 		return models.SubscriberActivity{}, echo.NewHTTPError(http.StatusInternalServerError,
@@ -228,6 +256,25 @@ func (c *Core) GetSubscriberActivity(id int) (models.SubscriberActivity, error)
 	return out, nil
 }
 
+// QuerySubscriberSends returns the paginated campaign send history of a subscriber,
+// optionally filtered by a campaign and a date range.
+func (c *Core) QuerySubscriberSends(subID, campID int, from, to null.Time, offset, limit int) ([]models.SubscriberSend, int, error) {
+	out := []models.SubscriberSend{}
+	if err := c.q.QuerySubscriberSends.SelectContext(c.ctx, &out, subID, campID, from, to, offset, limit); err != nil {
+		c.log.Printf("error fetching subscriber sends: %v", err)
+
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "sends", "error", pqErrMsg(err)))
+	}
+
+	total := 0
+	if len(out) > 0 {
+		total = out[0].Total
+	}
+
+	return out, total, nil
+}
+
 // ExportSubscribers returns an iterator function that provides lists of subscribers based
 // on the given criteria in an exportable form. The iterator function returned can be called
 // repeatedly until there are nil subscribers. It's an iterator because exports can be extremely
@@ -266,7 +313,7 @@ func (c *Core) ExportSubscribers(searchStr, query string, subIDs, listIDs []int,
 	id := 0
 	return func() ([]models.SubscriberExport, error) {
 		var out []models.SubscriberExport
-		if err := tx.Select(&out, pq.Array(listIDs), id, pq.Array(subIDs), subStatus, searchStr, batchSize); err != nil {
+		if err := tx.SelectContext(c.ctx, &out, pq.Array(listIDs), id, pq.Array(subIDs), subStatus, searchStr, batchSize); err != nil {
 			c.log.Printf("error exporting subscribers by query: %v", err)
 			return nil, echo.NewHTTPError(http.StatusInternalServerError,
 				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
@@ -313,7 +360,7 @@ func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs
 	listIDs = []int{manualListID}
 	listUUIDs = []string{} // Clear UUIDs as we are setting the ID directly.
 
-	if err = c.q.InsertSubscriber.Get(&sub.ID,
+	if err = c.q.InsertSubscriber.GetContext(c.ctx, &sub.ID,
 		sub.UUID,
 		sub.Email,
 		strings.TrimSpace(sub.Name),
@@ -321,8 +368,9 @@ func (c *Core) InsertSubscriber(sub models.Subscriber, listIDs []int, listUUIDs
 		sub.Attribs,
 		pq.Array(listIDs),
 		pq.Array(listUUIDs),
-		subStatus); err != nil {
-		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "subscribers_email_key" {
+		subStatus,
+		c.normalizeEmail(sub.Email)); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && (pqErr.Constraint == "subscribers_email_key" || pqErr.Constraint == "idx_subs_email_normalized") {
 			return models.Subscriber{}, false, echo.NewHTTPError(http.StatusConflict, c.i18n.T("subscribers.emailExists"))
 		} else {
 			// return sub.Subscriber, errSubscriberExists
@@ -367,11 +415,13 @@ func (c *Core) UpdateSubscriber(id int, sub models.Subscriber) (models.Subscribe
 		}
 	}
 
-	_, err := c.q.UpdateSubscriber.Exec(id,
+	_, err := c.q.UpdateSubscriber.ExecContext(c.ctx, id,
 		sub.Email,
 		strings.TrimSpace(sub.Name),
 		sub.Status,
 		json.RawMessage(attribs),
+		c.normalizeEmail(sub.Email),
+		pq.Array(c.consts.TrackedAttribs),
 	)
 	if err != nil {
 		c.log.Printf("error updating subscriber: %v", err)
@@ -408,7 +458,7 @@ func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs
 		}
 	}
 
-	_, err := c.q.UpdateSubscriberWithLists.Exec(id,
+	_, err := c.q.UpdateSubscriberWithLists.ExecContext(c.ctx, id,
 		sub.Email,
 		strings.TrimSpace(sub.Name),
 		sub.Status,
@@ -416,7 +466,9 @@ func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs
 		pq.Array(listIDs),
 		pq.Array(listUUIDs),
 		subStatus,
-		deleteLists)
+		deleteLists,
+		c.normalizeEmail(sub.Email),
+		pq.Array(c.consts.TrackedAttribs))
 	if err != nil {
 		c.log.Printf("error updating subscriber: %v", err)
 		return models.Subscriber{}, false, echo.NewHTTPError(http.StatusInternalServerError,
@@ -443,7 +495,7 @@ func (c *Core) UpdateSubscriberWithLists(id int, sub models.Subscriber, listIDs
 
 // BlocklistSubscribers blocklists the given list of subscribers.
 func (c *Core) BlocklistSubscribers(subIDs []int) error {
-	if _, err := c.q.BlocklistSubscribers.Exec(pq.Array(subIDs)); err != nil {
+	if _, err := c.q.BlocklistSubscribers.ExecContext(c.ctx, pq.Array(subIDs)); err != nil {
 		c.log.Printf("error blocklisting subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("subscribers.errorBlocklisting", "error", err.Error()))
@@ -454,7 +506,7 @@ func (c *Core) BlocklistSubscribers(subIDs []int) error {
 
 // BlocklistSubscribersByQuery blocklists the given list of subscribers.
 func (c *Core) BlocklistSubscribersByQuery(searchStr, queryExp string, listIDs []int, subStatus string) error {
-	if err := c.q.ExecSubQueryTpl(searchStr, sanitizeSQLExp(queryExp), c.q.BlocklistSubscribersByQuery, listIDs, c.db, subStatus); err != nil {
+	if err := c.q.ExecSubQueryTpl(searchStr, sanitizeSQLExp(queryExp), c.q.BlocklistSubscribersByQuery, listIDs, c.db, subStatus, c.queryTimeout(queryExp)); err != nil {
 		c.log.Printf("error blocklisting subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("subscribers.errorBlocklisting", "error", pqErrMsg(err)))
@@ -472,7 +524,7 @@ func (c *Core) DeleteSubscribers(subIDs []int, subUUIDs []string) error {
 		subUUIDs = []string{}
 	}
 
-	if _, err := c.q.DeleteSubscribers.Exec(pq.Array(subIDs), pq.Array(subUUIDs)); err != nil {
+	if _, err := c.q.DeleteSubscribers.ExecContext(c.ctx, pq.Array(subIDs), pq.Array(subUUIDs)); err != nil {
 		c.log.Printf("error deleting subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
@@ -483,7 +535,7 @@ func (c *Core) DeleteSubscribers(subIDs []int, subUUIDs []string) error {
 
 // DeleteSubscribersByQuery deletes subscribers by a given arbitrary query expression.
 func (c *Core) DeleteSubscribersByQuery(searchStr, queryExp string, listIDs []int, subStatus string) error {
-	err := c.q.ExecSubQueryTpl(searchStr, sanitizeSQLExp(queryExp), c.q.DeleteSubscribersByQuery, listIDs, c.db, subStatus)
+	err := c.q.ExecSubQueryTpl(searchStr, sanitizeSQLExp(queryExp), c.q.DeleteSubscribersByQuery, listIDs, c.db, subStatus, c.queryTimeout(queryExp))
 	if err != nil {
 		c.log.Printf("error deleting subscribers: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -493,9 +545,44 @@ func (c *Core) DeleteSubscribersByQuery(searchStr, queryExp string, listIDs []in
 	return err
 }
 
+// RequestSubscriberDeletion marks a subscriber for deletion after the grace period
+// and returns the subscriber.
+func (c *Core) RequestSubscriberDeletion(subUUID string) (models.Subscriber, error) {
+	var out models.Subscriber
+	if err := c.q.RequestSubscriberDeletion.GetContext(c.ctx, &out, subUUID); err != nil {
+		if err == sql.ErrNoRows {
+			return out, echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.subscriber}"))
+		}
+
+		c.log.Printf("error requesting subscriber deletion: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// CancelSubscriberDeletion cancels the pending deletion of a subscriber. The bool
+// is false if there was no pending deletion.
+func (c *Core) CancelSubscriberDeletion(subUUID string) (bool, error) {
+	var id int
+	if err := c.q.CancelSubscriberDeletion.GetContext(c.ctx, &id, subUUID); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+
+		c.log.Printf("error cancelling subscriber deletion: %v", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscriber}", "error", pqErrMsg(err)))
+	}
+
+	return true, nil
+}
+
 // UnsubscribeByCampaign unsubscribes a given subscriber from lists in a given campaign.
 func (c *Core) UnsubscribeByCampaign(subUUID, campUUID string, blocklist bool) error {
-	if _, err := c.q.UnsubscribeByCampaign.Exec(campUUID, subUUID, blocklist); err != nil {
+	if _, err := c.q.UnsubscribeByCampaign.ExecContext(c.ctx, campUUID, subUUID, blocklist); err != nil {
 		c.log.Printf("error unsubscribing: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
@@ -504,13 +591,49 @@ func (c *Core) UnsubscribeByCampaign(subUUID, campUUID string, blocklist bool) e
 	return nil
 }
 
+// UnsubscribeByReply unsubscribes a subscriber from the lists of a campaign that
+// they replied to with an unsubscribe request and records the unsubscription.
+func (c *Core) UnsubscribeByReply(subUUID, campUUID string) error {
+	if err := c.UnsubscribeByCampaign(subUUID, campUUID, false); err != nil {
+		return err
+	}
+
+	return c.InsertUnsubscribeEvent(subUUID, campUUID, "", "", models.UnsubscribeSourceEmailReply)
+}
+
+// InsertUnsubscribeEvent records an unsubscription of a subscriber from the public
+// unsubscribe page or API, or a reply, optionally from a campaign, with the optional
+// reason and comment, and the source (models.UnsubscribeSource*, empty for the page and API).
+func (c *Core) InsertUnsubscribeEvent(subUUID, campUUID, reason, comment, source string) error {
+	if _, err := c.q.InsertUnsubscribeEvent.ExecContext(c.ctx, subUUID, campUUID, reason, comment, source); err != nil {
+		c.log.Printf("error recording unsubscribe event: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// GetUnsubscribeReasons returns the aggregated unsubscribe reasons of a campaign, or of
+// all campaigns if campID is 0, since the given time with the latest numComments comments.
+func (c *Core) GetUnsubscribeReasons(campID int, since time.Time, numComments int) (models.UnsubscribeReasonStats, error) {
+	var out models.UnsubscribeReasonStats
+	if err := c.q.GetUnsubscribeReasons.GetContext(c.ctx, &out, campID, since, numComments); err != nil {
+		c.log.Printf("error fetching unsubscribe reasons: %v", err)
+		return models.UnsubscribeReasonStats{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // ConfirmOptionSubscription confirms a subscriber's optin subscription.
 func (c *Core) ConfirmOptionSubscription(subUUID string, listUUIDs []string, meta models.JSON) error {
 	if meta == nil {
 		meta = models.JSON{}
 	}
 
-	if _, err := c.q.ConfirmSubscriptionOptin.Exec(subUUID, pq.Array(listUUIDs), meta); err != nil {
+	if _, err := c.q.ConfirmSubscriptionOptin.ExecContext(c.ctx, subUUID, pq.Array(listUUIDs), meta); err != nil {
 		c.log.Printf("error confirming subscription: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
@@ -526,7 +649,7 @@ func (c *Core) DeleteSubscriberBounces(id int, uuid string) error {
 		uu = uuid
 	}
 
-	if _, err := c.q.DeleteBouncesBySubscriber.Exec(id, uu); err != nil {
+	if _, err := c.q.DeleteBouncesBySubscriber.ExecContext(c.ctx, id, uu); err != nil {
 		c.log.Printf("error deleting bounces: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.bounces}", "error", pqErrMsg(err)))
@@ -537,7 +660,7 @@ func (c *Core) DeleteSubscriberBounces(id int, uuid string) error {
 
 // DeleteOrphanSubscribers deletes orphan subscriber records (subscribers without lists).
 func (c *Core) DeleteOrphanSubscribers() (int, error) {
-	res, err := c.q.DeleteOrphanSubscribers.Exec()
+	res, err := c.q.DeleteOrphanSubscribers.ExecContext(c.ctx)
 	if err != nil {
 		c.log.Printf("error deleting orphan subscribers: %v", err)
 		return 0, echo.NewHTTPError(http.StatusInternalServerError,
@@ -550,7 +673,7 @@ func (c *Core) DeleteOrphanSubscribers() (int, error) {
 
 // DeleteBlocklistedSubscribers deletes blocklisted subscribers.
 func (c *Core) DeleteBlocklistedSubscribers() (int, error) {
-	res, err := c.q.DeleteBlocklistedSubscribers.Exec()
+	res, err := c.q.DeleteBlocklistedSubscribers.ExecContext(c.ctx)
 	if err != nil {
 		c.log.Printf("error deleting blocklisted subscribers: %v", err)
 		return 0, echo.NewHTTPError(http.StatusInternalServerError,
@@ -561,13 +684,205 @@ func (c *Core) DeleteBlocklistedSubscribers() (int, error) {
 	return int(n), nil
 }
 
+// MakeSubscriberFilterExp validates the given subscriber filters and ANDs their
+// conditions with the given (optional) query expression. The bounce filter is a
+// semi-join on the aggregated bounces and the engagement filters are anti-joins
+// on the views and clicks tables, which the subscriber queries can embed as-is.
+func (c *Core) MakeSubscriberFilterExp(queryExp string, f models.SubscriberFilter) (string, error) {
+	if f.IsEmpty() {
+		return queryExp, nil
+	}
+
+	var conds []string
+	if queryExp != "" {
+		conds = append(conds, "("+queryExp+")")
+	}
+
+	// Bounces.
+	if f.MinBounces < 0 {
+		return "", echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("globals.messages.invalidFields", "name", "min_bounces"))
+	}
+	switch f.BounceType {
+	case "", models.BounceTypeHard, models.BounceTypeSoft, models.BounceTypeComplaint:
+	default:
+		return "", echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("globals.messages.invalidFields", "name", "bounce_type"))
+	}
+	if f.MinBounces > 0 || f.BounceType != "" {
+		typ := "TRUE"
+		if f.BounceType != "" {
+			typ = fmt.Sprintf("type = '%s'", f.BounceType)
+		}
+
+		conds = append(conds, fmt.Sprintf(`subscribers.id IN (SELECT subscriber_id FROM bounces WHERE %s GROUP BY subscriber_id HAVING COUNT(*) >= %d)`,
+			typ, max(f.MinBounces, 1)))
+	}
+
+	// Opens and clicks.
+	for _, e := range []struct {
+		name, date, table string
+	}{
+		{"last_open_before", f.LastOpenBefore, "campaign_views"},
+		{"last_click_before", f.LastClickBefore, "link_clicks"},
+	} {
+		if e.date == "" {
+			continue
+		}
+
+		t, err := parseFilterDate(e.date)
+		if err != nil {
+			return "", echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("globals.messages.invalidFields", "name", e.name))
+		}
+
+		conds = append(conds, fmt.Sprintf(`NOT EXISTS (SELECT 1 FROM %s e WHERE e.subscriber_id = subscribers.id AND NOT e.is_bot AND e.created_at >= '%s'::TIMESTAMP WITH TIME ZONE)`,
+			e.table, t.Format(time.RFC3339)))
+	}
+
+	if f.NeverOpened {
+		conds = append(conds, `NOT EXISTS (SELECT 1 FROM campaign_views e WHERE e.subscriber_id = subscribers.id AND NOT e.is_bot)`)
+	}
+
+	if f.PendingDeletion {
+		conds = append(conds, `subscribers.deletion_requested_at IS NOT NULL`)
+	}
+
+	switch f.VerificationStatus {
+	case "":
+	case emailverify.StatusValid, emailverify.StatusRisky, emailverify.StatusInvalid, emailverify.StatusUnknown:
+		conds = append(conds, fmt.Sprintf(`subscribers.verification_status = '%s'`, f.VerificationStatus))
+	default:
+		return "", echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("globals.messages.invalidFields", "name", "verification_status"))
+	}
+
+	return strings.Join(conds, " AND "), nil
+}
+
+// parseFilterDate parses a date (2006-01-02) or an RFC3339 timestamp.
+func parseFilterDate(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+
+	return time.Parse(time.RFC3339, s)
+}
+
+// NormalizeSubscriberEmails finds groups of subscribers whose e-mails are the same
+// as per the normalization policy. If merge is true, the subscribers in each group
+// are merged into the oldest one, and the normalized e-mails of all subscribers are
+// updated to the current policy.
+func (c *Core) NormalizeSubscriberEmails(merge bool) (models.EmailNormalizationResult, error) {
+	var (
+		plus = pq.Array(emailnorm.PlusDomains(c.consts.EmailNormalization))
+		dots = pq.Array(emailnorm.DotDomains(c.consts.EmailNormalization))
+		out  = models.EmailNormalizationResult{Duplicates: []models.EmailDuplicate{}}
+	)
+
+	if err := c.q.GetEmailDuplicates.SelectContext(c.ctx, &out.Duplicates, plus, dots); err != nil {
+		c.log.Printf("error fetching duplicate e-mails: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	if !merge {
+		return out, nil
+	}
+
+	tx, err := c.db.BeginTxx(c.ctx, nil)
+	if err != nil {
+		c.log.Printf("error beginning transaction: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	for _, d := range out.Duplicates {
+		if _, err := tx.StmtxContext(c.ctx, c.q.MergeSubscribers).ExecContext(c.ctx, d.IDs[0], pq.Array(d.IDs[1:])); err != nil {
+			c.log.Printf("error merging subscribers %v: %v", d.IDs, err)
+			return out, echo.NewHTTPError(http.StatusInternalServerError,
+				c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+		}
+		out.Merged += len(d.IDs) - 1
+	}
+
+	// Reset the normalized e-mails that are stale as per the current policy and set them again.
+	if _, err := tx.StmtxContext(c.ctx, c.q.ClearStaleNormalizedEmails).ExecContext(c.ctx, plus, dots); err != nil {
+		c.log.Printf("error clearing normalized e-mails: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+	res, err := tx.StmtxContext(c.ctx, c.q.UpdateNormalizedEmails).ExecContext(c.ctx, plus, dots)
+	if err != nil {
+		c.log.Printf("error updating normalized e-mails: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+	n, _ := res.RowsAffected()
+	out.Updated = int(n)
+
+	if err := tx.Commit(); err != nil {
+		c.log.Printf("error committing e-mail normalization: %v", err)
+		return out, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// GetSubscribersToVerify returns a batch of enabled subscribers after the given ID
+// for bulk e-mail verification, optionally only the subscribers of the given lists
+// and the ones that haven't been verified since the given time.
+func (c *Core) GetSubscribersToVerify(afterID, limit int, listIDs []int, before null.Time) ([]models.Subscriber, error) {
+	out := []models.Subscriber{}
+	if err := c.q.GetSubscribersToVerify.SelectContext(c.ctx, &out, afterID, limit, pq.Array(listIDs), before); err != nil {
+		c.log.Printf("error fetching subscribers to verify: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// CountSubscribersToVerify returns the number of subscribers GetSubscribersToVerify
+// returns in all.
+func (c *Core) CountSubscribersToVerify(listIDs []int, before null.Time) (int, error) {
+	var n int
+	if err := c.q.CountSubscribersToVerify.GetContext(c.ctx, &n, pq.Array(listIDs), before); err != nil {
+		c.log.Printf("error counting subscribers to verify: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return n, nil
+}
+
+// UpdateSubscriberVerifications sets the verification statuses of subscribers.
+// statuses are in the order of ids.
+func (c *Core) UpdateSubscriberVerifications(ids []int, statuses []string) error {
+	if _, err := c.q.UpdateSubscriberVerifications.ExecContext(c.ctx, pq.Array(ids), pq.Array(statuses)); err != nil {
+		c.log.Printf("error updating subscriber verifications: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
+// normalizeEmail returns the normalized form of an e-mail as per the policy.
+// It's empty for an empty e-mail.
+func (c *Core) normalizeEmail(email string) string {
+	if email == "" {
+		return ""
+	}
+
+	return emailnorm.Normalize(email, c.consts.EmailNormalization)
+}
+
 func (c *Core) getSubscriberCount(searchStr, queryExp, subStatus string, listIDs []int) (int, error) {
 	// If there's no condition, it's a "get all" call which can probably be optionally pulled from cache.
 	if queryExp == "" {
 		_ = c.refreshCache(matListSubStats, false)
 
 		total := 0
-		if err := c.q.QuerySubscribersCountAll.Get(&total, pq.Array(listIDs), subStatus); err != nil {
+		if err := c.q.QuerySubscribersCountAll.GetContext(c.ctx, &total, pq.Array(listIDs), subStatus); err != nil {
 			return 0, echo.NewHTTPError(http.StatusInternalServerError,
 				c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 		}
@@ -578,16 +893,20 @@ func (c *Core) getSubscriberCount(searchStr, queryExp, subStatus string, listIDs
 	// Create a readonly transaction that just does COUNT() to obtain the count of results
 	// and to ensure that the arbitrary query is indeed readonly.
 	stmt := strings.ReplaceAll(c.q.QuerySubscribersCount, "%query%", queryExp)
-	tx, err := c.db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	tx, err := c.db.BeginTxx(c.ctx, &sql.TxOptions{ReadOnly: true})
 	if err != nil {
 		c.log.Printf("error preparing subscriber query: %v", err)
 		return 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
 	}
 	defer tx.Rollback()
 
+	if err := models.SetStatementTimeout(tx, c.queryTimeout(queryExp)); err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+
 	// Execute the readonly query and get the count of results.
 	total := 0
-	if err := tx.Get(&total, stmt, pq.Array(listIDs), subStatus, searchStr); err != nil {
+	if err := tx.GetContext(c.ctx, &total, stmt, pq.Array(listIDs), subStatus, searchStr); err != nil {
 		return 0, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
 	}
@@ -595,6 +914,116 @@ func (c *Core) getSubscriberCount(searchStr, queryExp, subStatus string, listIDs
 	return total, nil
 }
 
+// EstimateSubscriberQuery returns the planner's estimated cost and the largest
+// number of rows estimated to be scanned by any step of an ad-hoc subscriber query.
+func (c *Core) EstimateSubscriberQuery(searchStr, queryExp string, listIDs []int, subStatus string) (models.QueryEstimate, error) {
+	if listIDs == nil {
+		listIDs = []int{}
+	}
+
+	stmt := strings.ReplaceAll(c.q.QuerySubscribersCount, "%query%", queryExp)
+	tx, err := c.db.BeginTxx(c.ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return models.QueryEstimate{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	var plan string
+	if err := tx.QueryRowContext(c.ctx, "EXPLAIN (FORMAT JSON) "+stmt, pq.Array(listIDs), subStatus, searchStr).Scan(&plan); err != nil {
+		return models.QueryEstimate{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", pqErrMsg(err)))
+	}
+
+	out, err := getQueryPlanEstimate(plan)
+	if err != nil {
+		c.log.Printf("error parsing query plan: %v", err)
+		return models.QueryEstimate{}, echo.NewHTTPError(http.StatusBadRequest, c.i18n.Ts("subscribers.errorPreparingQuery", "error", err.Error()))
+	}
+
+	return out, nil
+}
+
+// CheckSubscriberQuery checks the estimates of an ad-hoc subscriber query against the
+// query guard limits. Queries that exceed them are refused for non-admins if the action
+// is 'refuse'. Otherwise, they are only allowed to run if confirm is true.
+func (c *Core) CheckSubscriberQuery(searchStr, queryExp string, listIDs []int, subStatus string, confirm, isAdmin bool) error {
+	g := c.consts.QueryGuard
+	if !g.Enabled || queryExp == "" {
+		return nil
+	}
+
+	est, err := c.EstimateSubscriberQuery(searchStr, queryExp, listIDs, subStatus)
+	if err != nil {
+		return err
+	}
+
+	if (g.MaxCost <= 0 || est.Cost <= g.MaxCost) && (g.MaxRows <= 0 || est.Rows <= g.MaxRows) {
+		return nil
+	}
+
+	var (
+		cost = strconv.FormatFloat(est.Cost, 'f', 0, 64)
+		rows = strconv.FormatFloat(est.Rows, 'f', 0, 64)
+	)
+	if g.Action == models.QueryGuardActionRefuse && !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, queryCostErr{
+			Message:  c.i18n.Ts("subscribers.queryTooExpensive", "cost", cost, "rows", rows),
+			Estimate: est,
+		})
+	}
+	if !confirm {
+		return echo.NewHTTPError(http.StatusPreconditionRequired, queryCostErr{
+			Message:  c.i18n.Ts("subscribers.queryNeedsConfirm", "cost", cost, "rows", rows),
+			Estimate: est,
+		})
+	}
+
+	return nil
+}
+
+// queryTimeout returns the statement timeout for a subscriber query. Only
+// ad-hoc queries are limited.
+func (c *Core) queryTimeout(queryExp string) time.Duration {
+	if !c.consts.QueryGuard.Enabled || queryExp == "" {
+		return 0
+	}
+
+	return c.consts.QueryTimeout
+}
+
+// getQueryPlanEstimate parses the EXPLAIN JSON to get the total cost of the query
+// and the largest "Plan Rows" of all the nodes in the plan.
+func getQueryPlanEstimate(explainJSON string) (models.QueryEstimate, error) {
+	var plans []struct {
+		Plan map[string]any `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(explainJSON), &plans); err != nil {
+		return models.QueryEstimate{}, err
+	}
+	if len(plans) == 0 || plans[0].Plan == nil {
+		return models.QueryEstimate{}, errors.New("empty query plan")
+	}
+
+	var out models.QueryEstimate
+	out.Cost, _ = plans[0].Plan["Total Cost"].(float64)
+	traverseQueryPlanRows(plans[0].Plan, &out.Rows)
+
+	return out, nil
+}
+
+func traverseQueryPlanRows(node map[string]any, rows *float64) {
+	if n, ok := node["Plan Rows"].(float64); ok && n > *rows {
+		*rows = n
+	}
+
+	if plans, ok := node["Plans"].([]any); ok {
+		for _, p := range plans {
+			if m, ok := p.(map[string]any); ok {
+				traverseQueryPlanRows(m, rows)
+			}
+		}
+	}
+}
+
 // validateQueryTables checks if the query accesses only allowed tables.
 func validateQueryTables(db *sqlx.DB, query string, allowedTables map[string]struct{}) error {
 	// Get the EXPLAIN (FORMAT JSON) output.
@@ -694,7 +1123,7 @@ func (c *Core) getOrCreateManualBatchList() (int, error) {
 			Count int    `db:"count"`
 		}
 
-		if err := c.db.Get(&res, query); err != nil {
+		if err := c.db.GetContext(c.ctx, &res, query); err != nil {
 			if err != sql.ErrNoRows {
 				return 0, err
 			}
@@ -724,7 +1153,7 @@ func (c *Core) getOrCreateManualBatchList() (int, error) {
 
 		name := fmt.Sprintf("manual-import-batch-%d", newBatchNum)
 		var newID int
-		if err := c.q.CreateList.Get(&newID,
+		if err := c.q.CreateList.GetContext(c.ctx, &newID,
 			uu,
 			name,
 			models.ListTypePrivate,
@@ -732,6 +1161,10 @@ func (c *Core) getOrCreateManualBatchList() (int, error) {
 			models.ListStatusActive,
 			pq.StringArray([]string{tagName}),
 			"Auto-created list for manual subscriber additions",
+			0,
+			nil,
+			"",
+			"",
 		); err != nil {
 			// If we hit a unique constraint violation (likely due to race condition), retry.
 			if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" { // unique_violation
@@ -0,0 +1,680 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/emailnorm"
+	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/testdb"
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+// newTestCore returns a Core on a test database. It skips the test if there's
+// no test database.
+func newTestCore(t *testing.T, consts Constants) (*Core, *testdb.DB) {
+	t.Helper()
+
+	db := testdb.New(t)
+
+	b, err := os.ReadFile("../../i18n/en.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := i18n.New(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return New(&Opt{
+		Constants: consts,
+		I18n:      i,
+		DB:        db.DB,
+		Queries:   db.Q,
+		Log:       log.New(io.Discard, "", 0),
+	}, &Hooks{}), db
+}
+
+func TestAttribHistory(t *testing.T) {
+	c, db := newTestCore(t, Constants{TrackedAttribs: []string{"plan"}})
+
+	var id int
+	if err := db.Get(&id, `INSERT INTO subscribers (uuid, email, name, attribs)
+		VALUES (gen_random_uuid(), 'sub@example.com', 'sub', '{"plan": "free", "city": "Berlin"}') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	type change struct {
+		Key      string  `db:"key"`
+		OldValue *string `db:"old_value"`
+		NewValue *string `db:"new_value"`
+	}
+	history := func() []change {
+		var out []change
+		if err := db.Select(&out, `SELECT key, old_value::TEXT, new_value::TEXT FROM subscriber_attrib_history
+			WHERE subscriber_id = $1 ORDER BY id`, id); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	// Changes to untracked keys aren't recorded.
+	if _, err := c.UpdateSubscriber(id, models.Subscriber{Attribs: models.JSON{"plan": "free", "city": "Paris", "new": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if h := history(); len(h) != 0 {
+		t.Fatalf("expected no history for untracked keys, got %+v", h)
+	}
+
+	// Changes to tracked keys are.
+	if _, err := c.UpdateSubscriber(id, models.Subscriber{Attribs: models.JSON{"plan": "pro", "city": "Rome"}}); err != nil {
+		t.Fatal(err)
+	}
+	h := history()
+	if len(h) != 1 || h[0].Key != "plan" || *h[0].OldValue != `"free"` || *h[0].NewValue != `"pro"` {
+		t.Fatalf("unexpected history %+v", h)
+	}
+
+	// Removing a tracked key is a change to null.
+	if _, err := c.UpdateSubscriber(id, models.Subscriber{Attribs: models.JSON{"city": "Rome"}}); err != nil {
+		t.Fatal(err)
+	}
+	if h := history(); len(h) != 2 || h[1].Key != "plan" || h[1].NewValue != nil {
+		t.Fatalf("unexpected history %+v", h)
+	}
+
+	// Nothing's recorded without tracked keys.
+	c.consts.TrackedAttribs = nil
+	if _, err := c.UpdateSubscriber(id, models.Subscriber{Attribs: models.JSON{"plan": "team"}}); err != nil {
+		t.Fatal(err)
+	}
+	if h := history(); len(h) != 2 {
+		t.Fatalf("expected no new history without tracked keys, got %+v", h)
+	}
+}
+
+func TestMergeSubscribers(t *testing.T) {
+	c, db := newTestCore(t, Constants{EmailNormalization: emailnorm.Policy{StripDots: true, StripPlus: true}})
+
+	newSub := func(email, status, attribs string) int {
+		var id int
+		if err := db.Get(&id, `INSERT INTO subscribers (uuid, email, name, status, attribs)
+			VALUES (gen_random_uuid(), $1, $1, $2, $3) RETURNING id`, email, status, attribs); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	var (
+		keep  = newSub("user@gmail.com", "enabled", `{"plan": "pro", "city": "Berlin"}`)
+		dup   = newSub("u.ser+news@gmail.com", "blocklisted", `{"plan": "free", "age": 30}`)
+		other = newSub("user@example.com", "enabled", `{}`)
+	)
+
+	var listID, campID int
+	if err := db.Get(&listID, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'list', 'private') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&campID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com', '', 'email') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	// The duplicate's data.
+	for _, q := range []string{
+		`INSERT INTO subscriber_lists (subscriber_id, list_id, status) VALUES ($1, $2, 'confirmed')`,
+		`INSERT INTO campaign_views (campaign_id, subscriber_id) VALUES ($3, $1)`,
+		`INSERT INTO campaign_sends (campaign_id, subscriber_id, messenger) VALUES ($3, $1, 'email')`,
+		`INSERT INTO bounces (subscriber_id, campaign_id) VALUES ($1, $3)`,
+		`INSERT INTO unsubscribe_events (subscriber_id, campaign_id) VALUES ($1, $3)`,
+		`INSERT INTO subscriber_attrib_history (subscriber_id, key, old_value, new_value) VALUES ($1, 'plan', NULL, '"free"')`,
+	} {
+		if _, err := db.Exec(q, dup, listID, campID); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+
+	count := func(table string, id int) int {
+		var n int
+		if err := db.Get(&n, `SELECT COUNT(*) FROM `+table+` WHERE subscriber_id = $1`, id); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	tables := []string{"subscriber_lists", "campaign_views", "campaign_sends", "bounces", "unsubscribe_events", "subscriber_attrib_history"}
+
+	// Without merging, the duplicates are only listed.
+	out, err := c.NormalizeSubscriberEmails(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Duplicates) != 1 || out.Duplicates[0].Email != "user@gmail.com" || out.Merged != 0 {
+		t.Fatalf("unexpected duplicates %+v", out)
+	}
+	if ids := out.Duplicates[0].IDs; len(ids) != 2 || int(ids[0]) != keep || int(ids[1]) != dup {
+		t.Fatalf("unexpected duplicate IDs %v", ids)
+	}
+	if n := count("subscriber_lists", dup); n != 1 {
+		t.Fatalf("expected the duplicate to be left without merging, got %d subscriptions", n)
+	}
+
+	// Merging moves everything over to the oldest subscriber.
+	out, err = c.NormalizeSubscriberEmails(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Merged != 1 {
+		t.Fatalf("expected 1 merged subscriber, got %+v", out)
+	}
+	for _, tb := range tables {
+		if n := count(tb, keep); n != 1 {
+			t.Errorf("%s: expected 1 row of the merged subscriber, got %d", tb, n)
+		}
+	}
+
+	var sub struct {
+		Status     string `db:"status"`
+		Attribs    string `db:"attribs"`
+		Normalized string `db:"email_normalized"`
+	}
+	if err := db.Get(&sub, `SELECT status, attribs::TEXT AS attribs, email_normalized FROM subscribers WHERE id = $1`, keep); err != nil {
+		t.Fatal(err)
+	}
+	if sub.Status != "blocklisted" || sub.Attribs != `{"age": 30, "city": "Berlin", "plan": "pro"}` || sub.Normalized != "user@gmail.com" {
+		t.Errorf("unexpected merged subscriber %+v", sub)
+	}
+
+	var n int
+	if err := db.Get(&n, `SELECT COUNT(*) FROM subscribers WHERE id = ANY($1::INT[])`, pq.Array([]int{dup, other})); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("expected only the duplicate to be deleted, got %d of 2 subscribers", n)
+	}
+
+	// There's nothing to merge the second time.
+	out, err = c.NormalizeSubscriberEmails(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Duplicates) != 0 || out.Merged != 0 {
+		t.Errorf("expected no duplicates after merging, got %+v", out)
+	}
+}
+
+func TestMakeSubscriberFilterExp(t *testing.T) {
+	b, err := os.ReadFile("../../i18n/en.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := i18n.New(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &Core{i18n: i}
+
+	// Without filters, the query is left as-is.
+	if got, err := c.MakeSubscriberFilterExp("subscribers.name = 'x'", models.SubscriberFilter{}); err != nil || got != "subscribers.name = 'x'" {
+		t.Errorf("expected the query to be unchanged, got %q: %v", got, err)
+	}
+
+	// The filters are ANDed with the query.
+	got, err := c.MakeSubscriberFilterExp("subscribers.name = 'x' OR TRUE", models.SubscriberFilter{MinBounces: 2, BounceType: models.BounceTypeSoft, NeverOpened: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(got, "(subscribers.name = 'x' OR TRUE) AND subscribers.id IN (") ||
+		!strings.Contains(got, "type = 'soft'") || !strings.Contains(got, "COUNT(*) >= 2") || !strings.HasSuffix(got, "AND NOT e.is_bot)") {
+		t.Errorf("unexpected expression %s", got)
+	}
+
+	// A bounce type alone is at least one bounce of the type.
+	if got, _ := c.MakeSubscriberFilterExp("", models.SubscriberFilter{BounceType: models.BounceTypeHard}); !strings.HasPrefix(got, "subscribers.id IN (") || !strings.Contains(got, "COUNT(*) >= 1") {
+		t.Errorf("unexpected expression %s", got)
+	}
+
+	// Dates and timestamps.
+	got, err = c.MakeSubscriberFilterExp("", models.SubscriberFilter{LastOpenBefore: "2024-01-02", LastClickBefore: "2024-01-02T10:00:00+05:30"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "campaign_views e") || !strings.Contains(got, "'2024-01-02T00:00:00Z'") ||
+		!strings.Contains(got, "link_clicks e") || !strings.Contains(got, "'2024-01-02T10:00:00+05:30'") {
+		t.Errorf("unexpected expression %s", got)
+	}
+
+	// Invalid filters.
+	for _, f := range []models.SubscriberFilter{
+		{MinBounces: -1},
+		{BounceType: "nope"},
+		{BounceType: "soft' OR '1'='1"},
+		{LastOpenBefore: "yesterday"},
+		{LastClickBefore: "2024-13-01"},
+		{VerificationStatus: "nope"},
+	} {
+		var he *echo.HTTPError
+		if _, err := c.MakeSubscriberFilterExp("", f); !errors.As(err, &he) || he.Code != http.StatusBadRequest {
+			t.Errorf("%+v: expected a bad request, got %v", f, err)
+		}
+	}
+}
+
+// TestSubscriberFilters runs each of the filters and their combinations with
+// the list, subscription status, and query filters, and the bulk actions.
+func TestSubscriberFilters(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	var listA, listB, campID, linkID int
+	if err := db.Get(&listA, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'a', 'private') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&listB, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'b', 'private') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&campID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com', '', 'email') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&linkID, `INSERT INTO links (uuid, url) VALUES (gen_random_uuid(), 'https://example.com') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Subscribers with their bounces, and opens and clicks n days ago.
+	type event struct {
+		bot  bool
+		days int
+	}
+	subs := []struct {
+		email, status string
+		list          int
+		subStatus     string
+		soft, hard    int
+		views, clicks []event
+	}{
+		// Two soft bounces and an old open.
+		{"old@example.com", "enabled", listA, "confirmed", 2, 0, []event{{false, 200}}, nil},
+		// Two soft bounces and recent opens and clicks.
+		{"active@example.com", "enabled", listA, "confirmed", 2, 0, []event{{false, 200}, {false, 10}}, []event{{false, 10}}},
+		// Hard bounces and no opens.
+		{"hard@example.com", "blocklisted", listA, "unsubscribed", 0, 3, nil, nil},
+		// One soft bounce and a recent open and click by a bot.
+		{"bot@example.com", "enabled", listB, "confirmed", 1, 0, []event{{true, 1}}, []event{{true, 1}}},
+		// No bounces and an old click.
+		{"clicker@example.com", "enabled", listA, "unconfirmed", 0, 0, nil, []event{{false, 200}}},
+	}
+	for _, s := range subs {
+		var id int
+		if err := db.Get(&id, `INSERT INTO subscribers (uuid, email, name, status) VALUES (gen_random_uuid(), $1, $1, $2) RETURNING id`, s.email, s.status); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO subscriber_lists (subscriber_id, list_id, status) VALUES ($1, $2, $3)`, id, s.list, s.subStatus); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO bounces (subscriber_id, type) SELECT $1, 'soft' FROM generate_series(1, $2)`, id, s.soft); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec(`INSERT INTO bounces (subscriber_id, type) SELECT $1, 'hard' FROM generate_series(1, $2)`, id, s.hard); err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range s.views {
+			if _, err := db.Exec(`INSERT INTO campaign_views (campaign_id, subscriber_id, is_bot, created_at) VALUES ($1, $2, $3, NOW() - MAKE_INTERVAL(days => $4))`,
+				campID, id, v.bot, v.days); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, v := range s.clicks {
+			if _, err := db.Exec(`INSERT INTO link_clicks (campaign_id, link_id, subscriber_id, is_bot, created_at) VALUES ($1, $2, $3, $4, NOW() - MAKE_INTERVAL(days => $5))`,
+				campID, linkID, id, v.bot, v.days); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var (
+		ago90  = time.Now().AddDate(0, 0, -90).Format(time.DateOnly)
+		ago100 = time.Now().AddDate(0, 0, -100).Format(time.RFC3339)
+	)
+	cases := []struct {
+		name      string
+		query     string
+		f         models.SubscriberFilter
+		listIDs   []int
+		subStatus string
+		exp       []string
+	}{
+		{"min bounces", "", models.SubscriberFilter{MinBounces: 2}, nil, "", []string{"active@example.com", "hard@example.com", "old@example.com"}},
+		{"min bounces of a type", "", models.SubscriberFilter{MinBounces: 2, BounceType: models.BounceTypeSoft}, nil, "", []string{"active@example.com", "old@example.com"}},
+		{"bounce type", "", models.SubscriberFilter{BounceType: models.BounceTypeSoft}, nil, "", []string{"active@example.com", "bot@example.com", "old@example.com"}},
+		{"too many bounces", "", models.SubscriberFilter{MinBounces: 4}, nil, "", nil},
+		{"last open before", "", models.SubscriberFilter{LastOpenBefore: ago90}, nil, "", []string{"bot@example.com", "clicker@example.com", "hard@example.com", "old@example.com"}},
+		{"last click before", "", models.SubscriberFilter{LastClickBefore: ago100}, nil, "", []string{"bot@example.com", "clicker@example.com", "hard@example.com", "old@example.com"}},
+		{"never opened", "", models.SubscriberFilter{NeverOpened: true}, nil, "", []string{"bot@example.com", "clicker@example.com", "hard@example.com"}},
+		{"soft bounces and no opens", "", models.SubscriberFilter{MinBounces: 2, BounceType: models.BounceTypeSoft, LastOpenBefore: ago90}, nil, "", []string{"old@example.com"}},
+		{"no opens or clicks", "", models.SubscriberFilter{LastOpenBefore: ago90, LastClickBefore: ago90}, nil, "", []string{"bot@example.com", "clicker@example.com", "hard@example.com", "old@example.com"}},
+		{"with a list", "", models.SubscriberFilter{MinBounces: 1}, []int{listB}, "", []string{"bot@example.com"}},
+		{"with a subscription status", "", models.SubscriberFilter{NeverOpened: true}, []int{listA}, "unconfirmed", []string{"clicker@example.com"}},
+		{"with a query", "subscribers.status = 'enabled'", models.SubscriberFilter{MinBounces: 2}, nil, "", []string{"active@example.com", "old@example.com"}},
+		{"with an OR query", "subscribers.status = 'blocklisted' OR subscribers.email = 'old@example.com'", models.SubscriberFilter{NeverOpened: true}, nil, "", []string{"hard@example.com"}},
+	}
+	for _, cs := range cases {
+		exp, err := c.MakeSubscriberFilterExp(cs.query, cs.f)
+		if err != nil {
+			t.Fatalf("%s: %v", cs.name, err)
+		}
+
+		res, total, err := c.QuerySubscribers("", exp, cs.listIDs, cs.subStatus, "asc", "email", 0, 50, false)
+		if err != nil {
+			t.Fatalf("%s: %v", cs.name, err)
+		}
+
+		var got []string
+		for _, s := range res {
+			got = append(got, s.Email)
+		}
+		if !slices.Equal(got, cs.exp) || total != len(cs.exp) {
+			t.Errorf("%s: expected %v, got %v (%d)", cs.name, cs.exp, got, total)
+		}
+	}
+
+	// The bulk actions by query apply to the filtered subscribers.
+	exp, err := c.MakeSubscriberFilterExp("", models.SubscriberFilter{MinBounces: 2, BounceType: models.BounceTypeSoft, LastOpenBefore: ago90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.BlocklistSubscribersByQuery("", exp, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	var blocked []string
+	if err := db.Select(&blocked, `SELECT email FROM subscribers WHERE status = 'blocklisted' ORDER BY email`); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(blocked, []string{"hard@example.com", "old@example.com"}) {
+		t.Errorf("unexpected blocklisted subscribers %v", blocked)
+	}
+
+	exp, err = c.MakeSubscriberFilterExp("", models.SubscriberFilter{NeverOpened: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.DeleteSubscribersByQuery("", exp, []int{listA}, ""); err != nil {
+		t.Fatal(err)
+	}
+	var left []string
+	if err := db.Select(&left, `SELECT email FROM subscribers ORDER BY email`); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(left, []string{"active@example.com", "bot@example.com", "old@example.com"}) {
+		t.Errorf("unexpected subscribers after the delete %v", left)
+	}
+}
+
+func TestGetQueryPlanEstimate(t *testing.T) {
+	plan := `[{"Plan": {"Node Type": "Aggregate", "Total Cost": 2345.67, "Plan Rows": 1, "Plans": [
+		{"Node Type": "Hash Join", "Plan Rows": 120, "Plans": [
+			{"Node Type": "Seq Scan", "Plan Rows": 50000},
+			{"Node Type": "Hash", "Plan Rows": 10}
+		]}
+	]}}]`
+
+	est, err := getQueryPlanEstimate(plan)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if est.Cost != 2345.67 || est.Rows != 50000 {
+		t.Errorf("expected the total cost and the largest row estimate, got %+v", est)
+	}
+
+	for _, p := range []string{``, `[]`, `[{}]`, `{"Plan": {}}`} {
+		if _, err := getQueryPlanEstimate(p); err == nil {
+			t.Errorf("%s: expected an error", p)
+		}
+	}
+}
+
+// TestCheckSubscriberQuery checks the query guard with an intentionally awful
+// query that table-scans a large subscriber table.
+func TestCheckSubscriberQuery(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	if _, err := db.Exec(`INSERT INTO subscribers (uuid, email, name, status, attribs)
+		SELECT gen_random_uuid(), 'user' || i || '@example.com', 'User ' || i, 'enabled', JSONB_BUILD_OBJECT('n', i)
+		FROM generate_series(1, 100000) i`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`ANALYZE subscribers, subscriber_lists`); err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		awful = `subscribers.attribs::TEXT ILIKE '%needle%' AND subscribers.created_at::DATE > '2000-01-01'`
+		wide  = `subscribers.created_at::DATE > '2000-01-01'`
+		cheap = `subscribers.id = 1`
+	)
+
+	// The planner's estimates of the awful query are far above those of the cheap one.
+	bad, err := c.EstimateSubscriberQuery("", awful, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	good, err := c.EstimateSubscriberQuery("", cheap, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bad.Cost <= 1000 || good.Cost >= 1000 {
+		t.Fatalf("unexpected estimates %+v and %+v", bad, good)
+	}
+
+	// Invalid queries fail the estimate.
+	var he *echo.HTTPError
+	if _, err := c.EstimateSubscriberQuery("", "nope = 1", nil, ""); !errors.As(err, &he) || he.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad request for an invalid query, got %v", err)
+	}
+
+	check := func(query string, confirm, isAdmin bool) (int, models.QueryEstimate) {
+		t.Helper()
+
+		err := c.CheckSubscriberQuery("", query, nil, "", confirm, isAdmin)
+		if err == nil {
+			return 0, models.QueryEstimate{}
+		}
+		if !errors.As(err, &he) {
+			t.Fatalf("unexpected error %v", err)
+		}
+		e, ok := he.Message.(queryCostErr)
+		if !ok || e.Message == "" {
+			t.Fatalf("expected the estimates in the error, got %v", he.Message)
+		}
+		return he.Code, e.Estimate
+	}
+
+	// Without the guard, everything runs.
+	if code, _ := check(awful, false, false); code != 0 {
+		t.Errorf("expected no checks without the guard, got %d", code)
+	}
+
+	// Queries over the limits need to be confirmed.
+	c.consts.QueryGuard = models.QueryGuard{Enabled: true, MaxCost: 1000, Action: models.QueryGuardActionConfirm}
+	if code, est := check(awful, false, false); code != http.StatusPreconditionRequired || est != bad {
+		t.Errorf("expected a confirmation with the estimates, got %d: %+v", code, est)
+	}
+	if code, _ := check(awful, true, false); code != 0 {
+		t.Errorf("expected the confirmed query to run, got %d", code)
+	}
+	if code, _ := check(cheap, false, false); code != 0 {
+		t.Errorf("expected the cheap query to run, got %d", code)
+	}
+	if code, _ := check("", false, false); code != 0 {
+		t.Errorf("expected no checks without a query, got %d", code)
+	}
+
+	// The row limit applies independently of the cost.
+	c.consts.QueryGuard = models.QueryGuard{Enabled: true, MaxRows: 10000, Action: models.QueryGuardActionConfirm}
+	if code, _ := check(wide, false, false); code != http.StatusPreconditionRequired {
+		t.Errorf("expected the row limit to apply, got %d", code)
+	}
+	if code, _ := check(cheap, false, false); code != 0 {
+		t.Errorf("expected the cheap query to run, got %d", code)
+	}
+
+	// Non-admins are refused even if they confirm whereas admins can confirm.
+	c.consts.QueryGuard = models.QueryGuard{Enabled: true, MaxCost: 1000, Action: models.QueryGuardActionRefuse}
+	if code, _ := check(awful, true, false); code != http.StatusForbidden {
+		t.Errorf("expected the query to be refused, got %d", code)
+	}
+	if code, _ := check(awful, false, true); code != http.StatusPreconditionRequired {
+		t.Errorf("expected admins to confirm, got %d", code)
+	}
+	if code, _ := check(awful, true, true); code != 0 {
+		t.Errorf("expected the admin's confirmed query to run, got %d", code)
+	}
+}
+
+// TestSubscriberQueryTimeout checks that ad-hoc queries are cancelled after the
+// query guard's statement timeout.
+func TestSubscriberQueryTimeout(t *testing.T) {
+	c, db := newTestCore(t, Constants{QueryTimeout: 100 * time.Millisecond})
+
+	if _, err := db.Exec(`INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), 'sub@example.com', 'sub')`); err != nil {
+		t.Fatal(err)
+	}
+
+	const slow = `(SELECT TRUE FROM pg_sleep(1))`
+
+	// The timeout only applies with the guard on.
+	if _, _, err := c.QuerySubscribers("", slow, nil, "", "", "", 0, 10, false); err != nil {
+		t.Fatalf("expected no timeout without the guard, got %v", err)
+	}
+
+	c.consts.QueryGuard = models.QueryGuard{Enabled: true, Action: models.QueryGuardActionConfirm}
+	if _, _, err := c.QuerySubscribers("", slow, nil, "", "", "", 0, 10, false); err == nil {
+		t.Error("expected the query to time out")
+	}
+	if err := c.DeleteSubscribersByQuery("", slow, nil, ""); err == nil {
+		t.Error("expected the bulk delete to time out")
+	}
+
+	// Queries without an ad-hoc expression aren't limited.
+	if _, _, err := c.QuerySubscribers("", "", nil, "", "", "", 0, 10, false); err != nil {
+		t.Errorf("expected no timeout without a query, got %v", err)
+	}
+
+	var n int
+	if err := db.Get(&n, `SELECT COUNT(*) FROM subscribers`); err != nil || n != 1 {
+		t.Errorf("expected the subscriber to not be deleted, got %d: %v", n, err)
+	}
+}
+
+func TestUnsubscribeReasons(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	var subUUID, campUUID string
+	if err := db.Get(&subUUID, `INSERT INTO subscribers (uuid, email, name) VALUES (gen_random_uuid(), 'sub@example.com', 'sub') RETURNING uuid`); err != nil {
+		t.Fatal(err)
+	}
+	var campID, otherID int
+	if err := db.Get(&campID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger)
+		VALUES (gen_random_uuid(), 'camp', 'camp', 'from@example.com', '', 'email') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&campUUID, `SELECT uuid FROM campaigns WHERE id = $1`, campID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Get(&otherID, `INSERT INTO campaigns (uuid, name, subject, from_email, body, messenger)
+		VALUES (gen_random_uuid(), 'other', 'other', 'from@example.com', '', 'email') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, e := range []struct{ camp, reason, comment string }{
+		{campUUID, "Too frequent", "Weekly is too much"},
+		{campUUID, "Too frequent", ""},
+		{campUUID, "Not relevant", "Older comment"},
+		{campUUID, "", ""},
+		{"", "Not relevant", "No campaign"},
+	} {
+		if err := c.InsertUnsubscribeEvent(subUUID, e.camp, e.reason, e.comment, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Order the comments deterministically.
+	if _, err := db.Exec(`UPDATE unsubscribe_events SET created_at = NOW() - INTERVAL '1 hour' WHERE comment = 'Older comment'`); err != nil {
+		t.Fatal(err)
+	}
+
+	type reason struct {
+		Reason string `json:"reason"`
+		Count  int    `json:"count"`
+	}
+	type comment struct {
+		Comment string `json:"comment"`
+	}
+	get := func(campID int, since time.Time, numComments int) (int, []reason, []comment) {
+		t.Helper()
+
+		out, err := c.GetUnsubscribeReasons(campID, since, numComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var (
+			r  []reason
+			cm []comment
+		)
+		if err := json.Unmarshal(out.Reasons, &r); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(out.Comments, &cm); err != nil {
+			t.Fatal(err)
+		}
+		return out.Total, r, cm
+	}
+
+	// A campaign's reasons are sorted by count, with skipped reasons counted under
+	// an empty reason, and the latest comments first.
+	total, r, cm := get(campID, time.Time{}, 10)
+	if total != 4 || !slices.Equal(r, []reason{{"Too frequent", 2}, {"", 1}, {"Not relevant", 1}}) {
+		t.Errorf("unexpected reasons %d %+v", total, r)
+	}
+	if !slices.Equal(cm, []comment{{"Weekly is too much"}, {"Older comment"}}) {
+		t.Errorf("unexpected comments %+v", cm)
+	}
+
+	// The number of comments is limited.
+	if _, _, cm := get(campID, time.Time{}, 1); !slices.Equal(cm, []comment{{"Weekly is too much"}}) {
+		t.Errorf("expected the latest comment, got %+v", cm)
+	}
+	if _, _, cm := get(campID, time.Time{}, 0); len(cm) != 0 {
+		t.Errorf("expected no comments, got %+v", cm)
+	}
+
+	// All the campaigns, including unsubscriptions without one.
+	if total, r, _ := get(0, time.Time{}, 0); total != 5 || !slices.Equal(r, []reason{{"Not relevant", 2}, {"Too frequent", 2}, {"", 1}}) {
+		t.Errorf("unexpected reasons %d %+v", total, r)
+	}
+
+	// Older events are excluded.
+	if total, r, _ := get(0, time.Now().Add(-time.Minute), 0); total != 4 || !slices.Contains(r, reason{"Not relevant", 1}) {
+		t.Errorf("expected the older event to be excluded, got %d %+v", total, r)
+	}
+
+	// Campaigns without unsubscriptions are empty arrays.
+	if total, r, cm := get(otherID, time.Time{}, 10); total != 0 || r == nil || len(r) != 0 || cm == nil || len(cm) != 0 {
+		t.Errorf("expected no reasons, got %d %+v %+v", total, r, cm)
+	}
+
+	// Unknown subscribers aren't recorded.
+	if err := c.InsertUnsubscribeEvent("00000000-0000-0000-0000-000000000000", campUUID, "Too frequent", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if total, _, _ := get(0, time.Time{}, 0); total != 5 {
+		t.Errorf("expected the unknown subscriber not to be recorded, got %d", total)
+	}
+}
@@ -12,7 +12,7 @@ import (
 // GetSubscriptions retrieves the subscriptions for a subscriber.
 func (c *Core) GetSubscriptions(subID int, subUUID string, allLists bool) ([]models.Subscription, error) {
 	var out []models.Subscription
-	err := c.q.GetSubscriptions.Select(&out, subID, subUUID, allLists)
+	err := c.q.GetSubscriptions.SelectContext(c.ctx, &out, subID, subUUID, allLists)
 	if err != nil {
 		c.log.Printf("error getting subscriptions: %v", err)
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
@@ -24,7 +24,7 @@ func (c *Core) GetSubscriptions(subID int, subUUID string, allLists bool) ([]mod
 
 // AddSubscriptions adds list subscriptions to subscribers.
 func (c *Core) AddSubscriptions(subIDs, listIDs []int, status string) error {
-	if _, err := c.q.AddSubscribersToLists.Exec(pq.Array(subIDs), pq.Array(listIDs), status); err != nil {
+	if _, err := c.q.AddSubscribersToLists.ExecContext(c.ctx, pq.Array(subIDs), pq.Array(listIDs), status); err != nil {
 		c.log.Printf("error adding subscriptions: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", err.Error()))
@@ -40,7 +40,7 @@ func (c *Core) AddSubscriptionsByQuery(searchStr, queryExp string, sourceListIDs
 		sourceListIDs = []int{}
 	}
 
-	err := c.q.ExecSubQueryTpl(searchStr, queryExp, c.q.AddSubscribersToListsByQuery, sourceListIDs, c.db, subStatus, pq.Array(targetListIDs), status)
+	err := c.q.ExecSubQueryTpl(searchStr, queryExp, c.q.AddSubscribersToListsByQuery, sourceListIDs, c.db, subStatus, c.queryTimeout(queryExp), pq.Array(targetListIDs), status)
 	if err != nil {
 		c.log.Printf("error adding subscriptions by query: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -52,7 +52,7 @@ func (c *Core) AddSubscriptionsByQuery(searchStr, queryExp string, sourceListIDs
 
 // DeleteSubscriptions delete list subscriptions from subscribers.
 func (c *Core) DeleteSubscriptions(subIDs, listIDs []int) error {
-	if _, err := c.q.DeleteSubscriptions.Exec(pq.Array(subIDs), pq.Array(listIDs)); err != nil {
+	if _, err := c.q.DeleteSubscriptions.ExecContext(c.ctx, pq.Array(subIDs), pq.Array(listIDs)); err != nil {
 		c.log.Printf("error deleting subscriptions: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", err.Error()))
@@ -69,7 +69,7 @@ func (c *Core) DeleteSubscriptionsByQuery(searchStr, queryExp string, sourceList
 		sourceListIDs = []int{}
 	}
 
-	err := c.q.ExecSubQueryTpl(searchStr, queryExp, c.q.DeleteSubscriptionsByQuery, sourceListIDs, c.db, subStatus, pq.Array(targetListIDs))
+	err := c.q.ExecSubQueryTpl(searchStr, queryExp, c.q.DeleteSubscriptionsByQuery, sourceListIDs, c.db, subStatus, c.queryTimeout(queryExp), pq.Array(targetListIDs))
 	if err != nil {
 		c.log.Printf("error deleting subscriptions by query: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -79,9 +79,35 @@ func (c *Core) DeleteSubscriptionsByQuery(searchStr, queryExp string, sourceList
 	return nil
 }
 
+// ManageSubscriptionItems applies a batch of subscription changes and returns
+// the result of each. The (subscriber, list) pairs in the items should be unique.
+func (c *Core) ManageSubscriptionItems(items []models.SubscriptionItem) ([]models.SubscriptionItemResult, error) {
+	var (
+		subIDs   = make([]int, len(items))
+		listIDs  = make([]int, len(items))
+		actions  = make([]string, len(items))
+		statuses = make([]string, len(items))
+	)
+	for n, it := range items {
+		subIDs[n] = it.SubscriberID
+		listIDs[n] = it.ListID
+		actions[n] = it.Action
+		statuses[n] = it.Status
+	}
+
+	out := []models.SubscriptionItemResult{}
+	if err := c.q.ManageSubscriptionItems.SelectContext(c.ctx, &out, pq.Array(subIDs), pq.Array(listIDs), pq.Array(actions), pq.Array(statuses)); err != nil {
+		c.log.Printf("error updating subscriptions: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
 // UnsubscribeLists sets list subscriptions to 'unsubscribed'.
 func (c *Core) UnsubscribeLists(subIDs, listIDs []int, listUUIDs []string) error {
-	if _, err := c.q.UnsubscribeSubscribersFromLists.Exec(pq.Array(subIDs), pq.Array(listIDs), pq.StringArray(listUUIDs)); err != nil {
+	if _, err := c.q.UnsubscribeSubscribersFromLists.ExecContext(c.ctx, pq.Array(subIDs), pq.Array(listIDs), pq.StringArray(listUUIDs)); err != nil {
 		c.log.Printf("error unsubscribing from lists: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.subscribers}", "error", err.Error()))
@@ -97,7 +123,7 @@ func (c *Core) UnsubscribeListsByQuery(searchStr, queryExp string, sourceListIDs
 		sourceListIDs = []int{}
 	}
 
-	err := c.q.ExecSubQueryTpl(searchStr, queryExp, c.q.UnsubscribeSubscribersFromListsByQuery, sourceListIDs, c.db, subStatus, pq.Array(targetListIDs))
+	err := c.q.ExecSubQueryTpl(searchStr, queryExp, c.q.UnsubscribeSubscribersFromListsByQuery, sourceListIDs, c.db, subStatus, c.queryTimeout(queryExp), pq.Array(targetListIDs))
 	if err != nil {
 		c.log.Printf("error unsubscribing from lists by query: %v", err)
 		return echo.NewHTTPError(http.StatusInternalServerError,
@@ -110,7 +136,7 @@ func (c *Core) UnsubscribeListsByQuery(searchStr, queryExp string, sourceListIDs
 // DeleteUnconfirmedSubscriptions sets list subscriptions to 'unsubscribed' by a given arbitrary query expression.
 // sourceListIDs is the list of list IDs to filter the subscriber query with.
 func (c *Core) DeleteUnconfirmedSubscriptions(beforeDate time.Time) (int, error) {
-	res, err := c.q.DeleteUnconfirmedSubscriptions.Exec(beforeDate)
+	res, err := c.q.DeleteUnconfirmedSubscriptions.ExecContext(c.ctx, beforeDate)
 	if err != nil {
 		c.log.Printf("error deleting unconfirmed subscribers: %v", err)
 		return 0, echo.NewHTTPError(http.StatusInternalServerError,
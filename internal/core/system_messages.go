@@ -0,0 +1,103 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
+)
+
+const (
+	// Number of attempts at sending a system e-mail, including the first one,
+	// after which it's marked as failed.
+	maxSystemMessageAttempts = 5
+
+	// Delay before the first retry of a system e-mail. It doubles on every
+	// subsequent attempt.
+	systemMessageBackoff = time.Minute
+
+	// Duration for which a claimed system e-mail isn't picked up again in case
+	// the attempt at sending it is never recorded, eg: on a crash.
+	systemMessageClaimTTL = time.Minute * 10
+)
+
+// QueueSystemMessage queues a system e-mail that failed to send for retries.
+func (c *Core) QueueSystemMessage(m models.Message, sendErr error) error {
+	hdr, err := json.Marshal(m.Headers)
+	if err != nil || m.Headers == nil {
+		hdr = []byte("{}")
+	}
+
+	var id int
+	if err := c.q.QueueSystemMessage.GetContext(c.ctx, &id, m.From, pq.Array(m.To), m.Subject, m.ContentType,
+		string(m.Body), string(hdr), 1, sendErr.Error(), systemMessageBackoff.Seconds()); err != nil {
+		c.log.Printf("error queueing system e-mail (%s) for retries: %v", m.Subject, err)
+		return err
+	}
+
+	return nil
+}
+
+// NextSystemMessages claims up to limit queued system e-mails that are due for a retry.
+func (c *Core) NextSystemMessages(limit int) ([]models.SystemMessage, error) {
+	out := []models.SystemMessage{}
+	if err := c.q.NextSystemMessages.SelectContext(c.ctx, &out, limit, systemMessageClaimTTL.Seconds()); err != nil {
+		c.log.Printf("error fetching queued system e-mails: %v", err)
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// RecordSystemMessageAttempt records an attempt at sending a queued system e-mail.
+// Sent e-mails are removed from the queue and the ones that fail all their
+// attempts are marked as failed.
+func (c *Core) RecordSystemMessageAttempt(id int, sendErr error) error {
+	if sendErr == nil {
+		if _, err := c.q.DeleteSystemMessage.ExecContext(c.ctx, id); err != nil {
+			c.log.Printf("error deleting sent system e-mail %d: %v", id, err)
+			return err
+		}
+		return nil
+	}
+
+	if _, err := c.q.RecordSystemMessageAttempt.ExecContext(c.ctx, id, sendErr.Error(),
+		maxSystemMessageAttempts, systemMessageBackoff.Seconds()); err != nil {
+		c.log.Printf("error recording attempt of system e-mail %d: %v", id, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetFailedSystemMessages returns the latest system e-mails that failed all their attempts.
+func (c *Core) GetFailedSystemMessages(limit int) ([]models.SystemMessage, error) {
+	out := []models.SystemMessage{}
+	if err := c.q.GetFailedSystemMessages.SelectContext(c.ctx, &out, limit); err != nil {
+		c.log.Printf("error fetching failed system e-mails: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.systemMessage}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// RetrySystemMessage queues a failed system e-mail for retries again.
+func (c *Core) RetrySystemMessage(id int) error {
+	res, err := c.q.RetrySystemMessage.ExecContext(c.ctx, id)
+	if err != nil {
+		c.log.Printf("error retrying system e-mail %d: %v", id, err)
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.systemMessage}", "error", pqErrMsg(err)))
+	}
+
+	if n, _ := res.RowsAffected(); n == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.systemMessage}"))
+	}
+
+	return nil
+}
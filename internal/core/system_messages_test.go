@@ -0,0 +1,144 @@
+package core
+
+import (
+	"errors"
+	"net/http"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+)
+
+func TestSystemMessageRetries(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	errSend := errors.New("421 try again later")
+	queue := func(to string) {
+		t.Helper()
+
+		hdr := textproto.MIMEHeader{}
+		hdr.Set(models.EmailHeaderSubscriberUUID, "sub-uuid")
+		if err := c.QueueSystemMessage(models.Message{From: "from@example.com", To: []string{to}, Subject: "Confirm",
+			ContentType: "html", Body: []byte("<p>Confirm</p>"), Headers: hdr}, errSend); err != nil {
+			t.Fatal(err)
+		}
+	}
+	due := func() {
+		t.Helper()
+		if _, err := db.Exec(`UPDATE system_messages SET next_attempt_at = NOW() - INTERVAL '1 second' WHERE status = 'pending'`); err != nil {
+			t.Fatal(err)
+		}
+	}
+	next := func() []models.SystemMessage {
+		t.Helper()
+		out, err := c.NextSystemMessages(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	get := func(id int) (models.SystemMessage, bool) {
+		t.Helper()
+		var out []models.SystemMessage
+		if err := db.Select(&out, `SELECT * FROM system_messages WHERE id = $1`, id); err != nil {
+			t.Fatal(err)
+		}
+		if len(out) == 0 {
+			return models.SystemMessage{}, false
+		}
+		return out[0], true
+	}
+
+	// A failed message is queued for a retry after the backoff.
+	queue("sub@example.com")
+	if msgs := next(); len(msgs) != 0 {
+		t.Fatalf("expected no due messages, got %+v", msgs)
+	}
+	due()
+	msgs := next()
+	if len(msgs) != 1 {
+		t.Fatalf("expected a due message, got %+v", msgs)
+	}
+	m := msgs[0]
+	if m.Attempts != 1 || m.LastError != errSend.Error() || m.ToEmails[0] != "sub@example.com" || m.Body != "<p>Confirm</p>" ||
+		string(m.Headers) != `{"X-Listmonk-Subscriber": ["sub-uuid"]}` {
+		t.Errorf("unexpected message %+v %s", m, m.Headers)
+	}
+
+	// Claimed messages aren't picked up again.
+	if msgs := next(); len(msgs) != 0 {
+		t.Fatalf("expected the claimed message not to be picked up, got %+v", msgs)
+	}
+
+	// Two failures with an increasing backoff and then a success.
+	for n := range 2 {
+		if err := c.RecordSystemMessageAttempt(m.ID, errSend); err != nil {
+			t.Fatal(err)
+		}
+		got, _ := get(m.ID)
+		backoff := systemMessageBackoff * time.Duration(1<<(n+1))
+		if got.Status != models.SystemMessagePending || got.Attempts != n+2 ||
+			time.Until(got.NextAttemptAt) < backoff-time.Minute || time.Until(got.NextAttemptAt) > backoff {
+			t.Fatalf("attempt %d: unexpected message %+v", n, got)
+		}
+
+		due()
+		if msgs := next(); len(msgs) != 1 || msgs[0].ID != m.ID {
+			t.Fatalf("attempt %d: expected the message to be retried, got %+v", n, msgs)
+		}
+	}
+	if err := c.RecordSystemMessageAttempt(m.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := get(m.ID); ok {
+		t.Fatal("expected the sent message to be removed")
+	}
+
+	// Messages that run out of attempts fail and aren't retried.
+	queue("dead@example.com")
+	due()
+	m = next()[0]
+	for range maxSystemMessageAttempts - 1 {
+		if err := c.RecordSystemMessageAttempt(m.ID, errSend); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, _ := get(m.ID); got.Status != models.SystemMessageFailed || got.Attempts != maxSystemMessageAttempts {
+		t.Fatalf("expected the message to fail, got %+v", got)
+	}
+	if _, err := db.Exec(`UPDATE system_messages SET next_attempt_at = NOW() - INTERVAL '1 second'`); err != nil {
+		t.Fatal(err)
+	}
+	if msgs := next(); len(msgs) != 0 {
+		t.Fatalf("expected the failed message not to be retried, got %+v", msgs)
+	}
+
+	failed, err := c.GetFailedSystemMessages(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].ID != m.ID || failed[0].ToEmails[0] != "dead@example.com" {
+		t.Fatalf("unexpected failed messages %+v", failed)
+	}
+
+	// A failed message can be retried with a fresh set of attempts.
+	if err := c.RetrySystemMessage(m.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := get(m.ID); got.Status != models.SystemMessagePending || got.Attempts != 0 {
+		t.Fatalf("expected the message to be pending, got %+v", got)
+	}
+	if msgs := next(); len(msgs) != 1 || msgs[0].ID != m.ID {
+		t.Fatalf("expected the message to be retried, got %+v", msgs)
+	}
+
+	// Only failed messages can be retried.
+	for _, id := range []int{m.ID, 999999} {
+		var he *echo.HTTPError
+		if err := c.RetrySystemMessage(id); !errors.As(err, &he) || he.Code != http.StatusBadRequest {
+			t.Errorf("%d: expected a bad request, got %v", id, err)
+		}
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"database/sql"
 	"net/http"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/knadh/listmonk/models"
 	"github.com/labstack/echo/v4"
+	"github.com/lib/pq"
 	null "gopkg.in/volatiletech/null.v6"
 )
 
-// GetTemplates retrieves all templates.
-func (c *Core) GetTemplates(status string, noBody bool) ([]models.Template, error) {
+// GetTemplates retrieves all templates optionally filtered by tags.
+// If tagMatchAny is true, templates having any of the given tags are matched instead of all of them.
+func (c *Core) GetTemplates(status string, tags []string, tagMatchAny, noBody bool) ([]models.Template, error) {
 	out := []models.Template{}
-	if err := c.q.GetTemplates.Select(&out, 0, noBody, status); err != nil {
+	if err := c.q.GetTemplates.SelectContext(c.ctx, &out, 0, noBody, status, pq.StringArray(normalizeTags(tags)), tagMatchAny); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.templates}", "error", pqErrMsg(err)))
 	}
@@ -23,7 +26,7 @@ func (c *Core) GetTemplates(status string, noBody bool) ([]models.Template, erro
 // GetTemplate retrieves a given template.
 func (c *Core) GetTemplate(id int, noBody bool) (models.Template, error) {
 	var out []models.Template
-	if err := c.q.GetTemplates.Select(&out, id, noBody, ""); err != nil {
+	if err := c.q.GetTemplates.SelectContext(c.ctx, &out, id, noBody, "", pq.StringArray{}, false); err != nil {
 		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.templates}", "error", pqErrMsg(err)))
 	}
@@ -37,9 +40,27 @@ func (c *Core) GetTemplate(id int, noBody bool) (models.Template, error) {
 }
 
 // CreateTemplate creates a new template.
-func (c *Core) CreateTemplate(name, typ, subject string, body []byte, bodySource null.String) (models.Template, error) {
+func (c *Core) CreateTemplate(name, typ, subject string, body []byte, bodySource null.String, tags []string, assets models.TemplateAssets, blockStyles models.BlockStyles) (models.Template, error) {
+	tx, err := c.db.BeginTxx(c.ctx, nil)
+	if err != nil {
+		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
 	var newID int
-	if err := c.q.CreateTemplate.Get(&newID, name, typ, subject, body, bodySource); err != nil {
+	if err := tx.StmtxContext(c.ctx, c.q.CreateTemplate).GetContext(c.ctx, &newID, name, typ, subject, body, bodySource, pq.StringArray(normalizeTags(tags)), blockStyles); err != nil {
+		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
+	}
+
+	if assets != nil {
+		if err := c.setTemplateAssets(tx, newID, assets); err != nil {
+			return models.Template{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
 		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
 	}
@@ -47,9 +68,20 @@ func (c *Core) CreateTemplate(name, typ, subject string, body []byte, bodySource
 	return c.GetTemplate(newID, false)
 }
 
-// UpdateTemplate updates a given template.
-func (c *Core) UpdateTemplate(id int, name, subject string, body []byte, bodySource null.String) (models.Template, error) {
-	res, err := c.q.UpdateTemplate.Exec(id, name, subject, body, bodySource)
+// UpdateTemplate updates a given template. If tags, assets, or block styles are nil, the existing ones are retained.
+func (c *Core) UpdateTemplate(id int, name, subject string, body []byte, bodySource null.String, tags []string, assets models.TemplateAssets, blockStyles models.BlockStyles) (models.Template, error) {
+	if tags != nil {
+		tags = normalizeTags(tags)
+	}
+
+	tx, err := c.db.BeginTxx(c.ctx, nil)
+	if err != nil {
+		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
+	}
+	defer tx.Rollback()
+
+	res, err := tx.StmtxContext(c.ctx, c.q.UpdateTemplate).ExecContext(c.ctx, id, name, subject, body, bodySource, pq.StringArray(tags), blockStyles)
 	if err != nil {
 		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
@@ -60,12 +92,77 @@ func (c *Core) UpdateTemplate(id int, name, subject string, body []byte, bodySou
 			c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.template}"))
 	}
 
+	if assets != nil {
+		if err := c.setTemplateAssets(tx, id, assets); err != nil {
+			return models.Template{}, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Template{}, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
+	}
+
 	return c.GetTemplate(id, false)
 }
 
+// GetTemplateAssets retrieves the assets of a template as a map of asset names to media filenames.
+func (c *Core) GetTemplateAssets(id int) (map[string]string, error) {
+	var res []struct {
+		Name     string `db:"name"`
+		Filename string `db:"filename"`
+	}
+	if err := c.q.GetTemplateAssets.SelectContext(c.ctx, &res, id); err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
+	}
+
+	out := make(map[string]string, len(res))
+	for _, r := range res {
+		out[r.Name] = r.Filename
+	}
+
+	return out, nil
+}
+
+// GetMediaTemplates retrieves the names of the templates that use a media item as an asset.
+func (c *Core) GetMediaTemplates(mediaID int) ([]string, error) {
+	out := []string{}
+	if err := c.q.GetMediaTemplates.SelectContext(c.ctx, &out, mediaID); err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.templates}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
+
+// setTemplateAssets replaces the assets of a template.
+func (c *Core) setTemplateAssets(tx *sqlx.Tx, id int, assets models.TemplateAssets) error {
+	var (
+		names    = make(pq.StringArray, 0, len(assets))
+		mediaIDs = make(pq.Int64Array, 0, len(assets))
+	)
+	for _, a := range assets {
+		names = append(names, a.Name)
+		mediaIDs = append(mediaIDs, int64(a.MediaID))
+	}
+
+	if _, err := tx.StmtxContext(c.ctx, c.q.SetTemplateAssets).ExecContext(c.ctx, id, names, mediaIDs); err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Constraint == "template_media_media_id_fkey" {
+			return echo.NewHTTPError(http.StatusBadRequest,
+				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.media}"))
+		}
+
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
+	}
+
+	return nil
+}
+
 // SetDefaultTemplate sets a template as default.
 func (c *Core) SetDefaultTemplate(id int) error {
-	if _, err := c.q.SetDefaultTemplate.Exec(id); err != nil {
+	if _, err := c.q.SetDefaultTemplate.ExecContext(c.ctx, id); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
 	}
@@ -76,7 +173,7 @@ func (c *Core) SetDefaultTemplate(id int) error {
 // DeleteTemplate deletes a given template.
 func (c *Core) DeleteTemplate(id int) error {
 	var delID int
-	if err := c.q.DeleteTemplate.Get(&delID, id); err != nil && err != sql.ErrNoRows {
+	if err := c.q.DeleteTemplate.GetContext(c.ctx, &delID, id); err != nil && err != sql.ErrNoRows {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.template}", "error", pqErrMsg(err)))
 	}
@@ -86,3 +183,15 @@ func (c *Core) DeleteTemplate(id int) error {
 
 	return nil
 }
+
+// GetTags retrieves all distinct campaign and template tags with their usage counts.
+// typ optionally filters by the source, campaign or template.
+func (c *Core) GetTags(typ string) ([]models.Tag, error) {
+	out := []models.Tag{}
+	if err := c.q.GetTags.SelectContext(c.ctx, &out, typ); err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError,
+			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.tags}", "error", pqErrMsg(err)))
+	}
+
+	return out, nil
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/labstack/echo/v4"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+func TestTemplateTags(t *testing.T) {
+	c, _ := newTestCore(t, Constants{})
+
+	// Tags are normalized on saving.
+	for _, tpl := range []struct {
+		name string
+		tags []string
+	}{
+		{"promo-x", []string{" Promo ", "PROMO", "Brand X"}},
+		{"promo-y", []string{"promo", "brand-y"}},
+		{"newsletter", []string{"newsletter"}},
+		{"untagged", nil},
+	} {
+		out, err := c.CreateTemplate(tpl.name, models.TemplateTypeCampaign, "", []byte(`{{ template "content" . }}`), null.String{}, tpl.tags, nil, models.BlockStyles{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tpl.name == "promo-x" && !slices.Equal(out.Tags, []string{"promo", "brand-x"}) {
+			t.Errorf("expected normalized tags, got %q", out.Tags)
+		}
+	}
+
+	cases := []struct {
+		name  string
+		tags  []string
+		any   bool
+		names []string
+	}{
+		{"no filter", nil, false, []string{"promo-x", "promo-y", "newsletter", "untagged"}},
+		{"all of the tags", []string{"promo", "brand-x"}, false, []string{"promo-x"}},
+		{"any of the tags", []string{"brand-x", "newsletter"}, true, []string{"promo-x", "newsletter"}},
+		{"normalized filter", []string{"BRAND X"}, false, []string{"promo-x"}},
+		{"no match", []string{"brand-x", "brand-y"}, false, nil},
+	}
+	for _, tc := range cases {
+		out, err := c.GetTemplates("", tc.tags, tc.any, true)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+
+		var names []string
+		for _, tpl := range out {
+			names = append(names, tpl.Name)
+		}
+		if !slices.Equal(names, tc.names) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.names, names)
+		}
+	}
+
+	// Tag counts across templates.
+	tags, err := c.GetTags("template")
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]int{}
+	for _, tg := range tags {
+		counts[tg.Tag] = tg.Count
+	}
+	if counts["promo"] != 2 || counts["brand-x"] != 1 || counts["newsletter"] != 1 || len(counts) != 4 {
+		t.Errorf("unexpected tag counts %v", counts)
+	}
+}
+
+func TestTemplateAssets(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	var logo, banner int
+	for _, m := range []struct {
+		id   *int
+		name string
+	}{{&logo, "logo.png"}, {&banner, "banner.png"}} {
+		if err := db.Get(m.id, `INSERT INTO media (uuid, provider, filename, thumb) VALUES (gen_random_uuid(), 'filesystem', $1, $1) RETURNING id`, m.name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	body := []byte(`<img src="{{ Asset "logo" }}" /> {{ template "content" . }}`)
+	tpl, err := c.CreateTemplate("branded", models.TemplateTypeCampaign, "", body, null.String{}, nil,
+		models.TemplateAssets{{Name: "logo", MediaID: logo}}, models.BlockStyles{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assets := func(exp map[string]string) {
+		t.Helper()
+		out, err := c.GetTemplateAssets(tpl.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(out, exp) {
+			t.Errorf("expected assets %v, got %v", exp, out)
+		}
+	}
+	assets(map[string]string{"logo": "logo.png"})
+
+	// Nil assets are retained on update.
+	if _, err := c.UpdateTemplate(tpl.ID, "branded", "", body, null.String{}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	assets(map[string]string{"logo": "logo.png"})
+
+	// Replacing the media of an asset changes its file without changing the body.
+	if _, err := c.UpdateTemplate(tpl.ID, "branded", "", body, null.String{}, nil,
+		models.TemplateAssets{{Name: "logo", MediaID: banner}, {Name: "old-logo", MediaID: logo}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	assets(map[string]string{"logo": "banner.png", "old-logo": "logo.png"})
+
+	// Media in use by templates is listed and can't be deleted.
+	if names, err := c.GetMediaTemplates(logo); err != nil || !slices.Equal(names, []string{"branded"}) {
+		t.Errorf("expected the template using the media, got %v: %v", names, err)
+	}
+	if _, err := db.Exec(`DELETE FROM media WHERE id = $1`, logo); err == nil {
+		t.Error("expected media in use by a template to not be deletable")
+	}
+
+	// Unknown media is rejected and the existing assets are untouched.
+	_, err = c.UpdateTemplate(tpl.ID, "branded", "", body, null.String{}, nil, models.TemplateAssets{{Name: "logo", MediaID: 99999}}, nil)
+	var he *echo.HTTPError
+	if !errors.As(err, &he) || he.Code != http.StatusBadRequest {
+		t.Errorf("expected a bad request for unknown media, got %v", err)
+	}
+	assets(map[string]string{"logo": "banner.png", "old-logo": "logo.png"})
+
+	// Removing the assets frees the media.
+	if _, err := c.UpdateTemplate(tpl.ID, "branded", "", body, null.String{}, nil, models.TemplateAssets{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	assets(map[string]string{})
+	if names, err := c.GetMediaTemplates(logo); err != nil || len(names) != 0 {
+		t.Errorf("expected no templates using the media, got %v: %v", names, err)
+	}
+	if _, err := db.Exec(`DELETE FROM media WHERE id = $1`, logo); err != nil {
+		t.Errorf("expected unused media to be deletable, got %v", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/knadh/listmonk/internal/auth"
 	"github.com/knadh/listmonk/internal/utils"
@@ -14,7 +15,7 @@ import (
 
 func (c *Core) GetUsers() ([]auth.User, error) {
 	out := []auth.User{}
-	if err := c.q.GetUsers.Select(&out); err != nil {
+	if err := c.q.GetUsers.SelectContext(c.ctx, &out); err != nil {
 		return nil, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorFetching", "name", "{globals.terms.users}", "error", pqErrMsg(err)))
 	}
@@ -25,7 +26,7 @@ func (c *Core) GetUsers() ([]auth.User, error) {
 // GetUser retrieves a specific user based on any one given identifier.
 func (c *Core) GetUser(id int, username, email string) (auth.User, error) {
 	var out auth.User
-	if err := c.q.GetUser.Get(&out, id, username, email); err != nil {
+	if err := c.q.GetUser.GetContext(c.ctx, &out, id, username, email); err != nil {
 		if err == sql.ErrNoRows {
 			return out, echo.NewHTTPError(http.StatusNotFound,
 				c.i18n.Ts("globals.messages.notFound", "name", "{globals.terms.user}"))
@@ -57,7 +58,7 @@ func (c *Core) CreateUser(u auth.User) (auth.User, error) {
 		u.Password = null.String{String: tk, Valid: true}
 	}
 
-	if err := c.q.CreateUser.Get(&id, u.Username, u.PasswordLogin, u.Password, u.Email, u.Name, u.Type, u.UserRoleID, u.ListRoleID, u.Status); err != nil {
+	if err := c.q.CreateUser.GetContext(c.ctx, &id, u.Username, u.PasswordLogin, u.Password, u.Email, u.Name, u.Type, u.UserRoleID, u.ListRoleID, u.Status, u.Language); err != nil {
 		return auth.User{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorCreating", "name", "{globals.terms.user}", "error", pqErrMsg(err)))
 	}
@@ -81,7 +82,7 @@ func (c *Core) UpdateUser(id int, u auth.User) (auth.User, error) {
 		listRoleID = *u.ListRoleID
 	}
 
-	res, err := c.q.UpdateUser.Exec(id, u.Username, u.PasswordLogin, u.Password, u.Email, u.Name, u.Type, u.UserRoleID, listRoleID, u.Status)
+	res, err := c.q.UpdateUser.ExecContext(c.ctx, id, u.Username, u.PasswordLogin, u.Password, u.Email, u.Name, u.Type, u.UserRoleID, listRoleID, u.Status, u.Language)
 	if err != nil {
 		return auth.User{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.user}", "error", pqErrMsg(err)))
@@ -98,7 +99,7 @@ func (c *Core) UpdateUser(id int, u auth.User) (auth.User, error) {
 
 // UpdateUserProfile updates the basic fields of a given uesr (name, email, password).
 func (c *Core) UpdateUserProfile(id int, u auth.User) (auth.User, error) {
-	res, err := c.q.UpdateUserProfile.Exec(id, u.Name, u.Email, u.PasswordLogin, u.Password)
+	res, err := c.q.UpdateUserProfile.ExecContext(c.ctx, id, u.Name, u.Email, u.PasswordLogin, u.Password, u.Language)
 	if err != nil {
 		return auth.User{}, echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.user}", "error", pqErrMsg(err)))
@@ -112,9 +113,35 @@ func (c *Core) UpdateUserProfile(id int, u auth.User) (auth.User, error) {
 	return c.GetUser(id, "", "")
 }
 
+// GetUserLangs returns the preferred notification languages of the users with
+// the given e-mails as a map of lowercased e-mail => language. Users without a
+// preference are not in the map.
+func (c *Core) GetUserLangs(emails []string) (map[string]string, error) {
+	lower := make([]string, len(emails))
+	for n, e := range emails {
+		lower[n] = strings.ToLower(e)
+	}
+
+	var res []struct {
+		Email    string `db:"email"`
+		Language string `db:"language"`
+	}
+	if err := c.q.GetUserLangs.SelectContext(c.ctx, &res, pq.StringArray(lower)); err != nil {
+		c.log.Printf("error fetching user languages: %v", err)
+		return nil, err
+	}
+
+	out := make(map[string]string, len(res))
+	for _, r := range res {
+		out[r.Email] = r.Language
+	}
+
+	return out, nil
+}
+
 // UpdateUserLogin updates a user's record post-login.
 func (c *Core) UpdateUserLogin(id int, avatar string) error {
-	if _, err := c.q.UpdateUserLogin.Exec(id, avatar); err != nil {
+	if _, err := c.q.UpdateUserLogin.ExecContext(c.ctx, id, avatar); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.user}", "error", pqErrMsg(err)))
 	}
@@ -124,7 +151,7 @@ func (c *Core) UpdateUserLogin(id int, avatar string) error {
 
 // SetTwoFA sets or clears the 2FA configuration for a user.
 func (c *Core) SetTwoFA(id int, twofaType, twofaKey string) error {
-	if _, err := c.q.SetUserTwoFA.Exec(id, twofaType, twofaKey); err != nil {
+	if _, err := c.q.SetUserTwoFA.ExecContext(c.ctx, id, twofaType, twofaKey); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorUpdating", "name", "{globals.terms.user}", "error", pqErrMsg(err)))
 	}
@@ -134,7 +161,7 @@ func (c *Core) SetTwoFA(id int, twofaType, twofaKey string) error {
 
 // DeleteUsers deletes a given user.
 func (c *Core) DeleteUsers(ids []int) error {
-	res, err := c.q.DeleteUsers.Exec(pq.Array(ids))
+	res, err := c.q.DeleteUsers.ExecContext(c.ctx, pq.Array(ids))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			c.i18n.Ts("globals.messages.errorDeleting", "name", "{globals.terms.user}", "error", pqErrMsg(err)))
@@ -149,7 +176,7 @@ func (c *Core) DeleteUsers(ids []int) error {
 // LoginUser attempts to log the given user_id in by matching the password.
 func (c *Core) LoginUser(username, password string) (auth.User, error) {
 	var out auth.User
-	if err := c.q.LoginUser.Get(&out, username, password); err != nil {
+	if err := c.q.LoginUser.GetContext(c.ctx, &out, username, password); err != nil {
 		if err == sql.ErrNoRows {
 			return out, echo.NewHTTPError(http.StatusForbidden, c.i18n.T("users.invalidLogin"))
 		}
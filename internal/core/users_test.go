@@ -0,0 +1,30 @@
+package core
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestGetUserLangs(t *testing.T) {
+	c, db := newTestCore(t, Constants{})
+
+	var roleID int
+	if err := db.Get(&roleID, `INSERT INTO roles (type, name) VALUES ('user', 'admin') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO users (username, password_login, email, name, type, user_role_id, status, language) VALUES
+		('fr', false, 'FR@example.com', 'FR', 'user', $1, 'enabled', 'fr'),
+		('en', false, 'en@example.com', 'EN', 'user', $1, 'enabled', ''),
+		('de', false, 'de@example.com', 'DE', 'user', $1, 'disabled', 'de')`, roleID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only enabled users with a preference are returned, matched case insensitively.
+	out, err := c.GetUserLangs([]string{"fr@Example.com", "en@example.com", "de@example.com", "other@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := map[string]string{"fr@example.com": "fr"}; !maps.Equal(out, exp) {
+		t.Errorf("expected %v, got %v", exp, out)
+	}
+}
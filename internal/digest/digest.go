@@ -0,0 +1,168 @@
+// Package digest builds and sends the periodic activity digest report, eg: a
+// Monday morning e-mail to admins with the last week's campaigns, engagement,
+// and subscriber growth. The report covers whole days ending at the start of
+// the day of the run so that the periods of consecutive runs are contiguous.
+package digest
+
+import (
+	"errors"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// Number of top campaigns in the report.
+const numTopCampaigns = 5
+
+// Opt represents the digest's options.
+type Opt struct {
+	models.DigestSettings
+
+	// Recipients when DigestSettings.Recipients is empty.
+	DefaultRecipients []string
+}
+
+// Store is the source of the report data and the record of runs.
+type Store interface {
+	GetDigestStats(from, to time.Time, topCampaigns int) (models.DigestStats, error)
+	RecordDigestRun(r models.DigestRun) error
+}
+
+// Notifier sends out a rendered report to the given e-mails.
+type Notifier func(toEmails []string, r Report) error
+
+// Report is the data of a digest report.
+type Report struct {
+	From time.Time
+	To   time.Time
+
+	// Enabled sections. The keys are models.DigestSection*.
+	Sections map[string]bool
+
+	Stats models.DigestStats
+
+	// Percentages of the delivered (sent minus bounced) messages, and
+	// bounces as a percentage of the sent messages.
+	OpenRate   float64
+	ClickRate  float64
+	BounceRate float64
+}
+
+// Digest builds and sends the report.
+type Digest struct {
+	opt    Opt
+	store  Store
+	notify Notifier
+	log    *log.Logger
+
+	// Prevents a scheduled run and a manual run from overlapping.
+	mut sync.Mutex
+}
+
+var errNoRecipients = errors.New("no recipients")
+
+// New returns a new Digest.
+func New(opt Opt, st Store, notify Notifier, lo *log.Logger) *Digest {
+	return &Digest{
+		opt:    opt,
+		store:  st,
+		notify: notify,
+		log:    lo,
+	}
+}
+
+// Run builds the report for the period ending at the start of the day of now,
+// sends it, and records the run. Failures are logged and recorded.
+func (d *Digest) Run(now time.Time) (models.DigestRun, error) {
+	d.mut.Lock()
+	defer d.mut.Unlock()
+
+	from, to := Period(now, d.opt.PeriodDays)
+	run := models.DigestRun{
+		PeriodStart: from,
+		PeriodEnd:   to,
+		Status:      models.DigestRunSent,
+	}
+
+	err := d.send(from, to, &run)
+	if err != nil {
+		d.log.Printf("error sending digest report for %s - %s: %v", from.Format(time.DateOnly), to.Format(time.DateOnly), err)
+		run.Status = models.DigestRunFailed
+		run.Error = err.Error()
+	} else {
+		d.log.Printf("sent digest report for %s - %s to %d recipient(s)", from.Format(time.DateOnly), to.Format(time.DateOnly), run.Recipients)
+	}
+
+	// Errors are logged by the store.
+	_ = d.store.RecordDigestRun(run)
+
+	return run, err
+}
+
+func (d *Digest) send(from, to time.Time, run *models.DigestRun) error {
+	rcpt := d.opt.Recipients
+	if len(rcpt) == 0 {
+		rcpt = d.opt.DefaultRecipients
+	}
+	if len(rcpt) == 0 {
+		return errNoRecipients
+	}
+
+	stats, err := d.store.GetDigestStats(from, to, numTopCampaigns)
+	if err != nil {
+		return err
+	}
+
+	if err := d.notify(rcpt, MakeReport(from, to, d.opt.Sections, stats)); err != nil {
+		return err
+	}
+	run.Recipients = len(rcpt)
+
+	return nil
+}
+
+// Period returns the period [from, to) of a report run at now: the given number
+// of whole days before the start of the day of now, in now's location.
+func Period(now time.Time, days int) (time.Time, time.Time) {
+	if days < 1 {
+		days = 1
+	}
+
+	y, m, d := now.Date()
+	to := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+
+	// AddDate keeps the calendar days intact across DST changes.
+	return to.AddDate(0, 0, -days), to
+}
+
+// MakeReport computes the rates from the stats and returns the report.
+func MakeReport(from, to time.Time, sections []string, s models.DigestStats) Report {
+	r := Report{
+		From:     from,
+		To:       to,
+		Sections: make(map[string]bool, len(sections)),
+		Stats:    s,
+	}
+	for _, sec := range sections {
+		if slices.Contains(models.DigestSections, sec) {
+			r.Sections[sec] = true
+		}
+	}
+
+	if delivered := s.Messages - s.Bounces; delivered > 0 {
+		r.OpenRate = percent(s.Views, delivered)
+		r.ClickRate = percent(s.Clicks, delivered)
+	}
+	if s.Messages > 0 {
+		r.BounceRate = percent(s.Bounces, s.Messages)
+	}
+
+	return r
+}
+
+func percent(n, total int) float64 {
+	return float64(n) / float64(total) * 100
+}
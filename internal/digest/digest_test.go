@@ -0,0 +1,197 @@
+package digest
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math"
+	"slices"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// testStore records the requested periods and the runs.
+type testStore struct {
+	stats   models.DigestStats
+	err     error
+	periods [][2]time.Time
+	runs    []models.DigestRun
+}
+
+func (s *testStore) GetDigestStats(from, to time.Time, topCampaigns int) (models.DigestStats, error) {
+	s.periods = append(s.periods, [2]time.Time{from, to})
+	return s.stats, s.err
+}
+
+func (s *testStore) RecordDigestRun(r models.DigestRun) error {
+	s.runs = append(s.runs, r)
+	return nil
+}
+
+func TestPeriod(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name     string
+		now      time.Time
+		days     int
+		from, to time.Time
+	}{
+		{
+			"monday morning",
+			time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC), 7,
+			time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"at midnight",
+			time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC), 1,
+			time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"just before midnight",
+			time.Date(2026, 3, 9, 23, 59, 59, 999999999, time.UTC), 1,
+			time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"invalid days default to one",
+			time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC), 0,
+			time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"across a year",
+			time.Date(2026, 1, 2, 8, 0, 0, 0, time.UTC), 7,
+			time.Date(2025, 12, 26, 0, 0, 0, 0, time.UTC), time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"leap day",
+			time.Date(2028, 3, 1, 8, 0, 0, 0, time.UTC), 1,
+			time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC), time.Date(2028, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			// The week with the DST change is 167 hours long.
+			"across dst",
+			time.Date(2026, 3, 30, 8, 0, 0, 0, berlin), 7,
+			time.Date(2026, 3, 23, 0, 0, 0, 0, berlin), time.Date(2026, 3, 30, 0, 0, 0, 0, berlin),
+		},
+	}
+	for _, c := range cases {
+		from, to := Period(c.now, c.days)
+		if !from.Equal(c.from) || !to.Equal(c.to) {
+			t.Errorf("%s: expected %v - %v, got %v - %v", c.name, c.from, c.to, from, to)
+		}
+	}
+
+	// The periods of consecutive runs are contiguous.
+	var (
+		now     = time.Date(2026, 3, 2, 8, 0, 0, 0, berlin)
+		_, prev = Period(now, 7)
+	)
+	for range 10 {
+		now = now.AddDate(0, 0, 7)
+		from, to := Period(now, 7)
+		if !from.Equal(prev) {
+			t.Fatalf("expected the period to start at %v, got %v", prev, from)
+		}
+		prev = to
+	}
+}
+
+func TestRun(t *testing.T) {
+	var (
+		now   = time.Date(2026, 3, 9, 8, 0, 0, 0, time.UTC)
+		from  = time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC)
+		to    = time.Date(2026, 3, 9, 0, 0, 0, 0, time.UTC)
+		stats = models.DigestStats{Campaigns: 2, Messages: 100, Views: 45, Clicks: 9, Bounces: 10}
+	)
+
+	cases := []struct {
+		name      string
+		rcpt      []string
+		def       []string
+		storeErr  error
+		notifyErr error
+		expRcpt   []string
+		status    string
+		err       string
+	}{
+		{"recipients", []string{"a@example.com", "b@example.com"}, []string{"admin@example.com"}, nil, nil,
+			[]string{"a@example.com", "b@example.com"}, models.DigestRunSent, ""},
+		{"default recipients", nil, []string{"admin@example.com"}, nil, nil,
+			[]string{"admin@example.com"}, models.DigestRunSent, ""},
+		{"no recipients", nil, nil, nil, nil, nil, models.DigestRunFailed, errNoRecipients.Error()},
+		{"store error", nil, []string{"admin@example.com"}, errors.New("db error"), nil, nil, models.DigestRunFailed, "db error"},
+		{"notify error", nil, []string{"admin@example.com"}, nil, errors.New("smtp error"),
+			[]string{"admin@example.com"}, models.DigestRunFailed, "smtp error"},
+	}
+	for _, c := range cases {
+		var (
+			st   = &testStore{stats: stats, err: c.storeErr}
+			sent []string
+			rep  Report
+		)
+		d := New(Opt{
+			DigestSettings:    models.DigestSettings{PeriodDays: 7, Recipients: c.rcpt, Sections: []string{models.DigestSectionEngagement, "nope"}},
+			DefaultRecipients: c.def,
+		}, st, func(to []string, r Report) error {
+			sent, rep = to, r
+			return c.notifyErr
+		}, log.New(io.Discard, "", 0))
+
+		run, err := d.Run(now)
+		if (err != nil) != (c.err != "") || (err != nil && err.Error() != c.err) {
+			t.Errorf("%s: expected error %q, got %v", c.name, c.err, err)
+		}
+		if !slices.Equal(sent, c.expRcpt) {
+			t.Errorf("%s: expected the recipients %v, got %v", c.name, c.expRcpt, sent)
+		}
+
+		// Every run is recorded with its period and status.
+		if len(st.runs) != 1 || st.runs[0] != run {
+			t.Fatalf("%s: expected the run to be recorded, got %+v", c.name, st.runs)
+		}
+		if !run.PeriodStart.Equal(from) || !run.PeriodEnd.Equal(to) || run.Status != c.status || run.Error != c.err {
+			t.Errorf("%s: unexpected run %+v", c.name, run)
+		}
+		if c.status == models.DigestRunSent && run.Recipients != len(c.expRcpt) {
+			t.Errorf("%s: expected %d recipients, got %d", c.name, len(c.expRcpt), run.Recipients)
+		}
+		if len(st.periods) > 0 && (!st.periods[0][0].Equal(from) || !st.periods[0][1].Equal(to)) {
+			t.Errorf("%s: unexpected stats period %v", c.name, st.periods[0])
+		}
+
+		if c.status == models.DigestRunSent {
+			if !rep.Sections[models.DigestSectionEngagement] || len(rep.Sections) != 1 {
+				t.Errorf("%s: unexpected sections %v", c.name, rep.Sections)
+			}
+			if !rep.From.Equal(from) || !rep.To.Equal(to) || rep.Stats.Campaigns != 2 {
+				t.Errorf("%s: unexpected report %+v", c.name, rep)
+			}
+		}
+	}
+}
+
+func TestMakeReport(t *testing.T) {
+	cases := []struct {
+		name                string
+		stats               models.DigestStats
+		open, click, bounce float64
+	}{
+		{"rates of delivered", models.DigestStats{Messages: 100, Views: 45, Clicks: 9, Bounces: 10}, 50, 10, 10},
+		{"nothing sent", models.DigestStats{}, 0, 0, 0},
+		{"all bounced", models.DigestStats{Messages: 5, Bounces: 5}, 0, 0, 100},
+	}
+	for _, c := range cases {
+		r := MakeReport(time.Time{}, time.Time{}, nil, c.stats)
+		for _, v := range [][2]float64{{r.OpenRate, c.open}, {r.ClickRate, c.click}, {r.BounceRate, c.bounce}} {
+			if math.Abs(v[0]-v[1]) > 0.001 {
+				t.Errorf("%s: expected %v, got %+v", c.name, v[1], r)
+			}
+		}
+	}
+}
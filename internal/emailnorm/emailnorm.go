@@ -0,0 +1,98 @@
+// Package emailnorm normalizes e-mail addresses into a canonical form so that
+// different spellings of the same mailbox, eg: User@Gmail.com, user@gmail.com,
+// u.ser@gmail.com, and user+news@gmail.com, can be detected as duplicates.
+package emailnorm
+
+import (
+	"strings"
+)
+
+// Policy represents the normalization rules. E-mails are always lowercased.
+type Policy struct {
+	// StripDots removes dots from the local part on providers that ignore them.
+	StripDots bool `json:"strip_dots"`
+
+	// StripPlus removes +tags from the local part on providers that support
+	// sub-addressing.
+	StripPlus bool `json:"strip_plus"`
+}
+
+// dotProviders are domains that ignore dots in the local part.
+var dotProviders = map[string]struct{}{
+	"gmail.com":      {},
+	"googlemail.com": {},
+}
+
+// plusProviders are domains that deliver user+tag@ to user@.
+var plusProviders = map[string]struct{}{
+	"gmail.com":      {},
+	"googlemail.com": {},
+	"outlook.com":    {},
+	"hotmail.com":    {},
+	"live.com":       {},
+	"msn.com":        {},
+	"icloud.com":     {},
+	"me.com":         {},
+	"mac.com":        {},
+	"protonmail.com": {},
+	"proton.me":      {},
+	"pm.me":          {},
+	"fastmail.com":   {},
+	"fastmail.fm":    {},
+	"zoho.com":       {},
+	"yandex.com":     {},
+	"yandex.ru":      {},
+}
+
+// Normalize returns the normalized form of an e-mail as per the policy.
+func Normalize(email string, p Policy) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return email
+	}
+
+	if p.StripPlus {
+		if _, ok := plusProviders[domain]; ok {
+			local, _, _ = strings.Cut(local, "+")
+		}
+	}
+
+	if p.StripDots {
+		if _, ok := dotProviders[domain]; ok {
+			local = strings.ReplaceAll(local, ".", "")
+		}
+	}
+
+	return local + "@" + domain
+}
+
+// PlusDomains returns the domains whose +tags are stripped by the policy
+// for use in SQL queries that replicate Normalize.
+func PlusDomains(p Policy) []string {
+	if !p.StripPlus {
+		return []string{}
+	}
+
+	return keys(plusProviders)
+}
+
+// DotDomains returns the domains whose dots are stripped by the policy
+// for use in SQL queries that replicate Normalize.
+func DotDomains(p Policy) []string {
+	if !p.StripDots {
+		return []string{}
+	}
+
+	return keys(dotProviders)
+}
+
+func keys(mp map[string]struct{}) []string {
+	out := make([]string, 0, len(mp))
+	for k := range mp {
+		out = append(out, k)
+	}
+
+	return out
+}
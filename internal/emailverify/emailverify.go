@@ -0,0 +1,339 @@
+// Package emailverify validates e-mail addresses beyond basic parsing with
+// strict syntax checks, MX record lookups, and suggestions for commonly
+// mistyped domains (eg: gamil.com => gmail.com).
+package emailverify
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ReasonSyntax = "syntax"
+	ReasonNoMX   = "no_mx"
+	ReasonTypo   = "typo"
+
+	// Verification statuses of Classify.
+	StatusValid   = "valid"
+	StatusRisky   = "risky"
+	StatusInvalid = "invalid"
+	StatusUnknown = "unknown"
+
+	// Maximum number of domains whose MX lookup results are cached. The cache
+	// is reset when full.
+	maxCacheSize = 10000
+
+	defaultDNSTimeout = 3 * time.Second
+	defaultCacheTTL   = time.Hour
+)
+
+// Resolver looks up DNS records of a domain. *net.Resolver satisfies it.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+	LookupHost(ctx context.Context, host string) ([]string, error)
+}
+
+// Opt represents the verifier options.
+type Opt struct {
+	// Syntax enables strict RFC 5321 syntax checks on top of the basic parsing,
+	// eg: rejecting domains without a TLD (test@test).
+	Syntax bool
+
+	// MX enables DNS lookups to check that the domain accepts e-mail.
+	MX bool
+
+	// Typos enables suggestions for commonly mistyped domains.
+	Typos bool
+
+	// DNSTimeout is the timeout for a single domain's DNS lookups.
+	DNSTimeout time.Duration
+
+	// CacheTTL is the duration for which MX lookup results are cached.
+	CacheTTL time.Duration
+
+	// LookupRate is the maximum number of domains looked up per second.
+	// Lookups beyond it wait. 0 is no limit.
+	LookupRate int
+
+	// Resolver is the DNS resolver. Defaults to net.DefaultResolver.
+	Resolver Resolver
+}
+
+// Error is returned when an e-mail fails verification.
+type Error struct {
+	// Reason is one of the Reason* constants.
+	Reason string
+
+	// Suggestion is the corrected e-mail for ReasonTypo.
+	Suggestion string
+}
+
+func (e *Error) Error() string {
+	switch e.Reason {
+	case ReasonNoMX:
+		return "e-mail domain does not accept mail"
+	case ReasonTypo:
+		return "possibly mistyped e-mail. Did you mean " + e.Suggestion + "?"
+	}
+
+	return "invalid e-mail syntax"
+}
+
+// Verifier verifies e-mail addresses.
+type Verifier struct {
+	opt Opt
+
+	cache    map[string]cacheItem
+	cacheMut sync.Mutex
+
+	// The time at which the next lookup can be made as per LookupRate.
+	nextLookup time.Time
+	rateMut    sync.Mutex
+}
+
+// domainResult is the result of a domain's DNS lookups.
+type domainResult int
+
+const (
+	// The lookup failed temporarily.
+	domainUnknown domainResult = iota
+
+	// The domain has MX records.
+	domainMX
+
+	// The domain has no MX records, but has address records that implicitly
+	// accept mail.
+	domainAddr
+
+	// The domain doesn't exist or has a null MX.
+	domainNone
+)
+
+type cacheItem struct {
+	res domainResult
+	exp time.Time
+}
+
+// New returns a new instance of the verifier.
+func New(o Opt) *Verifier {
+	if o.DNSTimeout <= 0 {
+		o.DNSTimeout = defaultDNSTimeout
+	}
+	if o.CacheTTL <= 0 {
+		o.CacheTTL = defaultCacheTTL
+	}
+	if o.Resolver == nil {
+		o.Resolver = net.DefaultResolver
+	}
+
+	return &Verifier{
+		opt:   o,
+		cache: make(map[string]cacheItem),
+	}
+}
+
+// Enabled returns true if any of the checks are turned on.
+func (v *Verifier) Enabled() bool {
+	return v.opt.Syntax || v.opt.MX || v.opt.Typos
+}
+
+// Verify runs the enabled checks on an e-mail that has already been parsed
+// and lowercased. It returns an *Error if the e-mail fails any of them.
+func (v *Verifier) Verify(email string) error {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return &Error{Reason: ReasonSyntax}
+	}
+
+	if v.opt.Syntax && !isValidSyntax(local, domain) {
+		return &Error{Reason: ReasonSyntax}
+	}
+
+	if v.opt.Typos {
+		if d, ok := typos[domain]; ok {
+			return &Error{Reason: ReasonTypo, Suggestion: local + "@" + d}
+		}
+	}
+
+	if v.opt.MX && !v.hasMX(domain) {
+		return &Error{Reason: ReasonNoMX}
+	}
+
+	return nil
+}
+
+// Classify runs all the checks on an e-mail irrespective of the options and
+// returns one of the Status* constants. Unlike Verify, it grades the results:
+// mistyped domains and domains that accept mail only implicitly (no MX records)
+// are risky, and DNS failures other than a non-existent domain are unknown.
+func (v *Verifier) Classify(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok || !isValidSyntax(local, domain) {
+		return StatusInvalid
+	}
+
+	if _, ok := typos[domain]; ok {
+		return StatusRisky
+	}
+
+	switch v.checkDomain(domain) {
+	case domainMX:
+		return StatusValid
+	case domainAddr:
+		return StatusRisky
+	case domainNone:
+		return StatusInvalid
+	}
+
+	return StatusUnknown
+}
+
+// hasMX checks whether a domain has MX records or, in their absence, an
+// address record that implicitly accepts mail (RFC 5321, 5.1). Lookup
+// failures other than a non-existent domain are treated as valid so that
+// DNS issues don't reject genuine addresses.
+func (v *Verifier) hasMX(domain string) bool {
+	return v.checkDomain(domain) != domainNone
+}
+
+// checkDomain looks up a domain's DNS records and caches the result.
+func (v *Verifier) checkDomain(domain string) domainResult {
+	v.cacheMut.Lock()
+	c, ok := v.cache[domain]
+	v.cacheMut.Unlock()
+	if ok && time.Now().Before(c.exp) {
+		return c.res
+	}
+
+	v.waitLookup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), v.opt.DNSTimeout)
+	defer cancel()
+
+	res, err := v.lookup(ctx, domain)
+	if err != nil {
+		var dErr *net.DNSError
+		if !errors.As(err, &dErr) || !dErr.IsNotFound {
+			// Don't cache temporary failures.
+			return domainUnknown
+		}
+		res = domainNone
+	}
+
+	v.cacheMut.Lock()
+	if len(v.cache) >= maxCacheSize {
+		v.cache = make(map[string]cacheItem)
+	}
+	v.cache[domain] = cacheItem{res: res, exp: time.Now().Add(v.opt.CacheTTL)}
+	v.cacheMut.Unlock()
+
+	return res
+}
+
+func (v *Verifier) lookup(ctx context.Context, domain string) (domainResult, error) {
+	mx, err := v.opt.Resolver.LookupMX(ctx, domain)
+	if err == nil && len(mx) > 0 {
+		// A single "." MX record is a null MX (RFC 7505) that explicitly
+		// declares that the domain doesn't accept mail.
+		if len(mx) == 1 && (mx[0].Host == "." || mx[0].Host == "") {
+			return domainNone, nil
+		}
+		return domainMX, nil
+	}
+
+	// No MX records. Fall back to the address records.
+	addrs, err := v.opt.Resolver.LookupHost(ctx, domain)
+	if err != nil {
+		return domainUnknown, err
+	}
+	if len(addrs) == 0 {
+		return domainNone, nil
+	}
+
+	return domainAddr, nil
+}
+
+// waitLookup blocks until a lookup can be made as per LookupRate.
+func (v *Verifier) waitLookup() {
+	if v.opt.LookupRate <= 0 {
+		return
+	}
+
+	v.rateMut.Lock()
+	now := time.Now()
+	if v.nextLookup.Before(now) {
+		v.nextLookup = now
+	}
+	wait := v.nextLookup.Sub(now)
+	v.nextLookup = v.nextLookup.Add(time.Second / time.Duration(v.opt.LookupRate))
+	v.rateMut.Unlock()
+
+	time.Sleep(wait)
+}
+
+// isValidSyntax checks the local and domain parts of an e-mail against the
+// RFC 5321 dot-atom rules and length limits. Quoted local parts and IP address
+// literals, while technically valid, are rejected as they are practically
+// never used by genuine subscribers.
+func isValidSyntax(local, domain string) bool {
+	if len(local) == 0 || len(local) > 64 || len(local)+len(domain)+1 > 254 {
+		return false
+	}
+
+	// Local part.
+	if local[0] == '.' || local[len(local)-1] == '.' || strings.Contains(local, "..") {
+		return false
+	}
+	for _, c := range local {
+		if !isAtext(c) && c != '.' {
+			return false
+		}
+	}
+
+	// Domain. It should have at least two labels.
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, l := range labels {
+		if len(l) == 0 || len(l) > 63 || l[0] == '-' || l[len(l)-1] == '-' {
+			return false
+		}
+		for _, c := range l {
+			if !isAlnum(c) && c != '-' {
+				return false
+			}
+		}
+	}
+
+	// The TLD should be alphabetic or an IDN (xn--).
+	tld := labels[len(labels)-1]
+	if strings.HasPrefix(tld, "xn--") {
+		return len(tld) > 4
+	}
+	if len(tld) < 2 {
+		return false
+	}
+	for _, c := range tld {
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isAlnum(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// isAtext checks whether c is an RFC 5322 atext character.
+func isAtext(c rune) bool {
+	return isAlnum(c) || strings.ContainsRune("!#$%&'*+-/=?^_`{|}~", c)
+}
@@ -0,0 +1,251 @@
+package emailverify
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testResolver is a fake DNS resolver with fixed records that counts lookups.
+type testResolver struct {
+	mx    map[string][]*net.MX
+	hosts map[string][]string
+
+	// Domains whose lookups fail temporarily.
+	fail map[string]bool
+
+	// If set, lookups block until the context is done.
+	block bool
+
+	mut     sync.Mutex
+	lookups map[string]int
+}
+
+func (r *testResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	r.mut.Lock()
+	if r.lookups == nil {
+		r.lookups = map[string]int{}
+	}
+	r.lookups[name]++
+	r.mut.Unlock()
+
+	if r.block {
+		<-ctx.Done()
+		return nil, &net.DNSError{Err: ctx.Err().Error(), Name: name, IsTimeout: true}
+	}
+	if r.fail[name] {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	if mx, ok := r.mx[name]; ok {
+		return mx, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *testResolver) LookupHost(ctx context.Context, name string) ([]string, error) {
+	if r.block {
+		<-ctx.Done()
+		return nil, &net.DNSError{Err: ctx.Err().Error(), Name: name, IsTimeout: true}
+	}
+	if r.fail[name] {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+	if h, ok := r.hosts[name]; ok {
+		return h, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+}
+
+func (r *testResolver) count(name string) int {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.lookups[name]
+}
+
+func newTestResolver() *testResolver {
+	return &testResolver{
+		mx: map[string][]*net.MX{
+			"example.com": {{Host: "mx.example.com.", Pref: 10}},
+			"nomail.com":  {{Host: ".", Pref: 0}},
+		},
+		hosts: map[string][]string{
+			"implicit.com": {"192.0.2.1"},
+		},
+		fail: map[string]bool{"flaky.com": true},
+	}
+}
+
+func TestIsValidSyntax(t *testing.T) {
+	cases := []struct {
+		email string
+		exp   bool
+	}{
+		{"user@example.com", true},
+		{"first.last+tag@sub.example.co.uk", true},
+		{"o'brien@example.com", true},
+		{"user@xn--80ak6aa92e.com", true},
+		{"user@example.xn--p1ai", true},
+		{"user@my-domain.com", true},
+		{"test@test", false},
+		{".user@example.com", false},
+		{"user.@example.com", false},
+		{"us..er@example.com", false},
+		{`"user"@example.com`, false},
+		{"user@[192.0.2.1]", false},
+		{"user@example.c", false},
+		{"user@example.c0m", false},
+		{"user@-example.com", false},
+		{"user@example-.com", false},
+		{"user@example..com", false},
+		{"user@exa_mple.com", false},
+		{"user@example.xn--", false},
+		{strings.Repeat("a", 65) + "@example.com", false},
+		{"user@" + strings.Repeat("a", 64) + ".com", false},
+		{strings.Repeat("a", 64) + "@" + strings.Repeat(strings.Repeat("b", 60)+".", 4) + "com", false},
+	}
+	for _, c := range cases {
+		local, domain, _ := strings.Cut(c.email, "@")
+		if got := isValidSyntax(local, domain); got != c.exp {
+			t.Errorf("%s: expected %v, got %v", c.email, c.exp, got)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	cases := []struct {
+		name       string
+		opt        Opt
+		email      string
+		reason     string
+		suggestion string
+	}{
+		{"no checks", Opt{}, "test@test", "", ""},
+		{"no @", Opt{}, "test", ReasonSyntax, ""},
+		{"syntax", Opt{Syntax: true}, "test@test", ReasonSyntax, ""},
+		{"valid syntax", Opt{Syntax: true}, "user@gamil.com", "", ""},
+		{"typo", Opt{Typos: true}, "user@gamil.com", ReasonTypo, "user@gmail.com"},
+		{"typo in the tld", Opt{Typos: true}, "user@hotmail.con", ReasonTypo, "user@hotmail.com"},
+		{"no typo", Opt{Typos: true}, "user@gmail.com", "", ""},
+		{"syntax before typo", Opt{Syntax: true, Typos: true}, ".user@gamil.com", ReasonSyntax, ""},
+		{"typo before mx", Opt{Typos: true, MX: true}, "user@gamil.com", ReasonTypo, "user@gmail.com"},
+		{"mx", Opt{MX: true}, "user@example.com", "", ""},
+		{"implicit mx", Opt{MX: true}, "user@implicit.com", "", ""},
+		{"null mx", Opt{MX: true}, "user@nomail.com", ReasonNoMX, ""},
+		{"no domain", Opt{MX: true}, "user@nope.com", ReasonNoMX, ""},
+		{"temporary failure", Opt{MX: true}, "user@flaky.com", "", ""},
+	}
+	for _, c := range cases {
+		c.opt.Resolver = newTestResolver()
+		err := New(c.opt).Verify(c.email)
+
+		if c.reason == "" {
+			if err != nil {
+				t.Errorf("%s: expected no error, got %v", c.name, err)
+			}
+			continue
+		}
+
+		var e *Error
+		if !errors.As(err, &e) || e.Reason != c.reason || e.Suggestion != c.suggestion {
+			t.Errorf("%s: expected %s (%s), got %v", c.name, c.reason, c.suggestion, err)
+		}
+	}
+}
+
+func TestVerifyCache(t *testing.T) {
+	r := newTestResolver()
+	v := New(Opt{MX: true, CacheTTL: 50 * time.Millisecond, Resolver: r})
+
+	// Results, including non-existent domains, are cached.
+	for range 3 {
+		_ = v.Verify("a@example.com")
+		_ = v.Verify("b@nope.com")
+	}
+	if r.count("example.com") != 1 || r.count("nope.com") != 1 {
+		t.Errorf("expected one lookup per domain, got %v", r.lookups)
+	}
+
+	// Temporary failures aren't.
+	for range 3 {
+		_ = v.Verify("a@flaky.com")
+	}
+	if n := r.count("flaky.com"); n != 3 {
+		t.Errorf("expected temporary failures to be looked up again, got %d", n)
+	}
+
+	// Cached results expire.
+	time.Sleep(60 * time.Millisecond)
+	_ = v.Verify("a@example.com")
+	if n := r.count("example.com"); n != 2 {
+		t.Errorf("expected the expired result to be looked up again, got %d", n)
+	}
+}
+
+func TestVerifyTimeout(t *testing.T) {
+	r := newTestResolver()
+	r.block = true
+	v := New(Opt{MX: true, DNSTimeout: 50 * time.Millisecond, Resolver: r})
+
+	// Lookups that time out don't reject the e-mail.
+	start := time.Now()
+	if err := v.Verify("user@slow.com"); err != nil {
+		t.Errorf("expected a timed out lookup to pass, got %v", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected the lookup to time out, took %v", d)
+	}
+	if got := v.Classify("user@slow.com"); got != StatusUnknown {
+		t.Errorf("expected a timed out lookup to be unknown, got %s", got)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	v := New(Opt{Resolver: newTestResolver()})
+
+	cases := []struct {
+		email string
+		exp   string
+	}{
+		{"user@example.com", StatusValid},
+		{" User@Example.com ", StatusValid},
+		{"user@implicit.com", StatusRisky},
+		{"user@gamil.com", StatusRisky},
+		{"user@nomail.com", StatusInvalid},
+		{"user@nope.com", StatusInvalid},
+		{"test@test", StatusInvalid},
+		{"test", StatusInvalid},
+		{"user@flaky.com", StatusUnknown},
+	}
+	for _, c := range cases {
+		if got := v.Classify(c.email); got != c.exp {
+			t.Errorf("%s: expected %s, got %s", c.email, c.exp, got)
+		}
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	if New(Opt{}).Enabled() {
+		t.Error("expected no checks to be enabled")
+	}
+	for _, o := range []Opt{{Syntax: true}, {MX: true}, {Typos: true}} {
+		if !New(o).Enabled() {
+			t.Errorf("expected %+v to be enabled", o)
+		}
+	}
+}
+
+func TestTypos(t *testing.T) {
+	// Suggestions should be correct domains themselves.
+	for typo, d := range typos {
+		if _, ok := typos[d]; ok || typo == d {
+			t.Errorf("%s: suggestion %s is itself a typo", typo, d)
+		}
+		if !isValidSyntax("user", d) {
+			t.Errorf("%s: invalid suggestion %s", typo, d)
+		}
+	}
+}
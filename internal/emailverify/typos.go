@@ -0,0 +1,79 @@
+package emailverify
+
+// typos is a map of commonly mistyped domains of popular mail providers
+// to their correct domains.
+var typos = map[string]string{
+	// gmail.com
+	"gamil.com":     "gmail.com",
+	"gmial.com":     "gmail.com",
+	"gmai.com":      "gmail.com",
+	"gmaill.com":    "gmail.com",
+	"gmali.com":     "gmail.com",
+	"gnail.com":     "gmail.com",
+	"gmail.co":      "gmail.com",
+	"gmail.cm":      "gmail.com",
+	"gmail.con":     "gmail.com",
+	"gmail.cmo":     "gmail.com",
+	"gmail.om":      "gmail.com",
+	"gmail.comm":    "gmail.com",
+	"gmil.com":      "gmail.com",
+	"gmal.com":      "gmail.com",
+	"gmaul.com":     "gmail.com",
+	"gmsil.com":     "gmail.com",
+	"googlemail.co": "googlemail.com",
+
+	// yahoo.com
+	"yaho.com":   "yahoo.com",
+	"yahooo.com": "yahoo.com",
+	"yahoo.co":   "yahoo.com",
+	"yahoo.cm":   "yahoo.com",
+	"yahoo.con":  "yahoo.com",
+	"yhoo.com":   "yahoo.com",
+	"yhaoo.com":  "yahoo.com",
+	"yaoo.com":   "yahoo.com",
+	"tahoo.com":  "yahoo.com",
+
+	// hotmail.com
+	"hotmial.com":  "hotmail.com",
+	"hotmal.com":   "hotmail.com",
+	"hotmai.com":   "hotmail.com",
+	"hotmil.com":   "hotmail.com",
+	"hotmaill.com": "hotmail.com",
+	"hotmail.co":   "hotmail.com",
+	"hotmail.cm":   "hotmail.com",
+	"hotmail.con":  "hotmail.com",
+	"homail.com":   "hotmail.com",
+	"htmail.com":   "hotmail.com",
+	"hotamil.com":  "hotmail.com",
+
+	// outlook.com
+	"outlok.com":  "outlook.com",
+	"outllok.com": "outlook.com",
+	"outlook.co":  "outlook.com",
+	"outlook.con": "outlook.com",
+	"outloo.com":  "outlook.com",
+	"otlook.com":  "outlook.com",
+	"outlool.com": "outlook.com",
+
+	// icloud.com
+	"iclod.com":   "icloud.com",
+	"icloud.co":   "icloud.com",
+	"icloud.con":  "icloud.com",
+	"icoud.com":   "icloud.com",
+	"iclould.com": "icloud.com",
+
+	// aol.com
+	"aol.co":   "aol.com",
+	"aol.con":  "aol.com",
+	"aoll.com": "aol.com",
+
+	// live.com
+	"live.co":  "live.com",
+	"live.con": "live.com",
+	"liv.com":  "live.com",
+
+	// protonmail.com
+	"protonmial.com": "protonmail.com",
+	"protonmail.co":  "protonmail.com",
+	"protonmal.com":  "protonmail.com",
+}
@@ -0,0 +1,255 @@
+// Package fetcher fetches remote files over HTTP, eg: per-subscriber campaign
+// attachments, with size limits, a content-type allowlist, a strict timeout,
+// bounded concurrency, and a small in-memory cache.
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultTimeout     = 10 * time.Second
+	defaultMaxSize     = 10 * 1024 * 1024
+	defaultConcurrency = 10
+	defaultCacheSize   = 100 * 1024 * 1024
+)
+
+// Opt represents the fetcher options.
+type Opt struct {
+	// Timeout is the timeout of a single fetch including reading the body.
+	Timeout time.Duration
+
+	// MaxSize is the maximum size of a file in bytes.
+	MaxSize int64
+
+	// ContentTypes is the list of allowed content types, eg: application/pdf.
+	// A type can end with /* to match all its subtypes, eg: image/*.
+	ContentTypes []string
+
+	// Concurrency is the maximum number of concurrent fetches.
+	Concurrency int
+
+	// CacheTTL is the duration for which fetched files are cached by their URLs.
+	// 0 disables the cache.
+	CacheTTL time.Duration
+
+	// CacheSize is the maximum total size of the cached files in bytes. The
+	// cache is reset when full.
+	CacheSize int64
+
+	// Client is the HTTP client. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// File represents a fetched file.
+type File struct {
+	ContentType string
+	Content     []byte
+}
+
+// Stats represents the counts of fetches since the fetcher was created.
+type Stats struct {
+	Fetched   int64 `json:"fetched"`
+	CacheHits int64 `json:"cache_hits"`
+	Errors    int64 `json:"errors"`
+}
+
+// Fetcher fetches files.
+type Fetcher struct {
+	opt Opt
+	sem chan struct{}
+
+	cache     map[string]cacheItem
+	cacheSize int64
+	cacheMut  sync.Mutex
+
+	fetched   atomic.Int64
+	cacheHits atomic.Int64
+	errors    atomic.Int64
+}
+
+type cacheItem struct {
+	file File
+	exp  time.Time
+}
+
+// New returns a new instance of the fetcher.
+func New(o Opt) *Fetcher {
+	if o.Timeout <= 0 {
+		o.Timeout = defaultTimeout
+	}
+	if o.MaxSize <= 0 {
+		o.MaxSize = defaultMaxSize
+	}
+	if o.Concurrency < 1 {
+		o.Concurrency = defaultConcurrency
+	}
+	if o.CacheSize <= 0 {
+		o.CacheSize = defaultCacheSize
+	}
+	if o.Client == nil {
+		o.Client = http.DefaultClient
+	}
+
+	return &Fetcher{
+		opt:   o,
+		sem:   make(chan struct{}, o.Concurrency),
+		cache: make(map[string]cacheItem),
+	}
+}
+
+// Concurrency returns the maximum number of concurrent fetches.
+func (f *Fetcher) Concurrency() int {
+	return f.opt.Concurrency
+}
+
+// MaxSize returns the maximum size of a file in bytes.
+func (f *Fetcher) MaxSize() int64 {
+	return f.opt.MaxSize
+}
+
+// Stats returns the fetch counts.
+func (f *Fetcher) Stats() Stats {
+	return Stats{
+		Fetched:   f.fetched.Load(),
+		CacheHits: f.cacheHits.Load(),
+		Errors:    f.errors.Load(),
+	}
+}
+
+// Fetch fetches the file at the given http(s) URL. It blocks if the
+// maximum number of concurrent fetches are in progress. Errors don't
+// contain the URL as it may have secrets, eg: per-subscriber tokens.
+func (f *Fetcher) Fetch(u string) (File, error) {
+	if file, ok := f.getCache(u); ok {
+		f.cacheHits.Add(1)
+		return file, nil
+	}
+
+	f.sem <- struct{}{}
+	defer func() { <-f.sem }()
+
+	file, err := f.fetch(u)
+	if err != nil {
+		f.errors.Add(1)
+		return File{}, err
+	}
+	f.fetched.Add(1)
+	f.setCache(u, file)
+
+	return file, nil
+}
+
+func (f *Fetcher) fetch(u string) (File, error) {
+	p, err := url.Parse(u)
+	if err != nil || (p.Scheme != "http" && p.Scheme != "https") || p.Host == "" {
+		return File{}, errors.New("invalid URL")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), f.opt.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return File{}, err
+	}
+
+	resp, err := f.opt.Client.Do(req)
+	if err != nil {
+		// Drop the URL from the error.
+		if uErr, ok := err.(*url.Error); ok {
+			return File{}, uErr.Err
+		}
+		return File{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return File{}, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	typ, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		typ = "application/octet-stream"
+	}
+	if !f.isAllowed(typ) {
+		return File{}, fmt.Errorf("content type %s is not allowed", typ)
+	}
+
+	if resp.ContentLength > f.opt.MaxSize {
+		return File{}, fmt.Errorf("file exceeds the maximum size of %d bytes", f.opt.MaxSize)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, f.opt.MaxSize+1))
+	if err != nil {
+		return File{}, fmt.Errorf("error reading file: %v", err)
+	}
+	if int64(len(b)) > f.opt.MaxSize {
+		return File{}, fmt.Errorf("file exceeds the maximum size of %d bytes", f.opt.MaxSize)
+	}
+	if len(b) == 0 {
+		return File{}, errors.New("empty file")
+	}
+
+	return File{ContentType: typ, Content: b}, nil
+}
+
+// isAllowed checks whether a content type is in the allowlist.
+func (f *Fetcher) isAllowed(typ string) bool {
+	typ = strings.ToLower(typ)
+	return slices.ContainsFunc(f.opt.ContentTypes, func(t string) bool {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if pre, ok := strings.CutSuffix(t, "/*"); ok {
+			return strings.HasPrefix(typ, pre+"/")
+		}
+		return t == typ
+	})
+}
+
+func (f *Fetcher) getCache(u string) (File, bool) {
+	if f.opt.CacheTTL <= 0 {
+		return File{}, false
+	}
+
+	f.cacheMut.Lock()
+	defer f.cacheMut.Unlock()
+
+	c, ok := f.cache[u]
+	if !ok || time.Now().After(c.exp) {
+		return File{}, false
+	}
+
+	return c.file, true
+}
+
+func (f *Fetcher) setCache(u string, file File) {
+	size := int64(len(file.Content))
+	if f.opt.CacheTTL <= 0 || size > f.opt.CacheSize {
+		return
+	}
+
+	f.cacheMut.Lock()
+	defer f.cacheMut.Unlock()
+
+	if old, ok := f.cache[u]; ok {
+		f.cacheSize -= int64(len(old.file.Content))
+	}
+	if f.cacheSize+size > f.opt.CacheSize {
+		f.cache = make(map[string]cacheItem)
+		f.cacheSize = 0
+	}
+
+	f.cache[u] = cacheItem{file: file, exp: time.Now().Add(f.opt.CacheTTL)}
+	f.cacheSize += size
+}
@@ -0,0 +1,220 @@
+package fetcher
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestServer returns a server that serves per-ID invoices at /inv/{id}.pdf and
+// files with the status, content type, and size in the query at /file. It counts
+// the requests and the maximum number of concurrent requests.
+func newTestServer(t *testing.T, delay time.Duration) (*httptest.Server, *atomic.Int64, *atomic.Int64) {
+	t.Helper()
+
+	var reqs, active, maxActive atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("/inv/{id}", func(w http.ResponseWriter, r *http.Request) {
+		reqs.Add(1)
+		n := active.Add(1)
+		defer active.Add(-1)
+		for {
+			m := maxActive.Load()
+			if n <= m || maxActive.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		time.Sleep(delay)
+
+		id, ok := strings.CutSuffix(r.PathValue("id"), ".pdf")
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("invoice " + id))
+	})
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		reqs.Add(1)
+		q := r.URL.Query()
+		if q.Has("type") {
+			w.Header().Set("Content-Type", q.Get("type"))
+		}
+		size, _ := strconv.Atoi(q.Get("size"))
+		if q.Has("chunked") {
+			// Flushing before writing drops the Content-Length.
+			w.(http.Flusher).Flush()
+		}
+		if s, _ := strconv.Atoi(q.Get("status")); s > 0 {
+			w.WriteHeader(s)
+		}
+		w.Write([]byte(strings.Repeat("a", size)))
+	})
+
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	return srv, &reqs, &maxActive
+}
+
+func TestFetch(t *testing.T) {
+	srv, reqs, _ := newTestServer(t, 0)
+	f := New(Opt{ContentTypes: []string{"application/pdf"}})
+
+	for _, id := range []string{"1", "2", "1"} {
+		file, err := f.Fetch(srv.URL + "/inv/" + id + ".pdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file.ContentType != "application/pdf" || string(file.Content) != "invoice "+id {
+			t.Errorf("%s: unexpected file %s %q", id, file.ContentType, file.Content)
+		}
+	}
+
+	// The cache is disabled by default.
+	if n := reqs.Load(); n != 3 {
+		t.Errorf("expected 3 requests, got %d", n)
+	}
+	if s := f.Stats(); s.Fetched != 3 || s.CacheHits != 0 || s.Errors != 0 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestFetchErrors(t *testing.T) {
+	srv, _, _ := newTestServer(t, 0)
+	f := New(Opt{ContentTypes: []string{"application/pdf", "image/*"}, MaxSize: 10})
+
+	cases := []struct {
+		name string
+		url  string
+		err  string
+	}{
+		{"invalid URL", "://secret", "invalid URL"},
+		{"relative URL", "/inv/1.pdf", "invalid URL"},
+		{"other scheme", "file:///etc/passwd", "invalid URL"},
+		{"not found", srv.URL + "/inv/secret", "unexpected status 404"},
+		{"error status", srv.URL + "/file?type=application/pdf&size=1&status=500", "unexpected status 500"},
+		{"disallowed type", srv.URL + "/file?type=text/html&size=1", "content type text/html is not allowed"},
+		{"no type", srv.URL + "/file?type=&size=1", "content type application/octet-stream is not allowed"},
+		{"too large", srv.URL + "/file?type=application/pdf&size=11", "exceeds the maximum size"},
+		{"too large without a length", srv.URL + "/file?type=application/pdf&size=11&chunked", "exceeds the maximum size"},
+		{"empty", srv.URL + "/file?type=application/pdf&size=0", "empty file"},
+		{"unreachable", "http://127.0.0.1:1/secret", "refused"},
+	}
+	for _, c := range cases {
+		_, err := f.Fetch(c.url)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: expected error %q, got %v", c.name, c.err, err)
+			continue
+		}
+
+		// URLs may have per-subscriber secrets.
+		if strings.Contains(err.Error(), "secret") {
+			t.Errorf("%s: expected the URL to be left out of the error, got %v", c.name, err)
+		}
+	}
+
+	for _, typ := range []string{"image/png", "Application/PDF; charset=binary"} {
+		if _, err := f.Fetch(srv.URL + "/file?size=10&type=" + url.QueryEscape(typ)); err != nil {
+			t.Errorf("%s: unexpected error: %v", typ, err)
+		}
+	}
+
+	if s := f.Stats(); s.Fetched != 2 || s.Errors != int64(len(cases)) {
+		t.Errorf("unexpected stats %+v", s)
+	}
+}
+
+func TestFetchTimeout(t *testing.T) {
+	srv, _, _ := newTestServer(t, time.Second)
+	f := New(Opt{ContentTypes: []string{"application/pdf"}, Timeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	if _, err := f.Fetch(srv.URL + "/inv/1.pdf"); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Errorf("expected the fetch to time out after 50ms, took %v", d)
+	}
+}
+
+func TestFetchConcurrency(t *testing.T) {
+	srv, reqs, maxActive := newTestServer(t, 20*time.Millisecond)
+	f := New(Opt{ContentTypes: []string{"application/pdf"}, Concurrency: 3})
+
+	var wg sync.WaitGroup
+	for i := range 12 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := f.Fetch(srv.URL + "/inv/" + strconv.Itoa(i) + ".pdf"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := reqs.Load(); n != 12 {
+		t.Errorf("expected 12 requests, got %d", n)
+	}
+	if n := maxActive.Load(); n > 3 {
+		t.Errorf("expected at most 3 concurrent fetches, got %d", n)
+	}
+}
+
+func TestFetchCache(t *testing.T) {
+	srv, reqs, _ := newTestServer(t, 0)
+	f := New(Opt{ContentTypes: []string{"application/pdf"}, CacheTTL: 100 * time.Millisecond, CacheSize: 20})
+
+	fetch := func(id string) {
+		t.Helper()
+		file, err := f.Fetch(srv.URL + "/inv/" + id + ".pdf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(file.Content) != "invoice "+id {
+			t.Fatalf("%s: unexpected content %q", id, file.Content)
+		}
+	}
+
+	// Files are cached by their URLs.
+	fetch("1")
+	fetch("1")
+	fetch("2")
+	if n := reqs.Load(); n != 2 {
+		t.Errorf("expected 2 requests, got %d", n)
+	}
+	if s := f.Stats(); s.Fetched != 2 || s.CacheHits != 1 {
+		t.Errorf("unexpected stats %+v", s)
+	}
+
+	// The cache is reset when it's full.
+	fetch("3")
+	fetch("1")
+	if n := reqs.Load(); n != 4 {
+		t.Errorf("expected the cache to be reset, got %d requests", n)
+	}
+
+	// Cached files expire.
+	time.Sleep(150 * time.Millisecond)
+	fetch("1")
+	if n := reqs.Load(); n != 5 {
+		t.Errorf("expected the cached file to expire, got %d requests", n)
+	}
+
+	// Errors aren't cached.
+	for range 2 {
+		if _, err := f.Fetch(srv.URL + "/inv/x"); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if n := reqs.Load(); n != 7 {
+		t.Errorf("expected errors to not be cached, got %d requests", n)
+	}
+}
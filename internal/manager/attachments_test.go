@@ -0,0 +1,242 @@
+package manager
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/fetcher"
+	"github.com/knadh/listmonk/models"
+)
+
+// attachMessenger records the attachments of the messages pushed to it.
+type attachMessenger struct {
+	testMessenger
+
+	mut  sync.Mutex
+	atts map[string][]models.Attachment
+}
+
+func (a *attachMessenger) Push(m models.Message) error {
+	a.mut.Lock()
+	a.atts[m.To[0]] = m.Attachments
+	a.mut.Unlock()
+
+	return a.testMessenger.Push(m)
+}
+
+func (a *attachMessenger) get(email string) ([]models.Attachment, bool) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+
+	atts, ok := a.atts[email]
+	return atts, ok
+}
+
+// TestDynamicAttachments sends a campaign with per-subscriber invoices from a
+// test server and checks that each subscriber gets their own invoice, and that
+// subscribers without one are skipped or sent to without it.
+func TestDynamicAttachments(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, _ := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/inv/"), ".pdf")
+		if _, err := strconv.Atoi(id); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Write([]byte("invoice " + id))
+	}))
+	defer srv.Close()
+
+	for _, onErr := range []string{models.AttachmentOnErrorSkip, models.AttachmentOnErrorSend} {
+		st := &testStore{}
+		m := newTestManager(Config{
+			BatchSize:       10,
+			Concurrency:     2,
+			AttachmentFetch: fetcher.Opt{ContentTypes: []string{"application/pdf"}, Concurrency: 3, Timeout: 5 * time.Second},
+		}, st)
+
+		msgr := &attachMessenger{atts: map[string][]models.Attachment{}}
+		if err := m.AddMessenger(msgr); err != nil {
+			t.Fatal(err)
+		}
+
+		subs := testSubscribers(1, 5)
+		for i := range subs {
+			subs[i].Email = strings.Repeat("s", i+1) + "@example.com"
+			subs[i].Attribs = models.JSON{"invoice_id": i + 1}
+		}
+		delete(subs[2].Attribs, "invoice_id")
+
+		var once sync.Once
+		st.nextSubscribers = func(campID, limit int) ([]models.Subscriber, error) {
+			var out []models.Subscriber
+			once.Do(func() { out = subs })
+			return out, nil
+		}
+
+		go m.Run()
+
+		c := newTestCampaign()
+		c.Simulation = false
+		c.Messenger = "test"
+		c.Attachments = make([]models.Attachment, 1, 2)
+		c.Attachments[0] = models.Attachment{Name: "terms.pdf", Content: []byte("terms")}
+		c.DynamicAttachments = models.DynamicAttachments{{
+			URL:      srv.URL + `/inv/{{ index .Subscriber.Attribs "invoice_id" }}.pdf`,
+			Filename: `invoice-{{ .Subscriber.ID }}.pdf`,
+			OnError:  onErr,
+		}}
+
+		p, err := m.newPipe(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.nextPipes <- p
+
+		waitFor(t, 10*time.Second, func() bool { return len(st.getStatuses()) > 0 })
+		m.Close()
+
+		for _, s := range subs {
+			atts, ok := msgr.get(s.Email)
+			if s.ID == 3 {
+				// The subscriber without an invoice.
+				if onErr == models.AttachmentOnErrorSkip && ok {
+					t.Errorf("%s: expected %s to be skipped", onErr, s.Email)
+				}
+				if onErr == models.AttachmentOnErrorSend && (!ok || len(atts) != 1) {
+					t.Errorf("%s: expected %s to be sent only the campaign's attachment, got %v", onErr, s.Email, atts)
+				}
+				continue
+			}
+
+			if !ok || len(atts) != 2 {
+				t.Fatalf("%s: expected 2 attachments for %s, got %v", onErr, s.Email, atts)
+			}
+			if atts[0].Name != "terms.pdf" {
+				t.Errorf("%s: unexpected campaign attachment %s", onErr, atts[0].Name)
+			}
+			a := atts[1]
+			if id := s.Attribs["invoice_id"]; a.Name != "invoice-"+strconv.Itoa(s.ID)+".pdf" || string(a.Content) != "invoice "+strconv.Itoa(id.(int)) {
+				t.Errorf("%s: unexpected attachment for %s: %s %q", onErr, s.Email, a.Name, a.Content)
+			}
+			if typ := a.Header.Get("Content-Type"); !strings.HasPrefix(typ, "application/pdf;") {
+				t.Errorf("%s: unexpected content type %s", onErr, typ)
+			}
+		}
+
+		// The subscriber's attachments aren't added to the campaign's.
+		if len(c.Attachments) != 1 {
+			t.Errorf("%s: expected the campaign's attachments to be left as is, got %d", onErr, len(c.Attachments))
+		}
+		if n := p.attachErrors.Load(); n != 1 {
+			t.Errorf("%s: expected 1 attachment error, got %d", onErr, n)
+		}
+	}
+}
+
+// sizeMessenger is a testMessenger that limits the size of the messages,
+// measured as the size of the body and the attachments.
+type sizeMessenger struct {
+	testMessenger
+
+	limit int64
+}
+
+func (s *sizeMessenger) Name() string { return "sized" }
+
+func (s *sizeMessenger) MaxMessageSize() int64 { return s.limit }
+
+func (s *sizeMessenger) MessageSize(m models.Message) (int64, error) {
+	n := int64(len(m.Body))
+	for _, a := range m.Attachments {
+		n += int64(len(a.Content))
+	}
+	return n, nil
+}
+
+// mediaStore returns the campaign's media attachments.
+type mediaStore struct {
+	*testStore
+
+	media map[int]models.Attachment
+}
+
+func (s *mediaStore) GetAttachment(id int) (models.Attachment, error) {
+	return s.media[id], nil
+}
+
+func TestEstimateMessageSize(t *testing.T) {
+	const mb = 1024 * 1024
+
+	st := &mediaStore{testStore: &testStore{}, media: map[int]models.Attachment{
+		1: {Name: "small.pdf", Content: make([]byte, 100*1024)},
+		2: {Name: "large.pdf", Content: make([]byte, 2*mb)},
+	}}
+	m := newTestManager(Config{AttachmentFetch: fetcher.Opt{MaxSize: mb}}, st)
+
+	for _, msgr := range []Messenger{&testMessenger{}, &sizeMessenger{limit: mb}} {
+		if err := m.AddMessenger(msgr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		name      string
+		messenger string
+		media     []int64
+		dynamic   int
+		ok        bool
+		tooLarge  bool
+	}{
+		{"messenger without limits", "test", []int64{2}, 0, false, false},
+		{"no attachments", "sized", nil, 0, true, false},
+		{"small attachment", "sized", []int64{1}, 0, true, false},
+		{"oversized attachment", "sized", []int64{1, 2}, 0, true, true},
+		{"dynamic attachments", "sized", []int64{1}, 2, true, false},
+	}
+	for _, c := range cases {
+		camp := newTestCampaign()
+		camp.Messenger = c.messenger
+		camp.MediaIDs = c.media
+		for range c.dynamic {
+			camp.DynamicAttachments = append(camp.DynamicAttachments, models.DynamicAttachment{URL: "https://example.com/{{ .Subscriber.UUID }}.pdf"})
+		}
+		if err := camp.CompileTemplate(m.TemplateFuncs(camp)); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := m.NewCampaignMessage(camp, testSubscribers(1, 1)[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sz, ok, err := m.EstimateMessageSize(msg)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if ok != c.ok {
+			t.Fatalf("%s: expected ok %v, got %v", c.name, c.ok, ok)
+		}
+		if !ok {
+			continue
+		}
+
+		exp := int64(len(msg.body))
+		for _, id := range c.media {
+			exp += int64(len(st.media[int(id)].Content))
+		}
+		if sz.Size != exp || sz.Limit != mb || (sz.Size > sz.Limit) != c.tooLarge {
+			t.Errorf("%s: unexpected size %+v, expected %d", c.name, sz, exp)
+		}
+
+		// Dynamic attachments are estimated at their maximum base64 encoded size.
+		if exp := int64(c.dynamic) * mb * 4 / 3; sz.MaxDynamicAttachments != exp {
+			t.Errorf("%s: expected dynamic attachments of %d, got %d", c.name, exp, sz.MaxDynamicAttachments)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// Delay before the first retry of a database query after the connection
+	// is lost. It doubles on every subsequent failure up to dbBackoffMax.
+	dbBackoffMin = time.Second
+	dbBackoffMax = time.Minute
+
+	// Maximum time for which the final updates of a campaign that ends while
+	// the database is unavailable are retried.
+	dbRetryTimeout = time.Minute * 10
+)
+
+// ErrDBUnavailable wraps the errors returned by the Store when the connection
+// to the database is lost, eg: on a network blip, failover, or restart. These
+// errors pause campaign processing instead of failing the campaigns.
+var ErrDBUnavailable = errors.New("database unavailable")
+
+// dbHealth tracks the state of the connection to the database. When it's lost,
+// fetching campaigns and subscribers is paused and retried with an exponential
+// backoff while the running campaigns are kept alive.
+type dbHealth struct {
+	mut     sync.RWMutex
+	down    bool
+	since   time.Time
+	err     error
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// fail records a failed query. If the error is a lost connection, the backoff is
+// increased and its duration returned along with true.
+func (h *dbHealth) fail(err error, lo *log.Logger) (time.Duration, bool) {
+	if !errors.Is(err, ErrDBUnavailable) {
+		return 0, false
+	}
+
+	h.mut.Lock()
+	defer h.mut.Unlock()
+
+	if !h.down {
+		h.down = true
+		h.since = time.Now()
+		h.backoff = dbBackoffMin
+		lo.Printf("database connection lost, pausing campaign processing: %v", err)
+	} else {
+		h.backoff = min(h.backoff*2, dbBackoffMax)
+	}
+	h.err = err
+	h.retryAt = time.Now().Add(h.backoff)
+
+	return h.backoff, true
+}
+
+// ok records a successful query, ending the backoff if the connection was lost.
+func (h *dbHealth) ok(lo *log.Logger) {
+	h.mut.RLock()
+	down := h.down
+	h.mut.RUnlock()
+	if !down {
+		return
+	}
+
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if !h.down {
+		return
+	}
+
+	lo.Printf("database connection restored after %s, resuming campaign processing", time.Since(h.since).Round(time.Second))
+	h.down = false
+	h.err = nil
+	h.backoff = 0
+}
+
+// waiting returns true if the connection is lost and the next retry isn't due yet.
+func (h *dbHealth) waiting() bool {
+	h.mut.RLock()
+	defer h.mut.RUnlock()
+
+	return h.down && time.Now().Before(h.retryAt)
+}
+
+// retryIn returns the time left until the next retry if the connection is lost,
+// and 0 otherwise.
+func (h *dbHealth) retryIn() time.Duration {
+	h.mut.RLock()
+	defer h.mut.RUnlock()
+
+	if !h.down {
+		return 0
+	}
+
+	return max(time.Until(h.retryAt), 0)
+}
+
+// status returns an error describing the lost connection, or nil if it's healthy.
+func (h *dbHealth) status() error {
+	h.mut.RLock()
+	defer h.mut.RUnlock()
+
+	if !h.down {
+		return nil
+	}
+
+	return fmt.Errorf("campaign processing paused since %s: %v", h.since.Format(time.RFC3339), h.err)
+}
+
+// DBStatus returns an error if campaign processing is paused because the
+// connection to the database is lost, and nil otherwise.
+func (m *Manager) DBStatus() error {
+	return m.db.status()
+}
+
+// retryDB runs fn and retries it with an exponential backoff for as long as
+// it fails with a lost connection, up to dbRetryTimeout.
+func (m *Manager) retryDB(fn func() error) error {
+	start := time.Now()
+	for {
+		err := fn()
+		if err == nil {
+			m.db.ok(m.log)
+			return nil
+		}
+
+		d, ok := m.db.fail(err, m.log)
+		if !ok || time.Since(start)+d > dbRetryTimeout {
+			return err
+		}
+		time.Sleep(d)
+	}
+}
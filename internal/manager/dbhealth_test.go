@@ -0,0 +1,152 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// TestDBOutage drops the database connection in the middle of a campaign and
+// checks that the campaign resumes and finishes once it's restored, without
+// being paused or counting the outage as send errors.
+func TestDBOutage(t *testing.T) {
+	var (
+		mut        sync.Mutex
+		calls      int
+		downStatus error
+		lost       = fmt.Errorf("%w: %w", ErrDBUnavailable, errors.New("connection reset by peer"))
+	)
+
+	st := &testStore{}
+	m := newTestManager(Config{BatchSize: 2, Concurrency: 1, MaxSendErrors: 1}, st)
+
+	st.nextSubscribers = func(campID, limit int) ([]models.Subscriber, error) {
+		mut.Lock()
+		defer mut.Unlock()
+
+		calls++
+		switch calls {
+		case 1:
+			return testSubscribers(1, 2), nil
+		case 2, 3:
+			// The connection is lost mid-campaign.
+			return nil, lost
+		case 4:
+			downStatus = m.DBStatus()
+			return testSubscribers(3, 2), nil
+		}
+		return nil, nil
+	}
+
+	// The final count update also hits the outage once.
+	var failedCounts bool
+	st.updateCounts = func(campID, toSend, sent, lastSubID int) error {
+		mut.Lock()
+		defer mut.Unlock()
+		if !failedCounts {
+			failedCounts = true
+			return lost
+		}
+		return nil
+	}
+
+	go m.Run()
+	defer m.Close()
+
+	p, err := m.newPipe(newTestCampaign())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.nextPipes <- p
+
+	waitFor(t, 20*time.Second, func() bool { return len(st.getStatuses()) > 0 })
+
+	if s := st.getStatuses(); len(s) != 1 || s[0] != models.CampaignStatusFinished {
+		t.Fatalf("expected the campaign to finish, got statuses %v", s)
+	}
+	if n := st.getSent(); n != 4 {
+		t.Fatalf("expected 4 sent, got %d", n)
+	}
+	if p.errors.Load() != 0 {
+		t.Fatalf("expected no send errors, got %d", p.errors.Load())
+	}
+	if downStatus == nil {
+		t.Fatal("expected DBStatus() to report the lost connection during the outage")
+	}
+	if err := m.DBStatus(); err != nil {
+		t.Fatalf("expected DBStatus() to recover, got %v", err)
+	}
+}
+
+// TestDBOutageOtherErrors checks that errors other than a lost connection don't
+// trigger the backoff.
+func TestDBOutageOtherErrors(t *testing.T) {
+	var h dbHealth
+
+	m := newTestManager(Config{}, &testStore{})
+	if _, ok := h.fail(errors.New("syntax error"), m.log); ok {
+		t.Fatal("expected a query error not to back off")
+	}
+	if h.retryIn() != 0 || h.status() != nil {
+		t.Fatal("expected the connection to be healthy")
+	}
+
+	d1, ok := h.fail(ErrDBUnavailable, m.log)
+	if !ok || d1 != dbBackoffMin {
+		t.Fatalf("expected a backoff of %s, got %s", dbBackoffMin, d1)
+	}
+	d2, _ := h.fail(ErrDBUnavailable, m.log)
+	if d2 != 2*d1 {
+		t.Fatalf("expected the backoff to double, got %s", d2)
+	}
+	if h.retryIn() <= 0 || h.status() == nil {
+		t.Fatal("expected the connection to be down")
+	}
+
+	h.ok(m.log)
+	if h.retryIn() != 0 || h.status() != nil {
+		t.Fatal("expected the connection to be restored")
+	}
+}
+
+// TestRequeuePipe checks that pipes are queued again when the queue is full,
+// and that pending ones give up without panicking when the manager is closed.
+func TestRequeuePipe(t *testing.T) {
+	m := newTestManager(Config{}, &testStore{})
+	m.nextPipes = make(chan *pipe, 1)
+
+	p1, p2 := &pipe{}, &pipe{}
+	m.nextPipes <- p1
+
+	done := make(chan struct{})
+	go func() {
+		m.requeuePipe(p2, 0)
+		close(done)
+	}()
+
+	// The queue is full. p2 waits for room instead of being dropped.
+	select {
+	case <-done:
+		t.Fatal("expected requeuePipe to block on a full queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if p := <-m.nextPipes; p != p1 {
+		t.Fatal("expected the first pipe")
+	}
+	<-done
+	if p := <-m.nextPipes; p != p2 {
+		t.Fatal("expected the queued pipe")
+	}
+
+	// A delayed requeue gives up when the manager is closed.
+	m.nextPipes <- p1
+	go m.requeuePipe(p2, time.Hour)
+	go m.requeuePipe(p2, 0)
+	time.Sleep(50 * time.Millisecond)
+	m.Close()
+	time.Sleep(50 * time.Millisecond)
+}
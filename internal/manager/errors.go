@@ -0,0 +1,104 @@
+package manager
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	// Maximum number of distinct error messages sampled per campaign run.
+	maxErrSamples = 50
+
+	// Maximum number of example recipient domains per error message.
+	maxErrDomains = 5
+)
+
+// reEmail matches e-mail addresses in error messages so that the local part
+// can be masked. This also makes errors that only differ by address identical.
+var reEmail = regexp.MustCompile(`[^\s<>"'(),;:\[\]]+@([^\s<>"'(),;:\[\]]+)`)
+
+// errSamples is a bounded set of the distinct errors that occur while sending
+// a campaign. When full, the least recently seen error is evicted.
+type errSamples struct {
+	mut   sync.Mutex
+	items []models.CampaignSendError
+}
+
+// add records an error against the recipient's e-mail domain.
+func (e *errSamples) add(err error, email string) {
+	msg := reEmail.ReplaceAllString(err.Error(), "*@$1")
+	if len(msg) > 500 {
+		msg = msg[:500]
+	}
+
+	domain := ""
+	if i := strings.LastIndexByte(email, '@'); i >= 0 {
+		domain = strings.ToLower(email[i+1:])
+	}
+
+	e.mut.Lock()
+	defer e.mut.Unlock()
+
+	i := slices.IndexFunc(e.items, func(s models.CampaignSendError) bool {
+		return s.Error == msg
+	})
+
+	// New error. Evict the least recently seen one if the buffer is full.
+	if i < 0 {
+		if len(e.items) >= maxErrSamples {
+			old := 0
+			for n, s := range e.items {
+				if s.LastAt.Before(e.items[old].LastAt) {
+					old = n
+				}
+			}
+			e.items = slices.Delete(e.items, old, old+1)
+		}
+
+		e.items = append(e.items, models.CampaignSendError{Error: msg, Domains: []string{}})
+		i = len(e.items) - 1
+	}
+
+	s := &e.items[i]
+	s.Count++
+	s.LastAt = time.Now()
+	if domain != "" && len(s.Domains) < maxErrDomains && !slices.Contains(s.Domains, domain) {
+		s.Domains = append(s.Domains, domain)
+	}
+}
+
+// get returns a copy of the sampled errors sorted by their counts, most frequent first.
+func (e *errSamples) get() []models.CampaignSendError {
+	e.mut.Lock()
+	out := make([]models.CampaignSendError, len(e.items))
+	for n, s := range e.items {
+		s.Domains = slices.Clone(s.Domains)
+		out[n] = s
+	}
+	e.mut.Unlock()
+
+	slices.SortStableFunc(out, func(a, b models.CampaignSendError) int {
+		return b.Count - a.Count
+	})
+
+	return out
+}
+
+// GetCampaignErrors returns the errors sampled so far for a campaign that's
+// currently being processed. The bool is false if the campaign isn't running.
+func (m *Manager) GetCampaignErrors(campID int) ([]models.CampaignSendError, bool) {
+	m.pipesMut.RLock()
+	p, ok := m.pipes[campID]
+	m.pipesMut.RUnlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	return p.errSamples.get(), true
+}
@@ -0,0 +1,193 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+func TestErrSamples(t *testing.T) {
+	var e errSamples
+
+	// Errors that only differ by the recipient's local part aggregate, with the addresses masked.
+	for _, email := range []string{"a@gmail.com", "b@gmail.com", "c.d+e@gmail.com"} {
+		e.add(fmt.Errorf("550 5.1.1 <%s>: mailbox unavailable", email), email)
+	}
+
+	// Example domains are lowercased, deduped, and capped.
+	for _, email := range []string{"a@Gmail.com", "b@gmail.com", "c@outlook.com", "d@yahoo.com", "e@aol.com", "f@gmx.de", "g@web.de", "h@gmx.de"} {
+		e.add(errors.New("421 try again later"), email)
+	}
+	e.add(errors.New("421 try again later"), "")
+
+	out := e.get()
+	if len(out) != 2 {
+		t.Fatalf("expected 2 distinct errors, got %+v", out)
+	}
+
+	// The most frequent error is first.
+	if s := out[0]; s.Error != "421 try again later" || s.Count != 9 ||
+		!slices.Equal(s.Domains, []string{"gmail.com", "outlook.com", "yahoo.com", "aol.com", "gmx.de"}) {
+		t.Errorf("unexpected error sample %+v", s)
+	}
+	if s := out[1]; s.Error != "550 5.1.1 <*@gmail.com>: mailbox unavailable" || s.Count != 3 || !slices.Equal(s.Domains, []string{"gmail.com"}) {
+		t.Errorf("unexpected error sample %+v", s)
+	}
+
+	// The samples are copies.
+	out[0].Domains[0] = "changed"
+	if e.get()[0].Domains[0] != "gmail.com" {
+		t.Error("expected get() to return a copy")
+	}
+
+	// Long messages are truncated.
+	e.add(errors.New(strings.Repeat("x", 1000)), "a@example.com")
+	if n := len(e.get()[2].Error); n != 500 {
+		t.Errorf("expected the message to be truncated to 500, got %d", n)
+	}
+}
+
+func TestErrSamplesEviction(t *testing.T) {
+	var e errSamples
+	for i := range maxErrSamples {
+		e.add(errors.New("error "+strconv.Itoa(i)), "a@example.com")
+		time.Sleep(time.Microsecond)
+	}
+
+	// Seeing the oldest error again makes the second oldest the least recently seen.
+	e.add(errors.New("error 0"), "a@example.com")
+	time.Sleep(time.Microsecond)
+	e.add(errors.New("new"), "a@example.com")
+
+	out := e.get()
+	if len(out) != maxErrSamples {
+		t.Fatalf("expected %d samples, got %d", maxErrSamples, len(out))
+	}
+
+	var msgs []string
+	for _, s := range out {
+		msgs = append(msgs, s.Error)
+	}
+	if !slices.Contains(msgs, "error 0") || !slices.Contains(msgs, "new") || slices.Contains(msgs, "error 1") {
+		t.Errorf("expected the least recently seen error to be evicted, got %v", msgs)
+	}
+	if out[0].Error != "error 0" || out[0].Count != 2 {
+		t.Errorf("expected the repeated error first, got %+v", out[0])
+	}
+}
+
+// errMessenger fails messages to recipients prefixed with "fail" with an
+// error that has the recipient's address in it.
+type errMessenger struct {
+	testMessenger
+}
+
+func (e *errMessenger) Push(m models.Message) error {
+	if strings.HasPrefix(m.To[0], "fail") {
+		return fmt.Errorf("550 <%s>: mailbox unavailable", m.To[0])
+	}
+	return e.testMessenger.Push(m)
+}
+
+// errStore records the campaign errors that are persisted.
+type errStore struct {
+	*testStore
+
+	mut  sync.Mutex
+	errs []models.CampaignSendError
+}
+
+func (s *errStore) UpdateCampaignErrors(id int, errs []models.CampaignSendError) error {
+	s.mut.Lock()
+	s.errs = errs
+	s.mut.Unlock()
+	return nil
+}
+
+// TestCampaignErrors sends a campaign with failing recipients and checks that
+// the errors are aggregated, persisted, and included in the pause notification.
+func TestCampaignErrors(t *testing.T) {
+	const numSubs = 20
+
+	for _, maxErrs := range []int{0, 5} {
+		var (
+			st = &errStore{testStore: &testStore{}}
+			n  = &notifyLog{}
+		)
+		m := newTestManager(Config{BatchSize: numSubs, Concurrency: 2, MaxSendErrors: maxErrs}, st)
+		m.fnNotify = n.notify
+		if err := m.AddMessenger(&errMessenger{}); err != nil {
+			t.Fatal(err)
+		}
+
+		subs := testSubscribers(1, numSubs)
+		for i := range subs {
+			if i%2 == 0 {
+				subs[i].Email = "fail" + strconv.Itoa(i) + "@example.com"
+			}
+		}
+
+		var once sync.Once
+		st.nextSubscribers = func(campID, limit int) ([]models.Subscriber, error) {
+			var out []models.Subscriber
+			once.Do(func() { out = subs })
+			return out, nil
+		}
+
+		done := make(chan struct{})
+		m.fnCampStop = func(*models.Campaign) { close(done) }
+		go m.Run()
+
+		c := newTestCampaign()
+		c.Simulation = false
+		c.Messenger = "test"
+		c.ToSend = numSubs
+
+		p, err := m.newPipe(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.nextPipes <- p
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the campaign to stop")
+		}
+		m.Close()
+
+		// The failures aggregate into a single error.
+		st.mut.Lock()
+		errs := st.errs
+		st.mut.Unlock()
+		if len(errs) != 1 || errs[0].Error != "550 <*@example.com>: mailbox unavailable" || !slices.Equal(errs[0].Domains, []string{"example.com"}) {
+			t.Fatalf("max %d: unexpected persisted errors %+v", maxErrs, errs)
+		}
+		if maxErrs == 0 && errs[0].Count != numSubs/2 {
+			t.Errorf("expected %d errors, got %d", numSubs/2, errs[0].Count)
+		}
+
+		if maxErrs == 0 {
+			continue
+		}
+
+		// The pause notification has the top errors.
+		if n.len() != 1 {
+			t.Fatalf("expected a notification, got %d", n.len())
+		}
+		n.mut.Lock()
+		data := n.data[0]
+		n.mut.Unlock()
+		top, _ := data["Errors"].([]string)
+		if data["Status"] != models.CampaignStatusPaused || len(top) != 1 || !strings.HasPrefix(top[0], "550 <*@example.com>: mailbox unavailable (") {
+			t.Errorf("unexpected notification %v", data)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package manager
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// hygieneStore returns a campaign with the given status and records the
+// hygiene summaries that are generated.
+type hygieneStore struct {
+	*testStore
+
+	status string
+	err    error
+
+	mut   sync.Mutex
+	calls []int
+}
+
+func (s *hygieneStore) GetCampaign(campID int) (*models.Campaign, error) {
+	return &models.Campaign{Status: s.status}, nil
+}
+
+func (s *hygieneStore) UpdateCampaignHygiene(campID int) (models.CampaignHygiene, error) {
+	s.mut.Lock()
+	s.calls = append(s.calls, campID)
+	s.mut.Unlock()
+
+	if s.err != nil {
+		return models.CampaignHygiene{}, s.err
+	}
+	return models.CampaignHygiene{CampaignID: campID, Sent: 5, BouncesHard: 1, QualityScore: 80}, nil
+}
+
+// TestCampaignHygiene runs campaigns to the end and checks that the hygiene
+// summary is only generated for campaigns that finish and is included in
+// the notification.
+func TestCampaignHygiene(t *testing.T) {
+	const numSubs = 5
+
+	cases := []struct {
+		name      string
+		status    string
+		err       error
+		expStatus string
+		expCalls  int
+		expScore  float64
+	}{
+		{"finished", models.CampaignStatusRunning, nil, models.CampaignStatusFinished, 1, 80},
+		{"summary error", models.CampaignStatusRunning, errors.New("db error"), models.CampaignStatusFinished, 1, -1},
+		{"paused elsewhere", models.CampaignStatusPaused, nil, models.CampaignStatusPaused, 0, -1},
+	}
+	for _, c := range cases {
+		var (
+			st = &hygieneStore{testStore: &testStore{}, status: c.status, err: c.err}
+			n  = &notifyLog{}
+		)
+		m := newTestManager(Config{BatchSize: numSubs, Concurrency: 1}, st)
+		m.fnNotify = n.notify
+		if err := m.AddMessenger(&testMessenger{}); err != nil {
+			t.Fatal(err)
+		}
+
+		var once sync.Once
+		st.nextSubscribers = func(campID, limit int) ([]models.Subscriber, error) {
+			var out []models.Subscriber
+			once.Do(func() { out = testSubscribers(1, numSubs) })
+			return out, nil
+		}
+
+		done := make(chan struct{})
+		m.fnCampStop = func(*models.Campaign) { close(done) }
+		go m.Run()
+
+		camp := newTestCampaign()
+		camp.Simulation = false
+		camp.Messenger = "test"
+		camp.ToSend = numSubs
+
+		p, err := m.newPipe(camp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.nextPipes <- p
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("%s: timed out waiting for the campaign to stop", c.name)
+		}
+		m.Close()
+
+		st.mut.Lock()
+		calls := st.calls
+		st.mut.Unlock()
+		if len(calls) != c.expCalls || (c.expCalls > 0 && calls[0] != camp.ID) {
+			t.Errorf("%s: expected %d hygiene summaries, got %v", c.name, c.expCalls, calls)
+		}
+
+		if n.len() != 1 {
+			t.Fatalf("%s: expected a notification, got %d", c.name, n.len())
+		}
+		n.mut.Lock()
+		data := n.data[0]
+		n.mut.Unlock()
+
+		h, _ := data["Hygiene"].(*models.CampaignHygiene)
+		if data["Status"] != c.expStatus {
+			t.Errorf("%s: expected the status %s, got %v", c.name, c.expStatus, data["Status"])
+		}
+		if c.expScore < 0 {
+			if h != nil {
+				t.Errorf("%s: expected no summary in the notification, got %+v", c.name, h)
+			}
+		} else if h == nil || h.CampaignID != camp.ID || h.QualityScore != c.expScore {
+			t.Errorf("%s: unexpected summary in the notification %+v", c.name, h)
+		}
+	}
+}
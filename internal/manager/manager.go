@@ -1,11 +1,14 @@
 package manager
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"html/template"
 	"log"
 	"net/textproto"
+	"net/url"
+	"path"
 	"strings"
 	"sync"
 	"time"
@@ -13,8 +16,11 @@ import (
 	"maps"
 
 	"github.com/Masterminds/sprig/v3"
+	"github.com/knadh/listmonk/internal/fetcher"
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/internal/notifs"
+	"github.com/knadh/listmonk/internal/sendlimit"
+	"github.com/knadh/listmonk/internal/shortlink"
 	"github.com/knadh/listmonk/models"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
@@ -39,9 +45,28 @@ type Store interface {
 	GetAttachment(mediaID int) (models.Attachment, error)
 	UpdateCampaignStatus(campID int, status string) error
 	UpdateCampaignCounts(campID int, toSend int, sent int, lastSubID int) error
-	CreateLink(url string) (string, error)
+	UpdateCampaignErrors(campID int, errs []models.CampaignSendError) error
+	UpdateCampaignHygiene(campID int) (models.CampaignHygiene, error)
+	RollupCampaignStats(campIDs []int, settle time.Duration, limit int) (int, error)
+	DeleteStaleAttribHistory(retention time.Duration) (int, error)
+	NextSystemMessages(limit int) ([]models.SystemMessage, error)
+	RecordSystemMessageAttempt(id int, sendErr error) error
+	RecordCampaignSends(campID int, subIDs []int64, messenger string) error
+	DeleteStaleCampaignRecipients(retention time.Duration) (int, error)
+	CreateLink(url string) (string, string, error)
 	BlocklistSubscriber(id int64) error
 	DeleteSubscriber(id int64) error
+	EnrollSequenceSubscribers() error
+	NextSequenceMessages(limit int) ([]models.SequenceMessage, error)
+	RecordSequenceSends(msgs []models.SequenceMessage) error
+	ReleaseSequenceMessages(msgs []models.SequenceMessage, backoff time.Duration, maxAttempts int) error
+	PurgeUnconfirmedSubscribers(limit int, anonymize bool) (int, error)
+	DeletePendingSubscribers(graceDays, limit int) (int, error)
+	SunsetSubscribers(p models.SunsetPolicy, dryRun bool, limit int) (models.SunsetResult, error)
+	RecordSunsetNotices(campID int, subIDs []int64) error
+	GetSubscribers(ids []int64) ([]models.Subscriber, error)
+	GetTemplateAssets(tplID int) (map[string]string, error)
+	GetListDescriptions() (map[int]template.HTML, error)
 }
 
 // Messenger is an interface for a generic messaging backend,
@@ -53,20 +78,63 @@ type Messenger interface {
 	Close() error
 }
 
+// MessageSizer is an optional interface implemented by messengers that
+// limit the size of the messages they send.
+type MessageSizer interface {
+	// MaxMessageSize returns the smallest maximum message size in bytes
+	// configured on the messenger. 0 means there's no limit.
+	MaxMessageSize() int64
+
+	// MessageSize returns the size of a message in bytes as composed by the messenger.
+	MessageSize(models.Message) (int64, error)
+}
+
+// MessageSize is the estimated size of a campaign message in bytes.
+type MessageSize struct {
+	Size  int64 `json:"size"`
+	Limit int64 `json:"limit"`
+
+	// Largest possible total size of the campaign's dynamic attachments, which
+	// can't be known before they're fetched for each subscriber.
+	MaxDynamicAttachments int64 `json:"max_dynamic_attachments"`
+}
+
 // CampStats contains campaign stats like per minute send rate.
 type CampStats struct {
 	SendRate int
+
+	// Number of messages sent via each of the messenger routes.
+	Routes []models.MessengerRouteCount
+
+	// Number of failed dynamic attachment fetches.
+	AttachmentErrors int64
+
+	// Max runtime of the campaign's current run (0 if there's no limit)
+	// and the time left before it's paused.
+	MaxRuntime       time.Duration
+	RuntimeRemaining time.Duration
 }
 
 // Manager handles the scheduling, processing, and queuing of campaigns
 // and message pushes.
 type Manager struct {
 	cfg        Config
+	cfgMut     sync.RWMutex
 	store      Store
 	i18n       *i18n.I18n
 	messengers map[string]Messenger
+	simulator  Messenger
 	fnNotify   func(subject string, data any) error
-	log        *log.Logger
+	fnEvent    func(event string, data any)
+
+	// Optional callbacks invoked when a campaign starts and stops being processed.
+	fnCampStart func(c *models.Campaign)
+	fnCampStop  func(c *models.Campaign)
+
+	// Optional callback that signs the {{ MessageURL }} of a campaign message.
+	fnSignMessageURL func(u, campUUID, subUUID string) string
+
+	log *log.Logger
 
 	// Campaigns that are currently running.
 	pipes    map[int]*pipe
@@ -75,16 +143,41 @@ type Manager struct {
 	tpls    map[int]*models.Template
 	tplsMut sync.RWMutex
 
+	// Prevents concurrent sunset policy runs.
+	sunsetMut sync.Mutex
+
+	// State of the connection to the database.
+	db dbHealth
+
 	// Links generated using Track() are cached here so as to not query
 	// the database for the link UUID for every message sent. This has to
 	// be locked as it may be used externally when previewing campaigns.
-	links    map[string]string
+	links    map[string]link
 	linksMut sync.RWMutex
 
+	// Fetches the dynamic attachments of campaign messages.
+	fetcher *fetcher.Fetcher
+
 	nextPipes chan *pipe
 	campMsgQ  chan CampaignMessage
 	msgQ      chan models.Message
 
+	// closed is closed when the manager is closed. closeMut guards the pipes
+	// that are queued again from outside Run() against a closed nextPipes.
+	closed   chan struct{}
+	closeMut sync.RWMutex
+
+	// Message workers, whose number can be changed at runtime. A worker exits
+	// on receiving a signal on stopWorker.
+	numWorkers int
+	workersMut sync.Mutex
+	stopWorker chan struct{}
+
+	// Transactional messages have their own queue and workers.
+	txQ        chan txMessage
+	txStatuses *txStatuses
+	txPushMut  sync.Mutex
+
 	// Sliding window keeps track of the total number of messages sent in a period
 	// and on reaching the specified limit, waits until the window is over before
 	// sending further messages.
@@ -100,14 +193,29 @@ type CampaignMessage struct {
 	Campaign   *models.Campaign
 	Subscriber models.Subscriber
 
-	from     string
-	to       string
-	subject  string
-	body     []byte
-	altBody  []byte
-	unsubURL string
+	from        string
+	to          string
+	subject     string
+	previewText string
+	body        []byte
+	altBody     []byte
+	unsubURL    string
+	headers     textproto.MIMEHeader
+
+	// Fetched dynamic attachments of the subscriber.
+	attachments []models.Attachment
 
 	pipe *pipe
+
+	// onDone, if set, is called with the result of the push of a message that's
+	// sent outside of the campaign pipes.
+	onDone func(error)
+}
+
+// link is a registered tracking link's UUID and short link slug.
+type link struct {
+	uuid string
+	slug string
 }
 
 // Config has parameters for configuring the manager.
@@ -115,6 +223,8 @@ type Config struct {
 	// Number of subscribers to pull from the DB in a single iteration.
 	BatchSize             int
 	Concurrency           int
+	TxConcurrency         int
+	TxQueueSize           int
 	MessageRate           int
 	MaxSendErrors         int
 	SlidingWindow         bool
@@ -122,19 +232,72 @@ type Config struct {
 	SlidingWindowRate     int
 	RequeueOnError        bool
 	FromEmail             string
+	ReplyTo               string
 	IndividualTracking    bool
 	LinkTrackURL          string
+	ShortLinkURL          string
 	UnsubURL              string
 	OptinURL              string
 	MessageURL            string
+	PreviewLinkURL        string
 	ViewTrackURL          string
 	ArchiveURL            string
 	RootURL               string
 	UnsubHeader           bool
 
+	// Whether the bot filter is enabled. The send timestamps that it uses to
+	// detect bots are only appended to the tracking URLs when it is.
+	BotFilter bool
+
+	// Ordered rules that route campaign messages to messengers by the
+	// recipients' e-mail domains.
+	MessengerRoutes []models.MessengerRoute
+
+	// Duration after which a running campaign is paused. Campaigns can
+	// override it. 0 disables the limit.
+	MaxRuntime time.Duration
+
+	// Per-message latency of the no-op messenger that simulated campaigns are sent via.
+	SimulationLatency time.Duration
+
 	// Interval to scan the DB for active campaign checkpoints.
 	ScanInterval time.Duration
 
+	// Interval to scan the DB for new sequence subscribers and due sequence steps.
+	SequenceInterval time.Duration
+
+	// Interval to scan the DB for queued system e-mails that are due for a retry.
+	SystemMessageInterval time.Duration
+
+	// Interval to purge subscribers who never confirmed their double opt-in
+	// subscriptions and whether to anonymize them instead of deleting.
+	PurgeInterval        time.Duration
+	AnonymizeUnconfirmed bool
+
+	// Duration for which the recipients of ended ad-hoc campaigns are retained.
+	AdhocRecipientsRetention time.Duration
+
+	// Duration after a campaign finishes after which its stats rollup is final.
+	StatsRollupAfter time.Duration
+
+	// Duration for which the changes to tracked subscriber attributes are retained.
+	AttribHistoryRetention time.Duration
+
+	// Number of days after which subscribers who requested the deletion of
+	// their data are deleted.
+	DeletionGraceDays int
+
+	// Sorted percentages of processed messages at which campaign progress events
+	// are emitted. Campaigns can override them.
+	ProgressMilestones []int
+
+	// Options for fetching the per-subscriber dynamic attachments of campaigns.
+	AttachmentFetch fetcher.Opt
+
+	// Sunset policy for inactive subscribers and the interval to apply it.
+	Sunset         models.SunsetPolicy
+	SunsetInterval time.Duration
+
 	// ScanCampaigns indicates whether this instance of manager will scan the DB
 	// for active campaigns and process them.
 	// This can be used to run multiple instances of listmonk
@@ -156,6 +319,24 @@ func New(cfg Config, store Store, i *i18n.I18n, l *log.Logger) *Manager {
 	if cfg.MessageRate < 1 {
 		cfg.MessageRate = 1
 	}
+	if cfg.TxConcurrency < 1 {
+		cfg.TxConcurrency = 1
+	}
+	if cfg.TxQueueSize < 1 {
+		cfg.TxQueueSize = 10000
+	}
+	if cfg.SequenceInterval <= 0 {
+		cfg.SequenceInterval = time.Minute
+	}
+	if cfg.SystemMessageInterval <= 0 {
+		cfg.SystemMessageInterval = time.Second * 30
+	}
+	if cfg.PurgeInterval <= 0 {
+		cfg.PurgeInterval = time.Hour
+	}
+	if cfg.SunsetInterval <= 0 {
+		cfg.SunsetInterval = time.Hour
+	}
 
 	m := &Manager{
 		cfg:   cfg,
@@ -164,14 +345,21 @@ func New(cfg Config, store Store, i *i18n.I18n, l *log.Logger) *Manager {
 		fnNotify: func(subject string, data any) error {
 			return notifs.NotifySystem(subject, notifs.TplCampaignStatus, data, nil)
 		},
+		fnEvent:      func(event string, data any) {},
 		log:          l,
 		messengers:   make(map[string]Messenger),
+		simulator:    &simulator{latency: cfg.SimulationLatency},
 		pipes:        make(map[int]*pipe),
 		tpls:         make(map[int]*models.Template),
-		links:        make(map[string]string),
+		links:        make(map[string]link),
+		fetcher:      fetcher.New(cfg.AttachmentFetch),
 		nextPipes:    make(chan *pipe, 1000),
+		closed:       make(chan struct{}),
 		campMsgQ:     make(chan CampaignMessage, cfg.Concurrency*cfg.MessageRate*2),
 		msgQ:         make(chan models.Message, cfg.Concurrency*cfg.MessageRate*2),
+		stopWorker:   make(chan struct{}),
+		txQ:          make(chan txMessage, cfg.TxQueueSize),
+		txStatuses:   newTxStatuses(maxTxStatuses),
 		slidingStart: time.Now(),
 	}
 	m.tplFuncs = m.makeGnericFuncMap()
@@ -179,6 +367,26 @@ func New(cfg Config, store Store, i *i18n.I18n, l *log.Logger) *Manager {
 	return m
 }
 
+// SetEventHandler sets the callback that receives events emitted
+// by the manager, such as the results of periodic jobs.
+func (m *Manager) SetEventHandler(fn func(event string, data any)) {
+	m.fnEvent = fn
+}
+
+// SetCampaignHandlers sets the callbacks that are invoked when a campaign starts
+// being processed and when it stops (finished, paused, cancelled), eg: for other
+// subsystems to track running campaigns.
+func (m *Manager) SetCampaignHandlers(onStart, onStop func(c *models.Campaign)) {
+	m.fnCampStart = onStart
+	m.fnCampStop = onStop
+}
+
+// SetMessageURLSigner sets the callback that signs the {{ MessageURL }} links to
+// the per-subscriber browser views of campaign messages so that they can't be guessed.
+func (m *Manager) SetMessageURLSigner(fn func(u, campUUID, subUUID string) string) {
+	m.fnSignMessageURL = fn
+}
+
 // AddMessenger adds a Messenger messaging backend to the manager.
 func (m *Manager) AddMessenger(msg Messenger) error {
 	id := msg.Name()
@@ -206,6 +414,29 @@ func (m *Manager) PushMessage(msg models.Message) error {
 	return nil
 }
 
+// SendSeedMessage sends a campaign message to a seed address directly via the
+// campaign's messenger, bypassing the queues, and returns the result. The message
+// is marked with the seed header.
+func (m *Manager) SendSeedMessage(msg CampaignMessage) error {
+	msgr, ok := m.messengers[msg.Campaign.Messenger]
+	if !ok {
+		return fmt.Errorf("unknown messenger %s", msg.Campaign.Messenger)
+	}
+
+	// Load any media/attachments.
+	if err := m.attachMedia(msg.Campaign); err != nil {
+		return err
+	}
+	if err := m.fetchAttachments(&msg, m.logAttachmentErr(&msg)); err != nil {
+		return err
+	}
+
+	out := msg.message()
+	out.Headers.Set(models.SeedHeader, "true")
+
+	return msgr.Push(out)
+}
+
 // PushCampaignMessage pushes a campaign messages into a queue to be sent out by the workers.
 // It times out if the queue is busy.
 func (m *Manager) PushCampaignMessage(msg CampaignMessage) error {
@@ -216,6 +447,9 @@ func (m *Manager) PushCampaignMessage(msg CampaignMessage) error {
 	if err := m.attachMedia(msg.Campaign); err != nil {
 		return err
 	}
+	if err := m.fetchAttachments(&msg, m.logAttachmentErr(&msg)); err != nil {
+		return err
+	}
 
 	select {
 	case m.campMsgQ <- msg:
@@ -234,6 +468,37 @@ func (m *Manager) HasMessenger(id string) bool {
 	return ok
 }
 
+// EstimateMessageSize returns the size of a rendered campaign message with the
+// campaign's media attachments as composed by the campaign's messenger, and the
+// messenger's maximum message size. ok is false if the messenger doesn't limit
+// message sizes.
+func (m *Manager) EstimateMessageSize(msg CampaignMessage) (MessageSize, bool, error) {
+	sz, ok := m.messengers[msg.Campaign.Messenger].(MessageSizer)
+	if !ok {
+		return MessageSize{}, false, nil
+	}
+
+	out := MessageSize{Limit: sz.MaxMessageSize()}
+	if out.Limit <= 0 {
+		return MessageSize{}, false, nil
+	}
+
+	if err := m.attachMedia(msg.Campaign); err != nil {
+		return MessageSize{}, false, err
+	}
+
+	n, err := sz.MessageSize(msg.message())
+	if err != nil {
+		return MessageSize{}, false, err
+	}
+	out.Size = n
+
+	// Dynamic attachments are base64 encoded.
+	out.MaxDynamicAttachments = int64(len(msg.Campaign.DynamicAttachments)) * m.fetcher.MaxSize() * 4 / 3
+
+	return out, true, nil
+}
+
 // HasRunningCampaigns checks if there are any active campaigns.
 func (m *Manager) HasRunningCampaigns() bool {
 	m.pipesMut.Lock()
@@ -245,14 +510,53 @@ func (m *Manager) HasRunningCampaigns() bool {
 // GetCampaignStats returns campaign statistics.
 func (m *Manager) GetCampaignStats(id int) CampStats {
 	n := 0
+	routes := []models.MessengerRouteCount{}
+	var (
+		attachErrs int64
+		maxRuntime time.Duration
+		remaining  time.Duration
+	)
 
 	m.pipesMut.Lock()
 	if c, ok := m.pipes[id]; ok {
 		n = int(c.rate.Rate())
+		routes = c.getRouteCounts()
+		attachErrs = c.attachErrors.Load()
+		if maxRuntime = c.maxRuntime(); maxRuntime > 0 {
+			remaining = max(maxRuntime-time.Since(c.startedAt), 0)
+		}
 	}
 	m.pipesMut.Unlock()
 
-	return CampStats{SendRate: n}
+	return CampStats{
+		SendRate:         n,
+		Routes:           routes,
+		AttachmentErrors: attachErrs,
+		MaxRuntime:       maxRuntime,
+		RuntimeRemaining: remaining,
+	}
+}
+
+// EstimateDuration returns a rough estimate of the time it would take to send n
+// messages given the configured concurrency, message rate, and sliding window limits.
+func (m *Manager) EstimateDuration(n int) time.Duration {
+	if n < 1 {
+		return 0
+	}
+
+	cfg := m.getCfg()
+
+	// Message rate is per worker per second.
+	d := time.Duration(float64(n) / float64(cfg.Concurrency*cfg.MessageRate) * float64(time.Second))
+
+	// With the sliding window, every window_rate messages take at least one window_duration.
+	if cfg.SlidingWindow && cfg.SlidingWindowRate > 0 {
+		if w := time.Duration((n-1)/cfg.SlidingWindowRate) * cfg.SlidingWindowDuration; w > d {
+			d = w
+		}
+	}
+
+	return d
 }
 
 // Run is a blocking function (that should be invoked as a goroutine)
@@ -266,27 +570,78 @@ func (m *Manager) Run() {
 		// Periodically scan campaigns and push running campaigns to nextPipes
 		// to fetch subscribers from the campaign.
 		go m.scanCampaigns(m.cfg.ScanInterval)
+
+		// Periodically enroll new subscribers into sequences and send due steps.
+		go m.scanSequences(m.cfg.SequenceInterval)
+
+		// Periodically retry sending the system e-mails that failed to send.
+		go m.retrySystemMessages(m.cfg.SystemMessageInterval)
+
+		// Periodically purge subscribers who never confirmed their subscriptions.
+		go m.purgeUnconfirmed(m.cfg.PurgeInterval)
+
+		// Periodically delete subscribers whose deletion grace period is over.
+		if m.cfg.DeletionGraceDays > 0 {
+			go m.purgeDeletions(m.cfg.PurgeInterval)
+		}
+
+		// Periodically delete the recipients of ended ad-hoc campaigns.
+		if m.cfg.AdhocRecipientsRetention > 0 {
+			go m.purgeRecipients(m.cfg.PurgeInterval)
+		}
+
+		// Periodically delete the old changes to tracked subscriber attributes.
+		if m.cfg.AttribHistoryRetention > 0 {
+			go m.purgeAttribHistory(m.cfg.PurgeInterval)
+		}
+
+		// Periodically save the final stats rollups of finished campaigns.
+		go m.rollupStats(m.cfg.PurgeInterval)
+
+		// Periodically apply the sunset policy to inactive subscribers.
+		if m.cfg.Sunset.Enabled && m.cfg.IndividualTracking {
+			go m.scanSunset(m.cfg.SunsetInterval)
+		}
 	}
 
 	// Spawn N message workers.
-	for i := 0; i < m.cfg.Concurrency; i++ {
-		go m.worker()
+	m.resizeWorkers(m.getCfg().Concurrency)
+
+	// Spawn N tx message workers.
+	for i := 0; i < m.cfg.TxConcurrency; i++ {
+		go m.txWorker()
 	}
 
 	// Indefinitely wait on the pipe queue to fetch the next set of subscribers
 	// for any active campaigns.
 	for p := range m.nextPipes {
+		// The connection to the database is lost. Fetch after the backoff.
+		if d := m.db.retryIn(); d > 0 {
+			go m.requeuePipe(p, d)
+			continue
+		}
+
 		has, err := p.NextSubscribers()
 		if err != nil {
+			// The connection to the database is lost. Keep the campaign alive and
+			// retry fetching after backing off instead of dropping it.
+			if d, ok := m.db.fail(err, m.log); ok {
+				go m.requeuePipe(p, d)
+				continue
+			}
+
 			m.log.Printf("error processing campaign batch (%s): %v", p.camp.Name, err)
 			continue
 		}
+		m.db.ok(m.log)
 
 		if has {
-			// There are more subscribers to fetch. Queue again.
+			// There are more subscribers to fetch. Queue again. If the queue is
+			// full, wait for room outside this loop, which is its only consumer.
 			select {
 			case m.nextPipes <- p:
 			default:
+				go m.requeuePipe(p, 0)
 			}
 		} else {
 			// The pipe is created with a +1 on the waitgroup pseudo counter
@@ -303,6 +658,45 @@ func (m *Manager) Run() {
 	}
 }
 
+// requeuePipe queues a pipe again after a delay, blocking until there's room
+// in the queue or the manager is closed.
+func (m *Manager) requeuePipe(p *pipe, delay time.Duration) {
+	m.requeue(delay, func() {
+		select {
+		case m.nextPipes <- p:
+		case <-m.closed:
+		}
+	})
+}
+
+// requeue calls push, which queues a pipe or a message again, after a delay
+// unless the manager is closed in the meantime. The queues are open while push
+// runs, and push should give up on m.closed if its queue is full.
+func (m *Manager) requeue(delay time.Duration, push func()) {
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+		case <-m.closed:
+			return
+		}
+	}
+
+	m.closeMut.RLock()
+	defer m.closeMut.RUnlock()
+
+	// The queues are closed after closed, so they're still open here.
+	select {
+	case <-m.closed:
+		return
+	default:
+	}
+
+	push()
+}
+
 // CacheTpl caches a template for ad-hoc use. This is currently only used by tx templates.
 func (m *Manager) CacheTpl(id int, tpl *models.Template) {
 	m.tplsMut.Lock()
@@ -333,23 +727,51 @@ func (m *Manager) GetTpl(id int) (*models.Template, error) {
 // TemplateFuncs returns the template functions to be applied into
 // compiled campaign templates.
 func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
+	// Load the template's assets once so that {{ Asset }} doesn't hit the DB per message.
+	assets := map[string]string{}
+	if c != nil && c.TemplateID.Valid {
+		a, err := m.store.GetTemplateAssets(c.TemplateID.Int)
+		if err != nil {
+			m.log.Printf("error fetching assets of template %d: %v", c.TemplateID.Int, err)
+		} else {
+			assets = a
+		}
+	}
+
+	var (
+		descs    map[int]template.HTML
+		descErr  error
+		descOnce sync.Once
+	)
+
 	f := template.FuncMap{
 		"TrackLink": func(url string, msg *CampaignMessage) string {
-			subUUID := msg.Subscriber.UUID
+			// Links are left untouched when tracking is disabled for the campaign.
+			if msg.Campaign.TrackingMode == models.CampaignTrackingModeNone {
+				return url
+			}
+
+			subID, subUUID := msg.Subscriber.ID, msg.Subscriber.UUID
 			if !m.cfg.IndividualTracking {
-				subUUID = dummyUUID
+				subID, subUUID = 0, dummyUUID
 			}
 
-			return m.trackLink(url, msg.Campaign.UUID, subUUID)
+			return m.trackLink(url, msg.Campaign, subID, subUUID)
 		},
 		"TrackView": func(msg *CampaignMessage) template.HTML {
+			// The view pixel is only injected when views are tracked for the campaign.
+			if msg.Campaign.TrackingMode == models.CampaignTrackingModeClicksOnly ||
+				msg.Campaign.TrackingMode == models.CampaignTrackingModeNone {
+				return ""
+			}
+
 			subUUID := msg.Subscriber.UUID
 			if !m.cfg.IndividualTracking {
 				subUUID = dummyUUID
 			}
 
 			return template.HTML(fmt.Sprintf(`<img src="%s" alt="" />`,
-				fmt.Sprintf(m.cfg.ViewTrackURL, msg.Campaign.UUID, subUUID)))
+				m.withSendTime(fmt.Sprintf(m.cfg.ViewTrackURL, msg.Campaign.UUID, subUUID), time.Now())))
 		},
 		"UnsubscribeURL": func(msg *CampaignMessage) string {
 			return msg.unsubURL
@@ -363,7 +785,7 @@ func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
 			return fmt.Sprintf(m.cfg.OptinURL, msg.Subscriber.UUID, "")
 		},
 		"MessageURL": func(msg *CampaignMessage) string {
-			return fmt.Sprintf(m.cfg.MessageURL, c.UUID, msg.Subscriber.UUID)
+			return m.messageURL(c.UUID, msg.Subscriber.UUID)
 		},
 		"ArchiveURL": func() string {
 			return m.cfg.ArchiveURL
@@ -371,6 +793,28 @@ func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
 		"RootURL": func() string {
 			return m.cfg.RootURL
 		},
+		"Asset": func(name string) (string, error) {
+			u, ok := assets[name]
+			if !ok {
+				return "", fmt.Errorf("unknown template asset '%s'", name)
+			}
+			return u, nil
+		},
+		"ListDescription": func(id int) (template.HTML, error) {
+			// The descriptions are only fetched if the template uses them.
+			descOnce.Do(func() {
+				descs, descErr = m.store.GetListDescriptions()
+				if descErr != nil {
+					m.log.Printf("error fetching list descriptions: %v", descErr)
+				}
+			})
+			if descErr != nil {
+				return "", descErr
+			}
+
+			// Unknown (eg: deleted) lists have no description.
+			return descs[id], nil
+		},
 	}
 
 	maps.Copy(f, m.tplFuncs)
@@ -378,6 +822,64 @@ func (m *Manager) TemplateFuncs(c *models.Campaign) template.FuncMap {
 	return f
 }
 
+// PreviewTemplateFuncs returns the campaign template functions for previewing
+// messages with real subscriber data. Tracking, unsubscription, and other
+// subscriber links are disarmed and point to the no-op preview link page so
+// that clicking them records no stats and changes nothing. The view pixel
+// is omitted.
+func (m *Manager) PreviewTemplateFuncs(c *models.Campaign) template.FuncMap {
+	disarm := func(u string) string {
+		return fmt.Sprintf(m.cfg.PreviewLinkURL, url.QueryEscape(u))
+	}
+
+	f := m.TemplateFuncs(c)
+	f["TrackLink"] = func(u string, msg *CampaignMessage) string {
+		return disarm(u)
+	}
+	f["TrackView"] = func(msg *CampaignMessage) template.HTML {
+		return ""
+	}
+	f["UnsubscribeURL"] = func(msg *CampaignMessage) string {
+		return disarm(msg.unsubURL)
+	}
+	f["ManageURL"] = func(msg *CampaignMessage) string {
+		return disarm(msg.unsubURL + "?manage=true")
+	}
+	f["OptinURL"] = func(msg *CampaignMessage) string {
+		return disarm(fmt.Sprintf(m.cfg.OptinURL, msg.Subscriber.UUID, ""))
+	}
+	f["MessageURL"] = func(msg *CampaignMessage) string {
+		return disarm(m.messageURL(c.UUID, msg.Subscriber.UUID))
+	}
+
+	return f
+}
+
+// ViewTemplateFuncs returns the campaign template functions for rendering a
+// message in the browser for its recipient. Links aren't tracked and the view
+// pixel is omitted so that viewing the message records no stats.
+func (m *Manager) ViewTemplateFuncs(c *models.Campaign) template.FuncMap {
+	f := m.TemplateFuncs(c)
+	f["TrackLink"] = func(u string, msg *CampaignMessage) string {
+		return u
+	}
+	f["TrackView"] = func(msg *CampaignMessage) template.HTML {
+		return ""
+	}
+
+	return f
+}
+
+// messageURL returns the signed URL of the browser view of a campaign message.
+func (m *Manager) messageURL(campUUID, subUUID string) string {
+	u := fmt.Sprintf(m.cfg.MessageURL, campUUID, subUUID)
+	if m.fnSignMessageURL == nil {
+		return u
+	}
+
+	return m.fnSignMessageURL(u, campUUID, subUUID)
+}
+
 func (m *Manager) GenericTemplateFuncs() template.FuncMap {
 	return m.tplFuncs
 }
@@ -393,8 +895,14 @@ func (m *Manager) StopCampaign(id int) {
 
 // Close closes and exits the campaign manager.
 func (m *Manager) Close() {
+	close(m.closed)
+
+	// Wait for the pipes and messages being queued again to give up.
+	m.closeMut.Lock()
 	close(m.nextPipes)
 	close(m.msgQ)
+	close(m.txQ)
+	m.closeMut.Unlock()
 }
 
 // scanCampaigns is a blocking function that periodically scans the data source
@@ -406,12 +914,23 @@ func (m *Manager) scanCampaigns(tick time.Duration) {
 
 	// Periodically scan the data source for campaigns to process.
 	for range t.C {
+		// The connection to the database is lost. Wait for the next retry.
+		if m.db.waiting() {
+			continue
+		}
+
+		// Pause the campaigns that have been running for too long.
+		m.checkRuntimes()
+
 		ids, counts := m.getCurrentCampaigns()
 		campaigns, err := m.store.NextCampaigns(ids, counts)
 		if err != nil {
-			m.log.Printf("error fetching campaigns: %v", err)
+			if _, ok := m.db.fail(err, m.log); !ok {
+				m.log.Printf("error fetching campaigns: %v", err)
+			}
 			continue
 		}
+		m.db.ok(m.log)
 
 		for _, c := range campaigns {
 			// Create a new pipe that'll handle this campaign's states.
@@ -422,12 +941,13 @@ func (m *Manager) scanCampaigns(tick time.Duration) {
 			}
 			m.log.Printf("start processing campaign (%s)", c.Name)
 
-			// If subscriber processing is busy, move on. Blocking and waiting
-			// can end up in a race condition where the waiting campaign's
-			// state in the data source has changed.
+			// If subscriber processing is busy, queue the pipe from a goroutine
+			// instead of blocking the scan. The pipe is already registered and
+			// wouldn't be picked up by the later scans if it were dropped.
 			select {
 			case m.nextPipes <- p:
 			default:
+				go m.requeuePipe(p, 0)
 			}
 		}
 	}
@@ -440,6 +960,10 @@ func (m *Manager) worker() {
 	numMsg := 0
 	for {
 		select {
+		// The worker pool has been shrunk.
+		case <-m.stopWorker:
+			return
+
 		// Campaign message.
 		case msg, ok := <-m.campMsgQ:
 			if !ok {
@@ -454,62 +978,60 @@ func (m *Manager) worker() {
 			}
 
 			// Pause on hitting the message rate.
-			if numMsg >= m.cfg.MessageRate {
+			if numMsg >= m.getCfg().MessageRate {
 				time.Sleep(time.Second)
 				numMsg = 0
 			}
 			numMsg++
 
-			// Outgoing message.
-			out := models.Message{
-				From:        msg.from,
-				To:          []string{msg.to},
-				Subject:     msg.subject,
-				ContentType: msg.Campaign.ContentType,
-				Body:        msg.body,
-				AltBody:     msg.altBody,
-				Subscriber:  msg.Subscriber,
-				Campaign:    msg.Campaign,
-				Attachments: msg.Campaign.Attachments,
-			}
-
-			h := textproto.MIMEHeader{}
-			h.Set(models.EmailHeaderCampaignUUID, msg.Campaign.UUID)
-			h.Set(models.EmailHeaderSubscriberUUID, msg.Subscriber.UUID)
-
-			// Attach List-Unsubscribe headers?
-			if m.cfg.UnsubHeader {
-				h.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
-				h.Set("List-Unsubscribe", `<`+msg.unsubURL+`>`)
+			// Push the message to the campaign's messenger or the one it's routed to.
+			// Simulated campaigns are pushed to the no-op simulator.
+			var (
+				msgr  string
+				route = -1
+				err   error
+			)
+			if msg.Campaign.Simulation {
+				msgr = m.simulator.Name()
+				err = m.simulator.Push(msg.message())
+			} else {
+				msgr, route = m.route(msg)
+				err = m.messengers[msgr].Push(msg.message())
 			}
 
-			// Attach any custom headers.
-			if len(msg.Campaign.Headers) > 0 {
-				for _, set := range msg.Campaign.Headers {
-					for hdr, val := range set {
-						h.Add(hdr, val)
+			// The messenger's servers are at their per-minute sending limits. Queue
+			// the message again once they free up instead of holding up the worker
+			// or counting it as an error.
+			var rateErr *sendlimit.ErrRateLimit
+			if errors.As(err, &rateErr) {
+				go m.requeue(rateErr.RetryAfter, func() {
+					select {
+					case m.campMsgQ <- msg:
+					case <-m.closed:
 					}
-				}
+				})
+				continue
 			}
 
-			// Set the headers.
-			out.Headers = h
-
-			// Push the message to the messenger.
-			err := m.messengers[msg.Campaign.Messenger].Push(out)
 			if err != nil {
 				m.log.Printf("error sending message in campaign %s: subscriber %d: %v", msg.Campaign.Name, msg.Subscriber.ID, err)
 			}
 
 			// Increment the send rate or the error counter if there was an error.
 			if msg.pipe != nil {
-				// Mark the message as done.
-				msg.pipe.wg.Done()
-
-				if err != nil {
+				// Track progress before marking the message as done so that the
+				// milestones are emitted before the campaign's cleanup.
+				msg.pipe.onProcessed(err)
+
+				var limErr *sendlimit.ErrDailyLimit
+				if errors.As(err, &limErr) {
+					// The messenger's servers have run out of their daily quota.
+					// Pause the campaign instead of erroring out every message.
+					msg.pipe.OnLimit(err, msg.Subscriber.Email)
+				} else if err != nil {
 					// Call the error callback, which keeps track of the error count
 					// and stops the campaign if the error count exceeds the threshold.
-					msg.pipe.OnError()
+					msg.pipe.OnError(err, msg.Subscriber.Email)
 				} else {
 					id := uint64(msg.Subscriber.ID)
 					if id > msg.pipe.lastID.Load() {
@@ -517,7 +1039,19 @@ func (m *Manager) worker() {
 					}
 					msg.pipe.rate.Incr(1)
 					msg.pipe.sent.Add(1)
+					msg.pipe.recordSend(msg.Subscriber.ID, msgr)
+					if route >= 0 {
+						msg.pipe.routed[route].Add(1)
+					}
 				}
+
+				// Mark the message as done only after it's been counted and
+				// recorded, as the last one triggers the campaign's cleanup,
+				// which saves the counts and flushes the send history.
+				msg.pipe.wg.Done()
+			}
+			if msg.onDone != nil {
+				msg.onDone(err)
 			}
 
 		// Arbitrary message.
@@ -527,7 +1061,19 @@ func (m *Manager) worker() {
 			}
 
 			// Push the message to the messenger.
-			if err := m.messengers[msg.Messenger].Push(msg); err != nil {
+			err := m.messengers[msg.Messenger].Push(msg)
+
+			var rateErr *sendlimit.ErrRateLimit
+			if errors.As(err, &rateErr) {
+				go m.requeue(rateErr.RetryAfter, func() {
+					select {
+					case m.msgQ <- msg:
+					case <-m.closed:
+					}
+				})
+				continue
+			}
+			if err != nil {
 				m.log.Printf("error sending message '%s': %v", msg.Subject, err)
 			}
 		}
@@ -558,44 +1104,68 @@ func (m *Manager) getCurrentCampaigns() ([]int64, []int64) {
 }
 
 // trackLink register a URL and return its UUID to be used in message templates
-// for tracking links.
-func (m *Manager) trackLink(url, campUUID, subUUID string) string {
+// for tracking links. Campaigns with short links get the short form of the URL
+// with the send timestamp in its ref.
+func (m *Manager) trackLink(url string, camp *models.Campaign, subID int, subUUID string) string {
 	url = strings.ReplaceAll(url, "&amp;", "&")
 
 	m.linksMut.RLock()
-	if uu, ok := m.links[url]; ok {
-		m.linksMut.RUnlock()
-		return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
-	}
+	l, ok := m.links[url]
 	m.linksMut.RUnlock()
 
-	// Register link.
-	uu, err := m.store.CreateLink(url)
-	if err != nil {
-		m.log.Printf("error registering tracking for link '%s': %v", url, err)
+	if !ok {
+		// Register link.
+		uu, slug, err := m.store.CreateLink(url)
+		if err != nil {
+			m.log.Printf("error registering tracking for link '%s': %v", url, err)
+
+			// If the registration fails, fail over to the original URL.
+			return url
+		}
 
-		// If the registration fails, fail over to the original URL.
-		return url
+		l = link{uuid: uu, slug: slug}
+		m.linksMut.Lock()
+		m.links[url] = l
+		m.linksMut.Unlock()
 	}
 
-	m.linksMut.Lock()
-	m.links[url] = uu
-	m.linksMut.Unlock()
+	now := time.Now()
+	if camp.ShortLinks && l.slug != "" {
+		return fmt.Sprintf(m.cfg.ShortLinkURL, l.slug, shortlink.MakeRef(l.slug, camp.ID, camp.UUID, subID, subUUID, now))
+	}
 
-	return fmt.Sprintf(m.cfg.LinkTrackURL, uu, campUUID, subUUID)
+	return m.withSendTime(fmt.Sprintf(m.cfg.LinkTrackURL, l.uuid, camp.UUID, subUUID), now)
 }
 
-// sendNotif sends a notification to registered admin e-mails.
-func (m *Manager) sendNotif(c *models.Campaign, status, reason string) error {
+// withSendTime appends the send timestamp to a tracking URL if the bot filter is
+// enabled, which uses it to detect bots that fetch the URL right after delivery.
+func (m *Manager) withSendTime(u string, t time.Time) string {
+	if !m.getCfg().BotFilter {
+		return u
+	}
+
+	return fmt.Sprintf("%s?t=%d", u, t.Unix())
+}
+
+// sendNotif sends a notification to registered admin e-mails. The most frequent
+// send errors and the bounce hygiene summary, if any, are included in it.
+func (m *Manager) sendNotif(c *models.Campaign, status, reason string, errs []models.CampaignSendError, hygiene *models.CampaignHygiene) error {
+	top := []string{}
+	for _, e := range errs[:min(len(errs), 3)] {
+		top = append(top, fmt.Sprintf("%s (%d)", e.Error, e.Count))
+	}
+
 	var (
 		subject = fmt.Sprintf("%s: %s", cases.Title(language.Und).String(status), c.Name)
 		data    = map[string]any{
-			"ID":     c.ID,
-			"Name":   c.Name,
-			"Status": status,
-			"Sent":   c.Sent,
-			"ToSend": c.ToSend,
-			"Reason": reason,
+			"ID":      c.ID,
+			"Name":    c.Name,
+			"Status":  status,
+			"Sent":    c.Sent,
+			"ToSend":  c.ToSend,
+			"Reason":  reason,
+			"Errors":  top,
+			"Hygiene": hygiene,
 		}
 	)
 
@@ -647,6 +1217,72 @@ func (m *Manager) attachMedia(c *models.Campaign) error {
 	return nil
 }
 
+// fetchAttachments renders the URLs and filenames of the campaign's dynamic attachments
+// for the message's subscriber and fetches them. A failed attachment is left out of the
+// message if its on_error is send, and an error is returned if it's skip, in which case
+// the message shouldn't be sent. onErr is called with every fetch error.
+func (m *Manager) fetchAttachments(msg *CampaignMessage, onErr func(error)) error {
+	for _, a := range msg.Campaign.DynamicAttachmentTpls {
+		att, err := m.fetchAttachment(a, msg)
+		if err != nil {
+			onErr(err)
+			if a.OnError == models.AttachmentOnErrorSend {
+				continue
+			}
+			return err
+		}
+
+		msg.attachments = append(msg.attachments, att)
+	}
+
+	return nil
+}
+
+// fetchAttachment renders and fetches a single dynamic attachment.
+func (m *Manager) fetchAttachment(a models.DynamicAttachmentTpl, msg *CampaignMessage) (models.Attachment, error) {
+	var b bytes.Buffer
+	if err := a.URL.ExecuteTemplate(&b, models.ContentTpl, msg); err != nil {
+		return models.Attachment{}, fmt.Errorf("error rendering attachment URL: %v", err)
+	}
+	u := strings.TrimSpace(b.String())
+
+	b.Reset()
+	if err := a.Filename.ExecuteTemplate(&b, models.ContentTpl, msg); err != nil {
+		return models.Attachment{}, fmt.Errorf("error rendering attachment filename: %v", err)
+	}
+	name := strings.TrimSpace(b.String())
+
+	// Don't log the whole URL as it may have per-subscriber secrets.
+	host := u
+	if p, err := url.Parse(u); err == nil {
+		host = p.Host
+		if name == "" {
+			name = path.Base(p.Path)
+		}
+	}
+	if name == "" || name == "." || name == "/" {
+		name = "attachment"
+	}
+
+	f, err := m.fetcher.Fetch(u)
+	if err != nil {
+		return models.Attachment{}, fmt.Errorf("error fetching attachment from %s: %v", host, err)
+	}
+
+	return models.Attachment{
+		Name:    name,
+		Header:  MakeAttachmentHeader(name, "base64", f.ContentType),
+		Content: f.Content,
+	}, nil
+}
+
+// logAttachmentErr returns a fetchAttachments error callback that logs the errors.
+func (m *Manager) logAttachmentErr(msg *CampaignMessage) func(error) {
+	return func(err error) {
+		m.log.Printf("error attaching file to message (%s) (%s): %v", msg.Campaign.Name, msg.Subscriber.Email, err)
+	}
+}
+
 // MakeAttachmentHeader is a helper function that returns a
 // textproto.MIMEHeader tailored for attachments, primarily
 // email. If no encoding is given, base64 is assumed.
@@ -0,0 +1,177 @@
+package manager
+
+import (
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// testStore is an in-memory Store. Methods that a test doesn't override with
+// the func fields panic as the embedded Store is nil.
+type testStore struct {
+	Store
+
+	mut      sync.Mutex
+	statuses []string
+	sent     int
+
+	nextSubscribers func(campID, limit int) ([]models.Subscriber, error)
+	updateCounts    func(campID, toSend, sent, lastSubID int) error
+}
+
+func (s *testStore) NextSubscribers(campID, limit int) ([]models.Subscriber, error) {
+	return s.nextSubscribers(campID, limit)
+}
+
+func (s *testStore) UpdateCampaignCounts(campID, toSend, sent, lastSubID int) error {
+	if s.updateCounts != nil {
+		if err := s.updateCounts(campID, toSend, sent, lastSubID); err != nil {
+			return err
+		}
+	}
+
+	s.mut.Lock()
+	s.sent = sent
+	s.mut.Unlock()
+	return nil
+}
+
+func (s *testStore) UpdateCampaignStatus(campID int, status string) error {
+	s.mut.Lock()
+	s.statuses = append(s.statuses, status)
+	s.mut.Unlock()
+	return nil
+}
+
+func (s *testStore) GetCampaign(campID int) (*models.Campaign, error) {
+	return &models.Campaign{Status: models.CampaignStatusRunning}, nil
+}
+
+func (s *testStore) UpdateCampaignErrors(int, []models.CampaignSendError) error { return nil }
+
+func (s *testStore) UpdateCampaignHygiene(int) (models.CampaignHygiene, error) {
+	return models.CampaignHygiene{}, nil
+}
+
+func (s *testStore) RollupCampaignStats([]int, time.Duration, int) (int, error) { return 0, nil }
+
+func (s *testStore) RecordCampaignSends(int, []int64, string) error { return nil }
+
+func (s *testStore) getSent() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.sent
+}
+
+func (s *testStore) getStatuses() []string {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]string{}, s.statuses...)
+}
+
+func newTestManager(cfg Config, st Store) *Manager {
+	if cfg.MessageRate == 0 {
+		cfg.MessageRate = 1000
+	}
+	m := New(cfg, st, nil, log.New(io.Discard, "", 0))
+	m.fnNotify = func(string, any) error { return nil }
+
+	return m
+}
+
+func newTestCampaign() *models.Campaign {
+	c := &models.Campaign{
+		Name:        "test",
+		Subject:     "Hello",
+		Body:        "Hello {{ .Subscriber.Name }}",
+		ContentType: models.CampaignContentTypeRichtext,
+		Status:      models.CampaignStatusRunning,
+		Simulation:  true,
+	}
+	c.ID = 1
+
+	return c
+}
+
+func testSubscribers(from, n int) []models.Subscriber {
+	out := make([]models.Subscriber, n)
+	for i := range out {
+		out[i].ID = from + i
+		out[i].Email = "sub@example.com"
+	}
+	return out
+}
+
+// waitFor polls fn until it returns true or the timeout expires.
+func waitFor(t *testing.T, timeout time.Duration, fn func() bool) {
+	t.Helper()
+
+	end := time.Now().Add(timeout)
+	for time.Now().Before(end) {
+		if fn() {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for the condition")
+}
+
+// TestCampaignHandlers checks that the campaign handlers are invoked once when a
+// campaign starts being processed and once when it stops.
+func TestCampaignHandlers(t *testing.T) {
+	st := &testStore{}
+	m := newTestManager(Config{BatchSize: 100, Concurrency: 2}, st)
+
+	var once sync.Once
+	st.nextSubscribers = func(campID, limit int) ([]models.Subscriber, error) {
+		var out []models.Subscriber
+		once.Do(func() { out = testSubscribers(1, 10) })
+		return out, nil
+	}
+
+	var (
+		mut    sync.Mutex
+		events []string
+		done   = make(chan struct{})
+	)
+	m.SetCampaignHandlers(func(c *models.Campaign) {
+		mut.Lock()
+		events = append(events, "start:"+c.UUID)
+		mut.Unlock()
+	}, func(c *models.Campaign) {
+		mut.Lock()
+		events = append(events, "stop:"+c.UUID)
+		mut.Unlock()
+		close(done)
+	})
+
+	go m.Run()
+	defer m.Close()
+
+	c := newTestCampaign()
+	c.UUID = "camp-uuid"
+	p, err := m.newPipe(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.nextPipes <- p
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the campaign to end")
+	}
+
+	mut.Lock()
+	defer mut.Unlock()
+	if len(events) != 2 || events[0] != "start:camp-uuid" || events[1] != "stop:camp-uuid" {
+		t.Errorf("unexpected events %v", events)
+	}
+	if n := st.getSent(); n != 10 {
+		t.Errorf("expected 10 messages, got %d", n)
+	}
+}
@@ -3,10 +3,27 @@ package manager
 import (
 	"bytes"
 	"fmt"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
 
 	"github.com/knadh/listmonk/models"
 )
 
+// preheaderTpl is the hidden snippet that e-mail clients show as the preview text
+// after the subject. The trailing padding of zero-width non-joiners and spaces
+// stops clients from filling the preview with the text that follows in the body.
+const preheaderTpl = `<div style="display:none;font-size:1px;line-height:1px;max-height:0;max-width:0;opacity:0;overflow:hidden;mso-hide:all;">%s%s</div>`
+
+var (
+	preheaderPad = strings.Repeat("&zwnj;&nbsp;", 100)
+
+	regexpBodyTag = regexp.MustCompile(`(?i)<body(\s[^>]*)?>`)
+)
+
 // NewCampaignMessage creates and returns a CampaignMessage that is made available
 // to message templates while they're compiled. It represents a message from
 // a campaign that's bound to a single Subscriber.
@@ -24,10 +41,64 @@ func (m *Manager) NewCampaignMessage(c *models.Campaign, s models.Subscriber) (C
 	if err := msg.render(); err != nil {
 		return msg, err
 	}
+	msg.headers = m.makeHeaders(c, s, msg.unsubURL)
 
 	return msg, nil
 }
 
+// messageIDDomain returns the domain for the Message-Ids of messages from the given
+// address: the address's domain, or the root URL's host if it can't be parsed.
+func (m *Manager) messageIDDomain(from string) string {
+	if a, err := mail.ParseAddress(from); err == nil {
+		if i := strings.LastIndex(a.Address, "@"); i >= 0 && i < len(a.Address)-1 {
+			return a.Address[i+1:]
+		}
+	}
+
+	if u, err := url.Parse(m.cfg.RootURL); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+
+	return "localhost"
+}
+
+// makeHeaders returns the headers for a campaign message. Custom campaign headers
+// are added last so that messengers see them along with the core headers. The
+// messenger's own headers (eg: SMTP level headers) are applied before these.
+func (m *Manager) makeHeaders(c *models.Campaign, s models.Subscriber, unsubURL string) textproto.MIMEHeader {
+	h := textproto.MIMEHeader{}
+	h.Set(models.EmailHeaderCampaignUUID, c.UUID)
+	h.Set(models.EmailHeaderSubscriberUUID, s.UUID)
+
+	// The Message-Id embeds the UUIDs so that replies can be attributed.
+	h.Set(models.EmailHeaderMessageId, models.MakeMessageID(c.UUID, s.UUID, m.messageIDDomain(c.FromEmail)))
+
+	// Attach List-Unsubscribe headers?
+	if m.cfg.UnsubHeader {
+		h.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+		h.Set("List-Unsubscribe", `<`+unsubURL+`>`)
+	}
+
+	// Attach any custom headers.
+	for _, set := range c.Headers {
+		for hdr, val := range set {
+			h.Add(hdr, val)
+		}
+	}
+
+	// A Reply-To in the custom headers takes precedence over the campaign's
+	// Reply-To, which in turn takes precedence over the global one.
+	if h.Get("Reply-To") == "" {
+		if c.ReplyTo != "" {
+			h.Set("Reply-To", c.ReplyTo)
+		} else if m.cfg.ReplyTo != "" {
+			h.Set("Reply-To", m.cfg.ReplyTo)
+		}
+	}
+
+	return h
+}
+
 // render takes a Message, executes its pre-compiled Campaign.Tpl
 // and applies the resultant bytes to Message.body to be used in messages.
 func (m *CampaignMessage) render() error {
@@ -48,6 +119,20 @@ func (m *CampaignMessage) render() error {
 	}
 	m.body = out.Bytes()
 
+	// Inject the preview text into HTML bodies. It's left out of the plain text
+	// alternative.
+	if m.Campaign.PreviewTextTpl != nil {
+		b := bytes.Buffer{}
+		if err := m.Campaign.PreviewTextTpl.ExecuteTemplate(&b, models.ContentTpl, m); err != nil {
+			return err
+		}
+		m.previewText = strings.TrimSpace(b.String())
+
+		if m.previewText != "" && m.Campaign.ContentType != models.CampaignContentTypePlain {
+			m.body = injectPreheader(m.body, fmt.Sprintf(preheaderTpl, m.previewText, preheaderPad))
+		}
+	}
+
 	// Is there an alt body?
 	if m.Campaign.ContentType != models.CampaignContentTypePlain && m.Campaign.AltBody.Valid {
 		if m.Campaign.AltBodyTpl != nil {
@@ -64,11 +149,32 @@ func (m *CampaignMessage) render() error {
 	return nil
 }
 
+// injectPreheader inserts the preheader snippet at the top of the body, right
+// after the <body> tag if there's one.
+func injectPreheader(body []byte, snippet string) []byte {
+	out := make([]byte, 0, len(body)+len(snippet))
+
+	loc := regexpBodyTag.FindIndex(body)
+	if loc == nil {
+		out = append(out, snippet...)
+		return append(out, body...)
+	}
+
+	out = append(out, body[:loc[1]]...)
+	out = append(out, snippet...)
+	return append(out, body[loc[1]:]...)
+}
+
 // Subject returns a copy of the message subject
 func (m *CampaignMessage) Subject() string {
 	return m.subject
 }
 
+// PreviewText returns the rendered preview text of the message.
+func (m *CampaignMessage) PreviewText() string {
+	return m.previewText
+}
+
 // Body returns a copy of the message body.
 func (m *CampaignMessage) Body() []byte {
 	out := make([]byte, len(m.body))
@@ -76,9 +182,46 @@ func (m *CampaignMessage) Body() []byte {
 	return out
 }
 
+// Headers returns a copy of the message headers.
+func (m *CampaignMessage) Headers() textproto.MIMEHeader {
+	out := make(textproto.MIMEHeader, len(m.headers))
+	for k, v := range m.headers {
+		out[k] = append([]string(nil), v...)
+	}
+	return out
+}
+
+// UnsubscribeURL returns the subscriber's unsubscribe URL for the message.
+func (m *CampaignMessage) UnsubscribeURL() string {
+	return m.unsubURL
+}
+
 // AltBody returns a copy of the message's alt body.
 func (m *CampaignMessage) AltBody() []byte {
 	out := make([]byte, len(m.altBody))
 	copy(out, m.altBody)
 	return out
 }
+
+// message returns the outgoing message to be pushed to a messenger.
+func (m *CampaignMessage) message() models.Message {
+	// Clip the campaign's attachments so that appending the subscriber's
+	// doesn't write to the shared backing array.
+	atts := m.Campaign.Attachments
+	if len(m.attachments) > 0 {
+		atts = append(slices.Clip(atts), m.attachments...)
+	}
+
+	return models.Message{
+		From:        m.from,
+		To:          []string{m.to},
+		Subject:     m.subject,
+		ContentType: m.Campaign.ContentType,
+		Body:        m.body,
+		AltBody:     m.altBody,
+		Subscriber:  m.Subscriber,
+		Campaign:    m.Campaign,
+		Attachments: atts,
+		Headers:     m.Headers(),
+	}
+}
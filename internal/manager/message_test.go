@@ -0,0 +1,274 @@
+package manager
+
+import (
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+func TestMakeHeaders(t *testing.T) {
+	const unsubURL = "https://example.com/unsub"
+
+	cases := []struct {
+		name    string
+		cfg     Config
+		replyTo string
+		headers models.Headers
+		exp     map[string][]string
+	}{
+		{
+			name: "core",
+			exp:  map[string][]string{"Reply-To": nil, "List-Unsubscribe": nil},
+		},
+		{
+			name: "unsubscribe header",
+			cfg:  Config{UnsubHeader: true},
+			exp: map[string][]string{
+				"List-Unsubscribe":      {"<" + unsubURL + ">"},
+				"List-Unsubscribe-Post": {"List-Unsubscribe=One-Click"},
+			},
+		},
+		{
+			name:    "custom headers",
+			headers: models.Headers{{"X-Campaign-Type": "promo"}, {"x-tag": "a"}, {"X-Tag": "b"}},
+			exp:     map[string][]string{"X-Campaign-Type": {"promo"}, "X-Tag": {"a", "b"}},
+		},
+		{
+			name: "global reply-to",
+			cfg:  Config{ReplyTo: "global@example.com"},
+			exp:  map[string][]string{"Reply-To": {"global@example.com"}},
+		},
+		{
+			name:    "campaign reply-to over global",
+			cfg:     Config{ReplyTo: "global@example.com"},
+			replyTo: "camp@example.com",
+			exp:     map[string][]string{"Reply-To": {"camp@example.com"}},
+		},
+		{
+			name:    "custom reply-to over campaign and global",
+			cfg:     Config{ReplyTo: "global@example.com"},
+			replyTo: "camp@example.com",
+			headers: models.Headers{{"Reply-To": "custom@example.com"}},
+			exp:     map[string][]string{"Reply-To": {"custom@example.com"}},
+		},
+	}
+	for _, c := range cases {
+		m := newTestManager(c.cfg, &testStore{})
+
+		camp := newTestCampaign()
+		camp.UUID = "camp-uuid"
+		camp.FromEmail = "Sender <sender@example.org>"
+		camp.ReplyTo = c.replyTo
+		camp.Headers = c.headers
+
+		h := m.makeHeaders(camp, models.Subscriber{UUID: "sub-uuid"}, unsubURL)
+
+		// The core headers are always set.
+		if h.Get(models.EmailHeaderCampaignUUID) != "camp-uuid" || h.Get(models.EmailHeaderSubscriberUUID) != "sub-uuid" {
+			t.Errorf("%s: unexpected UUID headers %v", c.name, h)
+		}
+		if id := h.Get(models.EmailHeaderMessageId); !strings.HasPrefix(id, "<lm.camp-uuid.sub-uuid.") || !strings.HasSuffix(id, "@example.org>") {
+			t.Errorf("%s: unexpected Message-Id %s", c.name, id)
+		}
+
+		for k, v := range c.exp {
+			if got := h[textproto.CanonicalMIMEHeaderKey(k)]; !reflect.DeepEqual(got, v) {
+				t.Errorf("%s: expected %s %v, got %v", c.name, k, v, got)
+			}
+		}
+	}
+}
+
+// TestCampaignMessageReplyTo checks that the Reply-To is on the messages that
+// are pushed to every messenger.
+func TestCampaignMessageReplyTo(t *testing.T) {
+	m := newTestManager(Config{ReplyTo: "global@example.com"}, &testStore{})
+
+	for _, replyTo := range []string{"", "Replies <camp@example.com>"} {
+		camp := newTestCampaign()
+		camp.ReplyTo = replyTo
+		if err := camp.CompileTemplate(m.TemplateFuncs(camp)); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := m.NewCampaignMessage(camp, models.Subscriber{UUID: "sub-uuid", Email: "sub@example.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := replyTo
+		if exp == "" {
+			exp = "global@example.com"
+		}
+		if got := msg.message().Headers["Reply-To"]; !reflect.DeepEqual(got, []string{exp}) {
+			t.Errorf("expected Reply-To %s, got %v", exp, got)
+		}
+	}
+}
+
+// assetStore serves a template's assets from a map that can be changed
+// between compilations.
+type assetStore struct {
+	*testStore
+
+	assets map[int]map[string]string
+	calls  int
+}
+
+func (s *assetStore) GetTemplateAssets(tplID int) (map[string]string, error) {
+	s.calls++
+	return s.assets[tplID], nil
+}
+
+func TestTemplateAssets(t *testing.T) {
+	st := &assetStore{testStore: &testStore{}, assets: map[int]map[string]string{
+		1: {"logo.png": "https://example.com/uploads/logo.png"},
+	}}
+	m := newTestManager(Config{}, st)
+
+	render := func(c *models.Campaign) (string, error) {
+		t.Helper()
+		if err := c.CompileTemplate(m.TemplateFuncs(c)); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := m.NewCampaignMessage(c, models.Subscriber{UUID: "sub-uuid", Name: "User"})
+		if err != nil {
+			return "", err
+		}
+		return string(msg.Body()), nil
+	}
+
+	c := newTestCampaign()
+	c.TemplateID = null.IntFrom(1)
+	c.Body = `<img src="{{ Asset "logo.png" }}" />`
+
+	body, err := render(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != `<img src="https://example.com/uploads/logo.png" />` {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	// Replacing the asset's media changes the output without changing the body.
+	st.assets[1]["logo.png"] = "https://example.com/uploads/logo-v2.png"
+	if body, err := render(c); err != nil || body != `<img src="https://example.com/uploads/logo-v2.png" />` {
+		t.Errorf("expected the replaced asset, got %s: %v", body, err)
+	}
+
+	// The assets are fetched once per compilation, not per message.
+	if st.calls != 2 {
+		t.Errorf("expected the assets to be fetched twice, got %d", st.calls)
+	}
+
+	// Renaming the asset breaks the references to the old name.
+	st.assets[1] = map[string]string{"brand.png": "https://example.com/uploads/logo-v2.png"}
+	if _, err := render(c); err == nil || !strings.Contains(err.Error(), "unknown template asset 'logo.png'") {
+		t.Errorf("expected an unknown asset error, got %v", err)
+	}
+	c.Body = `<img src="{{ Asset "brand.png" }}" />`
+	if body, err := render(c); err != nil || body != `<img src="https://example.com/uploads/logo-v2.png" />` {
+		t.Errorf("expected the renamed asset, got %s: %v", body, err)
+	}
+
+	// Campaigns without a template have no assets.
+	st.calls = 0
+	c.TemplateID = null.Int{}
+	if _, err := render(c); err == nil {
+		t.Error("expected an unknown asset error without a template")
+	}
+	if st.calls != 0 {
+		t.Errorf("expected no asset lookups without a template, got %d", st.calls)
+	}
+}
+
+func TestInjectPreheader(t *testing.T) {
+	const snippet = `<div>pre</div>`
+
+	cases := []struct {
+		body string
+		exp  string
+	}{
+		{`<p>Hello</p>`, `<div>pre</div><p>Hello</p>`},
+		{`<html><body><p>Hello</p></body></html>`, `<html><body><div>pre</div><p>Hello</p></body></html>`},
+		{`<html><BODY class="x" style="margin:0"><p>Hello</p></BODY></html>`, `<html><BODY class="x" style="margin:0"><div>pre</div><p>Hello</p></BODY></html>`},
+		{`<html><bodyx><p>Hello</p></html>`, `<div>pre</div><html><bodyx><p>Hello</p></html>`},
+	}
+	for _, c := range cases {
+		if got := string(injectPreheader([]byte(c.body), snippet)); got != c.exp {
+			t.Errorf("%s: expected %s, got %s", c.body, c.exp, got)
+		}
+	}
+}
+
+// TestPreviewText renders campaigns with a preview text and checks the
+// injected preheader markup.
+func TestPreviewText(t *testing.T) {
+	m := newTestManager(Config{}, &testStore{})
+
+	render := func(c *models.Campaign) CampaignMessage {
+		t.Helper()
+		if err := c.CompileTemplate(m.TemplateFuncs(c)); err != nil {
+			t.Fatal(err)
+		}
+		msg, err := m.NewCampaignMessage(c, models.Subscriber{UUID: "sub-uuid", Name: "Jane <J>"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg
+	}
+
+	c := newTestCampaign()
+	c.TemplateBody = `<html><body style="margin:0">{{ template "content" . }}</body></html>`
+	c.Body = `<p>Hello</p>`
+	c.PreviewText = `  Hi {{ .Subscriber.Name }}, news inside  `
+	c.AltBody = null.StringFrom("Hello")
+
+	// The preview text is rendered as a template, escaped, and injected right after the <body> tag.
+	msg := render(c)
+	exp := `<html><body style="margin:0">` +
+		`<div style="display:none;font-size:1px;line-height:1px;max-height:0;max-width:0;opacity:0;overflow:hidden;mso-hide:all;">` +
+		`Hi Jane &lt;J&gt;, news inside` + strings.Repeat("&zwnj;&nbsp;", 100) + `</div>` +
+		`<p>Hello</p></body></html>`
+	if got := string(msg.Body()); got != exp {
+		t.Errorf("unexpected body:\nexpected %s\ngot      %s", exp, got)
+	}
+	if msg.PreviewText() != "Hi Jane &lt;J&gt;, news inside" {
+		t.Errorf("unexpected preview text %q", msg.PreviewText())
+	}
+
+	// It's left out of the plain text alternative.
+	if string(msg.AltBody()) != "Hello" {
+		t.Errorf("expected the alt body without the preview text, got %s", msg.AltBody())
+	}
+
+	// Plain text campaigns don't get the markup.
+	c.ContentType = models.CampaignContentTypePlain
+	c.Body = "Hello"
+	msg = render(c)
+	if body := string(msg.Body()); strings.Contains(body, "display:none") {
+		t.Errorf("expected no preheader in a plain text body, got %s", body)
+	}
+
+	// Neither do campaigns without a preview text or with one that renders empty.
+	c.ContentType = models.CampaignContentTypeRichtext
+	c.Body = `<p>Hello</p>`
+	for _, p := range []string{"", `{{ if false }}hidden{{ end }}`} {
+		c.PreviewText = p
+		c.PreviewTextTpl = nil
+		msg := render(c)
+		if body := string(msg.Body()); body != `<html><body style="margin:0"><p>Hello</p></body></html>` {
+			t.Errorf("%q: expected no preheader, got %s", p, body)
+		}
+	}
+
+	// Invalid template expressions fail the compilation like the subject's.
+	c.PreviewText = `{{ .Subscriber.Name `
+	if err := c.CompileTemplate(m.TemplateFuncs(c)); err == nil {
+		t.Error("expected an invalid preview text to fail")
+	}
+}
@@ -20,13 +20,40 @@ type pipe struct {
 	stopped    atomic.Bool
 	withErrors atomic.Bool
 
+	// The campaign was stopped as the daily sending limits of its messenger's
+	// servers were reached.
+	limited atomic.Bool
+
+	// The campaign was paused for exceeding its max runtime.
+	timedOut atomic.Bool
+
+	// Distinct send errors with their counts for diagnostics.
+	errSamples errSamples
+
+	// Buffered per-subscriber send history.
+	sends sendLog
+
+	// Number of messages sent via each of the messenger routes (Config.MessengerRoutes).
+	routed []atomic.Int64
+
+	// Number of failed dynamic attachment fetches.
+	attachErrors atomic.Int64
+
+	// Progress milestones (sorted percentages), the index of the next one to be
+	// crossed, and the total sent and failed counts of the campaign.
+	milestones    []int
+	nextMilestone atomic.Int32
+	totalSent     atomic.Int64
+	failed        atomic.Int64
+	startedAt     time.Time
+
 	m *Manager
 }
 
 // newPipe adds a campaign to the process queue.
 func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
-	// Validate messenger.
-	if _, ok := m.messengers[c.Messenger]; !ok {
+	// Validate messenger. Simulated campaigns aren't sent via their messengers.
+	if _, ok := m.messengers[c.Messenger]; !ok && !c.Simulation {
 		m.store.UpdateCampaignStatus(c.ID, models.CampaignStatusCancelled)
 		return nil, fmt.Errorf("unknown messenger %s on campaign %s", c.Messenger, c.Name)
 	}
@@ -47,7 +74,10 @@ func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
 		rate: ratecounter.NewRateCounter(time.Minute),
 		wg:   &sync.WaitGroup{},
 		m:    m,
+
+		routed: make([]atomic.Int64, len(m.cfg.MessengerRoutes)),
 	}
+	p.initProgress()
 
 	// Increment the waitgroup so that Wait() blocks immediately. This is necessary
 	// as a campaign pipe is created first and subscribers/messages under it are
@@ -66,6 +96,11 @@ func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
 	m.pipesMut.Lock()
 	m.pipes[c.ID] = p
 	m.pipesMut.Unlock()
+
+	if m.fnCampStart != nil {
+		m.fnCampStart(c)
+	}
+
 	return p, nil
 }
 
@@ -75,9 +110,10 @@ func (m *Manager) newPipe(c *models.Campaign) (*pipe, error) {
 // have been processed, or that a campaign has been paused or cancelled.
 func (p *pipe) NextSubscribers() (bool, error) {
 	// Fetch the next batch of subscribers from a 'running' campaign.
-	subs, err := p.m.store.NextSubscribers(p.camp.ID, p.m.cfg.BatchSize)
+	cfg := p.m.getCfg()
+	subs, err := p.m.store.NextSubscribers(p.camp.ID, cfg.BatchSize)
 	if err != nil {
-		return false, fmt.Errorf("error fetching campaign subscribers (%s): %v", p.camp.Name, err)
+		return false, fmt.Errorf("error fetching campaign subscribers (%s): %w", p.camp.Name, err)
 	}
 
 	// There are no subscribers from the query. Either all subscribers on the campaign
@@ -87,46 +123,50 @@ func (p *pipe) NextSubscribers() (bool, error) {
 	}
 
 	// Is there a sliding window limit configured?
-	hasSliding := p.m.cfg.SlidingWindow &&
-		p.m.cfg.SlidingWindowRate > 0 &&
-		p.m.cfg.SlidingWindowDuration.Seconds() > 1
+	hasSliding := cfg.SlidingWindow &&
+		cfg.SlidingWindowRate > 0 &&
+		cfg.SlidingWindowDuration.Seconds() > 1
+
+	// Messages are rendered one at a time unless the campaign has dynamic
+	// attachments, in which case they're rendered and their attachments
+	// fetched concurrently in chunks.
+	chunk := 1
+	if len(p.camp.DynamicAttachmentTpls) > 0 {
+		chunk = p.m.fetcher.Concurrency()
+	}
 
 	// Push messages.
-	for _, s := range subs {
-		msg, err := p.newMessage(s)
-		if err != nil {
-			p.m.log.Printf("error rendering message (%s) (%s): %v", p.camp.Name, s.Email, err)
-			continue
-		}
-
-		// Push the message to the queue while blocking and waiting until
-		// the queue is drained.
-		p.m.campMsgQ <- msg
-
-		// Check if the sliding window is active.
-		if hasSliding {
-			diff := time.Since(p.m.slidingStart)
-
-			// Window has expired. Reset the clock.
-			if diff >= p.m.cfg.SlidingWindowDuration {
-				p.m.slidingStart = time.Now()
-				p.m.slidingCount = 0
-				continue
-			}
-
-			// Have the messages exceeded the limit?
-			p.m.slidingCount++
-			if p.m.slidingCount >= p.m.cfg.SlidingWindowRate {
-				wait := p.m.cfg.SlidingWindowDuration - diff
-
-				p.m.log.Printf("messages exceeded (%d) for the window (%v since %s). Sleeping for %s.",
-					p.m.slidingCount,
-					p.m.cfg.SlidingWindowDuration,
-					p.m.slidingStart.Format(time.RFC822Z),
-					wait.Round(time.Second)*1)
-
-				p.m.slidingCount = 0
-				time.Sleep(wait)
+	for n := 0; n < len(subs); n += chunk {
+		for _, msg := range p.newMessages(subs[n:min(n+chunk, len(subs))]) {
+			// Push the message to the queue while blocking and waiting until
+			// the queue is drained.
+			p.m.campMsgQ <- msg
+
+			// Check if the sliding window is active.
+			if hasSliding {
+				diff := time.Since(p.m.slidingStart)
+
+				// Window has expired. Reset the clock.
+				if diff >= cfg.SlidingWindowDuration {
+					p.m.slidingStart = time.Now()
+					p.m.slidingCount = 0
+					continue
+				}
+
+				// Have the messages exceeded the limit?
+				p.m.slidingCount++
+				if p.m.slidingCount >= cfg.SlidingWindowRate {
+					wait := cfg.SlidingWindowDuration - diff
+
+					p.m.log.Printf("messages exceeded (%d) for the window (%v since %s). Sleeping for %s.",
+						p.m.slidingCount,
+						cfg.SlidingWindowDuration,
+						p.m.slidingStart.Format(time.RFC822Z),
+						wait.Round(time.Second)*1)
+
+					p.m.slidingCount = 0
+					time.Sleep(wait)
+				}
 			}
 		}
 	}
@@ -134,9 +174,11 @@ func (p *pipe) NextSubscribers() (bool, error) {
 	return true, nil
 }
 
-// OnError keeps track of the number of errors that occur while sending messages
-// and pauses the campaign if the error threshold is met.
-func (p *pipe) OnError() {
+// OnError keeps track of the number and samples of errors that occur while sending
+// messages and pauses the campaign if the error threshold is met.
+func (p *pipe) OnError(err error, email string) {
+	p.errSamples.add(err, email)
+
 	if p.m.cfg.MaxSendErrors < 1 {
 		return
 	}
@@ -151,6 +193,21 @@ func (p *pipe) OnError() {
 	p.m.log.Printf("error count exceeded %d. pausing campaign %s", p.m.cfg.MaxSendErrors, p.camp.Name)
 }
 
+// OnLimit records a message that couldn't be sent as the daily sending limits
+// of the messenger's servers were reached and pauses the campaign. It isn't
+// counted as an error.
+func (p *pipe) OnLimit(err error, email string) {
+	p.errSamples.add(err, email)
+
+	if p.stopped.Load() {
+		return
+	}
+
+	p.limited.Store(true)
+	p.Stop(false)
+	p.m.log.Printf("daily sending limit reached. pausing campaign %s", p.camp.Name)
+}
+
 // Stop "marks" a campaign as stopped. It doesn't actually stop the processing
 // of messages. That happens when every queued message in the campaign is processed,
 // marking .wg, the waitgroup counter as done. That triggers cleanup().
@@ -167,15 +224,63 @@ func (p *pipe) Stop(withErrors bool) {
 	p.stopped.Store(true)
 }
 
+// newMessages returns the campaign messages of the given subscribers in order,
+// leaving out the ones that fail to render. More than one subscriber are rendered
+// concurrently.
+func (p *pipe) newMessages(subs []models.Subscriber) []CampaignMessage {
+	msgs := make([]CampaignMessage, len(subs))
+	ok := make([]bool, len(subs))
+
+	render := func(i int) {
+		msg, err := p.newMessage(subs[i])
+		if err != nil {
+			p.m.log.Printf("error rendering message (%s) (%s): %v", p.camp.Name, subs[i].Email, err)
+			return
+		}
+		msgs[i], ok[i] = msg, true
+	}
+
+	if len(subs) == 1 {
+		render(0)
+	} else {
+		var wg sync.WaitGroup
+		for i := range subs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				render(i)
+			}()
+		}
+		wg.Wait()
+	}
+
+	out := make([]CampaignMessage, 0, len(subs))
+	for i, msg := range msgs {
+		if ok[i] {
+			out = append(out, msg)
+		}
+	}
+
+	return out
+}
+
 // newMessage returns a campaign message while internally incrementing the
 // number of messages in the pipe wait group so that the status of every
-// message can be atomically tracked.
+// message can be atomically tracked. Failed dynamic attachment fetches are
+// recorded in the campaign's errors.
 func (p *pipe) newMessage(s models.Subscriber) (CampaignMessage, error) {
 	msg, err := p.m.NewCampaignMessage(p.camp, s)
 	if err != nil {
 		return msg, err
 	}
 
+	if err := p.m.fetchAttachments(&msg, func(err error) {
+		p.attachErrors.Add(1)
+		p.errSamples.add(err, s.Email)
+	}); err != nil {
+		return msg, err
+	}
+
 	msg.pipe = p
 	p.wg.Add(1)
 
@@ -190,22 +295,44 @@ func (p *pipe) cleanup() {
 		p.m.pipesMut.Lock()
 		delete(p.m.pipes, p.camp.ID)
 		p.m.pipesMut.Unlock()
+
+		if p.m.fnCampStop != nil {
+			p.m.fnCampStop(p.camp)
+		}
 	}()
 
-	// Update campaign's 'sent count.
-	if err := p.m.store.UpdateCampaignCounts(p.camp.ID, 0, int(p.sent.Load()), int(p.lastID.Load())); err != nil {
+	p.flushSends()
+
+	// Update campaign's 'sent count. If the connection to the database is lost,
+	// wait for it to be restored so that the campaign resumes from where it was.
+	if err := p.m.retryDB(func() error {
+		return p.m.store.UpdateCampaignCounts(p.camp.ID, 0, int(p.sent.Load()), int(p.lastID.Load()))
+	}); err != nil {
 		p.m.log.Printf("error updating campaign counts (%s): %v", p.camp.Name, err)
 	}
 
-	// The campaign was auto-paused due to errors.
-	if p.withErrors.Load() {
+	// Persist the sampled send errors, if any, so that they outlive the logs.
+	errs := p.errSamples.get()
+	if len(errs) > 0 {
+		if err := p.m.store.UpdateCampaignErrors(p.camp.ID, errs); err != nil {
+			p.m.log.Printf("error saving campaign errors (%s): %v", p.camp.Name, err)
+		}
+	}
+
+	// The campaign was auto-paused due to errors or sending limits.
+	if p.withErrors.Load() || p.limited.Load() {
+		reason := "Too many errors"
+		if p.limited.Load() {
+			reason = "Daily sending limit reached"
+		}
+
 		if err := p.m.store.UpdateCampaignStatus(p.camp.ID, models.CampaignStatusPaused); err != nil {
 			p.m.log.Printf("error updating campaign (%s) status to %s: %v", p.camp.Name, models.CampaignStatusPaused, err)
 		} else {
 			p.m.log.Printf("set campaign (%s) to %s", p.camp.Name, models.CampaignStatusPaused)
 		}
 
-		_ = p.m.sendNotif(p.camp, models.CampaignStatusPaused, "Too many errors")
+		_ = p.m.sendNotif(p.camp, models.CampaignStatusPaused, reason, errs, nil)
 		return
 	}
 
@@ -217,24 +344,45 @@ func (p *pipe) cleanup() {
 
 	// Campaign wasn't manually stopped and subscribers were naturally exhausted.
 	// Fetch the up-to-date campaign status from the DB.
-	c, err := p.m.store.GetCampaign(p.camp.ID)
-	if err != nil {
+	var c *models.Campaign
+	if err := p.m.retryDB(func() error {
+		var err error
+		c, err = p.m.store.GetCampaign(p.camp.ID)
+		return err
+	}); err != nil {
 		p.m.log.Printf("error fetching campaign (%s) for ending: %v", p.camp.Name, err)
 		return
 	}
 
 	// If a running campaign has exhausted subscribers, it's finished.
+	var hygiene *models.CampaignHygiene
 	if c.Status == models.CampaignStatusRunning || c.Status == models.CampaignStatusScheduled {
 		c.Status = models.CampaignStatusFinished
-		if err := p.m.store.UpdateCampaignStatus(p.camp.ID, models.CampaignStatusFinished); err != nil {
+		if err := p.m.retryDB(func() error {
+			return p.m.store.UpdateCampaignStatus(p.camp.ID, models.CampaignStatusFinished)
+		}); err != nil {
 			p.m.log.Printf("error finishing campaign (%s): %v", p.camp.Name, err)
 		} else {
 			p.m.log.Printf("campaign (%s) finished", p.camp.Name)
+			p.emitProgress(0, models.CampaignStatusFinished)
+
+			// Generate and save the bounce hygiene summary of the finished campaign.
+			if h, err := p.m.store.UpdateCampaignHygiene(p.camp.ID); err != nil {
+				p.m.log.Printf("error generating campaign (%s) hygiene: %v", p.camp.Name, err)
+			} else {
+				hygiene = &h
+			}
+
+			// Save the stats rollup of the finished campaign. It's final once
+			// it's recomputed after the settling period by rollupStats().
+			if _, err := p.m.store.RollupCampaignStats([]int{p.camp.ID}, p.m.cfg.StatsRollupAfter, 1); err != nil {
+				p.m.log.Printf("error saving campaign (%s) stats rollup: %v", p.camp.Name, err)
+			}
 		}
 	} else {
 		p.m.log.Printf("finish processing campaign (%s)", p.camp.Name)
 	}
 
 	// Notify admin.
-	_ = p.m.sendNotif(c, c.Status, "")
+	_ = p.m.sendNotif(c, c.Status, "", errs, hygiene)
 }
@@ -0,0 +1,85 @@
+package manager
+
+import (
+	"slices"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// initProgress sets up the progress milestones of a pipe, skipping the ones that
+// a resumed campaign has already crossed.
+func (p *pipe) initProgress() {
+	p.startedAt = time.Now()
+	p.totalSent.Store(int64(p.camp.Sent))
+
+	ms := p.m.cfg.ProgressMilestones
+	if p.camp.ProgressMilestones != nil {
+		ms = make([]int, 0, len(p.camp.ProgressMilestones))
+		for _, v := range p.camp.ProgressMilestones {
+			ms = append(ms, int(v))
+		}
+	}
+	ms = slices.Clone(ms)
+	slices.Sort(ms)
+	p.milestones = slices.Compact(ms)
+
+	pct := p.percent(p.camp.Sent)
+	n := 0
+	for n < len(p.milestones) && p.milestones[n] <= pct {
+		n++
+	}
+	p.nextMilestone.Store(int32(n))
+}
+
+// onProcessed records the outcome of a processed message and emits a progress
+// event for every milestone that the processed count crosses. Each milestone is
+// emitted exactly once even though workers call this concurrently.
+func (p *pipe) onProcessed(err error) {
+	var processed int
+	if err != nil {
+		processed = int(p.totalSent.Load() + p.failed.Add(1))
+	} else {
+		processed = int(p.totalSent.Add(1) + p.failed.Load())
+	}
+
+	if len(p.milestones) == 0 || p.camp.ToSend < 1 {
+		return
+	}
+
+	pct := p.percent(processed)
+	for {
+		n := p.nextMilestone.Load()
+		if int(n) >= len(p.milestones) || p.milestones[n] > pct {
+			return
+		}
+
+		if p.nextMilestone.CompareAndSwap(n, n+1) {
+			p.emitProgress(p.milestones[n], models.CampaignStatusRunning)
+		}
+	}
+}
+
+// percent returns the percentage of the campaign's messages that have been processed.
+func (p *pipe) percent(processed int) int {
+	if p.camp.ToSend < 1 {
+		return 0
+	}
+	return min(processed*100/p.camp.ToSend, 100)
+}
+
+// emitProgress emits a campaign progress event.
+func (p *pipe) emitProgress(milestone int, status string) {
+	p.m.fnEvent(models.EventCampaignProgress, models.CampaignProgress{
+		CampaignID:   p.camp.ID,
+		CampaignUUID: p.camp.UUID,
+		Name:         p.camp.Name,
+		Status:       status,
+		Milestone:    milestone,
+		ToSend:       p.camp.ToSend,
+		Sent:         int(p.totalSent.Load()),
+		Errored:      int(p.failed.Load()),
+		Rate:         p.rate.Rate(),
+		Elapsed:      int(time.Since(p.startedAt).Seconds()),
+	})
+}
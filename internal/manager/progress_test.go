@@ -0,0 +1,176 @@
+package manager
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+	"github.com/paulbellamy/ratecounter"
+)
+
+// progressEvents records the campaign progress events emitted by a manager.
+type progressEvents struct {
+	mut    sync.Mutex
+	events []models.CampaignProgress
+}
+
+func (p *progressEvents) handle(event string, data any) {
+	if event != models.EventCampaignProgress {
+		return
+	}
+
+	p.mut.Lock()
+	p.events = append(p.events, data.(models.CampaignProgress))
+	p.mut.Unlock()
+}
+
+// counts returns the number of running events per milestone and the number of
+// finished events.
+func (p *progressEvents) counts() (map[int]int, int) {
+	p.mut.Lock()
+	defer p.mut.Unlock()
+
+	var (
+		out      = map[int]int{}
+		finished int
+	)
+	for _, e := range p.events {
+		if e.Status == models.CampaignStatusFinished {
+			finished++
+			continue
+		}
+		out[e.Milestone]++
+	}
+	return out, finished
+}
+
+func TestOnProcessedConcurrent(t *testing.T) {
+	const (
+		toSend  = 1000
+		workers = 16
+	)
+
+	cases := []struct {
+		name       string
+		sent       int
+		milestones []int
+		exp        []int
+	}{
+		{"new", 0, []int{25, 50, 75, 100}, []int{25, 50, 75, 100}},
+		{"unsorted and duplicate", 0, []int{50, 1, 50, 99, 100}, []int{1, 50, 99, 100}},
+		{"resumed", 300, []int{10, 25, 50, 75, 100}, []int{50, 75, 100}},
+	}
+	for _, c := range cases {
+		for range 20 {
+			var ev progressEvents
+			m := newTestManager(Config{ProgressMilestones: c.milestones}, &testStore{})
+			m.SetEventHandler(ev.handle)
+
+			camp := newTestCampaign()
+			camp.ToSend = toSend
+			camp.Sent = c.sent
+
+			p := &pipe{camp: camp, rate: ratecounter.NewRateCounter(time.Minute), wg: &sync.WaitGroup{}, m: m}
+			p.initProgress()
+
+			// Process the remaining messages from concurrent workers, with some errors.
+			var (
+				wg   sync.WaitGroup
+				left = toSend - c.sent
+			)
+			for w := range workers {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for i := w; i < left; i += workers {
+						var err error
+						if i%7 == 0 {
+							err = errors.New("send failed")
+						}
+						p.onProcessed(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			got, _ := ev.counts()
+			if len(got) != len(c.exp) {
+				t.Fatalf("%s: expected milestones %v, got %v", c.name, c.exp, got)
+			}
+			for _, ms := range c.exp {
+				if got[ms] != 1 {
+					t.Fatalf("%s: expected milestone %d once, got %d", c.name, ms, got[ms])
+				}
+			}
+
+			if n := int(p.totalSent.Load() + p.failed.Load()); n != toSend {
+				t.Fatalf("%s: expected %d processed, got %d", c.name, toSend, n)
+			}
+		}
+	}
+}
+
+// TestProgressEvents runs campaigns with concurrent workers and checks that
+// every milestone and the finish are emitted exactly once.
+func TestProgressEvents(t *testing.T) {
+	const numSubs = 400
+
+	for i := range 5 {
+		var ev progressEvents
+		st := &testStore{}
+		m := newTestManager(Config{BatchSize: 50, Concurrency: 16, ProgressMilestones: []int{10, 25, 50, 75, 100}}, st)
+		m.SetEventHandler(ev.handle)
+		if err := m.AddMessenger(&testMessenger{}); err != nil {
+			t.Fatal(err)
+		}
+
+		subs := testSubscribers(1, numSubs)
+		for n := range subs {
+			if n%9 == 0 {
+				subs[n].Email = "fail" + strconv.Itoa(n) + "@example.com"
+			}
+		}
+
+		var once sync.Once
+		st.nextSubscribers = func(campID, limit int) ([]models.Subscriber, error) {
+			var out []models.Subscriber
+			once.Do(func() { out = subs })
+			return out, nil
+		}
+
+		done := make(chan struct{})
+		m.fnCampStop = func(*models.Campaign) { close(done) }
+		go m.Run()
+
+		c := newTestCampaign()
+		c.Simulation = false
+		c.Messenger = "test"
+		c.ToSend = numSubs
+
+		p, err := m.newPipe(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.nextPipes <- p
+
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for the campaign to finish")
+		}
+		m.Close()
+
+		got, finished := ev.counts()
+		for _, ms := range []int{10, 25, 50, 75, 100} {
+			if got[ms] != 1 {
+				t.Fatalf("run %d: expected milestone %d once, got %v", i, ms, got)
+			}
+		}
+		if len(got) != 5 || finished != 1 {
+			t.Fatalf("run %d: expected 5 milestones and 1 finish, got %v and %d", i, got, finished)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package manager
+
+import (
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// purgeUnconfirmed is a blocking function that periodically deletes (or anonymizes)
+// subscribers who never confirmed their double opt-in subscriptions within the
+// purge threshold of the lists. Subscribers are purged in batches and an event
+// with the number of purged subscribers is emitted after every run that purges any.
+func (m *Manager) purgeUnconfirmed(tick time.Duration) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	action := models.PurgeActionDelete
+	if m.cfg.AnonymizeUnconfirmed {
+		action = models.PurgeActionAnonymize
+	}
+
+	for range t.C {
+		total := 0
+		for {
+			n, err := m.store.PurgeUnconfirmedSubscribers(m.getCfg().BatchSize, m.cfg.AnonymizeUnconfirmed)
+			if err != nil {
+				m.log.Printf("error purging unconfirmed subscribers: %v", err)
+				break
+			}
+			total += n
+
+			if n < m.getCfg().BatchSize {
+				break
+			}
+		}
+
+		if total == 0 {
+			continue
+		}
+
+		m.log.Printf("purged (%s) %d unconfirmed subscribers", action, total)
+		m.fnEvent(models.EventUnconfirmedPurged, map[string]any{
+			"action": action,
+			"count":  total,
+		})
+	}
+}
+
+// purgeDeletions is a blocking function that periodically deletes, in batches,
+// the subscribers who requested the deletion of their data longer than the
+// grace period ago and didn't cancel.
+func (m *Manager) purgeDeletions(tick time.Duration) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	for range t.C {
+		total := 0
+		for {
+			n, err := m.store.DeletePendingSubscribers(m.cfg.DeletionGraceDays, m.getCfg().BatchSize)
+			if err != nil {
+				m.log.Printf("error deleting subscribers pending deletion: %v", err)
+				break
+			}
+			total += n
+
+			if n < m.getCfg().BatchSize {
+				break
+			}
+		}
+
+		if total > 0 {
+			m.log.Printf("deleted %d subscribers after the deletion grace period", total)
+		}
+	}
+}
+
+// purgeRecipients is a blocking function that periodically deletes the ad-hoc
+// recipients of campaigns that finished or were cancelled longer than the
+// retention period ago.
+func (m *Manager) purgeRecipients(tick time.Duration) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	for range t.C {
+		n, err := m.store.DeleteStaleCampaignRecipients(m.cfg.AdhocRecipientsRetention)
+		if err != nil {
+			m.log.Printf("error deleting ad-hoc campaign recipients: %v", err)
+			continue
+		}
+
+		if n > 0 {
+			m.log.Printf("deleted %d recipients of ended ad-hoc campaigns", n)
+		}
+	}
+}
+
+// purgeAttribHistory is a blocking function that periodically deletes the
+// changes to tracked subscriber attributes that are older than the retention period.
+func (m *Manager) purgeAttribHistory(tick time.Duration) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	for range t.C {
+		n, err := m.store.DeleteStaleAttribHistory(m.cfg.AttribHistoryRetention)
+		if err != nil {
+			m.log.Printf("error deleting subscriber attribute history: %v", err)
+			continue
+		}
+
+		if n > 0 {
+			m.log.Printf("deleted %d old subscriber attribute changes", n)
+		}
+	}
+}
+
+// rollupStats is a blocking function that periodically saves, in batches, the stats
+// rollups of finished campaigns that don't have one yet, eg: of campaigns that finished
+// before rollups existed, and the final rollups of the campaigns whose stats have
+// settled since they finished.
+func (m *Manager) rollupStats(tick time.Duration) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	for range t.C {
+		total := 0
+		for {
+			n, err := m.store.RollupCampaignStats(nil, m.cfg.StatsRollupAfter, m.getCfg().BatchSize)
+			if err != nil {
+				m.log.Printf("error saving campaign stats rollups: %v", err)
+				break
+			}
+			total += n
+
+			if n < m.getCfg().BatchSize {
+				break
+			}
+		}
+
+		if total > 0 {
+			m.log.Printf("saved the stats rollups of %d campaigns", total)
+		}
+	}
+}
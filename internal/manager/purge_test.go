@@ -0,0 +1,56 @@
+package manager
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// purgeStore has a number of subscribers pending deletion.
+type purgeStore struct {
+	*testStore
+
+	mut     sync.Mutex
+	pending int
+	calls   []int
+}
+
+func (s *purgeStore) DeletePendingSubscribers(graceDays, limit int) (int, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.calls = append(s.calls, graceDays)
+	n := min(s.pending, limit)
+	s.pending -= n
+	return n, nil
+}
+
+func (s *purgeStore) getCalls() []int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]int{}, s.calls...)
+}
+
+// TestPurgeDeletions checks that the deletion job deletes subscribers pending
+// deletion in batches with the grace period until there are none left.
+func TestPurgeDeletions(t *testing.T) {
+	st := &purgeStore{testStore: &testStore{}, pending: 5}
+	m := newTestManager(Config{BatchSize: 2, DeletionGraceDays: 30}, st)
+
+	go m.purgeDeletions(10 * time.Millisecond)
+
+	// 2 + 2 + 1.
+	waitFor(t, 5*time.Second, func() bool { return len(st.getCalls()) >= 3 })
+
+	st.mut.Lock()
+	pending := st.pending
+	st.mut.Unlock()
+	if pending != 0 {
+		t.Fatalf("expected all subscribers to be deleted, %d left", pending)
+	}
+	for _, g := range st.getCalls() {
+		if g != 30 {
+			t.Fatalf("expected the grace period of 30 days, got %d", g)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package manager
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/sendlimit"
+	"github.com/knadh/listmonk/models"
+)
+
+// limitMessenger is a testMessenger that's at its per-minute sending limit
+// for the first few messages pushed to it.
+type limitMessenger struct {
+	testMessenger
+
+	mut     sync.Mutex
+	limited int
+}
+
+func (l *limitMessenger) Push(m models.Message) error {
+	l.mut.Lock()
+	if l.limited > 0 {
+		l.limited--
+		l.mut.Unlock()
+		return &sendlimit.ErrRateLimit{RetryAfter: 50 * time.Millisecond}
+	}
+	l.mut.Unlock()
+
+	return l.testMessenger.Push(m)
+}
+
+// TestRateLimitRequeue checks that messages that hit the messenger's
+// per-minute limits are queued again and sent instead of erroring out.
+func TestRateLimitRequeue(t *testing.T) {
+	st := &seqStore{testStore: &testStore{}}
+	m := newTestManager(Config{Concurrency: 1}, st)
+
+	msgr := &limitMessenger{limited: 3}
+	if err := m.AddMessenger(msgr); err != nil {
+		t.Fatal(err)
+	}
+
+	go m.Run()
+	defer m.Close()
+
+	// Campaign messages.
+	var msgs []models.SequenceMessage
+	for i, email := range []string{"ok1@example.com", "ok2@example.com"} {
+		var sub models.Subscriber
+		sub.ID = i + 1
+		sub.Email = email
+		msgs = append(msgs, models.SequenceMessage{Subscriber: sub, SequenceID: 1, CampaignID: 1, Step: 1})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.pushSequenceMessages(msgs)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the messages to be pushed")
+	}
+
+	if len(st.recorded) != 2 || len(st.released) != 0 {
+		t.Fatalf("expected both messages to be sent, got %d sent and %d failed", len(st.recorded), len(st.released))
+	}
+
+	// Other messages.
+	msgr.mut.Lock()
+	msgr.limited = 2
+	msgr.mut.Unlock()
+
+	if err := m.PushMessage(models.Message{Messenger: "test", To: []string{"sys@example.com"}, Subject: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, 5*time.Second, func() bool {
+		msgr.testMessenger.mut.Lock()
+		defer msgr.testMessenger.mut.Unlock()
+		return len(msgr.sent) == 3
+	})
+}
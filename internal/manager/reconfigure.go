@@ -0,0 +1,61 @@
+package manager
+
+// getCfg returns a copy of the config. The fields that can be changed at runtime
+// with Reconfigure() should be read via this.
+func (m *Manager) getCfg() Config {
+	m.cfgMut.RLock()
+	defer m.cfgMut.RUnlock()
+
+	return m.cfg
+}
+
+// Reconfigure applies new values of the runtime-tunable fields of cfg, namely
+// the concurrency, message rate, batch size, sliding window parameters, and the
+// max campaign runtime, to a running manager without a restart. The other fields
+// are ignored.
+//
+// On growing the concurrency, new workers are spawned immediately. On shrinking,
+// the surplus workers exit as soon as they finish the message that they're
+// processing, if any, so no message is dropped. The capacity of the message queues
+// is set at boot and isn't changed.
+func (m *Manager) Reconfigure(cfg Config) {
+	m.cfgMut.Lock()
+	if cfg.BatchSize > 0 {
+		m.cfg.BatchSize = cfg.BatchSize
+	}
+	if cfg.Concurrency > 0 {
+		m.cfg.Concurrency = cfg.Concurrency
+	}
+	if cfg.MessageRate > 0 {
+		m.cfg.MessageRate = cfg.MessageRate
+	}
+	m.cfg.SlidingWindow = cfg.SlidingWindow
+	m.cfg.SlidingWindowDuration = cfg.SlidingWindowDuration
+	m.cfg.SlidingWindowRate = cfg.SlidingWindowRate
+	m.cfg.MaxRuntime = cfg.MaxRuntime
+
+	c := m.cfg
+	m.cfgMut.Unlock()
+
+	m.resizeWorkers(c.Concurrency)
+	m.log.Printf("reconfigured campaign manager: concurrency=%d, message_rate=%d, batch_size=%d",
+		c.Concurrency, c.MessageRate, c.BatchSize)
+}
+
+// resizeWorkers grows or shrinks the number of message workers to n.
+func (m *Manager) resizeWorkers(n int) {
+	m.workersMut.Lock()
+	defer m.workersMut.Unlock()
+
+	for ; m.numWorkers < n; m.numWorkers++ {
+		go m.worker()
+	}
+
+	// A worker only receives the stop signal between messages. The signal is sent
+	// asynchronously so that the caller isn't blocked on busy workers.
+	for ; m.numWorkers > n; m.numWorkers-- {
+		go func() {
+			m.stopWorker <- struct{}{}
+		}()
+	}
+}
@@ -0,0 +1,157 @@
+package manager
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// gateMessenger is a testMessenger whose pushes block till the gate is opened
+// and that keeps track of the number of pushes in progress.
+type gateMessenger struct {
+	testMessenger
+
+	gateMut  sync.Mutex
+	gate     chan struct{}
+	inflight atomic.Int32
+	max      atomic.Int32
+}
+
+func (g *gateMessenger) Push(m models.Message) error {
+	n := g.inflight.Add(1)
+	for {
+		cur := g.max.Load()
+		if n <= cur || g.max.CompareAndSwap(cur, n) {
+			break
+		}
+	}
+
+	g.gateMut.Lock()
+	gate := g.gate
+	g.gateMut.Unlock()
+	<-gate
+
+	g.inflight.Add(-1)
+	return g.testMessenger.Push(m)
+}
+
+// setGate replaces the gate with a new one that blocks pushes till it's closed
+// and resets the max number of pushes in progress.
+func (g *gateMessenger) setGate() chan struct{} {
+	g.gateMut.Lock()
+	defer g.gateMut.Unlock()
+
+	g.gate = make(chan struct{})
+	g.max.Store(0)
+	return g.gate
+}
+
+func (g *gateMessenger) numSent() int {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+	return len(g.sent)
+}
+
+func (m *Manager) getNumWorkers() int {
+	m.workersMut.Lock()
+	defer m.workersMut.Unlock()
+	return m.numWorkers
+}
+
+func TestReconfigureConfig(t *testing.T) {
+	m := newTestManager(Config{
+		Concurrency:           1,
+		BatchSize:             100,
+		MessageRate:           10,
+		MaxSendErrors:         5,
+		SlidingWindow:         true,
+		SlidingWindowDuration: time.Minute,
+		SlidingWindowRate:     50,
+		MaxRuntime:            time.Hour,
+	}, &testStore{})
+
+	// Zero sizes and rates are ignored, the sliding window and runtime are applied
+	// as they are, and the fields that aren't runtime-tunable are left.
+	m.Reconfigure(Config{MessageRate: 20, MaxSendErrors: 100})
+
+	cfg := m.getCfg()
+	if cfg.Concurrency != 1 || cfg.BatchSize != 100 || cfg.MessageRate != 20 {
+		t.Errorf("unexpected sizes and rates %+v", cfg)
+	}
+	if cfg.SlidingWindow || cfg.SlidingWindowDuration != 0 || cfg.SlidingWindowRate != 0 || cfg.MaxRuntime != 0 {
+		t.Errorf("expected the sliding window and max runtime to be reset: %+v", cfg)
+	}
+	if cfg.MaxSendErrors != 5 {
+		t.Errorf("expected max send errors to be left, got %d", cfg.MaxSendErrors)
+	}
+}
+
+func TestReconfigureWorkers(t *testing.T) {
+	m := newTestManager(Config{Concurrency: 1}, &testStore{})
+
+	msgr := &gateMessenger{}
+	gate := msgr.setGate()
+	if err := m.AddMessenger(msgr); err != nil {
+		t.Fatal(err)
+	}
+
+	go m.Run()
+	defer m.Close()
+	waitFor(t, 5*time.Second, func() bool { return m.getNumWorkers() == 1 })
+
+	push := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := m.PushMessage(models.Message{Messenger: "test", To: []string{"sub@example.com"}, Subject: "test"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Growing the concurrency spawns the workers immediately.
+	m.Reconfigure(Config{Concurrency: 3})
+	if n := m.getNumWorkers(); n != 3 {
+		t.Fatalf("expected 3 workers, got %d", n)
+	}
+
+	push(5)
+	waitFor(t, 5*time.Second, func() bool { return msgr.inflight.Load() == 3 })
+	time.Sleep(100 * time.Millisecond)
+	if n := msgr.max.Load(); n != 3 {
+		t.Fatalf("expected 3 messages in progress, got %d", n)
+	}
+
+	// Shrinking doesn't block on the busy workers, and the messages that they're
+	// processing and the queued ones are all sent.
+	done := make(chan struct{})
+	go func() {
+		m.Reconfigure(Config{Concurrency: 1})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the workers to be shrunk")
+	}
+	if n := m.getNumWorkers(); n != 1 {
+		t.Fatalf("expected 1 worker, got %d", n)
+	}
+
+	close(gate)
+	waitFor(t, 5*time.Second, func() bool { return msgr.numSent() == 5 })
+
+	// The surplus workers exit once they're idle.
+	time.Sleep(200 * time.Millisecond)
+	gate = msgr.setGate()
+	push(3)
+	waitFor(t, 5*time.Second, func() bool { return msgr.inflight.Load() == 1 })
+	time.Sleep(100 * time.Millisecond)
+	if n := msgr.max.Load(); n != 1 {
+		t.Fatalf("expected 1 message in progress after shrinking, got %d", n)
+	}
+
+	close(gate)
+	waitFor(t, 5*time.Second, func() bool { return msgr.numSent() == 8 })
+}
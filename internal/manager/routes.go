@@ -0,0 +1,66 @@
+package manager
+
+import (
+	"strings"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// route returns the messenger for a campaign message. The messenger routes
+// are evaluated in order and the first one whose domain pattern matches the
+// recipient's e-mail domain overrides the campaign's messenger. The index of
+// the matching route is returned, or -1 if there's no match.
+func (m *Manager) route(msg CampaignMessage) (string, int) {
+	routes := m.cfg.MessengerRoutes
+	if len(routes) == 0 {
+		return msg.Campaign.Messenger, -1
+	}
+
+	i := strings.LastIndexByte(msg.to, '@')
+	if i < 0 {
+		return msg.Campaign.Messenger, -1
+	}
+	domain := strings.ToLower(msg.to[i+1:])
+
+	for n, r := range routes {
+		if !MatchDomain(r.Domain, domain) {
+			continue
+		}
+
+		// Skip routes to messengers that aren't available, eg: a disabled SMTP.
+		if _, ok := m.messengers[r.Messenger]; !ok {
+			continue
+		}
+
+		return r.Messenger, n
+	}
+
+	return msg.Campaign.Messenger, -1
+}
+
+// MatchDomain checks whether a (lowercase) domain matches a route's domain pattern.
+// The pattern is either an exact domain (outlook.com) or a suffix wildcard
+// (*.outlook.com) that matches all of its subdomains but not the domain itself.
+func MatchDomain(pattern, domain string) bool {
+	pattern = strings.ToLower(pattern)
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return strings.HasSuffix(domain, suffix)
+	}
+
+	return domain == pattern
+}
+
+// getRouteCounts returns the number of messages of a campaign routed via each
+// messenger route.
+func (p *pipe) getRouteCounts() []models.MessengerRouteCount {
+	routes := p.m.cfg.MessengerRoutes
+	out := make([]models.MessengerRouteCount, len(routes))
+	for n, r := range routes {
+		out[n] = models.MessengerRouteCount{
+			MessengerRoute: r,
+			Count:          p.routed[n].Load(),
+		}
+	}
+
+	return out
+}
@@ -0,0 +1,99 @@
+package manager
+
+import (
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// namedMessenger is a testMessenger with a given name.
+type namedMessenger struct {
+	testMessenger
+	name string
+}
+
+func (n *namedMessenger) Name() string { return n.name }
+
+func TestMatchDomain(t *testing.T) {
+	cases := []struct {
+		pattern, domain string
+		exp             bool
+	}{
+		{"outlook.com", "outlook.com", true},
+		{"Outlook.COM", "outlook.com", true},
+		{"outlook.com", "eu.outlook.com", false},
+		{"outlook.com", "outlook.co", false},
+		{"*.outlook.com", "eu.outlook.com", true},
+		{"*.outlook.com", "a.b.outlook.com", true},
+		{"*.OUTLOOK.com", "eu.outlook.com", true},
+
+		// Wildcards don't match the domain itself or lookalikes.
+		{"*.outlook.com", "outlook.com", false},
+		{"*.outlook.com", "evil-outlook.com", false},
+		{"*.outlook.com", "outlook.com.evil.com", false},
+		{"*.co.uk", "bbc.co.uk", true},
+	}
+	for _, c := range cases {
+		if got := MatchDomain(c.pattern, c.domain); got != c.exp {
+			t.Errorf("%s %s: expected %v, got %v", c.pattern, c.domain, c.exp, got)
+		}
+	}
+}
+
+func TestRoute(t *testing.T) {
+	m := newTestManager(Config{MessengerRoutes: []models.MessengerRoute{
+		{Domain: "*.mail.example.com", Messenger: "smtp-a"},
+		{Domain: "*.example.com", Messenger: "smtp-b"},
+		{Domain: "outlook.com", Messenger: "smtp-b"},
+		{Domain: "*.outlook.com", Messenger: "smtp-a"},
+		{Domain: "gmail.com", Messenger: "disabled"},
+		{Domain: "gmail.com", Messenger: "smtp-a"},
+	}}, &testStore{})
+	for _, name := range []string{"email", "smtp-a", "smtp-b"} {
+		if err := m.AddMessenger(&namedMessenger{name: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		to        string
+		messenger string
+		route     int
+	}{
+		// The first matching route takes precedence.
+		{"a@eu.mail.example.com", "smtp-a", 0},
+		{"a@eu.example.com", "smtp-b", 1},
+		{"a@outlook.com", "smtp-b", 2},
+		{"a@eu.outlook.com", "smtp-a", 3},
+		{"a@Mail.Example.COM", "smtp-b", 1},
+
+		// Routes to messengers that aren't loaded are skipped.
+		{"a@gmail.com", "smtp-a", 5},
+
+		// The campaign's messenger is used without a matching route.
+		{"a@example.com", "email", -1},
+		{"a@evil-outlook.com", "email", -1},
+		{"a@yahoo.com", "email", -1},
+		{"invalid", "email", -1},
+
+		// The domain is after the last @.
+		{`"a@outlook.com"@yahoo.com`, "email", -1},
+	}
+	for _, c := range cases {
+		camp := newTestCampaign()
+		camp.Messenger = "email"
+
+		messenger, route := m.route(CampaignMessage{Campaign: camp, to: c.to})
+		if messenger != c.messenger || route != c.route {
+			t.Errorf("%s: expected %s (%d), got %s (%d)", c.to, c.messenger, c.route, messenger, route)
+		}
+	}
+
+	// Without routes, the campaign's messenger is used.
+	m = newTestManager(Config{}, &testStore{})
+	camp := newTestCampaign()
+	camp.Messenger = "email"
+	if messenger, route := m.route(CampaignMessage{Campaign: camp, to: "a@outlook.com"}); messenger != "email" || route != -1 {
+		t.Errorf("expected the campaign's messenger, got %s (%d)", messenger, route)
+	}
+}
@@ -0,0 +1,62 @@
+package manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// maxRuntime returns the duration after which the campaign's current run is
+// paused. 0 means there's no limit.
+func (p *pipe) maxRuntime() time.Duration {
+	if p.camp.MaxRuntime != "" {
+		// The campaign's value is validated on saving.
+		d, _ := time.ParseDuration(p.camp.MaxRuntime)
+		return d
+	}
+
+	return p.m.getCfg().MaxRuntime
+}
+
+// checkRuntimes pauses the running campaigns that have exceeded their max runtime.
+func (m *Manager) checkRuntimes() {
+	var expired []*pipe
+
+	m.pipesMut.RLock()
+	for _, p := range m.pipes {
+		if d := p.maxRuntime(); d > 0 && time.Since(p.startedAt) >= d {
+			expired = append(expired, p)
+		}
+	}
+	m.pipesMut.RUnlock()
+
+	for _, p := range expired {
+		p.onTimeout()
+	}
+}
+
+// onTimeout pauses a campaign that has exceeded its max runtime and notifies
+// the admins. The campaign is paused in the DB right away instead of in cleanup()
+// as a campaign that's stuck, eg: on a messenger that doesn't respond, may never
+// drain its queued messages.
+func (p *pipe) onTimeout() {
+	if p.stopped.Load() || !p.timedOut.CompareAndSwap(false, true) {
+		return
+	}
+	p.Stop(false)
+
+	var (
+		d      = p.maxRuntime()
+		reason = fmt.Sprintf("Exceeded max runtime (%s) with %d of %d sent", d, p.totalSent.Load(), p.camp.ToSend)
+	)
+	p.m.log.Printf("campaign (%s) exceeded max runtime %s. pausing", p.camp.Name, d)
+
+	if err := p.m.store.UpdateCampaignStatus(p.camp.ID, models.CampaignStatusPaused); err != nil {
+		p.m.log.Printf("error updating campaign (%s) status to %s: %v", p.camp.Name, models.CampaignStatusPaused, err)
+	} else {
+		p.m.log.Printf("set campaign (%s) to %s", p.camp.Name, models.CampaignStatusPaused)
+	}
+
+	_ = p.m.sendNotif(p.camp, models.CampaignStatusPaused, reason, p.errSamples.get(), nil)
+}
@@ -0,0 +1,166 @@
+package manager
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// stallMessenger is a testMessenger that sends the first few messages and
+// then blocks until it's released.
+type stallMessenger struct {
+	testMessenger
+
+	ok      int
+	release chan struct{}
+}
+
+func (s *stallMessenger) Push(m models.Message) error {
+	s.testMessenger.mut.Lock()
+	stall := len(s.testMessenger.sent) >= s.ok
+	s.testMessenger.mut.Unlock()
+
+	if stall {
+		<-s.release
+	}
+	return s.testMessenger.Push(m)
+}
+
+// notifyLog records the notifications sent by a manager.
+type notifyLog struct {
+	mut  sync.Mutex
+	subj []string
+	data []map[string]any
+}
+
+func (n *notifyLog) notify(subject string, data any) error {
+	n.mut.Lock()
+	n.subj = append(n.subj, subject)
+	n.data = append(n.data, data.(map[string]any))
+	n.mut.Unlock()
+	return nil
+}
+
+func (n *notifyLog) len() int {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	return len(n.subj)
+}
+
+func TestMaxRuntime(t *testing.T) {
+	cases := []struct {
+		name   string
+		global time.Duration
+		camp   string
+		exp    time.Duration
+	}{
+		{"no limit", 0, "", 0},
+		{"global", time.Hour, "", time.Hour},
+		{"campaign over global", time.Hour, "10m", 10 * time.Minute},
+		{"campaign without global", 0, "90s", 90 * time.Second},
+	}
+	for _, c := range cases {
+		m := newTestManager(Config{MaxRuntime: c.global}, &testStore{})
+		camp := newTestCampaign()
+		camp.MaxRuntime = c.camp
+
+		p := &pipe{camp: camp, m: m}
+		if d := p.maxRuntime(); d != c.exp {
+			t.Errorf("%s: expected %v, got %v", c.name, c.exp, d)
+		}
+	}
+}
+
+// TestRuntimeTimeout runs a campaign on a messenger that stalls and checks
+// that it's paused with a notification once it exceeds its max runtime.
+func TestRuntimeTimeout(t *testing.T) {
+	const numSubs = 10
+
+	var (
+		st = &testStore{}
+		n  = &notifyLog{}
+	)
+	m := newTestManager(Config{BatchSize: numSubs, Concurrency: 1}, st)
+	m.fnNotify = n.notify
+
+	msgr := &stallMessenger{ok: 1, release: make(chan struct{})}
+	if err := m.AddMessenger(msgr); err != nil {
+		t.Fatal(err)
+	}
+
+	var once sync.Once
+	st.nextSubscribers = func(campID, limit int) ([]models.Subscriber, error) {
+		var out []models.Subscriber
+		once.Do(func() { out = testSubscribers(1, numSubs) })
+		return out, nil
+	}
+
+	done := make(chan struct{})
+	m.fnCampStop = func(*models.Campaign) { close(done) }
+	go m.Run()
+	defer m.Close()
+
+	c := newTestCampaign()
+	c.Simulation = false
+	c.Messenger = "test"
+	c.ToSend = numSubs
+	c.MaxRuntime = "300ms"
+
+	p, err := m.newPipe(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.nextPipes <- p
+
+	// Wait for the first message to go out and the messenger to stall.
+	waitFor(t, 5*time.Second, func() bool { return p.totalSent.Load() == 1 })
+
+	// The campaign isn't paused before its max runtime.
+	m.checkRuntimes()
+	if s := m.GetCampaignStats(c.ID); s.MaxRuntime != 300*time.Millisecond || s.RuntimeRemaining <= 0 || s.RuntimeRemaining > s.MaxRuntime {
+		t.Fatalf("unexpected runtime stats %+v", s)
+	}
+	if n.len() != 0 || len(st.getStatuses()) != 0 {
+		t.Fatalf("expected the campaign to be running, got %v", st.getStatuses())
+	}
+
+	// The campaign is paused on expiry while the messenger is still stuck.
+	waitFor(t, 5*time.Second, func() bool {
+		m.checkRuntimes()
+		return n.len() > 0
+	})
+	if s := st.getStatuses(); len(s) != 1 || s[0] != models.CampaignStatusPaused {
+		t.Fatalf("expected the campaign to be paused, got %v", s)
+	}
+	if !p.stopped.Load() || !p.timedOut.Load() {
+		t.Fatal("expected the pipe to be stopped")
+	}
+	if s := m.GetCampaignStats(c.ID); s.RuntimeRemaining != 0 {
+		t.Errorf("expected no runtime remaining, got %v", s.RuntimeRemaining)
+	}
+
+	n.mut.Lock()
+	if n.subj[0] != "Paused: test" || n.data[0]["Reason"] != "Exceeded max runtime (300ms) with 1 of 10 sent" {
+		t.Errorf("unexpected notification %s %v", n.subj[0], n.data[0])
+	}
+	n.mut.Unlock()
+
+	// Further checks don't notify again.
+	m.checkRuntimes()
+	if n.len() != 1 {
+		t.Errorf("expected one notification, got %d", n.len())
+	}
+
+	// Once the messenger recovers, the pipe is cleaned up without finishing the campaign.
+	close(msgr.release)
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the campaign to stop")
+	}
+	if s := st.getStatuses(); len(s) != 1 || n.len() != 1 {
+		t.Errorf("expected the campaign to stay paused, got %v", s)
+	}
+}
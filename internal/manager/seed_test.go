@@ -0,0 +1,85 @@
+package manager
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// msgMessenger records the messages that are pushed to it.
+type msgMessenger struct {
+	testMessenger
+
+	mut  sync.Mutex
+	msgs []models.Message
+}
+
+func (r *msgMessenger) Push(m models.Message) error {
+	if err := r.testMessenger.Push(m); err != nil {
+		return err
+	}
+
+	r.mut.Lock()
+	r.msgs = append(r.msgs, m)
+	r.mut.Unlock()
+	return nil
+}
+
+func TestSendSeedMessage(t *testing.T) {
+	m := newTestManager(Config{}, &testStore{})
+	msgr := &msgMessenger{}
+	if err := m.AddMessenger(msgr); err != nil {
+		t.Fatal(err)
+	}
+
+	camp := newTestCampaign()
+	camp.UUID = "camp-uuid"
+	camp.Messenger = "test"
+	if err := camp.CompileTemplate(m.TemplateFuncs(camp)); err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(email string) error {
+		t.Helper()
+		msg, err := m.NewCampaignMessage(camp, models.Subscriber{UUID: models.SeedSubscriberUUID, Email: email, Name: email})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m.SendSeedMessage(msg)
+	}
+
+	// The message goes out directly through the campaign's messenger with the seed header.
+	if err := send("seed@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgr.msgs) != 1 {
+		t.Fatalf("expected a message, got %d", len(msgr.msgs))
+	}
+	out := msgr.msgs[0]
+	if out.To[0] != "seed@example.com" || string(out.Body) != "Hello seed@example.com" {
+		t.Errorf("unexpected message %s: %s", out.To, out.Body)
+	}
+	if out.Headers.Get(models.SeedHeader) != "true" || out.Headers.Get(models.EmailHeaderSubscriberUUID) != models.SeedSubscriberUUID {
+		t.Errorf("unexpected headers %v", out.Headers)
+	}
+
+	// Nothing is queued or counted for the campaign.
+	if len(m.campMsgQ) != 0 || len(m.msgQ) != 0 || len(m.pipes) != 0 {
+		t.Error("expected the seed message to bypass the queues")
+	}
+
+	// Messenger errors are returned.
+	if err := send("fail@example.com"); err == nil {
+		t.Error("expected the send to fail")
+	}
+
+	// Unknown messengers fail.
+	camp.Messenger = "nope"
+	if err := send("seed@example.com"); err == nil {
+		t.Error("expected an unknown messenger to fail")
+	}
+	if len(msgr.msgs) != 1 {
+		t.Errorf("expected one message, got %d", len(msgr.msgs))
+	}
+}
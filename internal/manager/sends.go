@@ -0,0 +1,60 @@
+package manager
+
+import (
+	"sync"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// sendLog buffers the IDs of the subscribers that a campaign has been sent to,
+// by the messenger they were sent via, so that they can be written to the send
+// history in batches.
+type sendLog struct {
+	mut    sync.Mutex
+	subIDs map[string][]int64
+}
+
+// recordSend adds a subscriber to the send history of the pipe's campaign and
+// flushes the messenger's buffer to the store if it's full. It's a no-op unless
+// individual subscriber tracking is enabled. Ad-hoc campaign recipients aren't
+// subscribers and have no send history.
+func (p *pipe) recordSend(subID int, messenger string) {
+	if !p.m.cfg.IndividualTracking || p.camp.Type == models.CampaignTypeAdhoc {
+		return
+	}
+
+	p.sends.mut.Lock()
+	if p.sends.subIDs == nil {
+		p.sends.subIDs = make(map[string][]int64)
+	}
+	ids := append(p.sends.subIDs[messenger], int64(subID))
+	if len(ids) < p.m.getCfg().BatchSize {
+		p.sends.subIDs[messenger] = ids
+		p.sends.mut.Unlock()
+		return
+	}
+	delete(p.sends.subIDs, messenger)
+	p.sends.mut.Unlock()
+
+	p.saveSends(ids, messenger)
+}
+
+// flushSends writes the buffered send history, if any, to the store.
+func (p *pipe) flushSends() {
+	p.sends.mut.Lock()
+	all := p.sends.subIDs
+	p.sends.subIDs = nil
+	p.sends.mut.Unlock()
+
+	for msgr, ids := range all {
+		if len(ids) > 0 {
+			p.saveSends(ids, msgr)
+		}
+	}
+}
+
+func (p *pipe) saveSends(ids []int64, messenger string) {
+	if err := p.m.store.RecordCampaignSends(p.camp.ID, ids, messenger); err != nil {
+		p.m.log.Printf("error recording campaign (%s) sends: %v", p.camp.Name, err)
+	}
+}
@@ -0,0 +1,107 @@
+package manager
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// sendsStore records the send history of campaigns.
+type sendsStore struct {
+	*testStore
+
+	mut sync.Mutex
+	ids []int64
+}
+
+func (s *sendsStore) RecordCampaignSends(campID int, ids []int64, messenger string) error {
+	s.mut.Lock()
+	s.ids = append(s.ids, ids...)
+	s.mut.Unlock()
+	return nil
+}
+
+// stopMessenger is a testMessenger that calls stop after a number of messages.
+type stopMessenger struct {
+	testMessenger
+
+	mut   sync.Mutex
+	after int
+	stop  func()
+}
+
+func (s *stopMessenger) Push(m models.Message) error {
+	s.mut.Lock()
+	if s.after--; s.after == 0 {
+		s.stop()
+	}
+	s.mut.Unlock()
+
+	return s.testMessenger.Push(m)
+}
+
+// TestRecordSends checks that every message that's sent is in the send history
+// that's flushed when a campaign finishes or is stopped midway.
+func TestRecordSends(t *testing.T) {
+	const numSubs = 200
+
+	for _, stopAfter := range []int{0, 50} {
+		for range 10 {
+			st := &sendsStore{testStore: &testStore{}}
+			m := newTestManager(Config{BatchSize: 1000, Concurrency: 8, IndividualTracking: true}, st)
+
+			var once sync.Once
+			st.nextSubscribers = func(campID, limit int) ([]models.Subscriber, error) {
+				var out []models.Subscriber
+				once.Do(func() { out = testSubscribers(1, numSubs) })
+				return out, nil
+			}
+
+			var p *pipe
+			msgr := &stopMessenger{after: stopAfter, stop: func() { p.Stop(false) }}
+			if err := m.AddMessenger(msgr); err != nil {
+				t.Fatal(err)
+			}
+
+			done := make(chan struct{})
+			m.fnCampStop = func(*models.Campaign) { close(done) }
+
+			go m.Run()
+
+			c := newTestCampaign()
+			c.Simulation = false
+			c.Messenger = "test"
+
+			var err error
+			p, err = m.newPipe(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.nextPipes <- p
+
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for the campaign to end")
+			}
+			m.Close()
+
+			sent := st.getSent()
+			if stopAfter == 0 && sent != numSubs {
+				t.Fatalf("expected %d messages to be sent, got %d", numSubs, sent)
+			}
+
+			st.mut.Lock()
+			ids := slices.Clone(st.ids)
+			st.mut.Unlock()
+
+			slices.Sort(ids)
+			if len(ids) != sent || len(slices.Compact(ids)) != sent {
+				t.Fatalf("stop after %d: expected %d sends in the history, got %d", stopAfter, sent, len(ids))
+			}
+		}
+	}
+}
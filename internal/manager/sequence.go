@@ -0,0 +1,156 @@
+package manager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	// Backoff between the attempts of a sequence step that failed to be sent,
+	// which is multiplied by the number of attempts.
+	sequenceRetryBackoff = time.Minute * 10
+
+	// Number of attempts after which a sequence step that fails to be sent is skipped.
+	sequenceMaxAttempts = 3
+)
+
+// scanSequences is a blocking function that periodically enrolls new list
+// subscribers into sequences and pushes messages for sequence steps that are due.
+// The state of every subscriber in a sequence is persisted in the DB and advanced
+// as steps are claimed, so sequences resume correctly across restarts. Claims of
+// steps that fail to be sent are released and retried.
+func (m *Manager) scanSequences(tick time.Duration) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	for range t.C {
+		if err := m.store.EnrollSequenceSubscribers(); err != nil {
+			m.log.Printf("error enrolling sequence subscribers: %v", err)
+			continue
+		}
+
+		// Drain all due steps in batches.
+		for {
+			msgs, err := m.store.NextSequenceMessages(m.getCfg().BatchSize)
+			if err != nil {
+				m.log.Printf("error fetching sequence messages: %v", err)
+				break
+			}
+
+			m.pushSequenceMessages(msgs)
+
+			if len(msgs) < m.getCfg().BatchSize {
+				break
+			}
+		}
+	}
+}
+
+// pushSequenceMessages renders and queues messages for a batch of due sequence
+// steps and waits for them to be sent. Every step is a campaign that's compiled
+// once per batch. The messages that are sent are counted towards their campaigns
+// and the claims of the ones that fail are released to be retried later.
+func (m *Manager) pushSequenceMessages(msgs []models.SequenceMessage) {
+	var (
+		camps  = make(map[int]*models.Campaign)
+		out    = make([]CampaignMessage, 0, len(msgs))
+		queued = make([]models.SequenceMessage, 0, len(msgs))
+		failed []models.SequenceMessage
+	)
+
+	for _, s := range msgs {
+		c, ok := camps[s.CampaignID]
+		if !ok {
+			var err error
+			c, err = m.compileCampaign(s.CampaignID)
+			if err != nil {
+				m.log.Printf("error loading sequence campaign: %v", err)
+			}
+
+			// Cache failures as well to skip the remaining messages of the campaign.
+			camps[s.CampaignID] = c
+		}
+		if c == nil {
+			failed = append(failed, s)
+			continue
+		}
+
+		msg, err := m.NewCampaignMessage(c, s.Subscriber)
+		if err != nil {
+			m.log.Printf("error rendering sequence message (%s) (%s): %v", c.Name, s.Email, err)
+			failed = append(failed, s)
+			continue
+		}
+
+		out = append(out, msg)
+		queued = append(queued, s)
+	}
+
+	var sent []models.SequenceMessage
+	for i, err := range m.pushMessages(out) {
+		if err != nil {
+			failed = append(failed, queued[i])
+		} else {
+			sent = append(sent, queued[i])
+		}
+	}
+
+	if len(sent) > 0 {
+		if err := m.store.RecordSequenceSends(sent); err != nil {
+			m.log.Printf("error recording sequence sends: %v", err)
+		}
+	}
+	if len(failed) > 0 {
+		if err := m.store.ReleaseSequenceMessages(failed, sequenceRetryBackoff, sequenceMaxAttempts); err != nil {
+			m.log.Printf("error releasing failed sequence messages: %v", err)
+		}
+	}
+}
+
+// pushMessages queues messages that are sent outside of the campaign pipes,
+// blocking until the queue has room, and waits for them to be sent. It returns
+// the push error of every message.
+func (m *Manager) pushMessages(msgs []CampaignMessage) []error {
+	var (
+		errs = make([]error, len(msgs))
+		wg   sync.WaitGroup
+	)
+
+	wg.Add(len(msgs))
+	for i := range msgs {
+		msgs[i].onDone = func(err error) {
+			errs[i] = err
+			wg.Done()
+		}
+		m.campMsgQ <- msgs[i]
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// compileCampaign fetches a campaign that's sent outside of the campaign
+// pipes, such as a sequence step, and compiles it.
+func (m *Manager) compileCampaign(id int) (*models.Campaign, error) {
+	c, err := m.store.GetCampaign(id)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching campaign %d: %v", id, err)
+	}
+
+	if _, ok := m.messengers[c.Messenger]; !ok {
+		return nil, fmt.Errorf("unknown messenger %s on campaign %s", c.Messenger, c.Name)
+	}
+
+	if err := c.CompileTemplate(m.TemplateFuncs(c)); err != nil {
+		return nil, fmt.Errorf("error compiling campaign %s: %v", c.Name, err)
+	}
+
+	if err := m.attachMedia(c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
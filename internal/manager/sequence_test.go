@@ -0,0 +1,136 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// testMessenger is a messenger that fails to send to addresses that start with "fail".
+type testMessenger struct {
+	mut  sync.Mutex
+	sent []string
+}
+
+func (t *testMessenger) Name() string { return "test" }
+
+func (t *testMessenger) Push(m models.Message) error {
+	if strings.HasPrefix(m.To[0], "fail") {
+		return errors.New("send failed")
+	}
+
+	t.mut.Lock()
+	t.sent = append(t.sent, m.To[0])
+	t.mut.Unlock()
+	return nil
+}
+
+func (t *testMessenger) Flush() error { return nil }
+func (t *testMessenger) Close() error { return nil }
+
+// seqStore records the settled sequence claims.
+type seqStore struct {
+	*testStore
+
+	recorded []models.SequenceMessage
+	released []models.SequenceMessage
+	backoff  time.Duration
+	attempts int
+}
+
+func (s *seqStore) GetCampaign(id int) (*models.Campaign, error) {
+	if id != 1 {
+		return nil, errors.New("campaign not found")
+	}
+
+	c := newTestCampaign()
+	c.Simulation = false
+	c.Messenger = "test"
+	return c, nil
+}
+
+func (s *seqStore) RecordSequenceSends(msgs []models.SequenceMessage) error {
+	s.recorded = append(s.recorded, msgs...)
+	return nil
+}
+
+func (s *seqStore) ReleaseSequenceMessages(msgs []models.SequenceMessage, backoff time.Duration, maxAttempts int) error {
+	s.released = append(s.released, msgs...)
+	s.backoff, s.attempts = backoff, maxAttempts
+	return nil
+}
+
+func seqEmails(msgs []models.SequenceMessage) []string {
+	out := make([]string, len(msgs))
+	for i, m := range msgs {
+		out[i] = m.Email
+	}
+	sort.Strings(out)
+	return out
+}
+
+// TestPushSequenceMessages checks that only the sequence messages that are sent
+// are counted and that the claims of the ones that fail are released.
+func TestPushSequenceMessages(t *testing.T) {
+	st := &seqStore{testStore: &testStore{}}
+	m := newTestManager(Config{Concurrency: 2}, st)
+
+	msgr := &testMessenger{}
+	if err := m.AddMessenger(msgr); err != nil {
+		t.Fatal(err)
+	}
+
+	go m.Run()
+	defer m.Close()
+
+	var msgs []models.SequenceMessage
+	for i, s := range []struct {
+		email  string
+		campID int
+	}{
+		{"ok1@example.com", 1},
+		{"fail1@example.com", 1},
+		{"ok2@example.com", 1},
+		{"missing@example.com", 2},
+	} {
+		var sub models.Subscriber
+		sub.ID = i + 1
+		sub.Email = s.email
+		msgs = append(msgs, models.SequenceMessage{Subscriber: sub, SequenceID: 1, CampaignID: s.campID, Step: 2})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		m.pushSequenceMessages(msgs)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the messages to be pushed")
+	}
+
+	if got, exp := seqEmails(st.recorded), []string{"ok1@example.com", "ok2@example.com"}; fmt.Sprint(got) != fmt.Sprint(exp) {
+		t.Errorf("expected recorded sends %v, got %v", exp, got)
+	}
+	if got, exp := seqEmails(st.released), []string{"fail1@example.com", "missing@example.com"}; fmt.Sprint(got) != fmt.Sprint(exp) {
+		t.Errorf("expected released claims %v, got %v", exp, got)
+	}
+	for _, r := range st.released {
+		if r.Step != 2 {
+			t.Errorf("expected the released claim of %s to keep its step, got %d", r.Email, r.Step)
+		}
+	}
+	if st.backoff != sequenceRetryBackoff || st.attempts != sequenceMaxAttempts {
+		t.Errorf("unexpected retry params %v %d", st.backoff, st.attempts)
+	}
+	if len(msgr.sent) != 2 {
+		t.Errorf("expected 2 messages to be sent, got %v", msgr.sent)
+	}
+}
@@ -0,0 +1,42 @@
+package manager
+
+import (
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+const simulatorName = "simulator"
+
+// simulator is a no-op messenger that the messages of campaigns started in the
+// simulation mode are pushed to instead of the campaign's messenger. Messages go
+// through the entire pipeline, fetching, rendering, rate limiting, and stats,
+// but aren't delivered anywhere.
+type simulator struct {
+	// Artificial delivery latency per message.
+	latency time.Duration
+}
+
+// Name returns the messenger's name.
+func (s *simulator) Name() string {
+	return simulatorName
+}
+
+// Push discards the message after the configured latency.
+func (s *simulator) Push(models.Message) error {
+	if s.latency > 0 {
+		time.Sleep(s.latency)
+	}
+
+	return nil
+}
+
+// Flush is a no-op.
+func (s *simulator) Flush() error {
+	return nil
+}
+
+// Close is a no-op.
+func (s *simulator) Close() error {
+	return nil
+}
@@ -0,0 +1,111 @@
+package manager
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// simStore records the messengers that the sends are recorded with.
+type simStore struct {
+	*testStore
+
+	mut   sync.Mutex
+	sends map[string]int
+}
+
+func (s *simStore) RecordCampaignSends(campID int, ids []int64, messenger string) error {
+	s.mut.Lock()
+	s.sends[messenger] += len(ids)
+	s.mut.Unlock()
+	return nil
+}
+
+// TestSimulation runs a simulated campaign through the pipeline and checks that
+// nothing is delivered while the sends are counted and recorded.
+func TestSimulation(t *testing.T) {
+	const numSubs = 100
+
+	st := &simStore{testStore: &testStore{}, sends: map[string]int{}}
+	m := newTestManager(Config{BatchSize: 1000, Concurrency: 4, IndividualTracking: true}, st)
+
+	var once sync.Once
+	st.nextSubscribers = func(campID, limit int) ([]models.Subscriber, error) {
+		var out []models.Subscriber
+		once.Do(func() { out = testSubscribers(1, numSubs) })
+		return out, nil
+	}
+
+	msgr := &testMessenger{}
+	if err := m.AddMessenger(msgr); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	m.fnCampStop = func(*models.Campaign) { close(done) }
+
+	go m.Run()
+	defer m.Close()
+
+	// Real campaigns with unknown messengers are cancelled.
+	c := newTestCampaign()
+	c.Simulation = false
+	c.Messenger = "nope"
+	if _, err := m.newPipe(c); err == nil {
+		t.Fatal("expected an unknown messenger to fail")
+	}
+
+	// Simulated campaigns don't use their messengers.
+	c.Simulation = true
+	p, err := m.newPipe(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.nextPipes <- p
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the campaign to end")
+	}
+
+	if n := st.getSent(); n != numSubs {
+		t.Errorf("expected %d messages to be counted as sent, got %d", numSubs, n)
+	}
+
+	msgr.mut.Lock()
+	delivered := len(msgr.sent)
+	msgr.mut.Unlock()
+	if delivered != 0 {
+		t.Errorf("expected no messages to be delivered, got %d", delivered)
+	}
+
+	st.mut.Lock()
+	defer st.mut.Unlock()
+	if len(st.sends) != 1 || st.sends[simulatorName] != numSubs {
+		t.Errorf("expected the sends to be recorded via the simulator, got %v", st.sends)
+	}
+}
+
+func TestSimulatorLatency(t *testing.T) {
+	s := &simulator{latency: 20 * time.Millisecond}
+
+	start := time.Now()
+	for range 3 {
+		if err := s.Push(models.Message{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 60*time.Millisecond {
+		t.Errorf("expected the latency per message, took %v", d)
+	}
+
+	// No latency.
+	s = &simulator{}
+	start = time.Now()
+	if err := s.Push(models.Message{}); err != nil || time.Since(start) > 10*time.Millisecond {
+		t.Errorf("expected no latency, took %v: %v", time.Since(start), err)
+	}
+}
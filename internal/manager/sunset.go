@@ -0,0 +1,120 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// scanSunset is a blocking function that periodically applies the sunset policy
+// to inactive subscribers. Final campaign notices are sent in batches and an event
+// with the counts is emitted after every run that notifies or sunsets anyone.
+func (m *Manager) scanSunset(tick time.Duration) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	for range t.C {
+		var notified, sunset int
+		for {
+			res, err := m.Sunset(false)
+			if err != nil {
+				m.log.Printf("error applying sunset policy: %v", err)
+				break
+			}
+			notified += res.Notified
+			sunset += res.Sunset
+
+			// Failed notices stay claimed, so the batches are drained by the claims.
+			if len(res.NotifyIDs) < m.getCfg().BatchSize {
+				break
+			}
+		}
+
+		if notified == 0 && sunset == 0 {
+			continue
+		}
+
+		m.log.Printf("sunset policy: notified %d and sunset %d inactive subscribers", notified, sunset)
+		m.fnEvent(models.EventSubscribersSunset, map[string]any{
+			"notified": notified,
+			"sunset":   sunset,
+		})
+	}
+}
+
+// Sunset applies the sunset policy once and sends the final campaign to a batch
+// of inactive subscribers who haven't been notified yet. Only the subscribers the
+// campaign is sent to are marked notified and counted. In dry-run mode, only the
+// counts of affected subscribers are returned and nothing is changed or sent.
+func (m *Manager) Sunset(dryRun bool) (models.SunsetResult, error) {
+	if !m.cfg.IndividualTracking {
+		return models.SunsetResult{}, errors.New("sunset policy requires individual subscriber tracking")
+	}
+
+	m.sunsetMut.Lock()
+	defer m.sunsetMut.Unlock()
+
+	res, err := m.store.SunsetSubscribers(m.cfg.Sunset, dryRun, m.getCfg().BatchSize)
+	if err != nil {
+		return res, err
+	}
+
+	if dryRun || len(res.NotifyIDs) == 0 {
+		return res, nil
+	}
+
+	n, err := m.pushSunsetNotices(res.NotifyIDs)
+	if err != nil {
+		m.log.Printf("error sending sunset notices: %v", err)
+	}
+	res.Notified = n
+
+	return res, nil
+}
+
+// pushSunsetNotices renders and sends the final campaign to the given subscribers,
+// marks the ones it was sent to as notified, and returns their count.
+func (m *Manager) pushSunsetNotices(subIDs []int64) (int, error) {
+	c, err := m.compileCampaign(m.cfg.Sunset.FinalCampaignID)
+	if err != nil {
+		return 0, err
+	}
+
+	subs, err := m.store.GetSubscribers(subIDs)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching subscribers: %v", err)
+	}
+
+	var (
+		out = make([]CampaignMessage, 0, len(subs))
+		ids = make([]int64, 0, len(subs))
+	)
+	for _, s := range subs {
+		msg, err := m.NewCampaignMessage(c, s)
+		if err != nil {
+			m.log.Printf("error rendering sunset message (%s) (%s): %v", c.Name, s.Email, err)
+			continue
+		}
+
+		out = append(out, msg)
+		ids = append(ids, int64(s.ID))
+	}
+
+	var sent []int64
+	for i, err := range m.pushMessages(out) {
+		if err == nil {
+			sent = append(sent, ids[i])
+		}
+	}
+	if len(sent) == 0 {
+		return 0, nil
+	}
+
+	if err := m.store.RecordSunsetNotices(c.ID, sent); err != nil {
+		return 0, fmt.Errorf("error recording sunset notices: %v", err)
+	}
+
+	return len(sent), nil
+}
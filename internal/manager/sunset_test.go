@@ -0,0 +1,91 @@
+package manager
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// sunsetStore returns a batch of subscribers to notify and records the notices.
+type sunsetStore struct {
+	*seqStore
+
+	subs     []models.Subscriber
+	notified []int64
+	campID   int
+}
+
+func (s *sunsetStore) SunsetSubscribers(p models.SunsetPolicy, dryRun bool, limit int) (models.SunsetResult, error) {
+	res := models.SunsetResult{Notified: len(s.subs)}
+	for _, sub := range s.subs {
+		res.NotifyIDs = append(res.NotifyIDs, int64(sub.ID))
+	}
+	return res, nil
+}
+
+func (s *sunsetStore) GetSubscribers(ids []int64) ([]models.Subscriber, error) {
+	return s.subs, nil
+}
+
+func (s *sunsetStore) RecordSunsetNotices(campID int, subIDs []int64) error {
+	s.campID = campID
+	s.notified = append(s.notified, subIDs...)
+	return nil
+}
+
+// TestSunsetNotices checks that only the subscribers the final campaign is sent
+// to are marked notified and counted.
+func TestSunsetNotices(t *testing.T) {
+	st := &sunsetStore{seqStore: &seqStore{testStore: &testStore{}}}
+	for i, email := range []string{"ok1@example.com", "fail1@example.com", "ok2@example.com"} {
+		var sub models.Subscriber
+		sub.ID = i + 1
+		sub.Email = email
+		st.subs = append(st.subs, sub)
+	}
+
+	m := newTestManager(Config{
+		Concurrency:        2,
+		IndividualTracking: true,
+		Sunset:             models.SunsetPolicy{Enabled: true, FinalCampaignID: 1},
+	}, st)
+	if err := m.AddMessenger(&testMessenger{}); err != nil {
+		t.Fatal(err)
+	}
+
+	go m.Run()
+	defer m.Close()
+
+	var (
+		res  models.SunsetResult
+		err  error
+		done = make(chan struct{})
+	)
+	go func() {
+		res, err = m.Sunset(false)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the notices to be sent")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.Notified != 2 {
+		t.Errorf("expected 2 notified, got %d", res.Notified)
+	}
+	if fmt.Sprint(st.notified) != "[1 3]" || st.campID != 1 {
+		t.Errorf("expected subscribers [1 3] to be marked notified for campaign 1, got %v for %d", st.notified, st.campID)
+	}
+
+	// Nothing's sent or recorded in a dry run.
+	st.notified = nil
+	if res, err := m.Sunset(true); err != nil || res.Notified != 3 || st.notified != nil {
+		t.Errorf("unexpected dry run %+v %v %v", res, err, st.notified)
+	}
+}
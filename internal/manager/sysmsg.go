@@ -0,0 +1,55 @@
+package manager
+
+import (
+	"encoding/json"
+	"net/textproto"
+	"time"
+
+	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/models"
+)
+
+// retrySystemMessages is a blocking function that periodically retries sending the
+// queued system e-mails, eg: opt-in confirmations, that failed to send. The store
+// reschedules the ones that fail again with backoff until they run out of attempts.
+func (m *Manager) retrySystemMessages(tick time.Duration) {
+	t := time.NewTicker(tick)
+	defer t.Stop()
+
+	for range t.C {
+		msgr, ok := m.messengers[email.MessengerName]
+		if !ok {
+			continue
+		}
+
+		msgs, err := m.store.NextSystemMessages(m.getCfg().BatchSize)
+		if err != nil {
+			continue
+		}
+
+		for _, s := range msgs {
+			var hdr textproto.MIMEHeader
+			if err := json.Unmarshal(s.Headers, &hdr); err != nil {
+				m.log.Printf("error reading headers of system e-mail %d: %v", s.ID, err)
+			}
+
+			err := msgr.Push(models.Message{
+				Messenger:   email.MessengerName,
+				From:        s.FromEmail,
+				To:          s.ToEmails,
+				Subject:     s.Subject,
+				ContentType: s.ContentType,
+				Body:        []byte(s.Body),
+				Headers:     hdr,
+			})
+			if err != nil {
+				m.log.Printf("error retrying system e-mail %d (%s), attempt %d: %v", s.ID, s.Subject, s.Attempts+1, err)
+			} else {
+				m.log.Printf("sent system e-mail %d (%s) on attempt %d", s.ID, s.Subject, s.Attempts+1)
+			}
+
+			// Errors are logged by the store.
+			_ = m.store.RecordSystemMessageAttempt(s.ID, err)
+		}
+	}
+}
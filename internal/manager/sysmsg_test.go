@@ -0,0 +1,126 @@
+package manager
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// flakyMessenger is an e-mail messenger that fails a number of sends before
+// sending successfully.
+type flakyMessenger struct {
+	mut   sync.Mutex
+	fails int
+	msgs  []models.Message
+}
+
+func (f *flakyMessenger) Name() string { return "email" }
+
+func (f *flakyMessenger) Push(m models.Message) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+
+	f.msgs = append(f.msgs, m)
+	if f.fails > 0 {
+		f.fails--
+		return errors.New("421 try again later")
+	}
+	return nil
+}
+
+func (f *flakyMessenger) Flush() error { return nil }
+func (f *flakyMessenger) Close() error { return nil }
+
+// sysMsgStore is a system e-mail queue that has the due messages claimed on
+// every scan and requeues the ones whose attempt failed.
+type sysMsgStore struct {
+	*testStore
+
+	mut      sync.Mutex
+	queue    []models.SystemMessage
+	claimed  map[int]models.SystemMessage
+	attempts []error
+}
+
+func (s *sysMsgStore) NextSystemMessages(limit int) ([]models.SystemMessage, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	n := min(limit, len(s.queue))
+	out := s.queue[:n]
+	s.queue = s.queue[n:]
+	for _, m := range out {
+		s.claimed[m.ID] = m
+	}
+	return out, nil
+}
+
+func (s *sysMsgStore) RecordSystemMessageAttempt(id int, sendErr error) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	s.attempts = append(s.attempts, sendErr)
+	if sendErr != nil {
+		m := s.claimed[id]
+		m.Attempts++
+		s.queue = append(s.queue, m)
+	}
+	delete(s.claimed, id)
+	return nil
+}
+
+func (s *sysMsgStore) getAttempts() []error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]error{}, s.attempts...)
+}
+
+// TestRetrySystemMessages checks that a queued opt-in confirmation that fails
+// twice is retried until it's sent.
+func TestRetrySystemMessages(t *testing.T) {
+	st := &sysMsgStore{testStore: &testStore{}, claimed: map[int]models.SystemMessage{}, queue: []models.SystemMessage{{
+		ID:          1,
+		Attempts:    1,
+		FromEmail:   "from@example.com",
+		ToEmails:    []string{"sub@example.com"},
+		Subject:     "Confirm",
+		ContentType: "html",
+		Body:        "<p>Confirm</p>",
+		Headers:     []byte(`{"X-Listmonk-Subscriber": ["sub-uuid"]}`),
+	}}}
+	m := newTestManager(Config{BatchSize: 10}, st)
+
+	msgr := &flakyMessenger{fails: 2}
+	if err := m.AddMessenger(msgr); err != nil {
+		t.Fatal(err)
+	}
+
+	go m.retrySystemMessages(10 * time.Millisecond)
+
+	waitFor(t, 5*time.Second, func() bool { return len(st.getAttempts()) >= 3 })
+
+	att := st.getAttempts()
+	if len(att) != 3 || att[0] == nil || att[1] == nil || att[2] != nil {
+		t.Fatalf("expected two failed attempts and a successful one, got %v", att)
+	}
+
+	msgr.mut.Lock()
+	defer msgr.mut.Unlock()
+	if len(msgr.msgs) != 3 {
+		t.Fatalf("expected 3 sends, got %d", len(msgr.msgs))
+	}
+	out := msgr.msgs[2]
+	if out.Messenger != "email" || out.From != "from@example.com" || out.To[0] != "sub@example.com" || out.Subject != "Confirm" ||
+		string(out.Body) != "<p>Confirm</p>" || out.Headers.Get(models.EmailHeaderSubscriberUUID) != "sub-uuid" {
+		t.Errorf("unexpected message %+v", out)
+	}
+
+	// Nothing's left to retry.
+	time.Sleep(50 * time.Millisecond)
+	if n := len(st.getAttempts()); n != 3 {
+		t.Errorf("expected no more attempts, got %d", n)
+	}
+}
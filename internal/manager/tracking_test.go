@@ -0,0 +1,191 @@
+package manager
+
+import (
+	"html/template"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// linkStore registers all links with the same UUID.
+type linkStore struct {
+	*testStore
+
+	created *atomic.Int64
+}
+
+func (l linkStore) CreateLink(url string) (string, string, error) {
+	if l.created != nil {
+		l.created.Add(1)
+	}
+	return "link-uuid", "", nil
+}
+
+// TestTrackingSendTime checks that the send timestamp that the bot filter uses
+// is only appended to the tracking URLs when the filter is enabled.
+func TestTrackingSendTime(t *testing.T) {
+	for _, on := range []bool{false, true} {
+		m := newTestManager(Config{
+			BotFilter:          on,
+			IndividualTracking: true,
+			LinkTrackURL:       "https://example.com/link/%s/%s/%s",
+			ViewTrackURL:       "https://example.com/campaign/%s/%s/px.png",
+		}, linkStore{testStore: &testStore{}})
+
+		c := newTestCampaign()
+		c.UUID = "camp-uuid"
+		msg := &CampaignMessage{Campaign: c}
+		msg.Subscriber.UUID = "sub-uuid"
+
+		link := m.trackLink("https://listmonk.app", c, 1, "sub-uuid")
+		view := string(m.TemplateFuncs(c)["TrackView"].(func(*CampaignMessage) template.HTML)(msg))
+
+		for _, u := range []string{link, view} {
+			if has := strings.Contains(u, "?t="); has != on {
+				t.Errorf("bot filter %v: unexpected tracking URL %s", on, u)
+			}
+		}
+		if !strings.HasPrefix(link, "https://example.com/link/link-uuid/camp-uuid/sub-uuid") {
+			t.Errorf("unexpected link URL %s", link)
+		}
+		if !strings.Contains(view, `src="https://example.com/campaign/camp-uuid/sub-uuid/px.png`) {
+			t.Errorf("unexpected view pixel %s", view)
+		}
+	}
+}
+
+// TestTrackingModes renders campaigns in each tracking mode and checks their
+// links and view pixels.
+func TestTrackingModes(t *testing.T) {
+	const (
+		link  = `https://example.com/link/link-uuid/camp-uuid/`
+		pixel = `<img src="https://example.com/campaign/camp-uuid/`
+	)
+
+	cases := []struct {
+		mode       string
+		individual bool
+		links      bool
+		view       bool
+	}{
+		{"", true, true, true},
+		{models.CampaignTrackingModeFull, true, true, true},
+		{models.CampaignTrackingModeFull, false, true, true},
+		{models.CampaignTrackingModeClicksOnly, true, true, false},
+		{models.CampaignTrackingModeNone, true, false, false},
+	}
+	for _, c := range cases {
+		var created atomic.Int64
+		m := newTestManager(Config{
+			IndividualTracking: c.individual,
+			LinkTrackURL:       "https://example.com/link/%s/%s/%s",
+			ViewTrackURL:       "https://example.com/campaign/%s/%s/px.png",
+			UnsubURL:           "https://example.com/unsub/%s/%s",
+		}, linkStore{testStore: &testStore{}, created: &created})
+
+		camp := newTestCampaign()
+		camp.UUID = "camp-uuid"
+		camp.TrackingMode = c.mode
+		camp.Body = `<p>Hello</p><a href="https://listmonk.app/docs@TrackLink">docs</a>` +
+			`<a href="{{ TrackLink "https://listmonk.app/?a=1&b=2" . }}">home</a>{{ TrackView }}`
+		if err := camp.CompileTemplate(m.TemplateFuncs(camp)); err != nil {
+			t.Fatal(err)
+		}
+
+		msg, err := m.NewCampaignMessage(camp, models.Subscriber{UUID: "sub-uuid"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := string(msg.Body())
+
+		subUUID := "sub-uuid"
+		if !c.individual {
+			subUUID = dummyUUID
+		}
+
+		if c.links {
+			if n := strings.Count(body, `href="`+link+subUUID); n != 2 {
+				t.Errorf("%s: expected 2 tracked links, got %d: %s", c.mode, n, body)
+			}
+			if strings.Contains(body, "listmonk.app") {
+				t.Errorf("%s: unexpected untracked link: %s", c.mode, body)
+			}
+		} else {
+			if !strings.Contains(body, `href="https://listmonk.app/docs"`) || !strings.Contains(body, `href="https://listmonk.app/?a=1&amp;b=2"`) {
+				t.Errorf("%s: expected the original links, got %s", c.mode, body)
+			}
+			if created.Load() != 0 {
+				t.Errorf("%s: expected no links to be registered", c.mode)
+			}
+		}
+
+		if has := strings.Contains(body, pixel+subUUID+"/px.png"); has != c.view {
+			t.Errorf("%s: expected view pixel %v, got %s", c.mode, c.view, body)
+		}
+		if !c.view && strings.Contains(body, "<img") {
+			t.Errorf("%s: unexpected image: %s", c.mode, body)
+		}
+	}
+}
+
+// TestPreviewTemplateFuncs renders a campaign for a real subscriber with the
+// preview functions and checks that its links are disarmed.
+func TestPreviewTemplateFuncs(t *testing.T) {
+	created := &atomic.Int64{}
+	m := newTestManager(Config{
+		IndividualTracking: true,
+		LinkTrackURL:       "https://example.com/link/%s/%s/%s",
+		ViewTrackURL:       "https://example.com/campaign/%s/%s/px.png",
+		UnsubURL:           "https://example.com/subscription/%s/%s",
+		OptinURL:           "https://example.com/subscription/optin/%s?%s",
+		MessageURL:         "https://example.com/campaign/%s/%s",
+		PreviewLinkURL:     "https://example.com/link/preview?url=%s",
+	}, linkStore{testStore: &testStore{}, created: created})
+
+	c := newTestCampaign()
+	c.UUID = "camp-uuid"
+	c.Body = `{{ if eq .Subscriber.Attribs.plan "pro" }}Pro{{ end }} <a href="https://listmonk.app@TrackLink">a</a>
+		<a href="{{ TrackLink "https://listmonk.app/b" . }}">b</a> {{ TrackView }}
+		{{ UnsubscribeURL }} {{ ManageURL }} {{ OptinURL }} {{ MessageURL }}`
+	if err := c.CompileTemplate(m.PreviewTemplateFuncs(c)); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := m.NewCampaignMessage(c, models.Subscriber{UUID: "sub-uuid", Name: "User", Attribs: models.JSON{"plan": "pro"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(msg.Body())
+
+	// The subscriber's data is used.
+	if !strings.HasPrefix(body, "Pro ") {
+		t.Errorf("expected the subscriber's attributes to be used, got %s", body)
+	}
+
+	// Every link points to the preview page with the escaped destination.
+	for _, u := range []string{
+		"https://listmonk.app",
+		"https://listmonk.app/b",
+		"https://example.com/subscription/camp-uuid/sub-uuid",
+		"https://example.com/subscription/camp-uuid/sub-uuid?manage=true",
+		"https://example.com/subscription/optin/sub-uuid?",
+		"https://example.com/campaign/camp-uuid/sub-uuid",
+	} {
+		if !strings.Contains(body, "https://example.com/link/preview?url="+url.QueryEscape(u)) {
+			t.Errorf("expected %s to be disarmed, got %s", u, body)
+		}
+	}
+
+	// There's no view pixel, tracked link, or live subscriber URL.
+	for _, s := range []string{"px.png", "/link/link-uuid/", `"https://example.com/subscription/`, " https://example.com/campaign/"} {
+		if strings.Contains(body, s) {
+			t.Errorf("unexpected %s in the preview: %s", s, body)
+		}
+	}
+	if n := created.Load(); n != 0 {
+		t.Errorf("expected no links to be registered, got %d", n)
+	}
+}
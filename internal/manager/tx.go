@@ -0,0 +1,173 @@
+package manager
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/knadh/listmonk/internal/sendlimit"
+	"github.com/knadh/listmonk/models"
+)
+
+const (
+	TxStatusQueued = "queued"
+	TxStatusSent   = "sent"
+	TxStatusFailed = "failed"
+
+	// Maximum number of tx message statuses retained in memory. Beyond this,
+	// the oldest statuses are discarded.
+	maxTxStatuses = 100000
+)
+
+// ErrTxQueueFull is returned when a tx message is pushed to a full tx queue.
+var ErrTxQueueFull = errors.New("tx queue is full")
+
+// TxStatus represents the delivery status of a transactional message.
+type TxStatus struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type txMessage struct {
+	id  string
+	msg models.Message
+}
+
+// txStatuses is a bounded in-memory store of tx message statuses.
+type txStatuses struct {
+	mut   sync.RWMutex
+	items map[string]TxStatus
+
+	// Ring of IDs in the order of insertion for evicting the oldest statuses.
+	ids  []string
+	next int
+}
+
+func newTxStatuses(size int) *txStatuses {
+	return &txStatuses{
+		items: make(map[string]TxStatus),
+		ids:   make([]string, size),
+	}
+}
+
+// add records a new queued message.
+func (t *txStatuses) add(id string) {
+	now := time.Now()
+
+	t.mut.Lock()
+	if old := t.ids[t.next]; old != "" {
+		delete(t.items, old)
+	}
+	t.ids[t.next] = id
+	t.next = (t.next + 1) % len(t.ids)
+
+	t.items[id] = TxStatus{ID: id, Status: TxStatusQueued, CreatedAt: now, UpdatedAt: now}
+	t.mut.Unlock()
+}
+
+// set updates the status of a message if it's still retained.
+func (t *txStatuses) set(id, status string, err error) {
+	t.mut.Lock()
+	defer t.mut.Unlock()
+
+	s, ok := t.items[id]
+	if !ok {
+		return
+	}
+
+	s.Status = status
+	s.UpdatedAt = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	t.items[id] = s
+}
+
+// PushTxMessage queues a transactional message to be sent by the dedicated tx
+// workers that take priority over campaign messages. It doesn't block and
+// returns ErrTxQueueFull if the queue is full. The returned ID can be used to
+// query the status of the message with GetTxStatus.
+func (m *Manager) PushTxMessage(msg models.Message) (string, error) {
+	ids, err := m.PushTxMessages([]models.Message{msg})
+	if err != nil {
+		return "", err
+	}
+
+	return ids[0], nil
+}
+
+// PushTxMessages queues a set of transactional messages, eg: the messages to all
+// the recipients of a tx request, and returns their IDs. Either all the messages
+// are queued or, if the queue doesn't have room for all of them, none are and
+// ErrTxQueueFull is returned.
+func (m *Manager) PushTxMessages(msgs []models.Message) ([]string, error) {
+	// Pushes are serialized so that the room in the queue, which the workers
+	// only ever increase, can't be taken by another push after it's checked.
+	m.txPushMut.Lock()
+	defer m.txPushMut.Unlock()
+
+	if cap(m.txQ)-len(m.txQ) < len(msgs) {
+		return nil, ErrTxQueueFull
+	}
+
+	ids := make([]string, len(msgs))
+	for i, msg := range msgs {
+		ids[i] = uuid.Must(uuid.NewV4()).String()
+
+		// Record the status before queuing so that a quick worker doesn't
+		// update it before it's there.
+		m.txStatuses.add(ids[i])
+		m.txQ <- txMessage{id: ids[i], msg: msg}
+	}
+
+	return ids, nil
+}
+
+// GetTxStatus returns the status of a tx message by its ID.
+func (m *Manager) GetTxStatus(id string) (TxStatus, bool) {
+	m.txStatuses.mut.RLock()
+	s, ok := m.txStatuses.items[id]
+	m.txStatuses.mut.RUnlock()
+
+	return s, ok
+}
+
+// txWorker is a blocking function that sends messages from the tx queue.
+// It isn't subject to the campaign message rate so that transactional messages
+// aren't held up behind large campaigns.
+func (m *Manager) txWorker() {
+	for t := range m.txQ {
+		msgr, ok := m.messengers[t.msg.Messenger]
+		if !ok {
+			m.txStatuses.set(t.id, TxStatusFailed, errors.New("unknown messenger "+t.msg.Messenger))
+			continue
+		}
+
+		err := msgr.Push(t.msg)
+
+		// The messenger's servers are at their per-minute sending limits.
+		// The message stays queued and is retried once they free up.
+		var rateErr *sendlimit.ErrRateLimit
+		if errors.As(err, &rateErr) {
+			go m.requeue(rateErr.RetryAfter, func() {
+				select {
+				case m.txQ <- t:
+				case <-m.closed:
+				}
+			})
+			continue
+		}
+
+		if err != nil {
+			m.log.Printf("error sending tx message '%s': %v", t.msg.Subject, err)
+			m.txStatuses.set(t.id, TxStatusFailed, err)
+			continue
+		}
+
+		m.txStatuses.set(t.id, TxStatusSent, nil)
+	}
+}
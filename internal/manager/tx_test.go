@@ -0,0 +1,50 @@
+package manager
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// TestPushTxMessages checks that the messages of a tx request are either all
+// queued or, if the queue doesn't have room, none are.
+func TestPushTxMessages(t *testing.T) {
+	// The manager isn't run, so the queue isn't drained.
+	m := newTestManager(Config{TxQueueSize: 3}, &testStore{})
+
+	msgs := make([]models.Message, 4)
+	if _, err := m.PushTxMessages(msgs); !errors.Is(err, ErrTxQueueFull) {
+		t.Fatalf("expected ErrTxQueueFull, got %v", err)
+	}
+	if len(m.txQ) != 0 || len(m.txStatuses.items) != 0 {
+		t.Fatalf("expected nothing to be queued, got %d messages and %d statuses", len(m.txQ), len(m.txStatuses.items))
+	}
+
+	ids, err := m.PushTxMessages(msgs[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Fatalf("unexpected IDs %v", ids)
+	}
+	for _, id := range ids {
+		if s, ok := m.GetTxStatus(id); !ok || s.Status != TxStatusQueued {
+			t.Fatalf("expected %s to be queued, got %+v", id, s)
+		}
+	}
+
+	// There's room for one more.
+	if _, err := m.PushTxMessages(msgs[:2]); !errors.Is(err, ErrTxQueueFull) {
+		t.Fatalf("expected ErrTxQueueFull, got %v", err)
+	}
+	if len(m.txQ) != 2 {
+		t.Fatalf("expected 2 queued messages, got %d", len(m.txQ))
+	}
+	if _, err := m.PushTxMessage(msgs[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.PushTxMessage(msgs[0]); !errors.Is(err, ErrTxQueueFull) {
+		t.Fatalf("expected ErrTxQueueFull, got %v", err)
+	}
+}
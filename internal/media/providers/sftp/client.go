@@ -0,0 +1,106 @@
+package sftp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// conn is an SFTP session over an SSH connection.
+type conn struct {
+	nc  *deadlineConn
+	ssh *ssh.Client
+	sc  *sftp.Client
+}
+
+// deadlineConn extends the deadline of the connection on every write so that
+// long transfers aren't timed out as long as they progress.
+type deadlineConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (d *deadlineConn) Write(b []byte) (int, error) {
+	d.Conn.SetDeadline(time.Now().Add(d.timeout))
+	return d.Conn.Write(b)
+}
+
+// dial connects to the SSH server and starts an SFTP session.
+func dial(addr string, cfg *ssh.ClientConfig, timeout time.Duration) (*conn, error) {
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	nc := &deadlineConn{Conn: c, timeout: timeout}
+	nc.SetDeadline(time.Now().Add(timeout))
+
+	sc, chans, reqs, err := ssh.NewClientConn(nc, addr, cfg)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	cl := ssh.NewClient(sc, chans, reqs)
+
+	s, err := sftp.NewClient(cl)
+	if err != nil {
+		cl.Close()
+		return nil, err
+	}
+
+	return &conn{nc: nc, ssh: cl, sc: s}, nil
+}
+
+// close closes the session and the connection.
+func (c *conn) close() {
+	c.sc.Close()
+	c.ssh.Close()
+}
+
+// writeFile creates or truncates a file and writes the contents of r into it.
+func (c *conn) writeFile(name string, r io.Reader) error {
+	f, err := c.sc.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.ReadFrom(r); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// readFile reads a whole file.
+func (c *conn) readFile(name string) ([]byte, error) {
+	f, err := c.sc.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return io.ReadAll(f)
+}
+
+// remove deletes a file.
+func (c *conn) remove(name string) error {
+	return c.sc.Remove(name)
+}
+
+// mkdir creates a directory and its parents.
+func (c *conn) mkdir(name string) error {
+	return c.sc.MkdirAll(name)
+}
+
+// isStatusErr checks whether an error is a definite response from the server,
+// eg: permission denied, as opposed to a connection error. Such operations
+// aren't retried.
+func isStatusErr(err error) bool {
+	var sErr *sftp.StatusError
+	return errors.As(err, &sErr) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission)
+}
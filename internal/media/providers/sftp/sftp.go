@@ -0,0 +1,247 @@
+// Package sftp implements a media store on a remote server over SFTP, eg: a
+// NAS shared by multiple listmonk instances. The files are expected to be
+// served publicly from PublicURL, eg: by a web server on the storage.
+package sftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/media"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultPort     = 22
+	defaultMaxConns = 2
+	defaultTimeout  = 10 * time.Second
+
+	// Number of times an operation is tried on connection errors.
+	maxTries      = 3
+	retryInterval = time.Second
+)
+
+// Opt represents SFTP specific params.
+type Opt struct {
+	Host     string `koanf:"host"`
+	Port     int    `koanf:"port"`
+	Username string `koanf:"username"`
+
+	// Password or PEM encoded (unencrypted) private key, or both.
+	Password   string `koanf:"password"`
+	PrivateKey string `koanf:"private_key"`
+
+	// HostKey is the server's public key in the authorized_keys format, eg:
+	// "ssh-ed25519 AAAA...". It's required to verify the server.
+	HostKey string `koanf:"host_key"`
+
+	// Path is the directory on the server in which files are stored.
+	Path string `koanf:"path"`
+
+	// PublicURL is the URL at which the files in Path are served.
+	PublicURL string `koanf:"public_url"`
+
+	MaxConns int           `koanf:"max_conns"`
+	Timeout  time.Duration `koanf:"timeout"`
+}
+
+// Client implements `media.Store` for the SFTP provider.
+type Client struct {
+	opt  Opt
+	addr string
+	cfg  *ssh.ClientConfig
+
+	// Idle connections and a semaphore that caps the total connections.
+	idle chan *conn
+	sem  chan struct{}
+}
+
+// New initialises store for the SFTP provider. Connections are made lazily
+// and are reused.
+func New(opt Opt) (media.Store, error) {
+	if opt.Host == "" {
+		return nil, errors.New("host is empty")
+	}
+	if opt.Path == "" {
+		return nil, errors.New("path is empty")
+	}
+	if opt.PublicURL == "" {
+		return nil, errors.New("public_url is empty")
+	}
+	if opt.Port == 0 {
+		opt.Port = defaultPort
+	}
+	if opt.MaxConns < 1 {
+		opt.MaxConns = defaultMaxConns
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = defaultTimeout
+	}
+	opt.PublicURL = strings.TrimRight(opt.PublicURL, "/")
+
+	var auth []ssh.AuthMethod
+	if opt.PrivateKey != "" {
+		key, err := ssh.ParsePrivateKey([]byte(opt.PrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("error parsing private key: %v", err)
+		}
+		auth = append(auth, ssh.PublicKeys(key))
+	}
+	if opt.Password != "" {
+		auth = append(auth, ssh.Password(opt.Password))
+	}
+	if len(auth) == 0 {
+		return nil, errors.New("password or private_key is required")
+	}
+
+	if strings.TrimSpace(opt.HostKey) == "" {
+		return nil, errors.New("host_key is required to verify the server")
+	}
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(opt.HostKey))
+	if err != nil {
+		return nil, fmt.Errorf("error parsing host key: %v", err)
+	}
+
+	return &Client{
+		opt:  opt,
+		addr: net.JoinHostPort(opt.Host, strconv.Itoa(opt.Port)),
+		cfg: &ssh.ClientConfig{
+			User:            opt.Username,
+			Auth:            auth,
+			HostKeyCallback: ssh.FixedHostKey(hostKey),
+			Timeout:         opt.Timeout,
+		},
+		idle: make(chan *conn, opt.MaxConns),
+		sem:  make(chan struct{}, opt.MaxConns),
+	}, nil
+}
+
+// Put takes in the filename, the content type and file object itself and uploads it.
+func (c *Client) Put(name string, cType string, file io.ReadSeeker) (string, error) {
+	err := c.do(func(cn *conn) error {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return cn.writeFile(c.path(name), file)
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// GetURL accepts a filename and returns its public URL.
+func (c *Client) GetURL(name string) string {
+	return c.opt.PublicURL + "/" + url.PathEscape(name)
+}
+
+// GetBlob accepts a URL and returns the contents of the file.
+func (c *Client) GetBlob(u string) ([]byte, error) {
+	// The URL is the public URL of the file.
+	name := path.Base(u)
+	if n, err := url.PathUnescape(name); err == nil {
+		name = n
+	}
+
+	var b []byte
+	err := c.do(func(cn *conn) error {
+		var err error
+		b, err = cn.readFile(c.path(name))
+		return err
+	})
+
+	return b, err
+}
+
+// Delete accepts a filename and deletes it. A missing file isn't an error.
+func (c *Client) Delete(name string) error {
+	return c.do(func(cn *conn) error {
+		err := cn.remove(c.path(name))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	})
+}
+
+// do runs an operation on a pooled connection. On connection errors, the
+// connection is discarded and the operation is retried on a new one.
+// SFTP errors (eg: permission denied) are returned as is.
+func (c *Client) do(fn func(*conn) error) error {
+	var err error
+	for n := range maxTries {
+		if n > 0 {
+			time.Sleep(retryInterval * time.Duration(n))
+		}
+
+		var cn *conn
+		cn, err = c.get()
+		if err != nil {
+			continue
+		}
+
+		err = fn(cn)
+		if err == nil || isStatusErr(err) {
+			c.put(cn)
+			return err
+		}
+
+		// Connection error. Discard the connection.
+		cn.close()
+		<-c.sem
+	}
+
+	return err
+}
+
+// get returns an idle connection or a new one. It blocks if the maximum
+// number of connections are in use.
+func (c *Client) get() (*conn, error) {
+	c.sem <- struct{}{}
+
+	select {
+	case cn := <-c.idle:
+		return cn, nil
+	default:
+	}
+
+	cn, err := dial(c.addr, c.cfg, c.opt.Timeout)
+	if err != nil {
+		<-c.sem
+		return nil, err
+	}
+
+	// Create the directory if it doesn't exist. The error is ignored as
+	// it most likely exists.
+	_ = cn.mkdir(c.opt.Path)
+
+	return cn, nil
+}
+
+// put returns a connection to the pool.
+func (c *Client) put(cn *conn) {
+	// Clear the deadline of the last operation so that the idle connection
+	// isn't timed out.
+	cn.nc.SetDeadline(time.Time{})
+
+	select {
+	case c.idle <- cn:
+	default:
+		cn.close()
+	}
+	<-c.sem
+}
+
+// path returns the path of a file on the server.
+func (c *Client) path(name string) string {
+	return path.Join(c.opt.Path, path.Base(name))
+}
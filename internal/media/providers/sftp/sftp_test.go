@@ -0,0 +1,218 @@
+package sftp
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testServer is an in-process SSH server with the SFTP subsystem that serves
+// the local filesystem.
+type testServer struct {
+	addr    string
+	hostKey string
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "listmonk" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, os.ErrPermission
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveConn(nc, cfg)
+		}
+	}()
+
+	return &testServer{
+		addr:    ln.Addr().String(),
+		hostKey: string(ssh.MarshalAuthorizedKey(signer.PublicKey())),
+	}
+}
+
+func serveConn(nc net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(nc, cfg)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for nch := range chans {
+		if nch.ChannelType() != "session" {
+			nch.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, reqs, err := nch.Accept()
+		if err != nil {
+			continue
+		}
+
+		go func() {
+			for req := range reqs {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				srv, err := sftp.NewServer(ch)
+				if err != nil {
+					ch.Close()
+					return
+				}
+				srv.Serve()
+				srv.Close()
+				return
+			}
+		}()
+	}
+}
+
+func (s *testServer) opt(dir string) Opt {
+	host, port, _ := net.SplitHostPort(s.addr)
+	p, _ := strconv.Atoi(port)
+
+	return Opt{
+		Host:      host,
+		Port:      p,
+		Username:  "listmonk",
+		Password:  "secret",
+		HostKey:   s.hostKey,
+		Path:      dir,
+		PublicURL: "https://cdn.example.com/uploads/",
+	}
+}
+
+func TestPutGetDelete(t *testing.T) {
+	var (
+		srv = newTestServer(t)
+		dir = filepath.Join(t.TempDir(), "uploads")
+	)
+
+	st, err := New(srv.opt(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Larger than a single SFTP packet.
+	data := bytes.Repeat([]byte("listmonk"), 20000)
+	name, err := st.Put("file name.png", "image/png", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	if b, err := os.ReadFile(filepath.Join(dir, name)); err != nil || !bytes.Equal(b, data) {
+		t.Fatalf("uploaded file doesn't match: %v", err)
+	}
+
+	u := st.GetURL(name)
+	if u != "https://cdn.example.com/uploads/file%20name.png" {
+		t.Fatalf("unexpected URL %s", u)
+	}
+
+	b, err := st.GetBlob(u)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Fatal("downloaded file doesn't match")
+	}
+
+	if err := st.Delete(name); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+		t.Fatal("file wasn't deleted")
+	}
+
+	// Deleting a missing file isn't an error.
+	if err := st.Delete(name); err != nil {
+		t.Fatalf("delete missing: %v", err)
+	}
+
+	// A missing file is an SFTP error that isn't retried.
+	if _, err := st.GetBlob(u); err == nil || !isStatusErr(err) {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+}
+
+func TestHostKey(t *testing.T) {
+	srv := newTestServer(t)
+
+	// The host key is required.
+	o := srv.opt(t.TempDir())
+	o.HostKey = ""
+	if _, err := New(o); err == nil || !strings.Contains(err.Error(), "host_key") {
+		t.Fatalf("expected a host_key error, got %v", err)
+	}
+
+	// A server with a different key is refused.
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	o.HostKey = string(ssh.MarshalAuthorizedKey(k))
+	st, err := New(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Put("a.txt", "text/plain", strings.NewReader("a")); err == nil {
+		t.Fatal("expected the upload to a server with a different host key to fail")
+	}
+}
+
+func TestAuthFailure(t *testing.T) {
+	srv := newTestServer(t)
+
+	o := srv.opt(t.TempDir())
+	o.Password = "wrong"
+	st, err := New(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Put("a.txt", "text/plain", strings.NewReader("a")); err == nil {
+		t.Fatal("expected the upload with a wrong password to fail")
+	}
+}
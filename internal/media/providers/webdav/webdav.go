@@ -0,0 +1,237 @@
+// Package webdav implements a media store on a WebDAV server, eg: a NAS shared
+// by multiple listmonk instances.
+package webdav
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/knadh/listmonk/internal/media"
+)
+
+const (
+	defaultTimeout = 30 * time.Second
+
+	// Number of times a request is tried on connection errors and 5xx responses.
+	maxTries      = 3
+	retryInterval = time.Second
+)
+
+// Opt represents WebDAV specific params.
+type Opt struct {
+	// URL is the URL of the collection (directory) in which files are stored,
+	// eg: https://nas.local/webdav/listmonk.
+	URL      string `koanf:"url"`
+	Username string `koanf:"username"`
+	Password string `koanf:"password"`
+
+	// PublicURL is the URL at which the files are served publicly. Defaults to URL.
+	PublicURL string `koanf:"public_url"`
+
+	Timeout time.Duration `koanf:"timeout"`
+}
+
+// Client implements `media.Store` for the WebDAV provider.
+type Client struct {
+	opt Opt
+	hc  *http.Client
+}
+
+// New initialises store for the WebDAV provider.
+func New(opt Opt) (media.Store, error) {
+	u, err := url.Parse(opt.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("invalid URL")
+	}
+	opt.URL = strings.TrimRight(opt.URL, "/")
+
+	if opt.PublicURL == "" {
+		opt.PublicURL = opt.URL
+	}
+	opt.PublicURL = strings.TrimRight(opt.PublicURL, "/")
+
+	if opt.Timeout <= 0 {
+		opt.Timeout = defaultTimeout
+	}
+
+	return &Client{
+		opt: opt,
+		hc: &http.Client{
+			Timeout: opt.Timeout,
+			Transport: &http.Transport{
+				Proxy:               http.ProxyFromEnvironment,
+				MaxIdleConnsPerHost: 4,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}, nil
+}
+
+// Put takes in the filename, the content type and file object itself and uploads it.
+// If the collection doesn't exist, it's created.
+func (c *Client) Put(name string, cType string, file io.ReadSeeker) (string, error) {
+	put := func() (*http.Response, error) {
+		return c.do(http.MethodPut, c.fileURL(name), file, cType)
+	}
+
+	resp, err := put()
+	if err != nil {
+		return "", err
+	}
+
+	// The parent collection doesn't exist. Create it and try again.
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound {
+		drain(resp)
+		if err := c.mkcol(); err != nil {
+			return "", err
+		}
+		if resp, err = put(); err != nil {
+			return "", err
+		}
+	}
+
+	if err := checkStatus(resp, http.StatusOK, http.StatusCreated, http.StatusNoContent); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// GetURL accepts a filename and returns its public URL.
+func (c *Client) GetURL(name string) string {
+	return c.opt.PublicURL + "/" + url.PathEscape(name)
+}
+
+// GetBlob accepts a URL and returns the contents of the file.
+func (c *Client) GetBlob(u string) ([]byte, error) {
+	// The URL is the public URL of the file.
+	name := path.Base(u)
+	if n, err := url.PathUnescape(name); err == nil {
+		name = n
+	}
+
+	resp, err := c.do(http.MethodGet, c.fileURL(name), nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp, http.StatusOK); err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// Delete accepts a filename and deletes it. A missing file isn't an error.
+func (c *Client) Delete(name string) error {
+	resp, err := c.do(http.MethodDelete, c.fileURL(name), nil, "")
+	if err != nil {
+		return err
+	}
+
+	return checkStatus(resp, http.StatusOK, http.StatusNoContent, http.StatusNotFound)
+}
+
+// mkcol creates the collection. It may already exist if there was a race.
+func (c *Client) mkcol() error {
+	resp, err := c.do("MKCOL", c.opt.URL+"/", nil, "")
+	if err != nil {
+		return err
+	}
+
+	return checkStatus(resp, http.StatusCreated, http.StatusMethodNotAllowed)
+}
+
+// do makes a request and retries it on connection errors and 5xx responses.
+// The body is rewound before each try.
+func (c *Client) do(method, u string, body io.ReadSeeker, cType string) (*http.Response, error) {
+	var (
+		resp *http.Response
+		err  error
+	)
+	for n := range maxTries {
+		if n > 0 {
+			time.Sleep(retryInterval * time.Duration(n))
+		}
+
+		var (
+			r    io.Reader
+			size int64
+		)
+		if body != nil {
+			// Get the size so that the body isn't sent chunked, which some servers reject.
+			var err error
+			if size, err = body.Seek(0, io.SeekEnd); err != nil {
+				return nil, err
+			}
+			if _, err := body.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			r = io.NopCloser(body)
+		}
+
+		req, rErr := http.NewRequest(method, u, r)
+		if rErr != nil {
+			return nil, rErr
+		}
+		if body != nil {
+			req.ContentLength = size
+		}
+		if cType != "" {
+			req.Header.Set("Content-Type", cType)
+		}
+		if c.opt.Username != "" || c.opt.Password != "" {
+			req.SetBasicAuth(c.opt.Username, c.opt.Password)
+		}
+
+		resp, err = c.hc.Do(req)
+		if err != nil {
+			continue
+		}
+		if resp.StatusCode >= http.StatusInternalServerError && n < maxTries-1 {
+			drain(resp)
+			continue
+		}
+
+		return resp, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+// fileURL returns the WebDAV URL of a file.
+func (c *Client) fileURL(name string) string {
+	return c.opt.URL + "/" + url.PathEscape(path.Base(name))
+}
+
+// checkStatus checks whether the response has one of the expected statuses
+// and drains the body if it doesn't or if it's not needed.
+func checkStatus(resp *http.Response, codes ...int) error {
+	for _, c := range codes {
+		if resp.StatusCode == c {
+			if resp.Request.Method != http.MethodGet {
+				drain(resp)
+			}
+			return nil
+		}
+	}
+
+	drain(resp)
+	return fmt.Errorf("webdav: %s", resp.Status)
+}
+
+func drain(resp *http.Response) {
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+}
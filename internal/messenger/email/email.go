@@ -2,12 +2,19 @@ package email
 
 import (
 	"crypto/tls"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"math/rand"
 	"net/smtp"
 	"net/textproto"
+	"os"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/knadh/listmonk/internal/sendlimit"
+	"github.com/knadh/listmonk/internal/verp"
 	"github.com/knadh/listmonk/models"
 	"github.com/knadh/smtppool/v2"
 )
@@ -18,6 +25,10 @@ const (
 	hdrReturnPath = "Return-Path"
 	hdrBcc        = "Bcc"
 	hdrCc         = "Cc"
+
+	// Slack for the MIME headers and boundaries in the upper bound of
+	// a message's size that's checked before composing it.
+	mimeOverhead = 16 * 1024
 )
 
 // Server represents an SMTP server's credentials.
@@ -31,18 +42,52 @@ type Server struct {
 	TLSSkipVerify bool              `json:"tls_skip_verify"`
 	EmailHeaders  map[string]string `json:"email_headers"`
 
+	// EnvelopeFrom is the optional envelope sender of all e-mails sent via the
+	// server. It's overridden by a message's Return-Path header and VERP.
+	EnvelopeFrom string `json:"envelope_from"`
+
+	// MaxMessageSizeMB is the maximum size of a composed message including its
+	// attachments. Larger messages are rejected with models.ErrMessageTooLarge
+	// without attempting delivery. 0 disables the check.
+	MaxMessageSizeMB int `json:"max_message_size_mb"`
+
+	// Provider is the optional name of a known provider in sendlimit.Providers
+	// whose published sending limits apply to the server.
+	Provider string `json:"provider"`
+
+	// Weight is the server's share of the messages relative to the other servers.
+	// If none of the servers have weights, they're weighted equally. Otherwise,
+	// servers without weights are only used on failover.
+	Weight int `json:"weight"`
+
+	// SendLimit overrides the provider's limits. 0 values use the provider's.
+	SendLimit sendlimit.Limit `json:"send_limit"`
+
 	// Rest of the options are embedded directly from the smtppool lib.
 	// The JSON tag is for config unmarshal to work.
 	//lint:ignore SA5008 ,squash is needed by koanf/mapstructure config unmarshal.
 	smtppool.Opt `json:",squash"`
 
-	pool *smtppool.Pool
+	pool   *smtppool.Pool
+	health *serverHealth
 }
 
 // Emailer is the SMTP e-mail messenger.
 type Emailer struct {
 	servers []*Server
 	name    string
+
+	// Whether any of the servers have weights.
+	weighted bool
+
+	// Optional VERP encoder for the envelope sender of campaign messages.
+	verp *verp.VERP
+
+	// Optional limiter of the servers' sending rates.
+	limiter *sendlimit.Limiter
+
+	// Global headers attached to every e-mail.
+	headers textproto.MIMEHeader
 }
 
 // New returns an SMTP e-mail Messenger backend with the given SMTP servers.
@@ -71,6 +116,15 @@ func New(name string, servers ...Server) (*Emailer, error) {
 		}
 		s.Opt.Auth = auth
 
+		// Without a HELO hostname, the pool identifies as "localhost", which
+		// strict receivers reject. Fall back to the OS hostname.
+		s.HelloHostname = strings.TrimSpace(s.HelloHostname)
+		if s.HelloHostname == "" {
+			if h, err := os.Hostname(); err == nil {
+				s.HelloHostname = h
+			}
+		}
+
 		// TLS config.
 		s.Opt.SSL = smtppool.SSLNone
 		if s.TLSType != "none" {
@@ -96,49 +150,271 @@ func New(name string, servers ...Server) (*Emailer, error) {
 		}
 
 		s.pool = pool
+		s.health = &serverHealth{}
 		e.servers = append(e.servers, &s)
+
+		if s.Weight > 0 {
+			e.weighted = true
+		}
 	}
 
 	return e, nil
 }
 
+// SetVERP sets the VERP encoder that's used to set the envelope sender of
+// campaign messages to a signed address that identifies the campaign and
+// subscriber for bounce attribution. The From header is untouched.
+func (e *Emailer) SetVERP(v *verp.VERP) {
+	e.verp = v
+}
+
+// SetHeaders sets the global headers that are attached to every e-mail sent via
+// the messenger. The SMTP level and e-mail level headers take precedence over them.
+func (e *Emailer) SetHeaders(h models.Headers) {
+	e.headers = textproto.MIMEHeader{}
+	for _, set := range h {
+		for k, v := range set {
+			e.headers.Set(k, v)
+		}
+	}
+}
+
+// SetLimiter sets the limiter that enforces the servers' sending limits and
+// registers the limits of the servers with it.
+func (e *Emailer) SetLimiter(l *sendlimit.Limiter) error {
+	for _, s := range e.servers {
+		lim, err := s.Limit()
+		if err != nil {
+			return err
+		}
+		if err := l.Add(s.Key(), lim); err != nil {
+			return err
+		}
+	}
+
+	e.limiter = l
+	return nil
+}
+
 // Name returns the messenger's name.
 func (e *Emailer) Name() string {
 	return e.name
 }
 
-// Push pushes a message to the server.
+// Push pushes a message to the server. If sending fails due to the server, eg: it's
+// unreachable, the server is skipped for a cooldown period and the message is
+// retried on the remaining servers.
 func (e *Emailer) Push(m models.Message) error {
-	// If there are more than one SMTP servers, send to a random
-	// one from the list.
 	var (
-		ln  = len(e.servers)
-		srv *Server
+		tried   []*Server
+		lastErr error
+	)
+	for len(tried) < len(e.servers) {
+		srv, err := e.pickServer(tried)
+		if err != nil {
+			// A rate limited server frees up shortly, so the message is requeued
+			// for it instead of failing with the error of the servers tried.
+			var rErr *sendlimit.ErrRateLimit
+			if lastErr != nil && !errors.As(err, &rErr) {
+				return lastErr
+			}
+			return err
+		}
+
+		err = e.send(srv, m)
+		if err == nil {
+			srv.health.ok()
+			return nil
+		}
+
+		// Only sent messages count against the server's limits.
+		if e.limiter != nil {
+			e.limiter.Release(srv.Key())
+		}
+		if !isServerErr(err) {
+			return err
+		}
+
+		srv.health.fail(err)
+		tried = append(tried, srv)
+		lastErr = err
+	}
+
+	return lastErr
+}
+
+// send sends a message via the given server.
+func (e *Emailer) send(srv *Server, m models.Message) error {
+	em, err := e.makeEmail(srv, m)
+	if err != nil {
+		return err
+	}
+
+	if err := srv.checkSize(&em); err != nil {
+		return err
+	}
+
+	return srv.pool.Send(em)
+}
+
+// pickServer returns a server, excluding the ones already tried for the message,
+// that's within its sending limits. Servers are picked at random in proportion to
+// their weights, and healthy servers are preferred over the ones in their cooldown
+// periods. The picked server's limits are taken for the message. If all the servers
+// are at their limits, *sendlimit.ErrRateLimit with the time after which the earliest
+// one frees up is returned, or if all of them are at their daily limits,
+// *sendlimit.ErrDailyLimit.
+func (e *Emailer) pickServer(tried []*Server) (*Server, error) {
+	servers := e.weightedOrder(e.candidates(tried))
+	if e.limiter == nil {
+		return servers[0], nil
+	}
+
+	var (
+		wait time.Duration
+		lErr error
+	)
+	for _, s := range servers {
+		w, err := e.limiter.Take(s.Key())
+		if err != nil {
+			lErr = err
+			continue
+		}
+		if w == 0 {
+			return s, nil
+		}
+		if wait == 0 || w < wait {
+			wait = w
+		}
+	}
+
+	if wait > 0 {
+		return nil, &sendlimit.ErrRateLimit{RetryAfter: wait}
+	}
+	return nil, lErr
+}
+
+// candidates returns the servers that haven't been tried for a message. Healthy
+// servers with weights are preferred, followed by the healthy servers without
+// weights, which are only used on failover. If there are none, the unhealthy
+// ones are returned so that the message is attempted anyway.
+func (e *Emailer) candidates(tried []*Server) []*Server {
+	var healthy, failover, down []*Server
+	for _, s := range e.servers {
+		if slices.Contains(tried, s) {
+			continue
+		}
+
+		switch {
+		case !s.health.healthy():
+			down = append(down, s)
+		case e.weight(s) > 0:
+			healthy = append(healthy, s)
+		default:
+			failover = append(failover, s)
+		}
+	}
+
+	if len(healthy) > 0 {
+		return healthy
+	}
+	if len(failover) > 0 {
+		return failover
+	}
+	return down
+}
+
+// weightedOrder returns the servers in a random order where servers with higher
+// weights are more likely to come first. Servers without weights come last.
+func (e *Emailer) weightedOrder(servers []*Server) []*Server {
+	var (
+		out   = make([]*Server, 0, len(servers))
+		rest  = slices.Clone(servers)
+		total = 0
 	)
-	if ln > 1 {
-		srv = e.servers[rand.Intn(ln)]
-	} else {
-		srv = e.servers[0]
+	for _, s := range rest {
+		total += e.weight(s)
+	}
+
+	for total > 0 {
+		n := rand.Intn(total)
+		for i, s := range rest {
+			if n -= e.weight(s); n < 0 {
+				out = append(out, s)
+				total -= e.weight(s)
+				rest = slices.Delete(rest, i, i+1)
+				break
+			}
+		}
 	}
 
-	// Are there attachments?
+	// Servers without weights in a random order.
+	rand.Shuffle(len(rest), func(i, j int) {
+		rest[i], rest[j] = rest[j], rest[i]
+	})
+
+	return append(out, rest...)
+}
+
+// weight returns the server's weight. Without weights on any of the
+// servers, they're weighted equally.
+func (e *Emailer) weight(s *Server) int {
+	if !e.weighted {
+		return 1
+	}
+
+	return max(s.Weight, 0)
+}
+
+// MaxMessageSize returns the smallest maximum message size in bytes among
+// the messenger's servers. It's 0 if none of the servers limit message sizes.
+func (e *Emailer) MaxMessageSize() int64 {
+	var out int64
+	for _, s := range e.servers {
+		if n := s.maxSize(); n > 0 && (out == 0 || n < out) {
+			out = n
+		}
+	}
+
+	return out
+}
+
+// MessageSize returns the size of the message in bytes when it's composed
+// into a MIME message with its attachments.
+func (e *Emailer) MessageSize(m models.Message) (int64, error) {
+	em, err := e.makeEmail(e.servers[0], m)
+	if err != nil {
+		return 0, err
+	}
+
+	b, err := em.Bytes()
+	if err != nil {
+		return 0, err
+	}
+
+	return int64(len(b)), nil
+}
+
+// makeEmail creates the e-mail to be sent to the given server from a message.
+func (e *Emailer) makeEmail(srv *Server, m models.Message) (smtppool.Email, error) {
+	// Are there attachments? Their contents are shared by all the messages of a
+	// campaign and are only read while composing, so they aren't copied per message.
 	var files []smtppool.Attachment
 	if m.Attachments != nil {
 		files = make([]smtppool.Attachment, 0, len(m.Attachments))
 		for _, f := range m.Attachments {
-			a := smtppool.Attachment{
+			files = append(files, smtppool.Attachment{
 				Filename: f.Name,
 				Header:   f.Header,
-				Content:  make([]byte, len(f.Content)),
-			}
-			copy(a.Content, f.Content)
-			files = append(files, a)
+				Content:  f.Content,
+			})
 		}
 	}
 
-	// Create the email.
+	// Create the email. Without an explicit Sender, the envelope sender is From.
 	em := smtppool.Email{
 		From:        m.From,
+		Sender:      srv.EnvelopeFrom,
 		To:          m.To,
 		Subject:     m.Subject,
 		Attachments: files,
@@ -146,14 +422,19 @@ func (e *Emailer) Push(m models.Message) error {
 
 	em.Headers = textproto.MIMEHeader{}
 
-	// Attach SMTP level headers.
+	// Attach global headers.
+	for k, v := range e.headers {
+		em.Headers[k] = slices.Clone(v)
+	}
+
+	// Attach SMTP level headers. These take precedence over the global headers.
 	for k, v := range srv.EmailHeaders {
 		em.Headers.Set(k, v)
 	}
 
-	// Attach e-mail level headers.
+	// Attach e-mail level headers. These take precedence over the SMTP level headers.
 	for k, v := range m.Headers {
-		em.Headers.Set(k, v[0])
+		em.Headers[textproto.CanonicalMIMEHeaderKey(k)] = v
 	}
 
 	// If the `Return-Path` header is set, it should be set as the
@@ -163,6 +444,15 @@ func (e *Emailer) Push(m models.Message) error {
 		em.Headers.Del(hdrReturnPath)
 	}
 
+	// VERP envelope sender for campaign messages. This takes precedence over Return-Path.
+	if e.verp != nil && m.Campaign != nil && m.Subscriber.UUID != "" {
+		sender, err := e.verp.Encode(m.Campaign.UUID, m.Subscriber.UUID)
+		if err != nil {
+			return smtppool.Email{}, err
+		}
+		em.Sender = sender
+	}
+
 	// If the `Bcc` header is set, it should be set on the Envelope
 	if bcc := em.Headers.Get(hdrBcc); bcc != "" {
 		for _, part := range strings.Split(bcc, ",") {
@@ -189,7 +479,72 @@ func (e *Emailer) Push(m models.Message) error {
 		}
 	}
 
-	return srv.pool.Send(em)
+	return em, nil
+}
+
+// Key returns the key that identifies the server's account in the send limiter.
+func (s *Server) Key() string {
+	return fmt.Sprintf("%s@%s:%d", s.Username, s.Host, s.Port)
+}
+
+// Limit returns the server's sending limits, which are its provider's
+// limits overridden by its own.
+func (s *Server) Limit() (sendlimit.Limit, error) {
+	var out sendlimit.Limit
+	if s.Provider != "" {
+		p, ok := sendlimit.Providers[s.Provider]
+		if !ok {
+			return out, fmt.Errorf("unknown SMTP provider '%s'", s.Provider)
+		}
+		out = p
+	}
+
+	if s.SendLimit.PerDay > 0 {
+		out.PerDay = s.SendLimit.PerDay
+	}
+	if s.SendLimit.PerMinute > 0 {
+		out.PerMinute = s.SendLimit.PerMinute
+	}
+
+	return out, nil
+}
+
+// maxSize returns the server's maximum message size in bytes.
+func (s *Server) maxSize() int64 {
+	return int64(s.MaxMessageSizeMB) * 1024 * 1024
+}
+
+// checkSize returns models.ErrMessageTooLarge if the composed e-mail exceeds
+// the server's maximum message size. As composing a message is expensive, it's
+// only done if a generous upper bound of the size exceeds the limit.
+func (s *Server) checkSize(em *smtppool.Email) error {
+	limit := s.maxSize()
+	if limit <= 0 {
+		return nil
+	}
+
+	// Quoted-printable bodies can at most triple in size and base64 attachments
+	// grow by a third plus line breaks.
+	upper := int64(mimeOverhead + len(em.Subject) + 3*(len(em.HTML)+len(em.Text)))
+	for k, v := range em.Headers {
+		upper += int64(len(k) + len(strings.Join(v, ", ")) + 4)
+	}
+	for _, a := range em.Attachments {
+		upper += int64(base64.StdEncoding.EncodedLen(len(a.Content))*78/76 + 2)
+	}
+	if upper <= limit {
+		return nil
+	}
+
+	b, err := em.Bytes()
+	if err != nil {
+		return err
+	}
+	if n := int64(len(b)); n > limit {
+		return &models.ErrMessageTooLarge{Size: n, Limit: limit}
+	}
+
+	return nil
 }
 
 // Flush flushes the message queue to the server.
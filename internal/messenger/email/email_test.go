@@ -0,0 +1,914 @@
+package email
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/sendlimit"
+	"github.com/knadh/listmonk/internal/verp"
+	"github.com/knadh/listmonk/models"
+	"github.com/knadh/smtppool/v2"
+)
+
+// nopStore is a sendlimit.Store that doesn't save anything.
+type nopStore struct{}
+
+func (nopStore) GetSendCounts(time.Time) (map[string]int, error) { return nil, nil }
+func (nopStore) AddSendCounts(time.Time, map[string]int) error   { return nil }
+
+func TestPushLimits(t *testing.T) {
+	e, err := New("email", Server{
+		TLSType:          "none",
+		MaxMessageSizeMB: 1,
+		SendLimit:        sendlimit.Limit{PerMinute: 1},
+		Opt:              smtppool.Opt{Host: "127.0.0.1", Port: 1, MaxConns: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	l := sendlimit.New(nopStore{}, log.New(io.Discard, "", 0))
+	if err := e.SetLimiter(l); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages that fail to send don't count against the limits, so the
+	// second one isn't rate limited.
+	big := models.Message{
+		From:    "from@example.com",
+		To:      []string{"to@example.com"},
+		Subject: "test",
+		Body:    []byte(strings.Repeat("a", 2*1024*1024)),
+	}
+	for i := range 2 {
+		var sErr *models.ErrMessageTooLarge
+		if err := e.Push(big); !errors.As(err, &sErr) {
+			t.Fatalf("push %d: expected the size error, got %v", i, err)
+		}
+	}
+
+	// At the limit, Push returns the time to retry after without blocking.
+	if w, err := l.Take(e.servers[0].Key()); w != 0 || err != nil {
+		t.Fatalf("unexpected limit %v %v", w, err)
+	}
+
+	start := time.Now()
+	err = e.Push(big)
+
+	var rErr *sendlimit.ErrRateLimit
+	if !errors.As(err, &rErr) {
+		t.Fatalf("expected the rate limit error, got %v", err)
+	}
+	if rErr.RetryAfter <= 0 || rErr.RetryAfter > time.Minute {
+		t.Errorf("unexpected retry after %v", rErr.RetryAfter)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected Push to return without waiting, took %v", d)
+	}
+}
+
+// TestPushFailoverRateLimit checks that a message that fails over to a server
+// at its limit is requeued with the rate limit error instead of failing.
+func TestPushFailoverRateLimit(t *testing.T) {
+	fallback := newFakeSMTP(t, "none")
+
+	srv := fallback.server()
+	srv.SendLimit = sendlimit.Limit{PerMinute: 1}
+	e, err := New("email", Server{Weight: 1, TLSType: "none", Opt: smtppool.Opt{Host: "127.0.0.1", Port: 1, MaxConns: 1}}, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	l := sendlimit.New(nopStore{}, log.New(io.Discard, "", 0))
+	if err := e.SetLimiter(l); err != nil {
+		t.Fatal(err)
+	}
+
+	// The fallback server is at its limit when the unreachable one fails.
+	if w, err := l.Take(e.servers[1].Key()); w != 0 || err != nil {
+		t.Fatalf("unexpected limit %v %v", w, err)
+	}
+
+	err = e.Push(testMsg("to@example.com"))
+
+	var rErr *sendlimit.ErrRateLimit
+	if !errors.As(err, &rErr) || rErr.RetryAfter <= 0 {
+		t.Fatalf("expected the rate limit error, got %v", err)
+	}
+	if n := len(fallback.getMsgs()); n != 0 {
+		t.Errorf("expected no messages on the fallback server, got %d", n)
+	}
+}
+
+// smtpMsg is a message received by fakeSMTP.
+type smtpMsg struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// fakeSMTP is a minimal SMTP server that records the messages sent to it.
+type fakeSMTP struct {
+	ln     net.Listener
+	port   int
+	tlsCfg *tls.Config
+
+	// tlsType is the server's TLS mode as in Server.TLSType.
+	tlsType string
+
+	// Recipients with this prefix are rejected with a 550.
+	reject string
+
+	mut      sync.Mutex
+	hellos   []string
+	msgs     []smtpMsg
+	rejected int
+}
+
+// newFakeSMTP starts a fake SMTP server with the given TLS mode (none, TLS, STARTTLS).
+func newFakeSMTP(t *testing.T, tlsType string) *fakeSMTP {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &fakeSMTP{
+		ln:      ln,
+		port:    ln.Addr().(*net.TCPAddr).Port,
+		tlsType: tlsType,
+		tlsCfg:  &tls.Config{Certificates: []tls.Certificate{newTestCert(t)}},
+	}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+
+	return s
+}
+
+// server returns the listmonk SMTP server config for the fake server. Its pool's
+// idle connections are swept in the background so that closing it doesn't block.
+func (s *fakeSMTP) server() Server {
+	return Server{
+		TLSType:       s.tlsType,
+		TLSSkipVerify: true,
+		Opt:           smtppool.Opt{Host: "127.0.0.1", Port: s.port, MaxConns: 2, IdleTimeout: 2 * time.Second},
+	}
+}
+
+func (s *fakeSMTP) serve(c net.Conn) {
+	defer func() { c.Close() }()
+
+	isTLS := false
+	if s.tlsType == "TLS" {
+		c = tls.Server(c, s.tlsCfg)
+		isTLS = true
+	}
+
+	tp := textproto.NewConn(c)
+	tp.PrintfLine("220 fake ESMTP")
+
+	var msg smtpMsg
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		addr := func() string {
+			_, a, _ := strings.Cut(arg, "<")
+			a, _, _ = strings.Cut(a, ">")
+			return a
+		}
+
+		switch strings.ToUpper(cmd) {
+		case "EHLO", "HELO":
+			s.mut.Lock()
+			s.hellos = append(s.hellos, arg)
+			s.mut.Unlock()
+
+			if s.tlsType == "STARTTLS" && !isTLS {
+				tp.PrintfLine("250-fake")
+				tp.PrintfLine("250 STARTTLS")
+			} else {
+				tp.PrintfLine("250 fake")
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 ready")
+			c = tls.Server(c, s.tlsCfg)
+			tp = textproto.NewConn(c)
+			isTLS = true
+		case "MAIL":
+			msg = smtpMsg{From: addr()}
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			if a := addr(); s.reject != "" && strings.HasPrefix(a, s.reject) {
+				s.mut.Lock()
+				s.rejected++
+				s.mut.Unlock()
+				tp.PrintfLine("550 5.1.1 no such user")
+			} else {
+				msg.To = append(msg.To, a)
+				tp.PrintfLine("250 ok")
+			}
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			b, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.Data = b
+
+			s.mut.Lock()
+			s.msgs = append(s.msgs, msg)
+			s.mut.Unlock()
+			tp.PrintfLine("250 ok")
+		case "RSET", "NOOP":
+			tp.PrintfLine("250 ok")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+func (s *fakeSMTP) getMsgs() []smtpMsg {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return slices.Clone(s.msgs)
+}
+
+func (s *fakeSMTP) getRejected() int {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.rejected
+}
+
+// newTestCert returns a self-signed certificate for 127.0.0.1.
+func newTestCert(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func testMsg(to ...string) models.Message {
+	return models.Message{
+		From:        "from@example.com",
+		To:          to,
+		Subject:     "test",
+		ContentType: "plain",
+		Body:        []byte("hello"),
+	}
+}
+
+// TestWeightedPick checks that servers are picked in proportion to their weights,
+// and that servers without weights are only picked on failover.
+func TestWeightedPick(t *testing.T) {
+	pick := func(e *Emailer, n int) map[string]int {
+		out := map[string]int{}
+		for range n {
+			s, err := e.pickServer(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out[s.Name]++
+		}
+		return out
+	}
+	// The servers are only picked and not connected to, so the pools aren't closed.
+	newEmailer := func(weights ...int) *Emailer {
+		var servers []Server
+		for i, w := range weights {
+			servers = append(servers, Server{
+				Name:    fmt.Sprintf("s%d", i),
+				Weight:  w,
+				TLSType: "none",
+				Opt:     smtppool.Opt{Host: "127.0.0.1", Port: 1, MaxConns: 1},
+			})
+		}
+		e, err := New("email", servers...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	const n = 5000
+	cases := []struct {
+		weights []int
+		exp     []float64
+	}{
+		{[]int{9, 1}, []float64{0.9, 0.1}},
+		{[]int{0, 0, 0}, []float64{1.0 / 3, 1.0 / 3, 1.0 / 3}},
+		{[]int{3, 1, 0}, []float64{0.75, 0.25, 0}},
+	}
+	for _, c := range cases {
+		got := pick(newEmailer(c.weights...), n)
+		for i, exp := range c.exp {
+			name := fmt.Sprintf("s%d", i)
+			if share := float64(got[name]) / n; math.Abs(share-exp) > 0.03 {
+				t.Errorf("weights %v: expected %s to get %.2f of the messages, got %.2f", c.weights, name, exp, share)
+			}
+			if exp == 0 && got[name] > 0 {
+				t.Errorf("weights %v: expected %s to never be picked, got %d", c.weights, name, got[name])
+			}
+		}
+	}
+
+	// Servers without weights are used when the weighted ones are down.
+	e := newEmailer(9, 1, 0)
+	e.servers[0].health.fail(errors.New("down"))
+	if got := pick(e, 100); got["s1"] != 100 {
+		t.Errorf("expected only s1 to be picked, got %v", got)
+	}
+	e.servers[1].health.fail(errors.New("down"))
+	if got := pick(e, 100); got["s2"] != 100 {
+		t.Errorf("expected only s2 to be picked, got %v", got)
+	}
+}
+
+// TestFailoverUnweighted checks that messages fail over to a server without a weight.
+func TestFailoverUnweighted(t *testing.T) {
+	fallback := newFakeSMTP(t, "none")
+
+	srv := fallback.server()
+	e, err := New("email", Server{Weight: 1, TLSType: "none", Opt: smtppool.Opt{Host: "127.0.0.1", Port: 1, MaxConns: 1}}, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	if err := e.Push(testMsg("to@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(fallback.getMsgs()); n != 1 {
+		t.Errorf("expected the message on the fallback server, got %d", n)
+	}
+}
+
+// TestHelloHostname checks that the configured HELO hostname, or the OS hostname
+// without one, is sent with EHLO on plain, STARTTLS, and TLS connections.
+func TestHelloHostname(t *testing.T) {
+	osHost, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, typ := range []string{"none", "STARTTLS", "TLS"} {
+		for hello, exp := range map[string]string{"mail.example.com": "mail.example.com", "  ": osHost} {
+			s := newFakeSMTP(t, typ)
+
+			srv := s.server()
+			srv.HelloHostname = hello
+			e, err := New("email", srv)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := e.Push(testMsg("to@example.com")); err != nil {
+				t.Fatalf("%s: unexpected error: %v", typ, err)
+			}
+			e.Close()
+
+			s.mut.Lock()
+			hellos := slices.Clone(s.hellos)
+			s.mut.Unlock()
+
+			// STARTTLS connections say EHLO again after the upgrade.
+			n := 1
+			if typ == "STARTTLS" {
+				n = 2
+			}
+			if len(hellos) != n {
+				t.Fatalf("%s: expected %d EHLOs, got %v", typ, n, hellos)
+			}
+			for _, h := range hellos {
+				if h != exp {
+					t.Errorf("%s: expected EHLO %q, got %q", typ, exp, h)
+				}
+			}
+		}
+	}
+}
+
+// TestAttachments sends a message with an HTML and plain text body and a PDF
+// attachment, and checks that it parses back into the right MIME parts.
+func TestAttachments(t *testing.T) {
+	s := newFakeSMTP(t, "none")
+	e, err := New("email", s.server())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	pdf := []byte("%PDF-1.4\n1 0 obj << /Type /Catalog >> endobj\ntrailer << /Root 1 0 R >>\n%%EOF\n")
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Disposition", "attachment; filename=invoice.pdf")
+	h.Set("Content-Type", `application/pdf; name="invoice.pdf"`)
+	h.Set("Content-Transfer-Encoding", "base64")
+
+	m := testMsg("to@example.com")
+	m.ContentType = "html"
+	m.Body = []byte("<p>Hello</p>")
+	m.AltBody = []byte("Hello")
+	m.Attachments = []models.Attachment{{Name: "invoice.pdf", Header: h, Content: pdf}}
+
+	// The attachment's contents aren't copied into the e-mail.
+	em, err := e.makeEmail(e.servers[0], m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if &em.Attachments[0].Content[0] != &pdf[0] {
+		t.Error("expected the attachment's contents to not be copied")
+	}
+
+	if err := e.Push(m); err != nil {
+		t.Fatal(err)
+	}
+	msgs := s.getMsgs()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(msgs[0].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := readParts(t, msg.Header.Get("Content-Type"), msg.Body)
+	if len(parts) != 2 || parts[0].typ != "multipart/alternative" {
+		t.Fatalf("expected the body and the attachment, got %+v", parts)
+	}
+
+	body := readParts(t, parts[0].header.Get("Content-Type"), bytes.NewReader(parts[0].body))
+	if len(body) != 2 || body[0].typ != "text/plain" || string(body[0].body) != "Hello" ||
+		body[1].typ != "text/html" || string(body[1].body) != "<p>Hello</p>" {
+		t.Errorf("unexpected body parts %+v", body)
+	}
+
+	a := parts[1]
+	if a.typ != "application/pdf" || a.filename != "invoice.pdf" {
+		t.Errorf("unexpected attachment %s %s", a.typ, a.filename)
+	}
+	b, err := base64.StdEncoding.DecodeString(string(a.body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, pdf) {
+		t.Errorf("unexpected attachment contents %q", b)
+	}
+}
+
+type mimePart struct {
+	typ      string
+	filename string
+	header   textproto.MIMEHeader
+	body     []byte
+}
+
+// readParts reads the parts of a multipart body. Quoted-printable parts are decoded.
+func readParts(t *testing.T, contentType string, r io.Reader) []mimePart {
+	t.Helper()
+
+	typ, params, err := mime.ParseMediaType(contentType)
+	if err != nil || !strings.HasPrefix(typ, "multipart/") {
+		t.Fatalf("expected a multipart type, got %q: %v", contentType, err)
+	}
+
+	var (
+		out []mimePart
+		mr  = multipart.NewReader(r, params["boundary"])
+	)
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		b, err := io.ReadAll(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		typ, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+
+		// Strip the line breaks of base64 bodies.
+		if strings.EqualFold(p.Header.Get("Content-Transfer-Encoding"), "base64") {
+			b = []byte(strings.Join(strings.Fields(string(b)), ""))
+		}
+		out = append(out, mimePart{typ: typ, filename: p.FileName(), header: p.Header, body: b})
+	}
+
+	return out
+}
+
+// TestRecipients checks that messages are sent from their From addresses to all
+// their To, Cc, and Bcc recipients, and that Bcc recipients aren't in the headers.
+func TestRecipients(t *testing.T) {
+	s := newFakeSMTP(t, "none")
+
+	srv := s.server()
+	srv.Username = "smtp-user"
+	e, err := New("email", srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	m := testMsg("to1@example.com", "to2@example.com")
+	m.From = "News <news@example.com>"
+	m.Headers = textproto.MIMEHeader{}
+	m.Headers.Set("Cc", "cc@example.com")
+	m.Headers.Set("Bcc", "bcc1@example.com, bcc2@example.com")
+
+	// Bcc only.
+	bm := testMsg()
+	bm.Headers = textproto.MIMEHeader{}
+	bm.Headers.Set("Bcc", "bcc@example.com")
+
+	for _, m := range []models.Message{m, bm} {
+		if err := e.Push(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs := s.getMsgs()
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+
+	cases := []struct {
+		from string
+		rcpt []string
+		to   string
+		cc   string
+	}{
+		{"news@example.com", []string{"to1@example.com", "to2@example.com", "cc@example.com", "bcc1@example.com", "bcc2@example.com"},
+			"<to1@example.com>, <to2@example.com>", "<cc@example.com>"},
+		{"from@example.com", []string{"bcc@example.com"}, "", ""},
+	}
+	for i, c := range cases {
+		got := msgs[i]
+		if got.From != c.from {
+			t.Errorf("%d: expected the envelope sender %s, got %s", i, c.from, got.From)
+		}
+		slices.Sort(got.To)
+		slices.Sort(c.rcpt)
+		if !slices.Equal(got.To, c.rcpt) {
+			t.Errorf("%d: expected the recipients %v, got %v", i, c.rcpt, got.To)
+		}
+
+		msg, err := mail.ReadMessage(bytes.NewReader(got.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := msg.Header
+		if a, err := mail.ParseAddress(h.Get("From")); err != nil || a.Address != c.from {
+			t.Errorf("%d: unexpected From header %s", i, h.Get("From"))
+		}
+		if h.Get("To") != c.to || h.Get("Cc") != c.cc {
+			t.Errorf("%d: unexpected To and Cc headers %q %q", i, h.Get("To"), h.Get("Cc"))
+		}
+		if _, ok := h["Bcc"]; ok || strings.Contains(string(got.Data), "bcc") {
+			t.Errorf("%d: expected the Bcc recipients to not be in the message", i)
+		}
+	}
+}
+
+// TestEnvelopeFrom checks that the server's envelope sender is used for the
+// envelope, but not for the From header, and that Return-Path overrides it.
+func TestEnvelopeFrom(t *testing.T) {
+	s := newFakeSMTP(t, "none")
+
+	srv := s.server()
+	srv.EnvelopeFrom = "bounces@example.com"
+	e, err := New("email", srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	rm := testMsg("to@example.com")
+	rm.Headers = textproto.MIMEHeader{}
+	rm.Headers.Set("Return-Path", "return@example.com")
+
+	for _, m := range []models.Message{testMsg("to@example.com"), rm} {
+		if err := e.Push(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs := s.getMsgs()
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+	for i, exp := range []string{"bounces@example.com", "return@example.com"} {
+		if msgs[i].From != exp {
+			t.Errorf("%d: expected the envelope sender %s, got %s", i, exp, msgs[i].From)
+		}
+
+		msg, err := mail.ReadMessage(bytes.NewReader(msgs[i].Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a, err := mail.ParseAddress(msg.Header.Get("From")); err != nil || a.Address != "from@example.com" {
+			t.Errorf("%d: unexpected From header %s", i, msg.Header.Get("From"))
+		}
+		if rp := msg.Header.Get("Return-Path"); rp != "" {
+			t.Errorf("%d: expected the Return-Path header to be removed, got %s", i, rp)
+		}
+	}
+}
+
+// TestVERP checks that campaign messages are sent with the signed VERP envelope
+// sender of the campaign and subscriber, and that other messages aren't.
+func TestVERP(t *testing.T) {
+	const (
+		campUUID = "2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11"
+		subUUID  = "7c1e0b7e-3f38-4b47-9f6a-0b1e2d3c4f5a"
+	)
+
+	s := newFakeSMTP(t, "none")
+	srv := s.server()
+	srv.EnvelopeFrom = "bounces@example.com"
+	e, err := New("email", srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	v, err := verp.New(verp.Opt{Domain: "bounces.example.com", Prefix: "bounce", Scheme: verp.SchemeCompact, Secret: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.SetVERP(v)
+
+	camp := testMsg("to@example.com")
+	camp.Campaign = &models.Campaign{UUID: campUUID}
+	camp.Subscriber = models.Subscriber{UUID: subUUID}
+	camp.Headers = textproto.MIMEHeader{}
+	camp.Headers.Set("Return-Path", "return@example.com")
+
+	for _, m := range []models.Message{camp, testMsg("to@example.com")} {
+		if err := e.Push(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	msgs := s.getMsgs()
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+
+	// The campaign message's envelope sender identifies it and the header From is untouched.
+	if c, sub, ok := v.Decode(msgs[0].From); !ok || c != campUUID || sub != subUUID {
+		t.Errorf("expected a VERP envelope sender, got %s", msgs[0].From)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(msgs[0].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a, err := mail.ParseAddress(msg.Header.Get("From")); err != nil || a.Address != "from@example.com" {
+		t.Errorf("unexpected From header %s", msg.Header.Get("From"))
+	}
+
+	// Other messages use the server's envelope sender.
+	if msgs[1].From != "bounces@example.com" {
+		t.Errorf("expected the server's envelope sender, got %s", msgs[1].From)
+	}
+
+	// Campaign messages with invalid UUIDs aren't sent.
+	camp.Subscriber.UUID = "sub"
+	if err := e.Push(camp); err == nil {
+		t.Error("expected an invalid subscriber UUID to fail")
+	}
+}
+
+// TestHeaderPrecedence checks that the SMTP level headers override the global
+// headers and that the campaign's own headers override both.
+func TestHeaderPrecedence(t *testing.T) {
+	s := newFakeSMTP(t, "none")
+
+	srv := s.server()
+	srv.EmailHeaders = map[string]string{"X-Server": "smtp", "X-Campaign-Type": "smtp"}
+	e, err := New("email", srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	e.SetHeaders(models.Headers{{"X-Global": "global"}, {"X-Server": "global"}, {"X-Campaign-Type": "global"}})
+
+	m := testMsg("to@example.com")
+	m.Headers = textproto.MIMEHeader{}
+	m.Headers.Set("X-Campaign-Type", "campaign")
+	if err := e.Push(m); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := s.getMsgs()
+	if len(msgs) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(msgs))
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(msgs[0].Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]string{"X-Global": "global", "X-Server": "smtp", "X-Campaign-Type": "campaign"} {
+		if got := msg.Header[k]; len(got) != 1 || got[0] != v {
+			t.Errorf("expected %s %s, got %v", k, v, got)
+		}
+	}
+}
+
+// TestMessageSize checks that messages over a server's maximum size are
+// rejected without attempting delivery.
+func TestMessageSize(t *testing.T) {
+	const mb = 1024 * 1024
+
+	s := newFakeSMTP(t, "none")
+	srv := s.server()
+	srv.MaxMessageSizeMB = 1
+	e, err := New("email", srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	withAttachment := func(n int) models.Message {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Disposition", "attachment; filename=file.bin")
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("Content-Transfer-Encoding", "base64")
+
+		m := testMsg("to@example.com")
+		m.Attachments = []models.Attachment{{Name: "file.bin", Header: h, Content: bytes.Repeat([]byte{0xab}, n)}}
+		return m
+	}
+
+	cases := []struct {
+		name     string
+		size     int
+		tooLarge bool
+	}{
+		{"small", 100 * 1024, false},
+
+		// Below the limit, but over the upper bound that skips composing the message.
+		{"just under the limit", 760000, false},
+		{"just over the limit", 768000, true},
+		{"oversized", 2 * mb, true},
+	}
+	for _, c := range cases {
+		m := withAttachment(c.size)
+		size, err := e.MessageSize(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (size > mb) != c.tooLarge {
+			t.Fatalf("%s: unexpected composed size %d", c.name, size)
+		}
+
+		before := len(s.getMsgs())
+		err = e.Push(m)
+
+		var tErr *models.ErrMessageTooLarge
+		if !c.tooLarge {
+			if err != nil {
+				t.Errorf("%s: expected the message to be sent, got %v", c.name, err)
+			}
+			if len(s.getMsgs()) != before+1 {
+				t.Errorf("%s: expected the message to be delivered", c.name)
+			}
+			continue
+		}
+
+		if !errors.As(err, &tErr) || tErr.Size <= mb || tErr.Limit != mb {
+			t.Errorf("%s: expected ErrMessageTooLarge, got %v", c.name, err)
+		}
+		if len(s.getMsgs()) != before {
+			t.Errorf("%s: expected no delivery attempt", c.name)
+		}
+	}
+
+	// A rejected message isn't a server failure.
+	if h := e.Health(); len(h) != 1 || !h[0].Healthy {
+		t.Errorf("expected the server to be healthy, got %+v", h)
+	}
+}
+
+func TestMaxMessageSize(t *testing.T) {
+	var servers []*Server
+	for _, n := range []int{0, 5, 2, 10} {
+		servers = append(servers, &Server{MaxMessageSizeMB: n})
+	}
+
+	cases := []struct {
+		servers []*Server
+		exp     int64
+	}{
+		{servers[:1], 0},
+		{servers[:2], 5 * 1024 * 1024},
+		{servers, 2 * 1024 * 1024},
+	}
+	for _, c := range cases {
+		e := &Emailer{servers: c.servers}
+		if n := e.MaxMessageSize(); n != c.exp {
+			t.Errorf("expected %d, got %d", c.exp, n)
+		}
+	}
+}
+
+// TestReplyTo checks that the message's Reply-To is sent once over the global
+// and SMTP level headers.
+func TestReplyTo(t *testing.T) {
+	s := newFakeSMTP(t, "none")
+
+	srv := s.server()
+	srv.EmailHeaders = map[string]string{"Reply-To": "smtp@example.com"}
+	e, err := New("email", srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+	e.SetHeaders(models.Headers{{"Reply-To": "global@example.com"}})
+
+	for _, c := range []struct {
+		replyTo string
+		name    string
+		addr    string
+	}{
+		{"", "", "smtp@example.com"},
+		{"Replies <reply@example.com>", "Replies", "reply@example.com"},
+	} {
+		m := testMsg("to@example.com")
+		m.Headers = textproto.MIMEHeader{}
+		if c.replyTo != "" {
+			m.Headers.Set("Reply-To", c.replyTo)
+		}
+		if err := e.Push(m); err != nil {
+			t.Fatal(err)
+		}
+
+		msgs := s.getMsgs()
+		msg, err := mail.ReadMessage(bytes.NewReader(msgs[len(msgs)-1].Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := msg.Header["Reply-To"]
+		if len(got) != 1 {
+			t.Fatalf("expected one Reply-To, got %v", got)
+		}
+		if a, err := mail.ParseAddress(got[0]); err != nil || a.Name != c.name || a.Address != c.addr {
+			t.Errorf("expected Reply-To %s <%s>, got %v", c.name, c.addr, got)
+		}
+	}
+}
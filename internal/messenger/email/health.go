@@ -0,0 +1,123 @@
+package email
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Duration for which a server is skipped after a send fails due to it.
+const serverCooldown = time.Minute
+
+// ServerHealth represents the health of an SMTP server.
+type ServerHealth struct {
+	Name    string `json:"name"`
+	Host    string `json:"host"`
+	Port    int    `json:"port"`
+	Healthy bool   `json:"healthy"`
+
+	// Time until which the server is skipped and the error that caused it.
+	DownUntil null.Time `json:"down_until"`
+	Error     string    `json:"error"`
+}
+
+// serverHealth tracks the failures of a server.
+type serverHealth struct {
+	mut       sync.RWMutex
+	downUntil time.Time
+	err       string
+}
+
+// fail marks the server as unhealthy for the cooldown period.
+func (h *serverHealth) fail(err error) {
+	h.mut.Lock()
+	h.downUntil = time.Now().Add(serverCooldown)
+	h.err = err.Error()
+	h.mut.Unlock()
+}
+
+// ok marks the server as healthy.
+func (h *serverHealth) ok() {
+	h.mut.RLock()
+	down := !h.downUntil.IsZero()
+	h.mut.RUnlock()
+	if !down {
+		return
+	}
+
+	h.mut.Lock()
+	h.downUntil = time.Time{}
+	h.err = ""
+	h.mut.Unlock()
+}
+
+// healthy returns true if the server isn't in its cooldown period.
+func (h *serverHealth) healthy() bool {
+	h.mut.RLock()
+	defer h.mut.RUnlock()
+
+	return time.Now().After(h.downUntil)
+}
+
+// Health returns the health of the messenger's servers.
+func (e *Emailer) Health() []ServerHealth {
+	out := make([]ServerHealth, 0, len(e.servers))
+	for _, s := range e.servers {
+		s.health.mut.RLock()
+		h := ServerHealth{
+			Name:    s.Name,
+			Host:    s.Host,
+			Port:    s.Port,
+			Healthy: time.Now().After(s.health.downUntil),
+		}
+		if !h.Healthy {
+			h.DownUntil = null.TimeFrom(s.health.downUntil)
+			h.Error = s.health.err
+		}
+		s.health.mut.RUnlock()
+
+		out = append(out, h)
+	}
+
+	return out
+}
+
+// isServerErr checks whether a send error is caused by the server, eg: it's
+// unreachable, unavailable, or rejects the credentials, rather than by the
+// message or its recipients. Such messages can be sent via another server.
+func isServerErr(err error) bool {
+	var tErr *textproto.Error
+	if errors.As(err, &tErr) {
+		switch tErr.Code {
+		// Service not available, TLS not available, and auth failures.
+		case 421, 454, 530, 534, 535:
+			return true
+		}
+
+		// Other replies, eg: 5xx rejections of recipients, are about the message.
+		return false
+	}
+
+	var (
+		netErr  net.Error
+		certErr *tls.CertificateVerificationError
+		hostErr x509.HostnameError
+		recErr  tls.RecordHeaderError
+	)
+	switch {
+	case errors.As(err, &netErr), errors.As(err, &certErr), errors.As(err, &hostErr), errors.As(err, &recErr),
+		errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+
+	// Missing STARTTLS or AUTH extensions on the server.
+	return strings.HasSuffix(err.Error(), "extension not found")
+}
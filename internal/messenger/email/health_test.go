@@ -0,0 +1,121 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"testing"
+	"time"
+
+	"github.com/knadh/smtppool/v2"
+)
+
+// TestFailover sends messages via two servers, one of which is down, and checks
+// that they're all sent via the other one and that the dead one is skipped.
+func TestFailover(t *testing.T) {
+	live := newFakeSMTP(t, "none")
+
+	// A closed port.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := Server{Name: "dead", TLSType: "none", Opt: smtppool.Opt{Host: "127.0.0.1", Port: ln.Addr().(*net.TCPAddr).Port, MaxConns: 1}}
+	ln.Close()
+
+	srv := live.server()
+	srv.Name = "live"
+	e, err := New("email", dead, srv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	for i := range 20 {
+		if err := e.Push(testMsg(fmt.Sprintf("to%d@example.com", i))); err != nil {
+			t.Fatalf("push %d: unexpected error: %v", i, err)
+		}
+	}
+	if n := len(live.getMsgs()); n != 20 {
+		t.Fatalf("expected 20 messages on the live server, got %d", n)
+	}
+
+	h := e.Health()
+	if len(h) != 2 || h[0].Name != "dead" || h[1].Name != "live" {
+		t.Fatalf("unexpected health %+v", h)
+	}
+	if h[0].Healthy || h[0].Error == "" || !h[0].DownUntil.Valid || time.Until(h[0].DownUntil.Time) > serverCooldown {
+		t.Errorf("expected the dead server to be down for the cooldown, got %+v", h[0])
+	}
+	if !h[1].Healthy || h[1].Error != "" || h[1].DownUntil.Valid {
+		t.Errorf("expected the live server to be healthy, got %+v", h[1])
+	}
+
+	// The dead server is only retried after the cooldown. Without any other
+	// server, it's tried anyway.
+	if c := e.candidates(nil); len(c) != 1 || c[0].Name != "live" {
+		t.Errorf("expected only the live server to be a candidate, got %d", len(c))
+	}
+	if c := e.candidates([]*Server{e.servers[1]}); len(c) != 1 || c[0].Name != "dead" {
+		t.Errorf("expected the dead server to be a candidate on failover, got %d", len(c))
+	}
+
+	// A server recovers when a message is sent via it.
+	e.servers[1].health.fail(errors.New("down"))
+	if err := e.Push(testMsg("to@example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if h := e.Health(); !h[1].Healthy {
+		t.Errorf("expected the live server to recover, got %+v", h[1])
+	}
+}
+
+// TestFailoverRejections checks that rejections of a message's recipients
+// aren't retried on other servers or count against the server's health.
+func TestFailoverRejections(t *testing.T) {
+	a, b := newFakeSMTP(t, "none"), newFakeSMTP(t, "none")
+	a.reject, b.reject = "bad", "bad"
+
+	e, err := New("email", a.server(), b.server())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	var tErr *textproto.Error
+	if err := e.Push(testMsg("bad@example.com")); !errors.As(err, &tErr) || tErr.Code != 550 {
+		t.Fatalf("expected the rejection, got %v", err)
+	}
+	if n := a.getRejected() + b.getRejected(); n != 1 {
+		t.Errorf("expected the message to be attempted once, got %d", n)
+	}
+	for _, h := range e.Health() {
+		if !h.Healthy {
+			t.Errorf("expected the servers to be healthy, got %+v", h)
+		}
+	}
+}
+
+func TestIsServerErr(t *testing.T) {
+	cases := []struct {
+		err error
+		exp bool
+	}{
+		{&textproto.Error{Code: 421, Msg: "service not available"}, true},
+		{&textproto.Error{Code: 535, Msg: "authentication failed"}, true},
+		{fmt.Errorf("error: %w", &textproto.Error{Code: 454, Msg: "TLS not available"}), true},
+		{&textproto.Error{Code: 550, Msg: "no such user"}, false},
+		{&textproto.Error{Code: 552, Msg: "message too large"}, false},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, true},
+		{io.EOF, true},
+		{errors.New("SMTP STARTTLS extension not found"), true},
+		{errors.New("mail: missing @ in addr-spec"), false},
+	}
+	for _, c := range cases {
+		if got := isServerErr(c.err); got != c.exp {
+			t.Errorf("%v: expected %v, got %v", c.err, c.exp, got)
+		}
+	}
+}
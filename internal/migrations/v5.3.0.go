@@ -0,0 +1,936 @@
+package migrations
+
+import (
+	"log"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/koanf/v2"
+	"github.com/knadh/stuffbin"
+)
+
+func V5_3_0(db *sqlx.DB, fs stuffbin.FileSystem, ko *koanf.Koanf, lo *log.Logger) error {
+	// Add tags to templates and index campaign and template tags.
+	_, err := db.Exec(`
+		ALTER TABLE templates ADD COLUMN IF NOT EXISTS tags VARCHAR(100)[];
+		CREATE INDEX IF NOT EXISTS idx_camps_tags ON campaigns USING GIN(tags);
+		CREATE INDEX IF NOT EXISTS idx_tpls_tags ON templates USING GIN(tags);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Campaign tags are now matched case insensitively. Normalize the existing tags
+	// like core.normalizeTags does: trimmed, lowercased, spaces replaced with dashes,
+	// and without duplicates.
+	_, err = db.Exec(`
+		WITH norm AS (
+			SELECT c.id, COALESCE(
+				(SELECT ARRAY_AGG(t ORDER BY pos) FROM (
+					SELECT DISTINCT ON (t) t, pos FROM (
+						SELECT LOWER(REGEXP_REPLACE(REGEXP_REPLACE(u.tag, '^\s+|\s+$', '', 'g'), '\s+', '-', 'g')) AS t, u.pos
+						FROM UNNEST(c.tags) WITH ORDINALITY AS u(tag, pos)
+					) n WHERE t != '' ORDER BY t, pos
+				) d), '{}') AS tags
+			FROM campaigns c WHERE c.tags IS NOT NULL
+		)
+		UPDATE campaigns SET tags = norm.tags FROM norm
+			WHERE campaigns.id = norm.id AND campaigns.tags::TEXT[] IS DISTINCT FROM norm.tags;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Add per-campaign tracking modes and the global default.
+	_, err = db.Exec(`
+		DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'tracking_mode') THEN
+				CREATE TYPE tracking_mode AS ENUM ('full', 'clicks_only', 'none');
+			END IF;
+		END $$;
+
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS tracking_mode tracking_mode NOT NULL DEFAULT 'full';
+		INSERT INTO settings (key, value, updated_at) VALUES ('privacy.tracking_mode', '"full"', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('privacy.link_attribs', '[]', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// UTM parameters for campaign links.
+	_, err = db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS utm JSONB NOT NULL DEFAULT '{}';
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.utm', '{"enabled": false, "source": "listmonk", "medium": "email", "campaign": "{{ .Campaign.Name }}", "term": "", "content": ""}', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Flag bot views and clicks.
+	_, err = db.Exec(`
+		ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT false;
+		ALTER TABLE link_clicks ADD COLUMN IF NOT EXISTS is_bot BOOLEAN NOT NULL DEFAULT false;
+		INSERT INTO settings (key, value, updated_at) VALUES ('privacy.bot_filter', '{"enabled": false, "user_agents": ["bot", "crawler", "spider", "safelinks", "mimecast", "barracuda", "proofpoint", "symantec", "trendmicro", "fortinet", "python-requests", "curl/", "wget/", "go-http-client", "headlesschrome"], "grace_window": "10s", "burst_window": "10s", "burst_count": 10}', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Drip sequences triggered by list subscriptions.
+	_, err = db.Exec(`
+		DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'sequence_status') THEN
+				CREATE TYPE sequence_status AS ENUM ('active', 'paused');
+			END IF;
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'sequence_state_status') THEN
+				CREATE TYPE sequence_state_status AS ENUM ('active', 'finished', 'cancelled');
+			END IF;
+		END $$;
+
+		CREATE TABLE IF NOT EXISTS sequences (
+			id               SERIAL PRIMARY KEY,
+			uuid uuid        NOT NULL UNIQUE,
+			name             TEXT NOT NULL,
+			list_id          INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			status           sequence_status NOT NULL DEFAULT 'active',
+			enrolled_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+
+		CREATE TABLE IF NOT EXISTS sequence_steps (
+			sequence_id      INTEGER NOT NULL REFERENCES sequences(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			position         INTEGER NOT NULL,
+			delay_secs       INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY(sequence_id, position)
+		);
+		CREATE INDEX IF NOT EXISTS idx_seq_steps_camp_id ON sequence_steps(campaign_id);
+
+		CREATE TABLE IF NOT EXISTS sequence_state (
+			sequence_id      INTEGER NOT NULL REFERENCES sequences(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			step             INTEGER NOT NULL DEFAULT 1,
+			status           sequence_state_status NOT NULL DEFAULT 'active',
+			attempts         INTEGER NOT NULL DEFAULT 0,
+			subscribed_at    TIMESTAMP WITH TIME ZONE NOT NULL,
+			next_send_at     TIMESTAMP WITH TIME ZONE NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY(sequence_id, subscriber_id)
+		);
+		CREATE INDEX IF NOT EXISTS idx_seq_state_sub_id ON sequence_state(subscriber_id);
+		CREATE INDEX IF NOT EXISTS idx_seq_state_next ON sequence_state(next_send_at) WHERE status = 'active';
+		CREATE INDEX IF NOT EXISTS idx_sub_lists_list_updated ON subscriber_lists(list_id, updated_at);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Sender identities (allowed campaign from addresses and domains).
+	_, err = db.Exec(`
+		DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'sender_identity_type') THEN
+				CREATE TYPE sender_identity_type AS ENUM ('address', 'domain');
+			END IF;
+		END $$;
+
+		CREATE TABLE IF NOT EXISTS sender_identities (
+			id               SERIAL PRIMARY KEY,
+			type             sender_identity_type NOT NULL,
+			value            TEXT NOT NULL UNIQUE,
+			verified         BOOLEAN NOT NULL DEFAULT false,
+			verify_token     TEXT NULL UNIQUE,
+			verified_at      TIMESTAMP WITH TIME ZONE NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		INSERT INTO settings (key, value, updated_at) VALUES ('security.sender_identities', '{"enabled": false, "require_verification": true}', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Purging of never confirmed double opt-in subscribers and outbound webhooks.
+	_, err = db.Exec(`
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS purge_unconfirmed_after_days INTEGER NOT NULL DEFAULT 0;
+		INSERT INTO settings (key, value, updated_at) VALUES ('privacy.purge_unconfirmed_action', '"delete"', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('webhooks', '[]', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Sunset policy for inactive subscribers.
+	_, err = db.Exec(`
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS sunset_inactive_days INTEGER NULL;
+
+		CREATE TABLE IF NOT EXISTS sunset_state (
+			subscriber_id    INTEGER NOT NULL PRIMARY KEY REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			claimed_at       TIMESTAMP WITH TIME ZONE NULL,
+			notified_at      TIMESTAMP WITH TIME ZONE NULL,
+			sunset_at        TIMESTAMP WITH TIME ZONE NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		INSERT INTO settings (key, value, updated_at) VALUES ('maintenance.sunset', '{"enabled": false, "inactive_days": 365, "inactive_campaigns": 0, "final_campaign_id": 0, "grace_days": 14, "dormant_list_id": 0, "unsubscribe": false}', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Cached engagement and bounce counts by the recipients' e-mail domain.
+	_, err = db.Exec(`
+		DROP MATERIALIZED VIEW IF EXISTS mat_domain_stats;
+		CREATE MATERIALIZED VIEW mat_domain_stats AS
+		    WITH doms AS (
+		        SELECT id, LOWER(SPLIT_PART(email, '@', 2)) AS domain FROM subscribers
+		    ),
+		    sent AS (
+		        -- Recipients are estimated from the current (non-unsubscribed) subscribers of the campaigns' lists.
+		        SELECT c.id AS campaign_id, d.domain, c.started_at::DATE AS date, COUNT(DISTINCT sl.subscriber_id) AS num
+		        FROM campaigns c
+		        JOIN campaign_lists cl ON (cl.campaign_id = c.id)
+		        JOIN subscriber_lists sl ON (sl.list_id = cl.list_id AND sl.status != 'unsubscribed')
+		        JOIN doms d ON (d.id = sl.subscriber_id)
+		        WHERE c.started_at IS NOT NULL AND c.status NOT IN ('draft', 'scheduled')
+		        GROUP BY c.id, d.domain, c.started_at::DATE
+		    ),
+		    events AS (
+		        SELECT campaign_id, d.domain, v.created_at::DATE AS date, 0 AS sent, 1 AS views, 0 AS clicks, NULL::bounce_type AS bounce
+		            FROM campaign_views v JOIN doms d ON (d.id = v.subscriber_id) WHERE NOT v.is_bot
+		        UNION ALL
+		        SELECT campaign_id, d.domain, k.created_at::DATE, 0, 0, 1, NULL
+		            FROM link_clicks k JOIN doms d ON (d.id = k.subscriber_id) WHERE NOT k.is_bot
+		        UNION ALL
+		        SELECT campaign_id, d.domain, b.created_at::DATE, 0, 0, 0, b.type
+		            FROM bounces b JOIN doms d ON (d.id = b.subscriber_id)
+		        UNION ALL
+		        SELECT campaign_id, domain, date, num, 0, 0, NULL FROM sent
+		    )
+		    SELECT NOW() AS updated_at, COALESCE(campaign_id, 0) AS campaign_id, domain, date,
+		        SUM(sent) AS sent, SUM(views) AS views, SUM(clicks) AS clicks,
+		        COUNT(*) FILTER (WHERE bounce = 'soft') AS bounces_soft,
+		        COUNT(*) FILTER (WHERE bounce = 'hard') AS bounces_hard,
+		        COUNT(*) FILTER (WHERE bounce = 'complaint') AS bounces_complaint
+		    FROM events
+		    GROUP BY COALESCE(campaign_id, 0), domain, date;
+		CREATE UNIQUE INDEX IF NOT EXISTS mat_domain_stats_idx ON mat_domain_stats (campaign_id, domain, date);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Samples of campaign send errors.
+	_, err = db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS send_errors JSONB NOT NULL DEFAULT '[]'`)
+	if err != nil {
+		return err
+	}
+
+	// Dedicated transactional message workers and queue.
+	_, err = db.Exec(`
+		INSERT INTO settings (key, value, updated_at) VALUES
+			('app.tx_concurrency', '2', NOW()),
+			('app.tx_queue_size', '10000', NOW())
+		ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Campaign seed list sends.
+	_, err = db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS seed_send JSONB NULL;
+		INSERT INTO settings (key, value, updated_at) VALUES
+			('app.seed_emails', '[]', NOW()),
+			('app.require_seed_send', 'false', NOW())
+		ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Campaign bounce hygiene summaries.
+	_, err = db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS hygiene JSONB NULL`)
+	if err != nil {
+		return err
+	}
+
+	// Ad-hoc campaign recipients.
+	_, err = db.Exec(`ALTER TYPE campaign_type ADD VALUE IF NOT EXISTS 'adhoc'`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_recipients (
+			id           BIGSERIAL PRIMARY KEY,
+			campaign_id  INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			uuid         UUID NOT NULL UNIQUE DEFAULT gen_random_uuid(),
+			email        TEXT NOT NULL,
+			name         TEXT NOT NULL DEFAULT '',
+			attribs      JSONB NOT NULL DEFAULT '{}',
+			bounce_type  bounce_type NULL,
+			created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_camp_recipients_email ON campaign_recipients (campaign_id, email);
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.adhoc_recipients_retention', '"168h"', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// E-mail verification settings.
+	_, err = db.Exec(`INSERT INTO settings (key, value, updated_at) VALUES ('privacy.email_verification', '{"syntax": false, "mx": false, "typos": false, "dns_timeout": "3s", "on_api": false}', NOW()) ON CONFLICT (key) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+
+	// Campaign approvals.
+	_, err = db.Exec(`ALTER TYPE campaign_status ADD VALUE IF NOT EXISTS 'pending_approval'`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS approved_by INTEGER NULL;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS approved_at TIMESTAMP WITH TIME ZONE NULL;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS approval_comment TEXT NOT NULL DEFAULT '';
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.require_campaign_approval', 'false', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Public subscription API rate limit.
+	_, err = db.Exec(`INSERT INTO settings (key, value, updated_at) VALUES ('security.public_rate_limit', '30', NOW()) ON CONFLICT (key) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+
+	// Reverse proxies trusted for the client IPs of requests. Defaults to the
+	// loopback and private networks, which the X-Forwarded-For headers were
+	// trusted from before.
+	_, err = db.Exec(`INSERT INTO settings (key, value, updated_at) VALUES ('security.trusted_proxies', '["127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"]', NOW()) ON CONFLICT (key) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+
+	// Campaign progress milestones.
+	_, err = db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS progress_milestones INT[] NULL;
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.progress_milestones', '[25, 50, 75, 100]', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Per-subscriber campaign send history.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_sends (
+			id               BIGSERIAL PRIMARY KEY,
+			campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			messenger        TEXT NOT NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_sends_camp_id ON campaign_sends(campaign_id);
+		CREATE INDEX IF NOT EXISTS idx_sends_sub_date ON campaign_sends(subscriber_id, created_at);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Template UUIDs for portable content bundles.
+	_, err = db.Exec(`ALTER TABLE templates ADD COLUMN IF NOT EXISTS uuid UUID NOT NULL UNIQUE DEFAULT gen_random_uuid();`)
+	if err != nil {
+		return err
+	}
+
+	// Index for list growth stats.
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_sub_lists_list_created ON subscriber_lists(list_id, created_at);`)
+	if err != nil {
+		return err
+	}
+
+	// Per-user notification language.
+	_, err = db.Exec(`ALTER TABLE users ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT '';`)
+	if err != nil {
+		return err
+	}
+
+	// Per-campaign archive access and the key for signing public URLs.
+	_, err = db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS archive_access TEXT NOT NULL DEFAULT 'public'
+			CHECK (archive_access IN ('public', 'unlisted', 'password'));
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS archive_password TEXT NULL;
+		INSERT INTO settings (key, value, updated_at) VALUES ('security.signing_key', TO_JSONB(ENCODE(GEN_RANDOM_BYTES(32), 'hex')), NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Unique (distinct subscriber) views and clicks, and engagement on the dashboard.
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_views_camp_sub ON campaign_views(campaign_id, subscriber_id);
+		CREATE INDEX IF NOT EXISTS idx_clicks_camp_sub ON link_clicks(campaign_id, subscriber_id);
+
+		DROP MATERIALIZED VIEW IF EXISTS mat_dashboard_counts;
+		CREATE MATERIALIZED VIEW mat_dashboard_counts AS
+		    WITH subs AS (
+		        SELECT COUNT(*) AS num, status FROM subscribers GROUP BY status
+		    )
+		    SELECT NOW() AS updated_at,
+		        JSON_BUILD_OBJECT(
+		            'subscribers', JSON_BUILD_OBJECT(
+		                'total', (SELECT SUM(num) FROM subs),
+		                'blocklisted', (SELECT num FROM subs WHERE status='blocklisted'),
+		                'orphans', (
+		                    SELECT COUNT(id) FROM subscribers
+		                    LEFT JOIN subscriber_lists ON (subscribers.id = subscriber_lists.subscriber_id)
+		                    WHERE subscriber_lists.subscriber_id IS NULL
+		                )
+		            ),
+		            'lists', JSON_BUILD_OBJECT(
+		                'total', (SELECT COUNT(*) FROM lists),
+		                'private', (SELECT COUNT(*) FROM lists WHERE type='private'),
+		                'public', (SELECT COUNT(*) FROM lists WHERE type='public'),
+		                'optin_single', (SELECT COUNT(*) FROM lists WHERE optin='single'),
+		                'optin_double', (SELECT COUNT(*) FROM lists WHERE optin='double')
+		            ),
+		            'campaigns', JSON_BUILD_OBJECT(
+		                'total', (SELECT COUNT(*) FROM campaigns),
+		                'by_status', (
+		                    SELECT JSON_OBJECT_AGG (status, num) FROM
+		                    (SELECT status, COUNT(*) AS num FROM campaigns GROUP BY status) r
+		                )
+		            ),
+		            'messages', (SELECT SUM(sent) AS messages FROM campaigns),
+		            'engagement', (
+		                -- Unique counts are distinct subscribers per campaign. Rates are
+		                -- percentages of the delivered (sent minus bounced) messages.
+		                WITH e AS (
+		                    SELECT
+		                        (SELECT COUNT(*) FROM campaign_views WHERE NOT is_bot) AS views,
+		                        (SELECT COUNT(*) FROM (SELECT DISTINCT campaign_id, subscriber_id FROM campaign_views WHERE NOT is_bot AND subscriber_id IS NOT NULL) v) AS unique_views,
+		                        (SELECT COUNT(*) FROM link_clicks WHERE NOT is_bot) AS clicks,
+		                        (SELECT COUNT(*) FROM (SELECT DISTINCT campaign_id, subscriber_id FROM link_clicks WHERE NOT is_bot AND subscriber_id IS NOT NULL) c) AS unique_clicks,
+		                        GREATEST(COALESCE((SELECT SUM(sent) FROM campaigns), 0) - (SELECT COUNT(*) FROM bounces WHERE campaign_id IS NOT NULL), 0) AS delivered
+		                )
+		                SELECT JSON_BUILD_OBJECT('views', views, 'unique_views', unique_views,
+		                    'clicks', clicks, 'unique_clicks', unique_clicks, 'delivered', delivered,
+		                    'open_rate', COALESCE(ROUND(unique_views * 100.0 / NULLIF(delivered, 0), 2), 0),
+		                    'click_rate', COALESCE(ROUND(unique_clicks * 100.0 / NULLIF(delivered, 0), 2), 0)
+		                ) FROM e
+		            )
+		        ) AS data;
+		CREATE UNIQUE INDEX IF NOT EXISTS mat_dashboard_stats_idx ON mat_dashboard_counts (updated_at);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Per-campaign and global Reply-To addresses.
+	_, err = db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS reply_to TEXT NOT NULL DEFAULT '';
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.reply_to', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Log-only mode for bounce webhook verification.
+	_, err = db.Exec(`
+		INSERT INTO settings (key, value, updated_at) VALUES ('bounce.webhooks_log_only', 'false', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Messenger routing by recipient domain.
+	_, err = db.Exec(`
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.messenger_routes', '[]', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Campaign simulation mode.
+	_, err = db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS simulation BOOLEAN NOT NULL DEFAULT false;
+		ALTER TABLE campaign_views ADD COLUMN IF NOT EXISTS simulated BOOLEAN NOT NULL DEFAULT false;
+		ALTER TABLE campaign_sends ADD COLUMN IF NOT EXISTS simulated BOOLEAN NOT NULL DEFAULT false;
+
+		DROP MATERIALIZED VIEW IF EXISTS mat_dashboard_counts;
+		CREATE MATERIALIZED VIEW mat_dashboard_counts AS
+		    WITH subs AS (
+		        SELECT COUNT(*) AS num, status FROM subscribers GROUP BY status
+		    )
+		    SELECT NOW() AS updated_at,
+		        JSON_BUILD_OBJECT(
+		            'subscribers', JSON_BUILD_OBJECT(
+		                'total', (SELECT SUM(num) FROM subs),
+		                'blocklisted', (SELECT num FROM subs WHERE status='blocklisted'),
+		                'orphans', (
+		                    SELECT COUNT(id) FROM subscribers
+		                    LEFT JOIN subscriber_lists ON (subscribers.id = subscriber_lists.subscriber_id)
+		                    WHERE subscriber_lists.subscriber_id IS NULL
+		                )
+		            ),
+		            'lists', JSON_BUILD_OBJECT(
+		                'total', (SELECT COUNT(*) FROM lists),
+		                'private', (SELECT COUNT(*) FROM lists WHERE type='private'),
+		                'public', (SELECT COUNT(*) FROM lists WHERE type='public'),
+		                'optin_single', (SELECT COUNT(*) FROM lists WHERE optin='single'),
+		                'optin_double', (SELECT COUNT(*) FROM lists WHERE optin='double')
+		            ),
+		            'campaigns', JSON_BUILD_OBJECT(
+		                'total', (SELECT COUNT(*) FROM campaigns),
+		                'by_status', (
+		                    SELECT JSON_OBJECT_AGG (status, num) FROM
+		                    (SELECT status, COUNT(*) AS num FROM campaigns GROUP BY status) r
+		                )
+		            ),
+		            'messages', (SELECT SUM(sent) AS messages FROM campaigns WHERE NOT simulation),
+		            'engagement', (
+		                -- Unique counts are distinct subscribers per campaign. Rates are
+		                -- percentages of the delivered (sent minus bounced) messages.
+		                -- Simulated campaign runs are excluded.
+		                WITH e AS (
+		                    SELECT
+		                        (SELECT COUNT(*) FROM campaign_views WHERE NOT is_bot AND NOT simulated) AS views,
+		                        (SELECT COUNT(*) FROM (SELECT DISTINCT campaign_id, subscriber_id FROM campaign_views WHERE NOT is_bot AND NOT simulated AND subscriber_id IS NOT NULL) v) AS unique_views,
+		                        (SELECT COUNT(*) FROM link_clicks WHERE NOT is_bot) AS clicks,
+		                        (SELECT COUNT(*) FROM (SELECT DISTINCT campaign_id, subscriber_id FROM link_clicks WHERE NOT is_bot AND subscriber_id IS NOT NULL) c) AS unique_clicks,
+		                        GREATEST(COALESCE((SELECT SUM(sent) FROM campaigns WHERE NOT simulation), 0) - (SELECT COUNT(*) FROM bounces WHERE campaign_id IS NOT NULL), 0) AS delivered
+		                )
+		                SELECT JSON_BUILD_OBJECT('views', views, 'unique_views', unique_views,
+		                    'clicks', clicks, 'unique_clicks', unique_clicks, 'delivered', delivered,
+		                    'open_rate', COALESCE(ROUND(unique_views * 100.0 / NULLIF(delivered, 0), 2), 0),
+		                    'click_rate', COALESCE(ROUND(unique_clicks * 100.0 / NULLIF(delivered, 0), 2), 0)
+		                ) FROM e
+		            )
+		        ) AS data;
+		CREATE UNIQUE INDEX IF NOT EXISTS mat_dashboard_stats_idx ON mat_dashboard_counts (updated_at);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Normalized subscriber e-mails. Lowercased e-mails are already unique.
+	_, err = db.Exec(`
+		ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS email_normalized TEXT NULL;
+		UPDATE subscribers SET email_normalized = LOWER(email) WHERE email_normalized IS NULL;
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_subs_email_normalized ON subscribers(email_normalized);
+
+		INSERT INTO settings (key, value, updated_at) VALUES ('privacy.email_normalization', '{"strip_dots": false, "strip_plus": false}', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// VERP envelope senders.
+	_, err = db.Exec(`INSERT INTO settings (key, value, updated_at) VALUES ('bounce.verp', '{"enabled": false, "domain": "", "prefix": "bounce", "scheme": "compact", "secret": ""}', NOW()) ON CONFLICT (key) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+
+	// Campaign autosave drafts.
+	_, err = db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS draft JSONB NULL`)
+	if err != nil {
+		return err
+	}
+
+	// Template assets.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS template_media (
+			template_id  INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			media_id     INTEGER NOT NULL REFERENCES media(id) ON DELETE RESTRICT ON UPDATE CASCADE,
+			name         TEXT NOT NULL,
+			PRIMARY KEY (template_id, name)
+		);
+		CREATE INDEX IF NOT EXISTS idx_tpl_media_media_id ON template_media(media_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Settings version for detecting concurrent updates.
+	_, err = db.Exec(`ALTER TABLE settings ADD COLUMN IF NOT EXISTS version INT NOT NULL DEFAULT 1`)
+	if err != nil {
+		return err
+	}
+
+	// Limits on ad-hoc subscriber queries.
+	_, err = db.Exec(`INSERT INTO settings (key, value, updated_at) VALUES ('app.query_guard', '{"enabled": false, "max_cost": 1000000, "max_rows": 1000000, "action": "confirm", "timeout": "30s"}', NOW()) ON CONFLICT (key) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+
+	// Complaints have their own bounce threshold and action.
+	_, err = db.Exec(`UPDATE settings SET value = value || '{"complaint": {"count": 1, "action": "blocklist"}}'
+		WHERE key = 'bounce.actions' AND NOT (value ? 'complaint')`)
+	if err != nil {
+		return err
+	}
+
+	// Resends to non-openers are linked to their parent campaigns.
+	_, err = db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS parent_id INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL;
+		CREATE INDEX IF NOT EXISTS idx_camps_parent_id ON campaigns(parent_id);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Campaign preview (preheader) text.
+	_, err = db.Exec(`ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS preview_text TEXT NOT NULL DEFAULT ''`)
+	if err != nil {
+		return err
+	}
+
+	// Per-subscriber dynamic campaign attachments.
+	_, err = db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS dynamic_attachments JSONB NOT NULL DEFAULT '[]';
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.attachment_fetch', '{"timeout": "10s", "max_size_mb": 10, "content_types": ["application/pdf", "image/png", "image/jpeg", "image/gif", "text/plain", "text/csv"], "concurrency": 10, "cache_ttl": "10m", "cache_size_mb": 100}', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Grace period for subscriber data deletion requests.
+	_, err = db.Exec(`
+		ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS deletion_requested_at TIMESTAMP WITH TIME ZONE NULL;
+		CREATE INDEX IF NOT EXISTS idx_subs_deletion_requested_at ON subscribers(deletion_requested_at) WHERE deletion_requested_at IS NOT NULL;
+		INSERT INTO settings (key, value, updated_at) VALUES ('privacy.deletion_grace_days', '14', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Maximum size of composed messages.
+	_, err = db.Exec(`INSERT INTO settings (key, value, updated_at) VALUES ('app.max_message_size_mb', '25', NOW()) ON CONFLICT (key) DO NOTHING`)
+	if err != nil {
+		return err
+	}
+
+	// Unsubscribe reasons.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS unsubscribe_events (
+			id               BIGSERIAL PRIMARY KEY,
+			subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+			reason           TEXT NOT NULL DEFAULT '',
+			comment          TEXT NOT NULL DEFAULT '',
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_unsub_events_camp_id ON unsubscribe_events(campaign_id);
+		CREATE INDEX IF NOT EXISTS idx_unsub_events_date ON unsubscribe_events(created_at);
+		INSERT INTO settings (key, value, updated_at) VALUES ('privacy.unsubscribe_reasons', '{"enabled": false, "choices": ["I receive too many e-mails", "The content is not relevant to me", "I no longer want to receive these e-mails", "I never signed up"], "allow_text": true}', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Daily counts of messages sent by SMTP servers.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS smtp_send_counts (
+			server           TEXT NOT NULL,
+			day              DATE NOT NULL,
+			sent             INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (server, day)
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Bulk e-mail verification results of subscribers.
+	_, err = db.Exec(`
+		DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'verification_status') THEN
+				CREATE TYPE verification_status AS ENUM ('valid', 'risky', 'invalid', 'unknown');
+			END IF;
+		END $$;
+
+		ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS verification_status verification_status NULL;
+		ALTER TABLE subscribers ADD COLUMN IF NOT EXISTS last_verified_at TIMESTAMP WITH TIME ZONE NULL;
+		CREATE INDEX IF NOT EXISTS idx_subs_verification_status ON subscribers(verification_status) WHERE verification_status IS NOT NULL;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// SFTP and WebDAV media upload providers.
+	_, err = db.Exec(`
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.sftp.host', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.sftp.port', '22', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.sftp.username', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.sftp.password', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.sftp.private_key', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.sftp.host_key', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.sftp.path', '"/"', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.sftp.public_url', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.sftp.max_conns', '2', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.webdav.url', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.webdav.username', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.webdav.password', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('upload.webdav.public_url', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Public list descriptions and sending frequency hints.
+	_, err = db.Exec(`
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS public_description TEXT NOT NULL DEFAULT '';
+		ALTER TABLE lists ADD COLUMN IF NOT EXISTS frequency TEXT NOT NULL DEFAULT '';
+
+		-- Public lists' descriptions were shown on the public subscription form earlier.
+		UPDATE lists SET public_description = description WHERE type = 'public' AND public_description = '' AND description != '';
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Login audit events and security alerts.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS login_events (
+			id               BIGSERIAL PRIMARY KEY,
+			username         TEXT NOT NULL,
+			ip               TEXT NOT NULL,
+			success          BOOLEAN NOT NULL,
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_login_events_created_at ON login_events(created_at);
+		CREATE TABLE IF NOT EXISTS api_token_networks (
+			username         TEXT NOT NULL,
+			network          TEXT NOT NULL,
+			ip               TEXT NOT NULL,
+			alerted          BOOLEAN NOT NULL DEFAULT false,
+			first_seen       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			last_seen        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (username, network)
+		);
+		CREATE INDEX IF NOT EXISTS idx_api_token_networks_alerted ON api_token_networks(first_seen) WHERE alerted = false;
+		CREATE TABLE IF NOT EXISTS security_alerts (
+			id               SERIAL PRIMARY KEY,
+			rule             TEXT NOT NULL,
+			data             JSONB NOT NULL DEFAULT '[]',
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_security_alerts ON security_alerts(rule, created_at);
+		INSERT INTO settings (key, value, updated_at) VALUES ('security.alerts', '{"failed_logins": {"enabled": true, "threshold": 10, "window": "15m", "cooldown": "1h"}, "new_token_network": {"enabled": true, "cooldown": "1h"}}', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Persisted subscriber import jobs.
+	_, err = db.Exec(`
+		DO $$ BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'import_status') THEN
+				CREATE TYPE import_status AS ENUM ('queued', 'importing', 'stopping', 'finished', 'failed', 'stopped');
+			END IF;
+		END $$;
+
+		CREATE TABLE IF NOT EXISTS import_jobs (
+			id               SERIAL PRIMARY KEY,
+			name             TEXT NOT NULL,
+			mode             TEXT NOT NULL,
+			status           import_status NOT NULL DEFAULT 'queued',
+			params           JSONB NOT NULL DEFAULT '{}',
+			file_path        TEXT NOT NULL,
+			total            INT NOT NULL DEFAULT 0,
+			imported         INT NOT NULL DEFAULT 0,
+			failed           INT NOT NULL DEFAULT 0,
+			line             INT NOT NULL DEFAULT 0,
+			state            JSONB NOT NULL DEFAULT '{}',
+			log              TEXT NOT NULL DEFAULT '',
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			started_at       TIMESTAMP WITH TIME ZONE NULL,
+			finished_at      TIMESTAMP WITH TIME ZONE NULL,
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_import_jobs_status ON import_jobs(status);
+
+		CREATE TABLE IF NOT EXISTS import_job_errors (
+			job_id           INTEGER NOT NULL REFERENCES import_jobs(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			line             INT NOT NULL,
+			data             TEXT NOT NULL DEFAULT '',
+			error            TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (job_id, line)
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Activity digest report runs.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS digest_runs (
+			id               SERIAL PRIMARY KEY,
+			period_start     TIMESTAMP WITH TIME ZONE NOT NULL,
+			period_end       TIMESTAMP WITH TIME ZONE NOT NULL,
+			status           TEXT NOT NULL,
+			recipients       INT NOT NULL DEFAULT 0,
+			error            TEXT NOT NULL DEFAULT '',
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.digest', '{"enabled": false, "schedule": "0 8 * * 1", "period_days": 7, "recipients": [], "sections": ["campaigns", "engagement", "subscribers", "top_campaigns"]}', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Short tracking links.
+	_, err = db.Exec(`
+		ALTER TABLE links ADD COLUMN IF NOT EXISTS slug TEXT NULL UNIQUE;
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS short_links BOOLEAN NOT NULL DEFAULT false;
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.short_link_url', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Unsubscribe requests sent as replies to campaigns.
+	_, err = db.Exec(`
+		ALTER TABLE unsubscribe_events ADD COLUMN IF NOT EXISTS source TEXT NOT NULL DEFAULT '';
+		INSERT INTO settings (key, value, updated_at) VALUES ('bounce.reply_unsubscribe', '{"enabled": false, "keywords": {"en": ["unsubscribe", "remove me", "opt out"], "de": ["abmelden", "abbestellen", "austragen"]}}', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Max runtime of campaigns.
+	_, err = db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS max_runtime TEXT NOT NULL DEFAULT '';
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.max_campaign_runtime', '"0"', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Global e-mail headers.
+	_, err = db.Exec(`
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.email_headers', '[]', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Directory of custom public page templates.
+	_, err = db.Exec(`
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.public_templates_dir', '""', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Stats rollups of finished campaigns.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS campaign_stats (
+			campaign_id      INTEGER NOT NULL PRIMARY KEY REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			sent             INTEGER NOT NULL DEFAULT 0,
+			delivered        INTEGER NOT NULL DEFAULT 0,
+			bounces          INTEGER NOT NULL DEFAULT 0,
+			hard_bounces     INTEGER NOT NULL DEFAULT 0,
+			soft_bounces     INTEGER NOT NULL DEFAULT 0,
+			complaints       INTEGER NOT NULL DEFAULT 0,
+			views            INTEGER NOT NULL DEFAULT 0,
+			unique_views     INTEGER NOT NULL DEFAULT 0,
+			clicks           INTEGER NOT NULL DEFAULT 0,
+			unique_clicks    INTEGER NOT NULL DEFAULT 0,
+			unsubscribes     INTEGER NOT NULL DEFAULT 0,
+			final            BOOLEAN NOT NULL DEFAULT false,
+			finished_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.campaign_stats_rollup_after', '"720h"', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// History of the changes to tracked subscriber attributes.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS subscriber_attrib_history (
+			id                 BIGSERIAL PRIMARY KEY,
+			subscriber_id      INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			key                TEXT NOT NULL,
+			old_value          JSONB NULL,
+			new_value          JSONB NULL,
+			created_at         TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_attrib_history_sub ON subscriber_attrib_history(subscriber_id, key);
+		CREATE INDEX IF NOT EXISTS idx_attrib_history_key_date ON subscriber_attrib_history(key, created_at);
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.tracked_attribs', '[]', NOW()) ON CONFLICT (key) DO NOTHING;
+		INSERT INTO settings (key, value, updated_at) VALUES ('app.attrib_history_retention', '"8760h"', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Retry queue of system e-mails that failed to send.
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS system_messages (
+			id               SERIAL PRIMARY KEY,
+			from_email       TEXT NOT NULL,
+			to_emails        TEXT[] NOT NULL,
+			subject          TEXT NOT NULL,
+			content_type     TEXT NOT NULL,
+			body             TEXT NOT NULL,
+			headers          JSONB NOT NULL DEFAULT '{}',
+			status           TEXT NOT NULL DEFAULT 'pending',
+			attempts         INT NOT NULL DEFAULT 0,
+			last_error       TEXT NOT NULL DEFAULT '',
+			next_attempt_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+			updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+		);
+		CREATE INDEX IF NOT EXISTS idx_system_messages_next ON system_messages(next_attempt_at) WHERE status = 'pending';
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Storage usage alert thresholds.
+	_, err = db.Exec(`
+		INSERT INTO settings (key, value, updated_at) VALUES ('maintenance.storage', '{"disk_threshold": 90, "db_threshold_mb": 0, "cooldown": "24h"}', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Structured (blocks) campaign content.
+	_, err = db.Exec(`ALTER TYPE content_type ADD VALUE IF NOT EXISTS 'blocks'`)
+	if err != nil {
+		return err
+	}
+
+	// Block styles of templates.
+	_, err = db.Exec(`ALTER TABLE templates ADD COLUMN IF NOT EXISTS block_styles JSONB NOT NULL DEFAULT '{}'`)
+	if err != nil {
+		return err
+	}
+
+	// Source IP allowlists of the bounce webhooks.
+	_, err = db.Exec(`
+		INSERT INTO settings (key, value, updated_at) VALUES ('bounce.webhook_allowlist', '{"audit_only": false, "trusted_proxies": [], "allowed_cidrs": {"ses": [], "sendgrid": [], "postmark": [], "forwardemail": []}}', NOW()) ON CONFLICT (key) DO NOTHING;
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Exclusion lists of campaigns.
+	_, err = db.Exec(`
+		ALTER TABLE campaigns ADD COLUMN IF NOT EXISTS excluded INT NOT NULL DEFAULT 0;
+
+		CREATE TABLE IF NOT EXISTS campaign_exclude_lists (
+			campaign_id  INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			list_id      INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+			PRIMARY KEY (campaign_id, list_id)
+		);
+	`)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
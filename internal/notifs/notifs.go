@@ -11,17 +11,24 @@ import (
 	"net/textproto"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/knadh/listmonk/internal/messenger/email"
 	"github.com/knadh/listmonk/models"
 )
 
 const (
-	TplImport          = "import-status"
-	TplCampaignStatus  = "campaign-status"
-	TplSubscriberOptin = "subscriber-optin"
-	TplSubscriberData  = "subscriber-data"
-	TplForgotPassword  = "forgot-password"
+	TplImport           = "import-status"
+	TplCampaignStatus   = "campaign-status"
+	TplSubscriberOptin  = "subscriber-optin"
+	TplSubscriberData   = "subscriber-data"
+	TplForgotPassword   = "forgot-password"
+	TplSenderVerify     = "sender-verify"
+	TplCampaignApproval = "campaign-approval"
+	TplSubscriberDelete = "subscriber-deletion"
+	TplSecurityAlert    = "security-alert"
+	TplDigest           = "digest"
+	TplStorageAlert     = "storage-alert"
 )
 
 type FuncPush func(msg models.Message) error
@@ -32,12 +39,33 @@ type Opt struct {
 	FromEmail    string
 	SystemEmails []string
 	ContentType  string
+
+	// Lang is the instance language in which Tpls are rendered.
+	Lang string
+
+	// FnUserLangs returns the preferred languages of the admin users with the
+	// given e-mails as a map of lowercased e-mail => language.
+	FnUserLangs func(emails []string) (map[string]string, error)
+
+	// FnLoadTpls loads the notification templates in the given language.
+	FnLoadTpls func(lang string) (*template.Template, error)
+
+	// FnQueue queues a message that failed to send for retries.
+	FnQueue func(m models.Message, sendErr error) error
 }
 
 type Notifs struct {
-	em *email.Emailer
+	// em is the e-mail messenger, an *email.Emailer.
+	em interface {
+		Push(models.Message) error
+	}
 	lo *log.Logger
 
+	// Notification templates in languages other than the instance language,
+	// loaded on demand.
+	langTpls map[string]*template.Template
+	langMut  sync.Mutex
+
 	opt Opt
 }
 
@@ -56,9 +84,10 @@ func Initialize(opt Opt, tpls *template.Template, em *email.Emailer, lo *log.Log
 
 	Tpls = tpls
 	no = &Notifs{
-		opt: opt,
-		em:  em,
-		lo:  lo,
+		opt:      opt,
+		em:       em,
+		lo:       lo,
+		langTpls: make(map[string]*template.Template),
 	}
 }
 
@@ -67,14 +96,93 @@ func NotifySystem(subject, tplName string, data any, hdr textproto.MIMEHeader) e
 	return Notify(no.opt.SystemEmails, subject, tplName, data, hdr)
 }
 
-// Notify sends out an e-mail notification.
+// Notify sends out an e-mail notification. Recipients who are admin users with
+// a language preference get the notification rendered in their language and the
+// rest in the instance language.
 func Notify(toEmails []string, subject, tplName string, data any, hdr textproto.MIMEHeader) error {
 	if len(toEmails) == 0 {
 		return nil
 	}
 
+	for _, g := range groupByLang(toEmails) {
+		if err := notify(g.emails, getTpls(g.lang), subject, tplName, data, hdr); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type langGroup struct {
+	lang   string
+	emails []string
+}
+
+// groupByLang groups the given e-mails by their recipients' preferred languages.
+// The instance language group, if any, is always the first.
+func groupByLang(emails []string) []langGroup {
+	out := []langGroup{{lang: no.opt.Lang}}
+	if no.opt.FnUserLangs == nil {
+		out[0].emails = emails
+		return out
+	}
+
+	langs, err := no.opt.FnUserLangs(emails)
+	if err != nil {
+		no.lo.Printf("error fetching notification recipient languages: %v", err)
+	}
+
+	for _, e := range emails {
+		lang, ok := langs[strings.ToLower(e)]
+		if !ok {
+			lang = no.opt.Lang
+		}
+
+		n := 0
+		for n < len(out) && out[n].lang != lang {
+			n++
+		}
+		if n == len(out) {
+			out = append(out, langGroup{lang: lang})
+		}
+		out[n].emails = append(out[n].emails, e)
+	}
+
+	if len(out[0].emails) == 0 {
+		out = out[1:]
+	}
+
+	return out
+}
+
+// getTpls returns the notification templates for the given language, falling
+// back to the instance language templates if they can't be loaded.
+func getTpls(lang string) *template.Template {
+	if lang == no.opt.Lang || no.opt.FnLoadTpls == nil {
+		return Tpls
+	}
+
+	no.langMut.Lock()
+	defer no.langMut.Unlock()
+
+	if t, ok := no.langTpls[lang]; ok {
+		return t
+	}
+
+	t, err := no.opt.FnLoadTpls(lang)
+	if err != nil {
+		no.lo.Printf("error loading notification templates for language '%s': %v", lang, err)
+		t = Tpls
+	}
+	no.langTpls[lang] = t
+
+	return t
+}
+
+// notify renders the given template and sends it to the recipients.
+func notify(toEmails []string, tpls *template.Template, subject, tplName string, data any, hdr textproto.MIMEHeader) error {
 	var buf bytes.Buffer
-	if err := Tpls.ExecuteTemplate(&buf, tplName, data); err != nil {
+	if err := tpls.ExecuteTemplate(&buf, tplName, data); err != nil {
 		no.lo.Printf("error compiling notification template '%s': %v", tplName, err)
 		return err
 	}
@@ -92,8 +200,16 @@ func Notify(toEmails []string, subject, tplName string, data any, hdr textproto.
 		Headers:     hdr,
 	}
 
-	// Send the message.
+	// Send the message. If it fails, eg: on a transient SMTP error, it's queued
+	// for retries so that the recipient still gets it.
 	if err := no.em.Push(m); err != nil {
+		if no.opt.FnQueue != nil {
+			if qErr := no.opt.FnQueue(m, err); qErr == nil {
+				no.lo.Printf("error sending notification (%s), queued for retries: %v", subject, err)
+				return nil
+			}
+		}
+
 		no.lo.Printf("error sending admin notification (%s): %v", subject, err)
 		return err
 	}
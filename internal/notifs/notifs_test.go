@@ -0,0 +1,356 @@
+package notifs
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/messenger/email"
+	"github.com/knadh/listmonk/models"
+	"github.com/knadh/smtppool/v2"
+)
+
+// testEmailer records the messages that are pushed to it. If err is set,
+// pushes fail with it.
+type testEmailer struct {
+	mut  sync.Mutex
+	msgs []models.Message
+	err  error
+}
+
+func (e *testEmailer) Push(m models.Message) error {
+	e.mut.Lock()
+	e.msgs = append(e.msgs, m)
+	e.mut.Unlock()
+	return e.err
+}
+
+// testTpls returns the notification templates with the given language's
+// i18n catalog.
+func testTpls(t *testing.T, lang string) *template.Template {
+	t.Helper()
+
+	b, err := os.ReadFile("../../i18n/" + lang + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := i18n.New(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return template.Must(template.New("").Funcs(template.FuncMap{
+		"L": func() *i18n.I18n { return i },
+	}).Parse(`{{ define "campaign-status" }}<title data-i18n>{{ L.T "email.status.campaignUpdateTitle" }}</title><p>{{ .Name }}</p>{{ end }}`))
+}
+
+// setup initializes the notifier for a test and restores it after.
+func setup(t *testing.T, opt Opt) *testEmailer {
+	t.Helper()
+
+	em := &testEmailer{}
+	prevTpls, prevNo := Tpls, no
+	t.Cleanup(func() { Tpls, no = prevTpls, prevNo })
+
+	Tpls = testTpls(t, "en")
+	no = &Notifs{
+		opt:      opt,
+		em:       em,
+		lo:       log.New(io.Discard, "", 0),
+		langTpls: make(map[string]*template.Template),
+	}
+
+	return em
+}
+
+// TestNotifyLangs sends the same notification to users with different language
+// preferences and checks that each gets it in their language.
+func TestNotifyLangs(t *testing.T) {
+	var (
+		mut   sync.Mutex
+		loads = map[string]int{}
+	)
+	em := setup(t, Opt{
+		Lang: "en",
+		FnUserLangs: func(emails []string) (map[string]string, error) {
+			return map[string]string{"fr@example.com": "fr", "fr2@example.com": "fr", "bad@example.com": "xx"}, nil
+		},
+		FnLoadTpls: func(lang string) (*template.Template, error) {
+			mut.Lock()
+			loads[lang]++
+			mut.Unlock()
+
+			if lang == "xx" {
+				return nil, errors.New("unknown language")
+			}
+			return testTpls(t, lang), nil
+		},
+	})
+
+	to := []string{"FR@example.com", "en@example.com", "other@example.com", "fr2@example.com", "bad@example.com"}
+	for range 2 {
+		em.msgs = nil
+		if err := Notify(to, "Campaign", TplCampaignStatus, map[string]string{"Name": "News"}, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		// One message per language with the instance language first. Users without a
+		// preference, non-users, and users whose language can't be loaded get the
+		// instance language.
+		if len(em.msgs) != 3 {
+			t.Fatalf("expected 3 messages, got %d", len(em.msgs))
+		}
+		exp := []struct {
+			to      []string
+			subject string
+		}{
+			{[]string{"en@example.com", "other@example.com"}, "Campaign update"},
+			{[]string{"FR@example.com", "fr2@example.com"}, "Mise à jour de campagne"},
+			{[]string{"bad@example.com"}, "Campaign update"},
+		}
+		for n, e := range exp {
+			m := em.msgs[n]
+			if !slices.Equal(m.To, e.to) || m.Subject != e.subject || !strings.Contains(string(m.Body), "<p>News</p>") {
+				t.Errorf("%d: expected %v (%s), got %v (%s): %s", n, e.to, e.subject, m.To, m.Subject, m.Body)
+			}
+		}
+	}
+
+	// The templates for other languages are loaded once.
+	if loads["fr"] != 1 || loads["xx"] != 1 || loads["en"] != 0 {
+		t.Errorf("expected the templates to be loaded once per language, got %v", loads)
+	}
+}
+
+func TestNotifyLangsFallback(t *testing.T) {
+	// Without language preferences, everyone gets the instance language.
+	em := setup(t, Opt{Lang: "en"})
+	if err := Notify([]string{"a@example.com", "b@example.com"}, "Campaign", TplCampaignStatus, map[string]string{"Name": "News"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(em.msgs) != 1 || len(em.msgs[0].To) != 2 || em.msgs[0].Subject != "Campaign update" {
+		t.Errorf("unexpected messages %+v", em.msgs)
+	}
+
+	// If the preferences can't be fetched, everyone gets the instance language.
+	em = setup(t, Opt{
+		Lang: "en",
+		FnUserLangs: func([]string) (map[string]string, error) {
+			return nil, errors.New("db error")
+		},
+	})
+	if err := Notify([]string{"a@example.com", "b@example.com"}, "Campaign", TplCampaignStatus, map[string]string{"Name": "News"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(em.msgs) != 1 || len(em.msgs[0].To) != 2 || em.msgs[0].Subject != "Campaign update" {
+		t.Errorf("unexpected messages %+v", em.msgs)
+	}
+
+	// Everyone preferring another language.
+	em = setup(t, Opt{
+		Lang: "en",
+		FnUserLangs: func([]string) (map[string]string, error) {
+			return map[string]string{"a@example.com": "fr"}, nil
+		},
+		FnLoadTpls: func(lang string) (*template.Template, error) {
+			return testTpls(t, lang), nil
+		},
+	})
+	if err := Notify([]string{"a@example.com"}, "Campaign", TplCampaignStatus, map[string]string{"Name": "News"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(em.msgs) != 1 || em.msgs[0].Subject != "Mise à jour de campagne" {
+		t.Errorf("unexpected messages %+v", em.msgs)
+	}
+}
+
+// TestNotifyQueue checks that notifications that fail to send are queued for
+// retries.
+func TestNotifyQueue(t *testing.T) {
+	var (
+		queued []models.Message
+		qErr   error
+	)
+	em := setup(t, Opt{
+		Lang:      "en",
+		FromEmail: "from@example.com",
+		FnQueue: func(m models.Message, sendErr error) error {
+			if sendErr == nil {
+				t.Error("expected the send error")
+			}
+			if qErr == nil {
+				queued = append(queued, m)
+			}
+			return qErr
+		},
+	})
+	hdr := textproto.MIMEHeader{}
+	hdr.Set(models.EmailHeaderSubscriberUUID, "sub-uuid")
+
+	// Sent messages aren't queued.
+	if err := Notify([]string{"a@example.com"}, "Campaign", TplCampaignStatus, map[string]string{"Name": "News"}, hdr); err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 0 {
+		t.Fatalf("expected nothing to be queued, got %+v", queued)
+	}
+
+	// A failed send is queued as is and isn't an error.
+	em.err = errors.New("421 try again later")
+	if err := Notify([]string{"a@example.com"}, "Campaign", TplCampaignStatus, map[string]string{"Name": "News"}, hdr); err != nil {
+		t.Fatalf("expected the queued message not to fail, got %v", err)
+	}
+	if len(queued) != 1 {
+		t.Fatalf("expected the message to be queued, got %d", len(queued))
+	}
+	q := queued[0]
+	if q.From != "from@example.com" || q.To[0] != "a@example.com" || q.Subject != "Campaign update" ||
+		!strings.Contains(string(q.Body), "<p>News</p>") || q.Headers.Get(models.EmailHeaderSubscriberUUID) != "sub-uuid" {
+		t.Errorf("unexpected queued message %+v", q)
+	}
+
+	// If it can't be queued, the send error is returned.
+	qErr = errors.New("db error")
+	if err := Notify([]string{"a@example.com"}, "Campaign", TplCampaignStatus, nil, nil); err != em.err {
+		t.Errorf("expected the send error, got %v", err)
+	}
+
+	// Without a queue, the send error is returned.
+	no.opt.FnQueue = nil
+	if err := Notify([]string{"a@example.com"}, "Campaign", TplCampaignStatus, nil, nil); err != em.err {
+		t.Errorf("expected the send error, got %v", err)
+	}
+}
+
+// smtpSink is a minimal SMTP server that records the data of the messages sent to it.
+type smtpSink struct {
+	ln net.Listener
+
+	mut  sync.Mutex
+	msgs [][]byte
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	s := &smtpSink{ln: ln}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+
+	return s
+}
+
+func (s *smtpSink) serve(c net.Conn) {
+	defer c.Close()
+
+	tp := textproto.NewConn(c)
+	_ = tp.PrintfLine("220 localhost")
+	for {
+		l, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+
+		switch strings.ToUpper(strings.SplitN(l, " ", 2)[0]) {
+		case "DATA":
+			_ = tp.PrintfLine("354 go ahead")
+			b, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			s.mut.Lock()
+			s.msgs = append(s.msgs, b)
+			s.mut.Unlock()
+			_ = tp.PrintfLine("250 ok")
+		case "QUIT":
+			_ = tp.PrintfLine("221 bye")
+			return
+		default:
+			_ = tp.PrintfLine("250 ok")
+		}
+	}
+}
+
+func (s *smtpSink) getMsgs() [][]byte {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return slices.Clone(s.msgs)
+}
+
+// TestNotifyGlobalHeaders checks that the global e-mail headers are attached to
+// the subscriber opt-in confirmations and the admin notifications.
+func TestNotifyGlobalHeaders(t *testing.T) {
+	// Idle connections are swept in the background so that closing the
+	// messenger doesn't block.
+	s := newSMTPSink(t)
+	em, err := email.New("email", email.Server{
+		TLSType: "none",
+		Opt:     smtppool.Opt{Host: "127.0.0.1", Port: s.ln.Addr().(*net.TCPAddr).Port, MaxConns: 2, IdleTimeout: 2 * time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer em.Close()
+	em.SetHeaders(models.Headers{{"X-Entity-Ref-ID": "ref"}, {"X-Compliance": "yes"}})
+
+	setup(t, Opt{Lang: "en", FromEmail: "from@example.com", SystemEmails: []string{"admin@example.com"}})
+	no.em = em
+	Tpls = template.Must(Tpls.Parse(`{{ define "subscriber-optin" }}<p>Confirm {{ .Name }}</p>{{ end }}`))
+
+	// An opt-in confirmation with its own headers as sent by the opt-in hook.
+	hdr := textproto.MIMEHeader{}
+	hdr.Set(models.EmailHeaderSubscriberUUID, "sub-uuid")
+	hdr.Set("X-Compliance", "optin")
+	if err := Notify([]string{"sub@example.com"}, "Confirm", TplSubscriberOptin, map[string]string{"Name": "sub"}, hdr); err != nil {
+		t.Fatal(err)
+	}
+
+	// An admin notification.
+	if err := NotifySystem("Campaign", TplCampaignStatus, map[string]string{"Name": "News"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs := s.getMsgs()
+	if len(msgs) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(msgs))
+	}
+	for n, exp := range []map[string]string{
+		{"X-Entity-Ref-ID": "ref", "X-Compliance": "optin", models.EmailHeaderSubscriberUUID: "sub-uuid", "To": "<sub@example.com>"},
+		{"X-Entity-Ref-ID": "ref", "X-Compliance": "yes", "To": "<admin@example.com>"},
+	} {
+		m, err := mail.ReadMessage(bytes.NewReader(msgs[n]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range exp {
+			if got := m.Header[textproto.CanonicalMIMEHeaderKey(k)]; len(got) != 1 || got[0] != v {
+				t.Errorf("%d: expected %s %s, got %v", n, k, v, got)
+			}
+		}
+	}
+}
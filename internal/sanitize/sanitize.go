@@ -0,0 +1,214 @@
+// Package sanitize renders user provided Markdown and HTML that's shown on
+// public pages into HTML that's safe to embed. It uses a strict allowlist of
+// basic formatting tags. All attributes other than link URLs are dropped.
+package sanitize
+
+import (
+	"bytes"
+	"html"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	ghtml "github.com/yuin/goldmark/renderer/html"
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// allowedTags is the allowlist of tags. The values indicate void tags that
+// have no content or closing tag.
+var allowedTags = map[atom.Atom]bool{
+	atom.P:          false,
+	atom.Br:         true,
+	atom.Strong:     false,
+	atom.B:          false,
+	atom.Em:         false,
+	atom.I:          false,
+	atom.U:          false,
+	atom.S:          false,
+	atom.Del:        false,
+	atom.Code:       false,
+	atom.Blockquote: false,
+	atom.Ul:         false,
+	atom.Ol:         false,
+	atom.Li:         false,
+	atom.A:          false,
+}
+
+// droppedTags are tags whose content is dropped along with them.
+var droppedTags = map[atom.Atom]bool{
+	atom.Script:    true,
+	atom.Style:     true,
+	atom.Iframe:    true,
+	atom.Object:    true,
+	atom.Embed:     true,
+	atom.Noscript:  true,
+	atom.Template:  true,
+	atom.Textarea:  true,
+	atom.Title:     true,
+	atom.Svg:       true,
+	atom.Math:      true,
+	atom.Select:    true,
+	atom.Xmp:       true,
+	atom.Noembed:   true,
+	atom.Noframes:  true,
+	atom.Plaintext: true,
+}
+
+// allowedSchemes are the URL schemes allowed in links.
+var allowedSchemes = map[string]bool{
+	"http":   true,
+	"https":  true,
+	"mailto": true,
+}
+
+// markdown renders Markdown. Raw HTML is passed through to the sanitizer.
+var markdown = goldmark.New(
+	goldmark.WithRendererOptions(
+		ghtml.WithUnsafe(),
+	),
+	goldmark.WithExtensions(
+		extension.Strikethrough,
+		extension.Linkify,
+	),
+)
+
+// Markdown renders Markdown (which may contain basic HTML) and sanitizes the result.
+func Markdown(src string) string {
+	if strings.TrimSpace(src) == "" {
+		return ""
+	}
+
+	var b bytes.Buffer
+	if err := markdown.Convert([]byte(src), &b); err != nil {
+		// Fall back to the escaped source.
+		return html.EscapeString(src)
+	}
+
+	return HTML(b.String())
+}
+
+// HTML sanitizes an HTML fragment. Tags that aren't in the allowlist are removed
+// but their text is retained, except for the ones like <script> whose content is
+// removed as well. Unbalanced tags are closed and text is re-escaped.
+func HTML(src string) string {
+	var (
+		z     = xhtml.NewTokenizer(strings.NewReader(src))
+		out   strings.Builder
+		stack []atom.Atom
+
+		// Depth of nested dropped tags whose content is being skipped.
+		skip int
+	)
+
+	for {
+		tt := z.Next()
+		if tt == xhtml.ErrorToken {
+			if z.Err() != io.EOF {
+				return ""
+			}
+			break
+		}
+
+		tok := z.Token()
+		switch tt {
+		case xhtml.TextToken:
+			if skip == 0 {
+				out.WriteString(html.EscapeString(tok.Data))
+			}
+
+		case xhtml.StartTagToken, xhtml.SelfClosingTagToken:
+			if droppedTags[tok.DataAtom] {
+				if tt == xhtml.StartTagToken {
+					skip++
+				}
+				continue
+			}
+
+			void, ok := allowedTags[tok.DataAtom]
+			if !ok || skip > 0 {
+				continue
+			}
+
+			writeTag(&out, tok)
+			if !void && tt == xhtml.StartTagToken {
+				stack = append(stack, tok.DataAtom)
+			} else if !void {
+				// <p/> isn't valid HTML. Close it immediately.
+				out.WriteString("</" + tok.DataAtom.String() + ">")
+			}
+
+		case xhtml.EndTagToken:
+			if droppedTags[tok.DataAtom] {
+				if skip > 0 {
+					skip--
+				}
+				continue
+			}
+			if skip > 0 {
+				continue
+			}
+
+			// Only close tags that are open, along with the ones nested in them.
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i] != tok.DataAtom {
+					continue
+				}
+				for j := len(stack) - 1; j >= i; j-- {
+					out.WriteString("</" + stack[j].String() + ">")
+				}
+				stack = stack[:i]
+				break
+			}
+		}
+
+		// Comments, doctypes etc. are dropped.
+	}
+
+	// Close the tags left open.
+	for i := len(stack) - 1; i >= 0; i-- {
+		out.WriteString("</" + stack[i].String() + ">")
+	}
+
+	return out.String()
+}
+
+// writeTag writes an allowed tag. Only the URL of links is retained.
+func writeTag(out *strings.Builder, tok xhtml.Token) {
+	out.WriteString("<" + tok.DataAtom.String())
+
+	if tok.DataAtom == atom.A {
+		for _, a := range tok.Attr {
+			if a.Namespace != "" || strings.ToLower(a.Key) != "href" {
+				continue
+			}
+			if u, ok := safeURL(a.Val); ok {
+				out.WriteString(` href="` + html.EscapeString(u) + `" rel="nofollow noopener noreferrer" target="_blank"`)
+			}
+			break
+		}
+	}
+
+	if allowedTags[tok.DataAtom] {
+		out.WriteString(" />")
+		return
+	}
+	out.WriteString(">")
+}
+
+// safeURL checks whether a URL is absolute and has an allowed scheme.
+func safeURL(s string) (string, bool) {
+	s = strings.TrimSpace(s)
+
+	u, err := url.Parse(s)
+	if err != nil || !allowedSchemes[strings.ToLower(u.Scheme)] {
+		return "", false
+	}
+	if u.Scheme != "mailto" && u.Host == "" {
+		return "", false
+	}
+
+	return u.String(), true
+}
@@ -0,0 +1,65 @@
+package sanitize
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHTML(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		exp  string
+	}{
+		{"text", `Hello & "bye"`, `Hello &amp; &#34;bye&#34;`},
+		{"allowed tags", `<p><strong>a</strong> <em>b</em><br>c</p>`, `<p><strong>a</strong> <em>b</em><br />c</p>`},
+		{"attributes dropped", `<p class="x" style="color: red" onclick="alert(1)">a</p>`, `<p>a</p>`},
+		{"unknown tags unwrapped", `<div><span>a</span><img src="x" onerror="alert(1)"></div>`, `a`},
+		{"dropped tags and content", `a<script>alert(1)</script><style>p {}</style><svg><a href="https://x.com">b</a></svg>c`, `ac`},
+		{"nested dropped tags", `a<noscript><script>x</script>y</noscript>b`, `ab`},
+		{"link", `<a href="https://example.com/?a=1&b=2" title="x">a</a>`,
+			`<a href="https://example.com/?a=1&amp;b=2" rel="nofollow noopener noreferrer" target="_blank">a</a>`},
+		{"mailto link", `<a href="mailto:a@example.com">a</a>`,
+			`<a href="mailto:a@example.com" rel="nofollow noopener noreferrer" target="_blank">a</a>`},
+		{"javascript link", `<a href="javascript:alert(1)">a</a>`, `<a>a</a>`},
+		{"obfuscated javascript link", `<a href=" JaVaScRiPt:alert(1)">a</a>`, `<a>a</a>`},
+		{"data link", `<a href="data:text/html;base64,PHNjcmlwdD4=">a</a>`, `<a>a</a>`},
+		{"relative link", `<a href="/admin">a</a>`, `<a>a</a>`},
+		{"protocol relative link", `<a href="//evil.com">a</a>`, `<a>a</a>`},
+		{"unbalanced tags", `<p><strong>a<em>b</p>c</em>`, `<p><strong>a<em>b</em></strong></p>c`},
+		{"stray end tags", `</p></script>a</div>`, `a`},
+		{"self closing", `<p/>a<br/>`, `<p></p>a<br />`},
+		{"comments", `a<!-- <script>x</script> -->b`, `ab`},
+		{"escaped text", `&lt;script&gt;alert(1)&lt;/script&gt;`, `&lt;script&gt;alert(1)&lt;/script&gt;`},
+	}
+
+	for _, c := range cases {
+		if out := HTML(c.in); out != c.exp {
+			t.Errorf("%s: expected\n%s\ngot\n%s", c.name, c.exp, out)
+		}
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	if out := Markdown("  \n "); out != "" {
+		t.Errorf("expected nothing for blank input, got %q", out)
+	}
+
+	out := Markdown("**Hi** ~~there~~, see https://example.com or [this](javascript:alert(1)).\n\n- a\n- b\n\n<script>alert(1)</script>\n\n<img src=x onerror=alert(1)>")
+	for _, s := range []string{
+		"<strong>Hi</strong>",
+		"<del>there</del>",
+		`<a href="https://example.com" rel="nofollow noopener noreferrer" target="_blank">https://example.com</a>`,
+		"<a>this</a>",
+		"<ul>\n<li>a</li>\n<li>b</li>\n</ul>",
+	} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %q in\n%s", s, out)
+		}
+	}
+	for _, s := range []string{"script", "alert", "img", "onerror", "javascript"} {
+		if strings.Contains(out, s) {
+			t.Errorf("unexpected %q in\n%s", s, out)
+		}
+	}
+}
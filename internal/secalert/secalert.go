@@ -0,0 +1,160 @@
+// Package secalert periodically evaluates security alert rules, such as repeated
+// failed logins and API tokens being used from new networks, over the login
+// audit data and fires aggregated alerts. A rule isn't fired again until its
+// cooldown has elapsed.
+package secalert
+
+import (
+	"log"
+	"net/netip"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+// FailedLoginsRule fires when a username or an IP has Threshold or more failed
+// logins within Window.
+type FailedLoginsRule struct {
+	Enabled   bool
+	Threshold int
+	Window    time.Duration
+	Cooldown  time.Duration
+}
+
+// NewTokenNetworkRule fires when an API token is used from a network that it
+// hasn't been used from before.
+type NewTokenNetworkRule struct {
+	Enabled  bool
+	Cooldown time.Duration
+}
+
+// Opt represents the alerter's options.
+type Opt struct {
+	// Interval at which the rules are evaluated.
+	Interval time.Duration
+
+	// Login events older than Retention are deleted.
+	Retention time.Duration
+
+	FailedLogins    FailedLoginsRule
+	NewTokenNetwork NewTokenNetworkRule
+}
+
+// Store is the source of the login audit data and the record of fired alerts.
+type Store interface {
+	GetFailedLogins(since time.Time, threshold int) ([]models.FailedLogins, error)
+	GetNewTokenNetworks() ([]models.TokenNetwork, error)
+	MarkTokenNetworksAlerted(nets []models.TokenNetwork) error
+	DeleteLoginEvents(before time.Time) error
+
+	// GetLastSecurityAlerts returns the time each rule was last fired at.
+	GetLastSecurityAlerts() (map[string]time.Time, error)
+	RecordSecurityAlert(rule string, data any) error
+}
+
+// Notifier sends out a fired alert. data is the list of models.FailedLogins
+// or models.TokenNetwork that triggered the rule.
+type Notifier func(rule string, data any) error
+
+// Alerter evaluates the alert rules.
+type Alerter struct {
+	opt    Opt
+	store  Store
+	notify Notifier
+	log    *log.Logger
+}
+
+// New returns a new Alerter.
+func New(opt Opt, st Store, notify Notifier, lo *log.Logger) *Alerter {
+	return &Alerter{
+		opt:    opt,
+		store:  st,
+		notify: notify,
+		log:    lo,
+	}
+}
+
+// Run evaluates the rules at the configured interval. It blocks forever.
+func (a *Alerter) Run() {
+	t := time.NewTicker(a.opt.Interval)
+	defer t.Stop()
+
+	for now := range t.C {
+		a.Evaluate(now)
+	}
+}
+
+// Evaluate evaluates the rules once and fires the ones that match and aren't
+// cooling down.
+func (a *Alerter) Evaluate(now time.Time) {
+	last, err := a.store.GetLastSecurityAlerts()
+	if err != nil {
+		return
+	}
+
+	if r := a.opt.FailedLogins; r.Enabled && !coolingDown(last[models.SecurityAlertFailedLogins], r.Cooldown, now) {
+		// Failures that have already been alerted aren't counted again.
+		since := now.Add(-r.Window)
+		if l := last[models.SecurityAlertFailedLogins]; l.After(since) {
+			since = l
+		}
+
+		if res, err := a.store.GetFailedLogins(since, r.Threshold); err == nil && len(res) > 0 {
+			a.fire(models.SecurityAlertFailedLogins, res)
+		}
+	}
+
+	if r := a.opt.NewTokenNetwork; !coolingDown(last[models.SecurityAlertNewTokenNetwork], r.Cooldown, now) {
+		if nets, err := a.store.GetNewTokenNetworks(); err == nil && len(nets) > 0 {
+			// When the rule is disabled, the new networks are marked as alerted anyway
+			// so that they aren't alerted en masse if the rule is enabled later.
+			if !r.Enabled || a.fire(models.SecurityAlertNewTokenNetwork, nets) {
+				a.store.MarkTokenNetworksAlerted(nets)
+			}
+		}
+	}
+
+	if a.opt.Retention > 0 {
+		a.store.DeleteLoginEvents(now.Add(-a.opt.Retention))
+	}
+}
+
+// fire sends out an alert and records it. The alert is recorded even if it
+// couldn't be sent so that it appears in the history and the rule cools down.
+func (a *Alerter) fire(rule string, data any) bool {
+	a.log.Printf("security alert: %s", rule)
+
+	if err := a.notify(rule, data); err != nil {
+		a.log.Printf("error sending security alert (%s): %v", rule, err)
+	}
+
+	return a.store.RecordSecurityAlert(rule, data) == nil
+}
+
+// coolingDown checks whether a rule last fired at the given time is in its cooldown period.
+func coolingDown(last time.Time, cooldown time.Duration, now time.Time) bool {
+	return !last.IsZero() && now.Sub(last) < cooldown
+}
+
+// Network returns the network of an IP that's compared to detect the use from
+// new networks: the /24 of IPv4 and the /48 of IPv6 addresses. It returns an
+// empty string if the IP is invalid.
+func Network(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap().WithZone("")
+
+	bits := 48
+	if addr.Is4() {
+		bits = 24
+	}
+
+	p, err := addr.Prefix(bits)
+	if err != nil {
+		return ""
+	}
+
+	return p.String()
+}
@@ -0,0 +1,248 @@
+package secalert
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"github.com/knadh/listmonk/models"
+)
+
+type loginEvent struct {
+	at       time.Time
+	username string
+	ip       string
+}
+
+// testStore is an in-memory Store whose alerts are recorded at the time in now.
+type testStore struct {
+	now *time.Time
+
+	failed  []loginEvent
+	nets    []models.TokenNetwork
+	alerted map[string]bool
+	alerts  map[string]time.Time
+	fired   map[string]int
+	deleted time.Time
+}
+
+func newTestStore(now *time.Time) *testStore {
+	return &testStore{
+		now:     now,
+		alerted: map[string]bool{},
+		alerts:  map[string]time.Time{},
+		fired:   map[string]int{},
+	}
+}
+
+func (s *testStore) GetFailedLogins(since time.Time, threshold int) ([]models.FailedLogins, error) {
+	var (
+		users = map[string]int{}
+		ips   = map[string]int{}
+	)
+	for _, e := range s.failed {
+		if e.at.After(since) {
+			users[e.username]++
+			ips[e.ip]++
+		}
+	}
+
+	var out []models.FailedLogins
+	for v, n := range users {
+		if n >= threshold {
+			out = append(out, models.FailedLogins{Type: "username", Value: v, Count: n})
+		}
+	}
+	for v, n := range ips {
+		if n >= threshold {
+			out = append(out, models.FailedLogins{Type: "ip", Value: v, Count: n})
+		}
+	}
+	return out, nil
+}
+
+func (s *testStore) GetNewTokenNetworks() ([]models.TokenNetwork, error) {
+	var out []models.TokenNetwork
+	for _, n := range s.nets {
+		if !s.alerted[n.Username+n.Network] {
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
+
+func (s *testStore) MarkTokenNetworksAlerted(nets []models.TokenNetwork) error {
+	for _, n := range nets {
+		s.alerted[n.Username+n.Network] = true
+	}
+	return nil
+}
+
+func (s *testStore) DeleteLoginEvents(before time.Time) error {
+	s.deleted = before
+	return nil
+}
+
+func (s *testStore) GetLastSecurityAlerts() (map[string]time.Time, error) {
+	out := make(map[string]time.Time, len(s.alerts))
+	for k, v := range s.alerts {
+		out[k] = v
+	}
+	return out, nil
+}
+
+func (s *testStore) RecordSecurityAlert(rule string, data any) error {
+	s.alerts[rule] = *s.now
+	s.fired[rule]++
+	return nil
+}
+
+// fail records n failed logins at the given time.
+func (s *testStore) fail(at time.Time, username, ip string, n int) {
+	for range n {
+		s.failed = append(s.failed, loginEvent{at: at, username: username, ip: ip})
+	}
+}
+
+func newTestAlerter(opt Opt, st Store) (*Alerter, map[string][]any) {
+	sent := map[string][]any{}
+	a := New(opt, st, func(rule string, data any) error {
+		sent[rule] = append(sent[rule], data)
+		return nil
+	}, log.New(io.Discard, "", 0))
+
+	return a, sent
+}
+
+func TestFailedLogins(t *testing.T) {
+	var (
+		t0  = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+		now = t0
+		st  = newTestStore(&now)
+	)
+	a, sent := newTestAlerter(Opt{
+		FailedLogins: FailedLoginsRule{Enabled: true, Threshold: 5, Window: 15 * time.Minute, Cooldown: time.Hour},
+	}, st)
+
+	// Below the threshold and outside the window.
+	st.fail(t0.Add(-time.Minute), "alice", "192.0.2.1", 4)
+	st.fail(t0.Add(-20*time.Minute), "bob", "192.0.2.2", 10)
+	a.Evaluate(now)
+	if len(sent) != 0 {
+		t.Fatalf("expected no alerts, got %v", sent)
+	}
+
+	// Crossing the threshold fires with only the matching username and IP.
+	st.fail(t0.Add(-time.Second), "alice", "192.0.2.1", 1)
+	a.Evaluate(now)
+	res, ok := sent[models.SecurityAlertFailedLogins]
+	if !ok || len(res) != 1 {
+		t.Fatalf("expected an alert, got %v", sent)
+	}
+	if fl := res[0].([]models.FailedLogins); len(fl) != 2 || fl[0].Count != 5 || fl[1].Count != 5 {
+		t.Fatalf("unexpected alert data %+v", fl)
+	}
+
+	// More failures during the cooldown don't fire again.
+	now = t0.Add(50 * time.Minute)
+	st.fail(now.Add(-time.Second), "carol", "192.0.2.3", 10)
+	a.Evaluate(now)
+	if n := len(sent[models.SecurityAlertFailedLogins]); n != 1 {
+		t.Fatalf("expected no alerts during the cooldown, got %d", n)
+	}
+
+	// After the cooldown, the failures since the last alert fire. The ones that
+	// were already alerted aren't counted again.
+	now = t0.Add(time.Hour)
+	a.Evaluate(now)
+	res = sent[models.SecurityAlertFailedLogins]
+	if len(res) != 2 {
+		t.Fatalf("expected an alert after the cooldown, got %d", len(res))
+	}
+	for _, f := range res[1].([]models.FailedLogins) {
+		if f.Value != "carol" && f.Value != "192.0.2.3" {
+			t.Errorf("unexpected failures in the alert: %+v", f)
+		}
+	}
+	if st.fired[models.SecurityAlertFailedLogins] != 2 {
+		t.Errorf("expected 2 recorded alerts, got %d", st.fired[models.SecurityAlertFailedLogins])
+	}
+}
+
+func TestNewTokenNetwork(t *testing.T) {
+	var (
+		t0  = time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+		now = t0
+		st  = newTestStore(&now)
+	)
+	opt := Opt{NewTokenNetwork: NewTokenNetworkRule{Enabled: true, Cooldown: time.Hour}, Retention: 24 * time.Hour}
+	a, sent := newTestAlerter(opt, st)
+
+	st.nets = []models.TokenNetwork{{Username: "api", Network: "192.0.2.0/24", IP: "192.0.2.1"}}
+	a.Evaluate(now)
+	if len(sent[models.SecurityAlertNewTokenNetwork]) != 1 || !st.alerted["api192.0.2.0/24"] {
+		t.Fatalf("expected an alert for the new network, got %v", sent)
+	}
+	if !st.deleted.Equal(now.Add(-24 * time.Hour)) {
+		t.Errorf("expected the events before the retention to be deleted, got %v", st.deleted)
+	}
+
+	// A new network during the cooldown waits until it's over, and alerted
+	// networks aren't alerted again.
+	st.nets = append(st.nets, models.TokenNetwork{Username: "api", Network: "198.51.100.0/24", IP: "198.51.100.1"})
+	now = t0.Add(59 * time.Minute)
+	a.Evaluate(now)
+	if n := len(sent[models.SecurityAlertNewTokenNetwork]); n != 1 {
+		t.Fatalf("expected no alerts during the cooldown, got %d", n)
+	}
+	now = t0.Add(time.Hour)
+	a.Evaluate(now)
+	res := sent[models.SecurityAlertNewTokenNetwork]
+	if len(res) != 2 {
+		t.Fatalf("expected an alert after the cooldown, got %d", len(res))
+	}
+	if nets := res[1].([]models.TokenNetwork); len(nets) != 1 || nets[0].Network != "198.51.100.0/24" {
+		t.Fatalf("unexpected alert data %+v", nets)
+	}
+
+	// With the rule disabled, new networks are marked as alerted without firing.
+	a, sent = newTestAlerter(Opt{NewTokenNetwork: NewTokenNetworkRule{Enabled: false}}, st)
+	st.nets = append(st.nets, models.TokenNetwork{Username: "api", Network: "203.0.113.0/24", IP: "203.0.113.1"})
+	now = t0.Add(3 * time.Hour)
+	a.Evaluate(now)
+	if len(sent) != 0 || !st.alerted["api203.0.113.0/24"] {
+		t.Fatalf("expected the network to be marked without an alert, got %v", sent)
+	}
+}
+
+func TestNotifyError(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	st := newTestStore(&now)
+	a := New(Opt{FailedLogins: FailedLoginsRule{Enabled: true, Threshold: 1, Window: time.Hour, Cooldown: time.Hour}}, st,
+		func(rule string, data any) error { return errors.New("smtp down") }, log.New(io.Discard, "", 0))
+
+	// Alerts that can't be sent are recorded so that the rule cools down.
+	st.fail(now.Add(-time.Minute), "alice", "192.0.2.1", 1)
+	a.Evaluate(now)
+	if st.fired[models.SecurityAlertFailedLogins] != 1 {
+		t.Fatal("expected the alert to be recorded")
+	}
+}
+
+func TestNetwork(t *testing.T) {
+	cases := map[string]string{
+		"192.0.2.55":        "192.0.2.0/24",
+		"::ffff:192.0.2.55": "192.0.2.0/24",
+		"2001:db8:1:2::1":   "2001:db8:1::/48",
+		"fe80::1%eth0":      "fe80::/48",
+		"not an ip":         "",
+		"":                  "",
+	}
+	for ip, want := range cases {
+		if got := Network(ip); got != want {
+			t.Errorf("%q: expected %q, got %q", ip, want, got)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Package sendlimit keeps per-server counts of sent messages and enforces the
+// daily and per-minute sending limits that mail providers publish, eg: 2000 a day
+// on Google Workspace. Servers are identified by arbitrary keys so that the
+// messengers sharing a server share its limits. Daily counts are persisted so
+// that they survive restarts.
+package sendlimit
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Limit represents the sending limits of a server. 0 is no limit.
+type Limit struct {
+	PerDay    int `json:"per_day"`
+	PerMinute int `json:"per_minute"`
+}
+
+// Providers is the table of known providers and their published limits. The limits
+// vary by account and change over time, and can be overridden per server.
+var Providers = map[string]Limit{
+	"gmail":                  {PerDay: 500},
+	"google_workspace":       {PerDay: 2000},
+	"google_workspace_relay": {PerDay: 10000},
+	"office365":              {PerDay: 10000, PerMinute: 30},
+	"outlook":                {PerDay: 300},
+	"yahoo":                  {PerDay: 500},
+	"zoho":                   {PerDay: 500},
+	"ses_sandbox":            {PerDay: 200, PerMinute: 60},
+	"sendgrid_free":          {PerDay: 100},
+	"mailgun_free":           {PerDay: 100},
+	"brevo_free":             {PerDay: 300},
+}
+
+// Store persists the daily per-server sent counts.
+type Store interface {
+	// GetSendCounts returns the counts of the given day by server key.
+	GetSendCounts(day time.Time) (map[string]int, error)
+
+	// AddSendCounts adds the given counts to the counts of the day.
+	AddSendCounts(day time.Time, counts map[string]int) error
+}
+
+// ErrDailyLimit is returned when a server's daily limit has been reached.
+type ErrDailyLimit struct {
+	Limit int
+}
+
+func (e *ErrDailyLimit) Error() string {
+	return "daily sending limit reached"
+}
+
+// ErrRateLimit is returned when a server's per-minute limit has been reached.
+// The message can be sent after RetryAfter.
+type ErrRateLimit struct {
+	RetryAfter time.Duration
+}
+
+func (e *ErrRateLimit) Error() string {
+	return fmt.Sprintf("sending rate limit reached, retry after %v", e.RetryAfter.Round(time.Second))
+}
+
+// Limiter tracks the sent counts of servers against their limits.
+type Limiter struct {
+	servers map[string]*counter
+	mut     sync.Mutex
+
+	// The current UTC day. Daily counts reset when it changes.
+	day time.Time
+
+	store Store
+	log   *log.Logger
+}
+
+type counter struct {
+	limit Limit
+
+	day int
+
+	// Counts sent on the day that are yet to be persisted.
+	unsaved int
+
+	minute      int
+	minuteStart time.Time
+}
+
+// New returns a new Limiter.
+func New(st Store, lo *log.Logger) *Limiter {
+	return &Limiter{
+		servers: make(map[string]*counter),
+		day:     today(),
+		store:   st,
+		log:     lo,
+	}
+}
+
+// Add sets the limits of a server and loads its count of the day. Servers that
+// aren't added aren't limited.
+func (l *Limiter) Add(key string, lim Limit) error {
+	if lim.PerDay <= 0 && lim.PerMinute <= 0 {
+		return nil
+	}
+
+	counts, err := l.store.GetSendCounts(l.day)
+	if err != nil {
+		return err
+	}
+
+	l.mut.Lock()
+	if c, ok := l.servers[key]; ok {
+		c.limit = lim
+	} else {
+		l.servers[key] = &counter{limit: lim, day: counts[key]}
+	}
+	l.mut.Unlock()
+
+	return nil
+}
+
+// Take counts a message against a server's limits. If the server's per-minute
+// limit has been reached, nothing is counted and the duration to wait for
+// before trying again is returned. If the daily limit has been reached,
+// *ErrDailyLimit is returned.
+func (l *Limiter) Take(key string) (time.Duration, error) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	c, ok := l.servers[key]
+	if !ok {
+		return 0, nil
+	}
+
+	now := time.Now()
+	if d := today(); !d.Equal(l.day) {
+		l.rollover(d)
+	}
+
+	if c.limit.PerDay > 0 && c.day >= c.limit.PerDay {
+		return 0, &ErrDailyLimit{Limit: c.limit.PerDay}
+	}
+
+	if c.limit.PerMinute > 0 {
+		if now.Sub(c.minuteStart) >= time.Minute {
+			c.minute = 0
+			c.minuteStart = now
+		}
+		if c.minute >= c.limit.PerMinute {
+			return c.minuteStart.Add(time.Minute).Sub(now), nil
+		}
+		c.minute++
+	}
+
+	c.day++
+	c.unsaved++
+
+	return 0, nil
+}
+
+// Release undoes a Take of a server, eg: when the message it was taken for
+// failed to be sent, so that only sent messages count against the limits.
+func (l *Limiter) Release(key string) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	c, ok := l.servers[key]
+	if !ok {
+		return
+	}
+
+	// The counts may have been reset since the Take.
+	if c.day > 0 {
+		c.day--
+	}
+	if c.unsaved > 0 {
+		c.unsaved--
+	}
+	if c.minute > 0 {
+		c.minute--
+	}
+}
+
+// Run persists the counts at the given interval. It blocks forever.
+func (l *Limiter) Run(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for range t.C {
+		l.Flush()
+	}
+}
+
+// Flush persists the counts that haven't been persisted yet.
+func (l *Limiter) Flush() {
+	l.mut.Lock()
+	day, counts := l.day, l.takeUnsaved()
+	l.mut.Unlock()
+
+	l.save(day, counts)
+}
+
+// rollover persists the unsaved counts of the previous day in the background
+// and resets the daily counts. It should be called with the lock held.
+func (l *Limiter) rollover(d time.Time) {
+	day, counts := l.day, l.takeUnsaved()
+	go l.save(day, counts)
+
+	for _, c := range l.servers {
+		c.day = 0
+	}
+	l.day = d
+}
+
+// takeUnsaved returns the unsaved counts and resets them. It should be
+// called with the lock held.
+func (l *Limiter) takeUnsaved() map[string]int {
+	out := make(map[string]int)
+	for k, c := range l.servers {
+		if c.unsaved > 0 {
+			out[k] = c.unsaved
+			c.unsaved = 0
+		}
+	}
+
+	return out
+}
+
+func (l *Limiter) save(day time.Time, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+
+	if err := l.store.AddSendCounts(day, counts); err != nil {
+		l.log.Printf("error saving server send counts: %v", err)
+	}
+}
+
+// today returns the current UTC day.
+func today() time.Time {
+	return time.Now().UTC().Truncate(24 * time.Hour)
+}
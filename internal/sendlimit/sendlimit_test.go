@@ -0,0 +1,117 @@
+package sendlimit
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memStore is an in-memory Store.
+type memStore struct {
+	mut    sync.Mutex
+	counts map[string]int
+}
+
+func (s *memStore) GetSendCounts(day time.Time) (map[string]int, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	out := make(map[string]int, len(s.counts))
+	for k, v := range s.counts {
+		out[k] = v
+	}
+	return out, nil
+}
+
+func (s *memStore) AddSendCounts(day time.Time, counts map[string]int) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	for k, v := range counts {
+		s.counts[k] += v
+	}
+	return nil
+}
+
+func newTestLimiter(t *testing.T, key string, lim Limit, saved int) (*Limiter, *memStore) {
+	t.Helper()
+
+	st := &memStore{counts: map[string]int{key: saved}}
+	l := New(st, log.New(io.Discard, "", 0))
+	if err := l.Add(key, lim); err != nil {
+		t.Fatal(err)
+	}
+	return l, st
+}
+
+func TestDailyLimit(t *testing.T) {
+	// The saved count of the day is loaded.
+	l, st := newTestLimiter(t, "a", Limit{PerDay: 3}, 1)
+
+	for range 2 {
+		if w, err := l.Take("a"); w != 0 || err != nil {
+			t.Fatalf("unexpected limit %v %v", w, err)
+		}
+	}
+
+	var dErr *ErrDailyLimit
+	if _, err := l.Take("a"); !errors.As(err, &dErr) || dErr.Limit != 3 {
+		t.Fatalf("expected the daily limit, got %v", err)
+	}
+
+	// Releasing a Take, eg: of a failed send, frees up the quota.
+	l.Release("a")
+	if _, err := l.Take("a"); err != nil {
+		t.Fatalf("expected the released quota to be available, got %v", err)
+	}
+
+	l.Flush()
+	if st.counts["a"] != 3 {
+		t.Fatalf("expected 3 saved, got %d", st.counts["a"])
+	}
+
+	// Servers without limits aren't limited.
+	for range 10 {
+		if w, err := l.Take("b"); w != 0 || err != nil {
+			t.Fatalf("unexpected limit %v %v", w, err)
+		}
+	}
+}
+
+func TestPerMinuteLimit(t *testing.T) {
+	l, st := newTestLimiter(t, "a", Limit{PerMinute: 2}, 0)
+
+	for range 2 {
+		if w, err := l.Take("a"); w != 0 || err != nil {
+			t.Fatalf("unexpected limit %v %v", w, err)
+		}
+	}
+
+	// Nothing's counted when the limit has been reached and the wait is returned.
+	w, err := l.Take("a")
+	if err != nil || w <= 0 || w > time.Minute {
+		t.Fatalf("expected a wait, got %v %v", w, err)
+	}
+
+	l.Release("a")
+	if w, err := l.Take("a"); w != 0 || err != nil {
+		t.Fatalf("expected the released quota to be available, got %v %v", w, err)
+	}
+
+	l.Flush()
+	if st.counts["a"] != 2 {
+		t.Fatalf("expected 2 saved, got %d", st.counts["a"])
+	}
+
+	// Releasing more than was taken doesn't go negative.
+	for range 5 {
+		l.Release("a")
+	}
+	l.Flush()
+	if st.counts["a"] != 2 {
+		t.Fatalf("expected 2 saved, got %d", st.counts["a"])
+	}
+}
@@ -0,0 +1,173 @@
+// Package shortlink generates and parses the short form of tracked links,
+// eg: https://listmonk.mysite.com/l/aZ3kP9/1c-8Fq2-1pQ0bK-3jXw9Lz0Q1.
+//
+// The first part is the link's random base62 slug and the second is the ref
+// that identifies the campaign and the subscriber, and carries the send
+// timestamp. The ref is signed with the campaign's and subscriber's UUIDs,
+// which are only known to the server and the recipient, so that refs can
+// neither be guessed from the sequential IDs nor tampered with, and remain
+// valid for as long as the campaign and subscriber exist.
+package shortlink
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	alphabet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+	// Length of new slugs. After every slugGrowAfter collisions in a row
+	// on generating a slug, the length is increased by one.
+	minSlugLen    = 6
+	slugGrowAfter = 3
+
+	// MaxSlugAttempts is the number of attempts at generating a unique
+	// slug before giving up.
+	MaxSlugAttempts = 12
+
+	// Number of bytes of the HMAC in the ref.
+	sigLen = 6
+
+	refSep = "-"
+)
+
+var (
+	errInvalidRef = errors.New("invalid ref")
+
+	alphabetLen = big.NewInt(int64(len(alphabet)))
+)
+
+// Ref identifies the campaign and subscriber of a short link.
+type Ref struct {
+	CampaignID int
+
+	// 0 for messages that aren't sent to a real subscriber, eg: seed list
+	// messages, and for all messages when individual tracking is disabled.
+	SubscriberID int
+
+	// Unix timestamp of when the message was sent.
+	SentAt int64
+
+	sig string
+}
+
+// NewSlug returns a random base62 slug for the given attempt (starting at 0)
+// at generating a unique slug. The slug grows longer with repeated collisions.
+func NewSlug(attempt int) (string, error) {
+	n := minSlugLen + attempt/slugGrowAfter
+
+	b := make([]byte, n)
+	for i := range b {
+		r, err := rand.Int(rand.Reader, alphabetLen)
+		if err != nil {
+			return "", err
+		}
+		b[i] = alphabet[r.Int64()]
+	}
+
+	return string(b), nil
+}
+
+// MakeRef returns the signed ref of a short link.
+func MakeRef(slug string, campID int, campUUID string, subID int, subUUID string, sentAt time.Time) string {
+	r := Ref{
+		CampaignID:   campID,
+		SubscriberID: subID,
+		SentAt:       sentAt.Unix(),
+	}
+
+	return strings.Join([]string{
+		Encode(uint64(r.CampaignID)),
+		Encode(uint64(r.SubscriberID)),
+		Encode(uint64(r.SentAt)),
+		r.sign(slug, campUUID, subUUID),
+	}, refSep)
+}
+
+// ParseRef parses a ref generated by MakeRef. The ref's signature should be
+// checked with Verify() once the campaign and subscriber UUIDs are known.
+func ParseRef(s string) (Ref, error) {
+	p := strings.Split(s, refSep)
+	if len(p) != 4 || p[3] == "" {
+		return Ref{}, errInvalidRef
+	}
+
+	var n [3]uint64
+	for i := range n {
+		v, err := Decode(p[i])
+		if err != nil || v > 1<<53 {
+			return Ref{}, errInvalidRef
+		}
+		n[i] = v
+	}
+
+	return Ref{
+		CampaignID:   int(n[0]),
+		SubscriberID: int(n[1]),
+		SentAt:       int64(n[2]),
+		sig:          p[3],
+	}, nil
+}
+
+// Verify checks the ref's signature against the link's slug and the
+// campaign's and subscriber's UUIDs.
+func (r Ref) Verify(slug, campUUID, subUUID string) bool {
+	return hmac.Equal([]byte(r.sig), []byte(r.sign(slug, campUUID, subUUID)))
+}
+
+func (r Ref) sign(slug, campUUID, subUUID string) string {
+	h := hmac.New(sha256.New, []byte(campUUID+"|"+subUUID))
+	h.Write([]byte(slug + "|" + strconv.FormatInt(r.SentAt, 10)))
+
+	var b [8]byte
+	copy(b[8-sigLen:], h.Sum(nil)[:sigLen])
+	return Encode(binary.BigEndian.Uint64(b[:]))
+}
+
+// Encode returns the base62 representation of n.
+func Encode(n uint64) string {
+	if n == 0 {
+		return alphabet[:1]
+	}
+
+	var b [11]byte
+	i := len(b)
+	for n > 0 {
+		i--
+		b[i] = alphabet[n%62]
+		n /= 62
+	}
+
+	return string(b[i:])
+}
+
+// Decode parses a base62 string generated by Encode.
+func Decode(s string) (uint64, error) {
+	if s == "" || len(s) > 11 {
+		return 0, errInvalidRef
+	}
+
+	var n uint64
+	for i := 0; i < len(s); i++ {
+		d := strings.IndexByte(alphabet, s[i])
+		if d < 0 {
+			return 0, errInvalidRef
+		}
+
+		v := n*62 + uint64(d)
+		if v/62 != n {
+			return 0, errInvalidRef
+		}
+		n = v
+	}
+
+	return n, nil
+}
@@ -0,0 +1,117 @@
+package shortlink
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	campUUID = "2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11"
+	subUUID  = "7c1e0b7e-3f38-4b47-9f6a-0b1e2d3c4f5a"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	for _, n := range []uint64{0, 1, 61, 62, 3843, 3844, 1 << 53, math.MaxUint64} {
+		s := Encode(n)
+		v, err := Decode(s)
+		if err != nil || v != n {
+			t.Errorf("%d: encoded to %s, decoded to %d: %v", n, s, v, err)
+		}
+	}
+
+	if Encode(61) != "Z" || Encode(62) != "10" {
+		t.Errorf("unexpected encoding %s, %s", Encode(61), Encode(62))
+	}
+
+	// Invalid characters, empty and overflowing strings.
+	for _, s := range []string{"", "a-b", "a b", "ü", "zzzzzzzzzzz", "100000000000"} {
+		if _, err := Decode(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestNewSlug(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		s, err := NewSlug(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(s) != minSlugLen || strings.Trim(s, alphabet) != "" {
+			t.Fatalf("invalid slug %q", s)
+		}
+		if seen[s] {
+			t.Fatalf("duplicate slug %q", s)
+		}
+		seen[s] = true
+	}
+
+	// Slugs grow longer with repeated collisions.
+	for attempt, n := range map[int]int{1: 6, 2: 6, 3: 7, 5: 7, 6: 8, MaxSlugAttempts - 1: 9} {
+		if s, _ := NewSlug(attempt); len(s) != n {
+			t.Errorf("attempt %d: expected a slug of length %d, got %q", attempt, n, s)
+		}
+	}
+}
+
+func TestRef(t *testing.T) {
+	sentAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	ref := MakeRef("aZ3kP9", 12, campUUID, 3456789, subUUID, sentAt)
+
+	r, err := ParseRef(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.CampaignID != 12 || r.SubscriberID != 3456789 || r.SentAt != sentAt.Unix() {
+		t.Fatalf("unexpected ref %+v", r)
+	}
+	if !r.Verify("aZ3kP9", campUUID, subUUID) {
+		t.Fatal("expected the ref to be verified")
+	}
+
+	// The signature is bound to the slug, the campaign and subscriber, and the timestamp.
+	if r.Verify("aZ3kP8", campUUID, subUUID) {
+		t.Error("expected the ref of another slug to fail verification")
+	}
+	if r.Verify("aZ3kP9", subUUID, campUUID) || r.Verify("aZ3kP9", campUUID, "") {
+		t.Error("expected the ref of another campaign or subscriber to fail verification")
+	}
+	p := strings.Split(ref, refSep)
+	for _, tampered := range []string{
+		strings.Join([]string{p[0], p[1], Encode(uint64(sentAt.Unix() + 1)), p[3]}, refSep),
+		strings.Join([]string{p[0], p[1], p[2], p[3] + "a"}, refSep),
+	} {
+		r, err := ParseRef(tampered)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Verify("aZ3kP9", campUUID, subUUID) {
+			t.Errorf("expected the tampered ref %s to fail verification", tampered)
+		}
+	}
+
+	// Refs without a subscriber are signed with the empty UUID.
+	r, err = ParseRef(MakeRef("aZ3kP9", 12, campUUID, 0, "", sentAt))
+	if err != nil || r.SubscriberID != 0 || !r.Verify("aZ3kP9", campUUID, "") {
+		t.Errorf("unexpected ref without a subscriber %+v: %v", r, err)
+	}
+}
+
+func TestParseRefInvalid(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"1-2-3",
+		"1-2-3-",
+		"1-2-3-4-5",
+		"1-x y-3-4",
+		"1--3-4",
+		Encode(1<<53+1) + "-1-1-abc",
+	} {
+		if _, err := ParseRef(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -1,9 +1,9 @@
 // Package subimporter implements a bulk ZIP/CSV importer of subscribers.
-// It implements a simple queue for buffering imports and committing records
-// to DB along with ZIP and CSV handling utilities. It is meant to be used as
-// a singleton as each Importer instance is stateful, where it keeps track of
-// an import in progress. Only one import should happen on a single importer
-// instance at a time.
+// Imports are queued as jobs that are persisted in the DB and run one at a time
+// by a worker. A job's progress is committed along with every batch of rows so
+// that a job that's interrupted by a shutdown or a crash resumes after the last
+// committed row instead of re-importing the file. It is meant to be used as
+// a singleton.
 package subimporter
 
 import (
@@ -25,6 +25,9 @@ import (
 	"time"
 
 	"github.com/gofrs/uuid/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/knadh/listmonk/internal/emailnorm"
+	"github.com/knadh/listmonk/internal/emailverify"
 	"github.com/knadh/listmonk/internal/i18n"
 	"github.com/knadh/listmonk/models"
 	"github.com/lib/pq"
@@ -33,17 +36,27 @@ import (
 )
 
 const (
-	// commitBatchSize is the number of inserts to commit in a single SQL transaction.
+	// commitBatchSize is the number of rows to commit in a single SQL transaction.
+	// The progress of a job is committed along with every batch.
 	commitBatchSize = 10000
+
+	// listBatchSize is the number of subscribers added to each of the lists
+	// that are created in the subscribe mode.
+	listBatchSize = 50
+
+	// pollInterval is the interval at which the idle worker checks the queue.
+	pollInterval = time.Second * 10
 )
 
 // Various import statuses.
 const (
 	StatusNone      = "none"
+	StatusQueued    = "queued"
 	StatusImporting = "importing"
 	StatusStopping  = "stopping"
 	StatusFinished  = "finished"
 	StatusFailed    = "failed"
+	StatusStopped   = "stopped"
 
 	ModeSubscribe = "subscribe"
 	ModeBlocklist = "blocklist"
@@ -54,6 +67,7 @@ type Importer struct {
 	opt  Options
 	db   *sql.DB
 	i18n *i18n.I18n
+	log  *log.Logger
 
 	domainBlocklist       map[string]struct{}
 	hasBlocklistWildcards bool
@@ -63,8 +77,13 @@ type Importer struct {
 	hasAllowlistWildcards bool
 	hasAllowlist          bool
 
-	stop   chan bool
-	status Status
+	// Signals the worker that a job has been queued.
+	queued chan bool
+
+	// The running session and the ID of the job whose status is reported
+	// by GetStats(). It's the last job that was queued or run.
+	sess   *Session
+	lastID int
 	sync.RWMutex
 }
 
@@ -74,19 +93,56 @@ type Options struct {
 	BlocklistStmt      *sql.Stmt
 	UpdateListDateStmt *sql.Stmt
 	CreateListStmt     *sql.Stmt
-	PostCB             func(subject string, data any) error
+
+	// Import job queries.
+	CreateJobStmt         *sqlx.Stmt
+	NextJobStmt           *sqlx.Stmt
+	GetJobStmt            *sqlx.Stmt
+	CountQueuedJobsStmt   *sqlx.Stmt
+	UpdateJobProgressStmt *sqlx.Stmt
+	UpdateJobStatusStmt   *sqlx.Stmt
+	StopQueuedJobStmt     *sqlx.Stmt
+	InsertJobErrorStmt    *sqlx.Stmt
+
+	PostCB func(subject string, data any) error
+
+	// Dir is the directory in which the files of queued jobs are kept until
+	// they're imported. It should persist across restarts for interrupted
+	// jobs to be resumed.
+	Dir string
 
 	DomainBlocklist []string
 	DomainAllowlist []string
+
+	// Verifier, if set, is used to verify e-mails in sessions
+	// that have VerifyEmails turned on.
+	Verifier *emailverify.Verifier
+
+	// EmailNormalization is the policy for detecting existing subscribers
+	// with differently spelt e-mails.
+	EmailNormalization emailnorm.Policy
+
+	// TrackedAttribs are the attribute keys whose value changes on
+	// overwriting existing subscribers are recorded in the history.
+	TrackedAttribs []string
 }
 
-// Session represents a single import session.
+// Session represents a single import job that's being run.
 type Session struct {
-	im       *Importer
-	subQueue chan SubReq
-	log      *log.Logger
-
+	im  *Importer
+	job models.ImportJob
 	opt SessionOpt
+
+	status   string
+	imported int
+	failed   int
+	state    sessionState
+
+	log    *log.Logger
+	logBuf *logBuffer
+
+	stop     chan bool
+	stopOnce sync.Once
 }
 
 // SessionOpt represents the options for an importer session.
@@ -97,15 +153,33 @@ type SessionOpt struct {
 	Overwrite bool   `json:"overwrite"`
 	Delim     string `json:"delim"`
 	ListIDs   []int  `json:"lists"`
+
+	// VerifyEmails runs the e-mail verification checks on every row and
+	// marks the subscribers that fail them with an `email_verification` attribute.
+	VerifyEmails bool `json:"verify_emails"`
 }
 
-// Status represents statistics from an ongoing import session.
+// sessionState is the state of a session that's carried over when an
+// interrupted job resumes.
+type sessionState struct {
+	// The list that subscribers are being added to in the subscribe mode,
+	// its sequence number, and the number of subscribers added to it.
+	ListID   int `json:"list_id"`
+	ListNum  int `json:"list_num"`
+	ListSubs int `json:"list_subs"`
+}
+
+// Status represents statistics from an import job.
 type Status struct {
+	ID       int    `json:"id"`
 	Name     string `json:"name"`
 	Total    int    `json:"total"`
 	Imported int    `json:"imported"`
+	Failed   int    `json:"failed"`
 	Status   string `json:"status"`
-	logBuf   *bytes.Buffer
+
+	// Number of jobs waiting in the queue.
+	Queued int `json:"queued"`
 }
 
 // SubReq is a wrapper over the Subscriber model.
@@ -123,10 +197,16 @@ type importStatusTpl struct {
 	Total    int
 }
 
+// logBuffer is a buffer that's safe for the session's logger to write to
+// while the logs are being read.
+type logBuffer struct {
+	buf bytes.Buffer
+	sync.Mutex
+}
+
 var (
-	// ErrIsImporting is thrown when an import request is made while an
-	// import is already running.
-	ErrIsImporting = errors.New("import is already running")
+	// ErrNotStoppable is returned when stopping a job that's neither running nor queued.
+	ErrNotStoppable = errors.New("import job isn't running or queued")
 
 	csvHeaders = map[string]bool{
 		"email":      true,
@@ -138,15 +218,15 @@ var (
 )
 
 // New returns a new instance of Importer.
-func New(opt Options, db *sql.DB, i *i18n.I18n) *Importer {
+func New(opt Options, db *sql.DB, i *i18n.I18n, lo *log.Logger) *Importer {
 	im := Importer{
 		opt:             opt,
 		db:              db,
 		i18n:            i,
+		log:             lo,
 		domainBlocklist: make(map[string]struct{}, len(opt.DomainBlocklist)),
 		domainAllowlist: make(map[string]struct{}, len(opt.DomainAllowlist)),
-		status:          Status{Status: StatusNone, logBuf: bytes.NewBuffer(nil)},
-		stop:            make(chan bool, 1),
+		queued:          make(chan bool, 1),
 	}
 
 	// Domain blocklist.
@@ -164,146 +244,428 @@ func New(opt Options, db *sql.DB, i *i18n.I18n) *Importer {
 	return &im
 }
 
-// NewSession returns an new instance of Session. It takes the name
-// of the uploaded file, but doesn't do anything with it but retains it for stats.
-func (im *Importer) NewSession(opt SessionOpt) (*Session, error) {
-	if !im.isDone() {
-		return nil, errors.New("an import is already running")
+// Queue copies the CSV or ZIP file at srcPath to the import directory and
+// queues a job to import it. Only the first CSV file in a ZIP is imported.
+func (im *Importer) Queue(opt SessionOpt, srcPath string) (Status, error) {
+	var (
+		logBuf = &logBuffer{}
+		lo     = newLogger(logBuf)
+	)
+	lo.Printf("processing '%s'", opt.Filename)
+
+	if err := os.MkdirAll(im.opt.Dir, 0700); err != nil {
+		return Status{}, err
+	}
+
+	uu, err := uuid.NewV4()
+	if err != nil {
+		return Status{}, err
+	}
+	path := filepath.Join(im.opt.Dir, uu.String()+".csv")
+
+	if strings.HasSuffix(strings.ToLower(opt.Filename), ".csv") {
+		err = copyFile(srcPath, path)
+	} else {
+		// Only 1 CSV from the ZIP is considered. If multiple files have
+		// to be processed, counting the net number of lines (to track progress),
+		// keeping the global import state (failed / successful) etc. across
+		// multiple files becomes complex. Instead, it's just easier for the
+		// end user to concat multiple CSVs (if there are multiple in the first)
+		// place and upload as one in the first place.
+		err = extractCSV(srcPath, path, lo)
+	}
+	if err != nil {
+		os.Remove(path)
+		return Status{}, err
+	}
+
+	// Count the total number of lines in the file. This doesn't distinguish
+	// between "blank" and non "blank" lines, and is only used to derive
+	// the progress percentage for the frontend.
+	numLines, err := countFileLines(path)
+	if err != nil || numLines == 0 {
+		os.Remove(path)
+		if err == nil {
+			err = errors.New("empty file")
+		}
+		return Status{}, err
+	}
+
+	params, err := json.Marshal(opt)
+	if err != nil {
+		os.Remove(path)
+		return Status{}, err
+	}
+
+	// Exclude the header from count.
+	var job models.ImportJob
+	if err := im.opt.CreateJobStmt.Get(&job, opt.Filename, opt.Mode, params, path, numLines-1, logBuf.String()); err != nil {
+		im.log.Printf("error creating import job: %v", err)
+		os.Remove(path)
+		return Status{}, err
 	}
 
 	im.Lock()
-	im.status = Status{Status: StatusImporting,
-		Name:   opt.Filename,
-		logBuf: bytes.NewBuffer(nil)}
+	if im.sess == nil {
+		im.lastID = job.ID
+	}
 	im.Unlock()
 
+	// Wake up the worker.
+	select {
+	case im.queued <- true:
+	default:
+	}
+
+	return jobStatus(job), nil
+}
+
+// Run runs the queued jobs one at a time in the order they were queued. A job
+// that was interrupted by a shutdown or a crash is resumed first. It blocks forever.
+func (im *Importer) Run() {
+	t := time.NewTicker(pollInterval)
+	defer t.Stop()
+
+	for {
+		// Run jobs until the queue is empty.
+		for im.runNext() {
+		}
+
+		select {
+		case <-im.queued:
+		case <-t.C:
+		}
+	}
+}
+
+// runNext runs the next job in the queue, if there's one.
+func (im *Importer) runNext() bool {
+	var job models.ImportJob
+	if err := im.opt.NextJobStmt.Get(&job); err != nil {
+		if err != sql.ErrNoRows {
+			im.log.Printf("error fetching next import job: %v", err)
+		}
+		return false
+	}
+
+	s, err := im.newSession(job)
+	if err != nil {
+		im.log.Printf("error loading import job %d: %v", job.ID, err)
+		if _, err := im.opt.UpdateJobStatusStmt.Exec(job.ID, StatusFailed, err.Error()+"\n"); err != nil {
+			im.log.Printf("error updating import job %d: %v", job.ID, err)
+			return false
+		}
+		os.Remove(job.FilePath)
+		return true
+	}
+
+	im.Lock()
+	im.sess = s
+	im.lastID = job.ID
+	im.Unlock()
+
+	s.run()
+
+	im.Lock()
+	im.sess = nil
+	im.Unlock()
+
+	return true
+}
+
+// newSession returns a session for running a job.
+func (im *Importer) newSession(job models.ImportJob) (*Session, error) {
 	s := &Session{
 		im:       im,
-		log:      log.New(im.status.logBuf, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile),
-		subQueue: make(chan SubReq, commitBatchSize),
-		opt:      opt,
+		job:      job,
+		status:   job.Status,
+		imported: job.Imported,
+		failed:   job.Failed,
+		logBuf:   &logBuffer{},
+		stop:     make(chan bool),
+	}
+	s.log = newLogger(s.logBuf)
+
+	if err := json.Unmarshal(job.Params, &s.opt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(job.State, &s.state); err != nil {
+		return nil, err
 	}
 
-	s.log.Printf("processing '%s'", opt.Filename)
 	return s, nil
 }
 
-// GetStats returns the global Stats of the importer.
+// GetStats returns the status of the running import job, or the last one.
 func (im *Importer) GetStats() Status {
 	im.RLock()
-	defer im.RUnlock()
+	s, id := im.sess, im.lastID
+	im.RUnlock()
 
-	return Status{
-		Name:     im.status.Name,
-		Status:   im.status.Status,
-		Total:    im.status.Total,
-		Imported: im.status.Imported,
+	out := Status{Status: StatusNone}
+	if s != nil {
+		out = s.getStatus()
+	} else if id > 0 {
+		var job models.ImportJob
+		if err := im.opt.GetJobStmt.Get(&job, id); err == nil {
+			out = jobStatus(job)
+		}
+	}
+
+	if err := im.opt.CountQueuedJobsStmt.Get(&out.Queued); err != nil {
+		im.log.Printf("error counting queued import jobs: %v", err)
 	}
+
+	return out
 }
 
-// GetLogs returns the log entries of the last import session.
+// GetLogs returns the log entries of the running import job, or the last one.
 func (im *Importer) GetLogs() []byte {
 	im.RLock()
-	defer im.RUnlock()
+	s, id := im.sess, im.lastID
+	im.RUnlock()
+
+	if s != nil {
+		return s.getLogs()
+	}
 
-	if im.status.logBuf == nil {
-		return []byte{}
+	if id > 0 {
+		var job models.ImportJob
+		if err := im.opt.GetJobStmt.Get(&job, id); err == nil {
+			return []byte(job.Log)
+		}
 	}
 
-	return im.status.logBuf.Bytes()
+	return []byte{}
 }
 
-// setStatus sets the Importer's status.
-func (im *Importer) setStatus(status string) {
+// Stop stops the running import job. If there's none, the status of the last
+// job is cleared.
+func (im *Importer) Stop() {
 	im.Lock()
-	im.status.Status = status
+	s := im.sess
+	if s == nil {
+		im.lastID = 0
+	}
 	im.Unlock()
+
+	if s != nil {
+		s.Stop()
+	}
 }
 
-// getStatus get's the Importer's status.
-func (im *Importer) getStatus() string {
+// StopJob stops a running job or cancels a queued one.
+func (im *Importer) StopJob(id int) error {
 	im.RLock()
-	status := im.status.Status
+	s := im.sess
 	im.RUnlock()
-	return status
-}
 
-// isDone returns true if the importer is working (importing|stopping).
-func (im *Importer) isDone() bool {
-	s := true
-	im.RLock()
-	if im.getStatus() == StatusImporting || im.getStatus() == StatusStopping {
-		s = false
+	if s != nil && s.job.ID == id {
+		s.Stop()
+		return nil
 	}
-	im.RUnlock()
 
-	return s
-}
+	var path string
+	if err := im.opt.StopQueuedJobStmt.Get(&path, id); err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNotStoppable
+		}
+		im.log.Printf("error stopping import job %d: %v", id, err)
+		return err
+	}
+	os.Remove(path)
 
-// incrementImportCount sets the Importer's "imported" counter.
-func (im *Importer) incrementImportCount(n int) {
-	im.Lock()
-	im.status.Imported += n
-	im.Unlock()
+	return nil
 }
 
 // sendNotif sends admin notifications for import completions.
-func (im *Importer) sendNotif(status string) error {
+func (im *Importer) sendNotif(s Status) error {
 	var (
-		s   = im.GetStats()
 		out = importStatusTpl{
 			Name:     s.Name,
-			Status:   status,
+			Status:   s.Status,
 			Imported: s.Imported,
 			Total:    s.Total,
 		}
-		subject = fmt.Sprintf("%s: %s import", cases.Title(language.Und).String(status), s.Name)
+		subject = fmt.Sprintf("%s: %s import", cases.Title(language.Und).String(s.Status), s.Name)
 	)
 	return im.opt.PostCB(subject, out)
 }
 
-// Start is a blocking function that selects on a channel queue until all
-// subscriber entries in the import session are imported. It should be
-// invoked as a goroutine.
-func (s *Session) Start() {
-	var (
-		tx    *sql.Tx
-		stmt  *sql.Stmt
-		err   error
-		total = 0
-		cur   = 0
-	)
+// run imports the job's file, resuming after the last committed row if the job
+// was interrupted, and records the job's final status.
+func (s *Session) run() {
+	var status string
+	if s.job.Status == StatusStopping {
+		// The job was interrupted while it was being stopped.
+		s.log.Println("stop request received")
+		status = StatusStopped
+	} else {
+		if s.job.Line > 0 {
+			s.log.Printf("resuming after line %d", s.job.Line)
+		}
+		status = s.importFile()
+	}
 
-	// List batching.
-	var (
-		listNameBase       = regexCleanSubStr.ReplaceAllString(strings.TrimSuffix(filepath.Base(s.opt.Filename), filepath.Ext(s.opt.Filename)), "_")
-		listCounter        = 0
-		currentListID      = 0
-		subsInCurrentList  = 0
-		createListStmt     *sql.Stmt
-	)
+	if status == StatusFinished {
+		s.log.Printf("imported finished")
+
+		// The lists are generated in the subscribe mode and are fresh.
+		listIDs := []int{}
+		if s.opt.Mode != ModeSubscribe {
+			listIDs = s.opt.ListIDs
+		}
+		if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(listIDs)); err != nil {
+			s.log.Printf("error updating lists date: %v", err)
+		}
+	}
+
+	logs, n := s.logBuf.flush()
+	if _, err := s.im.opt.UpdateJobStatusStmt.Exec(s.job.ID, status, logs); err != nil {
+		// The job is resumed on the next run.
+		s.im.log.Printf("error updating import job %d: %v", s.job.ID, err)
+		return
+	}
+
+	s.im.Lock()
+	s.status = status
+	s.job.Log += logs
+	s.logBuf.flushed(n)
+	s.im.Unlock()
+
+	os.Remove(s.job.FilePath)
+	s.im.sendNotif(s.getStatus())
+}
+
+// importFile imports the rows of the job's file that haven't been imported.
+// Every batch of rows is committed along with the job's progress. It returns
+// the final status of the job.
+func (s *Session) importFile() string {
+	f, err := os.Open(s.job.FilePath)
+	if err != nil {
+		s.log.Printf("error opening '%s': %v", s.job.FilePath, err)
+		return StatusFailed
+	}
+	defer f.Close()
+
+	delim := ','
+	if s.opt.Delim != "" {
+		delim = rune(s.opt.Delim[0])
+	}
+
+	rd := csv.NewReader(f)
+	rd.Comma = delim
+
+	// Read the header.
+	csvHdr, err := rd.Read()
+	if err != nil {
+		s.log.Printf("error reading header from '%s': '%v'", s.opt.Filename, err)
+		return StatusFailed
+	}
+
+	hdrKeys := s.mapCSVHeaders(csvHdr, csvHeaders)
+	// email is a required header.
+	if _, ok := hdrKeys["email"]; !ok {
+		s.log.Printf("'email' column not found in '%s'", s.opt.Filename)
+		return StatusFailed
+	}
 
 	// If there's no filename, use a timestamp.
+	listNameBase := regexCleanSubStr.ReplaceAllString(strings.TrimSuffix(filepath.Base(s.opt.Filename), filepath.Ext(s.opt.Filename)), "_")
 	if listNameBase == "" {
 		listNameBase = fmt.Sprintf("%d", time.Now().Unix())
 	}
 
-	// Override user selected lists if mode is subscribe.
-	listIDs := make([]int, len(s.opt.ListIDs))
-	copy(listIDs, s.opt.ListIDs)
+	var (
+		tx             *sql.Tx
+		stmt           *sql.Stmt
+		createListStmt *sql.Stmt
+		errStmt        *sql.Stmt
+
+		// Rows, imported and failed rows in the current batch.
+		cur      = 0
+		imported = 0
+		failed   = 0
+
+		lnHdr = len(hdrKeys)
+		line  = 0
+	)
+
+	// commit commits the current batch along with the job's progress.
+	commit := func() bool {
+		if tx == nil {
+			return true
+		}
+
+		state, _ := json.Marshal(s.state)
+		logs, n := s.logBuf.flush()
+		if _, err := tx.Stmt(s.im.opt.UpdateJobProgressStmt.Stmt).Exec(s.job.ID, imported, failed, line, state, logs); err != nil {
+			tx.Rollback()
+			s.log.Printf("error updating import progress: %v", err)
+			return false
+		}
+
+		if err := tx.Commit(); err != nil {
+			tx.Rollback()
+			s.log.Printf("error committing to DB: %v", err)
+			return false
+		}
+
+		s.im.Lock()
+		s.imported += imported
+		s.failed += failed
+		s.job.Line = line
+		s.job.Log += logs
+		s.logBuf.flushed(n)
+		s.im.Unlock()
+
+		s.log.Printf("imported %d", s.imported)
 
-	if s.opt.Mode == ModeSubscribe {
-		// Reset lists to empty because we will be generating them.
-		listIDs = []int{}
+		tx, cur, imported, failed = nil, 0, 0, 0
+		return true
 	}
 
-	for sub := range s.subQueue {
-		if cur == 0 {
+	for {
+		// Check for the stop signal.
+		select {
+		case <-s.stop:
+			s.log.Println("stop request received")
+			if !commit() {
+				return StatusFailed
+			}
+			return StatusStopped
+		default:
+		}
+
+		cols, readErr := rd.Read()
+		if readErr == io.EOF {
+			break
+		}
+		line++
+
+		// Rows with a mismatching number of columns are skipped.
+		if readErr != nil {
+			if e, ok := readErr.(*csv.ParseError); !ok || e.Err != csv.ErrFieldCount {
+				s.log.Printf("error reading CSV '%s'", readErr)
+				if tx != nil {
+					tx.Rollback()
+				}
+				return StatusFailed
+			}
+		}
+
+		// Skip the rows that were committed before the job was interrupted.
+		if line <= s.job.Line {
+			continue
+		}
+
+		if tx == nil {
 			// New transaction batch.
+			var err error
 			tx, err = s.im.db.Begin()
 			if err != nil {
 				s.log.Printf("error creating DB transaction: %v", err)
-				continue
+				return StatusFailed
 			}
 
 			if s.opt.Mode == ModeSubscribe {
@@ -312,343 +674,322 @@ func (s *Session) Start() {
 			} else {
 				stmt = tx.Stmt(s.im.opt.BlocklistStmt)
 			}
+			errStmt = tx.Stmt(s.im.opt.InsertJobErrorStmt.Stmt)
 		}
+		cur++
 
-		uu, err := uuid.NewV4()
-		if err != nil {
-			s.log.Printf("error generating UUID: %v", err)
-			tx.Rollback()
-			break
+		sub, rowErr := s.makeSub(cols, readErr, hdrKeys, lnHdr, line)
+		if rowErr != nil {
+			s.log.Printf("skipping line %d: %v", line, rowErr)
+			if _, err := errStmt.Exec(s.job.ID, line, encodeRow(cols, delim), rowErr.Error()); err != nil {
+				s.log.Printf("error recording skipped line: %v", err)
+				tx.Rollback()
+				return StatusFailed
+			}
+			failed++
+		} else {
+			if err := s.insertSub(sub, stmt, createListStmt, listNameBase); err != nil {
+				s.log.Printf("error executing insert: %v", err)
+				tx.Rollback()
+				return StatusFailed
+			}
+			imported++
 		}
 
-		if s.opt.Mode == ModeSubscribe {
-			// Check if we need a new list.
-			if currentListID == 0 || subsInCurrentList >= 50 {
-				listCounter++
-				subsInCurrentList = 0
+		// Batch size is met. Commit.
+		if cur >= commitBatchSize {
+			if !commit() {
+				return StatusFailed
+			}
+		}
+	}
 
-				// Name: {listNameBase}-{date}-batch-{listCounter}
-				// Tag: import-{listNameBase}
-				// Added seconds to avoid collisions on same-day re-runs.
-				listName := fmt.Sprintf("%s-%s-batch-%d", listNameBase, time.Now().Format("20060102-150405"), listCounter)
-				listTags := []string{fmt.Sprintf("import-%s", listNameBase)}
+	// Commit the records that are left.
+	if !commit() {
+		return StatusFailed
+	}
 
-				uuList, err := uuid.NewV4()
-				if err != nil {
-					s.log.Printf("error generating list UUID: %v", err)
-					tx.Rollback()
-					break
-				}
+	return StatusFinished
+}
 
-				if err := createListStmt.QueryRow(uuList, listName, models.ListTypePrivate, models.ListOptinSingle, models.ListStatusActive, pq.StringArray(listTags), "").Scan(&currentListID); err != nil {
-					s.log.Printf("error creating list: %v", err)
-					tx.Rollback()
-					break
-				}
-				s.log.Printf("created list '%s' (id: %d)", listName, currentListID)
-			}
+// makeSub validates a CSV row and returns a subscriber from it.
+func (s *Session) makeSub(cols []string, readErr error, hdrKeys map[string]int, lnHdr, line int) (SubReq, error) {
+	if readErr != nil {
+		return SubReq{}, readErr
+	}
 
-			// Assign the current batch list to the subscriber.
-			// We overwrite any previous lists because we are strictly batching.
-			// We append the new list ID to the existing listIDs (which is empty).
-			_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs, pq.Array([]int{currentListID}), s.opt.SubStatus, s.opt.Overwrite)
-			subsInCurrentList++
-		} else if s.opt.Mode == ModeBlocklist {
-			_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs)
-		}
+	lnCols := len(cols)
+	if lnCols < lnHdr {
+		return SubReq{}, fmt.Errorf("column count (%d) does not match minimum header count (%d)", lnCols, lnHdr)
+	}
 
-		if err != nil {
-			s.log.Printf("error executing insert: %v", err)
-			tx.Rollback()
-			break
+	// Iterate the key map and based on the indices mapped earlier,
+	// form a map of key: csv_value, eg: email: user@user.com.
+	row := make(map[string]string, lnCols)
+	for key := range hdrKeys {
+		row[key] = cols[hdrKeys[key]]
+	}
+
+	sub := SubReq{}
+	sub.Email = row["email"]
+
+	if v, ok := row["name"]; ok {
+		sub.Name = v
+	}
+
+	sub, err := s.im.ValidateFields(sub)
+	if err != nil {
+		return sub, err
+	}
+
+	// JSON attributes.
+	if len(row["attributes"]) > 0 {
+		var (
+			attribs models.JSON
+			b       = []byte(row["attributes"])
+		)
+		if err := json.Unmarshal(b, &attribs); err != nil {
+			s.log.Printf("skipping invalid attributes JSON on line %d for '%s': %v", line, sub.Email, err)
+		} else {
+			sub.Attribs = attribs
 		}
-		cur++
-		total++
+	}
 
-		// Batch size is met. Commit.
-		if cur%commitBatchSize == 0 {
-			if err := tx.Commit(); err != nil {
-				tx.Rollback()
-				s.log.Printf("error committing to DB: %v", err)
-			} else {
-				s.im.incrementImportCount(cur)
-				s.log.Printf("imported %d", total)
+	// Mark the subscriber if the e-mail fails verification.
+	if s.opt.VerifyEmails && s.im.opt.Verifier != nil {
+		if err := s.im.opt.Verifier.Verify(sub.Email); err != nil {
+			s.log.Printf("line %d: '%s' failed verification: %v", line, sub.Email, err)
 
-				// Update list date? The lists are new, so they have fresh dates.
-				// But if we were appending, we would update them.
+			v := map[string]any{"valid": false}
+			if e, ok := err.(*emailverify.Error); ok {
+				v["reason"] = e.Reason
+				if e.Suggestion != "" {
+					v["suggestion"] = e.Suggestion
+				}
 			}
 
-			cur = 0
+			if sub.Attribs == nil {
+				sub.Attribs = models.JSON{}
+			}
+			sub.Attribs["email_verification"] = v
 		}
 	}
 
-	// Queue's closed and there's nothing left to commit.
-	if cur == 0 {
-		s.im.setStatus(StatusFinished)
-		s.log.Printf("imported finished")
-		if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(listIDs)); err != nil {
-			s.log.Printf("error updating lists date: %v", err)
-		}
-		s.im.sendNotif(StatusFinished)
-		return
+	return sub, nil
+}
+
+// insertSub inserts a subscriber in the current transaction. In the subscribe
+// mode, subscribers are added to lists that are created in batches of listBatchSize.
+func (s *Session) insertSub(sub SubReq, stmt, createListStmt *sql.Stmt, listNameBase string) error {
+	uu, err := uuid.NewV4()
+	if err != nil {
+		return err
 	}
 
-	// Queue's closed and there are records left to commit.
-	if err := tx.Commit(); err != nil {
-		tx.Rollback()
-		s.im.setStatus(StatusFailed)
-		s.log.Printf("error committing to DB: %v", err)
-		s.im.sendNotif(StatusFailed)
-		return
+	if s.opt.Mode == ModeBlocklist {
+		_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs, emailnorm.Normalize(sub.Email, s.im.opt.EmailNormalization))
+		return err
 	}
 
-	s.im.incrementImportCount(cur)
-	s.im.setStatus(StatusFinished)
-	s.log.Printf("imported finished")
-	if _, err := s.im.opt.UpdateListDateStmt.Exec(pq.Array(listIDs)); err != nil {
-		s.log.Printf("error updating lists date: %v", err)
+	// Check if we need a new list.
+	if s.state.ListID == 0 || s.state.ListSubs >= listBatchSize {
+		s.state.ListNum++
+		s.state.ListSubs = 0
+
+		// Name: {listNameBase}-{date}-batch-{listCounter}
+		// Tag: import-{listNameBase}
+		// Added seconds to avoid collisions on same-day re-runs.
+		listName := fmt.Sprintf("%s-%s-batch-%d", listNameBase, time.Now().Format("20060102-150405"), s.state.ListNum)
+		listTags := []string{fmt.Sprintf("import-%s", listNameBase)}
+
+		uuList, err := uuid.NewV4()
+		if err != nil {
+			return err
+		}
+
+		if err := createListStmt.QueryRow(uuList, listName, models.ListTypePrivate, models.ListOptinSingle, models.ListStatusActive, pq.StringArray(listTags), "", 0, nil, "", "").Scan(&s.state.ListID); err != nil {
+			return fmt.Errorf("error creating list: %v", err)
+		}
+		s.log.Printf("created list '%s' (id: %d)", listName, s.state.ListID)
 	}
 
-	s.im.sendNotif(StatusFinished)
-}
+	// Assign the current batch list to the subscriber.
+	// We overwrite any previous lists because we are strictly batching.
+	_, err = stmt.Exec(uu, sub.Email, sub.Name, sub.Attribs, pq.Array([]int{s.state.ListID}), s.opt.SubStatus, s.opt.Overwrite,
+		emailnorm.Normalize(sub.Email, s.im.opt.EmailNormalization), pq.Array(s.im.opt.TrackedAttribs))
+	if err != nil {
+		return err
+	}
+	s.state.ListSubs++
 
-// Stop stops an active import session.
-func (s *Session) Stop() {
-	close(s.subQueue)
+	return nil
 }
 
-// ExtractZIP takes a ZIP file's path and extracts all .csv files in it to
-// a temporary directory, and returns the name of the temp directory and the
-// list of extracted .csv files.
-func (s *Session) ExtractZIP(srcPath string, maxCSVs int) (string, []string, error) {
-	if s.im.isDone() {
-		return "", nil, ErrIsImporting
+// getStatus returns the status of the session.
+func (s *Session) getStatus() Status {
+	s.im.RLock()
+	defer s.im.RUnlock()
+
+	return Status{
+		ID:       s.job.ID,
+		Name:     s.job.Name,
+		Total:    s.job.Total,
+		Imported: s.imported,
+		Failed:   s.failed,
+		Status:   s.status,
 	}
+}
+
+// getLogs returns the log of the session, including the entries that are
+// yet to be persisted.
+func (s *Session) getLogs() []byte {
+	s.im.RLock()
+	defer s.im.RUnlock()
 
-	failed := true
-	defer func() {
-		if failed {
-			s.im.setStatus(StatusFailed)
+	return []byte(s.job.Log + s.logBuf.String())
+}
+
+// Stop stops the session after committing the rows processed so far. The
+// stopping status is persisted so that the job isn't resumed if the stop
+// is interrupted.
+func (s *Session) Stop() {
+	s.stopOnce.Do(func() {
+		if _, err := s.im.opt.UpdateJobStatusStmt.Exec(s.job.ID, StatusStopping, ""); err != nil {
+			s.im.log.Printf("error updating import job %d: %v", s.job.ID, err)
 		}
-	}()
 
+		s.im.Lock()
+		s.status = StatusStopping
+		s.im.Unlock()
+
+		close(s.stop)
+	})
+}
+
+// extractCSV extracts the first .csv file in the ZIP file at srcPath to dstPath.
+func extractCSV(srcPath, dstPath string, lo *log.Logger) error {
 	z, err := zip.OpenReader(srcPath)
 	if err != nil {
-		return "", nil, err
+		return err
 	}
 	defer z.Close()
 
-	// Create a temporary directory to extract the files.
-	dir, err := os.MkdirTemp("", "listmonk")
-	if err != nil {
-		s.log.Printf("error creating temporary directory for extracting ZIP: %v", err)
-		return "", nil, err
-	}
-
-	files := make([]string, 0, len(z.File))
 	for _, f := range z.File {
 		fName := f.FileInfo().Name()
 
 		// Skip directories.
 		if f.FileInfo().IsDir() {
-			s.log.Printf("skipping directory '%s'", fName)
+			lo.Printf("skipping directory '%s'", fName)
 			continue
 		}
 
 		// Skip files without the .csv extension.
 		if !strings.HasSuffix(strings.ToLower(fName), ".csv") {
-			s.log.Printf("skipping non .csv file '%s'", fName)
+			lo.Printf("skipping non .csv file '%s'", fName)
 			continue
 		}
 
-		s.log.Printf("extracting '%s'", fName)
+		lo.Printf("extracting '%s'", fName)
 		src, err := f.Open()
 		if err != nil {
-			s.log.Printf("error opening '%s' from ZIP: '%v'", fName, err)
-			return "", nil, err
+			lo.Printf("error opening '%s' from ZIP: '%v'", fName, err)
+			return err
 		}
 		defer src.Close()
 
-		out, err := os.OpenFile(dir+"/"+fName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
-		if err != nil {
-			s.log.Printf("error creating '%s/%s': '%v'", dir, fName, err)
-			return "", nil, err
-		}
-		defer out.Close()
-
-		if _, err := io.Copy(out, src); err != nil {
-			s.log.Printf("error extracting to '%s/%s': '%v'", dir, fName, err)
-			return "", nil, err
+		if err := writeFile(dstPath, src); err != nil {
+			lo.Printf("error extracting '%s': '%v'", fName, err)
+			return err
 		}
-		s.log.Printf("extracted '%s'", fName)
+		lo.Printf("extracted '%s'", fName)
 
-		files = append(files, fName)
-		if len(files) > maxCSVs {
-			s.log.Printf("won't extract any more files. Maximum is %d", maxCSVs)
-			break
-		}
-	}
-
-	if len(files) == 0 {
-		s.log.Println("no CSV files found in the ZIP")
-		return "", nil, errors.New("no CSV files found in the ZIP")
+		return nil
 	}
 
-	failed = false
-	return dir, files, nil
+	lo.Println("no CSV files found in the ZIP")
+	return errors.New("no CSV files found in the ZIP")
 }
 
-// LoadCSV loads a CSV file and validates and imports the subscriber entries in it.
-func (s *Session) LoadCSV(srcPath string, delim rune) error {
-	if s.im.isDone() {
-		return ErrIsImporting
-	}
-
-	// Default status is "failed" in case the function
-	// returns at one of the many possible errors.
-	failed := true
-	defer func() {
-		if failed {
-			s.im.setStatus(StatusFailed)
-		}
-	}()
-
-	f, err := os.Open(srcPath)
+// copyFile copies the file at srcPath to dstPath.
+func copyFile(srcPath, dstPath string) error {
+	src, err := os.Open(srcPath)
 	if err != nil {
 		return err
 	}
+	defer src.Close()
 
-	// Count the total number of lines in the file. This doesn't distinguish
-	// between "blank" and non "blank" lines, and is only used to derive
-	// the progress percentage for the frontend.
-	numLines, err := countLines(f)
-	if err != nil {
-		s.log.Printf("error counting lines in '%s': '%v'", srcPath, err)
-		return err
-	}
-
-	if numLines == 0 {
-		return errors.New("empty file")
-	}
-
-	// Exclude the header from count.
-	s.im.Lock()
-	s.im.status.Total = numLines - 1
-	s.im.Unlock()
-
-	// Rewind, now that we've done a linecount on the same handler.
-	_, _ = f.Seek(0, 0)
-	rd := csv.NewReader(f)
-	rd.Comma = delim
+	return writeFile(dstPath, src)
+}
 
-	// Read the header.
-	csvHdr, err := rd.Read()
+// writeFile writes the contents of a reader to a new file.
+func writeFile(path string, r io.Reader) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		s.log.Printf("error reading header from '%s': '%v'", srcPath, err)
 		return err
 	}
 
-	hdrKeys := s.mapCSVHeaders(csvHdr, csvHeaders)
-	// email is a required header.
-	if _, ok := hdrKeys["email"]; !ok {
-		s.log.Printf("'email' column not found in '%s'", srcPath)
-		return errors.New("'email' column not found")
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
 	}
 
-	var (
-		lnHdr = len(hdrKeys)
-		i     = 0
-	)
-	for {
-		i++
-
-		// Check for the stop signal.
-		select {
-		case <-s.im.stop:
-			failed = false
-			close(s.subQueue)
-			s.log.Println("stop request received")
-			return nil
-		default:
-		}
-
-		cols, err := rd.Read()
-		if err == io.EOF {
-			break
-		} else if err != nil {
-			if err, ok := err.(*csv.ParseError); ok && err.Err == csv.ErrFieldCount {
-				s.log.Printf("skipping line %d. %v", i, err)
-				continue
-			} else {
-				s.log.Printf("error reading CSV '%s'", err)
-				return err
-			}
-		}
-
-		lnCols := len(cols)
-		if lnCols < lnHdr {
-			s.log.Printf("skipping line %d. column count (%d) does not match minimum header count (%d)", i, lnCols, lnHdr)
-			continue
-		}
-
-		// Iterate the key map and based on the indices mapped earlier,
-		// form a map of key: csv_value, eg: email: user@user.com.
-		row := make(map[string]string, lnCols)
-		for key := range hdrKeys {
-			row[key] = cols[hdrKeys[key]]
-		}
-
-		sub := SubReq{}
-		sub.Email = row["email"]
-
-		if v, ok := row["name"]; ok {
-			sub.Name = v
-		}
+	return out.Close()
+}
 
-		sub, err = s.im.ValidateFields(sub)
-		if err != nil {
-			s.log.Printf("skipping line %d: %v: %v", i, err, cols)
-			continue
-		}
+// encodeRow encodes a CSV row as it appears in a file.
+func encodeRow(cols []string, delim rune) string {
+	var b bytes.Buffer
+	w := csv.NewWriter(&b)
+	w.Comma = delim
+	w.Write(cols)
+	w.Flush()
 
-		// JSON attributes.
-		if len(row["attributes"]) > 0 {
-			var (
-				attribs models.JSON
-				b       = []byte(row["attributes"])
-			)
-			if err := json.Unmarshal(b, &attribs); err != nil {
-				s.log.Printf("skipping invalid attributes JSON on line %d for '%s': %v", i, sub.Email, err)
-			} else {
-				sub.Attribs = attribs
-			}
-		}
+	return strings.TrimRight(b.String(), "\r\n")
+}
 
-		// Send the subscriber to the queue.
-		s.subQueue <- sub
+// jobStatus returns the Status of a job.
+func jobStatus(j models.ImportJob) Status {
+	return Status{
+		ID:       j.ID,
+		Name:     j.Name,
+		Total:    j.Total,
+		Imported: j.Imported,
+		Failed:   j.Failed,
+		Status:   j.Status,
 	}
+}
 
-	close(s.subQueue)
-	failed = false
+// newLogger returns a logger for a session's log.
+func newLogger(b *logBuffer) *log.Logger {
+	return log.New(b, "", log.Ldate|log.Ltime|log.Lmicroseconds|log.Lshortfile)
+}
 
-	return nil
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
 }
 
-// Stop sends a signal to stop the existing import.
-func (im *Importer) Stop() {
-	if im.getStatus() != StatusImporting {
-		im.Lock()
-		im.status = Status{Status: StatusNone}
-		im.Unlock()
+// String returns the whole log.
+func (b *logBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
 
-		return
-	}
+// flush returns the log that's yet to be persisted and its length, which is
+// to be passed to flushed() once it's persisted.
+func (b *logBuffer) flush() (string, int) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String(), b.buf.Len()
+}
 
-	select {
-	case im.stop <- true:
-		im.setStatus(StatusStopping)
-	default:
-	}
+// flushed discards the first n bytes of the log that have been persisted.
+func (b *logBuffer) flushed(n int) {
+	b.Lock()
+	defer b.Unlock()
+	b.buf.Next(n)
 }
 
 // SanitizeEmail validates and sanitizes an e-mail string and returns the lowercased,
@@ -677,11 +1018,11 @@ func (im *Importer) SanitizeEmail(email string) (string, error) {
 		// If there's an allowlist, check if the domain is in it. Checking blocklist after that is moot.
 		if im.hasAllowlist {
 			if !im.checkInList(domain, im.hasAllowlistWildcards, im.domainAllowlist) {
-				return "", errors.New(im.i18n.T("subscribers.domainBlocklisted"))
+				return "", errDomainBlocked(im.i18n.T("subscribers.domainBlocklisted"))
 			}
 		} else if im.hasBlocklist {
 			if im.checkInList(domain, im.hasBlocklistWildcards, im.domainBlocklist) {
-				return "", errors.New(im.i18n.T("subscribers.domainBlocklisted"))
+				return "", errDomainBlocked(im.i18n.T("subscribers.domainBlocklisted"))
 			}
 		}
 	}
@@ -689,6 +1030,21 @@ func (im *Importer) SanitizeEmail(email string) (string, error) {
 	return em.Address, nil
 }
 
+// errDomainBlocked is returned by SanitizeEmail when the e-mail's domain is
+// blocklisted or isn't allowlisted.
+type errDomainBlocked string
+
+func (e errDomainBlocked) Error() string {
+	return string(e)
+}
+
+// IsDomainBlocked checks whether an error returned by SanitizeEmail is due to
+// the e-mail's domain not being allowed.
+func IsDomainBlocked(err error) bool {
+	_, ok := err.(errDomainBlocked)
+	return ok
+}
+
 // ValidateFields validates incoming subscriber field values and returns sanitized fields.
 func (im *Importer) ValidateFields(s SubReq) (SubReq, error) {
 	if len(s.Email) > 1000 {
@@ -761,6 +1117,17 @@ func (s *Session) mapCSVHeaders(csvHdrs []string, knownHdrs map[string]bool) map
 	return hdrKeys
 }
 
+// countFileLines counts the number of lines in the file at path.
+func countFileLines(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	return countLines(f)
+}
+
 // countLines counts the number of line breaks in a file. This does not
 // distinguish between "blank" and non "blank" lines.
 // Credit: https://stackoverflow.com/a/24563853
@@ -0,0 +1,250 @@
+package subimporter
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/knadh/listmonk/internal/emailverify"
+	"github.com/knadh/listmonk/internal/i18n"
+	"github.com/knadh/listmonk/internal/testdb"
+	"github.com/knadh/listmonk/models"
+	"github.com/lib/pq"
+)
+
+// newTestImporter returns an Importer on a test database. It skips the test if
+// there's no test database.
+func newTestImporter(t *testing.T) (*Importer, *testdb.DB) {
+	t.Helper()
+
+	db := testdb.New(t)
+
+	b, err := os.ReadFile("../../i18n/en.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := i18n.New(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q := db.Q
+	return New(Options{
+		UpsertStmt:         q.UpsertSubscriber.Stmt,
+		BlocklistStmt:      q.UpsertBlocklistSubscriber.Stmt,
+		UpdateListDateStmt: q.UpdateListsDate.Stmt,
+		CreateListStmt:     q.CreateList.Stmt,
+		Dir:                t.TempDir(),
+
+		CreateJobStmt:         q.CreateImportJob,
+		NextJobStmt:           q.NextImportJob,
+		GetJobStmt:            q.GetImportJob,
+		CountQueuedJobsStmt:   q.CountQueuedImportJobs,
+		UpdateJobProgressStmt: q.UpdateImportJobProgress,
+		UpdateJobStatusStmt:   q.UpdateImportJobStatus,
+		StopQueuedJobStmt:     q.StopQueuedImportJob,
+		InsertJobErrorStmt:    q.InsertImportJobError,
+
+		PostCB: func(string, any) error { return nil },
+	}, db.DB.DB, i, log.New(io.Discard, "", 0)), db
+}
+
+// queueCSV queues a job to import the given CSV.
+func queueCSV(t *testing.T, im *Importer, name, body string) models.ImportJob {
+	t.Helper()
+
+	src := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(src, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := im.Queue(SessionOpt{Filename: name, Mode: ModeSubscribe, SubStatus: models.SubscriptionStatusUnconfirmed}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return getJob(t, im, st.ID)
+}
+
+func getJob(t *testing.T, im *Importer, id int) models.ImportJob {
+	t.Helper()
+
+	var job models.ImportJob
+	if err := im.opt.GetJobStmt.Get(&job, id); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestImportCrashRecovery(t *testing.T) {
+	im, db := newTestImporter(t)
+
+	first := queueCSV(t, im, "first.csv", "email,name\nf1@example.com,f1\nf2@example.com,f2\n")
+	crashed := queueCSV(t, im, "crashed.csv",
+		"email,name\nc1@example.com,c1\nc2@example.com,c2\nc3@example.com,c3\ninvalid,c4\nc5@example.com,c5\n")
+	stopping := queueCSV(t, im, "stopping.csv", "email,name\ns1@example.com,s1\n")
+
+	if crashed.Status != StatusQueued || crashed.Total != 5 {
+		t.Fatalf("unexpected queued job %+v", crashed)
+	}
+	if _, err := os.Stat(crashed.FilePath); err != nil {
+		t.Fatalf("expected the file to be kept in the import dir: %v", err)
+	}
+
+	// Simulate a crash after the first two rows of a job were committed to the
+	// list created for them, and a crash while a job was being stopped.
+	var listID int
+	if err := db.Get(&listID, `INSERT INTO lists (uuid, name, type) VALUES (gen_random_uuid(), 'crashed-batch-1', 'private') RETURNING id`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE import_jobs SET status = 'importing', imported = 2, line = 2,
+		state = JSONB_BUILD_OBJECT('list_id', $2::INT, 'list_num', 1, 'list_subs', 2) WHERE id = $1`, crashed.ID, listID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE import_jobs SET status = 'stopping' WHERE id = $1`, stopping.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	emails := func() []string {
+		var out []string
+		if err := db.Select(&out, `SELECT email FROM subscribers ORDER BY email`); err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	// The interrupted job is resumed first, after its last committed row, and
+	// its rows are added to the list that it was adding them to.
+	if !im.runNext() {
+		t.Fatal("expected a job to run")
+	}
+	job := getJob(t, im, crashed.ID)
+	if job.Status != StatusFinished || job.Imported != 4 || job.Failed != 1 || job.Line != 5 {
+		t.Fatalf("unexpected resumed job %+v", job)
+	}
+	if e := emails(); len(e) != 2 || e[0] != "c3@example.com" || e[1] != "c5@example.com" {
+		t.Fatalf("expected only the uncommitted rows to be imported, got %v", e)
+	}
+	var n int
+	if err := db.Get(&n, `SELECT COUNT(*) FROM subscriber_lists WHERE list_id = $1`, listID); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected the resumed rows in the job's list, got %d", n)
+	}
+	var errLines []int64
+	if err := db.Get(pq.Array(&errLines), `SELECT ARRAY_AGG(line ORDER BY line) FROM import_job_errors WHERE job_id = $1`, crashed.ID); err != nil {
+		t.Fatal(err)
+	}
+	if len(errLines) != 1 || errLines[0] != 4 {
+		t.Errorf("expected the invalid row to be recorded, got lines %v", errLines)
+	}
+	if _, err := os.Stat(crashed.FilePath); !os.IsNotExist(err) {
+		t.Errorf("expected the file to be removed, got %v", err)
+	}
+
+	// The job that was being stopped is stopped without importing anything.
+	if !im.runNext() {
+		t.Fatal("expected a job to run")
+	}
+	if job := getJob(t, im, stopping.ID); job.Status != StatusStopped || job.Imported != 0 {
+		t.Fatalf("unexpected stopped job %+v", job)
+	}
+
+	// Then the queued jobs are run.
+	if !im.runNext() {
+		t.Fatal("expected a job to run")
+	}
+	if job := getJob(t, im, first.ID); job.Status != StatusFinished || job.Imported != 2 || job.Line != 2 {
+		t.Fatalf("unexpected job %+v", job)
+	}
+	if e := emails(); len(e) != 4 {
+		t.Fatalf("expected 4 subscribers, got %v", e)
+	}
+
+	if im.runNext() {
+		t.Fatal("expected no more jobs")
+	}
+	if st := im.GetStats(); st.ID != first.ID || st.Status != StatusFinished || st.Queued != 0 {
+		t.Errorf("unexpected stats %+v", st)
+	}
+}
+
+func TestImportStopQueued(t *testing.T) {
+	im, _ := newTestImporter(t)
+
+	job := queueCSV(t, im, "queued.csv", "email,name\nq1@example.com,q1\n")
+	if st := im.GetStats(); st.Queued != 1 {
+		t.Fatalf("expected 1 queued job, got %+v", st)
+	}
+
+	if err := im.StopJob(job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if job := getJob(t, im, job.ID); job.Status != StatusStopped {
+		t.Fatalf("expected the job to be stopped, got %s", job.Status)
+	}
+	if _, err := os.Stat(job.FilePath); !os.IsNotExist(err) {
+		t.Errorf("expected the file to be removed, got %v", err)
+	}
+
+	// Ended jobs can't be stopped, and there's nothing left to run.
+	if err := im.StopJob(job.ID); err != ErrNotStoppable {
+		t.Errorf("expected ErrNotStoppable, got %v", err)
+	}
+	if im.runNext() {
+		t.Error("expected no jobs to run")
+	}
+}
+
+func TestMakeSubVerify(t *testing.T) {
+	b, err := os.ReadFile("../../i18n/en.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := i18n.New(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := emailverify.New(emailverify.Opt{Syntax: true, Typos: true})
+	im := New(Options{Verifier: v}, nil, i, log.New(io.Discard, "", 0))
+
+	hdr := map[string]int{"email": 0, "name": 1, "attributes": 2}
+	newSub := func(verify bool, cols ...string) SubReq {
+		t.Helper()
+		s := &Session{im: im, opt: SessionOpt{VerifyEmails: verify}, log: log.New(io.Discard, "", 0)}
+		sub, err := s.makeSub(cols, nil, hdr, len(hdr), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sub
+	}
+
+	// Failing rows are imported and marked with the reason.
+	sub := newSub(true, "user@gamil.com", "User", `{"city": "Berlin"}`)
+	if sub.Email != "user@gamil.com" || sub.Attribs["city"] != "Berlin" {
+		t.Errorf("unexpected subscriber %+v", sub)
+	}
+	exp := map[string]any{"valid": false, "reason": emailverify.ReasonTypo, "suggestion": "user@gmail.com"}
+	if got, _ := sub.Attribs["email_verification"].(map[string]any); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, sub.Attribs["email_verification"])
+	}
+
+	sub = newSub(true, "test@test", "", "")
+	exp = map[string]any{"valid": false, "reason": emailverify.ReasonSyntax}
+	if got, _ := sub.Attribs["email_verification"].(map[string]any); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected %v, got %v", exp, sub.Attribs["email_verification"])
+	}
+
+	// Passing rows and sessions without verification aren't marked.
+	if sub := newSub(true, "user@gmail.com", "", ""); sub.Attribs != nil {
+		t.Errorf("expected no attributes, got %v", sub.Attribs)
+	}
+	if sub := newSub(false, "user@gamil.com", "", ""); sub.Attribs != nil {
+		t.Errorf("expected no attributes without verification, got %v", sub.Attribs)
+	}
+}
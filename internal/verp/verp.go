@@ -0,0 +1,180 @@
+// Package verp encodes campaign and subscriber UUIDs into signed VERP
+// (Variable Envelope Return Path) addresses, eg: bounce+<data>@bounces.example.com,
+// that are used as the SMTP envelope sender of campaign messages, and decodes
+// them from the recipient addresses of the bounces that come back.
+package verp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+)
+
+const (
+	// SchemeCompact packs both UUIDs and the signature into a single base32
+	// token that fits in the 64 character local part limit (RFC 5321).
+	SchemeCompact = "compact"
+
+	// SchemeUUID uses the readable UUIDs separated by +. The local part
+	// exceeds the RFC 5321 limit and may be rejected by some servers.
+	SchemeUUID = "uuid"
+
+	maxLocalLen = 64
+
+	// Signature lengths in bytes. The compact one is short to leave room for
+	// the prefix. Forging it still requires a subscriber's secret UUID.
+	compactSigLen = 3
+	uuidSigLen    = 8
+)
+
+// Length of the base32 token in the compact scheme: two UUIDs and the signature.
+var compactLen = enc.EncodedLen(16 + 16 + compactSigLen)
+
+// enc is lowercase base32 without padding as the local part of an address
+// may be lowercased on the way back.
+var enc = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// Opt represents the VERP options.
+type Opt struct {
+	// Domain is the domain of the envelope sender, eg: bounces.example.com.
+	Domain string `json:"domain"`
+
+	// Prefix is the local part before the encoded data, eg: bounce.
+	Prefix string `json:"prefix"`
+
+	// Scheme is one of the Scheme* constants.
+	Scheme string `json:"scheme"`
+
+	// Secret is the HMAC key for signing addresses.
+	Secret string `json:"secret"`
+}
+
+// VERP encodes and decodes VERP addresses.
+type VERP struct {
+	opt Opt
+}
+
+// New validates the options and returns a new VERP instance.
+func New(o Opt) (*VERP, error) {
+	o.Domain = strings.ToLower(strings.TrimSpace(o.Domain))
+	o.Prefix = strings.ToLower(strings.TrimSpace(o.Prefix))
+
+	if o.Domain == "" || strings.ContainsAny(o.Domain, "@ ") {
+		return nil, errors.New("invalid domain")
+	}
+	if o.Prefix == "" || strings.ContainsAny(o.Prefix, "+@ ") {
+		return nil, errors.New("invalid prefix")
+	}
+	if o.Secret == "" {
+		return nil, errors.New("secret is empty")
+	}
+
+	switch o.Scheme {
+	case SchemeCompact:
+		if n := len(o.Prefix) + 1 + compactLen; n > maxLocalLen {
+			return nil, fmt.Errorf("prefix is too long. The address local part (%d) exceeds %d characters", n, maxLocalLen)
+		}
+	case SchemeUUID:
+	default:
+		return nil, fmt.Errorf("unknown scheme '%s'", o.Scheme)
+	}
+
+	return &VERP{opt: o}, nil
+}
+
+// Encode returns the signed VERP address for a campaign and subscriber.
+func (v *VERP) Encode(campUUID, subUUID string) (string, error) {
+	c, err := uuid.FromString(campUUID)
+	if err != nil {
+		return "", fmt.Errorf("invalid campaign UUID: %v", err)
+	}
+	s, err := uuid.FromString(subUUID)
+	if err != nil {
+		return "", fmt.Errorf("invalid subscriber UUID: %v", err)
+	}
+
+	sig := v.sign(c, s)
+
+	var data string
+	switch v.opt.Scheme {
+	case SchemeUUID:
+		data = c.String() + "+" + s.String() + "+" + hex.EncodeToString(sig[:uuidSigLen])
+	default:
+		b := make([]byte, 0, 16+16+compactSigLen)
+		b = append(b, c.Bytes()...)
+		b = append(b, s.Bytes()...)
+		b = append(b, sig[:compactSigLen]...)
+		data = enc.EncodeToString(b)
+	}
+
+	return v.opt.Prefix + "+" + data + "@" + v.opt.Domain, nil
+}
+
+// Decode returns the campaign and subscriber UUIDs from a VERP address,
+// eg: the recipient of a bounce. The address can be in either scheme
+// so that bounces of messages sent before a scheme change are attributed.
+// ok is false if the address isn't a VERP address or its signature is invalid.
+func (v *VERP) Decode(addr string) (campUUID, subUUID string, ok bool) {
+	addr = strings.ToLower(strings.TrimSpace(addr))
+
+	// Name <address>.
+	if i := strings.LastIndexByte(addr, '<'); i >= 0 {
+		addr = strings.TrimSuffix(addr[i+1:], ">")
+	}
+
+	i := strings.LastIndexByte(addr, '@')
+	if i < 0 || addr[i+1:] != v.opt.Domain {
+		return "", "", false
+	}
+
+	data, found := strings.CutPrefix(addr[:i], v.opt.Prefix+"+")
+	if !found {
+		return "", "", false
+	}
+
+	var (
+		c, s uuid.UUID
+		sig  []byte
+		err  error
+	)
+	if p := strings.Split(data, "+"); len(p) == 3 {
+		// UUID scheme.
+		if c, err = uuid.FromString(p[0]); err != nil {
+			return "", "", false
+		}
+		if s, err = uuid.FromString(p[1]); err != nil {
+			return "", "", false
+		}
+		if sig, err = hex.DecodeString(p[2]); err != nil || len(sig) != uuidSigLen {
+			return "", "", false
+		}
+	} else {
+		// Compact scheme.
+		b, err := enc.DecodeString(data)
+		if err != nil || len(b) != 16+16+compactSigLen {
+			return "", "", false
+		}
+		c = uuid.FromBytesOrNil(b[:16])
+		s = uuid.FromBytesOrNil(b[16:32])
+		sig = b[32:]
+	}
+
+	if !hmac.Equal(sig, v.sign(c, s)[:len(sig)]) {
+		return "", "", false
+	}
+
+	return c.String(), s.String(), true
+}
+
+func (v *VERP) sign(c, s uuid.UUID) []byte {
+	h := hmac.New(sha256.New, []byte(v.opt.Secret))
+	h.Write(c.Bytes())
+	h.Write(s.Bytes())
+	return h.Sum(nil)
+}
@@ -0,0 +1,140 @@
+package verp
+
+import (
+	"strings"
+	"testing"
+)
+
+const (
+	testCampUUID = "2d3e6a52-5b4a-4a4f-9d2e-6f1f7d4b8c11"
+	testSubUUID  = "7c1e0b7e-3f38-4b47-9f6a-0b1e2d3c4f5a"
+)
+
+func newTestVERP(t *testing.T, scheme, secret string) *VERP {
+	t.Helper()
+
+	v, err := New(Opt{Domain: "Bounces.Example.com", Prefix: "bounce", Scheme: scheme, Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestNew(t *testing.T) {
+	cases := []struct {
+		name string
+		opt  Opt
+		ok   bool
+	}{
+		{"compact", Opt{Domain: "bounces.example.com", Prefix: "bounce", Scheme: SchemeCompact, Secret: "s"}, true},
+		{"uuid", Opt{Domain: "bounces.example.com", Prefix: "bounce", Scheme: SchemeUUID, Secret: "s"}, true},
+		{"no domain", Opt{Prefix: "bounce", Scheme: SchemeCompact, Secret: "s"}, false},
+		{"invalid domain", Opt{Domain: "a@example.com", Prefix: "bounce", Scheme: SchemeCompact, Secret: "s"}, false},
+		{"no prefix", Opt{Domain: "bounces.example.com", Scheme: SchemeCompact, Secret: "s"}, false},
+		{"invalid prefix", Opt{Domain: "bounces.example.com", Prefix: "bounce+x", Scheme: SchemeCompact, Secret: "s"}, false},
+		{"no secret", Opt{Domain: "bounces.example.com", Prefix: "bounce", Scheme: SchemeCompact}, false},
+		{"unknown scheme", Opt{Domain: "bounces.example.com", Prefix: "bounce", Scheme: "nope", Secret: "s"}, false},
+		{"long prefix", Opt{Domain: "bounces.example.com", Prefix: strings.Repeat("b", 8), Scheme: SchemeCompact, Secret: "s"}, false},
+		{"long prefix in the uuid scheme", Opt{Domain: "bounces.example.com", Prefix: strings.Repeat("b", 8), Scheme: SchemeUUID, Secret: "s"}, true},
+	}
+	for _, c := range cases {
+		if _, err := New(c.opt); (err == nil) != c.ok {
+			t.Errorf("%s: expected ok=%v, got %v", c.name, c.ok, err)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	for _, scheme := range []string{SchemeCompact, SchemeUUID} {
+		v := newTestVERP(t, scheme, "secret")
+
+		addr, err := v.Encode(testCampUUID, testSubUUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(addr, "bounce+") || !strings.HasSuffix(addr, "@bounces.example.com") {
+			t.Errorf("%s: unexpected address %s", scheme, addr)
+		}
+
+		// The compact addresses fit in the local part limit.
+		local, _, _ := strings.Cut(addr, "@")
+		if scheme == SchemeCompact && len(local) > maxLocalLen {
+			t.Errorf("%s: expected the local part to be at most %d characters, got %d", scheme, maxLocalLen, len(local))
+		}
+
+		// Addresses survive being uppercased and wrapped on the way back.
+		for _, in := range []string{addr, strings.ToUpper(addr), "Mailer <" + addr + ">", " " + addr + " "} {
+			c, s, ok := v.Decode(in)
+			if !ok || c != testCampUUID || s != testSubUUID {
+				t.Errorf("%s: %s: expected the UUIDs, got %s %s %v", scheme, in, c, s, ok)
+			}
+		}
+
+		// Invalid UUIDs aren't encoded.
+		if _, err := v.Encode("camp", testSubUUID); err == nil {
+			t.Errorf("%s: expected an invalid campaign UUID to fail", scheme)
+		}
+		if _, err := v.Encode(testCampUUID, "sub"); err == nil {
+			t.Errorf("%s: expected an invalid subscriber UUID to fail", scheme)
+		}
+	}
+
+	// Addresses in either scheme are decoded after a scheme change.
+	addr, _ := newTestVERP(t, SchemeUUID, "secret").Encode(testCampUUID, testSubUUID)
+	if c, s, ok := newTestVERP(t, SchemeCompact, "secret").Decode(addr); !ok || c != testCampUUID || s != testSubUUID {
+		t.Errorf("expected a uuid scheme address to be decoded, got %s %s %v", c, s, ok)
+	}
+}
+
+// TestDecodeForged checks that addresses that aren't signed with the secret
+// aren't decoded.
+func TestDecodeForged(t *testing.T) {
+	for _, scheme := range []string{SchemeCompact, SchemeUUID} {
+		v := newTestVERP(t, scheme, "secret")
+
+		addr, err := v.Encode(testCampUUID, testSubUUID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		local, domain, _ := strings.Cut(addr, "@")
+
+		// Another subscriber with the campaign's signature.
+		const otherUUID = "4f9f6a44-0b1b-4a4e-8f4f-0d7a7c6f5e11"
+		other, _ := v.Encode(testCampUUID, otherUUID)
+		otherLocal, _, _ := strings.Cut(other, "@")
+
+		forged := []string{
+			// Another secret.
+			func() string { a, _ := newTestVERP(t, scheme, "other").Encode(testCampUUID, testSubUUID); return a }(),
+			// A flipped character in the data.
+			flip(local, len("bounce+")+2) + "@" + domain,
+			// A flipped character in the signature.
+			flip(local, len(local)-1) + "@" + domain,
+			// The data of one address with the signature of another.
+			local[:len(local)-4] + otherLocal[len(otherLocal)-4:] + "@" + domain,
+			// Truncated.
+			local[:len(local)-2] + "@" + domain,
+			// Another domain or prefix.
+			local + "@example.com",
+			strings.Replace(local, "bounce+", "return+", 1) + "@" + domain,
+			// Not VERP addresses.
+			"bounce@" + domain,
+			"news@example.com",
+			"",
+		}
+		for _, a := range forged {
+			if c, s, ok := v.Decode(a); ok {
+				t.Errorf("%s: %s: expected the address to be rejected, got %s %s", scheme, a, c, s)
+			}
+		}
+	}
+}
+
+// flip changes the character at i to another valid character of the encodings.
+func flip(s string, i int) string {
+	c := byte('a')
+	if s[i] == 'a' {
+		c = 'b'
+	}
+	return s[:i] + string(c) + s[i+1:]
+}
@@ -0,0 +1,228 @@
+package webhooks
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testReq struct {
+	header http.Header
+	body   []byte
+}
+
+// testServer records the requests it receives and responds with the statuses
+// in order, and with 200 after they run out.
+type testServer struct {
+	*httptest.Server
+
+	mut      sync.Mutex
+	reqs     []testReq
+	statuses []int
+}
+
+func newTestServer(t *testing.T, statuses ...int) *testServer {
+	t.Helper()
+
+	s := &testServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+
+		s.mut.Lock()
+		s.reqs = append(s.reqs, testReq{header: r.Header.Clone(), body: b})
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status, s.statuses = s.statuses[0], s.statuses[1:]
+		}
+		s.mut.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *testServer) getReqs() []testReq {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]testReq{}, s.reqs...)
+}
+
+func waitFor(t *testing.T, fn func() bool) {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(5 * time.Millisecond) {
+		if fn() {
+			return
+		}
+	}
+	t.Fatal("timed out")
+}
+
+func TestDeliver(t *testing.T) {
+	var (
+		signed = newTestServer(t)
+		all    = newTestServer(t)
+	)
+	w := New([]Hook{
+		{Name: "signed", URL: signed.URL, Secret: "secret", Events: []string{"subscriber.created"}},
+		{Name: "all", URL: all.URL},
+	}, Opt{Concurrency: 2}, log.New(io.Discard, "", 0))
+	go w.Run()
+	defer w.Close()
+
+	w.Emit("subscriber.created", map[string]int{"id": 1})
+	w.Emit("campaign.finished", map[string]int{"id": 2})
+
+	waitFor(t, func() bool { return len(signed.getReqs()) == 1 && len(all.getReqs()) == 2 })
+	time.Sleep(20 * time.Millisecond)
+
+	// Only the subscribed events are delivered to a hook with events.
+	reqs := signed.getReqs()
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(reqs))
+	}
+	r := reqs[0]
+
+	var ev struct {
+		Event string         `json:"event"`
+		Data  map[string]int `json:"data"`
+	}
+	if err := json.Unmarshal(r.body, &ev); err != nil {
+		t.Fatal(err)
+	}
+	if ev.Event != "subscriber.created" || ev.Data["id"] != 1 || r.header.Get("X-Listmonk-Event") != "subscriber.created" {
+		t.Fatalf("unexpected event %+v", ev)
+	}
+	if r.header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected content type %s", r.header.Get("Content-Type"))
+	}
+
+	// The signature covers the timestamp and the body.
+	ts := r.header.Get("X-Listmonk-Timestamp")
+	n, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || time.Since(time.Unix(n, 0)) > time.Minute {
+		t.Fatalf("unexpected timestamp %q", ts)
+	}
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(ts + "."))
+	mac.Write(r.body)
+	if sig := r.header.Get("X-Listmonk-Signature"); sig != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("unexpected signature %s", sig)
+	}
+	if sign("secret", strconv.FormatInt(n+1, 10), r.body) == strings.TrimPrefix(r.header.Get("X-Listmonk-Signature"), "sha256=") {
+		t.Error("expected the signature to change with the timestamp")
+	}
+
+	// A hook without events receives all of them, unsigned without a secret.
+	reqs = all.getReqs()
+	if len(reqs) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(reqs))
+	}
+	for _, r := range reqs {
+		if r.header.Get("X-Listmonk-Signature") != "" || r.header.Get("X-Listmonk-Timestamp") != "" {
+			t.Errorf("unexpected signature headers %v", r.header)
+		}
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	var (
+		flaky = newTestServer(t, http.StatusInternalServerError, http.StatusBadGateway)
+		down  = newTestServer(t, http.StatusInternalServerError, http.StatusInternalServerError, http.StatusInternalServerError)
+		logs  bytes.Buffer
+		logMu sync.Mutex
+	)
+	w := New([]Hook{{URL: flaky.URL}, {URL: down.URL}}, Opt{MaxRetries: 2, RetryInterval: time.Millisecond, Concurrency: 2},
+		log.New(&syncWriter{w: &logs, mut: &logMu}, "", 0))
+	go w.Run()
+	defer w.Close()
+
+	w.Emit("campaign.finished", nil)
+
+	// Failed deliveries are retried up to MaxRetries times.
+	waitFor(t, func() bool {
+		logMu.Lock()
+		defer logMu.Unlock()
+		return len(flaky.getReqs()) == 3 && strings.Contains(logs.String(), "error delivering")
+	})
+	if n := len(down.getReqs()); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+
+	// Successful deliveries aren't retried.
+	time.Sleep(20 * time.Millisecond)
+	if n := len(flaky.getReqs()); n != 3 {
+		t.Fatalf("expected 3 attempts, got %d", n)
+	}
+	logMu.Lock()
+	defer logMu.Unlock()
+	if strings.Count(logs.String(), "error delivering") != 1 || !strings.Contains(logs.String(), down.URL) {
+		t.Errorf("expected only the failed delivery to be logged, got %q", logs.String())
+	}
+}
+
+func TestEmitQueueFull(t *testing.T) {
+	var logs bytes.Buffer
+	w := New([]Hook{{URL: "http://127.0.0.1:1"}}, Opt{QueueSize: 2}, log.New(&logs, "", 0))
+
+	// Without workers, the events beyond the queue size are dropped without blocking.
+	for range 5 {
+		w.Emit("subscriber.created", nil)
+	}
+	if n := len(w.q); n != 2 {
+		t.Fatalf("expected 2 queued events, got %d", n)
+	}
+	if n := strings.Count(logs.String(), "dropping event"); n != 3 {
+		t.Fatalf("expected 3 dropped events, got %d: %q", n, logs.String())
+	}
+}
+
+func TestEmitAfterClose(t *testing.T) {
+	w := New([]Hook{{URL: "http://127.0.0.1:1"}}, Opt{Concurrency: 2}, log.New(io.Discard, "", 0))
+
+	done := make(chan struct{})
+	go func() {
+		w.Run()
+		close(done)
+	}()
+
+	w.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Run to return after Close")
+	}
+
+	// Events after Close are dropped instead of panicking, and Close is idempotent.
+	w.Emit("subscriber.created", nil)
+	w.Close()
+
+	// A nil instance is a no-op.
+	var nw *Webhooks
+	nw.Emit("subscriber.created", nil)
+}
+
+// syncWriter serializes writes to a buffer that's read concurrently.
+type syncWriter struct {
+	w   io.Writer
+	mut *sync.Mutex
+}
+
+func (s *syncWriter) Write(b []byte) (int, error) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.w.Write(b)
+}
@@ -9,8 +9,28 @@ const (
 	BounceTypeHard      = "hard"
 	BounceTypeSoft      = "soft"
 	BounceTypeComplaint = "complaint"
+
+	BounceActionNone        = "none"
+	BounceActionUnsubscribe = "unsubscribe"
+	BounceActionBlocklist   = "blocklist"
+	BounceActionDelete      = "delete"
 )
 
+// BounceAction represents the action that's taken on a subscriber once the
+// number of their bounces of a type reaches Count.
+type BounceAction struct {
+	Count  int    `json:"count"`
+	Action string `json:"action"`
+}
+
+// BounceCounts represents the number of bounces of a campaign by type.
+type BounceCounts struct {
+	Hard      int `db:"hard" json:"hard"`
+	Soft      int `db:"soft" json:"soft"`
+	Complaint int `db:"complaint" json:"complaint"`
+	Total     int `db:"total" json:"total"`
+}
+
 // Bounce represents a single bounce event.
 type Bounce struct {
 	ID        int             `db:"id" json:"id"`
@@ -28,6 +48,11 @@ type Bounce struct {
 	CampaignUUID string           `db:"campaign_uuid" json:"campaign_uuid,omitempty"`
 	Campaign     *json.RawMessage `db:"campaign" json:"campaign"`
 
+	// Recipients of an incoming bounce message, ie: the envelope senders of the
+	// original message, for decoding VERP addresses. Not exposed to the API so that
+	// they can't be posted to the native webhook.
+	Recipients []string `db:"-" json:"-"`
+
 	// Pseudofield for getting the total number of bounces
 	// in searches and queries.
 	Total int `db:"total" json:"-"`
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	BundleVersion = 1
+
+	BundleTypeLists     = "lists"
+	BundleTypeTemplates = "templates"
+	BundleTypeCampaigns = "campaigns"
+	BundleTypeSettings  = "settings"
+
+	BundleActionCreated = "created"
+	BundleActionUpdated = "updated"
+	BundleActionSkipped = "skipped"
+)
+
+// Bundle is a portable export of an instance's content for importing into
+// another instance. Entities reference each other by UUIDs instead of IDs.
+// Subscribers aren't a part of bundles.
+type Bundle struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+
+	Lists     []BundleList     `json:"lists,omitempty"`
+	Templates []BundleTemplate `json:"templates,omitempty"`
+	Campaigns []BundleCampaign `json:"campaigns,omitempty"`
+
+	// Settings, key => value, without the ones with secrets.
+	Settings map[string]json.RawMessage `json:"settings,omitempty"`
+}
+
+// BundleList represents a list in a bundle.
+type BundleList struct {
+	UUID                      string         `db:"uuid" json:"uuid"`
+	Name                      string         `db:"name" json:"name"`
+	Type                      string         `db:"type" json:"type"`
+	Optin                     string         `db:"optin" json:"optin"`
+	Status                    string         `db:"status" json:"status"`
+	Tags                      pq.StringArray `db:"tags" json:"tags"`
+	Description               string         `db:"description" json:"description"`
+	PurgeUnconfirmedAfterDays int            `db:"purge_unconfirmed_after_days" json:"purge_unconfirmed_after_days"`
+	SunsetInactiveDays        null.Int       `db:"sunset_inactive_days" json:"sunset_inactive_days"`
+	PublicDescription         string         `db:"public_description" json:"public_description"`
+	Frequency                 string         `db:"frequency" json:"frequency"`
+}
+
+// BundleTemplate represents a template in a bundle.
+type BundleTemplate struct {
+	UUID       string         `db:"uuid" json:"uuid"`
+	Name       string         `db:"name" json:"name"`
+	Type       string         `db:"type" json:"type"`
+	Subject    string         `db:"subject" json:"subject"`
+	Body       string         `db:"body" json:"body"`
+	BodySource null.String    `db:"body_source" json:"body_source"`
+	Tags       pq.StringArray `db:"tags" json:"tags"`
+
+	BlockStyles BlockStyles `db:"block_styles" json:"block_styles"`
+}
+
+// BundleCampaign represents a campaign in a bundle. Campaigns are always
+// imported as drafts.
+type BundleCampaign struct {
+	UUID                string          `db:"uuid" json:"uuid"`
+	Type                string          `db:"type" json:"type"`
+	Name                string          `db:"name" json:"name"`
+	Subject             string          `db:"subject" json:"subject"`
+	PreviewText         string          `db:"preview_text" json:"preview_text"`
+	FromEmail           string          `db:"from_email" json:"from_email"`
+	Body                string          `db:"body" json:"body"`
+	BodySource          null.String     `db:"body_source" json:"body_source"`
+	AltBody             null.String     `db:"altbody" json:"altbody"`
+	ContentType         string          `db:"content_type" json:"content_type"`
+	Tags                pq.StringArray  `db:"tags" json:"tags"`
+	Headers             Headers         `db:"headers" json:"headers"`
+	Messenger           string          `db:"messenger" json:"messenger"`
+	TrackingMode        string          `db:"tracking_mode" json:"tracking_mode"`
+	UTM                 CampaignUTM     `db:"utm" json:"utm"`
+	Archive             bool            `db:"archive" json:"archive"`
+	ArchiveSlug         null.String     `db:"archive_slug" json:"archive_slug"`
+	ArchiveMeta         json.RawMessage `db:"archive_meta" json:"archive_meta"`
+	TemplateUUID        null.String     `db:"template_uuid" json:"template_uuid"`
+	ArchiveTemplateUUID null.String     `db:"archive_template_uuid" json:"archive_template_uuid"`
+	ListUUIDs           pq.StringArray  `db:"list_uuids" json:"list_uuids"`
+}
+
+// BundleResult is the result of importing a single bundle entity.
+type BundleResult struct {
+	Type   string `json:"type"`
+	UUID   string `json:"uuid"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Reason string `json:"reason,omitempty"`
+}
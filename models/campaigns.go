@@ -2,15 +2,21 @@ package models
 
 import (
 	"bytes"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	"math"
+	"net/url"
+	"regexp"
 	"strings"
 	txttpl "text/template"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/types"
+	"github.com/knadh/listmonk/internal/blocks"
 	"github.com/lib/pq"
 	null "gopkg.in/volatiletech/null.v6"
 )
@@ -24,11 +30,49 @@ const (
 	CampaignStatusCancelled     = "cancelled"
 	CampaignTypeRegular         = "regular"
 	CampaignTypeOptin           = "optin"
+	CampaignTypeAdhoc           = "adhoc"
 	CampaignContentTypeRichtext = "richtext"
 	CampaignContentTypeHTML     = "html"
 	CampaignContentTypeMarkdown = "markdown"
 	CampaignContentTypePlain    = "plain"
 	CampaignContentTypeVisual   = "visual"
+	CampaignContentTypeBlocks   = "blocks"
+
+	// Campaigns awaiting approval to be scheduled or started
+	// when campaign approvals are required.
+	CampaignStatusPendingApproval = "pending_approval"
+
+	// Tracking modes. full tracks views and clicks, clicks_only tracks
+	// only link clicks, and none tracks neither.
+	CampaignTrackingModeFull       = "full"
+	CampaignTrackingModeClicksOnly = "clicks_only"
+	CampaignTrackingModeNone       = "none"
+
+	// Archive access. public campaigns are listed in the archive, unlisted
+	// ones are only accessible via signed URLs, and password ones need a password.
+	CampaignArchiveAccessPublic   = "public"
+	CampaignArchiveAccessUnlisted = "unlisted"
+	CampaignArchiveAccessPassword = "password"
+
+	// Actions on a failed dynamic attachment fetch. skip doesn't send the
+	// message to the subscriber and send sends it without the attachment.
+	AttachmentOnErrorSkip = "skip"
+	AttachmentOnErrorSend = "send"
+
+	// Seed list sends. Seed messages are sent to ephemeral subscribers with the
+	// nil UUID so that views and clicks on them aren't recorded.
+	SeedStatusSent     = "sent"
+	SeedStatusFailed   = "failed"
+	SeedHeader         = "X-Listmonk-Seed"
+	SeedSubscriberUUID = "00000000-0000-0000-0000-000000000000"
+)
+
+var (
+	regexpAnchorTag = regexp.MustCompile(`(?is)<a\s[^>]*>`)
+	regexpHref      = regexp.MustCompile(`(?is)(\shref\s*=\s*)(["'])(.*?)(["'])`)
+	regexpHrefTpl   = regexp.MustCompile(`(?is)(\shref\s*=\s*)(["'])\s*(\{\{.*?\}\})\s*(["'])`)
+	regexpTplURL    = regexp.MustCompile("(?i)\"https?://[^\"]*\"|`https?://[^`]*`")
+	regexpNoUTM     = regexp.MustCompile(`(?i)\sdata-no-utm[\s=>/]`)
 )
 
 // Campaigns represents a slice of Campaigns.
@@ -43,7 +87,9 @@ type Campaign struct {
 	Type              string          `db:"type" json:"type"`
 	Name              string          `db:"name" json:"name"`
 	Subject           string          `db:"subject" json:"subject"`
+	PreviewText       string          `db:"preview_text" json:"preview_text"`
 	FromEmail         string          `db:"from_email" json:"from_email"`
+	ReplyTo           string          `db:"reply_to" json:"reply_to"`
 	Body              string          `db:"body" json:"body"`
 	BodySource        null.String     `db:"body_source" json:"body_source"`
 	AltBody           null.String     `db:"altbody" json:"altbody"`
@@ -54,16 +100,49 @@ type Campaign struct {
 	Headers           Headers         `db:"headers" json:"headers"`
 	TemplateID        null.Int        `db:"template_id" json:"template_id"`
 	Messenger         string          `db:"messenger" json:"messenger"`
+	TrackingMode      string          `db:"tracking_mode" json:"tracking_mode"`
+	Simulation        bool            `db:"simulation" json:"simulation"`
+	UTM               CampaignUTM     `db:"utm" json:"utm"`
 	Archive           bool            `db:"archive" json:"archive"`
 	ArchiveSlug       null.String     `db:"archive_slug" json:"archive_slug"`
 	ArchiveTemplateID null.Int        `db:"archive_template_id" json:"archive_template_id"`
 	ArchiveMeta       json.RawMessage `db:"archive_meta" json:"archive_meta"`
+	ArchiveAccess     string          `db:"archive_access" json:"archive_access"`
+	ArchivePassword   null.String     `db:"archive_password" json:"-"`
+
+	// Results of the last send to the seed list, if any (CampaignSeedSend).
+	SeedSend json.RawMessage `db:"seed_send" json:"seed_send"`
+
+	// Approval of the campaign's current content, if approvals are required.
+	// ApprovalComment is the approver's comment on approval or rejection.
+	ApprovedBy      null.Int  `db:"approved_by" json:"approved_by"`
+	ApprovedAt      null.Time `db:"approved_at" json:"approved_at"`
+	ApprovalComment string    `db:"approval_comment" json:"approval_comment"`
+
+	// Percentages at which progress events are emitted. If null, the global
+	// milestones are used.
+	ProgressMilestones pq.Int64Array `db:"progress_milestones" json:"progress_milestones"`
+
+	// ParentID is the campaign that this campaign resends to non-openers.
+	ParentID null.Int `db:"parent_id" json:"parent_id"`
+
+	// Per-subscriber attachments that are fetched from templated URLs.
+	DynamicAttachments DynamicAttachments `db:"dynamic_attachments" json:"dynamic_attachments"`
+
+	// Whether tracked links are shortened to the short link URL.
+	ShortLinks bool `db:"short_links" json:"short_links"`
+
+	// Duration after which the campaign is paused if it's still running, eg: 12h.
+	// Empty uses the global max runtime and 0 disables the limit.
+	MaxRuntime string `db:"max_runtime" json:"max_runtime"`
 
-	// TemplateBody is joined in from templates by the next-campaigns query.
+	// TemplateBody and TemplateBlockStyles are joined in from templates by the next-campaigns query.
 	TemplateBody        string             `db:"template_body" json:"-"`
+	TemplateBlockStyles BlockStyles        `db:"template_block_styles" json:"-"`
 	ArchiveTemplateBody string             `db:"archive_template_body" json:"-"`
 	Tpl                 *template.Template `json:"-"`
 	SubjectTpl          *txttpl.Template   `json:"-"`
+	PreviewTextTpl      *template.Template `json:"-"`
 	AltBodyTpl          *template.Template `json:"-"`
 
 	// List of media (attachment) IDs obtained from the next-campaign query
@@ -73,6 +152,9 @@ type Campaign struct {
 	// Fetched bodies of the attachments.
 	Attachments []Attachment `json:"-" db:"-"`
 
+	// Compiled URL and filename templates of DynamicAttachments.
+	DynamicAttachmentTpls []DynamicAttachmentTpl `json:"-" db:"-"`
+
 	// Pseudofield for getting the total number of subscribers
 	// in searches and queries.
 	Total int `db:"total" json:"-"`
@@ -85,6 +167,16 @@ type CampaignMeta struct {
 	Clicks     int `db:"clicks" json:"clicks"`
 	Bounces    int `db:"bounces" json:"bounces"`
 
+	// Number of distinct subscribers who viewed or clicked.
+	UniqueViews  int `db:"unique_views" json:"unique_views"`
+	UniqueClicks int `db:"unique_clicks" json:"unique_clicks"`
+
+	// Delivered is sent minus bounced. The rates are unique views and
+	// clicks as percentages of it. See CalcRates().
+	Delivered int     `db:"-" json:"delivered"`
+	OpenRate  float64 `db:"-" json:"open_rate"`
+	ClickRate float64 `db:"-" json:"click_rate"`
+
 	// This is a list of {list_id, name} pairs unlike Subscriber.Lists[]
 	// because lists can be deleted after a campaign is finished, resulting
 	// in null lists data to be returned. For that reason, campaign_lists maintains
@@ -93,9 +185,192 @@ type CampaignMeta struct {
 	Lists types.JSONText `db:"lists" json:"lists"`
 	Media types.JSONText `db:"media" json:"media"`
 
+	// Lists whose subscribers are excluded from the campaign as {id, name} pairs.
+	ExcludeLists types.JSONText `db:"exclude_lists" json:"exclude_lists"`
+
 	StartedAt null.Time `db:"started_at" json:"started_at"`
 	ToSend    int       `db:"to_send" json:"to_send"`
 	Sent      int       `db:"sent" json:"sent"`
+
+	// Number of eligible subscribers on the lists that were skipped for
+	// being on the excluded lists. It's computed when the campaign starts.
+	Excluded int `db:"excluded" json:"excluded"`
+}
+
+// CampaignProgress is the payload of campaign progress events that are emitted when
+// a campaign's processed messages cross a milestone percentage and when it ends.
+type CampaignProgress struct {
+	CampaignID   int    `json:"campaign_id"`
+	CampaignUUID string `json:"campaign_uuid"`
+	Name         string `json:"name"`
+	Status       string `json:"status"`
+
+	// The milestone percentage that was crossed. 0 for the event on ending.
+	Milestone int `json:"milestone"`
+
+	ToSend  int `json:"to_send"`
+	Sent    int `json:"sent"`
+	Errored int `json:"errored"`
+
+	// Messages sent per minute and the seconds elapsed since the campaign started.
+	Rate    int64 `json:"rate"`
+	Elapsed int   `json:"elapsed"`
+}
+
+// CampaignSendError represents a distinct error that occurred while sending a
+// campaign with the number of its occurrences and example recipient domains.
+type CampaignSendError struct {
+	Error   string    `json:"error"`
+	Count   int       `json:"count"`
+	Domains []string  `json:"domains"`
+	LastAt  time.Time `json:"last_at"`
+}
+
+// CampaignHygiene represents the bounce hygiene summary of a campaign.
+type CampaignHygiene struct {
+	CampaignID       int `db:"campaign_id" json:"campaign_id"`
+	Sent             int `db:"sent" json:"sent"`
+	BouncesHard      int `db:"bounces_hard" json:"bounces_hard"`
+	BouncesSoft      int `db:"bounces_soft" json:"bounces_soft"`
+	BouncesComplaint int `db:"bounces_complaint" json:"bounces_complaint"`
+
+	// Top domains by hard bounces [{domain, count}].
+	Domains types.JSONText `db:"domains" json:"domains"`
+
+	// Number of subscribers whose bounces on the campaign crossed the bounce action threshold.
+	ThresholdCrossed int `db:"threshold_crossed" json:"threshold_crossed"`
+
+	// Percentage of recipients that didn't hard bounce or complain, and the
+	// score of the previous campaign on the same lists for the trend.
+	QualityScore     float64      `db:"quality_score" json:"quality_score"`
+	PrevQualityScore null.Float64 `db:"prev_quality_score" json:"prev_quality_score"`
+
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// CampaignDraft represents the autosaved, unsaved content of a campaign.
+type CampaignDraft struct {
+	Subject    string      `db:"subject" json:"subject"`
+	Body       string      `db:"body" json:"body"`
+	BodySource null.String `db:"body_source" json:"body_source"`
+	UpdatedAt  time.Time   `db:"updated_at" json:"updated_at"`
+}
+
+// SimulationPurge represents the number of records of simulated campaign runs deleted.
+type SimulationPurge struct {
+	Sends int `db:"sends" json:"sends"`
+	Views int `db:"views" json:"views"`
+}
+
+// CampaignSeedSend represents the results of sending a campaign to the seed list.
+type CampaignSeedSend struct {
+	SentAt  time.Time        `json:"sent_at"`
+	Results []SeedSendResult `json:"results"`
+}
+
+// SeedSendResult represents the result of sending a campaign to a seed address.
+type SeedSendResult struct {
+	Email  string `json:"email"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// HasSent returns true if the campaign was sent to at least one seed address.
+func (s CampaignSeedSend) HasSent() bool {
+	for _, r := range s.Results {
+		if r.Status == SeedStatusSent {
+			return true
+		}
+	}
+	return false
+}
+
+// CampaignCalendarItem represents a lightweight campaign record on the campaign calendar.
+type CampaignCalendarItem struct {
+	ID         int            `db:"id" json:"id"`
+	UUID       string         `db:"uuid" json:"uuid"`
+	Name       string         `db:"name" json:"name"`
+	Status     string         `db:"status" json:"status"`
+	Type       string         `db:"type" json:"type"`
+	SendAt     null.Time      `db:"send_at" json:"send_at"`
+	StartedAt  null.Time      `db:"started_at" json:"started_at"`
+	FinishedAt null.Time      `db:"finished_at" json:"finished_at"`
+	ToSend     int            `db:"to_send" json:"to_send"`
+	Lists      types.JSONText `db:"lists" json:"lists"`
+
+	// ExpectedCount is the number of eligible recipients for campaigns
+	// that are yet to start, and to_send for the rest.
+	ExpectedCount int `db:"-" json:"expected_count"`
+}
+
+// CampaignListCount represents the number of eligible and excluded
+// subscribers on a campaign's list.
+type CampaignListCount struct {
+	ListID       int    `db:"list_id" json:"list_id"`
+	Name         string `db:"name" json:"name"`
+	Optin        string `db:"optin" json:"optin"`
+	Eligible     int    `db:"eligible" json:"eligible"`
+	Unsubscribed int    `db:"unsubscribed" json:"unsubscribed"`
+	Blocklisted  int    `db:"blocklisted" json:"blocklisted"`
+
+	// Total is the unique eligible count across all lists of the campaign.
+	Total int `db:"total" json:"-"`
+}
+
+// CampaignOverlap represents another campaign scheduled or running around the
+// same time as a campaign and the number of the campaign's subscribers it also targets.
+type CampaignOverlap struct {
+	CampaignID int       `db:"id" json:"campaign_id"`
+	Name       string    `db:"name" json:"name"`
+	Status     string    `db:"status" json:"status"`
+	SendAt     null.Time `db:"send_at" json:"send_at"`
+	Overlap    int       `db:"overlap" json:"overlap"`
+
+	// Estimated indicates that the overlap was extrapolated from a sample
+	// of the campaign's subscribers.
+	Estimated bool `db:"estimated" json:"estimated"`
+}
+
+// DynamicAttachment is a per-subscriber campaign attachment that's fetched
+// from a URL when a message is sent. The URL and the filename can have
+// {{ .Subscriber.UUID }} style template expressions.
+type DynamicAttachment struct {
+	URL      string `json:"url"`
+	Filename string `json:"filename"`
+
+	// Action on fetch errors. One of AttachmentOnError*.
+	OnError string `json:"on_error"`
+}
+
+// DynamicAttachments represents a slice of DynamicAttachment.
+type DynamicAttachments []DynamicAttachment
+
+// DynamicAttachmentTpl holds the compiled templates of a DynamicAttachment.
+type DynamicAttachmentTpl struct {
+	URL      *txttpl.Template
+	Filename *txttpl.Template
+	OnError  string
+}
+
+// AttachmentFetch represents the settings for fetching dynamic attachments.
+type AttachmentFetch struct {
+	Timeout      string   `json:"timeout"`
+	MaxSizeMB    int      `json:"max_size_mb"`
+	ContentTypes []string `json:"content_types"`
+	Concurrency  int      `json:"concurrency"`
+	CacheTTL     string   `json:"cache_ttl"`
+	CacheSizeMB  int      `json:"cache_size_mb"`
+}
+
+// CampaignUTM represents the UTM parameters that are automatically appended
+// to the links in a campaign. The values can have {{ .Campaign.Name }} style tokens.
+type CampaignUTM struct {
+	Enabled  bool   `json:"enabled"`
+	Source   string `json:"source"`
+	Medium   string `json:"medium"`
+	Campaign string `json:"campaign"`
+	Term     string `json:"term"`
+	Content  string `json:"content"`
 }
 
 // GetIDs returns the list of campaign IDs.
@@ -122,16 +397,35 @@ func (camps Campaigns) LoadStats(stmt *sqlx.Stmt) error {
 	for i, c := range meta {
 		if c.CampaignID == camps[i].ID {
 			camps[i].Lists = c.Lists
+			camps[i].ExcludeLists = c.ExcludeLists
 			camps[i].Views = c.Views
 			camps[i].Clicks = c.Clicks
 			camps[i].Bounces = c.Bounces
+			camps[i].UniqueViews = c.UniqueViews
+			camps[i].UniqueClicks = c.UniqueClicks
 			camps[i].Media = c.Media
+
+			camps[i].Delivered, camps[i].OpenRate, camps[i].ClickRate = CalcRates(camps[i].Sent, c.Bounces, c.UniqueViews, c.UniqueClicks)
 		}
 	}
 
 	return nil
 }
 
+// CalcRates returns the delivered (sent minus bounced) count of a campaign and its
+// open and click rates, which are the unique views and clicks as percentages of
+// the delivered count rounded to two decimals.
+func CalcRates(sent, bounces, uniqueViews, uniqueClicks int) (int, float64, float64) {
+	delivered := max(sent-bounces, 0)
+	if delivered == 0 {
+		return 0, 0, 0
+	}
+
+	return delivered,
+		math.Round(float64(uniqueViews)/float64(delivered)*10000) / 100,
+		math.Round(float64(uniqueClicks)/float64(delivered)*10000) / 100
+}
+
 // CompileTemplate compiles a campaign body template into its base
 // template and sets the resultant template to Campaign.Tpl.
 func (c *Campaign) CompileTemplate(f template.FuncMap) error {
@@ -150,6 +444,36 @@ func (c *Campaign) CompileTemplate(f template.FuncMap) error {
 		c.SubjectTpl = subjTpl
 	}
 
+	// The preview text is always compiled as it's HTML escaped.
+	if c.PreviewText != "" {
+		p := c.PreviewText
+		for _, r := range regTplFuncs {
+			p = r.regExp.ReplaceAllString(p, r.replace)
+		}
+
+		pTpl, err := template.New(ContentTpl).Funcs(f).Parse(p)
+		if err != nil {
+			return fmt.Errorf("error compiling preview text: %v", err)
+		}
+		c.PreviewTextTpl = pTpl
+	}
+
+	// Compile the dynamic attachment URLs and filenames. Their values
+	// aren't HTML, hence text templates.
+	var txtFuncs map[string]any = f
+	c.DynamicAttachmentTpls = make([]DynamicAttachmentTpl, 0, len(c.DynamicAttachments))
+	for _, a := range c.DynamicAttachments {
+		u, err := compileTextTpl(a.URL, txtFuncs)
+		if err != nil {
+			return fmt.Errorf("error compiling attachment URL: %v", err)
+		}
+		n, err := compileTextTpl(a.Filename, txtFuncs)
+		if err != nil {
+			return fmt.Errorf("error compiling attachment filename: %v", err)
+		}
+		c.DynamicAttachmentTpls = append(c.DynamicAttachmentTpls, DynamicAttachmentTpl{URL: u, Filename: n, OnError: a.OnError})
+	}
+
 	// Compile the base template.
 	body := c.TemplateBody
 
@@ -173,10 +497,22 @@ func (c *Campaign) CompileTemplate(f template.FuncMap) error {
 			return err
 		}
 		body = b.String()
+	} else if c.ContentType == CampaignContentTypeBlocks {
+		// If the format is blocks, render the blocks to HTML with the template's block styles.
+		out, err := blocks.Render(c.Body, blocks.Styles(c.TemplateBlockStyles), markdown)
+		if err != nil {
+			return fmt.Errorf("error rendering blocks: %v", err)
+		}
+		body = out
 	} else {
 		body = c.Body
 	}
 
+	// Append UTM parameters to links.
+	if c.UTM.Enabled {
+		body = c.UTM.TagLinks(body, c)
+	}
+
 	// Compile the campaign message.
 	for _, r := range regTplFuncs {
 		body = r.regExp.ReplaceAllString(body, r.replace)
@@ -208,6 +544,15 @@ func (c *Campaign) CompileTemplate(f template.FuncMap) error {
 	return nil
 }
 
+// compileTextTpl compiles a text template after expanding the template function shorthands.
+func compileTextTpl(s string, f map[string]any) (*txttpl.Template, error) {
+	for _, r := range regTplFuncs {
+		s = r.regExp.ReplaceAllString(s, r.replace)
+	}
+
+	return txttpl.New(ContentTpl).Funcs(f).Parse(s)
+}
+
 // ConvertContent converts a campaign's body from one format to another,
 // for example, Markdown to HTML.
 func (c *Campaign) ConvertContent(from, to string) (string, error) {
@@ -225,9 +570,178 @@ func (c *Campaign) ConvertContent(from, to string) (string, error) {
 			return out, err
 		}
 		out = b.String()
+	} else if from == CampaignContentTypeBlocks &&
+		(to == CampaignContentTypeHTML || to == CampaignContentTypeRichtext) {
+		// If the format is blocks, render the blocks to HTML.
+		o, err := blocks.Render(c.Body, blocks.Styles(c.TemplateBlockStyles), markdown)
+		if err != nil {
+			return out, err
+		}
+		out = o
 	} else {
 		return out, errors.New("unknown formats to convert")
 	}
 
 	return out, nil
 }
+
+// TagLinks appends the UTM parameters to all http(s) links in the <a> tags of the given
+// HTML body, skipping parameters that a link already defines and anchors that have the
+// data-no-utm attribute. Links with the @TrackLink shorthand are tagged before tracking,
+// and so are the quoted links in template expressions, eg: {{ TrackLink "https://x.com" . }}.
+func (u CampaignUTM) TagLinks(body string, c *Campaign) string {
+	// Render the {{ .Campaign.Name }} style tokens in the values.
+	params := make([][2]string, 0, 5)
+	for _, p := range [][2]string{
+		{"utm_source", u.Source},
+		{"utm_medium", u.Medium},
+		{"utm_campaign", u.Campaign},
+		{"utm_term", u.Term},
+		{"utm_content", u.Content},
+	} {
+		val := strings.TrimSpace(p[1])
+		if strings.Contains(val, "{{") {
+			if tpl, err := txttpl.New("utm").Parse(val); err == nil {
+				var b bytes.Buffer
+				if err := tpl.Execute(&b, map[string]any{"Campaign": c}); err == nil {
+					val = b.String()
+				}
+			}
+		}
+
+		if val != "" {
+			params = append(params, [2]string{p[0], url.QueryEscape(val)})
+		}
+	}
+	if len(params) == 0 {
+		return body
+	}
+
+	return regexpAnchorTag.ReplaceAllStringFunc(body, func(tag string) string {
+		if regexpNoUTM.MatchString(tag) {
+			return tag
+		}
+
+		// Tag the URL string literals in a template expression. The quotes of the
+		// expression's strings would otherwise end the href.
+		if m := regexpHrefTpl.FindStringSubmatchIndex(tag); m != nil {
+			expr := regexpTplURL.ReplaceAllStringFunc(tag[m[6]:m[7]], func(lit string) string {
+				q := lit[:1]
+				return q + tagUTMURL(lit[1:len(lit)-1], params) + q
+			})
+			return tag[:m[6]] + expr + tag[m[7]:]
+		}
+
+		m := regexpHref.FindStringSubmatchIndex(tag)
+		if m == nil {
+			return tag
+		}
+
+		// m[6]:m[7] is the URL between the quotes.
+		return tag[:m[6]] + tagUTMURL(tag[m[6]:m[7]], params) + tag[m[7]:]
+	})
+}
+
+// tagUTMURL appends the given query params to an http(s) URL if they're not already present.
+func tagUTMURL(u string, params [][2]string) string {
+	suffix := ""
+	if strings.HasSuffix(u, "@TrackLink") {
+		u = strings.TrimSuffix(u, "@TrackLink")
+		suffix = "@TrackLink"
+	}
+
+	lower := strings.ToLower(u)
+	if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+		return u + suffix
+	}
+
+	// Separate the #fragment.
+	frag := ""
+	if i := strings.Index(u, "#"); i > -1 {
+		u, frag = u[:i], u[i:]
+	}
+
+	// HTML encoded &amp; in the query string.
+	amp := "&"
+	if strings.Contains(u, "&amp;") {
+		amp = "&amp;"
+	}
+
+	// Collect the existing query param keys.
+	keys := map[string]struct{}{}
+	if i := strings.Index(u, "?"); i > -1 {
+		for _, kv := range strings.Split(strings.ReplaceAll(u[i+1:], "&amp;", "&"), "&") {
+			k, _, _ := strings.Cut(kv, "=")
+			keys[strings.ToLower(k)] = struct{}{}
+		}
+	}
+
+	sep := "?"
+	if strings.Contains(u, "?") {
+		sep = amp
+		if strings.HasSuffix(u, "?") || strings.HasSuffix(u, "&") {
+			sep = ""
+		}
+	}
+
+	for _, p := range params {
+		if _, ok := keys[p[0]]; ok {
+			continue
+		}
+
+		u += sep + p[0] + "=" + p[1]
+		sep = amp
+	}
+
+	return u + frag + suffix
+}
+
+// Scan implements the sql.Scanner interface.
+func (d *DynamicAttachments) Scan(src any) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, d)
+	case string:
+		return json.Unmarshal([]byte(src), d)
+	case nil:
+		return nil
+	}
+
+	return fmt.Errorf("could not not decode type %T -> %T", src, d)
+}
+
+// Value implements the driver.Valuer interface.
+func (d DynamicAttachments) Value() (driver.Value, error) {
+	if d == nil {
+		return []byte("[]"), nil
+	}
+
+	return json.Marshal(d)
+}
+
+// Scan implements the sql.Scanner interface.
+func (u *CampaignUTM) Scan(src any) error {
+	switch src := src.(type) {
+	case []byte:
+		return json.Unmarshal(src, u)
+	case string:
+		return json.Unmarshal([]byte(src), u)
+	case nil:
+		return nil
+	}
+
+	return fmt.Errorf("could not not decode type %T -> %T", src, u)
+}
+
+// Value implements the driver.Valuer interface.
+func (u CampaignUTM) Value() (driver.Value, error) {
+	return json.Marshal(u)
+}
+
+// ShortLink represents the link, campaign, and subscriber that a short link's
+// slug and ref resolve to.
+type ShortLink struct {
+	LinkUUID       string `db:"link_uuid"`
+	CampaignUUID   string `db:"campaign_uuid"`
+	SubscriberUUID string `db:"subscriber_uuid"`
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"testing"
+)
+
+func TestTagLinks(t *testing.T) {
+	u := CampaignUTM{Enabled: true, Source: "listmonk", Campaign: "{{ .Campaign.Name }}"}
+	c := &Campaign{Name: "Spring sale"}
+
+	cases := []struct {
+		name string
+		in   string
+		exp  string
+	}{
+		{"plain", `<a href="https://example.com">x</a>`,
+			`<a href="https://example.com?utm_source=listmonk&utm_campaign=Spring+sale">x</a>`},
+		{"existing params", `<a href='https://example.com/?a=1&amp;utm_source=x#top'>x</a>`,
+			`<a href='https://example.com/?a=1&amp;utm_source=x&amp;utm_campaign=Spring+sale#top'>x</a>`},
+		{"tracklink shorthand", `<a href="https://example.com@TrackLink">x</a>`,
+			`<a href="https://example.com?utm_source=listmonk&utm_campaign=Spring+sale@TrackLink">x</a>`},
+		{"tracklink expression", `<a href="{{ TrackLink "https://example.com/p?id=1" . }}">x</a>`,
+			`<a href="{{ TrackLink "https://example.com/p?id=1&utm_source=listmonk&utm_campaign=Spring+sale" . }}">x</a>`},
+		{"tracklink expression in single quotes", "<a class=\"b\" href='{{ TrackLink `https://example.com` . }}'>x</a>",
+			"<a class=\"b\" href='{{ TrackLink `https://example.com?utm_source=listmonk&utm_campaign=Spring+sale` . }}'>x</a>"},
+		{"expression without a link", `<a href="{{ UnsubscribeURL }}">x</a>`, `<a href="{{ UnsubscribeURL }}">x</a>`},
+		{"expression in the link", `<a href="https://example.com/{{ .Subscriber.UUID }}">x</a>`,
+			`<a href="https://example.com/{{ .Subscriber.UUID }}?utm_source=listmonk&utm_campaign=Spring+sale">x</a>`},
+		{"mailto", `<a href="mailto:a@example.com">x</a>`, `<a href="mailto:a@example.com">x</a>`},
+		{"no-utm", `<a data-no-utm href="{{ TrackLink "https://example.com" . }}">x</a>`,
+			`<a data-no-utm href="{{ TrackLink "https://example.com" . }}">x</a>`},
+	}
+
+	for _, tc := range cases {
+		if out := u.TagLinks(tc.in, c); out != tc.exp {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tc.name, tc.exp, out)
+		}
+	}
+}
+
+func TestCampaignSeedSendHasSent(t *testing.T) {
+	cases := []struct {
+		results []SeedSendResult
+		exp     bool
+	}{
+		{nil, false},
+		{[]SeedSendResult{{Email: "a@example.com", Status: SeedStatusFailed}}, false},
+		{[]SeedSendResult{{Email: "a@example.com", Status: SeedStatusFailed}, {Email: "b@example.com", Status: SeedStatusSent}}, true},
+	}
+	for n, c := range cases {
+		if got := (CampaignSeedSend{Results: c.results}).HasSent(); got != c.exp {
+			t.Errorf("%d: expected %v, got %v", n, c.exp, got)
+		}
+	}
+}
+
+func TestCalcRates(t *testing.T) {
+	cases := []struct {
+		sent, bounces, views, clicks int
+		delivered                    int
+		open, click                  float64
+	}{
+		{10, 2, 2, 1, 8, 25, 12.5},
+		{3, 0, 1, 2, 3, 33.33, 66.67},
+		{10, 0, 0, 0, 10, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0},
+
+		// More bounces than sends, eg: with resends.
+		{2, 3, 1, 1, 0, 0, 0},
+	}
+	for _, c := range cases {
+		d, open, click := CalcRates(c.sent, c.bounces, c.views, c.clicks)
+		if d != c.delivered || open != c.open || click != c.click {
+			t.Errorf("%+v: got %d, %v, %v", c, d, open, click)
+		}
+	}
+}
@@ -30,6 +30,12 @@ const (
 	// TwoFA types.
 	TwofaTypeNone = "none"
 	TwofaTypeTOTP = "totp"
+
+	// Events emitted to webhooks.
+	EventUnconfirmedPurged = "subscribers.unconfirmed_purged"
+	EventSubscribersSunset = "subscribers.sunset"
+	EventCampaignProgress  = "campaign.progress"
+	EventBounceRecorded    = "bounce.recorded"
 )
 
 // regTplFunc represents contains a regular expression for wrapping and
@@ -53,9 +59,10 @@ var regTplFuncs = []regTplFunc{
 	// This is for WYSIWYG editors that encode and break quotes {{ "" }} when inserted
 	// inside <a href="{{ TrackLink "https://these-quotes-break" }}>.
 	// The regex matches all characters that may occur in an URL
-	// (see "2. Characters" in RFC3986: https://www.ietf.org/rfc/rfc3986.txt)
+	// (see "2. Characters" in RFC3986: https://www.ietf.org/rfc/rfc3986.txt), including
+	// percent-encoded characters, and {{ .Subscriber.UUID }} style placeholders that are rendered on click.
 	{
-		regExp:  regexp.MustCompile(`(https?://[\p{L}\p{N}_\-\.~!#$&'()*+,/:;=?@\[\]]*)@TrackLink`),
+		regExp:  regexp.MustCompile(`(https?://(?:[\p{L}\p{N}_\-\.~!#$%&'()*+,/:;=?@\[\]]|{{[^{}"<>]*}})*)@TrackLink`),
 		replace: `{{ TrackLink "$1" . }}`,
 	},
 
@@ -91,6 +98,10 @@ var markdown = goldmark.New(
 // similar to url.Values{}
 type Headers []map[string]string
 
+// TotalSkipped is the total in paginated results when counting the
+// results is skipped (?skip_total=true).
+const TotalSkipped = -1
+
 // PageResults is a generic HTTP response container for paginated results of list of items.
 type PageResults struct {
 	Results any `json:"results"`
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Sections of the activity digest report.
+const (
+	DigestSectionCampaigns    = "campaigns"
+	DigestSectionEngagement   = "engagement"
+	DigestSectionSubscribers  = "subscribers"
+	DigestSectionTopCampaigns = "top_campaigns"
+)
+
+// Digest run statuses.
+const (
+	DigestRunSent   = "sent"
+	DigestRunFailed = "failed"
+)
+
+// DigestSections is the list of valid digest report sections.
+var DigestSections = []string{DigestSectionCampaigns, DigestSectionEngagement, DigestSectionSubscribers, DigestSectionTopCampaigns}
+
+// DigestSettings represents the schedule and contents of the periodic activity
+// digest report e-mailed to admins.
+type DigestSettings struct {
+	Enabled bool `json:"enabled" koanf:"enabled"`
+
+	// Standard cron expression, eg: "0 8 * * 1" (Monday, 8 AM).
+	Schedule string `json:"schedule" koanf:"schedule"`
+
+	// Number of whole days before the day of the run that the report covers.
+	PeriodDays int `json:"period_days" koanf:"period_days"`
+
+	// Recipient e-mails. If empty, app.notify_emails is used.
+	Recipients []string `json:"recipients" koanf:"recipients"`
+
+	// Sections to include. One or more of DigestSection*.
+	Sections []string `json:"sections" koanf:"sections"`
+}
+
+// DigestStats represents the aggregate activity of an instance in a period.
+// The campaign numbers are of the campaigns that finished in the period.
+type DigestStats struct {
+	Campaigns int `json:"campaigns"`
+	Messages  int `json:"messages"`
+
+	// Unique views and clicks, and bounces of the campaigns.
+	Views   int `json:"views"`
+	Clicks  int `json:"clicks"`
+	Bounces int `json:"bounces"`
+
+	NewSubscribers int              `json:"new_subscribers"`
+	Lists          []DigestList     `json:"lists"`
+	TopCampaigns   []DigestCampaign `json:"top_campaigns"`
+}
+
+// DigestList represents the subscriber growth of a list in a period.
+type DigestList struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Subscribed   int    `json:"subscribed"`
+	Unsubscribed int    `json:"unsubscribed"`
+}
+
+// DigestCampaign represents the stats of a campaign in a digest report.
+type DigestCampaign struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Sent    int    `json:"sent"`
+	Views   int    `json:"views"`
+	Clicks  int    `json:"clicks"`
+	Bounces int    `json:"bounces"`
+}
+
+// DigestRun represents a run of the digest report.
+type DigestRun struct {
+	ID          int       `db:"id" json:"id"`
+	PeriodStart time.Time `db:"period_start" json:"period_start"`
+	PeriodEnd   time.Time `db:"period_end" json:"period_end"`
+
+	// One of DigestRun*.
+	Status     string    `db:"status" json:"status"`
+	Recipients int       `db:"recipients" json:"recipients"`
+	Error      string    `db:"error" json:"error"`
+	CreatedAt  null.Time `db:"created_at" json:"created_at"`
+}
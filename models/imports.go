@@ -0,0 +1,43 @@
+package models
+
+import (
+	"encoding/json"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// ImportJob represents a subscriber import job.
+type ImportJob struct {
+	ID     int    `db:"id" json:"id"`
+	Name   string `db:"name" json:"name"`
+	Mode   string `db:"mode" json:"mode"`
+	Status string `db:"status" json:"status"`
+
+	// Import options (subimporter.SessionOpt).
+	Params   json.RawMessage `db:"params" json:"params"`
+	FilePath string          `db:"file_path" json:"-"`
+
+	Total    int `db:"total" json:"total"`
+	Imported int `db:"imported" json:"imported"`
+	Failed   int `db:"failed" json:"failed"`
+
+	// Last row that has been processed.
+	Line int `db:"line" json:"line"`
+
+	State      json.RawMessage `db:"state" json:"-"`
+	Log        string          `db:"log" json:"-"`
+	CreatedAt  null.Time       `db:"created_at" json:"created_at"`
+	StartedAt  null.Time       `db:"started_at" json:"started_at"`
+	FinishedAt null.Time       `db:"finished_at" json:"finished_at"`
+	UpdatedAt  null.Time       `db:"updated_at" json:"updated_at"`
+
+	// Pseudofield for getting the total number of jobs in paginated queries.
+	TotalJobs int `db:"total_jobs" json:"-"`
+}
+
+// ImportJobError represents a row of an import job that was skipped.
+type ImportJobError struct {
+	Line  int    `db:"line" json:"line"`
+	Data  string `db:"data" json:"data"`
+	Error string `db:"error" json:"error"`
+}
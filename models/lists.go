@@ -1,6 +1,9 @@
 package models
 
 import (
+	"html/template"
+
+	"github.com/knadh/listmonk/internal/sanitize"
 	"github.com/lib/pq"
 	null "gopkg.in/volatiletech/null.v6"
 )
@@ -14,6 +17,12 @@ const (
 	ListStatusArchived = "archived"
 )
 
+// Actions for purging subscribers who never confirmed their double opt-in subscriptions.
+const (
+	PurgeActionDelete    = "delete"
+	PurgeActionAnonymize = "anonymize"
+)
+
 // List represents a mailing list.
 type List struct {
 	Base
@@ -29,12 +38,48 @@ type List struct {
 	SubscriberCounts StringIntMap   `db:"subscriber_statuses" json:"subscriber_statuses"`
 	SubscriberID     int            `db:"subscriber_id" json:"-"`
 
+	// PublicDescription (Markdown or basic HTML) is shown to subscribers on public
+	// pages, unlike Description, which is internal. It's sanitized when rendered.
+	PublicDescription string `db:"public_description" json:"public_description"`
+
+	// Frequency is a hint of how often the list is mailed, eg: "weekly".
+	Frequency string `db:"frequency" json:"frequency"`
+
 	// This is only relevant when querying the lists of a subscriber.
 	SubscriptionStatus    string    `db:"subscription_status" json:"subscription_status,omitempty"`
 	SubscriptionCreatedAt null.Time `db:"subscription_created_at" json:"subscription_created_at,omitempty"`
 	SubscriptionUpdatedAt null.Time `db:"subscription_updated_at" json:"subscription_updated_at,omitempty"`
 
+	// PurgeUnconfirmedAfterDays is the number of days after which subscribers
+	// who never confirmed their double opt-in subscription are purged. 0 disables it.
+	PurgeUnconfirmedAfterDays int `db:"purge_unconfirmed_after_days" json:"purge_unconfirmed_after_days"`
+
+	// SunsetInactiveDays overrides the sunset policy's inactivity days.
+	// If it's null, the global policy applies and 0 exempts the list.
+	SunsetInactiveDays null.Int `db:"sunset_inactive_days" json:"sunset_inactive_days"`
+
+	// Stats is the optional subscriber breakdown of the list.
+	Stats *ListStats `db:"-" json:"stats,omitempty"`
+
 	// Pseudofield for getting the total number of subscribers
 	// in searches and queries.
 	Total int `db:"total" json:"-"`
 }
+
+// PublicDescriptionHTML renders the list's public description into sanitized HTML.
+func (l List) PublicDescriptionHTML() template.HTML {
+	return template.HTML(sanitize.Markdown(l.PublicDescription))
+}
+
+// ListStats represents the per-status subscriber breakdown and growth of a list.
+// Confirmed, unconfirmed, and unsubscribed add up to the total. Blocklisted is
+// the number of subscriptions (of any status) of blocklisted subscribers.
+type ListStats struct {
+	ListID       int `db:"list_id" json:"-"`
+	Confirmed    int `db:"confirmed" json:"confirmed"`
+	Unconfirmed  int `db:"unconfirmed" json:"unconfirmed"`
+	Unsubscribed int `db:"unsubscribed" json:"unsubscribed"`
+	Blocklisted  int `db:"blocklisted" json:"blocklisted"`
+	Total        int `db:"total" json:"total"`
+	NewLast30d   int `db:"new_last_30d" json:"new_last_30d"`
+}
@@ -2,13 +2,32 @@ package models
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/textproto"
+	"regexp"
 	"strings"
 	txttpl "text/template"
+	"time"
+
+	"github.com/lib/pq"
+	null "gopkg.in/volatiletech/null.v6"
 )
 
+// System message statuses.
+const (
+	SystemMessagePending = "pending"
+	SystemMessageFailed  = "failed"
+)
+
+// Message-Ids of campaign messages embed the campaign and subscriber UUIDs,
+// eg: <lm.{campaign_uuid}.{subscriber_uuid}.{random}@domain>, so that replies
+// to them can be attributed from their In-Reply-To and References headers.
+var reMessageID = regexp.MustCompile(`<lm\.([0-9a-fA-F\-]{36})\.([0-9a-fA-F\-]{36})\.[0-9a-f]+@[^<>\s]+>`)
+
 // Message is the message pushed to a Messenger.
 type Message struct {
 	From        string
@@ -29,6 +48,58 @@ type Message struct {
 	Messenger string
 }
 
+// SystemMessage is a system e-mail, eg: an opt-in confirmation or an admin
+// notification, that failed to send and is queued for retries.
+type SystemMessage struct {
+	ID          int             `db:"id" json:"id"`
+	FromEmail   string          `db:"from_email" json:"from_email"`
+	ToEmails    pq.StringArray  `db:"to_emails" json:"to_emails"`
+	Subject     string          `db:"subject" json:"subject"`
+	ContentType string          `db:"content_type" json:"content_type"`
+	Body        string          `db:"body" json:"-"`
+	Headers     json.RawMessage `db:"headers" json:"-"`
+
+	// One of SystemMessage*.
+	Status        string    `db:"status" json:"status"`
+	Attempts      int       `db:"attempts" json:"attempts"`
+	LastError     string    `db:"last_error" json:"last_error"`
+	NextAttemptAt time.Time `db:"next_attempt_at" json:"next_attempt_at"`
+	CreatedAt     null.Time `db:"created_at" json:"created_at"`
+	UpdatedAt     null.Time `db:"updated_at" json:"updated_at"`
+}
+
+// ErrMessageTooLarge is returned by messengers when a composed message
+// exceeds the maximum message size of the server it's sent to.
+type ErrMessageTooLarge struct {
+	Size  int64
+	Limit int64
+}
+
+func (e *ErrMessageTooLarge) Error() string {
+	return fmt.Sprintf("message size (%d bytes) exceeds the maximum message size (%d bytes)", e.Size, e.Limit)
+}
+
+// MakeMessageID returns a unique Message-Id for a campaign message to a subscriber.
+func MakeMessageID(campUUID, subUUID, domain string) string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+
+	return fmt.Sprintf("<lm.%s.%s.%s@%s>", campUUID, subUUID, hex.EncodeToString(b), domain)
+}
+
+// ParseMessageID returns the campaign and subscriber UUIDs embedded in the last
+// Message-Id generated by MakeMessageID in the given header value, eg: the
+// In-Reply-To or References header of a reply.
+func ParseMessageID(hdr string) (string, string, bool) {
+	m := reMessageID.FindAllStringSubmatch(hdr, -1)
+	if m == nil {
+		return "", "", false
+	}
+
+	last := m[len(m)-1]
+	return strings.ToLower(last[1]), strings.ToLower(last[2]), true
+}
+
 // Attachment represents a file or blob attachment that can be
 // sent along with a message by a Messenger.
 type Attachment struct {
@@ -62,6 +133,9 @@ type TxMessage struct {
 	Messenger   string         `json:"messenger"`
 	Subject     string         `json:"subject"`
 
+	// Whether the IDs of the queued messages are returned in the response.
+	ReturnIDs bool `json:"return_ids"`
+
 	// File attachments added from multi-part form data.
 	Attachments []Attachment `json:"-"`
 
@@ -3,7 +3,9 @@ package models
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/lib/pq"
@@ -13,6 +15,19 @@ import (
 type Queries struct {
 	GetDashboardCharts *sqlx.Stmt `query:"get-dashboard-charts"`
 	GetDashboardCounts *sqlx.Stmt `query:"get-dashboard-counts"`
+	GetDigestStats     *sqlx.Stmt `query:"get-digest-stats"`
+	InsertDigestRun    *sqlx.Stmt `query:"insert-digest-run"`
+	GetDigestRuns      *sqlx.Stmt `query:"get-digest-runs"`
+
+	QueueSystemMessage         *sqlx.Stmt `query:"queue-system-message"`
+	NextSystemMessages         *sqlx.Stmt `query:"next-system-messages"`
+	RecordSystemMessageAttempt *sqlx.Stmt `query:"record-system-message-attempt"`
+	DeleteSystemMessage        *sqlx.Stmt `query:"delete-system-message"`
+	GetFailedSystemMessages    *sqlx.Stmt `query:"get-failed-system-messages"`
+	RetrySystemMessage         *sqlx.Stmt `query:"retry-system-message"`
+
+	GetSMTPSendCounts *sqlx.Stmt `query:"get-smtp-send-counts"`
+	AddSMTPSendCounts *sqlx.Stmt `query:"add-smtp-send-counts"`
 
 	InsertSubscriber                *sqlx.Stmt `query:"insert-subscriber"`
 	UpsertSubscriber                *sqlx.Stmt `query:"upsert-subscriber"`
@@ -20,6 +35,7 @@ type Queries struct {
 	GetSubscriber                   *sqlx.Stmt `query:"get-subscriber"`
 	HasSubscriberLists              *sqlx.Stmt `query:"has-subscriber-list"`
 	GetSubscribersByEmails          *sqlx.Stmt `query:"get-subscribers-by-emails"`
+	GetSubscribersByIDs             *sqlx.Stmt `query:"get-subscribers-by-ids"`
 	GetSubscriberLists              *sqlx.Stmt `query:"get-subscriber-lists"`
 	GetSubscriptions                *sqlx.Stmt `query:"get-subscriptions"`
 	GetSubscriberListsLazy          *sqlx.Stmt `query:"get-subscriber-lists-lazy"`
@@ -28,15 +44,33 @@ type Queries struct {
 	BlocklistSubscribers            *sqlx.Stmt `query:"blocklist-subscribers"`
 	AddSubscribersToLists           *sqlx.Stmt `query:"add-subscribers-to-lists"`
 	DeleteSubscriptions             *sqlx.Stmt `query:"delete-subscriptions"`
+	ManageSubscriptionItems         *sqlx.Stmt `query:"manage-subscription-items"`
 	DeleteUnconfirmedSubscriptions  *sqlx.Stmt `query:"delete-unconfirmed-subscriptions"`
 	ConfirmSubscriptionOptin        *sqlx.Stmt `query:"confirm-subscription-optin"`
 	UnsubscribeSubscribersFromLists *sqlx.Stmt `query:"unsubscribe-subscribers-from-lists"`
 	DeleteSubscribers               *sqlx.Stmt `query:"delete-subscribers"`
 	DeleteBlocklistedSubscribers    *sqlx.Stmt `query:"delete-blocklisted-subscribers"`
+	GetEmailDuplicates              *sqlx.Stmt `query:"get-email-duplicates"`
+	MergeSubscribers                *sqlx.Stmt `query:"merge-subscribers"`
+	ClearStaleNormalizedEmails      *sqlx.Stmt `query:"clear-stale-normalized-emails"`
+	UpdateNormalizedEmails          *sqlx.Stmt `query:"update-normalized-emails"`
+	GetSubscribersToVerify          *sqlx.Stmt `query:"get-subscribers-to-verify"`
+	CountSubscribersToVerify        *sqlx.Stmt `query:"count-subscribers-to-verify"`
+	UpdateSubscriberVerifications   *sqlx.Stmt `query:"update-subscriber-verifications"`
 	DeleteOrphanSubscribers         *sqlx.Stmt `query:"delete-orphan-subscribers"`
+	PurgeUnconfirmedSubscribers     *sqlx.Stmt `query:"purge-unconfirmed-subscribers"`
+	RequestSubscriberDeletion       *sqlx.Stmt `query:"request-subscriber-deletion"`
+	CancelSubscriberDeletion        *sqlx.Stmt `query:"cancel-subscriber-deletion"`
+	DeletePendingSubscribers        *sqlx.Stmt `query:"delete-pending-subscribers"`
+	SunsetSubscribers               *sqlx.Stmt `query:"sunset-subscribers"`
+	RecordSunsetNotices             *sqlx.Stmt `query:"record-sunset-notices"`
 	UnsubscribeByCampaign           *sqlx.Stmt `query:"unsubscribe-by-campaign"`
+	InsertUnsubscribeEvent          *sqlx.Stmt `query:"insert-unsubscribe-event"`
+	GetUnsubscribeReasons           *sqlx.Stmt `query:"get-unsubscribe-reasons"`
 	ExportSubscriberData            *sqlx.Stmt `query:"export-subscriber-data"`
 	GetSubscriberActivity           *sqlx.Stmt `query:"get-subscriber-activity"`
+	DeleteStaleAttribHistory        *sqlx.Stmt `query:"delete-stale-attrib-history"`
+	QuerySubscriberSends            *sqlx.Stmt `query:"query-subscriber-sends"`
 
 	// Non-prepared arbitrary subscriber queries.
 	QuerySubscribers                       string     `query:"query-subscribers"`
@@ -55,18 +89,29 @@ type Queries struct {
 	GetLists        *sqlx.Stmt `query:"get-lists"`
 	GetListsByOptin *sqlx.Stmt `query:"get-lists-by-optin"`
 	GetListTypes    *sqlx.Stmt `query:"get-list-types"`
+	GetListStats    *sqlx.Stmt `query:"get-list-stats"`
 	UpdateList      *sqlx.Stmt `query:"update-list"`
 	UpdateListsDate *sqlx.Stmt `query:"update-lists-date"`
 	DeleteLists     *sqlx.Stmt `query:"delete-lists"`
+	ExportLists     *sqlx.Stmt `query:"export-lists"`
+	UpsertList      *sqlx.Stmt `query:"upsert-list-by-uuid"`
+	ImportList      *sqlx.Stmt `query:"import-list"`
+	HasListConflict *sqlx.Stmt `query:"has-list-name-conflict"`
 
 	CreateCampaign        *sqlx.Stmt `query:"create-campaign"`
+	ExportCampaigns       *sqlx.Stmt `query:"export-campaigns"`
+	UpsertCampaign        *sqlx.Stmt `query:"upsert-campaign-by-uuid"`
 	QueryCampaigns        string     `query:"query-campaigns"`
 	GetCampaign           *sqlx.Stmt `query:"get-campaign"`
 	GetCampaignForPreview *sqlx.Stmt `query:"get-campaign-for-preview"`
 	GetCampaignStats      *sqlx.Stmt `query:"get-campaign-stats"`
 	GetCampaignStatus     *sqlx.Stmt `query:"get-campaign-status"`
 	GetArchivedCampaigns  *sqlx.Stmt `query:"get-archived-campaigns"`
+	GetArchiveSitemap     *sqlx.Stmt `query:"get-archive-sitemap"`
 	CampaignHasLists      *sqlx.Stmt `query:"campaign-has-lists"`
+	GetCampaignCalendar   *sqlx.Stmt `query:"get-campaign-calendar"`
+	GetCampaignListCounts *sqlx.Stmt `query:"get-campaign-list-counts"`
+	GetCampaignOverlap    *sqlx.Stmt `query:"get-campaign-overlap"`
 
 	// These two queries are read as strings and based on settings.individual_tracking=on/off,
 	// are interpolated and copied to view and click counts. Same query, different tables.
@@ -75,6 +120,7 @@ type Queries struct {
 	GetCampaignClickCounts     *sqlx.Stmt `query:"get-campaign-click-counts"`
 	GetCampaignLinkCounts      *sqlx.Stmt `query:"get-campaign-link-counts"`
 	GetCampaignBounceCounts    *sqlx.Stmt `query:"get-campaign-bounce-counts"`
+	GetDomainStats             *sqlx.Stmt `query:"get-domain-stats"`
 	DeleteCampaignViews        *sqlx.Stmt `query:"delete-campaign-views"`
 	DeleteCampaignLinkClicks   *sqlx.Stmt `query:"delete-campaign-link-clicks"`
 
@@ -82,17 +128,43 @@ type Queries struct {
 	GetRunningCampaign       *sqlx.Stmt `query:"get-running-campaign"`
 	NextCampaignSubscribers  *sqlx.Stmt `query:"next-campaign-subscribers"`
 	GetOneCampaignSubscriber *sqlx.Stmt `query:"get-one-campaign-subscriber"`
+	NextCampaignRecipients   *sqlx.Stmt `query:"next-campaign-recipients"`
+	InsertCampaignRecipients *sqlx.Stmt `query:"insert-campaign-recipients"`
+	CountCampaignRecipients  *sqlx.Stmt `query:"count-campaign-recipients"`
+	DeleteCampaignRecipients *sqlx.Stmt `query:"delete-campaign-recipients"`
+	DeleteStaleRecipients    *sqlx.Stmt `query:"delete-stale-campaign-recipients"`
+	RecordRecipientBounce    *sqlx.Stmt `query:"record-campaign-recipient-bounce"`
 	UpdateCampaign           *sqlx.Stmt `query:"update-campaign"`
 	UpdateCampaignStatus     *sqlx.Stmt `query:"update-campaign-status"`
+	UpdateCampaignSimulation *sqlx.Stmt `query:"update-campaign-simulation"`
+	DeleteSimulationData     *sqlx.Stmt `query:"delete-simulation-data"`
+	GetCampaignBounceTypes   *sqlx.Stmt `query:"get-campaign-bounce-types"`
+	UpdateCampaignDraft      *sqlx.Stmt `query:"update-campaign-draft"`
+	GetCampaignDraft         *sqlx.Stmt `query:"get-campaign-draft"`
+	RestoreCampaignDraft     *sqlx.Stmt `query:"restore-campaign-draft"`
+	DeleteCampaignDraft      *sqlx.Stmt `query:"delete-campaign-draft"`
+	ApproveCampaign          *sqlx.Stmt `query:"approve-campaign"`
+	RejectCampaign           *sqlx.Stmt `query:"reject-campaign"`
+	SetCampaignApproval      *sqlx.Stmt `query:"set-campaign-approval"`
+	GetCampaignApprovers     *sqlx.Stmt `query:"get-campaign-approvers"`
 	UpdateCampaignCounts     *sqlx.Stmt `query:"update-campaign-counts"`
+	UpdateCampaignSeedSend   *sqlx.Stmt `query:"update-campaign-seed-send"`
+	InsertCampaignSends      *sqlx.Stmt `query:"insert-campaign-sends"`
+	UpdateCampaignSendErrors *sqlx.Stmt `query:"update-campaign-send-errors"`
+	GetCampaignHygiene       *sqlx.Stmt `query:"get-campaign-hygiene"`
+	UpdateCampaignHygiene    *sqlx.Stmt `query:"update-campaign-hygiene"`
+	RollupCampaignStats      *sqlx.Stmt `query:"rollup-campaign-stats"`
+	GetCampaignStoredHygiene *sqlx.Stmt `query:"get-campaign-stored-hygiene"`
+	GetCampaignSendErrors    *sqlx.Stmt `query:"get-campaign-send-errors"`
 	UpdateCampaignArchive    *sqlx.Stmt `query:"update-campaign-archive"`
+	CheckArchivePassword     *sqlx.Stmt `query:"check-campaign-archive-password"`
 	RegisterCampaignView     *sqlx.Stmt `query:"register-campaign-view"`
 	DeleteCampaign           *sqlx.Stmt `query:"delete-campaign"`
 	DeleteCampaigns          *sqlx.Stmt `query:"delete-campaigns"`
 
 	InsertMedia *sqlx.Stmt `query:"insert-media"`
 	GetMedia    *sqlx.Stmt `query:"get-media"`
-	QueryMedia  *sqlx.Stmt `query:"query-media"`
+	QueryMedia  string     `query:"query-media"`
 	DeleteMedia *sqlx.Stmt `query:"delete-media"`
 
 	CreateTemplate     *sqlx.Stmt `query:"create-template"`
@@ -100,13 +172,38 @@ type Queries struct {
 	UpdateTemplate     *sqlx.Stmt `query:"update-template"`
 	SetDefaultTemplate *sqlx.Stmt `query:"set-default-template"`
 	DeleteTemplate     *sqlx.Stmt `query:"delete-template"`
+	SetTemplateAssets  *sqlx.Stmt `query:"set-template-assets"`
+	GetTemplateAssets  *sqlx.Stmt `query:"get-template-assets"`
+	GetMediaTemplates  *sqlx.Stmt `query:"get-media-templates"`
+	GetTags            *sqlx.Stmt `query:"get-tags"`
+	ExportTemplates    *sqlx.Stmt `query:"export-templates"`
+	UpsertTemplate     *sqlx.Stmt `query:"upsert-template-by-uuid"`
+
+	GetSequences              *sqlx.Stmt `query:"get-sequences"`
+	CreateSequence            *sqlx.Stmt `query:"create-sequence"`
+	UpdateSequence            *sqlx.Stmt `query:"update-sequence"`
+	DeleteSequence            *sqlx.Stmt `query:"delete-sequence"`
+	EnrollSequenceSubscribers *sqlx.Stmt `query:"enroll-sequence-subscribers"`
+	NextSequenceMessages      *sqlx.Stmt `query:"next-sequence-messages"`
+	RecordSequenceSends       *sqlx.Stmt `query:"record-sequence-sends"`
+	ReleaseSequenceMessages   *sqlx.Stmt `query:"release-sequence-messages"`
+
+	GetSenderIdentities    *sqlx.Stmt `query:"get-sender-identities"`
+	CreateSenderIdentity   *sqlx.Stmt `query:"create-sender-identity"`
+	SetSenderIdentityToken *sqlx.Stmt `query:"set-sender-identity-token"`
+	VerifySenderIdentity   *sqlx.Stmt `query:"verify-sender-identity"`
+	DeleteSenderIdentity   *sqlx.Stmt `query:"delete-sender-identity"`
 
 	CreateLink        *sqlx.Stmt `query:"create-link"`
 	RegisterLinkClick *sqlx.Stmt `query:"register-link-click"`
+	GetShortLink      *sqlx.Stmt `query:"get-short-link"`
 
 	GetSettings         *sqlx.Stmt `query:"get-settings"`
 	UpdateSettings      *sqlx.Stmt `query:"update-settings"`
 	UpdateSettingsByKey *sqlx.Stmt `query:"update-settings-by-key"`
+	LockSettings        *sqlx.Stmt `query:"lock-settings"`
+	GetSettingsVersion  *sqlx.Stmt `query:"get-settings-version"`
+	BumpSettingsVersion *sqlx.Stmt `query:"bump-settings-version"`
 
 	// GetStats *sqlx.Stmt `query:"get-stats"`
 	RecordBounce                *sqlx.Stmt `query:"record-bounce"`
@@ -115,6 +212,7 @@ type Queries struct {
 	DeleteBounces               *sqlx.Stmt `query:"delete-bounces"`
 	DeleteBouncesBySubscriber   *sqlx.Stmt `query:"delete-bounces-by-subscriber"`
 	GetDBInfo                   string     `query:"get-db-info"`
+	GetDBSize                   *sqlx.Stmt `query:"get-db-size"`
 
 	CreateUser        *sqlx.Stmt `query:"create-user"`
 	UpdateUser        *sqlx.Stmt `query:"update-user"`
@@ -124,9 +222,31 @@ type Queries struct {
 	DeleteUsers       *sqlx.Stmt `query:"delete-users"`
 	GetUsers          *sqlx.Stmt `query:"get-users"`
 	GetUser           *sqlx.Stmt `query:"get-user"`
+	GetUserLangs      *sqlx.Stmt `query:"get-user-langs"`
 	GetAPITokens      *sqlx.Stmt `query:"get-api-tokens"`
 	LoginUser         *sqlx.Stmt `query:"login-user"`
 
+	InsertLoginEvent            *sqlx.Stmt `query:"insert-login-event"`
+	UpsertAPITokenNetwork       *sqlx.Stmt `query:"upsert-api-token-network"`
+	GetFailedLoginCounts        *sqlx.Stmt `query:"get-failed-login-counts"`
+	GetNewAPITokenNetworks      *sqlx.Stmt `query:"get-new-api-token-networks"`
+	MarkAPITokenNetworksAlerted *sqlx.Stmt `query:"mark-api-token-networks-alerted"`
+	DeleteLoginEvents           *sqlx.Stmt `query:"delete-login-events"`
+	GetLastSecurityAlerts       *sqlx.Stmt `query:"get-last-security-alerts"`
+	InsertSecurityAlert         *sqlx.Stmt `query:"insert-security-alert"`
+	QuerySecurityAlerts         *sqlx.Stmt `query:"query-security-alerts"`
+
+	CreateImportJob         *sqlx.Stmt `query:"create-import-job"`
+	NextImportJob           *sqlx.Stmt `query:"next-import-job"`
+	GetImportJob            *sqlx.Stmt `query:"get-import-job"`
+	QueryImportJobs         *sqlx.Stmt `query:"query-import-jobs"`
+	CountQueuedImportJobs   *sqlx.Stmt `query:"count-queued-import-jobs"`
+	UpdateImportJobProgress *sqlx.Stmt `query:"update-import-job-progress"`
+	UpdateImportJobStatus   *sqlx.Stmt `query:"update-import-job-status"`
+	StopQueuedImportJob     *sqlx.Stmt `query:"stop-queued-import-job"`
+	InsertImportJobError    *sqlx.Stmt `query:"insert-import-job-error"`
+	GetImportJobErrors      *sqlx.Stmt `query:"get-import-job-errors"`
+
 	CreateRole            *sqlx.Stmt `query:"create-role"`
 	GetUserRoles          *sqlx.Stmt `query:"get-user-roles"`
 	GetListRoles          *sqlx.Stmt `query:"get-list-roles"`
@@ -141,13 +261,17 @@ type Queries struct {
 // out of it using the raw `query-subscribers-template` query template.
 // While doing this, a readonly transaction is created and the query is
 // dry run on it to ensure that it is indeed readonly.
-func (q *Queries) compileSubscriberQueryTpl(searchStr, queryExp string, db *sqlx.DB, subStatus string) (string, error) {
+func (q *Queries) compileSubscriberQueryTpl(searchStr, queryExp string, db *sqlx.DB, subStatus string, timeout time.Duration) (string, error) {
 	tx, err := db.BeginTxx(context.Background(), &sql.TxOptions{ReadOnly: true})
 	if err != nil {
 		return "", err
 	}
 	defer tx.Rollback()
 
+	if err := SetStatementTimeout(tx, timeout); err != nil {
+		return "", err
+	}
+
 	// There's an arbitrary query condition.
 	cond := "TRUE"
 	if queryExp != "" {
@@ -165,10 +289,10 @@ func (q *Queries) compileSubscriberQueryTpl(searchStr, queryExp string, db *sqlx
 
 // compileSubscriberQueryTpl takes an arbitrary WHERE expressions and a subscriber
 // query template that depends on the filter (eg: delete by query, blocklist by query etc.)
-// combines and executes them.
-func (q *Queries) ExecSubQueryTpl(searchStr, queryExp, baseQueryTpl string, listIDs []int, db *sqlx.DB, subStatus string, args ...any) error {
+// combines and executes them. A timeout > 0 limits the execution time of the queries.
+func (q *Queries) ExecSubQueryTpl(searchStr, queryExp, baseQueryTpl string, listIDs []int, db *sqlx.DB, subStatus string, timeout time.Duration, args ...any) error {
 	// Perform a dry run.
-	filterExp, err := q.compileSubscriberQueryTpl(searchStr, queryExp, db, subStatus)
+	filterExp, err := q.compileSubscriberQueryTpl(searchStr, queryExp, db, subStatus, timeout)
 	if err != nil {
 		return err
 	}
@@ -184,8 +308,29 @@ func (q *Queries) ExecSubQueryTpl(searchStr, queryExp, baseQueryTpl string, list
 	a := append([]any{false, pq.Array(listIDs), subStatus, searchStr}, args...)
 
 	// Execute the query on the DB.
-	if _, err := db.Exec(stmt, a...); err != nil {
+	tx, err := db.Beginx()
+	if err != nil {
 		return err
 	}
-	return nil
+	defer tx.Rollback()
+
+	if err := SetStatementTimeout(tx, timeout); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(stmt, a...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetStatementTimeout overrides the connection's statement_timeout for the
+// rest of a transaction. A timeout <= 0 leaves it untouched.
+func SetStatementTimeout(tx *sqlx.Tx, timeout time.Duration) error {
+	if timeout <= 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", timeout.Milliseconds()))
+	return err
 }
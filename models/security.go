@@ -0,0 +1,42 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+// Security alert rules.
+const (
+	SecurityAlertFailedLogins    = "failed_logins"
+	SecurityAlertNewTokenNetwork = "new_token_network"
+)
+
+// SecurityAlert represents a security alert that was fired. Data is the list
+// of FailedLogins or TokenNetwork that triggered the rule.
+type SecurityAlert struct {
+	ID        int             `db:"id" json:"id"`
+	Rule      string          `db:"rule" json:"rule"`
+	Data      json.RawMessage `db:"data" json:"data"`
+	CreatedAt null.Time       `db:"created_at" json:"created_at"`
+
+	// Pseudofield for getting the total number of alerts in paginated queries.
+	Total int `db:"total" json:"-"`
+}
+
+// FailedLogins is the number of failed logins of a username or from an IP.
+type FailedLogins struct {
+	// username or ip.
+	Type  string `db:"type" json:"type"`
+	Value string `db:"value" json:"value"`
+	Count int    `db:"count" json:"count"`
+}
+
+// TokenNetwork is a network that an API token has been used from for the first time.
+type TokenNetwork struct {
+	Username  string    `db:"username" json:"username"`
+	Network   string    `db:"network" json:"network"`
+	IP        string    `db:"ip" json:"ip"`
+	FirstSeen time.Time `db:"first_seen" json:"first_seen"`
+}
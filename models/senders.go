@@ -0,0 +1,40 @@
+package models
+
+import (
+	"strings"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	SenderIdentityTypeAddress = "address"
+	SenderIdentityTypeDomain  = "domain"
+)
+
+// SenderIdentity represents an e-mail address or a whole domain that campaigns
+// are allowed to be sent from.
+type SenderIdentity struct {
+	Base
+
+	Type       string    `db:"type" json:"type"`
+	Value      string    `db:"value" json:"value"`
+	Verified   bool      `db:"verified" json:"verified"`
+	VerifiedAt null.Time `db:"verified_at" json:"verified_at"`
+
+	VerifyToken null.String `db:"verify_token" json:"-"`
+}
+
+// Matches checks whether the given e-mail address is covered by the identity,
+// case-insensitively. An address identity matches the exact address and a domain
+// identity matches any address on the domain, but not on its subdomains.
+func (s SenderIdentity) Matches(email string) bool {
+	switch s.Type {
+	case SenderIdentityTypeAddress:
+		return strings.EqualFold(email, s.Value)
+	case SenderIdentityTypeDomain:
+		_, domain, ok := strings.Cut(email, "@")
+		return ok && strings.EqualFold(domain, s.Value)
+	}
+
+	return false
+}
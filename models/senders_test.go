@@ -0,0 +1,37 @@
+package models
+
+import "testing"
+
+func TestSenderIdentityMatches(t *testing.T) {
+	var (
+		addr = SenderIdentity{Type: SenderIdentityTypeAddress, Value: "news@example.com"}
+		dom  = SenderIdentity{Type: SenderIdentityTypeDomain, Value: "example.com"}
+	)
+
+	cases := []struct {
+		name  string
+		id    SenderIdentity
+		email string
+		exp   bool
+	}{
+		{"exact address", addr, "news@example.com", true},
+		{"address case", addr, "News@Example.COM", true},
+		{"other address on the domain", addr, "sales@example.com", false},
+		{"address on a subdomain", addr, "news@mail.example.com", false},
+		{"address as a suffix", addr, "thenews@example.com", false},
+
+		{"domain", dom, "anyone@example.com", true},
+		{"domain case", dom, "Anyone@EXAMPLE.com", true},
+		{"subdomain", dom, "anyone@mail.example.com", false},
+		{"domain as a suffix", dom, "anyone@badexample.com", false},
+		{"parent domain", SenderIdentity{Type: SenderIdentityTypeDomain, Value: "mail.example.com"}, "anyone@example.com", false},
+		{"no domain", dom, "example.com", false},
+
+		{"unknown type", SenderIdentity{Type: "other", Value: "news@example.com"}, "news@example.com", false},
+	}
+	for _, c := range cases {
+		if got := c.id.Matches(c.email); got != c.exp {
+			t.Errorf("%s: expected %v, got %v", c.name, c.exp, got)
+		}
+	}
+}
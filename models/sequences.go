@@ -0,0 +1,71 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+
+	null "gopkg.in/volatiletech/null.v6"
+)
+
+const (
+	SequenceStatusActive = "active"
+	SequenceStatusPaused = "paused"
+
+	SequenceStateActive    = "active"
+	SequenceStateFinished  = "finished"
+	SequenceStateCancelled = "cancelled"
+)
+
+// Sequence represents a drip sequence of campaigns that are sent to subscribers
+// at fixed delays after they subscribe to a list.
+type Sequence struct {
+	Base
+
+	UUID     string        `db:"uuid" json:"uuid"`
+	Name     string        `db:"name" json:"name"`
+	ListID   int           `db:"list_id" json:"list_id"`
+	ListName string        `db:"list_name" json:"list_name"`
+	Status   string        `db:"status" json:"status"`
+	Steps    SequenceSteps `db:"steps" json:"steps"`
+
+	// Number of enrolled subscribers by their state in the sequence.
+	Stats StringIntMap `db:"stats" json:"stats"`
+
+	// Subscriptions on the list after this are enrolled into the sequence.
+	EnrolledAt null.Time `db:"enrolled_at" json:"enrolled_at"`
+}
+
+// SequenceStep is a single campaign in a sequence.
+type SequenceStep struct {
+	CampaignID   int    `json:"campaign_id"`
+	CampaignName string `json:"campaign_name"`
+
+	// Delay after the subscription at which the step is sent, as a duration
+	// string (eg: 72h). DelaySecs is the same in seconds.
+	Delay     string `json:"delay"`
+	DelaySecs int    `json:"delay_secs"`
+}
+
+// SequenceSteps represents a list of sequence steps.
+type SequenceSteps []SequenceStep
+
+// SequenceMessage is a subscriber due to receive a step (campaign) in a sequence.
+type SequenceMessage struct {
+	Subscriber
+
+	SequenceID int `db:"sequence_id"`
+	CampaignID int `db:"campaign_id"`
+
+	// Position of the step, which the subscriber is moved back to if the
+	// message fails to be sent.
+	Step int `db:"step"`
+}
+
+// Scan unmarshals JSONB from the DB.
+func (s *SequenceSteps) Scan(src any) error {
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, s)
+	}
+
+	return fmt.Errorf("could not not decode type %T -> %T", src, s)
+}
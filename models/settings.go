@@ -4,11 +4,19 @@ import "gopkg.in/volatiletech/null.v6"
 
 // Settings represents the app settings stored in the DB.
 type Settings struct {
+	// Version of the settings. Updates with a stale version are rejected
+	// so that concurrent saves don't silently overwrite each other.
+	Version int `json:"version"`
+
 	AppSiteName                   string   `json:"app.site_name"`
 	AppRootURL                    string   `json:"app.root_url"`
+	AppShortLinkURL               string   `json:"app.short_link_url"`
 	AppLogoURL                    string   `json:"app.logo_url"`
 	AppFaviconURL                 string   `json:"app.favicon_url"`
 	AppFromEmail                  string   `json:"app.from_email"`
+	AppReplyTo                    string   `json:"app.reply_to"`
+	AppEmailHeaders               Headers  `json:"app.email_headers"`
+	AppPublicTemplatesDir         string   `json:"app.public_templates_dir"`
 	AppNotifyEmails               []string `json:"app.notify_emails"`
 	EnablePublicSubPage           bool     `json:"app.enable_public_subscription_page"`
 	EnablePublicArchive           bool     `json:"app.enable_public_archive"`
@@ -17,13 +25,42 @@ type Settings struct {
 	CheckUpdates                  bool     `json:"app.check_updates"`
 	AppLang                       string   `json:"app.lang"`
 
+	AppUTM CampaignUTM `json:"app.utm"`
+
+	AppMessengerRoutes []MessengerRoute `json:"app.messenger_routes"`
+
 	AppBatchSize             int    `json:"app.batch_size"`
 	AppConcurrency           int    `json:"app.concurrency"`
 	AppMaxSendErrors         int    `json:"app.max_send_errors"`
+	AppMaxCampaignRuntime    string `json:"app.max_campaign_runtime"`
 	AppMessageRate           int    `json:"app.message_rate"`
 	CacheSlowQueries         bool   `json:"app.cache_slow_queries"`
 	CacheSlowQueriesInterval string `json:"app.cache_slow_queries_interval"`
 
+	AppQueryGuard QueryGuard `json:"app.query_guard"`
+
+	AppDigest DigestSettings `json:"app.digest"`
+
+	AppTxConcurrency int `json:"app.tx_concurrency"`
+	AppTxQueueSize   int `json:"app.tx_queue_size"`
+
+	AppSeedEmails      []string `json:"app.seed_emails"`
+	AppRequireSeedSend bool     `json:"app.require_seed_send"`
+
+	AppRequireCampaignApproval bool `json:"app.require_campaign_approval"`
+
+	AppAdhocRecipientsRetention string `json:"app.adhoc_recipients_retention"`
+	AppCampaignStatsRollupAfter string `json:"app.campaign_stats_rollup_after"`
+
+	AppTrackedAttribs         []string `json:"app.tracked_attribs"`
+	AppAttribHistoryRetention string   `json:"app.attrib_history_retention"`
+
+	AppProgressMilestones []int `json:"app.progress_milestones"`
+
+	AppAttachmentFetch AttachmentFetch `json:"app.attachment_fetch"`
+
+	AppMaxMessageSizeMB int `json:"app.max_message_size_mb"`
+
 	AppMessageSlidingWindow         bool   `json:"app.message_sliding_window"`
 	AppMessageSlidingWindowDuration string `json:"app.message_sliding_window_duration"`
 	AppMessageSlidingWindowRate     int    `json:"app.message_sliding_window_rate"`
@@ -34,10 +71,37 @@ type Settings struct {
 	PrivacyAllowPreferences   bool     `json:"privacy.allow_preferences"`
 	PrivacyAllowExport        bool     `json:"privacy.allow_export"`
 	PrivacyAllowWipe          bool     `json:"privacy.allow_wipe"`
+	PrivacyDeletionGraceDays  int      `json:"privacy.deletion_grace_days"`
 	PrivacyExportable         []string `json:"privacy.exportable"`
 	PrivacyRecordOptinIP      bool     `json:"privacy.record_optin_ip"`
-	DomainBlocklist           []string `json:"privacy.domain_blocklist"`
-	DomainAllowlist           []string `json:"privacy.domain_allowlist"`
+
+	PrivacyUnsubscribeReasons UnsubscribeReasons `json:"privacy.unsubscribe_reasons"`
+	PrivacyTrackingMode       string             `json:"privacy.tracking_mode"`
+	PrivacyLinkAttribs        []string           `json:"privacy.link_attribs"`
+	PrivacyBotFilter          struct {
+		Enabled     bool     `json:"enabled"`
+		UserAgents  []string `json:"user_agents"`
+		GraceWindow string   `json:"grace_window"`
+		BurstWindow string   `json:"burst_window"`
+		BurstCount  int      `json:"burst_count"`
+	} `json:"privacy.bot_filter"`
+	DomainBlocklist []string `json:"privacy.domain_blocklist"`
+	DomainAllowlist []string `json:"privacy.domain_allowlist"`
+
+	PrivacyEmailVerification struct {
+		Syntax     bool   `json:"syntax"`
+		MX         bool   `json:"mx"`
+		Typos      bool   `json:"typos"`
+		DNSTimeout string `json:"dns_timeout"`
+		OnAPI      bool   `json:"on_api"`
+	} `json:"privacy.email_verification"`
+
+	PrivacyEmailNormalization struct {
+		StripDots bool `json:"strip_dots"`
+		StripPlus bool `json:"strip_plus"`
+	} `json:"privacy.email_normalization"`
+
+	PrivacyPurgeUnconfirmedAction string `json:"privacy.purge_unconfirmed_action"`
 
 	SecurityCaptcha struct {
 		Altcha struct {
@@ -64,6 +128,27 @@ type Settings struct {
 
 	SecurityCORSOrigins []string `json:"security.cors_origins"`
 
+	SecurityPublicRateLimit int      `json:"security.public_rate_limit"`
+	SecurityTrustedProxies  []string `json:"security.trusted_proxies"`
+
+	SecuritySenderIdentities struct {
+		Enabled             bool `json:"enabled"`
+		RequireVerification bool `json:"require_verification"`
+	} `json:"security.sender_identities"`
+
+	SecurityAlerts struct {
+		FailedLogins struct {
+			Enabled   bool   `json:"enabled"`
+			Threshold int    `json:"threshold"`
+			Window    string `json:"window"`
+			Cooldown  string `json:"cooldown"`
+		} `json:"failed_logins"`
+		NewTokenNetwork struct {
+			Enabled  bool   `json:"enabled"`
+			Cooldown string `json:"cooldown"`
+		} `json:"new_token_network"`
+	} `json:"security.alerts"`
+
 	UploadProvider             string   `json:"upload.provider"`
 	UploadExtensions           []string `json:"upload.extensions"`
 	UploadFilesystemUploadPath string   `json:"upload.filesystem.upload_path"`
@@ -78,6 +163,19 @@ type Settings struct {
 	UploadS3BucketPath         string   `json:"upload.s3.bucket_path"`
 	UploadS3BucketType         string   `json:"upload.s3.bucket_type"`
 	UploadS3Expiry             string   `json:"upload.s3.expiry"`
+	UploadSFTPHost             string   `json:"upload.sftp.host"`
+	UploadSFTPPort             int      `json:"upload.sftp.port"`
+	UploadSFTPUsername         string   `json:"upload.sftp.username"`
+	UploadSFTPPassword         string   `json:"upload.sftp.password,omitempty"`
+	UploadSFTPPrivateKey       string   `json:"upload.sftp.private_key,omitempty"`
+	UploadSFTPHostKey          string   `json:"upload.sftp.host_key"`
+	UploadSFTPPath             string   `json:"upload.sftp.path"`
+	UploadSFTPPublicURL        string   `json:"upload.sftp.public_url"`
+	UploadSFTPMaxConns         int      `json:"upload.sftp.max_conns"`
+	UploadWebDAVURL            string   `json:"upload.webdav.url"`
+	UploadWebDAVUsername       string   `json:"upload.webdav.username"`
+	UploadWebDAVPassword       string   `json:"upload.webdav.password,omitempty"`
+	UploadWebDAVPublicURL      string   `json:"upload.webdav.public_url"`
 
 	SMTP []struct {
 		Name          string              `json:"name"`
@@ -96,6 +194,25 @@ type Settings struct {
 		WaitTimeout   string              `json:"wait_timeout"`
 		TLSType       string              `json:"tls_type"`
 		TLSSkipVerify bool                `json:"tls_skip_verify"`
+
+		// Optional envelope sender (MAIL FROM) of all e-mails sent via the
+		// server. By default, it's the message's From address.
+		EnvelopeFrom string `json:"envelope_from"`
+
+		// Maximum message size in MB. 0 uses app.max_message_size_mb.
+		MaxMessageSizeMB int `json:"max_message_size_mb"`
+
+		// Provider whose published sending limits apply, eg: gmail. The limits
+		// can be overridden with SendLimit.
+		Provider  string `json:"provider"`
+		SendLimit struct {
+			PerDay    int `json:"per_day"`
+			PerMinute int `json:"per_minute"`
+		} `json:"send_limit"`
+
+		// Share of the messages relative to the other servers. 0 on all the
+		// servers weights them equally. Otherwise, 0 is only used on failover.
+		Weight int `json:"weight"`
 	} `json:"smtp"`
 
 	Messengers []struct {
@@ -110,16 +227,23 @@ type Settings struct {
 		MaxMsgRetries int    `json:"max_msg_retries"`
 	} `json:"messengers"`
 
-	BounceEnabled        bool `json:"bounce.enabled"`
-	BounceEnableWebhooks bool `json:"bounce.webhooks_enabled"`
-	BounceActions        map[string]struct {
-		Count  int    `json:"count"`
-		Action string `json:"action"`
-	} `json:"bounce.actions"`
-	SESEnabled      bool   `json:"bounce.ses_enabled"`
-	SendgridEnabled bool   `json:"bounce.sendgrid_enabled"`
-	SendgridKey     string `json:"bounce.sendgrid_key"`
-	BouncePostmark  struct {
+	Webhooks []struct {
+		UUID    string   `json:"uuid"`
+		Enabled bool     `json:"enabled"`
+		Name    string   `json:"name"`
+		URL     string   `json:"url"`
+		Secret  string   `json:"secret,omitempty"`
+		Events  []string `json:"events"`
+	} `json:"webhooks"`
+
+	BounceEnabled         bool                    `json:"bounce.enabled"`
+	BounceEnableWebhooks  bool                    `json:"bounce.webhooks_enabled"`
+	BounceWebhooksLogOnly bool                    `json:"bounce.webhooks_log_only"`
+	BounceActions         map[string]BounceAction `json:"bounce.actions"`
+	SESEnabled            bool                    `json:"bounce.ses_enabled"`
+	SendgridEnabled       bool                    `json:"bounce.sendgrid_enabled"`
+	SendgridKey           string                  `json:"bounce.sendgrid_key"`
+	BouncePostmark        struct {
 		Enabled  bool   `json:"enabled"`
 		Username string `json:"username"`
 		Password string `json:"password"`
@@ -128,6 +252,22 @@ type Settings struct {
 		Enabled bool   `json:"enabled"`
 		Key     string `json:"key"`
 	} `json:"bounce.forwardemail"`
+	BounceWebhookAllowlist struct {
+		AuditOnly      bool                `json:"audit_only"`
+		TrustedProxies []string            `json:"trusted_proxies"`
+		AllowedCIDRs   map[string][]string `json:"allowed_cidrs"`
+	} `json:"bounce.webhook_allowlist"`
+	BounceReplyUnsubscribe struct {
+		Enabled  bool                `json:"enabled"`
+		Keywords map[string][]string `json:"keywords"`
+	} `json:"bounce.reply_unsubscribe"`
+	BounceVERP struct {
+		Enabled bool   `json:"enabled"`
+		Domain  string `json:"domain"`
+		Prefix  string `json:"prefix"`
+		Scheme  string `json:"scheme"`
+		Secret  string `json:"secret"`
+	} `json:"bounce.verp"`
 	BounceBoxes []struct {
 		UUID          string `json:"uuid"`
 		Enabled       bool   `json:"enabled"`
@@ -148,8 +288,30 @@ type Settings struct {
 		VacuumInterval string `json:"vacuum_cron_interval"`
 	} `json:"maintenance.db"`
 
+	MaintenanceSunset SunsetPolicy `json:"maintenance.sunset"`
+
+	MaintenanceStorage struct {
+		DiskThreshold int    `json:"disk_threshold"`
+		DBThresholdMB int    `json:"db_threshold_mb"`
+		Cooldown      string `json:"cooldown"`
+	} `json:"maintenance.storage"`
+
 	AdminCustomCSS  string `json:"appearance.admin.custom_css"`
 	AdminCustomJS   string `json:"appearance.admin.custom_js"`
 	PublicCustomCSS string `json:"appearance.public.custom_css"`
 	PublicCustomJS  string `json:"appearance.public.custom_js"`
 }
+
+// MessengerRoute overrides the messenger of campaign messages to recipients
+// whose e-mail domain matches Domain, which is either an exact domain or a
+// suffix wildcard, eg: *.outlook.com.
+type MessengerRoute struct {
+	Domain    string `json:"domain"`
+	Messenger string `json:"messenger"`
+}
+
+// MessengerRouteCount is the number of a campaign's messages sent via a route.
+type MessengerRouteCount struct {
+	MessengerRoute
+	Count int64 `json:"count"`
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/jmoiron/sqlx/types"
@@ -16,9 +17,15 @@ const (
 	SubscriberStatusDisabled    = "disabled"
 	SubscriberStatusBlockListed = "blocklisted"
 
+	// Pseudo status for querying subscribers whose data deletion is pending.
+	SubscriberStatusPendingDeletion = "pending_deletion"
+
 	SubscriptionStatusUnconfirmed  = "unconfirmed"
 	SubscriptionStatusConfirmed    = "confirmed"
 	SubscriptionStatusUnsubscribed = "unsubscribed"
+
+	QueryGuardActionConfirm = "confirm"
+	QueryGuardActionRefuse  = "refuse"
 )
 
 // Subscribers represents a slice of Subscriber.
@@ -34,6 +41,78 @@ type Subscriber struct {
 	Attribs JSON           `db:"attribs" json:"attribs"`
 	Status  string         `db:"status" json:"status"`
 	Lists   types.JSONText `db:"lists" json:"lists"`
+
+	// When the subscriber requested the deletion of their data, if pending.
+	DeletionRequestedAt null.Time `db:"deletion_requested_at" json:"deletion_requested_at"`
+
+	// Result of the last bulk e-mail verification: valid, risky, invalid, or unknown.
+	VerificationStatus null.String `db:"verification_status" json:"verification_status"`
+	LastVerifiedAt     null.Time   `db:"last_verified_at" json:"last_verified_at"`
+}
+
+// SubscriberFilter represents first-class subscriber filters on bounce history
+// and engagement recency that are translated into a query expression.
+type SubscriberFilter struct {
+	// Minimum number of bounces (of BounceType, if set).
+	MinBounces int    `json:"min_bounces"`
+	BounceType string `json:"bounce_type"`
+
+	// Subscribers with no (non-bot) opens or clicks on or after the date.
+	// Subscribers who have never opened or clicked also match.
+	LastOpenBefore  string `json:"last_open_before"`
+	LastClickBefore string `json:"last_click_before"`
+
+	// Subscribers who have never opened a campaign.
+	NeverOpened bool `json:"never_opened"`
+
+	// Subscribers whose data deletion is pending.
+	PendingDeletion bool `json:"pending_deletion"`
+
+	// Subscribers with the bulk e-mail verification status.
+	VerificationStatus string `json:"verification_status"`
+}
+
+// IsEmpty returns true if none of the filters are set.
+func (f SubscriberFilter) IsEmpty() bool {
+	return f == SubscriberFilter{}
+}
+
+// EmailDuplicate represents a group of subscribers whose e-mails have the same
+// normalized form. The subscribers are ordered oldest first.
+type EmailDuplicate struct {
+	Email  string         `db:"email" json:"email"`
+	IDs    pq.Int64Array  `db:"ids" json:"ids"`
+	Emails pq.StringArray `db:"emails" json:"emails"`
+}
+
+// EmailNormalizationResult represents the results of normalizing subscriber e-mails.
+type EmailNormalizationResult struct {
+	Duplicates []EmailDuplicate `json:"duplicates"`
+
+	// Number of subscribers merged into others and normalized e-mails updated.
+	Merged  int `json:"merged"`
+	Updated int `json:"updated"`
+}
+
+// QueryGuard represents the limits on ad-hoc subscriber SQL queries.
+type QueryGuard struct {
+	Enabled bool `json:"enabled"`
+
+	// Maximum planner cost and number of rows estimated by EXPLAIN.
+	MaxCost float64 `json:"max_cost"`
+	MaxRows float64 `json:"max_rows"`
+
+	// Action on queries that exceed the limits. One of QueryGuardAction*.
+	Action string `json:"action"`
+
+	// Statement timeout of ad-hoc queries, eg: 30s.
+	Timeout string `json:"timeout"`
+}
+
+// QueryEstimate represents the planner's estimates of an ad-hoc subscriber query.
+type QueryEstimate struct {
+	Cost float64 `json:"cost"`
+	Rows float64 `json:"rows"`
 }
 
 type subLists struct {
@@ -109,6 +188,40 @@ type Subscription struct {
 	Meta                  json.RawMessage `db:"meta" json:"meta"`
 }
 
+// Results of subscription item changes.
+const (
+	SubscriptionResultOK                  = "ok"
+	SubscriptionResultAlreadyMember       = "skipped_already_member"
+	SubscriptionResultNotMember           = "skipped_not_member"
+	SubscriptionResultAlreadyUnsubscribed = "skipped_already_unsubscribed"
+	SubscriptionResultNotFound            = "not_found"
+	SubscriptionResultBlocked             = "blocked"
+	SubscriptionResultForbidden           = "forbidden"
+)
+
+// SubscriptionItem represents a change to a subscriber's list subscription.
+type SubscriptionItem struct {
+	SubscriberID int `json:"subscriber_id"`
+	ListID       int `json:"list_id"`
+
+	// add, remove, or unsubscribe.
+	Action string `json:"action"`
+
+	// Subscription status of the add action. If empty, new subscriptions
+	// are unconfirmed and existing ones are retained as is.
+	Status string `json:"status"`
+}
+
+// SubscriptionItemResult represents the result of a SubscriptionItem.
+type SubscriptionItemResult struct {
+	SubscriberID int    `db:"subscriber_id" json:"subscriber_id"`
+	ListID       int    `db:"list_id" json:"list_id"`
+	Action       string `db:"action" json:"action"`
+
+	// One of SubscriptionResult*.
+	Result string `db:"result" json:"result"`
+}
+
 // SubscriberExport represents a subscriber record that is exported to raw data.
 type SubscriberExport struct {
 	Base
@@ -129,8 +242,58 @@ type SubscriberExportProfile struct {
 	LinkClicks    json.RawMessage `db:"link_clicks" json:"link_clicks,omitempty"`
 }
 
+// SubscriberSend represents a campaign sent to a subscriber and the bounce,
+// if any, that followed it.
+type SubscriberSend struct {
+	CampaignID      int         `db:"campaign_id" json:"campaign_id"`
+	CampaignUUID    string      `db:"campaign_uuid" json:"campaign_uuid"`
+	CampaignName    string      `db:"campaign_name" json:"campaign_name"`
+	CampaignSubject string      `db:"campaign_subject" json:"campaign_subject"`
+	Messenger       string      `db:"messenger" json:"messenger"`
+	SentAt          time.Time   `db:"sent_at" json:"sent_at"`
+	BounceType      null.String `db:"bounce_type" json:"bounce_type"`
+	BouncedAt       null.Time   `db:"bounced_at" json:"bounced_at"`
+
+	// Pseudofield for getting the total number of sends in paginated queries.
+	Total int `db:"total" json:"-"`
+}
+
 // SubscriberActivity represents a subscriber's campaign views and link clicks for the Activity tab.
 type SubscriberActivity struct {
 	CampaignViews json.RawMessage `db:"campaign_views" json:"campaign_views"`
 	LinkClicks    json.RawMessage `db:"link_clicks" json:"link_clicks"`
+
+	// Changes to the values of the tracked attributes, latest first.
+	AttribChanges json.RawMessage `db:"attrib_changes" json:"attrib_changes"`
+}
+
+// UnsubscribeSourceEmailReply is the source of unsubscriptions requested by
+// replying to campaigns. Unsubscriptions from the public unsubscribe page and
+// API have no source.
+const UnsubscribeSourceEmailReply = "email-reply"
+
+// UnsubscribeReasons represents the optional reason choices shown on the
+// public unsubscribe page.
+type UnsubscribeReasons struct {
+	Enabled bool     `json:"enabled"`
+	Choices []string `json:"choices"`
+
+	// AllowText allows subscribers to enter a free text comment.
+	AllowText bool `json:"allow_text"`
+}
+
+// UnsubscribeReasonStats represents the aggregated unsubscribe reasons of a
+// campaign or all campaigns.
+type UnsubscribeReasonStats struct {
+	Total int `db:"total" json:"total"`
+
+	// Number of unsubscriptions per reason [{reason, count}]. The reason is
+	// empty for unsubscriptions where it was skipped.
+	Reasons json.RawMessage `db:"reasons" json:"reasons"`
+
+	// Latest free text comments [{comment, created_at}].
+	Comments json.RawMessage `db:"comments" json:"comments"`
+
+	// Number of unsubscriptions per source [{source, count}].
+	Sources json.RawMessage `db:"sources" json:"sources"`
 }
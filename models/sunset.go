@@ -0,0 +1,34 @@
+package models
+
+import "github.com/lib/pq"
+
+// SunsetPolicy represents the policy for sunsetting subscribers who haven't
+// engaged (viewed or clicked) with the campaigns sent to their lists in a while.
+type SunsetPolicy struct {
+	Enabled bool `json:"enabled"`
+
+	// A subscription is inactive if there's no engagement in the last InactiveDays
+	// (overridable per list) or across the last InactiveCampaigns campaigns on the list.
+	InactiveDays      int `json:"inactive_days"`
+	InactiveCampaigns int `json:"inactive_campaigns"`
+
+	// Optional campaign sent to inactive subscribers before they're sunset after GraceDays.
+	FinalCampaignID int `json:"final_campaign_id"`
+	GraceDays       int `json:"grace_days"`
+
+	// Optional list that sunset subscribers are moved to and whether they're
+	// unsubscribed from the lists they're inactive on.
+	DormantListID int  `json:"dormant_list_id"`
+	Unsubscribe   bool `json:"unsubscribe"`
+}
+
+// SunsetResult represents the counts of a sunset policy run.
+type SunsetResult struct {
+	InactiveSubscriptions int `db:"inactive_subscriptions" json:"inactive_subscriptions"`
+	InactiveSubscribers   int `db:"inactive_subscribers" json:"inactive_subscribers"`
+	Notified              int `db:"notified" json:"notified"`
+	Sunset                int `db:"sunset" json:"sunset"`
+
+	// IDs of the subscribers to send the final campaign to.
+	NotifyIDs pq.Int64Array `db:"notify_ids" json:"-"`
+}
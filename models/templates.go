@@ -1,12 +1,15 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"strings"
 	txttpl "text/template"
 	"time"
 
+	"github.com/lib/pq"
 	null "gopkg.in/volatiletech/null.v6"
 )
 
@@ -22,19 +25,37 @@ const (
 type Template struct {
 	Base
 
+	UUID string `db:"uuid" json:"uuid"`
 	Name string `db:"name" json:"name"`
 	// Subject is only for type=tx.
-	Subject    string      `db:"subject" json:"subject"`
-	Type       string      `db:"type" json:"type"`
-	Body       string      `db:"body" json:"body,omitempty"`
-	BodySource null.String `db:"body_source" json:"body_source,omitempty"`
-	IsDefault  bool        `db:"is_default" json:"is_default"`
+	Subject    string         `db:"subject" json:"subject"`
+	Type       string         `db:"type" json:"type"`
+	Body       string         `db:"body" json:"body,omitempty"`
+	BodySource null.String    `db:"body_source" json:"body_source,omitempty"`
+	IsDefault  bool           `db:"is_default" json:"is_default"`
+	Tags       pq.StringArray `db:"tags" json:"tags"`
+
+	// Media attached to campaign templates that are referenced in the body by
+	// name with {{ Asset "name" }}. A nil value leaves the assets untouched on update.
+	Assets TemplateAssets `db:"assets" json:"assets"`
+
+	// Inline CSS styles of the block elements of blocks campaigns that use the
+	// template, eg: {"button": "color: #fff;"}. A nil value leaves the styles untouched on update.
+	BlockStyles BlockStyles `db:"block_styles" json:"block_styles"`
 
 	// Only relevant to tx (transactional) templates.
 	SubjectTpl *txttpl.Template   `json:"-"`
 	Tpl        *template.Template `json:"-"`
 }
 
+// Tag represents a distinct tag along with its usage count across campaigns and templates.
+type Tag struct {
+	Tag       string `db:"tag" json:"tag"`
+	Count     int    `db:"count" json:"count"`
+	Campaigns int    `db:"campaigns" json:"campaigns"`
+	Templates int    `db:"templates" json:"templates"`
+}
+
 // Compile compiles a template body and subject (only for tx templates) and
 // caches the templat references to be executed later.
 func (t *Template) Compile(f template.FuncMap) error {
@@ -58,24 +79,119 @@ func (t *Template) Compile(f template.FuncMap) error {
 	return nil
 }
 
+// TemplateAsset represents a media item attached to a template.
+type TemplateAsset struct {
+	Name     string `json:"name"`
+	MediaID  int    `json:"media_id"`
+	Filename string `json:"filename,omitempty"`
+}
+
+// TemplateAssets is a list of template assets.
+type TemplateAssets []TemplateAsset
+
+// Scan unmarshals JSONB from the DB.
+func (t *TemplateAssets) Scan(src any) error {
+	if src == nil {
+		*t = TemplateAssets{}
+		return nil
+	}
+
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, t)
+	}
+
+	return fmt.Errorf("could not not decode type %T -> %T", src, t)
+}
+
+// BlockStyles are the inline CSS styles of the elements of blocks by their keys.
+type BlockStyles map[string]string
+
+// Scan unmarshals JSONB from the DB.
+func (b *BlockStyles) Scan(src any) error {
+	if src == nil {
+		*b = BlockStyles{}
+		return nil
+	}
+
+	if data, ok := src.([]byte); ok {
+		return json.Unmarshal(data, b)
+	}
+
+	return fmt.Errorf("could not not decode type %T -> %T", src, b)
+}
+
+// Value returns the JSON for the DB. A nil value is NULL.
+func (b BlockStyles) Value() (driver.Value, error) {
+	if b == nil {
+		return nil, nil
+	}
+
+	return json.Marshal(b)
+}
+
 type CampaignStats struct {
 	ID        int       `db:"id" json:"id"`
+	UUID      string    `db:"uuid" json:"uuid"`
 	Status    string    `db:"status" json:"status"`
 	ToSend    int       `db:"to_send" json:"to_send"`
 	Sent      int       `db:"sent" json:"sent"`
+	Excluded  int       `db:"excluded" json:"excluded"`
 	Started   null.Time `db:"started_at" json:"started_at"`
 	UpdatedAt null.Time `db:"updated_at" json:"updated_at"`
 	Rate      int       `json:"rate"`
 	NetRate   int       `json:"net_rate"`
+
+	Views        int `db:"views" json:"views"`
+	UniqueViews  int `db:"unique_views" json:"unique_views"`
+	Clicks       int `db:"clicks" json:"clicks"`
+	UniqueClicks int `db:"unique_clicks" json:"unique_clicks"`
+	Bounces      int `db:"bounces" json:"bounces"`
+
+	// Delivered is sent minus bounced. The rates are percentages of it.
+	Delivered int     `json:"delivered"`
+	OpenRate  float64 `json:"open_rate"`
+	ClickRate float64 `json:"click_rate"`
+
+	// Number of messages sent via each messenger route.
+	Routes []MessengerRouteCount `json:"routes"`
+
+	// Number of dynamic attachment fetches that failed in the current run.
+	AttachmentErrors int64 `json:"attachment_errors"`
+
+	// Seconds left in the current run before the campaign is paused for exceeding
+	// its max runtime. Null if there's no limit.
+	RuntimeRemaining null.Int `json:"runtime_remaining"`
+
+	// Live bounce counts from the bounce processor while the campaign is running
+	// and the percentage of sent messages that have bounced.
+	LiveBounces *BounceCounts `json:"live_bounces"`
+	BounceRate  float64       `json:"bounce_rate"`
 }
 
 type CampaignAnalyticsCount struct {
-	CampaignID int       `db:"campaign_id" json:"campaign_id"`
-	Count      int       `db:"count" json:"count"`
-	Timestamp  time.Time `db:"timestamp" json:"timestamp"`
+	CampaignID   int       `db:"campaign_id" json:"campaign_id"`
+	Count        int       `db:"count" json:"count"`
+	Timestamp    time.Time `db:"timestamp" json:"timestamp"`
+	TrackingMode string    `db:"tracking_mode" json:"tracking_mode"`
 }
 
 type CampaignAnalyticsLink struct {
 	URL   string `db:"url" json:"url"`
 	Count int    `db:"count" json:"count"`
 }
+
+// DomainStats represents the engagement and bounce counts of the recipients
+// on an e-mail domain. The rates are percentages of the sent count.
+type DomainStats struct {
+	Domain           string `db:"domain" json:"domain"`
+	Sent             int    `db:"sent" json:"sent"`
+	Views            int    `db:"views" json:"views"`
+	Clicks           int    `db:"clicks" json:"clicks"`
+	BouncesSoft      int    `db:"bounces_soft" json:"bounces_soft"`
+	BouncesHard      int    `db:"bounces_hard" json:"bounces_hard"`
+	BouncesComplaint int    `db:"bounces_complaint" json:"bounces_complaint"`
+
+	ViewRate   float64 `json:"view_rate"`
+	ClickRate  float64 `json:"click_rate"`
+	BounceRate float64 `json:"bounce_rate"`
+}
@@ -1,7 +1,10 @@
 -- name: record-bounce
 -- Insert a bounce and count the bounces for the subscriber and either unsubscribe them,
 WITH sub AS (
-    SELECT id, status FROM subscribers WHERE CASE WHEN $1 != '' THEN uuid = $1::UUID ELSE email = $2 END
+    -- Resolve the subscriber by the UUID, falling back to the e-mail if there's no UUID match.
+    SELECT id, status FROM subscribers
+    WHERE uuid = NULLIF($1, '')::UUID OR LOWER(email) = NULLIF(LOWER($2), '')
+    ORDER BY (uuid = NULLIF($1, '')::UUID) IS TRUE DESC LIMIT 1
 ),
 camp AS (
     SELECT id FROM campaigns WHERE $3 != '' AND uuid = $3::UUID
@@ -30,7 +33,8 @@ DELETE FROM subscribers
     WHERE $9 = 'delete' AND (SELECT num FROM num) >= $8 AND id = (SELECT id FROM sub);
 
 -- name: query-bounces
-SELECT COUNT(*) OVER () AS total,
+-- %total% is the windowed COUNT() of all the matching rows or -1 if the count is skipped.
+SELECT %total% AS total,
     bounces.id,
     bounces.type,
     bounces.source,
@@ -1,6 +1,6 @@
 -- campaigns
 -- name: create-campaign
--- This creates the campaign and inserts campaign_lists relationships.
+-- This creates the campaign and inserts campaign_lists and campaign_exclude_lists relationships.
 WITH tpl AS (
     -- Select the template for the given template ID or use the default template.
     SELECT
@@ -25,7 +25,8 @@ WITH tpl AS (
 camp AS (
     INSERT INTO campaigns (uuid, type, name, subject, from_email, body, altbody,
         content_type, send_at, headers, tags, messenger, template_id, to_send,
-        max_subscriber_id, archive, archive_slug, archive_template_id, archive_meta, body_source)
+        max_subscriber_id, archive, archive_slug, archive_template_id, archive_meta, body_source, tracking_mode, utm,
+        progress_milestones, reply_to, parent_id, preview_text, dynamic_attachments, short_links, max_runtime)
         SELECT $1, $2, $3, $4, $5,
             -- body
             COALESCE(NULLIF($6, ''), (SELECT body FROM tpl), ''),
@@ -40,7 +41,17 @@ camp AS (
             $17,
             $18,
             -- body_source
-            COALESCE($20, (SELECT body_source FROM tpl))
+            COALESCE($20, (SELECT body_source FROM tpl)),
+            $21::tracking_mode,
+            $22,
+            $23::INT[],
+            $24,
+            -- parent_id
+            $25::INT,
+            $26,
+            $27,
+            $28,
+            $29
         RETURNING id
 ),
 med AS (
@@ -50,6 +61,10 @@ med AS (
 insLists AS (
     INSERT INTO campaign_lists (campaign_id, list_id, list_name)
         SELECT (SELECT id FROM camp), id, name FROM lists WHERE id=ANY($14::INT[])
+),
+insExcludeLists AS (
+    INSERT INTO campaign_exclude_lists (campaign_id, list_id)
+        SELECT (SELECT id FROM camp), id FROM lists WHERE id=ANY($30::INT[])
 )
 SELECT id FROM camp;
 
@@ -59,9 +74,9 @@ SELECT id FROM camp;
 -- While the results are sliced using offset+limit,
 -- there's a COUNT() OVER() that still returns the total result count
 -- for pagination in the frontend, albeit being a field that'll repeat
--- with every resultant row.
+-- with every resultant row. %total% is -1 if the count is skipped.
 SELECT  c.*,
-        COUNT(*) OVER () AS total,
+        %total% AS total,
         (
             SELECT COALESCE(ARRAY_TO_JSON(ARRAY_AGG(l)), '[]') FROM (
                 SELECT COALESCE(campaign_lists.list_id, 0) AS id,
@@ -72,7 +87,8 @@ SELECT  c.*,
 FROM campaigns c
 WHERE ($1 = 0 OR id = $1)
     AND (CARDINALITY($2::campaign_status[]) = 0 OR status = ANY($2))
-    AND (CARDINALITY($3::VARCHAR(100)[]) = 0 OR $3 <@ tags)
+    -- $9 = true matches campaigns with any of the tags, otherwise all of them.
+    AND (CARDINALITY($3::VARCHAR(100)[]) = 0 OR (CASE WHEN $9 THEN $3 && tags ELSE $3 <@ tags END))
     AND ($4 = '' OR TO_TSVECTOR(CONCAT(name, ' ', subject)) @@ TO_TSQUERY($4) OR CONCAT(c.name, ' ', c.subject) ILIKE $4)
     -- Get all campaigns or filter by list IDs.
     AND (
@@ -84,7 +100,8 @@ ORDER BY %order% OFFSET $7 LIMIT (CASE WHEN $8 < 1 THEN NULL ELSE $8 END);
 
 -- name: get-campaign
 SELECT campaigns.*,
-    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body
+    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body,
+    COALESCE(templates.block_styles, (SELECT block_styles FROM templates WHERE is_default = true LIMIT 1), '{}') AS template_block_styles
     FROM campaigns
     LEFT JOIN templates ON (
         CASE WHEN $4 = 'default' THEN templates.id = campaigns.template_id
@@ -98,15 +115,24 @@ SELECT campaigns.*,
 
 -- name: get-archived-campaigns
 SELECT COUNT(*) OVER () AS total, campaigns.*,
-    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body
+    COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body,
+    COALESCE(templates.block_styles, (SELECT block_styles FROM templates WHERE is_default = true LIMIT 1), '{}') AS template_block_styles
     FROM campaigns
     LEFT JOIN templates ON (
         CASE WHEN $3 = 'default' THEN templates.id = campaigns.template_id
         ELSE templates.id = campaigns.archive_template_id END
     )
-    WHERE campaigns.archive=true AND campaigns.type='regular' AND campaigns.status=ANY('{running, paused, finished}')
+    WHERE campaigns.archive=true AND campaigns.archive_access='public'
+        AND campaigns.type='regular' AND campaigns.status=ANY('{running, paused, finished}')
     ORDER by campaigns.created_at DESC OFFSET $1 LIMIT $2;
 
+-- name: get-archive-sitemap
+-- Lightweight list of public archived campaigns for the archive sitemap.
+SELECT uuid, archive_slug, updated_at FROM campaigns
+    WHERE archive=true AND archive_access='public'
+        AND type='regular' AND status=ANY('{running, paused, finished}')
+    ORDER by created_at DESC LIMIT $1;
+
 -- name: get-campaign-stats
 -- This query is used to lazy load campaign stats (views, counts, list of lists) given a list of campaign IDs.
 -- The query returns results in the same order as the given campaign IDs, and for non-existent campaign IDs,
@@ -116,34 +142,50 @@ WITH lists AS (
     SELECT campaign_id, JSON_AGG(JSON_BUILD_OBJECT('id', list_id, 'name', list_name)) AS lists FROM campaign_lists
     WHERE campaign_id = ANY($1) GROUP BY campaign_id
 ),
+excludeLists AS (
+    SELECT cl.campaign_id, JSON_AGG(JSON_BUILD_OBJECT('id', l.id, 'name', l.name)) AS lists FROM campaign_exclude_lists cl
+    JOIN lists l ON (l.id = cl.list_id)
+    WHERE cl.campaign_id = ANY($1) GROUP BY cl.campaign_id
+),
 media AS (
     SELECT campaign_id, JSON_AGG(JSON_BUILD_OBJECT('id', media_id, 'filename', filename)) AS media FROM campaign_media
     WHERE campaign_id = ANY($1) GROUP BY campaign_id
 ),
+-- The stats of finished campaigns with final rollups are read from the rollups
+-- and the others' are computed from the event tables.
+rollups AS (
+    SELECT * FROM campaign_stats WHERE campaign_id = ANY($1) AND final
+),
+-- Unique counts are the number of distinct subscribers who viewed or clicked.
 views AS (
-    SELECT campaign_id, COUNT(campaign_id) as num FROM campaign_views
-    WHERE campaign_id = ANY($1)
+    SELECT campaign_id, COUNT(campaign_id) as num, COUNT(DISTINCT subscriber_id) AS uniq FROM campaign_views
+    WHERE campaign_id = ANY($1) AND NOT is_bot AND campaign_id NOT IN (SELECT campaign_id FROM rollups)
     GROUP BY campaign_id
 ),
 clicks AS (
-    SELECT campaign_id, COUNT(campaign_id) as num FROM link_clicks
-    WHERE campaign_id = ANY($1)
+    SELECT campaign_id, COUNT(campaign_id) as num, COUNT(DISTINCT subscriber_id) AS uniq FROM link_clicks
+    WHERE campaign_id = ANY($1) AND NOT is_bot AND campaign_id NOT IN (SELECT campaign_id FROM rollups)
     GROUP BY campaign_id
 ),
 bounces AS (
     SELECT campaign_id, COUNT(campaign_id) as num FROM bounces
-    WHERE campaign_id = ANY($1)
+    WHERE campaign_id = ANY($1) AND campaign_id NOT IN (SELECT campaign_id FROM rollups)
     GROUP BY campaign_id
 )
 SELECT id as campaign_id,
-    COALESCE(v.num, 0) AS views,
-    COALESCE(c.num, 0) AS clicks,
-    COALESCE(b.num, 0) AS bounces,
+    COALESCE(r.views, v.num, 0) AS views,
+    COALESCE(r.unique_views, v.uniq, 0) AS unique_views,
+    COALESCE(r.clicks, c.num, 0) AS clicks,
+    COALESCE(r.unique_clicks, c.uniq, 0) AS unique_clicks,
+    COALESCE(r.bounces, b.num, 0) AS bounces,
     COALESCE(l.lists, '[]') AS lists,
+    COALESCE(el.lists, '[]') AS exclude_lists,
     COALESCE(m.media, '[]') AS media
 FROM (SELECT id FROM UNNEST($1) AS id) x
 LEFT JOIN lists AS l ON (l.campaign_id = id)
+LEFT JOIN excludeLists AS el ON (el.campaign_id = id)
 LEFT JOIN media AS m ON (m.campaign_id = id)
+LEFT JOIN rollups AS r ON (r.campaign_id = id)
 LEFT JOIN views AS v ON (v.campaign_id = id)
 LEFT JOIN clicks AS c ON (c.campaign_id = id)
 LEFT JOIN bounces AS b ON (b.campaign_id = id)
@@ -151,6 +193,7 @@ ORDER BY ARRAY_POSITION($1, id);
 
 -- name: get-campaign-for-preview
 SELECT campaigns.*, COALESCE(templates.body, '') AS template_body,
+    COALESCE(templates.block_styles, '{}') AS template_block_styles,
 (
 	SELECT COALESCE(ARRAY_TO_JSON(ARRAY_AGG(l)), '[]') FROM (
 		SELECT COALESCE(campaign_lists.list_id, 0) AS id,
@@ -163,7 +206,189 @@ LEFT JOIN templates ON (templates.id = (CASE WHEN $2=0 THEN campaigns.template_i
 WHERE campaigns.id = $1;
 
 -- name: get-campaign-status
-SELECT id, status, to_send, sent, started_at, updated_at FROM campaigns WHERE status=$1;
+-- Progress and engagement stats of campaigns with the given status. Unique counts
+-- are the number of distinct subscribers who viewed or clicked.
+SELECT c.id, c.uuid, c.status, c.to_send, c.sent, c.excluded, c.started_at, c.updated_at,
+    COALESCE(v.num, 0) AS views, COALESCE(v.uniq, 0) AS unique_views,
+    COALESCE(k.num, 0) AS clicks, COALESCE(k.uniq, 0) AS unique_clicks,
+    COALESCE(b.num, 0) AS bounces
+FROM campaigns c
+LEFT JOIN LATERAL (
+    SELECT COUNT(*) AS num, COUNT(DISTINCT subscriber_id) AS uniq FROM campaign_views
+    WHERE campaign_id = c.id AND NOT is_bot
+) v ON TRUE
+LEFT JOIN LATERAL (
+    SELECT COUNT(*) AS num, COUNT(DISTINCT subscriber_id) AS uniq FROM link_clicks
+    WHERE campaign_id = c.id AND NOT is_bot
+) k ON TRUE
+LEFT JOIN LATERAL (
+    SELECT COUNT(*) AS num FROM bounces WHERE campaign_id = c.id
+) b ON TRUE
+WHERE c.status=$1;
+
+-- name: get-campaign-calendar
+-- Retrieves lightweight campaign records for the calendar whose send_at, or the span between
+-- their start and finish (or now, if still running), falls within the window $1 to $2, both inclusive.
+SELECT c.id, c.uuid, c.name, c.status, c.type, c.send_at, c.started_at, c.to_send,
+    (CASE WHEN c.status IN ('finished', 'cancelled') THEN c.updated_at ELSE NULL END) AS finished_at,
+    (
+        SELECT COALESCE(JSON_AGG(JSON_BUILD_OBJECT('id', COALESCE(cl.list_id, 0), 'name', cl.list_name)), '[]')
+        FROM campaign_lists cl WHERE cl.campaign_id = c.id
+    ) AS lists
+FROM campaigns c
+WHERE (
+    (c.send_at >= $1 AND c.send_at <= $2)
+    OR (
+        c.started_at <= $2 AND
+        (CASE WHEN c.status IN ('finished', 'cancelled') THEN c.updated_at ELSE NOW() END) >= $1
+    )
+)
+-- Get all campaigns or filter by list IDs.
+AND (
+    $3 OR EXISTS (
+        SELECT 1 FROM campaign_lists WHERE campaign_id = c.id AND list_id = ANY($4::INT[])
+    )
+)
+ORDER BY COALESCE(c.send_at, c.started_at) ASC, c.id ASC;
+
+-- name: get-campaign-list-counts
+-- Returns the number of eligible subscribers on each list of the campaign $1 following the
+-- same eligibility rules as next-campaigns, along with the number of subscribers that are
+-- excluded for being unsubscribed or blocklisted. The total is the count of unique
+-- eligible subscribers across all the lists who aren't on any of the campaign's excluded
+-- lists and repeats on every row.
+WITH camp AS (
+    SELECT id, type FROM campaigns WHERE id = $1
+),
+subs AS (
+    SELECT l.id AS list_id, l.name, l.optin, sl.subscriber_id, sl.status AS sub_status, s.status,
+        (
+            s.status != 'blocklisted' AND
+            CASE
+                WHEN camp.type = 'optin' THEN sl.status = 'unconfirmed' AND l.optin = 'double'
+                WHEN l.optin = 'double' THEN sl.status = 'confirmed'
+                ELSE sl.status != 'unsubscribed'
+            END
+        ) AS eligible,
+        EXISTS (
+            SELECT 1 FROM subscriber_lists x JOIN campaign_exclude_lists ce ON (ce.list_id = x.list_id)
+            WHERE ce.campaign_id = camp.id AND x.subscriber_id = sl.subscriber_id
+        ) AS excluded
+    FROM camp
+    JOIN campaign_lists cl ON (cl.campaign_id = camp.id)
+    JOIN lists l ON (l.id = cl.list_id)
+    LEFT JOIN subscriber_lists sl ON (sl.list_id = l.id)
+    LEFT JOIN subscribers s ON (s.id = sl.subscriber_id)
+)
+SELECT list_id, name, optin,
+    COUNT(subscriber_id) FILTER (WHERE eligible) AS eligible,
+    COUNT(subscriber_id) FILTER (WHERE sub_status = 'unsubscribed') AS unsubscribed,
+    COUNT(subscriber_id) FILTER (WHERE status = 'blocklisted') AS blocklisted,
+    (SELECT COUNT(DISTINCT subscriber_id) FROM subs WHERE eligible AND NOT excluded) AS total
+FROM subs GROUP BY list_id, name, optin ORDER BY list_id;
+
+-- name: get-campaign-overlap
+-- Returns the other campaigns that are scheduled or running within an interval ($2 seconds)
+-- of the campaign $1's send time along with the number of the campaign's subscribers
+-- they also target. To keep it fast on large lists, only the subscribers of the campaign
+-- with the lowest IDs up to a sample size ($3) are checked. If the sample is exhausted, the
+-- overlap is extrapolated to the campaign's audience size from the (cached) list subscriber
+-- stats and flagged as estimated.
+--
+-- A campaign targets the subscribers it'd be sent to: the ones on its lists with the
+-- subscription statuses of the campaign type and list opt-in, minus the ones on its
+-- excluded lists, the blocklisted ones, and for resends to non-openers, the ones who
+-- engaged with the parent campaign. Ad-hoc campaigns target the subscribers among their
+-- recipients. Keep in sync with next-campaigns.
+WITH camp AS (
+    SELECT id, type, parent_id, COALESCE(send_at, NOW()) AS send_at FROM campaigns WHERE id = $1
+),
+others AS (
+    SELECT c.id, c.name, c.status, c.type, c.parent_id, (CASE WHEN c.status = 'running' THEN NOW() ELSE c.send_at END) AS send_at
+    FROM campaigns c, camp
+    WHERE c.id != camp.id AND c.status IN ('running', 'scheduled')
+    AND (CASE WHEN c.status = 'running' THEN NOW() ELSE c.send_at END)
+        BETWEEN camp.send_at - MAKE_INTERVAL(secs => $2) AND camp.send_at + MAKE_INTERVAL(secs => $2)
+),
+-- The campaign's subscribers (any subscription status) with the lowest IDs up to the sample size.
+sample AS (
+    SELECT id FROM (
+        SELECT sl.subscriber_id AS id FROM subscriber_lists sl
+            WHERE sl.list_id = ANY(SELECT list_id FROM campaign_lists WHERE campaign_id = $1)
+        UNION
+        SELECT s.id FROM campaign_recipients r JOIN subscribers s ON (LOWER(s.email) = r.email)
+            WHERE r.campaign_id = $1
+    ) ids
+    ORDER BY id LIMIT $3
+),
+-- The sampled subscribers that the campaign and the other campaigns target.
+targets AS (
+    SELECT t.id AS campaign_id, s.id AS subscriber_id
+    FROM (SELECT id, type, parent_id FROM camp UNION ALL SELECT id, type, parent_id FROM others) t
+    JOIN campaign_lists cl ON (cl.campaign_id = t.id)
+    JOIN lists l ON (l.id = cl.list_id)
+    JOIN subscriber_lists sl ON sl.list_id = cl.list_id
+        AND sl.subscriber_id IN (SELECT id FROM sample)
+        AND (
+            CASE
+                WHEN t.type = 'optin' THEN sl.status = 'unconfirmed' AND l.optin = 'double'
+                WHEN l.optin = 'double' THEN sl.status = 'confirmed'
+                ELSE sl.status != 'unsubscribed'
+            END
+        )
+    JOIN subscribers s ON (s.id = sl.subscriber_id AND s.status != 'blocklisted' AND s.deletion_requested_at IS NULL)
+    WHERE NOT EXISTS (
+        SELECT 1 FROM subscriber_lists x JOIN campaign_exclude_lists ce ON (ce.list_id = x.list_id)
+        WHERE ce.campaign_id = t.id AND x.subscriber_id = s.id
+    )
+    AND NOT EXISTS (
+        SELECT 1 FROM campaigns p WHERE p.id = t.parent_id AND (
+            s.id > p.max_subscriber_id
+            OR (
+                EXISTS (SELECT 1 FROM campaign_sends WHERE campaign_id = p.id)
+                AND NOT EXISTS (SELECT 1 FROM campaign_sends cs WHERE cs.campaign_id = p.id AND cs.subscriber_id = s.id AND NOT cs.simulated)
+            )
+            OR EXISTS (SELECT 1 FROM campaign_views v WHERE v.campaign_id = p.id AND v.subscriber_id = s.id AND NOT v.is_bot AND NOT v.simulated)
+            OR EXISTS (SELECT 1 FROM link_clicks lc WHERE lc.campaign_id = p.id AND lc.subscriber_id = s.id AND NOT lc.is_bot)
+            OR EXISTS (SELECT 1 FROM bounces b WHERE b.subscriber_id = s.id AND b.created_at >= p.started_at)
+        )
+    )
+    UNION
+    SELECT t.id, s.id
+    FROM (SELECT id, type FROM camp UNION ALL SELECT id, type FROM others) t
+    JOIN campaign_recipients r ON (r.campaign_id = t.id)
+    JOIN subscribers s ON (LOWER(s.email) = r.email AND s.status != 'blocklisted' AND s.deletion_requested_at IS NULL)
+    WHERE t.type = 'adhoc' AND s.id IN (SELECT id FROM sample)
+),
+sampled AS (
+    SELECT (SELECT COUNT(*) FROM sample) AS total, COUNT(*) AS num FROM targets WHERE campaign_id = $1
+),
+audience AS (
+    SELECT (CASE WHEN camp.type = 'adhoc' THEN (SELECT COUNT(*) FROM campaign_recipients WHERE campaign_id = $1)
+        ELSE (
+            SELECT COALESCE(SUM(subscriber_count), 0) FROM mat_list_subscriber_stats
+            WHERE list_id = ANY(SELECT list_id FROM campaign_lists WHERE campaign_id = $1)
+            AND status != 'unsubscribed'
+        ) END) AS num
+    FROM camp
+),
+overlap AS (
+    SELECT o.campaign_id AS id, COUNT(*) AS num
+    FROM targets o
+    JOIN targets c ON (c.subscriber_id = o.subscriber_id AND c.campaign_id = $1)
+    WHERE o.campaign_id != $1
+    GROUP BY o.campaign_id
+)
+SELECT o.id, o.name, o.status, o.send_at,
+    (CASE WHEN sampled.total < $3 OR sampled.num = 0 THEN COALESCE(ov.num, 0)
+        ELSE ROUND(COALESCE(ov.num, 0)::NUMERIC * GREATEST(audience.num, sampled.num) / sampled.num)
+    END)::INT AS overlap,
+    (sampled.total >= $3) AS estimated
+    FROM others o
+    CROSS JOIN sampled
+    CROSS JOIN audience
+    LEFT JOIN overlap ov ON (ov.id = o.id)
+    ORDER BY o.send_at, o.id;
 
 -- name: campaign-has-lists
 -- Returns TRUE if the campaign $1 has any of the lists given in $2.
@@ -178,13 +403,18 @@ SELECT EXISTS (
 -- Thus, it has a sideaffect.
 -- In addition, it finds the max_subscriber_id, the upper limit across all lists of
 -- a campaign. This is used to fetch and slice subscribers for the campaign in next-campaign-subscribers.
+-- Subscribers on any of the campaign's excluded lists aren't counted in to_send and are
+-- counted in excluded instead.
 WITH camps AS (
-    -- Get all running campaigns and their template bodies (if the template's deleted, the default template body instead)
-    SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body
+    -- Get all running campaigns and their template bodies and block styles (if the template's deleted, the default template's instead)
+    SELECT campaigns.*, COALESCE(templates.body, (SELECT body FROM templates WHERE is_default = true LIMIT 1), '') AS template_body,
+    COALESCE(templates.block_styles, (SELECT block_styles FROM templates WHERE is_default = true LIMIT 1), '{}') AS template_block_styles
     FROM campaigns
     LEFT JOIN templates ON (templates.id = campaigns.template_id)
     WHERE (status='running' OR (status='scheduled' AND NOW() >= campaigns.send_at))
     AND NOT(campaigns.id = ANY($1::INT[]))
+    -- $3 = true requires scheduled campaigns to be approved before they're started.
+    AND (status='running' OR NOT $3::BOOLEAN OR campaigns.approved_at IS NOT NULL)
 ),
 campLists AS (
     -- Get the list_ids and their optin statuses for the campaigns found in the previous step.
@@ -199,7 +429,10 @@ campMedia AS (
     GROUP BY campaign_id
 ),
 counts AS (
-    SELECT camps.id AS campaign_id, COUNT(DISTINCT sl.subscriber_id) AS to_send, COALESCE(MAX(sl.subscriber_id), 0) AS max_subscriber_id
+    SELECT camps.id AS campaign_id,
+        COUNT(DISTINCT sl.subscriber_id) FILTER (WHERE NOT ex.excluded) AS to_send,
+        COALESCE(MAX(sl.subscriber_id) FILTER (WHERE NOT ex.excluded), 0) AS max_subscriber_id,
+        COUNT(DISTINCT sl.subscriber_id) FILTER (WHERE ex.excluded) AS excluded
     FROM camps
     JOIN campLists cl ON cl.campaign_id = camps.id
     JOIN subscriber_lists sl ON sl.list_id = cl.list_id
@@ -210,7 +443,38 @@ counts AS (
                 ELSE sl.status != 'unsubscribed'
             END
         )
-    JOIN subscribers s ON (s.id = sl.subscriber_id AND s.status != 'blocklisted')
+    JOIN subscribers s ON (s.id = sl.subscriber_id AND s.status != 'blocklisted' AND s.deletion_requested_at IS NULL)
+    -- Membership of any of the campaign's excluded lists. Keep in sync with next-campaign-subscribers.
+    CROSS JOIN LATERAL (
+        SELECT EXISTS (
+            SELECT 1 FROM subscriber_lists x JOIN campaign_exclude_lists ce ON (ce.list_id = x.list_id)
+            WHERE ce.campaign_id = camps.id AND x.subscriber_id = s.id
+        ) AS excluded
+    ) ex
+    -- Resends to non-openers only go to the parent campaign's recipients
+    -- who haven't engaged with it. Keep in sync with next-campaign-subscribers.
+    WHERE NOT EXISTS (
+        SELECT 1 FROM campaigns p WHERE p.id = camps.parent_id AND (
+            s.id > p.max_subscriber_id
+            OR (
+                EXISTS (SELECT 1 FROM campaign_sends WHERE campaign_id = p.id)
+                AND NOT EXISTS (SELECT 1 FROM campaign_sends cs WHERE cs.campaign_id = p.id AND cs.subscriber_id = s.id AND NOT cs.simulated)
+            )
+            OR EXISTS (SELECT 1 FROM campaign_views v WHERE v.campaign_id = p.id AND v.subscriber_id = s.id AND NOT v.is_bot AND NOT v.simulated)
+            OR EXISTS (SELECT 1 FROM link_clicks lc WHERE lc.campaign_id = p.id AND lc.subscriber_id = s.id AND NOT lc.is_bot)
+            OR EXISTS (SELECT 1 FROM bounces b WHERE b.subscriber_id = s.id AND b.created_at >= p.started_at)
+        )
+    )
+    GROUP BY camps.id
+    UNION ALL
+    -- Ad-hoc campaigns are sent to their uploaded recipients, excluding blocklisted subscribers
+    -- and the ones pending deletion.
+    SELECT camps.id AS campaign_id, COUNT(r.id) AS to_send, COALESCE(MAX(r.id), 0) AS max_subscriber_id, 0 AS excluded
+    FROM camps
+    JOIN campaign_recipients r ON (r.campaign_id = camps.id)
+    WHERE camps.type = 'adhoc' AND NOT EXISTS (
+        SELECT 1 FROM subscribers s WHERE LOWER(s.email) = r.email AND (s.status = 'blocklisted' OR s.deletion_requested_at IS NOT NULL)
+    )
     GROUP BY camps.id
 ),
 updateCounts AS (
@@ -223,6 +487,7 @@ u AS (
     -- For each campaign, update the to_send count and set the max_subscriber_id.
     UPDATE campaigns AS ca
     SET to_send = co.to_send,
+        excluded = co.excluded,
         status = (CASE WHEN status != 'running' THEN 'running' ELSE status END),
         max_subscriber_id = co.max_subscriber_id,
         started_at=(CASE WHEN ca.started_at IS NULL THEN NOW() ELSE ca.started_at END)
@@ -239,10 +504,12 @@ WITH intval AS (
 uniqIDs AS (
     SELECT DISTINCT ON(subscriber_id) subscriber_id, campaign_id, DATE_TRUNC((SELECT * FROM intval), created_at) AS "timestamp"
     FROM %s
-    WHERE campaign_id=ANY($1) AND created_at >= $2 AND created_at <= $3
+    WHERE campaign_id=ANY($1) AND created_at >= $2 AND created_at <= $3 AND ($4 OR NOT is_bot)
     ORDER BY subscriber_id, "timestamp"
 )
-SELECT COUNT(*) AS "count", campaign_id, "timestamp"
+-- The tracking_mode labels the counts so that rates across modes aren't compared as-is.
+SELECT COUNT(*) AS "count", campaign_id, "timestamp",
+    (SELECT tracking_mode FROM campaigns WHERE id = campaign_id) AS tracking_mode
     FROM uniqIDs GROUP BY campaign_id, "timestamp" ORDER BY "timestamp" ASC;
 
 -- name: get-campaign-analytics-counts
@@ -251,9 +518,10 @@ WITH intval AS (
     -- For intervals < a week, aggregate counts hourly, otherwise daily.
     SELECT CASE WHEN (EXTRACT (EPOCH FROM ($3::TIMESTAMP - $2::TIMESTAMP)) / 86400) >= 7 THEN 'day' ELSE 'hour' END
 )
-SELECT campaign_id, COUNT(*) AS "count", DATE_TRUNC((SELECT * FROM intval), created_at) AS "timestamp"
+SELECT campaign_id, COUNT(*) AS "count", DATE_TRUNC((SELECT * FROM intval), created_at) AS "timestamp",
+    (SELECT tracking_mode FROM campaigns WHERE id = campaign_id) AS tracking_mode
     FROM %s
-    WHERE campaign_id=ANY($1) AND created_at >= $2 AND created_at <= $3
+    WHERE campaign_id=ANY($1) AND created_at >= $2 AND created_at <= $3 AND ($4 OR NOT is_bot)
     GROUP BY campaign_id, "timestamp" ORDER BY "timestamp" ASC;
 
 -- name: get-campaign-bounce-counts
@@ -261,11 +529,38 @@ WITH intval AS (
     -- For intervals < a week, aggregate counts hourly, otherwise daily.
     SELECT CASE WHEN (EXTRACT (EPOCH FROM ($3::TIMESTAMP - $2::TIMESTAMP)) / 86400) >= 7 THEN 'day' ELSE 'hour' END
 )
-SELECT campaign_id, COUNT(*) AS "count", DATE_TRUNC((SELECT * FROM intval), created_at) AS "timestamp"
+SELECT campaign_id, COUNT(*) AS "count", DATE_TRUNC((SELECT * FROM intval), created_at) AS "timestamp",
+    (SELECT tracking_mode FROM campaigns WHERE id = campaign_id) AS tracking_mode
     FROM bounces
     WHERE campaign_id=ANY($1) AND created_at >= $2 AND created_at <= $3
     GROUP BY campaign_id, "timestamp" ORDER BY "timestamp" ASC;
 
+-- name: get-domain-stats
+-- Returns the sent, view, click, and bounce counts grouped by the recipients' e-mail
+-- domain from the cached domain stats, optionally for a campaign ($1) and a date range
+-- ($2, $3). The top $4 domains by sent count are returned and the remaining domains
+-- are aggregated into a single 'other' row at the end.
+WITH stats AS (
+    SELECT domain, SUM(sent) AS sent, SUM(views) AS views, SUM(clicks) AS clicks,
+        SUM(bounces_soft) AS bounces_soft, SUM(bounces_hard) AS bounces_hard,
+        SUM(bounces_complaint) AS bounces_complaint
+    FROM mat_domain_stats
+    WHERE ($1 = 0 OR campaign_id = $1)
+        AND ($2::DATE IS NULL OR date >= $2::DATE)
+        AND ($3::DATE IS NULL OR date <= $3::DATE)
+    GROUP BY domain
+),
+ranked AS (
+    SELECT *, ROW_NUMBER() OVER (ORDER BY sent DESC, views DESC, domain) AS rank FROM stats
+)
+SELECT (CASE WHEN rank <= $4 THEN domain ELSE 'other' END) AS domain,
+    SUM(sent) AS sent, SUM(views) AS views, SUM(clicks) AS clicks,
+    SUM(bounces_soft) AS bounces_soft, SUM(bounces_hard) AS bounces_hard,
+    SUM(bounces_complaint) AS bounces_complaint
+    FROM ranked
+    GROUP BY 1
+    ORDER BY MIN(rank);
+
 -- name: get-campaign-link-counts
 -- raw: true
 -- %s = * or DISTINCT subscriber_id (prepared based on based on individual tracking=on/off). Prepared on boot.
@@ -273,15 +568,17 @@ SELECT COUNT(%s) AS "count", url
     FROM link_clicks
     LEFT JOIN links ON (link_clicks.link_id = links.id)
     WHERE campaign_id=ANY($1) AND link_clicks.created_at >= $2 AND link_clicks.created_at <= $3
+        AND ($4 OR NOT link_clicks.is_bot)
     GROUP BY links.url ORDER BY "count" DESC LIMIT 50;
 
 -- name: get-running-campaign
 -- Returns the metadata for a running campaign that is required by next-campaign-subscribers to retrieve
 -- a batch of campaign subscribers for processing.
+-- Ad-hoc campaigns have no lists and return a single row with a NULL list_id.
 SELECT campaigns.id AS campaign_id, campaigns.type as campaign_type, last_subscriber_id, max_subscriber_id, lists.id AS list_id
     FROM campaigns
-    INNER JOIN campaign_lists ON (campaign_lists.campaign_id = campaigns.id)
-    INNER JOIN lists ON (lists.id = campaign_lists.list_id)
+    LEFT JOIN campaign_lists ON (campaign_lists.campaign_id = campaigns.id)
+    LEFT JOIN lists ON (lists.id = campaign_lists.list_id)
     WHERE campaigns.id = $1 AND campaigns.status='running';
 
 -- name: next-campaign-subscribers
@@ -314,8 +611,9 @@ subs AS (
             AND s.id > $3
              -- max_subscriber_id
             AND s.id <= $4
-             -- Subscriber should not be blacklisted.
+             -- Subscriber should not be blacklisted or pending deletion.
             AND s.status != 'blocklisted'
+            AND s.deletion_requested_at IS NULL
             AND (
                 -- If it's an optin campaign and the list is double-optin, only pick unconfirmed subscribers.
                 ($2 = 'optin' AND sl.status = 'unconfirmed' AND campLists.optin = 'double')
@@ -330,6 +628,28 @@ subs AS (
                     )
                 )
             )
+            -- If it's a resend to non-openers, only pick the parent campaign's recipients
+            -- who haven't viewed or clicked it, or bounced since. Without send records
+            -- (older campaigns), all subscribers up to the parent's max_subscriber_id are
+            -- considered recipients.
+            AND NOT EXISTS (
+                SELECT 1 FROM campaigns c JOIN campaigns p ON (p.id = c.parent_id) WHERE c.id = $1 AND (
+                    s.id > p.max_subscriber_id
+                    OR (
+                        EXISTS (SELECT 1 FROM campaign_sends WHERE campaign_id = p.id)
+                        AND NOT EXISTS (SELECT 1 FROM campaign_sends cs WHERE cs.campaign_id = p.id AND cs.subscriber_id = s.id AND NOT cs.simulated)
+                    )
+                    OR EXISTS (SELECT 1 FROM campaign_views v WHERE v.campaign_id = p.id AND v.subscriber_id = s.id AND NOT v.is_bot AND NOT v.simulated)
+                    OR EXISTS (SELECT 1 FROM link_clicks lc WHERE lc.campaign_id = p.id AND lc.subscriber_id = s.id AND NOT lc.is_bot)
+                    OR EXISTS (SELECT 1 FROM bounces b WHERE b.subscriber_id = s.id AND b.created_at >= p.started_at)
+                )
+            )
+            -- Skip subscribers on any of the campaign's excluded lists, whatever
+            -- their subscription status on them.
+            AND NOT EXISTS (
+                SELECT 1 FROM subscriber_lists x JOIN campaign_exclude_lists ce ON (ce.list_id = x.list_id)
+                WHERE ce.campaign_id = $1 AND x.subscriber_id = s.id
+            )
         ORDER BY s.id LIMIT $6
     ) subIDs JOIN subscribers s ON (s.id = subIDs.id) ORDER BY s.id
 ),
@@ -340,6 +660,63 @@ u AS (
 )
 SELECT * FROM subs;
 
+-- name: next-campaign-recipients
+-- Returns a batch of the ad-hoc recipients of a campaign ($1) as transient subscribers
+-- starting from the last checkpoint ($2, last_subscriber_id) up to $3 (max_subscriber_id),
+-- skipping e-mails of subscribers that are blocklisted or pending deletion, and updates the checkpoint.
+WITH subs AS (
+    SELECT r.id, r.uuid, r.email, r.name, r.attribs, 'enabled'::subscriber_status AS status,
+        r.created_at, r.created_at AS updated_at
+    FROM campaign_recipients r
+    WHERE r.campaign_id = $1 AND r.id > $2 AND r.id <= $3
+    AND NOT EXISTS (
+        SELECT 1 FROM subscribers s WHERE LOWER(s.email) = r.email AND (s.status = 'blocklisted' OR s.deletion_requested_at IS NOT NULL)
+    )
+    ORDER BY r.id LIMIT $4
+),
+u AS (
+    UPDATE campaigns
+    SET last_subscriber_id = (SELECT MAX(id) FROM subs), updated_at = NOW()
+    WHERE (SELECT COUNT(id) FROM subs) > 0 AND id=$1
+)
+SELECT * FROM subs;
+
+-- name: insert-campaign-recipients
+-- Inserts a batch of ad-hoc recipients ($2 emails, $3 names, $4 attribs) for a campaign ($1)
+-- and returns the number of new recipients. Duplicate e-mails are ignored.
+WITH ins AS (
+    INSERT INTO campaign_recipients (campaign_id, email, name, attribs)
+        SELECT $1, UNNEST($2::TEXT[]), UNNEST($3::TEXT[]), UNNEST($4::JSONB[])
+    ON CONFLICT (campaign_id, email) DO NOTHING
+    RETURNING id
+)
+SELECT COUNT(*) FROM ins;
+
+-- name: count-campaign-recipients
+SELECT COUNT(*) FROM campaign_recipients WHERE campaign_id = $1;
+
+-- name: delete-campaign-recipients
+DELETE FROM campaign_recipients WHERE campaign_id = $1;
+
+-- name: delete-stale-campaign-recipients
+-- Deletes the ad-hoc recipients of campaigns that finished or were cancelled
+-- longer than the retention period ($1 seconds) ago.
+WITH del AS (
+    DELETE FROM campaign_recipients WHERE campaign_id IN (
+        SELECT id FROM campaigns WHERE type = 'adhoc' AND status IN ('finished', 'cancelled')
+        AND updated_at < NOW() - MAKE_INTERVAL(secs => $1)
+    )
+    RETURNING id
+)
+SELECT COUNT(*) FROM del;
+
+-- name: record-campaign-recipient-bounce
+-- Records a bounce ($4) on an ad-hoc recipient by the campaign UUID ($1) and
+-- the recipient's UUID ($2) or e-mail ($3).
+UPDATE campaign_recipients SET bounce_type=$4
+    WHERE campaign_id = (SELECT id FROM campaigns WHERE uuid = $1::UUID)
+    AND (CASE WHEN $2 != '' THEN uuid = $2::UUID ELSE email = LOWER($3) END);
+
 -- name: delete-campaign-views
 DELETE FROM campaign_views WHERE created_at < $1;
 
@@ -380,6 +757,16 @@ WITH camp AS (
         archive_template_id=(CASE WHEN $7::content_type = 'visual' THEN NULL ELSE $16::INT END),
         archive_meta=$17,
         body_source=$19,
+        tracking_mode=$20::tracking_mode,
+        utm=$21,
+        progress_milestones=$22::INT[],
+        reply_to=$23,
+        preview_text=$24,
+        dynamic_attachments=$25,
+        short_links=$26,
+        max_runtime=$27,
+        -- Saving discards the autosaved draft.
+        draft=NULL,
         updated_at=NOW()
     WHERE id = $1 RETURNING id
 ),
@@ -395,6 +782,15 @@ medi AS (
     INSERT INTO campaign_media (campaign_id, media_id, filename)
         (SELECT $1 AS campaign_id, id, filename FROM media WHERE id=ANY($18::INT[]))
         ON CONFLICT (campaign_id, media_id) DO NOTHING
+),
+-- Excluded lists are only reset if they're given ($28 isn't NULL).
+elists AS (
+    DELETE FROM campaign_exclude_lists WHERE campaign_id = $1 AND $28::INT[] IS NOT NULL AND NOT(list_id = ANY($28::INT[]))
+),
+elistsi AS (
+    INSERT INTO campaign_exclude_lists (campaign_id, list_id)
+        (SELECT $1 AS campaign_id, id FROM lists WHERE id=ANY($28::INT[]))
+        ON CONFLICT (campaign_id, list_id) DO NOTHING
 )
 INSERT INTO campaign_lists (campaign_id, list_id, list_name)
     (SELECT $1 as campaign_id, id, name FROM lists WHERE id=ANY($13::INT[]))
@@ -408,6 +804,151 @@ UPDATE campaigns SET
     updated_at=NOW()
 WHERE id=$1;
 
+-- name: insert-campaign-sends
+-- Records the sends of a campaign ($1) to a batch of subscribers ($2) via a messenger ($3),
+-- ignoring subscribers that have been deleted since. Sends of simulated campaigns are flagged.
+INSERT INTO campaign_sends (campaign_id, subscriber_id, messenger, simulated)
+    SELECT $1, id, $3, (SELECT simulation FROM campaigns WHERE id = $1) FROM subscribers WHERE id = ANY($2::INT[]);
+
+-- name: update-campaign-seed-send
+UPDATE campaigns SET seed_send=$2 WHERE id=$1;
+
+-- name: get-campaign-hygiene
+-- Returns the bounce hygiene summary of a campaign ($1) from the bounces recorded against
+-- it: the counts by type, the top domains by hard bounces, the number of subscribers whose
+-- bounces on the campaign crossed the bounce action thresholds ($2, {type: count} of the
+-- types that have an action), and the list quality score, the percentage of recipients that
+-- didn't hard bounce or complain, along with the score of the previous finished campaign
+-- on any of the campaign's lists.
+WITH camp AS (
+    SELECT id, sent, started_at FROM campaigns WHERE id = $1
+),
+b AS (
+    SELECT b.subscriber_id, b.type, b.created_at, LOWER(SPLIT_PART(s.email, '@', 2)) AS domain
+    FROM bounces b JOIN subscribers s ON (s.id = b.subscriber_id)
+    WHERE b.campaign_id = $1
+),
+doms AS (
+    SELECT domain, COUNT(*) AS count FROM b WHERE type = 'hard'
+    GROUP BY domain ORDER BY count DESC, domain LIMIT 10
+),
+crossed AS (
+    -- The bounce on the campaign was the one that brought the subscriber's bounce count to the threshold.
+    SELECT DISTINCT b.subscriber_id FROM b
+    WHERE COALESCE(($2::JSONB->>b.type::TEXT)::INT, 0) > 0
+    AND (
+        SELECT COUNT(*) FROM bounces x WHERE x.subscriber_id = b.subscriber_id
+        AND x.type = b.type AND x.created_at <= b.created_at
+    ) = ($2::JSONB->>b.type::TEXT)::INT
+),
+prev AS (
+    SELECT (c.hygiene->>'quality_score')::FLOAT AS score FROM campaigns c
+    WHERE c.id != $1 AND c.status = 'finished' AND c.hygiene IS NOT NULL
+    AND c.started_at < (SELECT started_at FROM camp)
+    AND EXISTS (
+        SELECT 1 FROM campaign_lists a JOIN campaign_lists o ON (o.list_id = a.list_id)
+        WHERE a.campaign_id = $1 AND o.campaign_id = c.id
+    )
+    ORDER BY c.started_at DESC LIMIT 1
+),
+counts AS (
+    SELECT COUNT(*) FILTER (WHERE type = 'hard') AS hard,
+        COUNT(*) FILTER (WHERE type = 'soft') AS soft,
+        COUNT(*) FILTER (WHERE type = 'complaint') AS complaint
+    FROM b
+)
+SELECT camp.id AS campaign_id, camp.sent,
+    counts.hard AS bounces_hard, counts.soft AS bounces_soft, counts.complaint AS bounces_complaint,
+    (SELECT COALESCE(JSON_AGG(doms), '[]') FROM doms) AS domains,
+    (SELECT COUNT(*) FROM crossed) AS threshold_crossed,
+    ROUND(100 * GREATEST(1 - (counts.hard + counts.complaint)::NUMERIC / GREATEST(camp.sent, 1), 0), 2)::FLOAT AS quality_score,
+    (SELECT score FROM prev) AS prev_quality_score,
+    NOW() AS created_at
+FROM camp, counts;
+
+-- name: update-campaign-hygiene
+UPDATE campaigns SET hygiene=$2 WHERE id=$1;
+
+-- name: rollup-campaign-stats
+-- Computes and saves the stats rollups of the given finished campaigns ($1), or if $1
+-- is NULL, of up to $3 finished campaigns whose rollups are missing or not yet final.
+-- A rollup is final when it's computed $2 seconds or more after the campaign finished,
+-- by when its views, clicks, and bounces have settled. Returns the number of rollups saved.
+WITH camps AS (
+    SELECT c.id, c.sent, COALESCE(s.finished_at, c.updated_at) AS finished_at FROM campaigns c
+    LEFT JOIN campaign_stats s ON (s.campaign_id = c.id)
+    WHERE c.status = 'finished'
+    AND (
+        CASE WHEN $1::INT[] IS NOT NULL THEN c.id = ANY($1::INT[])
+        ELSE s.campaign_id IS NULL OR (NOT s.final AND s.finished_at < NOW() - MAKE_INTERVAL(secs => $2))
+        END
+    )
+    ORDER BY c.id
+    LIMIT $3
+),
+views AS (
+    SELECT campaign_id, COUNT(*) AS num, COUNT(DISTINCT subscriber_id) AS uniq FROM campaign_views
+    WHERE campaign_id = ANY(SELECT id FROM camps) AND NOT is_bot
+    GROUP BY campaign_id
+),
+clicks AS (
+    SELECT campaign_id, COUNT(*) AS num, COUNT(DISTINCT subscriber_id) AS uniq FROM link_clicks
+    WHERE campaign_id = ANY(SELECT id FROM camps) AND NOT is_bot
+    GROUP BY campaign_id
+),
+bounces AS (
+    SELECT campaign_id, COUNT(*) AS num,
+        COUNT(*) FILTER (WHERE type = 'hard') AS hard,
+        COUNT(*) FILTER (WHERE type = 'soft') AS soft,
+        COUNT(*) FILTER (WHERE type = 'complaint') AS complaint
+    FROM bounces
+    WHERE campaign_id = ANY(SELECT id FROM camps)
+    GROUP BY campaign_id
+),
+unsubs AS (
+    SELECT campaign_id, COUNT(*) AS num FROM unsubscribe_events
+    WHERE campaign_id = ANY(SELECT id FROM camps)
+    GROUP BY campaign_id
+),
+ins AS (
+    INSERT INTO campaign_stats (campaign_id, sent, delivered, bounces, hard_bounces, soft_bounces, complaints,
+        views, unique_views, clicks, unique_clicks, unsubscribes, final, finished_at, updated_at)
+    SELECT camps.id, camps.sent, GREATEST(camps.sent - COALESCE(b.num, 0), 0),
+        COALESCE(b.num, 0), COALESCE(b.hard, 0), COALESCE(b.soft, 0), COALESCE(b.complaint, 0),
+        COALESCE(v.num, 0), COALESCE(v.uniq, 0), COALESCE(k.num, 0), COALESCE(k.uniq, 0), COALESCE(u.num, 0),
+        camps.finished_at < NOW() - MAKE_INTERVAL(secs => $2), camps.finished_at, NOW()
+    FROM camps
+    LEFT JOIN views v ON (v.campaign_id = camps.id)
+    LEFT JOIN clicks k ON (k.campaign_id = camps.id)
+    LEFT JOIN bounces b ON (b.campaign_id = camps.id)
+    LEFT JOIN unsubs u ON (u.campaign_id = camps.id)
+    ON CONFLICT (campaign_id) DO UPDATE SET
+        sent=EXCLUDED.sent,
+        delivered=EXCLUDED.delivered,
+        bounces=EXCLUDED.bounces,
+        hard_bounces=EXCLUDED.hard_bounces,
+        soft_bounces=EXCLUDED.soft_bounces,
+        complaints=EXCLUDED.complaints,
+        views=EXCLUDED.views,
+        unique_views=EXCLUDED.unique_views,
+        clicks=EXCLUDED.clicks,
+        unique_clicks=EXCLUDED.unique_clicks,
+        unsubscribes=EXCLUDED.unsubscribes,
+        final=EXCLUDED.final,
+        updated_at=NOW()
+    RETURNING 1
+)
+SELECT COUNT(*) FROM ins;
+
+-- name: get-campaign-stored-hygiene
+SELECT hygiene FROM campaigns WHERE id=$1;
+
+-- name: update-campaign-send-errors
+UPDATE campaigns SET send_errors=$2 WHERE id=$1;
+
+-- name: get-campaign-send-errors
+SELECT send_errors FROM campaigns WHERE id=$1;
+
 -- name: update-campaign-status
 UPDATE campaigns SET
     status=(
@@ -419,15 +960,108 @@ UPDATE campaigns SET
     updated_at=NOW()
 WHERE id = $1;
 
+-- name: update-campaign-simulation
+-- Sets the simulation mode of a campaign that hasn't started yet. Paused
+-- campaigns resume in the mode they were started in.
+UPDATE campaigns SET simulation=$2 WHERE id=$1 AND status IN ('draft', 'scheduled');
+
+-- name: get-campaign-bounce-types
+-- Number of bounces of a campaign by type.
+SELECT COUNT(*) FILTER (WHERE type = 'hard') AS hard,
+    COUNT(*) FILTER (WHERE type = 'soft') AS soft,
+    COUNT(*) FILTER (WHERE type = 'complaint') AS complaint,
+    COUNT(*) AS total
+    FROM bounces WHERE campaign_id = $1;
+
+-- name: update-campaign-draft
+-- Autosaves the unsaved subject and body of a campaign that can be edited. This is
+-- called every few seconds by the editor and is kept to a single cheap UPDATE
+-- that doesn't touch updated_at.
+UPDATE campaigns SET draft=JSONB_BUILD_OBJECT('subject', $2::TEXT, 'body', $3::TEXT, 'body_source', NULLIF($4, ''), 'updated_at', NOW())
+    WHERE id=$1 AND status IN ('draft', 'pending_approval', 'scheduled', 'paused');
+
+-- name: get-campaign-draft
+SELECT COALESCE(draft->>'subject', '') AS subject, COALESCE(draft->>'body', '') AS body,
+    draft->>'body_source' AS body_source, (draft->>'updated_at')::TIMESTAMP WITH TIME ZONE AS updated_at
+    FROM campaigns WHERE id=$1 AND draft IS NOT NULL;
+
+-- name: restore-campaign-draft
+-- Promotes the autosaved draft to the campaign's content and discards it.
+UPDATE campaigns SET
+    subject=COALESCE(NULLIF(draft->>'subject', ''), subject),
+    body=COALESCE(draft->>'body', body),
+    body_source=COALESCE(draft->>'body_source', body_source),
+    draft=NULL,
+    updated_at=NOW()
+WHERE id=$1 AND draft IS NOT NULL AND status IN ('draft', 'pending_approval', 'scheduled', 'paused');
+
+-- name: delete-campaign-draft
+UPDATE campaigns SET draft=NULL WHERE id=$1;
+
+-- name: delete-simulation-data
+-- Deletes the send and view records of simulated campaign runs.
+WITH s AS (
+    DELETE FROM campaign_sends WHERE simulated RETURNING 1
+),
+v AS (
+    DELETE FROM campaign_views WHERE simulated RETURNING 1
+)
+SELECT (SELECT COUNT(*) FROM s) AS sends, (SELECT COUNT(*) FROM v) AS views;
+
+-- name: approve-campaign
+-- Approves a campaign pending approval and moves it back to draft so that it can be
+-- scheduled or started.
+UPDATE campaigns SET status='draft', approved_by=$2, approved_at=NOW(), approval_comment=$3, updated_at=NOW()
+    WHERE id = $1 AND status='pending_approval';
+
+-- name: reject-campaign
+UPDATE campaigns SET status='draft', approved_by=NULL, approved_at=NULL, approval_comment=$2, updated_at=NOW()
+    WHERE id = $1 AND status='pending_approval';
+
+-- name: set-campaign-approval
+-- Records the approval of a campaign's current content by a user who can approve
+-- campaigns, or clears it if $2 = 0. When cleared, draft and scheduled campaigns
+-- go back to pending approval.
+UPDATE campaigns SET
+    approved_by=(CASE WHEN $2 > 0 THEN $2 ELSE NULL END),
+    approved_at=(CASE WHEN $2 > 0 THEN NOW() ELSE NULL END),
+    status=(
+        CASE
+            WHEN $2 = 0 AND status IN ('draft', 'scheduled') THEN 'pending_approval'
+            ELSE status
+        END
+    )
+    WHERE id = $1;
+
+-- name: get-campaign-approvers
+-- Returns the e-mails of enabled users who can approve campaigns.
+SELECT u.email FROM users u
+    JOIN roles r ON (r.id = u.user_role_id)
+    WHERE u.status = 'enabled' AND u.type = 'user'
+    AND (r.id = $1 OR 'campaigns:approve' = ANY(r.permissions));
+
 -- name: update-campaign-archive
 UPDATE campaigns SET
     archive=$2,
     archive_slug=(CASE WHEN $3::TEXT = '' THEN NULL ELSE $3 END),
     archive_template_id=(CASE WHEN $4 > 0 THEN $4 ELSE archive_template_id END),
     archive_meta=(CASE WHEN $5::TEXT != '' THEN $5::JSONB ELSE archive_meta END),
+    archive_access=$6,
+    archive_password=(
+        CASE
+            WHEN $6 != 'password' THEN NULL
+            WHEN $7 != '' THEN CRYPT($7, GEN_SALT('bf'))
+            ELSE archive_password
+        END
+    ),
     updated_at=NOW()
     WHERE id=$1;
 
+-- name: check-campaign-archive-password
+SELECT COUNT(*) > 0 FROM campaigns
+    WHERE id=$1 AND archive_access='password' AND archive_password IS NOT NULL
+    AND CRYPT($2, archive_password) = archive_password;
+
 -- name: delete-campaign
 DELETE FROM campaigns WHERE id=$1;
 
@@ -447,11 +1081,82 @@ AND (
 );
 
 -- name: register-campaign-view
+-- Views are only recorded for campaigns with the 'full' tracking mode. Views on seed
+-- list messages (nil subscriber UUID) are not recorded.
 WITH view AS (
-    SELECT campaigns.id as campaign_id, subscribers.id AS subscriber_id FROM campaigns
+    SELECT campaigns.id as campaign_id, subscribers.id AS subscriber_id, campaigns.tracking_mode, campaigns.simulation FROM campaigns
     LEFT JOIN subscribers ON (CASE WHEN $2::TEXT != '' THEN subscribers.uuid = $2::UUID ELSE FALSE END)
-    WHERE campaigns.uuid = $1
+    WHERE campaigns.uuid = $1 AND $2::TEXT != '00000000-0000-0000-0000-000000000000'
 )
-INSERT INTO campaign_views (campaign_id, subscriber_id)
-    VALUES((SELECT campaign_id FROM view), (SELECT subscriber_id FROM view));
+INSERT INTO campaign_views (campaign_id, subscriber_id, is_bot, simulated)
+    SELECT campaign_id, subscriber_id, $3, simulation FROM view WHERE tracking_mode = 'full';
+
 
+-- name: export-campaigns
+SELECT c.uuid, c.type, c.name, c.subject, c.preview_text, c.from_email, c.body, c.body_source, c.altbody,
+    c.content_type, COALESCE(c.tags, '{}') AS tags, c.headers, c.messenger, c.tracking_mode, c.utm,
+    c.archive, c.archive_slug, c.archive_meta,
+    t.uuid::TEXT AS template_uuid, at.uuid::TEXT AS archive_template_uuid,
+    COALESCE(ARRAY(
+        SELECT l.uuid::TEXT FROM campaign_lists cl JOIN lists l ON l.id = cl.list_id
+        WHERE cl.campaign_id = c.id ORDER BY l.id
+    ), '{}') AS list_uuids
+FROM campaigns c
+LEFT JOIN templates t ON t.id = c.template_id
+LEFT JOIN templates at ON at.id = c.archive_template_id
+ORDER BY c.id;
+
+-- name: upsert-campaign-by-uuid
+-- Creates a draft campaign or updates the campaign with the same UUID ($1) when importing
+-- a bundle, resolving the template UUIDs ($18, $19) and list UUIDs ($20). $21 is the preview text. Campaigns that
+-- aren't drafts are left untouched and return no rows. An archive slug that's already
+-- taken by another campaign is dropped. created is false if an existing campaign was updated.
+WITH camp AS (
+    INSERT INTO campaigns (uuid, type, name, subject, from_email, body, body_source, altbody,
+        content_type, tags, headers, messenger, tracking_mode, utm, archive, archive_slug, archive_meta,
+        template_id, archive_template_id, status, preview_text)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8,
+        $9::content_type, $10, $11, $12, $13::tracking_mode, $14, $15,
+        (CASE WHEN EXISTS (SELECT 1 FROM campaigns WHERE archive_slug = $16 AND uuid != $1) THEN NULL ELSE $16 END),
+        $17,
+        (SELECT id FROM templates WHERE uuid = $18::UUID),
+        (SELECT id FROM templates WHERE uuid = $19::UUID),
+        'draft',
+        $21)
+    ON CONFLICT (uuid) DO UPDATE SET
+        type=EXCLUDED.type,
+        name=EXCLUDED.name,
+        subject=EXCLUDED.subject,
+        from_email=EXCLUDED.from_email,
+        body=EXCLUDED.body,
+        body_source=EXCLUDED.body_source,
+        altbody=EXCLUDED.altbody,
+        content_type=EXCLUDED.content_type,
+        tags=EXCLUDED.tags,
+        headers=EXCLUDED.headers,
+        messenger=EXCLUDED.messenger,
+        tracking_mode=EXCLUDED.tracking_mode,
+        utm=EXCLUDED.utm,
+        archive=EXCLUDED.archive,
+        archive_slug=EXCLUDED.archive_slug,
+        archive_meta=EXCLUDED.archive_meta,
+        template_id=EXCLUDED.template_id,
+        archive_template_id=EXCLUDED.archive_template_id,
+        preview_text=EXCLUDED.preview_text,
+        updated_at=NOW()
+    WHERE campaigns.status = 'draft'
+    RETURNING id, (xmax = 0) AS created
+),
+ls AS (
+    SELECT id, name FROM lists WHERE uuid = ANY($20::UUID[])
+),
+del AS (
+    DELETE FROM campaign_lists WHERE campaign_id = (SELECT id FROM camp)
+        AND (list_id IS NULL OR list_id NOT IN (SELECT id FROM ls))
+),
+ins AS (
+    INSERT INTO campaign_lists (campaign_id, list_id, list_name)
+        SELECT (SELECT id FROM camp), id, name FROM ls WHERE EXISTS (SELECT 1 FROM camp)
+        ON CONFLICT (campaign_id, list_id) DO UPDATE SET list_name = EXCLUDED.list_name
+)
+SELECT created FROM camp;
@@ -0,0 +1,53 @@
+-- name: create-import-job
+INSERT INTO import_jobs (name, mode, params, file_path, total, log) VALUES($1, $2, $3, $4, $5, $6) RETURNING *;
+
+-- name: next-import-job
+-- Returns the next job to run and marks it as importing. A job that was interrupted
+-- by a shutdown or a crash is returned before the queued ones.
+UPDATE import_jobs SET status = (CASE WHEN status = 'queued' THEN 'importing' ELSE status END),
+    started_at = COALESCE(started_at, NOW()), updated_at = NOW()
+    WHERE id = (
+        SELECT id FROM import_jobs WHERE status IN ('queued', 'importing', 'stopping')
+        ORDER BY (status = 'queued'), id LIMIT 1
+    )
+    RETURNING *;
+
+-- name: get-import-job
+SELECT * FROM import_jobs WHERE id = $1;
+
+-- name: query-import-jobs
+-- Returns the jobs, latest first, without their logs.
+SELECT COUNT(*) OVER () AS total_jobs, id, name, mode, status, params, file_path,
+    total, imported, failed, line, state, '' AS log, created_at, started_at, finished_at, updated_at
+    FROM import_jobs
+    WHERE ($1 = '' OR status::TEXT = $1)
+    ORDER BY id DESC
+    OFFSET $2 LIMIT (CASE WHEN $3 < 1 THEN NULL ELSE $3 END);
+
+-- name: count-queued-import-jobs
+SELECT COUNT(*) FROM import_jobs WHERE status = 'queued';
+
+-- name: update-import-job-progress
+-- Records the progress of a job after the rows up to $4 have been committed.
+UPDATE import_jobs SET imported = imported + $2, failed = failed + $3, line = $4, state = $5,
+    log = log || $6, updated_at = NOW()
+    WHERE id = $1;
+
+-- name: update-import-job-status
+-- Updates the status of a job that hasn't ended and appends $3 to its log.
+UPDATE import_jobs SET status = $2::import_status, log = log || $3,
+    finished_at = (CASE WHEN $2 IN ('finished', 'failed', 'stopped') THEN NOW() ELSE NULL END),
+    updated_at = NOW()
+    WHERE id = $1 AND status IN ('queued', 'importing', 'stopping');
+
+-- name: stop-queued-import-job
+UPDATE import_jobs SET status = 'stopped', finished_at = NOW(), updated_at = NOW()
+    WHERE id = $1 AND status = 'queued'
+    RETURNING file_path;
+
+-- name: insert-import-job-error
+INSERT INTO import_job_errors (job_id, line, data, error) VALUES($1, $2, $3, $4)
+    ON CONFLICT (job_id, line) DO NOTHING;
+
+-- name: get-import-job-errors
+SELECT line, data, error FROM import_job_errors WHERE job_id = $1 ORDER BY line;
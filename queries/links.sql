@@ -1,15 +1,38 @@
 -- links
 -- name: create-link
-INSERT INTO links (uuid, url) VALUES($1, $2) ON CONFLICT (url) DO UPDATE SET url=EXCLUDED.url RETURNING uuid;
+-- Links created before short links existed get their slugs on their next use.
+INSERT INTO links (uuid, url, slug) VALUES($1, $2, $3)
+    ON CONFLICT (url) DO UPDATE SET url=EXCLUDED.url, slug=COALESCE(links.slug, EXCLUDED.slug)
+    RETURNING uuid, slug;
 
 -- name: register-link-click
+-- Clicks are not recorded for campaigns with the 'none' tracking mode or on seed list
+-- messages (nil subscriber UUID), but the URL is returned all the same. No rows are
+-- returned if the link doesn't exist.
 WITH link AS(
     SELECT id, url FROM links WHERE uuid = $1
+),
+camp AS (
+    SELECT id, tracking_mode FROM campaigns WHERE uuid = $2
+),
+ins AS (
+    INSERT INTO link_clicks (campaign_id, subscriber_id, link_id, is_bot)
+        SELECT (SELECT id FROM camp),
+            (SELECT id FROM subscribers WHERE
+                (CASE WHEN $3::TEXT != '' THEN subscribers.uuid = $3::UUID ELSE FALSE END)
+            ),
+            link.id,
+            $4
+        FROM link WHERE COALESCE((SELECT tracking_mode FROM camp), 'full') != 'none'
+            AND $3::TEXT != '00000000-0000-0000-0000-000000000000'
 )
-INSERT INTO link_clicks (campaign_id, subscriber_id, link_id) VALUES(
-    (SELECT id FROM campaigns WHERE uuid = $2),
-    (SELECT id FROM subscribers WHERE
-        (CASE WHEN $3::TEXT != '' THEN subscribers.uuid = $3::UUID ELSE FALSE END)
-    ),
-    (SELECT id FROM link)
-) RETURNING (SELECT url FROM link);
+SELECT url FROM link;
+
+-- name: get-short-link
+-- Returns the UUIDs of the link with the given slug and of the campaign and subscriber
+-- of a short link's ref. Missing campaigns and subscribers, and the subscriber ID 0
+-- (seed list messages or individual tracking being disabled) map to the nil UUID.
+SELECT links.uuid AS link_uuid,
+    COALESCE((SELECT uuid::TEXT FROM campaigns WHERE id = $2), '00000000-0000-0000-0000-000000000000') AS campaign_uuid,
+    COALESCE((SELECT uuid::TEXT FROM subscribers WHERE id = $3), '00000000-0000-0000-0000-000000000000') AS subscriber_uuid
+FROM links WHERE slug = $1;
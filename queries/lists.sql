@@ -38,6 +38,39 @@ statuses AS (
 SELECT ls.*, COALESCE(ss.subscriber_statuses, '{}') AS subscriber_statuses, COALESCE(ss.subscriber_count, 0) AS subscriber_count
     FROM ls LEFT JOIN statuses ss ON (ls.id = ss.list_id) ORDER BY %order%;
 
+-- name: get-list-stats
+-- Per-status subscriber breakdown of the given lists from the materialized stats,
+-- along with the number of blocklisted subscribers and subscriptions in the last 30 days.
+WITH statuses AS (
+    SELECT list_id,
+        COALESCE(SUM(subscriber_count) FILTER (WHERE status = 'confirmed'), 0) AS confirmed,
+        COALESCE(SUM(subscriber_count) FILTER (WHERE status = 'unconfirmed'), 0) AS unconfirmed,
+        COALESCE(SUM(subscriber_count) FILTER (WHERE status = 'unsubscribed'), 0) AS unsubscribed,
+        COALESCE(SUM(subscriber_count) FILTER (WHERE status IS NOT NULL), 0) AS total
+    FROM mat_list_subscriber_stats
+    WHERE list_id = ANY($1::INT[])
+    GROUP BY list_id
+),
+live AS (
+    SELECT sl.list_id,
+        COUNT(*) FILTER (WHERE s.status = 'blocklisted') AS blocklisted,
+        COUNT(*) FILTER (WHERE sl.created_at > NOW() - INTERVAL '30 days') AS new_last_30d
+    FROM subscriber_lists sl
+    JOIN subscribers s ON (s.id = sl.subscriber_id)
+    WHERE sl.list_id = ANY($1::INT[])
+    GROUP BY sl.list_id
+)
+SELECT l.id AS list_id,
+    COALESCE(st.confirmed, 0)::INT AS confirmed,
+    COALESCE(st.unconfirmed, 0)::INT AS unconfirmed,
+    COALESCE(st.unsubscribed, 0)::INT AS unsubscribed,
+    COALESCE(lv.blocklisted, 0)::INT AS blocklisted,
+    COALESCE(st.total, 0)::INT AS total,
+    COALESCE(lv.new_last_30d, 0)::INT AS new_last_30d
+FROM UNNEST($1::INT[]) AS l(id)
+LEFT JOIN statuses st ON (st.list_id = l.id)
+LEFT JOIN live lv ON (lv.list_id = l.id);
+
 -- name: get-lists-by-optin
 -- Can have a list of IDs or a list of UUIDs.
 SELECT * FROM lists WHERE (CASE WHEN $1 != '' THEN optin=$1::list_optin ELSE TRUE END) AND
@@ -53,7 +86,8 @@ SELECT id, uuid, type FROM lists WHERE
     END);
 
 -- name: create-list
-INSERT INTO lists (uuid, name, type, optin, status, tags, description) VALUES($1, $2, $3, $4, $5, $6, $7) RETURNING id;
+INSERT INTO lists (uuid, name, type, optin, status, tags, description, purge_unconfirmed_after_days, sunset_inactive_days, public_description, frequency)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id;
 
 -- name: update-list
 WITH l AS (
@@ -64,6 +98,10 @@ WITH l AS (
         status=(CASE WHEN $5 != '' THEN $5::list_status ELSE status END),
         tags=$6::VARCHAR(100)[],
         description=(CASE WHEN $7 != '' THEN $7 ELSE description END),
+        purge_unconfirmed_after_days=$8,
+        sunset_inactive_days=$9,
+        public_description=$10,
+        frequency=$11,
         updated_at=NOW()
     WHERE id = $1
     RETURNING id, name
@@ -87,3 +125,55 @@ AND CASE
     WHEN $3 = TRUE THEN TRUE ELSE id = ANY($4::INT[])
 END;
 
+
+-- name: export-lists
+SELECT uuid, name, type, optin, status, COALESCE(tags, '{}') AS tags, description,
+    purge_unconfirmed_after_days, sunset_inactive_days, public_description, frequency
+    FROM lists ORDER BY id;
+
+-- name: upsert-list-by-uuid
+-- Creates a list or updates the list with the same UUID ($1) when importing a bundle.
+-- created is false if an existing list was updated.
+INSERT INTO lists (uuid, name, type, optin, status, tags, description, purge_unconfirmed_after_days, sunset_inactive_days, public_description, frequency)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+    ON CONFLICT (uuid) DO UPDATE SET
+        name=EXCLUDED.name,
+        type=EXCLUDED.type,
+        optin=EXCLUDED.optin,
+        status=EXCLUDED.status,
+        tags=EXCLUDED.tags,
+        description=EXCLUDED.description,
+        purge_unconfirmed_after_days=EXCLUDED.purge_unconfirmed_after_days,
+        sunset_inactive_days=EXCLUDED.sunset_inactive_days,
+        public_description=EXCLUDED.public_description,
+        frequency=EXCLUDED.frequency,
+        updated_at=NOW()
+    RETURNING (xmax = 0) AS created;
+
+-- name: import-list
+-- Creates a list or updates the list with the same UUID ($1) when importing list definitions.
+-- Returns no rows if the existing list is identical. created is false if an existing list was updated.
+INSERT INTO lists (uuid, name, type, optin, status, tags, description, purge_unconfirmed_after_days, sunset_inactive_days, public_description, frequency)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+    ON CONFLICT (uuid) DO UPDATE SET
+        name=EXCLUDED.name,
+        type=EXCLUDED.type,
+        optin=EXCLUDED.optin,
+        status=EXCLUDED.status,
+        tags=EXCLUDED.tags,
+        description=EXCLUDED.description,
+        purge_unconfirmed_after_days=EXCLUDED.purge_unconfirmed_after_days,
+        sunset_inactive_days=EXCLUDED.sunset_inactive_days,
+        public_description=EXCLUDED.public_description,
+        frequency=EXCLUDED.frequency,
+        updated_at=NOW()
+    WHERE (lists.name, lists.type, lists.optin, lists.status, COALESCE(lists.tags, '{}'), lists.description,
+        lists.purge_unconfirmed_after_days, lists.sunset_inactive_days, lists.public_description, lists.frequency)
+        IS DISTINCT FROM (EXCLUDED.name, EXCLUDED.type, EXCLUDED.optin, EXCLUDED.status, COALESCE(EXCLUDED.tags, '{}'),
+        EXCLUDED.description, EXCLUDED.purge_unconfirmed_after_days, EXCLUDED.sunset_inactive_days,
+        EXCLUDED.public_description, EXCLUDED.frequency)
+    RETURNING (xmax = 0) AS created;
+
+-- name: has-list-name-conflict
+-- Checks whether a list with the name ($2) exists with a different UUID ($1).
+SELECT EXISTS(SELECT 1 FROM lists WHERE LOWER(name) = LOWER($2) AND uuid != $1);
@@ -3,7 +3,8 @@
 INSERT INTO media (uuid, filename, thumb, content_type, provider, meta, created_at) VALUES($1, $2, $3, $4, $5, $6, NOW()) RETURNING id;
 
 -- name: query-media
-SELECT COUNT(*) OVER () AS total, * FROM media
+-- %total% is the windowed COUNT() of all the matching rows or -1 if the count is skipped.
+SELECT %total% AS total, * FROM media
     WHERE ($1 = '' OR filename ILIKE $1) AND provider=$2 ORDER BY created_at DESC OFFSET $3 LIMIT $4;
 
 -- name: get-media
@@ -4,6 +4,93 @@ SELECT data FROM mat_dashboard_charts;
 -- name: get-dashboard-counts
 SELECT data FROM mat_dashboard_counts;
 
+-- name: get-digest-stats
+-- Returns the activity in the period [$1, $2) for the digest report. The campaign
+-- stats are of the campaigns that finished in the period. $3 is the number of top
+-- campaigns by unique views.
+WITH camps AS (
+    SELECT c.id, c.name, c.sent,
+        (SELECT COUNT(DISTINCT subscriber_id) FROM campaign_views v
+            WHERE v.campaign_id = c.id AND NOT v.is_bot AND NOT v.simulated AND v.subscriber_id IS NOT NULL) AS views,
+        (SELECT COUNT(DISTINCT subscriber_id) FROM link_clicks l
+            WHERE l.campaign_id = c.id AND NOT l.is_bot AND l.subscriber_id IS NOT NULL) AS clicks,
+        (SELECT COUNT(*) FROM bounces b WHERE b.campaign_id = c.id) AS bounces
+    FROM campaigns c
+    WHERE c.status = 'finished' AND NOT c.simulation AND c.updated_at >= $1 AND c.updated_at < $2
+),
+subs AS (
+    -- A subscription's updated_at is never before its created_at.
+    SELECT sl.list_id,
+        COUNT(*) FILTER (WHERE sl.created_at >= $1 AND sl.created_at < $2 AND sl.status != 'unsubscribed') AS subscribed,
+        COUNT(*) FILTER (WHERE sl.status = 'unsubscribed' AND sl.updated_at < $2) AS unsubscribed
+    FROM subscriber_lists sl
+    WHERE sl.updated_at >= $1
+    GROUP BY sl.list_id
+)
+SELECT JSON_BUILD_OBJECT(
+    'campaigns', (SELECT COUNT(*) FROM camps),
+    'messages', (SELECT COALESCE(SUM(sent), 0) FROM camps),
+    'views', (SELECT COALESCE(SUM(views), 0) FROM camps),
+    'clicks', (SELECT COALESCE(SUM(clicks), 0) FROM camps),
+    'bounces', (SELECT COALESCE(SUM(bounces), 0) FROM camps),
+    'new_subscribers', (SELECT COUNT(*) FROM subscribers WHERE created_at >= $1 AND created_at < $2),
+    'lists', COALESCE((SELECT JSON_AGG(r) FROM (
+        SELECT l.id, l.name, s.subscribed, s.unsubscribed FROM subs s
+        JOIN lists l ON (l.id = s.list_id)
+        WHERE s.subscribed > 0 OR s.unsubscribed > 0
+        ORDER BY s.subscribed - s.unsubscribed DESC, l.name
+    ) r), '[]'),
+    'top_campaigns', COALESCE((SELECT JSON_AGG(r) FROM (
+        SELECT id, name, sent, views, clicks, bounces FROM camps ORDER BY views DESC, clicks DESC, id DESC LIMIT $3
+    ) r), '[]')
+);
+
+-- name: insert-digest-run
+INSERT INTO digest_runs (period_start, period_end, status, recipients, error) VALUES($1, $2, $3, $4, $5);
+
+-- name: get-digest-runs
+SELECT * FROM digest_runs ORDER BY id DESC LIMIT $1;
+
+-- name: queue-system-message
+-- Queues a system e-mail that failed its first attempt ($8 is the error) for
+-- a retry after $9 seconds.
+INSERT INTO system_messages (from_email, to_emails, subject, content_type, body, headers, attempts, last_error, next_attempt_at)
+    VALUES($1, $2, $3, $4, $5, $6, $7, $8, NOW() + MAKE_INTERVAL(secs => $9)) RETURNING id;
+
+-- name: next-system-messages
+-- Claims up to $1 pending system e-mails that are due for a retry by pushing their
+-- next attempt $2 seconds ahead so that they aren't picked up again while they're
+-- being sent. The actual next attempt is set by record-system-message-attempt.
+UPDATE system_messages SET next_attempt_at = NOW() + MAKE_INTERVAL(secs => $2)
+    WHERE id IN (
+        SELECT id FROM system_messages WHERE status = 'pending' AND next_attempt_at <= NOW()
+        ORDER BY next_attempt_at LIMIT $1 FOR UPDATE SKIP LOCKED
+    )
+    RETURNING *;
+
+-- name: record-system-message-attempt
+-- Records a failed attempt ($2 is the error) at sending a system e-mail. The next
+-- attempt is after $4 seconds doubled for every earlier attempt, and the message
+-- is marked as failed once it has had $3 attempts.
+UPDATE system_messages SET
+    attempts = attempts + 1,
+    last_error = $2,
+    status = (CASE WHEN attempts + 1 >= $3 THEN 'failed' ELSE 'pending' END),
+    next_attempt_at = NOW() + MAKE_INTERVAL(secs => $4 * POWER(2, LEAST(attempts, 16))),
+    updated_at = NOW()
+    WHERE id = $1;
+
+-- name: delete-system-message
+DELETE FROM system_messages WHERE id = $1;
+
+-- name: get-failed-system-messages
+SELECT * FROM system_messages WHERE status = 'failed' ORDER BY id DESC LIMIT $1;
+
+-- name: retry-system-message
+-- Queues a failed system e-mail for retries again with a fresh set of attempts.
+UPDATE system_messages SET status = 'pending', attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+    WHERE id = $1 AND status = 'failed';
+
 -- name: get-settings
 SELECT JSON_OBJECT_AGG(key, value) AS settings FROM (SELECT * FROM settings ORDER BY key) t;
 
@@ -12,9 +99,40 @@ UPDATE settings AS s SET value = c.value
     -- For each key in the incoming JSON map, update the row with the key and its value.
     FROM(SELECT * FROM JSONB_EACH($1)) AS c(key, value) WHERE s.key = c.key;
 
+-- name: lock-settings
+-- Locks the settings rows till the end of the transaction and returns the current version.
+SELECT COALESCE(MAX(version), 0) FROM (SELECT version FROM settings FOR UPDATE) s;
+
+-- name: get-settings-version
+SELECT COALESCE(MAX(version), 0) FROM settings;
+
+-- name: bump-settings-version
+-- Sets all rows to the next version so that they stay in sync even if new
+-- setting keys were added with the default version.
+WITH v AS (SELECT COALESCE(MAX(version), 0) + 1 AS version FROM settings),
+u AS (UPDATE settings SET version = v.version FROM v)
+SELECT version FROM v;
+
 -- name: update-settings-by-key
 UPDATE settings SET value = $2, updated_at = NOW() WHERE key = $1;
 
 -- name: get-db-info
 SELECT JSON_BUILD_OBJECT('version', (SELECT VERSION()),
                         'size_mb', (SELECT ROUND(pg_database_size((SELECT CURRENT_DATABASE()))/(1024^2)))) AS info;
+
+-- name: get-db-size
+SELECT pg_database_size(CURRENT_DATABASE());
+
+-- name: get-smtp-send-counts
+-- Returns the counts of messages sent by each SMTP server on a day.
+SELECT server, sent FROM smtp_send_counts WHERE day = $1;
+
+-- name: add-smtp-send-counts
+-- Adds the counts of messages sent by SMTP servers ($1) to their counts ($2) of a day ($3)
+-- and deletes the counts of old days.
+WITH del AS (
+    DELETE FROM smtp_send_counts WHERE day < $3::DATE - 30
+)
+INSERT INTO smtp_send_counts (server, day, sent)
+    SELECT s, $3, n FROM UNNEST($1::TEXT[], $2::INT[]) AS t(s, n)
+    ON CONFLICT (server, day) DO UPDATE SET sent = smtp_send_counts.sent + EXCLUDED.sent;
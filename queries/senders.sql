@@ -0,0 +1,18 @@
+-- sender identities
+-- name: get-sender-identities
+SELECT * FROM sender_identities WHERE CASE WHEN $1 > 0 THEN id = $1 ELSE TRUE END ORDER BY value;
+
+-- name: create-sender-identity
+INSERT INTO sender_identities (type, value, verified, verify_token, verified_at)
+    VALUES($1, $2, $3, $4, (CASE WHEN $3 THEN NOW() ELSE NULL END)) RETURNING id;
+
+-- name: set-sender-identity-token
+-- Sets a new verification token on an unverified identity.
+UPDATE sender_identities SET verify_token=$2, updated_at=NOW() WHERE id=$1 AND verified=false;
+
+-- name: verify-sender-identity
+UPDATE sender_identities SET verified=true, verify_token=NULL, verified_at=NOW(), updated_at=NOW()
+    WHERE verify_token=$1 RETURNING *;
+
+-- name: delete-sender-identity
+DELETE FROM sender_identities WHERE id=$1;
@@ -0,0 +1,200 @@
+-- sequences
+-- name: get-sequences
+-- Returns all sequences or a single sequence ($1) with their ordered steps and
+-- per-status counts of subscribers enrolled in them.
+WITH seqs AS (
+    SELECT * FROM sequences WHERE CASE WHEN $1 > 0 THEN id = $1 ELSE TRUE END
+),
+steps AS (
+    SELECT st.sequence_id, JSONB_AGG(
+        JSONB_BUILD_OBJECT('campaign_id', st.campaign_id, 'campaign_name', c.name, 'delay_secs', st.delay_secs)
+        ORDER BY st.position
+    ) AS steps
+    FROM sequence_steps st
+    JOIN campaigns c ON c.id = st.campaign_id
+    WHERE st.sequence_id = ANY(SELECT id FROM seqs)
+    GROUP BY st.sequence_id
+),
+stats AS (
+    SELECT sequence_id, JSONB_OBJECT_AGG(status, num) AS stats FROM (
+        SELECT sequence_id, status, COUNT(*) AS num FROM sequence_state
+        WHERE sequence_id = ANY(SELECT id FROM seqs)
+        GROUP BY sequence_id, status
+    ) s GROUP BY sequence_id
+)
+SELECT seqs.*, lists.name AS list_name,
+    COALESCE(steps.steps, '[]') AS steps,
+    COALESCE(stats.stats, '{}') AS stats
+    FROM seqs
+    LEFT JOIN lists ON lists.id = seqs.list_id
+    LEFT JOIN steps ON steps.sequence_id = seqs.id
+    LEFT JOIN stats ON stats.sequence_id = seqs.id
+    ORDER BY seqs.created_at;
+
+-- name: create-sequence
+-- Creates a sequence and its steps. $5 and $6 are the step campaign IDs and delays
+-- in the order of the steps.
+WITH seq AS (
+    INSERT INTO sequences (uuid, name, list_id, status) VALUES($1, $2, $3, $4) RETURNING id
+),
+steps AS (
+    INSERT INTO sequence_steps (sequence_id, campaign_id, position, delay_secs)
+        SELECT seq.id, s.campaign_id, s.position, s.delay_secs
+        FROM seq, UNNEST($5::INT[], $6::INT[]) WITH ORDINALITY AS s(campaign_id, delay_secs, position)
+)
+SELECT id FROM seq;
+
+-- name: update-sequence
+-- Updates a sequence and replaces its steps. Steps are upserted by position and
+-- extra positions beyond the new steps are removed.
+WITH seq AS (
+    UPDATE sequences SET
+        name=$2,
+        list_id=$3,
+        status=$4::sequence_status,
+        updated_at=NOW()
+    WHERE id = $1 RETURNING id
+),
+del AS (
+    DELETE FROM sequence_steps WHERE sequence_id = (SELECT id FROM seq) AND position > CARDINALITY($5::INT[])
+),
+steps AS (
+    INSERT INTO sequence_steps (sequence_id, campaign_id, position, delay_secs)
+        SELECT seq.id, s.campaign_id, s.position, s.delay_secs
+        FROM seq, UNNEST($5::INT[], $6::INT[]) WITH ORDINALITY AS s(campaign_id, delay_secs, position)
+    ON CONFLICT (sequence_id, position) DO UPDATE SET campaign_id=EXCLUDED.campaign_id, delay_secs=EXCLUDED.delay_secs
+)
+SELECT id FROM seq;
+
+-- name: delete-sequence
+DELETE FROM sequences WHERE id = $1;
+
+-- name: enroll-sequence-subscribers
+-- Enrolls subscriptions on the lists of active sequences that were confirmed (or
+-- subscribed to single opt-in lists) since the last enrollment into the sequences.
+-- Subscribers whose sequence was cancelled (eg: on unsubscribing) and who have
+-- since re-subscribed are restarted from the first step.
+WITH prev AS (
+    SELECT id, enrolled_at FROM sequences
+    WHERE status = 'active' AND EXISTS (SELECT 1 FROM sequence_steps WHERE sequence_id = sequences.id)
+    FOR UPDATE SKIP LOCKED
+),
+seqs AS (
+    UPDATE sequences SET enrolled_at=NOW() FROM prev
+    WHERE sequences.id = prev.id
+    RETURNING sequences.id, sequences.list_id, prev.enrolled_at
+),
+subs AS (
+    SELECT seqs.id AS sequence_id, sl.subscriber_id, sl.updated_at AS subscribed_at
+    FROM seqs
+    JOIN lists ON lists.id = seqs.list_id
+    JOIN subscriber_lists sl ON sl.list_id = seqs.list_id
+    JOIN subscribers s ON s.id = sl.subscriber_id
+    WHERE
+        -- Allow a little slack for subscriptions committed after the last enrollment
+        -- with an older timestamp. Duplicates are ignored on conflict.
+        sl.updated_at >= seqs.enrolled_at - INTERVAL '1 minute'
+        AND s.status != 'blocklisted'
+        AND s.deletion_requested_at IS NULL
+        AND (
+            (lists.optin = 'double' AND sl.status = 'confirmed') OR
+            (lists.optin != 'double' AND sl.status != 'unsubscribed')
+        )
+)
+INSERT INTO sequence_state (sequence_id, subscriber_id, step, subscribed_at, next_send_at)
+    SELECT subs.sequence_id, subs.subscriber_id, st.position, subs.subscribed_at,
+        subs.subscribed_at + MAKE_INTERVAL(secs => st.delay_secs)
+    FROM subs
+    JOIN sequence_steps st ON (st.sequence_id = subs.sequence_id AND st.position = 1)
+    ON CONFLICT (sequence_id, subscriber_id) DO UPDATE SET
+        step=EXCLUDED.step,
+        status='active',
+        subscribed_at=EXCLUDED.subscribed_at,
+        next_send_at=EXCLUDED.next_send_at,
+        updated_at=NOW()
+    WHERE sequence_state.status = 'cancelled' AND EXCLUDED.subscribed_at > sequence_state.subscribed_at;
+
+-- name: next-sequence-messages
+-- Claims a batch ($1) of subscribers whose next sequence step is due and returns
+-- them along with the step's campaign. The state of every claimed subscriber is
+-- advanced to the next step (or finished) in the same query so that a restart
+-- resumes from where it left off. Subscribers who have since unsubscribed from the
+-- sequence's list are cancelled and skipped. The claims are settled with
+-- record-sequence-sends or release-sequence-messages once the messages are pushed.
+WITH due AS (
+    SELECT ss.sequence_id, ss.subscriber_id, ss.step, ss.subscribed_at, seq.list_id
+    FROM sequence_state ss
+    JOIN sequences seq ON seq.id = ss.sequence_id
+    WHERE ss.status = 'active' AND ss.next_send_at <= NOW() AND seq.status = 'active'
+    ORDER BY ss.next_send_at
+    LIMIT $1
+    FOR UPDATE OF ss SKIP LOCKED
+),
+subs AS (
+    SELECT due.*, COALESCE(s.status != 'blocklisted' AND s.deletion_requested_at IS NULL AND (
+        (lists.optin = 'double' AND sl.status = 'confirmed') OR
+        (lists.optin != 'double' AND sl.status != 'unsubscribed')
+    ), FALSE) AS subscribed
+    FROM due
+    JOIN lists ON lists.id = due.list_id
+    JOIN subscribers s ON s.id = due.subscriber_id
+    LEFT JOIN subscriber_lists sl ON (sl.subscriber_id = due.subscriber_id AND sl.list_id = due.list_id)
+),
+steps AS (
+    SELECT subs.*, cur.campaign_id, nxt.position AS next_step, nxt.delay_secs AS next_delay_secs
+    FROM subs
+    LEFT JOIN sequence_steps cur ON (cur.sequence_id = subs.sequence_id AND cur.position = subs.step)
+    LEFT JOIN sequence_steps nxt ON (nxt.sequence_id = subs.sequence_id AND nxt.position = subs.step + 1)
+),
+upd AS (
+    UPDATE sequence_state ss SET
+        status=(CASE
+            WHEN NOT steps.subscribed THEN 'cancelled'
+            WHEN steps.campaign_id IS NULL OR steps.next_step IS NULL THEN 'finished'
+            ELSE 'active' END)::sequence_state_status,
+        step=(CASE WHEN steps.subscribed AND steps.next_step IS NOT NULL THEN steps.next_step ELSE ss.step END),
+        next_send_at=(CASE WHEN steps.subscribed AND steps.next_step IS NOT NULL
+            THEN steps.subscribed_at + MAKE_INTERVAL(secs => steps.next_delay_secs) ELSE NULL END),
+        updated_at=NOW()
+    FROM steps
+    WHERE ss.sequence_id = steps.sequence_id AND ss.subscriber_id = steps.subscriber_id
+)
+SELECT steps.sequence_id, steps.campaign_id, steps.step, s.*
+    FROM steps
+    JOIN subscribers s ON s.id = steps.subscriber_id
+    WHERE steps.subscribed AND steps.campaign_id IS NOT NULL
+    ORDER BY steps.campaign_id;
+
+-- name: record-sequence-sends
+-- Increments the sent counts of the step campaigns of claimed sequence messages
+-- ($1 sequence IDs, $2 subscriber IDs, $3 campaign IDs) that were pushed and resets
+-- the subscribers' failed attempts.
+WITH msgs AS (
+    SELECT * FROM UNNEST($1::INT[], $2::INT[], $3::INT[]) AS m(sequence_id, subscriber_id, campaign_id)
+),
+attempts AS (
+    UPDATE sequence_state ss SET attempts=0
+    FROM msgs
+    WHERE ss.sequence_id = msgs.sequence_id AND ss.subscriber_id = msgs.subscriber_id AND ss.attempts > 0
+)
+UPDATE campaigns SET sent=sent + c.num
+    FROM (SELECT campaign_id, COUNT(*) AS num FROM msgs GROUP BY campaign_id) c
+    WHERE campaigns.id = c.campaign_id;
+
+-- name: release-sequence-messages
+-- Releases the claims of sequence messages ($1 sequence IDs, $2 subscriber IDs,
+-- $3 steps) that failed to be pushed by moving the subscribers back to the step,
+-- which is retried after a backoff ($4 seconds x attempts). Once a step has failed
+-- $5 times, it's skipped and the subscriber stays on the next step.
+WITH msgs AS (
+    SELECT * FROM UNNEST($1::INT[], $2::INT[], $3::INT[]) AS m(sequence_id, subscriber_id, step)
+)
+UPDATE sequence_state ss SET
+    step=(CASE WHEN ss.attempts + 1 < $5 THEN msgs.step ELSE ss.step END),
+    status=(CASE WHEN ss.attempts + 1 < $5 THEN 'active' ELSE ss.status END)::sequence_state_status,
+    next_send_at=(CASE WHEN ss.attempts + 1 < $5
+        THEN NOW() + MAKE_INTERVAL(secs => $4::INT * (ss.attempts + 1)) ELSE ss.next_send_at END),
+    attempts=(CASE WHEN ss.attempts + 1 < $5 THEN ss.attempts + 1 ELSE 0 END),
+    updated_at=NOW()
+FROM msgs
+WHERE ss.sequence_id = msgs.sequence_id AND ss.subscriber_id = msgs.subscriber_id;
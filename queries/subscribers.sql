@@ -1,12 +1,14 @@
 -- subscribers
 -- name: get-subscriber
--- Get a single subscriber by id or UUID or email.
+-- Get a single subscriber by id or UUID or email. E-mails are matched
+-- exactly or by their normalized form ($4).
 SELECT * FROM subscribers WHERE
     CASE
         WHEN $1 > 0 THEN id = $1
         WHEN $2 != '' THEN uuid = $2::UUID
-        WHEN $3 != '' THEN email = $3
-    END;
+        WHEN $3 != '' THEN email = $3 OR email_normalized = $4
+    END
+ORDER BY email = $3 DESC LIMIT 1;
 
 -- name: has-subscriber-list
 -- Used for checking access permission by list.
@@ -22,6 +24,9 @@ FROM subscribers s WHERE s.id = ANY($1);
 -- Get subscribers by emails.
 SELECT * FROM subscribers WHERE email=ANY($1);
 
+-- name: get-subscribers-by-ids
+SELECT * FROM subscribers WHERE id = ANY($1::INT[]) ORDER BY id;
+
 -- name: get-subscriber-lists
 WITH sub AS (
     SELECT id FROM subscribers WHERE CASE WHEN $1 > 0 THEN id = $1 ELSE uuid = $2 END
@@ -85,8 +90,8 @@ SELECT lists.*,
 
 -- name: insert-subscriber
 WITH sub AS (
-    INSERT INTO subscribers (uuid, email, name, status, attribs)
-    VALUES($1, $2, $3, $4, $5)
+    INSERT INTO subscribers (uuid, email, name, status, attribs, email_normalized)
+    VALUES($1, $2, $3, $4, $5, NULLIF($9, ''))
     RETURNING id, status
 ),
 listIDs AS (
@@ -113,16 +118,29 @@ SELECT id from sub;
 
 -- name: upsert-subscriber
 -- Upserts a subscriber where existing subscribers get their names and attributes overwritten.
--- If $7 = true, update values, otherwise, skip.
-WITH sub AS (
-    INSERT INTO subscribers as s (uuid, email, name, attribs, status)
-    VALUES($1, $2, $3, $4, 'enabled')
+-- If $7 = true, update values, otherwise, skip. An existing subscriber with the same
+-- normalized e-mail ($8) is treated as the same subscriber. Changes to the values of
+-- the tracked attribute keys ($9) of existing subscribers are recorded in the history.
+WITH old AS (
+    SELECT id, attribs FROM subscribers
+    WHERE email = COALESCE((SELECT email FROM subscribers WHERE email_normalized = NULLIF($8, '')), $2)
+),
+sub AS (
+    INSERT INTO subscribers as s (uuid, email, name, attribs, status, email_normalized)
+    VALUES($1, COALESCE((SELECT email FROM subscribers WHERE email_normalized = NULLIF($8, '')), $2), $3, $4, 'enabled', NULLIF($8, ''))
     ON CONFLICT (email)
     DO UPDATE SET
         name=(CASE WHEN $7 THEN $3 ELSE s.name END),
         attribs=(CASE WHEN $7 THEN $4 ELSE s.attribs END),
         updated_at=NOW()
-    RETURNING uuid, id, status
+    RETURNING uuid, id, status, attribs
+),
+hist AS (
+    INSERT INTO subscriber_attrib_history (subscriber_id, key, old_value, new_value)
+    SELECT sub.id, k.key, old.attribs->k.key, sub.attribs->k.key FROM sub
+    JOIN old ON (old.id = sub.id)
+    CROSS JOIN UNNEST($9::TEXT[]) AS k(key)
+    WHERE (old.attribs->k.key) IS DISTINCT FROM (sub.attribs->k.key)
 ),
 subs AS (
     INSERT INTO subscriber_lists (subscriber_id, list_id, status)
@@ -140,8 +158,8 @@ SELECT uuid, id from sub;
 -- existing subscriptions are marked as 'unsubscribed'.
 -- This is used in the bulk importer.
 WITH sub AS (
-    INSERT INTO subscribers (uuid, email, name, attribs, status)
-    VALUES($1, $2, $3, $4, 'blocklisted')
+    INSERT INTO subscribers (uuid, email, name, attribs, status, email_normalized)
+    VALUES($1, COALESCE((SELECT email FROM subscribers WHERE email_normalized = NULLIF($5, '')), $2), $3, $4, 'blocklisted', NULLIF($5, ''))
     ON CONFLICT (email) DO UPDATE SET status='blocklisted', updated_at=NOW()
     RETURNING id
 )
@@ -149,25 +167,49 @@ UPDATE subscriber_lists SET status='unsubscribed', updated_at=NOW()
     WHERE subscriber_id = (SELECT id FROM sub);
 
 -- name: update-subscriber
-UPDATE subscribers SET
-    email=(CASE WHEN $2 != '' THEN $2 ELSE email END),
-    name=(CASE WHEN $3 != '' THEN $3 ELSE name END),
-    status=(CASE WHEN $4 != '' THEN $4::subscriber_status ELSE status END),
-    attribs=(CASE WHEN $5 != '' THEN $5::JSONB ELSE attribs END),
-    updated_at=NOW()
-WHERE id = $1;
+-- Changes to the values of the tracked attribute keys ($7) are recorded in the history.
+WITH old AS (
+    SELECT id, attribs FROM subscribers WHERE id = $1
+),
+sub AS (
+    UPDATE subscribers SET
+        email=(CASE WHEN $2 != '' THEN $2 ELSE email END),
+        email_normalized=(CASE WHEN $2 != '' THEN NULLIF($6, '') ELSE email_normalized END),
+        name=(CASE WHEN $3 != '' THEN $3 ELSE name END),
+        status=(CASE WHEN $4 != '' THEN $4::subscriber_status ELSE status END),
+        attribs=(CASE WHEN $5 != '' THEN $5::JSONB ELSE attribs END),
+        updated_at=NOW()
+    WHERE id = $1 RETURNING id, attribs
+)
+INSERT INTO subscriber_attrib_history (subscriber_id, key, old_value, new_value)
+    SELECT sub.id, k.key, old.attribs->k.key, sub.attribs->k.key FROM sub
+    JOIN old ON (old.id = sub.id)
+    CROSS JOIN UNNEST($7::TEXT[]) AS k(key)
+    WHERE (old.attribs->k.key) IS DISTINCT FROM (sub.attribs->k.key);
 
 -- name: update-subscriber-with-lists
 -- Updates a subscriber's data, and given a list of list_ids, inserts subscriptions
--- for them while deleting existing subscriptions not in the list.
-WITH s AS (
+-- for them while deleting existing subscriptions not in the list. Changes to the
+-- values of the tracked attribute keys ($11) are recorded in the history.
+WITH old AS (
+    SELECT id, attribs FROM subscribers WHERE id = $1
+),
+s AS (
     UPDATE subscribers SET
         email=(CASE WHEN $2 != '' THEN $2 ELSE email END),
+        email_normalized=(CASE WHEN $2 != '' THEN NULLIF($10, '') ELSE email_normalized END),
         name=(CASE WHEN $3 != '' THEN $3 ELSE name END),
         status=(CASE WHEN $4 != '' THEN $4::subscriber_status ELSE status END),
         attribs=(CASE WHEN $5 != '' THEN $5::JSONB ELSE attribs END),
         updated_at=NOW()
-    WHERE id = $1 RETURNING id
+    WHERE id = $1 RETURNING id, attribs
+),
+hist AS (
+    INSERT INTO subscriber_attrib_history (subscriber_id, key, old_value, new_value)
+    SELECT s.id, k.key, old.attribs->k.key, s.attribs->k.key FROM s
+    JOIN old ON (old.id = s.id)
+    CROSS JOIN UNNEST($11::TEXT[]) AS k(key)
+    WHERE (old.attribs->k.key) IS DISTINCT FROM (s.attribs->k.key)
 ),
 listIDs AS (
     SELECT id FROM lists WHERE
@@ -199,6 +241,115 @@ INSERT INTO subscriber_lists (subscriber_id, list_id, status)
 -- Delete one or more subscribers by ID or UUID.
 DELETE FROM subscribers WHERE CASE WHEN ARRAY_LENGTH($1::INT[], 1) > 0 THEN id = ANY($1) ELSE uuid = ANY($2::UUID[]) END;
 
+-- name: get-email-duplicates
+-- Groups of subscribers whose e-mails have the same normalized form, oldest first.
+WITH n AS (
+    -- Normalized e-mails where +tags are stripped on the domains $1 and dots on the domains $2.
+    SELECT s.id, s.email, s.email_normalized, b.l || '@' || p.d AS norm FROM subscribers s,
+        LATERAL (SELECT SPLIT_PART(LOWER(s.email), '@', 1) AS l, SPLIT_PART(LOWER(s.email), '@', 2) AS d) p,
+        LATERAL (SELECT (CASE WHEN p.d = ANY($1::TEXT[]) THEN SPLIT_PART(p.l, '+', 1) ELSE p.l END) AS l) a,
+        LATERAL (SELECT (CASE WHEN p.d = ANY($2::TEXT[]) THEN REPLACE(a.l, '.', '') ELSE a.l END) AS l) b
+)
+SELECT norm AS email, ARRAY_AGG(id ORDER BY id) AS ids, ARRAY_AGG(email ORDER BY id) AS emails
+    FROM n GROUP BY norm HAVING COUNT(*) > 1 ORDER BY norm;
+
+-- name: merge-subscribers
+-- Merges the subscribers $2 into the subscriber $1. Subscriptions, views, clicks, sends,
+-- bounces, unsubscribe events, and attribute history are moved over, attributes are combined with $1's taking precedence,
+-- and $1 is blocklisted if any of $2 is, before $2 are deleted.
+WITH dups AS (
+    SELECT id, attribs, status FROM subscribers WHERE id = ANY($2::INT[]) AND id != $1
+),
+subs AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, meta, status, created_at, updated_at)
+        SELECT $1, list_id, meta, status, created_at, updated_at FROM subscriber_lists
+        WHERE subscriber_id = ANY(SELECT id FROM dups)
+    ON CONFLICT (subscriber_id, list_id) DO NOTHING
+),
+views AS (
+    UPDATE campaign_views SET subscriber_id = $1 WHERE subscriber_id = ANY(SELECT id FROM dups)
+),
+clicks AS (
+    UPDATE link_clicks SET subscriber_id = $1 WHERE subscriber_id = ANY(SELECT id FROM dups)
+),
+sends AS (
+    UPDATE campaign_sends SET subscriber_id = $1 WHERE subscriber_id = ANY(SELECT id FROM dups)
+),
+bounces AS (
+    UPDATE bounces SET subscriber_id = $1 WHERE subscriber_id = ANY(SELECT id FROM dups)
+),
+unsubs AS (
+    UPDATE unsubscribe_events SET subscriber_id = $1 WHERE subscriber_id = ANY(SELECT id FROM dups)
+),
+history AS (
+    UPDATE subscriber_attrib_history SET subscriber_id = $1 WHERE subscriber_id = ANY(SELECT id FROM dups)
+),
+sub AS (
+    UPDATE subscribers SET
+        attribs = COALESCE((SELECT JSONB_OBJECT_AGG(e.key, e.value) FROM dups, JSONB_EACH(dups.attribs) e), '{}') || attribs,
+        status = (CASE WHEN EXISTS (SELECT 1 FROM dups WHERE status = 'blocklisted') THEN 'blocklisted' ELSE status END),
+        updated_at = NOW()
+    WHERE id = $1
+)
+DELETE FROM subscribers WHERE id = ANY(SELECT id FROM dups);
+
+-- name: clear-stale-normalized-emails
+-- Clears the normalized e-mails that don't match the current policy so that they can be
+-- set again without transient unique conflicts.
+WITH n AS (
+    -- Normalized e-mails where +tags are stripped on the domains $1 and dots on the domains $2.
+    SELECT s.id, s.email, s.email_normalized, b.l || '@' || p.d AS norm FROM subscribers s,
+        LATERAL (SELECT SPLIT_PART(LOWER(s.email), '@', 1) AS l, SPLIT_PART(LOWER(s.email), '@', 2) AS d) p,
+        LATERAL (SELECT (CASE WHEN p.d = ANY($1::TEXT[]) THEN SPLIT_PART(p.l, '+', 1) ELSE p.l END) AS l) a,
+        LATERAL (SELECT (CASE WHEN p.d = ANY($2::TEXT[]) THEN REPLACE(a.l, '.', '') ELSE a.l END) AS l) b
+)
+UPDATE subscribers SET email_normalized = NULL FROM n
+    WHERE subscribers.id = n.id AND n.email_normalized IS NOT NULL AND n.email_normalized != n.norm;
+
+-- name: update-normalized-emails
+-- Sets the missing normalized e-mails of subscribers, skipping the ones that are
+-- duplicates of others.
+WITH n AS (
+    -- Normalized e-mails where +tags are stripped on the domains $1 and dots on the domains $2.
+    SELECT s.id, s.email, s.email_normalized, b.l || '@' || p.d AS norm FROM subscribers s,
+        LATERAL (SELECT SPLIT_PART(LOWER(s.email), '@', 1) AS l, SPLIT_PART(LOWER(s.email), '@', 2) AS d) p,
+        LATERAL (SELECT (CASE WHEN p.d = ANY($1::TEXT[]) THEN SPLIT_PART(p.l, '+', 1) ELSE p.l END) AS l) a,
+        LATERAL (SELECT (CASE WHEN p.d = ANY($2::TEXT[]) THEN REPLACE(a.l, '.', '') ELSE a.l END) AS l) b
+),
+dups AS (
+    SELECT norm FROM n GROUP BY norm HAVING COUNT(*) > 1
+)
+UPDATE subscribers SET email_normalized = n.norm FROM n
+    WHERE subscribers.id = n.id AND n.email_normalized IS NULL AND n.norm NOT IN (SELECT norm FROM dups);
+
+-- name: get-subscribers-to-verify
+-- Returns a batch ($2) of enabled subscribers after the ID $1 for bulk e-mail verification.
+-- If set, only the subscribers of the lists $3 and the ones not verified since $4 are returned.
+SELECT s.id, s.email FROM subscribers s
+    WHERE s.id > $1 AND s.status = 'enabled' AND s.deletion_requested_at IS NULL
+    AND (CARDINALITY($3::INT[]) = 0 OR EXISTS (
+        SELECT 1 FROM subscriber_lists sl
+        WHERE sl.subscriber_id = s.id AND sl.list_id = ANY($3::INT[]) AND sl.status != 'unsubscribed'
+    ))
+    AND ($4::TIMESTAMP WITH TIME ZONE IS NULL OR s.last_verified_at IS NULL OR s.last_verified_at < $4)
+    ORDER BY s.id LIMIT $2;
+
+-- name: count-subscribers-to-verify
+-- Counts the subscribers that get-subscribers-to-verify returns for the lists $1 and date $2.
+SELECT COUNT(*) FROM subscribers s
+    WHERE s.status = 'enabled' AND s.deletion_requested_at IS NULL
+    AND (CARDINALITY($1::INT[]) = 0 OR EXISTS (
+        SELECT 1 FROM subscriber_lists sl
+        WHERE sl.subscriber_id = s.id AND sl.list_id = ANY($1::INT[]) AND sl.status != 'unsubscribed'
+    ))
+    AND ($2::TIMESTAMP WITH TIME ZONE IS NULL OR s.last_verified_at IS NULL OR s.last_verified_at < $2);
+
+-- name: update-subscriber-verifications
+-- Sets the verification statuses ($2) of subscribers ($1).
+UPDATE subscribers s SET verification_status = u.status::verification_status, last_verified_at = NOW()
+    FROM UNNEST($1::INT[], $2::TEXT[]) AS u(id, status)
+    WHERE s.id = u.id;
+
 -- name: delete-blocklisted-subscribers
 DELETE FROM subscribers WHERE status = 'blocklisted';
 
@@ -206,6 +357,80 @@ DELETE FROM subscribers WHERE status = 'blocklisted';
 DELETE FROM subscribers a WHERE NOT EXISTS
     (SELECT 1 FROM subscriber_lists b WHERE b.subscriber_id = a.id);
 
+-- name: request-subscriber-deletion
+-- Marks a subscriber ($1 UUID) for deletion after the grace period. An existing
+-- request is retained so that the grace period isn't extended by repeat requests.
+UPDATE subscribers SET deletion_requested_at=COALESCE(deletion_requested_at, NOW()), updated_at=NOW()
+    WHERE uuid = $1 RETURNING *;
+
+-- name: cancel-subscriber-deletion
+-- Cancels the pending deletion of a subscriber ($1 UUID). Returns no rows if there's none.
+UPDATE subscribers SET deletion_requested_at=NULL, updated_at=NOW()
+    WHERE uuid = $1 AND deletion_requested_at IS NOT NULL RETURNING id;
+
+-- name: delete-pending-subscribers
+-- Deletes a batch ($2) of subscribers whose deletion was requested longer than
+-- the grace period ($1 days) ago and returns the number deleted.
+WITH subs AS (
+    SELECT id FROM subscribers
+    WHERE deletion_requested_at IS NOT NULL AND deletion_requested_at <= NOW() - MAKE_INTERVAL(days => $1)
+    LIMIT $2
+    FOR UPDATE SKIP LOCKED
+),
+del AS (
+    DELETE FROM subscribers WHERE id = ANY(SELECT id FROM subs)
+)
+SELECT COUNT(*) FROM subs;
+
+-- name: purge-unconfirmed-subscribers
+-- Deletes (or anonymizes if $2 = TRUE) a batch ($1) of subscribers who never confirmed
+-- their double opt-in subscriptions. A subscriber is purged only if they have at least one
+-- unconfirmed subscription on a list with purging enabled that's older than the list's
+-- threshold, and all their other subscriptions are also unconfirmed double opt-in ones.
+-- Subscribers with any confirmed, unsubscribed, or single opt-in subscription, or an
+-- unconfirmed one still within the threshold of a purging list, are left alone.
+-- Anonymized subscribers are removed from all lists and stripped of personal data.
+-- Returns the number of subscribers purged.
+WITH candidates AS (
+    SELECT DISTINCT sl.subscriber_id AS id
+    FROM subscriber_lists sl
+    JOIN lists l ON (l.id = sl.list_id)
+    JOIN subscribers s ON (s.id = sl.subscriber_id)
+    WHERE l.purge_unconfirmed_after_days > 0 AND l.optin = 'double' AND sl.status = 'unconfirmed'
+    AND s.status != 'blocklisted'
+    AND sl.updated_at < NOW() - MAKE_INTERVAL(days => l.purge_unconfirmed_after_days)
+    AND NOT EXISTS (
+        SELECT 1 FROM subscriber_lists o
+        JOIN lists ol ON (ol.id = o.list_id)
+        WHERE o.subscriber_id = sl.subscriber_id AND (
+            o.status != 'unconfirmed' OR ol.optin != 'double' OR
+            (ol.purge_unconfirmed_after_days > 0 AND o.updated_at >= NOW() - MAKE_INTERVAL(days => ol.purge_unconfirmed_after_days))
+        )
+    )
+    LIMIT $1
+),
+subs AS (
+    SELECT id FROM subscribers
+    WHERE id = ANY(SELECT id FROM candidates)
+    FOR UPDATE SKIP LOCKED
+),
+del AS (
+    DELETE FROM subscribers WHERE $2 = FALSE AND id = ANY(SELECT id FROM subs)
+),
+anon AS (
+    UPDATE subscribers SET
+        email=uuid::TEXT || '@anonymized.invalid',
+        email_normalized=uuid::TEXT || '@anonymized.invalid',
+        name='Anonymous',
+        attribs='{}',
+        updated_at=NOW()
+    WHERE $2 = TRUE AND id = ANY(SELECT id FROM subs)
+),
+unsub AS (
+    DELETE FROM subscriber_lists WHERE $2 = TRUE AND subscriber_id = ANY(SELECT id FROM subs)
+)
+SELECT COUNT(*) FROM subs;
+
 -- name: blocklist-subscribers
 WITH b AS (
     UPDATE subscribers SET status='blocklisted', updated_at=NOW()
@@ -223,6 +448,46 @@ INSERT INTO subscriber_lists (subscriber_id, list_id, status)
 DELETE FROM subscriber_lists
     WHERE (subscriber_id, list_id) = ANY(SELECT a, b FROM UNNEST($1::INT[]) a, UNNEST($2::INT[]) b);
 
+-- name: manage-subscription-items
+-- Applies the actions in the (subscriber_id $1, list_id $2, action $3, status $4)
+-- items and returns the result of each in order. The (subscriber_id, list_id) pairs
+-- should be unique. Items that wouldn't change anything are skipped, so applying
+-- the same items again is a no-op.
+WITH items AS (
+    SELECT * FROM UNNEST($1::INT[], $2::INT[], $3::TEXT[], $4::TEXT[]) WITH ORDINALITY AS t(subscriber_id, list_id, action, status, n)
+),
+res AS (
+    SELECT i.n, i.subscriber_id, i.list_id, i.action, i.status,
+        (CASE
+            WHEN s.id IS NULL OR l.id IS NULL THEN 'not_found'
+            WHEN i.action = 'add' AND s.status = 'blocklisted' THEN 'blocked'
+            WHEN i.action = 'add' AND sl.status IS NOT NULL AND (i.status = '' OR sl.status::TEXT = i.status) THEN 'skipped_already_member'
+            WHEN i.action = 'add' THEN 'ok'
+            WHEN sl.status IS NULL THEN 'skipped_not_member'
+            WHEN i.action = 'unsubscribe' AND sl.status = 'unsubscribed' THEN 'skipped_already_unsubscribed'
+            ELSE 'ok'
+        END) AS result
+    FROM items i
+    LEFT JOIN subscribers s ON (s.id = i.subscriber_id)
+    LEFT JOIN lists l ON (l.id = i.list_id)
+    LEFT JOIN subscriber_lists sl ON (sl.subscriber_id = i.subscriber_id AND sl.list_id = i.list_id)
+),
+ins AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+        SELECT subscriber_id, list_id, (CASE WHEN status != '' THEN status ELSE 'unconfirmed' END)::subscription_status
+        FROM res WHERE action = 'add' AND result = 'ok'
+    ON CONFLICT (subscriber_id, list_id) DO UPDATE SET status = EXCLUDED.status, updated_at = NOW()
+),
+del AS (
+    DELETE FROM subscriber_lists sl USING res
+    WHERE res.action = 'remove' AND res.result = 'ok' AND sl.subscriber_id = res.subscriber_id AND sl.list_id = res.list_id
+),
+unsub AS (
+    UPDATE subscriber_lists sl SET status = 'unsubscribed', updated_at = NOW() FROM res
+    WHERE res.action = 'unsubscribe' AND res.result = 'ok' AND sl.subscriber_id = res.subscriber_id AND sl.list_id = res.list_id
+)
+SELECT subscriber_id, list_id, action, result FROM res ORDER BY n;
+
 -- name: confirm-subscription-optin
 WITH subID AS (
     SELECT id FROM subscribers WHERE uuid = $1::UUID
@@ -261,6 +526,29 @@ UPDATE subscriber_lists SET status = 'unsubscribed', updated_at=NOW() WHERE
     -- If $3 is false, unsubscribe from the campaign's lists, otherwise all lists.
     CASE WHEN $3 IS FALSE THEN list_id = ANY(SELECT list_id FROM lists) ELSE list_id != 0 END;
 
+-- name: insert-unsubscribe-event
+-- Records an unsubscription of a subscriber ($1) from a campaign's ($2, optional) unsubscribe
+-- page, the public API, or a reply to the campaign along with the optional reason, comment,
+-- and source ($5).
+INSERT INTO unsubscribe_events (subscriber_id, campaign_id, reason, comment, source)
+    SELECT id, (SELECT id FROM campaigns WHERE uuid = NULLIF($2, '')::UUID), $3, $4, $5
+    FROM subscribers WHERE uuid = $1;
+
+-- name: get-unsubscribe-reasons
+-- Aggregates the unsubscribe reasons of a campaign ($1), or of all campaigns if $1 is 0,
+-- since $2. $3 is the number of the latest free text comments to return.
+WITH ev AS (
+    SELECT reason, comment, source, created_at FROM unsubscribe_events
+    WHERE ($1 = 0 OR campaign_id = $1) AND created_at >= $2
+)
+SELECT (SELECT COUNT(*) FROM ev) AS total,
+    COALESCE((SELECT JSON_AGG(r ORDER BY r.count DESC, r.reason) FROM
+        (SELECT reason, COUNT(*) AS count FROM ev GROUP BY reason) r), '[]') AS reasons,
+    COALESCE((SELECT JSON_AGG(c) FROM
+        (SELECT comment, created_at FROM ev WHERE comment != '' ORDER BY created_at DESC LIMIT $3) c), '[]') AS comments,
+    COALESCE((SELECT JSON_AGG(s ORDER BY s.count DESC, s.source) FROM
+        (SELECT source, COUNT(*) AS count FROM ev GROUP BY source) s), '[]') AS sources;
+
 -- name: delete-unconfirmed-subscriptions
 WITH optins AS (
     SELECT id FROM lists WHERE optin = 'double'
@@ -427,6 +715,32 @@ SELECT (SELECT email FROM prof) as email,
         COALESCE((SELECT JSON_AGG(t) FROM views t), '[]') AS campaign_views,
         COALESCE((SELECT JSON_AGG(t) FROM clicks t), '[]') AS link_clicks;
 
+-- name: query-subscriber-sends
+-- Returns the campaigns sent to a subscriber ($1), optionally filtered by a campaign ($2)
+-- and a date range ($3, $4), along with the first bounce, if any, that followed each send.
+SELECT COUNT(*) OVER () AS total,
+    cs.campaign_id,
+    c.uuid AS campaign_uuid,
+    c.name AS campaign_name,
+    c.subject AS campaign_subject,
+    cs.messenger,
+    cs.created_at AS sent_at,
+    b.type AS bounce_type,
+    b.created_at AS bounced_at
+FROM campaign_sends cs
+JOIN campaigns c ON c.id = cs.campaign_id
+LEFT JOIN LATERAL (
+    SELECT type, created_at FROM bounces
+    WHERE subscriber_id = cs.subscriber_id AND campaign_id = cs.campaign_id AND created_at >= cs.created_at
+    ORDER BY created_at LIMIT 1
+) b ON TRUE
+WHERE cs.subscriber_id = $1 AND NOT cs.simulated
+    AND (CASE WHEN $2 > 0 THEN cs.campaign_id = $2 ELSE TRUE END)
+    AND ($3::TIMESTAMP WITH TIME ZONE IS NULL OR cs.created_at >= $3)
+    AND ($4::TIMESTAMP WITH TIME ZONE IS NULL OR cs.created_at <= $4)
+ORDER BY cs.created_at DESC
+OFFSET $5 LIMIT (CASE WHEN $6 < 1 THEN NULL ELSE $6 END);
+
 -- name: get-subscriber-activity
 -- Gets the subscriber's campaign views and link clicks with detailed information
 -- for display in the Activity tab
@@ -460,7 +774,20 @@ clicks AS (
     WHERE lc.subscriber_id = $1
     GROUP BY l.id, l.url, c.id, c.uuid, c.name, c.subject
     ORDER BY last_clicked_at DESC
+),
+attribs AS (
+    SELECT key, old_value, new_value, created_at FROM subscriber_attrib_history
+    WHERE subscriber_id = $1
+    ORDER BY created_at DESC, id DESC
 )
 SELECT
     COALESCE((SELECT JSON_AGG(v) FROM views v), '[]') as campaign_views,
-    COALESCE((SELECT JSON_AGG(c) FROM clicks c), '[]') as link_clicks;
+    COALESCE((SELECT JSON_AGG(c) FROM clicks c), '[]') as link_clicks,
+    COALESCE((SELECT JSON_AGG(a) FROM attribs a), '[]') as attrib_changes;
+
+-- name: delete-stale-attrib-history
+-- Deletes the attribute change records older than $1 seconds.
+WITH del AS (
+    DELETE FROM subscriber_attrib_history WHERE created_at < NOW() - MAKE_INTERVAL(secs => $1) RETURNING 1
+)
+SELECT COUNT(*) FROM del;
@@ -0,0 +1,119 @@
+-- sunset
+-- name: sunset-subscribers
+-- Applies the sunset policy to subscriptions with no engagement (non-bot views or clicks)
+-- on the campaigns of their lists since a list's cut-off. The cut-off is the later of
+-- inactive days ($2, or the list's override) ago and the start of the list's Nth ($1)
+-- most recent finished campaign. Only subscriptions older than the cut-off on lists that
+-- have had campaigns since are considered. Subscribers pending data deletion are skipped.
+-- Engaging with the final campaign ($3) counts as engagement on all lists.
+--
+-- If a final campaign is set, a batch ($8) of inactive subscribers who haven't been
+-- notified are claimed and returned to be sent the campaign, and notified subscribers
+-- are sunset after the grace days ($4). Subscribers are marked notified with
+-- record-sunset-notices once the campaign is sent to them. Claims that weren't
+-- recorded, eg: failed sends, expire after a day and are retried. Otherwise, inactive
+-- subscribers are sunset right away. Sunset subscribers are added to the dormant list
+-- ($5) and optionally ($6) unsubscribed from their inactive lists. The state of
+-- subscribers who have since re-engaged is reset. If $7 is TRUE (dry run), nothing
+-- is changed.
+WITH ls AS (
+    SELECT l.id, GREATEST(
+        NOW() - MAKE_INTERVAL(days => COALESCE(l.sunset_inactive_days, $2)),
+        COALESCE(nth.started_at, '-infinity')
+    ) AS since
+    FROM lists l
+    LEFT JOIN LATERAL (
+        SELECT c.started_at FROM campaign_lists cl
+        JOIN campaigns c ON (c.id = cl.campaign_id)
+        WHERE $1 > 0 AND cl.list_id = l.id AND c.status = 'finished' AND c.started_at IS NOT NULL
+        ORDER BY c.started_at DESC OFFSET GREATEST($1 - 1, 0) LIMIT 1
+    ) nth ON TRUE
+    WHERE l.id != $5 AND l.status = 'active' AND COALESCE(l.sunset_inactive_days, $2) > 0
+),
+active AS (
+    -- Lists that have had campaigns since their cut-off. Subscribers can't be
+    -- inactive on lists that haven't been mailed.
+    SELECT ls.* FROM ls WHERE EXISTS (
+        SELECT 1 FROM campaign_lists cl
+        JOIN campaigns c ON (c.id = cl.campaign_id)
+        WHERE cl.list_id = ls.id AND c.status IN ('running', 'finished') AND c.started_at >= ls.since
+    )
+),
+inactive AS (
+    SELECT sl.subscriber_id, sl.list_id FROM subscriber_lists sl
+    JOIN active ON (active.id = sl.list_id)
+    JOIN subscribers s ON (s.id = sl.subscriber_id)
+    WHERE s.status = 'enabled' AND s.deletion_requested_at IS NULL
+    AND sl.status != 'unsubscribed' AND sl.created_at < active.since
+    AND NOT EXISTS (
+        SELECT 1 FROM campaign_views v
+        LEFT JOIN campaign_lists cl ON (cl.campaign_id = v.campaign_id AND cl.list_id = sl.list_id)
+        WHERE v.subscriber_id = sl.subscriber_id AND NOT v.is_bot AND v.created_at >= active.since
+        AND (cl.list_id IS NOT NULL OR v.campaign_id = $3)
+    )
+    AND NOT EXISTS (
+        SELECT 1 FROM link_clicks k
+        LEFT JOIN campaign_lists cl ON (cl.campaign_id = k.campaign_id AND cl.list_id = sl.list_id)
+        WHERE k.subscriber_id = sl.subscriber_id AND NOT k.is_bot AND k.created_at >= active.since
+        AND (cl.list_id IS NOT NULL OR k.campaign_id = $3)
+    )
+),
+subs AS (
+    SELECT DISTINCT subscriber_id FROM inactive
+),
+state AS (
+    SELECT st.* FROM sunset_state st JOIN subs ON (subs.subscriber_id = st.subscriber_id)
+),
+reset AS (
+    DELETE FROM sunset_state WHERE NOT $7 AND subscriber_id NOT IN (SELECT subscriber_id FROM subs)
+),
+notify AS (
+    SELECT subs.subscriber_id FROM subs
+    LEFT JOIN state ON (state.subscriber_id = subs.subscriber_id)
+    WHERE $3 > 0 AND (state.subscriber_id IS NULL OR (
+        state.notified_at IS NULL AND state.sunset_at IS NULL AND state.claimed_at < NOW() - INTERVAL '1 day'
+    ))
+    ORDER BY subs.subscriber_id
+    LIMIT $8
+),
+due AS (
+    SELECT subs.subscriber_id FROM subs
+    LEFT JOIN state ON (state.subscriber_id = subs.subscriber_id)
+    WHERE state.sunset_at IS NULL AND ($3 = 0 OR state.notified_at < NOW() - MAKE_INTERVAL(days => $4))
+),
+notified AS (
+    INSERT INTO sunset_state (subscriber_id, claimed_at)
+        SELECT subscriber_id, NOW() FROM notify WHERE NOT $7
+    ON CONFLICT (subscriber_id) DO UPDATE SET claimed_at=NOW()
+),
+dormant AS (
+    INSERT INTO subscriber_lists (subscriber_id, list_id, status)
+        SELECT subscriber_id, $5, 'unconfirmed' FROM due WHERE NOT $7 AND $5 > 0
+    ON CONFLICT (subscriber_id, list_id) DO NOTHING
+),
+unsub AS (
+    UPDATE subscriber_lists sl SET status='unsubscribed', updated_at=NOW()
+    FROM inactive
+    WHERE NOT $7 AND $6 AND sl.subscriber_id = inactive.subscriber_id AND sl.list_id = inactive.list_id
+    AND inactive.subscriber_id IN (SELECT subscriber_id FROM due)
+),
+sunset AS (
+    INSERT INTO sunset_state (subscriber_id, sunset_at)
+        SELECT subscriber_id, NOW() FROM due WHERE NOT $7
+    ON CONFLICT (subscriber_id) DO UPDATE SET sunset_at=NOW()
+)
+SELECT (SELECT COUNT(*) FROM inactive) AS inactive_subscriptions,
+    (SELECT COUNT(*) FROM subs) AS inactive_subscribers,
+    (SELECT COUNT(*) FROM notify) AS notified,
+    (SELECT COUNT(*) FROM due) AS sunset,
+    (SELECT COALESCE(ARRAY_AGG(subscriber_id), '{}') FROM notify) AS notify_ids;
+
+-- name: record-sunset-notices
+-- Marks the claimed subscribers ($1) who were sent the final campaign ($2) as notified,
+-- which starts their grace period, and increments the campaign's sent count.
+WITH notified AS (
+    UPDATE sunset_state SET notified_at=NOW()
+    WHERE subscriber_id = ANY($1::INT[]) AND notified_at IS NULL AND sunset_at IS NULL
+    RETURNING subscriber_id
+)
+UPDATE campaigns SET sent=sent + (SELECT COUNT(*) FROM notified) WHERE id = $2;
@@ -1,15 +1,20 @@
 -- templates
 -- name: get-templates
 -- Only if the second param ($2 - noBody) is true, body and body_source is returned.
-SELECT id, name, type, subject,
+SELECT id, uuid, name, type, subject,
     (CASE WHEN $2 = false THEN body ELSE '' END) as body,
     (CASE WHEN $2 = false THEN body_source ELSE NULL END) as body_source,
-    is_default, created_at, updated_at
+    is_default, COALESCE(tags, '{}') AS tags, block_styles, created_at, updated_at,
+    COALESCE((SELECT JSON_AGG(JSON_BUILD_OBJECT('name', tm.name, 'media_id', tm.media_id, 'filename', m.filename) ORDER BY tm.name)
+        FROM template_media tm JOIN media m ON m.id = tm.media_id WHERE tm.template_id = templates.id), '[]') AS assets
     FROM templates WHERE ($1 = 0 OR id = $1) AND ($3 = '' OR type = $3::template_type)
+    -- $5 = true matches templates with any of the tags, otherwise all of them.
+    AND (CARDINALITY($4::VARCHAR(100)[]) = 0 OR (CASE WHEN $5 THEN $4 && tags ELSE $4 <@ tags END))
     ORDER BY created_at;
 
 -- name: create-template
-INSERT INTO templates (name, type, subject, body, body_source) VALUES($1, $2, $3, $4, $5) RETURNING id;
+INSERT INTO templates (name, type, subject, body, body_source, tags, block_styles)
+    VALUES($1, $2, $3, $4, $5, $6, COALESCE($7::JSONB, '{}')) RETURNING id;
 
 -- name: update-template
 UPDATE templates SET
@@ -17,9 +22,33 @@ UPDATE templates SET
     subject=(CASE WHEN $3 != '' THEN $3 ELSE name END),
     body=(CASE WHEN $4 != '' THEN $4 ELSE body END),
     body_source=(CASE WHEN $5 != '' THEN $5 ELSE body_source END),
+    -- NULL tags leave the existing tags untouched.
+    tags=(CASE WHEN $6::VARCHAR(100)[] IS NOT NULL THEN $6 ELSE tags END),
+    -- NULL block styles leave the existing styles untouched.
+    block_styles=COALESCE($7::JSONB, block_styles),
     updated_at=NOW()
 WHERE id = $1;
 
+-- name: set-template-assets
+-- Replaces the assets of a template ($1) with the given names ($2) and media IDs ($3).
+-- Assets with existing names are pointed to the new media.
+WITH del AS (
+    DELETE FROM template_media WHERE template_id = $1 AND NOT (name = ANY($2::TEXT[]))
+)
+INSERT INTO template_media (template_id, name, media_id)
+    SELECT $1, UNNEST($2::TEXT[]), UNNEST($3::INT[])
+    ON CONFLICT (template_id, name) DO UPDATE SET media_id = EXCLUDED.media_id;
+
+-- name: get-template-assets
+-- Assets of a template for resolving {{ Asset }} in the template at render time.
+SELECT tm.name, m.filename FROM template_media tm
+    JOIN media m ON m.id = tm.media_id WHERE tm.template_id = $1;
+
+-- name: get-media-templates
+-- Names of the templates that use a media item as an asset.
+SELECT DISTINCT t.name FROM template_media tm
+    JOIN templates t ON t.id = tm.template_id WHERE tm.media_id = $1 ORDER BY t.name;
+
 -- name: set-default-template
 WITH u AS (
     UPDATE templates SET is_default=true WHERE id=$1 AND type='campaign' RETURNING id
@@ -40,3 +69,37 @@ up AS (
 )
 SELECT id FROM tpl;
 
+
+-- name: get-tags
+-- Returns all distinct tags across campaigns and templates along with their usage counts.
+-- $1 optionally filters by the source ('campaign' or 'template').
+WITH t AS (
+    SELECT 'campaign' AS type, UNNEST(tags) AS tag FROM campaigns WHERE $1 = '' OR $1 = 'campaign'
+    UNION ALL
+    SELECT 'template' AS type, UNNEST(tags) AS tag FROM templates WHERE $1 = '' OR $1 = 'template'
+)
+SELECT tag,
+    COUNT(*) AS count,
+    COUNT(*) FILTER (WHERE type = 'campaign') AS campaigns,
+    COUNT(*) FILTER (WHERE type = 'template') AS templates
+    FROM t GROUP BY tag ORDER BY tag;
+
+-- name: export-templates
+SELECT uuid, name, type, subject, body, body_source, COALESCE(tags, '{}') AS tags, block_styles
+    FROM templates ORDER BY id;
+
+-- name: upsert-template-by-uuid
+-- Creates a template or updates the template with the same UUID ($1) when importing
+-- a bundle. The default template flag is left untouched.
+INSERT INTO templates (uuid, name, type, subject, body, body_source, tags, block_styles)
+    VALUES($1, $2, $3, $4, $5, $6, $7, COALESCE($8::JSONB, '{}'))
+    ON CONFLICT (uuid) DO UPDATE SET
+        name=EXCLUDED.name,
+        type=EXCLUDED.type,
+        subject=EXCLUDED.subject,
+        body=EXCLUDED.body,
+        body_source=EXCLUDED.body_source,
+        tags=EXCLUDED.tags,
+        block_styles=EXCLUDED.block_styles,
+        updated_at=NOW()
+    RETURNING (xmax = 0) AS created;
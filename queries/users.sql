@@ -1,5 +1,5 @@
 -- name: create-user
-INSERT INTO users (username, password_login, password, email, name, type, user_role_id, list_role_id, status)
+INSERT INTO users (username, password_login, password, email, name, type, user_role_id, list_role_id, status, language)
     VALUES($1, $2, (
         CASE
             -- For user types with password_login enabled, bcrypt and store the hash of the password.
@@ -10,7 +10,7 @@ INSERT INTO users (username, password_login, password, email, name, type, user_r
                 THEN $3
             ELSE NULL
         END
-    ), $4, $5, $6, (SELECT id FROM roles WHERE id = $7 AND type = 'user'), (SELECT id FROM roles WHERE id = $8 AND type = 'list'), $9) RETURNING id;
+    ), $4, $5, $6, (SELECT id FROM roles WHERE id = $7 AND type = 'user'), (SELECT id FROM roles WHERE id = $8 AND type = 'list'), $9, $10) RETURNING id;
 
 -- name: update-user
 WITH u AS (
@@ -38,6 +38,7 @@ UPDATE users SET
             ELSE list_role_id END
     ),
     status=(CASE WHEN $10 != '' THEN $10::user_status ELSE status END),
+    language=(CASE WHEN $11 != '' THEN $11 ELSE language END),
     updated_at=NOW()
     WHERE id=$1 AND (SELECT canEdit FROM u) = TRUE;
 
@@ -128,6 +129,11 @@ FROM sel
     ) lp ON TRUE;
 
 
+-- name: get-user-langs
+-- Preferred languages of the enabled users with the given e-mails.
+SELECT LOWER(email) AS email, language FROM users
+    WHERE LOWER(email) = ANY($1::TEXT[]) AND language != '' AND status = 'enabled';
+
 -- name: get-api-tokens
 SELECT username, password FROM users WHERE status='enabled' AND type='api';
 
@@ -142,7 +148,8 @@ UPDATE users SET loggedin_at = NOW() WHERE id = (SELECT id FROM u) RETURNING *;
 
 -- name: update-user-profile
 UPDATE users SET name=$2, email=(CASE WHEN password_login THEN $3 ELSE email END),
-    password=(CASE WHEN $4 = TRUE THEN (CASE WHEN $5 != '' THEN CRYPT($5, GEN_SALT('bf')) ELSE password END) ELSE NULL END)
+    password=(CASE WHEN $4 = TRUE THEN (CASE WHEN $5 != '' THEN CRYPT($5, GEN_SALT('bf')) ELSE password END) ELSE NULL END),
+    language=(CASE WHEN $6 != '' THEN $6 ELSE language END)
     WHERE id=$1;
 
 -- name: update-user-login
@@ -150,3 +157,46 @@ UPDATE users SET loggedin_at=NOW(), avatar=(CASE WHEN $2 != '' THEN $2 ELSE avat
 
 -- name: set-user-twofa
 UPDATE users SET twofa_type=$2::twofa_type, twofa_key=$3, updated_at=NOW() WHERE id=$1;
+
+-- name: insert-login-event
+INSERT INTO login_events (username, ip, success) VALUES($1, $2, $3);
+
+-- name: upsert-api-token-network
+-- Records the network ($2) an API token ($1) is used from. The first network
+-- of a token is its baseline and isn't alerted.
+INSERT INTO api_token_networks (username, network, ip, alerted)
+    VALUES($1, $2, $3, NOT EXISTS (SELECT 1 FROM api_token_networks WHERE username = $1))
+    ON CONFLICT (username, network) DO UPDATE SET last_seen = NOW();
+
+-- name: get-failed-login-counts
+-- Usernames and IPs with at least $2 failed logins since $1.
+SELECT 'username' AS type, username AS value, COUNT(*) AS count FROM login_events
+    WHERE success = false AND created_at > $1 GROUP BY username HAVING COUNT(*) >= $2
+UNION ALL
+SELECT 'ip' AS type, ip AS value, COUNT(*) AS count FROM login_events
+    WHERE success = false AND created_at > $1 GROUP BY ip HAVING COUNT(*) >= $2
+ORDER BY count DESC LIMIT 100;
+
+-- name: get-new-api-token-networks
+SELECT username, network, ip, first_seen FROM api_token_networks
+    WHERE alerted = false ORDER BY first_seen LIMIT 100;
+
+-- name: mark-api-token-networks-alerted
+UPDATE api_token_networks SET alerted = true
+    WHERE (username, network) IN (SELECT * FROM UNNEST($1::TEXT[], $2::TEXT[]));
+
+-- name: delete-login-events
+DELETE FROM login_events WHERE created_at < $1;
+
+-- name: get-last-security-alerts
+-- Returns the time each rule was last fired at.
+SELECT rule, MAX(created_at) AS created_at FROM security_alerts GROUP BY rule;
+
+-- name: insert-security-alert
+INSERT INTO security_alerts (rule, data) VALUES($1, $2);
+
+-- name: query-security-alerts
+SELECT COUNT(*) OVER () AS total, * FROM security_alerts
+    WHERE ($1 = '' OR rule = $1)
+    ORDER BY created_at DESC
+    OFFSET $2 LIMIT (CASE WHEN $3 < 1 THEN NULL ELSE $3 END);
@@ -3,15 +3,21 @@ DROP TYPE IF EXISTS list_optin CASCADE; CREATE TYPE list_optin AS ENUM ('single'
 DROP TYPE IF EXISTS list_status CASCADE; CREATE TYPE list_status AS ENUM ('active', 'archived');
 DROP TYPE IF EXISTS subscriber_status CASCADE; CREATE TYPE subscriber_status AS ENUM ('enabled', 'disabled', 'blocklisted');
 DROP TYPE IF EXISTS subscription_status CASCADE; CREATE TYPE subscription_status AS ENUM ('unconfirmed', 'confirmed', 'unsubscribed');
-DROP TYPE IF EXISTS campaign_status CASCADE; CREATE TYPE campaign_status AS ENUM ('draft', 'running', 'scheduled', 'paused', 'cancelled', 'finished');
-DROP TYPE IF EXISTS campaign_type CASCADE; CREATE TYPE campaign_type AS ENUM ('regular', 'optin');
-DROP TYPE IF EXISTS content_type CASCADE; CREATE TYPE content_type AS ENUM ('richtext', 'html', 'plain', 'markdown', 'visual');
+DROP TYPE IF EXISTS campaign_status CASCADE; CREATE TYPE campaign_status AS ENUM ('draft', 'running', 'scheduled', 'paused', 'cancelled', 'finished', 'pending_approval');
+DROP TYPE IF EXISTS campaign_type CASCADE; CREATE TYPE campaign_type AS ENUM ('regular', 'optin', 'adhoc');
+DROP TYPE IF EXISTS tracking_mode CASCADE; CREATE TYPE tracking_mode AS ENUM ('full', 'clicks_only', 'none');
+DROP TYPE IF EXISTS content_type CASCADE; CREATE TYPE content_type AS ENUM ('richtext', 'html', 'plain', 'markdown', 'visual', 'blocks');
 DROP TYPE IF EXISTS bounce_type CASCADE; CREATE TYPE bounce_type AS ENUM ('soft', 'hard', 'complaint');
 DROP TYPE IF EXISTS template_type CASCADE; CREATE TYPE template_type AS ENUM ('campaign', 'campaign_visual', 'tx');
 DROP TYPE IF EXISTS user_type CASCADE; CREATE TYPE user_type AS ENUM ('user', 'api');
 DROP TYPE IF EXISTS user_status CASCADE; CREATE TYPE user_status AS ENUM ('enabled', 'disabled');
 DROP TYPE IF EXISTS role_type CASCADE; CREATE TYPE role_type AS ENUM ('user', 'list');
 DROP TYPE IF EXISTS twofa_type CASCADE; CREATE TYPE twofa_type AS ENUM ('none', 'totp');
+DROP TYPE IF EXISTS sequence_status CASCADE; CREATE TYPE sequence_status AS ENUM ('active', 'paused');
+DROP TYPE IF EXISTS sender_identity_type CASCADE; CREATE TYPE sender_identity_type AS ENUM ('address', 'domain');
+DROP TYPE IF EXISTS sequence_state_status CASCADE; CREATE TYPE sequence_state_status AS ENUM ('active', 'finished', 'cancelled');
+DROP TYPE IF EXISTS verification_status CASCADE; CREATE TYPE verification_status AS ENUM ('valid', 'risky', 'invalid', 'unknown');
+DROP TYPE IF EXISTS import_status CASCADE; CREATE TYPE import_status AS ENUM ('queued', 'importing', 'stopping', 'finished', 'failed', 'stopped');
 
 CREATE EXTENSION IF NOT EXISTS pgcrypto;
 
@@ -25,14 +31,30 @@ CREATE TABLE subscribers (
     attribs         JSONB NOT NULL DEFAULT '{}',
     status          subscriber_status NOT NULL DEFAULT 'enabled',
 
+    -- Canonical form of the e-mail as per the normalization policy (privacy.email_normalization)
+    -- used to detect different spellings of the same mailbox.
+    email_normalized TEXT NULL,
+
+    -- When the subscriber requested the deletion of their data. Such subscribers
+    -- are excluded from all sends and deleted after the grace period
+    -- (privacy.deletion_grace_days) unless they cancel.
+    deletion_requested_at TIMESTAMP WITH TIME ZONE NULL,
+
+    -- Result of the last bulk e-mail verification (list cleaning). NULL if never verified.
+    verification_status verification_status NULL,
+    last_verified_at TIMESTAMP WITH TIME ZONE NULL,
+
     created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 DROP INDEX IF EXISTS idx_subs_email; CREATE UNIQUE INDEX idx_subs_email ON subscribers(LOWER(email));
+DROP INDEX IF EXISTS idx_subs_email_normalized; CREATE UNIQUE INDEX idx_subs_email_normalized ON subscribers(email_normalized);
 DROP INDEX IF EXISTS idx_subs_status; CREATE INDEX idx_subs_status ON subscribers(status);
 DROP INDEX IF EXISTS idx_subs_id_status; CREATE INDEX idx_subs_id_status ON subscribers(id, status);
 DROP INDEX IF EXISTS idx_subs_created_at; CREATE INDEX idx_subs_created_at ON subscribers(created_at);
 DROP INDEX IF EXISTS idx_subs_updated_at; CREATE INDEX idx_subs_updated_at ON subscribers(updated_at);
+DROP INDEX IF EXISTS idx_subs_deletion_requested_at; CREATE INDEX idx_subs_deletion_requested_at ON subscribers(deletion_requested_at) WHERE deletion_requested_at IS NOT NULL;
+DROP INDEX IF EXISTS idx_subs_verification_status; CREATE INDEX idx_subs_verification_status ON subscribers(verification_status) WHERE verification_status IS NOT NULL;
 
 -- lists
 DROP TABLE IF EXISTS lists CASCADE;
@@ -46,6 +68,19 @@ CREATE TABLE lists (
     tags            VARCHAR(100)[],
     description     TEXT NOT NULL DEFAULT '',
 
+    -- Description (Markdown or basic HTML) shown to subscribers on public pages
+    -- and the expected sending frequency, eg: "weekly".
+    public_description TEXT NOT NULL DEFAULT '',
+    frequency       TEXT NOT NULL DEFAULT '',
+
+    -- Number of days after which subscribers who never confirmed their
+    -- double opt-in subscription are purged. 0 disables purging.
+    purge_unconfirmed_after_days INTEGER NOT NULL DEFAULT 0,
+
+    -- Overrides the sunset policy's inactivity days for the list's subscribers.
+    -- NULL uses the global policy and 0 exempts the list.
+    sunset_inactive_days INTEGER NULL,
+
     created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
@@ -71,23 +106,43 @@ CREATE TABLE subscriber_lists (
 );
 DROP INDEX IF EXISTS idx_sub_lists_sub_id; CREATE INDEX idx_sub_lists_sub_id ON subscriber_lists(subscriber_id);
 DROP INDEX IF EXISTS idx_sub_lists_list_id; CREATE INDEX idx_sub_lists_list_id ON subscriber_lists(list_id);
+DROP INDEX IF EXISTS idx_sub_lists_list_updated; CREATE INDEX idx_sub_lists_list_updated ON subscriber_lists(list_id, updated_at);
 DROP INDEX IF EXISTS idx_sub_lists_status; CREATE INDEX idx_sub_lists_status ON subscriber_lists(status);
 
+-- Changes to the values of the subscriber attribute keys in app.tracked_attribs.
+-- NULL values are of keys that didn't exist before or were removed.
+DROP TABLE IF EXISTS subscriber_attrib_history CASCADE;
+CREATE TABLE subscriber_attrib_history (
+    id                 BIGSERIAL PRIMARY KEY,
+    subscriber_id      INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    key                TEXT NOT NULL,
+    old_value          JSONB NULL,
+    new_value          JSONB NULL,
+    created_at         TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_attrib_history_sub; CREATE INDEX idx_attrib_history_sub ON subscriber_attrib_history(subscriber_id, key);
+DROP INDEX IF EXISTS idx_attrib_history_key_date; CREATE INDEX idx_attrib_history_key_date ON subscriber_attrib_history(key, created_at);
+DROP INDEX IF EXISTS idx_sub_lists_list_created; CREATE INDEX idx_sub_lists_list_created ON subscriber_lists(list_id, created_at);
+
 -- templates
 DROP TABLE IF EXISTS templates CASCADE;
 CREATE TABLE templates (
     id              SERIAL PRIMARY KEY,
+    uuid            UUID NOT NULL UNIQUE DEFAULT gen_random_uuid(),
     name            TEXT NOT NULL,
     type            template_type NOT NULL DEFAULT 'campaign',
     subject         TEXT NOT NULL,
     body            TEXT NOT NULL,
     body_source     TEXT NULL,
     is_default      BOOLEAN NOT NULL DEFAULT false,
+    tags            VARCHAR(100)[],
+    block_styles    JSONB NOT NULL DEFAULT '{}',
 
     created_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 CREATE UNIQUE INDEX ON templates (is_default) WHERE is_default = true;
+DROP INDEX IF EXISTS idx_tpls_tags; CREATE INDEX idx_tpls_tags ON templates USING GIN(tags);
 
 
 -- campaigns
@@ -97,7 +152,12 @@ CREATE TABLE campaigns (
     uuid uuid        NOT NULL UNIQUE,
     name             TEXT NOT NULL,
     subject          TEXT NOT NULL,
+
+    -- Preheader text shown after the subject in inboxes. It's injected into
+    -- the HTML body as a hidden snippet.
+    preview_text     TEXT NOT NULL DEFAULT '',
     from_email       TEXT NOT NULL,
+    reply_to         TEXT NOT NULL DEFAULT '',
     body             TEXT NOT NULL,
     body_source      TEXT NULL,
     altbody          TEXT NULL,
@@ -113,20 +173,75 @@ CREATE TABLE campaigns (
 
     -- The ID of the messenger backend used to send this campaign.
     messenger        TEXT NOT NULL,
+
+    -- Whether the campaign is sent in the simulation mode where messages go
+    -- through the entire pipeline but aren't delivered.
+    simulation       BOOLEAN NOT NULL DEFAULT false,
+
+    -- Autosaved, unsaved content (subject, body) from the editor. It's never used
+    -- for sending and is cleared when the campaign is saved.
+    draft            JSONB NULL,
+
+    -- Whether views (open pixels) and link clicks are tracked (full), only clicks, or neither.
+    tracking_mode    tracking_mode NOT NULL DEFAULT 'full',
+
+    -- UTM parameters that are automatically appended to links.
+    utm              JSONB NOT NULL DEFAULT '{}',
     template_id      INTEGER REFERENCES templates(id) ON DELETE SET NULL,
 
     -- Progress and stats.
     to_send            INT NOT NULL DEFAULT 0,
     sent               INT NOT NULL DEFAULT 0,
+
+    -- Number of eligible subscribers skipped for being on the excluded lists.
+    excluded           INT NOT NULL DEFAULT 0,
     max_subscriber_id  INT NOT NULL DEFAULT 0,
     last_subscriber_id INT NOT NULL DEFAULT 0,
 
+    -- Samples of the distinct errors from the last send run.
+    send_errors        JSONB NOT NULL DEFAULT '[]',
+
+    -- Results of the last send to the seed list.
+    seed_send          JSONB NULL,
+
+    -- Bounce hygiene summary generated when the campaign finishes.
+    hygiene            JSONB NULL,
+
+    -- Approval of the current content when campaign approvals are required.
+    -- approved_by is the ID of the approving user.
+    approved_by        INTEGER NULL,
+    approved_at        TIMESTAMP WITH TIME ZONE NULL,
+    approval_comment   TEXT NOT NULL DEFAULT '',
+
+    -- Percentages of processed messages at which progress events are emitted.
+    -- NULL uses the global milestones in settings.
+    progress_milestones INT[] NULL,
+
+    -- The campaign that this campaign resends to non-openers, if any.
+    parent_id           INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL,
+
+    -- Per-subscriber attachments fetched from templated URLs when sending.
+    -- [{"url": "", "filename": "", "on_error": "skip|send"}]
+    dynamic_attachments JSONB NOT NULL DEFAULT '[]',
+
+    -- Whether tracked links are shortened to app.short_link_url/{slug}/{ref}.
+    short_links         BOOLEAN NOT NULL DEFAULT false,
+
+    -- Duration after which a running campaign is paused, eg: 12h. Empty uses
+    -- app.max_campaign_runtime and 0 disables the limit.
+    max_runtime         TEXT NOT NULL DEFAULT '',
+
     -- Publishing.
     archive             BOOLEAN NOT NULL DEFAULT false,
     archive_slug        TEXT NULL UNIQUE,
     archive_template_id INTEGER REFERENCES templates(id) ON DELETE SET NULL,
     archive_meta        JSONB NOT NULL DEFAULT '{}',
 
+    -- public: listed in the archive. unlisted: only accessible via signed URLs.
+    -- password: accessible with a password whose hash is archive_password.
+    archive_access      TEXT NOT NULL DEFAULT 'public' CHECK (archive_access IN ('public', 'unlisted', 'password')),
+    archive_password    TEXT NULL,
+
     started_at       TIMESTAMP WITH TIME ZONE,
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
@@ -135,6 +250,8 @@ DROP INDEX IF EXISTS idx_camps_status; CREATE INDEX idx_camps_status ON campaign
 DROP INDEX IF EXISTS idx_camps_name; CREATE INDEX idx_camps_name ON campaigns(name);
 DROP INDEX IF EXISTS idx_camps_created_at; CREATE INDEX idx_camps_created_at ON campaigns(created_at);
 DROP INDEX IF EXISTS idx_camps_updated_at; CREATE INDEX idx_camps_updated_at ON campaigns(updated_at);
+DROP INDEX IF EXISTS idx_camps_tags; CREATE INDEX idx_camps_tags ON campaigns USING GIN(tags);
+DROP INDEX IF EXISTS idx_camps_parent_id; CREATE INDEX idx_camps_parent_id ON campaigns(parent_id);
 
 
 DROP TABLE IF EXISTS campaign_lists CASCADE;
@@ -151,6 +268,28 @@ CREATE UNIQUE INDEX ON campaign_lists (campaign_id, list_id);
 DROP INDEX IF EXISTS idx_camp_lists_camp_id; CREATE INDEX idx_camp_lists_camp_id ON campaign_lists(campaign_id);
 DROP INDEX IF EXISTS idx_camp_lists_list_id; CREATE INDEX idx_camp_lists_list_id ON campaign_lists(list_id);
 
+-- Lists whose subscribers are excluded from a campaign even if they're on its lists.
+DROP TABLE IF EXISTS campaign_exclude_lists CASCADE;
+CREATE TABLE campaign_exclude_lists (
+    campaign_id  INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    list_id      INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    PRIMARY KEY (campaign_id, list_id)
+);
+
+-- Ad-hoc recipients of 'adhoc' campaigns that are sent to without creating subscribers.
+DROP TABLE IF EXISTS campaign_recipients CASCADE;
+CREATE TABLE campaign_recipients (
+    id           BIGSERIAL PRIMARY KEY,
+    campaign_id  INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    uuid         UUID NOT NULL UNIQUE DEFAULT gen_random_uuid(),
+    email        TEXT NOT NULL,
+    name         TEXT NOT NULL DEFAULT '',
+    attribs      JSONB NOT NULL DEFAULT '{}',
+    bounce_type  bounce_type NULL,
+    created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+CREATE UNIQUE INDEX idx_camp_recipients_email ON campaign_recipients (campaign_id, email);
+
 DROP TABLE IF EXISTS campaign_views CASCADE;
 CREATE TABLE campaign_views (
     id               BIGSERIAL PRIMARY KEY,
@@ -158,11 +297,84 @@ CREATE TABLE campaign_views (
 
     -- Subscribers may be deleted, but the view counts should remain.
     subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    -- Views flagged as likely generated by bots and security scanners.
+    is_bot           BOOLEAN NOT NULL DEFAULT false,
+
+    -- Views on simulated campaign runs.
+    simulated        BOOLEAN NOT NULL DEFAULT false,
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 DROP INDEX IF EXISTS idx_views_camp_id; CREATE INDEX idx_views_camp_id ON campaign_views(campaign_id);
 DROP INDEX IF EXISTS idx_views_subscriber_id; CREATE INDEX idx_views_subscriber_id ON campaign_views(subscriber_id);
 DROP INDEX IF EXISTS idx_views_date; CREATE INDEX idx_views_date ON campaign_views((TIMEZONE('UTC', created_at)::DATE));
+DROP INDEX IF EXISTS idx_views_camp_sub; CREATE INDEX idx_views_camp_sub ON campaign_views(campaign_id, subscriber_id);
+
+-- Stats rollups of finished campaigns. A rollup is final once it's computed after
+-- app.campaign_stats_rollup_after from the campaign's finish, after which the campaign's
+-- stats are read from it instead of being computed from the event tables.
+DROP TABLE IF EXISTS campaign_stats CASCADE;
+CREATE TABLE campaign_stats (
+    campaign_id      INTEGER NOT NULL PRIMARY KEY REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    sent             INTEGER NOT NULL DEFAULT 0,
+    delivered        INTEGER NOT NULL DEFAULT 0,
+    bounces          INTEGER NOT NULL DEFAULT 0,
+    hard_bounces     INTEGER NOT NULL DEFAULT 0,
+    soft_bounces     INTEGER NOT NULL DEFAULT 0,
+    complaints       INTEGER NOT NULL DEFAULT 0,
+    views            INTEGER NOT NULL DEFAULT 0,
+    unique_views     INTEGER NOT NULL DEFAULT 0,
+    clicks           INTEGER NOT NULL DEFAULT 0,
+    unique_clicks    INTEGER NOT NULL DEFAULT 0,
+    unsubscribes     INTEGER NOT NULL DEFAULT 0,
+    final            BOOLEAN NOT NULL DEFAULT false,
+    finished_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Per-subscriber send records of campaigns, recorded with individual tracking.
+DROP TABLE IF EXISTS campaign_sends CASCADE;
+CREATE TABLE campaign_sends (
+    id               BIGSERIAL PRIMARY KEY,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    messenger        TEXT NOT NULL,
+
+    -- Sends of simulated campaign runs that weren't delivered.
+    simulated        BOOLEAN NOT NULL DEFAULT false,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_sends_camp_id; CREATE INDEX idx_sends_camp_id ON campaign_sends(campaign_id);
+DROP INDEX IF EXISTS idx_sends_sub_date; CREATE INDEX idx_sends_sub_date ON campaign_sends(subscriber_id, created_at);
+
+-- Daily counts of messages sent by SMTP servers for enforcing their providers' sending limits.
+DROP TABLE IF EXISTS smtp_send_counts CASCADE;
+CREATE TABLE smtp_send_counts (
+    -- username@host:port of the server.
+    server           TEXT NOT NULL,
+    day              DATE NOT NULL,
+    sent             INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY (server, day)
+);
+
+-- Unsubscriptions from the public unsubscribe page and API with the optional
+-- reasons given by subscribers.
+DROP TABLE IF EXISTS unsubscribe_events CASCADE;
+CREATE TABLE unsubscribe_events (
+    id               BIGSERIAL PRIMARY KEY,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    campaign_id      INTEGER NULL REFERENCES campaigns(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    -- One of the configured reason choices. Empty if the subscriber skipped it.
+    reason           TEXT NOT NULL DEFAULT '',
+    comment          TEXT NOT NULL DEFAULT '',
+
+    -- 'email-reply' for replies to campaigns. Empty for the unsubscribe page and API.
+    source           TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_unsub_events_camp_id; CREATE INDEX idx_unsub_events_camp_id ON unsubscribe_events(campaign_id);
+DROP INDEX IF EXISTS idx_unsub_events_date; CREATE INDEX idx_unsub_events_date ON unsubscribe_events(created_at);
 
 -- media
 DROP TABLE IF EXISTS media CASCADE;
@@ -192,6 +404,21 @@ CREATE TABLE campaign_media (
 DROP INDEX IF EXISTS idx_camp_media_id; CREATE UNIQUE INDEX idx_camp_media_id ON campaign_media (campaign_id, media_id);
 DROP INDEX IF EXISTS idx_camp_media_camp_id; CREATE INDEX idx_camp_media_camp_id ON campaign_media(campaign_id);
 
+-- template_media
+DROP TABLE IF EXISTS template_media CASCADE;
+CREATE TABLE template_media (
+    template_id  INTEGER NOT NULL REFERENCES templates(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    -- Media referenced by templates can't be deleted.
+    media_id     INTEGER NOT NULL REFERENCES media(id) ON DELETE RESTRICT ON UPDATE CASCADE,
+
+    -- Name with which the asset is referenced in the template, eg: {{ Asset "logo.png" }}.
+    name         TEXT NOT NULL,
+
+    PRIMARY KEY (template_id, name)
+);
+DROP INDEX IF EXISTS idx_tpl_media_media_id; CREATE INDEX idx_tpl_media_media_id ON template_media(media_id);
+
 
 -- links
 DROP TABLE IF EXISTS links CASCADE;
@@ -199,6 +426,7 @@ CREATE TABLE links (
     id               SERIAL PRIMARY KEY,
     uuid uuid        NOT NULL UNIQUE,
     url              TEXT NOT NULL UNIQUE,
+    slug             TEXT NULL UNIQUE,
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
@@ -210,36 +438,147 @@ CREATE TABLE link_clicks (
 
     -- Subscribers may be deleted, but the link counts should remain.
     subscriber_id    INTEGER NULL REFERENCES subscribers(id) ON DELETE SET NULL ON UPDATE CASCADE,
+
+    -- Clicks flagged as likely generated by bots and security scanners.
+    is_bot           BOOLEAN NOT NULL DEFAULT false,
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 DROP INDEX IF EXISTS idx_clicks_camp_id; CREATE INDEX idx_clicks_camp_id ON link_clicks(campaign_id);
 DROP INDEX IF EXISTS idx_clicks_link_id; CREATE INDEX idx_clicks_link_id ON link_clicks(link_id);
 DROP INDEX IF EXISTS idx_clicks_sub_id; CREATE INDEX idx_clicks_sub_id ON link_clicks(subscriber_id);
 DROP INDEX IF EXISTS idx_clicks_date; CREATE INDEX idx_clicks_date ON link_clicks((TIMEZONE('UTC', created_at)::DATE));
+DROP INDEX IF EXISTS idx_clicks_camp_sub; CREATE INDEX idx_clicks_camp_sub ON link_clicks(campaign_id, subscriber_id);
+
+-- sequences
+DROP TABLE IF EXISTS sequences CASCADE;
+CREATE TABLE sequences (
+    id               SERIAL PRIMARY KEY,
+    uuid uuid        NOT NULL UNIQUE,
+    name             TEXT NOT NULL,
+    list_id          INTEGER NOT NULL REFERENCES lists(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    status           sequence_status NOT NULL DEFAULT 'active',
+
+    -- Subscriptions on the list updated after this are enrolled into the sequence.
+    -- Existing subscribers at the time of creation are not enrolled.
+    enrolled_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+DROP TABLE IF EXISTS sequence_steps CASCADE;
+CREATE TABLE sequence_steps (
+    sequence_id      INTEGER NOT NULL REFERENCES sequences(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    campaign_id      INTEGER NOT NULL REFERENCES campaigns(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    position         INTEGER NOT NULL,
+
+    -- Delay in seconds after the subscription at which the step is sent.
+    delay_secs       INTEGER NOT NULL DEFAULT 0,
+
+    PRIMARY KEY(sequence_id, position)
+);
+DROP INDEX IF EXISTS idx_seq_steps_camp_id; CREATE INDEX idx_seq_steps_camp_id ON sequence_steps(campaign_id);
+
+DROP TABLE IF EXISTS sequence_state CASCADE;
+CREATE TABLE sequence_state (
+    sequence_id      INTEGER NOT NULL REFERENCES sequences(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    subscriber_id    INTEGER NOT NULL REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    -- Position of the next step to be sent.
+    step             INTEGER NOT NULL DEFAULT 1,
+    status           sequence_state_status NOT NULL DEFAULT 'active',
+
+    -- Number of times the step has failed to be sent.
+    attempts         INTEGER NOT NULL DEFAULT 0,
+    subscribed_at    TIMESTAMP WITH TIME ZONE NOT NULL,
+    next_send_at     TIMESTAMP WITH TIME ZONE NULL,
+
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY(sequence_id, subscriber_id)
+);
+DROP INDEX IF EXISTS idx_seq_state_sub_id; CREATE INDEX idx_seq_state_sub_id ON sequence_state(subscriber_id);
+DROP INDEX IF EXISTS idx_seq_state_next; CREATE INDEX idx_seq_state_next ON sequence_state(next_send_at) WHERE status = 'active';
+
+-- sunset state of inactive subscribers
+DROP TABLE IF EXISTS sunset_state CASCADE;
+CREATE TABLE sunset_state (
+    subscriber_id    INTEGER NOT NULL PRIMARY KEY REFERENCES subscribers(id) ON DELETE CASCADE ON UPDATE CASCADE,
+
+    -- When the subscriber was claimed to be sent the final re-engagement
+    -- campaign, when it was sent, and when the subscriber was sunset
+    -- (moved to the dormant list).
+    claimed_at       TIMESTAMP WITH TIME ZONE NULL,
+    notified_at      TIMESTAMP WITH TIME ZONE NULL,
+    sunset_at        TIMESTAMP WITH TIME ZONE NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- sender identities
+DROP TABLE IF EXISTS sender_identities CASCADE;
+CREATE TABLE sender_identities (
+    id               SERIAL PRIMARY KEY,
+    type             sender_identity_type NOT NULL,
+
+    -- Lowercased e-mail address or domain.
+    value            TEXT NOT NULL UNIQUE,
+    verified         BOOLEAN NOT NULL DEFAULT false,
+
+    -- Token in the confirmation link e-mailed to an address for verification.
+    verify_token     TEXT NULL UNIQUE,
+    verified_at      TIMESTAMP WITH TIME ZONE NULL,
+
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
 
 -- settings
 DROP TABLE IF EXISTS settings CASCADE;
 CREATE TABLE settings (
     key             TEXT NOT NULL UNIQUE,
     value           JSONB NOT NULL DEFAULT '{}',
+
+    -- Incremented on every settings update for detecting concurrent updates.
+    version         INT NOT NULL DEFAULT 1,
     updated_at      TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 DROP INDEX IF EXISTS idx_settings_key; CREATE INDEX idx_settings_key ON settings(key);
 INSERT INTO settings (key, value) VALUES
     ('app.site_name', '"Mailing list"'),
     ('app.root_url', '"http://localhost:9000"'),
+    ('app.short_link_url', '""'),
     ('app.favicon_url', '""'),
     ('app.from_email', '"listmonk <noreply@listmonk.yoursite.com>"'),
+    ('app.reply_to', '""'),
+    ('app.email_headers', '[]'),
+    ('app.public_templates_dir', '""'),
+    ('app.campaign_stats_rollup_after', '"720h"'),
+    ('app.messenger_routes', '[]'),
     ('app.logo_url', '""'),
     ('app.concurrency', '10'),
     ('app.message_rate', '10'),
     ('app.batch_size', '1000'),
     ('app.max_send_errors', '1000'),
+    ('app.max_campaign_runtime', '"0"'),
+    ('app.tx_concurrency', '2'),
+    ('app.tx_queue_size', '10000'),
+    ('app.seed_emails', '[]'),
+    ('app.require_seed_send', 'false'),
+    ('app.require_campaign_approval', 'false'),
+    ('app.adhoc_recipients_retention', '"168h"'),
+    ('app.tracked_attribs', '[]'),
+    ('app.attrib_history_retention', '"8760h"'),
+    ('app.progress_milestones', '[25, 50, 75, 100]'),
+    ('app.attachment_fetch', '{"timeout": "10s", "max_size_mb": 10, "content_types": ["application/pdf", "image/png", "image/jpeg", "image/gif", "text/plain", "text/csv"], "concurrency": 10, "cache_ttl": "10m", "cache_size_mb": 100}'),
+    ('app.max_message_size_mb', '25'),
     ('app.message_sliding_window', 'false'),
     ('app.message_sliding_window_duration', '"1h"'),
     ('app.message_sliding_window_rate', '10000'),
     ('app.cache_slow_queries', 'false'),
     ('app.cache_slow_queries_interval', '"0 3 * * *"'),
+    ('app.digest', '{"enabled": false, "schedule": "0 8 * * 1", "period_days": 7, "recipients": [], "sections": ["campaigns", "engagement", "subscribers", "top_campaigns"]}'),
+    ('app.query_guard', '{"enabled": false, "max_cost": 1000000, "max_rows": 1000000, "action": "confirm", "timeout": "30s"}'),
     ('app.enable_public_archive', 'true'),
     ('app.enable_public_subscription_page', 'true'),
     ('app.enable_public_archive_rss_content', 'true'),
@@ -247,19 +586,33 @@ INSERT INTO settings (key, value) VALUES
     ('app.check_updates', 'true'),
     ('app.notify_emails', '[]'),
     ('app.lang', '"en"'),
+    ('app.utm', '{"enabled": false, "source": "listmonk", "medium": "email", "campaign": "{{ .Campaign.Name }}", "term": "", "content": ""}'),
     ('privacy.individual_tracking', 'false'),
     ('privacy.unsubscribe_header', 'true'),
     ('privacy.allow_blocklist', 'true'),
     ('privacy.allow_export', 'true'),
     ('privacy.allow_wipe', 'true'),
+    ('privacy.deletion_grace_days', '14'),
     ('privacy.allow_preferences', 'true'),
     ('privacy.exportable', '["profile", "subscriptions", "campaign_views", "link_clicks"]'),
     ('privacy.domain_blocklist', '[]'),
     ('privacy.domain_allowlist', '[]'),
+    ('privacy.email_normalization', '{"strip_dots": false, "strip_plus": false}'),
+    ('privacy.email_verification', '{"syntax": false, "mx": false, "typos": false, "dns_timeout": "3s", "on_api": false}'),
     ('privacy.record_optin_ip', 'false'),
+    ('privacy.unsubscribe_reasons', '{"enabled": false, "choices": ["I receive too many e-mails", "The content is not relevant to me", "I no longer want to receive these e-mails", "I never signed up"], "allow_text": true}'),
+    ('privacy.tracking_mode', '"full"'),
+    ('privacy.link_attribs', '[]'),
+    ('privacy.purge_unconfirmed_action', '"delete"'),
+    ('privacy.bot_filter', '{"enabled": false, "user_agents": ["bot", "crawler", "spider", "safelinks", "mimecast", "barracuda", "proofpoint", "symantec", "trendmicro", "fortinet", "python-requests", "curl/", "wget/", "go-http-client", "headlesschrome"], "grace_window": "10s", "burst_window": "10s", "burst_count": 10}'),
     ('security.captcha', '{"altcha": {"enabled": false, "complexity": 300000}, "hcaptcha": {"enabled": false, "key": "", "secret": ""}}'),
     ('security.oidc', '{"enabled": false, "provider_url": "", "provider_name": "", "client_id": "", "client_secret": "", "auto_create_users": false, "default_user_role_id": null, "default_list_role_id": null}'),
     ('security.cors_origins', '[]'),
+    ('security.public_rate_limit', '30'),
+    ('security.trusted_proxies', '["127.0.0.0/8", "::1/128", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"]'),
+    ('security.sender_identities', '{"enabled": false, "require_verification": true}'),
+    ('security.alerts', '{"failed_logins": {"enabled": true, "threshold": 10, "window": "15m", "cooldown": "1h"}, "new_token_network": {"enabled": true, "cooldown": "1h"}}'),
+    ('security.signing_key', TO_JSONB(ENCODE(GEN_RANDOM_BYTES(32), 'hex'))),
     ('upload.provider', '"filesystem"'),
     ('upload.max_file_size', '5000'),
     ('upload.extensions', '["jpg","jpeg","png","gif","svg","*"]'),
@@ -275,25 +628,45 @@ INSERT INTO settings (key, value) VALUES
     ('upload.s3.bucket_path', '"/"'),
     ('upload.s3.bucket_type', '"public"'),
     ('upload.s3.expiry', '"167h"'),
+    ('upload.sftp.host', '""'),
+    ('upload.sftp.port', '22'),
+    ('upload.sftp.username', '""'),
+    ('upload.sftp.password', '""'),
+    ('upload.sftp.private_key', '""'),
+    ('upload.sftp.host_key', '""'),
+    ('upload.sftp.path', '"/"'),
+    ('upload.sftp.public_url', '""'),
+    ('upload.sftp.max_conns', '2'),
+    ('upload.webdav.url', '""'),
+    ('upload.webdav.username', '""'),
+    ('upload.webdav.password', '""'),
+    ('upload.webdav.public_url', '""'),
     ('smtp',
         '[{"enabled":true, "host":"smtp.yoursite.com","port":25,"auth_protocol":"cram","username":"username","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"tls_type":"STARTTLS","tls_skip_verify":false,"email_headers":[]},
           {"enabled":false, "host":"smtp.gmail.com","port":465,"auth_protocol":"login","username":"username@gmail.com","password":"password","hello_hostname":"","max_conns":10,"idle_timeout":"15s","wait_timeout":"5s","max_msg_retries":2,"tls_type":"TLS","tls_skip_verify":false,"email_headers":[]}]'),
     ('messengers', '[]'),
+    ('webhooks', '[]'),
     ('bounce.enabled', 'false'),
     ('bounce.webhooks_enabled', 'false'),
     ('bounce.actions', '{"soft": {"count": 2, "action": "none"}, "hard": {"count": 1, "action": "blocklist"}, "complaint" : {"count": 1, "action": "blocklist"}}'),
+    ('bounce.webhooks_log_only', 'false'),
     ('bounce.ses_enabled', 'false'),
     ('bounce.sendgrid_enabled', 'false'),
     ('bounce.sendgrid_key', '""'),
     ('bounce.postmark', '{"enabled": false, "username": "", "password": ""}'),
     ('bounce.forwardemail', '{"enabled": false, "key": ""}'),
+    ('bounce.webhook_allowlist', '{"audit_only": false, "trusted_proxies": [], "allowed_cidrs": {"ses": [], "sendgrid": [], "postmark": [], "forwardemail": []}}'),
+    ('bounce.reply_unsubscribe', '{"enabled": false, "keywords": {"en": ["unsubscribe", "remove me", "opt out"], "de": ["abmelden", "abbestellen", "austragen"]}}'),
+    ('bounce.verp', '{"enabled": false, "domain": "", "prefix": "bounce", "scheme": "compact", "secret": ""}'),
     ('bounce.mailboxes',
         '[{"enabled":false, "type": "pop", "host":"pop.yoursite.com","port":995,"auth_protocol":"userpass","username":"username","password":"password","return_path": "bounce@listmonk.yoursite.com","scan_interval":"15m","tls_enabled":true,"tls_skip_verify":false}]'),
     ('appearance.admin.custom_css', '""'),
     ('appearance.admin.custom_js', '""'),
     ('appearance.public.custom_css', '""'),
     ('appearance.public.custom_js', '""'),
-    ('maintenance.db', '{"vacuum": false, "vacuum_cron_interval": "0 2 * * *"}');
+    ('maintenance.db', '{"vacuum": false, "vacuum_cron_interval": "0 2 * * *"}'),
+    ('maintenance.storage', '{"disk_threshold": 90, "db_threshold_mb": 0, "cooldown": "24h"}'),
+    ('maintenance.sunset', '{"enabled": false, "inactive_days": 365, "inactive_campaigns": 0, "final_campaign_id": 0, "grace_days": 14, "dormant_list_id": 0, "unsubscribe": false}');
 
 -- bounces
 DROP TABLE IF EXISTS bounces CASCADE;
@@ -342,6 +715,9 @@ CREATE TABLE users (
     status           user_status NOT NULL DEFAULT 'disabled',
     twofa_type       twofa_type NOT NULL DEFAULT 'none',
     twofa_key        TEXT NULL,
+
+    -- Preferred language for notifications. Empty uses the instance language.
+    language         TEXT NOT NULL DEFAULT '',
     loggedin_at      TIMESTAMP WITH TIME ZONE NULL,
     created_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at       TIMESTAMP WITH TIME ZONE DEFAULT NOW()
@@ -356,6 +732,126 @@ CREATE TABLE sessions (
 );
 DROP INDEX IF EXISTS idx_sessions; CREATE INDEX idx_sessions ON sessions (id, created_at);
 
+-- Login attempts of users with passwords. Used for security alerts.
+DROP TABLE IF EXISTS login_events CASCADE;
+CREATE TABLE login_events (
+    id               BIGSERIAL PRIMARY KEY,
+    username         TEXT NOT NULL,
+    ip               TEXT NOT NULL,
+    success          BOOLEAN NOT NULL,
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_login_events_created_at; CREATE INDEX idx_login_events_created_at ON login_events(created_at);
+
+-- Networks (/24 for IPv4 and /48 for IPv6) that API tokens have been used from.
+DROP TABLE IF EXISTS api_token_networks CASCADE;
+CREATE TABLE api_token_networks (
+    username         TEXT NOT NULL,
+    network          TEXT NOT NULL,
+
+    -- The first IP seen from the network.
+    ip               TEXT NOT NULL,
+
+    -- Whether the use from the network has been alerted. A token's first network isn't alerted.
+    alerted          BOOLEAN NOT NULL DEFAULT false,
+    first_seen       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_seen        TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (username, network)
+);
+DROP INDEX IF EXISTS idx_api_token_networks_alerted; CREATE INDEX idx_api_token_networks_alerted ON api_token_networks(first_seen) WHERE alerted = false;
+
+-- Security alerts that have been fired.
+DROP TABLE IF EXISTS security_alerts CASCADE;
+CREATE TABLE security_alerts (
+    id               SERIAL PRIMARY KEY,
+    rule             TEXT NOT NULL,
+    data             JSONB NOT NULL DEFAULT '[]',
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_security_alerts; CREATE INDEX idx_security_alerts ON security_alerts(rule, created_at);
+
+-- Subscriber import jobs. Queued jobs are run one at a time in order.
+DROP TABLE IF EXISTS import_jobs CASCADE;
+CREATE TABLE import_jobs (
+    id               SERIAL PRIMARY KEY,
+
+    -- Name of the uploaded file.
+    name             TEXT NOT NULL,
+    mode             TEXT NOT NULL,
+    status           import_status NOT NULL DEFAULT 'queued',
+    params           JSONB NOT NULL DEFAULT '{}',
+
+    -- Path of the CSV file being imported. It's deleted when the job ends.
+    file_path        TEXT NOT NULL,
+
+    -- Number of rows in the file, and the ones imported and skipped so far.
+    total            INT NOT NULL DEFAULT 0,
+    imported         INT NOT NULL DEFAULT 0,
+    failed           INT NOT NULL DEFAULT 0,
+
+    -- Last row that has been processed and committed. An interrupted job resumes after it.
+    line             INT NOT NULL DEFAULT 0,
+
+    -- Job specific state that's carried over when an interrupted job resumes.
+    state            JSONB NOT NULL DEFAULT '{}',
+
+    log              TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    started_at       TIMESTAMP WITH TIME ZONE NULL,
+    finished_at      TIMESTAMP WITH TIME ZONE NULL,
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_import_jobs_status; CREATE INDEX idx_import_jobs_status ON import_jobs(status);
+
+-- Rows of import jobs that were skipped.
+DROP TABLE IF EXISTS import_job_errors CASCADE;
+CREATE TABLE import_job_errors (
+    job_id           INTEGER NOT NULL REFERENCES import_jobs(id) ON DELETE CASCADE ON UPDATE CASCADE,
+    line             INT NOT NULL,
+
+    -- The raw CSV row.
+    data             TEXT NOT NULL DEFAULT '',
+    error            TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (job_id, line)
+);
+
+-- Runs of the periodic activity digest report.
+DROP TABLE IF EXISTS digest_runs CASCADE;
+CREATE TABLE digest_runs (
+    id               SERIAL PRIMARY KEY,
+    period_start     TIMESTAMP WITH TIME ZONE NOT NULL,
+    period_end       TIMESTAMP WITH TIME ZONE NOT NULL,
+
+    -- sent or failed.
+    status           TEXT NOT NULL,
+    recipients       INT NOT NULL DEFAULT 0,
+    error            TEXT NOT NULL DEFAULT '',
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- System e-mails, eg: opt-in confirmations and admin notifications, that failed
+-- to send and are retried with backoff. Messages that fail all their attempts are
+-- marked as failed and kept until they're retried manually.
+DROP TABLE IF EXISTS system_messages CASCADE;
+CREATE TABLE system_messages (
+    id               SERIAL PRIMARY KEY,
+    from_email       TEXT NOT NULL,
+    to_emails        TEXT[] NOT NULL,
+    subject          TEXT NOT NULL,
+    content_type     TEXT NOT NULL,
+    body             TEXT NOT NULL,
+    headers          JSONB NOT NULL DEFAULT '{}',
+
+    -- pending or failed.
+    status           TEXT NOT NULL DEFAULT 'pending',
+    attempts         INT NOT NULL DEFAULT 0,
+    last_error       TEXT NOT NULL DEFAULT '',
+    next_attempt_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+DROP INDEX IF EXISTS idx_system_messages_next; CREATE INDEX idx_system_messages_next ON system_messages(next_attempt_at) WHERE status = 'pending';
+
 -- materialized views
 
 -- dashboard stats
@@ -389,7 +885,25 @@ CREATE MATERIALIZED VIEW mat_dashboard_counts AS
                     (SELECT status, COUNT(*) AS num FROM campaigns GROUP BY status) r
                 )
             ),
-            'messages', (SELECT SUM(sent) AS messages FROM campaigns)
+            'messages', (SELECT SUM(sent) AS messages FROM campaigns WHERE NOT simulation),
+            'engagement', (
+                -- Unique counts are distinct subscribers per campaign. Rates are
+                -- percentages of the delivered (sent minus bounced) messages.
+                -- Simulated campaign runs are excluded.
+                WITH e AS (
+                    SELECT
+                        (SELECT COUNT(*) FROM campaign_views WHERE NOT is_bot AND NOT simulated) AS views,
+                        (SELECT COUNT(*) FROM (SELECT DISTINCT campaign_id, subscriber_id FROM campaign_views WHERE NOT is_bot AND NOT simulated AND subscriber_id IS NOT NULL) v) AS unique_views,
+                        (SELECT COUNT(*) FROM link_clicks WHERE NOT is_bot) AS clicks,
+                        (SELECT COUNT(*) FROM (SELECT DISTINCT campaign_id, subscriber_id FROM link_clicks WHERE NOT is_bot AND subscriber_id IS NOT NULL) c) AS unique_clicks,
+                        GREATEST(COALESCE((SELECT SUM(sent) FROM campaigns WHERE NOT simulation), 0) - (SELECT COUNT(*) FROM bounces WHERE campaign_id IS NOT NULL), 0) AS delivered
+                )
+                SELECT JSON_BUILD_OBJECT('views', views, 'unique_views', unique_views,
+                    'clicks', clicks, 'unique_clicks', unique_clicks, 'delivered', delivered,
+                    'open_rate', COALESCE(ROUND(unique_views * 100.0 / NULLIF(delivered, 0), 2), 0),
+                    'click_rate', COALESCE(ROUND(unique_clicks * 100.0 / NULLIF(delivered, 0), 2), 0)
+                ) FROM e
+            )
         ) AS data;
 DROP INDEX IF EXISTS mat_dashboard_stats_idx; CREATE UNIQUE INDEX mat_dashboard_stats_idx ON mat_dashboard_counts (updated_at);
 
@@ -438,3 +952,40 @@ CREATE MATERIALIZED VIEW mat_list_subscriber_stats AS
     UNION ALL
     SELECT NOW() AS updated_at, 0 AS list_id, NULL AS status, COUNT(id) AS subscriber_count FROM subscribers;
 DROP INDEX IF EXISTS mat_list_subscriber_stats_idx; CREATE UNIQUE INDEX mat_list_subscriber_stats_idx ON mat_list_subscriber_stats (list_id, status);
+
+-- engagement and bounce counts by the recipients' e-mail domain
+DROP MATERIALIZED VIEW IF EXISTS mat_domain_stats;
+CREATE MATERIALIZED VIEW mat_domain_stats AS
+    WITH doms AS (
+        SELECT id, LOWER(SPLIT_PART(email, '@', 2)) AS domain FROM subscribers
+    ),
+    sent AS (
+        -- Recipients are estimated from the current (non-unsubscribed) subscribers of the campaigns' lists.
+        SELECT c.id AS campaign_id, d.domain, c.started_at::DATE AS date, COUNT(DISTINCT sl.subscriber_id) AS num
+        FROM campaigns c
+        JOIN campaign_lists cl ON (cl.campaign_id = c.id)
+        JOIN subscriber_lists sl ON (sl.list_id = cl.list_id AND sl.status != 'unsubscribed')
+        JOIN doms d ON (d.id = sl.subscriber_id)
+        WHERE c.started_at IS NOT NULL AND c.status NOT IN ('draft', 'scheduled')
+        GROUP BY c.id, d.domain, c.started_at::DATE
+    ),
+    events AS (
+        SELECT campaign_id, d.domain, v.created_at::DATE AS date, 0 AS sent, 1 AS views, 0 AS clicks, NULL::bounce_type AS bounce
+            FROM campaign_views v JOIN doms d ON (d.id = v.subscriber_id) WHERE NOT v.is_bot
+        UNION ALL
+        SELECT campaign_id, d.domain, k.created_at::DATE, 0, 0, 1, NULL
+            FROM link_clicks k JOIN doms d ON (d.id = k.subscriber_id) WHERE NOT k.is_bot
+        UNION ALL
+        SELECT campaign_id, d.domain, b.created_at::DATE, 0, 0, 0, b.type
+            FROM bounces b JOIN doms d ON (d.id = b.subscriber_id)
+        UNION ALL
+        SELECT campaign_id, domain, date, num, 0, 0, NULL FROM sent
+    )
+    SELECT NOW() AS updated_at, COALESCE(campaign_id, 0) AS campaign_id, domain, date,
+        SUM(sent) AS sent, SUM(views) AS views, SUM(clicks) AS clicks,
+        COUNT(*) FILTER (WHERE bounce = 'soft') AS bounces_soft,
+        COUNT(*) FILTER (WHERE bounce = 'hard') AS bounces_hard,
+        COUNT(*) FILTER (WHERE bounce = 'complaint') AS bounces_complaint
+    FROM events
+    GROUP BY COALESCE(campaign_id, 0), domain, date;
+DROP INDEX IF EXISTS mat_domain_stats_idx; CREATE UNIQUE INDEX mat_domain_stats_idx ON mat_domain_stats (campaign_id, domain, date);